@@ -0,0 +1,35 @@
+package app
+
+import (
+    "context"
+    "log"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+// alertRuleService creates the service of the alert rules when ALERT_RULES is enabled and registers the processor
+// evaluating them. It returns nil when the alert rules are disabled.
+func (a *App) alertRuleService(
+    ctx context.Context,
+    alertsPublisher services.Publisher,
+    notificationDispatcher *services.NotificationDispatcher,
+    accessService services.AccessService,
+) (*services.MongoAlertRuleService, error) {
+    if !a.cfg.AlertRulesEnabled() {
+        return nil, nil
+    }
+    ruleRepo := repositories.NewMongoAlertRuleRepository(a.db.Database("tracking"))
+    if err := ruleRepo.CreateIndexes(ctx); err != nil {
+        return nil, err
+    }
+    alertRuleService := services.NewMongoAlertRuleService(
+        ruleRepo,
+        accessService,
+        alertsPublisher,
+        notificationDispatcher,
+    )
+    a.processors.Register(services.NewAlertRuleProcessor(alertRuleService))
+    log.Println("Alert rules enabled")
+    return alertRuleService, nil
+}
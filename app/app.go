@@ -0,0 +1,1353 @@
+package app
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "os"
+    "os/signal"
+    "sync"
+    "sync/atomic"
+    "syscall"
+
+    "github.com/go-playground/validator/v10"
+    "github.com/goccy/go-json"
+    amqp "github.com/rabbitmq/amqp091-go"
+    "github.com/yemyoaung/managing-vehicle-tracking-common"
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/accesslog"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/cache"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/config"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/envelope"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/geo"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/handler"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/metrics"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/secrets"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/storage"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+    "go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+    ErrConfigMissing = errors.New("config is missing")
+    // ErrEncryptionKeysMissing is returned when TRACKING_ENCRYPTION is enabled without a place to read the keys
+    ErrEncryptionKeysMissing = errors.New("ENCRYPTION_KEYS_DIR is required when TRACKING_ENCRYPTION is enabled")
+    // ErrMemoryStorage is returned by the commands changing the tracking data when it is kept in the memory of the
+    // service
+    ErrMemoryStorage = errors.New("tracking data kept in memory can only be changed through the running service")
+)
+
+// App is the tracking service, it can be embedded by other services and integration tests
+// and customized with options
+type App struct {
+    validator    *validator.Validate
+    cfg          *config.EnvConfig
+    db           *mongo.Client
+    rabbitConn   broker
+    ownsBroker   bool
+    redis        *cache.RedisClient
+    trackingRepo repositories.TrackingRepository
+    routes       []func(router *http.ServeMux)
+    claims       handler.ClaimsResolver
+    tenants      handler.TenantResolver
+    secrets      secrets.Provider
+    policy       services.PolicyService
+    cipher       *envelope.Cipher
+    middlewares  []func(http.Handler) http.Handler
+    deprecations []*services.Deprecation
+    mapMatcher   geo.MapMatcher
+    distance     *geo.DistanceCalculator
+    processors   *services.ProcessorRegistry
+    accessLog    io.Closer
+    configFile   string
+    workers      *workerLimit
+    channel      *amqp.Channel
+    consumer     *services.RabbitConsumer
+    inflight     sync.WaitGroup
+    readiness    services.ReadinessService
+    debug        atomic.Bool
+    cancel       context.CancelFunc
+    shutdown     chan error
+    exit         chan os.Signal
+
+    // trackingService replaces the service storing and querying the tracking data when set
+    trackingService services.TrackingService
+    server          *http.Server
+    // postgres is the PostgreSQL connection pool of STORAGE_BACKEND=postgres, nil with the other backends
+    postgres *sql.DB
+    // history and mirror are the ClickHouse history store and the repository mirroring into it, nil without
+    // CLICKHOUSE_URL
+    history *repositories.ClickHouseHistoryRepository
+    mirror  *repositories.MirroringTrackingRepository
+    // buffer coalesces the readings into batches, nil without INGEST_BUFFER_SIZE
+    buffer *repositories.BufferedTrackingRepository
+    // rollups summarizes the readings per 5 minutes and hour, nil unless TRACKING_ROLLUPS is enabled
+    rollups *services.MongoTrackingRollupService
+}
+
+// NewApp creates a new App instance customized by the options
+func NewApp(opts ...Option) *App {
+    exit := make(chan os.Signal, 1)
+    shutdown := make(chan error, 1)
+
+    signal.Notify(exit, os.Interrupt, syscall.SIGTERM) // listen for termination signals
+
+    go func() {
+        defer close(exit)
+        <-exit
+        shutdown <- nil // shutdown
+    }()
+
+    a := &App{shutdown: shutdown, processors: services.NewProcessorRegistry(), workers: newWorkerLimit()}
+    for _, opt := range opts {
+        opt(a)
+    }
+    return a
+}
+
+// Consume processes incoming tracking data messages from RabbitMQ, every message is processed with a context of
+// ctx bounded by MESSAGE_TIMEOUT
+func (a *App) Consume(
+    ctx context.Context,
+    publisher services.Publisher,
+    trackingDataMessages <-chan amqp.Delivery,
+    trackingService services.TrackingService,
+    ingestionErrorService services.IngestionErrorService,
+) {
+    // recordIngestionError keeps the rejected message in the ingestion error log, so it can be looked up later.
+    // It is recorded even when the message timed out, with a deadline of its own.
+    recordIngestionError := func(ctx context.Context, payload []byte, err error) {
+        ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), a.cfg.MongoTimeoutDuration())
+        defer cancel()
+        if recordErr := ingestionErrorService.RecordIngestionError(
+            ctx,
+            repositories.IngestionSourceAMQP,
+            payload,
+            err,
+        ); recordErr != nil {
+            log.Println("Failed to record ingestion error: ", recordErr)
+        }
+    }
+
+    for msg := range trackingDataMessages {
+        // waits for a free worker when CONSUMER_WORKERS limits them
+        a.workers.acquire()
+        a.inflight.Add(1)
+        go func(msg amqp.Delivery, publisher services.Publisher) {
+            defer a.inflight.Done()
+            defer a.workers.release()
+
+            ctx, cancel := context.WithTimeout(ctx, a.cfg.MessageTimeoutDuration())
+            defer cancel()
+            // the device times are compared with the time the broker received the message, when it is stamped
+            ctx = services.WithReceivedAt(ctx, msg.Timestamp)
+            ctx, err := a.messageContext(ctx, msg)
+            if err != nil {
+                log.Println("Failed to read message tenant: ", err)
+                recordIngestionError(ctx, msg.Body, err)
+                if err := msg.Nack(false, false); err != nil {
+                    log.Println("Failed to nack message: ", err)
+                }
+                return
+            }
+
+            ctx, body, err := a.openMessage(ctx, msg)
+            if err != nil {
+                log.Println("Failed to decrypt message: ", err)
+                recordIngestionError(ctx, msg.Body, err)
+                if err := msg.Nack(false, false); err != nil {
+                    log.Println("Failed to nack message: ", err)
+                }
+                return
+            }
+
+            var trackingData services.TrackingDataRequest
+            if err := json.Unmarshal(body, &trackingData); err != nil {
+                log.Printf("Failed to unmarshal message: %v", err)
+                recordIngestionError(ctx, body, fmt.Errorf("%w: %w", services.ErrMalformedPayload, err))
+                // Nack the message on error
+                err := msg.Nack(false, false)
+                if err != nil {
+                    log.Println("Failed to nack message: ", err)
+                    return
+                }
+                return
+            }
+
+            if a.debug.Load() {
+                log.Println("Received tracking data: ", trackingData)
+            }
+
+            // Track the vehicle using the service
+            record, err := trackingService.TrackVehicle(ctx, &trackingData)
+            if err != nil {
+                // the reading isn't at fault when the app is shutting down, it is requeued for another instance
+                if errors.Is(context.Cause(ctx), context.Canceled) {
+                    if err := msg.Nack(false, true); err != nil {
+                        log.Println("Failed to requeue message: ", err)
+                    }
+                    return
+                }
+                // transient storage failures are requeued once, a reading failing again is rejected like the
+                // permanent failures so an outage doesn't redeliver it forever
+                if errors.Is(err, repositories.ErrTransient) && !msg.Redelivered {
+                    log.Println("Failed to track vehicle, requeueing: ", err)
+                    if err := msg.Nack(false, true); err != nil {
+                        log.Println("Failed to requeue message: ", err)
+                    }
+                    return
+                }
+                log.Println("Failed to track vehicle: ", err)
+                recordIngestionError(ctx, body, err)
+                err := msg.Nack(false, false)
+                if err != nil {
+                    log.Println("Failed to nack message: ", err)
+                    return
+                }
+                return
+            }
+
+            // Publish the result to a vehicle queue, for further processing 
+            a.inflight.Add(1)
+            // publishing outlives the handling of the message, it keeps the values of its context with a deadline
+            // of its own
+            publishCtx, cancelPublish := context.WithTimeout(
+                context.WithoutCancel(ctx),
+                a.cfg.PublishTimeoutDuration(),
+            )
+            go func(body []byte) {
+                defer a.inflight.Done()
+                defer cancelPublish()
+                if err := publisher.Publish(publishCtx, body); err != nil {
+                    log.Println("Failed to publish message: ", err)
+                }
+            }(services.TrackingDataMessage(record, body))
+
+            // Acknowledge the message after processing
+            if err := msg.Ack(false); err != nil {
+                log.Println("Failed to ack message: ", err)
+            }
+        }(msg, publisher)
+    }
+}
+
+// messageContext returns the context of a tracking data message with the tenant of its headers,
+// the tenant is required when multi-tenancy is enabled and ignored otherwise
+func (a *App) messageContext(ctx context.Context, msg amqp.Delivery) (context.Context, error) {
+    if !a.cfg.MultiTenancyEnabled() {
+        return ctx, nil
+    }
+    id, _ := msg.Headers[tenant.MessageHeader].(string)
+    if err := tenant.Validate(id); err != nil {
+        return ctx, fmt.Errorf("%w: %w", services.ErrInvalidTrackingData, err)
+    }
+    return tenant.WithID(ctx, id), nil
+}
+
+// openMessage returns the body of a tracking data message, decrypted when it is encrypted. The context carries
+// the key of the message, so the messages published for it are encrypted with the same key.
+func (a *App) openMessage(ctx context.Context, msg amqp.Delivery) (context.Context, []byte, error) {
+    if a.cipher == nil {
+        return ctx, msg.Body, nil
+    }
+    sealed, err := envelope.FromMessage(msg.Headers, msg.Body)
+    if err != nil {
+        return ctx, nil, fmt.Errorf("%w: %w", services.ErrInvalidTrackingData, err)
+    }
+    if sealed == nil {
+        if a.cfg.TrackingEncryptionRequired() {
+            return ctx, nil, fmt.Errorf("%w: %w", services.ErrInvalidTrackingData, envelope.ErrNotEncrypted)
+        }
+        return ctx, msg.Body, nil
+    }
+    id, _ := tenant.FromContext(ctx)
+    body, err := a.cipher.Open(ctx, id, sealed)
+    if err != nil {
+        return ctx, nil, fmt.Errorf("%w: %w", services.ErrInvalidTrackingData, err)
+    }
+    return envelope.WithKeyID(ctx, sealed.KeyID), body, nil
+}
+
+// newPublisher creates the publisher of a queue carrying location data, it encrypts the messages when
+// TRACKING_ENCRYPTION is enabled
+func (a *App) newPublisher(channel *amqp.Channel, queue string) *services.RabbitPublisher {
+    if a.cipher == nil {
+        return services.NewRabbitPublisher(channel, queue)
+    }
+    return services.NewEncryptingRabbitPublisher(channel, queue, a.cipher, a.cfg.EncryptionKeyIDValue())
+}
+
+// trackingCipher creates the cipher of the tracking data messages when they are encrypted, nil otherwise
+func (a *App) trackingCipher() (*envelope.Cipher, error) {
+    if !a.cfg.TrackingEncryptionEnabled() {
+        return nil, nil
+    }
+    provider := a.secrets
+    if provider == nil {
+        if a.cfg.EncryptionKeysDir == "" {
+            return nil, ErrEncryptionKeysMissing
+        }
+        provider = secrets.NewFileProvider(a.cfg.EncryptionKeysDir)
+    }
+    return envelope.NewCipher(provider), nil
+}
+
+// Run starts the app, connects to MongoDB, RabbitMQ and consumes tracking data messages
+func (a *App) Run(ctx context.Context) {
+    var err error
+    if a.cfg == nil {
+        a.shutdown <- ErrConfigMissing
+        return
+    }
+    // the config is checked before anything connects, so a missing variable doesn't fail halfway through
+    if err = a.cfg.Validate(); err != nil {
+        a.shutdown <- err
+        return
+    }
+    repositories.SetPageSizes(a.cfg.DefaultPageSizeValue(), a.cfg.MaxPageSizeValue())
+    if err = repositories.SetDefaultExclude(a.cfg.DefaultExclude); err != nil {
+        a.shutdown <- fmt.Errorf("DEFAULT_EXCLUDE: %w", err)
+        return
+    }
+    a.applyTunables(a.cfg, nil)
+
+    // background workers stop when the app shuts down
+    ctx, a.cancel = context.WithCancel(ctx)
+
+    // Connect to MongoDB
+    mongoOptions, err := a.mongoOptions()
+    if err != nil {
+        a.shutdown <- err
+        return
+    }
+    a.db, err = mongo.Connect(ctx, mongoOptions)
+    if err != nil {
+        a.shutdown <- err
+        return
+    }
+
+    // Set up the encryption of the tracking data messages, the keys are read when messages are consumed
+    if a.cipher, err = a.trackingCipher(); err != nil {
+        a.shutdown <- err
+        return
+    }
+
+    // Connect to RabbitMQ, unless an embedding service shares its connection
+    if a.rabbitConn == nil {
+        if a.rabbitConn, err = a.newBroker(); err != nil {
+            a.shutdown <- err
+            return
+        }
+        a.ownsBroker = true
+    }
+    channel, err := a.rabbitConn.Channel()
+    if err != nil {
+        a.shutdown <- err
+        return
+    }
+
+    // Declare the tracking queue with durable
+    _, err = channel.QueueDeclare(
+        a.cfg.TrackingQueue,
+        true,
+        false,
+        false,
+        false,
+        nil,
+    )
+    if err != nil {
+        a.shutdown <- err
+        return
+    }
+
+    a.channel = channel
+
+    // Set up map matching, it is optional and only enabled when a provider is configured
+    a.mapMatcher, err = geo.NewMapMatcher(a.cfg.MapMatchingProvider, a.cfg.MapMatchingURL)
+    if err != nil {
+        a.shutdown <- err
+        return
+    }
+    if a.mapMatcher != nil {
+        log.Println("Map matching enabled using provider: ", a.cfg.MapMatchingProvider)
+    }
+
+    // Set up reverse geocoding, it is optional and only enabled when a provider is configured. It runs before the
+    // built-in processors so they see the filled in location.
+    if err = a.registerGeocoding(); err != nil {
+        a.shutdown <- err
+        return
+    }
+
+    // Set up the distance calculation strategy used by all distance-derived metrics
+    a.distance, err = geo.NewDistanceCalculator(
+        geo.DistanceStrategy(a.cfg.DistanceStrategy),
+        a.mapMatcher,
+        a.cfg.DistanceSimplifyToleranceMeters(),
+    )
+    if err != nil {
+        a.shutdown <- err
+        return
+    }
+
+    // Initialize the access service, it limits the tracking queries to the vehicles the user claims give access to
+    accessService := services.NewMongoAccessService(
+        repositories.NewMongoVehicleAssignmentRepository(a.db.Database("tracking")),
+        repositories.NewMongoUserMembershipRepository(a.db.Database("tracking")),
+    )
+    accessHandler := handler.NewV1AccessHandler(accessService, a.validator)
+
+    // Initialize the tracking service
+    trackingRepo, trackingPartitions, err := a.storedTrackingRepository(ctx)
+    if err != nil {
+        a.shutdown <- err
+        return
+    }
+    // the stats aggregate the tracking data where it is stored, below the buffer, cache and history
+    trackingStatsRepo := a.trackingStatsRepository(trackingRepo, trackingPartitions)
+    trackingRepo = a.applyIngestBuffer(trackingRepo)
+    if trackingPartitions != nil {
+        if err = a.startPartitionMaintenance(ctx, trackingPartitions); err != nil {
+            a.shutdown <- err
+            return
+        }
+    }
+
+    // Set up the tracking data cache, it is optional and only enabled when a Redis url is configured
+    if a.cfg.RedisURL != "" {
+        a.redis, err = cache.NewRedisClient(a.cfg.RedisURL)
+        if err != nil {
+            a.shutdown <- err
+            return
+        }
+        trackingRepo = repositories.NewCachedTrackingRepository(trackingRepo, a.redis, a.cfg.CacheTTLDuration())
+        log.Println("Tracking data cache enabled with TTL: ", a.cfg.CacheTTLDuration())
+    }
+    trackingRepo = a.applyPurging(trackingRepo)
+    if trackingRepo, err = a.applyHistory(ctx, trackingRepo); err != nil {
+        a.shutdown <- err
+        return
+    }
+    if trackingRepo, err = a.applyRollups(ctx, trackingRepo, accessService); err != nil {
+        a.shutdown <- err
+        return
+    }
+    // Maintain the current state of every vehicle, the fleet snapshot is read from it instead of the tracking data
+    vehicleStateRepo := repositories.NewMongoVehicleStateRepository(a.db.Database("tracking"))
+    if err = vehicleStateRepo.CreateIndexes(ctx); err != nil {
+        a.shutdown <- err
+        return
+    }
+    trackingRepo = repositories.NewVehicleStateTrackingRepository(trackingRepo, vehicleStateRepo)
+    // the ingest metrics are recorded from process start, they feed the deployment health rollback signal
+    ingestRecorder := metrics.NewIngestRecorder()
+
+    // Publish the alerts to the event exchange, the alerts queue is bound to them
+    var alertsPublisher services.Publisher
+    alertsPublisher, err = a.eventPublisher(
+        channel,
+        a.newPublisher(channel, a.cfg.AlertsQueue),
+        a.cfg.AlertsQueue,
+        services.EventRoutingKey,
+        services.AlertEvents...,
+    )
+    if err != nil {
+        a.shutdown <- err
+        return
+    }
+
+    // Initialize the webhooks, they are optional and only enabled when WEBHOOKS is enabled. The alerts and the
+    // readings are delivered to the webhooks subscribed to them.
+    webhookService, err := a.webhookService(ctx)
+    if err != nil {
+        a.shutdown <- err
+        return
+    }
+    if webhookService != nil {
+        alertsPublisher = services.NewWebhookPublisher(alertsPublisher, webhookService)
+        a.processors.Register(services.NewWebhookProcessor(webhookService))
+    }
+
+    // Initialize the notifications, they are optional and only enabled when NOTIFICATIONS is enabled. The alerts are
+    // sent to the recipients of ALERT_NOTIFICATIONS by email, text message or Slack.
+    notificationDispatcher, recipients, err := a.notificationDispatcher(ctx)
+    if err != nil {
+        a.shutdown <- err
+        return
+    }
+    if notificationDispatcher != nil {
+        alertsPublisher = services.NewNotificationPublisher(alertsPublisher, notificationDispatcher, recipients)
+    }
+
+    // Initialize the motion alerts, vehicles entering or exiting the geofences and speeding vehicles are alerted about
+    motionMonitor, err := a.motionMonitor(ctx, alertsPublisher)
+    if err != nil {
+        a.shutdown <- err
+        return
+    }
+    if motionMonitor != nil {
+        a.processors.Register(motionMonitor)
+    }
+
+    // Initialize the stale vehicle watchdog, vehicles that stop reporting for STALE_AFTER are alerted about
+    staleVehicleService := a.staleVehicleService(ctx, vehicleStateRepo, alertsPublisher, accessService)
+
+    // Initialize the safety scores, the speeding, harsh events and idling of every reading are counted per day
+    safetyScoreService, err := a.safetyScoreService(ctx, alertsPublisher, accessService)
+    if err != nil {
+        a.shutdown <- err
+        return
+    }
+
+    // Initialize the idle detection, the periods active vehicles stand still are recorded for the idling report
+    idleService, err := a.idleService(ctx, accessService)
+    if err != nil {
+        a.shutdown <- err
+        return
+    }
+
+    // Initialize the alert rules, the readings are compared with the thresholds of the rules of their vehicle
+    alertRuleService, err := a.alertRuleService(ctx, alertsPublisher, notificationDispatcher, accessService)
+    if err != nil {
+        a.shutdown <- err
+        return
+    }
+
+    // Initialize the fuel anomaly service, every stored reading is compared with the previous one of the vehicle
+    fuelAnomalyRepo := repositories.NewMongoFuelAnomalyRepository(a.db.Database("tracking"))
+    fuelAnomalyService := services.NewMongoFuelAnomalyService(
+        trackingRepo,
+        fuelAnomalyRepo,
+        alertsPublisher,
+        a.cfg.FuelAnomalyMileagePerLevelValue(),
+    )
+    fuelAnomalyHandler := handler.NewV1FuelAnomalyHandler(fuelAnomalyService)
+
+    // Initialize the odometer service, it accumulates the distance traveled from the coordinates of the readings,
+    // matched to the road network first with MAP_MATCHING_INGEST
+    odometerRepo := repositories.NewMongoOdometerRepository(a.db.Database("tracking"))
+    odometerService := services.NewMongoOdometerService(odometerRepo, trackingRepo)
+    if a.cfg.MapMatchingIngestEnabled() {
+        odometerService = services.NewMapMatchingOdometerService(odometerRepo, trackingRepo, a.mapMatcher)
+        log.Println("Matching the readings to the road network on ingestion")
+    }
+
+    // Publish the maintenance events to the event exchange, the maintenance queue is bound to them
+    maintenancePublisher, err := a.eventPublisher(
+        channel,
+        a.newPublisher(channel, a.cfg.MaintenanceQueue),
+        a.cfg.MaintenanceQueue,
+        services.EventRoutingKey,
+        services.MaintenanceDueEvent,
+    )
+    if err != nil {
+        a.shutdown <- err
+        return
+    }
+
+    // Initialize the maintenance service, maintenance is due whenever the mileage crosses a threshold
+    maintenanceRepo := repositories.NewMongoMaintenanceRepository(a.db.Database("tracking"))
+    maintenanceService := services.NewMongoMaintenanceService(
+        trackingRepo,
+        maintenanceRepo,
+        maintenancePublisher,
+        a.cfg.MaintenanceIntervalValue(),
+    )
+    maintenanceHandler := handler.NewV1MaintenanceHandler(maintenanceService, a.validator)
+
+    // Initialize the freshness service, latest positions report how old they are against the expected interval
+    expectedIntervalRepo := repositories.NewMongoExpectedIntervalRepository(a.db.Database("tracking"))
+    freshnessService := services.NewMongoFreshnessService(
+        expectedIntervalRepo,
+        a.cfg.ExpectedReportIntervalDuration(),
+    )
+    freshnessHandler := handler.NewV1FreshnessHandler(freshnessService, a.validator)
+
+    // Initialize the driver assignments, the readings sent without a driver are attributed to the assigned one
+    driverAssignmentRepo := repositories.NewMongoDriverAssignmentRepository(a.db.Database("tracking"))
+    if err = driverAssignmentRepo.CreateIndexes(ctx); err != nil {
+        a.shutdown <- err
+        return
+    }
+    a.processors.Register(services.NewDriverAssignmentProcessor(driverAssignmentRepo))
+    driverHandler := handler.NewV1DriverHandler(services.NewMongoDriverService(driverAssignmentRepo), a.validator)
+
+    // Initialize the tracking poll service, waiting polls are notified of new readings by an ingestion processor
+    trackingNotifier := services.NewTrackingNotifier()
+    a.processors.Register(trackingNotifier)
+    trackingPollHandler := handler.NewV1TrackingPollHandler(
+        services.NewMongoTrackingPollService(trackingRepo, trackingNotifier, accessService),
+    )
+
+    // the processors, metrics and monitoring wrap the service storing the tracking data, also when it is replaced
+    baseTrackingService := a.trackingService
+    if baseTrackingService == nil {
+        baseTrackingService = services.NewMongoTrackingService(
+            trackingRepo,
+            odometerService,
+            freshnessService,
+            accessService,
+        )
+    }
+    // the readings failing the data quality checks are flagged or quarantined before the processors see them, when
+    // DATA_QUALITY is set
+    checkedTrackingService, quarantineRepo, err := a.checkDataQuality(
+        ctx,
+        services.NewProcessingTrackingService(baseTrackingService, a.processors),
+        vehicleStateRepo,
+    )
+    if err != nil {
+        a.shutdown <- err
+        return
+    }
+    // the readings of unknown vehicles are rejected before they are checked, when VEHICLE_SERVICE_URL is set. The
+    // device times are checked first, the data quality checks compare the times the readings are stored at.
+    validatingTrackingService, err := a.validateVehicles(channel, a.correctClockSkew(checkedTrackingService))
+    if err != nil {
+        a.shutdown <- err
+        return
+    }
+    var trackingService services.TrackingService = services.NewMaintenanceMonitoringTrackingService(
+        services.NewFuelMonitoringTrackingService(
+            services.NewInstrumentedTrackingService(
+                validatingTrackingService,
+                ingestRecorder,
+            ),
+            fuelAnomalyService,
+        ),
+        maintenanceService,
+    )
+
+    // Initialize the inactivity service, vehicles that don't move for INACTIVITY_DAYS are suggested inactive
+    inactivityService, err := a.inactivityService(ctx, channel)
+    if err != nil {
+        a.shutdown <- err
+        return
+    }
+    if a.cfg.InactivityPeriod() > 0 {
+        trackingService = services.NewInactivityMonitoringTrackingService(trackingService, inactivityService)
+    }
+    trackingService = a.applyRollupRoutes(trackingService)
+    statusSuggestionHandler := handler.NewV1StatusSuggestionHandler(inactivityService, a.validator)
+
+    // Declare the vehicle event queue with durable, the consumers migrating to the vehicle update events read it
+    if _, err = channel.QueueDeclare(a.cfg.VehicleEventQueueName(), true, false, false, false, nil); err != nil {
+        a.shutdown <- err
+        return
+    }
+
+    vehiclePublisher, err := a.vehiclePublisher(channel)
+    if err != nil {
+        a.shutdown <- err
+        return
+    }
+
+    // Initialize the vehicle event service, it publishes the stored tracking data in the legacy format, as vehicle
+    // update events or both while the consumers migrate
+    vehicleEventService := services.NewDualFormatVehicleEventService(
+        vehiclePublisher,
+        a.cfg.VehicleQueue,
+        a.newPublisher(channel, a.cfg.VehicleEventQueueName()),
+        a.cfg.VehicleEventQueueName(),
+        a.cfg.VehicleEventFormatValue(),
+        func(_ context.Context, queue string) (int, int, error) {
+            return a.inspectQueue(queue)
+        },
+    )
+    vehicleEventHandler := handler.NewV1VehicleEventHandler(vehicleEventService)
+    // the publish processors see the stored tracking data before any format is published
+    trackingPublisher := services.NewProcessingPublisher(vehicleEventService, a.processors)
+
+    // Initialize the quarantine service, the quarantined readings are released through the whole ingestion
+    var quarantineService *services.MongoQuarantineService
+    if quarantineRepo != nil {
+        quarantineService = services.NewMongoQuarantineService(quarantineRepo, trackingService, trackingPublisher)
+    }
+
+    // Initialize the ingestion error service, rejected readings from both AMQP and HTTP end up here
+    ingestionErrorRepo := repositories.NewMongoIngestionErrorRepository(a.db.Database("tracking"))
+    ingestionErrorService := services.NewMongoIngestionErrorService(ingestionErrorRepo)
+    ingestionErrorHandler := handler.NewV1IngestionErrorHandler(ingestionErrorService)
+
+    trackingHandler := handler.NewV1TrackingHandler(
+        trackingService,
+        ingestionErrorService,
+        services.NewMongoTrackingDeletionService(
+            trackingRepo,
+            repositories.NewMongoDeletionAuditRepository(a.db.Database("tracking")),
+        ),
+        trackingPublisher,
+        a.cfg.PublishTimeoutDuration(),
+        a.validator,
+    )
+    // API v2 serves the tracking data with the services of v1
+    v2TrackingHandler := handler.NewV2TrackingHandler(trackingHandler)
+
+    // Initialize the tracking stats service
+    trackingStatsService := services.NewMongoTrackingStatsService(trackingStatsRepo, accessService)
+    trackingStatsHandler := handler.NewV1TrackingStatsHandler(trackingStatsService)
+
+    // Initialize the digest service, it emails the summaries of the fleet to the subscribed tenants
+    digestService, err := a.digestService(ctx, trackingStatsRepo)
+    if err != nil {
+        a.shutdown <- err
+        return
+    }
+
+    // Initialize the connectivity service, the SLA report is computed from daily rollups of the readings
+    connectivityService := services.NewMongoConnectivityService(
+        trackingRepo,
+        repositories.NewMongoConnectivityRepository(a.db.Database("tracking")),
+        freshnessService,
+        accessService,
+    )
+    connectivityHandler := handler.NewV1ConnectivityHandler(connectivityService)
+    if a.cfg.ConnectivityRollupsEnabled() {
+        go services.NewConnectivityScheduler(connectivityService, a.cfg.ReportDelayDuration()).Run(ctx)
+        log.Println("Connectivity rollups enabled")
+    }
+
+    // Initialize the vehicle state service, the states of the vehicles tracked before are backfilled once
+    vehicleStateService := services.NewMongoVehicleStateService(vehicleStateRepo, trackingRepo, accessService)
+    vehicleStateHandler := handler.NewV1VehicleStateHandler(vehicleStateService)
+    go func() {
+        backfilled, err := vehicleStateService.Backfill(ctx)
+        if err != nil {
+            log.Println("Failed to backfill the vehicle states: ", err)
+            return
+        }
+        if backfilled > 0 {
+            log.Printf("Backfilled the state of %d vehicles", backfilled)
+        }
+    }()
+
+    // Initialize the public statistics service, municipal partners query noisy zone counts with their API keys
+    publicStatsService := services.NewNoisyPublicStatsService(
+        trackingStatsRepo,
+        repositories.NewMongoDisclosureAuditRepository(a.db.Database("tracking")),
+        services.PublicStatsPrivacy{
+            Epsilon:     a.cfg.PublicStatsEpsilonValue(),
+            MinVehicles: a.cfg.PublicStatsMinVehiclesValue(),
+            ZoneDegrees: a.cfg.PublicStatsZoneDegreesValue(),
+            NoiseKey:    []byte(a.cfg.PublicStatsNoiseKey),
+        },
+        a.cfg.PublicStatsPartnersValue(),
+    )
+    publicStatsHandler := handler.NewV1PublicStatsHandler(publicStatsService)
+
+    // Initialize the vendor service, vendors only see the ingestion errors and health of their own devices
+    vendorRepo := repositories.NewMongoVendorRepository(a.db.Database("tracking"))
+    vendorService := services.NewMongoVendorService(vendorRepo, ingestionErrorRepo, trackingStatsRepo)
+    vendorHandler := handler.NewV1VendorHandler(vendorService, a.validator)
+
+    // Initialize the deployment health service
+    deploymentHealthService := services.NewMetricsDeploymentHealthService(
+        ingestRecorder,
+        services.DeploymentBaseline{
+            ErrorRate:         a.cfg.DeployBaselineErrorRateValue(),
+            MaxErrorRateDelta: a.cfg.DeployMaxErrorRateDeltaValue(),
+            LatencyP95:        a.cfg.DeployBaselineLatencyP95Duration(),
+            MaxLatencyDelta:   a.cfg.DeployMaxLatencyDeltaValue(),
+            MinSamples:        a.cfg.DeployMinSamplesValue(),
+        },
+    )
+    deploymentHealthHandler := handler.NewV1DeploymentHealthHandler(deploymentHealthService)
+
+    // Initialize the readiness service, the service is ready once the cache is primed and only primes when the
+    // cache is enabled
+    primeWindow := a.cfg.CachePrimeWindowDuration()
+    if a.redis == nil {
+        primeWindow = 0
+    }
+    a.readiness = services.NewCachePrimingReadinessService(trackingRepo, primeWindow, a.cfg.CachePrimeVehiclesValue())
+    readinessHandler := handler.NewV1ReadinessHandler(a.readiness)
+
+    // Initialize the diagnostics service, it collects the bundle for support tickets
+    diagnosticsHandler := handler.NewV1DiagnosticsHandler(a.diagnosticsService(ingestRecorder))
+
+    // Initialize the deprecation service, it announces the deprecated features and counts who still uses them
+    deprecatedFeatures := append(deprecations(), a.deprecations...)
+    deprecationService := services.NewMetricsDeprecationService(
+        deprecatedFeatures,
+        metrics.NewDeprecationRecorder(),
+        a.cfg.SunsetEnforcementEnabled(),
+    )
+    deprecationHandler := handler.NewV1DeprecationHandler(deprecationService)
+
+    // Initialize the OpenAPI handler, it serves the document of the API routes
+    openAPIHandler := handler.NewV1OpenAPIHandler(
+        openAPIDocument(
+            deprecatedFeatures,
+            a.cfg.SimulationEnabled(),
+            a.cfg.ClickHouseEnabled(),
+            a.cfg.TrackingRollupsEnabled(),
+            staleVehicleService != nil,
+            webhookService != nil,
+            safetyScoreService != nil,
+            idleService != nil,
+            alertRuleService != nil,
+            digestService != nil,
+            quarantineService != nil,
+        ),
+    )
+
+    // Initialize the access audit service, it records who queried or changed which data
+    accessAuditService := services.NewMongoAccessAuditService(
+        repositories.NewMongoAccessAuditRepository(a.db.Database("tracking")),
+    )
+    accessAuditHandler := handler.NewV1AccessAuditHandler(accessAuditService)
+
+    // Initialize the geofence service
+    geofenceRepo := repositories.NewMongoGeofenceRepository(a.db.Database("tracking"))
+    geofenceService := services.NewMongoGeofenceService(geofenceRepo)
+    geofenceHandler := handler.NewV1GeofenceHandler(geofenceService)
+
+    // Set up the report scheduler, it is optional and only enabled when report periods are configured
+    if err = a.startReportScheduler(ctx, channel, trackingRepo); err != nil {
+        a.shutdown <- err
+        return
+    }
+
+    // Set up the snapshot scheduler, it is optional and only enabled with SNAPSHOTS
+    if err = a.startSnapshotScheduler(ctx, trackingRepo); err != nil {
+        a.shutdown <- err
+        return
+    }
+
+    // the thresholds and the vehicle event format are reloaded when the config file changes
+    a.watchConfig(
+        ctx,
+        &tunables{
+            fuelAnomaly:   fuelAnomalyService,
+            maintenance:   maintenanceService,
+            freshness:     freshnessService,
+            vehicleEvents: vehicleEventService,
+        },
+    )
+
+    // Start consuming messages from the declared queue, the consumer can be paused by the admins and is cancelled on
+    // shutdown
+    a.consumer = services.NewRabbitConsumer(
+        channel,
+        a.cfg.TrackingQueue,
+        consumerTag(),
+        func(trackingDataMessages <-chan amqp.Delivery) {
+            a.Consume(ctx, trackingPublisher, trackingDataMessages, trackingService, ingestionErrorService)
+        },
+    )
+    if err = a.consumer.Start(); err != nil {
+        a.shutdown <- err
+        return
+    }
+    consumerHandler := handler.NewV1ConsumerHandler(a.consumer)
+
+    // Set up the HTTP server
+    server := handler.NewRouter()
+
+    // Historical queries can be cached by CDNs, when HISTORICAL_CACHE_MAX_AGE is set
+    historical := a.historicalCache()
+    // Retried ingestion requests with the same Idempotency-Key are answered without storing the readings again
+    idempotent, err := a.idempotent(ctx)
+    if err != nil {
+        a.shutdown <- err
+        return
+    }
+
+    // Set up the API routes, a request with a method the path isn't registered for is answered 405
+    v1Router := handler.NewRouter()                                                                                 // API version 1 router
+    v1Router.Get("/api/v1/tracking-data", historical(trackingHandler.FindTrackingData))                             // Tracking data find
+    v1Router.Post("/api/v1/tracking-data", idempotent(trackingHandler.CreateTrackingData))                          // Tracking data creation
+    v1Router.Delete("/api/v1/tracking-data", trackingHandler.DeleteTrackingData)                                    // Tracking data deletion
+    v1Router.Post("/api/v1/tracking-data/batch", idempotent(trackingHandler.CreateTrackingDataBatch))               // Batch ingestion
+    v1Router.Post("/api/v1/tracking-data/batch-query", trackingHandler.BatchQueryTrackingData)                      // Latest points of many vehicles
+    v1Router.Get("/api/v1/tracking-data/deletions", trackingHandler.FindDeletionAudits)                             // Audit of deleted tracking data
+    v1Router.Get("/api/v1/tracking-data/export", historical(trackingHandler.ExportTrackingData))                    // Streamed file export
+    v1Router.Get("/api/v1/tracking-data/poll", trackingPollHandler.PollTrackingData)                                // Long-poll for new readings
+    v1Router.Get("/api/v1/tracking-data/route", historical(trackingHandler.FindRoute))                              // Route replay, optionally downsampled
+    v1Router.Get("/api/v1/tracking-data/stats", historical(trackingStatsHandler.TrackingDataStats))                 // Per-vehicle statistics
+    v1Router.Get("/api/v1/tracking-data/heatmap", historical(trackingStatsHandler.TrackingDataHeatmap))             // Readings per map tile
+    v1Router.Get("/api/v1/tracking-data/sla", connectivityHandler.SLAReport)                                        // Monthly connectivity per vehicle
+    v1Router.Get("/api/v1/tracking-data/{id}", trackingHandler.FindTrackingDataByID)                                // A single tracking data by ObjectID or public id
+    v1Router.Get("/api/v1/vehicles/state", vehicleStateHandler.States)                                              // Current state of every vehicle
+    v1Router.Get("/api/v1/vehicles/{vehicleID}/tracking-data", historical(trackingHandler.FindVehicleTrackingData)) // Tracking data of a vehicle
+    v1Router.Get("/api/v1/drivers/{driverID}/tracking-data", historical(trackingHandler.FindDriverTrackingData))    // Tracking data of a driver
+    v1Router.Get("/api/v1/geofences/export", geofenceHandler.ExportGeofences)                                       // GeoJSON export of all geofences
+    v1Router.Post("/api/v1/geofences/import", geofenceHandler.ImportGeofences)                                      // GeoJSON import, supports dry_run
+    v1Router.Get("/api/v1/ingestion-errors", ingestionErrorHandler.FindIngestionErrors)                             // Rejected readings log
+    v1Router.Get("/api/v1/fuel-anomalies", fuelAnomalyHandler.FindFuelAnomalies)                                    // Detected fuel anomalies
+    v1Router.Get("/api/v1/maintenance/thresholds", maintenanceHandler.FindThresholds)                               // Per-vehicle maintenance intervals
+    v1Router.Put("/api/v1/maintenance/thresholds", maintenanceHandler.SetThreshold)                                 // Set a maintenance interval
+    v1Router.Get("/api/v1/maintenance/events", maintenanceHandler.FindEvents)                                       // Crossed maintenance thresholds
+    v1Router.Get("/api/v1/status-suggestions", statusSuggestionHandler.FindStatusSuggestions)                       // Suggested vehicle status changes
+    v1Router.Put("/api/v1/status-suggestions/{id}", statusSuggestionHandler.ResolveStatusSuggestion)                // Accept or dismiss a suggestion
+    v1Router.Get("/api/v1/expected-intervals", freshnessHandler.FindExpectedIntervals)                              // Per-vehicle expected report intervals
+    v1Router.Put("/api/v1/expected-intervals", freshnessHandler.SetExpectedInterval)                                // Set an expected report interval
+    v1Router.Get("/api/v1/vehicle-assignments", accessHandler.FindAssignments)                                      // Vehicle assignments for access control
+    v1Router.Put("/api/v1/vehicle-assignments", accessHandler.SetAssignment)                                        // Assign a vehicle
+    v1Router.Get("/api/v1/user-memberships", accessHandler.FindMemberships)                                         // User memberships for access control
+    v1Router.Put("/api/v1/user-memberships", accessHandler.SetMembership)                                           // Put a user in an organization and fleet groups
+    v1Router.Get("/api/v1/driver-assignments", driverHandler.FindAssignments)                                       // Drivers assigned to the vehicles
+    v1Router.Put("/api/v1/driver-assignments", driverHandler.SetAssignment)                                         // Assign a driver to a vehicle
+    v1Router.Delete("/api/v1/driver-assignments/{vehicleID}", driverHandler.DeleteAssignment)                       // Unassign the driver of a vehicle
+    v1Router.Get("/api/v1/diagnostics", diagnosticsHandler.Diagnostics)                                             // Diagnostics bundle for support tickets
+    v1Router.Post("/api/v1/admin/consumer/pause", consumerHandler.PauseConsumer)                                    // Stop consuming the tracking queue
+    v1Router.Post("/api/v1/admin/consumer/resume", consumerHandler.ResumeConsumer)                                  // Consume the tracking queue again
+    v1Router.Get("/api/v1/access-audits", accessAuditHandler.FindAccessAudits)                                      // Audit of API requests
+    v1Router.Get("/api/v1/disclosure-audits", publicStatsHandler.FindDisclosureAudits)                              // Audit of disclosed public statistics
+    v1Router.Get("/api/v1/deprecations", deprecationHandler.Deprecations)                                           // Deprecated features and their callers
+    v1Router.Get("/api/v1/vehicle-events", vehicleEventHandler.VehicleEventStats)                                   // Published event formats and their consumers
+    v1Router.Get("/api/v1/vendors", vendorHandler.FindVendors)                                                      // Vendor list
+    v1Router.Post("/api/v1/vendors", vendorHandler.CreateVendor)                                                    // Vendor registration
+    v1Router.Put("/api/v1/vendors/devices", vendorHandler.SetVendorDevices)                                         // Vendor device registration
+    v1Router.Delete("/api/v1/vendors/{id}/key", vendorHandler.RevokeVendorKey)                                      // Vendor API key revocation
+    v1Router.Get("/api/v1/openapi.json", openAPIHandler.OpenAPI)                                                    // OpenAPI document of the API
+
+    // Swagger UI is optional, the OpenAPI document is always served
+    if a.cfg.OpenAPIUIEnabled() {
+        v1Router.Get("/api/v1/docs", openAPIHandler.SwaggerUI)
+    }
+
+    // Simulation is optional, it is meant for staging and demos
+    if a.cfg.SimulationEnabled() {
+        simulationHandler := handler.NewV1SimulationHandler(
+            services.NewRealTimeSimulationService(a.newSimulationPublisher(channel)),
+            a.validator,
+        )
+        v1Router.Get("/api/v1/simulation", simulationHandler.SimulationStatus)         // Status of the simulation
+        v1Router.Post("/api/v1/simulation", simulationHandler.StartSimulation)         // Start the simulation
+        v1Router.Delete("/api/v1/simulation", simulationHandler.StopSimulation)        // Stop the simulation
+        v1Router.Put("/api/v1/simulation/speed", simulationHandler.SetSimulationSpeed) // Speed of the simulated time
+        log.Println("Simulation enabled")
+    }
+
+    // The history API is optional, it is only served when the tracking data is mirrored into ClickHouse
+    if a.history != nil {
+        historyHandler := handler.NewV1HistoryHandler(services.NewClickHouseHistoryService(a.history, accessService))
+        v1Router.Get("/api/v1/history/heatmap", historyHandler.Heatmap)         // Readings per cell over the history
+        v1Router.Get("/api/v1/history/utilization", historyHandler.Utilization) // Utilization per vehicle and period
+    }
+
+    // The rollups are optional, they are only served when TRACKING_ROLLUPS is enabled
+    if a.rollups != nil {
+        rollupHandler := handler.NewV1TrackingRollupHandler(a.rollups)
+        v1Router.Get("/api/v1/tracking-data/rollups", rollupHandler.Rollups) // 5 minute and hourly summaries
+    }
+
+    // The stale vehicles are optional, they are only served when STALE_AFTER is set
+    if staleVehicleService != nil {
+        staleVehicleHandler := handler.NewV1StaleVehicleHandler(staleVehicleService)
+        v1Router.Get("/api/v1/vehicles/stale", staleVehicleHandler.Stale) // Vehicles that stopped reporting
+    }
+
+    // The safety scores are optional, they are only served when SAFETY_SCORING is enabled
+    if safetyScoreService != nil {
+        safetyScoreHandler := handler.NewV1SafetyScoreHandler(safetyScoreService)
+        v1Router.Get("/api/v1/scores", safetyScoreHandler.FindScores)            // Rolling safety scores
+        v1Router.Get("/api/v1/scores/daily", safetyScoreHandler.FindDailyScores) // Safety scores per day
+    }
+
+    // The idling is optional, it is only served when IDLE_DETECTION is enabled
+    if idleService != nil {
+        idleHandler := handler.NewV1IdleHandler(idleService)
+        v1Router.Get("/api/v1/idling/segments", idleHandler.FindSegments) // Periods active vehicles stood still
+        v1Router.Get("/api/v1/idling/report", idleHandler.FindReport)     // Idle time and wasted fuel per day
+    }
+
+    // The alert rules are optional, they are only served when ALERT_RULES is enabled
+    if alertRuleService != nil {
+        alertRuleHandler := handler.NewV1AlertRuleHandler(alertRuleService, a.validator)
+        v1Router.Get("/api/v1/alert-rules", alertRuleHandler.FindAlertRules)          // Alert rules
+        v1Router.Post("/api/v1/alert-rules", alertRuleHandler.CreateAlertRule)        // Alert rule creation
+        v1Router.Put("/api/v1/alert-rules/{id}", alertRuleHandler.UpdateAlertRule)    // Alert rule update
+        v1Router.Delete("/api/v1/alert-rules/{id}", alertRuleHandler.DeleteAlertRule) // Alert rule removal
+        v1Router.Get("/api/v1/alerts", alertRuleHandler.FindAlerts)                   // Alerts raised by the rules
+    }
+
+    // The quarantine is optional, it is only served when DATA_QUALITY is quarantine
+    if quarantineService != nil {
+        quarantineHandler := handler.NewV1QuarantineHandler(quarantineService)
+        v1Router.Get("/api/v1/quarantined-readings", quarantineHandler.FindQuarantinedReadings)                 // Readings to review
+        v1Router.Post("/api/v1/quarantined-readings/{id}/release", quarantineHandler.ReleaseQuarantinedReading) // Store a reviewed reading
+        v1Router.Delete("/api/v1/quarantined-readings/{id}", quarantineHandler.DiscardQuarantinedReading)       // Drop a reviewed reading
+    }
+
+    // The digests are optional, they are only served when DIGESTS is enabled
+    if digestService != nil {
+        digestHandler := handler.NewV1DigestHandler(digestService, a.validator)
+        v1Router.Get("/api/v1/digests", digestHandler.FindDigestSubscription) // Digest subscription of the tenant
+        v1Router.Put("/api/v1/digests", digestHandler.SaveDigestSubscription) // Digest subscription change
+        v1Router.Get("/api/v1/digests/preview", digestHandler.PreviewDigest)  // Digest of the last period
+    }
+
+    // The webhooks are optional, they are only served when WEBHOOKS is enabled
+    if webhookService != nil {
+        webhookHandler := handler.NewV1WebhookHandler(webhookService, a.validator)
+        v1Router.Get("/api/v1/webhooks", webhookHandler.FindWebhooks)                   // Registered webhooks
+        v1Router.Post("/api/v1/webhooks", webhookHandler.CreateWebhook)                 // Webhook registration
+        v1Router.Put("/api/v1/webhooks/{id}", webhookHandler.UpdateWebhook)             // Webhook update
+        v1Router.Delete("/api/v1/webhooks/{id}", webhookHandler.DeleteWebhook)          // Webhook removal
+        v1Router.Get("/api/v1/webhooks/{id}/deliveries", webhookHandler.FindDeliveries) // Delivery log
+    }
+
+    // Routes added by an embedding service
+    for _, routes := range a.routes {
+        routes(v1Router.ServeMux)
+    }
+
+    // Set up the vendor portal routes, authenticated by vendor API keys instead of the auth service
+    vendorRouter := handler.NewRouter()                                                          // Vendor portal router
+    vendorRouter.Get("/api/v1/vendor/ingestion-errors", vendorHandler.FindVendorIngestionErrors) // Own devices' rejected readings
+    vendorRouter.Get("/api/v1/vendor/device-health", vendorHandler.FindVendorDeviceHealth)       // Own devices' health
+
+    // Set up the API version 2 routes, they coexist with v1 until the deprecated v1 routes reach their sunset
+    v2Router := handler.NewRouter()                                                                       // API version 2 router
+    v2Router.Get("/api/v2/tracking-data", v2TrackingHandler.FindTrackingData)                             // Tracking data find, paged by cursor
+    v2Router.Post("/api/v2/tracking-data", idempotent(v2TrackingHandler.CreateTrackingData))              // Tracking data creation
+    v2Router.Get("/api/v2/tracking-data/{id}", v2TrackingHandler.FindTrackingDataByID)                    // A single tracking data
+    v2Router.Get("/api/v2/vehicles/{vehicleID}/tracking-data", v2TrackingHandler.FindVehicleTrackingData) // Tracking data of a vehicle
+
+    // The deprecations are announced on the v1 routes, v2 has none yet
+    versions := http.NewServeMux()
+    versions.Handle("/", handler.DeprecationMiddleware(deprecationService, v1Router)(v1Router))
+    versions.Handle("/api/v2/", v2Router)
+
+    // Apply middlewares and handle requests
+    // The v1Router (which holds our API routes) will have two middlewares applied:
+    // - CorsMiddleware: Adds CORS headers to the response
+    // - LoggingMiddleware: Logs each incoming request for debugging and monitoring
+    // - AuthorizationMiddleware: Authorizes the request using the auth service
+    // - VerifySignatureMiddleware: Verifies the request's signature (ensuring it's from a trusted source)
+    // - AccessLogIdentityMiddleware: Notes the authenticated user on the access log entry, when ACCESS_LOG is set
+    // - the middlewares added with WithMiddleware, in the order they were added
+    // - TenantMiddleware: Resolves the tenant the data is limited to, when MULTI_TENANCY is enabled
+    // - ClaimsMiddleware: Resolves the user claims for access control, when ACCESS_CONTROL is enabled
+    // - PolicyMiddleware: Authorizes the admin actions and exports with the policy engine, when POLICY_URL is set
+    // - AccessAuditMiddleware: Records who made the request in the access audit, when ACCESS_AUDIT is enabled
+    // - CompressionMiddleware: Compresses the responses with gzip or deflate, when RESPONSE_COMPRESSION is enabled
+    // - EmptyResultsMiddleware: Answers list queries without results with 200, when EMPTY_RESULTS is enabled
+    // - DeprecationMiddleware: Announces the deprecated features a v1 request uses and counts their callers
+    // The v2Router gets the same middlewares except the DeprecationMiddleware
+    server.Handle(
+        "/",
+        common.CorsMiddleware(nil)(
+            common.LoggingMiddleware(log.Default())(
+                common.AuthorizationMiddleware[models.AuthUser](a.cfg.AuthSvc, a.cfg.SignatureKey)(
+                    common.VerifySignatureMiddleware(a.cfg.SignatureKey)(
+                        handler.AccessLogIdentityMiddleware()(
+                            a.applyMiddlewares(
+                                a.applyTenancy(
+                                    accessService,
+                                    a.applyAccessControl(
+                                        accessService,
+                                        a.applyPolicy(
+                                            a.applyAccessAudit(
+                                                accessAuditService,
+                                                a.applyCompression(a.applyEmptyResults(versions)),
+                                            ),
+                                        ),
+                                    ),
+                                ),
+                            ),
+                        ),
+                    ),
+                ),
+            ),
+        ),
+    )
+
+    // The deployment health is polled by the CD system, it doesn't go through the auth service
+    server.Get("/api/v1/deployment-health", deploymentHealthHandler.DeploymentHealth)
+
+    // The readiness is polled by the load balancer, it doesn't go through the auth service either
+    server.Get("/readyz", readinessHandler.Readiness)
+
+    server.Handle(
+        "/api/v1/vendor/",
+        common.CorsMiddleware(nil)(
+            common.LoggingMiddleware(log.Default())(
+                handler.VendorAPIKeyMiddleware(vendorService)(
+                    a.applyEmptyResults(vendorRouter),
+                ),
+            ),
+        ),
+    )
+
+    // The public statistics are authenticated by partner API keys, they are only served when partners are set up
+    if a.cfg.PublicStatsEnabled() {
+        publicRouter := handler.NewRouter()                                         // Public statistics router
+        publicRouter.Get("/api/v1/public/zone-stats", publicStatsHandler.ZoneStats) // Noisy vehicles per zone and hour
+        server.Handle(
+            "/api/v1/public/",
+            common.CorsMiddleware(nil)(
+                common.LoggingMiddleware(log.Default())(
+                    handler.PartnerAPIKeyMiddleware(publicStatsService)(
+                        publicRouter,
+                    ),
+                ),
+            ),
+        )
+        log.Println("Public statistics enabled")
+    }
+
+    // The access log is written for every route, it is optional and only enabled when a sink is configured
+    root, err := a.applyAccessLog(server)
+    if err != nil {
+        a.shutdown <- err
+        return
+    }
+
+    // The server of WithServer keeps its settings, like timeouts and TLS, it serves the routes of the app
+    if a.server == nil {
+        a.server = &http.Server{}
+    }
+    if a.server.Addr == "" {
+        a.server.Addr = a.cfg.Host + ":" + a.cfg.Port
+    }
+    a.server.Handler = root
+
+    log.Println("Vehicle service started on: ", a.server.Addr)
+
+    // Start the HTTP server in a goroutine
+    go func(server *http.Server) {
+        var err error
+        if server.TLSConfig != nil {
+            err = server.ListenAndServeTLS("", "")
+        } else {
+            err = server.ListenAndServe()
+        }
+        if !errors.Is(err, http.ErrServerClosed) {
+            a.shutdown <- err
+        }
+    }(a.server)
+
+    // /readyz reports ready once the cache is primed, the server is already up so it can report it isn't yet
+    go a.primeCache(ctx)
+}
+
+// applyTenancy requires the tenant on every API route when multi-tenancy is enabled
+func (a *App) applyTenancy(accessService services.AccessService, h http.Handler) http.Handler {
+    if !a.cfg.MultiTenancyEnabled() {
+        return h
+    }
+    tenants := a.tenants
+    if tenants == nil {
+        tenants = handler.AuthUserTenant(accessService)
+    }
+    return handler.TenantMiddleware(tenants)(h)
+}
+
+// applyAccessControl requires the user claims on every API route when access control is enabled
+func (a *App) applyAccessControl(accessService services.AccessService, h http.Handler) http.Handler {
+    if !a.cfg.AccessControlEnabled() {
+        return h
+    }
+    claims := a.claims
+    if claims == nil {
+        claims = handler.AuthUserClaims(accessService)
+    }
+    return handler.ClaimsMiddleware(claims)(h)
+}
+
+// applyAccessAudit records every API request when the access audit is enabled
+func (a *App) applyAccessAudit(accessAuditService services.AccessAuditService, h http.Handler) http.Handler {
+    if !a.cfg.AccessAuditEnabled() {
+        return h
+    }
+    return handler.AccessAuditMiddleware(accessAuditService)(h)
+}
+
+// applyCompression compresses the API responses when RESPONSE_COMPRESSION is enabled
+func (a *App) applyCompression(h http.Handler) http.Handler {
+    if !a.cfg.ResponseCompressionEnabled() {
+        return h
+    }
+    return handler.CompressionMiddleware()(h)
+}
+
+// applyEmptyResults answers the list queries without results with an empty data array when EMPTY_RESULTS is enabled
+func (a *App) applyEmptyResults(h http.Handler) http.Handler {
+    if !a.cfg.EmptyResultsEnabled() {
+        return h
+    }
+    return handler.EmptyResultsMiddleware()(h)
+}
+
+// applyAccessLog writes the access log of the handler when ACCESS_LOG is set
+func (a *App) applyAccessLog(h http.Handler) (http.Handler, error) {
+    if a.cfg.AccessLog == "" {
+        return h, nil
+    }
+    logger, closer, err := accesslog.Open(a.cfg.AccessLog, accesslog.Format(a.cfg.AccessLogFormatValue()))
+    if err != nil {
+        return nil, err
+    }
+    a.accessLog = closer
+    log.Println("Access log enabled, writing to: ", a.cfg.AccessLog)
+    return handler.AccessLogMiddleware(logger)(h), nil
+}
+
+// applyMiddlewares wraps the handler with the middlewares added by an embedding service, the first is outermost
+func (a *App) applyMiddlewares(h http.Handler) http.Handler {
+    for i := len(a.middlewares) - 1; i >= 0; i-- {
+        h = a.middlewares[i](h)
+    }
+    return h
+}
+
+// startReportScheduler starts generating the configured reports in the background
+func (a *App) startReportScheduler(
+    ctx context.Context,
+    channel *amqp.Channel,
+    trackingRepo repositories.TrackingRepository,
+) error {
+    periods := make([]services.ReportPeriod, 0)
+    for _, period := range a.cfg.ReportPeriodList() {
+        if err := services.ReportPeriod(period).Valid(); err != nil {
+            return err
+        }
+        periods = append(periods, services.ReportPeriod(period))
+    }
+    if len(periods) == 0 {
+        return nil
+    }
+
+    // Publish the report events to the event exchange, the report queue is bound to them
+    reportPublisher, err := a.eventPublisher(
+        channel,
+        services.NewRabbitPublisher(channel, a.cfg.ReportQueue),
+        a.cfg.ReportQueue,
+        services.EventRoutingKey,
+        services.ReportReadyEvent,
+    )
+    if err != nil {
+        return err
+    }
+
+    reportStorage, err := storage.NewS3Storage(
+        a.cfg.S3Endpoint,
+        a.cfg.S3Region,
+        a.cfg.S3Bucket,
+        a.cfg.S3AccessKeyID,
+        a.cfg.S3SecretAccessKey,
+    )
+    if err != nil {
+        return err
+    }
+
+    reportService := services.NewMongoReportService(
+        trackingRepo,
+        a.distance,
+        reportStorage,
+        reportStorage.Bucket(),
+        reportPublisher,
+    )
+    go services.NewReportScheduler(reportService, periods, a.cfg.ReportDelayDuration()).Run(ctx)
+
+    log.Println("Report scheduler started for periods: ", a.cfg.ReportPeriods)
+    return nil
+}
+
+// Shutdown gracefully shuts down the app
+func (a *App) Shutdown(ctx context.Context) error {
+    defer close(a.shutdown)
+
+    err := <-a.shutdown
+
+    // The load balancer stops sending requests while the service shuts down
+    if a.readiness != nil {
+        a.readiness.Stop()
+    }
+
+    // Write the buffered readings, the in-flight messages waiting for their batch are acknowledged
+    a.flushIngestBuffer()
+
+    // Stop consuming and let the in-flight messages finish before anything is closed
+    a.drain(ctx)
+
+    // Stop serving once the consumer is drained, the requests being served are waited for
+    if a.server != nil {
+        if err := a.server.Shutdown(ctx); err != nil {
+            log.Println("Failed to shut down the HTTP server", err)
+        }
+    }
+
+    // Stop the background workers
+    if a.cancel != nil {
+        a.cancel()
+    }
+    a.waitMirror(ctx)
+
+    a.disconnect(ctx)
+    return err
+}
+
+// disconnect closes the connections to the dependencies
+func (a *App) disconnect(ctx context.Context) {
+    // Disconnect from MongoDB
+    defer func(ctx context.Context, db *mongo.Client) {
+        if db == nil {
+            return
+        }
+        err := db.Disconnect(ctx)
+        if err != nil {
+            log.Println("Failed to disconnect from database", err)
+        }
+    }(ctx, a.db)
+
+    // Close RabbitMQ connection, a shared connection is closed by its owner
+    defer func(conn broker) {
+        if conn == nil || !a.ownsBroker {
+            return
+        }
+        err := conn.Close()
+        if err != nil {
+            log.Println("Failed to close rabbitmq connection", err)
+        }
+    }(a.rabbitConn)
+
+    // Close the access log file
+    defer func(accessLog io.Closer) {
+        if accessLog == nil {
+            return
+        }
+        if err := accessLog.Close(); err != nil {
+            log.Println("Failed to close access log", err)
+        }
+    }(a.accessLog)
+
+    // Close the Redis connections
+    defer func(redis *cache.RedisClient) {
+        if redis == nil {
+            return
+        }
+        if err := redis.Close(); err != nil {
+            log.Println("Failed to close redis connections", err)
+        }
+    }(a.redis)
+
+    // Close the PostgreSQL connections
+    defer func(db *sql.DB) {
+        if db == nil {
+            return
+        }
+        if err := db.Close(); err != nil {
+            log.Println("Failed to close postgres connections", err)
+        }
+    }(a.postgres)
+
+    // Close the RabbitMQ channel before its connection, the consumer was drained before
+    defer func(channel *amqp.Channel) {
+        if channel == nil {
+            return
+        }
+        if err := channel.Close(); err != nil {
+            log.Println("Failed to close rabbitmq channel", err)
+        }
+    }(a.channel)
+}
@@ -0,0 +1,133 @@
+package app
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+
+    "github.com/goccy/go-json"
+    amqp "github.com/rabbitmq/amqp091-go"
+    "github.com/yemyoaung/managing-vehicle-tracking-common"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/envelope"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/migration"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/plan"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+)
+
+var (
+    ErrBackfillColumns = errors.New("the row doesn't have the columns of the header")
+)
+
+// BackfillOptions are the options of Backfill, the queue and the publishing are set by it
+type BackfillOptions = migration.BackfillOptions
+
+// BackfillProgress is the progress of Backfill
+type BackfillProgress = migration.BackfillProgress
+
+// Backfill publishes the readings of r, JSONL or CSV by the format of the options, to the tracking queue without
+// starting the service, for the backfill command. The readings are flagged backfill and stored by the running service
+// like the other readings, at their recorded_at when they have one, the invalid ones are skipped. The tenant of the
+// context is required with multi-tenancy.
+func (a *App) Backfill(ctx context.Context, r io.Reader, opts BackfillOptions) (BackfillProgress, error) {
+    opts, err := a.backfillOptions(ctx, opts)
+    if err != nil {
+        return BackfillProgress{}, err
+    }
+    var progress BackfillProgress
+    err = a.withMigrationBroker(ctx, func(broker migration.Broker) (err error) {
+        progress, err = migration.Backfill(ctx, broker, r, opts)
+        return err
+    })
+    return progress, err
+}
+
+// PlanBackfill returns what Backfill would publish from r without publishing anything
+func (a *App) PlanBackfill(ctx context.Context, r io.Reader, opts BackfillOptions) (*plan.Plan, error) {
+    opts, err := a.backfillOptions(ctx, opts)
+    if err != nil {
+        return nil, err
+    }
+    return migration.PlanBackfill(ctx, r, opts)
+}
+
+// backfillOptions publishes the readings to the tracking queue, encrypted when the tracking data is
+func (a *App) backfillOptions(ctx context.Context, opts BackfillOptions) (BackfillOptions, error) {
+    if a.cfg == nil {
+        return opts, ErrConfigMissing
+    }
+    if a.cfg.MultiTenancyEnabled() {
+        id, _ := tenant.FromContext(ctx)
+        if err := tenant.Validate(id); err != nil {
+            return opts, err
+        }
+    }
+    cipher, err := a.trackingCipher()
+    if err != nil {
+        return opts, err
+    }
+    opts.Queue, opts.Publishing = a.cfg.TrackingQueue, a.backfillPublishing(cipher)
+    return opts, nil
+}
+
+// backfillPublishing validates the readings and publishes them flagged backfill, with the tenant of the context in
+// the headers like the readings published by the service
+func (a *App) backfillPublishing(
+    cipher *envelope.Cipher,
+) func(ctx context.Context, record *migration.BackfillRecord) (amqp.Publishing, error) {
+    return func(ctx context.Context, record *migration.BackfillRecord) (amqp.Publishing, error) {
+        reading, err := decodeBackfillReading(record)
+        if err != nil {
+            return amqp.Publishing{}, fmt.Errorf("%w: %w", migration.ErrInvalidReading, err)
+        }
+        reading.Backfill = true
+        if _, err := reading.ToTrackingRecord(); err != nil {
+            return amqp.Publishing{}, fmt.Errorf("%w: %w", migration.ErrInvalidReading, err)
+        }
+        body, err := json.Marshal(reading)
+        if err != nil {
+            return amqp.Publishing{}, err
+        }
+
+        publishing := amqp.Publishing{
+            ContentType:  common.ApplicationJSON,
+            DeliveryMode: amqp.Persistent,
+            Headers:      amqp.Table{},
+            Body:         body,
+        }
+        id, hasTenant := tenant.FromContext(ctx)
+        if hasTenant {
+            publishing.Headers[tenant.MessageHeader] = id
+        }
+        if cipher != nil {
+            sealed, err := cipher.Seal(ctx, id, a.cfg.EncryptionKeyIDValue(), body)
+            if err != nil {
+                return amqp.Publishing{}, err
+            }
+            for key, value := range sealed.Headers() {
+                publishing.Headers[key] = value
+            }
+            publishing.ContentType, publishing.Body = "application/octet-stream", sealed.Body
+        }
+        if len(publishing.Headers) == 0 {
+            publishing.Headers = nil
+        }
+        return publishing, nil
+    }
+}
+
+// decodeBackfillReading decodes the reading of a JSONL line or of the columns of a CSV row
+func decodeBackfillReading(record *migration.BackfillRecord) (*services.TrackingDataRequest, error) {
+    if record.JSON == nil {
+        if record.Columns == nil {
+            return nil, ErrBackfillColumns
+        }
+        return services.DecodeCSVReading(record.Columns)
+    }
+    var reading services.TrackingDataRequest
+    if err := json.Unmarshal(record.JSON, &reading); err != nil {
+        return nil, fmt.Errorf("%w: %w", services.ErrMalformedPayload, err)
+    }
+    return &reading, nil
+}
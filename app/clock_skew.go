@@ -0,0 +1,23 @@
+package app
+
+import (
+    "log"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+// correctClockSkew wraps the tracking service to store the live readings at their device_time when CLOCK_SKEW is
+// set, the readings of a device clock off by more than CLOCK_SKEW_TOLERANCE are corrected or flagged skewed
+func (a *App) correctClockSkew(trackingService services.TrackingService) services.TrackingService {
+    mode := a.cfg.ClockSkewMode()
+    if mode == "" {
+        return trackingService
+    }
+    rules := services.ClockSkewRules{Correct: mode == "correct", Tolerance: a.cfg.ClockSkewToleranceDuration()}
+    log.Printf(
+        "Using the device times of the readings, clocks off by more than %s are handled with: %s",
+        rules.Tolerance,
+        mode,
+    )
+    return services.NewClockSkewTrackingService(trackingService, rules)
+}
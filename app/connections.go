@@ -0,0 +1,163 @@
+package app
+
+import (
+    "context"
+    "crypto/tls"
+    "database/sql"
+    "fmt"
+    "log"
+    "sync"
+
+    _ "github.com/jackc/pgx/v5/stdlib"
+    amqp "github.com/rabbitmq/amqp091-go"
+    "github.com/yemyoaung/managing-vehicle-tracking-common"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tlsconfig"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// broker is the RabbitMQ connection the channels are opened on
+type broker interface {
+    Channel() (*amqp.Channel, error)
+    Close() error
+}
+
+// mongoOptions returns the options of the MongoDB client, with the TLS config of the MONGO_TLS files
+func (a *App) mongoOptions() (*options.ClientOptions, error) {
+    // operations without a deadline of their own, like the ones of background jobs, are bounded by MONGO_TIMEOUT
+    opts := options.Client().ApplyURI(a.cfg.DatabaseURL).SetTimeout(a.cfg.MongoTimeoutDuration())
+    if files := a.cfg.MongoTLSFiles(); files.Configured() {
+        tlsConfig, err := tlsconfig.New(files)
+        if err != nil {
+            return nil, err
+        }
+        opts.SetTLSConfig(tlsConfig)
+    }
+    return opts, nil
+}
+
+// postgresTrackingRepository connects to POSTGRES_URL with POSTGRES_DRIVER and creates the hypertable of the
+// tracking data when it doesn't exist. The pgx driver is registered by the import above, other drivers by the
+// embedding service.
+func (a *App) postgresTrackingRepository(ctx context.Context) (*repositories.PostgresTrackingRepository, error) {
+    db, err := sql.Open(a.cfg.PostgresDriverName(), a.cfg.PostgresURL)
+    if err != nil {
+        return nil, fmt.Errorf("failed to open postgres with the %s driver: %w", a.cfg.PostgresDriverName(), err)
+    }
+    a.postgres = db
+    if err = db.PingContext(ctx); err != nil {
+        return nil, err
+    }
+    repo := repositories.NewPostgresTrackingRepository(db)
+    if err = repo.CreateSchema(ctx); err != nil {
+        return nil, err
+    }
+    return repo, nil
+}
+
+// storedTrackingRepository returns the repository storing the tracking data of STORAGE_BACKEND, unless an embedding
+// service replaced it, and creates its indexes. The partitions are nil unless the tracking data is stored in monthly
+// partitions.
+func (a *App) storedTrackingRepository(
+    ctx context.Context,
+) (repositories.TrackingRepository, *repositories.TrackingPartitions, error) {
+    if a.trackingRepo != nil {
+        return a.trackingRepo, nil, nil
+    }
+    if a.cfg.StorageBackendMemory() {
+        log.Println("Tracking data is kept in memory, it is lost on restart")
+        return repositories.NewMemoryTrackingRepository(), nil, nil
+    }
+    if a.cfg.StorageBackendPostgres() {
+        trackingRepo, err := a.postgresTrackingRepository(ctx)
+        if err != nil {
+            return nil, nil, err
+        }
+        log.Println("Tracking data is stored in PostgreSQL")
+        return trackingRepo, nil, nil
+    }
+
+    var trackingPartitions *repositories.TrackingPartitions
+    trackingRepo := repositories.NewMongoTackingRepository(a.db.Database("tracking"))
+    if a.cfg.TrackingPartitioningEnabled() {
+        trackingPartitions = repositories.NewTrackingPartitions(a.db.Database("tracking"))
+        trackingRepo = repositories.NewPartitionedMongoTackingRepository(a.db.Database("tracking"), trackingPartitions)
+    }
+    if err := trackingRepo.CreatePublicIDIndexes(ctx); err != nil {
+        return nil, nil, err
+    }
+    if err := trackingRepo.CreateGeohashIndexes(ctx); err != nil {
+        return nil, nil, err
+    }
+    if err := trackingRepo.CreateDriverIndexes(ctx); err != nil {
+        return nil, nil, err
+    }
+    if err := trackingRepo.CreateSensorIndexes(ctx); err != nil {
+        return nil, nil, err
+    }
+    if a.cfg.MultiTenancyEnabled() {
+        if err := trackingRepo.CreateTenantIndexes(ctx); err != nil {
+            return nil, nil, err
+        }
+    }
+    return trackingRepo, trackingPartitions, nil
+}
+
+// trackingStatsRepository returns the repository aggregating the tracking data of the stored repository, with the
+// pipelines of MongoDB or by streaming the tracking data of the other backends
+func (a *App) trackingStatsRepository(
+    storedRepo repositories.TrackingRepository,
+    partitions *repositories.TrackingPartitions,
+) repositories.TrackingStatsRepository {
+    if _, ok := storedRepo.(*repositories.MongoTackingRepository); !ok {
+        return repositories.NewStreamingTrackingStatsRepository(storedRepo)
+    }
+    if partitions != nil {
+        return repositories.NewPartitionedMongoTrackingStatsRepository(a.db.Database("tracking"), partitions)
+    }
+    return repositories.NewMongoTrackingStatsRepository(a.db.Database("tracking"))
+}
+
+// newBroker connects to RABBITMQ_URL, over TLS with the RABBITMQ_TLS files when they are set
+func (a *App) newBroker() (broker, error) {
+    files := a.cfg.RabbitmqTLSFiles()
+    if !files.Configured() {
+        return common.NewRabbitConnection(a.cfg.RabbitmqUrl), nil
+    }
+    tlsConfig, err := tlsconfig.New(files)
+    if err != nil {
+        return nil, err
+    }
+    return &tlsBroker{url: a.cfg.RabbitmqUrl, tlsConfig: tlsConfig}, nil
+}
+
+// tlsBroker is a RabbitMQ connection over TLS, it reconnects when the connection was closed
+type tlsBroker struct {
+    url       string
+    tlsConfig *tls.Config
+
+    mu   sync.Mutex
+    conn *amqp.Connection
+}
+
+func (b *tlsBroker) Channel() (*amqp.Channel, error) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    if b.conn == nil || b.conn.IsClosed() {
+        conn, err := amqp.DialTLS(b.url, b.tlsConfig)
+        if err != nil {
+            return nil, err
+        }
+        b.conn = conn
+    }
+    return b.conn.Channel()
+}
+
+func (b *tlsBroker) Close() error {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    if b.conn == nil || b.conn.IsClosed() {
+        return nil
+    }
+    return b.conn.Close()
+}
@@ -0,0 +1,17 @@
+package app
+
+import (
+    "database/sql"
+    "slices"
+    "testing"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/config"
+)
+
+func TestPostgresDriverRegistered(t *testing.T) {
+    // STORAGE_BACKEND=postgres works with the default driver in every build of the service
+    driver := (&config.EnvConfig{}).PostgresDriverName()
+    if !slices.Contains(sql.Drivers(), driver) {
+        t.Errorf("expected the %s driver to be registered, got %v", driver, sql.Drivers())
+    }
+}
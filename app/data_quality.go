@@ -0,0 +1,44 @@
+package app
+
+import (
+    "context"
+    "fmt"
+    "log"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+// checkDataQuality wraps the tracking service to check the readings against the state of their vehicle when
+// DATA_QUALITY is set, the readings failing a check are flagged suspect or quarantined for a review. The quarantine
+// is nil unless DATA_QUALITY is quarantine.
+func (a *App) checkDataQuality(
+    ctx context.Context,
+    trackingService services.TrackingService,
+    stateRepo repositories.VehicleStateRepository,
+) (services.TrackingService, *repositories.MongoQuarantineRepository, error) {
+    mode := a.cfg.DataQualityMode()
+    if mode == "" {
+        return trackingService, nil, nil
+    }
+    rules := services.DataQualityRules{
+        Checks:      a.cfg.DataQualityCheckList(),
+        MaxSpeedKmh: a.cfg.DataQualityMaxSpeedValue(),
+    }
+    if err := rules.Validate(); err != nil {
+        return nil, nil, fmt.Errorf("DATA_QUALITY_CHECKS: %w", err)
+    }
+
+    var quarantineRepo *repositories.MongoQuarantineRepository
+    // a nil pointer in the interface would be taken for a quarantine
+    var quarantine repositories.QuarantineRepository
+    if mode == "quarantine" {
+        quarantineRepo = repositories.NewMongoQuarantineRepository(a.db.Database("tracking"))
+        if err := quarantineRepo.CreateIndexes(ctx); err != nil {
+            return nil, nil, err
+        }
+        quarantine = quarantineRepo
+    }
+    log.Println("Checking the data quality of the readings, failing readings are handled with: ", mode)
+    return services.NewDataQualityTrackingService(trackingService, stateRepo, quarantine, rules), quarantineRepo, nil
+}
@@ -0,0 +1,40 @@
+package app
+
+import (
+    "net/http"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+// deprecations are the deprecated features of the service, remove them from the routes and from here once their
+// sunset passed and nobody uses them anymore
+func deprecations() []*services.Deprecation {
+    return []*services.Deprecation{
+        {
+            // sort_by takes the order of each field with a "-" or "+" prefix
+            Feature:      "tracking-data-sort-order",
+            Method:       http.MethodGet,
+            Path:         "/api/v1/tracking-data",
+            Param:        "sort_order",
+            DeprecatedAt: time.Date(2026, time.October, 16, 0, 0, 0, 0, time.UTC),
+            Sunset:       time.Date(2027, time.April, 16, 0, 0, 0, 0, time.UTC),
+        },
+        // the tracking data routes of API v2 replace those of v1
+        v2Replacement("tracking-data-find-v1", http.MethodGet, "/api/v1/tracking-data"),
+        v2Replacement("tracking-data-create-v1", http.MethodPost, "/api/v1/tracking-data"),
+        v2Replacement("tracking-data-by-id-v1", http.MethodGet, "/api/v1/tracking-data/{id}"),
+        v2Replacement("vehicle-tracking-data-v1", http.MethodGet, "/api/v1/vehicles/{vehicleID}/tracking-data"),
+    }
+}
+
+// v2Replacement deprecates the v1 route replaced by the route of API v2 with the same path
+func v2Replacement(feature, method, path string) *services.Deprecation {
+    return &services.Deprecation{
+        Feature:      feature,
+        Method:       method,
+        Path:         path,
+        DeprecatedAt: time.Date(2026, time.October, 16, 0, 0, 0, 0, time.UTC),
+        Sunset:       time.Date(2027, time.October, 16, 0, 0, 0, 0, time.UTC),
+    }
+}
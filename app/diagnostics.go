@@ -0,0 +1,140 @@
+package app
+
+import (
+    "context"
+    "fmt"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/cache"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/metrics"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// Diagnostics is the bundle collected by Diagnose
+type Diagnostics = services.Diagnostics
+
+// Diagnose connects to the dependencies and collects the diagnostics bundle without starting the service,
+// for the diagnostics command. The ingest metrics are only known by the running service, use the
+// /api/v1/diagnostics endpoint to include them.
+func (a *App) Diagnose(ctx context.Context) (*Diagnostics, error) {
+    if a.cfg == nil {
+        return nil, ErrConfigMissing
+    }
+    defer a.disconnect(ctx)
+
+    mongoOptions, err := a.mongoOptions()
+    if err != nil {
+        return nil, err
+    }
+    a.db, err = mongo.Connect(ctx, mongoOptions)
+    if err != nil {
+        return nil, err
+    }
+    if a.rabbitConn == nil {
+        if a.rabbitConn, err = a.newBroker(); err != nil {
+            return nil, err
+        }
+        a.ownsBroker = true
+    }
+    if a.cfg.RedisURL != "" {
+        if a.redis, err = cache.NewRedisClient(a.cfg.RedisURL); err != nil {
+            return nil, err
+        }
+    }
+
+    return a.diagnosticsService(nil).Diagnostics(ctx), nil
+}
+
+// diagnosticsService creates the diagnostics service of the connected dependencies, recorder is nil outside of Run
+func (a *App) diagnosticsService(recorder *metrics.IngestRecorder) services.DiagnosticsService {
+    return services.NewMongoDiagnosticsService(
+        a.cfg.Redacted(),
+        repositories.NewMongoIngestionErrorRepository(a.db.Database("tracking")),
+        recorder,
+        a.healthChecks(),
+    )
+}
+
+// healthChecks checks MongoDB, the depth of the RabbitMQ queues, Redis when the cache is enabled and the ingest
+// buffer and ClickHouse when they are
+func (a *App) healthChecks() []services.HealthCheck {
+    checks := []services.HealthCheck{
+        {
+            Name: "mongodb",
+            Run: func(ctx context.Context) (map[string]any, error) {
+                return nil, a.db.Ping(ctx, readpref.Primary())
+            },
+        },
+        {
+            Name: "rabbitmq",
+            Run:  a.inspectQueues,
+        },
+    }
+    if a.redis != nil {
+        checks = append(
+            checks, services.HealthCheck{
+                Name: "redis",
+                Run: func(ctx context.Context) (map[string]any, error) {
+                    return nil, a.redis.Ping(ctx)
+                },
+            },
+        )
+    }
+    if a.buffer != nil {
+        checks = append(checks, a.ingestBufferCheck())
+    }
+    if a.history != nil {
+        checks = append(
+            checks, services.HealthCheck{
+                Name: "clickhouse",
+                Run: func(ctx context.Context) (map[string]any, error) {
+                    return nil, a.history.Ping(ctx)
+                },
+            },
+        )
+    }
+    return checks
+}
+
+// inspectQueues returns the messages and consumers of the queues the service uses
+func (a *App) inspectQueues(context.Context) (map[string]any, error) {
+    queues := []string{
+        a.cfg.TrackingQueue,
+        a.cfg.VehicleQueue,
+        a.cfg.VehicleEventQueueName(),
+        a.cfg.AlertsQueue,
+        a.cfg.MaintenanceQueue,
+    }
+    if len(a.cfg.ReportPeriodList()) > 0 {
+        queues = append(queues, a.cfg.ReportQueue)
+    }
+    if a.cfg.InactivityPeriod() > 0 {
+        queues = append(queues, a.cfg.StatusSuggestionQueue)
+    }
+
+    details := map[string]any{}
+    for _, name := range queues {
+        messages, consumers, err := a.inspectQueue(name)
+        if err != nil {
+            return details, err
+        }
+        details[name] = map[string]int{"messages": messages, "consumers": consumers}
+    }
+    return details, nil
+}
+
+// inspectQueue returns the number of messages and consumers of a queue
+func (a *App) inspectQueue(name string) (messages int, consumers int, err error) {
+    // a failed passive declare closes the channel, so every queue is inspected on its own channel
+    channel, err := a.rabbitConn.Channel()
+    if err != nil {
+        return 0, 0, err
+    }
+    queue, err := channel.QueueDeclarePassive(name, true, false, false, false, nil)
+    if err != nil {
+        return 0, 0, fmt.Errorf("queue %s: %w", name, err)
+    }
+    return queue.Messages, queue.Consumers, channel.Close()
+}
@@ -0,0 +1,38 @@
+package app
+
+import (
+    "context"
+    "log"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+// digestService creates the service of the digests when DIGESTS is enabled and emails them through SMTP_HOST until
+// ctx is done. The alerts are counted when ALERT_RULES is enabled. It returns nil when the digests are disabled.
+func (a *App) digestService(
+    ctx context.Context,
+    statsRepo repositories.TrackingStatsRepository,
+) (*services.MongoDigestService, error) {
+    if !a.cfg.DigestsEnabled() {
+        return nil, nil
+    }
+    digestRepo := repositories.NewMongoDigestRepository(a.db.Database("tracking"))
+    if err := digestRepo.CreateIndexes(ctx); err != nil {
+        return nil, err
+    }
+    var alertRepo repositories.AlertRuleRepository
+    if a.cfg.AlertRulesEnabled() {
+        alertRepo = repositories.NewMongoAlertRuleRepository(a.db.Database("tracking"))
+    }
+    digestService := services.NewMongoDigestService(
+        digestRepo,
+        statsRepo,
+        alertRepo,
+        a.smtpSender(),
+        a.cfg.NotificationTimeoutDuration(),
+    )
+    go services.NewDigestScheduler(digestService, a.cfg.ReportDelayDuration()).Run(ctx)
+    log.Println("Digests enabled")
+    return digestService, nil
+}
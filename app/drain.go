@@ -0,0 +1,45 @@
+package app
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "os"
+)
+
+// consumerTag identifies the consumer of this instance, so it can be cancelled on shutdown
+func consumerTag() string {
+    host, err := os.Hostname()
+    if err != nil {
+        host = "unknown"
+    }
+    return fmt.Sprintf("tracking-svc-%s-%d", host, os.Getpid())
+}
+
+// drain stops the consumer on shutdown without losing messages: the broker stops delivering once the consumer is
+// cancelled and the in-flight messages are waited for up to SHUTDOWN_TIMEOUT to be acked or nacked, the channel is
+// closed after. Messages still unacknowledged when the timeout expires are redelivered by the broker.
+func (a *App) drain(ctx context.Context) {
+    if a.consumer == nil {
+        return
+    }
+
+    ctx, cancel := context.WithTimeout(ctx, a.cfg.ShutdownTimeoutDuration())
+    defer cancel()
+    drained := make(chan struct{})
+    go func() {
+        defer close(drained)
+        // a paused consumer is only kept from resuming
+        if err := a.consumer.Stop(ctx); err != nil {
+            log.Println("Failed to cancel the tracking data consumer", err)
+        }
+        a.inflight.Wait()
+    }()
+
+    select {
+    case <-drained:
+        log.Println("Drained the tracking data consumer")
+    case <-ctx.Done():
+        log.Println("Timed out draining the tracking data consumer, unacknowledged messages will be redelivered")
+    }
+}
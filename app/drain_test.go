@@ -0,0 +1,185 @@
+package app
+
+import (
+    "context"
+    "errors"
+    "sync"
+    "testing"
+    "time"
+
+    amqp "github.com/rabbitmq/amqp091-go"
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/config"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// drainChannel delivers the messages sent to deliveries until the consumer is cancelled
+type drainChannel struct {
+    deliveries chan amqp.Delivery
+    cancelled  chan struct{}
+}
+
+func (c *drainChannel) Consume(string, string, bool, bool, bool, bool, amqp.Table) (<-chan amqp.Delivery, error) {
+    return c.deliveries, nil
+}
+
+func (c *drainChannel) Cancel(string, bool) error {
+    close(c.cancelled)
+    close(c.deliveries)
+    return nil
+}
+
+// drainAcknowledger counts the acknowledged messages
+type drainAcknowledger struct {
+    mu    sync.Mutex
+    acks  int
+    nacks int
+}
+
+func (a *drainAcknowledger) Ack(uint64, bool) error {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    a.acks++
+    return nil
+}
+
+func (a *drainAcknowledger) Nack(uint64, bool, bool) error {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    a.nacks++
+    return nil
+}
+
+func (a *drainAcknowledger) Reject(tag uint64, requeue bool) error {
+    return a.Nack(tag, false, requeue)
+}
+
+func (a *drainAcknowledger) count() (int, int) {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    return a.acks, a.nacks
+}
+
+// blockingTrackingService stores a reading once it is released
+type blockingTrackingService struct {
+    services.TrackingService
+    started chan struct{}
+    release chan struct{}
+}
+
+func (s *blockingTrackingService) TrackVehicle(
+    ctx context.Context,
+    req *services.TrackingDataRequest,
+) (*repositories.TrackingRecord, error) {
+    s.started <- struct{}{}
+    <-s.release
+    return repositories.NewTrackingRecord(
+        &models.TrackingData{ID: primitive.NewObjectID(), VehicleID: primitive.NewObjectID()},
+    ), nil
+}
+
+type drainPublisher chan []byte
+
+func (p drainPublisher) Publish(ctx context.Context, body []byte) error {
+    p <- body
+    return nil
+}
+
+// startDrainConsumer consumes a message with the app until the tracking service is released
+func startDrainConsumer(
+    t *testing.T,
+    shutdownTimeout string,
+) (*App, *drainChannel, *drainAcknowledger, *blockingTrackingService, drainPublisher) {
+    a := &App{cfg: &config.EnvConfig{ShutdownTimeout: shutdownTimeout}, workers: newWorkerLimit()}
+    channel := &drainChannel{deliveries: make(chan amqp.Delivery, 1), cancelled: make(chan struct{})}
+    trackingService := &blockingTrackingService{started: make(chan struct{}, 1), release: make(chan struct{})}
+    publisher := make(drainPublisher, 1)
+    a.consumer = services.NewRabbitConsumer(
+        channel,
+        "tracking",
+        "tracking-svc-test",
+        func(deliveries <-chan amqp.Delivery) {
+            a.Consume(context.Background(), publisher, deliveries, trackingService, nil)
+        },
+    )
+    if err := a.consumer.Start(); err != nil {
+        t.Fatal(err)
+    }
+
+    acknowledger := &drainAcknowledger{}
+    channel.deliveries <- amqp.Delivery{
+        Acknowledger: acknowledger,
+        DeliveryTag:  1,
+        Body: []byte(
+            `{"vehicle_id":"6650c3e0f1a2b3c4d5e6f7a8","location":"Yangon","mileage":1200,"status":"active",` +
+                `"fuel_condition":"full"}`,
+        ),
+    }
+    select {
+    case <-trackingService.started:
+    case <-time.After(time.Second):
+        t.Fatal("expected the message to be consumed")
+    }
+    return a, channel, acknowledger, trackingService, publisher
+}
+
+func TestApp_Drain(t *testing.T) {
+    a, channel, acknowledger, trackingService, publisher := startDrainConsumer(t, "5s")
+
+    drained := make(chan struct{})
+    go func() {
+        defer close(drained)
+        a.drain(context.Background())
+    }()
+
+    select {
+    case <-channel.cancelled:
+    case <-time.After(time.Second):
+        t.Fatal("expected the consumer to be cancelled")
+    }
+    if _, err := a.consumer.Resume(context.Background()); !errors.Is(err, services.ErrConsumerStopped) {
+        t.Errorf("expected the drained consumer not to resume, got %v", err)
+    }
+    select {
+    case <-drained:
+        t.Fatal("expected the drain to wait for the in-flight message")
+    case <-time.After(50 * time.Millisecond):
+    }
+
+    close(trackingService.release)
+    select {
+    case <-drained:
+    case <-time.After(time.Second):
+        t.Fatal("expected the drain to finish with the in-flight message")
+    }
+    // the message was acknowledged and its result published before the drain returned
+    if acks, nacks := acknowledger.count(); acks != 1 || nacks != 0 {
+        t.Errorf("expected the in-flight message to be acknowledged, got %d acks and %d nacks", acks, nacks)
+    }
+    if len(publisher) != 1 {
+        t.Errorf("expected the result of the in-flight message to be published")
+    }
+}
+
+func TestApp_Drain_Timeout(t *testing.T) {
+    a, channel, acknowledger, trackingService, _ := startDrainConsumer(t, "50ms")
+    defer close(trackingService.release)
+
+    start := time.Now()
+    a.drain(context.Background())
+
+    select {
+    case <-channel.cancelled:
+    default:
+        t.Error("expected the consumer to be cancelled")
+    }
+    if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > time.Second {
+        t.Errorf("expected the drain to give up after SHUTDOWN_TIMEOUT, took %v", elapsed)
+    }
+    // the broker redelivers the message left unacknowledged
+    if acks, nacks := acknowledger.count(); acks != 0 || nacks != 0 {
+        t.Errorf("expected the in-flight message to be left unacknowledged, got %d acks and %d nacks", acks, nacks)
+    }
+}
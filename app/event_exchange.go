@@ -0,0 +1,45 @@
+package app
+
+import (
+    amqp "github.com/rabbitmq/amqp091-go"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/event"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+// eventPublisher makes the publisher publish to the topic exchange of EVENT_EXCHANGE with the routing key
+// tracking.{vehicle_id}.{event_type}. The queue is declared and bound to the event types, so its consumers keep
+// receiving them while other services bind queues of their own.
+func (a *App) eventPublisher(
+    channel *amqp.Channel,
+    publisher *services.RabbitPublisher,
+    queue string,
+    routingKey services.RoutingKey,
+    eventTypes ...string,
+) (*services.RabbitPublisher, error) {
+    exchange := a.cfg.EventExchangeName()
+    // Declare the event exchange and the queue with durable
+    if err := channel.ExchangeDeclare(exchange, amqp.ExchangeTopic, true, false, false, false, nil); err != nil {
+        return nil, err
+    }
+    if _, err := channel.QueueDeclare(queue, true, false, false, false, nil); err != nil {
+        return nil, err
+    }
+    for _, eventType := range eventTypes {
+        if err := channel.QueueBind(queue, services.TrackingBindingKey(eventType), exchange, false, nil); err != nil {
+            return nil, err
+        }
+    }
+    return publisher.WithExchange(exchange, routingKey), nil
+}
+
+// vehiclePublisher creates the publisher of the legacy format of the stored tracking data, VEHICLE_QUEUE is bound to
+// the vehicle updates
+func (a *App) vehiclePublisher(channel *amqp.Channel) (*services.RabbitPublisher, error) {
+    return a.eventPublisher(
+        channel,
+        a.newPublisher(channel, a.cfg.VehicleQueue),
+        a.cfg.VehicleQueue,
+        services.TrackingRoutingKey(event.TypeVehicleUpdated),
+        event.TypeVehicleUpdated,
+    )
+}
@@ -0,0 +1,22 @@
+package app
+
+import (
+    "log"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/geo"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+// registerGeocoding registers the processor filling in the location of the readings from their coordinates when
+// GEOCODING_PROVIDER is set
+func (a *App) registerGeocoding() error {
+    geocoder, err := geo.NewReverseGeocoder(a.cfg.GeocodingProvider, a.cfg.GeocodingURL, a.cfg.GeocodingAPIKey)
+    if err != nil || geocoder == nil {
+        return err
+    }
+    log.Println("Reverse geocoding enabled using provider: ", a.cfg.GeocodingProvider)
+    a.processors.Register(
+        services.NewGeocodingProcessor(geocoder, a.cfg.GeocodingCacheTTLDuration(), a.cfg.GeocodingRateValue()),
+    )
+    return nil
+}
@@ -0,0 +1,45 @@
+package app
+
+import (
+    "log"
+    "net/http"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/cdn"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/handler"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+)
+
+// cdnPurgeTimeout bounds the requests to the CDN purge endpoint, the correction is stored either way
+const cdnPurgeTimeout = 5 * time.Second
+
+func (a *App) historicalCachePolicy() cdn.Policy {
+    return cdn.Policy{MaxAge: a.cfg.HistoricalCacheMaxAgeDuration(), Settle: a.cfg.HistoricalCacheSettleDuration()}
+}
+
+// historicalCache wraps the tracking data queries with the caching headers of immutable historical queries,
+// the handlers are returned as they are when HISTORICAL_CACHE_MAX_AGE isn't set
+func (a *App) historicalCache() func(http.HandlerFunc) http.HandlerFunc {
+    policy := a.historicalCachePolicy()
+    if policy.MaxAge <= 0 {
+        return func(h http.HandlerFunc) http.HandlerFunc {
+            return h
+        }
+    }
+    log.Println("Historical queries cacheable for: ", policy.MaxAge)
+    return handler.HistoricalCache(policy)
+}
+
+// applyPurging purges the cached historical responses on corrections when CDN_PURGE_URL is set
+func (a *App) applyPurging(trackingRepo repositories.TrackingRepository) repositories.TrackingRepository {
+    policy := a.historicalCachePolicy()
+    if policy.MaxAge <= 0 || a.cfg.CDNPurgeURL == "" {
+        return trackingRepo
+    }
+    log.Println("Cached historical responses purged by: ", a.cfg.CDNPurgeURL)
+    return repositories.NewPurgingTrackingRepository(
+        trackingRepo,
+        cdn.NewHTTPPurger(&http.Client{Timeout: cdnPurgeTimeout}, a.cfg.CDNPurgeURL, a.cfg.CDNPurgeToken),
+        policy,
+    )
+}
@@ -0,0 +1,56 @@
+package app
+
+import (
+    "context"
+    "log"
+    "net/http"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+)
+
+// clickHouseTimeout bounds the requests to ClickHouse, the analytical queries over years of history included
+const clickHouseTimeout = time.Minute
+
+// applyHistory mirrors the tracking data into ClickHouse when CLICKHOUSE_URL is set, the mirror runs until ctx is
+// done. MongoDB stays the store of the tracking data, ClickHouse only answers the history API.
+func (a *App) applyHistory(
+    ctx context.Context,
+    trackingRepo repositories.TrackingRepository,
+) (repositories.TrackingRepository, error) {
+    if !a.cfg.ClickHouseEnabled() {
+        return trackingRepo, nil
+    }
+    history, err := repositories.NewClickHouseHistoryRepository(
+        &http.Client{Timeout: clickHouseTimeout},
+        a.cfg.ClickHouseURL,
+    )
+    if err != nil {
+        return nil, err
+    }
+    if err = history.CreateSchema(ctx); err != nil {
+        return nil, err
+    }
+    a.history = history
+    a.mirror = repositories.NewMirroringTrackingRepository(
+        trackingRepo,
+        history,
+        a.cfg.ClickHouseBatchSizeValue(),
+        a.cfg.ClickHouseFlushIntervalDuration(),
+    )
+    go a.mirror.Run(ctx)
+    log.Println("Tracking data mirrored into ClickHouse every: ", a.cfg.ClickHouseFlushIntervalDuration())
+    return a.mirror, nil
+}
+
+// waitMirror waits for the mirror to store the writes still queued once the background workers are stopped
+func (a *App) waitMirror(ctx context.Context) {
+    if a.mirror == nil {
+        return
+    }
+    select {
+    case <-a.mirror.Done():
+    case <-ctx.Done():
+        log.Println("Stopped waiting for the ClickHouse mirror", ctx.Err())
+    }
+}
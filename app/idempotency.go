@@ -0,0 +1,23 @@
+package app
+
+import (
+    "context"
+    "log"
+    "net/http"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/handler"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+// idempotent wraps the ingestion endpoints to answer the retries of the requests with an Idempotency-Key with the
+// response of the first request, kept for IDEMPOTENCY_KEY_TTL
+func (a *App) idempotent(ctx context.Context) (func(http.HandlerFunc) http.HandlerFunc, error) {
+    idempotencyRepo := repositories.NewMongoIdempotencyRepository(a.db.Database("tracking"))
+    if err := idempotencyRepo.CreateIndexes(ctx); err != nil {
+        return nil, err
+    }
+    ttl := a.cfg.IdempotencyKeyTTLDuration()
+    log.Println("Responses of the requests with an Idempotency-Key kept for: ", ttl)
+    return handler.Idempotency(services.NewMongoIdempotencyService(idempotencyRepo, ttl)), nil
+}
@@ -0,0 +1,27 @@
+package app
+
+import (
+    "context"
+    "log"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+// idleService creates the service of the idle segments when IDLE_DETECTION is enabled and registers the processor
+// recording them. It returns nil when the detection is disabled.
+func (a *App) idleService(
+    ctx context.Context,
+    accessService services.AccessService,
+) (*services.MongoIdleService, error) {
+    if !a.cfg.IdleDetectionEnabled() {
+        return nil, nil
+    }
+    segmentRepo := repositories.NewMongoIdleSegmentRepository(a.db.Database("tracking"))
+    if err := segmentRepo.CreateIndexes(ctx); err != nil {
+        return nil, err
+    }
+    a.processors.Register(services.NewIdleDetector(segmentRepo, a.cfg.IdleMinDurationValue()))
+    log.Println("Idle detection enabled with minimum duration: ", a.cfg.IdleMinDurationValue())
+    return services.NewMongoIdleService(segmentRepo, accessService, a.cfg.IdleFuelRateValue()), nil
+}
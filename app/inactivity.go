@@ -0,0 +1,42 @@
+package app
+
+import (
+    "context"
+    "log"
+
+    amqp "github.com/rabbitmq/amqp091-go"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+// inactivityService creates the service of the status suggestions. When INACTIVITY_DAYS is set, the suggestions are
+// published to the event exchange with the suggestion queue bound to them and inactive vehicles are looked for every
+// INACTIVITY_CHECK_INTERVAL until ctx is done.
+func (a *App) inactivityService(ctx context.Context, channel *amqp.Channel) (*services.MongoInactivityService, error) {
+    period := a.cfg.InactivityPeriod()
+    publisher := a.newPublisher(channel, a.cfg.StatusSuggestionQueue)
+    if period > 0 {
+        var err error
+        publisher, err = a.eventPublisher(
+            channel,
+            publisher,
+            a.cfg.StatusSuggestionQueue,
+            services.EventRoutingKey,
+            services.StatusSuggestedEvent,
+        )
+        if err != nil {
+            return nil, err
+        }
+    }
+    inactivityService := services.NewMongoInactivityService(
+        repositories.NewMongoVehicleActivityRepository(a.db.Database("tracking")),
+        publisher,
+        period,
+    )
+    if period <= 0 {
+        return inactivityService, nil
+    }
+    go services.NewInactivityScheduler(inactivityService, a.cfg.InactivityCheckIntervalDuration()).Run(ctx)
+    log.Println("Suggesting inactive vehicles after days without movement: ", a.cfg.InactivityDays)
+    return inactivityService, nil
+}
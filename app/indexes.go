@@ -0,0 +1,109 @@
+package app
+
+import (
+    "context"
+    "errors"
+    "log"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "go.mongodb.org/mongo-driver/mongo"
+)
+
+var ErrGeohashStorage = errors.New("geohashes are only stored by the MongoDB storage backend")
+
+// indexedRepository is a repository creating the indexes of its collections
+type indexedRepository interface {
+    CreateIndexes(ctx context.Context) error
+}
+
+// MigrateIndexes creates the indexes of the tracking data and of the enabled features without starting the service,
+// for the migrate-indexes command. The service creates them on startup too, creating them before a deployment
+// keeps the index builds of large collections out of the startup.
+func (a *App) MigrateIndexes(ctx context.Context) error {
+    if a.cfg == nil {
+        return ErrConfigMissing
+    }
+    if err := a.cfg.Validate(); err != nil {
+        return err
+    }
+    defer a.disconnect(ctx)
+    if err := a.connectMongo(ctx); err != nil {
+        return err
+    }
+
+    if _, _, err := a.storedTrackingRepository(ctx); err != nil {
+        return err
+    }
+    db := a.db.Database("tracking")
+    repos := []indexedRepository{
+        repositories.NewMongoVehicleStateRepository(db),
+        repositories.NewMongoDriverAssignmentRepository(db),
+        repositories.NewMongoIdempotencyRepository(db),
+    }
+    if a.cfg.TrackingRollupsEnabled() {
+        repos = append(repos, repositories.NewMongoTrackingRollupRepository(db))
+    }
+    if a.cfg.WebhooksEnabled() {
+        repos = append(repos, repositories.NewMongoWebhookRepository(db))
+    }
+    if a.cfg.GeofenceAlertsEnabled() || a.cfg.SpeedLimitKmhValue() > 0 {
+        repos = append(repos, repositories.NewMongoVehicleMotionRepository(db))
+    }
+    if a.cfg.SafetyScoringEnabled() {
+        repos = append(repos, repositories.NewMongoSafetyScoreRepository(db))
+    }
+    if a.cfg.IdleDetectionEnabled() {
+        repos = append(repos, repositories.NewMongoIdleSegmentRepository(db))
+    }
+    if a.cfg.AlertRulesEnabled() {
+        repos = append(repos, repositories.NewMongoAlertRuleRepository(db))
+    }
+    if a.cfg.DigestsEnabled() {
+        repos = append(repos, repositories.NewMongoDigestRepository(db))
+    }
+    if a.cfg.DataQualityMode() == "quarantine" {
+        repos = append(repos, repositories.NewMongoQuarantineRepository(db))
+    }
+    for _, repo := range repos {
+        if err := repo.CreateIndexes(ctx); err != nil {
+            return err
+        }
+    }
+    log.Println("Indexes created")
+    return nil
+}
+
+// BackfillGeohashes assigns a geohash to the tracking data stored before geohashes were added without starting the
+// service, for the backfill-geohashes command. It returns the number of updated tracking data.
+func (a *App) BackfillGeohashes(ctx context.Context) (int64, error) {
+    if a.cfg == nil {
+        return 0, ErrConfigMissing
+    }
+    if err := a.cfg.Validate(); err != nil {
+        return 0, err
+    }
+    defer a.disconnect(ctx)
+    if err := a.connectMongo(ctx); err != nil {
+        return 0, err
+    }
+
+    trackingRepo, _, err := a.storedTrackingRepository(ctx)
+    if err != nil {
+        return 0, err
+    }
+    mongoRepo, ok := trackingRepo.(*repositories.MongoTackingRepository)
+    if !ok {
+        return 0, ErrGeohashStorage
+    }
+    return mongoRepo.BackfillGeohashes(ctx)
+}
+
+// connectMongo connects to DATABASE_URL, for the commands running without the service
+func (a *App) connectMongo(ctx context.Context) error {
+    mongoOptions, err := a.mongoOptions()
+    if err != nil {
+        return err
+    }
+    a.db, err = mongo.Connect(ctx, mongoOptions)
+    return err
+}
@@ -0,0 +1,52 @@
+package app
+
+import (
+    "context"
+    "log"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+// applyIngestBuffer coalesces the readings stored one at a time into batches when INGEST_BUFFER_SIZE is set
+func (a *App) applyIngestBuffer(trackingRepo repositories.TrackingRepository) repositories.TrackingRepository {
+    size := a.cfg.IngestBufferSizeValue()
+    if size <= 1 {
+        return trackingRepo
+    }
+    a.buffer = repositories.NewBufferedTrackingRepository(
+        trackingRepo,
+        size,
+        a.cfg.IngestBufferMaxWaitDuration(),
+        a.cfg.MongoTimeoutDuration(),
+    )
+    log.Printf("Readings are written in batches of up to %d within %s", size, a.cfg.IngestBufferMaxWaitDuration())
+    return a.buffer
+}
+
+// flushIngestBuffer writes the buffered readings on shutdown, so the in-flight messages are acknowledged without
+// waiting for their batch
+func (a *App) flushIngestBuffer() {
+    if a.buffer == nil {
+        return
+    }
+    depth := a.buffer.Stats().Depth
+    a.buffer.Close()
+    log.Printf("Flushed %d buffered readings", depth)
+}
+
+// ingestBufferCheck reports the metrics of the ingest buffer in the diagnostics
+func (a *App) ingestBufferCheck() services.HealthCheck {
+    return services.HealthCheck{
+        Name: "ingest_buffer",
+        Run: func(context.Context) (map[string]any, error) {
+            stats := a.buffer.Stats()
+            return map[string]any{
+                "depth":   stats.Depth,
+                "flushes": stats.Flushes,
+                "flushed": stats.Flushed,
+                "failed":  stats.Failed,
+            }, nil
+        },
+    }
+}
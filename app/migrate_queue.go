@@ -0,0 +1,92 @@
+package app
+
+import (
+    "context"
+    "log"
+    "slices"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/migration"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/plan"
+)
+
+// MigrateOptions are the options of MigrateQueue
+type MigrateOptions = migration.Options
+
+// MigrateProgress is the progress of MigrateQueue
+type MigrateProgress = migration.Progress
+
+// MigrateQueue drains a queue into an exchange without starting the service, for the migrate-queue command.
+// Embedding services can pass their own transforms in the options.
+func (a *App) MigrateQueue(ctx context.Context, opts MigrateOptions) (MigrateProgress, error) {
+    var progress MigrateProgress
+    err := a.withMigrationBroker(ctx, func(broker migration.Broker) (err error) {
+        progress, err = migration.Migrate(ctx, broker, opts)
+        return err
+    })
+    return progress, err
+}
+
+// PlanQueueMigration returns what MigrateQueue would do with the options without migrating anything, the sampled
+// messages are requeued
+func (a *App) PlanQueueMigration(ctx context.Context, opts MigrateOptions) (*plan.Plan, error) {
+    var p *plan.Plan
+    err := a.withMigrationBroker(ctx, func(broker migration.Broker) (err error) {
+        p, err = migration.DryRun(ctx, broker, opts)
+        return err
+    })
+    return p, err
+}
+
+// ReplayDeadLetters republishes the messages of a dead-letter queue to the tracking queue without starting the
+// service, for the replay command. The transforms of the options fix the messages before the replay metadata is added.
+func (a *App) ReplayDeadLetters(ctx context.Context, opts MigrateOptions) (MigrateProgress, error) {
+    if a.cfg == nil {
+        return MigrateProgress{}, ErrConfigMissing
+    }
+    return a.MigrateQueue(ctx, a.replayOptions(opts))
+}
+
+// PlanDeadLetterReplay returns what ReplayDeadLetters would do with the options without replaying anything
+func (a *App) PlanDeadLetterReplay(ctx context.Context, opts MigrateOptions) (*plan.Plan, error) {
+    if a.cfg == nil {
+        return nil, ErrConfigMissing
+    }
+    return a.PlanQueueMigration(ctx, a.replayOptions(opts))
+}
+
+// replayOptions publishes to the tracking queue through the default exchange with the replay metadata
+func (a *App) replayOptions(opts MigrateOptions) MigrateOptions {
+    opts.To, opts.RoutingKey = "", a.cfg.TrackingQueue
+    opts.Transforms = append(slices.Clip(opts.Transforms), migration.ReplayTransform(opts.From))
+    return opts
+}
+
+// withMigrationBroker runs fn with a broker on its own channel and disconnects afterward
+func (a *App) withMigrationBroker(ctx context.Context, fn func(broker migration.Broker) error) error {
+    if a.cfg == nil {
+        return ErrConfigMissing
+    }
+    defer a.disconnect(ctx)
+
+    if a.rabbitConn == nil {
+        var err error
+        if a.rabbitConn, err = a.newBroker(); err != nil {
+            return err
+        }
+        a.ownsBroker = true
+    }
+    channel, err := a.rabbitConn.Channel()
+    if err != nil {
+        return err
+    }
+    defer func() {
+        if err := channel.Close(); err != nil {
+            log.Println("Failed to close migration channel", err)
+        }
+    }()
+    broker, err := migration.NewChannelBroker(channel)
+    if err != nil {
+        return err
+    }
+    return fn(broker)
+}
@@ -0,0 +1,67 @@
+package app
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "net/http"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/notifier"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+// notificationDispatcher creates the dispatcher sending the alerts to the fleet operators when NOTIFICATIONS is
+// enabled, with the recipients of ALERT_NOTIFICATIONS, and sends the messages until ctx is done. Emails need
+// SMTP_HOST and text messages TWILIO_ACCOUNT_SID. It returns nil when the notifications are disabled.
+func (a *App) notificationDispatcher(
+    ctx context.Context,
+) (*services.NotificationDispatcher, []*repositories.AlertNotification, error) {
+    if !a.cfg.NotificationsEnabled() {
+        return nil, nil, nil
+    }
+    client := &http.Client{Timeout: a.cfg.NotificationTimeoutDuration()}
+    senders := map[string]notifier.Sender{notifier.ChannelSlack: notifier.NewSlackSender(client)}
+    if a.cfg.SMTPHost != "" {
+        senders[notifier.ChannelEmail] = a.smtpSender()
+    }
+    if a.cfg.TwilioAccountSID != "" {
+        senders[notifier.ChannelSMS] = notifier.NewTwilioSender(
+            client,
+            a.cfg.TwilioAccountSID,
+            a.cfg.TwilioAuthToken,
+            a.cfg.TwilioFrom,
+        )
+    }
+    dispatcher := services.NewNotificationDispatcher(
+        senders,
+        a.cfg.NotificationTimeoutDuration(),
+        a.cfg.NotificationRateLimitValue(),
+    )
+
+    var recipients []*repositories.AlertNotification
+    for _, value := range a.cfg.AlertNotificationList() {
+        recipient, err := services.ParseAlertNotification(value)
+        if err == nil {
+            err = dispatcher.Validate(recipient)
+        }
+        if err != nil {
+            return nil, nil, fmt.Errorf("ALERT_NOTIFICATIONS: %w", err)
+        }
+        recipients = append(recipients, recipient)
+    }
+    go dispatcher.Run(ctx)
+    log.Println("Notifications enabled, recipients of the alerts: ", len(recipients))
+    return dispatcher, recipients, nil
+}
+
+// smtpSender creates the sender of the emails through SMTP_HOST
+func (a *App) smtpSender() *notifier.SMTPSender {
+    return notifier.NewSMTPSender(
+        a.cfg.SMTPHost,
+        a.cfg.SMTPPortValue(),
+        a.cfg.SMTPUsername,
+        a.cfg.SMTPPassword,
+        a.cfg.SMTPFrom,
+    )
+}
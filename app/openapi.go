@@ -0,0 +1,824 @@
+package app
+
+import (
+    "net/http"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/geojson"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/handler"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/openapi"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/parquet"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+// openAPIDocument describes the API routes, the schemas are generated from the request, filter and response types
+// so they follow the code. Add a route here when adding it to the v1 or v2 router.
+func openAPIDocument(
+    deprecations []*services.Deprecation,
+    simulation bool,
+    history bool,
+    rollups bool,
+    stale bool,
+    webhooks bool,
+    scoring bool,
+    idling bool,
+    alerting bool,
+    digests bool,
+    quarantine bool,
+) *openapi.Document {
+    generator := openapi.NewGenerator(
+        openapi.Info{
+            Title:   "Tracking Service API",
+            Version: "v1",
+            Description: "Stores and queries vehicle tracking data. Requests are authenticated by the gateway, " +
+                "successful responses are wrapped in {\"data\": ..., \"message\": ...}, those of v2 in " +
+                "{\"data\": ..., \"page\": ...}.",
+        },
+    )
+    generator.Error(&handler.ErrorResponse{})
+    generator.Pagination(&handler.Pagination{})
+    generator.Add(
+        openapi.Route{
+            Method:   http.MethodGet,
+            Path:     "/api/v1/tracking-data",
+            Tag:      "tracking-data",
+            Summary:  "Find tracking data with filtering, sorting and pagination",
+            Query:    repositories.TrackingFilter{},
+            Response: []*repositories.TrackingRecord{},
+        },
+        openapi.Route{
+            Method:   http.MethodPost,
+            Path:     "/api/v1/tracking-data",
+            Tag:      "tracking-data",
+            Summary:  "Ingest a tracking data reading",
+            Params:   []*openapi.Parameter{idempotencyKeyParameter()},
+            Body:     services.TrackingDataRequest{},
+            Response: repositories.TrackingRecord{},
+            Status:   http.StatusCreated,
+        },
+        openapi.Route{
+            Method:   http.MethodDelete,
+            Path:     "/api/v1/tracking-data",
+            Tag:      "tracking-data",
+            Summary:  "Soft delete or purge the tracking data of a vehicle",
+            Query:    services.TrackingDeletionRequest{},
+            Response: repositories.DeletionAudit{},
+            Admin:    true,
+        },
+        openapi.Route{
+            Method:   http.MethodPost,
+            Path:     "/api/v1/tracking-data/batch",
+            Tag:      "tracking-data",
+            Summary:  "Ingest up to 1000 readings, the outcome is reported per reading",
+            Params:   []*openapi.Parameter{idempotencyKeyParameter()},
+            Body:     []*services.TrackingDataRequest{},
+            Response: handler.BatchResult{},
+        },
+        openapi.Route{
+            Method:   http.MethodPost,
+            Path:     "/api/v1/tracking-data/batch-query",
+            Tag:      "tracking-data",
+            Summary:  "Find the latest tracking data of up to 100 vehicles",
+            Body:     services.BatchQueryRequest{},
+            Response: []*services.VehicleTrackingData{},
+        },
+        openapi.Route{
+            Method:   http.MethodGet,
+            Path:     "/api/v1/tracking-data/deletions",
+            Tag:      "tracking-data",
+            Summary:  "Find the audit of the deleted tracking data",
+            Query:    repositories.DeletionAuditFilter{},
+            Response: []*repositories.DeletionAudit{},
+            Admin:    true,
+        },
+        openapi.Route{
+            Method:  http.MethodGet,
+            Path:    "/api/v1/tracking-data/export",
+            Tag:     "tracking-data",
+            Summary: "Download the tracking data matching the filters as a file",
+            Query:   repositories.TrackingFilter{},
+            Params: []*openapi.Parameter{
+                queryParameter("format", "csv (default), json, ndjson, parquet, geojson or gpx"),
+                queryParameter("compression", "gzip, zstd or none"),
+            },
+            Download: []string{
+                "text/csv",
+                "application/json",
+                "application/x-ndjson",
+                parquet.ContentType,
+                geojson.ContentType,
+                "application/gpx+xml",
+            },
+        },
+        openapi.Route{
+            Method:  http.MethodGet,
+            Path:    "/api/v1/tracking-data/poll",
+            Tag:     "tracking-data",
+            Summary: "Long-poll for new tracking data of up to 100 vehicles",
+            Params: []*openapi.Parameter{
+                requiredQueryParameter("vehicle_id", "Comma separated vehicle ids"),
+                queryParameter("since", "The cursor of the previous poll"),
+                queryParameter("wait", "How long to wait for new tracking data, e.g. 30s, at most 60s"),
+            },
+            Response: services.TrackingPoll{},
+        },
+        openapi.Route{
+            Method:  http.MethodGet,
+            Path:    "/api/v1/tracking-data/route",
+            Tag:     "tracking-data",
+            Summary: "The path of a vehicle ordered by time, optionally simplified",
+            Params: []*openapi.Parameter{
+                requiredQueryParameter("vehicle_id", "The vehicle id"),
+                queryParameter("from", "RFC3339 start of the path"),
+                queryParameter("to", "RFC3339 end of the path"),
+                queryParameter("max_points", "Simplify the path to at most this many points"),
+                queryParameter(
+                    "exclude",
+                    "Comma separated backfill, anomalies, suspect and skewed to leave out, or none",
+                ),
+                queryParameter("resolution", "raw, 5m or 1h to read wide routes from the rollups, auto by default"),
+            },
+            Response: services.Route{},
+        },
+        openapi.Route{
+            Method:   http.MethodGet,
+            Path:     "/api/v1/tracking-data/stats",
+            Tag:      "tracking-data",
+            Summary:  "Statistics per vehicle",
+            Query:    repositories.TrackingStatsFilter{},
+            Response: []*repositories.VehicleStats{},
+        },
+        openapi.Route{
+            Method:   http.MethodGet,
+            Path:     "/api/v1/tracking-data/heatmap",
+            Tag:      "tracking-data",
+            Summary:  "Count the readings and vehicles per map tile of a bbox at a zoom",
+            Query:    repositories.TrackingHeatmapFilter{},
+            Response: []*repositories.HeatmapTile{},
+        },
+        openapi.Route{
+            Method:   http.MethodGet,
+            Path:     "/api/v1/tracking-data/sla",
+            Tag:      "tracking-data",
+            Summary:  "Monthly connectivity per vehicle for the tracker uptime commitments",
+            Query:    repositories.ConnectivityFilter{},
+            Response: []*services.VehicleConnectivity{},
+        },
+        openapi.Route{
+            Method:   http.MethodGet,
+            Path:     "/api/v1/tracking-data/{id}",
+            Tag:      "tracking-data",
+            Summary:  "Find a tracking data by its id, e.g. one of a vehicle queue event",
+            Params:   []*openapi.Parameter{pathParameter("id", "ObjectID or ULID public id of the tracking data")},
+            Response: repositories.TrackingRecord{},
+        },
+        openapi.Route{
+            Method:   http.MethodGet,
+            Path:     "/api/v1/vehicles/state",
+            Tag:      "tracking-data",
+            Summary:  "Find the current state of the vehicles from their latest readings",
+            Query:    repositories.VehicleStateFilter{},
+            Response: []*repositories.VehicleState{},
+        },
+        openapi.Route{
+            Method:   http.MethodGet,
+            Path:     "/api/v1/vehicles/{vehicleID}/tracking-data",
+            Tag:      "tracking-data",
+            Summary:  "Find the tracking data of a vehicle with filtering, sorting and pagination",
+            Query:    repositories.TrackingFilter{},
+            Params:   []*openapi.Parameter{pathParameter("vehicleID", "ObjectID of the vehicle")},
+            Response: []*repositories.TrackingRecord{},
+        },
+        openapi.Route{
+            Method:   http.MethodGet,
+            Path:     "/api/v1/drivers/{driverID}/tracking-data",
+            Tag:      "tracking-data",
+            Summary:  "Find the tracking data attributed to a driver with filtering, sorting and pagination",
+            Query:    repositories.TrackingFilter{},
+            Params:   []*openapi.Parameter{pathParameter("driverID", "Id of the driver")},
+            Response: []*repositories.TrackingRecord{},
+        },
+        openapi.Route{
+            Method:   http.MethodGet,
+            Path:     "/api/v1/geofences/export",
+            Tag:      "geofences",
+            Summary:  "Download all geofences as a GeoJSON feature collection",
+            Download: []string{geojson.ContentType},
+        },
+        openapi.Route{
+            Method:  http.MethodPost,
+            Path:    "/api/v1/geofences/import",
+            Tag:     "geofences",
+            Summary: "Import geofences from a GeoJSON feature collection",
+            Params: []*openapi.Parameter{
+                queryParameter("dry_run", "Validate the geofences without storing them"),
+            },
+            Body:     geojson.FeatureCollection{},
+            Response: services.GeofenceImportResult{},
+        },
+        openapi.Route{
+            Method:   http.MethodGet,
+            Path:     "/api/v1/ingestion-errors",
+            Tag:      "ingestion",
+            Summary:  "Find the rejected readings",
+            Query:    repositories.IngestionErrorFilter{},
+            Response: []*repositories.IngestionError{},
+        },
+        openapi.Route{
+            Method:   http.MethodGet,
+            Path:     "/api/v1/fuel-anomalies",
+            Tag:      "anomalies",
+            Summary:  "Find the detected fuel anomalies",
+            Query:    repositories.FuelAnomalyFilter{},
+            Response: []*repositories.FuelAnomaly{},
+        },
+        openapi.Route{
+            Method:   http.MethodGet,
+            Path:     "/api/v1/maintenance/thresholds",
+            Tag:      "maintenance",
+            Summary:  "Find the maintenance intervals of the vehicles",
+            Response: []*repositories.MaintenanceThreshold{},
+        },
+        openapi.Route{
+            Method:   http.MethodPut,
+            Path:     "/api/v1/maintenance/thresholds",
+            Tag:      "maintenance",
+            Summary:  "Set the maintenance interval of a vehicle",
+            Body:     services.MaintenanceThresholdRequest{},
+            Response: repositories.MaintenanceThreshold{},
+        },
+        openapi.Route{
+            Method:   http.MethodGet,
+            Path:     "/api/v1/maintenance/events",
+            Tag:      "maintenance",
+            Summary:  "Find the crossed maintenance thresholds",
+            Query:    repositories.MaintenanceEventFilter{},
+            Response: []*repositories.MaintenanceEvent{},
+        },
+        openapi.Route{
+            Method:   http.MethodGet,
+            Path:     "/api/v1/status-suggestions",
+            Tag:      "maintenance",
+            Summary:  "Find the suggested status changes of vehicles that stopped moving",
+            Query:    repositories.StatusSuggestionFilter{},
+            Response: []*repositories.StatusSuggestion{},
+        },
+        openapi.Route{
+            Method:   http.MethodPut,
+            Path:     "/api/v1/status-suggestions/{id}",
+            Tag:      "maintenance",
+            Summary:  "Accept or dismiss a pending status suggestion",
+            Params:   []*openapi.Parameter{pathParameter("id", "ObjectID or ULID public id of the suggestion")},
+            Body:     services.ResolveSuggestionRequest{},
+            Response: repositories.StatusSuggestion{},
+            Admin:    true,
+        },
+        openapi.Route{
+            Method:   http.MethodGet,
+            Path:     "/api/v1/expected-intervals",
+            Tag:      "freshness",
+            Summary:  "Find the expected report intervals of the vehicles",
+            Response: []*repositories.ExpectedInterval{},
+        },
+        openapi.Route{
+            Method:   http.MethodPut,
+            Path:     "/api/v1/expected-intervals",
+            Tag:      "freshness",
+            Summary:  "Set the expected report interval of a vehicle",
+            Body:     services.ExpectedIntervalRequest{},
+            Response: repositories.ExpectedInterval{},
+        },
+        openapi.Route{
+            Method:   http.MethodGet,
+            Path:     "/api/v1/vehicle-assignments",
+            Tag:      "access",
+            Summary:  "Find the vehicle assignments of access control",
+            Response: []*repositories.VehicleAssignment{},
+            Admin:    true,
+        },
+        openapi.Route{
+            Method:   http.MethodPut,
+            Path:     "/api/v1/vehicle-assignments",
+            Tag:      "access",
+            Summary:  "Assign a vehicle to an organization and fleet groups",
+            Body:     services.VehicleAssignmentRequest{},
+            Response: repositories.VehicleAssignment{},
+            Admin:    true,
+        },
+        openapi.Route{
+            Method:   http.MethodGet,
+            Path:     "/api/v1/user-memberships",
+            Tag:      "access",
+            Summary:  "Find the organizations and fleet groups of the users",
+            Response: []*repositories.UserMembership{},
+            Admin:    true,
+        },
+        openapi.Route{
+            Method:   http.MethodPut,
+            Path:     "/api/v1/user-memberships",
+            Tag:      "access",
+            Summary:  "Put a user in an organization and fleet groups",
+            Body:     services.UserMembershipRequest{},
+            Response: repositories.UserMembership{},
+            Admin:    true,
+        },
+        openapi.Route{
+            Method:   http.MethodGet,
+            Path:     "/api/v1/driver-assignments",
+            Tag:      "drivers",
+            Summary:  "Find the drivers assigned to the vehicles",
+            Response: []*repositories.DriverAssignment{},
+            Admin:    true,
+        },
+        openapi.Route{
+            Method:   http.MethodPut,
+            Path:     "/api/v1/driver-assignments",
+            Tag:      "drivers",
+            Summary:  "Assign a driver to a vehicle",
+            Body:     services.DriverAssignmentRequest{},
+            Response: repositories.DriverAssignment{},
+            Admin:    true,
+        },
+        openapi.Route{
+            Method:  http.MethodDelete,
+            Path:    "/api/v1/driver-assignments/{vehicleID}",
+            Tag:     "drivers",
+            Summary: "Unassign the driver of a vehicle",
+            Params:  []*openapi.Parameter{pathParameter("vehicleID", "ObjectID of the vehicle")},
+            Admin:   true,
+        },
+        openapi.Route{
+            Method:   http.MethodGet,
+            Path:     "/api/v1/access-audits",
+            Tag:      "access",
+            Summary:  "Find the audit of the API requests",
+            Query:    repositories.AccessAuditFilter{},
+            Response: []*repositories.AccessAudit{},
+            Admin:    true,
+        },
+        openapi.Route{
+            Method:   http.MethodGet,
+            Path:     "/api/v1/disclosure-audits",
+            Tag:      "access",
+            Summary:  "Find the audit of the public statistics disclosed to partners",
+            Query:    repositories.DisclosureAuditFilter{},
+            Response: []*repositories.DisclosureAudit{},
+            Admin:    true,
+        },
+        openapi.Route{
+            Method:   http.MethodGet,
+            Path:     "/api/v1/diagnostics",
+            Tag:      "operations",
+            Summary:  "Download the diagnostics bundle for support tickets",
+            Download: []string{"application/json"},
+            Admin:    true,
+        },
+        openapi.Route{
+            Method:   http.MethodPost,
+            Path:     "/api/v1/admin/consumer/pause",
+            Tag:      "operations",
+            Summary:  "Stop consuming the tracking queue, the messages wait in the broker until it is resumed",
+            Response: services.ConsumerStatus{},
+            Admin:    true,
+        },
+        openapi.Route{
+            Method:   http.MethodPost,
+            Path:     "/api/v1/admin/consumer/resume",
+            Tag:      "operations",
+            Summary:  "Consume the tracking queue again after it was paused",
+            Response: services.ConsumerStatus{},
+            Admin:    true,
+        },
+        openapi.Route{
+            Method:   http.MethodGet,
+            Path:     "/api/v1/deprecations",
+            Tag:      "operations",
+            Summary:  "Find the deprecated features and the callers still using them",
+            Response: []*services.DeprecationReport{},
+            Admin:    true,
+        },
+        openapi.Route{
+            Method:   http.MethodGet,
+            Path:     "/api/v1/vehicle-events",
+            Tag:      "operations",
+            Summary:  "Find the published formats of the vehicle updates and the consumers of each",
+            Response: &services.VehicleEventStats{},
+            Admin:    true,
+        },
+        openapi.Route{
+            Method:   http.MethodGet,
+            Path:     "/api/v1/vendors",
+            Tag:      "vendors",
+            Summary:  "Find the vendors",
+            Response: []*repositories.Vendor{},
+        },
+        openapi.Route{
+            Method:   http.MethodPost,
+            Path:     "/api/v1/vendors",
+            Tag:      "vendors",
+            Summary:  "Register a vendor, its API key is only returned once",
+            Body:     services.VendorRequest{},
+            Response: map[string]any{},
+            Status:   http.StatusCreated,
+        },
+        openapi.Route{
+            Method:   http.MethodPut,
+            Path:     "/api/v1/vendors/devices",
+            Tag:      "vendors",
+            Summary:  "Register the devices of a vendor",
+            Body:     services.VendorDevicesRequest{},
+            Response: repositories.Vendor{},
+        },
+        openapi.Route{
+            Method:   http.MethodDelete,
+            Path:     "/api/v1/vendors/{id}/key",
+            Tag:      "vendors",
+            Summary:  "Revoke the API key of a vendor",
+            Params:   []*openapi.Parameter{pathParameter("id", "ObjectID of the vendor")},
+            Response: repositories.Vendor{},
+        },
+    )
+    // the simulation routes are only documented where they are served
+    if simulation {
+        generator.Add(
+            openapi.Route{
+                Method:   http.MethodGet,
+                Path:     "/api/v1/simulation",
+                Tag:      "simulation",
+                Summary:  "Get the running simulation with the positions of its vehicles",
+                Response: services.SimulationStatus{},
+                Admin:    true,
+            },
+            openapi.Route{
+                Method:   http.MethodPost,
+                Path:     "/api/v1/simulation",
+                Tag:      "simulation",
+                Summary:  "Start driving virtual vehicles along routes in real time",
+                Body:     services.SimulationRequest{},
+                Response: services.SimulationStatus{},
+                Status:   http.StatusCreated,
+                Admin:    true,
+            },
+            openapi.Route{
+                Method:   http.MethodDelete,
+                Path:     "/api/v1/simulation",
+                Tag:      "simulation",
+                Summary:  "Stop the running simulation",
+                Response: services.SimulationStatus{},
+                Admin:    true,
+            },
+            openapi.Route{
+                Method:   http.MethodPut,
+                Path:     "/api/v1/simulation/speed",
+                Tag:      "simulation",
+                Summary:  "Change how fast the simulated time passes",
+                Body:     services.SimulationSpeedRequest{},
+                Response: services.SimulationStatus{},
+                Admin:    true,
+            },
+        )
+    }
+    // the history routes are only documented where ClickHouse is configured
+    if history {
+        generator.Add(
+            openapi.Route{
+                Method:   http.MethodGet,
+                Path:     "/api/v1/history/heatmap",
+                Tag:      "history",
+                Summary:  "Count the readings and vehicles per cell of a grid over the long-term history",
+                Query:    repositories.HistoryFilter{},
+                Response: []*repositories.HeatmapCell{},
+            },
+            openapi.Route{
+                Method:   http.MethodGet,
+                Path:     "/api/v1/history/utilization",
+                Tag:      "history",
+                Summary:  "Get the utilization of the vehicles per day, week, month or year",
+                Query:    repositories.HistoryFilter{},
+                Response: []*repositories.VehicleUtilization{},
+            },
+        )
+    }
+    // the rollups are only documented where they are computed
+    if rollups {
+        generator.Add(
+            openapi.Route{
+                Method:   http.MethodGet,
+                Path:     "/api/v1/tracking-data/rollups",
+                Tag:      "tracking-data",
+                Summary:  "Summaries of the readings per vehicle and 5 minutes or hour",
+                Query:    repositories.TrackingRollupFilter{},
+                Response: []*repositories.TrackingRollup{},
+            },
+        )
+    }
+    // the stale vehicles are only documented where the watchdog runs
+    if stale {
+        generator.Add(
+            openapi.Route{
+                Method:   http.MethodGet,
+                Path:     "/api/v1/vehicles/stale",
+                Tag:      "tracking-data",
+                Summary:  "Find the current state of the vehicles that stopped reporting",
+                Query:    repositories.VehicleStateFilter{},
+                Response: []*repositories.VehicleState{},
+            },
+        )
+    }
+    // the safety scores are only documented where the driving is scored
+    if scoring {
+        generator.Add(
+            openapi.Route{
+                Method:   http.MethodGet,
+                Path:     "/api/v1/scores",
+                Tag:      "scores",
+                Summary:  "Find the rolling safety scores of the vehicles or the drivers",
+                Query:    repositories.SafetyScoreFilter{},
+                Response: []*services.SafetyScore{},
+            },
+            openapi.Route{
+                Method:   http.MethodGet,
+                Path:     "/api/v1/scores/daily",
+                Tag:      "scores",
+                Summary:  "Find the safety scores of every day of the vehicles or the drivers, the newest day first",
+                Query:    repositories.SafetyScoreFilter{},
+                Response: []*services.SafetyScore{},
+            },
+        )
+    }
+    // the idling is only documented where it is detected
+    if idling {
+        generator.Add(
+            openapi.Route{
+                Method:   http.MethodGet,
+                Path:     "/api/v1/idling/segments",
+                Tag:      "idling",
+                Summary:  "Find the periods active vehicles stood still, the latest first",
+                Query:    repositories.IdleFilter{},
+                Response: []*repositories.IdleSegment{},
+            },
+            openapi.Route{
+                Method:   http.MethodGet,
+                Path:     "/api/v1/idling/report",
+                Tag:      "idling",
+                Summary:  "Idle time and estimated wasted fuel per vehicle and day, the latest day first",
+                Query:    repositories.IdleFilter{},
+                Response: []*services.IdleReportDay{},
+            },
+        )
+    }
+    // the alert rules are only documented where they are evaluated
+    if alerting {
+        generator.Add(
+            openapi.Route{
+                Method:   http.MethodGet,
+                Path:     "/api/v1/alert-rules",
+                Tag:      "alerts",
+                Summary:  "Find the alert rules",
+                Response: []*repositories.AlertRule{},
+                Admin:    true,
+            },
+            openapi.Route{
+                Method:   http.MethodPost,
+                Path:     "/api/v1/alert-rules",
+                Tag:      "alerts",
+                Summary:  "Create an alert rule",
+                Body:     services.AlertRuleRequest{},
+                Response: repositories.AlertRule{},
+                Status:   http.StatusCreated,
+                Admin:    true,
+            },
+            openapi.Route{
+                Method:   http.MethodPut,
+                Path:     "/api/v1/alert-rules/{id}",
+                Tag:      "alerts",
+                Summary:  "Replace an alert rule",
+                Params:   []*openapi.Parameter{pathParameter("id", "ObjectID of the alert rule")},
+                Body:     services.AlertRuleRequest{},
+                Response: repositories.AlertRule{},
+                Admin:    true,
+            },
+            openapi.Route{
+                Method:  http.MethodDelete,
+                Path:    "/api/v1/alert-rules/{id}",
+                Tag:     "alerts",
+                Summary: "Delete an alert rule, its alerts are kept",
+                Params:  []*openapi.Parameter{pathParameter("id", "ObjectID of the alert rule")},
+                Admin:   true,
+            },
+            openapi.Route{
+                Method:   http.MethodGet,
+                Path:     "/api/v1/alerts",
+                Tag:      "alerts",
+                Summary:  "Find the alerts raised by the alert rules, the latest first",
+                Query:    repositories.AlertFilter{},
+                Response: []*repositories.Alert{},
+            },
+        )
+    }
+    // the digests are only documented where they are emailed
+    if digests {
+        generator.Add(
+            openapi.Route{
+                Method:   http.MethodGet,
+                Path:     "/api/v1/digests",
+                Tag:      "digests",
+                Summary:  "Find the digest subscription of the tenant",
+                Response: repositories.DigestSubscription{},
+                Admin:    true,
+            },
+            openapi.Route{
+                Method:   http.MethodPut,
+                Path:     "/api/v1/digests",
+                Tag:      "digests",
+                Summary:  "Subscribe the tenant to the daily or weekly digests, or change its subscription",
+                Body:     services.DigestRequest{},
+                Response: repositories.DigestSubscription{},
+                Admin:    true,
+            },
+            openapi.Route{
+                Method:   http.MethodGet,
+                Path:     "/api/v1/digests/preview",
+                Tag:      "digests",
+                Summary:  "Build the digest of the last complete period without emailing it",
+                Params:   []*openapi.Parameter{queryParameter("period", "daily (default) or weekly")},
+                Response: services.Digest{},
+                Admin:    true,
+            },
+        )
+    }
+    // the quarantine is only documented where the failing readings are quarantined
+    if quarantine {
+        generator.Add(
+            openapi.Route{
+                Method:   http.MethodGet,
+                Path:     "/api/v1/quarantined-readings",
+                Tag:      "ingestion",
+                Summary:  "Find the readings that failed the data quality checks, the latest first",
+                Query:    repositories.QuarantineFilter{},
+                Response: []*repositories.QuarantinedReading{},
+                Admin:    true,
+            },
+            openapi.Route{
+                Method:   http.MethodPost,
+                Path:     "/api/v1/quarantined-readings/{id}/release",
+                Tag:      "ingestion",
+                Summary:  "Store a reviewed reading as it was received and publish it",
+                Params:   []*openapi.Parameter{pathParameter("id", "ObjectID of the quarantined reading")},
+                Response: repositories.TrackingRecord{},
+                Admin:    true,
+            },
+            openapi.Route{
+                Method:  http.MethodDelete,
+                Path:    "/api/v1/quarantined-readings/{id}",
+                Tag:     "ingestion",
+                Summary: "Discard a reviewed reading",
+                Params:  []*openapi.Parameter{pathParameter("id", "ObjectID of the quarantined reading")},
+                Admin:   true,
+            },
+        )
+    }
+    // the webhooks are only documented where they are delivered
+    if webhooks {
+        generator.Add(
+            openapi.Route{
+                Method:   http.MethodGet,
+                Path:     "/api/v1/webhooks",
+                Tag:      "webhooks",
+                Summary:  "Find the registered webhooks",
+                Response: []*repositories.Webhook{},
+                Admin:    true,
+            },
+            openapi.Route{
+                Method:   http.MethodPost,
+                Path:     "/api/v1/webhooks",
+                Tag:      "webhooks",
+                Summary:  "Register a webhook, its signing secret is only returned here",
+                Body:     services.WebhookRequest{},
+                Response: handler.WebhookResponse{},
+                Status:   http.StatusCreated,
+                Admin:    true,
+            },
+            openapi.Route{
+                Method:   http.MethodPut,
+                Path:     "/api/v1/webhooks/{id}",
+                Tag:      "webhooks",
+                Summary:  "Update a webhook, the new secret is returned when it is rotated",
+                Params:   []*openapi.Parameter{pathParameter("id", "ObjectID of the webhook")},
+                Body:     services.WebhookRequest{},
+                Response: handler.WebhookResponse{},
+                Admin:    true,
+            },
+            openapi.Route{
+                Method:  http.MethodDelete,
+                Path:    "/api/v1/webhooks/{id}",
+                Tag:     "webhooks",
+                Summary: "Delete a webhook with its delivery log",
+                Params:  []*openapi.Parameter{pathParameter("id", "ObjectID of the webhook")},
+                Admin:   true,
+            },
+            openapi.Route{
+                Method:   http.MethodGet,
+                Path:     "/api/v1/webhooks/{id}/deliveries",
+                Tag:      "webhooks",
+                Summary:  "Find the delivery log of a webhook, the newest first",
+                Params:   []*openapi.Parameter{pathParameter("id", "ObjectID of the webhook")},
+                Query:    repositories.WebhookDeliveryFilter{},
+                Response: []*repositories.WebhookDelivery{},
+                Admin:    true,
+            },
+        )
+    }
+    generator.Add(
+        openapi.Route{
+            Method:      http.MethodGet,
+            Path:        "/api/v2/tracking-data",
+            Tag:         "tracking-data-v2",
+            Summary:     "Find tracking data with filtering, a page at a time by cursor",
+            Description: v2ListDescription,
+            Query:       repositories.TrackingFilter{},
+            Params:      v2ListParameters(),
+            Response:    []*handler.V2TrackingData{},
+            Envelope:    &handler.V2Response{},
+        },
+        openapi.Route{
+            Method:   http.MethodPost,
+            Path:     "/api/v2/tracking-data",
+            Tag:      "tracking-data-v2",
+            Summary:  "Ingest a tracking data reading located by its GeoJSON position",
+            Params:   []*openapi.Parameter{idempotencyKeyParameter()},
+            Body:     handler.V2TrackingDataRequest{},
+            Response: handler.V2TrackingData{},
+            Status:   http.StatusCreated,
+            Envelope: &handler.V2Response{},
+        },
+        openapi.Route{
+            Method:   http.MethodGet,
+            Path:     "/api/v2/tracking-data/{id}",
+            Tag:      "tracking-data-v2",
+            Summary:  "Find a tracking data by its id",
+            Params:   []*openapi.Parameter{pathParameter("id", "ObjectID or ULID public id of the tracking data")},
+            Response: handler.V2TrackingData{},
+            Envelope: &handler.V2Response{},
+        },
+        openapi.Route{
+            Method:      http.MethodGet,
+            Path:        "/api/v2/vehicles/{vehicleID}/tracking-data",
+            Tag:         "tracking-data-v2",
+            Summary:     "Find the tracking data of a vehicle, a page at a time by cursor",
+            Description: v2ListDescription,
+            Query:       repositories.TrackingFilter{},
+            Params: append(
+                []*openapi.Parameter{pathParameter("vehicleID", "ObjectID of the vehicle")},
+                v2ListParameters()...,
+            ),
+            Response: []*handler.V2TrackingData{},
+            Envelope: &handler.V2Response{},
+        },
+    )
+    for _, deprecation := range deprecations {
+        generator.Deprecate(deprecation.Method, deprecation.Path, deprecation.Param)
+    }
+    return generator.Document()
+}
+
+// v2ListDescription tells the filters of v1 apart from its paging, which v2 replaces by the cursor
+const v2ListDescription = "Takes the filters of v1, page, sort_by, sort_order, fields and after are ignored."
+
+// v2ListParameters are the paging parameters of the v2 lists
+func v2ListParameters() []*openapi.Parameter {
+    return []*openapi.Parameter{
+        queryParameter("order", "desc (default) for the newest first or asc for the oldest first"),
+        queryParameter("cursor", "next_cursor of the previous page, the first page without one"),
+    }
+}
+
+func queryParameter(name, description string) *openapi.Parameter {
+    return &openapi.Parameter{Name: name, In: "query", Description: description, Schema: &openapi.Schema{Type: "string"}}
+}
+
+// idempotencyKeyParameter is the header the retries of an ingestion request are recognized by
+func idempotencyKeyParameter() *openapi.Parameter {
+    return &openapi.Parameter{
+        Name:        handler.IdempotencyKeyHeader,
+        In:          "header",
+        Description: "Unique key of the request, its retries get the response of the first request",
+        Schema:      &openapi.Schema{Type: "string"},
+    }
+}
+
+func pathParameter(name, description string) *openapi.Parameter {
+    return &openapi.Parameter{
+        Name:        name,
+        In:          "path",
+        Description: description,
+        Required:    true,
+        Schema:      &openapi.Schema{Type: "string"},
+    }
+}
+
+func requiredQueryParameter(name, description string) *openapi.Parameter {
+    parameter := queryParameter(name, description)
+    parameter.Required = true
+    return parameter
+}
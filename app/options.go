@@ -0,0 +1,171 @@
+package app
+
+import (
+    "context"
+    "database/sql"
+    "net/http"
+
+    "github.com/go-playground/validator/v10"
+    "github.com/yemyoaung/managing-vehicle-tracking-common"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/config"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/secrets"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+// The types needed to customize the app, so embedding services can name them outside of this module
+type (
+    Config             = config.EnvConfig
+    TrackingRepository = repositories.TrackingRepository
+    TrackingService    = services.TrackingService
+    TrackingFilter     = repositories.TrackingFilter
+    TrackingRecord     = repositories.TrackingRecord
+    VehicleLimit       = repositories.VehicleLimit
+    Processor          = services.Processor
+    PublishProcessor   = services.PublishProcessor
+    ProcessorFuncs     = services.ProcessorFuncs
+    TrackingRequest    = services.TrackingDataRequest
+    Claims             = services.Claims
+    Deprecation        = services.Deprecation
+    SecretsProvider    = secrets.Provider
+    PolicyService      = services.PolicyService
+    PolicyInput        = services.PolicyInput
+)
+
+// Option customizes the app created by NewApp
+type Option func(*App)
+
+// WithConfig sets the environment configuration, it is required
+func WithConfig(cfg *Config) Option {
+    return func(a *App) {
+        a.cfg = cfg
+    }
+}
+
+// WithValidator sets the validator of the request payloads
+func WithValidator(validate *validator.Validate) Option {
+    return func(a *App) {
+        a.validator = validate
+    }
+}
+
+// WithRepository replaces the MongoDB tracking data repository, e.g. with a fake in integration tests
+func WithRepository(repo TrackingRepository) Option {
+    return func(a *App) {
+        a.trackingRepo = repo
+    }
+}
+
+// NewMemoryRepository returns a tracking data repository keeping the tracking data in memory, for WithRepository
+// in tests that shouldn't need MongoDB
+func NewMemoryRepository() TrackingRepository {
+    return repositories.NewMemoryTrackingRepository()
+}
+
+// NewPostgresRepository returns a tracking data repository storing the tracking data in PostgreSQL with TimescaleDB,
+// for WithRepository in services opening the database themselves. The hypertable is created when it is missing.
+func NewPostgresRepository(ctx context.Context, db *sql.DB) (TrackingRepository, error) {
+    repo := repositories.NewPostgresTrackingRepository(db)
+    if err := repo.CreateSchema(ctx); err != nil {
+        return nil, err
+    }
+    return repo, nil
+}
+
+// WithTrackingService replaces the service storing and querying the tracking data, e.g. with a fake in tests or
+// another backend. The ingestion processors, metrics and monitoring of the app still wrap it.
+func WithTrackingService(service TrackingService) Option {
+    return func(a *App) {
+        a.trackingService = service
+    }
+}
+
+// WithServer serves the API with the server instead of a default one on HOST and PORT, e.g. with other timeouts
+// or TLS. Its handler is replaced by the routes of the app, its address defaults to HOST and PORT and it is
+// shut down with the app. A server with a TLSConfig serves TLS with the certificates of the config.
+func WithServer(server *http.Server) Option {
+    return func(a *App) {
+        a.server = server
+    }
+}
+
+// WithBroker shares a RabbitMQ connection instead of connecting to RABBITMQ_URL,
+// the connection isn't closed when the app shuts down
+func WithBroker(conn *common.RabbitConnection) Option {
+    return func(a *App) {
+        if conn != nil {
+            a.rabbitConn = conn
+        }
+    }
+}
+
+// WithRoutes registers additional routes next to the API routes, behind the same middlewares. The patterns can
+// have a method and path parameters like "GET /api/v1/items/{id}".
+func WithRoutes(routes func(router *http.ServeMux)) Option {
+    return func(a *App) {
+        a.routes = append(a.routes, routes)
+    }
+}
+
+// WithMiddleware wraps the API routes after authentication, middlewares run in the order they were added
+func WithMiddleware(middleware func(http.Handler) http.Handler) Option {
+    return func(a *App) {
+        a.middlewares = append(a.middlewares, middleware)
+    }
+}
+
+// WithProcessor adds an ingestion processor, processors run in the order they were added. A PublishProcessor also
+// runs before the stored reading is published.
+func WithProcessor(processor Processor) Option {
+    return func(a *App) {
+        a.processors.Register(processor)
+    }
+}
+
+// WithTenantResolver replaces how the tenant of API requests is read when MULTI_TENANCY is enabled, by default it is
+// the tenant of the membership of the user authenticated by the auth service
+func WithTenantResolver(resolve func(r *http.Request) (string, error)) Option {
+    return func(a *App) {
+        a.tenants = resolve
+    }
+}
+
+// WithClaimsResolver replaces how the user claims are read when ACCESS_CONTROL is enabled, by default they are
+// built from the user authenticated by the auth service and its membership
+func WithClaimsResolver(resolve func(r *http.Request) (*Claims, error)) Option {
+    return func(a *App) {
+        a.claims = resolve
+    }
+}
+
+// WithDeprecation marks an endpoint or parameter of the API routes as deprecated, next to the deprecations of
+// the service
+func WithDeprecation(deprecation Deprecation) Option {
+    return func(a *App) {
+        a.deprecations = append(a.deprecations, &deprecation)
+    }
+}
+
+// WithSecretsProvider replaces where the encryption keys of TRACKING_ENCRYPTION are read from, e.g. a secrets
+// manager, by default they are read from the files in ENCRYPTION_KEYS_DIR
+func WithSecretsProvider(provider SecretsProvider) Option {
+    return func(a *App) {
+        a.secrets = provider
+    }
+}
+
+// WithConfigFile sets the YAML or TOML file the config was loaded from, the file is watched and the tunables
+// (consumer workers, thresholds and log level) are reloaded when it changes
+func WithConfigFile(path string) Option {
+    return func(a *App) {
+        a.configFile = path
+    }
+}
+
+// WithPolicy replaces the authorization policy of the admin actions and exports, e.g. with an embedded policy
+// engine, by default the policy engine of POLICY_URL or the role checks decide
+func WithPolicy(policy PolicyService) Option {
+    return func(a *App) {
+        a.policy = policy
+    }
+}
@@ -0,0 +1,59 @@
+package app
+
+import (
+    "context"
+    "log"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+)
+
+// partitionMaintenanceInterval is how often the partitions are rolled over and old ones dropped
+const partitionMaintenanceInterval = 24 * time.Hour
+
+// startPartitionMaintenance creates the partitions of the current and next month before they are written to,
+// so the rollover doesn't slow down the first writes of a month, and drops the partitions past the retention.
+// It runs once before returning and then daily in the background.
+func (a *App) startPartitionMaintenance(ctx context.Context, partitions *repositories.TrackingPartitions) error {
+    if err := a.maintainPartitions(ctx, partitions, time.Now()); err != nil {
+        return err
+    }
+    go func() {
+        ticker := time.NewTicker(partitionMaintenanceInterval)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-ctx.Done():
+                return
+            case now := <-ticker.C:
+                if err := a.maintainPartitions(ctx, partitions, now); err != nil {
+                    log.Println("Failed to maintain tracking partitions: ", err)
+                }
+            }
+        }
+    }()
+    log.Println("Tracking data partitioned monthly, retention in months: ", a.cfg.TrackingPartitionRetentionMonths())
+    return nil
+}
+
+func (a *App) maintainPartitions(ctx context.Context, partitions *repositories.TrackingPartitions, now time.Time) error {
+    now = now.UTC()
+    month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+    for _, at := range []time.Time{month, month.AddDate(0, 1, 0)} {
+        if _, err := partitions.Partition(ctx, at); err != nil {
+            return err
+        }
+    }
+
+    retention := a.cfg.TrackingPartitionRetentionMonths()
+    if retention == 0 {
+        return nil
+    }
+    // the current month is kept on top of the retention
+    before := month.AddDate(0, -retention, 0)
+    dropped, err := partitions.DropBefore(ctx, before)
+    if len(dropped) > 0 {
+        log.Println("Dropped tracking partitions: ", dropped)
+    }
+    return err
+}
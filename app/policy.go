@@ -0,0 +1,32 @@
+package app
+
+import (
+    "log"
+    "net/http"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/handler"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+// policyHTTPTimeout bounds the requests to the policy decision point, a slow decision fails the request with 503
+const policyHTTPTimeout = 2 * time.Second
+
+// applyPolicy puts the authorization policy in the request context, the one set with WithPolicy, the policy engine
+// of POLICY_URL or the built-in role checks
+func (a *App) applyPolicy(h http.Handler) http.Handler {
+    policy := a.policy
+    if policy == nil && a.cfg.PolicyURL != "" {
+        policy = services.NewHTTPPolicyService(
+            &http.Client{Timeout: policyHTTPTimeout},
+            a.cfg.PolicyURL,
+            a.cfg.PolicyToken,
+            a.cfg.PolicyCacheTTLDuration(),
+        )
+        log.Println("Authorization policy decided by: ", a.cfg.PolicyURL)
+    }
+    if policy == nil {
+        return h
+    }
+    return handler.PolicyMiddleware(policy)(h)
+}
@@ -0,0 +1,96 @@
+package app
+
+import (
+    "context"
+    "net/url"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/cache"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/plan"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+)
+
+// TrackingDeletion is the audit of the tracking data deleted by DeleteTrackingData
+type TrackingDeletion = repositories.DeletionAudit
+
+// DeleteTrackingData soft deletes or purges the tracking data selected by the query without starting the service,
+// for the purge command. The query takes the parameters of DELETE /api/v1/tracking-data and the deletion is audited
+// like the ones of the API, the tenant of the context is required with multi-tenancy. The history in ClickHouse and
+// the rollups aren't updated, delete through the API when they are enabled.
+func (a *App) DeleteTrackingData(ctx context.Context, query url.Values, requestedBy string) (*TrackingDeletion, error) {
+    var audit *TrackingDeletion
+    err := a.withDeletionService(ctx, func(deletionService services.TrackingDeletionService) (err error) {
+        audit, err = deletionService.DeleteTrackingData(ctx, query, requestedBy)
+        return err
+    })
+    return audit, err
+}
+
+// PlanTrackingDataDeletion returns what DeleteTrackingData would delete with the query without deleting anything
+func (a *App) PlanTrackingDataDeletion(ctx context.Context, query url.Values) (*plan.Plan, error) {
+    var p *plan.Plan
+    err := a.withDeletionService(ctx, func(deletionService services.TrackingDeletionService) (err error) {
+        p, err = deletionService.PlanDeletion(ctx, query)
+        return err
+    })
+    return p, err
+}
+
+// withDeletionService runs fn with the deletion service of the stored tracking data and disconnects afterward
+func (a *App) withDeletionService(
+    ctx context.Context,
+    fn func(deletionService services.TrackingDeletionService) error,
+) error {
+    if a.cfg != nil && a.cfg.MultiTenancyEnabled() {
+        id, _ := tenant.FromContext(ctx)
+        if err := tenant.Validate(id); err != nil {
+            return err
+        }
+    }
+    return a.withStoredTrackingRepository(ctx, func(trackingRepo repositories.TrackingRepository) error {
+        // the deletions update the vehicle states like the ones of the service
+        trackingRepo = repositories.NewVehicleStateTrackingRepository(
+            trackingRepo,
+            repositories.NewMongoVehicleStateRepository(a.db.Database("tracking")),
+        )
+        return fn(services.NewMongoTrackingDeletionService(
+            trackingRepo,
+            repositories.NewMongoDeletionAuditRepository(a.db.Database("tracking")),
+        ))
+    })
+}
+
+// withStoredTrackingRepository runs fn with the stored tracking data and disconnects afterward, for the commands
+// changing it without starting the service
+func (a *App) withStoredTrackingRepository(
+    ctx context.Context,
+    fn func(trackingRepo repositories.TrackingRepository) error,
+) error {
+    if a.cfg == nil {
+        return ErrConfigMissing
+    }
+    if err := a.cfg.Validate(); err != nil {
+        return err
+    }
+    if a.trackingRepo == nil && a.cfg.StorageBackendMemory() {
+        return ErrMemoryStorage
+    }
+    defer a.disconnect(ctx)
+    if err := a.connectMongo(ctx); err != nil {
+        return err
+    }
+
+    trackingRepo, _, err := a.storedTrackingRepository(ctx)
+    if err != nil {
+        return err
+    }
+    // the changes invalidate the cached tracking data and responses like the ones of the service
+    if a.cfg.RedisURL != "" {
+        if a.redis, err = cache.NewRedisClient(a.cfg.RedisURL); err != nil {
+            return err
+        }
+        trackingRepo = repositories.NewCachedTrackingRepository(trackingRepo, a.redis, a.cfg.CacheTTLDuration())
+    }
+    return fn(a.applyPurging(trackingRepo))
+}
@@ -0,0 +1,23 @@
+package app
+
+import (
+    "context"
+    "log"
+    "time"
+)
+
+// primeCache loads the latest tracking data of the recently active vehicles into the cache before the service
+// reports ready, within CACHE_PRIME_TIMEOUT so a slow database can't keep it from ever being ready
+func (a *App) primeCache(ctx context.Context) {
+    ctx, cancel := context.WithTimeout(ctx, a.cfg.CachePrimeTimeoutDuration())
+    defer cancel()
+
+    started := time.Now()
+    if err := a.readiness.Prime(ctx); err != nil {
+        log.Println("Failed to prime the tracking data cache, ready without it: ", err)
+        return
+    }
+    if primed := a.readiness.Readiness().PrimedVehicles; primed > 0 {
+        log.Printf("Primed the tracking data cache with %d vehicles in %s", primed, time.Since(started))
+    }
+}
@@ -0,0 +1,96 @@
+package app
+
+import (
+    "context"
+    "log"
+    "sync"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/config"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+// tunables are the components whose settings are reloaded from the config file
+type tunables struct {
+    fuelAnomaly *services.MongoFuelAnomalyService
+    maintenance *services.MongoMaintenanceService
+    freshness   *services.MongoFreshnessService
+    // vehicleEvents switches the published format while the consumers migrate
+    vehicleEvents *services.DualFormatVehicleEventService
+}
+
+// applyTunables applies the reloadable variables of a config to the running app
+func (a *App) applyTunables(cfg *config.EnvConfig, t *tunables) {
+    a.workers.setLimit(cfg.ConsumerWorkersValue())
+    a.debug.Store(cfg.LogLevelValue() == "debug")
+    if t == nil {
+        return
+    }
+    t.fuelAnomaly.SetMileagePerLevel(cfg.FuelAnomalyMileagePerLevelValue())
+    t.maintenance.SetGlobalInterval(cfg.MaintenanceIntervalValue())
+    t.freshness.SetDefaultInterval(cfg.ExpectedReportIntervalDuration())
+    t.vehicleEvents.SetFormat(cfg.VehicleEventFormatValue())
+}
+
+// watchConfig reloads the tunables when the config file changes, the other variables need a restart
+func (a *App) watchConfig(ctx context.Context, t *tunables) {
+    if a.configFile == "" {
+        return
+    }
+    go config.Watch(
+        ctx,
+        a.configFile,
+        a.cfg,
+        a.cfg.ConfigReloadIntervalDuration(),
+        func(previous *config.EnvConfig, next *config.EnvConfig) {
+            reloaded, restart := previous.Changed(next)
+            if len(reloaded) > 0 {
+                a.applyTunables(next, t)
+                log.Println("Reloaded config: ", reloaded)
+            }
+            if len(restart) > 0 {
+                log.Println("Config changes that need a restart to apply: ", restart)
+            }
+        },
+    )
+    log.Println("Watching config file for changes: ", a.configFile)
+}
+
+// workerLimit limits how many messages are processed at once, the limit can change while messages are processed
+type workerLimit struct {
+    mu     sync.Mutex
+    cond   *sync.Cond
+    active int
+    // limit is 0 when the workers aren't limited
+    limit int
+}
+
+func newWorkerLimit() *workerLimit {
+    w := &workerLimit{}
+    w.cond = sync.NewCond(&w.mu)
+    return w
+}
+
+// acquire waits until a worker is free
+func (w *workerLimit) acquire() {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    for w.limit > 0 && w.active >= w.limit {
+        w.cond.Wait()
+    }
+    w.active++
+}
+
+func (w *workerLimit) release() {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    w.active--
+    w.cond.Signal()
+}
+
+// setLimit changes the limit, lowering it lets the active workers finish
+func (w *workerLimit) setLimit(limit int) {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    w.limit = limit
+    w.cond.Broadcast()
+}
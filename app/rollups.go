@@ -0,0 +1,47 @@
+package app
+
+import (
+    "context"
+    "log"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+// applyRollups rolls up the readings every ROLLUP_INTERVAL when TRACKING_ROLLUPS is enabled, the rollup job runs
+// until ctx is done. The corrections stored through the returned repository invalidate the rollups of their periods.
+func (a *App) applyRollups(
+    ctx context.Context,
+    trackingRepo repositories.TrackingRepository,
+    accessService services.AccessService,
+) (repositories.TrackingRepository, error) {
+    if !a.cfg.TrackingRollupsEnabled() {
+        return trackingRepo, nil
+    }
+    rollupRepo := repositories.NewMongoTrackingRollupRepository(a.db.Database("tracking"))
+    if err := rollupRepo.CreateIndexes(ctx); err != nil {
+        return nil, err
+    }
+    a.rollups = services.NewMongoTrackingRollupService(
+        trackingRepo,
+        rollupRepo,
+        accessService,
+        a.cfg.RollupDelayDuration(),
+    )
+    go services.NewTrackingRollupScheduler(a.rollups, a.cfg.RollupIntervalDuration()).Run(ctx)
+    log.Println("Tracking data rolled up every: ", a.cfg.RollupIntervalDuration())
+    return repositories.NewRollupInvalidatingTrackingRepository(trackingRepo, rollupRepo, a.rollups), nil
+}
+
+// applyRollupRoutes serves the routes over wide time ranges from the rollups when they are enabled
+func (a *App) applyRollupRoutes(trackingService services.TrackingService) services.TrackingService {
+    if a.rollups == nil {
+        return trackingService
+    }
+    return services.NewRollupRoutingTrackingService(
+        trackingService,
+        a.rollups,
+        a.cfg.Rollup5mAfterDuration(),
+        a.cfg.Rollup1hAfterDuration(),
+    )
+}
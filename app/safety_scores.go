@@ -0,0 +1,37 @@
+package app
+
+import (
+    "context"
+    "log"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+// safetyScoreService creates the service of the safety scores when SAFETY_SCORING is enabled and registers the
+// processor counting the driving of every reading, the score changes are published daily until ctx is done. It
+// returns nil when the scoring is disabled.
+func (a *App) safetyScoreService(
+    ctx context.Context,
+    alertsPublisher services.Publisher,
+    accessService services.AccessService,
+) (*services.MongoSafetyScoreService, error) {
+    if !a.cfg.SafetyScoringEnabled() {
+        return nil, nil
+    }
+    scoreRepo := repositories.NewMongoSafetyScoreRepository(a.db.Database("tracking"))
+    if err := scoreRepo.CreateIndexes(ctx); err != nil {
+        return nil, err
+    }
+    a.processors.Register(services.NewSafetyScoringProcessor(scoreRepo, a.cfg.SpeedLimitKmhValue()))
+    safetyScoreService := services.NewMongoSafetyScoreService(
+        scoreRepo,
+        accessService,
+        alertsPublisher,
+        a.cfg.SafetyScoreDaysValue(),
+        a.cfg.SafetyScoreChangeValue(),
+    )
+    go services.NewSafetyScoreScheduler(safetyScoreService, a.cfg.ReportDelayDuration()).Run(ctx)
+    log.Println("Safety scoring enabled, rolling over days: ", a.cfg.SafetyScoreDaysValue())
+    return safetyScoreService, nil
+}
@@ -0,0 +1,25 @@
+package app
+
+import (
+    "net/http"
+    "time"
+
+    amqp "github.com/rabbitmq/amqp091-go"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+// simulationHTTPTimeout bounds the requests posting simulated readings
+const simulationHTTPTimeout = 10 * time.Second
+
+// newSimulationPublisher creates the publisher of the simulated readings, they go through the tracking queue
+// like device messages unless SIMULATION_TARGET is http
+func (a *App) newSimulationPublisher(channel *amqp.Channel) services.Publisher {
+    if a.cfg.SimulationTarget == "http" {
+        return services.NewHTTPPublisher(
+            &http.Client{Timeout: simulationHTTPTimeout},
+            a.cfg.SimulationHTTPURL,
+            a.cfg.SimulationHTTPToken,
+        )
+    }
+    return a.newPublisher(channel, a.cfg.TrackingQueue)
+}
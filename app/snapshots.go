@@ -0,0 +1,82 @@
+package app
+
+import (
+    "context"
+    "log"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/storage"
+)
+
+// Snapshot is the export of the tracking data of a day by ExportSnapshot
+type Snapshot = services.Snapshot
+
+// SnapshotRestore is the outcome of RestoreSnapshot
+type SnapshotRestore = services.SnapshotRestore
+
+// ExportSnapshot exports the tracking data of the UTC day of day to S3_BUCKET without starting the service, for the
+// snapshot command. It replaces the snapshot the scheduler exported of the day.
+func (a *App) ExportSnapshot(ctx context.Context, day time.Time) (*Snapshot, error) {
+    var snapshot *Snapshot
+    err := a.withSnapshotService(ctx, func(snapshotService services.SnapshotService) (err error) {
+        snapshot, err = snapshotService.ExportSnapshot(ctx, day)
+        return err
+    })
+    return snapshot, err
+}
+
+// RestoreSnapshot inserts the readings of the snapshot of the UTC day of day that aren't stored anymore, for the
+// restore-snapshot command. Only the readings of the tenant of the context are restored when it has one. The history
+// in ClickHouse, the rollups and the vehicle states aren't updated.
+func (a *App) RestoreSnapshot(ctx context.Context, day time.Time) (*SnapshotRestore, error) {
+    var restore *SnapshotRestore
+    err := a.withSnapshotService(ctx, func(snapshotService services.SnapshotService) (err error) {
+        restore, err = snapshotService.RestoreSnapshot(ctx, day)
+        return err
+    })
+    return restore, err
+}
+
+// withSnapshotService runs fn with the snapshot service of the stored tracking data and disconnects afterward
+func (a *App) withSnapshotService(ctx context.Context, fn func(snapshotService services.SnapshotService) error) error {
+    return a.withStoredTrackingRepository(ctx, func(trackingRepo repositories.TrackingRepository) error {
+        snapshotStorage, err := a.snapshotStorage()
+        if err != nil {
+            return err
+        }
+        return fn(services.NewTrackingSnapshotService(trackingRepo, snapshotStorage, a.cfg.SnapshotParquetEnabled()))
+    })
+}
+
+// startSnapshotScheduler exports the tracking data of every day in the background when SNAPSHOTS is enabled
+func (a *App) startSnapshotScheduler(ctx context.Context, trackingRepo repositories.TrackingRepository) error {
+    if !a.cfg.SnapshotsEnabled() {
+        return nil
+    }
+    snapshotStorage, err := a.snapshotStorage()
+    if err != nil {
+        return err
+    }
+    snapshotService := services.NewTrackingSnapshotService(
+        trackingRepo,
+        snapshotStorage,
+        a.cfg.SnapshotParquetEnabled(),
+    )
+    go services.NewSnapshotScheduler(snapshotService, a.cfg.ReportDelayDuration()).Run(ctx)
+
+    log.Println("Snapshots enabled, exporting the tracking data to: ", snapshotStorage.Bucket())
+    return nil
+}
+
+// snapshotStorage returns the S3 storage of the snapshots, the one of the reports
+func (a *App) snapshotStorage() (*storage.S3Storage, error) {
+    return storage.NewS3Storage(
+        a.cfg.S3Endpoint,
+        a.cfg.S3Region,
+        a.cfg.S3Bucket,
+        a.cfg.S3AccessKeyID,
+        a.cfg.S3SecretAccessKey,
+    )
+}
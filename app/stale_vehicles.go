@@ -0,0 +1,27 @@
+package app
+
+import (
+    "context"
+    "log"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+// staleVehicleService creates the watchdog of the vehicles that stopped reporting when STALE_AFTER is set, stale
+// vehicles are looked for every STALE_CHECK_INTERVAL until ctx is done. It returns nil when the watchdog is disabled.
+func (a *App) staleVehicleService(
+    ctx context.Context,
+    stateRepo repositories.VehicleStateRepository,
+    alertsPublisher services.Publisher,
+    accessService services.AccessService,
+) *services.MongoStaleVehicleService {
+    staleAfter := a.cfg.StaleAfterDuration()
+    if staleAfter <= 0 {
+        return nil
+    }
+    staleVehicleService := services.NewMongoStaleVehicleService(stateRepo, alertsPublisher, accessService, staleAfter)
+    go services.NewStaleVehicleScheduler(staleVehicleService, a.cfg.StaleCheckIntervalDuration()).Run(ctx)
+    log.Println("Marking vehicles offline after not reporting for: ", staleAfter)
+    return staleVehicleService
+}
@@ -0,0 +1,40 @@
+package app
+
+import (
+    "log"
+    "net/http"
+    "time"
+
+    amqp "github.com/rabbitmq/amqp091-go"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+// vehicleServiceTimeout bounds the lookups of the vehicle service, a slow lookup counts as a failure
+const vehicleServiceTimeout = 2 * time.Second
+
+// validateVehicles wraps the tracking service to reject the readings of vehicles the vehicle service doesn't know
+// when VEHICLE_SERVICE_URL is set, the rejected readings are published to the quarantine queue
+func (a *App) validateVehicles(
+    channel *amqp.Channel,
+    trackingService services.TrackingService,
+) (services.TrackingService, error) {
+    if a.cfg.VehicleServiceURL == "" {
+        return trackingService, nil
+    }
+
+    // Declare the quarantine queue with durable
+    if _, err := channel.QueueDeclare(a.cfg.QuarantineQueueName(), true, false, false, false, nil); err != nil {
+        return nil, err
+    }
+    log.Println("Validating the vehicles with: ", a.cfg.VehicleServiceURL)
+    return services.NewVehicleValidatingTrackingService(
+        trackingService,
+        services.NewHTTPVehicleDirectory(
+            &http.Client{Timeout: vehicleServiceTimeout},
+            a.cfg.VehicleServiceURL,
+            a.cfg.VehicleServiceToken,
+            a.cfg.VehicleCacheTTLDuration(),
+        ),
+        a.newPublisher(channel, a.cfg.QuarantineQueueName()),
+    ), nil
+}
@@ -0,0 +1,55 @@
+package app
+
+import (
+    "context"
+    "log"
+    "net/http"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+// webhookService creates the service of the webhooks when WEBHOOKS is enabled, the due deliveries are attempted until
+// ctx is done. It returns nil when the webhooks are disabled.
+func (a *App) webhookService(ctx context.Context) (*services.MongoWebhookService, error) {
+    if !a.cfg.WebhooksEnabled() {
+        return nil, nil
+    }
+    webhookRepo := repositories.NewMongoWebhookRepository(a.db.Database("tracking"))
+    if err := webhookRepo.CreateIndexes(ctx); err != nil {
+        return nil, err
+    }
+    webhookService := services.NewMongoWebhookService(
+        webhookRepo,
+        &http.Client{Timeout: a.cfg.WebhookTimeoutDuration()},
+        a.cfg.WebhookMaxAttemptsValue(),
+        a.cfg.WebhookRetryBackoffDuration(),
+    )
+    go services.NewWebhookDispatcher(webhookService).Run(ctx)
+    log.Println("Webhooks enabled with attempts per delivery: ", a.cfg.WebhookMaxAttemptsValue())
+    return webhookService, nil
+}
+
+// motionMonitor creates the processor alerting about vehicles entering or exiting the geofences when GEOFENCE_ALERTS
+// is enabled, and about speeding vehicles when SPEED_LIMIT_KMH is set. It returns nil when both are disabled.
+func (a *App) motionMonitor(
+    ctx context.Context,
+    alertsPublisher services.Publisher,
+) (*services.MotionMonitor, error) {
+    geofencing, speedLimit := a.cfg.GeofenceAlertsEnabled(), a.cfg.SpeedLimitKmhValue()
+    if !geofencing && speedLimit == 0 {
+        return nil, nil
+    }
+    motionRepo := repositories.NewMongoVehicleMotionRepository(a.db.Database("tracking"))
+    if err := motionRepo.CreateIndexes(ctx); err != nil {
+        return nil, err
+    }
+    log.Println("Motion alerts enabled, geofences: ", geofencing, ", speed limit (km/h): ", speedLimit)
+    return services.NewMotionMonitor(
+        repositories.NewMongoGeofenceRepository(a.db.Database("tracking")),
+        motionRepo,
+        alertsPublisher,
+        geofencing,
+        speedLimit,
+    ), nil
+}
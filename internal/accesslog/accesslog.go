@@ -0,0 +1,227 @@
+package accesslog
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+)
+
+// Format is the line format of the access log
+type Format string
+
+const (
+    // FormatCommon is the NCSA Common Log Format
+    FormatCommon Format = "common"
+    // FormatCombined is the NCSA Combined Log Format followed by the latency in microseconds, like Apache's %D
+    FormatCombined Format = "combined"
+    // FormatJSON writes an object per line (JSON Lines)
+    FormatJSON Format = "json"
+
+    // clfTimeLayout is the time format of the common and combined formats
+    clfTimeLayout = "02/Jan/2006:15:04:05 -0700"
+)
+
+var (
+    ErrUnsupportedFormat = errors.New("unsupported access log format, it must be common, combined or json")
+)
+
+func (f Format) Valid() error {
+    switch f {
+    case FormatCommon, FormatCombined, FormatJSON:
+        return nil
+    }
+    return fmt.Errorf("%w: %s", ErrUnsupportedFormat, f)
+}
+
+// Entry is a served HTTP request
+type Entry struct {
+    Time       time.Time
+    RemoteAddr string
+    // UserID, Role and TenantID identify who made the request, empty when unknown
+    UserID    string
+    Role      string
+    TenantID  string
+    Method    string
+    URI       string
+    Protocol  string
+    Status    int
+    Bytes     int64
+    Duration  time.Duration
+    Referer   string
+    UserAgent string
+}
+
+// jsonEntry is the JSON Lines record of an entry
+type jsonEntry struct {
+    Time       timestamp.Time `json:"time"`
+    RemoteAddr string         `json:"remote_addr"`
+    UserID     string         `json:"user_id,omitempty"`
+    Role       string         `json:"role,omitempty"`
+    TenantID   string         `json:"tenant_id,omitempty"`
+    Method     string         `json:"method"`
+    URI        string         `json:"uri"`
+    Protocol   string         `json:"protocol"`
+    Status     int            `json:"status"`
+    Bytes      int64          `json:"bytes"`
+    DurationMs float64        `json:"duration_ms"`
+    Referer    string         `json:"referer,omitempty"`
+    UserAgent  string         `json:"user_agent,omitempty"`
+}
+
+// Logger writes an access log line per entry, it is safe for concurrent use
+type Logger struct {
+    mu     sync.Mutex
+    out    io.Writer
+    format Format
+}
+
+func NewLogger(out io.Writer, format Format) (*Logger, error) {
+    if err := format.Valid(); err != nil {
+        return nil, err
+    }
+    return &Logger{out: out, format: format}, nil
+}
+
+// Open creates the logger of a sink, "stdout", "stderr" or the path of a file the lines are appended to.
+// The returned closer closes the file, it does nothing for the standard streams.
+func Open(sink string, format Format) (*Logger, io.Closer, error) {
+    var out io.WriteCloser
+    switch sink {
+    case "stdout":
+        out = nopCloser{os.Stdout}
+    case "stderr":
+        out = nopCloser{os.Stderr}
+    default:
+        file, err := os.OpenFile(sink, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+        if err != nil {
+            return nil, nil, err
+        }
+        out = file
+    }
+    logger, err := NewLogger(out, format)
+    if err != nil {
+        _ = out.Close()
+        return nil, nil, err
+    }
+    return logger, out, nil
+}
+
+type nopCloser struct {
+    io.Writer
+}
+
+func (nopCloser) Close() error {
+    return nil
+}
+
+func (l *Logger) Log(entry *Entry) error {
+    line, err := l.format.line(entry)
+    if err != nil {
+        return err
+    }
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    _, err = l.out.Write(line)
+    return err
+}
+
+func (f Format) line(entry *Entry) ([]byte, error) {
+    if f == FormatJSON {
+        line, err := json.Marshal(
+            jsonEntry{
+                Time:       timestamp.New(entry.Time),
+                RemoteAddr: entry.RemoteAddr,
+                UserID:     entry.UserID,
+                Role:       entry.Role,
+                TenantID:   entry.TenantID,
+                Method:     entry.Method,
+                URI:        entry.URI,
+                Protocol:   entry.Protocol,
+                Status:     entry.Status,
+                Bytes:      entry.Bytes,
+                DurationMs: float64(entry.Duration) / float64(time.Millisecond),
+                Referer:    entry.Referer,
+                UserAgent:  entry.UserAgent,
+            },
+        )
+        if err != nil {
+            return nil, err
+        }
+        return append(line, '\n'), nil
+    }
+
+    // host ident authuser [date] "request" status bytes, the ident is never known
+    var b strings.Builder
+    b.WriteString(field(entry.RemoteAddr))
+    b.WriteString(" - ")
+    b.WriteString(field(entry.UserID))
+    b.WriteString(" [")
+    b.WriteString(entry.Time.Format(clfTimeLayout))
+    b.WriteString("] ")
+    b.WriteString(quote(entry.Method + " " + entry.URI + " " + entry.Protocol))
+    b.WriteString(" ")
+    b.WriteString(strconv.Itoa(entry.Status))
+    b.WriteString(" ")
+    if entry.Bytes == 0 {
+        b.WriteString("-")
+    } else {
+        b.WriteString(strconv.FormatInt(entry.Bytes, 10))
+    }
+    if f == FormatCombined {
+        b.WriteString(" ")
+        b.WriteString(quote(entry.Referer))
+        b.WriteString(" ")
+        b.WriteString(quote(entry.UserAgent))
+        b.WriteString(" ")
+        b.WriteString(strconv.FormatInt(entry.Duration.Microseconds(), 10))
+    }
+    b.WriteString("\n")
+    return []byte(b.String()), nil
+}
+
+// field returns a field of the common format, "-" when it is empty. Spaces would split the field.
+func field(value string) string {
+    if value == "" {
+        return "-"
+    }
+    return strings.Map(
+        func(r rune) rune {
+            if r == ' ' || r < 0x20 || r == 0x7f {
+                return '_'
+            }
+            return r
+        },
+        value,
+    )
+}
+
+// quote returns a quoted field of the common format, "-" when it is empty. Quotes, backslashes and control
+// characters are escaped, so a header can't forge a line.
+func quote(value string) string {
+    if value == "" {
+        return `"-"`
+    }
+    var b strings.Builder
+    b.WriteByte('"')
+    for i := 0; i < len(value); i++ {
+        switch c := value[i]; {
+        case c == '"' || c == '\\':
+            b.WriteByte('\\')
+            b.WriteByte(c)
+        case c < 0x20 || c == 0x7f:
+            fmt.Fprintf(&b, `\x%02x`, c)
+        default:
+            b.WriteByte(c)
+        }
+    }
+    b.WriteByte('"')
+    return b.String()
+}
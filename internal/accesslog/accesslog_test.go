@@ -0,0 +1,98 @@
+package accesslog
+
+import (
+    "bytes"
+    "errors"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/goccy/go-json"
+)
+
+func testEntry() *Entry {
+    return &Entry{
+        Time:       time.Date(2025, time.March, 4, 10, 20, 30, 0, time.UTC),
+        RemoteAddr: "10.0.0.1",
+        UserID:     "user-1",
+        Role:       "admin",
+        TenantID:   "acme",
+        Method:     "GET",
+        URI:        "/api/v1/tracking-data?vehicle_id=1",
+        Protocol:   "HTTP/1.1",
+        Status:     200,
+        Bytes:      512,
+        Duration:   1500 * time.Microsecond,
+        UserAgent:  `curl/8.0 "quoted"`,
+    }
+}
+
+func TestLogger_Common(t *testing.T) {
+    var out bytes.Buffer
+    logger, err := NewLogger(&out, FormatCommon)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err = logger.Log(testEntry()); err != nil {
+        t.Fatal(err)
+    }
+    expected := `10.0.0.1 - user-1 [04/Mar/2025:10:20:30 +0000] "GET /api/v1/tracking-data?vehicle_id=1 HTTP/1.1" 200 512` + "\n"
+    if out.String() != expected {
+        t.Errorf("expected %q, got %q", expected, out.String())
+    }
+
+    out.Reset()
+    entry := testEntry()
+    entry.UserID, entry.Bytes = "", 0
+    if err = logger.Log(entry); err != nil {
+        t.Fatal(err)
+    }
+    if !strings.HasPrefix(out.String(), "10.0.0.1 - - [") || !strings.HasSuffix(out.String(), " 200 -\n") {
+        t.Errorf("expected the unknown fields as -, got %q", out.String())
+    }
+}
+
+func TestLogger_Combined(t *testing.T) {
+    var out bytes.Buffer
+    logger, err := NewLogger(&out, FormatCombined)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err = logger.Log(testEntry()); err != nil {
+        t.Fatal(err)
+    }
+    if !strings.HasSuffix(out.String(), ` 200 512 "-" "curl/8.0 \"quoted\"" 1500`+"\n") {
+        t.Errorf("expected the referer, escaped user agent and latency, got %q", out.String())
+    }
+}
+
+func TestLogger_JSON(t *testing.T) {
+    var out bytes.Buffer
+    logger, err := NewLogger(&out, FormatJSON)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err = logger.Log(testEntry()); err != nil {
+        t.Fatal(err)
+    }
+    if err = logger.Log(testEntry()); err != nil {
+        t.Fatal(err)
+    }
+    lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+    if len(lines) != 2 {
+        t.Fatalf("expected a line per entry, got %q", out.String())
+    }
+    var record map[string]any
+    if err = json.Unmarshal([]byte(lines[0]), &record); err != nil {
+        t.Fatal(err)
+    }
+    if record["user_id"] != "user-1" || record["tenant_id"] != "acme" || record["duration_ms"] != 1.5 {
+        t.Errorf("expected the identity and latency fields, got %v", record)
+    }
+}
+
+func TestNewLogger_UnsupportedFormat(t *testing.T) {
+    if _, err := NewLogger(&bytes.Buffer{}, "xml"); !errors.Is(err, ErrUnsupportedFormat) {
+        t.Errorf("expected ErrUnsupportedFormat, got %v", err)
+    }
+}
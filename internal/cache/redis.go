@@ -0,0 +1,108 @@
+package cache
+
+import (
+    "context"
+    "errors"
+    "time"
+
+    "github.com/redis/go-redis/v9"
+)
+
+const (
+    // redisTimeout bounds a command when the context has no deadline
+    redisTimeout = 2 * time.Second
+    // redisPoolSize is the number of idle connections kept open
+    redisPoolSize = 16
+)
+
+var (
+    ErrInvalidRedisURL = errors.New(
+        "invalid redis url, expected redis://[[user]:password@]host[:port][/db] or rediss:// for TLS",
+    )
+    ErrUnexpectedReply = errors.New("unexpected redis reply")
+)
+
+// Cache is a key value store with expiring keys
+type Cache interface {
+    // Get returns nil when the key doesn't exist
+    Get(ctx context.Context, key string) ([]byte, error)
+    // MGet returns the values in the order of the keys, nil for the keys that don't exist
+    MGet(ctx context.Context, keys ...string) ([][]byte, error)
+    Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+    Incr(ctx context.Context, key string) (int64, error)
+}
+
+// RedisClient implements Cache with go-redis over a pool of connections
+type RedisClient struct {
+    client *redis.Client
+}
+
+// NewRedisClient creates a client for a redis:// or rediss:// url, connections are opened on first use
+func NewRedisClient(rawURL string) (*RedisClient, error) {
+    options, err := redis.ParseURL(rawURL)
+    if err != nil {
+        return nil, errors.Join(ErrInvalidRedisURL, err)
+    }
+    // the options of the url take precedence
+    for _, timeout := range []*time.Duration{&options.DialTimeout, &options.ReadTimeout, &options.WriteTimeout} {
+        if *timeout == 0 {
+            *timeout = redisTimeout
+        }
+    }
+    if options.MaxIdleConns == 0 {
+        options.MaxIdleConns = redisPoolSize
+    }
+    // the deadlines of the contexts bound the commands as well
+    options.ContextTimeoutEnabled = true
+    return &RedisClient{client: redis.NewClient(options)}, nil
+}
+
+func (c *RedisClient) Get(ctx context.Context, key string) ([]byte, error) {
+    value, err := c.client.Get(ctx, key).Bytes()
+    if errors.Is(err, redis.Nil) {
+        return nil, nil
+    }
+    return value, err
+}
+
+func (c *RedisClient) MGet(ctx context.Context, keys ...string) ([][]byte, error) {
+    if len(keys) == 0 {
+        return nil, nil
+    }
+    items, err := c.client.MGet(ctx, keys...).Result()
+    if err != nil {
+        return nil, err
+    }
+    if len(items) != len(keys) {
+        return nil, ErrUnexpectedReply
+    }
+    values := make([][]byte, len(items))
+    for i, item := range items {
+        switch value := item.(type) {
+        case nil:
+        case string:
+            values[i] = []byte(value)
+        default:
+            return nil, ErrUnexpectedReply
+        }
+    }
+    return values, nil
+}
+
+func (c *RedisClient) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+    return c.client.Set(ctx, key, value, ttl).Err()
+}
+
+func (c *RedisClient) Incr(ctx context.Context, key string) (int64, error) {
+    return c.client.Incr(ctx, key).Result()
+}
+
+// Ping checks that the server is reachable
+func (c *RedisClient) Ping(ctx context.Context) error {
+    return c.client.Ping(ctx).Err()
+}
+
+// Close closes the connections
+func (c *RedisClient) Close() error {
+    return c.client.Close()
+}
@@ -0,0 +1,130 @@
+package cache
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/alicebob/miniredis/v2"
+)
+
+func newTestRedisClient(t *testing.T) (*RedisClient, *miniredis.Miniredis) {
+    server := miniredis.RunT(t)
+    client, err := NewRedisClient("redis://" + server.Addr() + "/0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(
+        func() {
+            _ = client.Close()
+        },
+    )
+    return client, server
+}
+
+func TestRedisClient(t *testing.T) {
+    client, server := newTestRedisClient(t)
+    ctx := context.Background()
+
+    if err := client.Ping(ctx); err != nil {
+        t.Fatalf("Should reach the server, got %v", err)
+    }
+    if value, err := client.Get(ctx, "missing"); err != nil || value != nil {
+        t.Fatalf("Should read a missing key as nil, got %q %v", value, err)
+    }
+
+    if err := client.Set(ctx, "latest", []byte(`{"location":"Yangon"}`), time.Minute); err != nil {
+        t.Fatal(err)
+    }
+    if value, err := client.Get(ctx, "latest"); err != nil || string(value) != `{"location":"Yangon"}` {
+        t.Fatalf("Should read the value back, got %q %v", value, err)
+    }
+    if ttl := server.TTL("latest"); ttl != time.Minute {
+        t.Fatalf("Should set the ttl, got %v", ttl)
+    }
+    server.FastForward(time.Minute)
+    if value, err := client.Get(ctx, "latest"); err != nil || value != nil {
+        t.Fatalf("Should expire the key, got %q %v", value, err)
+    }
+
+    if err := client.Set(ctx, "a", []byte("1"), 0); err != nil {
+        t.Fatal(err)
+    }
+    if ttl := server.TTL("a"); ttl != 0 {
+        t.Fatalf("Should keep a key without a ttl, got %v", ttl)
+    }
+    if err := client.Set(ctx, "c", []byte{}, 0); err != nil {
+        t.Fatal(err)
+    }
+    values, err := client.MGet(ctx, "a", "b", "c")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(values) != 3 || string(values[0]) != "1" || values[1] != nil || values[2] == nil || len(values[2]) != 0 {
+        t.Fatalf("Should read the values in the order of the keys, got %q", values)
+    }
+    if values, err = client.MGet(ctx); err != nil || values != nil {
+        t.Fatalf("Should read no keys without a command, got %q %v", values, err)
+    }
+
+    for expected := int64(1); expected <= 2; expected++ {
+        if value, err := client.Incr(ctx, "generation"); err != nil || value != expected {
+            t.Fatalf("Should increment to %d, got %d %v", expected, value, err)
+        }
+    }
+    if value, err := client.Incr(ctx, "a"); err != nil || value != 2 {
+        t.Fatalf("Should increment a numeric value, got %d %v", value, err)
+    }
+    if err := client.Set(ctx, "text", []byte("Yangon"), 0); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := client.Incr(ctx, "text"); err == nil {
+        t.Fatal("Should return the error reply of the server")
+    }
+}
+
+func TestRedisClient_Unavailable(t *testing.T) {
+    client, server := newTestRedisClient(t)
+    server.Close()
+
+    ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+    defer cancel()
+    if _, err := client.Get(ctx, "latest"); err == nil {
+        t.Fatal("Should fail when the server is unavailable")
+    }
+}
+
+func TestNewRedisClient(t *testing.T) {
+    client, err := NewRedisClient("redis://:secret@localhost/2")
+    if err != nil {
+        t.Fatal(err)
+    }
+    options := client.client.Options()
+    if options.Addr != "localhost:6379" || options.Password != "secret" || options.DB != 2 {
+        t.Fatalf("Should parse the url, got %s %s %d", options.Addr, options.Password, options.DB)
+    }
+    if options.TLSConfig != nil || options.DialTimeout != redisTimeout || options.MaxIdleConns != redisPoolSize {
+        t.Fatalf("Should connect without TLS with the default timeouts, got %+v", options)
+    }
+
+    client, err = NewRedisClient("redis://localhost?dial_timeout=5s&max_idle_conns=4")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if options = client.client.Options(); options.DialTimeout != 5*time.Second || options.ReadTimeout != redisTimeout ||
+        options.MaxIdleConns != 4 {
+        t.Fatalf("Should apply the options of the url, got %+v", options)
+    }
+
+    client, err = NewRedisClient("rediss://cache.example.com:6380")
+    if err != nil || client.client.Options().TLSConfig == nil {
+        t.Fatalf("Should connect with TLS, got %v", err)
+    }
+
+    for _, rawURL := range []string{"http://localhost", "redis://localhost/db"} {
+        if _, err := NewRedisClient(rawURL); !errors.Is(err, ErrInvalidRedisURL) {
+            t.Fatalf("Should reject %s, got %v", rawURL, err)
+        }
+    }
+}
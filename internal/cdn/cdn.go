@@ -0,0 +1,94 @@
+package cdn
+
+import (
+    "context"
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+)
+
+const (
+    // SurrogateKeyHeader lists the surrogate keys of a response, separated by spaces, a CDN purges the cached
+    // responses by these keys
+    SurrogateKeyHeader = "Surrogate-Key"
+    // AllKey is a key of every cacheable response, purging it empties the whole cache
+    AllKey = "tracking-data"
+)
+
+// VehicleKey is the surrogate key of the responses about a single vehicle
+func VehicleKey(vehicleID string) string {
+    return "vehicle-" + vehicleID
+}
+
+// FleetKey is the surrogate key of the responses about all vehicles of the tenant, tenantID is empty without
+// multi-tenancy
+func FleetKey(tenantID string) string {
+    if tenantID == "" {
+        return "fleet"
+    }
+    return "fleet-" + tenantID
+}
+
+// Policy decides which queries are immutable. A query is immutable once its period ended Settle ago, readings
+// taken before then that are stored, flagged or deleted later are corrections that purge the cached responses.
+type Policy struct {
+    MaxAge time.Duration
+    Settle time.Duration
+}
+
+// Immutable reports whether a query ending at to no longer changes, except for corrections
+func (p Policy) Immutable(to, now time.Time) bool {
+    return !to.IsZero() && !to.After(now.Add(-p.Settle))
+}
+
+// Correction reports whether a change to a reading taken at createdAt may change immutable responses
+func (p Policy) Correction(createdAt, now time.Time) bool {
+    return createdAt.Before(now.Add(-p.Settle))
+}
+
+// CacheControl is the Cache-Control header of the immutable responses
+func (p Policy) CacheControl() string {
+    return fmt.Sprintf("public, max-age=%d, immutable", int64(p.MaxAge/time.Second))
+}
+
+// Purger purges the cached responses with any of the surrogate keys
+type Purger interface {
+    Purge(ctx context.Context, keys ...string) error
+}
+
+// HTTPPurger asks a CDN purge endpoint to purge the keys. The keys are sent in the Surrogate-Key header of a
+// POST request, like the batch purge of Fastly, and a non 2xx response is an error.
+type HTTPPurger struct {
+    client *http.Client
+    url    string
+    // token is sent as a bearer token when it is set
+    token string
+}
+
+func NewHTTPPurger(client *http.Client, url string, token string) *HTTPPurger {
+    return &HTTPPurger{client: client, url: url, token: token}
+}
+
+func (p *HTTPPurger) Purge(ctx context.Context, keys ...string) error {
+    if len(keys) == 0 {
+        return nil
+    }
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, nil)
+    if err != nil {
+        return err
+    }
+    req.Header.Set(SurrogateKeyHeader, strings.Join(keys, " "))
+    if p.token != "" {
+        req.Header.Set("Authorization", "Bearer "+p.token)
+    }
+    res, err := p.client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer res.Body.Close()
+    if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusMultipleChoices {
+        return fmt.Errorf("cdn purge endpoint responded with %s", res.Status)
+    }
+    return nil
+}
@@ -0,0 +1,86 @@
+package cdn
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+func TestPolicy_Immutable(t *testing.T) {
+    policy := Policy{MaxAge: 24 * time.Hour, Settle: time.Hour}
+    now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+    for _, tc := range []struct {
+        name string
+        to   time.Time
+        want bool
+    }{
+        {name: "no end", want: false},
+        {name: "ends now", to: now, want: false},
+        {name: "ends within the settle time", to: now.Add(-30 * time.Minute), want: false},
+        {name: "ends at the settle time", to: now.Add(-time.Hour), want: true},
+        {name: "ends before the settle time", to: now.Add(-48 * time.Hour), want: true},
+    } {
+        t.Run(
+            tc.name, func(t *testing.T) {
+                if got := policy.Immutable(tc.to, now); got != tc.want {
+                    t.Errorf("Immutable() = %v, want %v", got, tc.want)
+                }
+            },
+        )
+    }
+
+    if policy.Correction(now.Add(-30*time.Minute), now) {
+        t.Error("a reading within the settle time must not be a correction")
+    }
+    if !policy.Correction(now.Add(-2*time.Hour), now) {
+        t.Error("a reading before the settle time must be a correction")
+    }
+    if got, want := policy.CacheControl(), "public, max-age=86400, immutable"; got != want {
+        t.Errorf("CacheControl() = %q, want %q", got, want)
+    }
+}
+
+func TestKeys(t *testing.T) {
+    if got := VehicleKey("6721f0c2a1b2c3d4e5f60718"); got != "vehicle-6721f0c2a1b2c3d4e5f60718" {
+        t.Errorf("VehicleKey() = %q", got)
+    }
+    if got := FleetKey(""); got != "fleet" {
+        t.Errorf("FleetKey() = %q", got)
+    }
+    if got := FleetKey("acme"); got != "fleet-acme" {
+        t.Errorf("FleetKey() = %q", got)
+    }
+}
+
+func TestHTTPPurger_Purge(t *testing.T) {
+    var keys, authorization string
+    status := http.StatusOK
+    server := httptest.NewServer(
+        http.HandlerFunc(
+            func(w http.ResponseWriter, r *http.Request) {
+                keys, authorization = r.Header.Get(SurrogateKeyHeader), r.Header.Get("Authorization")
+                w.WriteHeader(status)
+            },
+        ),
+    )
+    defer server.Close()
+
+    purger := NewHTTPPurger(server.Client(), server.URL, "secret")
+    if err := purger.Purge(context.Background(), "vehicle-1", "fleet"); err != nil {
+        t.Fatalf("Purge() error = %v", err)
+    }
+    if keys != "vehicle-1 fleet" {
+        t.Errorf("Surrogate-Key = %q, want %q", keys, "vehicle-1 fleet")
+    }
+    if authorization != "Bearer secret" {
+        t.Errorf("Authorization = %q", authorization)
+    }
+
+    status = http.StatusInternalServerError
+    if err := purger.Purge(context.Background(), "fleet"); err == nil {
+        t.Error("Purge() must fail when the endpoint fails")
+    }
+}
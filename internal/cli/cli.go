@@ -0,0 +1,89 @@
+package cli
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "slices"
+    "strings"
+    "text/tabwriter"
+)
+
+var (
+    ErrUnknownCommand = errors.New("unknown command")
+)
+
+// helpFlags ask for the usage in place of a command
+var helpFlags = []string{"-h", "-help", "--help"}
+
+// Command is a subcommand of the binary, it parses its own flags from the arguments after its name
+type Command struct {
+    Name string
+    // Aliases are the other names of the command, e.g. the one it had before
+    Aliases []string
+    Summary string
+    Run     func(ctx context.Context, args []string) error
+}
+
+// CLI runs the command named by the first argument, the default command runs without arguments or when the first
+// argument is a flag
+type CLI struct {
+    program  string
+    commands []*Command
+    fallback string
+    output   io.Writer
+}
+
+func New(program string, fallback string, output io.Writer, commands ...*Command) *CLI {
+    return &CLI{program: program, commands: commands, fallback: fallback, output: output}
+}
+
+// Run runs the command of the arguments, help and the help flags in place of a command write the usage instead
+func (c *CLI) Run(ctx context.Context, args []string) error {
+    if len(args) > 0 && (args[0] == "help" || slices.Contains(helpFlags, args[0])) {
+        c.Usage()
+        return nil
+    }
+    name := c.fallback
+    if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+        name, args = args[0], args[1:]
+    }
+    command := c.Find(name)
+    if command == nil {
+        c.Usage()
+        return fmt.Errorf("%w: %s", ErrUnknownCommand, name)
+    }
+    if err := command.Run(ctx, args); err != nil {
+        return fmt.Errorf("%s: %w", command.Name, err)
+    }
+    return nil
+}
+
+// Find returns the command of the name or alias, nil when there is none
+func (c *CLI) Find(name string) *Command {
+    for _, command := range c.commands {
+        if command.Name == name || slices.Contains(command.Aliases, name) {
+            return command
+        }
+    }
+    return nil
+}
+
+// Usage writes the commands with their summaries
+func (c *CLI) Usage() {
+    _, _ = fmt.Fprintf(c.output, "Usage: %s [command] [flags]\n\nCommands:\n", c.program)
+    w := tabwriter.NewWriter(c.output, 0, 4, 2, ' ', 0)
+    for _, command := range c.commands {
+        summary := command.Summary
+        if command.Name == c.fallback {
+            summary += " (default)"
+        }
+        if len(command.Aliases) > 0 {
+            summary += fmt.Sprintf(" (alias: %s)", strings.Join(command.Aliases, ", "))
+        }
+        _, _ = fmt.Fprintf(w, "  %s\t%s\n", command.Name, summary)
+    }
+    _ = w.Flush()
+    _, _ = fmt.Fprintf(c.output, "\nRun '%s [command] -h' for the flags of a command.\n", c.program)
+}
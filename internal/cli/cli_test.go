@@ -0,0 +1,56 @@
+package cli
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "strings"
+    "testing"
+)
+
+func TestCLI(t *testing.T) {
+    var ran []string
+    command := func(name string) func(context.Context, []string) error {
+        return func(_ context.Context, args []string) error {
+            ran = append(ran, name+" "+strings.Join(args, " "))
+            return nil
+        }
+    }
+    var output bytes.Buffer
+    c := New(
+        "tracking-svc",
+        "serve",
+        &output,
+        &Command{Name: "serve", Summary: "Run the service", Run: command("serve")},
+        &Command{Name: "replay", Aliases: []string{"replay-dlq"}, Summary: "Replay messages", Run: command("replay")},
+        &Command{
+            Name:    "purge",
+            Summary: "Purge tracking data",
+            Run: func(context.Context, []string) error {
+                return errors.New("vehicle_id is required")
+            },
+        },
+    )
+
+    ctx := context.Background()
+    for _, args := range [][]string{nil, {"-debug"}, {"replay-dlq", "--from", "tracking.dlq"}} {
+        if err := c.Run(ctx, args); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if strings.Join(ran, "|") != "serve |serve -debug|replay --from tracking.dlq" {
+        t.Errorf("expected the default command and the alias to run, got %q", ran)
+    }
+
+    if err := c.Run(ctx, []string{"purge"}); err == nil || err.Error() != "purge: vehicle_id is required" {
+        t.Errorf("expected the error of the command, got %v", err)
+    }
+    if err := c.Run(ctx, []string{"backfill"}); !errors.Is(err, ErrUnknownCommand) {
+        t.Errorf("expected ErrUnknownCommand, got %v", err)
+    }
+    output.Reset()
+    if err := c.Run(ctx, []string{"--help"}); err != nil || !strings.Contains(output.String(), "replay-dlq") ||
+        !strings.Contains(output.String(), "Run the service (default)") {
+        t.Errorf("expected the usage, got %v, %q", err, output.String())
+    }
+}
@@ -0,0 +1,130 @@
+package config
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strconv"
+    "strings"
+
+    "github.com/BurntSushi/toml"
+    "gopkg.in/yaml.v3"
+)
+
+var (
+    ErrUnsupportedConfigFile = errors.New("unsupported config file, it must be .yaml, .yml or .toml")
+    ErrInvalidConfigFile     = errors.New("invalid config file")
+)
+
+// Load reads the config from a YAML or TOML file with the variables as keys, environment variables that are set
+// override the file so deployments can keep secrets out of it
+func Load(path string) (*EnvConfig, error) {
+    values, err := LoadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    cfg := &EnvConfig{}
+    v := reflect.ValueOf(cfg).Elem()
+    for i := 0; i < v.NumField(); i++ {
+        field := v.Type().Field(i)
+        name := field.Tag.Get("json")
+        if name == "" || field.Type.Kind() != reflect.String {
+            continue
+        }
+        value, ok := values[name]
+        if env, set := os.LookupEnv(name); set && env != "" {
+            value, ok = env, true
+        }
+        if ok {
+            v.Field(i).SetString(value)
+        }
+    }
+    return cfg, nil
+}
+
+// LoadFile reads the variables of a YAML or TOML file, the keys are case-insensitive and lists are joined with
+// commas like the comma separated variables
+func LoadFile(path string) (map[string]string, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    var values map[string]string
+    switch strings.ToLower(filepath.Ext(path)) {
+    case ".yaml", ".yml":
+        values, err = parseYAML(data)
+    case ".toml":
+        values, err = parseTOML(data)
+    default:
+        return nil, fmt.Errorf("%w: %s", ErrUnsupportedConfigFile, path)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("%w: %s: %w", ErrInvalidConfigFile, path, err)
+    }
+    return values, nil
+}
+
+func parseYAML(data []byte) (map[string]string, error) {
+    var document map[string]any
+    if err := yaml.Unmarshal(data, &document); err != nil {
+        return nil, err
+    }
+    return flatten(document)
+}
+
+// parseTOML reads a TOML document, it must be flat since the config has no tables
+func parseTOML(data []byte) (map[string]string, error) {
+    var document map[string]any
+    if err := toml.Unmarshal(data, &document); err != nil {
+        return nil, err
+    }
+    return flatten(document)
+}
+
+// flatten formats the values of a document by their uppercase keys, a key defined more than once in different cases
+// is ambiguous
+func flatten(document map[string]any) (map[string]string, error) {
+    values := make(map[string]string, len(document))
+    for key, value := range document {
+        text, err := scalarText(value)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", key, err)
+        }
+        key = strings.ToUpper(key)
+        if _, duplicate := values[key]; duplicate {
+            return nil, fmt.Errorf("%s is defined more than once", key)
+        }
+        values[key] = text
+    }
+    return values, nil
+}
+
+// scalarText formats a scalar or a list of scalars of a document
+func scalarText(value any) (string, error) {
+    switch value := value.(type) {
+    case nil:
+        return "", nil
+    case string:
+        return value, nil
+    case bool, int, int64, uint64:
+        return fmt.Sprint(value), nil
+    case float64:
+        return strconv.FormatFloat(value, 'f', -1, 64), nil
+    case []any:
+        items := make([]string, 0, len(value))
+        for _, item := range value {
+            if _, ok := item.([]any); ok {
+                return "", errors.New("nested lists are not supported")
+            }
+            text, err := scalarText(item)
+            if err != nil {
+                return "", err
+            }
+            items = append(items, text)
+        }
+        return strings.Join(items, ","), nil
+    }
+    return "", errors.New("only scalars and lists are supported, the config is flat")
+}
@@ -0,0 +1,129 @@
+package config
+
+import (
+    "errors"
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+)
+
+func writeConfigFile(t *testing.T, name string, content string) string {
+    path := filepath.Join(t.TempDir(), name)
+    if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+        t.Fatal(err)
+    }
+    return path
+}
+
+func TestLoad_YAML(t *testing.T) {
+    path := writeConfigFile(
+        t,
+        "config.yaml",
+        `
+database_url: mongodb://mongo:27017
+PORT: 8081
+CONSUMER_WORKERS: 4
+TRACKING_ENCRYPTION: false
+REPORT_PERIODS: [daily, weekly]
+`,
+    )
+    t.Setenv("PORT", "9090")
+
+    cfg, err := Load(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if cfg.DatabaseURL != "mongodb://mongo:27017" || cfg.ConsumerWorkers != "4" || cfg.TrackingEncryption != "false" {
+        t.Errorf("expected the values of the file, got %+v", cfg)
+    }
+    if cfg.ReportPeriods != "daily,weekly" {
+        t.Errorf("expected the list joined with commas, got %q", cfg.ReportPeriods)
+    }
+    if cfg.Port != "9090" {
+        t.Errorf("expected the environment to override the file, got %q", cfg.Port)
+    }
+
+    path = writeConfigFile(t, "nested.yml", "DATABASE_URL:\n  host: mongo\n")
+    if _, err = Load(path); !errors.Is(err, ErrInvalidConfigFile) {
+        t.Errorf("expected nested values to be invalid, got %v", err)
+    }
+}
+
+func TestLoad_TOML(t *testing.T) {
+    path := writeConfigFile(
+        t,
+        "config.toml",
+        `
+# tracking service
+DATABASE_URL = "mongodb://mongo:27017" # inline comment
+ALERTS_QUEUE = 'alerts\queue'
+MAINTENANCE_INTERVAL = 10_000
+REPORT_PERIODS = ["daily", "weekly"]
+`,
+    )
+
+    cfg, err := Load(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if cfg.DatabaseURL != "mongodb://mongo:27017" || cfg.AlertsQueue != `alerts\queue` {
+        t.Errorf("expected the strings of the file, got %+v", cfg)
+    }
+    if cfg.MaintenanceInterval != "10000" || cfg.ReportPeriods != "daily,weekly" {
+        t.Errorf("expected the number and array of the file, got %+v", cfg)
+    }
+
+    // the syntax of TOML beyond key = value lines
+    path = writeConfigFile(
+        t,
+        "syntax.toml",
+        `
+"database_url" = """
+mongodb://mongo:27017"""
+REPORT_PERIODS = [
+  "daily", # the default
+  'weekly',
+]
+CONSUMER_WORKERS = 0x10
+SPEED_LIMIT_KMH = 8.25e1
+TRACKING_ENCRYPTION = true
+`,
+    )
+    if cfg, err = Load(path); err != nil {
+        t.Fatal(err)
+    }
+    if cfg.DatabaseURL != "mongodb://mongo:27017" || cfg.ReportPeriods != "daily,weekly" ||
+        cfg.ConsumerWorkers != "16" || cfg.SpeedLimitKmh != "82.5" || cfg.TrackingEncryption != "true" {
+        t.Errorf("expected the values of the TOML syntax, got %+v", cfg)
+    }
+
+    for name, content := range map[string]string{
+        "table.toml":        "[server]\nPORT = 8080\n",
+        "inline-table.toml": "PORT = { value = 8080 }\n",
+        "unquoted.toml":     "ALERTS_QUEUE = alerts\n",
+        "duplicate.toml":    "PORT = 1\nPORT = 2\n",
+        "case.toml":         "PORT = 1\nport = 2\n",
+        "case.yaml":         "PORT: 1\nport: 2\n",
+    } {
+        if _, err = Load(writeConfigFile(t, name, content)); !errors.Is(err, ErrInvalidConfigFile) {
+            t.Errorf("%s: expected ErrInvalidConfigFile, got %v", name, err)
+        }
+    }
+    if _, err = Load(writeConfigFile(t, "config.json", "{}")); !errors.Is(err, ErrUnsupportedConfigFile) {
+        t.Errorf("expected ErrUnsupportedConfigFile, got %v", err)
+    }
+}
+
+func TestChanged(t *testing.T) {
+    previous := &EnvConfig{Port: "8080", ConsumerWorkers: "4", LogLevel: "info"}
+    next := &EnvConfig{Port: "8081", ConsumerWorkers: "8", LogLevel: "debug"}
+
+    reloaded, restart := previous.Changed(next)
+    if !reflect.DeepEqual(reloaded, []string{"CONSUMER_WORKERS", "LOG_LEVEL"}) {
+        t.Errorf("expected the tunables to be reloaded, got %v", reloaded)
+    }
+    if !reflect.DeepEqual(restart, []string{"PORT"}) {
+        t.Errorf("expected the port to need a restart, got %v", restart)
+    }
+}
@@ -0,0 +1,211 @@
+package config
+
+import (
+    "errors"
+    "fmt"
+    "reflect"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/go-playground/validator/v10"
+)
+
+const (
+    DefaultHost     = "0.0.0.0"
+    DefaultPort     = "8080"
+    DefaultPageSize = 10
+    MaxPageSize     = 100
+)
+
+var (
+    ErrInvalidConfig = errors.New("invalid config")
+)
+
+// SetDefaults fills in the variables that have a sensible default
+func (c *EnvConfig) SetDefaults() {
+    if c.Host == "" {
+        c.Host = DefaultHost
+    }
+    if c.Port == "" {
+        c.Port = DefaultPort
+    }
+    if c.DefaultPageSize == "" {
+        c.DefaultPageSize = strconv.Itoa(DefaultPageSize)
+    }
+    if c.MaxPageSize == "" {
+        c.MaxPageSize = strconv.Itoa(MaxPageSize)
+    }
+}
+
+// Validate sets the defaults and checks every variable, the returned error lists all the invalid variables
+// at once instead of failing on the first one used
+func (c *EnvConfig) Validate() error {
+    c.SetDefaults()
+
+    validate := validator.New(validator.WithRequiredStructEnabled())
+    // errors name the variables instead of the fields
+    validate.RegisterTagNameFunc(
+        func(field reflect.StructField) string {
+            return field.Tag.Get("json")
+        },
+    )
+
+    var errs []error
+    var validationErrs validator.ValidationErrors
+    if err := validate.Struct(c); errors.As(err, &validationErrs) {
+        for _, fieldErr := range validationErrs {
+            errs = append(errs, describe(fieldErr))
+        }
+    } else if err != nil {
+        return err
+    }
+
+    // the durations are checked here, the config loader validates with a validator that has no duration tag
+    for _, variable := range []struct {
+        name      string
+        value     string
+        allowZero bool
+    }{
+        {name: "REPORT_DELAY", value: c.ReportDelay, allowZero: true},
+        {name: "DEPLOY_BASELINE_LATENCY_P95", value: c.DeployBaselineLatencyP95},
+        {name: "EXPECTED_REPORT_INTERVAL", value: c.ExpectedReportInterval},
+        {name: "INACTIVITY_CHECK_INTERVAL", value: c.InactivityCheckInterval},
+        {name: "WEBHOOK_TIMEOUT", value: c.WebhookTimeout},
+        {name: "WEBHOOK_RETRY_BACKOFF", value: c.WebhookRetryBackoff},
+        {name: "STALE_AFTER", value: c.StaleAfter},
+        {name: "STALE_CHECK_INTERVAL", value: c.StaleCheckInterval},
+        {name: "IDLE_MIN_DURATION", value: c.IdleMinDuration},
+        {name: "NOTIFICATION_TIMEOUT", value: c.NotificationTimeout},
+        {name: "CACHE_TTL", value: c.CacheTTL},
+        {name: "CONFIG_RELOAD_INTERVAL", value: c.ConfigReloadInterval},
+        {name: "SHUTDOWN_TIMEOUT", value: c.ShutdownTimeout},
+        {name: "MESSAGE_TIMEOUT", value: c.MessageTimeout},
+        {name: "PUBLISH_TIMEOUT", value: c.PublishTimeout},
+        {name: "MONGO_TIMEOUT", value: c.MongoTimeout},
+        {name: "CACHE_PRIME_WINDOW", value: c.CachePrimeWindow, allowZero: true},
+        {name: "CACHE_PRIME_TIMEOUT", value: c.CachePrimeTimeout},
+        {name: "POLICY_CACHE_TTL", value: c.PolicyCacheTTL, allowZero: true},
+        {name: "VEHICLE_CACHE_TTL", value: c.VehicleCacheTTL, allowZero: true},
+        {name: "GEOCODING_CACHE_TTL", value: c.GeocodingCacheTTL, allowZero: true},
+        {name: "HISTORICAL_CACHE_MAX_AGE", value: c.HistoricalCacheMaxAge, allowZero: true},
+        {name: "HISTORICAL_CACHE_SETTLE", value: c.HistoricalCacheSettle},
+        {name: "CLICKHOUSE_FLUSH_INTERVAL", value: c.ClickHouseFlushInterval},
+        {name: "INGEST_BUFFER_MAX_WAIT", value: c.IngestBufferMaxWait},
+        {name: "ROLLUP_INTERVAL", value: c.RollupInterval},
+        {name: "ROLLUP_DELAY", value: c.RollupDelay},
+        {name: "ROLLUP_5M_AFTER", value: c.Rollup5mAfter},
+        {name: "ROLLUP_1H_AFTER", value: c.Rollup1hAfter},
+        {name: "CLOCK_SKEW_TOLERANCE", value: c.ClockSkewTolerance},
+        {name: "IDEMPOTENCY_KEY_TTL", value: c.IdempotencyKeyTTL},
+    } {
+        if variable.value == "" {
+            continue
+        }
+        duration, err := time.ParseDuration(variable.value)
+        if err != nil || duration < 0 || (duration == 0 && !variable.allowZero) {
+            errs = append(
+                errs,
+                fmt.Errorf("%s must be a positive duration like 30s or 5m, got %q", variable.name, variable.value),
+            )
+        }
+    }
+    if c.PublicStatsPartnerKeys != "" {
+        for _, pair := range strings.Split(c.PublicStatsPartnerKeys, ",") {
+            if partner, key, ok := strings.Cut(strings.TrimSpace(pair), ":"); !ok || partner == "" || key == "" {
+                errs = append(
+                    errs,
+                    errors.New("PUBLIC_STATS_PARTNER_KEYS must be a comma separated list of partner:api-key"),
+                )
+                break
+            }
+        }
+    }
+    for _, variable := range []struct {
+        name  string
+        value float64
+    }{
+        {name: "PUBLIC_STATS_EPSILON", value: c.PublicStatsEpsilonValue()},
+        {name: "PUBLIC_STATS_ZONE_DEGREES", value: c.PublicStatsZoneDegreesValue()},
+        {name: "GEOCODING_RATE", value: c.GeocodingRateValue()},
+        {name: "SAFETY_SCORE_CHANGE", value: c.SafetyScoreChangeValue()},
+        {name: "IDLE_FUEL_RATE", value: c.IdleFuelRateValue()},
+        {name: "DATA_QUALITY_MAX_SPEED", value: c.DataQualityMaxSpeedValue()},
+    } {
+        if variable.value <= 0 {
+            errs = append(errs, fmt.Errorf("%s must be greater than 0", variable.name))
+        }
+    }
+    // the longest window the safety scores can be queried for
+    if c.SafetyScoreDaysValue() > 90 {
+        errs = append(errs, errors.New("SAFETY_SCORE_DAYS must be at most 90"))
+    }
+    if c.DefaultPageSizeValue() > c.MaxPageSizeValue() {
+        errs = append(errs, errors.New("DEFAULT_PAGE_SIZE must not be greater than MAX_PAGE_SIZE"))
+    }
+
+    if len(errs) == 0 {
+        return nil
+    }
+    return fmt.Errorf("%w:\n%w", ErrInvalidConfig, errors.Join(errs...))
+}
+
+// DefaultPageSizeValue returns the page size of list queries without a limit, 10 by default
+func (c *EnvConfig) DefaultPageSizeValue() int {
+    return parsePositiveInt(c.DefaultPageSize, DefaultPageSize)
+}
+
+// MaxPageSizeValue returns the largest page size of list queries, 100 by default
+func (c *EnvConfig) MaxPageSizeValue() int {
+    return parsePositiveInt(c.MaxPageSize, MaxPageSize)
+}
+
+func parsePositiveInt(value string, fallback int) int {
+    parsed, err := strconv.Atoi(value)
+    if err != nil || parsed <= 0 {
+        return fallback
+    }
+    return parsed
+}
+
+// describe turns a failed validation into a message naming the variable
+func describe(err validator.FieldError) error {
+    name := err.Field()
+    switch err.Tag() {
+    case "required":
+        return fmt.Errorf("%s is required", name)
+    case "required_with":
+        return fmt.Errorf("%s is required when %s is set", name, variableNames(err.Param()))
+    case "required_if":
+        return fmt.Errorf("%s is required when %s", name, requiredIfCondition(err.Param()))
+    case "oneof":
+        return fmt.Errorf("%s must be one of %s, got %q", name, strings.ReplaceAll(err.Param(), " ", ", "), err.Value())
+    case "number":
+        return fmt.Errorf("%s must be a number, got %q", name, err.Value())
+    case "url":
+        return fmt.Errorf("%s must be a url", name)
+    }
+    return fmt.Errorf("%s is invalid: %s", name, err.Tag())
+}
+
+// variableNames maps the field names of a validation parameter to their variable names
+func variableNames(fields string) string {
+    t := reflect.TypeOf(EnvConfig{})
+    var names []string
+    for _, field := range strings.Fields(fields) {
+        if structField, ok := t.FieldByName(field); ok {
+            field = structField.Tag.Get("json")
+        }
+        names = append(names, field)
+    }
+    return strings.Join(names, ", ")
+}
+
+// requiredIfCondition describes the condition of a required_if parameter with variable names
+func requiredIfCondition(param string) string {
+    fields := strings.Fields(param)
+    if len(fields) != 2 {
+        return param
+    }
+    return fmt.Sprintf("%s is %s", variableNames(fields[0]), fields[1])
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+    "context"
+    "log"
+    "os"
+    "time"
+)
+
+// Watch checks the config file every interval and calls onReload with the previous and the new config when it
+// changed. A file that can't be read or isn't valid is logged and skipped, the previous config stays in use.
+func Watch(
+    ctx context.Context,
+    path string,
+    current *EnvConfig,
+    interval time.Duration,
+    onReload func(previous *EnvConfig, next *EnvConfig),
+) {
+    modTime, size := fileVersion(path)
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+
+        changedAt, changedSize := fileVersion(path)
+        if changedAt.Equal(modTime) && changedSize == size {
+            continue
+        }
+        modTime, size = changedAt, changedSize
+
+        next, err := Load(path)
+        if err == nil {
+            err = next.Validate()
+        }
+        if err != nil {
+            log.Println("Failed to reload config, keeping the previous one: ", err)
+            continue
+        }
+        onReload(current, next)
+        current = next
+    }
+}
+
+// fileVersion returns the modification time and size of a file, editors that replace the file in place may
+// keep the time so the size is compared too
+func fileVersion(path string) (time.Time, int64) {
+    info, err := os.Stat(path)
+    if err != nil {
+        return time.Time{}, -1
+    }
+    return info.ModTime(), info.Size()
+}
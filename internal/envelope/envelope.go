@@ -0,0 +1,181 @@
+package envelope
+
+import (
+    "context"
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/rand"
+    "encoding/base64"
+    "errors"
+    "fmt"
+    "strings"
+
+    amqp "github.com/rabbitmq/amqp091-go"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/secrets"
+)
+
+const (
+    // Algorithm encrypts the data keys and the bodies
+    Algorithm = "AES-256-GCM"
+
+    // The AMQP headers of encrypted messages, the body is the nonce followed by the ciphertext
+    HeaderAlgorithm = "encryption"
+    HeaderKeyID     = "encryption_key_id"
+    HeaderDataKey   = "encryption_data_key"
+
+    keySize = 32
+)
+
+var (
+    ErrUnsupportedAlgorithm = errors.New("unsupported encryption algorithm")
+    ErrInvalidKey           = errors.New("invalid encryption key, it must be 32 bytes encoded in base64")
+    ErrDecrypt              = errors.New("failed to decrypt message")
+    ErrNotEncrypted         = errors.New("message must be encrypted")
+)
+
+type keyIDContextKey struct{}
+
+// WithKeyID returns a context of the key the message was encrypted with, so messages published on its behalf
+// are encrypted with the same key
+func WithKeyID(ctx context.Context, keyID string) context.Context {
+    return context.WithValue(ctx, keyIDContextKey{}, keyID)
+}
+
+func KeyIDFromContext(ctx context.Context) (string, bool) {
+    keyID, ok := ctx.Value(keyIDContextKey{}).(string)
+    return keyID, ok && keyID != ""
+}
+
+// Sealed is an encrypted message, DataKey is the key of the body encrypted with the key KeyID
+type Sealed struct {
+    KeyID   string
+    DataKey []byte
+    Body    []byte
+}
+
+// Cipher does envelope encryption, every message is encrypted with a random data key which is encrypted with a
+// key of the tenant read from the secrets provider. The keys of a tenant are named <tenant>/<key id>, so a
+// message can only be decrypted with a key of its own tenant.
+type Cipher struct {
+    provider secrets.Provider
+}
+
+func NewCipher(provider secrets.Provider) *Cipher {
+    return &Cipher{provider: provider}
+}
+
+// Seal encrypts body with a new data key, tenantID is empty without multi-tenancy
+func (c *Cipher) Seal(ctx context.Context, tenantID, keyID string, body []byte) (*Sealed, error) {
+    kek, err := c.key(ctx, tenantID, keyID)
+    if err != nil {
+        return nil, err
+    }
+    dataKey := make([]byte, keySize)
+    if _, err = rand.Read(dataKey); err != nil {
+        return nil, err
+    }
+    wrapped, err := seal(kek, dataKey)
+    if err != nil {
+        return nil, err
+    }
+    sealed, err := seal(dataKey, body)
+    if err != nil {
+        return nil, err
+    }
+    return &Sealed{KeyID: keyID, DataKey: wrapped, Body: sealed}, nil
+}
+
+// Open decrypts the data key with the key of the tenant and the body with the data key
+func (c *Cipher) Open(ctx context.Context, tenantID string, sealed *Sealed) ([]byte, error) {
+    kek, err := c.key(ctx, tenantID, sealed.KeyID)
+    if err != nil {
+        return nil, err
+    }
+    dataKey, err := open(kek, sealed.DataKey)
+    if err != nil {
+        return nil, err
+    }
+    return open(dataKey, sealed.Body)
+}
+
+// key reads the key encryption key, stored in base64
+func (c *Cipher) key(ctx context.Context, tenantID, keyID string) ([]byte, error) {
+    name := keyID
+    if tenantID != "" {
+        name = tenantID + "/" + keyID
+    }
+    encoded, err := c.provider.Secret(ctx, name)
+    if err != nil {
+        return nil, err
+    }
+    key, err := base64.StdEncoding.DecodeString(string(encoded))
+    if err != nil || len(key) != keySize {
+        return nil, fmt.Errorf("%w: %s", ErrInvalidKey, name)
+    }
+    return key, nil
+}
+
+func seal(key, plaintext []byte) ([]byte, error) {
+    aead, err := newAEAD(key)
+    if err != nil {
+        return nil, err
+    }
+    nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+    if _, err = rand.Read(nonce); err != nil {
+        return nil, err
+    }
+    return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(key, ciphertext []byte) ([]byte, error) {
+    aead, err := newAEAD(key)
+    if err != nil {
+        return nil, err
+    }
+    if len(ciphertext) < aead.NonceSize() {
+        return nil, ErrDecrypt
+    }
+    plaintext, err := aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], nil)
+    if err != nil {
+        return nil, ErrDecrypt
+    }
+    return plaintext, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+    block, err := aes.NewCipher(key)
+    if err != nil {
+        return nil, err
+    }
+    return cipher.NewGCM(block)
+}
+
+// Headers returns the AMQP headers describing the sealed message
+func (s *Sealed) Headers() amqp.Table {
+    return amqp.Table{
+        HeaderAlgorithm: Algorithm,
+        HeaderKeyID:     s.KeyID,
+        HeaderDataKey:   base64.StdEncoding.EncodeToString(s.DataKey),
+    }
+}
+
+// FromMessage returns the sealed message of the AMQP headers and body, nil when the message isn't encrypted
+func FromMessage(headers amqp.Table, body []byte) (*Sealed, error) {
+    algorithm, ok := headers[HeaderAlgorithm].(string)
+    if !ok {
+        return nil, nil
+    }
+    if algorithm != Algorithm {
+        return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, algorithm)
+    }
+    keyID, _ := headers[HeaderKeyID].(string)
+    if err := secrets.ValidateName(keyID); err != nil || strings.Contains(keyID, "/") {
+        return nil, fmt.Errorf("%w: invalid %s", ErrDecrypt, HeaderKeyID)
+    }
+    encoded, _ := headers[HeaderDataKey].(string)
+    dataKey, err := base64.StdEncoding.DecodeString(encoded)
+    if err != nil || len(dataKey) == 0 {
+        return nil, fmt.Errorf("%w: invalid %s", ErrDecrypt, HeaderDataKey)
+    }
+    return &Sealed{KeyID: keyID, DataKey: dataKey, Body: body}, nil
+}
@@ -0,0 +1,74 @@
+package envelope
+
+import (
+    "bytes"
+    "context"
+    "crypto/rand"
+    "encoding/base64"
+    "errors"
+    "testing"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/secrets"
+)
+
+type memoryProvider map[string][]byte
+
+func (p memoryProvider) Secret(_ context.Context, name string) ([]byte, error) {
+    secret, ok := p[name]
+    if !ok {
+        return nil, secrets.ErrSecretNotFound
+    }
+    return secret, nil
+}
+
+func newKey(t *testing.T) []byte {
+    key := make([]byte, keySize)
+    if _, err := rand.Read(key); err != nil {
+        t.Fatal(err)
+    }
+    return []byte(base64.StdEncoding.EncodeToString(key))
+}
+
+func TestCipher(t *testing.T) {
+    ctx := context.Background()
+    c := NewCipher(memoryProvider{"acme/default": newKey(t), "other/default": newKey(t)})
+    body := []byte(`{"vehicle_id":"1","location":"16.8,96.1"}`)
+
+    sealed, err := c.Seal(ctx, "acme", "default", body)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if bytes.Contains(sealed.Body, []byte("location")) {
+        t.Fatal("expected the body to be encrypted")
+    }
+
+    received, err := FromMessage(sealed.Headers(), sealed.Body)
+    if err != nil {
+        t.Fatal(err)
+    }
+    opened, err := c.Open(ctx, "acme", received)
+    if err != nil || !bytes.Equal(opened, body) {
+        t.Fatalf("expected the body back, got %s, %v", opened, err)
+    }
+
+    if _, err = c.Open(ctx, "other", received); !errors.Is(err, ErrDecrypt) {
+        t.Errorf("expected the key of another tenant not to decrypt the message, got %v", err)
+    }
+    received.Body[len(received.Body)-1] ^= 1
+    if _, err = c.Open(ctx, "acme", received); !errors.Is(err, ErrDecrypt) {
+        t.Errorf("expected a tampered message not to decrypt, got %v", err)
+    }
+}
+
+func TestFromMessage(t *testing.T) {
+    if sealed, err := FromMessage(nil, []byte("{}")); sealed != nil || err != nil {
+        t.Errorf("expected a plaintext message, got %v, %v", sealed, err)
+    }
+    if _, err := FromMessage(map[string]any{HeaderAlgorithm: "ROT13"}, nil); !errors.Is(err, ErrUnsupportedAlgorithm) {
+        t.Errorf("expected ErrUnsupportedAlgorithm, got %v", err)
+    }
+    headers := map[string]any{HeaderAlgorithm: Algorithm, HeaderKeyID: "../other/default", HeaderDataKey: "a2V5"}
+    if _, err := FromMessage(headers, nil); !errors.Is(err, ErrDecrypt) {
+        t.Errorf("expected a key outside of the tenant to be rejected, got %v", err)
+    }
+}
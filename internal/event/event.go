@@ -0,0 +1,69 @@
+package event
+
+import (
+    "errors"
+    "time"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/ulid"
+)
+
+const (
+    // Version is the version of the envelope, it changes with incompatible changes of the envelope itself
+    Version = 1
+
+    // TypeVehicleUpdated is the event of a stored tracking data, the data is the tracking data
+    TypeVehicleUpdated = "vehicle.updated"
+    // TrackingDataSchemaVersion is the version of the data of TypeVehicleUpdated. It was the received reading in 1,
+    // it is the stored tracking data with its id, timestamps and computed distance and speed since 2.
+    TrackingDataSchemaVersion = 2
+)
+
+var (
+    ErrInvalidData = errors.New("event data must be JSON")
+)
+
+// Envelope wraps the payload of an event with what consumers need to route, order and deduplicate events
+// without knowing the payload
+type Envelope struct {
+    ID       string         `json:"id"`
+    Type     string         `json:"type"`
+    Version  int            `json:"version"`
+    Time     timestamp.Time `json:"time"`
+    TenantID string         `json:"tenant_id,omitempty"`
+    // SchemaVersion is the version of the data of the type, it is left out for types without one
+    SchemaVersion int `json:"schema_version,omitempty"`
+    // ProducedAt is when the event was wrapped, Time is when it happened
+    ProducedAt timestamp.Time  `json:"produced_at"`
+    Data       json.RawMessage `json:"data"`
+}
+
+// Wrap wraps the JSON data in an envelope of the type, the id is a ULID of the time
+func Wrap(eventType string, data []byte, t time.Time, tenantID string) (*Envelope, error) {
+    if !json.Valid(data) {
+        return nil, ErrInvalidData
+    }
+    return &Envelope{
+        ID:         ulid.New(t),
+        Type:       eventType,
+        Version:    Version,
+        Time:       timestamp.New(t),
+        TenantID:   tenantID,
+        ProducedAt: timestamp.Now(),
+        Data:       data,
+    }, nil
+}
+
+// IsEnvelope reports whether body is already an envelope, so wrapping twice can be avoided
+func IsEnvelope(body []byte) bool {
+    var envelope struct {
+        Type    string          `json:"type"`
+        Version int             `json:"version"`
+        Data    json.RawMessage `json:"data"`
+    }
+    if err := json.Unmarshal(body, &envelope); err != nil {
+        return false
+    }
+    return envelope.Type != "" && envelope.Version > 0 && len(envelope.Data) > 0
+}
@@ -0,0 +1,37 @@
+package event
+
+import (
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/goccy/go-json"
+)
+
+func TestWrap(t *testing.T) {
+    created := time.Date(2026, 10, 16, 8, 30, 0, 0, time.UTC)
+    envelope, err := Wrap(TypeVehicleUpdated, []byte(`{"vehicle_id":"1"}`), created, "acme")
+    if err != nil {
+        t.Fatal(err)
+    }
+    body, err := json.Marshal(envelope)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !IsEnvelope(body) {
+        t.Errorf("expected %s to be an envelope", body)
+    }
+    if envelope.Type != TypeVehicleUpdated || envelope.Version != Version || envelope.TenantID != "acme" {
+        t.Errorf("unexpected envelope %+v", envelope)
+    }
+    if !envelope.Time.Equal(created) || len(envelope.ID) != 26 || envelope.ProducedAt.Before(created) {
+        t.Errorf("expected a ULID id and the time of the event, got %+v", envelope)
+    }
+
+    if IsEnvelope([]byte(`{"vehicle_id":"1"}`)) {
+        t.Error("a raw payload must not be an envelope")
+    }
+    if _, err = Wrap(TypeVehicleUpdated, []byte("not json"), created, ""); !errors.Is(err, ErrInvalidData) {
+        t.Errorf("expected ErrInvalidData, got %v", err)
+    }
+}
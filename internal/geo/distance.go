@@ -0,0 +1,113 @@
+package geo
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "math"
+)
+
+const (
+    earthRadiusMeters = 6371008.8
+
+    DistanceHaversine  DistanceStrategy = "haversine"
+    DistanceSimplified DistanceStrategy = "simplified"
+    DistanceMapMatched DistanceStrategy = "map_matched"
+
+    DefaultSimplifyTolerance = 10.0 // meters
+)
+
+var (
+    ErrUnknownDistanceStrategy = errors.New("unknown distance strategy")
+    ErrMapMatcherRequired      = errors.New("map_matched distance strategy requires a map matching provider")
+)
+
+// DistanceStrategy is the method used to compute distances from GPS points
+type DistanceStrategy string
+
+func (s DistanceStrategy) Valid() error {
+    switch s {
+    case DistanceHaversine, DistanceSimplified, DistanceMapMatched:
+        return nil
+    default:
+        return fmt.Errorf("%w: %s", ErrUnknownDistanceStrategy, s)
+    }
+}
+
+// Distance is a computed distance together with the method that produced it,
+// so stored values and reports can state how they were calculated
+type Distance struct {
+    Meters float64          `json:"meters" bson:"meters"`
+    Method DistanceStrategy `json:"method" bson:"method"`
+}
+
+// Haversine returns the great-circle distance between two points in meters
+func Haversine(a, b Point) float64 {
+    lat1, lat2 := toRadians(a.Lat), toRadians(b.Lat)
+    dLat := lat2 - lat1
+    dLng := toRadians(b.Lng - a.Lng)
+    h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+    return 2 * earthRadiusMeters * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// PathLength returns the sum of the haversine distances between consecutive points in meters
+func PathLength(points []Point) float64 {
+    total := 0.0
+    for i := 1; i < len(points); i++ {
+        total += Haversine(points[i-1], points[i])
+    }
+    return total
+}
+
+// DistanceCalculator computes distances with the configured strategy
+type DistanceCalculator struct {
+    strategy  DistanceStrategy
+    matcher   MapMatcher
+    tolerance float64
+}
+
+// NewDistanceCalculator creates a calculator, tolerance is the simplification tolerance in meters
+func NewDistanceCalculator(strategy DistanceStrategy, matcher MapMatcher, tolerance float64) (*DistanceCalculator, error) {
+    if strategy == "" {
+        strategy = DistanceHaversine
+    }
+    if err := strategy.Valid(); err != nil {
+        return nil, err
+    }
+    if strategy == DistanceMapMatched && matcher == nil {
+        return nil, ErrMapMatcherRequired
+    }
+    if tolerance <= 0 {
+        tolerance = DefaultSimplifyTolerance
+    }
+    return &DistanceCalculator{strategy: strategy, matcher: matcher, tolerance: tolerance}, nil
+}
+
+func (c *DistanceCalculator) Strategy() DistanceStrategy {
+    return c.strategy
+}
+
+// Distance returns the length of the path through the points. When map matching fails,
+// the raw haversine distance is returned and recorded as such.
+func (c *DistanceCalculator) Distance(ctx context.Context, points []Point) Distance {
+    switch c.strategy {
+    case DistanceSimplified:
+        return Distance{Meters: PathLength(Simplify(points, c.tolerance)), Method: DistanceSimplified}
+    case DistanceMapMatched:
+        result, err := c.matcher.Match(ctx, points)
+        if err == nil {
+            meters := result.Distance
+            if meters == 0 {
+                meters = PathLength(result.Points)
+            }
+            return Distance{Meters: meters, Method: DistanceMapMatched}
+        }
+        log.Println("Map matching failed, falling back to haversine distance: ", err)
+    }
+    return Distance{Meters: PathLength(points), Method: DistanceHaversine}
+}
+
+func toRadians(degrees float64) float64 {
+    return degrees * math.Pi / 180
+}
@@ -0,0 +1,89 @@
+package geo
+
+import (
+    "context"
+    "math"
+    "testing"
+)
+
+func TestHaversine(t *testing.T) {
+    // Yangon to Mandalay is roughly 571km in a straight line
+    yangon := NewPoint(16.8409, 96.1735)
+    mandalay := NewPoint(21.9588, 96.0891)
+
+    distance := Haversine(yangon, mandalay)
+    if math.Abs(distance-569_000) > 5_000 {
+        t.Fatalf("Distance should be about 569km, got %.0fm", distance)
+    }
+
+    if Haversine(yangon, yangon) != 0 {
+        t.Fatal("Distance to the same point should be zero")
+    }
+}
+
+func TestSimplify(t *testing.T) {
+    // points along two straight legs, with the turn in the middle
+    points := []Point{
+        NewPoint(16.80, 96.100),
+        NewPoint(16.81, 96.105),
+        NewPoint(16.82, 96.110),
+        NewPoint(16.83, 96.105),
+        NewPoint(16.84, 96.100),
+    }
+
+    simplified := Simplify(points, 10)
+    if len(simplified) != 3 {
+        t.Fatalf("Should keep the start, the turn and the end, got %d points", len(simplified))
+    }
+    if simplified[1] != points[2] {
+        t.Fatal("Should keep the turn")
+    }
+
+    if len(Simplify(points, 100_000)) != 2 {
+        t.Fatal("Should keep only the start and the end with a large tolerance")
+    }
+}
+
+func TestSimplifyToCount(t *testing.T) {
+    points := []Point{
+        NewPoint(16.80, 96.100),
+        NewPoint(16.81, 96.105),
+        NewPoint(16.82, 96.110),
+        NewPoint(16.83, 96.105),
+        NewPoint(16.84, 96.100),
+    }
+
+    simplified := SimplifyToCount(points, 3)
+    if len(simplified) != 3 {
+        t.Fatalf("Should keep 3 points, got %d points", len(simplified))
+    }
+    if simplified[0] != points[0] || simplified[1] != points[2] || simplified[2] != points[4] {
+        t.Fatal("Should keep the start, the turn and the end")
+    }
+
+    if len(SimplifyToCount(points, 0)) != 2 {
+        t.Fatal("Should always keep the start and the end")
+    }
+    if len(SimplifyToCount(points, 10)) != len(points) {
+        t.Fatal("Should keep every point when there are less than the maximum")
+    }
+}
+
+func TestDistanceCalculator(t *testing.T) {
+    if _, err := NewDistanceCalculator(DistanceMapMatched, nil, 0); err == nil {
+        t.Fatal("Map matched strategy should require a map matcher")
+    }
+
+    calculator, err := NewDistanceCalculator("", nil, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    points := []Point{NewPoint(16.80, 96.10), NewPoint(16.81, 96.10)}
+    distance := calculator.Distance(context.Background(), points)
+    if distance.Method != DistanceHaversine {
+        t.Fatal("Default strategy should be haversine")
+    }
+    if distance.Meters != PathLength(points) {
+        t.Fatal("Haversine distance should be the path length")
+    }
+}
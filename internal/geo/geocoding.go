@@ -0,0 +1,157 @@
+package geo
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/goccy/go-json"
+)
+
+const (
+    GeocodingNominatim = "nominatim"
+    GeocodingGoogle    = "google"
+
+    // the public endpoints of the providers, used without a base URL
+    nominatimURL = "https://nominatim.openstreetmap.org"
+    googleURL    = "https://maps.googleapis.com"
+
+    // geocodingUserAgent identifies the service, the usage policy of the public Nominatim requires one
+    geocodingUserAgent = "managing-vehicle-tracking-tracking-svc"
+)
+
+var (
+    ErrUnknownGeocodingProvider = errors.New("unknown reverse geocoding provider")
+    ErrGeocodingFailed          = errors.New("reverse geocoding failed")
+    ErrAddressNotFound          = errors.New("no address at the position")
+)
+
+// ReverseGeocoder finds the address of a position
+type ReverseGeocoder interface {
+    ReverseGeocode(ctx context.Context, point Point) (string, error)
+}
+
+// NewReverseGeocoder creates the reverse geocoder of the provider, it returns nil when no provider is configured.
+// The public endpoint of the provider is used without a base URL.
+func NewReverseGeocoder(provider, baseURL, apiKey string) (ReverseGeocoder, error) {
+    client := &http.Client{Timeout: 5 * time.Second}
+    switch provider {
+    case "":
+        return nil, nil
+    case GeocodingNominatim:
+        return NewNominatimGeocoder(client, orDefault(baseURL, nominatimURL)), nil
+    case GeocodingGoogle:
+        return NewGoogleGeocoder(client, orDefault(baseURL, googleURL), apiKey), nil
+    default:
+        return nil, fmt.Errorf("%w: %s", ErrUnknownGeocodingProvider, provider)
+    }
+}
+
+// orDefault returns value, or fallback when value is empty
+func orDefault(value, fallback string) string {
+    if value == "" {
+        return fallback
+    }
+    return value
+}
+
+type NominatimGeocoder struct {
+    client  *http.Client
+    baseURL string
+}
+
+func NewNominatimGeocoder(client *http.Client, baseURL string) *NominatimGeocoder {
+    return &NominatimGeocoder{client: client, baseURL: strings.TrimRight(baseURL, "/")}
+}
+
+type nominatimReverseResponse struct {
+    DisplayName string `json:"display_name"`
+    Error       string `json:"error"`
+}
+
+func (g *NominatimGeocoder) ReverseGeocode(ctx context.Context, point Point) (string, error) {
+    query := url.Values{
+        "format": {"jsonv2"},
+        "lat":    {strconv.FormatFloat(point.Lat, 'f', -1, 64)},
+        "lon":    {strconv.FormatFloat(point.Lng, 'f', -1, 64)},
+    }
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.baseURL+"/reverse?"+query.Encode(), nil)
+    if err != nil {
+        return "", err
+    }
+    req.Header.Set("User-Agent", geocodingUserAgent)
+    res, err := g.client.Do(req)
+    if err != nil {
+        return "", err
+    }
+    defer res.Body.Close()
+    if res.StatusCode != http.StatusOK {
+        return "", fmt.Errorf("%w: %s", ErrGeocodingFailed, res.Status)
+    }
+
+    var body nominatimReverseResponse
+    if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+        return "", err
+    }
+    // positions without an address, e.g. at sea, are an error of the response
+    if body.Error != "" || body.DisplayName == "" {
+        return "", fmt.Errorf("%w: %s", ErrAddressNotFound, body.Error)
+    }
+    return body.DisplayName, nil
+}
+
+type GoogleGeocoder struct {
+    client  *http.Client
+    baseURL string
+    apiKey  string
+}
+
+func NewGoogleGeocoder(client *http.Client, baseURL string, apiKey string) *GoogleGeocoder {
+    return &GoogleGeocoder{client: client, baseURL: strings.TrimRight(baseURL, "/"), apiKey: apiKey}
+}
+
+type googleGeocodeResponse struct {
+    Status       string `json:"status"`
+    ErrorMessage string `json:"error_message"`
+    Results      []struct {
+        FormattedAddress string `json:"formatted_address"`
+    } `json:"results"`
+}
+
+func (g *GoogleGeocoder) ReverseGeocode(ctx context.Context, point Point) (string, error) {
+    query := url.Values{
+        "latlng": {strconv.FormatFloat(point.Lat, 'f', -1, 64) + "," + strconv.FormatFloat(point.Lng, 'f', -1, 64)},
+        "key":    {g.apiKey},
+    }
+    req, err := http.NewRequestWithContext(
+        ctx,
+        http.MethodGet,
+        g.baseURL+"/maps/api/geocode/json?"+query.Encode(),
+        nil,
+    )
+    if err != nil {
+        return "", err
+    }
+    res, err := g.client.Do(req)
+    if err != nil {
+        return "", err
+    }
+    defer res.Body.Close()
+
+    var body googleGeocodeResponse
+    if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+        return "", err
+    }
+    switch {
+    case body.Status == "ZERO_RESULTS" || (body.Status == "OK" && len(body.Results) == 0):
+        return "", ErrAddressNotFound
+    case body.Status != "OK":
+        return "", fmt.Errorf("%w: %s %s", ErrGeocodingFailed, body.Status, body.ErrorMessage)
+    }
+    return body.Results[0].FormattedAddress, nil
+}
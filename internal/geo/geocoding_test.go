@@ -0,0 +1,87 @@
+package geo
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+func TestNominatimGeocoder(t *testing.T) {
+    server := httptest.NewServer(
+        http.HandlerFunc(
+            func(w http.ResponseWriter, r *http.Request) {
+                query := r.URL.Query()
+                if r.URL.Path != "/reverse" || query.Get("lat") != "16.8409" || r.Header.Get("User-Agent") == "" {
+                    t.Errorf("unexpected request %s with user agent %q", r.URL, r.Header.Get("User-Agent"))
+                }
+                if query.Get("lon") == "0" {
+                    _, _ = w.Write([]byte(`{"error":"Unable to geocode"}`))
+                    return
+                }
+                _, _ = w.Write([]byte(`{"display_name":"Sule Pagoda Road, Yangon, Myanmar"}`))
+            },
+        ),
+    )
+    defer server.Close()
+
+    geocoder := NewNominatimGeocoder(server.Client(), server.URL+"/")
+    address, err := geocoder.ReverseGeocode(context.Background(), NewPoint(16.8409, 96.1561))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if address != "Sule Pagoda Road, Yangon, Myanmar" {
+        t.Errorf("expected the display name, got %q", address)
+    }
+    _, err = geocoder.ReverseGeocode(context.Background(), NewPoint(16.8409, 0))
+    if !errors.Is(err, ErrAddressNotFound) {
+        t.Errorf("expected no address, got %v", err)
+    }
+}
+
+func TestGoogleGeocoder(t *testing.T) {
+    server := httptest.NewServer(
+        http.HandlerFunc(
+            func(w http.ResponseWriter, r *http.Request) {
+                switch r.URL.Query().Get("key") {
+                case "key":
+                    _, _ = w.Write([]byte(`{"status":"OK","results":[{"formatted_address":"Yangon, Myanmar"}]}`))
+                default:
+                    _, _ = w.Write([]byte(`{"status":"REQUEST_DENIED","error_message":"The API key is invalid"}`))
+                }
+            },
+        ),
+    )
+    defer server.Close()
+
+    address, err := NewGoogleGeocoder(server.Client(), server.URL, "key").
+        ReverseGeocode(context.Background(), NewPoint(16.8409, 96.1561))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if address != "Yangon, Myanmar" {
+        t.Errorf("expected the formatted address, got %q", address)
+    }
+    _, err = NewGoogleGeocoder(server.Client(), server.URL, "").
+        ReverseGeocode(context.Background(), NewPoint(16.8409, 96.1561))
+    if !errors.Is(err, ErrGeocodingFailed) {
+        t.Errorf("expected the geocoding to fail, got %v", err)
+    }
+}
+
+func TestNewReverseGeocoder(t *testing.T) {
+    if geocoder, err := NewReverseGeocoder("", "", ""); geocoder != nil || err != nil {
+        t.Errorf("expected no geocoder, got %v %v", geocoder, err)
+    }
+    if _, err := NewReverseGeocoder("here", "", ""); !errors.Is(err, ErrUnknownGeocodingProvider) {
+        t.Errorf("expected an unknown provider, got %v", err)
+    }
+    geocoder, err := NewReverseGeocoder(GeocodingNominatim, "", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if nominatim := geocoder.(*NominatimGeocoder); nominatim.baseURL != nominatimURL {
+        t.Errorf("expected the public Nominatim, got %q", nominatim.baseURL)
+    }
+}
@@ -0,0 +1,75 @@
+package geo
+
+import (
+    "math"
+)
+
+const (
+    // GeohashPrecision is the number of characters of the stored geohashes, cells of about 5m x 5m
+    GeohashPrecision = 9
+
+    geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+)
+
+// Geohash encodes the position as a geohash of precision characters. Nearby positions share the leading
+// characters, so the positions of an area are a range of an index on the geohashes.
+func Geohash(lat, lng float64, precision int) string {
+    latRange, lngRange := [2]float64{-90, 90}, [2]float64{-180, 180}
+    hash := make([]byte, 0, precision)
+    var bits, char int
+    // the bits alternate between the longitude and the latitude, the longitude first
+    for even := true; len(hash) < precision; even = !even {
+        value, bounds := lat, &latRange
+        if even {
+            value, bounds = lng, &lngRange
+        }
+        mid := (bounds[0] + bounds[1]) / 2
+        char <<= 1
+        if value >= mid {
+            char |= 1
+            bounds[0] = mid
+        } else {
+            bounds[1] = mid
+        }
+        if bits++; bits == 5 {
+            hash = append(hash, geohashAlphabet[char])
+            bits, char = 0, 0
+        }
+    }
+    return string(hash)
+}
+
+// geohashCellSize returns the height and width in degrees of the geohash cells of precision characters
+func geohashCellSize(precision int) (float64, float64) {
+    lngBits := (5*precision + 1) / 2
+    latBits := 5 * precision / 2
+    return 180 / math.Exp2(float64(latBits)), 360 / math.Exp2(float64(lngBits))
+}
+
+// GeohashCover returns the geohashes of the cells overlapping the bounding box, of the longest precision up to
+// GeohashPrecision with at most maxCells of them. The box is covered by the 32 cells of a character otherwise.
+func GeohashCover(minLat, minLng, maxLat, maxLng float64, maxCells int) []string {
+    var cover []string
+    for precision := 1; precision <= GeohashPrecision; precision++ {
+        height, width := geohashCellSize(precision)
+        rows, columns := 180/height, 360/width
+        cell := func(value, origin, size, cells float64) int {
+            return int(min(max(math.Floor((value-origin)/size), 0), cells-1))
+        }
+        firstRow, lastRow := cell(minLat, -90, height, rows), cell(maxLat, -90, height, rows)
+        firstColumn, lastColumn := cell(minLng, -180, width, columns), cell(maxLng, -180, width, columns)
+        if cover != nil && (lastRow-firstRow+1)*(lastColumn-firstColumn+1) > maxCells {
+            break
+        }
+        cover = cover[:0]
+        for row := firstRow; row <= lastRow; row++ {
+            for column := firstColumn; column <= lastColumn; column++ {
+                // the center of a cell is only in that cell
+                lat := -90 + (float64(row)+0.5)*height
+                lng := -180 + (float64(column)+0.5)*width
+                cover = append(cover, Geohash(lat, lng, precision))
+            }
+        }
+    }
+    return cover
+}
@@ -0,0 +1,39 @@
+package geo
+
+import (
+    "slices"
+    "strings"
+    "testing"
+)
+
+func TestGeohash(t *testing.T) {
+    // the example of the geohash article, and Yangon
+    if hash := Geohash(57.64911, 10.40744, 11); hash != "u4pruydqqvj" {
+        t.Errorf("expected u4pruydqqvj, got %s", hash)
+    }
+    if hash := Geohash(16.8409, 96.1735, GeohashPrecision); !strings.HasPrefix(hash, "w4") || len(hash) != 9 {
+        t.Errorf("expected a geohash of Yangon of 9 characters, got %s", hash)
+    }
+}
+
+func TestGeohashCover(t *testing.T) {
+    // a box around Yangon is covered by the cells of the positions in it
+    cover := GeohashCover(16.75, 96.10, 16.90, 96.25, 32)
+    if len(cover) == 0 || len(cover) > 32 {
+        t.Fatalf("expected up to 32 cells, got %v", cover)
+    }
+    for _, point := range []Point{NewPoint(16.75, 96.10), NewPoint(16.84, 96.17), NewPoint(16.90, 96.25)} {
+        hash := Geohash(point.Lat, point.Lng, GeohashPrecision)
+        if !slices.ContainsFunc(cover, func(cell string) bool { return strings.HasPrefix(hash, cell) }) {
+            t.Errorf("expected the cover %v to contain %s", cover, hash)
+        }
+    }
+    if len(cover[0]) < 3 {
+        t.Errorf("expected cells of a few kilometers, got %v", cover)
+    }
+
+    // the whole world is the 32 cells of a character
+    if cover = GeohashCover(-90, -180, 90, 180, 4); len(cover) != 32 {
+        t.Errorf("expected the 32 cells of a character, got %v", cover)
+    }
+}
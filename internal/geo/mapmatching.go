@@ -0,0 +1,272 @@
+package geo
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "fmt"
+    "net/http"
+    "slices"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/goccy/go-json"
+)
+
+const (
+    MapMatchingOSRM     = "osrm"
+    MapMatchingValhalla = "valhalla"
+
+    // osrmMaxCoordinates is the default coordinate limit of the OSRM match service
+    osrmMaxCoordinates = 100
+)
+
+var (
+    ErrUnknownMapMatchingProvider = errors.New("unknown map matching provider")
+    ErrMapMatchingFailed          = errors.New("map matching failed")
+)
+
+type MatchResult struct {
+    // Points has one snapped point per input point, points the provider couldn't match are returned as is
+    Points []Point
+    // Matched tells for every point whether the provider snapped it to a road
+    Matched []bool
+    // Roads has the name of the road of every matched point, empty when the road has no name
+    Roads []string
+    // Distance is the length in meters of the matched route
+    Distance float64
+}
+
+// MapMatcher snaps noisy GPS traces to the road network
+type MapMatcher interface {
+    Match(ctx context.Context, points []Point) (*MatchResult, error)
+}
+
+// NewMapMatcher creates the map matcher for the provider, it returns nil when no provider is configured
+func NewMapMatcher(provider, baseURL string) (MapMatcher, error) {
+    client := &http.Client{Timeout: 10 * time.Second}
+    switch provider {
+    case "":
+        return nil, nil
+    case MapMatchingOSRM:
+        return NewOSRMMatcher(client, baseURL), nil
+    case MapMatchingValhalla:
+        return NewValhallaMatcher(client, baseURL), nil
+    default:
+        return nil, fmt.Errorf("%w: %s", ErrUnknownMapMatchingProvider, provider)
+    }
+}
+
+type OSRMMatcher struct {
+    client  *http.Client
+    baseURL string
+}
+
+func NewOSRMMatcher(client *http.Client, baseURL string) *OSRMMatcher {
+    return &OSRMMatcher{client: client, baseURL: strings.TrimRight(baseURL, "/")}
+}
+
+type osrmMatchResponse struct {
+    Code      string `json:"code"`
+    Message   string `json:"message"`
+    Matchings []struct {
+        Distance float64 `json:"distance"`
+    } `json:"matchings"`
+    Tracepoints []*struct {
+        Location []float64 `json:"location"`
+        Name     string    `json:"name"`
+    } `json:"tracepoints"`
+}
+
+func (m *OSRMMatcher) Match(ctx context.Context, points []Point) (*MatchResult, error) {
+    result := &MatchResult{
+        Points:  make([]Point, 0, len(points)),
+        Matched: make([]bool, 0, len(points)),
+        Roads:   make([]string, 0, len(points)),
+    }
+    // OSRM limits the number of coordinates per request, so long traces are matched in chunks
+    for start := 0; start < len(points); start += osrmMaxCoordinates {
+        end := min(start+osrmMaxCoordinates, len(points))
+        chunk, err := m.match(ctx, points[start:end])
+        if err != nil {
+            return nil, err
+        }
+        result.Points = append(result.Points, chunk.Points...)
+        result.Matched = append(result.Matched, chunk.Matched...)
+        result.Roads = append(result.Roads, chunk.Roads...)
+        result.Distance += chunk.Distance
+    }
+    return result, nil
+}
+
+func (m *OSRMMatcher) match(ctx context.Context, points []Point) (*MatchResult, error) {
+    if len(points) < 2 {
+        return unmatched(points), nil
+    }
+
+    coordinates := make([]string, 0, len(points))
+    timestamps := make([]string, 0, len(points))
+    for _, point := range points {
+        coordinates = append(
+            coordinates,
+            strconv.FormatFloat(point.Lng, 'f', -1, 64)+","+strconv.FormatFloat(point.Lat, 'f', -1, 64),
+        )
+        timestamps = append(timestamps, strconv.FormatInt(point.Time.Unix(), 10))
+    }
+
+    url := fmt.Sprintf("%s/match/v1/driving/%s?overview=false&tidy=true", m.baseURL, strings.Join(coordinates, ";"))
+    // timestamps are only useful when every point has one
+    if !hasZeroTime(points) {
+        url += "&timestamps=" + strings.Join(timestamps, ";")
+    }
+
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+    if err != nil {
+        return nil, err
+    }
+    res, err := m.client.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer res.Body.Close()
+
+    var body osrmMatchResponse
+    if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+        return nil, err
+    }
+    if body.Code != "Ok" {
+        return nil, fmt.Errorf("%w: %s %s", ErrMapMatchingFailed, body.Code, body.Message)
+    }
+
+    result := unmatched(points)
+    for i := range points {
+        if i < len(body.Tracepoints) && body.Tracepoints[i] != nil && len(body.Tracepoints[i].Location) == 2 {
+            result.Points[i].Lng = body.Tracepoints[i].Location[0]
+            result.Points[i].Lat = body.Tracepoints[i].Location[1]
+            result.Matched[i] = true
+            result.Roads[i] = body.Tracepoints[i].Name
+        }
+    }
+    for _, matching := range body.Matchings {
+        result.Distance += matching.Distance
+    }
+    return result, nil
+}
+
+type ValhallaMatcher struct {
+    client  *http.Client
+    baseURL string
+}
+
+func NewValhallaMatcher(client *http.Client, baseURL string) *ValhallaMatcher {
+    return &ValhallaMatcher{client: client, baseURL: strings.TrimRight(baseURL, "/")}
+}
+
+type valhallaShapePoint struct {
+    Lat  float64 `json:"lat"`
+    Lon  float64 `json:"lon"`
+    Time int64   `json:"time,omitempty"`
+}
+
+type valhallaTraceRequest struct {
+    Shape      []valhallaShapePoint `json:"shape"`
+    Costing    string               `json:"costing"`
+    ShapeMatch string               `json:"shape_match"`
+}
+
+type valhallaTraceResponse struct {
+    Error         string `json:"error"`
+    MatchedPoints []struct {
+        Lat  float64 `json:"lat"`
+        Lon  float64 `json:"lon"`
+        Type string  `json:"type"`
+        // EdgeIndex is the edge the point was matched to, missing for unmatched points
+        EdgeIndex *int `json:"edge_index"`
+    } `json:"matched_points"`
+    Edges []struct {
+        // Length is in kilometers
+        Length float64  `json:"length"`
+        Names  []string `json:"names"`
+    } `json:"edges"`
+}
+
+func (m *ValhallaMatcher) Match(ctx context.Context, points []Point) (*MatchResult, error) {
+    if len(points) < 2 {
+        return unmatched(points), nil
+    }
+
+    payload := valhallaTraceRequest{
+        Shape:      make([]valhallaShapePoint, 0, len(points)),
+        Costing:    "auto",
+        ShapeMatch: "map_snap",
+    }
+    for _, point := range points {
+        shapePoint := valhallaShapePoint{Lat: point.Lat, Lon: point.Lng}
+        if !point.Time.IsZero() {
+            shapePoint.Time = point.Time.Unix()
+        }
+        payload.Shape = append(payload.Shape, shapePoint)
+    }
+    buf, err := json.Marshal(payload)
+    if err != nil {
+        return nil, err
+    }
+
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.baseURL+"/trace_attributes", bytes.NewReader(buf))
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    res, err := m.client.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer res.Body.Close()
+
+    var body valhallaTraceResponse
+    if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+        return nil, err
+    }
+    if res.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("%w: %s", ErrMapMatchingFailed, body.Error)
+    }
+
+    result := unmatched(points)
+    for i := range points {
+        if i >= len(body.MatchedPoints) || body.MatchedPoints[i].Type == "unmatched" {
+            continue
+        }
+        matched := body.MatchedPoints[i]
+        result.Points[i].Lat = matched.Lat
+        result.Points[i].Lng = matched.Lon
+        result.Matched[i] = true
+        if matched.EdgeIndex != nil && *matched.EdgeIndex < len(body.Edges) {
+            if names := body.Edges[*matched.EdgeIndex].Names; len(names) > 0 {
+                result.Roads[i] = names[0]
+            }
+        }
+    }
+    for _, edge := range body.Edges {
+        result.Distance += edge.Length * 1000
+    }
+    return result, nil
+}
+
+// unmatched returns the points as a result without any matched point
+func unmatched(points []Point) *MatchResult {
+    return &MatchResult{
+        Points:  slices.Clone(points),
+        Matched: make([]bool, len(points)),
+        Roads:   make([]string, len(points)),
+    }
+}
+
+func hasZeroTime(points []Point) bool {
+    for _, point := range points {
+        if point.Time.IsZero() {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,73 @@
+package geo
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+func TestOSRMMatcher(t *testing.T) {
+    server := httptest.NewServer(
+        http.HandlerFunc(
+            func(w http.ResponseWriter, r *http.Request) {
+                _, _ = w.Write(
+                    []byte(`{"code":"Ok","matchings":[{"distance":120.5}],"tracepoints":[` +
+                        `{"location":[96.1001,16.8001],"name":"Pyay Road"},null]}`),
+                )
+            },
+        ),
+    )
+    defer server.Close()
+
+    result, err := NewOSRMMatcher(server.Client(), server.URL).Match(
+        context.Background(),
+        []Point{NewPoint(16.8, 96.1), NewPoint(16.81, 96.11)},
+    )
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !result.Matched[0] || result.Points[0].Lat != 16.8001 || result.Roads[0] != "Pyay Road" {
+        t.Errorf("expected the first point on Pyay Road, got %+v", result)
+    }
+    if result.Matched[1] || result.Points[1].Lat != 16.81 || result.Distance != 120.5 {
+        t.Errorf("expected the second point unmatched, got %+v", result)
+    }
+}
+
+func TestValhallaMatcher(t *testing.T) {
+    server := httptest.NewServer(
+        http.HandlerFunc(
+            func(w http.ResponseWriter, r *http.Request) {
+                _, _ = w.Write(
+                    []byte(`{"matched_points":[{"lat":16.8001,"lon":96.1001,"type":"matched","edge_index":1},` +
+                        `{"lat":16.81,"lon":96.11,"type":"unmatched"}],` +
+                        `"edges":[{"length":0.1},{"length":0.02,"names":["Pyay Road"]}]}`),
+                )
+            },
+        ),
+    )
+    defer server.Close()
+
+    result, err := NewValhallaMatcher(server.Client(), server.URL).Match(
+        context.Background(),
+        []Point{NewPoint(16.8, 96.1), NewPoint(16.81, 96.11)},
+    )
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !result.Matched[0] || result.Points[0].Lng != 96.1001 || result.Roads[0] != "Pyay Road" {
+        t.Errorf("expected the first point on Pyay Road, got %+v", result)
+    }
+    if result.Matched[1] || result.Roads[1] != "" || result.Distance != 120 {
+        t.Errorf("expected the second point unmatched and 120m, got %+v", result)
+    }
+
+    // a single point can't be matched
+    if result, err = NewValhallaMatcher(server.Client(), server.URL).Match(
+        context.Background(),
+        []Point{NewPoint(16.8, 96.1)},
+    ); err != nil || len(result.Matched) != 1 || result.Matched[0] {
+        t.Errorf("expected the single point unmatched, got %+v, %v", result, err)
+    }
+}
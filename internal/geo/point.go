@@ -0,0 +1,37 @@
+package geo
+
+import (
+    "time"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+)
+
+// Point is a single GPS position, Time is optional and only used where ordering or speed matters
+type Point struct {
+    Lat  float64   `json:"lat" bson:"lat"`
+    Lng  float64   `json:"lng" bson:"lng"`
+    Time time.Time `json:"time,omitempty" bson:"time,omitempty"`
+}
+
+func NewPoint(lat, lng float64) Point {
+    return Point{Lat: lat, Lng: lng}
+}
+
+// Valid reports whether the point is within the latitude and longitude ranges
+func (p Point) Valid() bool {
+    return p.Lat >= -90 && p.Lat <= 90 && p.Lng >= -180 && p.Lng <= 180
+}
+
+// MarshalJSON formats the time like every other timestamp of the API, points without a time omit it
+func (p Point) MarshalJSON() ([]byte, error) {
+    point := struct {
+        Lat  float64         `json:"lat"`
+        Lng  float64         `json:"lng"`
+        Time *timestamp.Time `json:"time,omitempty"`
+    }{Lat: p.Lat, Lng: p.Lng}
+    if !p.Time.IsZero() {
+        point.Time = timestamp.Ptr(p.Time)
+    }
+    return json.Marshal(point)
+}
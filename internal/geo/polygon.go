@@ -0,0 +1,33 @@
+package geo
+
+// InPolygon reports whether the point is inside the polygon, a list of linear rings of [longitude, latitude]
+// positions like the coordinates of a GeoJSON Polygon. The first ring is the outer boundary and the others are
+// holes, a point in a hole is outside. The rings are treated as planar, which is precise enough for geofences a few
+// kilometers wide.
+func InPolygon(p Point, polygon [][][]float64) bool {
+    if len(polygon) == 0 || !inRing(p, polygon[0]) {
+        return false
+    }
+    for _, hole := range polygon[1:] {
+        if inRing(p, hole) {
+            return false
+        }
+    }
+    return true
+}
+
+// inRing casts a ray from the point to the east and counts the edges of the ring it crosses, an odd count is inside
+func inRing(p Point, ring [][]float64) bool {
+    inside := false
+    for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+        if len(ring[i]) < 2 || len(ring[j]) < 2 {
+            continue
+        }
+        lngI, latI := ring[i][0], ring[i][1]
+        lngJ, latJ := ring[j][0], ring[j][1]
+        if (latI > p.Lat) != (latJ > p.Lat) && p.Lng < (lngJ-lngI)*(p.Lat-latI)/(latJ-latI)+lngI {
+            inside = !inside
+        }
+    }
+    return inside
+}
@@ -0,0 +1,28 @@
+package geo
+
+import "testing"
+
+func TestInPolygon(t *testing.T) {
+    // a square around downtown Yangon with a square hole in the middle
+    polygon := [][][]float64{
+        {{96.10, 16.75}, {96.20, 16.75}, {96.20, 16.85}, {96.10, 16.85}, {96.10, 16.75}},
+        {{96.14, 16.79}, {96.16, 16.79}, {96.16, 16.81}, {96.14, 16.81}, {96.14, 16.79}},
+    }
+    for _, tc := range []struct {
+        name   string
+        point  Point
+        inside bool
+    }{
+        {name: "inside", point: NewPoint(16.77, 96.12), inside: true},
+        {name: "in the hole", point: NewPoint(16.80, 96.15), inside: false},
+        {name: "east of it", point: NewPoint(16.80, 96.25), inside: false},
+        {name: "north of it", point: NewPoint(16.90, 96.15), inside: false},
+    } {
+        if got := InPolygon(tc.point, polygon); got != tc.inside {
+            t.Errorf("%s: InPolygon = %v, want %v", tc.name, got, tc.inside)
+        }
+    }
+    if InPolygon(NewPoint(16.77, 96.12), nil) {
+        t.Error("a point is inside an empty polygon")
+    }
+}
@@ -0,0 +1,133 @@
+package geo
+
+import (
+    "container/heap"
+    "math"
+)
+
+// Simplify reduces the number of points with the Douglas-Peucker algorithm,
+// tolerance is the maximum distance in meters a removed point may be from the simplified line
+func Simplify(points []Point, tolerance float64) []Point {
+    if len(points) < 3 || tolerance <= 0 {
+        return points
+    }
+
+    keep := make([]bool, len(points))
+    keep[0], keep[len(points)-1] = true, true
+
+    // an explicit stack instead of recursion, long tracks can have hundreds of thousands of points
+    stack := [][2]int{{0, len(points) - 1}}
+    for len(stack) > 0 {
+        segment := stack[len(stack)-1]
+        stack = stack[:len(stack)-1]
+        first, last := segment[0], segment[1]
+
+        maxDistance, index := 0.0, -1
+        for i := first + 1; i < last; i++ {
+            distance := crossTrackDistance(points[i], points[first], points[last])
+            if distance > maxDistance {
+                maxDistance, index = distance, i
+            }
+        }
+        if index != -1 && maxDistance > tolerance {
+            keep[index] = true
+            stack = append(stack, [2]int{first, index}, [2]int{index, last})
+        }
+    }
+
+    simplified := make([]Point, 0, len(points))
+    for i, point := range points {
+        if keep[i] {
+            simplified = append(simplified, point)
+        }
+    }
+    return simplified
+}
+
+// SimplifyToCount reduces the points to at most maxPoints with the Douglas-Peucker algorithm, instead of a tolerance
+// the segments are split at the point farthest from them first, until maxPoints points are kept.
+// The first and last points are always kept, so maxPoints below 2 keeps both.
+func SimplifyToCount(points []Point, maxPoints int) []Point {
+    maxPoints = max(maxPoints, 2)
+    if len(points) <= maxPoints {
+        return points
+    }
+
+    keep := make([]bool, len(points))
+    keep[0], keep[len(points)-1] = true, true
+    kept := 2
+
+    segments := &segmentHeap{}
+    segments.push(points, 0, len(points)-1)
+    for kept < maxPoints && segments.Len() > 0 {
+        segment := heap.Pop(segments).(simplifySegment)
+        keep[segment.index] = true
+        kept++
+        segments.push(points, segment.first, segment.index)
+        segments.push(points, segment.index, segment.last)
+    }
+
+    simplified := make([]Point, 0, kept)
+    for i, point := range points {
+        if keep[i] {
+            simplified = append(simplified, point)
+        }
+    }
+    return simplified
+}
+
+// simplifySegment is a segment of a track with the point farthest from it
+type simplifySegment struct {
+    first, last int
+    index       int
+    distance    float64
+}
+
+// segmentHeap orders the segments by the distance of their farthest point, farthest first
+type segmentHeap []simplifySegment
+
+func (h segmentHeap) Len() int           { return len(h) }
+func (h segmentHeap) Less(i, j int) bool { return h[i].distance > h[j].distance }
+func (h segmentHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *segmentHeap) Push(x any)        { *h = append(*h, x.(simplifySegment)) }
+func (h *segmentHeap) Pop() any {
+    old := *h
+    segment := old[len(old)-1]
+    *h = old[:len(old)-1]
+    return segment
+}
+
+// push adds the segment first-last unless it has no points in between
+func (h *segmentHeap) push(points []Point, first, last int) {
+    if last-first < 2 {
+        return
+    }
+    segment := simplifySegment{first: first, last: last, index: first + 1, distance: -1}
+    for i := first + 1; i < last; i++ {
+        distance := crossTrackDistance(points[i], points[first], points[last])
+        if distance > segment.distance {
+            segment.index, segment.distance = i, distance
+        }
+    }
+    heap.Push(h, segment)
+}
+
+// crossTrackDistance returns the distance in meters from p to the segment a-b,
+// using an equirectangular projection which is accurate enough for the short segments of a track
+func crossTrackDistance(p, a, b Point) float64 {
+    lat0 := toRadians((a.Lat + b.Lat) / 2)
+    project := func(point Point) (float64, float64) {
+        return toRadians(point.Lng) * math.Cos(lat0) * earthRadiusMeters, toRadians(point.Lat) * earthRadiusMeters
+    }
+    px, py := project(p)
+    ax, ay := project(a)
+    bx, by := project(b)
+
+    dx, dy := bx-ax, by-ay
+    if dx == 0 && dy == 0 {
+        return math.Hypot(px-ax, py-ay)
+    }
+    t := ((px-ax)*dx + (py-ay)*dy) / (dx*dx + dy*dy)
+    t = math.Max(0, math.Min(1, t))
+    return math.Hypot(px-(ax+t*dx), py-(ay+t*dy))
+}
@@ -0,0 +1,189 @@
+package geojson
+
+import (
+    "errors"
+    "fmt"
+
+    "github.com/goccy/go-json"
+)
+
+const (
+    ContentType = "application/geo+json"
+
+    TypeFeature           = "Feature"
+    TypeFeatureCollection = "FeatureCollection"
+    TypePoint             = "Point"
+    TypeLineString        = "LineString"
+    TypePolygon           = "Polygon"
+    TypeMultiPolygon      = "MultiPolygon"
+)
+
+var (
+    ErrUnsupportedGeometry = errors.New("unsupported geometry type")
+    ErrInvalidPosition     = errors.New("invalid position")
+    ErrInvalidRing         = errors.New("invalid linear ring")
+)
+
+// Geometry is a GeoJSON geometry object. Coordinates are kept untyped so the
+// same struct can be decoded from both JSON and BSON documents.
+type Geometry struct {
+    Type        string `json:"type" bson:"type"`
+    Coordinates any    `json:"coordinates" bson:"coordinates"`
+}
+
+type Feature struct {
+    Type       string         `json:"type"`
+    ID         any            `json:"id,omitempty"`
+    Geometry   *Geometry      `json:"geometry"`
+    Properties map[string]any `json:"properties"`
+}
+
+type FeatureCollection struct {
+    Type     string     `json:"type"`
+    Features []*Feature `json:"features"`
+}
+
+func NewFeature(id any, geometry *Geometry, properties map[string]any) *Feature {
+    if properties == nil {
+        properties = map[string]any{}
+    }
+    return &Feature{Type: TypeFeature, ID: id, Geometry: geometry, Properties: properties}
+}
+
+func NewFeatureCollection(features []*Feature) *FeatureCollection {
+    if features == nil {
+        features = []*Feature{}
+    }
+    return &FeatureCollection{Type: TypeFeatureCollection, Features: features}
+}
+
+// NewPoint creates a Point geometry, GeoJSON positions are [longitude, latitude]
+func NewPoint(lng, lat float64) *Geometry {
+    return &Geometry{Type: TypePoint, Coordinates: []float64{lng, lat}}
+}
+
+// NewLineString creates a LineString geometry from [longitude, latitude] positions
+func NewLineString(positions [][]float64) *Geometry {
+    return &Geometry{Type: TypeLineString, Coordinates: positions}
+}
+
+// Polygons returns the geometry as a list of polygons, a Polygon is returned as a list with one element
+func (g *Geometry) Polygons() ([][][][]float64, error) {
+    // by marshalling and unmarshalling the coordinates, we can support
+    // both []any (decoded from JSON) and primitive.A (decoded from BSON)
+    buf, err := json.Marshal(g.Coordinates)
+    if err != nil {
+        return nil, err
+    }
+    switch g.Type {
+    case TypePolygon:
+        var polygon [][][]float64
+        if err := json.Unmarshal(buf, &polygon); err != nil {
+            return nil, err
+        }
+        return [][][][]float64{polygon}, nil
+    case TypeMultiPolygon:
+        var polygons [][][][]float64
+        if err := json.Unmarshal(buf, &polygons); err != nil {
+            return nil, err
+        }
+        return polygons, nil
+    default:
+        return nil, fmt.Errorf("%w: %s", ErrUnsupportedGeometry, g.Type)
+    }
+}
+
+// ValidatePolygons checks that the geometry is a Polygon or MultiPolygon with closed rings of valid positions that
+// don't intersect themselves
+func (g *Geometry) ValidatePolygons() error {
+    polygons, err := g.Polygons()
+    if err != nil {
+        return err
+    }
+    if len(polygons) == 0 {
+        return ErrInvalidRing
+    }
+    for _, polygon := range polygons {
+        if len(polygon) == 0 {
+            return ErrInvalidRing
+        }
+        for _, ring := range polygon {
+            if err := validateRing(ring); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+func validateRing(ring [][]float64) error {
+    // a linear ring needs at least 4 positions, and the first and last must be equal
+    if len(ring) < 4 {
+        return fmt.Errorf("%w: needs at least 4 positions", ErrInvalidRing)
+    }
+    for _, position := range ring {
+        if err := ValidatePosition(position); err != nil {
+            return err
+        }
+    }
+    first, last := ring[0], ring[len(ring)-1]
+    if first[0] != last[0] || first[1] != last[1] {
+        return fmt.Errorf("%w: ring is not closed", ErrInvalidRing)
+    }
+    if selfIntersects(ring) {
+        return fmt.Errorf("%w: ring intersects itself", ErrInvalidRing)
+    }
+    return nil
+}
+
+// selfIntersects reports whether two edges of the closed ring touch or cross, besides the neighbouring edges
+// sharing their position
+func selfIntersects(ring [][]float64) bool {
+    edges := len(ring) - 1
+    for i := 0; i < edges; i++ {
+        for j := i + 2; j < edges; j++ {
+            // the last edge ends where the first one starts
+            if i == 0 && j == edges-1 {
+                continue
+            }
+            if segmentsIntersect(ring[i], ring[i+1], ring[j], ring[j+1]) {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// segmentsIntersect reports whether the segments a-b and c-d have a position in common
+func segmentsIntersect(a, b, c, d []float64) bool {
+    abc, abd := orientation(a, b, c), orientation(a, b, d)
+    cda, cdb := orientation(c, d, a), orientation(c, d, b)
+    if abc*abd < 0 && cda*cdb < 0 {
+        return true
+    }
+    // a position of one segment on the other one
+    return abc == 0 && onSegment(a, b, c) || abd == 0 && onSegment(a, b, d) ||
+        cda == 0 && onSegment(c, d, a) || cdb == 0 && onSegment(c, d, b)
+}
+
+// orientation is positive when a, b and c turn counterclockwise, negative when they turn clockwise and 0 when
+// they are on a line
+func orientation(a, b, c []float64) float64 {
+    return (b[0]-a[0])*(c[1]-a[1]) - (b[1]-a[1])*(c[0]-a[0])
+}
+
+// onSegment reports whether p, on the line of a-b, is within the segment
+func onSegment(a, b, p []float64) bool {
+    return min(a[0], b[0]) <= p[0] && p[0] <= max(a[0], b[0]) && min(a[1], b[1]) <= p[1] && p[1] <= max(a[1], b[1])
+}
+
+// ValidatePosition checks that the position is [longitude, latitude] within range
+func ValidatePosition(position []float64) error {
+    if len(position) < 2 {
+        return ErrInvalidPosition
+    }
+    if position[0] < -180 || position[0] > 180 || position[1] < -90 || position[1] > 90 {
+        return fmt.Errorf("%w: [%v, %v] is out of range", ErrInvalidPosition, position[0], position[1])
+    }
+    return nil
+}
@@ -0,0 +1,68 @@
+package geojson
+
+import (
+    "errors"
+    "testing"
+
+    "github.com/goccy/go-json"
+)
+
+func TestGeometry_ValidatePolygons(t *testing.T) {
+    for _, test := range []struct {
+        name     string
+        geometry string
+        expected error
+    }{
+        {"polygon", `{"type":"Polygon","coordinates":[[[96,16],[97,16],[97,17],[96,17],[96,16]]]}`, nil},
+        {
+            "polygon with a hole",
+            `{"type":"Polygon","coordinates":[[[96,16],[98,16],[98,18],[96,18],[96,16]],` +
+                `[[96.5,16.5],[97.5,16.5],[97.5,17.5],[96.5,16.5]]]}`,
+            nil,
+        },
+        {
+            "multipolygon",
+            `{"type":"MultiPolygon","coordinates":[[[[96,16],[97,16],[97,17],[96,16]]],` +
+                `[[[98,16],[99,16],[99,17],[98,16]]]]}`,
+            nil,
+        },
+        {
+            "concave polygon",
+            `{"type":"Polygon","coordinates":[[[0,0],[4,0],[4,4],[2,1],[0,4],[0,0]]]}`,
+            nil,
+        },
+        {
+            "self-intersecting",
+            `{"type":"Polygon","coordinates":[[[96,16],[97,17],[97,16],[96,17],[96,16]]]}`,
+            ErrInvalidRing,
+        },
+        {
+            "touching itself",
+            `{"type":"Polygon","coordinates":[[[0,0],[4,0],[4,4],[2,0],[0,4],[0,0]]]}`,
+            ErrInvalidRing,
+        },
+        {"unclosed", `{"type":"Polygon","coordinates":[[[96,16],[97,16],[97,17],[96,17]]]}`, ErrInvalidRing},
+        {"too few positions", `{"type":"Polygon","coordinates":[[[96,16],[97,16],[96,16]]]}`, ErrInvalidRing},
+        {"empty", `{"type":"Polygon","coordinates":[]}`, ErrInvalidRing},
+        {
+            "out of range",
+            `{"type":"Polygon","coordinates":[[[96,16],[197,16],[97,17],[96,16]]]}`,
+            ErrInvalidPosition,
+        },
+        {"point", `{"type":"Point","coordinates":[96,16]}`, ErrUnsupportedGeometry},
+        {"line", `{"type":"LineString","coordinates":[[96,16],[97,17]]}`, ErrUnsupportedGeometry},
+    } {
+        t.Run(
+            test.name, func(t *testing.T) {
+                var geometry Geometry
+                if err := json.Unmarshal([]byte(test.geometry), &geometry); err != nil {
+                    t.Fatal(err)
+                }
+                err := geometry.ValidatePolygons()
+                if test.expected == nil && err != nil || test.expected != nil && !errors.Is(err, test.expected) {
+                    t.Errorf("expected %v, got %v", test.expected, err)
+                }
+            },
+        )
+    }
+}
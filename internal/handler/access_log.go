@@ -0,0 +1,82 @@
+package handler
+
+import (
+    "context"
+    "log"
+    "net"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/accesslog"
+)
+
+type accessLogEntryContextKey struct{}
+
+// AccessLogMiddleware writes an access log line per request once it is served. It runs before the requests are
+// authenticated, so rejected requests are logged too, and the identity is noted by AccessLogIdentityMiddleware
+// and the TenantMiddleware once the request is authenticated.
+func AccessLogMiddleware(logger *accesslog.Logger) func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(
+            func(w http.ResponseWriter, r *http.Request) {
+                started := time.Now()
+                entry := &accesslog.Entry{
+                    Time:       started,
+                    RemoteAddr: clientAddr(r),
+                    Method:     r.Method,
+                    URI:        r.URL.RequestURI(),
+                    Protocol:   r.Proto,
+                    Referer:    r.Referer(),
+                    UserAgent:  r.UserAgent(),
+                }
+                recorder := &statusRecorder{ResponseWriter: w}
+                ctx := context.WithValue(r.Context(), accessLogEntryContextKey{}, entry)
+                next.ServeHTTP(recorder, r.WithContext(ctx))
+
+                entry.Status, entry.Bytes, entry.Duration = recorder.status, recorder.bytes, time.Since(started)
+                if entry.Status == 0 {
+                    entry.Status = http.StatusOK
+                }
+                if err := logger.Log(entry); err != nil {
+                    log.Println("Failed to write access log: ", err)
+                }
+            },
+        )
+    }
+}
+
+// AccessLogIdentityMiddleware notes the user authenticated by the auth service on the access log entry of the
+// request, requests that aren't logged are left as they are
+func AccessLogIdentityMiddleware() func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(
+            func(w http.ResponseWriter, r *http.Request) {
+                entry, logged := r.Context().Value(accessLogEntryContextKey{}).(*accesslog.Entry)
+                if user, ok := AuthUserFromContext(r.Context()); ok && logged {
+                    entry.UserID, entry.Role = user.Data.Id, user.Data.Role
+                }
+                next.ServeHTTP(w, r)
+            },
+        )
+    }
+}
+
+// noteAccessLogTenant notes the resolved tenant on the access log entry of the request
+func noteAccessLogTenant(r *http.Request, id string) {
+    if entry, ok := r.Context().Value(accessLogEntryContextKey{}).(*accesslog.Entry); ok {
+        entry.TenantID = id
+    }
+}
+
+// clientAddr returns the address of the client, the first X-Forwarded-For address when behind the gateway
+func clientAddr(r *http.Request) string {
+    if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+        return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+    }
+    host, _, err := net.SplitHostPort(r.RemoteAddr)
+    if err != nil {
+        return r.RemoteAddr
+    }
+    return host
+}
@@ -0,0 +1,176 @@
+package handler
+
+import (
+    "compress/flate"
+    "compress/gzip"
+    "io"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+const (
+    EncodingGzip    = "gzip"
+    EncodingDeflate = "deflate"
+)
+
+// precompressedTypes are the content types that are compressed already, compressing them again only costs CPU
+var precompressedTypes = []string{"application/gzip", "application/zstd", "application/zip"}
+
+// responseCompressor is the compressor of an encoding, it is reset to write to each response
+type responseCompressor interface {
+    io.WriteCloser
+    Flush() error
+    Reset(w io.Writer)
+}
+
+var compressorPools = map[string]*sync.Pool{
+    EncodingGzip: {
+        New: func() any {
+            return gzip.NewWriter(io.Discard)
+        },
+    },
+    EncodingDeflate: {
+        New: func() any {
+            // only fails for an invalid level
+            compressor, _ := flate.NewWriter(io.Discard, flate.DefaultCompression)
+            return compressor
+        },
+    },
+}
+
+// CompressionMiddleware compresses the responses with gzip or deflate, whichever the Accept-Encoding header of the
+// request prefers. Responses that are compressed already, like compressed exports, and responses without a body
+// are sent as they are. Streaming responses keep streaming, every flush flushes the compressor first.
+func CompressionMiddleware() func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(
+            func(w http.ResponseWriter, r *http.Request) {
+                w.Header().Add("Vary", "Accept-Encoding")
+                encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+                if encoding == "" || r.Method == http.MethodHead {
+                    next.ServeHTTP(w, r)
+                    return
+                }
+                writer := &compressionWriter{ResponseWriter: w, encoding: encoding}
+                defer writer.close()
+                next.ServeHTTP(writer, r)
+            },
+        )
+    }
+}
+
+// negotiateEncoding returns the supported encoding with the highest quality in the Accept-Encoding header, gzip
+// when they are equal. An empty encoding means the response isn't compressed.
+func negotiateEncoding(acceptEncoding string) string {
+    encoding, best := "", 0.0
+    for _, coding := range strings.Split(acceptEncoding, ",") {
+        name, params, _ := strings.Cut(coding, ";")
+        name = strings.ToLower(strings.TrimSpace(name))
+        if name == "*" {
+            name = EncodingGzip
+        }
+        if name != EncodingGzip && name != EncodingDeflate {
+            continue
+        }
+        quality := 1.0
+        if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+            q, err := strconv.ParseFloat(value, 64)
+            if err != nil {
+                continue
+            }
+            quality = q
+        }
+        if quality > best || (quality == best && quality > 0 && name == EncodingGzip) {
+            encoding, best = name, quality
+        }
+    }
+    return encoding
+}
+
+// compressionWriter decides whether to compress once the status and headers are known
+type compressionWriter struct {
+    http.ResponseWriter
+    encoding    string
+    compressor  responseCompressor
+    wroteHeader bool
+}
+
+func (w *compressionWriter) WriteHeader(status int) {
+    if w.wroteHeader {
+        w.ResponseWriter.WriteHeader(status)
+        return
+    }
+    w.wroteHeader = true
+    if compressible(status, w.Header()) {
+        w.Header().Set("Content-Encoding", w.encoding)
+        // the length of the compressed body isn't known upfront
+        w.Header().Del("Content-Length")
+        w.compressor = compressorPools[w.encoding].Get().(responseCompressor)
+        w.compressor.Reset(w.ResponseWriter)
+    }
+    w.ResponseWriter.WriteHeader(status)
+}
+
+// compressible reports whether a response has a body that isn't compressed already
+func compressible(status int, header http.Header) bool {
+    if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+        return false
+    }
+    if header.Get("Content-Encoding") != "" {
+        return false
+    }
+    contentType := header.Get("Content-Type")
+    for _, precompressed := range precompressedTypes {
+        if strings.HasPrefix(contentType, precompressed) {
+            return false
+        }
+    }
+    return true
+}
+
+func (w *compressionWriter) Write(b []byte) (int, error) {
+    if !w.wroteHeader {
+        // the content type is sniffed from the uncompressed body, the server would sniff the compressed one
+        if w.Header().Get("Content-Type") == "" {
+            w.Header().Set("Content-Type", http.DetectContentType(b))
+        }
+        w.WriteHeader(http.StatusOK)
+    }
+    if w.compressor == nil {
+        return w.ResponseWriter.Write(b)
+    }
+    return w.compressor.Write(b)
+}
+
+// Flush sends what was compressed so far to the client, so streaming responses aren't held back
+func (w *compressionWriter) Flush() {
+    if !w.wroteHeader {
+        w.WriteHeader(http.StatusOK)
+    }
+    if w.compressor != nil {
+        if err := w.compressor.Flush(); err != nil {
+            return
+        }
+    }
+    if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+        flusher.Flush()
+    }
+}
+
+func (w *compressionWriter) Unwrap() http.ResponseWriter {
+    return w.ResponseWriter
+}
+
+// close completes the compressed body and returns the compressor to its pool
+func (w *compressionWriter) close() {
+    if w.compressor == nil {
+        return
+    }
+    // the client went away when it fails, the response is over either way
+    _ = w.compressor.Close()
+    w.compressor.Reset(io.Discard)
+    compressorPools[w.encoding].Put(w.compressor)
+    w.compressor = nil
+}
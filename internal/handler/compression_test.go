@@ -0,0 +1,90 @@
+package handler
+
+import (
+    "compress/flate"
+    "compress/gzip"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+    for acceptEncoding, expected := range map[string]string{
+        "":                          "",
+        "br":                        "",
+        "gzip":                      EncodingGzip,
+        "deflate, gzip":             EncodingGzip,
+        "gzip;q=0.5, deflate":       EncodingDeflate,
+        "GZIP;q=0.8, deflate;q=0.2": EncodingGzip,
+        "*":                         EncodingGzip,
+        "gzip;q=0":                  "",
+        "gzip;q=x, deflate;q=0.1":   EncodingDeflate,
+    } {
+        if encoding := negotiateEncoding(acceptEncoding); encoding != expected {
+            t.Errorf("expected %q for %q, got %q", expected, acceptEncoding, encoding)
+        }
+    }
+}
+
+func compress(handler http.HandlerFunc, acceptEncoding string) *httptest.ResponseRecorder {
+    r := httptest.NewRequest(http.MethodGet, "/", nil)
+    r.Header.Set("Accept-Encoding", acceptEncoding)
+    w := httptest.NewRecorder()
+    CompressionMiddleware()(handler).ServeHTTP(w, r)
+    return w
+}
+
+func TestCompressionMiddleware(t *testing.T) {
+    body := strings.Repeat(`{"location":"Yangon"}`, 100)
+    respond := func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "application/json")
+        _, _ = io.WriteString(w, body)
+    }
+
+    w := compress(respond, "gzip")
+    if w.Header().Get("Content-Encoding") != EncodingGzip || w.Header().Get("Vary") != "Accept-Encoding" {
+        t.Fatalf("expected a gzip response varying by Accept-Encoding, got %v", w.Header())
+    }
+    reader, err := gzip.NewReader(w.Body)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if decompressed, err := io.ReadAll(reader); err != nil || string(decompressed) != body {
+        t.Fatalf("expected the body to be gzip compressed, got %d bytes, %v", len(decompressed), err)
+    }
+
+    w = compress(respond, "deflate")
+    if w.Header().Get("Content-Encoding") != EncodingDeflate {
+        t.Fatalf("expected a deflate response, got %v", w.Header())
+    }
+    if decompressed, err := io.ReadAll(flate.NewReader(w.Body)); err != nil || string(decompressed) != body {
+        t.Fatalf("expected the body to be deflate compressed, got %d bytes, %v", len(decompressed), err)
+    }
+
+    if w = compress(respond, ""); w.Header().Get("Content-Encoding") != "" || w.Body.String() != body {
+        t.Errorf("expected an uncompressed response without Accept-Encoding, got %v", w.Header())
+    }
+}
+
+func TestCompressionMiddleware_Skips(t *testing.T) {
+    for name, handler := range map[string]http.HandlerFunc{
+        "no content": func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) },
+        "not modified": func(w http.ResponseWriter, r *http.Request) {
+            w.Header().Set("ETag", `"1"`)
+            w.WriteHeader(http.StatusNotModified)
+        },
+        "precompressed": func(w http.ResponseWriter, r *http.Request) {
+            w.Header().Set("Content-Type", "application/gzip")
+            _, _ = io.WriteString(w, "compressed export")
+        },
+    } {
+        uncompressed := compress(handler, "")
+        w := compress(handler, "gzip")
+        if w.Header().Get("Content-Encoding") != "" || w.Code != uncompressed.Code ||
+            w.Body.String() != uncompressed.Body.String() {
+            t.Errorf("expected the %s response to be sent as it is, got %d %v", name, w.Code, w.Header())
+        }
+    }
+}
@@ -0,0 +1,41 @@
+package handler
+
+import (
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+)
+
+// versionETag returns the entity tag of the results of a query at the version. It is weak, the compressed and
+// uncompressed responses have the same tag.
+func versionETag(version *repositories.TrackingDataVersion) string {
+    return fmt.Sprintf(`W/"%s-%x"`, version.ID.Hex(), version.Count)
+}
+
+// notModified sets the validators of the version on the response and reports whether the request already has
+// the results of that version. If-None-Match is preferred over If-Modified-Since, like RFC 9110 requires.
+func notModified(w http.ResponseWriter, r *http.Request, version *repositories.TrackingDataVersion) bool {
+    etag := versionETag(version)
+    w.Header().Set("ETag", etag)
+    w.Header().Set("Last-Modified", version.ModifiedAt().Format(http.TimeFormat))
+
+    if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+        for _, tag := range strings.Split(ifNoneMatch, ",") {
+            tag = strings.TrimSpace(tag)
+            // the weak comparison, the tags match regardless of W/
+            if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+                return true
+            }
+        }
+        return false
+    }
+    since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+    if err != nil {
+        return false
+    }
+    // Last-Modified has a precision of a second
+    return !version.ModifiedAt().Truncate(time.Second).After(since)
+}
@@ -0,0 +1,181 @@
+package handler
+
+import (
+    "bytes"
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeTrackingService finds the records it has, newest last, and counts the queries finding them. The readings it
+// tracks are stored as they are.
+type fakeTrackingService struct {
+    services.TrackingService
+    records []*repositories.TrackingRecord
+    finds   int
+    query   url.Values
+}
+
+func (s *fakeTrackingService) FindTrackingData(
+    ctx context.Context,
+    query url.Values,
+) ([]*repositories.TrackingRecord, error) {
+    s.finds++
+    s.query = query
+    return s.records, nil
+}
+
+func (s *fakeTrackingService) FindTrackingDataVersion(
+    ctx context.Context,
+    query url.Values,
+) (*repositories.TrackingDataVersion, error) {
+    if len(s.records) == 0 {
+        return nil, nil
+    }
+    version := &repositories.TrackingDataVersion{Count: int64(len(s.records))}
+    for _, record := range s.records {
+        if bytes.Compare(record.ID[:], version.ID[:]) > 0 {
+            version.ID = record.ID
+        }
+    }
+    return version, nil
+}
+
+func (s *fakeTrackingService) TrackVehicle(
+    ctx context.Context,
+    req *services.TrackingDataRequest,
+) (*repositories.TrackingRecord, error) {
+    vehicleID, err := primitive.ObjectIDFromHex(req.VehicleID)
+    if err != nil {
+        return nil, err
+    }
+    record := newTestRecord(time.Now())
+    record.VehicleID, record.Location, record.Mileage = vehicleID, req.Location, req.Mileage
+    record.Status, record.FuelCondition = req.Status, req.FuelCondition
+    record.Lat, record.Lng = req.Lat, req.Lng
+    s.records = append(s.records, record)
+    return record, nil
+}
+
+func newTestRecord(createdAt time.Time) *repositories.TrackingRecord {
+    return repositories.NewTrackingRecord(
+        &models.TrackingData{
+            ID:            primitive.NewObjectID(),
+            VehicleID:     primitive.NewObjectID(),
+            Location:      "Yangon",
+            Mileage:       1200,
+            Status:        models.VehicleStatusActive,
+            FuelCondition: models.FuelConditionFull,
+            CreatedAt:     createdAt,
+            UpdatedAt:     createdAt,
+        },
+    )
+}
+
+func TestV1TrackingHandler_FindTrackingData_Conditional(t *testing.T) {
+    // the reading was stored at 03:04:05
+    record := newTestRecord(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+    record.ID = primitive.NewObjectIDFromTimestamp(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+    etag := versionETag(&repositories.TrackingDataVersion{ID: record.ID, Count: 1})
+    lastModified := "Tue, 02 Jan 2024 03:04:05 GMT"
+
+    for _, test := range []struct {
+        name     string
+        headers  map[string]string
+        expected int
+    }{
+        {"unconditional", nil, http.StatusOK},
+        {"matching etag", map[string]string{"If-None-Match": etag}, http.StatusNotModified},
+        {
+            "strong etag in a list",
+            map[string]string{"If-None-Match": `"other", ` + strings.TrimPrefix(etag, "W/")},
+            http.StatusNotModified,
+        },
+        {"any etag", map[string]string{"If-None-Match": "*"}, http.StatusNotModified},
+        {
+            "etag preferred over the date",
+            map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": lastModified},
+            http.StatusOK,
+        },
+        {"not modified since", map[string]string{"If-Modified-Since": lastModified}, http.StatusNotModified},
+        {"modified since", map[string]string{"If-Modified-Since": "Tue, 02 Jan 2024 03:04:04 GMT"}, http.StatusOK},
+    } {
+        t.Run(
+            test.name, func(t *testing.T) {
+                trackingService := &fakeTrackingService{records: []*repositories.TrackingRecord{record}}
+                h := NewV1TrackingHandler(trackingService, nil, nil, nil, 0, nil)
+                r := httptest.NewRequest(http.MethodGet, "/api/v1/tracking-data", nil)
+                for name, value := range test.headers {
+                    r.Header.Set(name, value)
+                }
+                w := httptest.NewRecorder()
+                h.FindTrackingData(w, r)
+
+                if w.Code != test.expected {
+                    t.Fatalf("expected %d, got %d %s", test.expected, w.Code, w.Body.String())
+                }
+                if w.Header().Get("ETag") != etag || w.Header().Get("Last-Modified") != lastModified {
+                    t.Errorf("expected the validators of the version, got %v", w.Header())
+                }
+                if test.expected == http.StatusNotModified && (w.Body.Len() > 0 || trackingService.finds > 0) {
+                    t.Errorf("expected 304 without finding the results, got %d finds", trackingService.finds)
+                }
+                if test.expected == http.StatusOK && !strings.Contains(w.Body.String(), record.ID.Hex()) {
+                    t.Errorf("expected the results, got %s", w.Body.String())
+                }
+            },
+        )
+    }
+}
+
+func TestV1TrackingHandler_FindTrackingData_NewerVersion(t *testing.T) {
+    start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+    trackingService := &fakeTrackingService{records: []*repositories.TrackingRecord{newTestRecord(start)}}
+    h := NewV1TrackingHandler(trackingService, nil, nil, nil, 0, nil)
+    find := func(etag string) *httptest.ResponseRecorder {
+        r := httptest.NewRequest(http.MethodGet, "/api/v1/tracking-data", nil)
+        r.Header.Set("If-None-Match", etag)
+        w := httptest.NewRecorder()
+        h.FindTrackingData(w, r)
+        return w
+    }
+    etag := find("").Header().Get("ETag")
+
+    for _, test := range []struct {
+        name  string
+        write func()
+    }{
+        {
+            "newer reading", func() {
+                trackingService.records = append(trackingService.records, newTestRecord(start.Add(time.Millisecond)))
+            },
+        },
+        {
+            // a late or backfilled reading is older than the newest one but stored after it
+            "backdated reading", func() {
+                trackingService.records = append(trackingService.records, newTestRecord(start.Add(-time.Hour)))
+            },
+        },
+        {
+            "deleted reading", func() {
+                trackingService.records = trackingService.records[1:]
+            },
+        },
+    } {
+        test.write()
+        w := find(etag)
+        if w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+            t.Fatalf("%s: expected the changed results with a new tag, got %d %q", test.name, w.Code, etag)
+        }
+        etag = w.Header().Get("ETag")
+    }
+}
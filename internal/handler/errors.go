@@ -0,0 +1,243 @@
+package handler
+
+import (
+    "errors"
+    "log"
+    "net/http"
+    "strings"
+    "unicode"
+
+    "github.com/go-playground/validator/v10"
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-common"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/params"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+)
+
+// The codes of the error responses, clients branch on them instead of the messages. The codes of rejected readings
+// are the reasons of the ingestion errors.
+const (
+    CodeBadRequest               = "bad_request"
+    CodeInvalidQuery             = "invalid_query"
+    CodeInvalidID                = "invalid_id"
+    CodeMalformedPayload         = repositories.IngestionReasonMalformed
+    CodeInvalidData              = repositories.IngestionReasonInvalid
+    CodeUnknownVehicle           = repositories.IngestionReasonUnknownVehicle
+    CodeQuarantined              = repositories.IngestionReasonQuarantined
+    CodeEmptyBatch               = "empty_batch"
+    CodeBatchTooLarge            = "batch_too_large"
+    CodePayloadTooLarge          = "payload_too_large"
+    CodeIdempotencyKeyInvalid    = "idempotency_key_invalid"
+    CodeIdempotencyKeyInProgress = "idempotency_key_in_progress"
+    CodeIdempotencyKeyReused     = "idempotency_key_reused"
+    CodeUnauthorized             = "unauthorized"
+    CodeTenantMissing            = "tenant_missing"
+    CodeInvalidTenant            = "invalid_tenant"
+    CodeTenantMismatch           = "tenant_mismatch"
+    CodeClaimsMissing            = "claims_missing"
+    CodeAPIKeyMissing            = "api_key_missing"
+    CodeInvalidAPIKey            = "invalid_api_key"
+    CodeForbidden                = "forbidden"
+    CodeScopeNotGranted          = "scope_not_granted"
+    CodeAdminRequired            = "admin_required"
+    CodeVehicleNotAllowed        = "vehicle_not_allowed"
+    CodePolicyDenied             = "policy_denied"
+    CodeNotFound                 = "not_found"
+    CodeMethodNotAllowed         = "method_not_allowed"
+    CodeConflict                 = "conflict"
+    CodeFeatureSunset            = "feature_sunset"
+    CodeUnprocessable            = "unprocessable"
+    CodeInternal                 = "internal_error"
+    CodeUnavailable              = "unavailable"
+)
+
+// errorCodes are the codes of the errors, the first one the error matches with errors.Is applies
+var errorCodes = []struct {
+    err  error
+    code string
+}{
+    {err: services.ErrMalformedPayload, code: CodeMalformedPayload},
+    {err: services.ErrInvalidTrackingData, code: CodeInvalidData},
+    {err: services.ErrUnknownVehicle, code: CodeUnknownVehicle},
+    {err: services.ErrQuarantinedReading, code: CodeQuarantined},
+    {err: params.ErrInvalidQuery, code: CodeInvalidQuery},
+    {err: repositories.ErrInvalidID, code: CodeInvalidID},
+    {err: ErrEmptyBatch, code: CodeEmptyBatch},
+    {err: ErrBatchTooLarge, code: CodeBatchTooLarge},
+    {err: services.ErrIdempotencyKeyTooLong, code: CodeIdempotencyKeyInvalid},
+    {err: services.ErrIdempotencyKeyInProgress, code: CodeIdempotencyKeyInProgress},
+    {err: services.ErrIdempotencyKeyReused, code: CodeIdempotencyKeyReused},
+    {err: tenant.ErrTenantMissing, code: CodeTenantMissing},
+    {err: tenant.ErrInvalidTenant, code: CodeInvalidTenant},
+    {err: tenant.ErrTenantMismatch, code: CodeTenantMismatch},
+    {err: ErrClaimsMissing, code: CodeClaimsMissing},
+    {err: ErrAPIKeyMissing, code: CodeAPIKeyMissing},
+    {err: services.ErrInvalidAPIKey, code: CodeInvalidAPIKey},
+    {err: ErrScopeNotGranted, code: CodeScopeNotGranted},
+    {err: services.ErrAdminRequired, code: CodeAdminRequired},
+    {err: services.ErrVehicleNotAllowed, code: CodeVehicleNotAllowed},
+    {err: services.ErrPolicyDenied, code: CodePolicyDenied},
+    {err: services.ErrFeatureSunset, code: CodeFeatureSunset},
+    {err: ErrMethodNotAllowed, code: CodeMethodNotAllowed},
+    {err: repositories.ErrNotFound, code: CodeNotFound},
+    {err: ErrNotFound, code: CodeNotFound},
+    {err: repositories.ErrDuplicate, code: CodeConflict},
+    {err: repositories.ErrTransient, code: CodeUnavailable},
+}
+
+// statusCodes are the codes of the errors without their own, by the status they are answered with
+var statusCodes = map[int]string{
+    http.StatusBadRequest:            CodeBadRequest,
+    http.StatusUnauthorized:          CodeUnauthorized,
+    http.StatusForbidden:             CodeForbidden,
+    http.StatusNotFound:              CodeNotFound,
+    http.StatusMethodNotAllowed:      CodeMethodNotAllowed,
+    http.StatusConflict:              CodeConflict,
+    http.StatusRequestEntityTooLarge: CodePayloadTooLarge,
+    http.StatusUnprocessableEntity:   CodeUnprocessable,
+    http.StatusServiceUnavailable:    CodeUnavailable,
+}
+
+// ErrorCode returns the code of the error answered with status
+func ErrorCode(err error, status int) string {
+    for _, known := range errorCodes {
+        if errors.Is(err, known.err) {
+            return known.code
+        }
+    }
+    var validationErrs validator.ValidationErrors
+    if errors.As(err, &validationErrs) {
+        return CodeInvalidData
+    }
+    var tooLarge *http.MaxBytesError
+    if errors.As(err, &tooLarge) {
+        return CodePayloadTooLarge
+    }
+    if code, ok := statusCodes[status]; ok {
+        return code
+    }
+    if status >= http.StatusInternalServerError {
+        return CodeInternal
+    }
+    return CodeBadRequest
+}
+
+// FieldError is an invalid field of a payload or query parameter of a request. Code is the failed validation, like
+// required or oneof for the payloads and invalid for the query parameters.
+type FieldError struct {
+    Field   string `json:"field"`
+    Code    string `json:"code"`
+    Message string `json:"message"`
+    Value   string `json:"value,omitempty"`
+}
+
+// ErrorResponse is the envelope of every error response, the data is always null. Fields lists the invalid fields of
+// the request. Error is the message of each invalid payload field by its lowercase name, like in the responses before
+// the codes.
+type ErrorResponse struct {
+    Success bool              `json:"success"`
+    Code    string            `json:"code" doc:"stable code of the error, like not_found or invalid_data"`
+    Message string            `json:"message"`
+    Fields  []*FieldError     `json:"fields,omitempty"`
+    Data    any               `json:"data"`
+    Error   map[string]string `json:"error" doc:"message of each invalid payload field by its lowercase name"`
+}
+
+// NewErrorResponse returns the response to err answered with status
+func NewErrorResponse(status int, err error) *ErrorResponse {
+    response := &ErrorResponse{Code: ErrorCode(err, status), Message: err.Error()}
+    var validationErrs validator.ValidationErrors
+    var invalid *params.Error
+    switch {
+    case errors.As(err, &validationErrs):
+        response.Error = make(map[string]string, len(validationErrs))
+        for _, fieldErr := range validationErrs {
+            response.Error[strings.ToLower(fieldErr.Field())] = common.FormatValidationMessage(fieldErr.Tag())
+            response.Fields = append(
+                response.Fields, &FieldError{
+                    Field:   snakeCase(fieldErr.Field()),
+                    Code:    fieldErr.Tag(),
+                    Message: validationMessage(fieldErr),
+                },
+            )
+        }
+    case errors.As(err, &invalid):
+        for _, field := range invalid.Fields {
+            response.Fields = append(
+                response.Fields,
+                &FieldError{Field: field.Field, Code: "invalid", Message: field.Message, Value: field.Value},
+            )
+        }
+    }
+    return response
+}
+
+// validationMessage describes the failed validation of a payload field
+func validationMessage(err validator.FieldError) string {
+    switch err.Tag() {
+    case "required":
+        return "is required"
+    case "required_with":
+        return "is required with " + snakeCase(err.Param())
+    case "oneof":
+        return "must be one of " + strings.ReplaceAll(err.Param(), " ", ", ")
+    case "max":
+        return "must be at most " + err.Param()
+    case "min":
+        return "must be at least " + err.Param()
+    case "mongodb":
+        return "must be an ObjectID of 24 hex digits"
+    case "latitude", "longitude":
+        return "must be a " + err.Tag()
+    }
+    return "failed the " + err.Tag() + " validation"
+}
+
+// snakeCase returns the JSON name of a Go field name, like vehicle_id for VehicleID
+func snakeCase(name string) string {
+    var snake strings.Builder
+    runes := []rune(name)
+    for i, r := range runes {
+        // a word starts at an upper case letter after a lower case one, or before one in an acronym
+        if i > 0 && unicode.IsUpper(r) &&
+            (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+            snake.WriteByte('_')
+        }
+        snake.WriteRune(unicode.ToLower(r))
+    }
+    return snake.String()
+}
+
+// writeError answers err with status in the error envelope
+func writeError(status int, w http.ResponseWriter, err error) {
+    w.Header().Set("Content-Type", common.ApplicationJSON)
+    w.WriteHeader(status)
+    if err := json.NewEncoder(w).Encode(NewErrorResponse(status, err)); err != nil {
+        log.Println("Failed to encode error response", err)
+    }
+}
+
+// errorStatus maps the kinds of storage failures to their status, other errors get status
+func errorStatus(err error, status int) int {
+    switch {
+    case errors.Is(err, repositories.ErrNotFound):
+        return http.StatusNotFound
+    case errors.Is(err, repositories.ErrDuplicate):
+        return http.StatusConflict
+    case errors.Is(err, repositories.ErrTransient):
+        return http.StatusServiceUnavailable
+    }
+    return status
+}
+
+// handleError answers err like writeError, except for the kinds of storage failures, so a Mongo timeout is answered
+// 503 instead of blaming the request
+func handleError(status int, w http.ResponseWriter, err error) {
+    status = errorStatus(err, status)
+    if status == http.StatusServiceUnavailable {
+        w.Header().Set("Retry-After", "1")
+    }
+    writeError(status, w, err)
+}
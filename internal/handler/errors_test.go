@@ -0,0 +1,160 @@
+package handler
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "testing"
+
+    "github.com/go-playground/validator/v10"
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/params"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+func decodeErrorResponse(t *testing.T, w *httptest.ResponseRecorder) *ErrorResponse {
+    t.Helper()
+    var response ErrorResponse
+    if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+        t.Fatalf("expected the error envelope, got %s: %v", w.Body.String(), err)
+    }
+    return &response
+}
+
+func TestHandleError_Codes(t *testing.T) {
+    for _, test := range []struct {
+        name     string
+        status   int
+        err      error
+        expected int
+        code     string
+    }{
+        {
+            "invalid reading",
+            http.StatusBadRequest,
+            services.ErrInvalidTrackingData,
+            http.StatusBadRequest,
+            CodeInvalidData,
+        },
+        {"invalid id", http.StatusBadRequest, repositories.ErrInvalidID, http.StatusBadRequest, CodeInvalidID},
+        {
+            "wrapped not found",
+            http.StatusInternalServerError,
+            fmt.Errorf("vehicle: %w", repositories.ErrNotFound),
+            http.StatusNotFound,
+            CodeNotFound,
+        },
+        {"duplicate", http.StatusInternalServerError, repositories.ErrDuplicate, http.StatusConflict, CodeConflict},
+        {"unknown by status", http.StatusForbidden, errors.New("nope"), http.StatusForbidden, CodeForbidden},
+        {
+            "unknown failure",
+            http.StatusInternalServerError,
+            errors.New("boom"),
+            http.StatusInternalServerError,
+            CodeInternal,
+        },
+    } {
+        t.Run(
+            test.name, func(t *testing.T) {
+                w := httptest.NewRecorder()
+                handleError(test.status, w, test.err)
+
+                if w.Code != test.expected || w.Header().Get("Retry-After") != "" {
+                    t.Fatalf("expected %d without Retry-After, got %d %v", test.expected, w.Code, w.Header())
+                }
+                response := decodeErrorResponse(t, w)
+                if response.Success || response.Code != test.code || response.Message != test.err.Error() ||
+                    response.Data != nil {
+                    t.Errorf("expected the %s envelope, got %+v", test.code, response)
+                }
+            },
+        )
+    }
+}
+
+func TestHandleError_Transient(t *testing.T) {
+    w := httptest.NewRecorder()
+    handleError(http.StatusInternalServerError, w, fmt.Errorf("find: %w", repositories.ErrTransient))
+
+    if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "1" {
+        t.Fatalf("expected 503 with Retry-After, got %d %v", w.Code, w.Header())
+    }
+    if response := decodeErrorResponse(t, w); response.Code != CodeUnavailable {
+        t.Errorf("expected the %s code, got %q", CodeUnavailable, response.Code)
+    }
+
+    // writeError keeps the status of the caller
+    w = httptest.NewRecorder()
+    writeError(http.StatusInternalServerError, w, repositories.ErrTransient)
+    if w.Code != http.StatusInternalServerError || w.Header().Get("Retry-After") != "" {
+        t.Errorf("expected the status as it is, got %d %v", w.Code, w.Header())
+    }
+}
+
+func TestWriteError_ValidationFields(t *testing.T) {
+    lat := 16.8
+    request := &services.TrackingDataRequest{
+        TrackingDataRequest: models.TrackingDataRequest{
+            VehicleID:     "6650c3e0f1a2b3c4d5e6f7a8",
+            Mileage:       1200,
+            Status:        models.VehicleStatusActive,
+            FuelCondition: models.FuelConditionFull,
+        },
+        Lat: &lat,
+    }
+    err := validator.New().Struct(request)
+    if err == nil {
+        t.Fatal("expected the reading without a location and lng to be invalid")
+    }
+    w := httptest.NewRecorder()
+    writeError(http.StatusBadRequest, w, err)
+
+    response := decodeErrorResponse(t, w)
+    if w.Code != http.StatusBadRequest || response.Code != CodeInvalidData {
+        t.Fatalf("expected 400 %s, got %d %q", CodeInvalidData, w.Code, response.Code)
+    }
+    expected := []FieldError{
+        {Field: "location", Code: "required", Message: "is required"},
+        {Field: "lng", Code: "required_with", Message: "is required with lat"},
+    }
+    if len(response.Fields) != len(expected) {
+        t.Fatalf("expected %d fields, got %+v", len(expected), response.Fields)
+    }
+    for i, field := range response.Fields {
+        if *field != expected[i] {
+            t.Errorf("expected %+v, got %+v", expected[i], *field)
+        }
+    }
+    if _, ok := response.Error["lng"]; !ok || response.Error["location"] == "" {
+        t.Errorf("expected the messages by lowercase field name, got %v", response.Error)
+    }
+}
+
+func TestWriteError_QueryFields(t *testing.T) {
+    parser := params.NewParser(url.Values{"limit": {"many"}})
+    parser.Int("limit", 1, 100)
+    parser.Invalid("from", "must be before to")
+    w := httptest.NewRecorder()
+    writeError(http.StatusBadRequest, w, parser.Err())
+
+    response := decodeErrorResponse(t, w)
+    if response.Code != CodeInvalidQuery || len(response.Fields) != 2 {
+        t.Fatalf("expected the %s code with both fields, got %q %+v", CodeInvalidQuery, response.Code,
+            response.Fields)
+    }
+    if field := *response.Fields[0]; field != (FieldError{
+        Field:   "limit",
+        Code:    "invalid",
+        Message: "must be an integer",
+        Value:   "many",
+    }) {
+        t.Errorf("expected the invalid limit with its value, got %+v", field)
+    }
+    if field := response.Fields[1]; field.Field != "from" || field.Value != "" {
+        t.Errorf("expected the absent from without a value, got %+v", field)
+    }
+}
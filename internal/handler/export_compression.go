@@ -0,0 +1,129 @@
+package handler
+
+import (
+    "compress/gzip"
+    "errors"
+    "fmt"
+    "io"
+    "mime"
+    "strings"
+
+    "github.com/klauspost/compress/zstd"
+)
+
+const (
+    ExportCompressionGzip = "gzip"
+    ExportCompressionZstd = "zstd"
+)
+
+var ErrUnsupportedExportCompression = errors.New("unsupported export compression")
+
+// exportCompressor compresses the file written by an exportWriter
+type exportCompressor interface {
+    io.Writer
+    Flush() error
+    Close() error
+}
+
+// negotiateExportCompression returns the compression of the compression parameter, or else the first compressed
+// media type in the Accept header. An empty compression means the file isn't compressed.
+func negotiateExportCompression(param string, accept string) (string, error) {
+    switch param {
+    case "":
+    case "none":
+        return "", nil
+    case ExportCompressionGzip, ExportCompressionZstd:
+        return param, nil
+    default:
+        return "", fmt.Errorf("%w: %s", ErrUnsupportedExportCompression, param)
+    }
+
+    for _, mediaRange := range strings.Split(accept, ",") {
+        mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+        if err != nil || params["q"] == "0" {
+            continue
+        }
+        switch mediaType {
+        case "application/zstd":
+            return ExportCompressionZstd, nil
+        case "application/gzip":
+            return ExportCompressionGzip, nil
+        }
+    }
+    return "", nil
+}
+
+func newExportCompressor(w io.Writer, compression string) (exportCompressor, error) {
+    switch compression {
+    case ExportCompressionGzip:
+        return gzip.NewWriter(w), nil
+    case ExportCompressionZstd:
+        // a single encoder goroutine is enough, the export is limited by the database cursor
+        return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+    default:
+        return nil, fmt.Errorf("%w: %s", ErrUnsupportedExportCompression, compression)
+    }
+}
+
+// compressedExportWriter compresses the file of an exportWriter, which must write to the compressor.
+// The compressed file keeps the extension of the format, e.g. tracking-data.csv.zst.
+type compressedExportWriter struct {
+    exportWriter
+    compressor  exportCompressor
+    compression string
+    begun       bool
+    closed      bool
+}
+
+func (e *compressedExportWriter) ContentType() string {
+    if e.compression == ExportCompressionZstd {
+        return "application/zstd"
+    }
+    return "application/gzip"
+}
+
+func (e *compressedExportWriter) Extension() string {
+    if e.compression == ExportCompressionZstd {
+        return e.exportWriter.Extension() + ".zst"
+    }
+    return e.exportWriter.Extension() + ".gz"
+}
+
+func (e *compressedExportWriter) Begin() error {
+    e.begun = true
+    return e.exportWriter.Begin()
+}
+
+func (e *compressedExportWriter) End() error {
+    if err := e.exportWriter.End(); err != nil {
+        return err
+    }
+    if err := e.exportWriter.Flush(); err != nil {
+        return err
+    }
+    return e.Close()
+}
+
+// Flush flushes the buffered records through the compressor, so the client receives them
+func (e *compressedExportWriter) Flush() error {
+    if e.closed {
+        return nil
+    }
+    if err := e.exportWriter.Flush(); err != nil {
+        return err
+    }
+    return e.compressor.Flush()
+}
+
+// Close completes the compressed file, it is safe to call more than once.
+// Nothing is written when the file wasn't begun, the response may hold an error instead.
+func (e *compressedExportWriter) Close() error {
+    if e.closed {
+        return nil
+    }
+    e.closed = true
+    if !e.begun {
+        return nil
+    }
+    return e.compressor.Close()
+}
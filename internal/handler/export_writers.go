@@ -0,0 +1,338 @@
+package handler
+
+import (
+    "bufio"
+    "encoding/csv"
+    "encoding/xml"
+    "io"
+    "strconv"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/geojson"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/parquet"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+)
+
+var csvHeader = []string{"id", "vehicle_id", "location", "mileage", "status", "fuel_condition", "lat", "lng", "created_at"}
+
+type csvExportWriter struct {
+    writer *csv.Writer
+}
+
+func newCSVExportWriter(w io.Writer) *csvExportWriter {
+    return &csvExportWriter{writer: csv.NewWriter(w)}
+}
+
+func (e *csvExportWriter) ContentType() string {
+    return "text/csv; charset=utf-8"
+}
+
+func (e *csvExportWriter) Extension() string {
+    return ExportFormatCSV
+}
+
+func (e *csvExportWriter) Begin() error {
+    return e.writer.Write(csvHeader)
+}
+
+func (e *csvExportWriter) Write(record *repositories.TrackingRecord) error {
+    lat, lng := "", ""
+    if point, ok := record.Point(); ok {
+        lat = strconv.FormatFloat(point.Lat, 'f', -1, 64)
+        lng = strconv.FormatFloat(point.Lng, 'f', -1, 64)
+    }
+    return e.writer.Write(
+        []string{
+            record.ID.Hex(),
+            record.VehicleID.Hex(),
+            record.Location,
+            strconv.FormatFloat(record.Mileage, 'f', -1, 64),
+            string(record.Status),
+            string(record.FuelCondition),
+            lat,
+            lng,
+            timestamp.Format(record.CreatedAt),
+        },
+    )
+}
+
+func (e *csvExportWriter) End() error {
+    return nil
+}
+
+func (e *csvExportWriter) Flush() error {
+    e.writer.Flush()
+    return e.writer.Error()
+}
+
+// ndjsonExportWriter writes a tracking record as JSON per line, the same as the records of the API responses
+type ndjsonExportWriter struct {
+    writer  *bufio.Writer
+    encoder *json.Encoder
+}
+
+func newNDJSONExportWriter(w io.Writer) *ndjsonExportWriter {
+    writer := bufio.NewWriter(w)
+    return &ndjsonExportWriter{writer: writer, encoder: json.NewEncoder(writer)}
+}
+
+func (e *ndjsonExportWriter) ContentType() string {
+    return "application/x-ndjson"
+}
+
+func (e *ndjsonExportWriter) Extension() string {
+    return ExportFormatNDJSON
+}
+
+func (e *ndjsonExportWriter) Begin() error {
+    return nil
+}
+
+func (e *ndjsonExportWriter) Write(record *repositories.TrackingRecord) error {
+    // Encode terminates each record with a newline
+    return e.encoder.Encode(record)
+}
+
+func (e *ndjsonExportWriter) End() error {
+    return nil
+}
+
+func (e *ndjsonExportWriter) Flush() error {
+    return e.writer.Flush()
+}
+
+// parquetExportWriter writes the tracking records as a Parquet file, the rows are written a row group at a time
+type parquetExportWriter struct {
+    out    io.Writer
+    writer *repositories.TrackingParquetWriter
+}
+
+func newParquetExportWriter(w io.Writer) *parquetExportWriter {
+    return &parquetExportWriter{out: w}
+}
+
+func (e *parquetExportWriter) ContentType() string {
+    return parquet.ContentType
+}
+
+func (e *parquetExportWriter) Extension() string {
+    return ExportFormatParquet
+}
+
+func (e *parquetExportWriter) Begin() (err error) {
+    e.writer, err = repositories.NewTrackingParquetWriter(e.out)
+    return err
+}
+
+func (e *parquetExportWriter) Write(record *repositories.TrackingRecord) error {
+    return e.writer.Write(record)
+}
+
+func (e *parquetExportWriter) End() error {
+    return e.writer.Close()
+}
+
+// Flush has nothing to flush, the row groups are written to the client as they are complete
+func (e *parquetExportWriter) Flush() error {
+    return nil
+}
+
+// jsonExportWriter writes the tracking records as the elements of a JSON array, each one as soon as it is read,
+// so large results aren't held in memory to encode them as a whole
+type jsonExportWriter struct {
+    writer  *bufio.Writer
+    records int
+}
+
+func newJSONExportWriter(w io.Writer) *jsonExportWriter {
+    return &jsonExportWriter{writer: bufio.NewWriter(w)}
+}
+
+func (e *jsonExportWriter) ContentType() string {
+    return "application/json"
+}
+
+func (e *jsonExportWriter) Extension() string {
+    return ExportFormatJSON
+}
+
+func (e *jsonExportWriter) Begin() error {
+    return e.writer.WriteByte('[')
+}
+
+func (e *jsonExportWriter) Write(record *repositories.TrackingRecord) error {
+    buf, err := json.Marshal(record)
+    if err != nil {
+        return err
+    }
+    if e.records > 0 {
+        if err := e.writer.WriteByte(','); err != nil {
+            return err
+        }
+    }
+    e.records++
+    _, err = e.writer.Write(buf)
+    return err
+}
+
+func (e *jsonExportWriter) End() error {
+    return e.writer.WriteByte(']')
+}
+
+func (e *jsonExportWriter) Flush() error {
+    return e.writer.Flush()
+}
+
+// geoJSONExportWriter writes a FeatureCollection with a Point feature per record,
+// followed by a LineString feature of the whole route. Records without coordinates are skipped.
+type geoJSONExportWriter struct {
+    writer    *bufio.Writer
+    vehicleID string
+    route     [][]float64
+    features  int
+}
+
+func newGeoJSONExportWriter(w io.Writer, vehicleID string) *geoJSONExportWriter {
+    return &geoJSONExportWriter{writer: bufio.NewWriter(w), vehicleID: vehicleID}
+}
+
+func (e *geoJSONExportWriter) ContentType() string {
+    return geojson.ContentType
+}
+
+func (e *geoJSONExportWriter) Extension() string {
+    return ExportFormatGeoJSON
+}
+
+func (e *geoJSONExportWriter) Begin() error {
+    _, err := e.writer.WriteString(`{"type":"FeatureCollection","features":[`)
+    return err
+}
+
+func (e *geoJSONExportWriter) Write(record *repositories.TrackingRecord) error {
+    point, ok := record.Point()
+    if !ok {
+        return nil
+    }
+    e.route = append(e.route, []float64{point.Lng, point.Lat})
+    return e.writeFeature(
+        geojson.NewFeature(
+            record.ID.Hex(),
+            geojson.NewPoint(point.Lng, point.Lat),
+            map[string]any{
+                "vehicle_id":     record.VehicleID.Hex(),
+                "location":       record.Location,
+                "mileage":        record.Mileage,
+                "status":         record.Status,
+                "fuel_condition": record.FuelCondition,
+                "time":           timestamp.Format(record.CreatedAt),
+            },
+        ),
+    )
+}
+
+func (e *geoJSONExportWriter) End() error {
+    if len(e.route) >= 2 {
+        err := e.writeFeature(
+            geojson.NewFeature(
+                "route",
+                geojson.NewLineString(e.route),
+                map[string]any{"vehicle_id": e.vehicleID, "points": len(e.route)},
+            ),
+        )
+        if err != nil {
+            return err
+        }
+    }
+    _, err := e.writer.WriteString("]}")
+    return err
+}
+
+func (e *geoJSONExportWriter) Flush() error {
+    return e.writer.Flush()
+}
+
+func (e *geoJSONExportWriter) writeFeature(feature *geojson.Feature) error {
+    buf, err := json.Marshal(feature)
+    if err != nil {
+        return err
+    }
+    if e.features > 0 {
+        if err := e.writer.WriteByte(','); err != nil {
+            return err
+        }
+    }
+    e.features++
+    _, err = e.writer.Write(buf)
+    return err
+}
+
+type gpxTrackPoint struct {
+    XMLName xml.Name `xml:"trkpt"`
+    Lat     float64  `xml:"lat,attr"`
+    Lon     float64  `xml:"lon,attr"`
+    Time    string   `xml:"time"`
+    Desc    string   `xml:"desc,omitempty"`
+}
+
+// gpxExportWriter writes a GPX 1.1 document with a single track of the vehicle's route.
+// Records without coordinates are skipped.
+type gpxExportWriter struct {
+    writer    *bufio.Writer
+    encoder   *xml.Encoder
+    vehicleID string
+}
+
+func newGPXExportWriter(w io.Writer, vehicleID string) *gpxExportWriter {
+    writer := bufio.NewWriter(w)
+    return &gpxExportWriter{writer: writer, encoder: xml.NewEncoder(writer), vehicleID: vehicleID}
+}
+
+func (e *gpxExportWriter) ContentType() string {
+    return "application/gpx+xml"
+}
+
+func (e *gpxExportWriter) Extension() string {
+    return ExportFormatGPX
+}
+
+func (e *gpxExportWriter) Begin() error {
+    if _, err := e.writer.WriteString(
+        xml.Header + `<gpx version="1.1" creator="tracking-svc" xmlns="http://www.topografix.com/GPX/1/1"><trk><name>`,
+    ); err != nil {
+        return err
+    }
+    if err := xml.EscapeText(e.writer, []byte(e.vehicleID)); err != nil {
+        return err
+    }
+    _, err := e.writer.WriteString("</name><trkseg>")
+    return err
+}
+
+func (e *gpxExportWriter) Write(record *repositories.TrackingRecord) error {
+    point, ok := record.Point()
+    if !ok {
+        return nil
+    }
+    return e.encoder.Encode(
+        gpxTrackPoint{
+            Lat:  point.Lat,
+            Lon:  point.Lng,
+            Time: timestamp.Format(record.CreatedAt),
+            Desc: string(record.Status) + " " + record.Location,
+        },
+    )
+}
+
+func (e *gpxExportWriter) End() error {
+    _, err := e.writer.WriteString("</trkseg></trk></gpx>")
+    return err
+}
+
+func (e *gpxExportWriter) Flush() error {
+    if err := e.encoder.Flush(); err != nil {
+        return err
+    }
+    return e.writer.Flush()
+}
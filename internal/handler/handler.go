@@ -4,4 +4,95 @@ import "net/http"
 
 type TrackingHandler interface {
     FindTrackingData(w http.ResponseWriter, r *http.Request)
+    FindVehicleTrackingData(w http.ResponseWriter, r *http.Request)
+    FindTrackingDataByID(w http.ResponseWriter, r *http.Request)
+    CreateTrackingData(w http.ResponseWriter, r *http.Request)
+    CreateTrackingDataBatch(w http.ResponseWriter, r *http.Request)
+    ExportTrackingData(w http.ResponseWriter, r *http.Request)
+    BatchQueryTrackingData(w http.ResponseWriter, r *http.Request)
+    FindRoute(w http.ResponseWriter, r *http.Request)
+    DeleteTrackingData(w http.ResponseWriter, r *http.Request)
+    FindDeletionAudits(w http.ResponseWriter, r *http.Request)
+}
+
+type GeofenceHandler interface {
+    ExportGeofences(w http.ResponseWriter, r *http.Request)
+    ImportGeofences(w http.ResponseWriter, r *http.Request)
+}
+
+type IngestionErrorHandler interface {
+    FindIngestionErrors(w http.ResponseWriter, r *http.Request)
+}
+
+type TrackingStatsHandler interface {
+    TrackingDataStats(w http.ResponseWriter, r *http.Request)
+}
+
+type VendorHandler interface {
+    CreateVendor(w http.ResponseWriter, r *http.Request)
+    FindVendors(w http.ResponseWriter, r *http.Request)
+    SetVendorDevices(w http.ResponseWriter, r *http.Request)
+    FindVendorIngestionErrors(w http.ResponseWriter, r *http.Request)
+    FindVendorDeviceHealth(w http.ResponseWriter, r *http.Request)
+}
+
+type DeploymentHealthHandler interface {
+    DeploymentHealth(w http.ResponseWriter, r *http.Request)
+}
+
+type FuelAnomalyHandler interface {
+    FindFuelAnomalies(w http.ResponseWriter, r *http.Request)
+}
+
+type MaintenanceHandler interface {
+    FindThresholds(w http.ResponseWriter, r *http.Request)
+    SetThreshold(w http.ResponseWriter, r *http.Request)
+    FindEvents(w http.ResponseWriter, r *http.Request)
+}
+
+type FreshnessHandler interface {
+    FindExpectedIntervals(w http.ResponseWriter, r *http.Request)
+    SetExpectedInterval(w http.ResponseWriter, r *http.Request)
+}
+
+type TrackingPollHandler interface {
+    PollTrackingData(w http.ResponseWriter, r *http.Request)
+}
+
+type AccessHandler interface {
+    FindAssignments(w http.ResponseWriter, r *http.Request)
+    SetAssignment(w http.ResponseWriter, r *http.Request)
+}
+
+type DiagnosticsHandler interface {
+    Diagnostics(w http.ResponseWriter, r *http.Request)
+}
+
+type DeprecationHandler interface {
+    Deprecations(w http.ResponseWriter, r *http.Request)
+}
+
+type StatusSuggestionHandler interface {
+    FindStatusSuggestions(w http.ResponseWriter, r *http.Request)
+    ResolveStatusSuggestion(w http.ResponseWriter, r *http.Request)
+}
+
+type VehicleEventHandler interface {
+    VehicleEventStats(w http.ResponseWriter, r *http.Request)
+}
+
+type AccessAuditHandler interface {
+    FindAccessAudits(w http.ResponseWriter, r *http.Request)
+}
+
+type OpenAPIHandler interface {
+    OpenAPI(w http.ResponseWriter, r *http.Request)
+    SwaggerUI(w http.ResponseWriter, r *http.Request)
+}
+
+type SimulationHandler interface {
+    SimulationStatus(w http.ResponseWriter, r *http.Request)
+    StartSimulation(w http.ResponseWriter, r *http.Request)
+    StopSimulation(w http.ResponseWriter, r *http.Request)
+    SetSimulationSpeed(w http.ResponseWriter, r *http.Request)
 }
@@ -0,0 +1,99 @@
+package handler
+
+import (
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/cdn"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+)
+
+// cacheVary is the request header the responses depend on besides the url, the user of the token and its tenant
+// limit what a query returns
+const cacheVary = "Authorization"
+
+// HistoricalCache lets CDNs cache the successful responses of tracking data queries whose to parameter is
+// immutable by the policy. They get a Cache-Control with the max age of the policy and the surrogate keys of the
+// vehicles they are about, the vehicleID path parameter or vehicle_id, or of all vehicles of the tenant, so
+// corrections can purge them. Other responses aren't cached.
+func HistoricalCache(policy cdn.Policy) func(http.HandlerFunc) http.HandlerFunc {
+    return func(next http.HandlerFunc) http.HandlerFunc {
+        return func(w http.ResponseWriter, r *http.Request) {
+            to, err := time.Parse(time.RFC3339, r.URL.Query().Get("to"))
+            if err != nil || !policy.Immutable(to, time.Now()) {
+                w.Header().Set("Cache-Control", "no-cache")
+                next(w, r)
+                return
+            }
+
+            vehicleID := r.PathValue("vehicleID")
+            if vehicleID == "" {
+                vehicleID = r.URL.Query().Get("vehicle_id")
+            }
+            // a selection of vehicles is purged with any of them
+            var keys []string
+            for _, id := range strings.Split(vehicleID, ",") {
+                if id = strings.TrimSpace(id); id != "" {
+                    keys = append(keys, cdn.VehicleKey(id))
+                }
+            }
+            key := strings.Join(keys, " ")
+            if len(keys) == 0 {
+                id, _ := tenant.FromContext(r.Context())
+                key = cdn.FleetKey(id)
+            }
+            next(
+                &cacheHeaderWriter{
+                    ResponseWriter: w,
+                    cacheControl:   policy.CacheControl(),
+                    surrogateKeys:  cdn.AllKey + " " + key,
+                },
+                r,
+            )
+        }
+    }
+}
+
+// cacheHeaderWriter sets the caching headers once the status is known, only successful responses are cached. Not
+// modified responses repeat the headers of the response they revalidate.
+type cacheHeaderWriter struct {
+    http.ResponseWriter
+    cacheControl  string
+    surrogateKeys string
+    wroteHeader   bool
+}
+
+func (w *cacheHeaderWriter) WriteHeader(status int) {
+    if !w.wroteHeader {
+        w.wroteHeader = true
+        if status == http.StatusOK || status == http.StatusNotModified {
+            w.Header().Set("Cache-Control", w.cacheControl)
+            w.Header().Set(cdn.SurrogateKeyHeader, w.surrogateKeys)
+            w.Header().Add("Vary", cacheVary)
+        } else {
+            w.Header().Set("Cache-Control", "no-store")
+        }
+    }
+    w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *cacheHeaderWriter) Write(b []byte) (int, error) {
+    if !w.wroteHeader {
+        w.WriteHeader(http.StatusOK)
+    }
+    return w.ResponseWriter.Write(b)
+}
+
+func (w *cacheHeaderWriter) Flush() {
+    if !w.wroteHeader {
+        w.WriteHeader(http.StatusOK)
+    }
+    if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+        flusher.Flush()
+    }
+}
+
+func (w *cacheHeaderWriter) Unwrap() http.ResponseWriter {
+    return w.ResponseWriter
+}
@@ -0,0 +1,115 @@
+package handler
+
+import (
+    "bytes"
+    "errors"
+    "io"
+    "log"
+    "net/http"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+)
+
+const (
+    IdempotencyKeyHeader = "Idempotency-Key"
+    // IdempotentReplayedHeader is set on the responses repeated for a retry
+    IdempotentReplayedHeader = "Idempotent-Replayed"
+)
+
+// Idempotency answers the retries of a request sent with an Idempotency-Key with the response of the first request,
+// so retried readings aren't stored twice. The key can't be reused for a different method, path or body. Responses
+// with a 5xx status aren't kept, the retries of the request are processed again. Requests without the header are
+// processed as usual.
+func Idempotency(idempotencyService services.IdempotencyService) func(http.HandlerFunc) http.HandlerFunc {
+    return func(next http.HandlerFunc) http.HandlerFunc {
+        return func(w http.ResponseWriter, r *http.Request) {
+            key := r.Header.Get(IdempotencyKeyHeader)
+            if key == "" {
+                next(w, r)
+                return
+            }
+            body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBatchRequestBodySize))
+            if err != nil {
+                writeError(http.StatusBadRequest, w, err)
+                return
+            }
+            r.Body = io.NopCloser(bytes.NewReader(body))
+
+            request := append([]byte(r.Method+" "+r.URL.Path+"\n"), body...)
+            response, err := idempotencyService.Begin(r.Context(), key, request)
+            switch {
+            case errors.Is(err, services.ErrIdempotencyKeyTooLong):
+                writeError(http.StatusBadRequest, w, err)
+                return
+            case errors.Is(err, services.ErrIdempotencyKeyInProgress):
+                w.Header().Set("Retry-After", "1")
+                writeError(http.StatusConflict, w, err)
+                return
+            case errors.Is(err, services.ErrIdempotencyKeyReused):
+                writeError(http.StatusUnprocessableEntity, w, err)
+                return
+            case err != nil:
+                handleError(http.StatusInternalServerError, w, err)
+                return
+            case response != nil:
+                // without a content type the response is sniffed again, like the first time
+                if response.ContentType != "" {
+                    w.Header().Set("Content-Type", response.ContentType)
+                }
+                w.Header().Set(IdempotentReplayedHeader, "true")
+                w.WriteHeader(response.Status)
+                if _, err := w.Write(response.Body); err != nil {
+                    log.Printf("Failed to write the replayed response: %v", err)
+                }
+                return
+            }
+
+            recorder := &idempotencyRecorder{ResponseWriter: w}
+            next(recorder, r)
+
+            // the outcome is kept even when the client is gone, it is the one retrying
+            ctx := tenant.Detach(r.Context())
+            if recorder.status == 0 || recorder.status >= http.StatusInternalServerError {
+                err = idempotencyService.Release(ctx, key)
+            } else {
+                err = idempotencyService.Complete(
+                    ctx, key, &services.IdempotentResponse{
+                        Status:      recorder.status,
+                        ContentType: w.Header().Get("Content-Type"),
+                        Body:        recorder.body.Bytes(),
+                    },
+                )
+            }
+            if err != nil {
+                log.Printf("Failed to keep the outcome of the Idempotency-Key %q: %v", key, err)
+            }
+        }
+    }
+}
+
+// idempotencyRecorder keeps the status and body of the response it writes
+type idempotencyRecorder struct {
+    http.ResponseWriter
+    status int
+    body   bytes.Buffer
+}
+
+func (w *idempotencyRecorder) WriteHeader(status int) {
+    if w.status == 0 {
+        w.status = status
+    }
+    w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *idempotencyRecorder) Write(b []byte) (int, error) {
+    if w.status == 0 {
+        w.WriteHeader(http.StatusOK)
+    }
+    w.body.Write(b)
+    return w.ResponseWriter.Write(b)
+}
+
+func (w *idempotencyRecorder) Unwrap() http.ResponseWriter {
+    return w.ResponseWriter
+}
@@ -0,0 +1,200 @@
+package handler
+
+import (
+    "context"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+// memoryIdempotencyRepo keeps the requests by key like MongoIdempotencyRepository, safe for concurrent requests
+type memoryIdempotencyRepo struct {
+    mu       sync.Mutex
+    requests map[string]*repositories.IdempotentRequest
+}
+
+func (r *memoryIdempotencyRepo) ReserveIdempotencyKey(
+    _ context.Context,
+    request *repositories.IdempotentRequest,
+) (*repositories.IdempotentRequest, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if stored, ok := r.requests[request.Key]; ok && stored.ExpiresAt.After(time.Now()) {
+        copied := *stored
+        return &copied, nil
+    }
+    reserved := *request
+    r.requests[request.Key] = &reserved
+    return nil, nil
+}
+
+func (r *memoryIdempotencyRepo) CompleteIdempotencyKey(
+    _ context.Context,
+    request *repositories.IdempotentRequest,
+) error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    stored := r.requests[request.Key]
+    stored.Status, stored.ContentType, stored.Body = request.Status, request.ContentType, request.Body
+    stored.ExpiresAt = request.ExpiresAt
+    return nil
+}
+
+func (r *memoryIdempotencyRepo) ReleaseIdempotencyKey(_ context.Context, key string) error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    delete(r.requests, key)
+    return nil
+}
+
+// errorCode returns the code of the error envelope of the response, empty when it isn't one
+func errorCode(w *httptest.ResponseRecorder) string {
+    var response ErrorResponse
+    if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+        return ""
+    }
+    return response.Code
+}
+
+// idempotentHandler is the Idempotency middleware around next, with its own key store
+func idempotentHandler(next http.HandlerFunc) http.HandlerFunc {
+    repo := &memoryIdempotencyRepo{requests: map[string]*repositories.IdempotentRequest{}}
+    return Idempotency(services.NewMongoIdempotencyService(repo, time.Hour))(next)
+}
+
+func send(handler http.HandlerFunc, key, body string) *httptest.ResponseRecorder {
+    r := httptest.NewRequest(http.MethodPost, "/api/v1/tracking-data", strings.NewReader(body))
+    if key != "" {
+        r.Header.Set(IdempotencyKeyHeader, key)
+    }
+    w := httptest.NewRecorder()
+    handler(w, r)
+    return w
+}
+
+func TestIdempotency_Replay(t *testing.T) {
+    var calls atomic.Int32
+    handler := idempotentHandler(
+        func(w http.ResponseWriter, r *http.Request) {
+            n := calls.Add(1)
+            w.Header().Set("Content-Type", "application/json")
+            w.WriteHeader(http.StatusCreated)
+            _, _ = fmt.Fprintf(w, `{"call":%d}`, n)
+        },
+    )
+    body := `{"vehicle_id":"6650c3e0f1a2b3c4d5e6f7a8"}`
+
+    first := send(handler, "key-1", body)
+    if first.Code != http.StatusCreated || first.Header().Get(IdempotentReplayedHeader) != "" {
+        t.Fatalf("expected the first request to be processed, got %d %v", first.Code, first.Header())
+    }
+    retry := send(handler, "key-1", body)
+    if retry.Code != http.StatusCreated || retry.Body.String() != first.Body.String() ||
+        retry.Header().Get("Content-Type") != "application/json" ||
+        retry.Header().Get(IdempotentReplayedHeader) != "true" {
+        t.Fatalf("expected the first response replayed, got %d %s %v", retry.Code, retry.Body.String(), retry.Header())
+    }
+    if calls.Load() != 1 {
+        t.Fatalf("expected the retry not to be processed, got %d calls", calls.Load())
+    }
+
+    // other keys and requests without one are processed
+    send(handler, "key-2", body)
+    send(handler, "", body)
+    send(handler, "", body)
+    if calls.Load() != 4 {
+        t.Errorf("expected the requests without the key to be processed, got %d calls", calls.Load())
+    }
+}
+
+func TestIdempotency_ConcurrentDuplicate(t *testing.T) {
+    entered, release := make(chan struct{}), make(chan struct{})
+    var calls atomic.Int32
+    handler := idempotentHandler(
+        func(w http.ResponseWriter, r *http.Request) {
+            calls.Add(1)
+            close(entered)
+            <-release
+            w.WriteHeader(http.StatusCreated)
+        },
+    )
+    body := `{"vehicle_id":"6650c3e0f1a2b3c4d5e6f7a8"}`
+
+    done := make(chan *httptest.ResponseRecorder)
+    go func() {
+        done <- send(handler, "key-1", body)
+    }()
+    <-entered
+    duplicate := send(handler, "key-1", body)
+    if duplicate.Code != http.StatusConflict || duplicate.Header().Get("Retry-After") != "1" ||
+        errorCode(duplicate) != CodeIdempotencyKeyInProgress {
+        t.Errorf("expected the duplicate to be told to retry, got %d %s", duplicate.Code, duplicate.Body.String())
+    }
+    close(release)
+    if first := <-done; first.Code != http.StatusCreated {
+        t.Fatalf("expected the first request to be stored, got %d", first.Code)
+    }
+
+    if retry := send(handler, "key-1", body); retry.Code != http.StatusCreated ||
+        retry.Header().Get(IdempotentReplayedHeader) != "true" {
+        t.Errorf("expected the retry after it to get the first response, got %d %v", retry.Code, retry.Header())
+    }
+    if calls.Load() != 1 {
+        t.Errorf("expected the reading to be processed once, got %d calls", calls.Load())
+    }
+}
+
+func TestIdempotency_Reused(t *testing.T) {
+    var calls atomic.Int32
+    handler := idempotentHandler(
+        func(w http.ResponseWriter, r *http.Request) {
+            calls.Add(1)
+            w.WriteHeader(http.StatusCreated)
+        },
+    )
+    send(handler, "key-1", `{"vehicle_id":"6650c3e0f1a2b3c4d5e6f7a8"}`)
+
+    reused := send(handler, "key-1", `{"vehicle_id":"6650c3e0f1a2b3c4d5e6f7a9"}`)
+    if reused.Code != http.StatusUnprocessableEntity || errorCode(reused) != CodeIdempotencyKeyReused {
+        t.Errorf("expected the key reused for another body to be rejected, got %d %s", reused.Code,
+            reused.Body.String())
+    }
+    tooLong := send(handler, strings.Repeat("k", services.MaxIdempotencyKeyLength+1), `{}`)
+    if tooLong.Code != http.StatusBadRequest || errorCode(tooLong) != CodeIdempotencyKeyInvalid {
+        t.Errorf("expected a too long key to be rejected, got %d %s", tooLong.Code, tooLong.Body.String())
+    }
+    if calls.Load() != 1 {
+        t.Errorf("expected the rejected requests not to be processed, got %d calls", calls.Load())
+    }
+}
+
+func TestIdempotency_ServerError(t *testing.T) {
+    var calls atomic.Int32
+    handler := idempotentHandler(
+        func(w http.ResponseWriter, r *http.Request) {
+            if calls.Add(1) == 1 {
+                handleError(http.StatusInternalServerError, w, repositories.ErrTransient)
+                return
+            }
+            w.WriteHeader(http.StatusCreated)
+        },
+    )
+    body := `{"vehicle_id":"6650c3e0f1a2b3c4d5e6f7a8"}`
+
+    if failed := send(handler, "key-1", body); failed.Code != http.StatusServiceUnavailable {
+        t.Fatalf("expected the storage failure, got %d", failed.Code)
+    }
+    if retry := send(handler, "key-1", body); retry.Code != http.StatusCreated ||
+        retry.Header().Get(IdempotentReplayedHeader) != "" {
+        t.Errorf("expected the retry of a failed request to be processed again, got %d %v", retry.Code, retry.Header())
+    }
+}
@@ -0,0 +1,22 @@
+package handler
+
+import (
+    "log"
+    "net/http"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-common"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/plan"
+)
+
+// writePlan answers the dry run of a destructive operation with its plan
+func writePlan(w http.ResponseWriter, p *plan.Plan) {
+    if err := json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            p,
+            "dry run, nothing was changed",
+        ),
+    ); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
@@ -0,0 +1,56 @@
+package handler
+
+import (
+    "errors"
+    "net/http"
+    "net/url"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+)
+
+// PolicyMiddleware puts the authorization policy in the context of the requests, the handlers authorize
+// their actions with it
+func PolicyMiddleware(policy services.PolicyService) func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(
+            func(w http.ResponseWriter, r *http.Request) {
+                next.ServeHTTP(w, r.WithContext(services.WithPolicy(r.Context(), policy)))
+            },
+        )
+    }
+}
+
+// authorize asks the policy whether the user of the request may do the action on the resource, the request is
+// rejected with 403 when it may not and 503 when the policy couldn't decide
+func authorize(w http.ResponseWriter, r *http.Request, action string, resource map[string]any) bool {
+    input := &services.PolicyInput{Action: action, Resource: resource}
+    if claims, ok := services.ClaimsFromContext(r.Context()); ok {
+        input.User = claims
+    }
+    if id, ok := tenant.FromContext(r.Context()); ok {
+        input.TenantID = id
+    }
+
+    err := services.PolicyFromContext(r.Context()).Authorize(r.Context(), input)
+    if errors.Is(err, services.ErrPolicyUnavailable) {
+        writeError(http.StatusServiceUnavailable, w, err)
+        return false
+    }
+    if err != nil {
+        writeError(http.StatusForbidden, w, err)
+        return false
+    }
+    return true
+}
+
+// queryResource returns the query parameters of a request that describe the resource of an action
+func queryResource(query url.Values, names ...string) map[string]any {
+    resource := make(map[string]any, len(names))
+    for _, name := range names {
+        if value := query.Get(name); value != "" {
+            resource[name] = value
+        }
+    }
+    return resource
+}
@@ -0,0 +1,75 @@
+package handler
+
+import (
+    "context"
+    "log"
+    "net/http"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-common"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+)
+
+type emptyResultsContextKey struct{}
+
+// Pagination is the page of a paged list, Count is the number of results on it
+type Pagination struct {
+    Page  int `json:"page"`
+    Limit int `json:"limit"`
+    Count int `json:"count"`
+}
+
+// ListResponse is the success envelope of the lists, with the pagination of the paged ones
+type ListResponse struct {
+    *common.Response
+    Pagination *Pagination `json:"pagination,omitempty"`
+}
+
+// EmptyResultsMiddleware answers the list queries without results 200 with an empty data array instead of 404,
+// lookups of a single resource are still answered 404
+func EmptyResultsMiddleware() func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(
+            func(w http.ResponseWriter, r *http.Request) {
+                next.ServeHTTP(w, r.WithContext(WithEmptyResults(r.Context())))
+            },
+        )
+    }
+}
+
+// WithEmptyResults marks the list queries of the context to be answered with an empty data array without results
+func WithEmptyResults(ctx context.Context) context.Context {
+    return context.WithValue(ctx, emptyResultsContextKey{}, true)
+}
+
+func emptyResults(ctx context.Context) bool {
+    empty, _ := ctx.Value(emptyResultsContextKey{}).(bool)
+    return empty
+}
+
+// paginate returns the page of the paged list queried by the request
+func paginate(r *http.Request) *Pagination {
+    page, limit := repositories.PageOf(r.URL.Query())
+    return &Pagination{Page: page, Limit: limit}
+}
+
+// writeResults answers the count results of a list query in the success envelope, page is nil for the lists that
+// aren't paged. A query without results is answered 404 unless the request accepts empty results.
+func writeResults(w http.ResponseWriter, r *http.Request, results any, count int, page *Pagination, message string) {
+    if count == 0 {
+        if !emptyResults(r.Context()) {
+            writeError(http.StatusNotFound, w, ErrNotFound)
+            return
+        }
+        // nil slices would be encoded as null
+        results = []any{}
+    }
+    if page != nil {
+        page.Count = count
+    }
+    if err := json.NewEncoder(w).Encode(
+        &ListResponse{Response: common.DefaultSuccessResponse(results, message), Pagination: page},
+    ); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
@@ -0,0 +1,67 @@
+package handler
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+)
+
+func findPage(handler http.Handler) *httptest.ResponseRecorder {
+    w := httptest.NewRecorder()
+    handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/tracking-data?page=2&limit=5", nil))
+    return w
+}
+
+func TestWriteResults_NotFound(t *testing.T) {
+    h := NewV1TrackingHandler(&fakeTrackingService{}, nil, nil, nil, 0, nil)
+    w := findPage(http.HandlerFunc(h.FindTrackingData))
+
+    if w.Code != http.StatusNotFound || errorCode(w) != CodeNotFound {
+        t.Errorf("expected 404 %s without EMPTY_RESULTS, got %d %s", CodeNotFound, w.Code, w.Body.String())
+    }
+}
+
+func TestWriteResults_EmptyResults(t *testing.T) {
+    h := NewV1TrackingHandler(&fakeTrackingService{}, nil, nil, nil, 0, nil)
+    w := findPage(EmptyResultsMiddleware()(http.HandlerFunc(h.FindTrackingData)))
+
+    if w.Code != http.StatusOK {
+        t.Fatalf("expected 200 with EMPTY_RESULTS, got %d %s", w.Code, w.Body.String())
+    }
+    var response struct {
+        Success    bool             `json:"success"`
+        Data       *json.RawMessage `json:"data"`
+        Pagination *Pagination      `json:"pagination"`
+    }
+    if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+        t.Fatal(err)
+    }
+    if !response.Success || response.Data == nil || string(*response.Data) != "[]" {
+        t.Errorf("expected an empty data array, got %s", w.Body.String())
+    }
+    if response.Pagination == nil || *response.Pagination != (Pagination{Page: 2, Limit: 5, Count: 0}) {
+        t.Errorf("expected the empty page, got %+v", response.Pagination)
+    }
+
+    // the results are sent as they are once there are some
+    record := newTestRecord(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+    h = NewV1TrackingHandler(
+        &fakeTrackingService{records: []*repositories.TrackingRecord{record}},
+        nil,
+        nil,
+        nil,
+        0,
+        nil,
+    )
+    w = findPage(EmptyResultsMiddleware()(http.HandlerFunc(h.FindTrackingData)))
+    if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+        t.Fatal(err)
+    }
+    if w.Code != http.StatusOK || response.Pagination.Count != 1 {
+        t.Errorf("expected the page of one result, got %d %s", w.Code, w.Body.String())
+    }
+}
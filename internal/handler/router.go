@@ -0,0 +1,70 @@
+package handler
+
+import (
+    "net/http"
+    "slices"
+    "strings"
+)
+
+// Router routes requests by method and path on top of the patterns of http.ServeMux, so handlers don't check
+// the method themselves and can read path parameters like {id} with r.PathValue. A request to a registered path
+// with another method is answered 405 with the Allow header, in the error format of every other response.
+type Router struct {
+    *http.ServeMux
+    methods []string
+}
+
+func NewRouter() *Router {
+    return &Router{ServeMux: http.NewServeMux()}
+}
+
+// Method registers the handler for the method and path pattern
+func (rt *Router) Method(method, pattern string, handler http.HandlerFunc) {
+    rt.ServeMux.HandleFunc(method+" "+pattern, handler)
+    if !slices.Contains(rt.methods, method) {
+        rt.methods = append(rt.methods, method)
+    }
+}
+
+func (rt *Router) Get(pattern string, handler http.HandlerFunc) {
+    rt.Method(http.MethodGet, pattern, handler)
+}
+
+func (rt *Router) Post(pattern string, handler http.HandlerFunc) {
+    rt.Method(http.MethodPost, pattern, handler)
+}
+
+func (rt *Router) Put(pattern string, handler http.HandlerFunc) {
+    rt.Method(http.MethodPut, pattern, handler)
+}
+
+func (rt *Router) Delete(pattern string, handler http.HandlerFunc) {
+    rt.Method(http.MethodDelete, pattern, handler)
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    if _, pattern := rt.ServeMux.Handler(r); pattern == "" {
+        if allowed := rt.allowed(r); len(allowed) > 0 {
+            w.Header().Set("Allow", strings.Join(allowed, ", "))
+            writeError(http.StatusMethodNotAllowed, w, ErrMethodNotAllowed)
+            return
+        }
+    }
+    rt.ServeMux.ServeHTTP(w, r)
+}
+
+// allowed returns the methods registered for the path of the request, a GET route also serves HEAD
+func (rt *Router) allowed(r *http.Request) []string {
+    var allowed []string
+    probe := *r
+    for _, method := range rt.methods {
+        probe.Method = method
+        if _, pattern := rt.ServeMux.Handler(&probe); pattern != "" {
+            allowed = append(allowed, method)
+            if method == http.MethodGet {
+                allowed = append(allowed, http.MethodHead)
+            }
+        }
+    }
+    return allowed
+}
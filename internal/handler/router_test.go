@@ -0,0 +1,44 @@
+package handler
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "github.com/goccy/go-json"
+)
+
+func TestRouter(t *testing.T) {
+    rt := NewRouter()
+    rt.Get(
+        "/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+            _, _ = w.Write([]byte(r.PathValue("id")))
+        },
+    )
+    rt.Delete("/items/{id}", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+
+    w := httptest.NewRecorder()
+    rt.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/items/42", nil))
+    if w.Code != http.StatusOK || w.Body.String() != "42" {
+        t.Fatalf("expected the route with its path value, got %d %q", w.Code, w.Body.String())
+    }
+
+    w = httptest.NewRecorder()
+    rt.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/items/42", nil))
+    if w.Code != http.StatusMethodNotAllowed {
+        t.Fatalf("expected 405 for a method the path isn't registered for, got %d", w.Code)
+    }
+    if allow := w.Header().Get("Allow"); allow != "GET, HEAD, DELETE" {
+        t.Errorf("expected the registered methods in Allow, got %q", allow)
+    }
+    var response ErrorResponse
+    if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || response.Code != CodeMethodNotAllowed {
+        t.Errorf("expected the error envelope with the method_not_allowed code, got %s, %v", w.Body.String(), err)
+    }
+
+    w = httptest.NewRecorder()
+    rt.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/other", nil))
+    if w.Code != http.StatusNotFound || w.Header().Get("Allow") != "" {
+        t.Errorf("expected 404 without Allow for an unknown path, got %d %q", w.Code, w.Header().Get("Allow"))
+    }
+}
@@ -0,0 +1,59 @@
+package handler
+
+import (
+    "errors"
+    "net/http"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+)
+
+// TenantResolver returns the tenant of the request
+type TenantResolver func(r *http.Request) (string, error)
+
+// AuthUserTenant resolves the tenant of the membership of the user authenticated by the auth service. The
+// X-Tenant-ID header is only checked against it, a request naming another tenant is rejected.
+func AuthUserTenant(accessService services.AccessService) TenantResolver {
+    return func(r *http.Request) (string, error) {
+        user, ok := AuthUserFromContext(r.Context())
+        if !ok {
+            return "", tenant.ErrTenantMissing
+        }
+        id, err := accessService.Tenant(r.Context(), user)
+        if err != nil {
+            return "", err
+        }
+        if header := r.Header.Get(tenant.Header); header != "" && header != id {
+            return "", tenant.ErrTenantMismatch
+        }
+        return id, nil
+    }
+}
+
+// TenantMiddleware puts the tenant of the request in its context, the repositories limit the data read and
+// written to it. Requests without a tenant are rejected.
+func TenantMiddleware(resolve TenantResolver) func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(
+            func(w http.ResponseWriter, r *http.Request) {
+                id, err := resolve(r)
+                switch {
+                case errors.Is(err, tenant.ErrTenantMissing), errors.Is(err, services.ErrUserMissing):
+                    writeError(http.StatusUnauthorized, w, err)
+                    return
+                case errors.Is(err, tenant.ErrTenantMismatch):
+                    writeError(http.StatusForbidden, w, err)
+                    return
+                case errors.Is(err, tenant.ErrInvalidTenant):
+                    writeError(http.StatusBadRequest, w, err)
+                    return
+                case err != nil:
+                    handleError(http.StatusInternalServerError, w, err)
+                    return
+                }
+                noteAccessLogTenant(r, id)
+                next.ServeHTTP(w, r.WithContext(tenant.WithID(r.Context(), id)))
+            },
+        )
+    }
+}
@@ -0,0 +1,68 @@
+package handler
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+)
+
+func TestAuthUserTenant(t *testing.T) {
+    accessService := services.NewMongoAccessService(
+        nil,
+        &fakeMembershipRepo{
+            memberships: map[string]*repositories.UserMembership{
+                "driver-1": {UserID: "driver-1", TenantID: "acme"},
+                "driver-2": {UserID: "driver-2"},
+            },
+        },
+    )
+    for _, test := range []struct {
+        name     string
+        userID   string
+        header   string
+        expected int
+        code     string
+    }{
+        {"tenant of the user", "driver-1", "", http.StatusOK, ""},
+        {"matching header", "driver-1", "acme", http.StatusOK, ""},
+        {"mismatched header", "driver-1", "globex", http.StatusForbidden, CodeTenantMismatch},
+        {"user without a tenant", "driver-2", "acme", http.StatusUnauthorized, CodeTenantMissing},
+        {"user without a membership", "driver-3", "acme", http.StatusUnauthorized, CodeTenantMissing},
+        {"unauthenticated", "", "acme", http.StatusUnauthorized, CodeTenantMissing},
+    } {
+        t.Run(
+            test.name, func(t *testing.T) {
+                r := httptest.NewRequest(http.MethodGet, "/api/v1/tracking-data", nil)
+                if test.header != "" {
+                    r.Header.Set(tenant.Header, test.header)
+                }
+                if test.userID != "" {
+                    r = authenticated(r, test.userID, "user")
+                }
+                var resolved string
+                w := httptest.NewRecorder()
+                TenantMiddleware(AuthUserTenant(accessService))(
+                    http.HandlerFunc(
+                        func(w http.ResponseWriter, r *http.Request) {
+                            resolved, _ = tenant.FromContext(r.Context())
+                        },
+                    ),
+                ).ServeHTTP(w, r)
+
+                if w.Code != test.expected || errorCode(w) != test.code {
+                    t.Fatalf("expected %d %q, got %d %s", test.expected, test.code, w.Code, w.Body.String())
+                }
+                if test.expected == http.StatusOK && resolved != "acme" {
+                    t.Errorf("expected the tenant of the membership, got %q", resolved)
+                }
+                if test.expected != http.StatusOK && resolved != "" {
+                    t.Errorf("expected the request not to be served, got tenant %q", resolved)
+                }
+            },
+        )
+    }
+}
@@ -0,0 +1,115 @@
+package handler
+
+import (
+    "context"
+    "log"
+    "net/http"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+)
+
+const (
+    // accessAuditTimeout bounds recording the audit after the response, the request context may already be done
+    accessAuditTimeout = 5 * time.Second
+)
+
+// statusRecorder remembers the status and size of the response, it keeps flushing for the streaming endpoints
+type statusRecorder struct {
+    http.ResponseWriter
+    status int
+    bytes  int64
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+    if w.status == 0 {
+        w.status = status
+    }
+    w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+    if w.status == 0 {
+        w.status = http.StatusOK
+    }
+    n, err := w.ResponseWriter.Write(b)
+    w.bytes += int64(n)
+    return n, err
+}
+
+func (w *statusRecorder) Flush() {
+    if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+        flusher.Flush()
+    }
+}
+
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+    return w.ResponseWriter
+}
+
+// AccessAuditMiddleware records every request in the access audit once it is served, with who made it, its
+// query parameters, status and the number of results the handler reported
+func AccessAuditMiddleware(accessAuditService services.AccessAuditService) func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(
+            func(w http.ResponseWriter, r *http.Request) {
+                started := time.Now()
+                audit := &repositories.AccessAudit{
+                    UserID: requesterOf(r),
+                    Method: r.Method,
+                    Path:   r.URL.Path,
+                    Params: r.URL.Query(),
+                }
+                if claims, ok := services.ClaimsFromContext(r.Context()); ok {
+                    audit.Role = claims.Role
+                } else if user, ok := AuthUserFromContext(r.Context()); ok {
+                    audit.Role = user.Data.Role
+                }
+                if len(audit.Params) == 0 {
+                    audit.Params = nil
+                }
+
+                recorder := &statusRecorder{ResponseWriter: w}
+                next.ServeHTTP(recorder, r.WithContext(services.WithAccessAudit(r.Context(), audit)))
+
+                audit.Status = recorder.status
+                if audit.Status == 0 {
+                    audit.Status = http.StatusOK
+                }
+                audit.DurationMs = float64(time.Since(started)) / float64(time.Millisecond)
+
+                ctx, cancel := context.WithTimeout(tenant.Detach(r.Context()), accessAuditTimeout)
+                defer cancel()
+                if err := accessAuditService.RecordAccess(ctx, audit); err != nil {
+                    log.Printf("Failed to record access audit of %s %s: %v", audit.Method, audit.Path, err)
+                }
+            },
+        )
+    }
+}
+
+type V1AccessAuditHandler struct {
+    accessAuditService services.AccessAuditService
+}
+
+func NewV1AccessAuditHandler(accessAuditService services.AccessAuditService) *V1AccessAuditHandler {
+    return &V1AccessAuditHandler{accessAuditService: accessAuditService}
+}
+
+// FindAccessAudits finds who queried or changed which data, admin only
+func (h *V1AccessAuditHandler) FindAccessAudits(w http.ResponseWriter, r *http.Request) {
+    if !authorize(w, r, services.ActionReadAccessAudits, nil) {
+        return
+    }
+
+    audits, err := h.accessAuditService.FindAccessAudits(r.Context(), r.URL.Query())
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+    services.RecordResultCount(r.Context(), len(audits))
+
+    writeResults(w, r, audits, len(audits), paginate(r), "successfully fetched access audits")
+}
@@ -0,0 +1,177 @@
+package handler
+
+import (
+    "context"
+    "errors"
+    "log"
+    "net/http"
+
+    "github.com/go-playground/validator/v10"
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-common"
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+)
+
+var (
+    ErrClaimsMissing = errors.New("user claims are missing")
+)
+
+// AuthUserFromContext returns the user the auth service authenticated the request of the context for,
+// AuthorizationMiddleware puts it in the request context
+func AuthUserFromContext(ctx context.Context) (*models.AuthUser, bool) {
+    user, ok := ctx.Value(common.UserContextKey).(*models.AuthUser)
+    return user, ok && user != nil && user.Data.Id != ""
+}
+
+// ClaimsResolver returns the claims of the authenticated user of the request
+type ClaimsResolver func(r *http.Request) (*services.Claims, error)
+
+// AuthUserClaims resolves the claims of the user authenticated by the auth service: the role it answered with and
+// the organization and fleet groups of the user's membership. Nothing but the token is taken from the client.
+func AuthUserClaims(accessService services.AccessService) ClaimsResolver {
+    return func(r *http.Request) (*services.Claims, error) {
+        user, ok := AuthUserFromContext(r.Context())
+        if !ok {
+            return nil, ErrClaimsMissing
+        }
+        return accessService.Claims(r.Context(), user)
+    }
+}
+
+// ClaimsMiddleware puts the claims of the user in the request context, the services limit the tracking queries
+// to the vehicles the claims give access to. Requests without claims are rejected.
+func ClaimsMiddleware(resolve ClaimsResolver) func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(
+            func(w http.ResponseWriter, r *http.Request) {
+                claims, err := resolve(r)
+                if errors.Is(err, ErrClaimsMissing) || errors.Is(err, services.ErrUserMissing) {
+                    writeError(http.StatusUnauthorized, w, err)
+                    return
+                }
+                if err != nil {
+                    handleError(http.StatusInternalServerError, w, err)
+                    return
+                }
+                next.ServeHTTP(w, r.WithContext(services.WithClaims(r.Context(), claims)))
+            },
+        )
+    }
+}
+
+type V1AccessHandler struct {
+    accessService services.AccessService
+    validate      *validator.Validate
+}
+
+func NewV1AccessHandler(accessService services.AccessService, validate *validator.Validate) *V1AccessHandler {
+    return &V1AccessHandler{accessService: accessService, validate: validate}
+}
+
+func (h *V1AccessHandler) FindAssignments(w http.ResponseWriter, r *http.Request) {
+    if !authorize(w, r, services.ActionReadAssignments, nil) {
+        return
+    }
+    assignments, err := h.accessService.FindAssignments(r.Context())
+    if err != nil {
+        handleError(http.StatusInternalServerError, w, err)
+        return
+    }
+
+    writeResults(w, r, assignments, len(assignments), nil, "successfully fetched vehicle assignments")
+}
+
+// SetAssignment assigns a vehicle to an organization and fleet groups, replacing its previous assignment
+func (h *V1AccessHandler) SetAssignment(w http.ResponseWriter, r *http.Request) {
+    if !authorize(w, r, services.ActionWriteAssignments, nil) {
+        return
+    }
+
+    var req services.VehicleAssignmentRequest
+    if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodySize)).Decode(&req); err != nil {
+        writeError(http.StatusBadRequest, w, err)
+        return
+    }
+    if err := h.validate.Struct(&req); err != nil {
+        writeError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    assignment, err := h.accessService.SetAssignment(r.Context(), &req)
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    if err = json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            assignment,
+            "successfully set vehicle assignment",
+        ),
+    ); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
+
+func (h *V1AccessHandler) FindMemberships(w http.ResponseWriter, r *http.Request) {
+    if !authorize(w, r, services.ActionReadAssignments, nil) {
+        return
+    }
+    memberships, err := h.accessService.FindMemberships(r.Context())
+    if err != nil {
+        handleError(http.StatusInternalServerError, w, err)
+        return
+    }
+
+    writeResults(w, r, memberships, len(memberships), nil, "successfully fetched user memberships")
+}
+
+// SetMembership puts a user in an organization and fleet groups, replacing its previous membership
+func (h *V1AccessHandler) SetMembership(w http.ResponseWriter, r *http.Request) {
+    if !authorize(w, r, services.ActionWriteAssignments, nil) {
+        return
+    }
+
+    var req services.UserMembershipRequest
+    if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodySize)).Decode(&req); err != nil {
+        writeError(http.StatusBadRequest, w, err)
+        return
+    }
+    if err := h.validate.Struct(&req); err != nil {
+        writeError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    membership, err := h.accessService.SetMembership(r.Context(), &req)
+    if errors.Is(err, tenant.ErrTenantMismatch) {
+        writeError(http.StatusForbidden, w, err)
+        return
+    }
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    if err = json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            membership,
+            "successfully set user membership",
+        ),
+    ); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
+
+// requesterOf returns the user of the request for audit records, the authenticated user when access control is
+// disabled
+func requesterOf(r *http.Request) string {
+    if claims, ok := services.ClaimsFromContext(r.Context()); ok {
+        return claims.UserID
+    }
+    if user, ok := AuthUserFromContext(r.Context()); ok {
+        return user.Data.Id
+    }
+    return "unknown"
+}
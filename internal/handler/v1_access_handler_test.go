@@ -0,0 +1,101 @@
+package handler
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-common"
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+// fakeMembershipRepo finds the memberships it has by user
+type fakeMembershipRepo struct {
+    repositories.UserMembershipRepository
+    memberships map[string]*repositories.UserMembership
+}
+
+func (r *fakeMembershipRepo) FindMembership(_ context.Context, userID string) (*repositories.UserMembership, error) {
+    membership, ok := r.memberships[userID]
+    if !ok {
+        return nil, repositories.ErrNotFound
+    }
+    return membership, nil
+}
+
+// authenticated returns the request as AuthorizationMiddleware passes it on for the user
+func authenticated(r *http.Request, userID, role string) *http.Request {
+    user := &models.AuthUser{}
+    user.Data.Id, user.Data.Role = userID, role
+    return r.WithContext(context.WithValue(r.Context(), common.UserContextKey, user))
+}
+
+// resolveClaims returns the response of the ClaimsMiddleware to the request and the claims it passed on
+func resolveClaims(r *http.Request) (*httptest.ResponseRecorder, *services.Claims) {
+    accessService := services.NewMongoAccessService(
+        nil,
+        &fakeMembershipRepo{
+            memberships: map[string]*repositories.UserMembership{
+                "driver-1": {UserID: "driver-1", OrganizationID: "acme", FleetGroupIDs: []string{"north"}},
+            },
+        },
+    )
+    var claims *services.Claims
+    w := httptest.NewRecorder()
+    ClaimsMiddleware(AuthUserClaims(accessService))(
+        http.HandlerFunc(
+            func(w http.ResponseWriter, r *http.Request) {
+                claims, _ = services.ClaimsFromContext(r.Context())
+            },
+        ),
+    ).ServeHTTP(w, r)
+    return w, claims
+}
+
+func TestAuthUserClaims(t *testing.T) {
+    r := httptest.NewRequest(http.MethodGet, "/api/v1/tracking-data", nil)
+    w, claims := resolveClaims(authenticated(r, "driver-1", "user"))
+
+    if w.Code != http.StatusOK || claims == nil {
+        t.Fatalf("expected the claims of the user, got %d %s", w.Code, w.Body.String())
+    }
+    if claims.UserID != "driver-1" || claims.Role != "user" || claims.OrganizationID != "acme" ||
+        len(claims.FleetGroupIDs) != 1 || claims.FleetGroupIDs[0] != "north" {
+        t.Errorf("expected the role of the auth service and the membership, got %+v", claims)
+    }
+
+    // users without a membership only get their role
+    _, claims = resolveClaims(authenticated(r, "driver-2", "user"))
+    if claims == nil || claims.OrganizationID != "" || len(claims.FleetGroupIDs) != 0 {
+        t.Errorf("expected the claims without organization, got %+v", claims)
+    }
+}
+
+func TestAuthUserClaims_IgnoresHeaders(t *testing.T) {
+    spoofed := func() *http.Request {
+        r := httptest.NewRequest(http.MethodGet, "/api/v1/tracking-data", nil)
+        r.Header.Set("X-User-ID", "admin-1")
+        r.Header.Set("X-User-Role", services.RoleAdmin)
+        r.Header.Set("X-Organization-ID", "globex")
+        r.Header.Set("X-Fleet-Group-IDs", "south")
+        return r
+    }
+
+    w, claims := resolveClaims(authenticated(spoofed(), "driver-1", "user"))
+    if w.Code != http.StatusOK || claims == nil {
+        t.Fatalf("expected the claims of the user, got %d %s", w.Code, w.Body.String())
+    }
+    if claims.IsAdmin() || claims.UserID != "driver-1" || claims.OrganizationID != "acme" ||
+        claims.FleetGroupIDs[0] != "north" {
+        t.Errorf("expected the spoofed headers to be ignored, got %+v", claims)
+    }
+
+    if w, claims = resolveClaims(spoofed()); w.Code != http.StatusUnauthorized || claims != nil ||
+        errorCode(w) != CodeClaimsMissing {
+        t.Errorf("expected the request without an authenticated user to be rejected, got %d %s", w.Code,
+            w.Body.String())
+    }
+}
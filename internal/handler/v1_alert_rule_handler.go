@@ -0,0 +1,146 @@
+package handler
+
+import (
+    "log"
+    "net/http"
+
+    "github.com/go-playground/validator/v10"
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-common"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+type V1AlertRuleHandler struct {
+    alertRuleService services.AlertRuleService
+    validate         *validator.Validate
+}
+
+func NewV1AlertRuleHandler(
+    alertRuleService services.AlertRuleService,
+    validate *validator.Validate,
+) *V1AlertRuleHandler {
+    return &V1AlertRuleHandler{alertRuleService: alertRuleService, validate: validate}
+}
+
+// FindAlertRules lists the alert rules, admin only
+func (h *V1AlertRuleHandler) FindAlertRules(w http.ResponseWriter, r *http.Request) {
+    if !authorize(w, r, services.ActionReadAlertRules, nil) {
+        return
+    }
+
+    rules, err := h.alertRuleService.FindAlertRules(r.Context())
+    if err != nil {
+        handleError(http.StatusInternalServerError, w, err)
+        return
+    }
+
+    if err = json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            rules,
+            "successfully fetched alert rules",
+        ),
+    ); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
+
+// CreateAlertRule creates an alert rule, admin only
+func (h *V1AlertRuleHandler) CreateAlertRule(w http.ResponseWriter, r *http.Request) {
+    if !authorize(w, r, services.ActionWriteAlertRules, nil) {
+        return
+    }
+    req, ok := h.decode(w, r)
+    if !ok {
+        return
+    }
+
+    rule, err := h.alertRuleService.CreateAlertRule(r.Context(), req)
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusCreated)
+    if err = json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            rule,
+            "successfully created alert rule",
+        ),
+    ); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
+
+// UpdateAlertRule replaces an alert rule, the alerts it raised stay open until its readings no longer meet it, admin
+// only
+func (h *V1AlertRuleHandler) UpdateAlertRule(w http.ResponseWriter, r *http.Request) {
+    if !authorize(w, r, services.ActionWriteAlertRules, nil) {
+        return
+    }
+    req, ok := h.decode(w, r)
+    if !ok {
+        return
+    }
+
+    rule, err := h.alertRuleService.UpdateAlertRule(r.Context(), r.PathValue("id"), req)
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    if err = json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            rule,
+            "successfully updated alert rule",
+        ),
+    ); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
+
+// DeleteAlertRule deletes an alert rule, the alert history is kept, admin only
+func (h *V1AlertRuleHandler) DeleteAlertRule(w http.ResponseWriter, r *http.Request) {
+    if !authorize(w, r, services.ActionWriteAlertRules, nil) {
+        return
+    }
+
+    if err := h.alertRuleService.DeleteAlertRule(r.Context(), r.PathValue("id")); err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    if err := json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            nil,
+            "successfully deleted alert rule",
+        ),
+    ); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
+
+// FindAlerts returns the alert history of the vehicles the user has access to, the latest first
+func (h *V1AlertRuleHandler) FindAlerts(w http.ResponseWriter, r *http.Request) {
+    alerts, err := h.alertRuleService.FindAlerts(r.Context(), r.URL.Query())
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    services.RecordResultCount(r.Context(), len(alerts))
+
+    writeResults(w, r, alerts, len(alerts), paginate(r), "successfully fetched alerts")
+}
+
+func (h *V1AlertRuleHandler) decode(w http.ResponseWriter, r *http.Request) (*services.AlertRuleRequest, bool) {
+    var req services.AlertRuleRequest
+    if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodySize)).Decode(&req); err != nil {
+        writeError(http.StatusBadRequest, w, err)
+        return nil, false
+    }
+    if err := h.validate.Struct(&req); err != nil {
+        writeError(http.StatusBadRequest, w, err)
+        return nil, false
+    }
+    return &req, true
+}
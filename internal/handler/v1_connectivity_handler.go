@@ -0,0 +1,28 @@
+package handler
+
+import (
+    "net/http"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+type V1ConnectivityHandler struct {
+    connectivityService services.ConnectivityService
+}
+
+func NewV1ConnectivityHandler(connectivityService services.ConnectivityService) *V1ConnectivityHandler {
+    return &V1ConnectivityHandler{connectivityService: connectivityService}
+}
+
+// SLAReport returns the monthly connectivity of every vehicle for the tracker uptime commitments
+func (h *V1ConnectivityHandler) SLAReport(w http.ResponseWriter, r *http.Request) {
+    report, err := h.connectivityService.FindSLAReport(r.Context(), r.URL.Query())
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    services.RecordResultCount(r.Context(), len(report))
+
+    writeResults(w, r, report, len(report), nil, "successfully fetched the SLA report")
+}
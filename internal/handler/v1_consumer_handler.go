@@ -0,0 +1,68 @@
+package handler
+
+import (
+    "errors"
+    "log"
+    "net/http"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-common"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+type V1ConsumerHandler struct {
+    consumerService services.ConsumerService
+}
+
+func NewV1ConsumerHandler(consumerService services.ConsumerService) *V1ConsumerHandler {
+    return &V1ConsumerHandler{consumerService: consumerService}
+}
+
+// PauseConsumer stops consuming the tracking queue, e.g. during a database maintenance, the messages wait in the
+// broker until the consumer is resumed, admin only
+func (h *V1ConsumerHandler) PauseConsumer(w http.ResponseWriter, r *http.Request) {
+    if !authorize(w, r, services.ActionWriteConsumer, nil) {
+        return
+    }
+
+    status, err := h.consumerService.Pause(r.Context())
+    if err != nil {
+        handleError(http.StatusInternalServerError, w, err)
+        return
+    }
+
+    if err = json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            status,
+            "successfully paused the consumer",
+        ),
+    ); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
+
+// ResumeConsumer starts consuming the tracking queue again, admin only
+func (h *V1ConsumerHandler) ResumeConsumer(w http.ResponseWriter, r *http.Request) {
+    if !authorize(w, r, services.ActionWriteConsumer, nil) {
+        return
+    }
+
+    status, err := h.consumerService.Resume(r.Context())
+    if errors.Is(err, services.ErrConsumerStopped) {
+        handleError(http.StatusServiceUnavailable, w, err)
+        return
+    }
+    if err != nil {
+        handleError(http.StatusInternalServerError, w, err)
+        return
+    }
+
+    if err = json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            status,
+            "successfully resumed the consumer",
+        ),
+    ); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
@@ -0,0 +1,39 @@
+package handler
+
+import (
+    "log"
+    "net/http"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-common"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+type V1DeploymentHealthHandler struct {
+    deploymentHealthService services.DeploymentHealthService
+}
+
+func NewV1DeploymentHealthHandler(
+    deploymentHealthService services.DeploymentHealthService,
+) *V1DeploymentHealthHandler {
+    return &V1DeploymentHealthHandler{deploymentHealthService: deploymentHealthService}
+}
+
+// DeploymentHealth reports whether this deployment should be rolled back, responding with
+// 503 when rollback is recommended so the CD system can act on the status code alone
+func (h *V1DeploymentHealthHandler) DeploymentHealth(w http.ResponseWriter, r *http.Request) {
+    health := h.deploymentHealthService.DeploymentHealth()
+
+    w.Header().Set("Content-Type", common.ApplicationJSON)
+    if health.Recommendation == services.DeploymentRollback {
+        w.WriteHeader(http.StatusServiceUnavailable)
+    }
+    if err := json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            health,
+            "successfully computed deployment health",
+        ),
+    ); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
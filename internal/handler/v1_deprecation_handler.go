@@ -0,0 +1,111 @@
+package handler
+
+import (
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-common"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+)
+
+const (
+    DeprecationHeader = "Deprecation"
+    SunsetHeader      = "Sunset"
+)
+
+// DeprecationMiddleware announces the deprecated features a request uses with the Deprecation (RFC 9745),
+// Sunset (RFC 8594) and Link headers and counts their use per caller. Features past their sunset date are
+// rejected with 410 when the sunset policy is enforced. The paths of the deprecations are the route patterns of
+// the router, like /api/v1/tracking-data/{id}.
+func DeprecationMiddleware(
+    deprecationService services.DeprecationService,
+    router *Router,
+) func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(
+            func(w http.ResponseWriter, r *http.Request) {
+                deprecations, err := deprecationService.Use(r.Method, routePath(router, r), r.URL.Query(), callerOf(r))
+                if err != nil {
+                    writeError(http.StatusGone, w, err)
+                    return
+                }
+                if len(deprecations) > 0 {
+                    setDeprecationHeaders(w.Header(), deprecations)
+                }
+                next.ServeHTTP(w, r)
+            },
+        )
+    }
+}
+
+// routePath returns the path of the route pattern the router matches the request with, the path of the request
+// when none matches
+func routePath(router *Router, r *http.Request) string {
+    _, pattern := router.Handler(r)
+    if pattern == "" {
+        return r.URL.Path
+    }
+    // the patterns of the routes are the method and the path, the ones added to the ServeMux may have no method
+    if _, path, ok := strings.Cut(pattern, " "); ok {
+        return path
+    }
+    return pattern
+}
+
+// setDeprecationHeaders announces the earliest deprecation and sunset of the used features, each feature
+// links its own documentation
+func setDeprecationHeaders(header http.Header, deprecations []*services.Deprecation) {
+    deprecatedAt, sunset := deprecations[0].DeprecatedAt, deprecations[0].Sunset
+    for _, deprecation := range deprecations {
+        if deprecation.DeprecatedAt.Before(deprecatedAt) {
+            deprecatedAt = deprecation.DeprecatedAt
+        }
+        if !deprecation.Sunset.IsZero() && (sunset.IsZero() || deprecation.Sunset.Before(sunset)) {
+            sunset = deprecation.Sunset
+        }
+        if deprecation.Link != "" {
+            header.Add("Link", "<"+deprecation.Link+`>; rel="deprecation"`)
+        }
+    }
+    header.Set(DeprecationHeader, "@"+strconv.FormatInt(deprecatedAt.Unix(), 10))
+    if !sunset.IsZero() {
+        header.Set(SunsetHeader, sunset.UTC().Format(http.TimeFormat))
+    }
+}
+
+// callerOf identifies the caller in the usage metrics, the user is prefixed with the tenant when there is one
+func callerOf(r *http.Request) string {
+    caller := requesterOf(r)
+    if id, ok := tenant.FromContext(r.Context()); ok {
+        return id + "/" + caller
+    }
+    return caller
+}
+
+type V1DeprecationHandler struct {
+    deprecationService services.DeprecationService
+}
+
+func NewV1DeprecationHandler(deprecationService services.DeprecationService) *V1DeprecationHandler {
+    return &V1DeprecationHandler{deprecationService: deprecationService}
+}
+
+// Deprecations lists the deprecated features with the callers still using them, admin only
+func (h *V1DeprecationHandler) Deprecations(w http.ResponseWriter, r *http.Request) {
+    if !authorize(w, r, services.ActionReadDeprecations, nil) {
+        return
+    }
+
+    if err := json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            h.deprecationService.Deprecations(),
+            "successfully fetched deprecations",
+        ),
+    ); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
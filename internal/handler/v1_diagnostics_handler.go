@@ -0,0 +1,45 @@
+package handler
+
+import (
+    "fmt"
+    "log"
+    "net/http"
+    "time"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-common"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+type V1DiagnosticsHandler struct {
+    diagnosticsService services.DiagnosticsService
+}
+
+func NewV1DiagnosticsHandler(diagnosticsService services.DiagnosticsService) *V1DiagnosticsHandler {
+    return &V1DiagnosticsHandler{diagnosticsService: diagnosticsService}
+}
+
+// Diagnostics downloads the diagnostics bundle to attach to support tickets, admin only
+func (h *V1DiagnosticsHandler) Diagnostics(w http.ResponseWriter, r *http.Request) {
+    if !authorize(w, r, services.ActionReadDiagnostics, nil) {
+        return
+    }
+
+    diagnostics := h.diagnosticsService.Diagnostics(r.Context())
+
+    w.Header().Set("Content-Type", common.ApplicationJSON)
+    w.Header().Set(
+        "Content-Disposition",
+        fmt.Sprintf(`attachment; filename="%s"`, DiagnosticsFileName(diagnostics.GeneratedAt.Time)),
+    )
+    encoder := json.NewEncoder(w)
+    encoder.SetIndent("", "  ")
+    if err := encoder.Encode(diagnostics); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
+
+// DiagnosticsFileName is the file name of a diagnostics bundle generated at the given time
+func DiagnosticsFileName(generatedAt time.Time) string {
+    return fmt.Sprintf("tracking-svc-diagnostics-%s.json", generatedAt.UTC().Format("20060102T150405Z"))
+}
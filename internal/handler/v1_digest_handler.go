@@ -0,0 +1,100 @@
+package handler
+
+import (
+    "log"
+    "net/http"
+    "time"
+
+    "github.com/go-playground/validator/v10"
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-common"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+type V1DigestHandler struct {
+    digestService services.DigestService
+    validate      *validator.Validate
+}
+
+func NewV1DigestHandler(digestService services.DigestService, validate *validator.Validate) *V1DigestHandler {
+    return &V1DigestHandler{digestService: digestService, validate: validate}
+}
+
+// FindDigestSubscription returns the digest subscription of the tenant, admin only
+func (h *V1DigestHandler) FindDigestSubscription(w http.ResponseWriter, r *http.Request) {
+    if !authorize(w, r, services.ActionReadDigests, nil) {
+        return
+    }
+
+    subscription, err := h.digestService.FindDigestSubscription(r.Context())
+    if err != nil {
+        handleError(http.StatusInternalServerError, w, err)
+        return
+    }
+
+    if err = json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            subscription,
+            "successfully fetched digest subscription",
+        ),
+    ); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
+
+// SaveDigestSubscription opts the tenant in to the digests or changes its subscription, admin only
+func (h *V1DigestHandler) SaveDigestSubscription(w http.ResponseWriter, r *http.Request) {
+    if !authorize(w, r, services.ActionWriteDigests, nil) {
+        return
+    }
+    var req services.DigestRequest
+    if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodySize)).Decode(&req); err != nil {
+        writeError(http.StatusBadRequest, w, err)
+        return
+    }
+    if err := h.validate.Struct(&req); err != nil {
+        writeError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    subscription, err := h.digestService.SaveDigestSubscription(r.Context(), &req)
+    if err != nil {
+        handleError(http.StatusInternalServerError, w, err)
+        return
+    }
+
+    if err = json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            subscription,
+            "successfully saved digest subscription",
+        ),
+    ); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
+
+// PreviewDigest returns the digest of the tenant over the last complete period, daily by default, admin only
+func (h *V1DigestHandler) PreviewDigest(w http.ResponseWriter, r *http.Request) {
+    if !authorize(w, r, services.ActionReadDigests, nil) {
+        return
+    }
+    period := services.ReportPeriodDaily
+    if value := r.URL.Query().Get("period"); value != "" {
+        period = services.ReportPeriod(value)
+    }
+
+    digest, err := h.digestService.BuildDigest(r.Context(), period, time.Now())
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    if err = json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            digest,
+            "successfully built digest",
+        ),
+    ); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
@@ -0,0 +1,87 @@
+package handler
+
+import (
+    "log"
+    "net/http"
+
+    "github.com/go-playground/validator/v10"
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-common"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+type V1DriverHandler struct {
+    driverService services.DriverService
+    validate      *validator.Validate
+}
+
+func NewV1DriverHandler(driverService services.DriverService, validate *validator.Validate) *V1DriverHandler {
+    return &V1DriverHandler{driverService: driverService, validate: validate}
+}
+
+func (h *V1DriverHandler) FindAssignments(w http.ResponseWriter, r *http.Request) {
+    if !authorize(w, r, services.ActionReadDriverAssignments, nil) {
+        return
+    }
+    assignments, err := h.driverService.FindAssignments(r.Context())
+    if err != nil {
+        handleError(http.StatusInternalServerError, w, err)
+        return
+    }
+
+    writeResults(w, r, assignments, len(assignments), nil, "successfully fetched driver assignments")
+}
+
+// SetAssignment assigns a driver to a vehicle, the next readings of the vehicle sent without a driver are attributed
+// to them
+func (h *V1DriverHandler) SetAssignment(w http.ResponseWriter, r *http.Request) {
+    if !authorize(w, r, services.ActionWriteDriverAssignments, nil) {
+        return
+    }
+
+    var req services.DriverAssignmentRequest
+    if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodySize)).Decode(&req); err != nil {
+        writeError(http.StatusBadRequest, w, err)
+        return
+    }
+    if err := h.validate.Struct(&req); err != nil {
+        writeError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    assignment, err := h.driverService.SetAssignment(r.Context(), &req)
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    if err = json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            assignment,
+            "successfully set driver assignment",
+        ),
+    ); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
+
+// DeleteAssignment unassigns the driver of the vehicle in the path
+func (h *V1DriverHandler) DeleteAssignment(w http.ResponseWriter, r *http.Request) {
+    if !authorize(w, r, services.ActionWriteDriverAssignments, nil) {
+        return
+    }
+
+    if err := h.driverService.DeleteAssignment(r.Context(), r.PathValue("vehicleID")); err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    if err := json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            nil,
+            "successfully deleted driver assignment",
+        ),
+    ); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
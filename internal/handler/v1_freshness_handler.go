@@ -0,0 +1,61 @@
+package handler
+
+import (
+    "log"
+    "net/http"
+
+    "github.com/go-playground/validator/v10"
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-common"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+type V1FreshnessHandler struct {
+    freshnessService services.FreshnessService
+    validate         *validator.Validate
+}
+
+func NewV1FreshnessHandler(
+    freshnessService services.FreshnessService,
+    validate *validator.Validate,
+) *V1FreshnessHandler {
+    return &V1FreshnessHandler{freshnessService: freshnessService, validate: validate}
+}
+
+func (h *V1FreshnessHandler) FindExpectedIntervals(w http.ResponseWriter, r *http.Request) {
+    intervals, err := h.freshnessService.FindExpectedIntervals(r.Context())
+    if err != nil {
+        handleError(http.StatusInternalServerError, w, err)
+        return
+    }
+
+    writeResults(w, r, intervals, len(intervals), nil, "successfully fetched expected intervals")
+}
+
+// SetExpectedInterval sets how often a vehicle is expected to report, overriding the global interval
+func (h *V1FreshnessHandler) SetExpectedInterval(w http.ResponseWriter, r *http.Request) {
+    var req services.ExpectedIntervalRequest
+    if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodySize)).Decode(&req); err != nil {
+        writeError(http.StatusBadRequest, w, err)
+        return
+    }
+    if err := h.validate.Struct(&req); err != nil {
+        writeError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    interval, err := h.freshnessService.SetExpectedInterval(r.Context(), &req)
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    if err = json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            interval,
+            "successfully set expected interval",
+        ),
+    ); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
@@ -0,0 +1,27 @@
+package handler
+
+import (
+    "net/http"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+type V1FuelAnomalyHandler struct {
+    fuelAnomalyService services.FuelAnomalyService
+}
+
+func NewV1FuelAnomalyHandler(fuelAnomalyService services.FuelAnomalyService) *V1FuelAnomalyHandler {
+    return &V1FuelAnomalyHandler{fuelAnomalyService: fuelAnomalyService}
+}
+
+func (h *V1FuelAnomalyHandler) FindFuelAnomalies(w http.ResponseWriter, r *http.Request) {
+    anomalies, err := h.fuelAnomalyService.FindFuelAnomalies(r.Context(), r.URL.Query())
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    services.RecordResultCount(r.Context(), len(anomalies))
+
+    writeResults(w, r, anomalies, len(anomalies), paginate(r), "successfully fetched fuel anomalies")
+}
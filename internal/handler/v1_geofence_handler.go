@@ -0,0 +1,74 @@
+package handler
+
+import (
+    "errors"
+    "log"
+    "net/http"
+    "strconv"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-common"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/geojson"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+type V1GeofenceHandler struct {
+    geofenceService services.GeofenceService
+}
+
+func NewV1GeofenceHandler(geofenceService services.GeofenceService) *V1GeofenceHandler {
+    return &V1GeofenceHandler{geofenceService: geofenceService}
+}
+
+func (h *V1GeofenceHandler) ExportGeofences(w http.ResponseWriter, r *http.Request) {
+    collection, err := h.geofenceService.ExportGeofences(r.Context())
+    if err != nil {
+        handleError(http.StatusInternalServerError, w, err)
+        return
+    }
+
+    // the feature collection is written as is (without the response envelope),
+    // so it can be loaded directly into GIS tools
+    w.Header().Set("Content-Type", geojson.ContentType)
+    w.Header().Set("Content-Disposition", `attachment; filename="geofences.geojson"`)
+    if err = json.NewEncoder(w).Encode(collection); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
+
+func (h *V1GeofenceHandler) ImportGeofences(w http.ResponseWriter, r *http.Request) {
+    dryRun := false
+    if value := r.URL.Query().Get("dry_run"); value != "" {
+        parsed, err := strconv.ParseBool(value)
+        if err != nil {
+            writeError(http.StatusBadRequest, w, err)
+            return
+        }
+        dryRun = parsed
+    }
+
+    var collection geojson.FeatureCollection
+    if err := json.NewDecoder(r.Body).Decode(&collection); err != nil {
+        writeError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    result, err := h.geofenceService.ImportGeofences(r.Context(), &collection, dryRun)
+    // a dry run reports validation errors as part of the result instead of failing
+    if err != nil && !(dryRun && errors.Is(err, services.ErrInvalidGeofences)) {
+        if errors.Is(err, services.ErrInvalidGeofences) || errors.Is(err, services.ErrInvalidFeatureCollection) {
+            handleError(http.StatusBadRequest, w, err)
+            return
+        }
+        writeError(http.StatusInternalServerError, w, err)
+        return
+    }
+
+    message := "successfully imported geofences"
+    if dryRun {
+        message = "dry run completed, no geofences were changed"
+    }
+    if err = json.NewEncoder(w).Encode(common.DefaultSuccessResponse(result, message)); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
@@ -0,0 +1,41 @@
+package handler
+
+import (
+    "net/http"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+type V1HistoryHandler struct {
+    historyService services.HistoryService
+}
+
+func NewV1HistoryHandler(historyService services.HistoryService) *V1HistoryHandler {
+    return &V1HistoryHandler{historyService: historyService}
+}
+
+// Heatmap returns the number of readings and vehicles per cell of a grid over the long-term history
+func (h *V1HistoryHandler) Heatmap(w http.ResponseWriter, r *http.Request) {
+    cells, err := h.historyService.FindHeatmap(r.Context(), r.URL.Query())
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    services.RecordResultCount(r.Context(), len(cells))
+
+    writeResults(w, r, cells, len(cells), nil, "successfully fetched tracking data heatmap")
+}
+
+// Utilization returns the readings, active days, utilization and distance per vehicle and day, week, month or year
+func (h *V1HistoryHandler) Utilization(w http.ResponseWriter, r *http.Request) {
+    utilization, err := h.historyService.FindUtilization(r.Context(), r.URL.Query())
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    services.RecordResultCount(r.Context(), len(utilization))
+
+    writeResults(w, r, utilization, len(utilization), nil, "successfully fetched vehicle utilization")
+}
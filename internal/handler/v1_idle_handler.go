@@ -0,0 +1,39 @@
+package handler
+
+import (
+    "net/http"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+type V1IdleHandler struct {
+    idleService services.IdleService
+}
+
+func NewV1IdleHandler(idleService services.IdleService) *V1IdleHandler {
+    return &V1IdleHandler{idleService: idleService}
+}
+
+func (h *V1IdleHandler) FindSegments(w http.ResponseWriter, r *http.Request) {
+    segments, err := h.idleService.FindSegments(r.Context(), r.URL.Query())
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    services.RecordResultCount(r.Context(), len(segments))
+
+    writeResults(w, r, segments, len(segments), paginate(r), "successfully fetched idle segments")
+}
+
+func (h *V1IdleHandler) FindReport(w http.ResponseWriter, r *http.Request) {
+    report, err := h.idleService.FindReport(r.Context(), r.URL.Query())
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    services.RecordResultCount(r.Context(), len(report))
+
+    writeResults(w, r, report, len(report), paginate(r), "successfully fetched idling report")
+}
@@ -0,0 +1,27 @@
+package handler
+
+import (
+    "net/http"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+type V1IngestionErrorHandler struct {
+    ingestionErrorService services.IngestionErrorService
+}
+
+func NewV1IngestionErrorHandler(ingestionErrorService services.IngestionErrorService) *V1IngestionErrorHandler {
+    return &V1IngestionErrorHandler{ingestionErrorService: ingestionErrorService}
+}
+
+func (h *V1IngestionErrorHandler) FindIngestionErrors(w http.ResponseWriter, r *http.Request) {
+    ingestionErrors, err := h.ingestionErrorService.FindIngestionErrors(r.Context(), r.URL.Query())
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    services.RecordResultCount(r.Context(), len(ingestionErrors))
+
+    writeResults(w, r, ingestionErrors, len(ingestionErrors), paginate(r), "successfully fetched ingestion errors")
+}
@@ -0,0 +1,83 @@
+package handler
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/go-playground/validator/v10"
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+func TestV1IngestionErrorHandler_FindIngestionErrors(t *testing.T) {
+    ingestionErrorService := services.NewMongoIngestionErrorService(&fakeIngestionErrorRepo{})
+    trackingHandler := NewV1TrackingHandler(
+        &fakeTrackingService{},
+        ingestionErrorService,
+        nil,
+        nil,
+        0,
+        validator.New(),
+    )
+    // the readings are rejected by the ingestion endpoint, which records them
+    for _, body := range []string{
+        `{"vehicle_id":`,
+        `{"vehicle_id":"6650c3e0f1a2b3c4d5e6f7a8","location":"Yangon","status":"active","fuel_condition":"full"}`,
+    } {
+        w := httptest.NewRecorder()
+        trackingHandler.CreateTrackingData(
+            w,
+            httptest.NewRequest(http.MethodPost, "/api/v1/tracking-data", strings.NewReader(body)),
+        )
+        if w.Code != http.StatusBadRequest {
+            t.Fatalf("expected the reading to be rejected, got %d %s", w.Code, w.Body.String())
+        }
+    }
+
+    h := NewV1IngestionErrorHandler(ingestionErrorService)
+    find := func(target string) *httptest.ResponseRecorder {
+        w := httptest.NewRecorder()
+        h.FindIngestionErrors(w, httptest.NewRequest(http.MethodGet, target, nil))
+        return w
+    }
+    decode := func(w *httptest.ResponseRecorder) []*repositories.IngestionError {
+        var response struct {
+            Data []*repositories.IngestionError `json:"data"`
+        }
+        if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+            t.Fatal(err)
+        }
+        return response.Data
+    }
+
+    w := find("/api/v1/ingestion-errors")
+    if w.Code != http.StatusOK {
+        t.Fatalf("expected 200, got %d %s", w.Code, w.Body.String())
+    }
+    ingestionErrors := decode(w)
+    if len(ingestionErrors) != 2 {
+        t.Fatalf("expected the 2 rejected readings, got %s", w.Body.String())
+    }
+    invalid, malformed := ingestionErrors[0], ingestionErrors[1]
+    if invalid.Reason != repositories.IngestionReasonInvalid || invalid.Source != repositories.IngestionSourceHTTP ||
+        invalid.VehicleID != "6650c3e0f1a2b3c4d5e6f7a8" || invalid.Error == "" ||
+        time.Since(invalid.CreatedAt.Time) > time.Minute {
+        t.Errorf("expected the invalid reading newest first with its vehicle, got %+v", invalid)
+    }
+    if malformed.Reason != repositories.IngestionReasonMalformed || malformed.Payload != `{"vehicle_id":` {
+        t.Errorf("expected the malformed reading with its payload, got %+v", malformed)
+    }
+
+    w = find("/api/v1/ingestion-errors?reason=" + repositories.IngestionReasonMalformed)
+    if ingestionErrors = decode(w); w.Code != http.StatusOK || len(ingestionErrors) != 1 ||
+        ingestionErrors[0].Reason != repositories.IngestionReasonMalformed {
+        t.Errorf("expected the malformed reading only, got %d %s", w.Code, w.Body.String())
+    }
+    if w = find("/api/v1/ingestion-errors?from=yesterday"); w.Code != http.StatusBadRequest {
+        t.Errorf("expected 400 for an invalid time range, got %d %s", w.Code, w.Body.String())
+    }
+}
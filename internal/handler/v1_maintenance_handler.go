@@ -0,0 +1,73 @@
+package handler
+
+import (
+    "log"
+    "net/http"
+
+    "github.com/go-playground/validator/v10"
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-common"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+type V1MaintenanceHandler struct {
+    maintenanceService services.MaintenanceService
+    validate           *validator.Validate
+}
+
+func NewV1MaintenanceHandler(
+    maintenanceService services.MaintenanceService,
+    validate *validator.Validate,
+) *V1MaintenanceHandler {
+    return &V1MaintenanceHandler{maintenanceService: maintenanceService, validate: validate}
+}
+
+func (h *V1MaintenanceHandler) FindThresholds(w http.ResponseWriter, r *http.Request) {
+    thresholds, err := h.maintenanceService.FindThresholds(r.Context())
+    if err != nil {
+        handleError(http.StatusInternalServerError, w, err)
+        return
+    }
+
+    writeResults(w, r, thresholds, len(thresholds), nil, "successfully fetched maintenance thresholds")
+}
+
+// SetThreshold sets the maintenance interval of a vehicle, overriding the global interval
+func (h *V1MaintenanceHandler) SetThreshold(w http.ResponseWriter, r *http.Request) {
+    var req services.MaintenanceThresholdRequest
+    if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodySize)).Decode(&req); err != nil {
+        writeError(http.StatusBadRequest, w, err)
+        return
+    }
+    if err := h.validate.Struct(&req); err != nil {
+        writeError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    threshold, err := h.maintenanceService.SetThreshold(r.Context(), &req)
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    if err = json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            threshold,
+            "successfully set maintenance threshold",
+        ),
+    ); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
+
+func (h *V1MaintenanceHandler) FindEvents(w http.ResponseWriter, r *http.Request) {
+    events, err := h.maintenanceService.FindEvents(r.Context(), r.URL.Query())
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    services.RecordResultCount(r.Context(), len(events))
+
+    writeResults(w, r, events, len(events), paginate(r), "successfully fetched maintenance events")
+}
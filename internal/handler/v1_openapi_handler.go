@@ -0,0 +1,52 @@
+package handler
+
+import (
+    "log"
+    "net/http"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-common"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/openapi"
+)
+
+// swaggerUI loads Swagger UI from a CDN and points it at the document next to it
+const swaggerUI = `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="utf-8">
+    <title>Tracking Service API</title>
+    <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+<script>
+    window.ui = SwaggerUIBundle({url: "openapi.json", dom_id: "#swagger-ui"});
+</script>
+</body>
+</html>
+`
+
+type V1OpenAPIHandler struct {
+    document *openapi.Document
+}
+
+func NewV1OpenAPIHandler(document *openapi.Document) *V1OpenAPIHandler {
+    return &V1OpenAPIHandler{document: document}
+}
+
+// OpenAPI serves the OpenAPI document of the API as is, without the response envelope, so tools can load it
+func (h *V1OpenAPIHandler) OpenAPI(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", common.ApplicationJSON)
+    if err := json.NewEncoder(w).Encode(h.document); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
+
+// SwaggerUI serves Swagger UI to browse the OpenAPI document
+func (h *V1OpenAPIHandler) SwaggerUI(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "text/html; charset=utf-8")
+    if _, err := w.Write([]byte(swaggerUI)); err != nil {
+        log.Printf("Failed to write response: %v", err)
+    }
+}
@@ -0,0 +1,98 @@
+package handler
+
+import (
+    "context"
+    "errors"
+    "log"
+    "net/http"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-common"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+var (
+    ErrPartnerNotInContext = errors.New("partner is missing from the request context")
+)
+
+type partnerContextKey struct{}
+
+// PartnerFromContext returns the partner authenticated by PartnerAPIKeyMiddleware
+func PartnerFromContext(ctx context.Context) (string, bool) {
+    partner, ok := ctx.Value(partnerContextKey{}).(string)
+    return partner, ok && partner != ""
+}
+
+// PartnerAPIKeyMiddleware authenticates the public statistics requests of municipal partners by their API key
+func PartnerAPIKeyMiddleware(publicStatsService services.PublicStatsService) func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(
+            func(w http.ResponseWriter, r *http.Request) {
+                apiKey := r.Header.Get(APIKeyHeader)
+                if apiKey == "" {
+                    writeError(http.StatusUnauthorized, w, ErrAPIKeyMissing)
+                    return
+                }
+                partner, err := publicStatsService.Authenticate(apiKey)
+                if err != nil {
+                    writeError(http.StatusUnauthorized, w, err)
+                    return
+                }
+                next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), partnerContextKey{}, partner)))
+            },
+        )
+    }
+}
+
+type V1PublicStatsHandler struct {
+    publicStatsService services.PublicStatsService
+}
+
+func NewV1PublicStatsHandler(publicStatsService services.PublicStatsService) *V1PublicStatsHandler {
+    return &V1PublicStatsHandler{publicStatsService: publicStatsService}
+}
+
+// ZoneStats returns the number of distinct vehicles per zone and hour of a period to the calling partner,
+// with noise and without the zones with too few vehicles
+func (h *V1PublicStatsHandler) ZoneStats(w http.ResponseWriter, r *http.Request) {
+    partner, ok := PartnerFromContext(r.Context())
+    if !ok {
+        writeError(http.StatusUnauthorized, w, ErrPartnerNotInContext)
+        return
+    }
+
+    stats, err := h.publicStatsService.ZoneStats(r.Context(), partner, r.URL.Query())
+    if errors.Is(err, services.ErrInvalidStatsPeriod) {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+    if err != nil {
+        writeError(http.StatusInternalServerError, w, err)
+        return
+    }
+
+    if err = json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            stats,
+            "successfully computed zone statistics",
+        ),
+    ); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
+
+// FindDisclosureAudits finds which statistics were disclosed to which partner, admin only
+func (h *V1PublicStatsHandler) FindDisclosureAudits(w http.ResponseWriter, r *http.Request) {
+    if !authorize(w, r, services.ActionReadDisclosureAudits, nil) {
+        return
+    }
+
+    audits, err := h.publicStatsService.FindDisclosureAudits(r.Context(), r.URL.Query())
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+    services.RecordResultCount(r.Context(), len(audits))
+
+    writeResults(w, r, audits, len(audits), paginate(r), "successfully fetched disclosure audits")
+}
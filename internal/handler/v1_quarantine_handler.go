@@ -0,0 +1,78 @@
+package handler
+
+import (
+    "log"
+    "net/http"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-common"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+type V1QuarantineHandler struct {
+    quarantineService services.QuarantineService
+}
+
+func NewV1QuarantineHandler(quarantineService services.QuarantineService) *V1QuarantineHandler {
+    return &V1QuarantineHandler{quarantineService: quarantineService}
+}
+
+// FindQuarantinedReadings lists the readings that failed the data quality checks, the latest first, admin only
+func (h *V1QuarantineHandler) FindQuarantinedReadings(w http.ResponseWriter, r *http.Request) {
+    if !authorize(w, r, services.ActionReadQuarantine, nil) {
+        return
+    }
+
+    readings, err := h.quarantineService.FindQuarantinedReadings(r.Context(), r.URL.Query())
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    services.RecordResultCount(r.Context(), len(readings))
+
+    writeResults(w, r, readings, len(readings), paginate(r), "successfully fetched quarantined readings")
+}
+
+// ReleaseQuarantinedReading stores a reviewed reading as it was received, admin only
+func (h *V1QuarantineHandler) ReleaseQuarantinedReading(w http.ResponseWriter, r *http.Request) {
+    if !authorize(w, r, services.ActionWriteQuarantine, nil) {
+        return
+    }
+
+    record, err := h.quarantineService.ReleaseQuarantinedReading(r.Context(), r.PathValue("id"))
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    if err = json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            record,
+            "successfully released quarantined reading",
+        ),
+    ); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
+
+// DiscardQuarantinedReading deletes a reviewed reading, admin only
+func (h *V1QuarantineHandler) DiscardQuarantinedReading(w http.ResponseWriter, r *http.Request) {
+    if !authorize(w, r, services.ActionWriteQuarantine, nil) {
+        return
+    }
+
+    if err := h.quarantineService.DiscardQuarantinedReading(r.Context(), r.PathValue("id")); err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    if err := json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            nil,
+            "successfully discarded quarantined reading",
+        ),
+    ); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
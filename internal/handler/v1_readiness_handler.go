@@ -0,0 +1,37 @@
+package handler
+
+import (
+    "log"
+    "net/http"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-common"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+type V1ReadinessHandler struct {
+    readinessService services.ReadinessService
+}
+
+func NewV1ReadinessHandler(readinessService services.ReadinessService) *V1ReadinessHandler {
+    return &V1ReadinessHandler{readinessService: readinessService}
+}
+
+// Readiness responds with 503 until the cache is primed and while the service shuts down, so the load balancer
+// only sends traffic to instances ready for it
+func (h *V1ReadinessHandler) Readiness(w http.ResponseWriter, r *http.Request) {
+    readiness := h.readinessService.Readiness()
+
+    w.Header().Set("Content-Type", common.ApplicationJSON)
+    if readiness.Status != services.ReadinessReady {
+        w.WriteHeader(http.StatusServiceUnavailable)
+    }
+    if err := json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            readiness,
+            "successfully computed readiness",
+        ),
+    ); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
@@ -0,0 +1,42 @@
+package handler
+
+import (
+    "context"
+    "net/http"
+    "net/url"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+type V1SafetyScoreHandler struct {
+    safetyScoreService services.SafetyScoreService
+}
+
+func NewV1SafetyScoreHandler(safetyScoreService services.SafetyScoreService) *V1SafetyScoreHandler {
+    return &V1SafetyScoreHandler{safetyScoreService: safetyScoreService}
+}
+
+func (h *V1SafetyScoreHandler) FindScores(w http.ResponseWriter, r *http.Request) {
+    h.find(w, r, h.safetyScoreService.FindScores, "successfully fetched safety scores")
+}
+
+func (h *V1SafetyScoreHandler) FindDailyScores(w http.ResponseWriter, r *http.Request) {
+    h.find(w, r, h.safetyScoreService.FindDailyScores, "successfully fetched daily safety scores")
+}
+
+func (h *V1SafetyScoreHandler) find(
+    w http.ResponseWriter,
+    r *http.Request,
+    find func(ctx context.Context, query url.Values) ([]*services.SafetyScore, error),
+    message string,
+) {
+    scores, err := find(r.Context(), r.URL.Query())
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    services.RecordResultCount(r.Context(), len(scores))
+
+    writeResults(w, r, scores, len(scores), paginate(r), message)
+}
@@ -0,0 +1,128 @@
+package handler
+
+import (
+    "errors"
+    "log"
+    "net/http"
+
+    "github.com/go-playground/validator/v10"
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-common"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+type V1SimulationHandler struct {
+    simulationService services.SimulationService
+    validate          *validator.Validate
+}
+
+func NewV1SimulationHandler(
+    simulationService services.SimulationService,
+    validate *validator.Validate,
+) *V1SimulationHandler {
+    return &V1SimulationHandler{simulationService: simulationService, validate: validate}
+}
+
+// SimulationStatus returns the running simulation with the positions of its vehicles, admin only
+func (h *V1SimulationHandler) SimulationStatus(w http.ResponseWriter, r *http.Request) {
+    if !authorize(w, r, services.ActionReadSimulation, nil) {
+        return
+    }
+    if err := json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            h.simulationService.Status(),
+            "successfully fetched simulation",
+        ),
+    ); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
+
+// StartSimulation starts driving virtual vehicles, their readings are published with the tenant of the request
+func (h *V1SimulationHandler) StartSimulation(w http.ResponseWriter, r *http.Request) {
+    if !authorize(w, r, services.ActionWriteSimulation, nil) {
+        return
+    }
+
+    var req services.SimulationRequest
+    if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodySize)).Decode(&req); err != nil {
+        writeError(http.StatusBadRequest, w, err)
+        return
+    }
+    if err := h.validate.Struct(&req); err != nil {
+        writeError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    status, err := h.simulationService.Start(r.Context(), &req)
+    if errors.Is(err, services.ErrSimulationRunning) {
+        writeError(http.StatusConflict, w, err)
+        return
+    }
+    if err != nil {
+        writeError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusCreated)
+    if err = json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            status,
+            "successfully started simulation",
+        ),
+    ); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
+
+// StopSimulation stops the running simulation
+func (h *V1SimulationHandler) StopSimulation(w http.ResponseWriter, r *http.Request) {
+    if !authorize(w, r, services.ActionWriteSimulation, nil) {
+        return
+    }
+
+    status, err := h.simulationService.Stop()
+    if err != nil {
+        writeError(http.StatusConflict, w, err)
+        return
+    }
+    if err = json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            status,
+            "successfully stopped simulation",
+        ),
+    ); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
+
+// SetSimulationSpeed changes how fast the simulated time of the running simulation passes
+func (h *V1SimulationHandler) SetSimulationSpeed(w http.ResponseWriter, r *http.Request) {
+    if !authorize(w, r, services.ActionWriteSimulation, nil) {
+        return
+    }
+
+    var req services.SimulationSpeedRequest
+    if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodySize)).Decode(&req); err != nil {
+        writeError(http.StatusBadRequest, w, err)
+        return
+    }
+    if err := h.validate.Struct(&req); err != nil {
+        writeError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    status, err := h.simulationService.SetSpeed(req.Speed)
+    if err != nil {
+        writeError(http.StatusConflict, w, err)
+        return
+    }
+    if err = json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            status,
+            "successfully changed simulation speed",
+        ),
+    ); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
@@ -0,0 +1,28 @@
+package handler
+
+import (
+    "net/http"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+type V1StaleVehicleHandler struct {
+    staleVehicleService services.StaleVehicleService
+}
+
+func NewV1StaleVehicleHandler(staleVehicleService services.StaleVehicleService) *V1StaleVehicleHandler {
+    return &V1StaleVehicleHandler{staleVehicleService: staleVehicleService}
+}
+
+// Stale returns the state of the vehicles that stopped reporting
+func (h *V1StaleVehicleHandler) Stale(w http.ResponseWriter, r *http.Request) {
+    states, err := h.staleVehicleService.FindStale(r.Context(), r.URL.Query())
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    services.RecordResultCount(r.Context(), len(states))
+
+    writeResults(w, r, states, len(states), paginate(r), "successfully fetched stale vehicles")
+}
@@ -0,0 +1,78 @@
+package handler
+
+import (
+    "errors"
+    "log"
+    "net/http"
+
+    "github.com/go-playground/validator/v10"
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-common"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+type V1StatusSuggestionHandler struct {
+    inactivityService services.InactivityService
+    validate          *validator.Validate
+}
+
+func NewV1StatusSuggestionHandler(
+    inactivityService services.InactivityService,
+    validate *validator.Validate,
+) *V1StatusSuggestionHandler {
+    return &V1StatusSuggestionHandler{inactivityService: inactivityService, validate: validate}
+}
+
+// FindStatusSuggestions lists the status suggestions, the pending ones unless another state is asked for
+func (h *V1StatusSuggestionHandler) FindStatusSuggestions(w http.ResponseWriter, r *http.Request) {
+    suggestions, err := h.inactivityService.FindSuggestions(r.Context(), r.URL.Query())
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    services.RecordResultCount(r.Context(), len(suggestions))
+
+    writeResults(w, r, suggestions, len(suggestions), paginate(r), "successfully fetched status suggestions")
+}
+
+// ResolveStatusSuggestion accepts or dismisses a pending status suggestion, admin only
+func (h *V1StatusSuggestionHandler) ResolveStatusSuggestion(w http.ResponseWriter, r *http.Request) {
+    if !authorize(w, r, services.ActionWriteStatusSuggestions, nil) {
+        return
+    }
+
+    var req services.ResolveSuggestionRequest
+    if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodySize)).Decode(&req); err != nil {
+        writeError(http.StatusBadRequest, w, err)
+        return
+    }
+    if err := h.validate.Struct(&req); err != nil {
+        writeError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    suggestion, err := h.inactivityService.ResolveSuggestion(r.Context(), r.PathValue("id"), &req)
+    if errors.Is(err, repositories.ErrSuggestionNotFound) {
+        writeError(http.StatusNotFound, w, err)
+        return
+    }
+    if errors.Is(err, repositories.ErrSuggestionResolved) {
+        writeError(http.StatusConflict, w, err)
+        return
+    }
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    if err = json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            suggestion,
+            "successfully resolved status suggestion",
+        ),
+    ); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
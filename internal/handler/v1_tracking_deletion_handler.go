@@ -0,0 +1,81 @@
+package handler
+
+import (
+    "errors"
+    "log"
+    "net/http"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-common"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/plan"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+// DeleteTrackingData soft deletes or purges the tracking data of a vehicle for retention and right to erasure
+// requests, admin only. The deletion is audited with the user who requested it. With dry_run=true nothing is
+// deleted, the plan of the deletion is returned instead.
+func (h *V1TrackingHandler) DeleteTrackingData(w http.ResponseWriter, r *http.Request) {
+    if !authorize(w, r, services.ActionDeleteTrackingData, queryResource(r.URL.Query(), "vehicle_id", "mode", "before")) {
+        return
+    }
+
+    dryRun, err := plan.DryRun(r.URL.Query())
+    if err != nil {
+        writeError(http.StatusBadRequest, w, err)
+        return
+    }
+    if dryRun {
+        p, err := h.deletionService.PlanDeletion(r.Context(), r.URL.Query())
+        if err != nil {
+            handleDeletionError(w, err)
+            return
+        }
+        writePlan(w, p)
+        return
+    }
+
+    audit, err := h.deletionService.DeleteTrackingData(r.Context(), r.URL.Query(), requesterOf(r))
+    if err != nil {
+        handleDeletionError(w, err)
+        return
+    }
+    services.RecordResultCount(r.Context(), int(audit.Deleted))
+
+    if err = json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            audit,
+            "successfully deleted tracking data",
+        ),
+    ); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
+
+func handleDeletionError(w http.ResponseWriter, err error) {
+    status := http.StatusInternalServerError
+    if errors.Is(err, services.ErrDeletionVehicleMissing) ||
+        errors.Is(err, services.ErrInvalidDeletionBefore) ||
+        errors.Is(err, services.ErrInvalidDeletionMode) ||
+        errors.Is(err, repositories.ErrInvalidID) {
+        status = http.StatusBadRequest
+    }
+    handleError(status, w, err)
+}
+
+// FindDeletionAudits finds who deleted which tracking data, admin only
+func (h *V1TrackingHandler) FindDeletionAudits(w http.ResponseWriter, r *http.Request) {
+    if !authorize(w, r, services.ActionReadDeletionAudits, nil) {
+        return
+    }
+
+    audits, err := h.deletionService.FindDeletionAudits(r.Context(), r.URL.Query())
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    services.RecordResultCount(r.Context(), len(audits))
+
+    writeResults(w, r, audits, len(audits), paginate(r), "successfully fetched deletion audits")
+}
@@ -0,0 +1,212 @@
+package handler
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "net/url"
+    "strings"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+const (
+    ExportFormatCSV     = "csv"
+    ExportFormatGeoJSON = "geojson"
+    ExportFormatGPX     = "gpx"
+    ExportFormatJSON    = "json"
+    ExportFormatNDJSON  = "ndjson"
+    ExportFormatParquet = "parquet"
+
+    // exportFlushInterval is the number of records written between flushes to the client
+    exportFlushInterval = 500
+)
+
+var (
+    ErrUnsupportedExportFormat = errors.New("unsupported export format")
+    ErrVehicleIDRequired       = errors.New("a single vehicle_id is required for this export format")
+)
+
+// exportWriter writes tracking records in a file format, records are written one at a time
+type exportWriter interface {
+    ContentType() string
+    Extension() string
+    Begin() error
+    Write(record *repositories.TrackingRecord) error
+    End() error
+    Flush() error
+}
+
+// ExportTrackingData streams the tracking data matching the query parameters as a file download.
+// Records are written as they are read from the database, so exports of any size use constant memory.
+// The geojson and gpx formats export the route of a single vehicle, ordered by time.
+// The file is compressed with gzip or zstd when requested by the compression parameter or the Accept header, except
+// parquet files whose columns are compressed with zstd already.
+func (h *V1TrackingHandler) ExportTrackingData(w http.ResponseWriter, r *http.Request) {
+    query := r.URL.Query()
+    format := query.Get("format")
+    if format == "" {
+        format = ExportFormatCSV
+    }
+    query.Del("format")
+
+    if !authorize(w, r, services.ActionExportTrackingData, exportResource(query, format, time.Now())) {
+        return
+    }
+
+    compression, err := negotiateExportCompression(query.Get("compression"), r.Header.Get("Accept"))
+    if err != nil {
+        writeError(http.StatusBadRequest, w, err)
+        return
+    }
+    query.Del("compression")
+
+    var newWriter func(out io.Writer) exportWriter
+    switch format {
+    case ExportFormatCSV:
+        newWriter = func(out io.Writer) exportWriter {
+            return newCSVExportWriter(out)
+        }
+    case ExportFormatNDJSON:
+        newWriter = func(out io.Writer) exportWriter {
+            return newNDJSONExportWriter(out)
+        }
+    case ExportFormatJSON:
+        newWriter = func(out io.Writer) exportWriter {
+            return newJSONExportWriter(out)
+        }
+    case ExportFormatParquet:
+        // compressing the compressed columns again only costs CPU
+        compression = ""
+        newWriter = func(out io.Writer) exportWriter {
+            return newParquetExportWriter(out)
+        }
+    case ExportFormatGeoJSON, ExportFormatGPX:
+        vehicleID := query.Get("vehicle_id")
+        if vehicleID == "" || strings.Contains(vehicleID, ",") {
+            writeError(http.StatusBadRequest, w, ErrVehicleIDRequired)
+            return
+        }
+        // routes only make sense in the order they were driven
+        query.Set("sort_by", "created_at")
+        query.Set("sort_order", "asc")
+        newWriter = func(out io.Writer) exportWriter {
+            if format == ExportFormatGeoJSON {
+                return newGeoJSONExportWriter(out, vehicleID)
+            }
+            return newGPXExportWriter(out, vehicleID)
+        }
+    default:
+        writeError(http.StatusBadRequest, w, fmt.Errorf("%w: %s", ErrUnsupportedExportFormat, format))
+        return
+    }
+
+    if compression == "" {
+        h.export(w, r, query, newWriter(w))
+        return
+    }
+
+    // the format writer writes to the compressor, which writes the compressed file to the response
+    compressor, err := newExportCompressor(w, compression)
+    if err != nil {
+        writeError(http.StatusInternalServerError, w, err)
+        return
+    }
+    compressed := &compressedExportWriter{
+        exportWriter: newWriter(compressor),
+        compressor:   compressor,
+        compression:  compression,
+    }
+    // completes the file when the export stops early, the compressor may hold resources until then
+    defer func() {
+        if err := compressed.Close(); err != nil {
+            log.Printf("Failed to close export compressor: %v", err)
+        }
+    }()
+    h.export(w, r, query, compressed)
+}
+
+// exportResource describes an export to the policy, age_days is how many days back the export reaches and is left
+// out when it has no start, so policies can limit exports of old data
+func exportResource(query url.Values, format string, now time.Time) map[string]any {
+    resource := queryResource(query, "vehicle_id", "from", "to")
+    resource["format"] = format
+    if from, err := time.Parse(time.RFC3339, query.Get("from")); err == nil {
+        resource["age_days"] = int(now.Sub(from).Hours() / 24)
+    }
+    return resource
+}
+
+func (h *V1TrackingHandler) export(w http.ResponseWriter, r *http.Request, query url.Values, writer exportWriter) {
+    flusher, _ := w.(http.Flusher)
+    records := 0
+    started := false
+    defer func() {
+        services.RecordResultCount(r.Context(), records)
+    }()
+
+    // the headers are written with the first record, so errors before that can still be reported with a status code
+    begin := func() error {
+        started = true
+        w.Header().Set("Content-Type", writer.ContentType())
+        w.Header().Set(
+            "Content-Disposition",
+            fmt.Sprintf(
+                `attachment; filename="tracking-data-%s.%s"`,
+                time.Now().UTC().Format("20060102T150405Z"),
+                writer.Extension(),
+            ),
+        )
+        return writer.Begin()
+    }
+
+    err := h.trackingService.ExportTrackingData(
+        r.Context(), query, func(record *repositories.TrackingRecord) error {
+            if !started {
+                if err := begin(); err != nil {
+                    return err
+                }
+            }
+            if err := writer.Write(record); err != nil {
+                return err
+            }
+            records++
+            if records%exportFlushInterval == 0 {
+                if err := writer.Flush(); err != nil {
+                    return err
+                }
+                if flusher != nil {
+                    flusher.Flush()
+                }
+            }
+            return nil
+        },
+    )
+    if err != nil {
+        if !started {
+            handleError(http.StatusBadRequest, w, err)
+            return
+        }
+        // the response has already started, the best we can do is to stop writing
+        log.Printf("Failed to export tracking data after %d records: %v", records, err)
+        return
+    }
+
+    if !started {
+        if err := begin(); err != nil {
+            log.Printf("Failed to write export: %v", err)
+            return
+        }
+    }
+    if err := writer.End(); err != nil {
+        log.Printf("Failed to write export: %v", err)
+        return
+    }
+    if err := writer.Flush(); err != nil {
+        log.Printf("Failed to write export: %v", err)
+    }
+}
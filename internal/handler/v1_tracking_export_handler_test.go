@@ -0,0 +1,229 @@
+package handler
+
+import (
+    "compress/gzip"
+    "context"
+    "encoding/csv"
+    "errors"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/go-playground/validator/v10"
+    "github.com/goccy/go-json"
+    "github.com/klauspost/compress/zstd"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+)
+
+// ExportTrackingData passes the records it has to fn in order
+func (s *fakeTrackingService) ExportTrackingData(
+    ctx context.Context,
+    query url.Values,
+    fn func(trackingData *repositories.TrackingRecord) error,
+) error {
+    s.query = query
+    for _, record := range s.records {
+        if err := fn(record); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// export returns the response to an export of the records
+func export(
+    records []*repositories.TrackingRecord,
+    target string,
+    headers map[string]string,
+) *httptest.ResponseRecorder {
+    h := NewV1TrackingHandler(
+        &fakeTrackingService{records: records},
+        &fakeIngestionErrorService{},
+        nil,
+        nil,
+        0,
+        validator.New(),
+    )
+    r := httptest.NewRequest(http.MethodGet, target, nil)
+    for name, value := range headers {
+        r.Header.Set(name, value)
+    }
+    w := httptest.NewRecorder()
+    h.ExportTrackingData(w, r)
+    return w
+}
+
+func TestV1TrackingHandler_ExportTrackingData_CSV(t *testing.T) {
+    createdAt := time.Date(2024, 1, 2, 3, 4, 5, 600_000_000, time.UTC)
+    plain := newTestRecord(createdAt)
+    quoted := newTestRecord(createdAt).SetPosition(16.8, 96.15)
+    quoted.Location, quoted.Mileage = `Main St, "North" Gate`+"\nYangon", 1250.5
+
+    w := export([]*repositories.TrackingRecord{plain, quoted}, "/api/v1/tracking-data/export", nil)
+    if w.Code != http.StatusOK {
+        t.Fatalf("expected 200, got %d %s", w.Code, w.Body.String())
+    }
+    if contentType := w.Header().Get("Content-Type"); contentType != "text/csv; charset=utf-8" {
+        t.Errorf("expected a CSV file, got %q", contentType)
+    }
+    if disposition := w.Header().Get("Content-Disposition"); !strings.HasPrefix(disposition, "attachment;") ||
+        !strings.HasSuffix(disposition, `.csv"`) {
+        t.Errorf("expected a CSV attachment, got %q", disposition)
+    }
+
+    expected := "id,vehicle_id,location,mileage,status,fuel_condition,lat,lng,created_at\n" +
+        plain.ID.Hex() + "," + plain.VehicleID.Hex() + ",Yangon,1200,active,full,,,2024-01-02T03:04:05.600Z\n" +
+        quoted.ID.Hex() + "," + quoted.VehicleID.Hex() + `,"Main St, ""North"" Gate` + "\nYangon\"," +
+        "1250.5,active,full,16.8,96.15,2024-01-02T03:04:05.600Z\n"
+    if w.Body.String() != expected {
+        t.Fatalf("expected the header and the escaped records\n%s\ngot\n%s", expected, w.Body.String())
+    }
+
+    // the quoted fields are read back as they were
+    rows, err := csv.NewReader(strings.NewReader(w.Body.String())).ReadAll()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(rows) != 3 || rows[2][2] != quoted.Location {
+        t.Errorf("expected the location to survive the escaping, got %q", rows)
+    }
+
+    // an export without records is the header row only
+    if w = export(nil, "/api/v1/tracking-data/export?format=csv", nil); w.Code != http.StatusOK ||
+        w.Body.String() != strings.Join(csvHeader, ",")+"\n" {
+        t.Errorf("expected the header row only, got %d %q", w.Code, w.Body.String())
+    }
+}
+
+func TestV1TrackingHandler_ExportTrackingData_JSON(t *testing.T) {
+    // many records cross the flushes to the client
+    for _, count := range []int{0, 1, 2*exportFlushInterval + 1} {
+        records := make([]*repositories.TrackingRecord, count)
+        for i := range records {
+            records[i] = newTestRecord(time.Now())
+        }
+
+        w := export(records, "/api/v1/tracking-data/export?format=json", nil)
+        if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+            t.Fatalf("expected a JSON file of %d records, got %d %v", count, w.Code, w.Header())
+        }
+        if !json.Valid(w.Body.Bytes()) {
+            t.Fatalf("expected a valid JSON array of %d records, got %q", count, w.Body.String())
+        }
+        var exported []*repositories.TrackingRecord
+        if err := json.Unmarshal(w.Body.Bytes(), &exported); err != nil {
+            t.Fatal(err)
+        }
+        if exported == nil || len(exported) != count {
+            t.Fatalf("expected an array of %d records, got %d: %.100s", count, len(exported), w.Body.String())
+        }
+        for i, record := range exported {
+            if record.ID != records[i].ID {
+                t.Fatalf("expected record %d to be %s, got %s", i, records[i].ID.Hex(), record.ID.Hex())
+            }
+        }
+    }
+}
+
+func TestNegotiateExportCompression(t *testing.T) {
+    for _, test := range []struct {
+        param    string
+        accept   string
+        expected string
+    }{
+        {"", "", ""},
+        {"", "text/csv", ""},
+        {"", "application/zstd", ExportCompressionZstd},
+        {"", "text/csv, application/gzip", ExportCompressionGzip},
+        {"", "application/zstd;q=0, application/gzip", ExportCompressionGzip},
+        {"gzip", "application/zstd", ExportCompressionGzip},
+        {"zstd", "", ExportCompressionZstd},
+        {"none", "application/zstd", ""},
+    } {
+        compression, err := negotiateExportCompression(test.param, test.accept)
+        if err != nil || compression != test.expected {
+            t.Errorf("expected %q for %q and %q, got %q %v", test.expected, test.param, test.accept, compression, err)
+        }
+    }
+    if _, err := negotiateExportCompression("br", ""); !errors.Is(err, ErrUnsupportedExportCompression) {
+        t.Errorf("expected an unsupported compression, got %v", err)
+    }
+}
+
+func TestV1TrackingHandler_ExportTrackingData_Compression(t *testing.T) {
+    records := []*repositories.TrackingRecord{newTestRecord(time.Now()), newTestRecord(time.Now())}
+    expected := export(records, "/api/v1/tracking-data/export?format=ndjson", nil).Body.String()
+
+    decoders := map[string]func(body io.Reader) (io.Reader, error){
+        ExportCompressionGzip: func(body io.Reader) (io.Reader, error) {
+            return gzip.NewReader(body)
+        },
+        ExportCompressionZstd: func(body io.Reader) (io.Reader, error) {
+            return zstd.NewReader(body)
+        },
+    }
+    for _, test := range []struct {
+        name        string
+        target      string
+        accept      string
+        compression string
+        extension   string
+    }{
+        {"gzip parameter", "?format=ndjson&compression=gzip", "", ExportCompressionGzip, ".ndjson.gz"},
+        {"zstd parameter", "?format=ndjson&compression=zstd", "", ExportCompressionZstd, ".ndjson.zst"},
+        {"gzip accepted", "?format=ndjson", "application/gzip", ExportCompressionGzip, ".ndjson.gz"},
+        {"zstd accepted", "?format=ndjson", "application/zstd, application/gzip", ExportCompressionZstd, ".ndjson.zst"},
+    } {
+        t.Run(
+            test.name, func(t *testing.T) {
+                r := httptest.NewRequest(http.MethodGet, "/api/v1/tracking-data/export"+test.target, nil)
+                r.Header.Set("Accept", test.accept)
+                // compressed exports aren't encoded again by the response compression
+                r.Header.Set("Accept-Encoding", "gzip")
+                w := httptest.NewRecorder()
+                h := NewV1TrackingHandler(
+                    &fakeTrackingService{records: records},
+                    &fakeIngestionErrorService{},
+                    nil,
+                    nil,
+                    0,
+                    validator.New(),
+                )
+                CompressionMiddleware()(http.HandlerFunc(h.ExportTrackingData)).ServeHTTP(w, r)
+
+                if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "" {
+                    t.Fatalf("expected a compressed file without a content encoding, got %d %v", w.Code, w.Header())
+                }
+                if contentType := w.Header().Get("Content-Type"); contentType != "application/"+test.compression {
+                    t.Errorf("expected the content type of %s, got %q", test.compression, contentType)
+                }
+                if disposition := w.Header().Get("Content-Disposition"); !strings.HasSuffix(
+                    disposition,
+                    test.extension+`"`,
+                ) {
+                    t.Errorf("expected the %s extension, got %q", test.extension, disposition)
+                }
+                reader, err := decoders[test.compression](w.Body)
+                if err != nil {
+                    t.Fatal(err)
+                }
+                if decompressed, err := io.ReadAll(reader); err != nil || string(decompressed) != expected {
+                    t.Fatalf("expected the decompressed export\n%s\ngot\n%s %v", expected, decompressed, err)
+                }
+            },
+        )
+    }
+
+    if w := export(records, "/api/v1/tracking-data/export?compression=br", nil); w.Code != http.StatusBadRequest {
+        t.Errorf("expected 400 for an unsupported compression, got %d %s", w.Code, w.Body.String())
+    }
+    // parquet columns are compressed already
+    w := export(records, "/api/v1/tracking-data/export?format=parquet&compression=gzip", nil)
+    if w.Code != http.StatusOK || !strings.HasSuffix(w.Header().Get("Content-Disposition"), `.parquet"`) {
+        t.Errorf("expected an uncompressed parquet file, got %d %v", w.Code, w.Header())
+    }
+}
@@ -1,56 +1,386 @@
 package handler
 
 import (
+    "context"
     "errors"
+    "fmt"
+    "io"
     "log"
     "net/http"
+    "net/url"
+    "time"
 
     "github.com/go-playground/validator/v10"
     "github.com/goccy/go-json"
     "github.com/yemyoaung/managing-vehicle-tracking-common"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
     "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+)
+
+const (
+    maxRequestBodySize      = 1 << 20  // 1MB
+    maxBatchRequestBodySize = 10 << 20 // 10MB
+    maxBatchSize            = 1000
 )
 
 var (
     ErrMethodNotAllowed = errors.New("method was not allowed")
     ErrNotFound         = errors.New("not found")
+    ErrEmptyBatch       = errors.New("batch is empty")
+    ErrBatchTooLarge    = fmt.Errorf("batch exceeds the maximum of %d items", maxBatchSize)
 )
 
+type BatchItemResult struct {
+    Index   int    `json:"index"`
+    Success bool   `json:"success"`
+    ID      string `json:"id,omitempty"`
+    Error   string `json:"error,omitempty"`
+    // Code and Fields are those of the error response the item would get on its own
+    Code   string        `json:"code,omitempty"`
+    Fields []*FieldError `json:"fields,omitempty"`
+}
+
+// fail records why the item wasn't stored
+func (r *BatchItemResult) fail(err error) {
+    response := NewErrorResponse(errorStatus(err, http.StatusUnprocessableEntity), err)
+    r.Error, r.Code, r.Fields = response.Message, response.Code, response.Fields
+}
+
+type BatchResult struct {
+    Total     int                `json:"total"`
+    Succeeded int                `json:"succeeded"`
+    Failed    int                `json:"failed"`
+    Results   []*BatchItemResult `json:"results"`
+}
+
 type V1TrackingHandler struct {
-    trackingService services.TrackingService
-    validate        *validator.Validate
+    trackingService       services.TrackingService
+    ingestionErrorService services.IngestionErrorService
+    deletionService       services.TrackingDeletionService
+    publisher             services.Publisher
+    // publishTimeout bounds publishing a stored reading, it outlives the request
+    publishTimeout time.Duration
+    validate       *validator.Validate
+}
+
+func NewV1TrackingHandler(
+    vehicleService services.TrackingService,
+    ingestionErrorService services.IngestionErrorService,
+    deletionService services.TrackingDeletionService,
+    publisher services.Publisher,
+    publishTimeout time.Duration,
+    validate *validator.Validate,
+) *V1TrackingHandler {
+    return &V1TrackingHandler{
+        trackingService:       vehicleService,
+        ingestionErrorService: ingestionErrorService,
+        deletionService:       deletionService,
+        publisher:             publisher,
+        publishTimeout:        publishTimeout,
+        validate:              validate,
+    }
+}
+
+// publish publishes the stored reading in the background. The tenant of ctx is kept but not its cancellation, so
+// the publishing is bounded by the publish timeout instead.
+func (h *V1TrackingHandler) publish(ctx context.Context, body []byte) {
+    go func() {
+        ctx, cancel := context.WithTimeout(tenant.Detach(ctx), h.publishTimeout)
+        defer cancel()
+        if err := h.publisher.Publish(ctx, body); err != nil {
+            log.Println("Failed to publish message: ", err)
+        }
+    }()
+}
+
+// rejectReading records a rejected reading in the ingestion error log and responds with the matching status
+func (h *V1TrackingHandler) rejectReading(w http.ResponseWriter, r *http.Request, payload []byte, err error) {
+    h.recordIngestionError(r.Context(), payload, err)
+    if services.IngestionErrorReason(err) == repositories.IngestionReasonStorageFailed {
+        handleError(http.StatusInternalServerError, w, err)
+        return
+    }
+    writeError(http.StatusBadRequest, w, err)
+}
+
+func (h *V1TrackingHandler) recordIngestionError(ctx context.Context, payload []byte, err error) {
+    if recordErr := h.ingestionErrorService.RecordIngestionError(
+        ctx,
+        repositories.IngestionSourceHTTP,
+        payload,
+        err,
+    ); recordErr != nil {
+        log.Println("Failed to record ingestion error: ", recordErr)
+    }
 }
 
-func NewV1TrackingHandler(vehicleService services.TrackingService, validate *validator.Validate) *V1TrackingHandler {
-    return &V1TrackingHandler{trackingService: vehicleService, validate: validate}
+// CreateTrackingData ingests a single tracking data reading over HTTP, for devices that can't speak AMQP
+func (h *V1TrackingHandler) CreateTrackingData(w http.ResponseWriter, r *http.Request) {
+    body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBodySize))
+    if err != nil {
+        writeError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    var req services.TrackingDataRequest
+    if err = json.Unmarshal(body, &req); err != nil {
+        h.rejectReading(w, r, body, fmt.Errorf("%w: %w", services.ErrMalformedPayload, err))
+        return
+    }
+
+    if err = h.validate.Struct(&req); err != nil {
+        h.rejectReading(w, r, body, fmt.Errorf("%w: %w", services.ErrInvalidTrackingData, err))
+        return
+    }
+
+    trackingData := h.track(w, r, body, &req)
+    if trackingData == nil {
+        return
+    }
+
+    w.WriteHeader(http.StatusCreated)
+    if err = json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            trackingData,
+            "successfully created tracking data",
+        ),
+    ); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
 }
 
-func (h *V1TrackingHandler) methodWasNotAllowed(w http.ResponseWriter) {
-    common.HandleError(http.StatusMethodNotAllowed, w, ErrMethodNotAllowed)
+// track stores the reading of the request body and publishes it, a rejected reading is answered and nil returned
+func (h *V1TrackingHandler) track(
+    w http.ResponseWriter,
+    r *http.Request,
+    body []byte,
+    req *services.TrackingDataRequest,
+) *repositories.TrackingRecord {
+    trackingData, err := h.trackingService.TrackVehicle(r.Context(), req)
+    if err != nil {
+        h.rejectReading(w, r, body, err)
+        return nil
+    }
+    services.RecordResultCount(r.Context(), 1)
+
+    // Publish the stored tracking data to the vehicle queue, the same as readings consumed from the tracking queue
+    h.publish(r.Context(), services.TrackingDataMessage(trackingData, body))
+    return trackingData
 }
 
 func (h *V1TrackingHandler) FindTrackingData(w http.ResponseWriter, r *http.Request) {
-    if r.Method != http.MethodGet {
-        h.methodWasNotAllowed(w)
+    h.findTrackingData(w, r, r.URL.Query())
+}
+
+// FindTrackingDataByID returns the tracking data with the id in the path, an ObjectID or a public id, so
+// downstream systems can dereference the ids of the vehicle queue events
+func (h *V1TrackingHandler) FindTrackingDataByID(w http.ResponseWriter, r *http.Request) {
+    trackingData, err := h.trackingService.FindTrackingDataByID(r.Context(), r.PathValue("id"))
+    if errors.Is(err, repositories.ErrTrackingDataNotFound) {
+        writeError(http.StatusNotFound, w, err)
+        return
+    }
+    if errors.Is(err, repositories.ErrInvalidID) {
+        writeError(http.StatusBadRequest, w, err)
+        return
+    }
+    if err != nil {
+        handleError(http.StatusInternalServerError, w, err)
+        return
+    }
+    services.RecordResultCount(r.Context(), 1)
+
+    if err = json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            trackingData,
+            "successfully fetched tracking data",
+        ),
+    ); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
+
+// FindVehicleTrackingData finds the tracking data of the vehicle in the path, with the filters of FindTrackingData
+func (h *V1TrackingHandler) FindVehicleTrackingData(w http.ResponseWriter, r *http.Request) {
+    query := r.URL.Query()
+    query.Set("vehicle_id", r.PathValue("vehicleID"))
+    h.findTrackingData(w, r, query)
+}
+
+// FindDriverTrackingData finds the tracking data attributed to the driver in the path, with the filters of
+// FindTrackingData
+func (h *V1TrackingHandler) FindDriverTrackingData(w http.ResponseWriter, r *http.Request) {
+    query := r.URL.Query()
+    query.Set("driver_id", r.PathValue("driverID"))
+    h.findTrackingData(w, r, query)
+}
+
+// findTrackingData answers 304 when the newest tracking data matching the query is still the one the client has,
+// without finding the results, as dashboards poll the same queries every few seconds
+func (h *V1TrackingHandler) findTrackingData(w http.ResponseWriter, r *http.Request, query url.Values) {
+    version, err := h.trackingService.FindTrackingDataVersion(r.Context(), query)
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+    if version != nil && notModified(w, r, version) {
+        w.WriteHeader(http.StatusNotModified)
         return
     }
-    vehicles, err := h.trackingService.FindTrackingData(r.Context(), r.URL.Query())
+
+    vehicles, err := h.trackingService.FindTrackingData(r.Context(), query)
     if err != nil {
-        common.HandleError(http.StatusBadRequest, w, err)
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+    services.RecordResultCount(r.Context(), len(vehicles))
+
+    // projected tracking data only has the requested fields, the query was validated by the service already
+    var data any = vehicles
+    if fields, _ := repositories.SplitValues("fields", query.Get("fields")); len(fields) > 0 {
+        data = repositories.ProjectRecords(vehicles, fields)
+    }
+    writeResults(w, r, data, len(vehicles), paginate(r), "successfully fetched tracking data")
+}
+
+// CreateTrackingDataBatch ingests readings buffered by a device while it was offline.
+// Each reading is validated and stored on its own, the response reports the outcome per item.
+func (h *V1TrackingHandler) CreateTrackingDataBatch(w http.ResponseWriter, r *http.Request) {
+    // decoding into raw messages first, so a malformed item only fails itself
+    var items []json.RawMessage
+    if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchRequestBodySize)).Decode(&items); err != nil {
+        writeError(http.StatusBadRequest, w, err)
+        return
+    }
+    if len(items) == 0 {
+        writeError(http.StatusBadRequest, w, ErrEmptyBatch)
+        return
+    }
+    if len(items) > maxBatchSize {
+        writeError(http.StatusRequestEntityTooLarge, w, ErrBatchTooLarge)
+        return
+    }
+
+    result := &BatchResult{Total: len(items), Results: make([]*BatchItemResult, len(items))}
+    reqs := make([]*services.TrackingDataRequest, 0, len(items))
+    // indexes maps the position in reqs back to the position in items
+    indexes := make([]int, 0, len(items))
+    for i, item := range items {
+        result.Results[i] = &BatchItemResult{Index: i}
+        var req services.TrackingDataRequest
+        if err := json.Unmarshal(item, &req); err != nil {
+            err = fmt.Errorf("%w: %w", services.ErrMalformedPayload, err)
+            h.recordIngestionError(r.Context(), item, err)
+            result.Results[i].fail(err)
+            continue
+        }
+        if err := h.validate.Struct(&req); err != nil {
+            err = fmt.Errorf("%w: %w", services.ErrInvalidTrackingData, err)
+            h.recordIngestionError(r.Context(), item, err)
+            result.Results[i].fail(err)
+            continue
+        }
+        reqs = append(reqs, &req)
+        indexes = append(indexes, i)
+    }
+
+    trackingData, errs := h.trackingService.TrackVehicles(r.Context(), reqs)
+    for j, i := range indexes {
+        if errs[j] != nil {
+            h.recordIngestionError(r.Context(), items[i], errs[j])
+            result.Results[i].fail(errs[j])
+            continue
+        }
+        result.Results[i].Success = true
+        result.Results[i].ID = trackingData[j].ID.Hex()
+
+        // Publish each stored reading to the vehicle queue, the same as single readings
+        h.publish(r.Context(), services.TrackingDataMessage(trackingData[j], items[i]))
+    }
+
+    for _, item := range result.Results {
+        if item.Success {
+            result.Succeeded++
+            continue
+        }
+        result.Failed++
+    }
+    services.RecordResultCount(r.Context(), result.Succeeded)
+
+    if err := json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            result,
+            "successfully processed tracking data batch",
+        ),
+    ); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
+
+// BatchQueryTrackingData returns the latest tracking data of several vehicles grouped per vehicle,
+// so clients showing many vehicles don't need a request per vehicle
+func (h *V1TrackingHandler) BatchQueryTrackingData(w http.ResponseWriter, r *http.Request) {
+    var req services.BatchQueryRequest
+    if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodySize)).Decode(&req); err != nil {
+        writeError(http.StatusBadRequest, w, err)
+        return
+    }
+    if err := h.validate.Struct(&req); err != nil {
+        writeError(http.StatusBadRequest, w, err)
         return
     }
 
-    if len(vehicles) == 0 {
-        common.HandleError(http.StatusNotFound, w, ErrNotFound)
+    results, err := h.trackingService.BatchQueryTrackingData(r.Context(), &req)
+    if errors.Is(err, services.ErrVehicleNotAllowed) {
+        writeError(http.StatusForbidden, w, err)
         return
     }
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+    count := 0
+    for _, result := range results {
+        count += len(result.TrackingData)
+    }
+    services.RecordResultCount(r.Context(), count)
 
     if err = json.NewEncoder(w).Encode(
         common.DefaultSuccessResponse(
-            vehicles,
+            results,
             "successfully fetched tracking data",
         ),
     ); err != nil {
         log.Printf("Failed to encode response: %v", err)
     }
 }
+
+// FindRoute returns the path of a vehicle for map replay, optionally simplified to max_points
+func (h *V1TrackingHandler) FindRoute(w http.ResponseWriter, r *http.Request) {
+    route, err := h.trackingService.FindRoute(r.Context(), r.URL.Query())
+    if errors.Is(err, services.ErrVehicleNotAllowed) {
+        writeError(http.StatusForbidden, w, err)
+        return
+    }
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    services.RecordResultCount(r.Context(), len(route.Path))
+
+    if route.TotalPoints == 0 {
+        writeError(http.StatusNotFound, w, ErrNotFound)
+        return
+    }
+
+    if err = json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            route,
+            "successfully fetched route",
+        ),
+    ); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
@@ -0,0 +1,447 @@
+package handler
+
+import (
+    "context"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/go-playground/validator/v10"
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TrackVehicles tracks the readings one by one, a reading with the vehicle and mileage of a stored one is a duplicate
+func (s *fakeTrackingService) TrackVehicles(
+    ctx context.Context,
+    reqs []*services.TrackingDataRequest,
+) ([]*repositories.TrackingRecord, []error) {
+    results, errs := make([]*repositories.TrackingRecord, len(reqs)), make([]error, len(reqs))
+    for i, req := range reqs {
+        for _, record := range s.records {
+            if record.VehicleID.Hex() == req.VehicleID && record.Mileage == req.Mileage {
+                errs[i] = fmt.Errorf("%w: tracking data %s", repositories.ErrDuplicate, record.ID.Hex())
+            }
+        }
+        if errs[i] == nil {
+            results[i], errs[i] = s.TrackVehicle(ctx, req)
+        }
+    }
+    return results, errs
+}
+
+// BatchQueryTrackingData groups the records it has by the requested vehicles, newest first
+func (s *fakeTrackingService) BatchQueryTrackingData(
+    ctx context.Context,
+    req *services.BatchQueryRequest,
+) ([]*services.VehicleTrackingData, error) {
+    results := make([]*services.VehicleTrackingData, 0, len(req.Vehicles))
+    for _, vehicle := range req.Vehicles {
+        result := &services.VehicleTrackingData{
+            VehicleID:    vehicle.VehicleID,
+            TrackingData: []*repositories.TrackingRecord{},
+        }
+        for i := len(s.records) - 1; i >= 0; i-- {
+            if s.records[i].VehicleID.Hex() == vehicle.VehicleID {
+                result.TrackingData = append(result.TrackingData, s.records[i])
+            }
+        }
+        results = append(results, result)
+    }
+    return results, nil
+}
+
+// contextPublisher publishes once the request is done and sends the context it published with to its channel,
+// with the error the context had by then
+type contextPublisher struct {
+    done      chan struct{}
+    published chan context.Context
+    errs      chan error
+}
+
+func (p *contextPublisher) Publish(ctx context.Context, body []byte) error {
+    <-p.done
+    p.published <- ctx
+    p.errs <- ctx.Err()
+    return nil
+}
+
+func TestV1TrackingHandler_CreateTrackingData_PublishTimeout(t *testing.T) {
+    publisher := &contextPublisher{
+        done:      make(chan struct{}),
+        published: make(chan context.Context, 1),
+        errs:      make(chan error, 1),
+    }
+    h := NewV1TrackingHandler(
+        &fakeTrackingService{},
+        &fakeIngestionErrorService{},
+        nil,
+        publisher,
+        time.Minute,
+        validator.New(),
+    )
+    ctx, cancel := context.WithCancel(context.Background())
+    r := httptest.NewRequestWithContext(
+        ctx,
+        http.MethodPost,
+        "/api/v1/tracking-data",
+        strings.NewReader(
+            `{"vehicle_id":"6650c3e0f1a2b3c4d5e6f7a8","location":"Yangon","mileage":1200,"status":"active",`+
+                `"fuel_condition":"full"}`,
+        ),
+    )
+    w := httptest.NewRecorder()
+    h.CreateTrackingData(w, r)
+    // the client is gone once it has the response
+    cancel()
+    close(publisher.done)
+    if w.Code != http.StatusCreated {
+        t.Fatalf("expected 201, got %d %s", w.Code, w.Body.String())
+    }
+
+    select {
+    case published := <-publisher.published:
+        deadline, ok := published.Deadline()
+        if !ok || time.Until(deadline) > time.Minute {
+            t.Errorf("expected the publishing to be bounded by the publish timeout, got %v", deadline)
+        }
+        if err := <-publisher.errs; err != nil {
+            t.Errorf("expected the publishing to outlive the request, got %v", err)
+        }
+    case <-time.After(time.Second):
+        t.Fatal("expected the reading to be published")
+    }
+}
+
+// batchItems returns a JSON array of n valid readings of the vehicle
+func batchItems(vehicleID string, n int) string {
+    items := make([]string, n)
+    for i := range items {
+        items[i] = fmt.Sprintf(
+            `{"vehicle_id":%q,"location":"Yangon","mileage":%d,"status":"active","fuel_condition":"full"}`,
+            vehicleID,
+            1000+i,
+        )
+    }
+    return "[" + strings.Join(items, ",") + "]"
+}
+
+func TestV1TrackingHandler_CreateTrackingDataBatch(t *testing.T) {
+    vehicleID := primitive.NewObjectID().Hex()
+    trackingService := &fakeTrackingService{}
+    ingestionErrorService := &fakeIngestionErrorService{}
+    publisher := make(fakePublisher, 2)
+    h := NewV1TrackingHandler(trackingService, ingestionErrorService, nil, publisher, time.Second, validator.New())
+
+    w := httptest.NewRecorder()
+    h.CreateTrackingDataBatch(
+        w,
+        httptest.NewRequest(
+            http.MethodPost,
+            "/api/v1/tracking-data/batch",
+            strings.NewReader(
+                `[`+
+                    `{"vehicle_id":"`+vehicleID+`","location":"Yangon","mileage":1200,"status":"active",`+
+                    `"fuel_condition":"full"},`+
+                    `"not a reading",`+
+                    `{"vehicle_id":"`+vehicleID+`","location":"Yangon","status":"active","fuel_condition":"full"},`+
+                    `{"vehicle_id":"`+vehicleID+`","location":"Bago","mileage":1200,"status":"active",`+
+                    `"fuel_condition":"full"},`+
+                    `{"vehicle_id":"`+vehicleID+`","location":"Bago","mileage":1250,"status":"idle",`+
+                    `"fuel_condition":"half"}`+
+                    `]`,
+            ),
+        ),
+    )
+    if w.Code != http.StatusOK {
+        t.Fatalf("expected 200, got %d %s", w.Code, w.Body.String())
+    }
+    var response struct {
+        Data *BatchResult `json:"data"`
+    }
+    if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+        t.Fatal(err)
+    }
+    result := response.Data
+    if result.Total != 5 || result.Succeeded != 2 || result.Failed != 3 || len(result.Results) != 5 {
+        t.Fatalf("expected 2 of the 5 readings to be stored, got %+v", result)
+    }
+
+    for i, expected := range []struct {
+        success bool
+        code    string
+        field   string
+    }{
+        {success: true},
+        {code: CodeMalformedPayload},
+        {code: CodeInvalidData, field: "mileage"},
+        {code: CodeConflict},
+        {success: true},
+    } {
+        item := result.Results[i]
+        if item.Index != i || item.Success != expected.success || item.Code != expected.code {
+            t.Errorf("expected item %d to succeed %v with %q, got %+v", i, expected.success, expected.code, item)
+            continue
+        }
+        if expected.success && (item.ID == "" || item.Error != "") {
+            t.Errorf("expected the id of stored item %d, got %+v", i, item)
+        }
+        if !expected.success && item.Error == "" {
+            t.Errorf("expected the error of item %d, got %+v", i, item)
+        }
+        if expected.field != "" && (len(item.Fields) != 1 || item.Fields[0].Field != expected.field) {
+            t.Errorf("expected item %d to name the invalid field %q, got %+v", i, expected.field, item.Fields)
+        }
+    }
+
+    if len(trackingService.records) != 2 {
+        t.Errorf("expected the valid readings to be stored, got %d", len(trackingService.records))
+    }
+    expectedReasons := []string{CodeMalformedPayload, CodeInvalidData, repositories.IngestionReasonStorageFailed}
+    if fmt.Sprint(ingestionErrorService.reasons) != fmt.Sprint(expectedReasons) {
+        t.Errorf("expected the rejected readings to be recorded as %v, got %v", expectedReasons,
+            ingestionErrorService.reasons)
+    }
+    for range result.Succeeded {
+        select {
+        case <-publisher:
+        case <-time.After(time.Second):
+            t.Fatal("expected every stored reading to be published")
+        }
+    }
+}
+
+func TestV1TrackingHandler_CreateTrackingDataBatch_Size(t *testing.T) {
+    vehicleID := primitive.NewObjectID().Hex()
+    for _, test := range []struct {
+        name     string
+        body     string
+        expected int
+        code     string
+    }{
+        {"empty batch", `[]`, http.StatusBadRequest, CodeEmptyBatch},
+        {"not an array", `{}`, http.StatusBadRequest, CodeBadRequest},
+        {"oversized batch", batchItems(vehicleID, maxBatchSize+1), http.StatusRequestEntityTooLarge, CodeBatchTooLarge},
+    } {
+        t.Run(
+            test.name, func(t *testing.T) {
+                trackingService := &fakeTrackingService{}
+                h := NewV1TrackingHandler(trackingService, &fakeIngestionErrorService{}, nil, nil, 0, validator.New())
+                w := httptest.NewRecorder()
+                h.CreateTrackingDataBatch(
+                    w,
+                    httptest.NewRequest(http.MethodPost, "/api/v1/tracking-data/batch", strings.NewReader(test.body)),
+                )
+
+                if w.Code != test.expected || errorCode(w) != test.code {
+                    t.Fatalf("expected %d %q, got %d %s", test.expected, test.code, w.Code, w.Body.String())
+                }
+                if len(trackingService.records) != 0 {
+                    t.Errorf("expected no reading to be stored, got %d", len(trackingService.records))
+                }
+            },
+        )
+    }
+
+    // the largest batch is accepted
+    publisher := make(fakePublisher, maxBatchSize)
+    h := NewV1TrackingHandler(
+        &fakeTrackingService{},
+        &fakeIngestionErrorService{},
+        nil,
+        publisher,
+        time.Second,
+        validator.New(),
+    )
+    w := httptest.NewRecorder()
+    h.CreateTrackingDataBatch(
+        w,
+        httptest.NewRequest(
+            http.MethodPost,
+            "/api/v1/tracking-data/batch",
+            strings.NewReader(batchItems(vehicleID, maxBatchSize)),
+        ),
+    )
+    if w.Code != http.StatusOK {
+        t.Fatalf("expected a batch of %d readings to be accepted, got %d %s", maxBatchSize, w.Code, w.Body.String())
+    }
+    for range maxBatchSize {
+        select {
+        case <-publisher:
+        case <-time.After(time.Second):
+            t.Fatal("expected every stored reading to be published")
+        }
+    }
+}
+
+func TestV1TrackingHandler_BatchQueryTrackingData(t *testing.T) {
+    trackingService := &fakeTrackingService{}
+    first, second := newTestRecord(time.Now().Add(-time.Minute)), newTestRecord(time.Now())
+    second.VehicleID = first.VehicleID
+    trackingService.records = []*repositories.TrackingRecord{first, second, newTestRecord(time.Now())}
+    h := NewV1TrackingHandler(trackingService, &fakeIngestionErrorService{}, nil, nil, 0, validator.New())
+    query := func(body string) *httptest.ResponseRecorder {
+        w := httptest.NewRecorder()
+        h.BatchQueryTrackingData(
+            w,
+            httptest.NewRequest(http.MethodPost, "/api/v1/tracking-data/batch-query", strings.NewReader(body)),
+        )
+        return w
+    }
+
+    unknown := primitive.NewObjectID().Hex()
+    w := query(`{"vehicles":[{"vehicle_id":"` + unknown + `"},{"vehicle_id":"` + first.VehicleID.Hex() + `"}]}`)
+    if w.Code != http.StatusOK {
+        t.Fatalf("expected 200, got %d %s", w.Code, w.Body.String())
+    }
+    var response struct {
+        Data []*services.VehicleTrackingData `json:"data"`
+    }
+    if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+        t.Fatal(err)
+    }
+    if len(response.Data) != 2 || response.Data[0].VehicleID != unknown ||
+        response.Data[1].VehicleID != first.VehicleID.Hex() {
+        t.Fatalf("expected the vehicles in the requested order, got %s", w.Body.String())
+    }
+    if response.Data[0].TrackingData == nil || len(response.Data[0].TrackingData) != 0 {
+        t.Errorf("expected an empty list for the vehicle without tracking data, got %s", w.Body.String())
+    }
+    if data := response.Data[1].TrackingData; len(data) != 2 || data[0].ID != second.ID || data[1].ID != first.ID {
+        t.Errorf("expected the tracking data of the vehicle newest first, got %s", w.Body.String())
+    }
+
+    vehicles := make([]string, 101)
+    for i := range vehicles {
+        vehicles[i] = `{"vehicle_id":"` + primitive.NewObjectID().Hex() + `"}`
+    }
+    for _, test := range []struct {
+        name string
+        body string
+    }{
+        {"no vehicles", `{"vehicles":[]}`},
+        {"too many vehicles", `{"vehicles":[` + strings.Join(vehicles, ",") + `]}`},
+        {"invalid vehicle id", `{"vehicles":[{"vehicle_id":"v1"}]}`},
+        {"limit over the maximum", `{"limit":101,"vehicles":[{"vehicle_id":"` + unknown + `"}]}`},
+        {"malformed", `{"vehicles":`},
+    } {
+        if w := query(test.body); w.Code != http.StatusBadRequest {
+            t.Errorf("expected 400 for %s, got %d %s", test.name, w.Code, w.Body.String())
+        }
+    }
+}
+
+func TestV1TrackingHandler_CreateTrackingData(t *testing.T) {
+    const vehicleID = "6650c3e0f1a2b3c4d5e6f7a8"
+    trackingRepo := repositories.NewMemoryTrackingRepository()
+    ingestionErrorService := &fakeIngestionErrorService{}
+    publisher := make(fakePublisher, 1)
+    h := NewV1TrackingHandler(
+        services.NewMongoTrackingService(trackingRepo, services.NewMongoOdometerService(nil, trackingRepo), nil, nil),
+        ingestionErrorService,
+        nil,
+        publisher,
+        time.Second,
+        validator.New(),
+    )
+    create := func(body string) *httptest.ResponseRecorder {
+        w := httptest.NewRecorder()
+        h.CreateTrackingData(
+            w,
+            httptest.NewRequest(http.MethodPost, "/api/v1/tracking-data", strings.NewReader(body)),
+        )
+        return w
+    }
+
+    w := create(
+        `{"vehicle_id":"` + vehicleID + `","location":"Yangon","mileage":1200,"status":"active",` +
+            `"fuel_condition":"full"}`,
+    )
+    if w.Code != http.StatusCreated {
+        t.Fatalf("expected 201, got %d %s", w.Code, w.Body.String())
+    }
+    var response struct {
+        Data *repositories.TrackingRecord `json:"data"`
+    }
+    if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+        t.Fatal(err)
+    }
+    created := response.Data
+    if created == nil || created.ID.IsZero() || created.VehicleID.Hex() != vehicleID || created.Location != "Yangon" ||
+        created.Mileage != 1200 || created.CreatedAt.IsZero() {
+        t.Fatalf("expected the stored tracking data, got %s", w.Body.String())
+    }
+    if stored, err := trackingRepo.FindTrackingDataByID(context.Background(), created.ID.Hex()); err != nil ||
+        stored.Location != "Yangon" {
+        t.Errorf("expected the tracking data to be stored, got %v %v", stored, err)
+    }
+    select {
+    case body := <-publisher:
+        var published repositories.TrackingRecord
+        if err := json.Unmarshal(body, &published); err != nil || published.ID != created.ID {
+            t.Errorf("expected the stored tracking data to be published, got %s %v", body, err)
+        }
+    case <-time.After(time.Second):
+        t.Fatal("expected the stored tracking data to be published")
+    }
+
+    for _, test := range []struct {
+        name   string
+        body   string
+        code   string
+        reason string
+    }{
+        {
+            "mileage 0",
+            `{"vehicle_id":"` + vehicleID + `","location":"Yangon","mileage":0,"status":"active",` +
+                `"fuel_condition":"full"}`,
+            CodeInvalidData,
+            repositories.IngestionReasonInvalid,
+        },
+        {
+            "empty location",
+            `{"vehicle_id":"` + vehicleID + `","location":"","mileage":1200,"status":"active",` +
+                `"fuel_condition":"full"}`,
+            CodeInvalidData,
+            repositories.IngestionReasonInvalid,
+        },
+        {
+            "bad fuel_condition",
+            `{"vehicle_id":"` + vehicleID + `","location":"Yangon","mileage":1200,"status":"active",` +
+                `"fuel_condition":"overflowing"}`,
+            CodeInvalidData,
+            repositories.IngestionReasonInvalid,
+        },
+        {
+            "malformed",
+            `{"vehicle_id":"` + vehicleID + `","location":`,
+            CodeMalformedPayload,
+            repositories.IngestionReasonMalformed,
+        },
+    } {
+        t.Run(
+            test.name, func(t *testing.T) {
+                ingestionErrorService.reasons = nil
+                w := create(test.body)
+
+                if w.Code != http.StatusBadRequest || errorCode(w) != test.code {
+                    t.Fatalf("expected 400 %q, got %d %s", test.code, w.Code, w.Body.String())
+                }
+                if len(ingestionErrorService.reasons) != 1 || ingestionErrorService.reasons[0] != test.reason {
+                    t.Errorf("expected the reading to be recorded as %s, got %v", test.reason,
+                        ingestionErrorService.reasons)
+                }
+                select {
+                case body := <-publisher:
+                    t.Errorf("expected the rejected reading not to be published, got %s", body)
+                case <-time.After(50 * time.Millisecond):
+                }
+            },
+        )
+    }
+}
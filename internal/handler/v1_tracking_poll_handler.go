@@ -0,0 +1,48 @@
+package handler
+
+import (
+    "context"
+    "errors"
+    "log"
+    "net/http"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-common"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+type V1TrackingPollHandler struct {
+    pollService services.TrackingPollService
+}
+
+func NewV1TrackingPollHandler(pollService services.TrackingPollService) *V1TrackingPollHandler {
+    return &V1TrackingPollHandler{pollService: pollService}
+}
+
+// PollTrackingData holds the request until tracking data of the vehicles arrives or the wait expires,
+// for clients behind proxies that don't allow streaming connections. An expired wait returns an empty list.
+func (h *V1TrackingPollHandler) PollTrackingData(w http.ResponseWriter, r *http.Request) {
+    poll, err := h.pollService.Poll(r.Context(), r.URL.Query())
+    if errors.Is(err, context.Canceled) {
+        // the client went away, there is nobody to respond to
+        return
+    }
+    if errors.Is(err, services.ErrVehicleNotAllowed) {
+        writeError(http.StatusForbidden, w, err)
+        return
+    }
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+    services.RecordResultCount(r.Context(), len(poll.TrackingData))
+
+    if err = json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            poll,
+            "successfully polled tracking data",
+        ),
+    ); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
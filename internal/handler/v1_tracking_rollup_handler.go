@@ -0,0 +1,28 @@
+package handler
+
+import (
+    "net/http"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+type V1TrackingRollupHandler struct {
+    rollupService services.TrackingRollupService
+}
+
+func NewV1TrackingRollupHandler(rollupService services.TrackingRollupService) *V1TrackingRollupHandler {
+    return &V1TrackingRollupHandler{rollupService: rollupService}
+}
+
+// Rollups returns the 5 minute or hourly summaries of the readings per vehicle
+func (h *V1TrackingRollupHandler) Rollups(w http.ResponseWriter, r *http.Request) {
+    rollups, err := h.rollupService.FindRollups(r.Context(), r.URL.Query())
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    services.RecordResultCount(r.Context(), len(rollups))
+
+    writeResults(w, r, rollups, len(rollups), nil, "successfully fetched tracking data rollups")
+}
@@ -0,0 +1,42 @@
+package handler
+
+import (
+    "net/http"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+type V1TrackingStatsHandler struct {
+    statsService services.TrackingStatsService
+}
+
+func NewV1TrackingStatsHandler(statsService services.TrackingStatsService) *V1TrackingStatsHandler {
+    return &V1TrackingStatsHandler{statsService: statsService}
+}
+
+// TrackingDataStats returns the mileage, activity, fuel condition and status statistics per vehicle
+func (h *V1TrackingStatsHandler) TrackingDataStats(w http.ResponseWriter, r *http.Request) {
+    stats, err := h.statsService.FindVehicleStats(r.Context(), r.URL.Query())
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    services.RecordResultCount(r.Context(), len(stats))
+
+    writeResults(w, r, stats, len(stats), nil, "successfully fetched tracking data stats")
+}
+
+// TrackingDataHeatmap returns the number of readings and vehicles per map tile of a bbox at a zoom, for density
+// heatmaps without sending every position to the browser
+func (h *V1TrackingStatsHandler) TrackingDataHeatmap(w http.ResponseWriter, r *http.Request) {
+    tiles, err := h.statsService.FindHeatmapTiles(r.Context(), r.URL.Query())
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    services.RecordResultCount(r.Context(), len(tiles))
+
+    writeResults(w, r, tiles, len(tiles), nil, "successfully fetched tracking data heatmap")
+}
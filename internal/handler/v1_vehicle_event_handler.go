@@ -0,0 +1,34 @@
+package handler
+
+import (
+    "log"
+    "net/http"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-common"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+type V1VehicleEventHandler struct {
+    vehicleEventService services.VehicleEventService
+}
+
+func NewV1VehicleEventHandler(vehicleEventService services.VehicleEventService) *V1VehicleEventHandler {
+    return &V1VehicleEventHandler{vehicleEventService: vehicleEventService}
+}
+
+// VehicleEventStats shows the published formats of the vehicle updates with the consumers of each, admin only
+func (h *V1VehicleEventHandler) VehicleEventStats(w http.ResponseWriter, r *http.Request) {
+    if !authorize(w, r, services.ActionReadVehicleEvents, nil) {
+        return
+    }
+
+    if err := json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            h.vehicleEventService.Stats(r.Context()),
+            "successfully fetched vehicle event stats",
+        ),
+    ); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
@@ -0,0 +1,28 @@
+package handler
+
+import (
+    "net/http"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+type V1VehicleStateHandler struct {
+    vehicleStateService services.VehicleStateService
+}
+
+func NewV1VehicleStateHandler(vehicleStateService services.VehicleStateService) *V1VehicleStateHandler {
+    return &V1VehicleStateHandler{vehicleStateService: vehicleStateService}
+}
+
+// States returns the current state of the vehicles, the fleet snapshot
+func (h *V1VehicleStateHandler) States(w http.ResponseWriter, r *http.Request) {
+    states, err := h.vehicleStateService.FindStates(r.Context(), r.URL.Query())
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    services.RecordResultCount(r.Context(), len(states))
+
+    writeResults(w, r, states, len(states), paginate(r), "successfully fetched vehicle states")
+}
@@ -0,0 +1,213 @@
+package handler
+
+import (
+    "context"
+    "errors"
+    "log"
+    "net/http"
+
+    "github.com/go-playground/validator/v10"
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-common"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+const (
+    APIKeyHeader = "X-API-Key"
+)
+
+var (
+    ErrAPIKeyMissing      = errors.New("api key is missing")
+    ErrScopeNotGranted    = errors.New("api key is not granted this scope")
+    ErrVendorNotInContext = errors.New("vendor is missing from the request context")
+)
+
+type vendorContextKey struct{}
+
+// VendorFromContext returns the vendor authenticated by VendorAPIKeyMiddleware
+func VendorFromContext(ctx context.Context) (*repositories.Vendor, bool) {
+    vendor, ok := ctx.Value(vendorContextKey{}).(*repositories.Vendor)
+    return vendor, ok
+}
+
+// VendorAPIKeyMiddleware authenticates vendor portal requests by their API key
+func VendorAPIKeyMiddleware(vendorService services.VendorService) func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(
+            func(w http.ResponseWriter, r *http.Request) {
+                apiKey := r.Header.Get(APIKeyHeader)
+                if apiKey == "" {
+                    writeError(http.StatusUnauthorized, w, ErrAPIKeyMissing)
+                    return
+                }
+                vendor, err := vendorService.Authenticate(r.Context(), apiKey)
+                if errors.Is(err, services.ErrInvalidAPIKey) {
+                    writeError(http.StatusUnauthorized, w, err)
+                    return
+                }
+                if err != nil {
+                    handleError(http.StatusInternalServerError, w, err)
+                    return
+                }
+                next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), vendorContextKey{}, vendor)))
+            },
+        )
+    }
+}
+
+type V1VendorHandler struct {
+    vendorService services.VendorService
+    validate      *validator.Validate
+}
+
+func NewV1VendorHandler(vendorService services.VendorService, validate *validator.Validate) *V1VendorHandler {
+    return &V1VendorHandler{vendorService: vendorService, validate: validate}
+}
+
+// CreateVendor registers a vendor, the API key is only part of this response
+func (h *V1VendorHandler) CreateVendor(w http.ResponseWriter, r *http.Request) {
+    var req services.VendorRequest
+    if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodySize)).Decode(&req); err != nil {
+        writeError(http.StatusBadRequest, w, err)
+        return
+    }
+    if err := h.validate.Struct(&req); err != nil {
+        writeError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    vendor, apiKey, err := h.vendorService.CreateVendor(r.Context(), &req)
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusCreated)
+    if err = json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            map[string]any{"vendor": vendor, "api_key": apiKey},
+            "successfully created vendor, store the api key as it won't be shown again",
+        ),
+    ); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
+
+func (h *V1VendorHandler) FindVendors(w http.ResponseWriter, r *http.Request) {
+    vendors, err := h.vendorService.FindVendors(r.Context())
+    if err != nil {
+        handleError(http.StatusInternalServerError, w, err)
+        return
+    }
+
+    writeResults(w, r, vendors, len(vendors), nil, "successfully fetched vendors")
+}
+
+// SetVendorDevices replaces the devices registered to a vendor
+func (h *V1VendorHandler) SetVendorDevices(w http.ResponseWriter, r *http.Request) {
+    var req services.VendorDevicesRequest
+    if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodySize)).Decode(&req); err != nil {
+        writeError(http.StatusBadRequest, w, err)
+        return
+    }
+    if err := h.validate.Struct(&req); err != nil {
+        writeError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    vendor, err := h.vendorService.SetVendorDevices(r.Context(), &req)
+    if errors.Is(err, repositories.ErrVendorNotFound) {
+        writeError(http.StatusNotFound, w, err)
+        return
+    }
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    if err = json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            vendor,
+            "successfully updated vendor devices",
+        ),
+    ); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
+
+// RevokeVendorKey revokes the API key of a vendor, the vendor portal answers 401 to it from then on
+func (h *V1VendorHandler) RevokeVendorKey(w http.ResponseWriter, r *http.Request) {
+    vendor, err := h.vendorService.RevokeVendorKey(r.Context(), r.PathValue("id"))
+    if errors.Is(err, repositories.ErrVendorNotFound) {
+        writeError(http.StatusNotFound, w, err)
+        return
+    }
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    if err = json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            vendor,
+            "successfully revoked vendor api key",
+        ),
+    ); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
+
+// FindVendorIngestionErrors returns the ingestion errors of the calling vendor's devices
+func (h *V1VendorHandler) FindVendorIngestionErrors(w http.ResponseWriter, r *http.Request) {
+    vendor, ok := h.authorize(w, r, repositories.ScopeIngestionErrorsRead)
+    if !ok {
+        return
+    }
+
+    ingestionErrors, err := h.vendorService.FindIngestionErrors(r.Context(), vendor, r.URL.Query())
+    if errors.Is(err, services.ErrDeviceNotOwned) {
+        writeError(http.StatusForbidden, w, err)
+        return
+    }
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    writeResults(w, r, ingestionErrors, len(ingestionErrors), paginate(r), "successfully fetched ingestion errors")
+}
+
+// FindVendorDeviceHealth returns the health of the calling vendor's devices
+func (h *V1VendorHandler) FindVendorDeviceHealth(w http.ResponseWriter, r *http.Request) {
+    vendor, ok := h.authorize(w, r, repositories.ScopeDeviceHealthRead)
+    if !ok {
+        return
+    }
+
+    health, err := h.vendorService.FindDeviceHealth(r.Context(), vendor, r.URL.Query())
+    if errors.Is(err, services.ErrDeviceNotOwned) {
+        writeError(http.StatusForbidden, w, err)
+        return
+    }
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    writeResults(w, r, health, len(health), nil, "successfully fetched device health")
+}
+
+// authorize checks the authenticated vendor's API key was granted the scope
+func (h *V1VendorHandler) authorize(w http.ResponseWriter, r *http.Request, scope string) (*repositories.Vendor, bool) {
+    vendor, ok := VendorFromContext(r.Context())
+    if !ok {
+        writeError(http.StatusUnauthorized, w, ErrVendorNotInContext)
+        return nil, false
+    }
+    if !vendor.HasScope(scope) {
+        writeError(http.StatusForbidden, w, ErrScopeNotGranted)
+        return nil, false
+    }
+    return vendor, true
+}
@@ -0,0 +1,159 @@
+package handler
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeVendorRepo keeps the vendors in memory and finds them by the hash of their key
+type fakeVendorRepo struct {
+    repositories.VendorRepository
+    vendors []*repositories.Vendor
+}
+
+func (r *fakeVendorRepo) CreateVendor(_ context.Context, vendor *repositories.Vendor) error {
+    vendor.ID = primitive.NewObjectID()
+    r.vendors = append(r.vendors, vendor)
+    return nil
+}
+
+func (r *fakeVendorRepo) FindVendorByKeyHash(_ context.Context, keyHash string) (*repositories.Vendor, error) {
+    for _, vendor := range r.vendors {
+        if vendor.KeyHash == keyHash {
+            return vendor, nil
+        }
+    }
+    return nil, repositories.ErrVendorNotFound
+}
+
+func (r *fakeVendorRepo) RevokeVendorKey(_ context.Context, id primitive.ObjectID) (*repositories.Vendor, error) {
+    for _, vendor := range r.vendors {
+        if vendor.ID == id {
+            revokedAt := timestamp.Now()
+            vendor.RevokedAt = &revokedAt
+            return vendor, nil
+        }
+    }
+    return nil, repositories.ErrVendorNotFound
+}
+
+// fakeIngestionErrorRepo keeps the ingestion errors it has in memory and finds them by the vehicle_id and reason
+// filters, newest first
+type fakeIngestionErrorRepo struct {
+    repositories.IngestionErrorRepository
+    ingestionErrors []*repositories.IngestionError
+}
+
+func (r *fakeIngestionErrorRepo) CreateIngestionError(
+    _ context.Context,
+    ingestionError *repositories.IngestionError,
+) error {
+    ingestionError.ID, ingestionError.CreatedAt = primitive.NewObjectID(), timestamp.Now()
+    r.ingestionErrors = append(r.ingestionErrors, ingestionError)
+    return nil
+}
+
+func (r *fakeIngestionErrorRepo) FindIngestionErrors(
+    _ context.Context,
+    filter *repositories.IngestionErrorFilter,
+) ([]*repositories.IngestionError, error) {
+    if err := filter.Build(); err != nil {
+        return nil, err
+    }
+    var found []*repositories.IngestionError
+    for i := len(r.ingestionErrors) - 1; i >= 0; i-- {
+        ingestionError := r.ingestionErrors[i]
+        if (filter.VehicleID == "" || ingestionError.VehicleID == filter.VehicleID) &&
+            (filter.Reason == "" || ingestionError.Reason == filter.Reason) {
+            found = append(found, ingestionError)
+        }
+    }
+    return found, nil
+}
+
+func TestVendorAPIKeyMiddleware(t *testing.T) {
+    acmeDevice, globexDevice := primitive.NewObjectID().Hex(), primitive.NewObjectID().Hex()
+    vendorRepo := &fakeVendorRepo{}
+    vendorService := services.NewMongoVendorService(
+        vendorRepo,
+        &fakeIngestionErrorRepo{
+            ingestionErrors: []*repositories.IngestionError{
+                {VehicleID: acmeDevice, Reason: repositories.IngestionReasonInvalid},
+                {VehicleID: globexDevice, Reason: repositories.IngestionReasonMalformed},
+            },
+        },
+        nil,
+    )
+    ctx := context.Background()
+    _, acmeKey, err := vendorService.CreateVendor(
+        ctx,
+        &services.VendorRequest{Name: "acme", DeviceIDs: []string{acmeDevice}},
+    )
+    if err != nil {
+        t.Fatal(err)
+    }
+    revoked, revokedKey, err := vendorService.CreateVendor(ctx, &services.VendorRequest{Name: "initech"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err = vendorService.RevokeVendorKey(ctx, revoked.ID.Hex()); err != nil {
+        t.Fatal(err)
+    }
+
+    vendorHandler := NewV1VendorHandler(vendorService, nil)
+    vendorRouter := http.NewServeMux()
+    vendorRouter.HandleFunc("GET /api/v1/vendor/ingestion-errors", vendorHandler.FindVendorIngestionErrors)
+    vendorRouter.HandleFunc("GET /api/v1/vendor/device-health", vendorHandler.FindVendorDeviceHealth)
+    h := VendorAPIKeyMiddleware(vendorService)(vendorRouter)
+
+    for _, test := range []struct {
+        name     string
+        apiKey   string
+        target   string
+        expected int
+        code     string
+    }{
+        {"missing key", "", "/api/v1/vendor/ingestion-errors", http.StatusUnauthorized, CodeAPIKeyMissing},
+        {"wrong key", acmeKey + "0", "/api/v1/vendor/ingestion-errors", http.StatusUnauthorized, CodeInvalidAPIKey},
+        {"not a vendor key", "key", "/api/v1/vendor/ingestion-errors", http.StatusUnauthorized, CodeInvalidAPIKey},
+        {"revoked key", revokedKey, "/api/v1/vendor/ingestion-errors", http.StatusUnauthorized, CodeInvalidAPIKey},
+        {"own devices", acmeKey, "/api/v1/vendor/ingestion-errors", http.StatusOK, ""},
+        {"own device", acmeKey, "/api/v1/vendor/ingestion-errors?vehicle_id=" + acmeDevice, http.StatusOK, ""},
+        {
+            "ingestion errors of another vendor",
+            acmeKey,
+            "/api/v1/vendor/ingestion-errors?vehicle_id=" + globexDevice,
+            http.StatusForbidden,
+            "",
+        },
+        {
+            "device health of another vendor",
+            acmeKey,
+            "/api/v1/vendor/device-health?vehicle_id=" + globexDevice,
+            http.StatusForbidden,
+            "",
+        },
+    } {
+        t.Run(
+            test.name, func(t *testing.T) {
+                r := httptest.NewRequest(http.MethodGet, test.target, nil)
+                if test.apiKey != "" {
+                    r.Header.Set(APIKeyHeader, test.apiKey)
+                }
+                w := httptest.NewRecorder()
+                h.ServeHTTP(w, r)
+
+                if w.Code != test.expected || test.code != "" && errorCode(w) != test.code {
+                    t.Fatalf("expected %d %q, got %d %s", test.expected, test.code, w.Code, w.Body.String())
+                }
+            },
+        )
+    }
+}
@@ -0,0 +1,157 @@
+package handler
+
+import (
+    "log"
+    "net/http"
+
+    "github.com/go-playground/validator/v10"
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-common"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+)
+
+type V1WebhookHandler struct {
+    webhookService services.WebhookService
+    validate       *validator.Validate
+}
+
+// WebhookResponse is a created or updated webhook, with its secret when it was created or rotated
+type WebhookResponse struct {
+    Webhook *repositories.Webhook `json:"webhook"`
+    Secret  string                `json:"secret,omitempty"`
+}
+
+func NewV1WebhookHandler(webhookService services.WebhookService, validate *validator.Validate) *V1WebhookHandler {
+    return &V1WebhookHandler{webhookService: webhookService, validate: validate}
+}
+
+// FindWebhooks lists the webhooks without their secrets, admin only
+func (h *V1WebhookHandler) FindWebhooks(w http.ResponseWriter, r *http.Request) {
+    if !authorize(w, r, services.ActionReadWebhooks, nil) {
+        return
+    }
+
+    webhooks, err := h.webhookService.FindWebhooks(r.Context())
+    if err != nil {
+        handleError(http.StatusInternalServerError, w, err)
+        return
+    }
+
+    if err = json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            webhooks,
+            "successfully fetched webhooks",
+        ),
+    ); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
+
+// CreateWebhook registers a webhook, the secret is only part of this response, admin only
+func (h *V1WebhookHandler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+    if !authorize(w, r, services.ActionWriteWebhooks, nil) {
+        return
+    }
+    req, ok := h.decode(w, r)
+    if !ok {
+        return
+    }
+
+    webhook, secret, err := h.webhookService.CreateWebhook(r.Context(), req)
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusCreated)
+    if err = json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            WebhookResponse{Webhook: webhook, Secret: secret},
+            "successfully created webhook, store the secret as it won't be shown again",
+        ),
+    ); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
+
+// UpdateWebhook replaces the url, events and state of a webhook and rotates its secret on request, admin only
+func (h *V1WebhookHandler) UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+    if !authorize(w, r, services.ActionWriteWebhooks, nil) {
+        return
+    }
+    req, ok := h.decode(w, r)
+    if !ok {
+        return
+    }
+
+    webhook, secret, err := h.webhookService.UpdateWebhook(r.Context(), r.PathValue("id"), req)
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    message := "successfully updated webhook"
+    if secret != "" {
+        message = "successfully updated webhook, store the new secret as it won't be shown again"
+    }
+    if err = json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            WebhookResponse{Webhook: webhook, Secret: secret},
+            message,
+        ),
+    ); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
+
+// DeleteWebhook deletes a webhook with its delivery log, its pending deliveries are dropped, admin only
+func (h *V1WebhookHandler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+    if !authorize(w, r, services.ActionWriteWebhooks, nil) {
+        return
+    }
+
+    if err := h.webhookService.DeleteWebhook(r.Context(), r.PathValue("id")); err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    if err := json.NewEncoder(w).Encode(
+        common.DefaultSuccessResponse(
+            nil,
+            "successfully deleted webhook",
+        ),
+    ); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
+
+// FindDeliveries returns the delivery log of a webhook, the newest first, admin only
+func (h *V1WebhookHandler) FindDeliveries(w http.ResponseWriter, r *http.Request) {
+    if !authorize(w, r, services.ActionReadWebhooks, nil) {
+        return
+    }
+
+    deliveries, err := h.webhookService.FindDeliveries(r.Context(), r.PathValue("id"), r.URL.Query())
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    services.RecordResultCount(r.Context(), len(deliveries))
+
+    writeResults(w, r, deliveries, len(deliveries), paginate(r), "successfully fetched webhook deliveries")
+}
+
+func (h *V1WebhookHandler) decode(w http.ResponseWriter, r *http.Request) (*services.WebhookRequest, bool) {
+    var req services.WebhookRequest
+    if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodySize)).Decode(&req); err != nil {
+        writeError(http.StatusBadRequest, w, err)
+        return nil, false
+    }
+    if err := h.validate.Struct(&req); err != nil {
+        writeError(http.StatusBadRequest, w, err)
+        return nil, false
+    }
+    return &req, true
+}
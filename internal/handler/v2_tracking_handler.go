@@ -0,0 +1,282 @@
+package handler
+
+import (
+    "encoding/base64"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "net/url"
+    "time"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-common"
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/geojson"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/params"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// v2PagingParams are the v1 paging parameters, v2 lists are paged by cursor, newest or oldest first
+var v2PagingParams = []string{"page", "sort_by", "sort_order", "fields", "after", "order", "cursor"}
+
+// V2Response is the success envelope of API v2, Page is set for the lists only
+type V2Response struct {
+    Data any         `json:"data"`
+    Page *CursorPage `json:"page,omitempty"`
+}
+
+// CursorPage is a page of a v2 list, NextCursor is the cursor of the next page, left out on the last one
+type CursorPage struct {
+    Limit      int    `json:"limit"`
+    Count      int    `json:"count"`
+    NextCursor string `json:"next_cursor,omitempty" doc:"opaque cursor of the next page, absent on the last page"`
+}
+
+// Point is a GeoJSON Point, its coordinates are [longitude, latitude]
+type Point struct {
+    Type        string    `json:"type" validate:"required,oneof=Point"`
+    Coordinates []float64 `json:"coordinates" validate:"required,len=2"`
+}
+
+// newPoint returns the point of the coordinates, nil without them
+func newPoint(lat, lng *float64) *Point {
+    if lat == nil || lng == nil {
+        return nil
+    }
+    return &Point{Type: geojson.TypePoint, Coordinates: []float64{*lng, *lat}}
+}
+
+// V2TrackingData is a tracking data of API v2, located by its GeoJSON position instead of lat and lng fields
+type V2TrackingData struct {
+    ID              string               `json:"id"`
+    PublicID        string               `json:"public_id,omitempty"`
+    VehicleID       string               `json:"vehicle_id"`
+    DriverID        string               `json:"driver_id,omitempty"`
+    Position        *Point               `json:"position" doc:"GeoJSON Point of the reading, null without coordinates"`
+    MatchedPosition *Point               `json:"matched_position,omitempty" doc:"position snapped to the road network"`
+    RoadName        string               `json:"road_name,omitempty"`
+    Location        string               `json:"location"`
+    Mileage         float64              `json:"mileage"`
+    Status          models.VehicleStatus `json:"status"`
+    FuelCondition   models.FuelCondition `json:"fuel_condition"`
+    Sensors         repositories.Sensors `json:"sensors,omitempty"`
+    Flags           []string             `json:"flags,omitempty"`
+    DistanceMeters  *float64             `json:"distance_meters,omitempty"`
+    OdometerMeters  *float64             `json:"odometer_meters,omitempty"`
+    SpeedKmh        *float64             `json:"speed_kmh,omitempty"`
+    RecordedAt      time.Time            `json:"recorded_at" doc:"when the reading was taken, the created_at of v1"`
+    DeviceTime      *timestamp.Time      `json:"device_time,omitempty"`
+    ReceivedTime    *timestamp.Time      `json:"received_time,omitempty"`
+}
+
+func newV2TrackingData(record *repositories.TrackingRecord) *V2TrackingData {
+    return &V2TrackingData{
+        ID:              record.ID.Hex(),
+        PublicID:        record.PublicID,
+        VehicleID:       record.VehicleID.Hex(),
+        DriverID:        record.DriverID,
+        Position:        newPoint(record.Lat, record.Lng),
+        MatchedPosition: newPoint(record.MatchedLat, record.MatchedLng),
+        RoadName:        record.RoadName,
+        Location:        record.Location,
+        Mileage:         record.Mileage,
+        Status:          record.Status,
+        FuelCondition:   record.FuelCondition,
+        Sensors:         record.Sensors,
+        Flags:           record.Flags,
+        DistanceMeters:  record.DistanceMeters,
+        OdometerMeters:  record.OdometerMeters,
+        SpeedKmh:        record.SpeedKmh,
+        RecordedAt:      record.CreatedAt,
+        DeviceTime:      record.DeviceTime,
+        ReceivedTime:    record.ReceivedTime,
+    }
+}
+
+// V2TrackingDataRequest is a reading sent to API v2, the location is optional and named by the address or the
+// coordinates of the position without one
+type V2TrackingDataRequest struct {
+    VehicleID     string               `json:"vehicle_id" validate:"required,mongodb"`
+    Position      *Point               `json:"position" validate:"required"`
+    Location      string               `json:"location,omitempty"`
+    Mileage       float64              `json:"mileage"`
+    Status        models.VehicleStatus `json:"status" validate:"required"`
+    FuelCondition models.FuelCondition `json:"fuel_condition" validate:"required"`
+    DriverID      string               `json:"driver_id,omitempty" validate:"omitempty,max=64"`
+    Sensors       repositories.Sensors `json:"sensors,omitempty"`
+    Backfill      bool                 `json:"backfill,omitempty"`
+    RecordedAt    *time.Time           `json:"recorded_at,omitempty"`
+    DeviceTime    *time.Time           `json:"device_time,omitempty"`
+}
+
+// toTrackingDataRequest converts the reading to the request of the tracking service
+func (r *V2TrackingDataRequest) toTrackingDataRequest() (*services.TrackingDataRequest, error) {
+    if err := geojson.ValidatePosition(r.Position.Coordinates); err != nil {
+        return nil, err
+    }
+    lng, lat := r.Position.Coordinates[0], r.Position.Coordinates[1]
+    return &services.TrackingDataRequest{
+        TrackingDataRequest: models.TrackingDataRequest{
+            VehicleID:     r.VehicleID,
+            Location:      r.Location,
+            Mileage:       r.Mileage,
+            Status:        r.Status,
+            FuelCondition: r.FuelCondition,
+        },
+        Lat:        &lat,
+        Lng:        &lng,
+        DriverID:   r.DriverID,
+        Sensors:    r.Sensors,
+        Backfill:   r.Backfill,
+        RecordedAt: r.RecordedAt,
+        DeviceTime: r.DeviceTime,
+    }, nil
+}
+
+// encodeCursor returns the opaque cursor of the page after the tracking data with the id
+func encodeCursor(id primitive.ObjectID) string {
+    return base64.RawURLEncoding.EncodeToString(id[:])
+}
+
+// decodeCursor returns the id of the tracking data the page of the cursor starts after
+func decodeCursor(cursor string) (primitive.ObjectID, error) {
+    var id primitive.ObjectID
+    decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+    if err != nil || len(decoded) != len(id) {
+        return id, errors.New("must be the next_cursor of a page")
+    }
+    copy(id[:], decoded)
+    return id, nil
+}
+
+// writeV2 answers the response with status in the envelope of API v2
+func writeV2(w http.ResponseWriter, status int, response *V2Response) {
+    w.Header().Set("Content-Type", common.ApplicationJSON)
+    w.WriteHeader(status)
+    if err := json.NewEncoder(w).Encode(response); err != nil {
+        log.Printf("Failed to encode response: %v", err)
+    }
+}
+
+// V2TrackingHandler serves the tracking data of API v2, with the services of the v1 handler. The lists are paged by
+// cursor and lists without results are answered with an empty array, errors get the envelope of v1.
+type V2TrackingHandler struct {
+    v1 *V1TrackingHandler
+}
+
+func NewV2TrackingHandler(v1 *V1TrackingHandler) *V2TrackingHandler {
+    return &V2TrackingHandler{v1: v1}
+}
+
+// CreateTrackingData ingests a single reading located by its GeoJSON position
+func (h *V2TrackingHandler) CreateTrackingData(w http.ResponseWriter, r *http.Request) {
+    body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBodySize))
+    if err != nil {
+        writeError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    var reading V2TrackingDataRequest
+    if err = json.Unmarshal(body, &reading); err != nil {
+        h.v1.rejectReading(w, r, body, fmt.Errorf("%w: %w", services.ErrMalformedPayload, err))
+        return
+    }
+    if err = h.v1.validate.Struct(&reading); err != nil {
+        h.v1.rejectReading(w, r, body, fmt.Errorf("%w: %w", services.ErrInvalidTrackingData, err))
+        return
+    }
+    req, err := reading.toTrackingDataRequest()
+    if err != nil {
+        h.v1.rejectReading(w, r, body, fmt.Errorf("%w: %w", services.ErrInvalidTrackingData, err))
+        return
+    }
+
+    trackingData := h.v1.track(w, r, body, req)
+    if trackingData == nil {
+        return
+    }
+    writeV2(w, http.StatusCreated, &V2Response{Data: newV2TrackingData(trackingData)})
+}
+
+// FindTrackingDataByID returns the tracking data with the id in the path, an ObjectID or a public id
+func (h *V2TrackingHandler) FindTrackingDataByID(w http.ResponseWriter, r *http.Request) {
+    trackingData, err := h.v1.trackingService.FindTrackingDataByID(r.Context(), r.PathValue("id"))
+    if errors.Is(err, repositories.ErrTrackingDataNotFound) {
+        writeError(http.StatusNotFound, w, err)
+        return
+    }
+    if errors.Is(err, repositories.ErrInvalidID) {
+        writeError(http.StatusBadRequest, w, err)
+        return
+    }
+    if err != nil {
+        handleError(http.StatusInternalServerError, w, err)
+        return
+    }
+    services.RecordResultCount(r.Context(), 1)
+    writeV2(w, http.StatusOK, &V2Response{Data: newV2TrackingData(trackingData)})
+}
+
+// FindTrackingData finds the tracking data with the filters of v1, a page of limit results at a time
+func (h *V2TrackingHandler) FindTrackingData(w http.ResponseWriter, r *http.Request) {
+    h.findTrackingData(w, r, r.URL.Query())
+}
+
+// FindVehicleTrackingData finds the tracking data of the vehicle in the path, like FindTrackingData
+func (h *V2TrackingHandler) FindVehicleTrackingData(w http.ResponseWriter, r *http.Request) {
+    query := r.URL.Query()
+    query.Set("vehicle_id", r.PathValue("vehicleID"))
+    h.findTrackingData(w, r, query)
+}
+
+// findTrackingData pages the tracking data by id, the cursor of a page is the last id of the previous one so
+// readings stored meanwhile don't shift the pages like the page numbers of v1
+func (h *V2TrackingHandler) findTrackingData(w http.ResponseWriter, r *http.Request, query url.Values) {
+    p := params.NewParser(query)
+    order := p.Enum("order", "asc", "desc")
+    var after primitive.ObjectID
+    if cursor := query.Get("cursor"); cursor != "" {
+        var err error
+        if after, err = decodeCursor(cursor); err != nil {
+            p.Invalid("cursor", err.Error())
+        }
+    }
+    if err := p.Err(); err != nil {
+        writeError(http.StatusBadRequest, w, err)
+        return
+    }
+
+    for _, name := range v2PagingParams {
+        query.Del(name)
+    }
+    query.Set("sort_by", "-id")
+    if order == "asc" {
+        query.Set("sort_by", "id")
+    }
+    if !after.IsZero() {
+        query.Set("after", after.Hex())
+    }
+    records, err := h.v1.trackingService.FindTrackingData(r.Context(), query)
+    if err != nil {
+        handleError(http.StatusBadRequest, w, err)
+        return
+    }
+    services.RecordResultCount(r.Context(), len(records))
+
+    data := make([]*V2TrackingData, len(records))
+    for i, record := range records {
+        data[i] = newV2TrackingData(record)
+    }
+    _, limit := repositories.PageOf(query)
+    page := &CursorPage{Limit: limit, Count: len(records)}
+    // a full page may be followed by more results, the next page is empty otherwise
+    if len(records) == limit {
+        page.NextCursor = encodeCursor(records[len(records)-1].ID)
+    }
+    writeV2(w, http.StatusOK, &V2Response{Data: data, Page: page})
+}
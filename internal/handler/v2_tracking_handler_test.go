@@ -0,0 +1,202 @@
+package handler
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/go-playground/validator/v10"
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/services"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakePublisher sends the published messages to its channel
+type fakePublisher chan []byte
+
+func (p fakePublisher) Publish(ctx context.Context, body []byte) error {
+    p <- body
+    return nil
+}
+
+// fakeIngestionErrorService keeps the reasons of the rejected readings
+type fakeIngestionErrorService struct {
+    services.IngestionErrorService
+    reasons []string
+}
+
+func (s *fakeIngestionErrorService) RecordIngestionError(
+    ctx context.Context,
+    source string,
+    payload []byte,
+    err error,
+) error {
+    s.reasons = append(s.reasons, services.IngestionErrorReason(err))
+    return nil
+}
+
+type v2Page struct {
+    Data []*V2TrackingData `json:"data"`
+    Page *CursorPage       `json:"page"`
+}
+
+func findV2Page(t *testing.T, h *V2TrackingHandler, target string) (*httptest.ResponseRecorder, *v2Page) {
+    t.Helper()
+    w := httptest.NewRecorder()
+    h.FindTrackingData(w, httptest.NewRequest(http.MethodGet, target, nil))
+    var page v2Page
+    if w.Code == http.StatusOK {
+        if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+            t.Fatal(err)
+        }
+    }
+    return w, &page
+}
+
+func TestDecodeCursor(t *testing.T) {
+    id := primitive.NewObjectID()
+    decoded, err := decodeCursor(encodeCursor(id))
+    if err != nil || decoded != id {
+        t.Fatalf("expected the cursor to decode to %s, got %s, %v", id.Hex(), decoded.Hex(), err)
+    }
+    for _, cursor := range []string{"not a cursor", encodeCursor(id)[:10], id.Hex()} {
+        if _, err := decodeCursor(cursor); err == nil {
+            t.Errorf("expected %q to be rejected", cursor)
+        }
+    }
+}
+
+func TestV2TrackingHandler_FindTrackingData_Cursor(t *testing.T) {
+    start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+    records := []*repositories.TrackingRecord{newTestRecord(start), newTestRecord(start.Add(time.Minute))}
+    lat, lng := 16.8, 96.1
+    records[0].Lat, records[0].Lng = &lat, &lng
+    trackingService := &fakeTrackingService{records: records}
+    h := NewV2TrackingHandler(NewV1TrackingHandler(trackingService, nil, nil, nil, 0, nil))
+
+    // a full page has a next page
+    w, page := findV2Page(t, h, "/api/v2/tracking-data?limit=2&page=3&sort_by=mileage")
+    if w.Code != http.StatusOK {
+        t.Fatalf("expected 200, got %d %s", w.Code, w.Body.String())
+    }
+    if page.Page.Limit != 2 || page.Page.Count != 2 || page.Page.NextCursor != encodeCursor(records[1].ID) {
+        t.Errorf("expected the cursor after the last reading, got %+v", page.Page)
+    }
+    if trackingService.query.Get("sort_by") != "-id" || trackingService.query.Has("page") ||
+        trackingService.query.Has("after") {
+        t.Errorf("expected the newest first without v1 paging, got %v", trackingService.query)
+    }
+
+    // the cursor pages after the reading, oldest first on request
+    w, page = findV2Page(t, h, "/api/v2/tracking-data?limit=5&order=asc&cursor="+page.Page.NextCursor)
+    if w.Code != http.StatusOK || page.Page.NextCursor != "" || page.Page.Count != 2 {
+        t.Errorf("expected the last page without a cursor, got %d %+v", w.Code, page.Page)
+    }
+    if trackingService.query.Get("sort_by") != "id" || trackingService.query.Get("after") != records[1].ID.Hex() {
+        t.Errorf("expected the readings after the cursor oldest first, got %v", trackingService.query)
+    }
+
+    // readings are located by their GeoJSON position
+    if position := page.Data[0].Position; position == nil || position.Type != "Point" ||
+        position.Coordinates[0] != lng || position.Coordinates[1] != lat {
+        t.Errorf("expected the [lng, lat] point, got %+v", position)
+    }
+    if page.Data[1].Position != nil || !strings.Contains(w.Body.String(), `"position":null`) {
+        t.Errorf("expected a null position without coordinates, got %s", w.Body.String())
+    }
+    if page.Data[0].RecordedAt != start || page.Data[0].Status != models.VehicleStatusActive {
+        t.Errorf("expected the reading of the record, got %+v", page.Data[0])
+    }
+}
+
+func TestV2TrackingHandler_FindTrackingData_Invalid(t *testing.T) {
+    trackingService := &fakeTrackingService{}
+    h := NewV2TrackingHandler(NewV1TrackingHandler(trackingService, nil, nil, nil, 0, nil))
+
+    w, _ := findV2Page(t, h, "/api/v2/tracking-data?cursor=nope&order=up")
+    if w.Code != http.StatusBadRequest || trackingService.finds != 0 {
+        t.Fatalf("expected 400 without finding, got %d %d finds", w.Code, trackingService.finds)
+    }
+    if response := decodeErrorResponse(t, w); response.Code != CodeInvalidQuery || len(response.Fields) != 2 {
+        t.Errorf("expected the invalid order and cursor, got %+v", response.Fields)
+    }
+
+    // lists without results are empty arrays
+    w, page := findV2Page(t, h, "/api/v2/tracking-data")
+    if w.Code != http.StatusOK || page.Data == nil || len(page.Data) != 0 || page.Page.NextCursor != "" {
+        t.Errorf("expected an empty page, got %d %s", w.Code, w.Body.String())
+    }
+}
+
+func TestV2TrackingHandler_CreateTrackingData(t *testing.T) {
+    trackingService := &fakeTrackingService{}
+    ingestionErrorService := &fakeIngestionErrorService{}
+    publisher := make(fakePublisher, 1)
+    h := NewV2TrackingHandler(
+        NewV1TrackingHandler(trackingService, ingestionErrorService, nil, publisher, time.Second, validator.New()),
+    )
+    create := func(body string) *httptest.ResponseRecorder {
+        w := httptest.NewRecorder()
+        h.CreateTrackingData(w, httptest.NewRequest(http.MethodPost, "/api/v2/tracking-data", strings.NewReader(body)))
+        return w
+    }
+
+    w := create(
+        `{"vehicle_id":"6650c3e0f1a2b3c4d5e6f7a8","position":{"type":"Point","coordinates":[96.1,16.8]},` +
+            `"location":"Yangon","mileage":1200,"status":"active","fuel_condition":"full"}`,
+    )
+    if w.Code != http.StatusCreated {
+        t.Fatalf("expected 201, got %d %s", w.Code, w.Body.String())
+    }
+    var response struct {
+        Data *V2TrackingData `json:"data"`
+    }
+    if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+        t.Fatal(err)
+    }
+    if stored := trackingService.records[0]; *stored.Lat != 16.8 || *stored.Lng != 96.1 {
+        t.Errorf("expected the position stored as lat and lng, got %v, %v", *stored.Lat, *stored.Lng)
+    }
+    if position := response.Data.Position; position == nil || position.Coordinates[0] != 96.1 ||
+        position.Coordinates[1] != 16.8 {
+        t.Errorf("expected the stored position, got %+v", position)
+    }
+    select {
+    case <-publisher:
+    case <-time.After(time.Second):
+        t.Error("expected the reading to be published")
+    }
+
+    for _, rejected := range []struct {
+        body   string
+        reason string
+    }{
+        {`{"vehicle_id":`, repositories.IngestionReasonMalformed},
+        {
+            `{"vehicle_id":"6650c3e0f1a2b3c4d5e6f7a8","mileage":1200,"status":"active","fuel_condition":"full"}`,
+            repositories.IngestionReasonInvalid,
+        },
+        {
+            `{"vehicle_id":"6650c3e0f1a2b3c4d5e6f7a8","position":{"type":"Point","coordinates":[196.1,16.8]},` +
+                `"mileage":1200,"status":"active","fuel_condition":"full"}`,
+            repositories.IngestionReasonInvalid,
+        },
+    } {
+        ingestionErrorService.reasons = nil
+        if w := create(rejected.body); w.Code != http.StatusBadRequest || errorCode(w) != rejected.reason {
+            t.Errorf("expected %s to be rejected as %s, got %d %s", rejected.body, rejected.reason, w.Code,
+                w.Body.String())
+        }
+        if len(ingestionErrorService.reasons) != 1 || ingestionErrorService.reasons[0] != rejected.reason {
+            t.Errorf("expected the rejected reading to be recorded, got %v", ingestionErrorService.reasons)
+        }
+    }
+    if len(trackingService.records) != 1 {
+        t.Errorf("expected the rejected readings not to be tracked, got %d", len(trackingService.records))
+    }
+}
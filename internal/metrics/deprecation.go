@@ -0,0 +1,74 @@
+package metrics
+
+import (
+    "slices"
+    "sync"
+    "time"
+)
+
+const (
+    // maxDeprecationCallers bounds the callers tracked per feature, the uses of further callers are counted
+    // as OtherCallers
+    maxDeprecationCallers = 1000
+
+    OtherCallers = "other"
+)
+
+// DeprecationUsage is how often a caller used a deprecated feature since the process started
+type DeprecationUsage struct {
+    Caller   string
+    Count    int64
+    LastSeen time.Time
+}
+
+// DeprecationRecorder counts the uses of deprecated features per caller, it is safe for concurrent use
+type DeprecationRecorder struct {
+    mu    sync.Mutex
+    usage map[string]map[string]*DeprecationUsage
+}
+
+func NewDeprecationRecorder() *DeprecationRecorder {
+    return &DeprecationRecorder{usage: map[string]map[string]*DeprecationUsage{}}
+}
+
+func (r *DeprecationRecorder) Observe(feature, caller string, at time.Time) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    callers, ok := r.usage[feature]
+    if !ok {
+        callers = map[string]*DeprecationUsage{}
+        r.usage[feature] = callers
+    }
+    usage, ok := callers[caller]
+    if !ok && len(callers) >= maxDeprecationCallers {
+        caller = OtherCallers
+        usage, ok = callers[caller]
+    }
+    if !ok {
+        usage = &DeprecationUsage{Caller: caller}
+        callers[caller] = usage
+    }
+    usage.Count++
+    usage.LastSeen = at
+}
+
+// Usage returns the callers of the feature, the most frequent first
+func (r *DeprecationRecorder) Usage(feature string) []DeprecationUsage {
+    r.mu.Lock()
+    usage := make([]DeprecationUsage, 0, len(r.usage[feature]))
+    for _, u := range r.usage[feature] {
+        usage = append(usage, *u)
+    }
+    r.mu.Unlock()
+
+    slices.SortFunc(
+        usage, func(a, b DeprecationUsage) int {
+            if a.Count != b.Count {
+                return int(b.Count - a.Count)
+            }
+            return b.LastSeen.Compare(a.LastSeen)
+        },
+    )
+    return usage
+}
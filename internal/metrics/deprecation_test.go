@@ -0,0 +1,42 @@
+package metrics
+
+import (
+    "strconv"
+    "testing"
+    "time"
+)
+
+func TestDeprecationRecorder(t *testing.T) {
+    recorder := NewDeprecationRecorder()
+    now := time.Now()
+    recorder.Observe("sort_order", "alice", now)
+    recorder.Observe("sort_order", "bob", now)
+    recorder.Observe("sort_order", "bob", now.Add(time.Second))
+    recorder.Observe("other_feature", "alice", now)
+
+    usage := recorder.Usage("sort_order")
+    if len(usage) != 2 || usage[0].Caller != "bob" || usage[0].Count != 2 || usage[1].Count != 1 {
+        t.Fatalf("expected bob with 2 uses before alice with 1, got %+v", usage)
+    }
+    if !usage[0].LastSeen.Equal(now.Add(time.Second)) {
+        t.Errorf("expected the last use of bob, got %v", usage[0].LastSeen)
+    }
+    if usage = recorder.Usage("unused"); len(usage) != 0 {
+        t.Errorf("expected no usage of an unused feature, got %+v", usage)
+    }
+}
+
+func TestDeprecationRecorder_MaxCallers(t *testing.T) {
+    recorder := NewDeprecationRecorder()
+    for i := 0; i < maxDeprecationCallers+10; i++ {
+        recorder.Observe("sort_order", strconv.Itoa(i), time.Now())
+    }
+
+    usage := recorder.Usage("sort_order")
+    if len(usage) != maxDeprecationCallers+1 {
+        t.Fatalf("expected %d callers, got %d", maxDeprecationCallers+1, len(usage))
+    }
+    if usage[0].Caller != OtherCallers || usage[0].Count != 10 {
+        t.Errorf("expected the further callers to be counted as %s, got %+v", OtherCallers, usage[0])
+    }
+}
@@ -0,0 +1,83 @@
+package metrics
+
+import (
+    "slices"
+    "sync"
+    "time"
+)
+
+const (
+    // latencyWindow is the number of most recent latencies kept for percentiles
+    latencyWindow = 1024
+)
+
+// IngestSnapshot is a point in time view of the ingest metrics since the process started
+type IngestSnapshot struct {
+    Since        time.Time
+    Total        int64
+    Errors       int64
+    AvgLatency   time.Duration
+    P95Latency   time.Duration
+    LatencyCount int
+}
+
+// ErrorRate returns the share of failed ingests, 0 without ingests
+func (s IngestSnapshot) ErrorRate() float64 {
+    if s.Total == 0 {
+        return 0
+    }
+    return float64(s.Errors) / float64(s.Total)
+}
+
+// IngestRecorder records the outcome and latency of every ingest, it is safe for concurrent use
+type IngestRecorder struct {
+    mu           sync.Mutex
+    since        time.Time
+    total        int64
+    errors       int64
+    totalLatency time.Duration
+    latencies    []time.Duration
+    next         int
+}
+
+func NewIngestRecorder() *IngestRecorder {
+    return &IngestRecorder{since: time.Now(), latencies: make([]time.Duration, 0, latencyWindow)}
+}
+
+func (r *IngestRecorder) Observe(latency time.Duration, failed bool) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    r.total++
+    if failed {
+        r.errors++
+    }
+    r.totalLatency += latency
+    if len(r.latencies) < latencyWindow {
+        r.latencies = append(r.latencies, latency)
+        return
+    }
+    r.latencies[r.next] = latency
+    r.next = (r.next + 1) % latencyWindow
+}
+
+func (r *IngestRecorder) Snapshot() IngestSnapshot {
+    r.mu.Lock()
+    snapshot := IngestSnapshot{
+        Since:        r.since,
+        Total:        r.total,
+        Errors:       r.errors,
+        LatencyCount: len(r.latencies),
+    }
+    if r.total > 0 {
+        snapshot.AvgLatency = r.totalLatency / time.Duration(r.total)
+    }
+    latencies := slices.Clone(r.latencies)
+    r.mu.Unlock()
+
+    if len(latencies) > 0 {
+        slices.Sort(latencies)
+        snapshot.P95Latency = latencies[(len(latencies)*95+99)/100-1]
+    }
+    return snapshot
+}
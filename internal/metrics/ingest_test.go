@@ -0,0 +1,46 @@
+package metrics
+
+import (
+    "testing"
+    "time"
+)
+
+func TestIngestRecorder(t *testing.T) {
+    recorder := NewIngestRecorder()
+    for i := 1; i <= 100; i++ {
+        recorder.Observe(time.Duration(i)*time.Millisecond, i%10 == 0)
+    }
+
+    snapshot := recorder.Snapshot()
+    if snapshot.Total != 100 || snapshot.Errors != 10 {
+        t.Fatalf("expected 100 ingests with 10 errors, got %d with %d", snapshot.Total, snapshot.Errors)
+    }
+    if rate := snapshot.ErrorRate(); rate != 0.1 {
+        t.Errorf("expected error rate 0.1, got %v", rate)
+    }
+    if snapshot.P95Latency != 95*time.Millisecond {
+        t.Errorf("expected p95 of 95ms, got %v", snapshot.P95Latency)
+    }
+    if snapshot.AvgLatency != 50500*time.Microsecond {
+        t.Errorf("expected average of 50.5ms, got %v", snapshot.AvgLatency)
+    }
+}
+
+func TestIngestRecorder_Window(t *testing.T) {
+    recorder := NewIngestRecorder()
+    for i := 0; i < latencyWindow; i++ {
+        recorder.Observe(time.Second, false)
+    }
+    // the window only keeps the most recent latencies
+    for i := 0; i < latencyWindow; i++ {
+        recorder.Observe(time.Millisecond, false)
+    }
+
+    snapshot := recorder.Snapshot()
+    if snapshot.LatencyCount != latencyWindow {
+        t.Errorf("expected %d latencies, got %d", latencyWindow, snapshot.LatencyCount)
+    }
+    if snapshot.P95Latency != time.Millisecond {
+        t.Errorf("expected p95 of 1ms, got %v", snapshot.P95Latency)
+    }
+}
@@ -0,0 +1,337 @@
+package migration
+
+import (
+    "bufio"
+    "bytes"
+    "context"
+    "encoding/csv"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/goccy/go-json"
+    amqp "github.com/rabbitmq/amqp091-go"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/plan"
+)
+
+const (
+    BackfillOperation = "tracking:backfill"
+    // maxBackfillLine is the longest line of a JSONL backfill input
+    maxBackfillLine = 1 << 20
+)
+
+// BackfillFormat is the format of a backfill input
+type BackfillFormat string
+
+const (
+    // BackfillJSONL has a JSON reading per line
+    BackfillJSONL BackfillFormat = "jsonl"
+    // BackfillCSV has a reading per row, the columns are named by the header row
+    BackfillCSV BackfillFormat = "csv"
+)
+
+var (
+    ErrInvalidReading        = errors.New("invalid reading")
+    ErrQueueMissing          = errors.New("the queue to backfill is required")
+    ErrPublishingMissing     = errors.New("the publishing of the backfilled readings is required")
+    ErrUnknownBackfillFormat = errors.New("the backfill format must be jsonl or csv")
+    ErrCheckpointMismatch    = errors.New("the checkpoint belongs to the backfill of another file")
+)
+
+// BackfillRecord is a reading of a backfill input
+type BackfillRecord struct {
+    // Line is the number of the line the reading starts at
+    Line int
+    // JSON is the line of a JSONL reading, it is only valid during the call
+    JSON []byte
+    // Columns are the values of a CSV reading by the names of the header, nil when the row has another number of
+    // columns than the header
+    Columns map[string]string
+}
+
+// BackfillOptions of a backfill, the readings of the input are published to Queue through the default exchange
+type BackfillOptions struct {
+    Queue string
+    // Format defaults to BackfillJSONL
+    Format BackfillFormat
+    // Publishing turns a reading of the input into its message, the readings it rejects with ErrInvalidReading are
+    // skipped
+    Publishing func(ctx context.Context, record *BackfillRecord) (amqp.Publishing, error)
+    // After skips the readings up to the line, the line of the checkpoint of an interrupted backfill
+    After int
+    // Rate is the number of readings published per second at most, 0 doesn't limit it
+    Rate float64
+    // OnSkip is called with the line and the error of every skipped reading
+    OnSkip func(line int, err error)
+    // OnCheckpoint is called with the line of every reading that was confirmed or skipped, a backfill resumed after
+    // it doesn't publish a reading twice. Its error stops the backfill.
+    OnCheckpoint func(line int) error
+    // OnProgress is called every ProgressInterval and once the backfill stops
+    OnProgress       func(progress BackfillProgress)
+    ProgressInterval time.Duration
+}
+
+// BackfillProgress of a backfill, Line is the line of the last reading that was confirmed or skipped and Offset the
+// bytes of the input read up to the end of it
+type BackfillProgress struct {
+    Published int
+    Skipped   int
+    Line      int
+    Offset    int64
+    Elapsed   time.Duration
+}
+
+// Backfill publishes the readings of r, e.g. the readings exported from another system. Every reading waits for the
+// confirmation of the broker, the backfill stops at the first one that fails to be published and can be resumed
+// after the line of the progress.
+func Backfill(
+    ctx context.Context,
+    broker Broker,
+    r io.Reader,
+    opts BackfillOptions,
+) (progress BackfillProgress, err error) {
+    if err = opts.validate(); err != nil {
+        return progress, err
+    }
+    progress.Line = opts.After
+    started := time.Now()
+    reported := started
+    report := func() {
+        progress.Elapsed = time.Since(started)
+        if opts.OnProgress != nil {
+            opts.OnProgress(progress)
+        }
+        reported = time.Now()
+    }
+    defer report()
+
+    var interval time.Duration
+    if opts.Rate > 0 {
+        interval = time.Duration(float64(time.Second) / opts.Rate)
+    }
+    next := started
+    err = scanRecords(
+        r, opts.Format, func(record *BackfillRecord, offset int64) error {
+            if record.Line <= opts.After {
+                return nil
+            }
+            publishing, err := opts.Publishing(ctx, record)
+            switch {
+            case errors.Is(err, ErrInvalidReading):
+                progress.Skipped++
+                if opts.OnSkip != nil {
+                    opts.OnSkip(record.Line, err)
+                }
+            case err != nil:
+                return fmt.Errorf("line %d: %w", record.Line, err)
+            default:
+                if err = pace(ctx, next); err != nil {
+                    return err
+                }
+                if err = broker.Publish(ctx, "", opts.Queue, publishing); err != nil {
+                    return fmt.Errorf("line %d: %w", record.Line, err)
+                }
+                progress.Published++
+                next = next.Add(interval)
+            }
+            progress.Line, progress.Offset = record.Line, offset
+            if opts.OnCheckpoint != nil {
+                if err = opts.OnCheckpoint(record.Line); err != nil {
+                    return err
+                }
+            }
+            if opts.ProgressInterval > 0 && time.Since(reported) >= opts.ProgressInterval {
+                report()
+            }
+            return nil
+        },
+    )
+    return progress, err
+}
+
+// PlanBackfill plans the backfill of r without publishing anything, the skipped readings are the warnings. The
+// estimate assumes confirmedRate without a rate.
+func PlanBackfill(ctx context.Context, r io.Reader, opts BackfillOptions) (*plan.Plan, error) {
+    if err := opts.validate(); err != nil {
+        return nil, err
+    }
+    var affected, skipped int64
+    ids := []string{}
+    var warnings []string
+    err := scanRecords(
+        r, opts.Format, func(record *BackfillRecord, _ int64) error {
+            if err := ctx.Err(); err != nil {
+                return err
+            }
+            if record.Line <= opts.After {
+                return nil
+            }
+            _, err := opts.Publishing(ctx, record)
+            if errors.Is(err, ErrInvalidReading) {
+                skipped++
+                if len(warnings) < plan.SampleSize {
+                    warnings = append(warnings, fmt.Sprintf("line %d would be skipped: %v", record.Line, err))
+                }
+                return nil
+            }
+            if err != nil {
+                return fmt.Errorf("line %d: %w", record.Line, err)
+            }
+            affected++
+            if len(ids) < plan.SampleSize {
+                ids = append(ids, fmt.Sprintf("#%d", record.Line))
+            }
+            return nil
+        },
+    )
+    if err != nil {
+        return nil, err
+    }
+    if skipped > int64(len(warnings)) {
+        warnings = append(warnings, fmt.Sprintf("%d more lines would be skipped", skipped-int64(len(warnings))))
+    }
+
+    rate := opts.Rate
+    if rate == 0 {
+        rate = confirmedRate
+    }
+    p := plan.New(BackfillOperation, affected, rate)
+    p.SampleIDs = ids
+    p.Scope = map[string]string{"queue": opts.Queue, "format": string(opts.Format)}
+    if opts.After > 0 {
+        p.Scope["after"] = strconv.Itoa(opts.After)
+    }
+    p.Warnings = warnings
+    return p, nil
+}
+
+func (opts *BackfillOptions) validate() error {
+    if opts.Queue == "" {
+        return ErrQueueMissing
+    }
+    if opts.Publishing == nil {
+        return ErrPublishingMissing
+    }
+    if opts.Rate < 0 {
+        return ErrInvalidMigrateRate
+    }
+    if opts.Format == "" {
+        opts.Format = BackfillJSONL
+    }
+    if opts.Format != BackfillJSONL && opts.Format != BackfillCSV {
+        return fmt.Errorf("%w: %s", ErrUnknownBackfillFormat, opts.Format)
+    }
+    return nil
+}
+
+// BackfillFormatOf returns the format of the file by its extension, .csv is CSV and anything else JSONL
+func BackfillFormatOf(file string) BackfillFormat {
+    if strings.EqualFold(filepath.Ext(file), ".csv") {
+        return BackfillCSV
+    }
+    return BackfillJSONL
+}
+
+// scanRecords calls fn with every reading of r and the offset of its end, the blank lines are left out
+func scanRecords(r io.Reader, format BackfillFormat, fn func(record *BackfillRecord, offset int64) error) error {
+    if format == BackfillCSV {
+        return scanCSV(r, fn)
+    }
+    scanner := bufio.NewScanner(r)
+    scanner.Buffer(make([]byte, 0, 64*1024), maxBackfillLine)
+    var offset int64
+    record := &BackfillRecord{}
+    for scanner.Scan() {
+        record.Line++
+        offset += int64(len(scanner.Bytes())) + 1
+        if record.JSON = bytes.TrimSpace(scanner.Bytes()); len(record.JSON) == 0 {
+            continue
+        }
+        if err := fn(record, offset); err != nil {
+            return err
+        }
+    }
+    return scanner.Err()
+}
+
+// scanCSV calls fn with every row after the header
+func scanCSV(r io.Reader, fn func(record *BackfillRecord, offset int64) error) error {
+    reader := csv.NewReader(r)
+    reader.FieldsPerRecord = -1
+    header, err := reader.Read()
+    if errors.Is(err, io.EOF) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    for i := range header {
+        header[i] = strings.ToLower(strings.TrimSpace(header[i]))
+    }
+    for {
+        row, err := reader.Read()
+        if errors.Is(err, io.EOF) {
+            return nil
+        }
+        if err != nil {
+            return err
+        }
+        record := &BackfillRecord{}
+        record.Line, _ = reader.FieldPos(0)
+        if len(row) == len(header) {
+            record.Columns = make(map[string]string, len(header))
+            for i, column := range header {
+                record.Columns[column] = row[i]
+            }
+        }
+        if err = fn(record, reader.InputOffset()); err != nil {
+            return err
+        }
+    }
+}
+
+// BackfillCheckpoint is the progress of a backfill saved to resume it, Line is the line of the last reading that
+// was confirmed or skipped
+type BackfillCheckpoint struct {
+    File string `json:"file"`
+    Line int    `json:"line"`
+}
+
+// LoadBackfillCheckpoint reads the checkpoint of the backfill of file from path, a missing checkpoint starts at the
+// first line
+func LoadBackfillCheckpoint(path string, file string) (int, error) {
+    data, err := os.ReadFile(path)
+    if errors.Is(err, os.ErrNotExist) {
+        return 0, nil
+    }
+    if err != nil {
+        return 0, err
+    }
+    var checkpoint BackfillCheckpoint
+    if err = json.Unmarshal(data, &checkpoint); err != nil {
+        return 0, err
+    }
+    if checkpoint.File != file {
+        return 0, fmt.Errorf("%w: %s", ErrCheckpointMismatch, checkpoint.File)
+    }
+    return checkpoint.Line, nil
+}
+
+// SaveBackfillCheckpoint writes the checkpoint of the backfill of file to path, through a temporary file renamed
+// over it so an interrupted write keeps the previous checkpoint
+func SaveBackfillCheckpoint(path string, file string, line int) error {
+    data, err := json.Marshal(BackfillCheckpoint{File: file, Line: line})
+    if err != nil {
+        return err
+    }
+    temporary := path + ".tmp"
+    if err = os.WriteFile(temporary, data, 0o600); err != nil {
+        return err
+    }
+    return os.Rename(temporary, path)
+}
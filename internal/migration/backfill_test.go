@@ -0,0 +1,116 @@
+package migration
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "path/filepath"
+    "strings"
+    "testing"
+
+    amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// jsonPublishing publishes the JSON objects of a JSONL input and the ids of a CSV input, the others are invalid
+// readings
+func jsonPublishing(_ context.Context, record *BackfillRecord) (amqp.Publishing, error) {
+    if record.Columns != nil && record.Columns["id"] != "" {
+        return amqp.Publishing{Body: []byte(record.Columns["id"])}, nil
+    }
+    if len(record.JSON) == 0 || record.JSON[0] != '{' {
+        return amqp.Publishing{}, ErrInvalidReading
+    }
+    return amqp.Publishing{Body: bytes.Clone(record.JSON)}, nil
+}
+
+func TestBackfill(t *testing.T) {
+    broker := &memoryBroker{fail: `{"id":"4"}`}
+    input := strings.NewReader("{\"id\":\"1\"}\n\nnot json\n  {\"id\":\"2\"}  \n{\"id\":\"4\"}\n{\"id\":\"5\"}\n")
+    var skipped, checkpoints []int
+    progress, err := Backfill(
+        context.Background(),
+        broker,
+        input,
+        BackfillOptions{
+            Queue:      "tracking",
+            Publishing: jsonPublishing,
+            OnSkip: func(line int, _ error) {
+                skipped = append(skipped, line)
+            },
+            OnCheckpoint: func(line int) error {
+                checkpoints = append(checkpoints, line)
+                return nil
+            },
+        },
+    )
+    // a reading that fails to be published stops the backfill
+    if !errors.Is(err, ErrPublishNacked) || !strings.Contains(err.Error(), "line 5") {
+        t.Fatalf("expected the backfill to stop at line 5, got %v", err)
+    }
+    if progress.Published != 2 || progress.Skipped != 1 || len(skipped) != 1 || skipped[0] != 3 {
+        t.Errorf("expected 2 published and line 3 skipped, got %+v, %v", progress, skipped)
+    }
+    if progress.Line != 4 || progress.Offset != 36 || len(checkpoints) != 3 || checkpoints[2] != 4 {
+        t.Errorf("expected the progress up to line 4, got %+v, %v", progress, checkpoints)
+    }
+    if broker.published[1].exchange != "" || broker.published[1].key != "tracking" ||
+        string(broker.published[1].msg.Body) != `{"id":"2"}` {
+        t.Errorf("expected the trimmed reading in the queue, got %+v", broker.published[1])
+    }
+}
+
+func TestBackfillCSV(t *testing.T) {
+    broker := &memoryBroker{}
+    input := strings.NewReader("ID,Mileage\n1,12\n2\n\"3\nx\",14\n4,15\n")
+    progress, err := Backfill(
+        context.Background(),
+        broker,
+        input,
+        // a resumed backfill skips the readings up to the line of its checkpoint
+        BackfillOptions{Queue: "tracking", Format: BackfillCSV, Publishing: jsonPublishing, After: 2},
+    )
+    if err != nil {
+        t.Fatal(err)
+    }
+    // the row with a missing column is skipped and the quoted line break is a column of a row
+    if progress.Published != 2 || progress.Skipped != 1 || progress.Line != 6 {
+        t.Errorf("expected 2 readings published after line 2, got %+v", progress)
+    }
+    if string(broker.published[0].msg.Body) != "3\nx" || string(broker.published[1].msg.Body) != "4" {
+        t.Errorf("expected the ids of the rows, got %+v", broker.published)
+    }
+}
+
+func TestPlanBackfill(t *testing.T) {
+    input := strings.NewReader(strings.Repeat("{}\n", 1200) + strings.Repeat("[]\n", 12))
+    p, err := PlanBackfill(context.Background(), input, BackfillOptions{Queue: "tracking", Publishing: jsonPublishing})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if p.Affected != 1200 || p.SampleIDs[0] != "#1" || p.EstimatedSeconds != 3 {
+        t.Errorf("expected 1200 readings at the confirmed rate, got %+v", p)
+    }
+    if len(p.Warnings) != 11 || p.Warnings[10] != "2 more lines would be skipped" {
+        t.Errorf("expected the skipped lines to be warned about, got %v", p.Warnings)
+    }
+    opts := BackfillOptions{Queue: "tracking", Format: "xml", Publishing: jsonPublishing}
+    if _, err = PlanBackfill(context.Background(), input, opts); !errors.Is(err, ErrUnknownBackfillFormat) {
+        t.Errorf("expected ErrUnknownBackfillFormat, got %v", err)
+    }
+}
+
+func TestBackfillCheckpoint(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "backfill.checkpoint")
+    if line, err := LoadBackfillCheckpoint(path, "export.csv"); err != nil || line != 0 {
+        t.Fatalf("expected a missing checkpoint to start at the first line, got %d, %v", line, err)
+    }
+    if err := SaveBackfillCheckpoint(path, "export.csv", 42); err != nil {
+        t.Fatal(err)
+    }
+    if line, err := LoadBackfillCheckpoint(path, "export.csv"); err != nil || line != 42 {
+        t.Errorf("expected the saved line, got %d, %v", line, err)
+    }
+    if _, err := LoadBackfillCheckpoint(path, "other.csv"); !errors.Is(err, ErrCheckpointMismatch) {
+        t.Errorf("expected ErrCheckpointMismatch, got %v", err)
+    }
+}
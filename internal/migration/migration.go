@@ -0,0 +1,373 @@
+package migration
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "time"
+
+    "github.com/goccy/go-json"
+    amqp "github.com/rabbitmq/amqp091-go"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/envelope"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/event"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/plan"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+)
+
+const (
+    Operation = "queue:migrate"
+    // confirmedRate is the number of messages per second the plans of migrations without a rate assume, every
+    // message waits for the confirmation of its copy
+    confirmedRate = 500
+
+    // The headers of the replay metadata, set on the messages replayed from a dead-letter queue
+    HeaderReplayCount  = "x-replay-count"
+    HeaderReplayedFrom = "x-replayed-from"
+    HeaderReplayedAt   = "x-replayed-at"
+)
+
+var (
+    ErrSourceMissing      = errors.New("the queue to migrate from is required")
+    ErrPublishNacked      = errors.New("the broker didn't confirm the message")
+    ErrUnroutable         = errors.New("the message can't be routed by the destination exchange")
+    ErrEncryptedMessage   = errors.New("encrypted messages can't be transformed")
+    ErrUnknownTransform   = errors.New("unknown transform")
+    ErrInvalidMigrateRate = errors.New("rate must not be negative")
+    ErrNotJSONObject      = errors.New("the message isn't a JSON object")
+)
+
+// Broker is the part of an AMQP channel the migration needs
+type Broker interface {
+    // Get takes the next message of the queue without acknowledging it, false when the queue is empty
+    Get(queue string) (amqp.Delivery, bool, error)
+    // Publish returns once the broker confirmed the message
+    Publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error
+}
+
+// Transform is a hook changing a message before it is republished, e.g. to the format of the destination. The
+// publishing starts as a copy of the delivery.
+type Transform func(delivery *amqp.Delivery, publishing *amqp.Publishing) error
+
+// Transforms are the transforms that can be chosen by name
+var Transforms = map[string]Transform{
+    "envelope": EnvelopeTransform(event.TypeVehicleUpdated),
+}
+
+// EnvelopeTransform wraps the JSON bodies in an event envelope of the type, messages that already are envelopes
+// are left as they are so a migration can be run again
+func EnvelopeTransform(eventType string) Transform {
+    return func(delivery *amqp.Delivery, publishing *amqp.Publishing) error {
+        if _, ok := delivery.Headers[envelope.HeaderAlgorithm]; ok {
+            return ErrEncryptedMessage
+        }
+        if event.IsEnvelope(publishing.Body) {
+            return nil
+        }
+        created := delivery.Timestamp
+        if created.IsZero() {
+            created = time.Now()
+        }
+        tenantID, _ := delivery.Headers[tenant.MessageHeader].(string)
+        wrapped, err := event.Wrap(eventType, publishing.Body, created, tenantID)
+        if err != nil {
+            return err
+        }
+        if publishing.Body, err = json.Marshal(wrapped); err != nil {
+            return err
+        }
+        publishing.Type = eventType
+        return nil
+    }
+}
+
+// ReplayTransform adds the replay metadata to the messages replayed from the queue, the replay count of a message
+// replayed before is incremented
+func ReplayTransform(queue string) Transform {
+    return func(delivery *amqp.Delivery, publishing *amqp.Publishing) error {
+        // the headers are shared with the delivery until they are copied
+        headers := make(amqp.Table, len(publishing.Headers)+3)
+        for key, value := range publishing.Headers {
+            headers[key] = value
+        }
+        headers[HeaderReplayCount] = ReplayCount(delivery.Headers) + 1
+        headers[HeaderReplayedFrom] = queue
+        headers[HeaderReplayedAt] = time.Now().UTC().Format(time.RFC3339)
+        publishing.Headers = headers
+        return nil
+    }
+}
+
+// ReplayCount returns how many times the message was replayed, 0 when it never was
+func ReplayCount(headers amqp.Table) int64 {
+    switch count := headers[HeaderReplayCount].(type) {
+    case int64:
+        return count
+    case int32:
+        return int64(count)
+    case int:
+        return int64(count)
+    }
+    return 0
+}
+
+// SetFieldsTransform sets the fields of the JSON object bodies, e.g. to fix the messages a schema bug rejected. The
+// other fields are left as they are.
+func SetFieldsTransform(fields map[string]any) Transform {
+    return func(delivery *amqp.Delivery, publishing *amqp.Publishing) error {
+        if _, ok := delivery.Headers[envelope.HeaderAlgorithm]; ok {
+            return ErrEncryptedMessage
+        }
+        var object map[string]json.RawMessage
+        if err := json.Unmarshal(publishing.Body, &object); err != nil || object == nil {
+            return ErrNotJSONObject
+        }
+        for field, value := range fields {
+            encoded, err := json.Marshal(value)
+            if err != nil {
+                return err
+            }
+            object[field] = encoded
+        }
+        body, err := json.Marshal(object)
+        if err != nil {
+            return err
+        }
+        publishing.Body = body
+        return nil
+    }
+}
+
+// Options of a migration, the messages of From are republished to the exchange To with their routing key or
+// RoutingKey when it is set
+type Options struct {
+    From       string
+    To         string
+    RoutingKey string
+    Transforms []Transform
+    // Rate is the number of messages migrated per second at most, 0 doesn't limit it
+    Rate float64
+    // Limit is the number of messages migrated at most, 0 drains the queue
+    Limit int
+    // OnProgress is called every ProgressInterval and once the migration stops
+    OnProgress       func(progress Progress)
+    ProgressInterval time.Duration
+}
+
+// Progress of a migration, Remaining is the number of messages left in the queue when the last one was taken
+type Progress struct {
+    Migrated  int
+    Remaining int
+    Elapsed   time.Duration
+}
+
+// Migrate drains the queue into the exchange. A message is only acknowledged once its copy was confirmed by the
+// broker, so nothing is lost when the migration stops. The message that failed to be transformed or published is
+// requeued and the migration stops with its error, after fixing the cause it can be run again.
+func Migrate(ctx context.Context, broker Broker, opts Options) (progress Progress, err error) {
+    if opts.From == "" {
+        return progress, ErrSourceMissing
+    }
+    if opts.Rate < 0 {
+        return progress, ErrInvalidMigrateRate
+    }
+    started := time.Now()
+    reported := started
+    report := func() {
+        progress.Elapsed = time.Since(started)
+        if opts.OnProgress != nil {
+            opts.OnProgress(progress)
+        }
+        reported = time.Now()
+    }
+    defer report()
+
+    var interval time.Duration
+    if opts.Rate > 0 {
+        interval = time.Duration(float64(time.Second) / opts.Rate)
+    }
+    next := started
+    for opts.Limit == 0 || progress.Migrated < opts.Limit {
+        if err := pace(ctx, next); err != nil {
+            return progress, err
+        }
+
+        delivery, ok, err := broker.Get(opts.From)
+        if err != nil {
+            return progress, err
+        }
+        if !ok {
+            progress.Remaining = 0
+            return progress, nil
+        }
+        if err = migrate(ctx, broker, opts, &delivery); err != nil {
+            if nackErr := delivery.Nack(false, true); nackErr != nil {
+                err = errors.Join(err, nackErr)
+            }
+            return progress, err
+        }
+        if err = delivery.Ack(false); err != nil {
+            return progress, err
+        }
+        progress.Migrated++
+        progress.Remaining = int(delivery.MessageCount)
+        next = next.Add(interval)
+        if opts.ProgressInterval > 0 && time.Since(reported) >= opts.ProgressInterval {
+            report()
+        }
+    }
+    return progress, nil
+}
+
+// pace waits until next, the time the next message is due at the rate of the migration
+func pace(ctx context.Context, next time.Time) error {
+    if wait := time.Until(next); wait > 0 {
+        timer := time.NewTimer(wait)
+        defer timer.Stop()
+        select {
+        case <-ctx.Done():
+            return ctx.Err()
+        case <-timer.C:
+        }
+    }
+    return ctx.Err()
+}
+
+func migrate(ctx context.Context, broker Broker, opts Options, delivery *amqp.Delivery) error {
+    publishing, err := republishing(opts, delivery)
+    if err != nil {
+        return err
+    }
+    key := opts.RoutingKey
+    if key == "" {
+        key = delivery.RoutingKey
+    }
+    return broker.Publish(ctx, opts.To, key, publishing)
+}
+
+// republishing copies the delivery into the publishing of its copy and applies the transforms
+func republishing(opts Options, delivery *amqp.Delivery) (amqp.Publishing, error) {
+    publishing := amqp.Publishing{
+        Headers:         delivery.Headers,
+        ContentType:     delivery.ContentType,
+        ContentEncoding: delivery.ContentEncoding,
+        DeliveryMode:    amqp.Persistent,
+        Priority:        delivery.Priority,
+        CorrelationId:   delivery.CorrelationId,
+        ReplyTo:         delivery.ReplyTo,
+        Expiration:      delivery.Expiration,
+        MessageId:       delivery.MessageId,
+        Timestamp:       delivery.Timestamp,
+        Type:            delivery.Type,
+        UserId:          delivery.UserId,
+        AppId:           delivery.AppId,
+        Body:            delivery.Body,
+    }
+    for _, transform := range opts.Transforms {
+        if err := transform(delivery, &publishing); err != nil {
+            return publishing, fmt.Errorf("transform message %d: %w", delivery.DeliveryTag, err)
+        }
+    }
+    return publishing, nil
+}
+
+// DryRun plans the migration without migrating anything. It takes up to plan.SampleSize messages of the queue to
+// sample their ids and run the transforms on them, then requeues them, they are marked redelivered. The estimate
+// assumes confirmedRate without a rate.
+func DryRun(ctx context.Context, broker Broker, opts Options) (p *plan.Plan, err error) {
+    if opts.From == "" {
+        return nil, ErrSourceMissing
+    }
+    if opts.Rate < 0 {
+        return nil, ErrInvalidMigrateRate
+    }
+    var sampled []amqp.Delivery
+    defer func() {
+        for _, delivery := range sampled {
+            if nackErr := delivery.Nack(false, true); nackErr != nil {
+                err = errors.Join(err, nackErr)
+            }
+        }
+    }()
+
+    var queued int64
+    ids := []string{}
+    var warnings []string
+    for len(sampled) < plan.SampleSize {
+        if err := ctx.Err(); err != nil {
+            return nil, err
+        }
+        delivery, ok, err := broker.Get(opts.From)
+        if err != nil {
+            return nil, err
+        }
+        if !ok {
+            break
+        }
+        sampled = append(sampled, delivery)
+        if len(sampled) == 1 {
+            // the count excludes the message taken
+            queued = int64(delivery.MessageCount) + 1
+        }
+        id := delivery.MessageId
+        if id == "" {
+            id = fmt.Sprintf("#%d", len(sampled))
+        }
+        ids = append(ids, id)
+        if _, err = republishing(opts, &delivery); err != nil {
+            warnings = append(warnings, fmt.Sprintf("message %s would stop the migration: %v", id, err))
+        }
+    }
+
+    affected := queued
+    if opts.Limit > 0 {
+        affected = min(affected, int64(opts.Limit))
+    }
+    rate := opts.Rate
+    if rate == 0 {
+        rate = confirmedRate
+    }
+    p = plan.New(Operation, affected, rate)
+    p.SampleIDs = ids
+    p.Scope = map[string]string{"from": opts.From, "to": opts.To, "routing_key": opts.RoutingKey}
+    p.Warnings = warnings
+    return p, nil
+}
+
+// ChannelBroker is a Broker on an AMQP channel in confirm mode. Messages are published mandatory, so a message
+// the destination exchange can't route is an error instead of being dropped.
+type ChannelBroker struct {
+    channel *amqp.Channel
+    returns chan amqp.Return
+}
+
+func NewChannelBroker(channel *amqp.Channel) (*ChannelBroker, error) {
+    if err := channel.Confirm(false); err != nil {
+        return nil, err
+    }
+    return &ChannelBroker{channel: channel, returns: channel.NotifyReturn(make(chan amqp.Return, 1))}, nil
+}
+
+func (b *ChannelBroker) Get(queue string) (amqp.Delivery, bool, error) {
+    return b.channel.Get(queue, false)
+}
+
+func (b *ChannelBroker) Publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+    confirmation, err := b.channel.PublishWithDeferredConfirmWithContext(ctx, exchange, key, true, false, msg)
+    if err != nil {
+        return err
+    }
+    acked, err := confirmation.WaitContext(ctx)
+    if err != nil {
+        return err
+    }
+    // an unroutable mandatory message is returned before it is confirmed
+    select {
+    case returned := <-b.returns:
+        return fmt.Errorf("%w: %s", ErrUnroutable, returned.ReplyText)
+    default:
+    }
+    if !acked {
+        return ErrPublishNacked
+    }
+    return nil
+}
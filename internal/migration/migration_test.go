@@ -0,0 +1,227 @@
+package migration
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+
+    amqp "github.com/rabbitmq/amqp091-go"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/event"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/plan"
+)
+
+// acknowledger records the acknowledgements of the deliveries
+type acknowledger struct {
+    acked    []uint64
+    requeued []uint64
+}
+
+func (a *acknowledger) Ack(tag uint64, _ bool) error {
+    a.acked = append(a.acked, tag)
+    return nil
+}
+
+func (a *acknowledger) Nack(tag uint64, _ bool, requeue bool) error {
+    if requeue {
+        a.requeued = append(a.requeued, tag)
+    }
+    return nil
+}
+
+func (a *acknowledger) Reject(tag uint64, requeue bool) error {
+    return a.Nack(tag, false, requeue)
+}
+
+type publishedMessage struct {
+    exchange, key string
+    msg           amqp.Publishing
+}
+
+// memoryBroker hands out the bodies as deliveries and fails publishing the bodies in fail
+type memoryBroker struct {
+    ack       *acknowledger
+    bodies    []string
+    fail      string
+    published []publishedMessage
+}
+
+func (b *memoryBroker) Get(queue string) (amqp.Delivery, bool, error) {
+    if len(b.bodies) == 0 {
+        return amqp.Delivery{}, false, nil
+    }
+    body := b.bodies[0]
+    b.bodies = b.bodies[1:]
+    return amqp.Delivery{
+        Acknowledger: b.ack,
+        DeliveryTag:  uint64(len(b.published) + 1),
+        RoutingKey:   queue,
+        MessageCount: uint32(len(b.bodies)),
+        Body:         []byte(body),
+    }, true, nil
+}
+
+func (b *memoryBroker) Publish(_ context.Context, exchange, key string, msg amqp.Publishing) error {
+    if string(msg.Body) == b.fail {
+        return ErrPublishNacked
+    }
+    b.published = append(b.published, publishedMessage{exchange: exchange, key: key, msg: msg})
+    return nil
+}
+
+func TestMigrate(t *testing.T) {
+    broker := &memoryBroker{ack: &acknowledger{}, bodies: []string{`{"id":"1"}`, `{"id":"2"}`, `{"id":"3"}`}}
+    var reports []Progress
+    progress, err := Migrate(
+        context.Background(),
+        broker,
+        Options{
+            From:       "tracking",
+            To:         "vehicle-events",
+            Transforms: []Transform{Transforms["envelope"]},
+            OnProgress: func(progress Progress) {
+                reports = append(reports, progress)
+            },
+        },
+    )
+    if err != nil {
+        t.Fatal(err)
+    }
+    if progress.Migrated != 3 || progress.Remaining != 0 {
+        t.Errorf("expected 3 migrated messages and none remaining, got %+v", progress)
+    }
+    if len(reports) != 1 || reports[0].Migrated != 3 {
+        t.Errorf("expected the final progress to be reported, got %+v", reports)
+    }
+    if len(broker.ack.acked) != 3 {
+        t.Errorf("expected every message to be acknowledged, got %v", broker.ack.acked)
+    }
+    for _, published := range broker.published {
+        if published.exchange != "vehicle-events" || published.key != "tracking" {
+            t.Errorf("expected the message to keep its routing key, got %s %s", published.exchange, published.key)
+        }
+        if !event.IsEnvelope(published.msg.Body) || published.msg.DeliveryMode != amqp.Persistent {
+            t.Errorf("expected a persistent envelope, got %s", published.msg.Body)
+        }
+    }
+
+    // envelopes aren't wrapped again when a migration is run twice
+    wrapped := string(broker.published[0].msg.Body)
+    broker.bodies = []string{wrapped}
+    if _, err = Migrate(
+        context.Background(),
+        broker,
+        Options{From: "tracking", To: "vehicle-events", Transforms: []Transform{Transforms["envelope"]}},
+    ); err != nil {
+        t.Fatal(err)
+    }
+    if got := string(broker.published[3].msg.Body); got != wrapped {
+        t.Errorf("expected the envelope to be republished as it is, got %s", got)
+    }
+}
+
+func TestMigrateStopsAndRequeuesOnFailure(t *testing.T) {
+    broker := &memoryBroker{
+        ack:    &acknowledger{},
+        bodies: []string{`{"id":"1"}`, `{"id":"2"}`, `{"id":"3"}`},
+        fail:   `{"id":"2"}`,
+    }
+    progress, err := Migrate(context.Background(), broker, Options{From: "tracking", To: "", RoutingKey: "vehicle"})
+    if !errors.Is(err, ErrPublishNacked) {
+        t.Fatalf("expected the publish error, got %v", err)
+    }
+    if progress.Migrated != 1 || len(broker.ack.acked) != 1 || len(broker.ack.requeued) != 1 {
+        t.Errorf("expected one migrated and one requeued message, got %+v and %+v", progress, broker.ack)
+    }
+    if len(broker.bodies) != 1 || broker.published[0].key != "vehicle" {
+        t.Errorf("expected the migration to stop and use the routing key, got %+v", broker.published)
+    }
+}
+
+func TestMigrateLimitAndRate(t *testing.T) {
+    broker := &memoryBroker{ack: &acknowledger{}, bodies: []string{"1", "2", "3", "4"}}
+    started := time.Now()
+    progress, err := Migrate(
+        context.Background(),
+        broker,
+        Options{From: "tracking", To: "vehicle-events", Limit: 3, Rate: 50},
+    )
+    if err != nil {
+        t.Fatal(err)
+    }
+    if progress.Migrated != 3 || progress.Remaining != 1 {
+        t.Errorf("expected the limit to stop the migration, got %+v", progress)
+    }
+    // 3 messages at 50 per second are 2 intervals of 20ms apart
+    if elapsed := time.Since(started); elapsed < 40*time.Millisecond {
+        t.Errorf("expected the rate to be limited, took %v", elapsed)
+    }
+}
+
+func TestDryRun(t *testing.T) {
+    broker := &memoryBroker{ack: &acknowledger{}, bodies: make([]string, 25)}
+    for i := range broker.bodies {
+        broker.bodies[i] = `{"id":"1"}`
+    }
+    broker.bodies[3] = "not json"
+    p, err := DryRun(
+        context.Background(),
+        broker,
+        Options{From: "tracking", To: "vehicle-events", Limit: 20, Transforms: []Transform{Transforms["envelope"]}},
+    )
+    if err != nil {
+        t.Fatal(err)
+    }
+    if p.Affected != 20 || len(p.SampleIDs) != plan.SampleSize || p.SampleIDs[0] != "#1" {
+        t.Errorf("expected 20 messages limited and sampled, got %+v", p)
+    }
+    if p.Rate != confirmedRate || p.EstimatedSeconds != 1 {
+        t.Errorf("expected the confirmed rate, got %+v", p)
+    }
+    if len(p.Warnings) != 1 {
+        t.Errorf("expected the failing transform to be warned about, got %v", p.Warnings)
+    }
+    if len(broker.published) != 0 || len(broker.ack.acked) != 0 || len(broker.ack.requeued) != plan.SampleSize {
+        t.Errorf("expected the samples to be requeued, got %+v", broker.ack)
+    }
+}
+
+func TestReplay(t *testing.T) {
+    broker := &memoryBroker{ack: &acknowledger{}, bodies: []string{`{"vehicle_id":"1","mileage":"12"}`, `[]`}}
+    _, err := Migrate(
+        context.Background(),
+        broker,
+        Options{
+            From:       "tracking.dlq",
+            RoutingKey: "tracking",
+            Transforms: []Transform{
+                SetFieldsTransform(map[string]any{"mileage": 12.0}),
+                ReplayTransform("tracking.dlq"),
+            },
+        },
+    )
+    // a message that can't be fixed stops the replay
+    if !errors.Is(err, ErrNotJSONObject) || len(broker.ack.requeued) != 1 {
+        t.Fatalf("expected the array to be requeued, got %v", err)
+    }
+    if len(broker.published) != 1 {
+        t.Fatalf("expected one replayed message, got %+v", broker.published)
+    }
+    replayed := broker.published[0].msg
+    if string(replayed.Body) != `{"mileage":12,"vehicle_id":"1"}` {
+        t.Errorf("expected the fixed message, got %s", replayed.Body)
+    }
+    if ReplayCount(replayed.Headers) != 1 || replayed.Headers[HeaderReplayedFrom] != "tracking.dlq" {
+        t.Errorf("expected the replay metadata, got %v", replayed.Headers)
+    }
+
+    // the count of a message replayed again is incremented
+    delivery := &amqp.Delivery{Headers: amqp.Table{HeaderReplayCount: int32(2)}}
+    publishing := &amqp.Publishing{Headers: delivery.Headers}
+    if err = ReplayTransform("tracking.dlq")(delivery, publishing); err != nil {
+        t.Fatal(err)
+    }
+    if ReplayCount(publishing.Headers) != 3 || ReplayCount(delivery.Headers) != 2 {
+        t.Errorf("expected the count of the copy to be incremented, got %v", publishing.Headers)
+    }
+}
@@ -0,0 +1,200 @@
+package notifier
+
+import (
+    "bytes"
+    "context"
+    "crypto/tls"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "net/smtp"
+    "net/url"
+    "strings"
+    "time"
+
+    "github.com/goccy/go-json"
+)
+
+const (
+    ChannelEmail = "email"
+    ChannelSMS   = "sms"
+    ChannelSlack = "slack"
+)
+
+var ErrRejected = errors.New("notification rejected")
+
+// Message is a notification to a recipient, an email address, a phone number or a Slack incoming webhook url
+// depending on the channel. Subject is only used by emails.
+type Message struct {
+    To      string
+    Subject string
+    Text    string
+}
+
+// Sender sends messages over a channel
+type Sender interface {
+    Send(ctx context.Context, message *Message) error
+}
+
+// SMTPSender sends emails through an SMTP server, upgrading the connection with STARTTLS when the server offers it
+// and authenticating with PLAIN when a username is set
+type SMTPSender struct {
+    addr     string
+    host     string
+    username string
+    password string
+    from     string
+}
+
+func NewSMTPSender(host string, port int, username, password, from string) *SMTPSender {
+    return &SMTPSender{
+        addr:     net.JoinHostPort(host, fmt.Sprint(port)),
+        host:     host,
+        username: username,
+        password: password,
+        from:     from,
+    }
+}
+
+func (s *SMTPSender) Send(ctx context.Context, message *Message) error {
+    var dialer net.Dialer
+    conn, err := dialer.DialContext(ctx, "tcp", s.addr)
+    if err != nil {
+        return err
+    }
+    // the whole conversation is bound by the deadline of ctx, net/smtp doesn't take a context
+    if deadline, ok := ctx.Deadline(); ok {
+        _ = conn.SetDeadline(deadline)
+    }
+    client, err := smtp.NewClient(conn, s.host)
+    if err != nil {
+        _ = conn.Close()
+        return err
+    }
+    defer func() {
+        _ = client.Close()
+    }()
+    if ok, _ := client.Extension("STARTTLS"); ok {
+        if err = client.StartTLS(&tls.Config{ServerName: s.host, MinVersion: tls.VersionTLS12}); err != nil {
+            return err
+        }
+    }
+    if s.username != "" {
+        if err = client.Auth(smtp.PlainAuth("", s.username, s.password, s.host)); err != nil {
+            return err
+        }
+    }
+    if err = client.Mail(s.from); err != nil {
+        return err
+    }
+    if err = client.Rcpt(message.To); err != nil {
+        return err
+    }
+    body, err := client.Data()
+    if err != nil {
+        return err
+    }
+    if _, err = io.WriteString(body, s.email(message)); err != nil {
+        return err
+    }
+    if err = body.Close(); err != nil {
+        return err
+    }
+    return client.Quit()
+}
+
+// email formats the message as a plain text email
+func (s *SMTPSender) email(message *Message) string {
+    var b strings.Builder
+    b.WriteString("From: " + s.from + "\r\n")
+    b.WriteString("To: " + message.To + "\r\n")
+    // a line break in the subject would start another header
+    b.WriteString("Subject: " + strings.NewReplacer("\r", " ", "\n", " ").Replace(message.Subject) + "\r\n")
+    b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+    b.WriteString("MIME-Version: 1.0\r\n")
+    b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+    b.WriteString("\r\n")
+    b.WriteString(strings.ReplaceAll(strings.ReplaceAll(message.Text, "\r\n", "\n"), "\n", "\r\n"))
+    b.WriteString("\r\n")
+    return b.String()
+}
+
+// TwilioSender sends text messages with the Messages API of Twilio
+type TwilioSender struct {
+    client     *http.Client
+    baseURL    string
+    accountSID string
+    authToken  string
+    from       string
+}
+
+func NewTwilioSender(client *http.Client, accountSID, authToken, from string) *TwilioSender {
+    return &TwilioSender{
+        client:     client,
+        baseURL:    "https://api.twilio.com",
+        accountSID: accountSID,
+        authToken:  authToken,
+        from:       from,
+    }
+}
+
+// WithBaseURL sends the messages to another API, like a test server
+func (s *TwilioSender) WithBaseURL(baseURL string) *TwilioSender {
+    s.baseURL = strings.TrimSuffix(baseURL, "/")
+    return s
+}
+
+func (s *TwilioSender) Send(ctx context.Context, message *Message) error {
+    form := url.Values{"To": {message.To}, "From": {s.from}, "Body": {message.Text}}
+    req, err := http.NewRequestWithContext(
+        ctx,
+        http.MethodPost,
+        s.baseURL+"/2010-04-01/Accounts/"+url.PathEscape(s.accountSID)+"/Messages.json",
+        strings.NewReader(form.Encode()),
+    )
+    if err != nil {
+        return err
+    }
+    req.SetBasicAuth(s.accountSID, s.authToken)
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    return do(s.client, req)
+}
+
+// SlackSender posts messages to Slack incoming webhooks, the recipient is the url of the webhook
+type SlackSender struct {
+    client *http.Client
+}
+
+func NewSlackSender(client *http.Client) *SlackSender {
+    return &SlackSender{client: client}
+}
+
+func (s *SlackSender) Send(ctx context.Context, message *Message) error {
+    body, err := json.Marshal(map[string]string{"text": message.Text})
+    if err != nil {
+        return err
+    }
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, message.To, bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    return do(s.client, req)
+}
+
+// do sends the request, a non 2xx response is an error
+func do(client *http.Client, req *http.Request) error {
+    res, err := client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer res.Body.Close()
+    // the body is drained so the connection is reused, the response itself isn't used
+    _, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+    if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusMultipleChoices {
+        return fmt.Errorf("%w with status %s", ErrRejected, res.Status)
+    }
+    return nil
+}
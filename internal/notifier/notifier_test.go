@@ -0,0 +1,84 @@
+package notifier
+
+import (
+    "context"
+    "errors"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "strings"
+    "testing"
+
+    "github.com/goccy/go-json"
+)
+
+func TestTwilioSender_Send(t *testing.T) {
+    var path, user, password string
+    var form url.Values
+    server := httptest.NewServer(
+        http.HandlerFunc(
+            func(w http.ResponseWriter, r *http.Request) {
+                path = r.URL.Path
+                user, password, _ = r.BasicAuth()
+                _ = r.ParseForm()
+                form = r.PostForm
+                w.WriteHeader(http.StatusCreated)
+            },
+        ),
+    )
+    defer server.Close()
+
+    sender := NewTwilioSender(server.Client(), "AC123", "secret", "+15550000000").WithBaseURL(server.URL)
+    if err := sender.Send(context.Background(), &Message{To: "+15551234567", Text: "Freezer warm"}); err != nil {
+        t.Fatal(err)
+    }
+    if path != "/2010-04-01/Accounts/AC123/Messages.json" || user != "AC123" || password != "secret" {
+        t.Errorf("Should post to the messages of the account, got %s as %s", path, user)
+    }
+    if form.Get("To") != "+15551234567" || form.Get("From") != "+15550000000" || form.Get("Body") != "Freezer warm" {
+        t.Errorf("Should send the message, got %v", form)
+    }
+}
+
+func TestSlackSender_Send(t *testing.T) {
+    var body map[string]string
+    status := http.StatusOK
+    server := httptest.NewServer(
+        http.HandlerFunc(
+            func(w http.ResponseWriter, r *http.Request) {
+                data, _ := io.ReadAll(r.Body)
+                _ = json.Unmarshal(data, &body)
+                w.WriteHeader(status)
+            },
+        ),
+    )
+    defer server.Close()
+
+    sender := NewSlackSender(server.Client())
+    if err := sender.Send(context.Background(), &Message{To: server.URL, Text: "Vehicle entered Depot"}); err != nil {
+        t.Fatal(err)
+    }
+    if body["text"] != "Vehicle entered Depot" {
+        t.Errorf("Should post the text, got %v", body)
+    }
+
+    status = http.StatusNotFound
+    if err := sender.Send(context.Background(), &Message{To: server.URL, Text: "x"}); !errors.Is(err, ErrRejected) {
+        t.Errorf("Should fail with ErrRejected, got %v", err)
+    }
+}
+
+func TestSMTPSender_email(t *testing.T) {
+    sender := NewSMTPSender("smtp.example.com", 587, "", "", "alerts@example.com")
+    email := sender.email(&Message{To: "ops@example.com", Subject: "Alert\r\nBcc: x@example.com", Text: "a\nb"})
+    if !strings.Contains(email, "Subject: Alert  Bcc: x@example.com\r\n") {
+        t.Errorf("Should keep the subject on one line, got %q", email)
+    }
+    if !strings.HasSuffix(email, "\r\n\r\na\r\nb\r\n") {
+        t.Errorf("Should end lines of the text with CRLF, got %q", email)
+    }
+    if sender.addr != "smtp.example.com:587" {
+        t.Errorf("Should dial the host and port, got %s", sender.addr)
+    }
+}
@@ -0,0 +1,392 @@
+package openapi
+
+import (
+    "net/http"
+    "reflect"
+    "slices"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const Version = "3.0.3"
+
+// Document is an OpenAPI 3 document, only the parts the service describes are modeled
+type Document struct {
+    OpenAPI    string                           `json:"openapi"`
+    Info       Info                             `json:"info"`
+    Paths      map[string]map[string]*Operation `json:"paths"`
+    Components Components                       `json:"components"`
+}
+
+type Info struct {
+    Title       string `json:"title"`
+    Version     string `json:"version"`
+    Description string `json:"description,omitempty"`
+}
+
+type Components struct {
+    Schemas map[string]*Schema `json:"schemas"`
+}
+
+type Operation struct {
+    Summary     string               `json:"summary,omitempty"`
+    Description string               `json:"description,omitempty"`
+    Tags        []string             `json:"tags,omitempty"`
+    Parameters  []*Parameter         `json:"parameters,omitempty"`
+    RequestBody *RequestBody         `json:"requestBody,omitempty"`
+    Responses   map[string]*Response `json:"responses"`
+    Deprecated  bool                 `json:"deprecated,omitempty"`
+}
+
+type Parameter struct {
+    Name        string  `json:"name"`
+    In          string  `json:"in"`
+    Description string  `json:"description,omitempty"`
+    Required    bool    `json:"required,omitempty"`
+    Deprecated  bool    `json:"deprecated,omitempty"`
+    Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+    Required bool                  `json:"required"`
+    Content  map[string]*MediaType `json:"content"`
+}
+
+type MediaType struct {
+    Schema *Schema `json:"schema"`
+}
+
+type Response struct {
+    Description string                `json:"description"`
+    Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+type Schema struct {
+    Ref                  string             `json:"$ref,omitempty"`
+    Type                 string             `json:"type,omitempty"`
+    Format               string             `json:"format,omitempty"`
+    Description          string             `json:"description,omitempty"`
+    Items                *Schema            `json:"items,omitempty"`
+    Properties           map[string]*Schema `json:"properties,omitempty"`
+    AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+    Required             []string           `json:"required,omitempty"`
+    AllOf                []*Schema          `json:"allOf,omitempty"`
+}
+
+// Route describes an endpoint, the schemas of Query, Body and Response are generated from their types: the json
+// tags name the fields, the validate tags mark the required ones and the doc tags describe them
+type Route struct {
+    Method      string
+    Path        string
+    Tag         string
+    Summary     string
+    Description string
+    // Query is a struct whose fields are the query parameters, Params adds the parameters read by hand
+    Query  any
+    Params []*Parameter
+    Body   any
+    // Response is the data of the success envelope, nil for endpoints without data
+    Response any
+    // Status is the success status, 200 when it isn't set
+    Status int
+    // Download is the content types of a file download, the response isn't wrapped in the envelope then
+    Download []string
+    // Envelope is the struct of the success envelope when it isn't the default one, its data is the Response
+    Envelope any
+    Admin    bool
+}
+
+// Generator generates the document of the routes, named types are shared as components
+type Generator struct {
+    document   *Document
+    names      map[reflect.Type]string
+    pagination *Schema
+}
+
+func NewGenerator(info Info) *Generator {
+    return &Generator{
+        document: &Document{
+            OpenAPI: Version,
+            Info:    info,
+            Paths:   map[string]map[string]*Operation{},
+            Components: Components{
+                Schemas: map[string]*Schema{
+                    "Error": {
+                        Type:        "object",
+                        Description: "The envelope of errors",
+                        Properties:  map[string]*Schema{"message": {Type: "string"}},
+                    },
+                },
+            },
+        },
+        names: map[reflect.Type]string{},
+    }
+}
+
+func (g *Generator) Add(routes ...Route) {
+    for _, route := range routes {
+        operations, ok := g.document.Paths[route.Path]
+        if !ok {
+            operations = map[string]*Operation{}
+            g.document.Paths[route.Path] = operations
+        }
+        operations[strings.ToLower(route.Method)] = g.operation(route)
+    }
+}
+
+// Deprecate marks an operation, or its parameter when param is set, as deprecated, an empty method marks every
+// method of the path
+func (g *Generator) Deprecate(method, path, param string) {
+    for operationMethod, operation := range g.document.Paths[path] {
+        if method != "" && !strings.EqualFold(method, operationMethod) {
+            continue
+        }
+        if param == "" {
+            operation.Deprecated = true
+            continue
+        }
+        for _, parameter := range operation.Parameters {
+            if parameter.Name == param {
+                parameter.Deprecated = true
+            }
+        }
+    }
+}
+
+// Error sets the schema of the error envelope, shared by every operation, to the one of the struct
+func (g *Generator) Error(envelope any) {
+    t := indirect(reflect.TypeOf(envelope))
+    g.names[t] = "Error"
+    schema := g.structSchema(t)
+    schema.Description = g.document.Components.Schemas["Error"].Description
+    g.document.Components.Schemas["Error"] = schema
+}
+
+// Pagination sets the schema of the pagination of the struct, the envelope of the operations with a page parameter
+// has it
+func (g *Generator) Pagination(pagination any) {
+    g.pagination = g.schema(reflect.TypeOf(pagination))
+}
+
+func (g *Generator) Document() *Document {
+    return g.document
+}
+
+func (g *Generator) operation(route Route) *Operation {
+    operation := &Operation{
+        Summary:     route.Summary,
+        Description: route.Description,
+        Responses:   map[string]*Response{},
+    }
+    if route.Tag != "" {
+        operation.Tags = []string{route.Tag}
+    }
+    if route.Admin {
+        operation.Description = strings.TrimSpace(operation.Description + " Admin only when access control is enabled.")
+    }
+    if route.Query != nil {
+        operation.Parameters = g.queryParameters(reflect.TypeOf(route.Query))
+    }
+    operation.Parameters = append(operation.Parameters, route.Params...)
+    if route.Body != nil {
+        operation.RequestBody = &RequestBody{
+            Required: true,
+            Content:  map[string]*MediaType{"application/json": {Schema: g.schema(reflect.TypeOf(route.Body))}},
+        }
+    }
+
+    status := route.Status
+    if status == 0 {
+        status = http.StatusOK
+    }
+    success := &Response{Description: http.StatusText(status)}
+    switch {
+    case len(route.Download) > 0:
+        success.Content = map[string]*MediaType{}
+        for _, contentType := range route.Download {
+            success.Content[contentType] = &MediaType{Schema: &Schema{Type: "string", Format: "binary"}}
+        }
+    case route.Envelope != nil:
+        envelope := g.structSchema(indirect(reflect.TypeOf(route.Envelope)))
+        if route.Response != nil {
+            envelope.Properties["data"] = g.schema(reflect.TypeOf(route.Response))
+        }
+        success.Content = map[string]*MediaType{"application/json": {Schema: envelope}}
+    default:
+        envelope := &Schema{
+            Type:       "object",
+            Properties: map[string]*Schema{"message": {Type: "string"}},
+        }
+        if route.Response != nil {
+            envelope.Properties["data"] = g.schema(reflect.TypeOf(route.Response))
+        }
+        if g.pagination != nil && slices.ContainsFunc(
+            operation.Parameters,
+            func(parameter *Parameter) bool { return parameter.Name == "page" },
+        ) {
+            envelope.Properties["pagination"] = g.pagination
+        }
+        success.Content = map[string]*MediaType{"application/json": {Schema: envelope}}
+    }
+    operation.Responses[strconv.Itoa(status)] = success
+    operation.Responses["default"] = &Response{
+        Description: "Error",
+        Content:     map[string]*MediaType{"application/json": {Schema: &Schema{Ref: "#/components/schemas/Error"}}},
+    }
+    return operation
+}
+
+// queryParameters returns the fields of the struct as query parameters
+func (g *Generator) queryParameters(t reflect.Type) []*Parameter {
+    t = indirect(t)
+    var parameters []*Parameter
+    for _, field := range fields(t) {
+        parameters = append(
+            parameters, &Parameter{
+                Name:        field.name,
+                In:          "query",
+                Description: field.doc,
+                Required:    field.required,
+                Schema:      g.schema(field.typ),
+            },
+        )
+    }
+    return parameters
+}
+
+func (g *Generator) schema(t reflect.Type) *Schema {
+    t = indirect(t)
+    switch t {
+    case reflect.TypeOf(time.Time{}), reflect.TypeOf(timestamp.Time{}):
+        return &Schema{Type: "string", Format: "date-time"}
+    case reflect.TypeOf(primitive.ObjectID{}):
+        return &Schema{Type: "string", Description: "24 hex digits object id"}
+    case reflect.TypeOf(json.RawMessage{}):
+        return &Schema{}
+    }
+
+    switch t.Kind() {
+    case reflect.Bool:
+        return &Schema{Type: "boolean"}
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+        reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+        return &Schema{Type: "integer"}
+    case reflect.Float32, reflect.Float64:
+        return &Schema{Type: "number"}
+    case reflect.String:
+        return &Schema{Type: "string"}
+    case reflect.Slice, reflect.Array:
+        if t.Elem().Kind() == reflect.Uint8 {
+            return &Schema{Type: "string", Format: "byte"}
+        }
+        return &Schema{Type: "array", Items: g.schema(t.Elem())}
+    case reflect.Map:
+        return &Schema{Type: "object", AdditionalProperties: g.schema(t.Elem())}
+    case reflect.Struct:
+        if t.Name() == "" {
+            return g.structSchema(t)
+        }
+        return &Schema{Ref: "#/components/schemas/" + g.component(t)}
+    default:
+        return &Schema{}
+    }
+}
+
+// component returns the name of the component of the named struct, registering it on first use
+func (g *Generator) component(t reflect.Type) string {
+    if name, ok := g.names[t]; ok {
+        return name
+    }
+    name := t.Name()
+    if _, taken := g.document.Components.Schemas[name]; taken {
+        name = pascal(t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:]) + name
+    }
+    g.names[t] = name
+    // the placeholder stops the recursion of self referencing types
+    g.document.Components.Schemas[name] = &Schema{}
+    *g.document.Components.Schemas[name] = *g.structSchema(t)
+    return name
+}
+
+func (g *Generator) structSchema(t reflect.Type) *Schema {
+    schema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+    for _, field := range fields(t) {
+        property := g.schema(field.typ)
+        if field.doc != "" {
+            if property.Ref != "" {
+                // siblings of $ref are ignored in OpenAPI 3.0
+                property = &Schema{Description: field.doc, AllOf: []*Schema{property}}
+            } else {
+                property.Description = field.doc
+            }
+        }
+        schema.Properties[field.name] = property
+        if field.required {
+            schema.Required = append(schema.Required, field.name)
+        }
+    }
+    return schema
+}
+
+type field struct {
+    name     string
+    doc      string
+    required bool
+    typ      reflect.Type
+}
+
+// fields returns the JSON fields of the struct, the fields of embedded structs without a name are promoted
+func fields(t reflect.Type) []field {
+    var result []field
+    for i := 0; i < t.NumField(); i++ {
+        structField := t.Field(i)
+        tag := structField.Tag.Get("json")
+        if tag == "-" {
+            continue
+        }
+        name, _, _ := strings.Cut(tag, ",")
+        if structField.Anonymous && name == "" && indirect(structField.Type).Kind() == reflect.Struct {
+            for _, promoted := range fields(indirect(structField.Type)) {
+                if !slices.ContainsFunc(result, func(f field) bool { return f.name == promoted.name }) {
+                    result = append(result, promoted)
+                }
+            }
+            continue
+        }
+        if !structField.IsExported() {
+            continue
+        }
+        if name == "" {
+            name = structField.Name
+        }
+        result = slices.DeleteFunc(result, func(f field) bool { return f.name == name })
+        result = append(
+            result, field{
+                name:     name,
+                doc:      structField.Tag.Get("doc"),
+                required: slices.Contains(strings.Split(structField.Tag.Get("validate"), ","), "required"),
+                typ:      structField.Type,
+            },
+        )
+    }
+    return result
+}
+
+func indirect(t reflect.Type) reflect.Type {
+    for t.Kind() == reflect.Pointer {
+        t = t.Elem()
+    }
+    return t
+}
+
+func pascal(s string) string {
+    if s == "" {
+        return s
+    }
+    return strings.ToUpper(s[:1]) + s[1:]
+}
@@ -0,0 +1,153 @@
+package openapi
+
+import (
+    "net/http"
+    "testing"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+)
+
+type base struct {
+    ID        string         `json:"id"`
+    CreatedAt timestamp.Time `json:"created_at"`
+}
+
+type item struct {
+    base
+    Name     string   `json:"name" validate:"required,min=1" doc:"The name of the item"`
+    Tags     []string `json:"tags,omitempty"`
+    Parent   *item    `json:"parent,omitempty"`
+    internal string
+}
+
+type itemFilter struct {
+    Page  int    `json:"page"`
+    Name  string `json:"name"`
+    Order string `json:"order"`
+}
+
+func TestGenerator(t *testing.T) {
+    g := NewGenerator(Info{Title: "test", Version: "v1"})
+    g.Add(
+        Route{Method: http.MethodGet, Path: "/items", Query: itemFilter{}, Response: []*item{}},
+        Route{Method: http.MethodPost, Path: "/items", Body: item{}, Response: item{}, Status: http.StatusCreated},
+    )
+    g.Deprecate(http.MethodGet, "/items", "order")
+    document := g.Document()
+
+    list := document.Paths["/items"]["get"]
+    if len(list.Parameters) != 3 || list.Parameters[0].Name != "page" || list.Parameters[0].Schema.Type != "integer" {
+        t.Fatalf("expected the fields of the filter as query parameters, got %+v", list.Parameters)
+    }
+    if !list.Parameters[2].Deprecated || list.Parameters[1].Deprecated {
+        t.Errorf("expected only order to be deprecated")
+    }
+    data := list.Responses["200"].Content["application/json"].Schema.Properties["data"]
+    if data.Type != "array" || data.Items.Ref != "#/components/schemas/item" {
+        t.Errorf("expected an array of the item component, got %+v", data)
+    }
+
+    create := document.Paths["/items"]["post"]
+    if _, ok := create.Responses["201"]; !ok || create.RequestBody == nil {
+        t.Errorf("expected a request body and a 201 response, got %+v", create)
+    }
+
+    schema := document.Components.Schemas["item"]
+    for _, name := range []string{"id", "created_at", "name", "tags", "parent"} {
+        if _, ok := schema.Properties[name]; !ok {
+            t.Errorf("expected the property %s, got %v", name, schema.Properties)
+        }
+    }
+    if len(schema.Properties) != 5 {
+        t.Errorf("expected the unexported field to be left out, got %v", schema.Properties)
+    }
+    if schema.Properties["created_at"].Format != "date-time" {
+        t.Errorf("expected timestamps to be date-time strings, got %+v", schema.Properties["created_at"])
+    }
+    if len(schema.Required) != 1 || schema.Required[0] != "name" || schema.Properties["name"].Description == "" {
+        t.Errorf("expected the validate and doc tags to be used, got %v", schema.Required)
+    }
+    if schema.Properties["parent"].Ref != "#/components/schemas/item" {
+        t.Errorf("expected the self reference to use the component, got %+v", schema.Properties["parent"])
+    }
+}
+
+type errorEnvelope struct {
+    Code    string   `json:"code"`
+    Message string   `json:"message"`
+    Fields  []string `json:"fields,omitempty"`
+}
+
+func TestGenerator_Error(t *testing.T) {
+    g := NewGenerator(Info{Title: "test", Version: "v1"})
+    g.Error(&errorEnvelope{})
+    g.Add(Route{Method: http.MethodGet, Path: "/items", Response: []*item{}})
+    document := g.Document()
+
+    schema := document.Components.Schemas["Error"]
+    if schema.Properties["code"] == nil || schema.Properties["message"] == nil || schema.Description == "" {
+        t.Fatalf("expected the error envelope to have the fields of the struct, got %+v", schema)
+    }
+    if _, ok := document.Components.Schemas["errorEnvelope"]; ok {
+        t.Errorf("expected the envelope to be registered as the Error component only")
+    }
+    if document.Paths["/items"]["get"].Responses["default"].Content["application/json"].Schema.Ref !=
+        "#/components/schemas/Error" {
+        t.Errorf("expected the operations to refer to the error envelope")
+    }
+}
+
+type page struct {
+    Page  int `json:"page"`
+    Count int `json:"count"`
+}
+
+func TestGenerator_Pagination(t *testing.T) {
+    g := NewGenerator(Info{Title: "test", Version: "v1"})
+    g.Pagination(&page{})
+    g.Add(
+        Route{Method: http.MethodGet, Path: "/items", Query: itemFilter{}, Response: []*item{}},
+        Route{Method: http.MethodGet, Path: "/items/{id}", Response: item{}},
+    )
+    document := g.Document()
+
+    list := document.Paths["/items"]["get"].Responses["200"].Content["application/json"].Schema
+    if list.Properties["pagination"] == nil || list.Properties["pagination"].Ref != "#/components/schemas/page" {
+        t.Errorf("expected the paged list to have the pagination, got %+v", list.Properties["pagination"])
+    }
+    lookup := document.Paths["/items/{id}"]["get"].Responses["200"].Content["application/json"].Schema
+    if _, ok := lookup.Properties["pagination"]; ok {
+        t.Errorf("expected the lookup without a page parameter to have no pagination")
+    }
+}
+
+type cursorEnvelope struct {
+    Data any   `json:"data"`
+    Page *page `json:"page,omitempty"`
+}
+
+func TestGenerator_Envelope(t *testing.T) {
+    g := NewGenerator(Info{Title: "test", Version: "v2"})
+    g.Pagination(&page{})
+    g.Add(
+        Route{
+            Method:   http.MethodGet,
+            Path:     "/items",
+            Query:    itemFilter{},
+            Response: []*item{},
+            Envelope: &cursorEnvelope{},
+        },
+    )
+
+    schema := g.Document().Paths["/items"]["get"].Responses["200"].Content["application/json"].Schema
+    if schema.Properties["data"] == nil || schema.Properties["data"].Items == nil ||
+        schema.Properties["data"].Items.Ref != "#/components/schemas/item" {
+        t.Errorf("expected the data of the envelope to be the response, got %+v", schema.Properties["data"])
+    }
+    if schema.Properties["page"] == nil || schema.Properties["message"] != nil {
+        t.Errorf("expected the properties of the envelope struct, got %+v", schema.Properties)
+    }
+    if _, ok := schema.Properties["pagination"]; ok {
+        t.Errorf("expected the envelope to have no pagination of the default envelope")
+    }
+}
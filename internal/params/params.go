@@ -0,0 +1,175 @@
+package params
+
+import (
+    "errors"
+    "fmt"
+    "net/url"
+    "slices"
+    "strconv"
+    "strings"
+    "time"
+)
+
+var (
+    ErrInvalidQuery = errors.New("invalid query parameters")
+)
+
+// FieldError is an invalid query parameter with the value it was given
+type FieldError struct {
+    Field   string `json:"field"`
+    Value   string `json:"value"`
+    Message string `json:"message"`
+}
+
+// Error lists every invalid parameter of a query, it matches ErrInvalidQuery with errors.Is
+type Error struct {
+    Fields []*FieldError `json:"fields"`
+}
+
+func (e *Error) Error() string {
+    messages := make([]string, 0, len(e.Fields))
+    for _, field := range e.Fields {
+        messages = append(messages, field.Field+" "+field.Message)
+    }
+    return fmt.Sprintf("%s: %s", ErrInvalidQuery, strings.Join(messages, ", "))
+}
+
+func (e *Error) Unwrap() error {
+    return ErrInvalidQuery
+}
+
+// Parser reads typed query parameters and collects why they are invalid instead of stopping at the first one,
+// absent parameters are zero values. Err returns the collected errors once everything was read.
+type Parser struct {
+    query  url.Values
+    fields []*FieldError
+}
+
+func NewParser(query url.Values) *Parser {
+    return &Parser{query: query}
+}
+
+// Invalid reports the parameter as invalid, e.g. after a check across several parameters
+func (p *Parser) Invalid(name string, message string) {
+    p.fields = append(p.fields, &FieldError{Field: name, Value: p.query.Get(name), Message: message})
+}
+
+func (p *Parser) String(name string) string {
+    return p.query.Get(name)
+}
+
+// Int returns the parameter as an integer between low and high
+func (p *Parser) Int(name string, low int, high int) int {
+    value := p.query.Get(name)
+    if value == "" {
+        return 0
+    }
+    parsed, err := strconv.Atoi(value)
+    if err != nil {
+        p.Invalid(name, "must be an integer")
+        return 0
+    }
+    if parsed < low || parsed > high {
+        p.Invalid(name, fmt.Sprintf("must be between %d and %d", low, high))
+        return 0
+    }
+    return parsed
+}
+
+// Float returns the parameter as a number of at least low
+func (p *Parser) Float(name string, low float64) float64 {
+    if value := p.OptionalFloat(name, low); value != nil {
+        return *value
+    }
+    return 0
+}
+
+// OptionalFloat is Float for parameters where 0 is a value of its own, nil when the parameter is absent or invalid
+func (p *Parser) OptionalFloat(name string, low float64) *float64 {
+    value := p.query.Get(name)
+    if value == "" {
+        return nil
+    }
+    parsed, err := strconv.ParseFloat(value, 64)
+    if err != nil {
+        p.Invalid(name, "must be a number")
+        return nil
+    }
+    if parsed < low {
+        p.Invalid(name, fmt.Sprintf("must be at least %s", strconv.FormatFloat(low, 'f', -1, 64)))
+        return nil
+    }
+    return &parsed
+}
+
+// Enum returns the parameter when it is one of the values
+func (p *Parser) Enum(name string, values ...string) string {
+    value := p.query.Get(name)
+    if value == "" {
+        return ""
+    }
+    for _, allowed := range values {
+        if value == allowed {
+            return value
+        }
+    }
+    p.Invalid(name, "must be one of "+strings.Join(values, ", "))
+    return ""
+}
+
+// Time returns the parameter as an RFC3339 timestamp
+func (p *Parser) Time(name string) time.Time {
+    value := p.query.Get(name)
+    if value == "" {
+        return time.Time{}
+    }
+    parsed, err := time.Parse(time.RFC3339, value)
+    if err != nil {
+        p.Invalid(name, "must be an RFC3339 timestamp, e.g. 2024-01-02T03:04:05Z")
+        return time.Time{}
+    }
+    return parsed
+}
+
+// Check returns the parameter when valid accepts it, the error of valid is the message otherwise
+func (p *Parser) Check(name string, valid func(value string) error) string {
+    value := p.query.Get(name)
+    if value == "" {
+        return ""
+    }
+    if err := valid(value); err != nil {
+        p.Invalid(name, err.Error())
+        return ""
+    }
+    return value
+}
+
+// List returns the distinct comma separated values of the parameter that valid accepts, every rejected value is
+// reported with the error of valid as the message. More than high values are invalid.
+func (p *Parser) List(name string, high int, valid func(value string) error) []string {
+    var values []string
+    for _, value := range strings.Split(p.query.Get(name), ",") {
+        value = strings.TrimSpace(value)
+        if value == "" || slices.Contains(values, value) {
+            continue
+        }
+        if err := valid(value); err != nil {
+            p.fields = append(p.fields, &FieldError{Field: name, Value: value, Message: err.Error()})
+            continue
+        }
+        values = append(values, value)
+    }
+    if len(values) > high {
+        p.Invalid(name, fmt.Sprintf("must have at most %d values", high))
+        return nil
+    }
+    return values
+}
+
+// Err returns an *Error listing the invalid parameters, nil when they are all valid
+func (p *Parser) Err() error {
+    if len(p.fields) == 0 {
+        return nil
+    }
+    return &Error{Fields: p.fields}
+}
@@ -0,0 +1,84 @@
+package params
+
+import (
+    "errors"
+    "net/url"
+    "testing"
+)
+
+func TestParser(t *testing.T) {
+    p := NewParser(
+        url.Values{
+            "page":       {"two"},
+            "limit":      {"500"},
+            "mileage":    {"-1"},
+            "sort_order": {"up"},
+            "from":       {"yesterday"},
+            "location":   {"Yangon"},
+            "status":     {"PARKED"},
+            "vehicle_id": {"a,b,c"},
+        },
+    )
+    if got := p.String("location"); got != "Yangon" {
+        t.Errorf("expected the location, got %s", got)
+    }
+    p.Int("page", 1, 100)
+    p.Int("limit", 1, 100)
+    p.Float("mileage", 0)
+    p.Enum("sort_order", "asc", "desc")
+    p.Time("from")
+    p.Time("to")
+    p.Check(
+        "status", func(string) error {
+            return errors.New("must be a vehicle status")
+        },
+    )
+    p.List(
+        "vehicle_id", 2, func(value string) error {
+            if value == "c" {
+                return errors.New("must be a known vehicle")
+            }
+            return nil
+        },
+    )
+
+    err := p.Err()
+    if !errors.Is(err, ErrInvalidQuery) {
+        t.Fatalf("expected ErrInvalidQuery, got %v", err)
+    }
+    var invalid *Error
+    if !errors.As(err, &invalid) {
+        t.Fatalf("expected an *Error, got %T", err)
+    }
+    expected := map[string]string{
+        "page":       "must be an integer",
+        "limit":      "must be between 1 and 100",
+        "mileage":    "must be at least 0",
+        "sort_order": "must be one of asc, desc",
+        "from":       "must be an RFC3339 timestamp, e.g. 2024-01-02T03:04:05Z",
+        "status":     "must be a vehicle status",
+        "vehicle_id": "must be a known vehicle",
+    }
+    if len(invalid.Fields) != len(expected) {
+        t.Fatalf("expected %d invalid fields, got %+v", len(expected), invalid.Fields)
+    }
+    for _, field := range invalid.Fields {
+        if expected[field.Field] != field.Message || field.Value == "" {
+            t.Errorf("unexpected error of %s: %+v", field.Field, field)
+        }
+    }
+
+    if list := NewParser(url.Values{"ids": {"a,b,c"}}); list.List("ids", 2, func(string) error { return nil }) != nil ||
+        list.Err() == nil {
+        t.Error("expected a list with too many values to be invalid")
+    }
+
+    valid := NewParser(url.Values{"page": {"2"}, "sort_order": {"desc"}, "from": {"2024-01-02T03:04:05Z"}})
+    page, order, from := valid.Int("page", 1, 100), valid.Enum("sort_order", "asc", "desc"), valid.Time("from")
+    if page != 2 || order != "desc" || from.IsZero() {
+        t.Errorf("expected the valid parameters to be parsed, got %d, %s and %v", page, order, from)
+    }
+    if err = valid.Err(); err != nil {
+        t.Errorf("expected no error, got %v", err)
+    }
+}
@@ -0,0 +1,379 @@
+package parquet
+
+import (
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+    "math"
+    "time"
+
+    "github.com/klauspost/compress/zstd"
+)
+
+const (
+    ContentType = "application/vnd.apache.parquet"
+
+    magic = "PAR1"
+    // createdBy is the application recorded in the metadata of the files
+    createdBy = "tracking-svc"
+
+    // The values of the enums of the Parquet metadata, see
+    // https://github.com/apache/parquet-format/blob/master/src/main/thrift/parquet.thrift
+    typeInt64                = 2
+    typeDouble               = 5
+    typeByteArray            = 6
+    repetitionRequired       = 0
+    repetitionOptional       = 1
+    convertedUTF8            = 0
+    convertedTimestampMillis = 9
+    encodingPlain            = 0
+    encodingRLE              = 3
+    codecZstd                = 6
+    pageTypeData             = 0
+)
+
+var (
+    ErrRowLength    = errors.New("the row doesn't have a value per column")
+    ErrValueType    = errors.New("the value doesn't have the type of its column")
+    ErrValueMissing = errors.New("the column is required")
+    ErrClosed       = errors.New("the parquet writer is closed")
+)
+
+// Type is the type of the values of a column
+type Type int
+
+const (
+    // String columns have string values, stored as UTF-8 byte arrays
+    String Type = iota
+    // Double columns have float64 or *float64 values
+    Double
+    // Timestamp columns have time.Time values, stored as the UTC milliseconds since the Unix epoch
+    Timestamp
+)
+
+// Column is a column of a file, the values of an optional column can be nil
+type Column struct {
+    Name     string
+    Type     Type
+    Optional bool
+}
+
+func (c Column) physicalType() int32 {
+    switch c.Type {
+    case Double:
+        return typeDouble
+    case Timestamp:
+        return typeInt64
+    default:
+        return typeByteArray
+    }
+}
+
+func (c Column) repetition() int32 {
+    if c.Optional {
+        return repetitionOptional
+    }
+    return repetitionRequired
+}
+
+// columnChunk is the buffered values of a column of the current row group
+type columnChunk struct {
+    // levels are the definition levels of an optional column, 0 for the nil values
+    levels []byte
+    // values are the PLAIN encoded values that aren't nil
+    values []byte
+}
+
+// columnMetadata is the location of a written column chunk
+type columnMetadata struct {
+    offset       int64
+    values       int64
+    uncompressed int64
+    compressed   int64
+}
+
+type rowGroup struct {
+    columns []columnMetadata
+    rows    int64
+    size    int64
+}
+
+// Writer writes a Parquet file of flat columns a row at a time. The rows are buffered and written as a row group
+// every rowGroupRows rows, every column chunk is a single PLAIN encoded data page compressed with zstd. It writes that
+// subset of the format only, there are no nested or repeated columns, dictionaries, statistics or other codecs.
+type Writer struct {
+    out          *countingWriter
+    columns      []Column
+    rowGroupRows int
+    encoder      *zstd.Encoder
+
+    chunks []columnChunk
+    // marks are the lengths of the values of the chunks before the row being written
+    marks     []int
+    buffered  int
+    rows      int64
+    rowGroups []rowGroup
+    closed    bool
+}
+
+func NewWriter(w io.Writer, rowGroupRows int, columns ...Column) (*Writer, error) {
+    encoder, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+    if err != nil {
+        return nil, err
+    }
+    return &Writer{
+        out:          &countingWriter{writer: w},
+        columns:      columns,
+        rowGroupRows: max(rowGroupRows, 1),
+        encoder:      encoder,
+        chunks:       make([]columnChunk, len(columns)),
+        marks:        make([]int, len(columns)),
+    }, nil
+}
+
+// Write buffers a row with a value per column, a row with an invalid value isn't written
+func (w *Writer) Write(row ...any) error {
+    if w.closed {
+        return ErrClosed
+    }
+    if len(row) != len(w.columns) {
+        return fmt.Errorf("%w: %d values for %d columns", ErrRowLength, len(row), len(w.columns))
+    }
+    for i, value := range row {
+        w.marks[i] = len(w.chunks[i].values)
+        if err := w.append(i, value); err != nil {
+            // the values of the row appended before are dropped
+            for j := range i {
+                w.chunks[j].values = w.chunks[j].values[:w.marks[j]]
+                if w.columns[j].Optional {
+                    w.chunks[j].levels = w.chunks[j].levels[:w.buffered]
+                }
+            }
+            return fmt.Errorf("%s: %w", w.columns[i].Name, err)
+        }
+    }
+    w.buffered++
+    if w.buffered >= w.rowGroupRows {
+        return w.writeRowGroup()
+    }
+    return nil
+}
+
+func (w *Writer) append(i int, value any) error {
+    column, chunk := w.columns[i], &w.chunks[i]
+    if pointer, ok := value.(*float64); ok {
+        value = nil
+        if pointer != nil {
+            value = *pointer
+        }
+    }
+    if value == nil {
+        if !column.Optional {
+            return ErrValueMissing
+        }
+        chunk.levels = append(chunk.levels, 0)
+        return nil
+    }
+
+    switch v := value.(type) {
+    case string:
+        if column.Type != String {
+            return ErrValueType
+        }
+        chunk.values = binary.LittleEndian.AppendUint32(chunk.values, uint32(len(v)))
+        chunk.values = append(chunk.values, v...)
+    case float64:
+        if column.Type != Double {
+            return ErrValueType
+        }
+        chunk.values = binary.LittleEndian.AppendUint64(chunk.values, math.Float64bits(v))
+    case time.Time:
+        if column.Type != Timestamp {
+            return ErrValueType
+        }
+        chunk.values = binary.LittleEndian.AppendUint64(chunk.values, uint64(v.UnixMilli()))
+    default:
+        return ErrValueType
+    }
+    if column.Optional {
+        chunk.levels = append(chunk.levels, 1)
+    }
+    return nil
+}
+
+// writeRowGroup writes the buffered rows as a row group
+func (w *Writer) writeRowGroup() error {
+    if w.buffered == 0 {
+        return nil
+    }
+    if err := w.begin(); err != nil {
+        return err
+    }
+    group := rowGroup{rows: int64(w.buffered)}
+    for i, column := range w.columns {
+        chunk := &w.chunks[i]
+        var page []byte
+        if column.Optional {
+            levels := encodeLevels(chunk.levels)
+            page = binary.LittleEndian.AppendUint32(page, uint32(len(levels)))
+            page = append(page, levels...)
+        }
+        page = append(page, chunk.values...)
+        compressed := w.encoder.EncodeAll(page, nil)
+        header := pageHeader(w.buffered, len(page), len(compressed))
+
+        metadata := columnMetadata{
+            offset:       w.out.written,
+            values:       int64(w.buffered),
+            uncompressed: int64(len(header) + len(page)),
+            compressed:   int64(len(header) + len(compressed)),
+        }
+        if _, err := w.out.Write(header); err != nil {
+            return err
+        }
+        if _, err := w.out.Write(compressed); err != nil {
+            return err
+        }
+        group.columns = append(group.columns, metadata)
+        group.size += metadata.uncompressed
+        chunk.levels, chunk.values = chunk.levels[:0], chunk.values[:0]
+    }
+    w.rowGroups = append(w.rowGroups, group)
+    w.rows += group.rows
+    w.buffered = 0
+    return nil
+}
+
+// begin writes the magic number the file starts with before the first row group
+func (w *Writer) begin() error {
+    if w.out.written > 0 {
+        return nil
+    }
+    _, err := io.WriteString(w.out, magic)
+    return err
+}
+
+// Close writes the buffered rows and the metadata of the file, it doesn't close the underlying writer
+func (w *Writer) Close() error {
+    if w.closed {
+        return nil
+    }
+    w.closed = true
+    defer w.encoder.Close()
+    if err := w.writeRowGroup(); err != nil {
+        return err
+    }
+    if err := w.begin(); err != nil {
+        return err
+    }
+    metadata := w.fileMetadata()
+    if _, err := w.out.Write(metadata); err != nil {
+        return err
+    }
+    footer := binary.LittleEndian.AppendUint32(nil, uint32(len(metadata)))
+    _, err := w.out.Write(append(footer, magic...))
+    return err
+}
+
+// encodeLevels encodes the definition levels with the RLE of the RLE/bit-packing hybrid, a run per repeated level
+func encodeLevels(levels []byte) []byte {
+    var encoded []byte
+    for i := 0; i < len(levels); {
+        run := i + 1
+        for run < len(levels) && levels[run] == levels[i] {
+            run++
+        }
+        encoded = binary.AppendUvarint(encoded, uint64(run-i)<<1)
+        encoded = append(encoded, levels[i])
+        i = run
+    }
+    return encoded
+}
+
+// pageHeader encodes the PageHeader of a data page
+func pageHeader(values, uncompressed, compressed int) []byte {
+    e := &compactEncoder{}
+    e.beginStruct()
+    e.i32(1, pageTypeData)
+    e.i32(2, int32(uncompressed))
+    e.i32(3, int32(compressed))
+    e.structField(5)
+    e.i32(1, int32(values))
+    e.i32(2, encodingPlain)
+    e.i32(3, encodingRLE)
+    e.i32(4, encodingRLE)
+    e.endStruct()
+    e.endStruct()
+    return e.buf
+}
+
+// fileMetadata encodes the FileMetaData of the written row groups
+func (w *Writer) fileMetadata() []byte {
+    e := &compactEncoder{}
+    e.beginStruct()
+    e.i32(1, 1)
+
+    e.list(2, compactStruct, len(w.columns)+1)
+    e.beginStruct()
+    e.binary(4, "schema")
+    e.i32(5, int32(len(w.columns)))
+    e.endStruct()
+    for _, column := range w.columns {
+        e.beginStruct()
+        e.i32(1, column.physicalType())
+        e.i32(3, column.repetition())
+        e.binary(4, column.Name)
+        switch column.Type {
+        case String:
+            e.i32(6, convertedUTF8)
+        case Timestamp:
+            e.i32(6, convertedTimestampMillis)
+        }
+        e.endStruct()
+    }
+
+    e.i64(3, w.rows)
+    e.list(4, compactStruct, len(w.rowGroups))
+    for _, group := range w.rowGroups {
+        e.beginStruct()
+        e.list(1, compactStruct, len(group.columns))
+        for i, metadata := range group.columns {
+            column := w.columns[i]
+            e.beginStruct()
+            e.i64(2, metadata.offset)
+            e.structField(3)
+            e.i32(1, column.physicalType())
+            e.list(2, compactI32, 2)
+            e.i32Element(encodingPlain)
+            e.i32Element(encodingRLE)
+            e.list(3, compactBinary, 1)
+            e.binaryElement(column.Name)
+            e.i32(4, codecZstd)
+            e.i64(5, metadata.values)
+            e.i64(6, metadata.uncompressed)
+            e.i64(7, metadata.compressed)
+            e.i64(9, metadata.offset)
+            e.endStruct()
+            e.endStruct()
+        }
+        e.i64(2, group.size)
+        e.i64(3, group.rows)
+        e.endStruct()
+    }
+    e.binary(6, createdBy)
+    e.endStruct()
+    return e.buf
+}
+
+// countingWriter counts the bytes written, for the offsets of the column chunks
+type countingWriter struct {
+    writer  io.Writer
+    written int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+    n, err := w.writer.Write(p)
+    w.written += int64(n)
+    return n, err
+}
@@ -0,0 +1,323 @@
+package parquet
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "math"
+    "os"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/klauspost/compress/s2"
+    "github.com/klauspost/compress/zstd"
+)
+
+// The values the writer doesn't write, the files of other writers have them
+const (
+    codecUncompressed  = 0
+    codecSnappy        = 1
+    pageTypeDictionary = 2
+    // the types of the compact protocol
+    compactTrue   = 1
+    compactFalse  = 2
+    compactByte   = 3
+    compactI16    = 4
+    compactDouble = 7
+    compactSet    = 10
+)
+
+// compactDecoder decodes the Thrift compact structs of a file, fields by id with the structs as maps, the lists as
+// slices, the integers as int64, the binaries as strings and the booleans and doubles as themselves
+type compactDecoder struct {
+    buf []byte
+    pos int
+}
+
+func (d *compactDecoder) varint() uint64 {
+    v, n := binary.Uvarint(d.buf[d.pos:])
+    d.pos += n
+    return v
+}
+
+func (d *compactDecoder) zigzag() int64 {
+    v := d.varint()
+    return int64(v>>1) ^ -int64(v&1)
+}
+
+func (d *compactDecoder) value(kind byte) any {
+    switch kind {
+    case compactTrue, compactFalse:
+        // the booleans of a list are a byte each
+        d.pos++
+        return d.buf[d.pos-1] == compactTrue
+    case compactByte:
+        d.pos++
+        return int64(int8(d.buf[d.pos-1]))
+    case compactI16, compactI32, compactI64:
+        return d.zigzag()
+    case compactDouble:
+        d.pos += 8
+        return math.Float64frombits(binary.LittleEndian.Uint64(d.buf[d.pos-8:]))
+    case compactBinary:
+        n := int(d.varint())
+        d.pos += n
+        return string(d.buf[d.pos-n : d.pos])
+    case compactList, compactSet:
+        header := d.buf[d.pos]
+        d.pos++
+        size := int(header >> 4)
+        if size == 15 {
+            size = int(d.varint())
+        }
+        list := make([]any, size)
+        for i := range list {
+            list[i] = d.value(header & 0x0f)
+        }
+        return list
+    case compactStruct:
+        fields := map[int16]any{}
+        var id int16
+        for {
+            header := d.buf[d.pos]
+            d.pos++
+            if header == 0 {
+                return fields
+            }
+            if delta := int16(header >> 4); delta != 0 {
+                id += delta
+            } else {
+                id = int16(d.zigzag())
+            }
+            // the booleans of a struct are the types of their field headers
+            if kind := header & 0x0f; kind == compactTrue || kind == compactFalse {
+                fields[id] = kind == compactTrue
+            } else {
+                fields[id] = d.value(kind)
+            }
+        }
+    }
+    panic("unexpected compact type")
+}
+
+// requiredFields are the ids of the required fields of the structs of the metadata, see
+// https://github.com/apache/parquet-format/blob/master/src/main/thrift/parquet.thrift
+var requiredFields = map[string][]int16{
+    "FileMetaData":   {1, 2, 3, 4},
+    "SchemaElement":  {4},
+    "RowGroup":       {1, 2, 3},
+    "ColumnChunk":    {2},
+    "ColumnMetaData": {1, 2, 3, 4, 5, 6, 7, 9},
+    "PageHeader":     {1, 2, 3},
+    "DataPageHeader": {1, 2, 3, 4},
+}
+
+func checkRequired(t *testing.T, name string, fields any) {
+    t.Helper()
+    for _, id := range requiredFields[name] {
+        if _, ok := fields.(map[int16]any)[id]; !ok {
+            t.Errorf("expected the %s to have the field %d, got %v", name, id, fields)
+        }
+    }
+}
+
+// readMetadata decodes the FileMetaData of the footer of a file
+func readMetadata(t *testing.T, file []byte) map[int16]any {
+    t.Helper()
+    if len(file) < 12 || string(file[:4]) != magic || string(file[len(file)-4:]) != magic {
+        t.Fatalf("expected the file to start and end with %s", magic)
+    }
+    length := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+    d := &compactDecoder{buf: file, pos: len(file) - 8 - length}
+    metadata := d.value(compactStruct).(map[int16]any)
+    if d.pos != len(file)-8 {
+        t.Fatalf("expected the metadata to end at the footer, it ends at %d", d.pos)
+    }
+    checkRequired(t, "FileMetaData", metadata)
+    for _, element := range metadata[2].([]any) {
+        checkRequired(t, "SchemaElement", element)
+    }
+    return metadata
+}
+
+// readPage decodes the header of the page at the offset and decompresses the page with the codec of its column
+func readPage(t *testing.T, file []byte, offset int, codec int64) (map[int16]any, []byte) {
+    t.Helper()
+    d := &compactDecoder{buf: file, pos: offset}
+    header := d.value(compactStruct).(map[int16]any)
+    checkRequired(t, "PageHeader", header)
+    compressed := file[d.pos : d.pos+int(header[3].(int64))]
+
+    var page []byte
+    var err error
+    switch codec {
+    case codecUncompressed:
+        page = compressed
+    case codecSnappy:
+        page, err = s2.Decode(nil, compressed)
+    case codecZstd:
+        var decoder *zstd.Decoder
+        if decoder, err = zstd.NewReader(nil); err == nil {
+            defer decoder.Close()
+            page, err = decoder.DecodeAll(compressed, nil)
+        }
+    default:
+        t.Fatalf("unexpected codec %d", codec)
+    }
+    if err != nil {
+        t.Fatal(err)
+    }
+    if int64(len(page)) != header[2].(int64) {
+        t.Fatalf("expected a page of %d bytes, got %d", header[2], len(page))
+    }
+    return header, page
+}
+
+// readColumn returns the definition levels and the values of the data pages of a column, of every row group
+func readColumn(t *testing.T, file []byte, metadata map[int16]any, column int, optional bool) ([]byte, []byte) {
+    t.Helper()
+    var levels, values []byte
+    for _, group := range metadata[4].([]any) {
+        checkRequired(t, "RowGroup", group)
+        chunk := group.(map[int16]any)[1].([]any)[column]
+        checkRequired(t, "ColumnChunk", chunk)
+        chunkMetadata := chunk.(map[int16]any)[3].(map[int16]any)
+        checkRequired(t, "ColumnMetaData", chunkMetadata)
+
+        header, page := readPage(t, file, int(chunkMetadata[9].(int64)), chunkMetadata[4].(int64))
+        checkRequired(t, "DataPageHeader", header[5])
+        if optional {
+            // the levels are length prefixed RLE runs of the RLE/bit-packing hybrid
+            n := binary.LittleEndian.Uint32(page)
+            encoded := page[4 : 4+n]
+            for i := 0; i < len(encoded); {
+                run, size := binary.Uvarint(encoded[i:])
+                levels = append(levels, bytes.Repeat(encoded[i+size:i+size+1], int(run>>1))...)
+                i += size + 1
+            }
+            page = page[4+n:]
+        }
+        values = append(values, page...)
+    }
+    return levels, values
+}
+
+// testdata/pyarrow.parquet is written by pyarrow 17.0.0, an optional int64 column test of 1, 2 and 3 dictionary
+// encoded and compressed with snappy. It checks the reading of the tests against a file of another writer.
+func TestReferenceFile(t *testing.T) {
+    file, err := os.ReadFile("testdata/pyarrow.parquet")
+    if err != nil {
+        t.Fatal(err)
+    }
+    metadata := readMetadata(t, file)
+    schema := metadata[2].([]any)
+    column := schema[1].(map[int16]any)
+    if metadata[3] != int64(3) || len(schema) != 2 || schema[0].(map[int16]any)[5] != int64(1) ||
+        column[4] != "test" || column[1] != int64(typeInt64) || column[3] != int64(repetitionOptional) {
+        t.Fatalf("expected 3 rows of the optional int64 test, got %v", metadata)
+    }
+    if createdBy := metadata[6].(string); !strings.HasPrefix(createdBy, "parquet-cpp-arrow") {
+        t.Errorf("expected the file of pyarrow, got %q", createdBy)
+    }
+
+    // the levels are encoded as the writer encodes them, the values are the indexes of the dictionary in a group of 8
+    // bit-packed values
+    levels, indexes := readColumn(t, file, metadata, 0, true)
+    if !bytes.Equal(levels, []byte{1, 1, 1}) || string(indexes) != "\x02\x03\x24\x00" {
+        t.Errorf("expected 3 values with the indexes 0, 1 and 2, got %v %q", levels, indexes)
+    }
+    chunkMetadata := metadata[4].([]any)[0].(map[int16]any)[1].([]any)[0].(map[int16]any)[3].(map[int16]any)
+    _, page := readPage(t, file, int(chunkMetadata[9].(int64)), chunkMetadata[4].(int64))
+    if n := binary.LittleEndian.Uint32(page); !bytes.Equal(page[4:4+n], encodeLevels(levels)) {
+        t.Errorf("expected the levels of pyarrow to be encoded as %q, got %q", encodeLevels(levels), page[4:4+n])
+    }
+    header, dictionary := readPage(t, file, int(chunkMetadata[11].(int64)), chunkMetadata[4].(int64))
+    if header[1] != int64(pageTypeDictionary) || len(dictionary) != 24 ||
+        binary.LittleEndian.Uint64(dictionary[16:]) != 3 {
+        t.Errorf("expected a dictionary of 1, 2 and 3, got %v %v", header, dictionary)
+    }
+}
+
+func TestWriter(t *testing.T) {
+    var file bytes.Buffer
+    w, err := NewWriter(
+        &file,
+        2,
+        Column{Name: "vehicle_id", Type: String},
+        Column{Name: "lat", Type: Double, Optional: true},
+        Column{Name: "created_at", Type: Timestamp},
+    )
+    if err != nil {
+        t.Fatal(err)
+    }
+    at := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+    lat := 16.8
+    for _, row := range [][]any{
+        {"a", &lat, at},
+        {"b", (*float64)(nil), at.Add(time.Second)},
+        {"c", nil, at.Add(2 * time.Second)},
+    } {
+        if err = w.Write(row...); err != nil {
+            t.Fatal(err)
+        }
+    }
+    // an invalid row isn't written, the values before the invalid one included
+    if err = w.Write("d", 1.5, "yesterday"); !errors.Is(err, ErrValueType) {
+        t.Errorf("expected ErrValueType, got %v", err)
+    }
+    if err = w.Write(nil, 1.5, at); !errors.Is(err, ErrValueMissing) {
+        t.Errorf("expected ErrValueMissing, got %v", err)
+    }
+    if err = w.Write("d"); !errors.Is(err, ErrRowLength) {
+        t.Errorf("expected ErrRowLength, got %v", err)
+    }
+    if err = w.Close(); err != nil {
+        t.Fatal(err)
+    }
+    if err = w.Write("e", nil, at); !errors.Is(err, ErrClosed) {
+        t.Errorf("expected ErrClosed, got %v", err)
+    }
+
+    data := file.Bytes()
+    metadata := readMetadata(t, data)
+    if metadata[3] != int64(3) || len(metadata[4].([]any)) != 2 || metadata[6] != createdBy {
+        t.Fatalf("expected 3 rows in 2 row groups, got %v", metadata)
+    }
+    schema := metadata[2].([]any)
+    latColumn := schema[2].(map[int16]any)
+    if len(schema) != 4 || latColumn[4] != "lat" || latColumn[1] != int64(typeDouble) ||
+        latColumn[3] != int64(repetitionOptional) {
+        t.Errorf("expected the optional double lat in the schema, got %v", schema)
+    }
+    if schema[3].(map[int16]any)[6] != int64(convertedTimestampMillis) {
+        t.Errorf("expected created_at to be a timestamp, got %v", schema[3])
+    }
+
+    _, ids := readColumn(t, data, metadata, 0, false)
+    if want := "\x01\x00\x00\x00a\x01\x00\x00\x00b\x01\x00\x00\x00c"; string(ids) != want {
+        t.Errorf("expected the PLAIN encoded vehicle ids, got %q", ids)
+    }
+    levels, lats := readColumn(t, data, metadata, 1, true)
+    if !bytes.Equal(levels, []byte{1, 0, 0}) || math.Float64frombits(binary.LittleEndian.Uint64(lats)) != lat {
+        t.Errorf("expected a single lat, got %v, %v", levels, lats)
+    }
+    _, times := readColumn(t, data, metadata, 2, false)
+    if len(times) != 24 || int64(binary.LittleEndian.Uint64(times[16:])) != at.Add(2*time.Second).UnixMilli() {
+        t.Errorf("expected the milliseconds of the times, got %v", times)
+    }
+}
+
+func TestWriterEmpty(t *testing.T) {
+    var file bytes.Buffer
+    w, err := NewWriter(&file, 10, Column{Name: "id", Type: String})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err = w.Close(); err != nil {
+        t.Fatal(err)
+    }
+    if metadata := readMetadata(t, file.Bytes()); metadata[3] != int64(0) || len(metadata[4].([]any)) != 0 {
+        t.Errorf("expected a file without rows, got %v", metadata)
+    }
+}
@@ -0,0 +1,94 @@
+package parquet
+
+import (
+    "encoding/binary"
+)
+
+// The types of the Thrift compact protocol, the metadata of a Parquet file is encoded with it.
+// See https://github.com/apache/thrift/blob/master/doc/specs/thrift-compact-protocol.md.
+const (
+    compactI32    = 5
+    compactI64    = 6
+    compactBinary = 8
+    compactList   = 9
+    compactStruct = 12
+)
+
+// compactEncoder encodes Thrift structs with the compact protocol, the fields of a struct must be written in
+// ascending order of their ids
+type compactEncoder struct {
+    buf []byte
+    // fields is the id of the last field written of every struct being written, the innermost last
+    fields []int16
+}
+
+func (e *compactEncoder) varint(v uint64) {
+    e.buf = binary.AppendUvarint(e.buf, v)
+}
+
+// zigzag maps signed integers to unsigned ones so small negative numbers stay small
+func (e *compactEncoder) zigzag(v int64) {
+    e.varint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (e *compactEncoder) fieldHeader(id int16, kind byte) {
+    last := &e.fields[len(e.fields)-1]
+    if delta := id - *last; delta > 0 && delta <= 15 {
+        e.buf = append(e.buf, byte(delta)<<4|kind)
+    } else {
+        e.buf = append(e.buf, kind)
+        e.zigzag(int64(id))
+    }
+    *last = id
+}
+
+func (e *compactEncoder) beginStruct() {
+    e.fields = append(e.fields, 0)
+}
+
+func (e *compactEncoder) endStruct() {
+    e.buf = append(e.buf, 0)
+    e.fields = e.fields[:len(e.fields)-1]
+}
+
+func (e *compactEncoder) i32(id int16, v int32) {
+    e.fieldHeader(id, compactI32)
+    e.zigzag(int64(v))
+}
+
+func (e *compactEncoder) i64(id int16, v int64) {
+    e.fieldHeader(id, compactI64)
+    e.zigzag(v)
+}
+
+func (e *compactEncoder) binary(id int16, v string) {
+    e.fieldHeader(id, compactBinary)
+    e.varint(uint64(len(v)))
+    e.buf = append(e.buf, v...)
+}
+
+// structField starts a struct field, its fields follow and endStruct ends it
+func (e *compactEncoder) structField(id int16) {
+    e.fieldHeader(id, compactStruct)
+    e.beginStruct()
+}
+
+// list starts a list field of size elements of the kind, the elements follow without field headers
+func (e *compactEncoder) list(id int16, kind byte, size int) {
+    e.fieldHeader(id, compactList)
+    if size < 15 {
+        e.buf = append(e.buf, byte(size)<<4|kind)
+        return
+    }
+    e.buf = append(e.buf, 0xf0|kind)
+    e.varint(uint64(size))
+}
+
+func (e *compactEncoder) i32Element(v int32) {
+    e.zigzag(int64(v))
+}
+
+func (e *compactEncoder) binaryElement(v string) {
+    e.varint(uint64(len(v)))
+    e.buf = append(e.buf, v...)
+}
@@ -0,0 +1,65 @@
+package plan
+
+import (
+    "errors"
+    "math"
+    "net/url"
+    "strconv"
+    "time"
+)
+
+const (
+    // SampleSize is the number of ids of the affected records or messages a plan shows
+    SampleSize = 10
+    // QueryParameter asks the destructive admin APIs for their plan instead of executing them
+    QueryParameter = "dry_run"
+)
+
+var (
+    ErrInvalidDryRun = errors.New("dry_run must be true or false")
+)
+
+// Plan is the scope of a destructive operation, what a dry run returns instead of executing it. It is computed
+// from the same parameters as the execution, so it shows exactly what running the operation then would affect.
+type Plan struct {
+    Operation string `json:"operation"`
+    // Affected is the number of records or messages the operation would change
+    Affected int64 `json:"affected"`
+    // SampleIDs are the ids of up to SampleSize of the affected records or messages
+    SampleIDs []string `json:"sample_ids"`
+    // EstimatedSeconds is how long the operation would take at Rate, the throughput the estimate assumes
+    EstimatedSeconds float64 `json:"estimated_seconds"`
+    Rate             float64 `json:"rate"`
+    // Scope are the parameters the operation was planned with
+    Scope map[string]string `json:"scope,omitempty"`
+    // Warnings are what would fail or surprise when the operation runs, e.g. sampled messages failing a transform
+    Warnings []string `json:"warnings,omitempty"`
+}
+
+// New plans the operation affecting that many records at rate per second
+func New(operation string, affected int64, rate float64) *Plan {
+    p := &Plan{Operation: operation, Affected: affected, SampleIDs: []string{}, Rate: rate}
+    p.EstimatedSeconds = Estimate(affected, rate).Seconds()
+    return p
+}
+
+// Estimate returns how long changing that many records takes at rate per second, rounded up to a second
+func Estimate(affected int64, rate float64) time.Duration {
+    if affected <= 0 || rate <= 0 {
+        return 0
+    }
+    return time.Duration(math.Ceil(float64(affected)/rate)) * time.Second
+}
+
+// DryRun reports whether the query asks for a dry run, absent means no
+func DryRun(query url.Values) (bool, error) {
+    value := query.Get(QueryParameter)
+    if value == "" {
+        return false, nil
+    }
+    dryRun, err := strconv.ParseBool(value)
+    if err != nil {
+        return false, ErrInvalidDryRun
+    }
+    return dryRun, nil
+}
@@ -0,0 +1,36 @@
+package plan
+
+import (
+    "net/url"
+    "testing"
+    "time"
+)
+
+func TestEstimate(t *testing.T) {
+    for _, tt := range []struct {
+        affected int64
+        rate     float64
+        want     time.Duration
+    }{
+        {0, 100, 0},
+        {10, 0, 0},
+        {100, 100, time.Second},
+        {101, 100, 2 * time.Second},
+    } {
+        if got := Estimate(tt.affected, tt.rate); got != tt.want {
+            t.Errorf("Estimate(%d, %v) = %v, want %v", tt.affected, tt.rate, got, tt.want)
+        }
+    }
+}
+
+func TestDryRun(t *testing.T) {
+    for value, want := range map[string]bool{"": false, "true": true, "1": true, "false": false} {
+        got, err := DryRun(url.Values{QueryParameter: {value}})
+        if err != nil || got != want {
+            t.Errorf("DryRun(%q) = %v, %v, want %v", value, got, err, want)
+        }
+    }
+    if _, err := DryRun(url.Values{QueryParameter: {"maybe"}}); err != ErrInvalidDryRun {
+        t.Errorf("expected ErrInvalidDryRun, got %v", err)
+    }
+}
@@ -0,0 +1,150 @@
+package repositories
+
+import (
+    "context"
+    "log"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AccessAudit records an API request for compliance, who made it, with which parameters and how many results it
+// returned or changed. Request bodies aren't recorded, they contain location data.
+type AccessAudit struct {
+    ID       primitive.ObjectID  `json:"id" bson:"_id,omitempty"`
+    TenantID string              `json:"tenant_id,omitempty" bson:"tenant_id,omitempty"`
+    UserID   string              `json:"user_id" bson:"user_id"`
+    Role     string              `json:"role,omitempty" bson:"role,omitempty"`
+    Method   string              `json:"method" bson:"method"`
+    Path     string              `json:"path" bson:"path"`
+    Params   map[string][]string `json:"params,omitempty" bson:"params,omitempty"`
+    Status   int                 `json:"status" bson:"status"`
+    // ResultCount is the number of records returned or changed, nil when the endpoint doesn't report it
+    ResultCount *int64         `json:"result_count" bson:"result_count,omitempty"`
+    DurationMs  float64        `json:"duration_ms" bson:"duration_ms"`
+    CreatedAt   timestamp.Time `json:"created_at" bson:"created_at"`
+}
+
+type AccessAuditFilter struct {
+    Page     int    `json:"page"`
+    PageSize int    `json:"limit"`
+    UserID   string `json:"user_id"`
+    Method   string `json:"method"`
+    Path     string `json:"path"`
+    From     string `json:"from"`
+    To       string `json:"to"`
+
+    from time.Time
+    to   time.Time
+}
+
+func (f *AccessAuditFilter) Build() error {
+    if f.Page == 0 {
+        f.Page = 1
+    }
+    f.PageSize = pageSize(f.PageSize)
+    if f.From != "" {
+        from, err := time.Parse(time.RFC3339, f.From)
+        if err != nil {
+            return ErrInvalidTimeRange
+        }
+        f.from = from
+    }
+    if f.To != "" {
+        to, err := time.Parse(time.RFC3339, f.To)
+        if err != nil {
+            return ErrInvalidTimeRange
+        }
+        f.to = to
+    }
+    if !f.from.IsZero() && !f.to.IsZero() && !f.from.Before(f.to) {
+        return ErrInvalidTimeRange
+    }
+    return nil
+}
+
+type AccessAuditRepository interface {
+    CreateAccessAudit(ctx context.Context, audit *AccessAudit) error
+    FindAccessAudits(ctx context.Context, filter *AccessAuditFilter) ([]*AccessAudit, error)
+}
+
+type MongoAccessAuditRepository struct {
+    collection *mongo.Collection
+}
+
+func NewMongoAccessAuditRepository(db *mongo.Database) *MongoAccessAuditRepository {
+    return &MongoAccessAuditRepository{
+        collection: db.Collection("access_audits"),
+    }
+}
+
+func (repo *MongoAccessAuditRepository) CreateAccessAudit(ctx context.Context, audit *AccessAudit) error {
+    if audit.CreatedAt.IsZero() {
+        audit.CreatedAt = timestamp.Now()
+    }
+    audit.TenantID = tenantOf(ctx)
+    result, err := repo.collection.InsertOne(ctx, audit)
+    if err != nil {
+        return err
+    }
+    audit.ID = result.InsertedID.(primitive.ObjectID)
+    return nil
+}
+
+// FindAccessAudits finds the requests newest first
+func (repo *MongoAccessAuditRepository) FindAccessAudits(
+    ctx context.Context,
+    filter *AccessAuditFilter,
+) ([]*AccessAudit, error) {
+    var audits []*AccessAudit
+    bsonMFilter := scopeTenant(ctx, bson.M{})
+    findOptions := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}})
+    if filter != nil {
+        if err := filter.Build(); err != nil {
+            return nil, err
+        }
+        if filter.UserID != "" {
+            bsonMFilter["user_id"] = filter.UserID
+        }
+        if filter.Method != "" {
+            bsonMFilter["method"] = filter.Method
+        }
+        if filter.Path != "" {
+            bsonMFilter["path"] = filter.Path
+        }
+        if !filter.from.IsZero() || !filter.to.IsZero() {
+            createdAt := bson.M{}
+            if !filter.from.IsZero() {
+                createdAt["$gte"] = filter.from
+            }
+            if !filter.to.IsZero() {
+                createdAt["$lt"] = filter.to
+            }
+            bsonMFilter["created_at"] = createdAt
+        }
+        findOptions.SetSkip(int64((filter.Page - 1) * filter.PageSize))
+        findOptions.SetLimit(int64(filter.PageSize))
+    }
+    cursor, err := repo.collection.Find(ctx, bsonMFilter, findOptions)
+    if err != nil {
+        return nil, err
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
+        }
+    }(cursor, ctx)
+    for cursor.Next(ctx) {
+        var audit AccessAudit
+        if err := cursor.Decode(&audit); err != nil {
+            return nil, err
+        }
+        audits = append(audits, &audit)
+    }
+    return audits, nil
+}
@@ -0,0 +1,488 @@
+package repositories
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "strings"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrAlertRuleNotFound = fmt.Errorf("alert rule %w", ErrNotFound)
+
+// AlertRule alerts when a metric of the readings of its vehicles meets the comparison with the threshold for at
+// least DurationSeconds. A rule without vehicles applies to every vehicle.
+type AlertRule struct {
+    ID              primitive.ObjectID   `json:"id" bson:"_id,omitempty"`
+    TenantID        string               `json:"tenant_id,omitempty" bson:"tenant_id,omitempty"`
+    Name            string               `json:"name" bson:"name"`
+    Metric          string               `json:"metric" bson:"metric"`
+    Comparator      string               `json:"comparator" bson:"comparator"`
+    Threshold       float64              `json:"threshold" bson:"threshold"`
+    DurationSeconds int64                `json:"duration_seconds" bson:"duration_seconds"`
+    VehicleIDs      []primitive.ObjectID `json:"vehicle_ids,omitempty" bson:"vehicle_ids,omitempty"`
+    Active          bool                 `json:"active" bson:"active"`
+    // Notifications are sent when the rule triggers and resolves an alert
+    Notifications []*AlertNotification `json:"notifications,omitempty" bson:"notifications,omitempty"`
+    CreatedAt     timestamp.Time       `json:"created_at" bson:"created_at"`
+    UpdatedAt     timestamp.Time       `json:"updated_at" bson:"updated_at"`
+}
+
+// AlertNotification sends the alerts of a rule over a channel, email, sms or slack, to a recipient, an email address,
+// a phone number in E.164 format or the url of a Slack incoming webhook. Template is the text/template of the message,
+// the default one of the event when it is empty.
+type AlertNotification struct {
+    Channel  string `json:"channel" bson:"channel"`
+    To       string `json:"to" bson:"to"`
+    Template string `json:"template,omitempty" bson:"template,omitempty"`
+}
+
+// AlertRuleState is where the rule stands for a vehicle: since when its readings meet the rule and the alert
+// raised, nil while the rule isn't met long enough
+type AlertRuleState struct {
+    RuleID    primitive.ObjectID  `json:"rule_id" bson:"rule_id"`
+    VehicleID primitive.ObjectID  `json:"vehicle_id" bson:"vehicle_id"`
+    TenantID  string              `json:"tenant_id,omitempty" bson:"tenant_id,omitempty"`
+    At        timestamp.Time      `json:"at" bson:"at"`
+    Since     *timestamp.Time     `json:"since,omitempty" bson:"since,omitempty"`
+    AlertID   *primitive.ObjectID `json:"alert_id,omitempty" bson:"alert_id,omitempty"`
+}
+
+// Alert is raised once when the readings of a vehicle met a rule long enough, and resolved by the first reading
+// no longer meeting it
+type Alert struct {
+    ID             primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+    TenantID       string             `json:"tenant_id,omitempty" bson:"tenant_id,omitempty"`
+    RuleID         primitive.ObjectID `json:"rule_id" bson:"rule_id"`
+    RuleName       string             `json:"rule_name" bson:"rule_name"`
+    VehicleID      primitive.ObjectID `json:"vehicle_id" bson:"vehicle_id"`
+    DriverID       string             `json:"driver_id,omitempty" bson:"driver_id,omitempty"`
+    TrackingDataID primitive.ObjectID `json:"tracking_data_id" bson:"tracking_data_id"`
+    Metric         string             `json:"metric" bson:"metric"`
+    Comparator     string             `json:"comparator" bson:"comparator"`
+    Threshold      float64            `json:"threshold" bson:"threshold"`
+    Value          float64            `json:"value" bson:"value"`
+    // Since is when the readings started meeting the rule and TriggeredAt when they met it long enough
+    Since       timestamp.Time  `json:"since" bson:"since"`
+    TriggeredAt timestamp.Time  `json:"triggered_at" bson:"triggered_at"`
+    ResolvedAt  *timestamp.Time `json:"resolved_at,omitempty" bson:"resolved_at,omitempty"`
+}
+
+type AlertFilter struct {
+    Page      int    `json:"page"`
+    PageSize  int    `json:"limit"`
+    VehicleID string `json:"vehicle_id" doc:"Comma separated vehicle ids"`
+    RuleID    string `json:"rule_id"`
+    From      string `json:"from" doc:"RFC3339 start of triggered_at, inclusive"`
+    To        string `json:"to" doc:"RFC3339 end of triggered_at, exclusive"`
+
+    vehicleIDs []primitive.ObjectID
+    restricted []primitive.ObjectID
+    ruleID     primitive.ObjectID
+    from       time.Time
+    to         time.Time
+}
+
+// RestrictVehicles limits the alerts to the given vehicles, on top of the vehicle_id filter
+func (f *AlertFilter) RestrictVehicles(vehicleIDs []primitive.ObjectID) {
+    f.restricted = vehicleIDs
+}
+
+func (f *AlertFilter) Build() error {
+    if f.Page == 0 {
+        f.Page = 1
+    }
+    f.PageSize = pageSize(f.PageSize)
+    f.vehicleIDs = nil
+    if f.VehicleID != "" {
+        for _, value := range strings.Split(f.VehicleID, ",") {
+            id, err := primitive.ObjectIDFromHex(strings.TrimSpace(value))
+            if err != nil {
+                return ErrInvalidID
+            }
+            f.vehicleIDs = append(f.vehicleIDs, id)
+        }
+    }
+    f.ruleID = primitive.NilObjectID
+    if f.RuleID != "" {
+        id, err := primitive.ObjectIDFromHex(f.RuleID)
+        if err != nil {
+            return ErrInvalidID
+        }
+        f.ruleID = id
+    }
+    if f.From != "" {
+        from, err := time.Parse(time.RFC3339, f.From)
+        if err != nil {
+            return ErrInvalidTimeRange
+        }
+        f.from = from
+    }
+    if f.To != "" {
+        to, err := time.Parse(time.RFC3339, f.To)
+        if err != nil {
+            return ErrInvalidTimeRange
+        }
+        f.to = to
+    }
+    if !f.from.IsZero() && !f.to.IsZero() && !f.from.Before(f.to) {
+        return ErrInvalidTimeRange
+    }
+    return nil
+}
+
+// match selects the alerts of the vehicles and rule of the filter triggered during its time range
+func (f *AlertFilter) match(ctx context.Context) bson.M {
+    match := scopeTenant(ctx, bson.M{})
+    vehicleIDs := f.vehicleIDs
+    if f.restricted != nil {
+        vehicleIDs = []primitive.ObjectID{}
+        for _, id := range f.restricted {
+            if f.vehicleIDs == nil || len(intersect(f.vehicleIDs, id)) > 0 {
+                vehicleIDs = append(vehicleIDs, id)
+            }
+        }
+    }
+    if vehicleIDs != nil {
+        match["vehicle_id"] = bson.M{"$in": vehicleIDs}
+    }
+    if !f.ruleID.IsZero() {
+        match["rule_id"] = f.ruleID
+    }
+    if !f.from.IsZero() || !f.to.IsZero() {
+        triggeredAt := bson.M{}
+        if !f.from.IsZero() {
+            triggeredAt["$gte"] = f.from
+        }
+        if !f.to.IsZero() {
+            triggeredAt["$lt"] = f.to
+        }
+        match["triggered_at"] = triggeredAt
+    }
+    return match
+}
+
+type AlertRuleRepository interface {
+    CreateAlertRule(ctx context.Context, rule *AlertRule) error
+    FindAlertRules(ctx context.Context) ([]*AlertRule, error)
+    FindAlertRule(ctx context.Context, id primitive.ObjectID) (*AlertRule, error)
+    // UpdateAlertRule replaces the rule, the state of its vehicles is kept
+    UpdateAlertRule(ctx context.Context, rule *AlertRule) error
+    // DeleteAlertRule deletes the rule with the state of its vehicles, its alerts are kept
+    DeleteAlertRule(ctx context.Context, id primitive.ObjectID) error
+    // FindActiveAlertRules returns the active rules of the tenant of the context
+    FindActiveAlertRules(ctx context.Context) ([]*AlertRule, error)
+
+    // FindAlertRuleStates returns the state of every rule of the vehicle
+    FindAlertRuleStates(ctx context.Context, vehicleID primitive.ObjectID) ([]*AlertRuleState, error)
+    SaveAlertRuleState(ctx context.Context, state *AlertRuleState) error
+
+    CreateAlert(ctx context.Context, alert *Alert) error
+    // ResolveAlert sets when the alert was resolved and returns it
+    ResolveAlert(ctx context.Context, id primitive.ObjectID, at time.Time) (*Alert, error)
+    // FindAlerts returns a page of the alerts of the filter, the latest first
+    FindAlerts(ctx context.Context, filter *AlertFilter) ([]*Alert, error)
+    // CountAlerts counts the alerts of the tenant of the context triggered in [from, to) by vehicle
+    CountAlerts(ctx context.Context, from, to time.Time) (map[primitive.ObjectID]int64, error)
+}
+
+type MongoAlertRuleRepository struct {
+    rules  *mongo.Collection
+    states *mongo.Collection
+    alerts *mongo.Collection
+}
+
+func NewMongoAlertRuleRepository(db *mongo.Database) *MongoAlertRuleRepository {
+    return &MongoAlertRuleRepository{
+        rules:  db.Collection("alert_rules"),
+        states: db.Collection("alert_rule_states"),
+        alerts: db.Collection("alerts"),
+    }
+}
+
+// CreateIndexes creates the indexes of the active rules, the unique index of the state of every rule and vehicle and
+// the indexes of the alert history
+func (repo *MongoAlertRuleRepository) CreateIndexes(ctx context.Context) error {
+    if _, err := repo.rules.Indexes().CreateOne(
+        ctx,
+        mongo.IndexModel{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "active", Value: 1}}},
+    ); err != nil {
+        return classify(err)
+    }
+    if _, err := repo.states.Indexes().CreateOne(
+        ctx,
+        mongo.IndexModel{
+            Keys: bson.D{
+                {Key: "tenant_id", Value: 1},
+                {Key: "vehicle_id", Value: 1},
+                {Key: "rule_id", Value: 1},
+            },
+            Options: options.Index().SetUnique(true),
+        },
+    ); err != nil {
+        return classify(err)
+    }
+    _, err := repo.alerts.Indexes().CreateMany(
+        ctx,
+        []mongo.IndexModel{
+            {
+                Keys: bson.D{
+                    {Key: "tenant_id", Value: 1},
+                    {Key: "vehicle_id", Value: 1},
+                    {Key: "triggered_at", Value: -1},
+                },
+            },
+            {
+                Keys: bson.D{
+                    {Key: "tenant_id", Value: 1},
+                    {Key: "rule_id", Value: 1},
+                    {Key: "triggered_at", Value: -1},
+                },
+            },
+        },
+    )
+    return classify(err)
+}
+
+func (repo *MongoAlertRuleRepository) CreateAlertRule(ctx context.Context, rule *AlertRule) error {
+    now := timestamp.Now()
+    rule.CreatedAt = now
+    rule.UpdatedAt = now
+    rule.TenantID = tenantOf(ctx)
+    result, err := repo.rules.InsertOne(ctx, rule)
+    if err != nil {
+        return classify(err)
+    }
+    rule.ID = result.InsertedID.(primitive.ObjectID)
+    return nil
+}
+
+func (repo *MongoAlertRuleRepository) FindAlertRules(ctx context.Context) ([]*AlertRule, error) {
+    return repo.findAlertRules(ctx, scopeTenant(ctx, bson.M{}))
+}
+
+// FindActiveAlertRules never returns the rules of another tenant, without a tenant in the context it returns the
+// rules without a tenant
+func (repo *MongoAlertRuleRepository) FindActiveAlertRules(ctx context.Context) ([]*AlertRule, error) {
+    match := bson.M{"active": true, "tenant_id": bson.M{"$exists": false}}
+    if id, ok := tenant.FromContext(ctx); ok {
+        match["tenant_id"] = id
+    }
+    return repo.findAlertRules(ctx, match)
+}
+
+func (repo *MongoAlertRuleRepository) findAlertRules(ctx context.Context, match bson.M) ([]*AlertRule, error) {
+    cursor, err := repo.rules.Find(ctx, match, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+    if err != nil {
+        return nil, classify(err)
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
+        }
+    }(cursor, ctx)
+
+    rules := []*AlertRule{}
+    for cursor.Next(ctx) {
+        var rule AlertRule
+        if err := cursor.Decode(&rule); err != nil {
+            return nil, err
+        }
+        rules = append(rules, &rule)
+    }
+    return rules, classify(cursor.Err())
+}
+
+func (repo *MongoAlertRuleRepository) FindAlertRule(ctx context.Context, id primitive.ObjectID) (*AlertRule, error) {
+    var rule AlertRule
+    err := repo.rules.FindOne(ctx, scopeTenant(ctx, bson.M{"_id": id})).Decode(&rule)
+    if errors.Is(err, mongo.ErrNoDocuments) {
+        return nil, ErrAlertRuleNotFound
+    }
+    if err != nil {
+        return nil, classify(err)
+    }
+    return &rule, nil
+}
+
+func (repo *MongoAlertRuleRepository) UpdateAlertRule(ctx context.Context, rule *AlertRule) error {
+    rule.UpdatedAt = timestamp.Now()
+    result, err := repo.rules.UpdateOne(
+        ctx,
+        scopeTenant(ctx, bson.M{"_id": rule.ID}),
+        bson.M{
+            "$set": bson.M{
+                "name":             rule.Name,
+                "metric":           rule.Metric,
+                "comparator":       rule.Comparator,
+                "threshold":        rule.Threshold,
+                "duration_seconds": rule.DurationSeconds,
+                "vehicle_ids":      rule.VehicleIDs,
+                "active":           rule.Active,
+                "notifications":    rule.Notifications,
+                "updated_at":       rule.UpdatedAt,
+            },
+        },
+    )
+    if err != nil {
+        return classify(err)
+    }
+    if result.MatchedCount == 0 {
+        return ErrAlertRuleNotFound
+    }
+    return nil
+}
+
+func (repo *MongoAlertRuleRepository) DeleteAlertRule(ctx context.Context, id primitive.ObjectID) error {
+    result, err := repo.rules.DeleteOne(ctx, scopeTenant(ctx, bson.M{"_id": id}))
+    if err != nil {
+        return classify(err)
+    }
+    if result.DeletedCount == 0 {
+        return ErrAlertRuleNotFound
+    }
+    _, err = repo.states.DeleteMany(ctx, bson.M{"rule_id": id})
+    return classify(err)
+}
+
+func (repo *MongoAlertRuleRepository) FindAlertRuleStates(
+    ctx context.Context,
+    vehicleID primitive.ObjectID,
+) ([]*AlertRuleState, error) {
+    cursor, err := repo.states.Find(ctx, scopeTenant(ctx, bson.M{"vehicle_id": vehicleID}))
+    if err != nil {
+        return nil, classify(err)
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
+        }
+    }(cursor, ctx)
+    var states []*AlertRuleState
+    for cursor.Next(ctx) {
+        var state AlertRuleState
+        if err := cursor.Decode(&state); err != nil {
+            return nil, err
+        }
+        states = append(states, &state)
+    }
+    return states, classify(cursor.Err())
+}
+
+func (repo *MongoAlertRuleRepository) SaveAlertRuleState(ctx context.Context, state *AlertRuleState) error {
+    state.TenantID = tenantOf(ctx)
+    _, err := repo.states.ReplaceOne(
+        ctx,
+        scopeTenant(ctx, bson.M{"vehicle_id": state.VehicleID, "rule_id": state.RuleID}),
+        state,
+        options.Replace().SetUpsert(true),
+    )
+    return classify(err)
+}
+
+func (repo *MongoAlertRuleRepository) CreateAlert(ctx context.Context, alert *Alert) error {
+    alert.TenantID = tenantOf(ctx)
+    result, err := repo.alerts.InsertOne(ctx, alert)
+    if err != nil {
+        return classify(err)
+    }
+    alert.ID = result.InsertedID.(primitive.ObjectID)
+    return nil
+}
+
+func (repo *MongoAlertRuleRepository) ResolveAlert(
+    ctx context.Context,
+    id primitive.ObjectID,
+    at time.Time,
+) (*Alert, error) {
+    var alert Alert
+    err := repo.alerts.FindOneAndUpdate(
+        ctx,
+        scopeTenant(ctx, bson.M{"_id": id}),
+        bson.M{"$set": bson.M{"resolved_at": timestamp.New(at)}},
+        options.FindOneAndUpdate().SetReturnDocument(options.After),
+    ).Decode(&alert)
+    if errors.Is(err, mongo.ErrNoDocuments) {
+        return nil, fmt.Errorf("alert %w", ErrNotFound)
+    }
+    if err != nil {
+        return nil, classify(err)
+    }
+    return &alert, nil
+}
+
+func (repo *MongoAlertRuleRepository) FindAlerts(ctx context.Context, filter *AlertFilter) ([]*Alert, error) {
+    if err := filter.Build(); err != nil {
+        return nil, err
+    }
+    cursor, err := repo.alerts.Find(
+        ctx,
+        filter.match(ctx),
+        options.Find().
+            SetSort(bson.D{{Key: "triggered_at", Value: -1}, {Key: "_id", Value: -1}}).
+            SetSkip(int64((filter.Page-1)*filter.PageSize)).
+            SetLimit(int64(filter.PageSize)),
+    )
+    if err != nil {
+        return nil, classify(err)
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
+        }
+    }(cursor, ctx)
+    var alerts []*Alert
+    for cursor.Next(ctx) {
+        var alert Alert
+        if err := cursor.Decode(&alert); err != nil {
+            return nil, err
+        }
+        alerts = append(alerts, &alert)
+    }
+    return alerts, cursor.Err()
+}
+
+func (repo *MongoAlertRuleRepository) CountAlerts(
+    ctx context.Context,
+    from, to time.Time,
+) (map[primitive.ObjectID]int64, error) {
+    cursor, err := repo.alerts.Aggregate(
+        ctx,
+        mongo.Pipeline{
+            {{Key: "$match", Value: scopeTenant(ctx, bson.M{"triggered_at": bson.M{"$gte": from, "$lt": to}})}},
+            {{Key: "$group", Value: bson.M{"_id": "$vehicle_id", "count": bson.M{"$sum": 1}}}},
+        },
+    )
+    if err != nil {
+        return nil, classify(err)
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
+        }
+    }(cursor, ctx)
+    counts := map[primitive.ObjectID]int64{}
+    for cursor.Next(ctx) {
+        var count struct {
+            VehicleID primitive.ObjectID `bson:"_id"`
+            Count     int64              `bson:"count"`
+        }
+        if err := cursor.Decode(&count); err != nil {
+            return nil, err
+        }
+        counts[count.VehicleID] = count.Count
+    }
+    return counts, cursor.Err()
+}
@@ -0,0 +1,214 @@
+package repositories
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "slices"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+)
+
+// bufferedWrite is a record waiting in the buffer, done receives the result of the batch it was written with
+type bufferedWrite struct {
+    record *TrackingRecord
+    done   chan error
+}
+
+// bufferKey groups the buffered writes by tenant, a batch is written with the tenant of its records
+type bufferKey struct {
+    tenantID string
+    scoped   bool
+}
+
+// BufferStats are the metrics of the buffer since the process started
+type BufferStats struct {
+    // Depth is the number of records waiting to be written
+    Depth int `json:"depth"`
+    // Flushes is the number of batches written, Flushed and Failed the number of records stored and not stored
+    Flushes int64 `json:"flushes"`
+    Flushed int64 `json:"flushed"`
+    Failed  int64 `json:"failed"`
+}
+
+// BufferedTrackingRepository coalesces the tracking data stored one reading at a time into batches written with
+// CreateManyTrackingData. A batch is written once it has batchSize records or maxWait after the oldest of them was
+// buffered. CreateTrackingData only returns once the batch of its record was written, with the result of that
+// record, so a message acknowledged after storing its reading is only acknowledged once the reading is durable.
+// Close writes the buffered records right away on shutdown.
+type BufferedTrackingRepository struct {
+    TrackingRepository
+    batchSize    int
+    maxWait      time.Duration
+    flushTimeout time.Duration
+
+    mu      sync.Mutex
+    pending map[bufferKey][]*bufferedWrite
+    depth   int
+    timer   *time.Timer
+    closed  bool
+
+    flushes atomic.Int64
+    flushed atomic.Int64
+    failed  atomic.Int64
+}
+
+// NewBufferedTrackingRepository buffers up to batchSize records for up to maxWait, every batch is written with a
+// deadline of flushTimeout
+func NewBufferedTrackingRepository(
+    trackingRepo TrackingRepository,
+    batchSize int,
+    maxWait time.Duration,
+    flushTimeout time.Duration,
+) *BufferedTrackingRepository {
+    return &BufferedTrackingRepository{
+        TrackingRepository: trackingRepo,
+        batchSize:          batchSize,
+        maxWait:            maxWait,
+        flushTimeout:       flushTimeout,
+        pending:            map[bufferKey][]*bufferedWrite{},
+    }
+}
+
+// CreateTrackingData buffers the record and waits for its batch to be written. When ctx is done first, the record
+// is taken out of the buffer and not stored, unless its batch is being written already.
+func (repo *BufferedTrackingRepository) CreateTrackingData(ctx context.Context, trackingData *TrackingRecord) error {
+    id, scoped := tenant.FromContext(ctx)
+    key := bufferKey{tenantID: id, scoped: scoped}
+    write := &bufferedWrite{record: trackingData, done: make(chan error, 1)}
+
+    repo.mu.Lock()
+    if repo.closed {
+        repo.mu.Unlock()
+        return repo.TrackingRepository.CreateTrackingData(ctx, trackingData)
+    }
+    repo.pending[key] = append(repo.pending[key], write)
+    repo.depth++
+    var batch []*bufferedWrite
+    if len(repo.pending[key]) >= repo.batchSize {
+        batch = repo.take(key)
+    } else if repo.timer == nil {
+        repo.timer = time.AfterFunc(repo.maxWait, repo.flushPending)
+    }
+    repo.mu.Unlock()
+
+    if batch != nil {
+        repo.write(key, batch)
+    }
+    select {
+    case err := <-write.done:
+        return err
+    case <-ctx.Done():
+    }
+
+    repo.mu.Lock()
+    removed := repo.remove(key, write)
+    repo.mu.Unlock()
+    if !removed {
+        return <-write.done
+    }
+    if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+        return fmt.Errorf("%w: %w", ErrTransient, ctx.Err())
+    }
+    return ctx.Err()
+}
+
+// Close writes the buffered records, the records stored after are written right away without buffering
+func (repo *BufferedTrackingRepository) Close() {
+    repo.mu.Lock()
+    repo.closed = true
+    batches := repo.takeAll()
+    repo.mu.Unlock()
+    repo.writeAll(batches)
+}
+
+// Stats returns the metrics of the buffer
+func (repo *BufferedTrackingRepository) Stats() BufferStats {
+    repo.mu.Lock()
+    depth := repo.depth
+    repo.mu.Unlock()
+    return BufferStats{
+        Depth:   depth,
+        Flushes: repo.flushes.Load(),
+        Flushed: repo.flushed.Load(),
+        Failed:  repo.failed.Load(),
+    }
+}
+
+// flushPending writes every buffered record once the oldest of them waited maxWait
+func (repo *BufferedTrackingRepository) flushPending() {
+    repo.mu.Lock()
+    batches := repo.takeAll()
+    repo.mu.Unlock()
+    repo.writeAll(batches)
+}
+
+// take removes the batch of the key from the buffer, repo.mu must be held
+func (repo *BufferedTrackingRepository) take(key bufferKey) []*bufferedWrite {
+    batch := repo.pending[key]
+    delete(repo.pending, key)
+    repo.depth -= len(batch)
+    return batch
+}
+
+// takeAll removes every batch from the buffer and stops the timer, repo.mu must be held
+func (repo *BufferedTrackingRepository) takeAll() map[bufferKey][]*bufferedWrite {
+    if repo.timer != nil {
+        repo.timer.Stop()
+        repo.timer = nil
+    }
+    batches := repo.pending
+    repo.pending = map[bufferKey][]*bufferedWrite{}
+    repo.depth = 0
+    return batches
+}
+
+// remove takes a write out of the buffer, it reports false when its batch was taken already. repo.mu must be held.
+func (repo *BufferedTrackingRepository) remove(key bufferKey, write *bufferedWrite) bool {
+    i := slices.Index(repo.pending[key], write)
+    if i < 0 {
+        return false
+    }
+    repo.pending[key] = slices.Delete(repo.pending[key], i, i+1)
+    if len(repo.pending[key]) == 0 {
+        delete(repo.pending, key)
+    }
+    repo.depth--
+    return true
+}
+
+func (repo *BufferedTrackingRepository) writeAll(batches map[bufferKey][]*bufferedWrite) {
+    for key, batch := range batches {
+        repo.write(key, batch)
+    }
+}
+
+// write stores a batch with the tenant of its key and hands every write its result
+func (repo *BufferedTrackingRepository) write(key bufferKey, batch []*bufferedWrite) {
+    ctx, cancel := context.WithTimeout(context.Background(), repo.flushTimeout)
+    defer cancel()
+    if key.scoped {
+        ctx = tenant.WithID(ctx, key.tenantID)
+    }
+    records := make([]*TrackingRecord, len(batch))
+    for i, write := range batch {
+        records[i] = write.record
+    }
+    errs, err := repo.TrackingRepository.CreateManyTrackingData(ctx, records)
+    repo.flushes.Add(1)
+    for i, write := range batch {
+        writeErr := err
+        if writeErr == nil && errs != nil {
+            writeErr = errs[i]
+        }
+        if writeErr != nil {
+            repo.failed.Add(1)
+        } else {
+            repo.flushed.Add(1)
+        }
+        write.done <- writeErr
+    }
+}
@@ -0,0 +1,124 @@
+package repositories
+
+import (
+    "context"
+    "errors"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// batchingTrackingRepo is the in-memory repository recording the size of the batches it stored
+type batchingTrackingRepo struct {
+    *MemoryTrackingRepository
+    mu      sync.Mutex
+    batches []int
+}
+
+func (repo *batchingTrackingRepo) CreateManyTrackingData(
+    ctx context.Context,
+    trackingData []*TrackingRecord,
+) ([]error, error) {
+    repo.mu.Lock()
+    repo.batches = append(repo.batches, len(trackingData))
+    repo.mu.Unlock()
+    return repo.MemoryTrackingRepository.CreateManyTrackingData(ctx, trackingData)
+}
+
+func TestBufferedTrackingRepository_Batches(t *testing.T) {
+    inner := &batchingTrackingRepo{MemoryTrackingRepository: NewMemoryTrackingRepository()}
+    repo := NewBufferedTrackingRepository(inner, 3, time.Hour, time.Second)
+    acme, globex := tenant.WithID(context.Background(), "acme"), tenant.WithID(context.Background(), "globex")
+    vehicleID := primitive.NewObjectID()
+
+    // a full batch is written right away, the tenants are written apart
+    var wg sync.WaitGroup
+    errs := make(chan error, 4)
+    records := make([]*TrackingRecord, 4)
+    tenants := []context.Context{acme, globex, acme, acme}
+    for i, ctx := range tenants {
+        records[i] = newMemoryRecord(vehicleID, time.Now(), float64((i+1)*100))
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            errs <- repo.CreateTrackingData(ctx, records[i])
+        }()
+    }
+    deadline := time.Now().Add(time.Second)
+    for stats := repo.Stats(); stats.Depth != 1 || stats.Flushed != 3; stats = repo.Stats() {
+        if time.Now().After(deadline) {
+            t.Fatalf("Should write the full batch of the tenant and buffer the other, got %+v", stats)
+        }
+        time.Sleep(time.Millisecond)
+    }
+
+    repo.Close()
+    wg.Wait()
+    close(errs)
+    for err := range errs {
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+    if records[1].TenantID != "globex" || records[0].TenantID != "acme" {
+        t.Fatalf("Should store the records with their tenant, got %q and %q", records[1].TenantID, records[0].TenantID)
+    }
+    if len(inner.batches) != 2 || inner.batches[0] != 3 || inner.batches[1] != 1 {
+        t.Fatalf("Should write the buffered record on close, got batches %v", inner.batches)
+    }
+    for i, ctx := range tenants {
+        stored, err := inner.FindTrackingDataByID(ctx, records[i].ID.Hex())
+        if err != nil || stored.Mileage != records[i].Mileage {
+            t.Fatalf("Should store record %d for its tenant, got %v, %v", i, stored, err)
+        }
+    }
+    if _, err := inner.FindTrackingDataByID(acme, records[1].ID.Hex()); !errors.Is(err, ErrTrackingDataNotFound) {
+        t.Fatalf("Should not store the record of globex for acme, got %v", err)
+    }
+
+    // once closed the records are written right away
+    record := newMemoryRecord(vehicleID, time.Now(), 500)
+    if err := repo.CreateTrackingData(acme, record); err != nil {
+        t.Fatal(err)
+    }
+    if stats := repo.Stats(); stats.Depth != 0 || stats.Flushed != 4 {
+        t.Fatalf("Should write around the closed buffer, got %+v", stats)
+    }
+    if _, err := inner.FindTrackingDataByID(acme, record.ID.Hex()); err != nil {
+        t.Fatalf("Should store the record written around the buffer, got %v", err)
+    }
+}
+
+func TestBufferedTrackingRepository_MaxWait(t *testing.T) {
+    inner := NewMemoryTrackingRepository()
+    repo := NewBufferedTrackingRepository(inner, 100, 10*time.Millisecond, time.Second)
+    ctx := context.Background()
+    record := newMemoryRecord(primitive.NewObjectID(), time.Now(), 100)
+    if err := repo.CreateTrackingData(ctx, record); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := inner.FindTrackingDataByID(ctx, record.ID.Hex()); err != nil {
+        t.Fatalf("Should store the record before returning, got %v", err)
+    }
+    if err := repo.CreateTrackingData(ctx, record); !errors.Is(err, ErrDuplicate) {
+        t.Fatalf("Should return the error of the record in its batch, got %v", err)
+    }
+}
+
+func TestBufferedTrackingRepository_Canceled(t *testing.T) {
+    inner := NewMemoryTrackingRepository()
+    repo := NewBufferedTrackingRepository(inner, 100, time.Hour, time.Second)
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+    defer cancel()
+    record := newMemoryRecord(primitive.NewObjectID(), time.Now(), 100)
+    if err := repo.CreateTrackingData(ctx, record); !errors.Is(err, ErrTransient) {
+        t.Fatalf("Should give up on the record when the deadline expires, got %v", err)
+    }
+    repo.Close()
+    if stats := repo.Stats(); stats.Depth != 0 || stats.Flushes != 0 {
+        t.Fatalf("Should take the record out of the buffer, got %+v", stats)
+    }
+}
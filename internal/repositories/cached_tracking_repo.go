@@ -0,0 +1,300 @@
+package repositories
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "log"
+    "strconv"
+    "time"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/cache"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+    cacheKeyPrefix = "tracking:"
+    // allVehiclesVersion is bumped by every write, it versions the queries that aren't limited to a vehicle
+    allVehiclesVersion = "all"
+)
+
+// cachedRecords is the cached value, BSON keeps the records exactly as they are stored
+type cachedRecords struct {
+    Records []*TrackingRecord `bson:"records"`
+}
+
+// CachedTrackingRepository caches the latest tracking data and the results of FindTrackingData for a TTL.
+// Every cache key contains a version of the vehicle it is about, writes bump the version of their vehicles
+// so the cached results are never read again and expire on their own. Cache failures are logged and the
+// wrapped repository is used, so the cache is never required to serve a request.
+type CachedTrackingRepository struct {
+    TrackingRepository
+    cache cache.Cache
+    ttl   time.Duration
+}
+
+func NewCachedTrackingRepository(
+    trackingRepo TrackingRepository,
+    cache cache.Cache,
+    ttl time.Duration,
+) *CachedTrackingRepository {
+    return &CachedTrackingRepository{TrackingRepository: trackingRepo, cache: cache, ttl: ttl}
+}
+
+func (repo *CachedTrackingRepository) CreateTrackingData(ctx context.Context, trackingData *TrackingRecord) error {
+    if err := repo.TrackingRepository.CreateTrackingData(ctx, trackingData); err != nil {
+        return err
+    }
+    repo.invalidate(ctx, trackingData.VehicleID)
+    return nil
+}
+
+func (repo *CachedTrackingRepository) CreateManyTrackingData(
+    ctx context.Context,
+    trackingData []*TrackingRecord,
+) ([]error, error) {
+    errs, err := repo.TrackingRepository.CreateManyTrackingData(ctx, trackingData)
+    if err != nil {
+        return errs, err
+    }
+    var vehicleIDs []primitive.ObjectID
+    seen := make(map[primitive.ObjectID]bool, len(trackingData))
+    for i, data := range trackingData {
+        if (errs == nil || errs[i] == nil) && !seen[data.VehicleID] {
+            seen[data.VehicleID] = true
+            vehicleIDs = append(vehicleIDs, data.VehicleID)
+        }
+    }
+    repo.invalidate(ctx, vehicleIDs...)
+    return errs, nil
+}
+
+func (repo *CachedTrackingRepository) FindTrackingData(
+    ctx context.Context,
+    filter *TrackingFilter,
+) ([]*TrackingRecord, error) {
+    // the exported fields are the query as requested, the key is computed before Build fills the defaults.
+    // The restricted vehicles are part of the key, so users with different access never share a result.
+    query, err := json.Marshal(
+        struct {
+            *TrackingFilter
+            RestrictedVehicles []primitive.ObjectID `json:"restricted_vehicles"`
+        }{filter, filter.RestrictedVehicles()},
+    )
+    if err != nil {
+        return nil, err
+    }
+    // a selection of vehicles is versioned by every vehicle of it, the versions only grow so their sum changes
+    // whenever one of them is written
+    scope, scopes := allVehiclesVersion, []string{allVehiclesVersion}
+    if vehicleIDs, err := SplitValues("vehicle_id", filter.VehicleID); err == nil && len(vehicleIDs) > 0 {
+        scope, scopes = vehicleIDs[0], vehicleIDs
+        if len(vehicleIDs) > 1 {
+            scope = "selection"
+        }
+    }
+    versions, err := repo.versions(ctx, scopes...)
+    if err != nil {
+        log.Println("Failed to read tracking data cache versions", err)
+        return repo.TrackingRepository.FindTrackingData(ctx, filter)
+    }
+    var version int64
+    for _, v := range versions {
+        version += v
+    }
+    sum := sha256.Sum256(query)
+    key := fmt.Sprintf("%sfind:%s:%d:%s", resultKeyPrefix(ctx), scope, version, hex.EncodeToString(sum[:]))
+
+    if records, ok := repo.get(ctx, key); ok {
+        return records, nil
+    }
+    records, err := repo.TrackingRepository.FindTrackingData(ctx, filter)
+    if err != nil {
+        return nil, err
+    }
+    repo.set(ctx, key, records)
+    return records, nil
+}
+
+func (repo *CachedTrackingRepository) DeleteTrackingData(
+    ctx context.Context,
+    vehicleID primitive.ObjectID,
+    before time.Time,
+    purge bool,
+) (int64, error) {
+    deleted, err := repo.TrackingRepository.DeleteTrackingData(ctx, vehicleID, before, purge)
+    if err != nil {
+        return deleted, err
+    }
+    repo.invalidate(ctx, vehicleID)
+    return deleted, nil
+}
+
+func (repo *CachedTrackingRepository) FlagTrackingData(
+    ctx context.Context,
+    trackingData *TrackingRecord,
+    flag string,
+) error {
+    if err := repo.TrackingRepository.FlagTrackingData(ctx, trackingData, flag); err != nil {
+        return err
+    }
+    repo.invalidate(ctx, trackingData.VehicleID)
+    return nil
+}
+
+func (repo *CachedTrackingRepository) UpdateTrackingDistances(
+    ctx context.Context,
+    trackingData []*TrackingRecord,
+) error {
+    if err := repo.TrackingRepository.UpdateTrackingDistances(ctx, trackingData); err != nil {
+        return err
+    }
+    var vehicleIDs []primitive.ObjectID
+    seen := make(map[primitive.ObjectID]bool, len(trackingData))
+    for _, data := range trackingData {
+        if !seen[data.VehicleID] {
+            seen[data.VehicleID] = true
+            vehicleIDs = append(vehicleIDs, data.VehicleID)
+        }
+    }
+    repo.invalidate(ctx, vehicleIDs...)
+    return nil
+}
+
+// FindLatestTrackingData only asks the wrapped repository for the vehicles missing from the cache
+func (repo *CachedTrackingRepository) FindLatestTrackingData(
+    ctx context.Context,
+    limits []VehicleLimit,
+) (map[primitive.ObjectID][]*TrackingRecord, error) {
+    scopes := make([]string, len(limits))
+    for i, limit := range limits {
+        scopes[i] = limit.VehicleID.Hex()
+    }
+    versions, err := repo.versions(ctx, scopes...)
+    if err != nil {
+        log.Println("Failed to read tracking data cache versions", err)
+        return repo.TrackingRepository.FindLatestTrackingData(ctx, limits)
+    }
+
+    keys := make([]string, len(limits))
+    for i, limit := range limits {
+        keys[i] = fmt.Sprintf("%slatest:%s:%d:%d", resultKeyPrefix(ctx), scopes[i], versions[i], limit.Limit)
+    }
+    values, err := repo.cache.MGet(ctx, keys...)
+    if err != nil {
+        log.Println("Failed to read tracking data cache", err)
+        values = make([][]byte, len(keys))
+    }
+
+    results := make(map[primitive.ObjectID][]*TrackingRecord, len(limits))
+    var missing []VehicleLimit
+    missingKeys := make(map[primitive.ObjectID]string)
+    for i, limit := range limits {
+        if records, ok := repo.decode(values[i]); ok {
+            if len(records) > 0 {
+                results[limit.VehicleID] = records
+            }
+            continue
+        }
+        missing = append(missing, limit)
+        missingKeys[limit.VehicleID] = keys[i]
+    }
+    if len(missing) == 0 {
+        return results, nil
+    }
+
+    found, err := repo.TrackingRepository.FindLatestTrackingData(ctx, missing)
+    if err != nil {
+        return nil, err
+    }
+    for _, limit := range missing {
+        records := found[limit.VehicleID]
+        // vehicles without tracking data are cached too, so unknown vehicles don't always reach the database
+        repo.set(ctx, missingKeys[limit.VehicleID], records)
+        if len(records) > 0 {
+            results[limit.VehicleID] = records
+        }
+    }
+    return results, nil
+}
+
+// resultKeyPrefix prefixes the keys of cached results with the tenant of the context, so tenants never share a result
+func resultKeyPrefix(ctx context.Context) string {
+    if id := tenantOf(ctx); id != "" {
+        return cacheKeyPrefix + "tenant:" + id + ":"
+    }
+    return cacheKeyPrefix
+}
+
+// invalidate bumps the versions of the vehicles and of the queries over all vehicles
+func (repo *CachedTrackingRepository) invalidate(ctx context.Context, vehicleIDs ...primitive.ObjectID) {
+    if len(vehicleIDs) == 0 {
+        return
+    }
+    scopes := []string{allVehiclesVersion}
+    for _, vehicleID := range vehicleIDs {
+        scopes = append(scopes, vehicleID.Hex())
+    }
+    for _, scope := range scopes {
+        if _, err := repo.cache.Incr(ctx, cacheKeyPrefix+"version:"+scope); err != nil {
+            log.Println("Failed to invalidate tracking data cache", err)
+        }
+    }
+}
+
+// versions returns the current version of every scope, 0 for scopes that were never written
+func (repo *CachedTrackingRepository) versions(ctx context.Context, scopes ...string) ([]int64, error) {
+    keys := make([]string, len(scopes))
+    for i, scope := range scopes {
+        keys[i] = cacheKeyPrefix + "version:" + scope
+    }
+    values, err := repo.cache.MGet(ctx, keys...)
+    if err != nil {
+        return nil, err
+    }
+    versions := make([]int64, len(scopes))
+    for i, value := range values {
+        if value == nil {
+            continue
+        }
+        if versions[i], err = strconv.ParseInt(string(value), 10, 64); err != nil {
+            return nil, err
+        }
+    }
+    return versions, nil
+}
+
+func (repo *CachedTrackingRepository) get(ctx context.Context, key string) ([]*TrackingRecord, bool) {
+    value, err := repo.cache.Get(ctx, key)
+    if err != nil {
+        log.Println("Failed to read tracking data cache", err)
+        return nil, false
+    }
+    return repo.decode(value)
+}
+
+func (repo *CachedTrackingRepository) decode(value []byte) ([]*TrackingRecord, bool) {
+    if value == nil {
+        return nil, false
+    }
+    var cached cachedRecords
+    if err := bson.Unmarshal(value, &cached); err != nil {
+        log.Println("Failed to decode cached tracking data", err)
+        return nil, false
+    }
+    return cached.Records, true
+}
+
+func (repo *CachedTrackingRepository) set(ctx context.Context, key string, records []*TrackingRecord) {
+    value, err := bson.Marshal(cachedRecords{Records: records})
+    if err != nil {
+        log.Println("Failed to encode tracking data for the cache", err)
+        return
+    }
+    if err := repo.cache.Set(ctx, key, value, repo.ttl); err != nil {
+        log.Println("Failed to write tracking data cache", err)
+    }
+}
@@ -0,0 +1,147 @@
+package repositories
+
+import (
+    "context"
+    "strconv"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type memoryCache struct {
+    mu     sync.Mutex
+    values map[string][]byte
+}
+
+func (c *memoryCache) Get(_ context.Context, key string) ([]byte, error) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.values[key], nil
+}
+
+func (c *memoryCache) MGet(ctx context.Context, keys ...string) ([][]byte, error) {
+    values := make([][]byte, len(keys))
+    for i, key := range keys {
+        values[i], _ = c.Get(ctx, key)
+    }
+    return values, nil
+}
+
+func (c *memoryCache) Set(_ context.Context, key string, value []byte, _ time.Duration) error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.values[key] = value
+    return nil
+}
+
+func (c *memoryCache) Incr(_ context.Context, key string) (int64, error) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    value, _ := strconv.ParseInt(string(c.values[key]), 10, 64)
+    value++
+    c.values[key] = []byte(strconv.FormatInt(value, 10))
+    return value, nil
+}
+
+// countingTrackingRepo keeps the tracking data in memory and counts the reads reaching it
+type countingTrackingRepo struct {
+    TrackingRepository
+    records []*TrackingRecord
+    reads   int
+}
+
+func (repo *countingTrackingRepo) CreateTrackingData(ctx context.Context, trackingData *TrackingRecord) error {
+    trackingData.TenantID = tenantOf(ctx)
+    repo.records = append(repo.records, trackingData)
+    return nil
+}
+
+func (repo *countingTrackingRepo) FindLatestTrackingData(
+    ctx context.Context,
+    limits []VehicleLimit,
+) (map[primitive.ObjectID][]*TrackingRecord, error) {
+    repo.reads++
+    results := map[primitive.ObjectID][]*TrackingRecord{}
+    for _, limit := range limits {
+        for i := len(repo.records) - 1; i >= 0 && len(results[limit.VehicleID]) < limit.Limit; i-- {
+            if repo.records[i].VehicleID == limit.VehicleID && repo.records[i].TenantID == tenantOf(ctx) {
+                results[limit.VehicleID] = append(results[limit.VehicleID], repo.records[i])
+            }
+        }
+    }
+    return results, nil
+}
+
+func TestCachedTrackingRepository_FindLatestTrackingData(t *testing.T) {
+    ctx := context.Background()
+    inner := &countingTrackingRepo{}
+    repo := NewCachedTrackingRepository(inner, &memoryCache{values: map[string][]byte{}}, time.Minute)
+
+    vehicleID := primitive.NewObjectID()
+    first := &TrackingRecord{}
+    first.VehicleID = vehicleID
+    first.Location = "first"
+    if err := repo.CreateTrackingData(ctx, first); err != nil {
+        t.Fatal(err)
+    }
+
+    limits := []VehicleLimit{{VehicleID: vehicleID, Limit: 1}}
+    for i := 0; i < 2; i++ {
+        results, err := repo.FindLatestTrackingData(ctx, limits)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(results[vehicleID]) != 1 || results[vehicleID][0].Location != "first" {
+            t.Fatalf("Should find the first record, got %v", results[vehicleID])
+        }
+    }
+    if inner.reads != 1 {
+        t.Fatalf("Should read the repository once, got %d reads", inner.reads)
+    }
+
+    second := &TrackingRecord{}
+    second.VehicleID = vehicleID
+    second.Location = "second"
+    if err := repo.CreateTrackingData(ctx, second); err != nil {
+        t.Fatal(err)
+    }
+    results, err := repo.FindLatestTrackingData(ctx, limits)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(results[vehicleID]) != 1 || results[vehicleID][0].Location != "second" {
+        t.Fatalf("Should find the second record after the write, got %v", results[vehicleID])
+    }
+    if inner.reads != 2 {
+        t.Fatalf("Should read the repository again after the write, got %d reads", inner.reads)
+    }
+}
+
+func TestCachedTrackingRepository_TenantsDontShareResults(t *testing.T) {
+    acme := tenant.WithID(context.Background(), "acme")
+    other := tenant.WithID(context.Background(), "other")
+    inner := &countingTrackingRepo{}
+    repo := NewCachedTrackingRepository(inner, &memoryCache{values: map[string][]byte{}}, time.Minute)
+
+    vehicleID := primitive.NewObjectID()
+    record := &TrackingRecord{}
+    record.VehicleID = vehicleID
+    if err := repo.CreateTrackingData(acme, record); err != nil {
+        t.Fatal(err)
+    }
+
+    limits := []VehicleLimit{{VehicleID: vehicleID, Limit: 1}}
+    if results, err := repo.FindLatestTrackingData(acme, limits); err != nil || len(results[vehicleID]) != 1 {
+        t.Fatalf("Should find the record of the tenant, got %v, %v", results[vehicleID], err)
+    }
+    results, err := repo.FindLatestTrackingData(other, limits)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(results[vehicleID]) != 0 {
+        t.Fatalf("Should not find the cached record of another tenant, got %v", results[vehicleID])
+    }
+}
@@ -0,0 +1,216 @@
+package repositories
+
+import (
+    "context"
+    "errors"
+    "log"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+    // MonthLayout is the layout of the months of the SLA report
+    MonthLayout = "2006-01"
+    // MaxConnectivityMonths is the number of months an SLA report covers at most
+    MaxConnectivityMonths = 24
+)
+
+var (
+    ErrInvalidMonthRange = errors.New("invalid month range, from and to must be months like 2024-01, from up to 24 months before to")
+)
+
+// ConnectivityRollup is the connectivity of a vehicle during a UTC day, the time of the day covered by readings
+// arriving within the expected interval of the vehicle
+type ConnectivityRollup struct {
+    VehicleID primitive.ObjectID `json:"vehicle_id" bson:"vehicle_id"`
+    TenantID  string             `json:"tenant_id,omitempty" bson:"tenant_id,omitempty"`
+    // Day is midnight UTC of the day
+    Day                     timestamp.Time `json:"day" bson:"day"`
+    Readings                int            `json:"readings" bson:"readings"`
+    ConnectedSeconds        float64        `json:"connected_seconds" bson:"connected_seconds"`
+    ExpectedIntervalSeconds float64        `json:"expected_interval_seconds" bson:"expected_interval_seconds"`
+    // Connectivity is the percentage of the day the vehicle was connected
+    Connectivity float64        `json:"connectivity" bson:"connectivity"`
+    ComputedAt   timestamp.Time `json:"computed_at" bson:"computed_at"`
+}
+
+type ConnectivityFilter struct {
+    VehicleID string `json:"vehicle_id"`
+    From      string `json:"from" doc:"First month of the report, e.g. 2024-01, the current month by default"`
+    To        string `json:"to" doc:"Last month of the report, inclusive, the from month by default"`
+
+    vehicleID  primitive.ObjectID
+    vehicleIDs []primitive.ObjectID
+    from       time.Time
+    to         time.Time
+}
+
+// RestrictVehicles limits the report to the given vehicles, on top of the vehicle_id filter
+func (f *ConnectivityFilter) RestrictVehicles(vehicleIDs []primitive.ObjectID) {
+    f.vehicleIDs = vehicleIDs
+}
+
+// Months returns the start of the first month of the report and the end of its last month
+func (f *ConnectivityFilter) Months() (time.Time, time.Time) {
+    return f.from, f.to
+}
+
+// Build validates the filter, the months default to the current one at now
+func (f *ConnectivityFilter) Build(now time.Time) error {
+    if f.VehicleID != "" {
+        id, err := primitive.ObjectIDFromHex(f.VehicleID)
+        if err != nil {
+            return ErrInvalidID
+        }
+        f.vehicleID = id
+    }
+    now = now.UTC()
+    f.from = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+    if f.From != "" {
+        from, err := time.Parse(MonthLayout, f.From)
+        if err != nil {
+            return ErrInvalidMonthRange
+        }
+        f.from = from
+    }
+    last := f.from
+    if f.To != "" {
+        to, err := time.Parse(MonthLayout, f.To)
+        if err != nil {
+            return ErrInvalidMonthRange
+        }
+        last = to
+    }
+    if last.Before(f.from) || last.After(f.from.AddDate(0, MaxConnectivityMonths-1, 0)) {
+        return ErrInvalidMonthRange
+    }
+    f.to = last.AddDate(0, 1, 0)
+    return nil
+}
+
+// match selects the rollups of the vehicles of the filter
+func (f *ConnectivityFilter) match(ctx context.Context) bson.M {
+    match := scopeTenant(ctx, bson.M{})
+    switch {
+    case f.vehicleIDs != nil && !f.vehicleID.IsZero():
+        match["vehicle_id"] = bson.M{"$in": intersect(f.vehicleIDs, f.vehicleID)}
+    case f.vehicleIDs != nil:
+        match["vehicle_id"] = bson.M{"$in": f.vehicleIDs}
+    case !f.vehicleID.IsZero():
+        match["vehicle_id"] = f.vehicleID
+    }
+    return match
+}
+
+// intersect returns vehicleID when it is one of vehicleIDs, an empty list otherwise
+func intersect(vehicleIDs []primitive.ObjectID, vehicleID primitive.ObjectID) []primitive.ObjectID {
+    for _, id := range vehicleIDs {
+        if id == vehicleID {
+            return []primitive.ObjectID{vehicleID}
+        }
+    }
+    return []primitive.ObjectID{}
+}
+
+type ConnectivityRepository interface {
+    // SaveRollup stores the rollup of the vehicle and day, replacing the one computed before
+    SaveRollup(ctx context.Context, rollup *ConnectivityRollup) error
+    // FindRollups returns the rollups of the vehicles of the filter during its months, ordered by vehicle and day
+    FindRollups(ctx context.Context, filter *ConnectivityFilter) ([]*ConnectivityRollup, error)
+    // FindTrackedSince returns the first day with a rollup of every vehicle of the filter, of any month
+    FindTrackedSince(ctx context.Context, filter *ConnectivityFilter) (map[primitive.ObjectID]time.Time, error)
+}
+
+type MongoConnectivityRepository struct {
+    collection *mongo.Collection
+}
+
+func NewMongoConnectivityRepository(db *mongo.Database) *MongoConnectivityRepository {
+    return &MongoConnectivityRepository{
+        collection: db.Collection("connectivity_rollups"),
+    }
+}
+
+func (repo *MongoConnectivityRepository) SaveRollup(ctx context.Context, rollup *ConnectivityRollup) error {
+    rollup.TenantID = tenantOf(ctx)
+    rollup.ComputedAt = timestamp.Now()
+    _, err := repo.collection.ReplaceOne(
+        ctx,
+        scopeTenant(ctx, bson.M{"vehicle_id": rollup.VehicleID, "day": rollup.Day}),
+        rollup,
+        options.Replace().SetUpsert(true),
+    )
+    return classify(err)
+}
+
+func (repo *MongoConnectivityRepository) FindRollups(
+    ctx context.Context,
+    filter *ConnectivityFilter,
+) ([]*ConnectivityRollup, error) {
+    match := filter.match(ctx)
+    match["day"] = bson.M{"$gte": filter.from, "$lt": filter.to}
+    cursor, err := repo.collection.Find(
+        ctx,
+        match,
+        options.Find().SetSort(bson.D{{Key: "vehicle_id", Value: 1}, {Key: "day", Value: 1}}),
+    )
+    if err != nil {
+        return nil, classify(err)
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
+        }
+    }(cursor, ctx)
+
+    var rollups []*ConnectivityRollup
+    for cursor.Next(ctx) {
+        var rollup ConnectivityRollup
+        if err := cursor.Decode(&rollup); err != nil {
+            return nil, err
+        }
+        rollups = append(rollups, &rollup)
+    }
+    return rollups, classify(cursor.Err())
+}
+
+func (repo *MongoConnectivityRepository) FindTrackedSince(
+    ctx context.Context,
+    filter *ConnectivityFilter,
+) (map[primitive.ObjectID]time.Time, error) {
+    cursor, err := repo.collection.Aggregate(
+        ctx,
+        mongo.Pipeline{
+            {{Key: "$match", Value: filter.match(ctx)}},
+            {{Key: "$group", Value: bson.M{"_id": "$vehicle_id", "since": bson.M{"$min": "$day"}}}},
+        },
+    )
+    if err != nil {
+        return nil, classify(err)
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
+        }
+    }(cursor, ctx)
+
+    since := map[primitive.ObjectID]time.Time{}
+    for cursor.Next(ctx) {
+        var first struct {
+            VehicleID primitive.ObjectID `bson:"_id"`
+            Since     time.Time          `bson:"since"`
+        }
+        if err := cursor.Decode(&first); err != nil {
+            return nil, err
+        }
+        since[first.VehicleID] = first.Since.UTC()
+    }
+    return since, classify(cursor.Err())
+}
@@ -0,0 +1,40 @@
+package repositories
+
+import (
+    "errors"
+    "testing"
+    "time"
+)
+
+func TestConnectivityFilter_Build(t *testing.T) {
+    now := time.Date(2024, 5, 17, 8, 0, 0, 0, time.UTC)
+    filter := &ConnectivityFilter{}
+    if err := filter.Build(now); err != nil {
+        t.Fatal(err)
+    }
+    from, to := filter.Months()
+    if !from.Equal(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)) || !to.Equal(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)) {
+        t.Fatalf("Should default to the current month, got %v and %v", from, to)
+    }
+
+    filter = &ConnectivityFilter{From: "2023-11", To: "2024-02"}
+    if err := filter.Build(now); err != nil {
+        t.Fatal(err)
+    }
+    if _, to = filter.Months(); !to.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)) {
+        t.Fatalf("Should end after the to month, got %v", to)
+    }
+
+    for _, filter := range []*ConnectivityFilter{
+        {From: "2024-13"},
+        {From: "2024-03", To: "2024-02"},
+        {From: "2022-01", To: "2024-01"},
+        {VehicleID: "42"},
+    } {
+        if err := filter.Build(now); err == nil {
+            t.Errorf("Should reject %+v", filter)
+        } else if filter.VehicleID == "" && !errors.Is(err, ErrInvalidMonthRange) {
+            t.Errorf("Should reject %+v as an invalid month range, got %v", filter, err)
+        }
+    }
+}
@@ -0,0 +1,121 @@
+package repositories
+
+import (
+    "context"
+    "log"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+    // DeletionModeSoft flags the tracking data as deleted, it is excluded from every query but can be restored
+    DeletionModeSoft = "soft"
+    // DeletionModePurge removes the tracking data for good, including soft deleted data
+    DeletionModePurge = "purge"
+)
+
+// DeletionAudit records who deleted which tracking data, it is kept after the data is purged
+type DeletionAudit struct {
+    ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+    TenantID  string             `json:"tenant_id,omitempty" bson:"tenant_id,omitempty"`
+    VehicleID primitive.ObjectID `json:"vehicle_id" bson:"vehicle_id"`
+    // Before is the end of the deleted time range, nil when all the tracking data of the vehicle was deleted
+    Before      *timestamp.Time `json:"before" bson:"before,omitempty"`
+    Mode        string          `json:"mode" bson:"mode"`
+    Deleted     int64           `json:"deleted" bson:"deleted"`
+    RequestedBy string          `json:"requested_by" bson:"requested_by"`
+    CreatedAt   timestamp.Time  `json:"created_at" bson:"created_at"`
+}
+
+type DeletionAuditFilter struct {
+    Page      int    `json:"page"`
+    PageSize  int    `json:"limit"`
+    VehicleID string `json:"vehicle_id"`
+
+    vehicleID primitive.ObjectID
+}
+
+func (f *DeletionAuditFilter) Build() error {
+    if f.Page == 0 {
+        f.Page = 1
+    }
+    f.PageSize = pageSize(f.PageSize)
+    if f.VehicleID != "" {
+        id, err := primitive.ObjectIDFromHex(f.VehicleID)
+        if err != nil {
+            return ErrInvalidID
+        }
+        f.vehicleID = id
+    }
+    return nil
+}
+
+type DeletionAuditRepository interface {
+    CreateDeletionAudit(ctx context.Context, audit *DeletionAudit) error
+    FindDeletionAudits(ctx context.Context, filter *DeletionAuditFilter) ([]*DeletionAudit, error)
+}
+
+type MongoDeletionAuditRepository struct {
+    collection *mongo.Collection
+}
+
+func NewMongoDeletionAuditRepository(db *mongo.Database) *MongoDeletionAuditRepository {
+    return &MongoDeletionAuditRepository{
+        collection: db.Collection("deletion_audits"),
+    }
+}
+
+func (repo *MongoDeletionAuditRepository) CreateDeletionAudit(ctx context.Context, audit *DeletionAudit) error {
+    if audit.CreatedAt.IsZero() {
+        audit.CreatedAt = timestamp.Now()
+    }
+    audit.TenantID = tenantOf(ctx)
+    result, err := repo.collection.InsertOne(ctx, audit)
+    if err != nil {
+        return err
+    }
+    audit.ID = result.InsertedID.(primitive.ObjectID)
+    return nil
+}
+
+// FindDeletionAudits finds the deletions newest first
+func (repo *MongoDeletionAuditRepository) FindDeletionAudits(
+    ctx context.Context,
+    filter *DeletionAuditFilter,
+) ([]*DeletionAudit, error) {
+    var audits []*DeletionAudit
+    bsonMFilter := scopeTenant(ctx, bson.M{})
+    findOptions := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}})
+    if filter != nil {
+        if err := filter.Build(); err != nil {
+            return nil, err
+        }
+        if !filter.vehicleID.IsZero() {
+            bsonMFilter["vehicle_id"] = filter.vehicleID
+        }
+        findOptions.SetSkip(int64((filter.Page - 1) * filter.PageSize))
+        findOptions.SetLimit(int64(filter.PageSize))
+    }
+    cursor, err := repo.collection.Find(ctx, bsonMFilter, findOptions)
+    if err != nil {
+        return nil, err
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
+        }
+    }(cursor, ctx)
+    for cursor.Next(ctx) {
+        var audit DeletionAudit
+        if err := cursor.Decode(&audit); err != nil {
+            return nil, err
+        }
+        audits = append(audits, &audit)
+    }
+    return audits, nil
+}
@@ -0,0 +1,139 @@
+package repositories
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrDigestSubscriptionNotFound = fmt.Errorf("digest subscription %w", ErrNotFound)
+
+// DigestSubscription opts a tenant in to the digest emails of its fleet, sent for every one of its periods to its
+// recipients. Sent is the start of the latest period sent by period, the tenant is empty without multi-tenancy.
+type DigestSubscription struct {
+    TenantID   string                    `json:"tenant_id,omitempty" bson:"tenant_id"`
+    Periods    []string                  `json:"periods" bson:"periods"`
+    Recipients []string                  `json:"recipients" bson:"recipients"`
+    Active     bool                      `json:"active" bson:"active"`
+    Sent       map[string]timestamp.Time `json:"sent,omitempty" bson:"sent,omitempty"`
+    UpdatedAt  timestamp.Time            `json:"updated_at" bson:"updated_at"`
+}
+
+type DigestRepository interface {
+    // FindDigestSubscription returns the subscription of the tenant of the context
+    FindDigestSubscription(ctx context.Context) (*DigestSubscription, error)
+    // SaveDigestSubscription creates or replaces the subscription of the tenant of the context, keeping what was sent
+    SaveDigestSubscription(ctx context.Context, subscription *DigestSubscription) error
+    // FindActiveDigestSubscriptions returns the active subscriptions of every tenant
+    FindActiveDigestSubscriptions(ctx context.Context) ([]*DigestSubscription, error)
+    // ClaimDigest marks the digest of the period starting at from sent to the tenant, false when it was claimed
+    // already, so a digest is sent by a single instance
+    ClaimDigest(ctx context.Context, tenantID string, period string, from time.Time) (bool, error)
+}
+
+type MongoDigestRepository struct {
+    collection *mongo.Collection
+}
+
+func NewMongoDigestRepository(db *mongo.Database) *MongoDigestRepository {
+    return &MongoDigestRepository{collection: db.Collection("digest_subscriptions")}
+}
+
+// CreateIndexes creates the unique index of the subscription of every tenant
+func (repo *MongoDigestRepository) CreateIndexes(ctx context.Context) error {
+    _, err := repo.collection.Indexes().CreateOne(
+        ctx,
+        mongo.IndexModel{
+            Keys:    bson.D{{Key: "tenant_id", Value: 1}},
+            Options: options.Index().SetUnique(true),
+        },
+    )
+    return classify(err)
+}
+
+func (repo *MongoDigestRepository) FindDigestSubscription(ctx context.Context) (*DigestSubscription, error) {
+    var subscription DigestSubscription
+    err := repo.collection.FindOne(ctx, bson.M{"tenant_id": tenantOf(ctx)}).Decode(&subscription)
+    if errors.Is(err, mongo.ErrNoDocuments) {
+        return nil, ErrDigestSubscriptionNotFound
+    }
+    if err != nil {
+        return nil, classify(err)
+    }
+    return &subscription, nil
+}
+
+func (repo *MongoDigestRepository) SaveDigestSubscription(
+    ctx context.Context,
+    subscription *DigestSubscription,
+) error {
+    subscription.TenantID = tenantOf(ctx)
+    subscription.UpdatedAt = timestamp.Now()
+    var saved DigestSubscription
+    err := repo.collection.FindOneAndUpdate(
+        ctx,
+        bson.M{"tenant_id": subscription.TenantID},
+        bson.M{
+            "$set": bson.M{
+                "periods":    subscription.Periods,
+                "recipients": subscription.Recipients,
+                "active":     subscription.Active,
+                "updated_at": subscription.UpdatedAt,
+            },
+        },
+        options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+    ).Decode(&saved)
+    if err != nil {
+        return classify(err)
+    }
+    subscription.Sent = saved.Sent
+    return nil
+}
+
+func (repo *MongoDigestRepository) FindActiveDigestSubscriptions(ctx context.Context) ([]*DigestSubscription, error) {
+    cursor, err := repo.collection.Find(ctx, bson.M{"active": true})
+    if err != nil {
+        return nil, classify(err)
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
+        }
+    }(cursor, ctx)
+
+    subscriptions := make([]*DigestSubscription, 0)
+    if err = cursor.All(ctx, &subscriptions); err != nil {
+        return nil, classify(err)
+    }
+    return subscriptions, nil
+}
+
+func (repo *MongoDigestRepository) ClaimDigest(
+    ctx context.Context,
+    tenantID string,
+    period string,
+    from time.Time,
+) (bool, error) {
+    sent := "sent." + period
+    result, err := repo.collection.UpdateOne(
+        ctx,
+        bson.M{
+            "tenant_id": tenantID,
+            "active":    true,
+            "$or":       bson.A{bson.M{sent: bson.M{"$exists": false}}, bson.M{sent: bson.M{"$lt": from}}},
+        },
+        bson.M{"$set": bson.M{sent: from}},
+    )
+    if err != nil {
+        return false, classify(err)
+    }
+    return result.ModifiedCount == 1, nil
+}
@@ -0,0 +1,107 @@
+package repositories
+
+import (
+    "context"
+    "log"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DisclosureAudit records which aggregate statistics were disclosed to a partner and with which privacy
+// parameters, so every release of the public statistics can be accounted for
+type DisclosureAudit struct {
+    ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+    Partner     string             `json:"partner" bson:"partner"`
+    From        timestamp.Time     `json:"from" bson:"from"`
+    To          timestamp.Time     `json:"to" bson:"to"`
+    ZoneDegrees float64            `json:"zone_degrees" bson:"zone_degrees"`
+    Epsilon     float64            `json:"epsilon" bson:"epsilon"`
+    MinVehicles int                `json:"min_vehicles" bson:"min_vehicles"`
+    // Disclosed is the number of zone hours returned and Suppressed the number withheld for having too few vehicles
+    Disclosed  int            `json:"disclosed" bson:"disclosed"`
+    Suppressed int            `json:"suppressed" bson:"suppressed"`
+    CreatedAt  timestamp.Time `json:"created_at" bson:"created_at"`
+}
+
+type DisclosureAuditFilter struct {
+    Page     int    `json:"page"`
+    PageSize int    `json:"limit"`
+    Partner  string `json:"partner"`
+}
+
+func (f *DisclosureAuditFilter) Build() error {
+    if f.Page == 0 {
+        f.Page = 1
+    }
+    f.PageSize = pageSize(f.PageSize)
+    return nil
+}
+
+type DisclosureAuditRepository interface {
+    CreateDisclosureAudit(ctx context.Context, audit *DisclosureAudit) error
+    FindDisclosureAudits(ctx context.Context, filter *DisclosureAuditFilter) ([]*DisclosureAudit, error)
+}
+
+type MongoDisclosureAuditRepository struct {
+    collection *mongo.Collection
+}
+
+func NewMongoDisclosureAuditRepository(db *mongo.Database) *MongoDisclosureAuditRepository {
+    return &MongoDisclosureAuditRepository{
+        collection: db.Collection("disclosure_audits"),
+    }
+}
+
+func (repo *MongoDisclosureAuditRepository) CreateDisclosureAudit(ctx context.Context, audit *DisclosureAudit) error {
+    if audit.CreatedAt.IsZero() {
+        audit.CreatedAt = timestamp.Now()
+    }
+    result, err := repo.collection.InsertOne(ctx, audit)
+    if err != nil {
+        return err
+    }
+    audit.ID = result.InsertedID.(primitive.ObjectID)
+    return nil
+}
+
+// FindDisclosureAudits finds the disclosures newest first
+func (repo *MongoDisclosureAuditRepository) FindDisclosureAudits(
+    ctx context.Context,
+    filter *DisclosureAuditFilter,
+) ([]*DisclosureAudit, error) {
+    var audits []*DisclosureAudit
+    bsonMFilter := bson.M{}
+    findOptions := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}})
+    if filter != nil {
+        if err := filter.Build(); err != nil {
+            return nil, err
+        }
+        if filter.Partner != "" {
+            bsonMFilter["partner"] = filter.Partner
+        }
+        findOptions.SetSkip(int64((filter.Page - 1) * filter.PageSize))
+        findOptions.SetLimit(int64(filter.PageSize))
+    }
+    cursor, err := repo.collection.Find(ctx, bsonMFilter, findOptions)
+    if err != nil {
+        return nil, err
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
+        }
+    }(cursor, ctx)
+    for cursor.Next(ctx) {
+        var audit DisclosureAudit
+        if err := cursor.Decode(&audit); err != nil {
+            return nil, err
+        }
+        audits = append(audits, &audit)
+    }
+    return audits, nil
+}
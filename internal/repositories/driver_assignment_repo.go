@@ -0,0 +1,122 @@
+package repositories
+
+import (
+    "context"
+    "log"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DriverAssignment is the driver currently driving a vehicle, the readings of the vehicle sent without a driver are
+// attributed to them
+type DriverAssignment struct {
+    VehicleID primitive.ObjectID `json:"vehicle_id" bson:"vehicle_id"`
+    TenantID  string             `json:"tenant_id,omitempty" bson:"tenant_id,omitempty"`
+    DriverID  string             `json:"driver_id" bson:"driver_id"`
+    UpdatedAt timestamp.Time     `json:"updated_at" bson:"updated_at"`
+}
+
+type DriverAssignmentRepository interface {
+    // FindDriverAssignment returns the assignment of the vehicle, ErrNotFound when no driver is assigned to it
+    FindDriverAssignment(ctx context.Context, vehicleID primitive.ObjectID) (*DriverAssignment, error)
+    FindDriverAssignments(ctx context.Context) ([]*DriverAssignment, error)
+    UpsertDriverAssignment(ctx context.Context, assignment *DriverAssignment) error
+    // DeleteDriverAssignment unassigns the driver of the vehicle, ErrNotFound when no driver is assigned to it
+    DeleteDriverAssignment(ctx context.Context, vehicleID primitive.ObjectID) error
+}
+
+type MongoDriverAssignmentRepository struct {
+    collection *mongo.Collection
+}
+
+func NewMongoDriverAssignmentRepository(db *mongo.Database) *MongoDriverAssignmentRepository {
+    return &MongoDriverAssignmentRepository{collection: db.Collection("driver_assignments")}
+}
+
+// CreateIndexes creates the unique index of the assignment of every vehicle
+func (repo *MongoDriverAssignmentRepository) CreateIndexes(ctx context.Context) error {
+    _, err := repo.collection.Indexes().CreateOne(
+        ctx,
+        mongo.IndexModel{
+            Keys:    bson.D{{Key: "tenant_id", Value: 1}, {Key: "vehicle_id", Value: 1}},
+            Options: options.Index().SetUnique(true),
+        },
+    )
+    return classify(err)
+}
+
+func (repo *MongoDriverAssignmentRepository) FindDriverAssignment(
+    ctx context.Context,
+    vehicleID primitive.ObjectID,
+) (*DriverAssignment, error) {
+    var assignment DriverAssignment
+    if err := repo.collection.FindOne(ctx, repo.match(ctx, vehicleID)).Decode(&assignment); err != nil {
+        return nil, classify(err)
+    }
+    return &assignment, nil
+}
+
+func (repo *MongoDriverAssignmentRepository) FindDriverAssignments(ctx context.Context) ([]*DriverAssignment, error) {
+    var assignments []*DriverAssignment
+    cursor, err := repo.collection.Find(
+        ctx,
+        scopeTenant(ctx, bson.M{}),
+        options.Find().SetSort(bson.D{{Key: "vehicle_id", Value: 1}}),
+    )
+    if err != nil {
+        return nil, classify(err)
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
+        }
+    }(cursor, ctx)
+    for cursor.Next(ctx) {
+        var assignment DriverAssignment
+        if err := cursor.Decode(&assignment); err != nil {
+            return nil, err
+        }
+        assignments = append(assignments, &assignment)
+    }
+    return assignments, cursor.Err()
+}
+
+func (repo *MongoDriverAssignmentRepository) UpsertDriverAssignment(
+    ctx context.Context,
+    assignment *DriverAssignment,
+) error {
+    assignment.TenantID = tenantOf(ctx)
+    assignment.UpdatedAt = timestamp.Now()
+    _, err := repo.collection.UpdateOne(
+        ctx,
+        repo.match(ctx, assignment.VehicleID),
+        bson.M{"$set": bson.M{"driver_id": assignment.DriverID, "updated_at": assignment.UpdatedAt}},
+        options.Update().SetUpsert(true),
+    )
+    return classify(err)
+}
+
+func (repo *MongoDriverAssignmentRepository) DeleteDriverAssignment(
+    ctx context.Context,
+    vehicleID primitive.ObjectID,
+) error {
+    result, err := repo.collection.DeleteOne(ctx, repo.match(ctx, vehicleID))
+    if err != nil {
+        return classify(err)
+    }
+    if result.DeletedCount == 0 {
+        return ErrNotFound
+    }
+    return nil
+}
+
+// match selects the assignment of the vehicle of the tenant of the context, the upserted assignments get the
+// tenant of the match
+func (repo *MongoDriverAssignmentRepository) match(ctx context.Context, vehicleID primitive.ObjectID) bson.M {
+    return scopeTenant(ctx, bson.M{"vehicle_id": vehicleID})
+}
@@ -0,0 +1,58 @@
+package repositories
+
+import (
+    "errors"
+    "fmt"
+
+    "go.mongodb.org/mongo-driver/mongo"
+)
+
+// The kinds of storage failures, the errors of the repositories wrap one of them when the kind is known so callers
+// can tell a retryable failure from a permanent one with errors.Is
+var (
+    ErrNotFound  = errors.New("not found")
+    ErrDuplicate = errors.New("duplicate")
+    // ErrTransient failures, like timeouts, lost connections and primary elections, may succeed when retried
+    ErrTransient = errors.New("transient storage failure")
+)
+
+// transientLabels are the error labels Mongo puts on failures that are safe to retry
+var transientLabels = []string{"RetryableWriteError", "TransientTransactionError", "NetworkError"}
+
+// classify wraps a Mongo error with the kind of failure it is, other errors are returned as they are
+func classify(err error) error {
+    switch {
+    case err == nil, errors.Is(err, ErrNotFound), errors.Is(err, ErrDuplicate), errors.Is(err, ErrTransient):
+        return err
+    case errors.Is(err, mongo.ErrNoDocuments):
+        return fmt.Errorf("%w: %w", ErrNotFound, err)
+    case mongo.IsDuplicateKeyError(err):
+        return fmt.Errorf("%w: %w", ErrDuplicate, err)
+    case isTransient(err):
+        return fmt.Errorf("%w: %w", ErrTransient, err)
+    }
+    return err
+}
+
+func isTransient(err error) bool {
+    if mongo.IsTimeout(err) || mongo.IsNetworkError(err) || errors.Is(err, mongo.ErrClientDisconnected) {
+        return true
+    }
+    var labeled mongo.LabeledError
+    if errors.As(err, &labeled) {
+        for _, label := range transientLabels {
+            if labeled.HasErrorLabel(label) {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// classifyWriteError classifies a failed write of a bulk insert, duplicate key errors are ErrDuplicate
+func classifyWriteError(writeErr mongo.BulkWriteError) error {
+    if mongo.IsDuplicateKeyError(writeErr) {
+        return fmt.Errorf("%w: %s", ErrDuplicate, writeErr.Message)
+    }
+    return errors.New(writeErr.Message)
+}
@@ -0,0 +1,50 @@
+package repositories
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "testing"
+
+    "go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestClassify(t *testing.T) {
+    invalid := errors.New("invalid tracking data")
+    tests := []struct {
+        name     string
+        err      error
+        expected error
+    }{
+        {"no documents", mongo.ErrNoDocuments, ErrNotFound},
+        {"duplicate key", mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 11000}}}, ErrDuplicate},
+        {"deadline", fmt.Errorf("aggregate: %w", context.DeadlineExceeded), ErrTransient},
+        {"network", mongo.CommandError{Code: 6, Labels: []string{"NetworkError"}}, ErrTransient},
+        {"election", mongo.CommandError{Code: 189, Labels: []string{"RetryableWriteError"}}, ErrTransient},
+        {"disconnected", mongo.ErrClientDisconnected, ErrTransient},
+    }
+    for _, test := range tests {
+        t.Run(
+            test.name, func(t *testing.T) {
+                err := classify(test.err)
+                if !errors.Is(err, test.expected) {
+                    t.Fatalf("Should classify %v as %v, got %v", test.err, test.expected, err)
+                }
+            },
+        )
+    }
+
+    if err := classify(invalid); err != invalid {
+        t.Fatalf("Should return other errors as they are, got %v", err)
+    }
+    if err := classify(nil); err != nil {
+        t.Fatalf("Should not classify nil, got %v", err)
+    }
+    if !errors.Is(ErrTrackingDataNotFound, ErrNotFound) || errors.Is(classify(ErrTrackingDataNotFound), ErrTransient) {
+        t.Fatal("Should keep the not found errors of the repositories as they are")
+    }
+    duplicate := classifyWriteError(mongo.BulkWriteError{WriteError: mongo.WriteError{Code: 11000, Message: "E11000"}})
+    if !errors.Is(duplicate, ErrDuplicate) {
+        t.Fatalf("Should classify a duplicate write of a batch, got %v", duplicate)
+    }
+}
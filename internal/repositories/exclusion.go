@@ -0,0 +1,98 @@
+package repositories
+
+import (
+    "errors"
+    "fmt"
+    "slices"
+    "strings"
+    "sync/atomic"
+
+    "go.mongodb.org/mongo-driver/bson"
+)
+
+// The flags of tracking data that read queries can exclude
+const (
+    // FlagBackfill marks readings sent late from a device buffer or imported from another system
+    FlagBackfill = "backfill"
+    // FlagAnomaly marks readings flagged by the anomaly detection, like fuel drops
+    FlagAnomaly = "anomaly"
+    // FlagSuspect marks readings failing the data quality checks, like impossible jumps
+    FlagSuspect = "suspect"
+    // FlagSkewed marks readings stored at the time of a device clock that is off by more than the tolerance
+    FlagSkewed = "skewed"
+)
+
+// The values of the exclude parameter, ExcludeNone includes everything regardless of the default
+const (
+    ExcludeBackfill  = "backfill"
+    ExcludeAnomalies = "anomalies"
+    ExcludeSuspect   = "suspect"
+    ExcludeSkewed    = "skewed"
+    ExcludeNone      = "none"
+)
+
+var (
+    ErrInvalidExclude = errors.New(
+        "invalid exclude, it must be a comma separated list of backfill, anomalies, suspect and skewed, or none",
+    )
+)
+
+// excludeFlags maps the exclude values to the flags they exclude
+var excludeFlags = map[string]string{
+    ExcludeBackfill:  FlagBackfill,
+    ExcludeAnomalies: FlagAnomaly,
+    ExcludeSuspect:   FlagSuspect,
+    ExcludeSkewed:    FlagSkewed,
+}
+
+// defaultExclude holds the flags excluded from queries without an exclude parameter
+var defaultExclude atomic.Pointer[[]string]
+
+// SetDefaultExclude sets what queries without an exclude parameter exclude, e.g. "backfill,anomalies"
+func SetDefaultExclude(exclude string) error {
+    if exclude == "" {
+        exclude = ExcludeNone
+    }
+    flags, err := parseExclude(exclude)
+    if err != nil {
+        return err
+    }
+    defaultExclude.Store(&flags)
+    return nil
+}
+
+// excludedFlags returns the flags excluded by the exclude parameter, the default when it is empty
+func excludedFlags(exclude string) ([]string, error) {
+    if exclude == "" {
+        if flags := defaultExclude.Load(); flags != nil {
+            return *flags, nil
+        }
+        return nil, nil
+    }
+    return parseExclude(exclude)
+}
+
+func parseExclude(exclude string) ([]string, error) {
+    if exclude == ExcludeNone {
+        return nil, nil
+    }
+    var flags []string
+    for _, value := range strings.Split(exclude, ",") {
+        flag, ok := excludeFlags[strings.TrimSpace(value)]
+        if !ok {
+            return nil, fmt.Errorf("%w: %q", ErrInvalidExclude, value)
+        }
+        if !slices.Contains(flags, flag) {
+            flags = append(flags, flag)
+        }
+    }
+    return flags, nil
+}
+
+// excludeFlagged excludes the tracking data with any of the flags from a match
+func excludeFlagged(match bson.M, flags []string) bson.M {
+    if len(flags) > 0 {
+        match["flags"] = bson.M{"$nin": flags}
+    }
+    return match
+}
@@ -0,0 +1,96 @@
+package repositories
+
+import (
+    "context"
+    "log"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ExpectedInterval is how often a vehicle is expected to report, overriding the global interval
+type ExpectedInterval struct {
+    VehicleID       primitive.ObjectID `json:"vehicle_id" bson:"_id"`
+    IntervalSeconds float64            `json:"interval_seconds" bson:"interval_seconds"`
+    UpdatedAt       timestamp.Time     `json:"updated_at" bson:"updated_at"`
+}
+
+type ExpectedIntervalRepository interface {
+    FindExpectedIntervals(
+        ctx context.Context,
+        vehicleIDs []primitive.ObjectID,
+    ) (map[primitive.ObjectID]*ExpectedInterval, error)
+    FindAllExpectedIntervals(ctx context.Context) ([]*ExpectedInterval, error)
+    UpsertExpectedInterval(ctx context.Context, interval *ExpectedInterval) error
+}
+
+type MongoExpectedIntervalRepository struct {
+    collection *mongo.Collection
+}
+
+func NewMongoExpectedIntervalRepository(db *mongo.Database) *MongoExpectedIntervalRepository {
+    return &MongoExpectedIntervalRepository{
+        collection: db.Collection("expected_intervals"),
+    }
+}
+
+func (repo *MongoExpectedIntervalRepository) FindExpectedIntervals(
+    ctx context.Context,
+    vehicleIDs []primitive.ObjectID,
+) (map[primitive.ObjectID]*ExpectedInterval, error) {
+    intervals := make(map[primitive.ObjectID]*ExpectedInterval, len(vehicleIDs))
+    if len(vehicleIDs) == 0 {
+        return intervals, nil
+    }
+    found, err := repo.find(ctx, bson.M{"_id": bson.M{"$in": vehicleIDs}})
+    if err != nil {
+        return nil, err
+    }
+    for _, interval := range found {
+        intervals[interval.VehicleID] = interval
+    }
+    return intervals, nil
+}
+
+func (repo *MongoExpectedIntervalRepository) FindAllExpectedIntervals(ctx context.Context) ([]*ExpectedInterval, error) {
+    return repo.find(ctx, bson.M{})
+}
+
+func (repo *MongoExpectedIntervalRepository) UpsertExpectedInterval(
+    ctx context.Context,
+    interval *ExpectedInterval,
+) error {
+    interval.UpdatedAt = timestamp.Now()
+    _, err := repo.collection.UpdateOne(
+        ctx,
+        bson.M{"_id": interval.VehicleID},
+        bson.M{"$set": bson.M{"interval_seconds": interval.IntervalSeconds, "updated_at": interval.UpdatedAt}},
+        options.Update().SetUpsert(true),
+    )
+    return err
+}
+
+func (repo *MongoExpectedIntervalRepository) find(ctx context.Context, filter bson.M) ([]*ExpectedInterval, error) {
+    var intervals []*ExpectedInterval
+    cursor, err := repo.collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+    if err != nil {
+        return nil, err
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
+        }
+    }(cursor, ctx)
+    for cursor.Next(ctx) {
+        var interval ExpectedInterval
+        if err := cursor.Decode(&interval); err != nil {
+            return nil, err
+        }
+        intervals = append(intervals, &interval)
+    }
+    return intervals, nil
+}
@@ -0,0 +1,157 @@
+package repositories
+
+import (
+    "context"
+    "log"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/ulid"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// FuelAnomaly is a suspicious fuel drop between two consecutive readings of a vehicle,
+// a potential theft or leak
+type FuelAnomaly struct {
+    ID                     primitive.ObjectID   `json:"id" bson:"_id,omitempty"`
+    PublicID               string               `json:"public_id,omitempty" bson:"public_id,omitempty"`
+    TenantID               string               `json:"tenant_id,omitempty" bson:"tenant_id,omitempty"`
+    VehicleID              primitive.ObjectID   `json:"vehicle_id" bson:"vehicle_id"`
+    TrackingDataID         primitive.ObjectID   `json:"tracking_data_id" bson:"tracking_data_id"`
+    TrackingDataPublicID   string               `json:"tracking_data_public_id,omitempty" bson:"tracking_data_public_id,omitempty"`
+    PreviousTrackingDataID primitive.ObjectID   `json:"previous_tracking_data_id" bson:"previous_tracking_data_id"`
+    FromCondition          models.FuelCondition `json:"from_condition" bson:"from_condition"`
+    ToCondition            models.FuelCondition `json:"to_condition" bson:"to_condition"`
+    LevelsDropped          int                  `json:"levels_dropped" bson:"levels_dropped"`
+    MileageDelta           float64              `json:"mileage_delta" bson:"mileage_delta"`
+    ExpectedMileage        float64              `json:"expected_mileage" bson:"expected_mileage"`
+    ReadingAt              timestamp.Time       `json:"reading_at" bson:"reading_at"`
+    DetectedAt             timestamp.Time       `json:"detected_at" bson:"detected_at"`
+}
+
+type FuelAnomalyFilter struct {
+    Page      int    `json:"page"`
+    PageSize  int    `json:"limit"`
+    VehicleID string `json:"vehicle_id"`
+    From      string `json:"from"`
+    To        string `json:"to"`
+
+    vehicleID primitive.ObjectID
+    from      time.Time
+    to        time.Time
+}
+
+func (f *FuelAnomalyFilter) Build() error {
+    if f.Page == 0 {
+        f.Page = 1
+    }
+    f.PageSize = pageSize(f.PageSize)
+    if f.VehicleID != "" {
+        id, err := primitive.ObjectIDFromHex(f.VehicleID)
+        if err != nil {
+            return ErrInvalidID
+        }
+        f.vehicleID = id
+    }
+    if f.From != "" {
+        from, err := time.Parse(time.RFC3339, f.From)
+        if err != nil {
+            return ErrInvalidTimeRange
+        }
+        f.from = from
+    }
+    if f.To != "" {
+        to, err := time.Parse(time.RFC3339, f.To)
+        if err != nil {
+            return ErrInvalidTimeRange
+        }
+        f.to = to
+    }
+    if !f.from.IsZero() && !f.to.IsZero() && !f.from.Before(f.to) {
+        return ErrInvalidTimeRange
+    }
+    return nil
+}
+
+type FuelAnomalyRepository interface {
+    CreateFuelAnomaly(ctx context.Context, anomaly *FuelAnomaly) error
+    FindFuelAnomalies(ctx context.Context, filter *FuelAnomalyFilter) ([]*FuelAnomaly, error)
+}
+
+type MongoFuelAnomalyRepository struct {
+    collection *mongo.Collection
+}
+
+func NewMongoFuelAnomalyRepository(db *mongo.Database) *MongoFuelAnomalyRepository {
+    return &MongoFuelAnomalyRepository{
+        collection: db.Collection("fuel_anomalies"),
+    }
+}
+
+func (repo *MongoFuelAnomalyRepository) CreateFuelAnomaly(ctx context.Context, anomaly *FuelAnomaly) error {
+    if anomaly.DetectedAt.IsZero() {
+        anomaly.DetectedAt = timestamp.Now()
+    }
+    if anomaly.PublicID == "" {
+        anomaly.PublicID = ulid.New(anomaly.DetectedAt.Time)
+    }
+    anomaly.TenantID = tenantOf(ctx)
+    result, err := repo.collection.InsertOne(ctx, anomaly)
+    if err != nil {
+        return err
+    }
+    anomaly.ID = result.InsertedID.(primitive.ObjectID)
+    return nil
+}
+
+// FindFuelAnomalies finds the anomalies by the time of the reading that triggered them, newest first
+func (repo *MongoFuelAnomalyRepository) FindFuelAnomalies(
+    ctx context.Context,
+    filter *FuelAnomalyFilter,
+) ([]*FuelAnomaly, error) {
+    var anomalies []*FuelAnomaly
+    bsonMFilter := scopeTenant(ctx, bson.M{})
+    findOptions := options.Find().SetSort(bson.D{{Key: "reading_at", Value: -1}, {Key: "_id", Value: -1}})
+    if filter != nil {
+        if err := filter.Build(); err != nil {
+            return nil, err
+        }
+        if !filter.vehicleID.IsZero() {
+            bsonMFilter["vehicle_id"] = filter.vehicleID
+        }
+        if !filter.from.IsZero() || !filter.to.IsZero() {
+            readingAt := bson.M{}
+            if !filter.from.IsZero() {
+                readingAt["$gte"] = filter.from
+            }
+            if !filter.to.IsZero() {
+                readingAt["$lt"] = filter.to
+            }
+            bsonMFilter["reading_at"] = readingAt
+        }
+        findOptions.SetSkip(int64((filter.Page - 1) * filter.PageSize))
+        findOptions.SetLimit(int64(filter.PageSize))
+    }
+    cursor, err := repo.collection.Find(ctx, bsonMFilter, findOptions)
+    if err != nil {
+        return nil, err
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
+        }
+    }(cursor, ctx)
+    for cursor.Next(ctx) {
+        var anomaly FuelAnomaly
+        if err := cursor.Decode(&anomaly); err != nil {
+            return nil, err
+        }
+        anomalies = append(anomalies, &anomaly)
+    }
+    return anomalies, nil
+}
@@ -0,0 +1,94 @@
+package repositories
+
+import (
+    "context"
+    "log"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/geojson"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type Geofence struct {
+    ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+    Name       string             `json:"name" bson:"name"`
+    Properties map[string]any     `json:"properties,omitempty" bson:"properties,omitempty"`
+    Geometry   *geojson.Geometry  `json:"geometry" bson:"geometry"`
+    CreatedAt  timestamp.Time     `json:"created_at" bson:"created_at"`
+    UpdatedAt  timestamp.Time     `json:"updated_at" bson:"updated_at"`
+}
+
+type GeofenceRepository interface {
+    FindGeofences(ctx context.Context) ([]*Geofence, error)
+    UpsertGeofences(ctx context.Context, geofences []*Geofence) (created int, updated int, err error)
+}
+
+type MongoGeofenceRepository struct {
+    collection *mongo.Collection
+}
+
+func NewMongoGeofenceRepository(db *mongo.Database) *MongoGeofenceRepository {
+    return &MongoGeofenceRepository{
+        collection: db.Collection("geofences"),
+    }
+}
+
+func (repo *MongoGeofenceRepository) FindGeofences(ctx context.Context) ([]*Geofence, error) {
+    var geofences []*Geofence
+    cursor, err := repo.collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+    if err != nil {
+        return nil, err
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
+        }
+    }(cursor, ctx)
+    for cursor.Next(ctx) {
+        var geofence Geofence
+        if err := cursor.Decode(&geofence); err != nil {
+            return nil, err
+        }
+        geofences = append(geofences, &geofence)
+    }
+    return geofences, nil
+}
+
+// UpsertGeofences creates or replaces geofences by name in a single bulk write
+func (repo *MongoGeofenceRepository) UpsertGeofences(
+    ctx context.Context,
+    geofences []*Geofence,
+) (int, int, error) {
+    if len(geofences) == 0 {
+        return 0, 0, nil
+    }
+    now := timestamp.Now()
+    writes := make([]mongo.WriteModel, 0, len(geofences))
+    for _, geofence := range geofences {
+        geofence.UpdatedAt = now
+        writes = append(
+            writes, mongo.NewUpdateOneModel().
+                SetFilter(bson.M{"name": geofence.Name}).
+                SetUpdate(
+                    bson.M{
+                        "$set": bson.M{
+                            "properties": geofence.Properties,
+                            "geometry":   geofence.Geometry,
+                            "updated_at": now,
+                        },
+                        "$setOnInsert": bson.M{"created_at": now},
+                    },
+                ).
+                SetUpsert(true),
+        )
+    }
+    result, err := repo.collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+    if err != nil {
+        return 0, 0, err
+    }
+    return int(result.UpsertedCount), int(result.MatchedCount), nil
+}
@@ -0,0 +1,134 @@
+package repositories
+
+import (
+    "context"
+    "log"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/geo"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Geohashes are assigned to the tracking data with coordinates when it is stored. The positions of an area share
+// the leading characters of their geohashes, so bounding box queries read a few ranges of the geohash index.
+
+const (
+    // maxGeohashCover is how many geohash ranges a bounding box query reads at most
+    maxGeohashCover = 32
+    // geohashBackfillBatch is the number of tracking data updated by a write of BackfillGeohashes
+    geohashBackfillBatch = 1000
+)
+
+// geohashIndexes are the indexes of the bounding box queries, the tracking data without coordinates has no geohash
+var geohashIndexes = []mongo.IndexModel{
+    {
+        Keys: bson.D{{Key: "geohash", Value: 1}, {Key: "created_at", Value: -1}},
+        Options: options.Index().
+            SetPartialFilterExpression(bson.M{"geohash": bson.M{"$exists": true}}),
+    },
+}
+
+// CreateGeohashIndexes creates the index of the bounding box queries, on every partition when partitioned
+func (repo *MongoTackingRepository) CreateGeohashIndexes(ctx context.Context) error {
+    if repo.partitions != nil {
+        return repo.partitions.AddIndexes(ctx, geohashIndexes)
+    }
+    _, err := repo.collection.Indexes().CreateMany(ctx, geohashIndexes)
+    return err
+}
+
+// BackfillGeohashes assigns a geohash to the tracking data with coordinates stored before geohashes were added, of
+// every tenant. It returns the number of updated tracking data.
+func (repo *MongoTackingRepository) BackfillGeohashes(ctx context.Context) (int64, error) {
+    collections, err := repo.readCollections(ctx, time.Time{}, time.Time{})
+    if err != nil {
+        return 0, classify(err)
+    }
+    var updated int64
+    for _, collection := range collections {
+        n, err := backfillGeohashes(ctx, collection)
+        updated += n
+        if err != nil {
+            return updated, classify(err)
+        }
+    }
+    return updated, nil
+}
+
+func backfillGeohashes(ctx context.Context, collection *mongo.Collection) (int64, error) {
+    cursor, err := collection.Find(
+        ctx,
+        bson.M{"geohash": bson.M{"$exists": false}, "lat": bson.M{"$ne": nil}, "lng": bson.M{"$ne": nil}},
+        options.Find().SetProjection(bson.M{"lat": 1, "lng": 1}),
+    )
+    if err != nil {
+        return 0, err
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
+        }
+    }(cursor, ctx)
+
+    var updated int64
+    write := func(models []mongo.WriteModel) error {
+        if len(models) == 0 {
+            return nil
+        }
+        result, err := collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+        if result != nil {
+            updated += result.ModifiedCount
+        }
+        return err
+    }
+    var models []mongo.WriteModel
+    for cursor.Next(ctx) {
+        var position struct {
+            ID  primitive.ObjectID `bson:"_id"`
+            Lat float64            `bson:"lat"`
+            Lng float64            `bson:"lng"`
+        }
+        if err := cursor.Decode(&position); err != nil {
+            return updated, err
+        }
+        geohash := geo.Geohash(position.Lat, position.Lng, geo.GeohashPrecision)
+        models = append(
+            models,
+            mongo.NewUpdateOneModel().
+                SetFilter(bson.M{"_id": position.ID}).
+                SetUpdate(bson.M{"$set": bson.M{"geohash": geohash}}),
+        )
+        if len(models) == geohashBackfillBatch {
+            if err := write(models); err != nil {
+                return updated, err
+            }
+            models = models[:0]
+        }
+    }
+    if err := cursor.Err(); err != nil {
+        return updated, err
+    }
+    return updated, write(models)
+}
+
+// assignGeohash sets the geohash of the record from its coordinates, records without coordinates have none
+func assignGeohash(record *TrackingRecord) {
+    record.Geohash = ""
+    if point, ok := record.Point(); ok {
+        record.Geohash = geo.Geohash(point.Lat, point.Lng, geo.GeohashPrecision)
+    }
+}
+
+// geohashCover matches the geohashes of the cells overlapping the bounding box, a prefix per cell
+func geohashCover(minLat, minLng, maxLat, maxLng float64) bson.M {
+    cells := geo.GeohashCover(minLat, minLng, maxLat, maxLng, maxGeohashCover)
+    prefixes := make(bson.A, 0, len(cells))
+    for _, cell := range cells {
+        prefixes = append(prefixes, primitive.Regex{Pattern: "^" + cell})
+    }
+    return bson.M{"$in": prefixes}
+}
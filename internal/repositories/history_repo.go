@@ -0,0 +1,605 @@
+package repositories
+
+import (
+    "bufio"
+    "bytes"
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "math"
+    "net"
+    "net/http"
+    "net/url"
+    "slices"
+    "strings"
+    "time"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+    // historyTable is the ClickHouse table of the mirrored tracking data
+    historyTable = "tracking_history"
+    // clickHouseTimeLayout is the text format of the DateTime64(3) values of ClickHouse
+    clickHouseTimeLayout = "2006-01-02 15:04:05.000"
+    // DefaultHeatmapCellDegrees is the size of the heatmap cells without cell_degrees, about a kilometer
+    DefaultHeatmapCellDegrees = 0.01
+    // MaxHeatmapCells is how many of the busiest cells a heatmap returns at most
+    MaxHeatmapCells = 10000
+    // MaxUtilizationRows is how many periods of vehicles a utilization query returns at most
+    MaxUtilizationRows = 10000
+)
+
+var (
+    ErrInvalidInterval    = errors.New("invalid interval, it must be day, week, month or year")
+    ErrInvalidCellDegrees = errors.New("invalid cell_degrees, it must be between 0.0001 and 10")
+    ErrTooManyPeriods     = fmt.Errorf(
+        "%w: the query has more than %d vehicle periods, narrow the time range or vehicles or use a longer interval",
+        ErrTooManyValues,
+        MaxUtilizationRows,
+    )
+)
+
+// historySchema creates the table of the mirrored tracking data. ReplacingMergeTree keeps the latest version of a
+// reading, so readings mirrored again when they are flagged replace the previous ones, the queries read it FINAL.
+var historySchema = `CREATE TABLE IF NOT EXISTS tracking_history (
+    id              String,
+    public_id       String,
+    tenant_id       String,
+    vehicle_id      String,
+    location        String,
+    mileage         Float64,
+    status          LowCardinality(String),
+    fuel_condition  LowCardinality(String),
+    lat             Nullable(Float64),
+    lng             Nullable(Float64),
+    distance_meters Nullable(Float64),
+    odometer_meters Nullable(Float64),
+    flags           Array(LowCardinality(String)),
+    created_at      DateTime64(3, 'UTC'),
+    version         UInt64
+) ENGINE = ReplacingMergeTree(version)
+PARTITION BY toYYYYMM(created_at)
+ORDER BY (tenant_id, vehicle_id, created_at, id)`
+
+// HistoryInterval is the length of the periods the utilization is reported for
+type HistoryInterval string
+
+const (
+    HistoryIntervalDay   HistoryInterval = "day"
+    HistoryIntervalWeek  HistoryInterval = "week"
+    HistoryIntervalMonth HistoryInterval = "month"
+    HistoryIntervalYear  HistoryInterval = "year"
+)
+
+// historyIntervalStarts are the ClickHouse functions returning the start of the period of a time
+var historyIntervalStarts = map[HistoryInterval]string{
+    HistoryIntervalDay:   "toStartOfDay",
+    HistoryIntervalWeek:  "toMonday",
+    HistoryIntervalMonth: "toStartOfMonth",
+    HistoryIntervalYear:  "toStartOfYear",
+}
+
+// End returns the end of the period starting at start
+func (i HistoryInterval) End(start time.Time) time.Time {
+    switch i {
+    case HistoryIntervalDay:
+        return start.AddDate(0, 0, 1)
+    case HistoryIntervalWeek:
+        return start.AddDate(0, 0, 7)
+    case HistoryIntervalYear:
+        return start.AddDate(1, 0, 0)
+    }
+    return start.AddDate(0, 1, 0)
+}
+
+type HistoryFilter struct {
+    VehicleID   string   `json:"vehicle_id" doc:"Comma separated vehicle ids"`
+    From        string   `json:"from" doc:"RFC3339 start of created_at, inclusive"`
+    To          string   `json:"to" doc:"RFC3339 end of created_at, exclusive"`
+    Interval    string   `json:"interval" doc:"day, week, month (default) or year, the periods of the utilization"`
+    CellDegrees *float64 `json:"cell_degrees" doc:"Size of the heatmap cells in degrees, 0.01 by default"`
+    Exclude     string   `json:"exclude" doc:"Comma separated backfill, anomalies, suspect and skewed to leave out, or none"`
+
+    selected    []primitive.ObjectID
+    vehicleIDs  []primitive.ObjectID
+    from        time.Time
+    to          time.Time
+    interval    HistoryInterval
+    cellDegrees float64
+    excluded    []string
+}
+
+// RestrictVehicles limits the history to the given vehicles, on top of the vehicle_id filter
+func (f *HistoryFilter) RestrictVehicles(vehicleIDs []primitive.ObjectID) {
+    f.vehicleIDs = vehicleIDs
+}
+
+// TimeRange returns the parsed from and to of the filter, zero when not set
+func (f *HistoryFilter) TimeRange() (time.Time, time.Time) {
+    return f.from, f.to
+}
+
+// IntervalValue returns the interval of the utilization periods, month by default
+func (f *HistoryFilter) IntervalValue() HistoryInterval {
+    return f.interval
+}
+
+func (f *HistoryFilter) Build() error {
+    f.selected = nil
+    vehicleIDs, err := SplitValues("vehicle_id", f.VehicleID)
+    if err != nil {
+        return err
+    }
+    for _, vehicleID := range vehicleIDs {
+        id, err := primitive.ObjectIDFromHex(vehicleID)
+        if err != nil {
+            return ErrInvalidID
+        }
+        f.selected = append(f.selected, id)
+    }
+    if f.From != "" {
+        from, err := time.Parse(time.RFC3339, f.From)
+        if err != nil {
+            return ErrInvalidTimeRange
+        }
+        f.from = from.UTC()
+    }
+    if f.To != "" {
+        to, err := time.Parse(time.RFC3339, f.To)
+        if err != nil {
+            return ErrInvalidTimeRange
+        }
+        f.to = to.UTC()
+    }
+    if !f.from.IsZero() && !f.to.IsZero() && !f.from.Before(f.to) {
+        return ErrInvalidTimeRange
+    }
+    f.interval = HistoryIntervalMonth
+    if f.Interval != "" {
+        f.interval = HistoryInterval(f.Interval)
+        if _, ok := historyIntervalStarts[f.interval]; !ok {
+            return ErrInvalidInterval
+        }
+    }
+    f.cellDegrees = DefaultHeatmapCellDegrees
+    if f.CellDegrees != nil {
+        if *f.CellDegrees < 0.0001 || *f.CellDegrees > 10 {
+            return ErrInvalidCellDegrees
+        }
+        f.cellDegrees = *f.CellDegrees
+    }
+    excluded, err := excludedFlags(f.Exclude)
+    if err != nil {
+        return err
+    }
+    f.excluded = excluded
+    return nil
+}
+
+// HeatmapCell is the number of readings and distinct vehicles in a cell of the heatmap grid
+type HeatmapCell struct {
+    // Lat and Lng are the south west corner of the cell, CellDegrees its size
+    Lat         float64 `json:"lat"`
+    Lng         float64 `json:"lng"`
+    CellDegrees float64 `json:"cell_degrees"`
+    Readings    int64   `json:"readings"`
+    Vehicles    int64   `json:"vehicles"`
+}
+
+// VehicleUtilization is how much a vehicle was used during a period
+type VehicleUtilization struct {
+    VehicleID string `json:"vehicle_id"`
+    // Period is the first day of the period, e.g. 2024-01-01 for January with the month interval
+    Period   string `json:"period"`
+    Readings int64  `json:"readings"`
+    // ActiveDays is the number of distinct UTC days with at least one reading, Days the number of days of the
+    // period within the time range of the query
+    ActiveDays int64 `json:"active_days"`
+    Days       int   `json:"days"`
+    // Utilization is the percentage of the days of the period the vehicle was active
+    Utilization    float64        `json:"utilization"`
+    DistanceMeters float64        `json:"distance_meters"`
+    MileageDelta   float64        `json:"mileage_delta"`
+    FirstSeen      timestamp.Time `json:"first_seen"`
+    LastSeen       timestamp.Time `json:"last_seen"`
+}
+
+// HistoryRepository is the analytical store of the long-term tracking data history, a mirror of the hot store
+type HistoryRepository interface {
+    // InsertTrackingData stores the tracking data, a reading stored again replaces the previous one
+    InsertTrackingData(ctx context.Context, trackingData []*TrackingRecord) error
+    // DeleteTrackingData deletes the tracking data of the vehicle created before before, a zero before deletes
+    // all of it
+    DeleteTrackingData(ctx context.Context, vehicleID primitive.ObjectID, before time.Time) error
+    // FindHeatmap counts the readings with coordinates per cell of a grid, the busiest cells first
+    FindHeatmap(ctx context.Context, filter *HistoryFilter) ([]*HeatmapCell, error)
+    // FindUtilization aggregates the readings per vehicle and period, ordered by vehicle and period. Days and
+    // Utilization are left to the caller.
+    FindUtilization(ctx context.Context, filter *HistoryFilter) ([]*VehicleUtilization, error)
+}
+
+// ClickHouseHistoryRepository stores the history in ClickHouse through its HTTP interface, the rows are sent and
+// read as JSONEachRow and the values of the queries are bound as query parameters
+type ClickHouseHistoryRepository struct {
+    client *http.Client
+    // url is the HTTP endpoint without credentials, e.g. http://localhost:8123/?database=tracking
+    url      *url.URL
+    user     string
+    password string
+}
+
+// NewClickHouseHistoryRepository connects to the HTTP interface of ClickHouse at rawURL, its user info are the
+// credentials and its database parameter the database of the table
+func NewClickHouseHistoryRepository(client *http.Client, rawURL string) (*ClickHouseHistoryRepository, error) {
+    u, err := url.Parse(rawURL)
+    if err != nil {
+        return nil, err
+    }
+    repo := &ClickHouseHistoryRepository{client: client}
+    if u.User != nil {
+        repo.user = u.User.Username()
+        repo.password, _ = u.User.Password()
+        u.User = nil
+    }
+    repo.url = u
+    return repo, nil
+}
+
+// CreateSchema creates the table of the history when it doesn't exist
+func (repo *ClickHouseHistoryRepository) CreateSchema(ctx context.Context) error {
+    body, err := repo.do(ctx, historySchema, nil, nil)
+    if err != nil {
+        return err
+    }
+    return body.Close()
+}
+
+// Ping checks that ClickHouse answers queries
+func (repo *ClickHouseHistoryRepository) Ping(ctx context.Context) error {
+    body, err := repo.do(ctx, "SELECT 1", nil, nil)
+    if err != nil {
+        return err
+    }
+    return body.Close()
+}
+
+// do sends a query with its parameters and data to ClickHouse, the caller closes the returned body
+func (repo *ClickHouseHistoryRepository) do(
+    ctx context.Context,
+    query string,
+    params map[string]string,
+    data io.Reader,
+) (io.ReadCloser, error) {
+    u := *repo.url
+    values := u.Query()
+    values.Set("query", query)
+    // the counts are UInt64, they are decoded as numbers instead of the default strings
+    values.Set("output_format_json_quote_64bit_integers", "0")
+    for name, value := range params {
+        values.Set("param_"+name, value)
+    }
+    u.RawQuery = values.Encode()
+    // every query is a POST, ClickHouse runs the GET ones in readonly mode only
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), data)
+    if err != nil {
+        return nil, err
+    }
+    if repo.user != "" {
+        req.SetBasicAuth(repo.user, repo.password)
+    }
+    res, err := repo.client.Do(req)
+    if err != nil {
+        return nil, classifyClickHouse(err)
+    }
+    if res.StatusCode != http.StatusOK {
+        defer res.Body.Close()
+        message, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+        err = fmt.Errorf("clickhouse responded with %s: %s", res.Status, bytes.TrimSpace(message))
+        if res.StatusCode >= http.StatusInternalServerError {
+            return nil, fmt.Errorf("%w: %w", ErrTransient, err)
+        }
+        return nil, err
+    }
+    return res.Body, nil
+}
+
+// classifyClickHouse wraps the failures to reach ClickHouse with ErrTransient
+func classifyClickHouse(err error) error {
+    var netErr net.Error
+    if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) {
+        return fmt.Errorf("%w: %w", ErrTransient, err)
+    }
+    return err
+}
+
+// historyRow is a row of the history table
+type historyRow struct {
+    ID             string   `json:"id"`
+    PublicID       string   `json:"public_id"`
+    TenantID       string   `json:"tenant_id"`
+    VehicleID      string   `json:"vehicle_id"`
+    Location       string   `json:"location"`
+    Mileage        float64  `json:"mileage"`
+    Status         string   `json:"status"`
+    FuelCondition  string   `json:"fuel_condition"`
+    Lat            *float64 `json:"lat"`
+    Lng            *float64 `json:"lng"`
+    DistanceMeters *float64 `json:"distance_meters"`
+    OdometerMeters *float64 `json:"odometer_meters"`
+    Flags          []string `json:"flags"`
+    CreatedAt      string   `json:"created_at"`
+    Version        uint64   `json:"version"`
+}
+
+// InsertTrackingData inserts the tracking data in a single request, its version is the time it is inserted so
+// the latest insert of a reading is kept
+func (repo *ClickHouseHistoryRepository) InsertTrackingData(
+    ctx context.Context,
+    trackingData []*TrackingRecord,
+) error {
+    if len(trackingData) == 0 {
+        return nil
+    }
+    version := uint64(time.Now().UnixNano())
+    var rows bytes.Buffer
+    encoder := json.NewEncoder(&rows)
+    for _, data := range trackingData {
+        flags := data.Flags
+        if flags == nil {
+            flags = []string{}
+        }
+        err := encoder.Encode(
+            historyRow{
+                ID:             data.ID.Hex(),
+                PublicID:       data.PublicID,
+                TenantID:       data.TenantID,
+                VehicleID:      data.VehicleID.Hex(),
+                Location:       data.Location,
+                Mileage:        data.Mileage,
+                Status:         string(data.Status),
+                FuelCondition:  string(data.FuelCondition),
+                Lat:            data.Lat,
+                Lng:            data.Lng,
+                DistanceMeters: data.DistanceMeters,
+                OdometerMeters: data.OdometerMeters,
+                Flags:          flags,
+                CreatedAt:      data.CreatedAt.UTC().Format(clickHouseTimeLayout),
+                Version:        version,
+            },
+        )
+        if err != nil {
+            return err
+        }
+    }
+    body, err := repo.do(ctx, fmt.Sprintf("INSERT INTO %s FORMAT JSONEachRow", historyTable), nil, &rows)
+    if err != nil {
+        return err
+    }
+    return body.Close()
+}
+
+// DeleteTrackingData deletes with a lightweight delete, the rows are hidden right away and removed by merges
+func (repo *ClickHouseHistoryRepository) DeleteTrackingData(
+    ctx context.Context,
+    vehicleID primitive.ObjectID,
+    before time.Time,
+) error {
+    query := &clickHouseQuery{params: map[string]string{}}
+    if id, ok := tenant.FromContext(ctx); ok {
+        query.where("tenant_id = {tenant_id:String}", "tenant_id", id)
+    }
+    query.where("vehicle_id = {vehicle_id:String}", "vehicle_id", vehicleID.Hex())
+    if !before.IsZero() {
+        query.where("created_at < {before:DateTime64(3, 'UTC')}", "before", clickHouseTime(before))
+    }
+    body, err := repo.do(ctx, fmt.Sprintf("DELETE FROM %s%s", historyTable, query.whereClause()), query.params, nil)
+    if err != nil {
+        return err
+    }
+    return body.Close()
+}
+
+// clickHouseQuery are the conditions of a query, the values are bound to the {name:Type} placeholders of the
+// conditions by params
+type clickHouseQuery struct {
+    conditions []string
+    params     map[string]string
+}
+
+func (q *clickHouseQuery) where(condition string, name string, value string) {
+    q.conditions = append(q.conditions, condition)
+    if name != "" {
+        q.params[name] = value
+    }
+}
+
+func (q *clickHouseQuery) whereClause() string {
+    if len(q.conditions) == 0 {
+        return ""
+    }
+    return " WHERE " + strings.Join(q.conditions, " AND ")
+}
+
+func clickHouseTime(t time.Time) string {
+    return t.UTC().Format(clickHouseTimeLayout)
+}
+
+// clickHouseStrings formats the values as an Array(String) parameter
+func clickHouseStrings(values []string) string {
+    quoted := make([]string, len(values))
+    for i, value := range values {
+        quoted[i] = "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+    }
+    return "[" + strings.Join(quoted, ",") + "]"
+}
+
+// historyQuery translates the filter into the conditions of a history query, limited to the tenant of the context
+func historyQuery(ctx context.Context, filter *HistoryFilter) *clickHouseQuery {
+    query := &clickHouseQuery{params: map[string]string{}}
+    if id, ok := tenant.FromContext(ctx); ok {
+        query.where("tenant_id = {tenant_id:String}", "tenant_id", id)
+    }
+    vehicleIDs := filter.selected
+    if filter.vehicleIDs != nil {
+        vehicleIDs = filter.vehicleIDs
+        if filter.selected != nil {
+            vehicleIDs = slices.DeleteFunc(
+                slices.Clone(vehicleIDs), func(id primitive.ObjectID) bool {
+                    return !slices.Contains(filter.selected, id)
+                },
+            )
+        }
+    }
+    if vehicleIDs != nil && len(vehicleIDs) == 0 {
+        // none of the vehicles are allowed
+        query.where("0", "", "")
+    } else if vehicleIDs != nil {
+        query.where("vehicle_id IN {vehicle_ids:Array(String)}", "vehicle_ids", clickHouseStrings(hexes(vehicleIDs)))
+    }
+    if !filter.from.IsZero() {
+        query.where("created_at >= {from:DateTime64(3, 'UTC')}", "from", clickHouseTime(filter.from))
+    }
+    if !filter.to.IsZero() {
+        query.where("created_at < {to:DateTime64(3, 'UTC')}", "to", clickHouseTime(filter.to))
+    }
+    if len(filter.excluded) > 0 {
+        query.where("NOT hasAny(flags, {excluded:Array(String)})", "excluded", clickHouseStrings(filter.excluded))
+    }
+    return query
+}
+
+// decodeRows decodes the JSONEachRow rows of a response body, calling fn for each of them
+func decodeRows[T any](body io.ReadCloser, fn func(row *T)) error {
+    defer body.Close()
+    scanner := bufio.NewScanner(body)
+    scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+    for scanner.Scan() {
+        line := scanner.Bytes()
+        if len(bytes.TrimSpace(line)) == 0 {
+            continue
+        }
+        // an exception of a failing query is written into the body after the rows that were sent already, as a row
+        // of the exception by the recent versions and as text by the older ones
+        var exception struct {
+            Exception string `json:"exception"`
+        }
+        if bytes.Contains(line, []byte(`"exception"`)) && json.Unmarshal(line, &exception) == nil &&
+            exception.Exception != "" {
+            return fmt.Errorf("clickhouse query failed: %s", exception.Exception)
+        }
+        var row T
+        if err := json.Unmarshal(line, &row); err != nil {
+            return fmt.Errorf("clickhouse query failed: %s", bytes.TrimSpace(line))
+        }
+        fn(&row)
+    }
+    return classifyClickHouse(scanner.Err())
+}
+
+func (repo *ClickHouseHistoryRepository) FindHeatmap(
+    ctx context.Context,
+    filter *HistoryFilter,
+) ([]*HeatmapCell, error) {
+    if err := filter.Build(); err != nil {
+        return nil, err
+    }
+    query := historyQuery(ctx, filter)
+    query.where("lat IS NOT NULL AND lng IS NOT NULL", "", "")
+    query.params["cell"] = fmt.Sprint(filter.cellDegrees)
+    query.params["limit"] = fmt.Sprint(MaxHeatmapCells)
+    body, err := repo.do(
+        ctx,
+        fmt.Sprintf(
+            "SELECT floor(lat / {cell:Float64}) AS lat_index, floor(lng / {cell:Float64}) AS lng_index, "+
+                "count() AS readings, uniqExact(vehicle_id) AS vehicles FROM %s FINAL%s "+
+                "GROUP BY lat_index, lng_index ORDER BY readings DESC, lat_index, lng_index "+
+                "LIMIT {limit:UInt32} FORMAT JSONEachRow",
+            historyTable,
+            query.whereClause(),
+        ),
+        query.params,
+        nil,
+    )
+    if err != nil {
+        return nil, err
+    }
+    cells := []*HeatmapCell{}
+    err = decodeRows(
+        body, func(row *struct {
+            LatIndex float64 `json:"lat_index"`
+            LngIndex float64 `json:"lng_index"`
+            Readings int64   `json:"readings"`
+            Vehicles int64   `json:"vehicles"`
+        }) {
+            cells = append(
+                cells, &HeatmapCell{
+                    Lat:         roundDegrees(row.LatIndex * filter.cellDegrees),
+                    Lng:         roundDegrees(row.LngIndex * filter.cellDegrees),
+                    CellDegrees: filter.cellDegrees,
+                    Readings:    row.Readings,
+                    Vehicles:    row.Vehicles,
+                },
+            )
+        },
+    )
+    return cells, err
+}
+
+// roundDegrees drops the floating point noise of a cell corner, the cells are at least 0.0001 degrees
+func roundDegrees(degrees float64) float64 {
+    return math.Round(degrees*1e6) / 1e6
+}
+
+func (repo *ClickHouseHistoryRepository) FindUtilization(
+    ctx context.Context,
+    filter *HistoryFilter,
+) ([]*VehicleUtilization, error) {
+    if err := filter.Build(); err != nil {
+        return nil, err
+    }
+    query := historyQuery(ctx, filter)
+    query.params["limit"] = fmt.Sprint(MaxUtilizationRows + 1)
+    body, err := repo.do(
+        ctx,
+        fmt.Sprintf(
+            "SELECT vehicle_id, toString(toDate(%s(created_at))) AS period, count() AS readings, "+
+                "uniqExact(toDate(created_at)) AS active_days, sum(ifNull(distance_meters, 0)) AS distance_meters, "+
+                "max(mileage) - min(mileage) AS mileage_delta, "+
+                "toUnixTimestamp64Milli(min(created_at)) AS first_seen, "+
+                "toUnixTimestamp64Milli(max(created_at)) AS last_seen FROM %s FINAL%s "+
+                "GROUP BY vehicle_id, period ORDER BY vehicle_id, period LIMIT {limit:UInt32} FORMAT JSONEachRow",
+            historyIntervalStarts[filter.interval],
+            historyTable,
+            query.whereClause(),
+        ),
+        query.params,
+        nil,
+    )
+    if err != nil {
+        return nil, err
+    }
+    utilization := []*VehicleUtilization{}
+    err = decodeRows(
+        body, func(row *struct {
+            VehicleUtilization
+            FirstSeen int64 `json:"first_seen"`
+            LastSeen  int64 `json:"last_seen"`
+        }) {
+            row.VehicleUtilization.FirstSeen = timestamp.New(time.UnixMilli(row.FirstSeen))
+            row.VehicleUtilization.LastSeen = timestamp.New(time.UnixMilli(row.LastSeen))
+            utilization = append(utilization, &row.VehicleUtilization)
+        },
+    )
+    if err != nil {
+        return nil, err
+    }
+    if len(utilization) > MaxUtilizationRows {
+        return nil, ErrTooManyPeriods
+    }
+    return utilization, nil
+}
@@ -0,0 +1,288 @@
+package repositories
+
+import (
+    "bufio"
+    "bytes"
+    "context"
+    "errors"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "os"
+    "reflect"
+    "slices"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestHistoryQuery(t *testing.T) {
+    vehicleID, other := primitive.NewObjectID(), primitive.NewObjectID()
+    filter := &HistoryFilter{
+        VehicleID: vehicleID.Hex() + "," + other.Hex(),
+        From:      "2024-01-01T00:00:00Z",
+        Exclude:   ExcludeAnomalies,
+    }
+    if err := filter.Build(); err != nil {
+        t.Fatal(err)
+    }
+    if filter.IntervalValue() != HistoryIntervalMonth || filter.cellDegrees != DefaultHeatmapCellDegrees {
+        t.Fatalf("Should default to monthly periods and 0.01 degree cells, got %s %v",
+            filter.interval, filter.cellDegrees)
+    }
+    filter.RestrictVehicles([]primitive.ObjectID{other})
+    query := historyQuery(tenant.WithID(context.Background(), "acme"), filter)
+
+    expected := " WHERE tenant_id = {tenant_id:String} AND vehicle_id IN {vehicle_ids:Array(String)} " +
+        "AND created_at >= {from:DateTime64(3, 'UTC')} AND NOT hasAny(flags, {excluded:Array(String)})"
+    if clauses := query.whereClause(); clauses != expected {
+        t.Fatalf("Should translate the filter to ClickHouse conditions, got %q", clauses)
+    }
+    params := map[string]string{
+        "tenant_id":   "acme",
+        "vehicle_ids": "['" + other.Hex() + "']",
+        "from":        "2024-01-01 00:00:00.000",
+        "excluded":    "['" + FlagAnomaly + "']",
+    }
+    for name, value := range params {
+        if query.params[name] != value {
+            t.Errorf("Should bind %s to %q, got %q", name, value, query.params[name])
+        }
+    }
+
+    filter.RestrictVehicles([]primitive.ObjectID{})
+    if query = historyQuery(context.Background(), filter); !slices.Contains(query.conditions, "0") {
+        t.Fatalf("Should match nothing when no vehicle is allowed, got %v", query.conditions)
+    }
+}
+
+func TestHistoryFilter_Build(t *testing.T) {
+    cellDegrees := 20.0
+    filters := map[*HistoryFilter]error{
+        {Interval: "decade"}:        ErrInvalidInterval,
+        {CellDegrees: &cellDegrees}: ErrInvalidCellDegrees,
+        {VehicleID: "vehicle"}:      ErrInvalidID,
+        {From: "2024-02-01T00:00:00Z", To: "2024-01-01T00:00:00Z"}: ErrInvalidTimeRange,
+    }
+    for filter, expected := range filters {
+        if err := filter.Build(); !errors.Is(err, expected) {
+            t.Errorf("Should reject %+v with %v, got %v", filter, expected, err)
+        }
+    }
+}
+
+func TestClickHouseStrings(t *testing.T) {
+    if quoted := clickHouseStrings([]string{`it's`, `a\b`}); quoted != `['it\'s','a\\b']` {
+        t.Fatalf("Should escape the quotes and backslashes, got %s", quoted)
+    }
+}
+
+// clickHouseRequest is a request received by the fake ClickHouse
+type clickHouseRequest struct {
+    method   string
+    params   url.Values
+    body     []byte
+    user     string
+    password string
+}
+
+// newFakeClickHouse answers every query with the status and the fixture of testdata/clickhouse, the requests are
+// recorded
+func newFakeClickHouse(t *testing.T, status int, fixture string) (*ClickHouseHistoryRepository, *[]clickHouseRequest) {
+    var response []byte
+    if fixture != "" {
+        var err error
+        if response, err = os.ReadFile("testdata/clickhouse/" + fixture); err != nil {
+            t.Fatal(err)
+        }
+    }
+    var requests []clickHouseRequest
+    server := httptest.NewServer(
+        http.HandlerFunc(
+            func(w http.ResponseWriter, r *http.Request) {
+                body, _ := io.ReadAll(r.Body)
+                user, password, _ := r.BasicAuth()
+                requests = append(requests, clickHouseRequest{r.Method, r.URL.Query(), body, user, password})
+                w.WriteHeader(status)
+                _, _ = w.Write(response)
+            },
+        ),
+    )
+    t.Cleanup(server.Close)
+    repo, err := NewClickHouseHistoryRepository(
+        server.Client(),
+        strings.Replace(server.URL, "http://", "http://default:secret@", 1)+"/?database=tracking",
+    )
+    if err != nil {
+        t.Fatal(err)
+    }
+    return repo, &requests
+}
+
+// readHistoryRows decodes the JSONEachRow rows of an insert
+func readHistoryRows(t *testing.T, data []byte) []historyRow {
+    var rows []historyRow
+    scanner := bufio.NewScanner(bytes.NewReader(data))
+    for scanner.Scan() {
+        var row historyRow
+        if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+            t.Fatal(err)
+        }
+        rows = append(rows, row)
+    }
+    return rows
+}
+
+func TestClickHouseHistoryRepository_InsertTrackingData(t *testing.T) {
+    repo, requests := newFakeClickHouse(t, http.StatusOK, "")
+    vehicleID, _ := primitive.ObjectIDFromHex("6650c3e0f1a2b3c4d5e6f7a8")
+    newRecord := func(id, location string, mileage float64, createdAt time.Time) *TrackingRecord {
+        objectID, _ := primitive.ObjectIDFromHex(id)
+        return NewTrackingRecord(
+            &models.TrackingData{
+                ID:            objectID,
+                VehicleID:     vehicleID,
+                Location:      location,
+                Mileage:       mileage,
+                Status:        models.VehicleStatusActive,
+                FuelCondition: models.FuelConditionFull,
+                CreatedAt:     createdAt,
+            },
+        )
+    }
+    flagged := newRecord("6650c3e0f1a2b3c4d5e6f7a1", "Yangon", 1200, time.Date(2024, 1, 1, 8, 0, 0, 5e8, time.UTC)).
+        SetPosition(16.8, 96.15).
+        SetDistance(12.5, 1200).
+        AddFlag(FlagBackfill)
+    flagged.TenantID, flagged.PublicID = "acme", "01HZX3V8Q9W8N7M6K5J4H3G2F1"
+    plain := newRecord("6650c3e0f1a2b3c4d5e6f7a2", "Mandalay", 1250.5, time.Date(2024, 1, 2, 8, 0, 0, 0, time.UTC))
+
+    if err := repo.InsertTrackingData(context.Background(), nil); err != nil || len(*requests) != 0 {
+        t.Fatalf("Should send nothing without tracking data, got %v %v", *requests, err)
+    }
+    if err := repo.InsertTrackingData(context.Background(), []*TrackingRecord{flagged, plain}); err != nil {
+        t.Fatal(err)
+    }
+    request := (*requests)[0]
+    if request.method != http.MethodPost ||
+        request.params.Get("query") != "INSERT INTO tracking_history FORMAT JSONEachRow" ||
+        request.params.Get("database") != "tracking" || request.user != "default" || request.password != "secret" {
+        t.Fatalf("Should post the insert to the database with the credentials of the url, got %+v", request)
+    }
+
+    // the rows are the ones of the fixture, but for the version of the insert
+    fixture, err := os.ReadFile("testdata/clickhouse/insert.jsonl")
+    if err != nil {
+        t.Fatal(err)
+    }
+    rows, expected := readHistoryRows(t, request.body), readHistoryRows(t, fixture)
+    if len(rows) != 2 || rows[0].Version == 0 || rows[0].Version != rows[1].Version {
+        t.Fatalf("Should insert the rows with the same version, got %+v", rows)
+    }
+    for i := range rows {
+        rows[i].Version = 0
+    }
+    if !reflect.DeepEqual(rows, expected) {
+        t.Fatalf("Should insert the rows of the fixture\n%+v\ngot\n%+v", expected, rows)
+    }
+}
+
+func TestClickHouseHistoryRepository_DeleteTrackingData(t *testing.T) {
+    repo, requests := newFakeClickHouse(t, http.StatusOK, "")
+    vehicleID := primitive.NewObjectID()
+    before := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+    if err := repo.DeleteTrackingData(tenant.WithID(context.Background(), "acme"), vehicleID, before); err != nil {
+        t.Fatal(err)
+    }
+    params := (*requests)[0].params
+    expected := "DELETE FROM tracking_history WHERE tenant_id = {tenant_id:String} AND " +
+        "vehicle_id = {vehicle_id:String} AND created_at < {before:DateTime64(3, 'UTC')}"
+    if params.Get("query") != expected || params.Get("param_tenant_id") != "acme" ||
+        params.Get("param_vehicle_id") != vehicleID.Hex() || params.Get("param_before") != "2024-01-01 00:00:00.000" {
+        t.Fatalf("Should delete the tracking data of the vehicle before the time, got %v", params)
+    }
+}
+
+func TestClickHouseHistoryRepository_FindHeatmap(t *testing.T) {
+    repo, requests := newFakeClickHouse(t, http.StatusOK, "heatmap.jsonl")
+    cells, err := repo.FindHeatmap(tenant.WithID(context.Background(), "acme"), &HistoryFilter{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    expected := []*HeatmapCell{
+        {Lat: 16.8, Lng: 96.15, CellDegrees: 0.01, Readings: 12, Vehicles: 2},
+        {Lat: -16.81, Lng: 96.16, CellDegrees: 0.01, Readings: 3, Vehicles: 1},
+    }
+    if !reflect.DeepEqual(cells, expected) {
+        t.Fatalf("Should read the cells of the rows, got %+v %+v", cells[0], cells[1])
+    }
+    params := (*requests)[0].params
+    if (*requests)[0].method != http.MethodPost || params.Get("param_cell") != "0.01" ||
+        params.Get("param_limit") != "10000" || params.Get("param_tenant_id") != "acme" ||
+        params.Get("output_format_json_quote_64bit_integers") != "0" ||
+        !strings.Contains(params.Get("query"), "FROM tracking_history FINAL WHERE tenant_id = {tenant_id:String}") {
+        t.Fatalf("Should bind the parameters of the heatmap query, got %v", params)
+    }
+}
+
+func TestClickHouseHistoryRepository_FindUtilization(t *testing.T) {
+    repo, requests := newFakeClickHouse(t, http.StatusOK, "utilization.jsonl")
+    utilization, err := repo.FindUtilization(context.Background(), &HistoryFilter{Interval: "month"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(utilization) != 2 {
+        t.Fatalf("Should read a period per row, got %d", len(utilization))
+    }
+    january := utilization[0]
+    if january.VehicleID != "6650c3e0f1a2b3c4d5e6f7a8" || january.Period != "2024-01-01" || january.Readings != 3 ||
+        january.ActiveDays != 2 || january.DistanceMeters != 1500.5 || january.MileageDelta != 50.5 ||
+        !january.FirstSeen.Equal(time.Date(2024, 1, 1, 8, 0, 0, 5e8, time.UTC)) ||
+        !january.LastSeen.Equal(time.Date(2024, 1, 2, 8, 0, 0, 0, time.UTC)) {
+        t.Fatalf("Should read the period of the row, got %+v", january)
+    }
+    if query := (*requests)[0].params.Get("query"); !strings.Contains(query, "toStartOfMonth(created_at)") {
+        t.Fatalf("Should group by the months, got %s", query)
+    }
+}
+
+func TestClickHouseHistoryRepository_Errors(t *testing.T) {
+    for _, test := range []struct {
+        name      string
+        status    int
+        fixture   string
+        message   string
+        transient bool
+    }{
+        {"exception row", http.StatusOK, "exception.jsonl", "MEMORY_LIMIT_EXCEEDED", false},
+        {"exception text", http.StatusOK, "exception.txt", "MEMORY_LIMIT_EXCEEDED", false},
+        {"unknown table", http.StatusNotFound, "unknown-table.txt", "UNKNOWN_TABLE", false},
+        {"unavailable", http.StatusServiceUnavailable, "", "503", true},
+    } {
+        t.Run(
+            test.name, func(t *testing.T) {
+                repo, _ := newFakeClickHouse(t, test.status, test.fixture)
+                utilization, err := repo.FindUtilization(context.Background(), &HistoryFilter{})
+                if err == nil || !strings.Contains(err.Error(), test.message) {
+                    t.Fatalf("Should fail with %s, got %v %v", test.message, utilization, err)
+                }
+                if errors.Is(err, ErrTransient) != test.transient {
+                    t.Fatalf("Should be transient %v, got %v", test.transient, err)
+                }
+            },
+        )
+    }
+
+    // the mirror retries the inserts while ClickHouse can't be reached
+    repo, _ := newFakeClickHouse(t, http.StatusOK, "")
+    repo.url.Host = "127.0.0.1:1"
+    if err := repo.Ping(context.Background()); !errors.Is(err, ErrTransient) {
+        t.Fatalf("Should be transient when ClickHouse can't be reached, got %v", err)
+    }
+}
@@ -0,0 +1,146 @@
+package repositories
+
+import (
+    "context"
+    "errors"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// IdempotentRequest is a request sent with an Idempotency-Key and its response, kept until ExpiresAt so the
+// retries of the request are answered with the same response instead of being processed again. A request in
+// progress has no status yet.
+type IdempotentRequest struct {
+    ID       primitive.ObjectID `bson:"_id,omitempty"`
+    TenantID string             `bson:"tenant_id,omitempty"`
+    Key      string             `bson:"key"`
+    // Fingerprint identifies the method, path and body of the request, a key can't be reused for another request
+    Fingerprint string         `bson:"fingerprint"`
+    Status      int            `bson:"status,omitempty"`
+    ContentType string         `bson:"content_type,omitempty"`
+    Body        []byte         `bson:"body,omitempty"`
+    CreatedAt   timestamp.Time `bson:"created_at"`
+    ExpiresAt   timestamp.Time `bson:"expires_at"`
+}
+
+type IdempotencyRepository interface {
+    // ReserveIdempotencyKey stores the request unless the tenant of the context has a request with its key stored,
+    // that request is returned then. An expired request is replaced, nil is returned when the request is stored.
+    ReserveIdempotencyKey(ctx context.Context, request *IdempotentRequest) (*IdempotentRequest, error)
+    // CompleteIdempotencyKey stores the response of the reserved request and when it expires
+    CompleteIdempotencyKey(ctx context.Context, request *IdempotentRequest) error
+    // ReleaseIdempotencyKey deletes the reserved request of the key, so its retries are processed again
+    ReleaseIdempotencyKey(ctx context.Context, key string) error
+}
+
+type MongoIdempotencyRepository struct {
+    collection *mongo.Collection
+}
+
+func NewMongoIdempotencyRepository(db *mongo.Database) *MongoIdempotencyRepository {
+    return &MongoIdempotencyRepository{collection: db.Collection("idempotency_keys")}
+}
+
+// CreateIndexes creates the unique index of the keys of every tenant and the index expiring the requests at their
+// expires_at
+func (repo *MongoIdempotencyRepository) CreateIndexes(ctx context.Context) error {
+    _, err := repo.collection.Indexes().CreateMany(
+        ctx,
+        []mongo.IndexModel{
+            {
+                Keys:    bson.D{{Key: "tenant_id", Value: 1}, {Key: "key", Value: 1}},
+                Options: options.Index().SetUnique(true),
+            },
+            {
+                Keys:    bson.D{{Key: "expires_at", Value: 1}},
+                Options: options.Index().SetExpireAfterSeconds(0),
+            },
+        },
+    )
+    return classify(err)
+}
+
+// keyFilter selects the request of the key of the tenant of the context, of no tenant without one
+func keyFilter(ctx context.Context, key string) bson.M {
+    filter := bson.M{"key": key, "tenant_id": bson.M{"$exists": false}}
+    if id, ok := tenant.FromContext(ctx); ok {
+        filter["tenant_id"] = id
+    }
+    return filter
+}
+
+// ReserveIdempotencyKey inserts the request unless the key exists, expired requests linger until MongoDB removes
+// them so they are replaced here. Concurrent inserts of a key fail on the unique index, they are retried once and
+// then find the request of the other insert.
+func (repo *MongoIdempotencyRepository) ReserveIdempotencyKey(
+    ctx context.Context,
+    request *IdempotentRequest,
+) (*IdempotentRequest, error) {
+    request.TenantID = tenantOf(ctx)
+    if request.CreatedAt.IsZero() {
+        request.CreatedAt = timestamp.Now()
+    }
+    var stored IdempotentRequest
+    var err error
+    for range 2 {
+        err = repo.collection.FindOneAndUpdate(
+            ctx,
+            keyFilter(ctx, request.Key),
+            bson.M{"$setOnInsert": request},
+            options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before),
+        ).Decode(&stored)
+        if !mongo.IsDuplicateKeyError(err) {
+            break
+        }
+    }
+    if errors.Is(err, mongo.ErrNoDocuments) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, classify(err)
+    }
+    if stored.ExpiresAt.After(time.Now()) {
+        return &stored, nil
+    }
+
+    // another retry may have replaced the expired request meanwhile, its request is returned then
+    result, err := repo.collection.ReplaceOne(
+        ctx,
+        bson.M{"_id": stored.ID, "expires_at": stored.ExpiresAt},
+        request,
+    )
+    if err != nil {
+        return nil, classify(err)
+    }
+    if result.ModifiedCount == 0 {
+        return repo.ReserveIdempotencyKey(ctx, request)
+    }
+    return nil, nil
+}
+
+func (repo *MongoIdempotencyRepository) CompleteIdempotencyKey(ctx context.Context, request *IdempotentRequest) error {
+    _, err := repo.collection.UpdateOne(
+        ctx,
+        keyFilter(ctx, request.Key),
+        bson.M{
+            "$set": bson.M{
+                "status":       request.Status,
+                "content_type": request.ContentType,
+                "body":         request.Body,
+                "expires_at":   request.ExpiresAt,
+            },
+        },
+    )
+    return classify(err)
+}
+
+func (repo *MongoIdempotencyRepository) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+    _, err := repo.collection.DeleteOne(ctx, keyFilter(ctx, key))
+    return classify(err)
+}
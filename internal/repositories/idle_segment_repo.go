@@ -0,0 +1,313 @@
+package repositories
+
+import (
+    "context"
+    "errors"
+    "log"
+    "strings"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// IdleSegment is a period an active vehicle stood still, segments crossing midnight UTC are split per day
+type IdleSegment struct {
+    ID              primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+    TenantID        string             `json:"tenant_id,omitempty" bson:"tenant_id,omitempty"`
+    VehicleID       primitive.ObjectID `json:"vehicle_id" bson:"vehicle_id"`
+    DriverID        string             `json:"driver_id,omitempty" bson:"driver_id,omitempty"`
+    Lat             float64            `json:"lat" bson:"lat"`
+    Lng             float64            `json:"lng" bson:"lng"`
+    Start           timestamp.Time     `json:"start" bson:"start"`
+    End             timestamp.Time     `json:"end" bson:"end"`
+    DurationSeconds float64            `json:"duration_seconds" bson:"duration_seconds"`
+}
+
+// IdleDay is the idling of a vehicle during a UTC day
+type IdleDay struct {
+    VehicleID   primitive.ObjectID `json:"vehicle_id"`
+    Day         timestamp.Time     `json:"day"`
+    Segments    int64              `json:"segments"`
+    IdleSeconds float64            `json:"idle_seconds"`
+}
+
+// IdleState is the previous reading of a vehicle the next one is compared with, with the idling it is in
+type IdleState struct {
+    VehicleID primitive.ObjectID `json:"vehicle_id" bson:"vehicle_id"`
+    TenantID  string             `json:"tenant_id,omitempty" bson:"tenant_id,omitempty"`
+    DriverID  string             `json:"driver_id,omitempty" bson:"driver_id,omitempty"`
+    Lat       float64            `json:"lat" bson:"lat"`
+    Lng       float64            `json:"lng" bson:"lng"`
+    At        timestamp.Time     `json:"at" bson:"at"`
+    Active    bool               `json:"active" bson:"active"`
+    // IdleSince is when the vehicle started idling at IdleLat and IdleLng, nil when it isn't idling
+    IdleSince *timestamp.Time `json:"idle_since,omitempty" bson:"idle_since,omitempty"`
+    IdleLat   float64         `json:"idle_lat,omitempty" bson:"idle_lat,omitempty"`
+    IdleLng   float64         `json:"idle_lng,omitempty" bson:"idle_lng,omitempty"`
+}
+
+type IdleFilter struct {
+    Page      int    `json:"page"`
+    PageSize  int    `json:"limit"`
+    VehicleID string `json:"vehicle_id" doc:"Comma separated vehicle ids"`
+    From      string `json:"from"`
+    To        string `json:"to"`
+
+    vehicleIDs []primitive.ObjectID
+    restricted []primitive.ObjectID
+    from       time.Time
+    to         time.Time
+}
+
+// RestrictVehicles limits the idling to the given vehicles, on top of the vehicle_id filter
+func (f *IdleFilter) RestrictVehicles(vehicleIDs []primitive.ObjectID) {
+    f.restricted = vehicleIDs
+}
+
+func (f *IdleFilter) Build() error {
+    if f.Page == 0 {
+        f.Page = 1
+    }
+    f.PageSize = pageSize(f.PageSize)
+    f.vehicleIDs = nil
+    if f.VehicleID != "" {
+        for _, value := range strings.Split(f.VehicleID, ",") {
+            id, err := primitive.ObjectIDFromHex(strings.TrimSpace(value))
+            if err != nil {
+                return ErrInvalidID
+            }
+            f.vehicleIDs = append(f.vehicleIDs, id)
+        }
+    }
+    if f.From != "" {
+        from, err := time.Parse(time.RFC3339, f.From)
+        if err != nil {
+            return ErrInvalidTimeRange
+        }
+        f.from = from
+    }
+    if f.To != "" {
+        to, err := time.Parse(time.RFC3339, f.To)
+        if err != nil {
+            return ErrInvalidTimeRange
+        }
+        f.to = to
+    }
+    if !f.from.IsZero() && !f.to.IsZero() && !f.from.Before(f.to) {
+        return ErrInvalidTimeRange
+    }
+    return nil
+}
+
+// match selects the segments of the vehicles of the filter starting during its time range
+func (f *IdleFilter) match(ctx context.Context) bson.M {
+    match := scopeTenant(ctx, bson.M{})
+    vehicleIDs := f.vehicleIDs
+    if f.restricted != nil {
+        vehicleIDs = []primitive.ObjectID{}
+        for _, id := range f.restricted {
+            if f.vehicleIDs == nil || len(intersect(f.vehicleIDs, id)) > 0 {
+                vehicleIDs = append(vehicleIDs, id)
+            }
+        }
+    }
+    if vehicleIDs != nil {
+        match["vehicle_id"] = bson.M{"$in": vehicleIDs}
+    }
+    if !f.from.IsZero() || !f.to.IsZero() {
+        start := bson.M{}
+        if !f.from.IsZero() {
+            start["$gte"] = f.from
+        }
+        if !f.to.IsZero() {
+            start["$lt"] = f.to
+        }
+        match["start"] = start
+    }
+    return match
+}
+
+type IdleSegmentRepository interface {
+    CreateIdleSegments(ctx context.Context, segments []*IdleSegment) error
+    // FindIdleSegments returns a page of the segments of the filter, the latest first
+    FindIdleSegments(ctx context.Context, filter *IdleFilter) ([]*IdleSegment, error)
+    // SumIdleDays returns a page of the idling of the vehicles of the filter per day, the latest day first
+    SumIdleDays(ctx context.Context, filter *IdleFilter) ([]*IdleDay, error)
+    // FindIdleState returns the state of the vehicle, nil when it has none yet
+    FindIdleState(ctx context.Context, vehicleID primitive.ObjectID) (*IdleState, error)
+    SaveIdleState(ctx context.Context, state *IdleState) error
+}
+
+type MongoIdleSegmentRepository struct {
+    segments *mongo.Collection
+    states   *mongo.Collection
+}
+
+func NewMongoIdleSegmentRepository(db *mongo.Database) *MongoIdleSegmentRepository {
+    return &MongoIdleSegmentRepository{
+        segments: db.Collection("idle_segments"),
+        states:   db.Collection("idle_states"),
+    }
+}
+
+// CreateIndexes creates the index of the segments of every vehicle and the unique index of the state of every
+// vehicle
+func (repo *MongoIdleSegmentRepository) CreateIndexes(ctx context.Context) error {
+    _, err := repo.segments.Indexes().CreateOne(
+        ctx,
+        mongo.IndexModel{
+            Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "vehicle_id", Value: 1}, {Key: "start", Value: -1}},
+        },
+    )
+    if err != nil {
+        return classify(err)
+    }
+    _, err = repo.states.Indexes().CreateOne(
+        ctx,
+        mongo.IndexModel{
+            Keys:    bson.D{{Key: "tenant_id", Value: 1}, {Key: "vehicle_id", Value: 1}},
+            Options: options.Index().SetUnique(true),
+        },
+    )
+    return classify(err)
+}
+
+func (repo *MongoIdleSegmentRepository) CreateIdleSegments(ctx context.Context, segments []*IdleSegment) error {
+    if len(segments) == 0 {
+        return nil
+    }
+    documents := make([]any, 0, len(segments))
+    for _, segment := range segments {
+        segment.TenantID = tenantOf(ctx)
+        documents = append(documents, segment)
+    }
+    result, err := repo.segments.InsertMany(ctx, documents)
+    if err != nil {
+        return classify(err)
+    }
+    for i, id := range result.InsertedIDs {
+        segments[i].ID = id.(primitive.ObjectID)
+    }
+    return nil
+}
+
+func (repo *MongoIdleSegmentRepository) FindIdleSegments(
+    ctx context.Context,
+    filter *IdleFilter,
+) ([]*IdleSegment, error) {
+    if err := filter.Build(); err != nil {
+        return nil, err
+    }
+    cursor, err := repo.segments.Find(
+        ctx,
+        filter.match(ctx),
+        options.Find().
+            SetSort(bson.D{{Key: "start", Value: -1}, {Key: "_id", Value: -1}}).
+            SetSkip(int64((filter.Page-1)*filter.PageSize)).
+            SetLimit(int64(filter.PageSize)),
+    )
+    if err != nil {
+        return nil, classify(err)
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
+        }
+    }(cursor, ctx)
+    var segments []*IdleSegment
+    for cursor.Next(ctx) {
+        var segment IdleSegment
+        if err := cursor.Decode(&segment); err != nil {
+            return nil, err
+        }
+        segments = append(segments, &segment)
+    }
+    return segments, cursor.Err()
+}
+
+func (repo *MongoIdleSegmentRepository) SumIdleDays(ctx context.Context, filter *IdleFilter) ([]*IdleDay, error) {
+    if err := filter.Build(); err != nil {
+        return nil, err
+    }
+    pipeline := mongo.Pipeline{
+        {{Key: "$match", Value: filter.match(ctx)}},
+        {{
+            Key: "$group", Value: bson.M{
+                "_id": bson.D{
+                    {Key: "vehicle_id", Value: "$vehicle_id"},
+                    {Key: "day", Value: bson.M{"$dateTrunc": bson.M{"date": "$start", "unit": "day"}}},
+                },
+                "segments":     bson.M{"$sum": 1},
+                "idle_seconds": bson.M{"$sum": "$duration_seconds"},
+            },
+        }},
+        {{Key: "$sort", Value: bson.D{{Key: "_id.day", Value: -1}, {Key: "_id.vehicle_id", Value: 1}}}},
+        {{Key: "$skip", Value: int64((filter.Page - 1) * filter.PageSize)}},
+        {{Key: "$limit", Value: int64(filter.PageSize)}},
+    }
+    cursor, err := repo.segments.Aggregate(ctx, pipeline)
+    if err != nil {
+        return nil, classify(err)
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
+        }
+    }(cursor, ctx)
+    var days []*IdleDay
+    for cursor.Next(ctx) {
+        var result struct {
+            ID struct {
+                VehicleID primitive.ObjectID `bson:"vehicle_id"`
+                Day       time.Time          `bson:"day"`
+            } `bson:"_id"`
+            Segments    int64   `bson:"segments"`
+            IdleSeconds float64 `bson:"idle_seconds"`
+        }
+        if err := cursor.Decode(&result); err != nil {
+            return nil, err
+        }
+        days = append(
+            days,
+            &IdleDay{
+                VehicleID:   result.ID.VehicleID,
+                Day:         timestamp.New(result.ID.Day),
+                Segments:    result.Segments,
+                IdleSeconds: result.IdleSeconds,
+            },
+        )
+    }
+    return days, cursor.Err()
+}
+
+func (repo *MongoIdleSegmentRepository) FindIdleState(
+    ctx context.Context,
+    vehicleID primitive.ObjectID,
+) (*IdleState, error) {
+    var state IdleState
+    err := repo.states.FindOne(ctx, scopeTenant(ctx, bson.M{"vehicle_id": vehicleID})).Decode(&state)
+    if errors.Is(err, mongo.ErrNoDocuments) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, classify(err)
+    }
+    return &state, nil
+}
+
+func (repo *MongoIdleSegmentRepository) SaveIdleState(ctx context.Context, state *IdleState) error {
+    state.TenantID = tenantOf(ctx)
+    _, err := repo.states.ReplaceOne(
+        ctx,
+        scopeTenant(ctx, bson.M{"vehicle_id": state.VehicleID}),
+        state,
+        options.Replace().SetUpsert(true),
+    )
+    return classify(err)
+}
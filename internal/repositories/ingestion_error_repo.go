@@ -0,0 +1,222 @@
+package repositories
+
+import (
+    "context"
+    "log"
+    "slices"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+    IngestionSourceAMQP = "amqp"
+    IngestionSourceHTTP = "http"
+
+    IngestionReasonMalformed     = "malformed_payload"
+    IngestionReasonInvalid       = "invalid_data"
+    IngestionReasonStorageFailed = "storage_failed"
+    // IngestionReasonUnknownVehicle is a reading of a vehicle the vehicle service doesn't know, it is quarantined
+    IngestionReasonUnknownVehicle = "unknown_vehicle"
+    // IngestionReasonQuarantined is a reading failing the data quality checks, it waits for a review in the quarantine
+    IngestionReasonQuarantined = "quarantined"
+)
+
+// IngestionError is the summary of a rejected tracking data message
+type IngestionError struct {
+    ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+    TenantID  string             `json:"tenant_id,omitempty" bson:"tenant_id,omitempty"`
+    Source    string             `json:"source" bson:"source"`
+    Reason    string             `json:"reason" bson:"reason"`
+    Error     string             `json:"error" bson:"error"`
+    VehicleID string             `json:"vehicle_id,omitempty" bson:"vehicle_id,omitempty"`
+    Payload   string             `json:"payload,omitempty" bson:"payload,omitempty"`
+    CreatedAt timestamp.Time     `json:"created_at" bson:"created_at"`
+}
+
+type IngestionErrorFilter struct {
+    Page      int    `json:"page"`
+    PageSize  int    `json:"limit"`
+    Source    string `json:"source"`
+    Reason    string `json:"reason"`
+    VehicleID string `json:"vehicle_id"`
+    From      string `json:"from"`
+    To        string `json:"to"`
+
+    from       time.Time
+    to         time.Time
+    vehicleIDs []string
+}
+
+// RestrictVehicles limits the results to the given vehicles, on top of the vehicle_id filter
+func (f *IngestionErrorFilter) RestrictVehicles(vehicleIDs []string) {
+    f.vehicleIDs = vehicleIDs
+}
+
+func (f *IngestionErrorFilter) Build() error {
+    if f.Page == 0 {
+        f.Page = 1
+    }
+    f.PageSize = pageSize(f.PageSize)
+    if f.From != "" {
+        from, err := time.Parse(time.RFC3339, f.From)
+        if err != nil {
+            return ErrInvalidTimeRange
+        }
+        f.from = from
+    }
+    if f.To != "" {
+        to, err := time.Parse(time.RFC3339, f.To)
+        if err != nil {
+            return ErrInvalidTimeRange
+        }
+        f.to = to
+    }
+    if !f.from.IsZero() && !f.to.IsZero() && !f.from.Before(f.to) {
+        return ErrInvalidTimeRange
+    }
+    return nil
+}
+
+type IngestionErrorRepository interface {
+    CreateIngestionError(ctx context.Context, ingestionError *IngestionError) error
+    FindIngestionErrors(ctx context.Context, filter *IngestionErrorFilter) ([]*IngestionError, error)
+    CountIngestionErrors(ctx context.Context, vehicleIDs []string, from, to time.Time) (map[string]int64, error)
+}
+
+type MongoIngestionErrorRepository struct {
+    collection *mongo.Collection
+}
+
+func NewMongoIngestionErrorRepository(db *mongo.Database) *MongoIngestionErrorRepository {
+    return &MongoIngestionErrorRepository{
+        collection: db.Collection("ingestion_errors"),
+    }
+}
+
+func (repo *MongoIngestionErrorRepository) CreateIngestionError(
+    ctx context.Context,
+    ingestionError *IngestionError,
+) error {
+    if ingestionError.CreatedAt.IsZero() {
+        ingestionError.CreatedAt = timestamp.Now()
+    }
+    ingestionError.TenantID = tenantOf(ctx)
+    result, err := repo.collection.InsertOne(ctx, ingestionError)
+    if err != nil {
+        return err
+    }
+    ingestionError.ID = result.InsertedID.(primitive.ObjectID)
+    return nil
+}
+
+func (repo *MongoIngestionErrorRepository) FindIngestionErrors(
+    ctx context.Context,
+    filter *IngestionErrorFilter,
+) ([]*IngestionError, error) {
+    var ingestionErrors []*IngestionError
+    bsonMFilter := scopeTenant(ctx, bson.M{})
+    // the newest errors are the most relevant when debugging
+    findOptions := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}})
+    if filter != nil {
+        if err := filter.Build(); err != nil {
+            return nil, err
+        }
+        if filter.Source != "" {
+            bsonMFilter["source"] = filter.Source
+        }
+        if filter.Reason != "" {
+            bsonMFilter["reason"] = filter.Reason
+        }
+        if filter.VehicleID != "" {
+            bsonMFilter["vehicle_id"] = filter.VehicleID
+        }
+        if filter.vehicleIDs != nil {
+            vehicleIDs := filter.vehicleIDs
+            if filter.VehicleID != "" {
+                vehicleIDs = slices.DeleteFunc(
+                    slices.Clone(vehicleIDs), func(id string) bool {
+                        return id != filter.VehicleID
+                    },
+                )
+            }
+            bsonMFilter["vehicle_id"] = bson.M{"$in": vehicleIDs}
+        }
+        if !filter.from.IsZero() || !filter.to.IsZero() {
+            createdAt := bson.M{}
+            if !filter.from.IsZero() {
+                createdAt["$gte"] = filter.from
+            }
+            if !filter.to.IsZero() {
+                createdAt["$lt"] = filter.to
+            }
+            bsonMFilter["created_at"] = createdAt
+        }
+        findOptions.SetSkip(int64((filter.Page - 1) * filter.PageSize))
+        findOptions.SetLimit(int64(filter.PageSize))
+    }
+    cursor, err := repo.collection.Find(ctx, bsonMFilter, findOptions)
+    if err != nil {
+        return nil, err
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
+        }
+    }(cursor, ctx)
+    for cursor.Next(ctx) {
+        var ingestionError IngestionError
+        if err := cursor.Decode(&ingestionError); err != nil {
+            return nil, err
+        }
+        ingestionErrors = append(ingestionErrors, &ingestionError)
+    }
+    return ingestionErrors, nil
+}
+
+// CountIngestionErrors counts the errors per vehicle created in [from, to)
+func (repo *MongoIngestionErrorRepository) CountIngestionErrors(
+    ctx context.Context,
+    vehicleIDs []string,
+    from, to time.Time,
+) (map[string]int64, error) {
+    pipeline := mongo.Pipeline{
+        {{
+            Key: "$match", Value: scopeTenant(
+                ctx, bson.M{
+                    "vehicle_id": bson.M{"$in": vehicleIDs},
+                    "created_at": bson.M{"$gte": from, "$lt": to},
+                },
+            ),
+        }},
+        {{Key: "$group", Value: bson.M{"_id": "$vehicle_id", "count": bson.M{"$sum": 1}}}},
+    }
+    cursor, err := repo.collection.Aggregate(ctx, pipeline)
+    if err != nil {
+        return nil, err
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
+        }
+    }(cursor, ctx)
+
+    counts := make(map[string]int64, len(vehicleIDs))
+    for cursor.Next(ctx) {
+        var count struct {
+            VehicleID string `bson:"_id"`
+            Count     int64  `bson:"count"`
+        }
+        if err := cursor.Decode(&count); err != nil {
+            return nil, err
+        }
+        counts[count.VehicleID] = count.Count
+    }
+    return counts, cursor.Err()
+}
@@ -0,0 +1,201 @@
+package repositories
+
+import (
+    "context"
+    "errors"
+    "log"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/ulid"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MaintenanceThreshold overrides the global maintenance interval of a vehicle,
+// maintenance is due every Interval of mileage
+type MaintenanceThreshold struct {
+    VehicleID primitive.ObjectID `json:"vehicle_id" bson:"_id"`
+    Interval  float64            `json:"interval" bson:"interval"`
+    UpdatedAt timestamp.Time     `json:"updated_at" bson:"updated_at"`
+}
+
+// MaintenanceEvent is recorded once per vehicle and crossed threshold
+type MaintenanceEvent struct {
+    ID                   primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+    PublicID             string             `json:"public_id,omitempty" bson:"public_id,omitempty"`
+    TenantID             string             `json:"tenant_id,omitempty" bson:"tenant_id,omitempty"`
+    VehicleID            primitive.ObjectID `json:"vehicle_id" bson:"vehicle_id"`
+    TrackingDataID       primitive.ObjectID `json:"tracking_data_id" bson:"tracking_data_id"`
+    TrackingDataPublicID string             `json:"tracking_data_public_id,omitempty" bson:"tracking_data_public_id,omitempty"`
+    Threshold            float64            `json:"threshold" bson:"threshold"`
+    Interval             float64            `json:"interval" bson:"interval"`
+    Mileage              float64            `json:"mileage" bson:"mileage"`
+    CreatedAt            timestamp.Time     `json:"created_at" bson:"created_at"`
+}
+
+type MaintenanceEventFilter struct {
+    Page      int    `json:"page"`
+    PageSize  int    `json:"limit"`
+    VehicleID string `json:"vehicle_id"`
+
+    vehicleID primitive.ObjectID
+}
+
+func (f *MaintenanceEventFilter) Build() error {
+    if f.Page == 0 {
+        f.Page = 1
+    }
+    f.PageSize = pageSize(f.PageSize)
+    if f.VehicleID != "" {
+        id, err := primitive.ObjectIDFromHex(f.VehicleID)
+        if err != nil {
+            return ErrInvalidID
+        }
+        f.vehicleID = id
+    }
+    return nil
+}
+
+type MaintenanceRepository interface {
+    FindThreshold(ctx context.Context, vehicleID primitive.ObjectID) (*MaintenanceThreshold, error)
+    FindThresholds(ctx context.Context) ([]*MaintenanceThreshold, error)
+    UpsertThreshold(ctx context.Context, threshold *MaintenanceThreshold) error
+    // RecordEvent stores the event unless the vehicle already crossed the same threshold,
+    // created is false for duplicates
+    RecordEvent(ctx context.Context, event *MaintenanceEvent) (created bool, err error)
+    FindEvents(ctx context.Context, filter *MaintenanceEventFilter) ([]*MaintenanceEvent, error)
+}
+
+type MongoMaintenanceRepository struct {
+    thresholds *mongo.Collection
+    events     *mongo.Collection
+}
+
+func NewMongoMaintenanceRepository(db *mongo.Database) *MongoMaintenanceRepository {
+    return &MongoMaintenanceRepository{
+        thresholds: db.Collection("maintenance_thresholds"),
+        events:     db.Collection("maintenance_events"),
+    }
+}
+
+// FindThreshold returns the threshold of the vehicle, nil when it uses the global interval
+func (repo *MongoMaintenanceRepository) FindThreshold(
+    ctx context.Context,
+    vehicleID primitive.ObjectID,
+) (*MaintenanceThreshold, error) {
+    var threshold MaintenanceThreshold
+    err := repo.thresholds.FindOne(ctx, bson.M{"_id": vehicleID}).Decode(&threshold)
+    if errors.Is(err, mongo.ErrNoDocuments) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    return &threshold, nil
+}
+
+func (repo *MongoMaintenanceRepository) FindThresholds(ctx context.Context) ([]*MaintenanceThreshold, error) {
+    var thresholds []*MaintenanceThreshold
+    cursor, err := repo.thresholds.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+    if err != nil {
+        return nil, err
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
+        }
+    }(cursor, ctx)
+    for cursor.Next(ctx) {
+        var threshold MaintenanceThreshold
+        if err := cursor.Decode(&threshold); err != nil {
+            return nil, err
+        }
+        thresholds = append(thresholds, &threshold)
+    }
+    return thresholds, nil
+}
+
+func (repo *MongoMaintenanceRepository) UpsertThreshold(ctx context.Context, threshold *MaintenanceThreshold) error {
+    threshold.UpdatedAt = timestamp.Now()
+    _, err := repo.thresholds.UpdateOne(
+        ctx,
+        bson.M{"_id": threshold.VehicleID},
+        bson.M{"$set": bson.M{"interval": threshold.Interval, "updated_at": threshold.UpdatedAt}},
+        options.Update().SetUpsert(true),
+    )
+    return err
+}
+
+func (repo *MongoMaintenanceRepository) RecordEvent(ctx context.Context, event *MaintenanceEvent) (bool, error) {
+    if event.CreatedAt.IsZero() {
+        event.CreatedAt = timestamp.Now()
+    }
+    if event.PublicID == "" {
+        event.PublicID = ulid.New(event.CreatedAt.Time)
+    }
+    event.TenantID = tenantOf(ctx)
+    // the tenant of the filter is set on the inserted event
+    result, err := repo.events.UpdateOne(
+        ctx,
+        scopeTenant(ctx, bson.M{"vehicle_id": event.VehicleID, "threshold": event.Threshold}),
+        bson.M{
+            "$setOnInsert": bson.M{
+                "public_id":               event.PublicID,
+                "tracking_data_id":        event.TrackingDataID,
+                "tracking_data_public_id": event.TrackingDataPublicID,
+                "interval":                event.Interval,
+                "mileage":                 event.Mileage,
+                "created_at":              event.CreatedAt,
+            },
+        },
+        options.Update().SetUpsert(true),
+    )
+    if err != nil {
+        return false, err
+    }
+    if result.UpsertedID == nil {
+        return false, nil
+    }
+    event.ID = result.UpsertedID.(primitive.ObjectID)
+    return true, nil
+}
+
+func (repo *MongoMaintenanceRepository) FindEvents(
+    ctx context.Context,
+    filter *MaintenanceEventFilter,
+) ([]*MaintenanceEvent, error) {
+    var events []*MaintenanceEvent
+    bsonMFilter := scopeTenant(ctx, bson.M{})
+    findOptions := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}})
+    if filter != nil {
+        if err := filter.Build(); err != nil {
+            return nil, err
+        }
+        if !filter.vehicleID.IsZero() {
+            bsonMFilter["vehicle_id"] = filter.vehicleID
+        }
+        findOptions.SetSkip(int64((filter.Page - 1) * filter.PageSize))
+        findOptions.SetLimit(int64(filter.PageSize))
+    }
+    cursor, err := repo.events.Find(ctx, bsonMFilter, findOptions)
+    if err != nil {
+        return nil, err
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
+        }
+    }(cursor, ctx)
+    for cursor.Next(ctx) {
+        var event MaintenanceEvent
+        if err := cursor.Decode(&event); err != nil {
+            return nil, err
+        }
+        events = append(events, &event)
+    }
+    return events, nil
+}
@@ -0,0 +1,499 @@
+package repositories
+
+import (
+    "bytes"
+    "cmp"
+    "context"
+    "fmt"
+    "maps"
+    "slices"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memorySortFields compare two records by a stored sortable field, present reports whether a record has the
+// field, records without it are ordered last like in the Mongo queries
+var memorySortFields = map[string]struct {
+    compare func(a, b *TrackingRecord) int
+    present func(r *TrackingRecord) bool
+}{
+    "_id": {compare: func(a, b *TrackingRecord) int { return bytes.Compare(a.ID[:], b.ID[:]) }},
+    "public_id": {
+        compare: func(a, b *TrackingRecord) int { return strings.Compare(a.PublicID, b.PublicID) },
+        present: func(r *TrackingRecord) bool { return r.PublicID != "" },
+    },
+    "vehicle_id": {
+        compare: func(a, b *TrackingRecord) int { return bytes.Compare(a.VehicleID[:], b.VehicleID[:]) },
+    },
+    "created_at": {compare: func(a, b *TrackingRecord) int { return a.CreatedAt.Compare(b.CreatedAt) }},
+    "updated_at": {compare: func(a, b *TrackingRecord) int { return a.UpdatedAt.Compare(b.UpdatedAt) }},
+    "device_time": {
+        compare: func(a, b *TrackingRecord) int { return a.DeviceTime.Compare(b.DeviceTime.Time) },
+        present: func(r *TrackingRecord) bool { return r.DeviceTime != nil },
+    },
+    "received_time": {
+        compare: func(a, b *TrackingRecord) int { return a.ReceivedTime.Compare(b.ReceivedTime.Time) },
+        present: func(r *TrackingRecord) bool { return r.ReceivedTime != nil },
+    },
+    "location": {compare: func(a, b *TrackingRecord) int { return strings.Compare(a.Location, b.Location) }},
+    "mileage":  {compare: func(a, b *TrackingRecord) int { return cmp.Compare(a.Mileage, b.Mileage) }},
+    "status":   {compare: func(a, b *TrackingRecord) int { return cmp.Compare(a.Status, b.Status) }},
+    "fuel_condition": {
+        compare: func(a, b *TrackingRecord) int { return cmp.Compare(a.FuelCondition, b.FuelCondition) },
+    },
+    "distance_meters": {
+        compare: func(a, b *TrackingRecord) int { return cmp.Compare(*a.DistanceMeters, *b.DistanceMeters) },
+        present: func(r *TrackingRecord) bool { return r.DistanceMeters != nil },
+    },
+    "odometer_meters": {
+        compare: func(a, b *TrackingRecord) int { return cmp.Compare(*a.OdometerMeters, *b.OdometerMeters) },
+        present: func(r *TrackingRecord) bool { return r.OdometerMeters != nil },
+    },
+}
+
+// memoryRecord is a stored record, soft deleted records are kept with the time they were deleted
+type memoryRecord struct {
+    record    *TrackingRecord
+    deletedAt time.Time
+}
+
+// MemoryTrackingRepository keeps the tracking data in memory with the filter, sort and pagination semantics of
+// MongoTackingRepository, for unit tests and running the service without MongoDB. The records are copied in and
+// out, so callers can't change the stored ones. Projections aren't applied, the handlers project the records.
+type MemoryTrackingRepository struct {
+    mu      sync.RWMutex
+    records []*memoryRecord
+}
+
+func NewMemoryTrackingRepository() *MemoryTrackingRepository {
+    return &MemoryTrackingRepository{}
+}
+
+// copyRecord copies a record, the flags are the only values of a stored record that change
+func copyRecord(record *TrackingRecord) *TrackingRecord {
+    copied := *record
+    copied.Flags = slices.Clone(record.Flags)
+    copied.Sensors = maps.Clone(record.Sensors)
+    return &copied
+}
+
+func (repo *MemoryTrackingRepository) CreateTrackingData(ctx context.Context, trackingData *TrackingRecord) error {
+    repo.mu.Lock()
+    defer repo.mu.Unlock()
+    return repo.create(ctx, trackingData)
+}
+
+func (repo *MemoryTrackingRepository) CreateManyTrackingData(
+    ctx context.Context,
+    trackingData []*TrackingRecord,
+) ([]error, error) {
+    repo.mu.Lock()
+    defer repo.mu.Unlock()
+    itemErrs := make([]error, len(trackingData))
+    for i, data := range trackingData {
+        itemErrs[i] = repo.create(ctx, data)
+    }
+    return itemErrs, nil
+}
+
+// create stores a copy of the record, the ids are unique like with the indexes of the collection
+func (repo *MemoryTrackingRepository) create(ctx context.Context, trackingData *TrackingRecord) error {
+    if err := trackingData.Build(); err != nil {
+        return err
+    }
+    if trackingData.CreatedAt.IsZero() {
+        trackingData.CreatedAt = time.Now().UTC()
+        trackingData.UpdatedAt = trackingData.CreatedAt
+    }
+    trackingData.TenantID = tenantOf(ctx)
+    if trackingData.ID.IsZero() {
+        trackingData.ID = primitive.NewObjectID()
+    }
+    assignPublicID(trackingData)
+    for _, stored := range repo.records {
+        if stored.record.ID == trackingData.ID || stored.record.PublicID == trackingData.PublicID {
+            return fmt.Errorf("%w: tracking data %s", ErrDuplicate, trackingData.ID.Hex())
+        }
+    }
+    repo.records = append(repo.records, &memoryRecord{record: copyRecord(trackingData)})
+    return nil
+}
+
+// visible reports whether the stored record isn't deleted and belongs to the tenant of the context
+func visible(ctx context.Context, stored *memoryRecord) bool {
+    if !stored.deletedAt.IsZero() {
+        return false
+    }
+    id, ok := tenant.FromContext(ctx)
+    return !ok || stored.record.TenantID == id
+}
+
+// find returns copies of the visible records matching the filter in the order of the filter, the filter is built
+func (repo *MemoryTrackingRepository) find(ctx context.Context, filter *TrackingFilter) ([]*TrackingRecord, error) {
+    if filter == nil {
+        filter = &TrackingFilter{}
+    }
+    if err := filter.Build(); err != nil {
+        return nil, err
+    }
+    repo.mu.RLock()
+    defer repo.mu.RUnlock()
+    var records []*TrackingRecord
+    for _, stored := range repo.records {
+        if visible(ctx, stored) && filter.matches(stored.record) {
+            records = append(records, copyRecord(stored.record))
+        }
+    }
+    sortRecords(records, filter.SortKeys())
+    return records, nil
+}
+
+// matches reports whether the record matches the built filter, like the match of buildQuery
+func (t *TrackingFilter) matches(record *TrackingRecord) bool {
+    for _, flag := range t.excluded {
+        if slices.Contains(record.Flags, flag) {
+            return false
+        }
+    }
+    switch {
+    case t.publicID != "" && record.PublicID != t.publicID:
+        return false
+    case t.publicID == "" && t.ID != "" && record.ID != t.id:
+        return false
+    case !t.startsAfter(record.ID):
+        return false
+    case t.selected != nil && !slices.Contains(t.selected, record.VehicleID):
+        return false
+    case t.vehicleIDs != nil && !slices.Contains(t.vehicleIDs, record.VehicleID):
+        return false
+    case len(t.driverIDs) > 0 && !slices.Contains(t.driverIDs, record.DriverID):
+        return false
+    case t.Location != "" && !strings.HasPrefix(strings.ToLower(record.Location), strings.ToLower(t.Location)):
+        return false
+    case t.MileageMin != nil && record.Mileage < *t.MileageMin:
+        return false
+    case t.MileageMax != nil && record.Mileage > *t.MileageMax:
+        return false
+    case len(t.statuses) > 0 && !slices.Contains(t.statuses, record.Status):
+        return false
+    case len(t.fuelConditions) > 0 && !slices.Contains(t.fuelConditions, record.FuelCondition):
+        return false
+    case !t.inTimeRange(record):
+        return false
+    }
+    for _, condition := range t.sensors {
+        if !condition.Matches(record.Sensors) {
+            return false
+        }
+    }
+    return true
+}
+
+// inTimeRange reports whether the timestamp of the time axis of the record is in the time range, a record without
+// that timestamp only matches without a time range like in Mongo
+func (t *TrackingFilter) inTimeRange(record *TrackingRecord) bool {
+    if t.from.IsZero() && t.to.IsZero() {
+        return true
+    }
+    at := record.CreatedAt
+    switch t.TimeField() {
+    case "device_time":
+        if record.DeviceTime == nil {
+            return false
+        }
+        at = record.DeviceTime.Time
+    case "received_time":
+        if record.ReceivedTime == nil {
+            return false
+        }
+        at = record.ReceivedTime.Time
+    }
+    return (t.from.IsZero() || !at.Before(t.from)) && (t.to.IsZero() || at.Before(t.to))
+}
+
+// sortRecords orders the records by the sort keys, the records missing a field last in both directions
+func sortRecords(records []*TrackingRecord, sortKeys []SortKey) {
+    slices.SortStableFunc(
+        records, func(a, b *TrackingRecord) int {
+            for _, key := range sortKeys {
+                field := memorySortFields[key.Field]
+                if field.present != nil {
+                    aPresent, bPresent := field.present(a), field.present(b)
+                    if aPresent != bPresent {
+                        if aPresent {
+                            return -1
+                        }
+                        return 1
+                    }
+                    if !aPresent {
+                        continue
+                    }
+                }
+                if c := field.compare(a, b) * key.Order; c != 0 {
+                    return c
+                }
+            }
+            return 0
+        },
+    )
+}
+
+func (repo *MemoryTrackingRepository) FindTrackingData(
+    ctx context.Context,
+    filter *TrackingFilter,
+) ([]*TrackingRecord, error) {
+    records, err := repo.find(ctx, filter)
+    if err != nil || filter == nil {
+        return records, err
+    }
+    skip := min(max(filter.Page-1, 0)*filter.PageSize, len(records))
+    return records[skip:min(skip+filter.PageSize, len(records))], nil
+}
+
+func (repo *MemoryTrackingRepository) FindTrackingDataByID(ctx context.Context, id string) (*TrackingRecord, error) {
+    objectID, publicID, err := parseID(id)
+    if err != nil {
+        return nil, err
+    }
+    repo.mu.RLock()
+    defer repo.mu.RUnlock()
+    for _, stored := range repo.records {
+        if !visible(ctx, stored) {
+            continue
+        }
+        if (publicID != "" && stored.record.PublicID == publicID) || (publicID == "" && stored.record.ID == objectID) {
+            return copyRecord(stored.record), nil
+        }
+    }
+    return nil, ErrTrackingDataNotFound
+}
+
+// StreamTrackingData calls fn for the tracking data matching the filter when they were found, the tracking data
+// stored meanwhile isn't streamed
+func (repo *MemoryTrackingRepository) StreamTrackingData(
+    ctx context.Context,
+    filter *TrackingFilter,
+    fn func(trackingData *TrackingRecord) error,
+) error {
+    records, err := repo.find(ctx, filter)
+    if err != nil {
+        return err
+    }
+    for _, record := range records {
+        if err := ctx.Err(); err != nil {
+            return err
+        }
+        if err := fn(record); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (repo *MemoryTrackingRepository) FindLatestTrackingData(
+    ctx context.Context,
+    limits []VehicleLimit,
+) (map[primitive.ObjectID][]*TrackingRecord, error) {
+    trackingData := make(map[primitive.ObjectID][]*TrackingRecord, len(limits))
+    repo.mu.RLock()
+    defer repo.mu.RUnlock()
+    for _, limit := range limits {
+        var records []*TrackingRecord
+        for _, stored := range repo.records {
+            if visible(ctx, stored) && stored.record.VehicleID == limit.VehicleID {
+                records = append(records, copyRecord(stored.record))
+            }
+        }
+        if records != nil {
+            trackingData[limit.VehicleID] = latestOf(records, limit.Limit)
+        }
+    }
+    return trackingData, nil
+}
+
+func (repo *MemoryTrackingRepository) FindTrackingDataAfter(
+    ctx context.Context,
+    after primitive.ObjectID,
+    vehicleIDs []primitive.ObjectID,
+    limit int,
+) ([]*TrackingRecord, error) {
+    repo.mu.RLock()
+    defer repo.mu.RUnlock()
+    var records []*TrackingRecord
+    for _, stored := range repo.records {
+        if visible(ctx, stored) &&
+            bytes.Compare(stored.record.ID[:], after[:]) > 0 &&
+            slices.Contains(vehicleIDs, stored.record.VehicleID) {
+            records = append(records, copyRecord(stored.record))
+        }
+    }
+    sortRecords(records, []SortKey{{Field: "_id", Order: 1}})
+    return records[:min(limit, len(records))], nil
+}
+
+// deletable returns the stored records of the vehicle created before before that a deletion changes, the soft
+// deleted ones only when purging
+func (repo *MemoryTrackingRepository) deletable(
+    ctx context.Context,
+    vehicleID primitive.ObjectID,
+    before time.Time,
+    purge bool,
+) []*memoryRecord {
+    id, scoped := tenant.FromContext(ctx)
+    var deletable []*memoryRecord
+    for _, stored := range repo.records {
+        switch {
+        case stored.record.VehicleID != vehicleID,
+            scoped && stored.record.TenantID != id,
+            !before.IsZero() && !stored.record.CreatedAt.Before(before),
+            !purge && !stored.deletedAt.IsZero():
+            continue
+        }
+        deletable = append(deletable, stored)
+    }
+    return deletable
+}
+
+func (repo *MemoryTrackingRepository) DeleteTrackingData(
+    ctx context.Context,
+    vehicleID primitive.ObjectID,
+    before time.Time,
+    purge bool,
+) (int64, error) {
+    repo.mu.Lock()
+    defer repo.mu.Unlock()
+    deletable := repo.deletable(ctx, vehicleID, before, purge)
+    if purge {
+        repo.records = slices.DeleteFunc(
+            repo.records, func(stored *memoryRecord) bool {
+                return slices.Contains(deletable, stored)
+            },
+        )
+        return int64(len(deletable)), nil
+    }
+    deletedAt := time.Now()
+    for _, stored := range deletable {
+        stored.deletedAt = deletedAt
+    }
+    return int64(len(deletable)), nil
+}
+
+func (repo *MemoryTrackingRepository) FindDeletionScope(
+    ctx context.Context,
+    vehicleID primitive.ObjectID,
+    before time.Time,
+    purge bool,
+    samples int,
+) (int64, []string, error) {
+    repo.mu.RLock()
+    defer repo.mu.RUnlock()
+    var records []*TrackingRecord
+    for _, stored := range repo.deletable(ctx, vehicleID, before, purge) {
+        records = append(records, stored.record)
+    }
+    sortRecords(records, []SortKey{{Field: "created_at", Order: 1}, {Field: "_id", Order: 1}})
+    ids := []string{}
+    for _, record := range records[:min(samples, len(records))] {
+        id := record.PublicID
+        if id == "" {
+            id = record.ID.Hex()
+        }
+        ids = append(ids, id)
+    }
+    return int64(len(records)), ids, nil
+}
+
+func (repo *MemoryTrackingRepository) FlagTrackingData(
+    ctx context.Context,
+    trackingData *TrackingRecord,
+    flag string,
+) error {
+    repo.mu.Lock()
+    defer repo.mu.Unlock()
+    id, scoped := tenant.FromContext(ctx)
+    for _, stored := range repo.records {
+        if stored.record.ID == trackingData.ID && (!scoped || stored.record.TenantID == id) {
+            stored.record.AddFlag(flag)
+        }
+    }
+    trackingData.AddFlag(flag)
+    return nil
+}
+
+func (repo *MemoryTrackingRepository) UpdateTrackingDistances(
+    ctx context.Context,
+    trackingData []*TrackingRecord,
+) error {
+    repo.mu.Lock()
+    defer repo.mu.Unlock()
+    id, scoped := tenant.FromContext(ctx)
+    for _, data := range trackingData {
+        for _, stored := range repo.records {
+            if stored.record.ID == data.ID && (!scoped || stored.record.TenantID == id) {
+                stored.record.DistanceMeters, stored.record.OdometerMeters = data.DistanceMeters, data.OdometerMeters
+                stored.record.SpeedKmh = data.SpeedKmh
+            }
+        }
+    }
+    return nil
+}
+
+func (repo *MemoryTrackingRepository) FindActiveVehicles(
+    ctx context.Context,
+    since time.Time,
+    limit int,
+) ([]*ActiveVehicle, error) {
+    repo.mu.RLock()
+    defer repo.mu.RUnlock()
+    type key struct {
+        tenantID  string
+        vehicleID primitive.ObjectID
+    }
+    active := map[key]*ActiveVehicle{}
+    var vehicles []*ActiveVehicle
+    for _, stored := range repo.records {
+        record := stored.record
+        if !visible(ctx, stored) || record.CreatedAt.Before(since) {
+            continue
+        }
+        vehicle, ok := active[key{record.TenantID, record.VehicleID}]
+        if !ok {
+            vehicle = &ActiveVehicle{TenantID: record.TenantID, VehicleID: record.VehicleID}
+            active[key{record.TenantID, record.VehicleID}] = vehicle
+            vehicles = append(vehicles, vehicle)
+        }
+        if record.CreatedAt.After(vehicle.LastSeen) {
+            vehicle.LastSeen = record.CreatedAt
+        }
+    }
+    slices.SortStableFunc(
+        vehicles, func(a, b *ActiveVehicle) int {
+            return b.LastSeen.Compare(a.LastSeen)
+        },
+    )
+    return vehicles[:min(limit, len(vehicles))], nil
+}
+
+func (repo *MemoryTrackingRepository) FindLatestVersion(
+    ctx context.Context,
+    filter *TrackingFilter,
+) (*TrackingDataVersion, error) {
+    records, err := repo.find(ctx, filter)
+    if err != nil {
+        return nil, err
+    }
+    if len(records) == 0 {
+        return nil, nil
+    }
+    version := &TrackingDataVersion{Count: int64(len(records))}
+    for _, record := range records {
+        if bytes.Compare(record.ID[:], version.ID[:]) > 0 {
+            version.ID = record.ID
+        }
+    }
+    return version, nil
+}
@@ -0,0 +1,284 @@
+package repositories
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func newMemoryRecord(vehicleID primitive.ObjectID, createdAt time.Time, mileage float64) *TrackingRecord {
+    return NewTrackingRecord(
+        &models.TrackingData{
+            VehicleID:     vehicleID,
+            Location:      "Yangon",
+            Mileage:       mileage,
+            Status:        models.VehicleStatusActive,
+            FuelCondition: models.FuelConditionFull,
+            CreatedAt:     createdAt,
+        },
+    )
+}
+
+func TestMemoryTrackingRepository_FindTrackingData(t *testing.T) {
+    repo := NewMemoryTrackingRepository()
+    ctx := context.Background()
+    vehicleID, other := primitive.NewObjectID(), primitive.NewObjectID()
+    start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+    for i := range 5 {
+        record := newMemoryRecord(vehicleID, start.Add(time.Duration(i)*time.Hour), float64((i+1)*100))
+        if err := repo.CreateTrackingData(ctx, record); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err := repo.CreateTrackingData(ctx, newMemoryRecord(other, start, 1000)); err != nil {
+        t.Fatal(err)
+    }
+
+    found, err := repo.FindTrackingData(
+        ctx,
+        &TrackingFilter{VehicleID: vehicleID.Hex(), SortOrder: "desc", PageSize: 2, Page: 2},
+    )
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(found) != 2 || found[0].Mileage != 300 || found[1].Mileage != 200 {
+        t.Fatalf("Should find the second page newest first, got %v", found)
+    }
+
+    mileageMin, mileageMax := 100.0, 300.0
+    found, err = repo.FindTrackingData(ctx, &TrackingFilter{MileageMin: &mileageMin, MileageMax: &mileageMax})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(found) != 3 {
+        t.Fatalf("Should find the tracking data of the mileage range, got %d", len(found))
+    }
+
+    // the stored records can't be changed through the found ones
+    found[0].Mileage = -1
+    if stored, _ := repo.FindTrackingDataByID(ctx, found[0].ID.Hex()); stored.Mileage == -1 {
+        t.Fatal("Should return copies of the stored records")
+    }
+}
+
+func TestMemoryTrackingRepository_Sensors(t *testing.T) {
+    repo := NewMemoryTrackingRepository()
+    ctx := context.Background()
+    vehicleID := primitive.NewObjectID()
+    start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+    for i, sensors := range []Sensors{
+        {"temperature": -18.0, "door_open": false},
+        {"temperature": -4.0, "door_open": true},
+        {"temperature": "error"},
+        nil,
+    } {
+        record := newMemoryRecord(vehicleID, start.Add(time.Duration(i)*time.Hour), float64((i+1)*100))
+        record.Sensors = sensors
+        if err := repo.CreateTrackingData(ctx, record); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    found, err := repo.FindTrackingData(ctx, &TrackingFilter{Sensor: "temperature:..-15"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(found) != 1 || found[0].Sensors["temperature"] != -18.0 {
+        t.Fatalf("Should find the reading in the range, got %v", found)
+    }
+    found, err = repo.FindTrackingData(ctx, &TrackingFilter{Sensor: "door_open:true"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(found) != 1 || found[0].Sensors["temperature"] != -4.0 {
+        t.Fatalf("Should find the reading with the door open, got %v", found)
+    }
+}
+
+func TestMemoryTrackingRepository_SortMissingLast(t *testing.T) {
+    repo := NewMemoryTrackingRepository()
+    ctx := context.Background()
+    vehicleID := primitive.NewObjectID()
+    now := time.Now().UTC()
+    withDistance := newMemoryRecord(vehicleID, now, 100).SetDistance(50, 50)
+    for _, record := range []*TrackingRecord{newMemoryRecord(vehicleID, now, 100), withDistance} {
+        if err := repo.CreateTrackingData(ctx, record); err != nil {
+            t.Fatal(err)
+        }
+    }
+    for _, order := range []string{"asc", "desc"} {
+        found, err := repo.FindTrackingData(ctx, &TrackingFilter{SortField: "distance_meters", SortOrder: order})
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(found) != 2 || found[0].ID != withDistance.ID {
+            t.Errorf("Should order the tracking data without a distance last when %s, got %v", order, found)
+        }
+    }
+}
+
+func TestMemoryTrackingRepository_Deletion(t *testing.T) {
+    repo := NewMemoryTrackingRepository()
+    acme := tenant.WithID(context.Background(), "acme")
+    vehicleID := primitive.NewObjectID()
+    now := time.Now().UTC()
+    old, recent := newMemoryRecord(vehicleID, now.Add(-48*time.Hour), 100), newMemoryRecord(vehicleID, now, 200)
+    for _, record := range []*TrackingRecord{old, recent} {
+        if err := repo.CreateTrackingData(acme, record); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err := repo.CreateTrackingData(acme, old); !errors.Is(err, ErrDuplicate) {
+        t.Fatalf("Should reject a stored id as a duplicate, got %v", err)
+    }
+
+    other := tenant.WithID(context.Background(), "globex")
+    if deleted, _ := repo.DeleteTrackingData(other, vehicleID, time.Time{}, false); deleted != 0 {
+        t.Fatalf("Should not delete the tracking data of another tenant, deleted %d", deleted)
+    }
+    count, ids, err := repo.FindDeletionScope(acme, vehicleID, now.Add(-time.Hour), false, 10)
+    if err != nil || count != 1 || len(ids) != 1 || ids[0] != old.PublicID {
+        t.Fatalf("Should plan deleting the old tracking data, got %d %v %v", count, ids, err)
+    }
+    if deleted, _ := repo.DeleteTrackingData(acme, vehicleID, now.Add(-time.Hour), false); deleted != 1 {
+        t.Fatalf("Should soft delete the old tracking data, deleted %d", deleted)
+    }
+    if _, err = repo.FindTrackingDataByID(acme, old.ID.Hex()); !errors.Is(err, ErrTrackingDataNotFound) {
+        t.Fatalf("Should not find the deleted tracking data, got %v", err)
+    }
+
+    latest, err := repo.FindLatestTrackingData(acme, []VehicleLimit{{VehicleID: vehicleID, Limit: 5}})
+    if err != nil || len(latest[vehicleID]) != 1 || latest[vehicleID][0].ID != recent.ID {
+        t.Fatalf("Should find the remaining tracking data, got %v, %v", latest[vehicleID], err)
+    }
+    if deleted, _ := repo.DeleteTrackingData(acme, vehicleID, time.Time{}, true); deleted != 2 {
+        t.Fatalf("Should purge the soft deleted tracking data too, deleted %d", deleted)
+    }
+}
+
+func TestMemoryTrackingRepository_Exclude(t *testing.T) {
+    repo := NewMemoryTrackingRepository()
+    ctx := context.Background()
+    record := newMemoryRecord(primitive.NewObjectID(), time.Now().UTC(), 100)
+    if err := repo.CreateTrackingData(ctx, record); err != nil {
+        t.Fatal(err)
+    }
+    if err := repo.FlagTrackingData(ctx, record, FlagAnomaly); err != nil {
+        t.Fatal(err)
+    }
+    found, err := repo.FindTrackingData(ctx, &TrackingFilter{Exclude: ExcludeAnomalies})
+    if err != nil || len(found) != 0 {
+        t.Fatalf("Should exclude the flagged tracking data, got %v, %v", found, err)
+    }
+    version, err := repo.FindLatestVersion(ctx, &TrackingFilter{Exclude: ExcludeNone})
+    if err != nil || version == nil || version.ID != record.ID {
+        t.Fatalf("Should find the version of the flagged tracking data, got %v, %v", version, err)
+    }
+}
+
+func TestMemoryTrackingRepository_TimeAxis(t *testing.T) {
+    repo := NewMemoryTrackingRepository()
+    ctx := context.Background()
+    start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+    // the device clock of the first reading is an hour behind, the second reading has no device time
+    skewed := newMemoryRecord(primitive.NewObjectID(), start.Add(2*time.Hour), 100)
+    skewed.DeviceTime = timestamp.Ptr(start.Add(time.Hour))
+    if err := repo.CreateTrackingData(ctx, skewed); err != nil {
+        t.Fatal(err)
+    }
+    onTime := newMemoryRecord(primitive.NewObjectID(), start.Add(time.Hour), 200)
+    if err := repo.CreateTrackingData(ctx, onTime); err != nil {
+        t.Fatal(err)
+    }
+
+    filter := func(axis string) *TrackingFilter {
+        return &TrackingFilter{
+            From:     start.Add(time.Hour).Format(time.RFC3339),
+            To:       start.Add(90 * time.Minute).Format(time.RFC3339),
+            TimeAxis: axis,
+        }
+    }
+    found, err := repo.FindTrackingData(ctx, filter("device_time"))
+    if err != nil || len(found) != 1 || found[0].ID != skewed.ID {
+        t.Fatalf("Should find the reading by its device time, got %v, %v", found, err)
+    }
+    found, err = repo.FindTrackingData(ctx, filter(""))
+    if err != nil || len(found) != 1 || found[0].ID != onTime.ID {
+        t.Fatalf("Should find the reading by its created_at by default, got %v, %v", found, err)
+    }
+    if _, err = repo.FindTrackingData(ctx, filter("sent_at")); !errors.Is(err, ErrInvalidTimeAxis) {
+        t.Fatalf("Should reject an unknown time axis, got %v", err)
+    }
+}
+
+func TestMemoryTrackingRepository_After(t *testing.T) {
+    repo := NewMemoryTrackingRepository()
+    ctx := context.Background()
+    vehicleID := primitive.NewObjectID()
+    start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+    records := make([]*TrackingRecord, 4)
+    for i := range records {
+        records[i] = newMemoryRecord(vehicleID, start.Add(time.Duration(i)*time.Hour), float64((i+1)*100))
+        if err := repo.CreateTrackingData(ctx, records[i]); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    found, err := repo.FindTrackingData(
+        ctx,
+        &TrackingFilter{SortField: "id", SortOrder: "asc", PageSize: 2, After: records[0].ID.Hex()},
+    )
+    if err != nil || len(found) != 2 || found[0].ID != records[1].ID || found[1].ID != records[2].ID {
+        t.Fatalf("Should find the tracking data after the id, got %v, %v", found, err)
+    }
+    found, err = repo.FindTrackingData(
+        ctx,
+        &TrackingFilter{SortField: "-id", SortOrder: "asc", After: records[2].ID.Hex()},
+    )
+    if err != nil || len(found) != 2 || found[0].ID != records[1].ID || found[1].ID != records[0].ID {
+        t.Fatalf("Should find the tracking data before the id newest first, got %v, %v", found, err)
+    }
+    _, err = repo.FindTrackingData(ctx, &TrackingFilter{SortField: "created_at", After: records[0].ID.Hex()})
+    if !errors.Is(err, ErrInvalidAfter) {
+        t.Fatalf("Should reject after without sorting by id, got %v", err)
+    }
+}
+
+func TestMemoryTrackingRepository_FindLatestVersion(t *testing.T) {
+    repo := NewMemoryTrackingRepository()
+    ctx := context.Background()
+    vehicleID := primitive.NewObjectID()
+    now := time.Now().UTC()
+    if err := repo.CreateTrackingData(ctx, newMemoryRecord(vehicleID, now, 100)); err != nil {
+        t.Fatal(err)
+    }
+    filter := &TrackingFilter{VehicleID: vehicleID.Hex()}
+    version, err := repo.FindLatestVersion(ctx, filter)
+    if err != nil || version == nil || version.Count != 1 {
+        t.Fatalf("Should find the version of the tracking data, got %v, %v", version, err)
+    }
+
+    // a backfilled reading is older than the newest one, the version changes anyway
+    backfilled := newMemoryRecord(vehicleID, now.Add(-time.Hour), 50)
+    if err = repo.CreateTrackingData(ctx, backfilled); err != nil {
+        t.Fatal(err)
+    }
+    backfilledVersion, err := repo.FindLatestVersion(ctx, filter)
+    if err != nil || *backfilledVersion == *version || backfilledVersion.ID != backfilled.ID {
+        t.Fatalf("Should change the version with the backfilled reading, got %v, %v", backfilledVersion, err)
+    }
+
+    if _, err = repo.DeleteTrackingData(ctx, vehicleID, now.Add(-time.Minute), false); err != nil {
+        t.Fatal(err)
+    }
+    deletedVersion, err := repo.FindLatestVersion(ctx, filter)
+    if err != nil || *deletedVersion == *backfilledVersion || deletedVersion.Count != 1 {
+        t.Fatalf("Should change the version with the deleted reading, got %v, %v", deletedVersion, err)
+    }
+}
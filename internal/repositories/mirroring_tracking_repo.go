@@ -0,0 +1,230 @@
+package repositories
+
+import (
+    "context"
+    "errors"
+    "log"
+    "slices"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// mirrorFlushTimeout bounds the last flush of the pending writes when the mirror stops
+const mirrorFlushTimeout = 10 * time.Second
+
+// mirrorOp is a write to mirror, either a record to store or a deletion
+type mirrorOp struct {
+    record   *TrackingRecord
+    deletion *mirrorDeletion
+}
+
+type mirrorDeletion struct {
+    tenantID  string
+    scoped    bool
+    vehicleID primitive.ObjectID
+    before    time.Time
+}
+
+// matches tells whether the deletion deletes the record
+func (d *mirrorDeletion) matches(record *TrackingRecord) bool {
+    return record.VehicleID == d.vehicleID &&
+        (!d.scoped || record.TenantID == d.tenantID) &&
+        (d.before.IsZero() || record.CreatedAt.Before(d.before))
+}
+
+// MirroringTrackingRepository mirrors the writes of the tracking data into the history store. The writes are
+// queued and stored by Run in batches, so the history store never slows down or fails the writes of the hot
+// store. When the queue is full the writes are dropped and logged, and batches failing with transient errors
+// are retried with the next flush up to ten batches.
+type MirroringTrackingRepository struct {
+    TrackingRepository
+    history   HistoryRepository
+    ops       chan mirrorOp
+    batchSize int
+    interval  time.Duration
+    done      chan struct{}
+}
+
+func NewMirroringTrackingRepository(
+    trackingRepo TrackingRepository,
+    history HistoryRepository,
+    batchSize int,
+    interval time.Duration,
+) *MirroringTrackingRepository {
+    return &MirroringTrackingRepository{
+        TrackingRepository: trackingRepo,
+        history:            history,
+        ops:                make(chan mirrorOp, batchSize*10),
+        batchSize:          batchSize,
+        interval:           interval,
+        done:               make(chan struct{}),
+    }
+}
+
+func (repo *MirroringTrackingRepository) CreateTrackingData(ctx context.Context, trackingData *TrackingRecord) error {
+    if err := repo.TrackingRepository.CreateTrackingData(ctx, trackingData); err != nil {
+        return err
+    }
+    repo.enqueue(mirrorOp{record: copyRecord(trackingData)})
+    return nil
+}
+
+func (repo *MirroringTrackingRepository) CreateManyTrackingData(
+    ctx context.Context,
+    trackingData []*TrackingRecord,
+) ([]error, error) {
+    errs, err := repo.TrackingRepository.CreateManyTrackingData(ctx, trackingData)
+    if err != nil {
+        return errs, err
+    }
+    for i, data := range trackingData {
+        if errs == nil || errs[i] == nil {
+            repo.enqueue(mirrorOp{record: copyRecord(data)})
+        }
+    }
+    return errs, nil
+}
+
+func (repo *MirroringTrackingRepository) DeleteTrackingData(
+    ctx context.Context,
+    vehicleID primitive.ObjectID,
+    before time.Time,
+    purge bool,
+) (int64, error) {
+    deleted, err := repo.TrackingRepository.DeleteTrackingData(ctx, vehicleID, before, purge)
+    if err != nil {
+        return deleted, err
+    }
+    if deleted > 0 {
+        id, scoped := tenant.FromContext(ctx)
+        repo.enqueue(
+            mirrorOp{
+                deletion: &mirrorDeletion{tenantID: id, scoped: scoped, vehicleID: vehicleID, before: before},
+            },
+        )
+    }
+    return deleted, nil
+}
+
+// FlagTrackingData stores the flagged record again, it replaces the previous version in the history store
+func (repo *MirroringTrackingRepository) FlagTrackingData(
+    ctx context.Context,
+    trackingData *TrackingRecord,
+    flag string,
+) error {
+    if err := repo.TrackingRepository.FlagTrackingData(ctx, trackingData, flag); err != nil {
+        return err
+    }
+    repo.enqueue(mirrorOp{record: copyRecord(trackingData)})
+    return nil
+}
+
+// UpdateTrackingDistances stores the updated records again, they replace the previous versions in the history store
+func (repo *MirroringTrackingRepository) UpdateTrackingDistances(
+    ctx context.Context,
+    trackingData []*TrackingRecord,
+) error {
+    if err := repo.TrackingRepository.UpdateTrackingDistances(ctx, trackingData); err != nil {
+        return err
+    }
+    for _, data := range trackingData {
+        repo.enqueue(mirrorOp{record: copyRecord(data)})
+    }
+    return nil
+}
+
+func (repo *MirroringTrackingRepository) enqueue(op mirrorOp) {
+    select {
+    case repo.ops <- op:
+    default:
+        log.Println("History mirror queue is full, dropping a tracking data write")
+    }
+}
+
+// Run stores the queued writes until ctx is done, then flushes the writes still queued and closes Done
+func (repo *MirroringTrackingRepository) Run(ctx context.Context) {
+    defer close(repo.done)
+    ticker := time.NewTicker(repo.interval)
+    defer ticker.Stop()
+    var pending []*TrackingRecord
+    for {
+        select {
+        case <-ctx.Done():
+            flushCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), mirrorFlushTimeout)
+            defer cancel()
+            for {
+                select {
+                case op := <-repo.ops:
+                    pending = repo.apply(flushCtx, pending, op)
+                default:
+                    if pending = repo.flush(flushCtx, pending); len(pending) > 0 {
+                        log.Printf("History mirror stopped with %d tracking data not stored", len(pending))
+                    }
+                    return
+                }
+            }
+        case op := <-repo.ops:
+            if pending = repo.apply(ctx, pending, op); len(pending) >= repo.batchSize {
+                pending = repo.flush(ctx, pending)
+            }
+        case <-ticker.C:
+            pending = repo.flush(ctx, pending)
+        }
+    }
+}
+
+// Done is closed when Run returned
+func (repo *MirroringTrackingRepository) Done() <-chan struct{} {
+    return repo.done
+}
+
+// apply adds a record to the pending ones or deletes from the history store, dropping the pending records the
+// deletion deletes
+func (repo *MirroringTrackingRepository) apply(
+    ctx context.Context,
+    pending []*TrackingRecord,
+    op mirrorOp,
+) []*TrackingRecord {
+    if op.record != nil {
+        return append(pending, op.record)
+    }
+    pending = slices.DeleteFunc(pending, op.deletion.matches)
+    deleteCtx := ctx
+    if op.deletion.scoped {
+        deleteCtx = tenant.WithID(ctx, op.deletion.tenantID)
+    }
+    if err := repo.history.DeleteTrackingData(deleteCtx, op.deletion.vehicleID, op.deletion.before); err != nil {
+        log.Println("Failed to delete tracking data from the history", err)
+    }
+    return pending
+}
+
+// flush stores the pending records, it returns the records to retry with the next flush
+func (repo *MirroringTrackingRepository) flush(ctx context.Context, pending []*TrackingRecord) []*TrackingRecord {
+    if len(pending) == 0 {
+        return pending
+    }
+    // the records stored twice in a batch, like flagged right after they were created, are stored once
+    latest := make(map[primitive.ObjectID]int, len(pending))
+    batch := make([]*TrackingRecord, 0, len(pending))
+    for _, record := range pending {
+        if i, ok := latest[record.ID]; ok {
+            batch[i] = record
+            continue
+        }
+        latest[record.ID] = len(batch)
+        batch = append(batch, record)
+    }
+    err := repo.history.InsertTrackingData(ctx, batch)
+    if err == nil {
+        return pending[:0]
+    }
+    if errors.Is(err, ErrTransient) && len(batch) < repo.batchSize*10 {
+        log.Println("Failed to store tracking data in the history, retrying with the next flush", err)
+        return batch
+    }
+    log.Printf("Failed to store %d tracking data in the history: %v", len(batch), err)
+    return pending[:0]
+}
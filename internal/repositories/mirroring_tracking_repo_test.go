@@ -0,0 +1,70 @@
+package repositories
+
+import (
+    "context"
+    "errors"
+    "slices"
+    "testing"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// recordingHistoryRepo records the writes of the mirror
+type recordingHistoryRepo struct {
+    HistoryRepository
+    batches   [][]*TrackingRecord
+    deletions []string
+}
+
+func (repo *recordingHistoryRepo) InsertTrackingData(_ context.Context, trackingData []*TrackingRecord) error {
+    repo.batches = append(repo.batches, trackingData)
+    return nil
+}
+
+func (repo *recordingHistoryRepo) DeleteTrackingData(
+    ctx context.Context,
+    vehicleID primitive.ObjectID,
+    _ time.Time,
+) error {
+    id, _ := tenant.FromContext(ctx)
+    repo.deletions = append(repo.deletions, id+"/"+vehicleID.Hex())
+    return nil
+}
+
+func TestMirroringTrackingRepository(t *testing.T) {
+    history := &recordingHistoryRepo{}
+    repo := NewMirroringTrackingRepository(NewMemoryTrackingRepository(), history, 100, time.Hour)
+    ctx := tenant.WithID(context.Background(), "acme")
+    vehicleID, other := primitive.NewObjectID(), primitive.NewObjectID()
+    now := time.Now().UTC()
+    flagged, kept, deleted := newMemoryRecord(vehicleID, now, 100), newMemoryRecord(vehicleID, now, 110),
+        newMemoryRecord(other, now, 200)
+    errs, err := repo.CreateManyTrackingData(ctx, []*TrackingRecord{flagged, kept, deleted})
+    if err = errors.Join(append(errs, err)...); err != nil {
+        t.Fatal(err)
+    }
+    if err := repo.FlagTrackingData(ctx, flagged, FlagAnomaly); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := repo.DeleteTrackingData(ctx, other, time.Time{}, false); err != nil {
+        t.Fatal(err)
+    }
+
+    runCtx, cancel := context.WithCancel(context.Background())
+    cancel()
+    repo.Run(runCtx)
+    <-repo.Done()
+
+    if len(history.batches) != 1 || len(history.batches[0]) != 2 {
+        t.Fatalf("Should store the queued tracking data in a batch when stopped, got %v", history.batches)
+    }
+    stored := history.batches[0][0]
+    if stored.ID != flagged.ID || !slices.Contains(stored.Flags, FlagAnomaly) || history.batches[0][1].ID != kept.ID {
+        t.Fatalf("Should store the flagged version of the tracking data once, got %v", history.batches[0])
+    }
+    if len(history.deletions) != 1 || history.deletions[0] != "acme/"+other.Hex() {
+        t.Fatalf("Should mirror the deletion for the tenant, got %v", history.deletions)
+    }
+}
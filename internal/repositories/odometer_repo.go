@@ -0,0 +1,73 @@
+package repositories
+
+import (
+    "context"
+    "log"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// VehicleOdometer is the distance a vehicle traveled according to its coordinates, independent of the
+// mileage reported by the device, together with the last position it was advanced to
+type VehicleOdometer struct {
+    VehicleID primitive.ObjectID `json:"vehicle_id" bson:"_id"`
+    Meters    float64            `json:"meters" bson:"meters"`
+    Lat       float64            `json:"lat" bson:"lat"`
+    Lng       float64            `json:"lng" bson:"lng"`
+    // ReadingAt is the time of the reading the odometer was last advanced with
+    ReadingAt timestamp.Time `json:"reading_at" bson:"reading_at"`
+}
+
+type OdometerRepository interface {
+    FindOdometers(ctx context.Context, vehicleIDs []primitive.ObjectID) (map[primitive.ObjectID]*VehicleOdometer, error)
+    SaveOdometer(ctx context.Context, odometer *VehicleOdometer) error
+}
+
+type MongoOdometerRepository struct {
+    collection *mongo.Collection
+}
+
+func NewMongoOdometerRepository(db *mongo.Database) *MongoOdometerRepository {
+    return &MongoOdometerRepository{
+        collection: db.Collection("vehicle_odometers"),
+    }
+}
+
+func (repo *MongoOdometerRepository) FindOdometers(
+    ctx context.Context,
+    vehicleIDs []primitive.ObjectID,
+) (map[primitive.ObjectID]*VehicleOdometer, error) {
+    odometers := make(map[primitive.ObjectID]*VehicleOdometer, len(vehicleIDs))
+    cursor, err := repo.collection.Find(ctx, bson.M{"_id": bson.M{"$in": vehicleIDs}})
+    if err != nil {
+        return nil, classify(err)
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
+        }
+    }(cursor, ctx)
+    for cursor.Next(ctx) {
+        var odometer VehicleOdometer
+        if err := cursor.Decode(&odometer); err != nil {
+            return nil, err
+        }
+        odometers[odometer.VehicleID] = &odometer
+    }
+    return odometers, classify(cursor.Err())
+}
+
+func (repo *MongoOdometerRepository) SaveOdometer(ctx context.Context, odometer *VehicleOdometer) error {
+    _, err := repo.collection.ReplaceOne(
+        ctx,
+        bson.M{"_id": odometer.VehicleID},
+        odometer,
+        options.Replace().SetUpsert(true),
+    )
+    return classify(err)
+}
@@ -0,0 +1,42 @@
+package repositories
+
+import (
+    "net/url"
+    "strconv"
+    "sync/atomic"
+)
+
+var (
+    defaultPageSize atomic.Int64
+    maxPageSize     atomic.Int64
+)
+
+func init() {
+    SetPageSizes(10, 100)
+}
+
+// SetPageSizes sets the page size of list queries without a limit and the largest page size they can ask for
+func SetPageSizes(defaultSize, maxSize int) {
+    defaultPageSize.Store(int64(defaultSize))
+    maxPageSize.Store(int64(maxSize))
+}
+
+// pageSize applies the default and largest page size to the limit of a list query
+func pageSize(size int) int {
+    if size == 0 {
+        size = int(defaultPageSize.Load())
+    }
+    return min(size, int(maxPageSize.Load()))
+}
+
+// MaxPageSize returns the largest page size list queries can ask for
+func MaxPageSize() int {
+    return int(maxPageSize.Load())
+}
+
+// PageOf returns the page and page size the filters of list queries read from the query parameters
+func PageOf(query url.Values) (page, size int) {
+    page, _ = strconv.Atoi(query.Get("page"))
+    size, _ = strconv.Atoi(query.Get("limit"))
+    return max(page, 1), pageSize(size)
+}
@@ -0,0 +1,1004 @@
+package repositories
+
+import (
+    "context"
+    "database/sql"
+    "database/sql/driver"
+    "errors"
+    "fmt"
+    "log"
+    "net"
+    "slices"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/ulid"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// postgresTable is the hypertable of the tracking data, TimescaleDB chunks it by created_at
+const postgresTable = "tracking_data"
+
+// postgresColumns are the columns of a tracking record in the order scanRecord reads them
+const postgresColumns = "id, public_id, tenant_id, vehicle_id, driver_id, location, mileage, status, fuel_condition, " +
+    "lat, lng, distance_meters, odometer_meters, speed_kmh, flags, sensors, device_time, received_time, created_at, " +
+    "updated_at"
+
+// postgresInsertBatch is how many tracking data a single insert of CreateManyTrackingData stores at most, it keeps
+// the statements far below the limit of 65535 parameters
+const postgresInsertBatch = 1000
+
+// postgresSchema creates the hypertable and its indexes. The ids are compared byte wise with the C collation, so
+// they are ordered like ObjectIDs and ULIDs. TimescaleDB requires the unique indexes to include created_at, the
+// time the hypertable is partitioned by.
+var postgresSchema = []string{
+    `CREATE EXTENSION IF NOT EXISTS timescaledb`,
+    `CREATE TABLE IF NOT EXISTS tracking_data (
+        id              TEXT COLLATE "C" NOT NULL,
+        public_id       TEXT COLLATE "C",
+        tenant_id       TEXT NOT NULL DEFAULT '',
+        vehicle_id      TEXT COLLATE "C" NOT NULL,
+        driver_id       TEXT,
+        location        TEXT NOT NULL,
+        mileage         DOUBLE PRECISION NOT NULL,
+        status          TEXT NOT NULL,
+        fuel_condition  TEXT NOT NULL,
+        lat             DOUBLE PRECISION,
+        lng             DOUBLE PRECISION,
+        distance_meters DOUBLE PRECISION,
+        odometer_meters DOUBLE PRECISION,
+        speed_kmh       DOUBLE PRECISION,
+        flags           JSONB NOT NULL DEFAULT '[]',
+        sensors         JSONB,
+        device_time     TIMESTAMPTZ,
+        received_time   TIMESTAMPTZ,
+        created_at      TIMESTAMPTZ NOT NULL,
+        updated_at      TIMESTAMPTZ NOT NULL,
+        deleted_at      TIMESTAMPTZ,
+        PRIMARY KEY (id, created_at)
+    )`,
+    // tables created before the speed was computed
+    `ALTER TABLE tracking_data ADD COLUMN IF NOT EXISTS speed_kmh DOUBLE PRECISION`,
+    // tables created before the drivers were stored
+    `ALTER TABLE tracking_data ADD COLUMN IF NOT EXISTS driver_id TEXT`,
+    // tables created before the sensors were stored
+    `ALTER TABLE tracking_data ADD COLUMN IF NOT EXISTS sensors JSONB`,
+    // tables created before the device and received times were stored
+    `ALTER TABLE tracking_data ADD COLUMN IF NOT EXISTS device_time TIMESTAMPTZ`,
+    `ALTER TABLE tracking_data ADD COLUMN IF NOT EXISTS received_time TIMESTAMPTZ`,
+    `SELECT create_hypertable('tracking_data', 'created_at', if_not_exists => TRUE)`,
+    `CREATE UNIQUE INDEX IF NOT EXISTS tracking_data_public_id ON tracking_data (public_id, created_at)`,
+    `CREATE INDEX IF NOT EXISTS tracking_data_vehicle ON tracking_data (tenant_id, vehicle_id, created_at DESC)`,
+    `CREATE INDEX IF NOT EXISTS tracking_data_driver ON tracking_data (tenant_id, driver_id, created_at DESC)
+        WHERE driver_id IS NOT NULL`,
+    `CREATE INDEX IF NOT EXISTS tracking_data_sensors ON tracking_data USING GIN (sensors jsonb_path_ops)
+        WHERE sensors IS NOT NULL`,
+}
+
+// PostgresTrackingRepository stores the tracking data in a PostgreSQL table turned into a TimescaleDB hypertable,
+// for deployments without MongoDB. It has the filter, sort and pagination semantics of MongoTackingRepository, the
+// ObjectIDs are generated and stored as hex. It works with any database/sql driver for PostgreSQL, the binary
+// registers one. Projections aren't applied, the handlers project the records.
+type PostgresTrackingRepository struct {
+    db *sql.DB
+}
+
+func NewPostgresTrackingRepository(db *sql.DB) *PostgresTrackingRepository {
+    return &PostgresTrackingRepository{db: db}
+}
+
+// CreateSchema creates the hypertable and its indexes when they don't exist, the TimescaleDB extension has to be
+// available to the database
+func (repo *PostgresTrackingRepository) CreateSchema(ctx context.Context) error {
+    for _, statement := range postgresSchema {
+        if _, err := repo.db.ExecContext(ctx, statement); err != nil {
+            return classifySQL(err)
+        }
+    }
+    return nil
+}
+
+// sqlStateError is implemented by the errors of the PostgreSQL drivers, like pgconn.PgError and pq.Error
+type sqlStateError interface {
+    SQLState() string
+}
+
+// transientSQLStates are the SQLSTATE codes of failures that are safe to retry, besides the connection exceptions
+// of class 08
+var transientSQLStates = []string{
+    "40001", // serialization_failure
+    "40P01", // deadlock_detected
+    "53300", // too_many_connections
+    "57P01", // admin_shutdown
+    "57P02", // crash_shutdown
+    "57P03", // cannot_connect_now
+}
+
+// classifySQL wraps a database/sql error with the kind of failure it is, like classify does for Mongo errors
+func classifySQL(err error) error {
+    switch {
+    case err == nil, errors.Is(err, ErrNotFound), errors.Is(err, ErrDuplicate), errors.Is(err, ErrTransient):
+        return err
+    case errors.Is(err, sql.ErrNoRows):
+        return fmt.Errorf("%w: %w", ErrNotFound, err)
+    case sqlState(err) == "23505": // unique_violation
+        return fmt.Errorf("%w: %w", ErrDuplicate, err)
+    case isTransientSQL(err):
+        return fmt.Errorf("%w: %w", ErrTransient, err)
+    }
+    return err
+}
+
+// sqlState returns the SQLSTATE code of a PostgreSQL error, empty for other errors
+func sqlState(err error) string {
+    var stateErr sqlStateError
+    if errors.As(err, &stateErr) {
+        return stateErr.SQLState()
+    }
+    return ""
+}
+
+func isTransientSQL(err error) bool {
+    if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) ||
+        errors.Is(err, context.DeadlineExceeded) {
+        return true
+    }
+    var netErr net.Error
+    if errors.As(err, &netErr) {
+        return true
+    }
+    state := sqlState(err)
+    return strings.HasPrefix(state, "08") || slices.Contains(transientSQLStates, state)
+}
+
+// sqlQuery is a query translated to SQL, its conditions are joined with AND and refer to args with $n placeholders
+type sqlQuery struct {
+    conditions []string
+    args       []any
+    orderBy    []string
+}
+
+// arg adds an argument and returns its placeholder
+func (q *sqlQuery) arg(value any) string {
+    q.args = append(q.args, value)
+    return fmt.Sprintf("$%d", len(q.args))
+}
+
+// where adds a condition, every %s of the condition is the placeholder of the next value
+func (q *sqlQuery) where(condition string, values ...any) {
+    placeholders := make([]any, len(values))
+    for i, value := range values {
+        placeholders[i] = q.arg(value)
+    }
+    q.conditions = append(q.conditions, fmt.Sprintf(condition, placeholders...))
+}
+
+// whereIn adds a condition matching any of the values of the column, none of them when there aren't any
+func (q *sqlQuery) whereIn(column string, values []string) {
+    if len(values) == 0 {
+        q.conditions = append(q.conditions, "FALSE")
+        return
+    }
+    if len(values) == 1 {
+        q.where(column+" = %s", values[0])
+        return
+    }
+    placeholders := make([]string, len(values))
+    for i, value := range values {
+        placeholders[i] = q.arg(value)
+    }
+    q.conditions = append(q.conditions, fmt.Sprintf("%s IN (%s)", column, strings.Join(placeholders, ", ")))
+}
+
+// whereSensor adds a sensor condition, a value is matched by containment and a range with a JSON path comparing
+// only the numbers, so both can use the GIN index of the sensors
+func (q *sqlQuery) whereSensor(condition *SensorCondition) error {
+    if condition.Value != nil {
+        value, err := json.Marshal(Sensors{condition.Name: condition.Value})
+        if err != nil {
+            return err
+        }
+        q.where("sensors @> %s::jsonb", string(value))
+        return nil
+    }
+    var bounds []string
+    if condition.Min != nil {
+        bounds = append(bounds, "@ >= "+strconv.FormatFloat(*condition.Min, 'f', -1, 64))
+    }
+    if condition.Max != nil {
+        bounds = append(bounds, "@ <= "+strconv.FormatFloat(*condition.Max, 'f', -1, 64))
+    }
+    q.where("sensors @? %s::jsonpath", fmt.Sprintf("$.%s ? (%s)", condition.Name, strings.Join(bounds, " && ")))
+    return nil
+}
+
+// clauses returns the WHERE and ORDER BY clauses of the query, each starting with a space when present
+func (q *sqlQuery) clauses() string {
+    var clauses strings.Builder
+    if len(q.conditions) > 0 {
+        clauses.WriteString(" WHERE " + strings.Join(q.conditions, " AND "))
+    }
+    if len(q.orderBy) > 0 {
+        clauses.WriteString(" ORDER BY " + strings.Join(q.orderBy, ", "))
+    }
+    return clauses.String()
+}
+
+// scope limits the query to the tracking data that isn't deleted, of the tenant of the context
+func (q *sqlQuery) scope(ctx context.Context) *sqlQuery {
+    q.conditions = append(q.conditions, "deleted_at IS NULL")
+    if id, ok := tenant.FromContext(ctx); ok {
+        q.where("tenant_id = %s", id)
+    }
+    return q
+}
+
+// scopedSQLQuery starts a query of the tracking data that isn't deleted, limited to the tenant of the context
+func scopedSQLQuery(ctx context.Context) *sqlQuery {
+    return (&sqlQuery{}).scope(ctx)
+}
+
+// hexes returns the hex of the ObjectIDs, the way they are stored in PostgreSQL
+func hexes(ids []primitive.ObjectID) []string {
+    values := make([]string, len(ids))
+    for i, id := range ids {
+        values[i] = id.Hex()
+    }
+    return values
+}
+
+// sqlStrings converts the values of a string type to the strings stored in PostgreSQL
+func sqlStrings[T ~string](values []T) []string {
+    converted := make([]string, len(values))
+    for i, value := range values {
+        converted[i] = string(value)
+    }
+    return converted
+}
+
+// likeEscaper escapes the wildcards of a LIKE pattern, backslash is the default escape character of PostgreSQL
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// buildSQLQuery translates the filter into SQL like buildQuery does into Mongo, pagination is left to the caller.
+// PostgreSQL orders nulls last when ascending and first when descending, so the descending sorts on the fields
+// that can be missing put them last explicitly.
+func buildSQLQuery(ctx context.Context, filter *TrackingFilter) (*sqlQuery, error) {
+    query := scopedSQLQuery(ctx)
+    if filter == nil {
+        return query, nil
+    }
+    if err := filter.Build(); err != nil {
+        return nil, err
+    }
+    for _, flag := range filter.excluded {
+        query.where("NOT (flags @> jsonb_build_array(%s::text))", flag)
+    }
+    if filter.publicID != "" {
+        query.where("public_id = %s", filter.publicID)
+    } else if filter.ID != "" {
+        query.where("id = %s", filter.id.Hex())
+    }
+    if !filter.after.IsZero() {
+        // the hex ids sort like the ObjectIDs
+        operator := ">"
+        if filter.sortKeys[0].Order < 0 {
+            operator = "<"
+        }
+        query.where("id "+operator+" %s", filter.after.Hex())
+    }
+    if filter.selected != nil {
+        query.whereIn("vehicle_id", hexes(filter.selected))
+    }
+    if filter.vehicleIDs != nil {
+        vehicleIDs := filter.vehicleIDs
+        if filter.selected != nil {
+            vehicleIDs = slices.DeleteFunc(
+                slices.Clone(vehicleIDs), func(id primitive.ObjectID) bool {
+                    return !slices.Contains(filter.selected, id)
+                },
+            )
+        }
+        query.whereIn("vehicle_id", hexes(vehicleIDs))
+    }
+    if len(filter.driverIDs) > 0 {
+        query.whereIn("driver_id", filter.driverIDs)
+    }
+    if filter.Location != "" {
+        query.where("location ILIKE %s", likeEscaper.Replace(filter.Location)+"%")
+    }
+    if filter.MileageMin != nil {
+        query.where("mileage >= %s", *filter.MileageMin)
+    }
+    if filter.MileageMax != nil {
+        query.where("mileage <= %s", *filter.MileageMax)
+    }
+    for _, condition := range filter.sensors {
+        if err := query.whereSensor(condition); err != nil {
+            return nil, err
+        }
+    }
+    if len(filter.statuses) > 0 {
+        query.whereIn("status", sqlStrings(filter.statuses))
+    }
+    if len(filter.fuelConditions) > 0 {
+        query.whereIn("fuel_condition", sqlStrings(filter.fuelConditions))
+    }
+    from, to := filter.TimeRange()
+    if !from.IsZero() {
+        query.where(filter.TimeField()+" >= %s", from)
+    }
+    if !to.IsZero() {
+        query.where(filter.TimeField()+" < %s", to)
+    }
+    for _, key := range filter.SortKeys() {
+        column := key.Field
+        if column == "_id" {
+            column = "id"
+        }
+        switch {
+        case key.Order > 0:
+            query.orderBy = append(query.orderBy, column+" ASC")
+        case alwaysPresentFields[key.Field]:
+            query.orderBy = append(query.orderBy, column+" DESC")
+        default:
+            query.orderBy = append(query.orderBy, column+" DESC NULLS LAST")
+        }
+    }
+    return query, nil
+}
+
+// rowScanner is a *sql.Row or *sql.Rows
+type rowScanner interface {
+    Scan(dest ...any) error
+}
+
+// scanRecord reads a tracking record from the postgresColumns of a row
+func scanRecord(row rowScanner) (*TrackingRecord, error) {
+    var record TrackingRecord
+    var id, vehicleID string
+    var publicID, driverID sql.NullString
+    var lat, lng, distanceMeters, odometerMeters, speedKmh sql.NullFloat64
+    var flags, sensors []byte
+    var deviceTime, receivedTime sql.NullTime
+    err := row.Scan(
+        &id,
+        &publicID,
+        &record.TenantID,
+        &vehicleID,
+        &driverID,
+        &record.Location,
+        &record.Mileage,
+        &record.Status,
+        &record.FuelCondition,
+        &lat,
+        &lng,
+        &distanceMeters,
+        &odometerMeters,
+        &speedKmh,
+        &flags,
+        &sensors,
+        &deviceTime,
+        &receivedTime,
+        &record.CreatedAt,
+        &record.UpdatedAt,
+    )
+    if err != nil {
+        return nil, classifySQL(err)
+    }
+    if record.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+        return nil, err
+    }
+    if record.VehicleID, err = primitive.ObjectIDFromHex(vehicleID); err != nil {
+        return nil, err
+    }
+    if err = json.Unmarshal(flags, &record.Flags); err != nil {
+        return nil, err
+    }
+    if len(record.Flags) == 0 {
+        record.Flags = nil
+    }
+    if sensors != nil {
+        if err = json.Unmarshal(sensors, &record.Sensors); err != nil {
+            return nil, err
+        }
+    }
+    record.PublicID = publicID.String
+    record.DriverID = driverID.String
+    record.Lat, record.Lng = nullFloat(lat), nullFloat(lng)
+    record.DistanceMeters, record.OdometerMeters = nullFloat(distanceMeters), nullFloat(odometerMeters)
+    record.SpeedKmh = nullFloat(speedKmh)
+    record.DeviceTime, record.ReceivedTime = nullTime(deviceTime), nullTime(receivedTime)
+    record.CreatedAt, record.UpdatedAt = record.CreatedAt.UTC(), record.UpdatedAt.UTC()
+    return &record, nil
+}
+
+func nullFloat(value sql.NullFloat64) *float64 {
+    if !value.Valid {
+        return nil
+    }
+    return &value.Float64
+}
+
+func nullTime(value sql.NullTime) *timestamp.Time {
+    if !value.Valid {
+        return nil
+    }
+    return timestamp.Ptr(value.Time.UTC())
+}
+
+// sqlTime returns the time of an optional timestamp, NULL when it is missing
+func sqlTime(value *timestamp.Time) sql.NullTime {
+    if value == nil {
+        return sql.NullTime{}
+    }
+    return sql.NullTime{Time: value.Time, Valid: true}
+}
+
+// recordArgs returns the values of the postgresColumns of a record
+func recordArgs(record *TrackingRecord) ([]any, error) {
+    flags := record.Flags
+    if flags == nil {
+        flags = []string{}
+    }
+    encodedFlags, err := json.Marshal(flags)
+    if err != nil {
+        return nil, err
+    }
+    var encodedSensors sql.NullString
+    if len(record.Sensors) > 0 {
+        value, err := json.Marshal(record.Sensors)
+        if err != nil {
+            return nil, err
+        }
+        encodedSensors = sql.NullString{String: string(value), Valid: true}
+    }
+    return []any{
+        record.ID.Hex(),
+        sql.NullString{String: record.PublicID, Valid: record.PublicID != ""},
+        record.TenantID,
+        record.VehicleID.Hex(),
+        sql.NullString{String: record.DriverID, Valid: record.DriverID != ""},
+        record.Location,
+        record.Mileage,
+        string(record.Status),
+        string(record.FuelCondition),
+        record.Lat,
+        record.Lng,
+        record.DistanceMeters,
+        record.OdometerMeters,
+        record.SpeedKmh,
+        string(encodedFlags),
+        encodedSensors,
+        sqlTime(record.DeviceTime),
+        sqlTime(record.ReceivedTime),
+        record.CreatedAt,
+        record.UpdatedAt,
+    }, nil
+}
+
+// prepare builds a record before it is stored, the ids and created_at are the keys of the hypertable so they are
+// assigned here when missing
+func prepare(ctx context.Context, trackingData *TrackingRecord) error {
+    if err := trackingData.Build(); err != nil {
+        return err
+    }
+    if trackingData.CreatedAt.IsZero() {
+        trackingData.CreatedAt = time.Now().UTC()
+        trackingData.UpdatedAt = trackingData.CreatedAt
+    }
+    trackingData.TenantID = tenantOf(ctx)
+    if trackingData.ID.IsZero() {
+        trackingData.ID = primitive.NewObjectID()
+    }
+    assignPublicID(trackingData)
+    return nil
+}
+
+// insertStatement returns an insert of rows records, rows that would break a unique index are skipped and the ids of
+// the inserted ones returned
+func insertStatement(rows int) string {
+    columns := strings.Count(postgresColumns, ",") + 1
+    values := make([]string, rows)
+    for row := range rows {
+        placeholders := make([]string, columns)
+        for column := range columns {
+            placeholders[column] = fmt.Sprintf("$%d", row*columns+column+1)
+        }
+        values[row] = "(" + strings.Join(placeholders, ", ") + ")"
+    }
+    return fmt.Sprintf(
+        "INSERT INTO %s (%s) VALUES %s ON CONFLICT DO NOTHING RETURNING id",
+        postgresTable,
+        postgresColumns,
+        strings.Join(values, ", "),
+    )
+}
+
+func (repo *PostgresTrackingRepository) CreateTrackingData(ctx context.Context, trackingData *TrackingRecord) error {
+    if err := prepare(ctx, trackingData); err != nil {
+        return classifySQL(err)
+    }
+    args, err := recordArgs(trackingData)
+    if err != nil {
+        return err
+    }
+    var id string
+    err = repo.db.QueryRowContext(ctx, insertStatement(1), args...).Scan(&id)
+    if errors.Is(err, sql.ErrNoRows) {
+        return fmt.Errorf("%w: tracking data %s", ErrDuplicate, trackingData.ID.Hex())
+    }
+    return classifySQL(err)
+}
+
+// CreateManyTrackingData inserts the tracking data with multi-row inserts, the duplicates are skipped so they don't
+// stop the rest. The returned slice has an entry per tracking data, nil for the ones that were inserted.
+func (repo *PostgresTrackingRepository) CreateManyTrackingData(
+    ctx context.Context,
+    trackingData []*TrackingRecord,
+) ([]error, error) {
+    itemErrs := make([]error, len(trackingData))
+    var batch []*TrackingRecord
+    // indexes maps the position in batch back to the position in trackingData
+    var indexes []int
+    var args []any
+    insert := func() error {
+        defer func() {
+            batch, indexes, args = batch[:0], indexes[:0], args[:0]
+        }()
+        if len(batch) == 0 {
+            return nil
+        }
+        rows, err := repo.db.QueryContext(ctx, insertStatement(len(batch)), args...)
+        if err != nil {
+            return classifySQL(err)
+        }
+        defer func(rows *sql.Rows) {
+            if err := rows.Close(); err != nil {
+                log.Println("Error closing rows", err)
+            }
+        }(rows)
+        inserted := map[string]bool{}
+        for rows.Next() {
+            var id string
+            if err := rows.Scan(&id); err != nil {
+                return classifySQL(err)
+            }
+            inserted[id] = true
+        }
+        if err := rows.Err(); err != nil {
+            return classifySQL(err)
+        }
+        for i, data := range batch {
+            if !inserted[data.ID.Hex()] {
+                itemErrs[indexes[i]] = fmt.Errorf("%w: tracking data %s", ErrDuplicate, data.ID.Hex())
+            }
+        }
+        return nil
+    }
+
+    for i, data := range trackingData {
+        if err := prepare(ctx, data); err != nil {
+            itemErrs[i] = err
+            continue
+        }
+        // a batch can't insert the same id twice, the second one is a duplicate of the first
+        if slices.ContainsFunc(
+            batch, func(other *TrackingRecord) bool {
+                return other.ID == data.ID
+            },
+        ) {
+            itemErrs[i] = fmt.Errorf("%w: tracking data %s", ErrDuplicate, data.ID.Hex())
+            continue
+        }
+        dataArgs, err := recordArgs(data)
+        if err != nil {
+            itemErrs[i] = err
+            continue
+        }
+        batch, indexes, args = append(batch, data), append(indexes, i), append(args, dataArgs...)
+        if len(batch) == postgresInsertBatch {
+            if err := insert(); err != nil {
+                return nil, err
+            }
+        }
+    }
+    if err := insert(); err != nil {
+        return nil, err
+    }
+    return itemErrs, nil
+}
+
+// query runs a query of the postgresColumns and calls fn for every record it returns
+func (repo *PostgresTrackingRepository) query(
+    ctx context.Context,
+    fn func(record *TrackingRecord) error,
+    query string,
+    args ...any,
+) error {
+    rows, err := repo.db.QueryContext(ctx, query, args...)
+    if err != nil {
+        return classifySQL(err)
+    }
+    defer func(rows *sql.Rows) {
+        if err := rows.Close(); err != nil {
+            log.Println("Error closing rows", err)
+        }
+    }(rows)
+    for rows.Next() {
+        record, err := scanRecord(rows)
+        if err != nil {
+            return err
+        }
+        if err := fn(record); err != nil {
+            return err
+        }
+    }
+    return classifySQL(rows.Err())
+}
+
+// collect returns a function appending the records to trackingData, for query
+func collect(trackingData *[]*TrackingRecord) func(record *TrackingRecord) error {
+    return func(record *TrackingRecord) error {
+        *trackingData = append(*trackingData, record)
+        return nil
+    }
+}
+
+func (repo *PostgresTrackingRepository) FindTrackingData(
+    ctx context.Context,
+    filter *TrackingFilter,
+) ([]*TrackingRecord, error) {
+    query, err := buildSQLQuery(ctx, filter)
+    if err != nil {
+        return nil, classifySQL(err)
+    }
+    statement := fmt.Sprintf("SELECT %s FROM %s%s", postgresColumns, postgresTable, query.clauses())
+    if filter != nil {
+        statement += fmt.Sprintf(
+            " LIMIT %s OFFSET %s",
+            query.arg(filter.PageSize),
+            query.arg((filter.Page-1)*filter.PageSize),
+        )
+    }
+    var trackingData []*TrackingRecord
+    if err := repo.query(ctx, collect(&trackingData), statement, query.args...); err != nil {
+        return nil, err
+    }
+    return trackingData, nil
+}
+
+func (repo *PostgresTrackingRepository) FindTrackingDataByID(ctx context.Context, id string) (*TrackingRecord, error) {
+    objectID, publicID, err := parseID(id)
+    if err != nil {
+        return nil, classifySQL(err)
+    }
+    query := scopedSQLQuery(ctx)
+    if publicID != "" {
+        // the ULIDs have the time of the creation of the tracking data, so only its chunk is read
+        from, err := ulid.Time(publicID)
+        if err != nil {
+            return nil, classifySQL(err)
+        }
+        query.where("public_id = %s", publicID)
+        query.where("created_at >= %s AND created_at < %s", from, from.Add(time.Millisecond))
+    } else {
+        query.where("id = %s", objectID.Hex())
+    }
+    record, err := scanRecord(
+        repo.db.QueryRowContext(
+            ctx,
+            fmt.Sprintf("SELECT %s FROM %s%s LIMIT 1", postgresColumns, postgresTable, query.clauses()),
+            query.args...,
+        ),
+    )
+    if errors.Is(err, ErrNotFound) {
+        return nil, ErrTrackingDataNotFound
+    }
+    return record, err
+}
+
+// StreamTrackingData calls fn for every tracking data matching the filter, ignoring pagination. The rows are read
+// one at a time as the driver receives them, so memory usage doesn't grow with the result size.
+func (repo *PostgresTrackingRepository) StreamTrackingData(
+    ctx context.Context,
+    filter *TrackingFilter,
+    fn func(trackingData *TrackingRecord) error,
+) error {
+    query, err := buildSQLQuery(ctx, filter)
+    if err != nil {
+        return classifySQL(err)
+    }
+    return repo.query(
+        ctx,
+        fn,
+        fmt.Sprintf("SELECT %s FROM %s%s", postgresColumns, postgresTable, query.clauses()),
+        query.args...,
+    )
+}
+
+// FindLatestTrackingData finds the latest tracking data of several vehicles in one round trip, newest first. Every
+// vehicle gets its own lateral subquery, so each one can use the index on vehicle_id and created_at and stop after
+// its limit.
+func (repo *PostgresTrackingRepository) FindLatestTrackingData(
+    ctx context.Context,
+    limits []VehicleLimit,
+) (map[primitive.ObjectID][]*TrackingRecord, error) {
+    trackingData := make(map[primitive.ObjectID][]*TrackingRecord, len(limits))
+    if len(limits) == 0 {
+        return trackingData, nil
+    }
+    query := &sqlQuery{}
+    values := make([]string, len(limits))
+    for i, limit := range limits {
+        values[i] = fmt.Sprintf("(%s::text, %s::integer)", query.arg(limit.VehicleID.Hex()), query.arg(limit.Limit))
+    }
+    // the conditions and order are the ones of the subquery, its placeholders continue after the ones of the limits
+    query.scope(ctx)
+    query.conditions = append(query.conditions, "vehicle_id = limits.vehicle_id")
+    query.orderBy = []string{"created_at DESC", "id DESC"}
+    statement := fmt.Sprintf(
+        "SELECT latest.* FROM (VALUES %s) AS limits (vehicle_id, max_rows) "+
+            "CROSS JOIN LATERAL (SELECT %s FROM %s%s LIMIT limits.max_rows) AS latest",
+        strings.Join(values, ", "),
+        postgresColumns,
+        postgresTable,
+        query.clauses(),
+    )
+    err := repo.query(
+        ctx, func(record *TrackingRecord) error {
+            trackingData[record.VehicleID] = append(trackingData[record.VehicleID], record)
+            return nil
+        }, statement, query.args...,
+    )
+    if err != nil {
+        return nil, err
+    }
+    return trackingData, nil
+}
+
+func (repo *PostgresTrackingRepository) FindTrackingDataAfter(
+    ctx context.Context,
+    after primitive.ObjectID,
+    vehicleIDs []primitive.ObjectID,
+    limit int,
+) ([]*TrackingRecord, error) {
+    query := scopedSQLQuery(ctx)
+    query.where("id > %s", after.Hex())
+    query.whereIn("vehicle_id", hexes(vehicleIDs))
+    query.orderBy = []string{"id ASC"}
+    statement := fmt.Sprintf(
+        "SELECT %s FROM %s%s LIMIT %s",
+        postgresColumns,
+        postgresTable,
+        query.clauses(),
+        query.arg(limit),
+    )
+    var trackingData []*TrackingRecord
+    if err := repo.query(ctx, collect(&trackingData), statement, query.args...); err != nil {
+        return nil, err
+    }
+    return trackingData, nil
+}
+
+// deletionQuery selects the tracking data of the vehicle created before before that a deletion changes, the soft
+// deleted ones only when purging
+func deletionQuery(ctx context.Context, vehicleID primitive.ObjectID, before time.Time, purge bool) *sqlQuery {
+    query := &sqlQuery{}
+    if !purge {
+        query.conditions = append(query.conditions, "deleted_at IS NULL")
+    }
+    if id, ok := tenant.FromContext(ctx); ok {
+        query.where("tenant_id = %s", id)
+    }
+    query.where("vehicle_id = %s", vehicleID.Hex())
+    if !before.IsZero() {
+        query.where("created_at < %s", before)
+    }
+    return query
+}
+
+func (repo *PostgresTrackingRepository) DeleteTrackingData(
+    ctx context.Context,
+    vehicleID primitive.ObjectID,
+    before time.Time,
+    purge bool,
+) (int64, error) {
+    query := deletionQuery(ctx, vehicleID, before, purge)
+    statement := fmt.Sprintf("DELETE FROM %s%s", postgresTable, query.clauses())
+    if !purge {
+        statement = fmt.Sprintf(
+            "UPDATE %s SET deleted_at = %s%s",
+            postgresTable,
+            query.arg(time.Now().UTC()),
+            query.clauses(),
+        )
+    }
+    result, err := repo.db.ExecContext(ctx, statement, query.args...)
+    if err != nil {
+        return 0, classifySQL(err)
+    }
+    deleted, err := result.RowsAffected()
+    return deleted, classifySQL(err)
+}
+
+func (repo *PostgresTrackingRepository) FindDeletionScope(
+    ctx context.Context,
+    vehicleID primitive.ObjectID,
+    before time.Time,
+    purge bool,
+    samples int,
+) (int64, []string, error) {
+    query := deletionQuery(ctx, vehicleID, before, purge)
+    var count int64
+    err := repo.db.QueryRowContext(
+        ctx,
+        fmt.Sprintf("SELECT count(*) FROM %s%s", postgresTable, query.clauses()),
+        query.args...,
+    ).Scan(&count)
+    if err != nil {
+        return 0, nil, classifySQL(err)
+    }
+    query.orderBy = []string{"created_at ASC", "id ASC"}
+    rows, err := repo.db.QueryContext(
+        ctx,
+        fmt.Sprintf(
+            "SELECT COALESCE(public_id, id) FROM %s%s LIMIT %s",
+            postgresTable,
+            query.clauses(),
+            query.arg(samples),
+        ),
+        query.args...,
+    )
+    if err != nil {
+        return 0, nil, classifySQL(err)
+    }
+    defer func(rows *sql.Rows) {
+        if err := rows.Close(); err != nil {
+            log.Println("Error closing rows", err)
+        }
+    }(rows)
+    ids := []string{}
+    for rows.Next() {
+        var id string
+        if err := rows.Scan(&id); err != nil {
+            return 0, nil, classifySQL(err)
+        }
+        ids = append(ids, id)
+    }
+    return count, ids, classifySQL(rows.Err())
+}
+
+func (repo *PostgresTrackingRepository) FlagTrackingData(
+    ctx context.Context,
+    trackingData *TrackingRecord,
+    flag string,
+) error {
+    query := &sqlQuery{}
+    flagArg := query.arg(flag)
+    query.where("id = %s", trackingData.ID.Hex())
+    if id, ok := tenant.FromContext(ctx); ok {
+        query.where("tenant_id = %s", id)
+    }
+    query.conditions = append(query.conditions, fmt.Sprintf("NOT (flags @> jsonb_build_array(%s::text))", flagArg))
+    _, err := repo.db.ExecContext(
+        ctx,
+        fmt.Sprintf(
+            "UPDATE %s SET flags = flags || jsonb_build_array(%s::text)%s",
+            postgresTable,
+            flagArg,
+            query.clauses(),
+        ),
+        query.args...,
+    )
+    if err != nil {
+        return classifySQL(err)
+    }
+    trackingData.AddFlag(flag)
+    return nil
+}
+
+func (repo *PostgresTrackingRepository) UpdateTrackingDistances(
+    ctx context.Context,
+    trackingData []*TrackingRecord,
+) error {
+    for _, data := range trackingData {
+        query := &sqlQuery{}
+        distance, odometer := query.arg(data.DistanceMeters), query.arg(data.OdometerMeters)
+        speed := query.arg(data.SpeedKmh)
+        query.where("id = %s", data.ID.Hex())
+        if id, ok := tenant.FromContext(ctx); ok {
+            query.where("tenant_id = %s", id)
+        }
+        _, err := repo.db.ExecContext(
+            ctx,
+            fmt.Sprintf(
+                "UPDATE %s SET distance_meters = %s, odometer_meters = %s, speed_kmh = %s%s",
+                postgresTable,
+                distance,
+                odometer,
+                speed,
+                query.clauses(),
+            ),
+            query.args...,
+        )
+        if err != nil {
+            return classifySQL(err)
+        }
+    }
+    return nil
+}
+
+func (repo *PostgresTrackingRepository) FindActiveVehicles(
+    ctx context.Context,
+    since time.Time,
+    limit int,
+) ([]*ActiveVehicle, error) {
+    query := scopedSQLQuery(ctx)
+    query.where("created_at >= %s", since)
+    rows, err := repo.db.QueryContext(
+        ctx,
+        fmt.Sprintf(
+            "SELECT tenant_id, vehicle_id, max(created_at) AS last_seen FROM %s%s "+
+                "GROUP BY tenant_id, vehicle_id ORDER BY last_seen DESC LIMIT %s",
+            postgresTable,
+            query.clauses(),
+            query.arg(limit),
+        ),
+        query.args...,
+    )
+    if err != nil {
+        return nil, classifySQL(err)
+    }
+    defer func(rows *sql.Rows) {
+        if err := rows.Close(); err != nil {
+            log.Println("Error closing rows", err)
+        }
+    }(rows)
+    var vehicles []*ActiveVehicle
+    for rows.Next() {
+        var vehicle ActiveVehicle
+        var vehicleID string
+        if err := rows.Scan(&vehicle.TenantID, &vehicleID, &vehicle.LastSeen); err != nil {
+            return nil, classifySQL(err)
+        }
+        if vehicle.VehicleID, err = primitive.ObjectIDFromHex(vehicleID); err != nil {
+            return nil, err
+        }
+        vehicle.LastSeen = vehicle.LastSeen.UTC()
+        vehicles = append(vehicles, &vehicle)
+    }
+    return vehicles, classifySQL(rows.Err())
+}
+
+func (repo *PostgresTrackingRepository) FindLatestVersion(
+    ctx context.Context,
+    filter *TrackingFilter,
+) (*TrackingDataVersion, error) {
+    query, err := buildSQLQuery(ctx, filter)
+    if err != nil {
+        return nil, classifySQL(err)
+    }
+    // the hex ids sort like the ObjectIDs
+    query.orderBy = nil
+    var id sql.NullString
+    var version TrackingDataVersion
+    err = repo.db.QueryRowContext(
+        ctx,
+        fmt.Sprintf("SELECT max(id), count(*) FROM %s%s", postgresTable, query.clauses()),
+        query.args...,
+    ).Scan(&id, &version.Count)
+    if err != nil {
+        return nil, classifySQL(err)
+    }
+    if !id.Valid {
+        return nil, nil
+    }
+    if version.ID, err = primitive.ObjectIDFromHex(id.String); err != nil {
+        return nil, err
+    }
+    return &version, nil
+}
@@ -0,0 +1,134 @@
+package repositories
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "slices"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestBuildSQLQuery(t *testing.T) {
+    vehicleID, other := primitive.NewObjectID(), primitive.NewObjectID()
+    mileageMin := 100.0
+    filter := &TrackingFilter{
+        VehicleID:  vehicleID.Hex() + "," + other.Hex(),
+        Location:   "50%_off",
+        MileageMin: &mileageMin,
+        Status:     models.VehicleStatusActive,
+        From:       "2024-01-01T00:00:00Z",
+        SortField:  "-distance_meters,created_at",
+        Exclude:    ExcludeAnomalies,
+    }
+    filter.RestrictVehicles([]primitive.ObjectID{vehicleID})
+    query, err := buildSQLQuery(tenant.WithID(context.Background(), "acme"), filter)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    expected := " WHERE deleted_at IS NULL AND tenant_id = $1 AND NOT (flags @> jsonb_build_array($2::text)) " +
+        "AND vehicle_id IN ($3, $4) AND vehicle_id = $5 AND location ILIKE $6 AND mileage >= $7 AND status = $8 " +
+        "AND created_at >= $9 ORDER BY distance_meters DESC NULLS LAST, created_at ASC, id ASC"
+    if clauses := query.clauses(); clauses != expected {
+        t.Fatalf("Should translate the filter to SQL, got %q", clauses)
+    }
+    args := []any{
+        "acme",
+        FlagAnomaly,
+        vehicleID.Hex(),
+        other.Hex(),
+        vehicleID.Hex(),
+        `50\%\_off%`,
+        100.0,
+        string(models.VehicleStatusActive),
+        time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+    }
+    if fmt.Sprint(query.args) != fmt.Sprint(args) {
+        t.Fatalf("Should pass the values of the filter as arguments, got %v", query.args)
+    }
+}
+
+func TestBuildSQLQuery_RestrictedToNone(t *testing.T) {
+    filter := &TrackingFilter{VehicleID: primitive.NewObjectID().Hex(), SortOrder: "desc"}
+    filter.RestrictVehicles([]primitive.ObjectID{primitive.NewObjectID()})
+    query, err := buildSQLQuery(context.Background(), filter)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !slices.Contains(query.conditions, "FALSE") {
+        t.Fatalf("Should match nothing when the vehicle isn't allowed, got %v", query.conditions)
+    }
+    if !slices.Equal(query.orderBy, []string{"created_at DESC", "id ASC"}) {
+        t.Fatalf("Should sort on the fields present on every row without NULLS LAST, got %v", query.orderBy)
+    }
+}
+
+func TestBuildSQLQuery_Sensors(t *testing.T) {
+    query, err := buildSQLQuery(context.Background(), &TrackingFilter{Sensor: "temperature:-20..-15,door_open:false"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    expected := " WHERE deleted_at IS NULL AND sensors @? $1::jsonpath AND sensors @> $2::jsonb " +
+        "ORDER BY created_at ASC, id ASC"
+    if clauses := query.clauses(); clauses != expected {
+        t.Fatalf("Should translate the sensor conditions to SQL, got %q", clauses)
+    }
+    args := []any{"$.temperature ? (@ >= -20 && @ <= -15)", `{"door_open":false}`}
+    if !slices.Equal(query.args, args) {
+        t.Fatalf("Should pass the path and the value of the sensors as arguments, got %v", query.args)
+    }
+}
+
+func TestInsertStatement(t *testing.T) {
+    statement := insertStatement(2)
+    firstRow := "($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20), ($21,"
+    if !strings.Contains(statement, firstRow) ||
+        !strings.HasSuffix(statement, "$40) ON CONFLICT DO NOTHING RETURNING id") {
+        t.Fatalf("Should insert every row with its own placeholders, got %s", statement)
+    }
+    args, err := recordArgs(newMemoryRecord(primitive.NewObjectID(), time.Now(), 0))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(args) != 20 || args[14] != "[]" {
+        t.Fatalf("Should pass a value per column with empty flags, got %v", args)
+    }
+}
+
+type sqlStateErr string
+
+func (e sqlStateErr) Error() string {
+    return "sqlstate " + string(e)
+}
+
+func (e sqlStateErr) SQLState() string {
+    return string(e)
+}
+
+func TestClassifySQL(t *testing.T) {
+    tests := []struct {
+        err  error
+        kind error
+    }{
+        {err: sql.ErrNoRows, kind: ErrNotFound},
+        {err: fmt.Errorf("insert: %w", sqlStateErr("23505")), kind: ErrDuplicate},
+        {err: sqlStateErr("08006"), kind: ErrTransient},
+        {err: sqlStateErr("40001"), kind: ErrTransient},
+        {err: sql.ErrConnDone, kind: ErrTransient},
+    }
+    for _, test := range tests {
+        if err := classifySQL(test.err); !errors.Is(err, test.kind) {
+            t.Errorf("Should classify %v as %v, got %v", test.err, test.kind, err)
+        }
+    }
+    if err := classifySQL(sqlStateErr("23502")); errors.Is(err, ErrDuplicate) || errors.Is(err, ErrTransient) {
+        t.Errorf("Should leave other errors unclassified, got %v", err)
+    }
+}
@@ -0,0 +1,53 @@
+package repositories
+
+import (
+    "context"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/ulid"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Public IDs are ULIDs assigned when the tracking data is stored. Unlike the ObjectIDs they don't depend on
+// Mongo, so the links and bookmarks of clients keep working when the storage changes. Lookups accept both.
+
+// publicIDIndexes makes the public ids unique, the tracking data stored before public ids were added has none
+var publicIDIndexes = []mongo.IndexModel{
+    {
+        Keys: bson.D{{Key: "public_id", Value: 1}},
+        Options: options.Index().
+            SetUnique(true).
+            SetPartialFilterExpression(bson.M{"public_id": bson.M{"$exists": true}}),
+    },
+}
+
+// CreatePublicIDIndexes creates the index of the public id lookups, on every partition when partitioned
+func (repo *MongoTackingRepository) CreatePublicIDIndexes(ctx context.Context) error {
+    if repo.partitions != nil {
+        return repo.partitions.AddIndexes(ctx, publicIDIndexes)
+    }
+    _, err := repo.collection.Indexes().CreateMany(ctx, publicIDIndexes)
+    return err
+}
+
+// assignPublicID sets a public id on the record if it has none, the time of the ULID is the creation time of
+// the record so public ids sort like the records
+func assignPublicID(record *TrackingRecord) {
+    if record.PublicID == "" {
+        record.PublicID = ulid.New(record.CreatedAt)
+    }
+}
+
+// parseID parses an ObjectID hex or a ULID, only one of the returned ids is set
+func parseID(id string) (primitive.ObjectID, string, error) {
+    if objectID, err := primitive.ObjectIDFromHex(id); err == nil {
+        return objectID, "", nil
+    }
+    publicID, err := ulid.Parse(id)
+    if err != nil {
+        return primitive.NilObjectID, "", ErrInvalidID
+    }
+    return primitive.NilObjectID, publicID, nil
+}
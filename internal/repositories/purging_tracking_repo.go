@@ -0,0 +1,123 @@
+package repositories
+
+import (
+    "context"
+    "log"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/cdn"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PurgingTrackingRepository purges the responses CDNs cached for immutable historical queries when they are
+// corrected: readings stored or flagged after the settle time of their period and deletions. The responses of
+// the vehicle and of the queries over all vehicles of the tenant are purged. Purge failures are logged, the
+// responses then stay cached until they expire.
+type PurgingTrackingRepository struct {
+    TrackingRepository
+    purger cdn.Purger
+    policy cdn.Policy
+}
+
+func NewPurgingTrackingRepository(
+    trackingRepo TrackingRepository,
+    purger cdn.Purger,
+    policy cdn.Policy,
+) *PurgingTrackingRepository {
+    return &PurgingTrackingRepository{TrackingRepository: trackingRepo, purger: purger, policy: policy}
+}
+
+func (repo *PurgingTrackingRepository) CreateTrackingData(ctx context.Context, trackingData *TrackingRecord) error {
+    if err := repo.TrackingRepository.CreateTrackingData(ctx, trackingData); err != nil {
+        return err
+    }
+    if repo.policy.Correction(trackingData.CreatedAt, time.Now()) {
+        repo.purge(ctx, trackingData.VehicleID)
+    }
+    return nil
+}
+
+func (repo *PurgingTrackingRepository) CreateManyTrackingData(
+    ctx context.Context,
+    trackingData []*TrackingRecord,
+) ([]error, error) {
+    errs, err := repo.TrackingRepository.CreateManyTrackingData(ctx, trackingData)
+    if err != nil {
+        return errs, err
+    }
+    now := time.Now()
+    var vehicleIDs []primitive.ObjectID
+    seen := make(map[primitive.ObjectID]bool, len(trackingData))
+    for i, data := range trackingData {
+        if (errs == nil || errs[i] == nil) && !seen[data.VehicleID] && repo.policy.Correction(data.CreatedAt, now) {
+            seen[data.VehicleID] = true
+            vehicleIDs = append(vehicleIDs, data.VehicleID)
+        }
+    }
+    repo.purge(ctx, vehicleIDs...)
+    return errs, nil
+}
+
+func (repo *PurgingTrackingRepository) DeleteTrackingData(
+    ctx context.Context,
+    vehicleID primitive.ObjectID,
+    before time.Time,
+    purge bool,
+) (int64, error) {
+    deleted, err := repo.TrackingRepository.DeleteTrackingData(ctx, vehicleID, before, purge)
+    if err != nil {
+        return deleted, err
+    }
+    if deleted > 0 {
+        repo.purge(ctx, vehicleID)
+    }
+    return deleted, nil
+}
+
+func (repo *PurgingTrackingRepository) FlagTrackingData(
+    ctx context.Context,
+    trackingData *TrackingRecord,
+    flag string,
+) error {
+    if err := repo.TrackingRepository.FlagTrackingData(ctx, trackingData, flag); err != nil {
+        return err
+    }
+    if repo.policy.Correction(trackingData.CreatedAt, time.Now()) {
+        repo.purge(ctx, trackingData.VehicleID)
+    }
+    return nil
+}
+
+func (repo *PurgingTrackingRepository) UpdateTrackingDistances(
+    ctx context.Context,
+    trackingData []*TrackingRecord,
+) error {
+    if err := repo.TrackingRepository.UpdateTrackingDistances(ctx, trackingData); err != nil {
+        return err
+    }
+    now := time.Now()
+    var vehicleIDs []primitive.ObjectID
+    seen := make(map[primitive.ObjectID]bool, len(trackingData))
+    for _, data := range trackingData {
+        if !seen[data.VehicleID] && repo.policy.Correction(data.CreatedAt, now) {
+            seen[data.VehicleID] = true
+            vehicleIDs = append(vehicleIDs, data.VehicleID)
+        }
+    }
+    repo.purge(ctx, vehicleIDs...)
+    return nil
+}
+
+func (repo *PurgingTrackingRepository) purge(ctx context.Context, vehicleIDs ...primitive.ObjectID) {
+    if len(vehicleIDs) == 0 {
+        return
+    }
+    keys := make([]string, 0, len(vehicleIDs)+1)
+    for _, vehicleID := range vehicleIDs {
+        keys = append(keys, cdn.VehicleKey(vehicleID.Hex()))
+    }
+    keys = append(keys, cdn.FleetKey(tenantOf(ctx)))
+    if err := repo.purger.Purge(ctx, keys...); err != nil {
+        log.Println("Failed to purge cached tracking data responses", err)
+    }
+}
@@ -0,0 +1,72 @@
+package repositories
+
+import (
+    "context"
+    "slices"
+    "testing"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/cdn"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type recordingPurger struct {
+    purges [][]string
+}
+
+func (p *recordingPurger) Purge(_ context.Context, keys ...string) error {
+    p.purges = append(p.purges, keys)
+    return nil
+}
+
+// deletingTrackingRepo is countingTrackingRepo with deletions, every deletion removes one reading
+type deletingTrackingRepo struct {
+    countingTrackingRepo
+}
+
+func (repo *deletingTrackingRepo) DeleteTrackingData(
+    context.Context,
+    primitive.ObjectID,
+    time.Time,
+    bool,
+) (int64, error) {
+    return 1, nil
+}
+
+func TestPurgingTrackingRepository(t *testing.T) {
+    purger := &recordingPurger{}
+    repo := NewPurgingTrackingRepository(
+        &deletingTrackingRepo{},
+        purger,
+        cdn.Policy{MaxAge: time.Hour, Settle: time.Hour},
+    )
+    ctx := tenant.WithID(context.Background(), "acme")
+    vehicleID := primitive.NewObjectID()
+
+    // a live reading can't be part of an immutable response
+    live := NewTrackingRecord(&models.TrackingData{VehicleID: vehicleID, CreatedAt: time.Now()})
+    if err := repo.CreateTrackingData(ctx, live); err != nil {
+        t.Fatal(err)
+    }
+    if len(purger.purges) != 0 {
+        t.Fatalf("a live reading purged %v", purger.purges)
+    }
+
+    late := NewTrackingRecord(&models.TrackingData{VehicleID: vehicleID, CreatedAt: time.Now().Add(-2 * time.Hour)})
+    if err := repo.CreateTrackingData(ctx, late); err != nil {
+        t.Fatal(err)
+    }
+    want := []string{cdn.VehicleKey(vehicleID.Hex()), cdn.FleetKey("acme")}
+    if len(purger.purges) != 1 || !slices.Equal(purger.purges[0], want) {
+        t.Fatalf("a late reading purged %v, want %v", purger.purges, want)
+    }
+
+    if _, err := repo.DeleteTrackingData(ctx, vehicleID, time.Now(), false); err != nil {
+        t.Fatal(err)
+    }
+    if len(purger.purges) != 2 || !slices.Equal(purger.purges[1], want) {
+        t.Fatalf("a deletion purged %v, want %v", purger.purges, want)
+    }
+}
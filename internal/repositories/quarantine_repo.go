@@ -0,0 +1,200 @@
+package repositories
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "strings"
+    "time"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrQuarantinedReadingNotFound = fmt.Errorf("quarantined reading %w", ErrNotFound)
+
+// QuarantinedReading is a reading that failed the data quality checks, kept out of the tracking data until it is
+// reviewed. Reading is the reading as it was received.
+type QuarantinedReading struct {
+    ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+    TenantID   string             `json:"tenant_id,omitempty" bson:"tenant_id,omitempty"`
+    VehicleID  primitive.ObjectID `json:"vehicle_id" bson:"vehicle_id"`
+    Checks     []string           `json:"checks" bson:"checks"`
+    Reading    json.RawMessage    `json:"reading" bson:"reading"`
+    ReceivedAt timestamp.Time     `json:"received_at" bson:"received_at"`
+}
+
+type QuarantineFilter struct {
+    Page      int    `json:"page"`
+    PageSize  int    `json:"limit"`
+    VehicleID string `json:"vehicle_id" doc:"Comma separated vehicle ids"`
+    Check     string `json:"check" doc:"A failed check, like speed_jump"`
+    From      string `json:"from" doc:"RFC3339 start of received_at, inclusive"`
+    To        string `json:"to" doc:"RFC3339 end of received_at, exclusive"`
+
+    vehicleIDs []primitive.ObjectID
+    from       time.Time
+    to         time.Time
+}
+
+func (f *QuarantineFilter) Build() error {
+    if f.Page == 0 {
+        f.Page = 1
+    }
+    f.PageSize = pageSize(f.PageSize)
+    f.vehicleIDs = nil
+    if f.VehicleID != "" {
+        for _, value := range strings.Split(f.VehicleID, ",") {
+            id, err := primitive.ObjectIDFromHex(strings.TrimSpace(value))
+            if err != nil {
+                return ErrInvalidID
+            }
+            f.vehicleIDs = append(f.vehicleIDs, id)
+        }
+    }
+    if f.From != "" {
+        from, err := time.Parse(time.RFC3339, f.From)
+        if err != nil {
+            return ErrInvalidTimeRange
+        }
+        f.from = from
+    }
+    if f.To != "" {
+        to, err := time.Parse(time.RFC3339, f.To)
+        if err != nil {
+            return ErrInvalidTimeRange
+        }
+        f.to = to
+    }
+    if !f.from.IsZero() && !f.to.IsZero() && !f.from.Before(f.to) {
+        return ErrInvalidTimeRange
+    }
+    return nil
+}
+
+// match selects the quarantined readings of the tenant, vehicles, check and period of the filter
+func (f *QuarantineFilter) match(ctx context.Context) bson.M {
+    match := scopeTenant(ctx, bson.M{})
+    if f.vehicleIDs != nil {
+        match["vehicle_id"] = bson.M{"$in": f.vehicleIDs}
+    }
+    if f.Check != "" {
+        match["checks"] = f.Check
+    }
+    if !f.from.IsZero() || !f.to.IsZero() {
+        receivedAt := bson.M{}
+        if !f.from.IsZero() {
+            receivedAt["$gte"] = f.from
+        }
+        if !f.to.IsZero() {
+            receivedAt["$lt"] = f.to
+        }
+        match["received_at"] = receivedAt
+    }
+    return match
+}
+
+type QuarantineRepository interface {
+    CreateQuarantinedReading(ctx context.Context, reading *QuarantinedReading) error
+    // FindQuarantinedReadings returns a page of the quarantined readings of the filter, the latest first
+    FindQuarantinedReadings(ctx context.Context, filter *QuarantineFilter) ([]*QuarantinedReading, error)
+    // TakeQuarantinedReading deletes the quarantined reading and returns it, so it is released or discarded once
+    TakeQuarantinedReading(ctx context.Context, id primitive.ObjectID) (*QuarantinedReading, error)
+}
+
+type MongoQuarantineRepository struct {
+    collection *mongo.Collection
+}
+
+func NewMongoQuarantineRepository(db *mongo.Database) *MongoQuarantineRepository {
+    return &MongoQuarantineRepository{collection: db.Collection("quarantined_readings")}
+}
+
+// CreateIndexes creates the indexes of the review of the quarantined readings, latest first
+func (repo *MongoQuarantineRepository) CreateIndexes(ctx context.Context) error {
+    _, err := repo.collection.Indexes().CreateMany(
+        ctx,
+        []mongo.IndexModel{
+            {Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "received_at", Value: -1}}},
+            {
+                Keys: bson.D{
+                    {Key: "tenant_id", Value: 1},
+                    {Key: "vehicle_id", Value: 1},
+                    {Key: "received_at", Value: -1},
+                },
+            },
+        },
+    )
+    return classify(err)
+}
+
+func (repo *MongoQuarantineRepository) CreateQuarantinedReading(
+    ctx context.Context,
+    reading *QuarantinedReading,
+) error {
+    if reading.ReceivedAt.IsZero() {
+        reading.ReceivedAt = timestamp.Now()
+    }
+    reading.TenantID = tenantOf(ctx)
+    result, err := repo.collection.InsertOne(ctx, reading)
+    if err != nil {
+        return classify(err)
+    }
+    reading.ID = result.InsertedID.(primitive.ObjectID)
+    return nil
+}
+
+func (repo *MongoQuarantineRepository) FindQuarantinedReadings(
+    ctx context.Context,
+    filter *QuarantineFilter,
+) ([]*QuarantinedReading, error) {
+    if err := filter.Build(); err != nil {
+        return nil, err
+    }
+    cursor, err := repo.collection.Find(
+        ctx,
+        filter.match(ctx),
+        options.Find().
+            SetSort(bson.D{{Key: "received_at", Value: -1}, {Key: "_id", Value: -1}}).
+            SetSkip(int64((filter.Page-1)*filter.PageSize)).
+            SetLimit(int64(filter.PageSize)),
+    )
+    if err != nil {
+        return nil, classify(err)
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
+        }
+    }(cursor, ctx)
+    var readings []*QuarantinedReading
+    for cursor.Next(ctx) {
+        var reading QuarantinedReading
+        if err := cursor.Decode(&reading); err != nil {
+            return nil, err
+        }
+        readings = append(readings, &reading)
+    }
+    return readings, cursor.Err()
+}
+
+func (repo *MongoQuarantineRepository) TakeQuarantinedReading(
+    ctx context.Context,
+    id primitive.ObjectID,
+) (*QuarantinedReading, error) {
+    var reading QuarantinedReading
+    err := repo.collection.FindOneAndDelete(ctx, scopeTenant(ctx, bson.M{"_id": id})).Decode(&reading)
+    if errors.Is(err, mongo.ErrNoDocuments) {
+        return nil, ErrQuarantinedReadingNotFound
+    }
+    if err != nil {
+        return nil, classify(err)
+    }
+    return &reading, nil
+}
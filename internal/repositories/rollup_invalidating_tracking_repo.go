@@ -0,0 +1,107 @@
+package repositories
+
+import (
+    "context"
+    "time"
+
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RollupInvalidator rolls up the periods of a vehicle again once their readings changed
+type RollupInvalidator interface {
+    Invalidate(ctx context.Context, vehicleID primitive.ObjectID, from, to time.Time)
+}
+
+// RollupInvalidatingTrackingRepository keeps the rollups in line with corrections of the readings they were
+// computed from: readings stored late, flagged or deleted. Deleted readings take their rollups with them right away,
+// the other corrections invalidate the periods of the readings to be rolled up again by the next pass.
+type RollupInvalidatingTrackingRepository struct {
+    TrackingRepository
+    rollupRepo  TrackingRollupRepository
+    invalidator RollupInvalidator
+}
+
+func NewRollupInvalidatingTrackingRepository(
+    trackingRepo TrackingRepository,
+    rollupRepo TrackingRollupRepository,
+    invalidator RollupInvalidator,
+) *RollupInvalidatingTrackingRepository {
+    return &RollupInvalidatingTrackingRepository{
+        TrackingRepository: trackingRepo,
+        rollupRepo:         rollupRepo,
+        invalidator:        invalidator,
+    }
+}
+
+func (repo *RollupInvalidatingTrackingRepository) CreateTrackingData(
+    ctx context.Context,
+    trackingData *TrackingRecord,
+) error {
+    if err := repo.TrackingRepository.CreateTrackingData(ctx, trackingData); err != nil {
+        return err
+    }
+    repo.invalidator.Invalidate(ctx, trackingData.VehicleID, trackingData.CreatedAt, trackingData.CreatedAt)
+    return nil
+}
+
+func (repo *RollupInvalidatingTrackingRepository) UpdateTrackingDistances(
+    ctx context.Context,
+    trackingData []*TrackingRecord,
+) error {
+    if err := repo.TrackingRepository.UpdateTrackingDistances(ctx, trackingData); err != nil {
+        return err
+    }
+    for _, data := range trackingData {
+        repo.invalidator.Invalidate(ctx, data.VehicleID, data.CreatedAt, data.CreatedAt)
+    }
+    return nil
+}
+
+func (repo *RollupInvalidatingTrackingRepository) CreateManyTrackingData(
+    ctx context.Context,
+    trackingData []*TrackingRecord,
+) ([]error, error) {
+    errs, err := repo.TrackingRepository.CreateManyTrackingData(ctx, trackingData)
+    if err != nil {
+        return errs, err
+    }
+    for i, data := range trackingData {
+        if errs == nil || errs[i] == nil {
+            repo.invalidator.Invalidate(ctx, data.VehicleID, data.CreatedAt, data.CreatedAt)
+        }
+    }
+    return errs, nil
+}
+
+// DeleteTrackingData deletes the rollups of the periods starting before before, the period before falls in still
+// has readings after it so it is rolled up again
+func (repo *RollupInvalidatingTrackingRepository) DeleteTrackingData(
+    ctx context.Context,
+    vehicleID primitive.ObjectID,
+    before time.Time,
+    purge bool,
+) (int64, error) {
+    deleted, err := repo.TrackingRepository.DeleteTrackingData(ctx, vehicleID, before, purge)
+    if err != nil || deleted == 0 {
+        return deleted, err
+    }
+    if err := repo.rollupRepo.DeleteRollups(ctx, vehicleID, before); err != nil {
+        return deleted, err
+    }
+    if !before.IsZero() {
+        repo.invalidator.Invalidate(ctx, vehicleID, before, before)
+    }
+    return deleted, nil
+}
+
+func (repo *RollupInvalidatingTrackingRepository) FlagTrackingData(
+    ctx context.Context,
+    trackingData *TrackingRecord,
+    flag string,
+) error {
+    if err := repo.TrackingRepository.FlagTrackingData(ctx, trackingData, flag); err != nil {
+        return err
+    }
+    repo.invalidator.Invalidate(ctx, trackingData.VehicleID, trackingData.CreatedAt, trackingData.CreatedAt)
+    return nil
+}
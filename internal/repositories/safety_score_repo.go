@@ -0,0 +1,333 @@
+package repositories
+
+import (
+    "context"
+    "errors"
+    "log"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+    SafetyScoreByVehicle = "vehicle"
+    SafetyScoreByDriver  = "driver"
+
+    // MaxSafetyScoreDays is the longest window of the safety scores
+    MaxSafetyScoreDays = 90
+)
+
+var (
+    ErrInvalidSafetyScoreBy   = errors.New("by must be vehicle or driver")
+    ErrInvalidSafetyScoreDays = errors.New("days must be between 1 and 90")
+)
+
+// SafetyCounts are the driving behaviors a safety score is computed from
+type SafetyCounts struct {
+    DistanceMeters float64 `json:"distance_meters" bson:"distance_meters"`
+    SpeedingEvents int64   `json:"speeding_events" bson:"speeding_events"`
+    HarshEvents    int64   `json:"harsh_events" bson:"harsh_events"`
+    IdleSeconds    float64 `json:"idle_seconds" bson:"idle_seconds"`
+}
+
+// IsZero reports whether nothing was counted
+func (c SafetyCounts) IsZero() bool {
+    return c == SafetyCounts{}
+}
+
+// SafetyScoreDay is the driving of a vehicle by a driver during a UTC day, the readings without a driver are counted
+// with an empty driver id
+type SafetyScoreDay struct {
+    VehicleID    primitive.ObjectID `json:"vehicle_id" bson:"vehicle_id"`
+    TenantID     string             `json:"tenant_id,omitempty" bson:"tenant_id,omitempty"`
+    DriverID     string             `json:"driver_id" bson:"driver_id"`
+    Day          timestamp.Time     `json:"day" bson:"day"`
+    SafetyCounts `bson:",inline"`
+}
+
+// SafetyTotals are the counts of a vehicle or of a driver over the window of a filter, per day for the daily filters
+type SafetyTotals struct {
+    TenantID     string             `bson:"tenant_id"`
+    VehicleID    primitive.ObjectID `bson:"vehicle_id"`
+    DriverID     string             `bson:"driver_id"`
+    Day          time.Time          `bson:"day"`
+    SafetyCounts `bson:",inline"`
+}
+
+// SafetyState is the previous scored reading of a vehicle the next one is compared with
+type SafetyState struct {
+    VehicleID primitive.ObjectID `json:"vehicle_id" bson:"vehicle_id"`
+    TenantID  string             `json:"tenant_id,omitempty" bson:"tenant_id,omitempty"`
+    Lat       float64            `json:"lat" bson:"lat"`
+    Lng       float64            `json:"lng" bson:"lng"`
+    At        timestamp.Time     `json:"at" bson:"at"`
+    // SpeedKmh is the average speed since the reading before, nil when it is unknown
+    SpeedKmh *float64 `json:"speed_kmh,omitempty" bson:"speed_kmh,omitempty"`
+    Speeding bool     `json:"speeding" bson:"speeding"`
+}
+
+type SafetyScoreFilter struct {
+    Page     int `json:"page"`
+    PageSize int `json:"limit"`
+    // By is what the scores are of, vehicle (default) or driver
+    By        string `json:"by" doc:"vehicle (default) or driver"`
+    VehicleID string `json:"vehicle_id"`
+    DriverID  string `json:"driver_id"`
+    // Days is the number of UTC days of the window, ending with today
+    Days int `json:"days"`
+
+    vehicleID  primitive.ObjectID
+    restricted []primitive.ObjectID
+    from       time.Time
+    to         time.Time
+    daily      bool
+}
+
+// RestrictVehicles limits the scores to the given vehicles, on top of the vehicle_id filter
+func (f *SafetyScoreFilter) RestrictVehicles(vehicleIDs []primitive.ObjectID) {
+    f.restricted = vehicleIDs
+}
+
+// Window limits the scores to the UTC days [from, to), instead of the days ending with today
+func (f *SafetyScoreFilter) Window(from, to time.Time) {
+    f.from, f.to = from, to
+}
+
+// Daily counts every day of the window apart
+func (f *SafetyScoreFilter) Daily() {
+    f.daily = true
+}
+
+// Build validates the filter, the window is computed from now when it isn't set
+func (f *SafetyScoreFilter) Build(now time.Time) error {
+    if f.Page == 0 {
+        f.Page = 1
+    }
+    f.PageSize = pageSize(f.PageSize)
+    if f.By == "" {
+        f.By = SafetyScoreByVehicle
+    }
+    if f.By != SafetyScoreByVehicle && f.By != SafetyScoreByDriver {
+        return ErrInvalidSafetyScoreBy
+    }
+    if f.Days < 1 || f.Days > MaxSafetyScoreDays {
+        return ErrInvalidSafetyScoreDays
+    }
+    if f.VehicleID != "" {
+        id, err := primitive.ObjectIDFromHex(f.VehicleID)
+        if err != nil {
+            return ErrInvalidID
+        }
+        f.vehicleID = id
+    }
+    if len(f.DriverID) > MaxDriverIDLength {
+        return ErrInvalidDriverID
+    }
+    if f.from.IsZero() {
+        today := now.UTC().Truncate(24 * time.Hour)
+        f.from, f.to = today.AddDate(0, 0, 1-f.Days), today.AddDate(0, 0, 1)
+    }
+    return nil
+}
+
+// match selects the days of the window and of the vehicles and driver of the filter
+func (f *SafetyScoreFilter) match(ctx context.Context) bson.M {
+    match := scopeTenant(ctx, bson.M{"day": bson.M{"$gte": f.from, "$lt": f.to}})
+    if !f.vehicleID.IsZero() {
+        match["vehicle_id"] = f.vehicleID
+    }
+    if f.restricted != nil {
+        vehicleIDs := f.restricted
+        if !f.vehicleID.IsZero() {
+            vehicleIDs = intersect(f.restricted, f.vehicleID)
+        }
+        match["vehicle_id"] = bson.M{"$in": vehicleIDs}
+    }
+    if f.DriverID != "" {
+        match["driver_id"] = f.DriverID
+    } else if f.By == SafetyScoreByDriver {
+        // the readings without a driver have no driver to score
+        match["driver_id"] = bson.M{"$ne": ""}
+    }
+    return match
+}
+
+type SafetyScoreRepository interface {
+    // AddSafetyCounts adds the counts to the day of the vehicle and driver
+    AddSafetyCounts(ctx context.Context, day *SafetyScoreDay) error
+    // SumSafetyCounts sums the counts of the window of the filter per tenant and vehicle or driver, and per day for
+    // the daily filters. The totals are ordered by vehicle or driver, the newest day first.
+    SumSafetyCounts(ctx context.Context, filter *SafetyScoreFilter) ([]*SafetyTotals, error)
+    // FindSafetyState returns the state of the vehicle, nil when it has none yet
+    FindSafetyState(ctx context.Context, vehicleID primitive.ObjectID) (*SafetyState, error)
+    SaveSafetyState(ctx context.Context, state *SafetyState) error
+}
+
+type MongoSafetyScoreRepository struct {
+    days   *mongo.Collection
+    states *mongo.Collection
+}
+
+func NewMongoSafetyScoreRepository(db *mongo.Database) *MongoSafetyScoreRepository {
+    return &MongoSafetyScoreRepository{
+        days:   db.Collection("safety_score_days"),
+        states: db.Collection("safety_score_states"),
+    }
+}
+
+// CreateIndexes creates the unique indexes of the day of every vehicle and driver and of the state of every vehicle,
+// and the index of the days of the drivers
+func (repo *MongoSafetyScoreRepository) CreateIndexes(ctx context.Context) error {
+    _, err := repo.days.Indexes().CreateMany(
+        ctx,
+        []mongo.IndexModel{
+            {
+                Keys: bson.D{
+                    {Key: "tenant_id", Value: 1},
+                    {Key: "vehicle_id", Value: 1},
+                    {Key: "day", Value: 1},
+                    {Key: "driver_id", Value: 1},
+                },
+                Options: options.Index().SetUnique(true),
+            },
+            {
+                Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "driver_id", Value: 1}, {Key: "day", Value: 1}},
+            },
+        },
+    )
+    if err != nil {
+        return classify(err)
+    }
+    _, err = repo.states.Indexes().CreateOne(
+        ctx,
+        mongo.IndexModel{
+            Keys:    bson.D{{Key: "tenant_id", Value: 1}, {Key: "vehicle_id", Value: 1}},
+            Options: options.Index().SetUnique(true),
+        },
+    )
+    return classify(err)
+}
+
+// AddSafetyCounts increments the counts, concurrent upserts of a new day fail on the unique index and are retried
+// once to increment the day of the other write
+func (repo *MongoSafetyScoreRepository) AddSafetyCounts(ctx context.Context, day *SafetyScoreDay) error {
+    day.TenantID = tenantOf(ctx)
+    update := bson.M{
+        "$inc": bson.M{
+            "distance_meters": day.DistanceMeters,
+            "speeding_events": day.SpeedingEvents,
+            "harsh_events":    day.HarshEvents,
+            "idle_seconds":    day.IdleSeconds,
+        },
+    }
+    var err error
+    for range 2 {
+        _, err = repo.days.UpdateOne(
+            ctx,
+            scopeTenant(ctx, bson.M{"vehicle_id": day.VehicleID, "day": day.Day.Time, "driver_id": day.DriverID}),
+            update,
+            options.Update().SetUpsert(true),
+        )
+        if !mongo.IsDuplicateKeyError(err) {
+            break
+        }
+    }
+    return classify(err)
+}
+
+func (repo *MongoSafetyScoreRepository) SumSafetyCounts(
+    ctx context.Context,
+    filter *SafetyScoreFilter,
+) ([]*SafetyTotals, error) {
+    subject := "$vehicle_id"
+    if filter.By == SafetyScoreByDriver {
+        subject = "$driver_id"
+    }
+    key := bson.D{{Key: "tenant_id", Value: "$tenant_id"}, {Key: filter.By, Value: subject}}
+    sort := bson.D{{Key: "_id.tenant_id", Value: 1}, {Key: "_id." + filter.By, Value: 1}}
+    if filter.daily {
+        key = append(key, bson.E{Key: "day", Value: "$day"})
+        sort = bson.D{{Key: "_id.day", Value: -1}, {Key: "_id." + filter.By, Value: 1}}
+    }
+    pipeline := mongo.Pipeline{
+        {{Key: "$match", Value: filter.match(ctx)}},
+        {{
+            Key: "$group", Value: bson.M{
+                "_id":             key,
+                "distance_meters": bson.M{"$sum": "$distance_meters"},
+                "speeding_events": bson.M{"$sum": "$speeding_events"},
+                "harsh_events":    bson.M{"$sum": "$harsh_events"},
+                "idle_seconds":    bson.M{"$sum": "$idle_seconds"},
+            },
+        }},
+        {{Key: "$sort", Value: sort}},
+        {{Key: "$skip", Value: int64((filter.Page - 1) * filter.PageSize)}},
+        {{Key: "$limit", Value: int64(filter.PageSize)}},
+    }
+    cursor, err := repo.days.Aggregate(ctx, pipeline)
+    if err != nil {
+        return nil, classify(err)
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
+        }
+    }(cursor, ctx)
+    var totals []*SafetyTotals
+    for cursor.Next(ctx) {
+        var result struct {
+            ID struct {
+                TenantID  string             `bson:"tenant_id"`
+                VehicleID primitive.ObjectID `bson:"vehicle"`
+                DriverID  string             `bson:"driver"`
+                Day       time.Time          `bson:"day"`
+            } `bson:"_id"`
+            SafetyCounts `bson:",inline"`
+        }
+        if err := cursor.Decode(&result); err != nil {
+            return nil, err
+        }
+        totals = append(
+            totals,
+            &SafetyTotals{
+                TenantID:     result.ID.TenantID,
+                VehicleID:    result.ID.VehicleID,
+                DriverID:     result.ID.DriverID,
+                Day:          result.ID.Day,
+                SafetyCounts: result.SafetyCounts,
+            },
+        )
+    }
+    return totals, cursor.Err()
+}
+
+func (repo *MongoSafetyScoreRepository) FindSafetyState(
+    ctx context.Context,
+    vehicleID primitive.ObjectID,
+) (*SafetyState, error) {
+    var state SafetyState
+    err := repo.states.FindOne(ctx, scopeTenant(ctx, bson.M{"vehicle_id": vehicleID})).Decode(&state)
+    if errors.Is(err, mongo.ErrNoDocuments) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, classify(err)
+    }
+    return &state, nil
+}
+
+func (repo *MongoSafetyScoreRepository) SaveSafetyState(ctx context.Context, state *SafetyState) error {
+    state.TenantID = tenantOf(ctx)
+    _, err := repo.states.ReplaceOne(
+        ctx,
+        scopeTenant(ctx, bson.M{"vehicle_id": state.VehicleID}),
+        state,
+        options.Replace().SetUpsert(true),
+    )
+    return classify(err)
+}
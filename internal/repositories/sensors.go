@@ -0,0 +1,158 @@
+package repositories
+
+import (
+    "errors"
+    "fmt"
+    "regexp"
+    "strconv"
+    "strings"
+)
+
+var (
+    ErrInvalidSensors      = errors.New("invalid sensors")
+    ErrInvalidSensorFilter = errors.New("invalid sensor filter")
+)
+
+const (
+    // MaxSensors is how many sensor values a reading carries at most
+    MaxSensors = 20
+    // MaxSensorNameLength is the longest name of a sensor
+    MaxSensorNameLength = 32
+    // MaxSensorTextLength is the longest text value of a sensor, like a door status
+    MaxSensorTextLength = 64
+)
+
+// sensorName is the form of the names of the sensors, they are stored as field names so they are kept simple
+var sensorName = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// Sensors are the values of the additional sensors of a vehicle by sensor name, like temperature, door_open or
+// battery_voltage. A value is a number, a boolean or a short text.
+type Sensors map[string]any
+
+// Validate fails with ErrInvalidSensors when a name or a value isn't accepted
+func (s Sensors) Validate() error {
+    if len(s) > MaxSensors {
+        return fmt.Errorf("%w: at most %d sensors", ErrInvalidSensors, MaxSensors)
+    }
+    for name, value := range s {
+        if err := ValidateSensorName(name); err != nil {
+            return fmt.Errorf("%w: %w", ErrInvalidSensors, err)
+        }
+        switch value := value.(type) {
+        case float64, bool:
+        case string:
+            if len(value) > MaxSensorTextLength {
+                return fmt.Errorf(
+                    "%w: %s must be at most %d characters",
+                    ErrInvalidSensors,
+                    name,
+                    MaxSensorTextLength,
+                )
+            }
+        default:
+            return fmt.Errorf("%w: %s must be a number, a boolean or a text", ErrInvalidSensors, name)
+        }
+    }
+    return nil
+}
+
+// ValidateSensorName fails when the name isn't the name of a sensor
+func ValidateSensorName(name string) error {
+    if len(name) > MaxSensorNameLength || !sensorName.MatchString(name) {
+        return fmt.Errorf(
+            "sensor name %q must be at most %d lowercase letters, digits and underscores, starting with a letter",
+            name,
+            MaxSensorNameLength,
+        )
+    }
+    return nil
+}
+
+// SensorCondition is a condition of the sensor filter on the value of a sensor, either a numeric range with Min
+// and Max inclusive or an exact Value
+type SensorCondition struct {
+    Name  string
+    Min   *float64
+    Max   *float64
+    Value any
+}
+
+// ParseSensorCondition parses a condition of the sensor filter. name:min..max selects the readings with a value of
+// the sensor in the range, either bound can be left out, and name:value the readings with exactly that value, true
+// and false being booleans and numbers numbers.
+func ParseSensorCondition(condition string) (*SensorCondition, error) {
+    name, value, ok := strings.Cut(condition, ":")
+    if !ok || value == "" {
+        return nil, fmt.Errorf("%w: %q must be name:min..max or name:value", ErrInvalidSensorFilter, condition)
+    }
+    if err := ValidateSensorName(name); err != nil {
+        return nil, fmt.Errorf("%w: %w", ErrInvalidSensorFilter, err)
+    }
+    parsed := &SensorCondition{Name: name}
+    low, high, isRange := strings.Cut(value, "..")
+    if !isRange {
+        parsed.Value = sensorValue(value)
+        return parsed, nil
+    }
+    if low == "" && high == "" {
+        return nil, fmt.Errorf("%w: the range of %s has no bound", ErrInvalidSensorFilter, name)
+    }
+    var err error
+    if parsed.Min, err = sensorBound(name, low); err != nil {
+        return nil, err
+    }
+    if parsed.Max, err = sensorBound(name, high); err != nil {
+        return nil, err
+    }
+    if parsed.Min != nil && parsed.Max != nil && *parsed.Min > *parsed.Max {
+        return nil, fmt.Errorf("%w: the minimum of %s is above its maximum", ErrInvalidSensorFilter, name)
+    }
+    return parsed, nil
+}
+
+// sensorValue converts the exact value of a condition to the type it is stored with
+func sensorValue(value string) any {
+    switch value {
+    case "true":
+        return true
+    case "false":
+        return false
+    }
+    if number, err := strconv.ParseFloat(value, 64); err == nil {
+        return number
+    }
+    return value
+}
+
+// sensorBound parses a bound of a range, nil when it is left out
+func sensorBound(name, value string) (*float64, error) {
+    if value == "" {
+        return nil, nil
+    }
+    bound, err := strconv.ParseFloat(value, 64)
+    if err != nil {
+        return nil, fmt.Errorf("%w: the range of %s must be numbers", ErrInvalidSensorFilter, name)
+    }
+    return &bound, nil
+}
+
+// Matches reports whether the value of the sensor meets the condition, a range only matches numbers
+func (c *SensorCondition) Matches(sensors Sensors) bool {
+    value, ok := sensors[c.Name]
+    if !ok {
+        return false
+    }
+    if c.Value != nil {
+        return value == c.Value
+    }
+    number, ok := value.(float64)
+    if !ok {
+        return false
+    }
+    return (c.Min == nil || number >= *c.Min) && (c.Max == nil || number <= *c.Max)
+}
+
+// path returns the path of the sensor in the stored document
+func (c *SensorCondition) path() string {
+    return "sensors." + c.Name
+}
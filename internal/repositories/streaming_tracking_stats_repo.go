@@ -0,0 +1,251 @@
+package repositories
+
+import (
+    "cmp"
+    "context"
+    "math"
+    "slices"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// StreamingTrackingStatsRepository aggregates the tracking data of any TrackingRepository while streaming it, for the
+// storage backends without the aggregations of MongoTrackingStatsRepository. The results are the same, but every
+// reading of the time range is read by the service, so it suits the in-memory and PostgreSQL backends.
+type StreamingTrackingStatsRepository struct {
+    trackingRepo TrackingRepository
+}
+
+func NewStreamingTrackingStatsRepository(trackingRepo TrackingRepository) *StreamingTrackingStatsRepository {
+    return &StreamingTrackingStatsRepository{trackingRepo: trackingRepo}
+}
+
+// rfc3339 formats the time for the from and to of a TrackingFilter, zero is left out
+func rfc3339(t time.Time) string {
+    if t.IsZero() {
+        return ""
+    }
+    return t.Format(time.RFC3339Nano)
+}
+
+// vehicleAggregate is the running summary of the readings of a vehicle
+type vehicleAggregate struct {
+    stats          *VehicleStats
+    days           map[string]struct{}
+    fuelConditions map[string]int64
+    odometerMin    *float64
+    odometerMax    *float64
+}
+
+// FindVehicleStats aggregates the tracking data per vehicle like the facets of the Mongo pipeline, the readings are
+// streamed oldest first so the first and last of a vehicle give its mileage. Vehicles are ordered by id.
+func (repo *StreamingTrackingStatsRepository) FindVehicleStats(
+    ctx context.Context,
+    filter *TrackingStatsFilter,
+) ([]*VehicleStats, error) {
+    if err := filter.Build(); err != nil {
+        return nil, err
+    }
+    trackingFilter := &TrackingFilter{
+        VehicleID: filter.VehicleID,
+        From:      filter.From,
+        To:        filter.To,
+        Exclude:   filter.Exclude,
+    }
+    trackingFilter.RestrictVehicles(filter.vehicleIDs)
+
+    byVehicle := map[primitive.ObjectID]*vehicleAggregate{}
+    err := repo.trackingRepo.StreamTrackingData(
+        ctx, trackingFilter, func(record *TrackingRecord) error {
+            vehicle, ok := byVehicle[record.VehicleID]
+            if !ok {
+                vehicle = &vehicleAggregate{
+                    stats: &VehicleStats{
+                        VehicleID:    record.VehicleID,
+                        FirstSeen:    timestamp.New(record.CreatedAt),
+                        MileageStart: record.Mileage,
+                        Statuses:     map[string]int64{},
+                    },
+                    days:           map[string]struct{}{},
+                    fuelConditions: map[string]int64{},
+                }
+                byVehicle[record.VehicleID] = vehicle
+            }
+            vehicle.stats.Readings++
+            vehicle.stats.LastSeen = timestamp.New(record.CreatedAt)
+            vehicle.stats.MileageEnd = record.Mileage
+            vehicle.stats.Statuses[string(record.Status)]++
+            vehicle.fuelConditions[string(record.FuelCondition)]++
+            vehicle.days[record.CreatedAt.UTC().Format(time.DateOnly)] = struct{}{}
+            if odometer := record.OdometerMeters; odometer != nil {
+                if vehicle.odometerMin == nil || *odometer < *vehicle.odometerMin {
+                    vehicle.odometerMin = odometer
+                }
+                if vehicle.odometerMax == nil || *odometer > *vehicle.odometerMax {
+                    vehicle.odometerMax = odometer
+                }
+            }
+            return nil
+        },
+    )
+    if err != nil {
+        return nil, err
+    }
+
+    stats := make([]*VehicleStats, 0, len(byVehicle))
+    for _, vehicle := range byVehicle {
+        vehicle.stats.MileageDelta = vehicle.stats.MileageEnd - vehicle.stats.MileageStart
+        vehicle.stats.ActiveDays = len(vehicle.days)
+        vehicle.stats.FuelConditions = make(map[string]float64, len(vehicle.fuelConditions))
+        for fuelCondition, count := range vehicle.fuelConditions {
+            vehicle.stats.FuelConditions[fuelCondition] = float64(count) / float64(vehicle.stats.Readings)
+        }
+        if vehicle.odometerMin != nil && vehicle.odometerMax != nil {
+            vehicle.stats.DistanceMeters = *vehicle.odometerMax - *vehicle.odometerMin
+        }
+        stats = append(stats, vehicle.stats)
+    }
+    slices.SortFunc(
+        stats, func(a, b *VehicleStats) int {
+            return cmp.Compare(a.VehicleID.Hex(), b.VehicleID.Hex())
+        },
+    )
+    return stats, nil
+}
+
+// zoneKey is a zone of the grid during an hour
+type zoneKey struct {
+    latIndex int64
+    lngIndex int64
+    hour     time.Time
+}
+
+// tileKey is a web mercator tile of a heatmap
+type tileKey struct {
+    x int64
+    y int64
+}
+
+// vehicleCount counts the readings and the distinct vehicles of a zone or a tile
+type vehicleCount struct {
+    readings int64
+    vehicles map[primitive.ObjectID]struct{}
+}
+
+func (c *vehicleCount) add(vehicleID primitive.ObjectID) {
+    if c.vehicles == nil {
+        c.vehicles = map[primitive.ObjectID]struct{}{}
+    }
+    c.readings++
+    c.vehicles[vehicleID] = struct{}{}
+}
+
+func (repo *StreamingTrackingStatsRepository) FindZoneCounts(
+    ctx context.Context,
+    from, to time.Time,
+    zoneDegrees float64,
+) ([]*ZoneCount, error) {
+    zones := map[zoneKey]*vehicleCount{}
+    err := repo.trackingRepo.StreamTrackingData(
+        ctx, &TrackingFilter{From: rfc3339(from), To: rfc3339(to)}, func(record *TrackingRecord) error {
+            if record.Lat == nil || record.Lng == nil {
+                return nil
+            }
+            key := zoneKey{
+                latIndex: int64(math.Floor(*record.Lat / zoneDegrees)),
+                lngIndex: int64(math.Floor(*record.Lng / zoneDegrees)),
+                hour:     record.CreatedAt.UTC().Truncate(time.Hour),
+            }
+            zone, ok := zones[key]
+            if !ok {
+                zone = &vehicleCount{}
+                zones[key] = zone
+            }
+            zone.add(record.VehicleID)
+            return nil
+        },
+    )
+    if err != nil {
+        return nil, err
+    }
+
+    var counts []*ZoneCount
+    for key, zone := range zones {
+        counts = append(
+            counts, &ZoneCount{
+                LatIndex: key.latIndex,
+                LngIndex: key.lngIndex,
+                Hour:     key.hour,
+                Vehicles: int64(len(zone.vehicles)),
+                Readings: zone.readings,
+            },
+        )
+    }
+    slices.SortFunc(
+        counts, func(a, b *ZoneCount) int {
+            return cmp.Or(
+                a.Hour.Compare(b.Hour),
+                cmp.Compare(a.LatIndex, b.LatIndex),
+                cmp.Compare(a.LngIndex, b.LngIndex),
+            )
+        },
+    )
+    return counts, nil
+}
+
+// FindHeatmapTiles counts the readings with coordinates in the bbox per tile of the zoom, the busiest tiles first
+func (repo *StreamingTrackingStatsRepository) FindHeatmapTiles(
+    ctx context.Context,
+    filter *TrackingHeatmapFilter,
+) ([]*HeatmapTile, error) {
+    if err := filter.Build(); err != nil {
+        return nil, err
+    }
+    trackingFilter := &TrackingFilter{
+        VehicleID: filter.VehicleID,
+        From:      filter.From,
+        To:        filter.To,
+        Exclude:   filter.Exclude,
+    }
+    trackingFilter.RestrictVehicles(filter.vehicleIDs)
+
+    tiles := map[tileKey]*vehicleCount{}
+    err := repo.trackingRepo.StreamTrackingData(
+        ctx, trackingFilter, func(record *TrackingRecord) error {
+            if record.Lat == nil || record.Lng == nil {
+                return nil
+            }
+            lat, lng := *record.Lat, *record.Lng
+            if lng < filter.minLng || lng > filter.maxLng || lat < filter.minLat || lat > filter.maxLat {
+                return nil
+            }
+            key := tileKey{x: TileX(lng, filter.zoom), y: TileY(lat, filter.zoom)}
+            tile, ok := tiles[key]
+            if !ok {
+                tile = &vehicleCount{}
+                tiles[key] = tile
+            }
+            tile.add(record.VehicleID)
+            return nil
+        },
+    )
+    if err != nil {
+        return nil, err
+    }
+
+    var heatmap []*HeatmapTile
+    for key, tile := range tiles {
+        heatmap = append(
+            heatmap,
+            newHeatmapTile(filter.zoom, key.x, key.y, tile.readings, int64(len(tile.vehicles))),
+        )
+    }
+    slices.SortFunc(
+        heatmap, func(a, b *HeatmapTile) int {
+            return cmp.Or(cmp.Compare(b.Readings, a.Readings), cmp.Compare(a.Y, b.Y), cmp.Compare(a.X, b.X))
+        },
+    )
+    return heatmap, nil
+}
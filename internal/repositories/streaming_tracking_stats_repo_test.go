@@ -0,0 +1,158 @@
+package repositories
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func statsRecord(vehicleID primitive.ObjectID, createdAt time.Time, mileage, lat, lng float64) *TrackingRecord {
+    record := NewTrackingRecord(
+        &models.TrackingData{
+            VehicleID:     vehicleID,
+            Location:      "Yangon",
+            Mileage:       mileage,
+            Status:        models.VehicleStatusActive,
+            FuelCondition: models.FuelConditionFull,
+            CreatedAt:     createdAt,
+        },
+    )
+    record.Lat, record.Lng = &lat, &lng
+    odometer := mileage * 1000
+    record.OdometerMeters = &odometer
+    return record
+}
+
+func newStreamingStatsRepository(t *testing.T, records ...*TrackingRecord) *StreamingTrackingStatsRepository {
+    t.Helper()
+    trackingRepo := NewMemoryTrackingRepository()
+    for _, record := range records {
+        if err := trackingRepo.CreateTrackingData(context.Background(), record); err != nil {
+            t.Fatal(err)
+        }
+    }
+    return NewStreamingTrackingStatsRepository(trackingRepo)
+}
+
+func TestStreamingTrackingStatsRepository_FindVehicleStats(t *testing.T) {
+    vehicleID, other := primitive.NewObjectID(), primitive.NewObjectID()
+    start := time.Date(2024, 1, 1, 22, 0, 0, 0, time.UTC)
+    low := statsRecord(vehicleID, start.Add(2*time.Hour), 130, 16.8, 96.1)
+    low.FuelCondition = models.FuelConditionLow
+    low.Status = models.VehicleStatusRepair
+    repo := newStreamingStatsRepository(
+        t,
+        low,
+        statsRecord(vehicleID, start, 100, 16.8, 96.1),
+        statsRecord(vehicleID, start.Add(time.Hour), 110, 16.8, 96.1),
+        statsRecord(vehicleID, start.Add(-48*time.Hour), 50, 16.8, 96.1),
+        statsRecord(other, start, 500, 16.8, 96.1),
+    )
+
+    stats, err := repo.FindVehicleStats(
+        context.Background(),
+        &TrackingStatsFilter{VehicleID: vehicleID.Hex(), From: start.Format(time.RFC3339)},
+    )
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(stats) != 1 {
+        t.Fatalf("Should find the stats of the vehicle only, got %d", len(stats))
+    }
+    vehicle := stats[0]
+    if vehicle.Readings != 3 || vehicle.MileageStart != 100 || vehicle.MileageEnd != 130 || vehicle.MileageDelta != 30 {
+        t.Errorf("Should aggregate the readings of the time range oldest first, got %+v", vehicle)
+    }
+    if vehicle.DistanceMeters != 30000 || vehicle.ActiveDays != 2 {
+        t.Errorf("Should measure the odometer and the UTC days, got %v m over %d days", vehicle.DistanceMeters,
+            vehicle.ActiveDays)
+    }
+    if vehicle.Statuses[string(models.VehicleStatusActive)] != 2 ||
+        vehicle.Statuses[string(models.VehicleStatusRepair)] != 1 {
+        t.Errorf("Should count the statuses, got %v", vehicle.Statuses)
+    }
+    if share := vehicle.FuelConditions[string(models.FuelConditionLow)]; share < 0.33 || share > 0.34 {
+        t.Errorf("Should share the fuel conditions, got %v", vehicle.FuelConditions)
+    }
+
+    stats, err = repo.FindVehicleStats(context.Background(), &TrackingStatsFilter{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(stats) != 2 || stats[0].VehicleID.Hex() > stats[1].VehicleID.Hex() {
+        t.Errorf("Should find the stats of every vehicle ordered by id, got %d", len(stats))
+    }
+}
+
+func TestStreamingTrackingStatsRepository_FindZoneCounts(t *testing.T) {
+    vehicleID, other := primitive.NewObjectID(), primitive.NewObjectID()
+    start := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+    noCoordinates := statsRecord(other, start, 100, 0, 0)
+    noCoordinates.Lat, noCoordinates.Lng = nil, nil
+    repo := newStreamingStatsRepository(
+        t,
+        statsRecord(vehicleID, start.Add(10*time.Minute), 100, 16.81, 96.15),
+        statsRecord(vehicleID, start.Add(20*time.Minute), 101, 16.82, 96.16),
+        statsRecord(other, start.Add(30*time.Minute), 200, 16.83, 96.17),
+        statsRecord(other, start.Add(90*time.Minute), 201, 16.83, 96.17),
+        statsRecord(other, start.Add(3*time.Hour), 202, 16.83, 96.17),
+        noCoordinates,
+    )
+
+    counts, err := repo.FindZoneCounts(context.Background(), start, start.Add(2*time.Hour), 0.1)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(counts) != 2 {
+        t.Fatalf("Should count the zones per hour of the time range, got %d", len(counts))
+    }
+    if counts[0].Hour != start || counts[0].LatIndex != 168 || counts[0].LngIndex != 961 ||
+        counts[0].Readings != 3 || counts[0].Vehicles != 2 {
+        t.Errorf("Should count the readings and distinct vehicles of the zone, got %+v", counts[0])
+    }
+    if counts[1].Hour != start.Add(time.Hour) || counts[1].Readings != 1 {
+        t.Errorf("Should count the next hour apart, got %+v", counts[1])
+    }
+}
+
+func TestStreamingTrackingStatsRepository_FindHeatmapTiles(t *testing.T) {
+    vehicleID, other := primitive.NewObjectID(), primitive.NewObjectID()
+    start := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+    repo := newStreamingStatsRepository(
+        t,
+        statsRecord(vehicleID, start, 100, 16.8, 96.1),
+        statsRecord(other, start, 200, 16.8, 96.1),
+        statsRecord(other, start.Add(time.Hour), 201, 16.8, 96.1),
+        statsRecord(vehicleID, start.Add(time.Hour), 101, 26.5, 99.0),
+        statsRecord(vehicleID, start.Add(2*time.Hour), 102, 1.3, 103.8),
+    )
+
+    tiles, err := repo.FindHeatmapTiles(context.Background(), &TrackingHeatmapFilter{BBox: "92,9,102,29", Zoom: "6"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(tiles) != 2 {
+        t.Fatalf("Should count the tiles of the readings in the bbox, got %d", len(tiles))
+    }
+    if tiles[0].X != TileX(96.1, 6) || tiles[0].Y != TileY(16.8, 6) || tiles[0].Readings != 3 ||
+        tiles[0].Vehicles != 2 {
+        t.Errorf("Should put the busiest tile first, got %+v", tiles[0])
+    }
+    if tiles[1].Readings != 1 || tiles[1].Vehicles != 1 {
+        t.Errorf("Should count the other tile, got %+v", tiles[1])
+    }
+
+    tiles, err = repo.FindHeatmapTiles(
+        context.Background(),
+        &TrackingHeatmapFilter{BBox: "92,9,102,29", Zoom: "6", VehicleID: other.Hex()},
+    )
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(tiles) != 1 || tiles[0].Readings != 2 || tiles[0].Vehicles != 1 {
+        t.Errorf("Should count the readings of the selected vehicle only, got %d tiles", len(tiles))
+    }
+}
@@ -0,0 +1,22 @@
+package repositories
+
+import (
+    "context"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+    "go.mongodb.org/mongo-driver/bson"
+)
+
+// scopeTenant limits a match to the tenant of the context, contexts without a tenant aren't limited
+func scopeTenant(ctx context.Context, match bson.M) bson.M {
+    if id, ok := tenant.FromContext(ctx); ok {
+        match["tenant_id"] = id
+    }
+    return match
+}
+
+// tenantOf returns the tenant of the context, empty without one
+func tenantOf(ctx context.Context) string {
+    id, _ := tenant.FromContext(ctx)
+    return id
+}
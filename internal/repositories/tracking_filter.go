@@ -0,0 +1,553 @@
+package repositories
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "slices"
+    "strings"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+    ErrInvalidID        = errors.New("invalid id")
+    ErrInvalidTimeRange = errors.New("invalid time range, from and to must be RFC3339 timestamps and from must be before to")
+    ErrInvalidSort      = errors.New("invalid sort")
+    ErrTooManyValues    = errors.New("too many values")
+    ErrInvalidField     = errors.New("invalid field")
+    ErrInvalidMileage   = errors.New("invalid mileage range, mileage_min must not be above mileage_max")
+    ErrInvalidDriverID  = errors.New("invalid driver id")
+    ErrInvalidTimeAxis  = errors.New("invalid time axis, it must be created_at, device_time or received_time")
+    ErrInvalidAfter     = errors.New("invalid after, it must be an ObjectID and the tracking data sorted by id")
+)
+
+// MaxFilterValues is how many values a multi-value filter like vehicle_id accepts, so a selection stays a
+// reasonable $in
+const MaxFilterValues = 100
+
+// MaxDriverIDLength is the longest driver id, the ids are issued by the system managing the drivers
+const MaxDriverIDLength = 64
+
+// sortableFields are the fields tracking data can be sorted by, mapped to their stored name. Other fields are
+// rejected, so a typo doesn't silently sort on a missing field without an index.
+var sortableFields = map[string]string{
+    "id":              "_id",
+    "_id":             "_id",
+    "public_id":       "public_id",
+    "vehicle_id":      "vehicle_id",
+    "created_at":      "created_at",
+    "updated_at":      "updated_at",
+    "device_time":     "device_time",
+    "received_time":   "received_time",
+    "location":        "location",
+    "mileage":         "mileage",
+    "status":          "status",
+    "fuel_condition":  "fuel_condition",
+    "distance_meters": "distance_meters",
+    "odometer_meters": "odometer_meters",
+}
+
+// timeAxes are the timestamps from and to can apply to, created_at by default
+var timeAxes = []string{"created_at", "device_time", "received_time"}
+
+// alwaysPresentFields are set on every tracking document, so sorting on them doesn't need null handling
+// and can use indexes
+var alwaysPresentFields = map[string]bool{
+    "_id":        true,
+    "vehicle_id": true,
+    "created_at": true,
+}
+
+type TrackingFilter struct {
+    ID            string               `json:"id" doc:"The ObjectID or the ULID public id of a tracking data"`
+    Page          int                  `json:"page" doc:"Page number, starting at 1"`
+    PageSize      int                  `json:"limit" doc:"Page size, 10 by default and at most 100"`
+    SortField     string               `json:"sort_by" doc:"Comma separated fields, prefixed with - for descending or + for ascending"`
+    SortOrder     string               `json:"sort_order" doc:"asc or desc, the order of the sort_by fields without a prefix"`
+    VehicleID     string               `json:"vehicle_id" doc:"Comma separated vehicle ids"`
+    DriverID      string               `json:"driver_id" doc:"Comma separated driver ids"`
+    Location      string               `json:"location"`
+    Mileage       *float64             `json:"mileage" doc:"Deprecated, the same as mileage_min"`
+    MileageMin    *float64             `json:"mileage_min" doc:"Lowest mileage, inclusive"`
+    MileageMax    *float64             `json:"mileage_max" doc:"Highest mileage, inclusive"`
+    Status        models.VehicleStatus `json:"status" doc:"Comma separated statuses, e.g. active,repair"`
+    FuelCondition models.FuelCondition `json:"fuel_condition" doc:"Comma separated fuel conditions"`
+    From          string               `json:"from" doc:"RFC3339 start of created_at, inclusive"`
+    To            string               `json:"to" doc:"RFC3339 end of created_at, exclusive"`
+    TimeAxis      string               `json:"time_axis" doc:"created_at, device_time or received_time, the timestamp from and to apply to"`
+    Exclude       string               `json:"exclude" doc:"Comma separated backfill, anomalies, suspect and skewed to leave out, or none to include everything"`
+    Sensor        string               `json:"sensor" doc:"Comma separated sensor conditions, name:min..max or name:value, e.g. temperature:-20..-15"`
+    Fields        string               `json:"fields" doc:"Comma separated fields to return, e.g. vehicle_id,lat,lng,created_at, all by default"`
+    After         string               `json:"after" doc:"ObjectID of the tracking data the results start after, when sorted by id"`
+
+    id             primitive.ObjectID
+    publicID       string
+    vehicleID      primitive.ObjectID
+    selected       []primitive.ObjectID
+    vehicleIDs     []primitive.ObjectID
+    driverIDs      []string
+    statuses       []models.VehicleStatus
+    fuelConditions []models.FuelCondition
+    from           time.Time
+    to             time.Time
+    sortKeys       []SortKey
+    excluded       []string
+    sensors        []*SensorCondition
+    fields         []string
+    after          primitive.ObjectID
+}
+
+// SortKey is a single field of a multi-key sort, Order is 1 for ascending and -1 for descending
+type SortKey struct {
+    Field string
+    Order int
+}
+
+// VehicleObjID returns the vehicle of the filter, zero when it selects no vehicle or several
+func (t *TrackingFilter) VehicleObjID() primitive.ObjectID {
+    return t.vehicleID
+}
+
+// VehicleObjIDs returns the vehicles selected by vehicle_id, nil when it isn't set
+func (t *TrackingFilter) VehicleObjIDs() []primitive.ObjectID {
+    return t.selected
+}
+
+// RestrictVehicles limits the tracking data to the given vehicles, on top of the vehicle_id filter
+func (t *TrackingFilter) RestrictVehicles(vehicleIDs []primitive.ObjectID) {
+    t.vehicleIDs = vehicleIDs
+}
+
+// RestrictedVehicles returns the vehicles the tracking data is limited to, nil when it isn't restricted
+func (t *TrackingFilter) RestrictedVehicles() []primitive.ObjectID {
+    return t.vehicleIDs
+}
+
+// TimeRange returns the parsed from and to of the filter, zero when not set
+func (t *TrackingFilter) TimeRange() (time.Time, time.Time) {
+    return t.from, t.to
+}
+
+// TimeField returns the stored timestamp the time range applies to
+func (t *TrackingFilter) TimeField() string {
+    if t.TimeAxis == "" {
+        return "created_at"
+    }
+    return t.TimeAxis
+}
+
+// startsAfter reports whether the id comes after the after id in the order of the ids, every id does without one
+func (t *TrackingFilter) startsAfter(id primitive.ObjectID) bool {
+    return t.after.IsZero() || bytes.Compare(id[:], t.after[:])*t.sortKeys[0].Order > 0
+}
+
+// createdRange returns the time range when it applies to created_at, zero otherwise. The tracking data is
+// partitioned by created_at, so the ranges of the other axes read every partition.
+func (t *TrackingFilter) createdRange() (time.Time, time.Time) {
+    if t.TimeField() != "created_at" {
+        return time.Time{}, time.Time{}
+    }
+    return t.from, t.to
+}
+
+// Projection returns the fields the tracking data is projected to, nil for all of them
+func (t *TrackingFilter) Projection() []string {
+    return t.fields
+}
+
+// SortKeys returns the parsed sort keys, always ending with _id so the order is stable across pages
+func (t *TrackingFilter) SortKeys() []SortKey {
+    return t.sortKeys
+}
+
+func (t *TrackingFilter) Build() error {
+    if t.Page == 0 {
+        t.Page = 1
+    }
+    t.PageSize = pageSize(t.PageSize)
+    if t.SortField == "" {
+        t.SortField = "created_at"
+    }
+    if t.SortOrder == "" {
+        t.SortOrder = "asc"
+    }
+    if err := t.buildSortKeys(); err != nil {
+        return err
+    }
+    t.after = primitive.NilObjectID
+    if t.After != "" {
+        after, err := primitive.ObjectIDFromHex(t.After)
+        if err != nil || t.sortKeys[0].Field != "_id" {
+            return ErrInvalidAfter
+        }
+        t.after = after
+    }
+    if t.ID != "" {
+        id, publicID, err := parseID(t.ID)
+        if err != nil {
+            return err
+        }
+        t.id, t.publicID = id, publicID
+    }
+    t.selected, t.vehicleID = nil, primitive.NilObjectID
+    vehicleIDs, err := SplitValues("vehicle_id", t.VehicleID)
+    if err != nil {
+        return err
+    }
+    for _, vehicleID := range vehicleIDs {
+        id, err := primitive.ObjectIDFromHex(vehicleID)
+        if err != nil {
+            return ErrInvalidID
+        }
+        t.selected = append(t.selected, id)
+    }
+    if len(t.selected) == 1 {
+        t.vehicleID = t.selected[0]
+    }
+    if t.driverIDs, err = SplitValues("driver_id", t.DriverID); err != nil {
+        return err
+    }
+    for _, driverID := range t.driverIDs {
+        if len(driverID) > MaxDriverIDLength {
+            return fmt.Errorf("%w: at most %d characters", ErrInvalidDriverID, MaxDriverIDLength)
+        }
+    }
+    if t.From != "" {
+        from, err := time.Parse(time.RFC3339, t.From)
+        if err != nil {
+            return ErrInvalidTimeRange
+        }
+        t.from = from
+    }
+    if t.To != "" {
+        to, err := time.Parse(time.RFC3339, t.To)
+        if err != nil {
+            return ErrInvalidTimeRange
+        }
+        t.to = to
+    }
+    if !t.from.IsZero() && !t.to.IsZero() && !t.from.Before(t.to) {
+        return ErrInvalidTimeRange
+    }
+    if t.TimeAxis != "" && !slices.Contains(timeAxes, t.TimeAxis) {
+        return ErrInvalidTimeAxis
+    }
+    if t.MileageMin == nil {
+        t.MileageMin = t.Mileage
+    }
+    if t.MileageMin != nil && t.MileageMax != nil && *t.MileageMin > *t.MileageMax {
+        return ErrInvalidMileage
+    }
+    statuses, err := SplitValues("status", string(t.Status))
+    if err != nil {
+        return err
+    }
+    t.statuses = t.statuses[:0]
+    for _, value := range statuses {
+        status := models.VehicleStatus(value)
+        if err := status.Valid(); err != nil {
+            return err
+        }
+        t.statuses = append(t.statuses, status)
+    }
+    fuelConditions, err := SplitValues("fuel_condition", string(t.FuelCondition))
+    if err != nil {
+        return err
+    }
+    t.fuelConditions = t.fuelConditions[:0]
+    for _, value := range fuelConditions {
+        fuelCondition := models.FuelCondition(value)
+        if err := fuelCondition.Valid(); err != nil {
+            return err
+        }
+        t.fuelConditions = append(t.fuelConditions, fuelCondition)
+    }
+    excluded, err := excludedFlags(t.Exclude)
+    if err != nil {
+        return err
+    }
+    t.excluded = excluded
+    if err := t.buildSensors(); err != nil {
+        return err
+    }
+    fields, err := SplitValues("fields", t.Fields)
+    if err != nil {
+        return err
+    }
+    for _, field := range fields {
+        if err := ValidateField(field); err != nil {
+            return err
+        }
+    }
+    t.fields = fields
+    return nil
+}
+
+// buildSensors parses the sensor conditions, a sensor is only filtered once
+func (t *TrackingFilter) buildSensors() error {
+    conditions, err := SplitValues("sensor", t.Sensor)
+    if err != nil {
+        return err
+    }
+    t.sensors = t.sensors[:0]
+    seen := map[string]bool{}
+    for _, value := range conditions {
+        condition, err := ParseSensorCondition(value)
+        if err != nil {
+            return err
+        }
+        if seen[condition.Name] {
+            return fmt.Errorf("%w: %s is filtered more than once", ErrInvalidSensorFilter, condition.Name)
+        }
+        seen[condition.Name] = true
+        t.sensors = append(t.sensors, condition)
+    }
+    return nil
+}
+
+// SplitValues splits a comma separated filter value, dropping blanks and duplicates. It fails with
+// ErrTooManyValues beyond MaxFilterValues values.
+func SplitValues(name string, value string) ([]string, error) {
+    if value == "" {
+        return nil, nil
+    }
+    var values []string
+    for _, v := range strings.Split(value, ",") {
+        v = strings.TrimSpace(v)
+        if v != "" && !slices.Contains(values, v) {
+            values = append(values, v)
+        }
+    }
+    if len(values) > MaxFilterValues {
+        return nil, fmt.Errorf("%w: %s accepts at most %d values", ErrTooManyValues, name, MaxFilterValues)
+    }
+    return values, nil
+}
+
+// buildSortKeys parses sort_by as a comma separated list of fields, a field prefixed with "-" is sorted
+// descending and one prefixed with "+" ascending, other fields use sort_order.
+func (t *TrackingFilter) buildSortKeys() error {
+    defaultOrder := 1
+    switch t.SortOrder {
+    case "asc":
+    case "desc":
+        defaultOrder = -1
+    default:
+        return fmt.Errorf("%w: sort_order must be asc or desc", ErrInvalidSort)
+    }
+
+    t.sortKeys = t.sortKeys[:0]
+    seen := map[string]bool{}
+    for _, field := range strings.Split(t.SortField, ",") {
+        field = strings.TrimSpace(field)
+        order := defaultOrder
+        if strings.HasPrefix(field, "-") {
+            field, order = field[1:], -1
+        } else if strings.HasPrefix(field, "+") {
+            field, order = field[1:], 1
+        }
+        if field == "" {
+            return fmt.Errorf("%w: empty sort field", ErrInvalidSort)
+        }
+        stored, ok := sortableFields[field]
+        if !ok {
+            return fmt.Errorf(
+                "%w: unknown sort field %q, the sortable fields are %s",
+                ErrInvalidSort,
+                field,
+                strings.Join(SortableFields(), ", "),
+            )
+        }
+        field = stored
+        if seen[field] {
+            return fmt.Errorf("%w: %s is sorted more than once", ErrInvalidSort, field)
+        }
+        seen[field] = true
+        t.sortKeys = append(t.sortKeys, SortKey{Field: field, Order: order})
+    }
+    if !seen["_id"] {
+        t.sortKeys = append(t.sortKeys, SortKey{Field: "_id", Order: 1})
+    }
+    return nil
+}
+
+// SortableFields returns the fields sort_by accepts in alphabetical order
+func SortableFields() []string {
+    fields := make([]string, 0, len(sortableFields))
+    for field := range sortableFields {
+        fields = append(fields, field)
+    }
+    slices.Sort(fields)
+    return fields
+}
+
+// trackingQuery is a filter translated to Mongo, run as an aggregation so records missing
+// a sort field can be ordered last regardless of the sort direction
+type trackingQuery struct {
+    match    bson.M
+    sortKeys []SortKey
+    // projection is nil when the tracking data isn't projected, only the queries of pages project it
+    projection bson.M
+}
+
+// notDeleted excludes the soft deleted tracking data from a match
+func notDeleted(match bson.M) bson.M {
+    match["deleted_at"] = bson.M{"$exists": false}
+    return match
+}
+
+// buildQuery translates the filter into a Mongo query, pagination is left to the caller
+func buildQuery(filter *TrackingFilter) (*trackingQuery, error) {
+    query := &trackingQuery{match: notDeleted(bson.M{})}
+    if filter == nil {
+        return query, nil
+    }
+    if err := filter.Build(); err != nil {
+        return nil, err
+    }
+    excludeFlagged(query.match, filter.excluded)
+    if filter.publicID != "" {
+        query.match["public_id"] = filter.publicID
+    } else if filter.ID != "" {
+        query.match["_id"] = filter.id
+    }
+    if !filter.after.IsZero() {
+        after := bson.M{"$gt": filter.after}
+        if filter.sortKeys[0].Order < 0 {
+            after = bson.M{"$lt": filter.after}
+        }
+        if id, ok := query.match["_id"]; ok {
+            after["$eq"] = id
+        }
+        query.match["_id"] = after
+    }
+    if filter.selected != nil {
+        query.match["vehicle_id"] = matchAny(filter.selected)
+    }
+    if filter.vehicleIDs != nil {
+        vehicleIDs := filter.vehicleIDs
+        if filter.selected != nil {
+            vehicleIDs = slices.DeleteFunc(
+                slices.Clone(vehicleIDs), func(id primitive.ObjectID) bool {
+                    return !slices.Contains(filter.selected, id)
+                },
+            )
+        }
+        query.match["vehicle_id"] = bson.M{"$in": vehicleIDs}
+    }
+    if len(filter.driverIDs) > 0 {
+        query.match["driver_id"] = matchAny(filter.driverIDs)
+    }
+    if len(filter.fields) > 0 {
+        // the id is returned by default, it is only kept when requested
+        query.projection = bson.M{"_id": 0}
+        for _, field := range filter.fields {
+            query.projection[fieldPath(field)] = 1
+        }
+    }
+    if filter.Location != "" {
+        query.match["location"] = bson.M{"$regex": fmt.Sprintf("^%s", filter.Location), "$options": "i"}
+    }
+    if filter.MileageMin != nil || filter.MileageMax != nil {
+        mileage := bson.M{}
+        if filter.MileageMin != nil {
+            mileage["$gte"] = *filter.MileageMin
+        }
+        if filter.MileageMax != nil {
+            mileage["$lte"] = *filter.MileageMax
+        }
+        query.match["mileage"] = mileage
+    }
+    for _, condition := range filter.sensors {
+        if condition.Value != nil {
+            query.match[condition.path()] = condition.Value
+            continue
+        }
+        // a range on a number doesn't match the text values of the sensor
+        value := bson.M{}
+        if condition.Min != nil {
+            value["$gte"] = *condition.Min
+        }
+        if condition.Max != nil {
+            value["$lte"] = *condition.Max
+        }
+        query.match[condition.path()] = value
+    }
+    if len(filter.statuses) > 0 {
+        query.match["status"] = matchAny(filter.statuses)
+    }
+    if len(filter.fuelConditions) > 0 {
+        query.match["fuel_condition"] = matchAny(filter.fuelConditions)
+    }
+    if from, to := filter.TimeRange(); !from.IsZero() || !to.IsZero() {
+        timeRange := bson.M{}
+        if !from.IsZero() {
+            timeRange["$gte"] = from
+        }
+        if !to.IsZero() {
+            timeRange["$lt"] = to
+        }
+        query.match[filter.TimeField()] = timeRange
+    }
+    query.sortKeys = filter.SortKeys()
+    return query, nil
+}
+
+// matchAny matches a single value by equality and several with $in
+func matchAny[T any](values []T) any {
+    if len(values) == 1 {
+        return values[0]
+    }
+    return bson.M{"$in": values}
+}
+
+// pipeline builds the aggregation pipeline, a zero limit means no pagination.
+// Mongo orders null and missing values first in ascending sorts, so for every sort field that can be missing
+// a flag is computed and sorted on first, which puts the missing values last in both directions.
+func (q *trackingQuery) pipeline(skip, limit int64) mongo.Pipeline {
+    pipeline := mongo.Pipeline{{{Key: "$match", Value: q.match}}}
+
+    missingFlags := bson.D{}
+    sort := bson.D{}
+    for i, key := range q.sortKeys {
+        if !alwaysPresentFields[key.Field] {
+            flag := fmt.Sprintf("__missing_%d", i)
+            missingFlags = append(
+                missingFlags, bson.E{
+                    Key: flag,
+                    Value: bson.M{
+                        "$cond": bson.A{bson.M{"$eq": bson.A{bson.M{"$ifNull": bson.A{"$" + key.Field, nil}}, nil}}, 1, 0},
+                    },
+                },
+            )
+            sort = append(sort, bson.E{Key: flag, Value: 1})
+        }
+        sort = append(sort, bson.E{Key: key.Field, Value: key.Order})
+    }
+
+    if len(missingFlags) > 0 {
+        pipeline = append(pipeline, bson.D{{Key: "$addFields", Value: missingFlags}})
+    }
+    if len(sort) > 0 {
+        pipeline = append(pipeline, bson.D{{Key: "$sort", Value: sort}})
+    }
+    if skip > 0 {
+        pipeline = append(pipeline, bson.D{{Key: "$skip", Value: skip}})
+    }
+    if limit > 0 {
+        pipeline = append(pipeline, bson.D{{Key: "$limit", Value: limit}})
+    }
+    if len(missingFlags) > 0 {
+        unset := bson.A{}
+        for _, flag := range missingFlags {
+            unset = append(unset, flag.Key)
+        }
+        pipeline = append(pipeline, bson.D{{Key: "$unset", Value: unset}})
+    }
+    return pipeline
+}
@@ -0,0 +1,288 @@
+package repositories
+
+import (
+    "errors"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/ulid"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestTrackingFilter_SortKeys(t *testing.T) {
+    filter := &TrackingFilter{SortField: "location, -mileage", SortOrder: "desc"}
+    if err := filter.Build(); err != nil {
+        t.Fatal(err)
+    }
+
+    expected := []SortKey{
+        {Field: "location", Order: -1},
+        {Field: "mileage", Order: -1},
+        {Field: "_id", Order: 1},
+    }
+    keys := filter.SortKeys()
+    if len(keys) != len(expected) {
+        t.Fatalf("Should have %d sort keys, got %d", len(expected), len(keys))
+    }
+    for i := range expected {
+        if keys[i] != expected[i] {
+            t.Fatalf("Sort key %d should be %v, got %v", i, expected[i], keys[i])
+        }
+    }
+
+    filter = &TrackingFilter{SortField: "location,location"}
+    if err := filter.Build(); err == nil {
+        t.Fatal("Should reject a field sorted more than once")
+    }
+
+    filter = &TrackingFilter{SortField: "-milage"}
+    if err := filter.Build(); !errors.Is(err, ErrInvalidSort) || !strings.Contains(err.Error(), `"milage"`) {
+        t.Fatalf("Should reject an unknown sort field, got %v", err)
+    }
+
+    filter = &TrackingFilter{SortField: "-id"}
+    if err := filter.Build(); err != nil {
+        t.Fatal(err)
+    }
+    if keys := filter.SortKeys(); len(keys) != 1 || keys[0] != (SortKey{Field: "_id", Order: -1}) {
+        t.Fatalf("Should sort id by its stored name without a second _id key, got %v", keys)
+    }
+
+    filter = &TrackingFilter{SortOrder: "up"}
+    if err := filter.Build(); err == nil {
+        t.Fatal("Should reject an invalid sort order")
+    }
+}
+
+func TestTrackingQuery_NullsLast(t *testing.T) {
+    query, err := buildQuery(&TrackingFilter{SortField: "location"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    // $match, $addFields, $sort, $unset
+    if len(query.pipeline(0, 0)) != 4 {
+        t.Fatal("Sorting on a field that can be missing should flag missing values")
+    }
+
+    query, err = buildQuery(&TrackingFilter{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    // $match, $sort, $skip, $limit
+    if len(query.pipeline(10, 10)) != 4 {
+        t.Fatal("Sorting on created_at should not flag missing values")
+    }
+}
+
+func TestTrackingQuery_Exclude(t *testing.T) {
+    query, err := buildQuery(&TrackingFilter{Exclude: "backfill, anomalies"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    expected := bson.M{"$nin": []string{FlagBackfill, FlagAnomaly}}
+    if !reflect.DeepEqual(query.match["flags"], expected) {
+        t.Fatalf("Should exclude the flagged tracking data, got %v", query.match["flags"])
+    }
+
+    if err = SetDefaultExclude("anomalies"); err != nil {
+        t.Fatal(err)
+    }
+    defer func() {
+        _ = SetDefaultExclude("")
+    }()
+    query, err = buildQuery(&TrackingFilter{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !reflect.DeepEqual(query.match["flags"], bson.M{"$nin": []string{FlagAnomaly}}) {
+        t.Fatalf("Should apply the default exclude, got %v", query.match["flags"])
+    }
+    query, err = buildQuery(&TrackingFilter{Exclude: ExcludeNone})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, ok := query.match["flags"]; ok {
+        t.Fatal("Should include everything with exclude=none")
+    }
+
+    if _, err = buildQuery(&TrackingFilter{Exclude: "corrected"}); !errors.Is(err, ErrInvalidExclude) {
+        t.Fatalf("Should reject an unknown exclude, got %v", err)
+    }
+}
+
+func TestTrackingQuery_ID(t *testing.T) {
+    objectID := primitive.NewObjectID()
+    query, err := buildQuery(&TrackingFilter{ID: objectID.Hex()})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if query.match["_id"] != objectID {
+        t.Fatalf("Should match an ObjectID on _id, got %v", query.match)
+    }
+
+    publicID := ulid.New(time.Now())
+    query, err = buildQuery(&TrackingFilter{ID: strings.ToLower(publicID)})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if query.match["public_id"] != publicID {
+        t.Fatalf("Should match a ULID on public_id, got %v", query.match)
+    }
+    if _, ok := query.match["_id"]; ok {
+        t.Fatal("Should not match a ULID on _id")
+    }
+
+    if _, err = buildQuery(&TrackingFilter{ID: "42"}); !errors.Is(err, ErrInvalidID) {
+        t.Fatalf("Should reject an invalid id, got %v", err)
+    }
+}
+
+func TestTrackingQuery_MultiValue(t *testing.T) {
+    first, second := primitive.NewObjectID(), primitive.NewObjectID()
+    query, err := buildQuery(
+        &TrackingFilter{
+            VehicleID: first.Hex() + ", " + second.Hex() + "," + first.Hex(),
+            Status:    models.VehicleStatusActive + "," + models.VehicleStatusRepair,
+        },
+    )
+    if err != nil {
+        t.Fatal(err)
+    }
+    vehicleIDs := bson.M{"$in": []primitive.ObjectID{first, second}}
+    if !reflect.DeepEqual(query.match["vehicle_id"], vehicleIDs) {
+        t.Fatalf("Should match the distinct vehicles with $in, got %v", query.match["vehicle_id"])
+    }
+    if _, ok := query.match["status"].(bson.M)["$in"]; !ok {
+        t.Fatalf("Should match the statuses with $in, got %v", query.match["status"])
+    }
+
+    filter := &TrackingFilter{VehicleID: first.Hex(), FuelCondition: models.FuelConditionFull}
+    query, err = buildQuery(filter)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if query.match["vehicle_id"] != first || filter.VehicleObjID() != first {
+        t.Fatalf("Should match a single vehicle by equality, got %v", query.match["vehicle_id"])
+    }
+
+    // the selection is intersected with the vehicles the filter is restricted to
+    filter = &TrackingFilter{VehicleID: first.Hex() + "," + second.Hex()}
+    filter.RestrictVehicles([]primitive.ObjectID{second})
+    query, err = buildQuery(filter)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !reflect.DeepEqual(query.match["vehicle_id"], bson.M{"$in": []primitive.ObjectID{second}}) {
+        t.Fatalf("Should only match the allowed vehicles of the selection, got %v", query.match["vehicle_id"])
+    }
+    if !filter.VehicleObjID().IsZero() {
+        t.Fatal("Should not have a single vehicle for a selection")
+    }
+
+    if _, err = buildQuery(&TrackingFilter{VehicleID: first.Hex() + ",42"}); !errors.Is(err, ErrInvalidID) {
+        t.Fatalf("Should reject an invalid vehicle id of the list, got %v", err)
+    }
+    many := make([]string, MaxFilterValues+1)
+    for i := range many {
+        many[i] = primitive.NewObjectID().Hex()
+    }
+    if _, err = buildQuery(&TrackingFilter{VehicleID: strings.Join(many, ",")}); !errors.Is(err, ErrTooManyValues) {
+        t.Fatalf("Should reject more than %d vehicles, got %v", MaxFilterValues, err)
+    }
+}
+
+func TestTrackingQuery_Driver(t *testing.T) {
+    query, err := buildQuery(&TrackingFilter{DriverID: "driver-1"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if query.match["driver_id"] != "driver-1" {
+        t.Fatalf("Should match a single driver by equality, got %v", query.match["driver_id"])
+    }
+    query, err = buildQuery(&TrackingFilter{DriverID: "driver-1, driver-2"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !reflect.DeepEqual(query.match["driver_id"], bson.M{"$in": []string{"driver-1", "driver-2"}}) {
+        t.Fatalf("Should match the drivers with $in, got %v", query.match["driver_id"])
+    }
+    if _, err = buildQuery(&TrackingFilter{DriverID: strings.Repeat("d", 65)}); !errors.Is(err, ErrInvalidDriverID) {
+        t.Fatalf("Should reject a driver id longer than %d characters, got %v", MaxDriverIDLength, err)
+    }
+}
+
+func TestTrackingQuery_MileageRange(t *testing.T) {
+    low, high := 0.0, 20000.0
+    query, err := buildQuery(&TrackingFilter{MileageMin: &low, MileageMax: &high})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !reflect.DeepEqual(query.match["mileage"], bson.M{"$gte": 0.0, "$lte": 20000.0}) {
+        t.Fatalf("Should match the band including a 0 minimum, got %v", query.match["mileage"])
+    }
+
+    // mileage is the former name of mileage_min
+    query, err = buildQuery(&TrackingFilter{Mileage: &high})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !reflect.DeepEqual(query.match["mileage"], bson.M{"$gte": 20000.0}) {
+        t.Fatalf("Should match the minimum of the former parameter, got %v", query.match["mileage"])
+    }
+
+    if _, err = buildQuery(&TrackingFilter{MileageMin: &high, MileageMax: &low}); !errors.Is(err, ErrInvalidMileage) {
+        t.Fatalf("Should reject a minimum above the maximum, got %v", err)
+    }
+}
+
+func TestTrackingQuery_Projection(t *testing.T) {
+    query, err := buildQuery(&TrackingFilter{Fields: "vehicle_id, lat,lng,created_at,lat"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    projection := bson.M{"_id": 0, "vehicle_id": 1, "lat": 1, "lng": 1, "created_at": 1}
+    if !reflect.DeepEqual(query.projection, projection) {
+        t.Fatalf("Should project the distinct fields without the id, got %v", query.projection)
+    }
+
+    if query, err = buildQuery(&TrackingFilter{Fields: "id,mileage"}); err != nil {
+        t.Fatal(err)
+    }
+    if !reflect.DeepEqual(query.projection, bson.M{"_id": 1, "mileage": 1}) {
+        t.Fatalf("Should keep the id when requested, got %v", query.projection)
+    }
+
+    if query, err = buildQuery(&TrackingFilter{}); err != nil || query.projection != nil {
+        t.Fatalf("Should not project without fields, got %v", query.projection)
+    }
+    if _, err = buildQuery(&TrackingFilter{Fields: "vehicle_id,password"}); !errors.Is(err, ErrInvalidField) {
+        t.Fatalf("Should reject unknown fields, got %v", err)
+    }
+}
+
+func TestTrackingQuery_Sensors(t *testing.T) {
+    query, err := buildQuery(&TrackingFilter{Sensor: "temperature:-20..-15,door_open:false,battery_voltage:11.5.."})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !reflect.DeepEqual(query.match["sensors.temperature"], bson.M{"$gte": -20.0, "$lte": -15.0}) {
+        t.Fatalf("Should match the range of the sensor, got %v", query.match["sensors.temperature"])
+    }
+    if query.match["sensors.door_open"] != false {
+        t.Fatalf("Should match the boolean value of the sensor, got %v", query.match["sensors.door_open"])
+    }
+    if !reflect.DeepEqual(query.match["sensors.battery_voltage"], bson.M{"$gte": 11.5}) {
+        t.Fatalf("Should match the open range of the sensor, got %v", query.match["sensors.battery_voltage"])
+    }
+
+    for _, sensor := range []string{"temperature", "Temperature:1", "temperature:..", "temperature:a..b",
+        "temperature:5..1", "temperature:1,temperature:2"} {
+        if _, err = buildQuery(&TrackingFilter{Sensor: sensor}); !errors.Is(err, ErrInvalidSensorFilter) {
+            t.Fatalf("Should reject the sensor filter %q, got %v", sensor, err)
+        }
+    }
+}
@@ -0,0 +1,311 @@
+package repositories
+
+import (
+    "context"
+    "errors"
+    "log"
+    "math"
+    "slices"
+    "strconv"
+    "strings"
+    "time"
+
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+    // MaxTileZoom is the deepest zoom of the heatmap tiles, the one of most web maps
+    MaxTileZoom = 22
+    // MaxTileLat is the latitude of the edges of the web mercator projection, readings beyond aren't on a tile
+    MaxTileLat = 85.05112878
+    // MaxHeatmapTiles is how many tiles a bbox may cover at the zoom of a heatmap
+    MaxHeatmapTiles = 10000
+)
+
+var (
+    ErrInvalidBBox  = errors.New("invalid bbox, it must be min_lng,min_lat,max_lng,max_lat with min below max")
+    ErrInvalidZoom  = errors.New("invalid zoom, it must be an integer between 0 and 22")
+    ErrTooManyTiles = errors.New("the bbox covers more than 10000 tiles at the zoom, zoom out or narrow the bbox")
+)
+
+// TileX returns the column of the web mercator tile of the longitude at the zoom
+func TileX(lng float64, zoom int) int64 {
+    n := float64(int64(1) << zoom)
+    return clampTile(math.Floor((lng+180)/360*n), zoom)
+}
+
+// TileY returns the row of the web mercator tile of the latitude at the zoom, the rows go from north to south
+func TileY(lat float64, zoom int) int64 {
+    n := float64(int64(1) << zoom)
+    rad := max(-MaxTileLat, min(lat, MaxTileLat)) * math.Pi / 180
+    return clampTile(math.Floor((1-math.Log(math.Tan(rad)+1/math.Cos(rad))/math.Pi)/2*n), zoom)
+}
+
+// clampTile keeps the edges of the map, e.g. the longitude 180, on the last tile
+func clampTile(index float64, zoom int) int64 {
+    return max(0, min(int64(index), int64(1)<<zoom-1))
+}
+
+// tileLng returns the longitude of the west edge of the column x
+func tileLng(x int64, zoom int) float64 {
+    return float64(x)/float64(int64(1)<<zoom)*360 - 180
+}
+
+// tileLat returns the latitude of the north edge of the row y
+func tileLat(y int64, zoom int) float64 {
+    return math.Atan(math.Sinh(math.Pi*(1-2*float64(y)/float64(int64(1)<<zoom)))) * 180 / math.Pi
+}
+
+// HeatmapTile is the number of readings and distinct vehicles on a web mercator tile, the z/x/y of the slippy maps
+type HeatmapTile struct {
+    Zoom int   `json:"zoom"`
+    X    int64 `json:"x"`
+    Y    int64 `json:"y"`
+    // Lat and Lng are the center of the tile, the point of the tile for point based heatmap layers
+    Lat      float64 `json:"lat"`
+    Lng      float64 `json:"lng"`
+    MinLat   float64 `json:"min_lat"`
+    MinLng   float64 `json:"min_lng"`
+    MaxLat   float64 `json:"max_lat"`
+    MaxLng   float64 `json:"max_lng"`
+    Readings int64   `json:"readings"`
+    Vehicles int64   `json:"vehicles"`
+}
+
+func newHeatmapTile(zoom int, x, y, readings, vehicles int64) *HeatmapTile {
+    tile := &HeatmapTile{
+        Zoom:     zoom,
+        X:        x,
+        Y:        y,
+        MinLat:   tileLat(y+1, zoom),
+        MinLng:   tileLng(x, zoom),
+        MaxLat:   tileLat(y, zoom),
+        MaxLng:   tileLng(x+1, zoom),
+        Readings: readings,
+        Vehicles: vehicles,
+    }
+    tile.Lat = (tile.MinLat + tile.MaxLat) / 2
+    tile.Lng = (tile.MinLng + tile.MaxLng) / 2
+    return tile
+}
+
+type TrackingHeatmapFilter struct {
+    BBox      string `json:"bbox" doc:"min_lng,min_lat,max_lng,max_lat of the map view"`
+    Zoom      string `json:"zoom" doc:"Zoom of the tiles, 0 to 22"`
+    VehicleID string `json:"vehicle_id" doc:"Comma separated vehicle ids"`
+    From      string `json:"from" doc:"RFC3339 start of created_at, inclusive"`
+    To        string `json:"to" doc:"RFC3339 end of created_at, exclusive"`
+    Exclude   string `json:"exclude" doc:"Comma separated backfill, anomalies, suspect and skewed to leave out, or none"`
+
+    minLng, minLat, maxLng, maxLat float64
+    zoom                           int
+    selected                       []primitive.ObjectID
+    vehicleIDs                     []primitive.ObjectID
+    from                           time.Time
+    to                             time.Time
+    excluded                       []string
+}
+
+// RestrictVehicles limits the heatmap to the given vehicles, on top of the vehicle_id filter
+func (f *TrackingHeatmapFilter) RestrictVehicles(vehicleIDs []primitive.ObjectID) {
+    f.vehicleIDs = vehicleIDs
+}
+
+func (f *TrackingHeatmapFilter) Build() error {
+    bbox := strings.Split(f.BBox, ",")
+    if len(bbox) != 4 {
+        return ErrInvalidBBox
+    }
+    var values [4]float64
+    for i, value := range bbox {
+        parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+        if err != nil || math.IsNaN(parsed) {
+            return ErrInvalidBBox
+        }
+        values[i] = parsed
+    }
+    f.minLng, f.minLat, f.maxLng, f.maxLat = values[0], values[1], values[2], values[3]
+    if f.minLng < -180 || f.maxLng > 180 || f.minLat < -90 || f.maxLat > 90 ||
+        f.minLng >= f.maxLng || f.minLat >= f.maxLat {
+        return ErrInvalidBBox
+    }
+    // the poles aren't on the tiles
+    f.minLat, f.maxLat = max(f.minLat, -MaxTileLat), min(f.maxLat, MaxTileLat)
+    if f.minLat >= f.maxLat {
+        return ErrInvalidBBox
+    }
+
+    zoom, err := strconv.Atoi(f.Zoom)
+    if err != nil || zoom < 0 || zoom > MaxTileZoom {
+        return ErrInvalidZoom
+    }
+    f.zoom = zoom
+    columns := TileX(f.maxLng, zoom) - TileX(f.minLng, zoom) + 1
+    rows := TileY(f.minLat, zoom) - TileY(f.maxLat, zoom) + 1
+    if columns*rows > MaxHeatmapTiles {
+        return ErrTooManyTiles
+    }
+
+    f.selected = nil
+    vehicleIDs, err := SplitValues("vehicle_id", f.VehicleID)
+    if err != nil {
+        return err
+    }
+    for _, vehicleID := range vehicleIDs {
+        id, err := primitive.ObjectIDFromHex(vehicleID)
+        if err != nil {
+            return ErrInvalidID
+        }
+        f.selected = append(f.selected, id)
+    }
+    if f.From != "" {
+        from, err := time.Parse(time.RFC3339, f.From)
+        if err != nil {
+            return ErrInvalidTimeRange
+        }
+        f.from = from
+    }
+    if f.To != "" {
+        to, err := time.Parse(time.RFC3339, f.To)
+        if err != nil {
+            return ErrInvalidTimeRange
+        }
+        f.to = to
+    }
+    if !f.from.IsZero() && !f.to.IsZero() && !f.from.Before(f.to) {
+        return ErrInvalidTimeRange
+    }
+    excluded, err := excludedFlags(f.Exclude)
+    if err != nil {
+        return err
+    }
+    f.excluded = excluded
+    return nil
+}
+
+type heatmapTileCount struct {
+    X        int64 `bson:"x"`
+    Y        int64 `bson:"y"`
+    Readings int64 `bson:"readings"`
+    Vehicles int64 `bson:"vehicles"`
+}
+
+// FindHeatmapTiles counts the readings with coordinates in the bbox per tile of the zoom, the tiles are computed by
+// the aggregation so only the counts leave the database. The busiest tiles come first.
+func (repo *MongoTrackingStatsRepository) FindHeatmapTiles(
+    ctx context.Context,
+    filter *TrackingHeatmapFilter,
+) ([]*HeatmapTile, error) {
+    if err := filter.Build(); err != nil {
+        return nil, err
+    }
+
+    match := excludeFlagged(notDeleted(bson.M{}), filter.excluded)
+    match["lng"] = bson.M{"$gte": filter.minLng, "$lte": filter.maxLng}
+    match["lat"] = bson.M{"$gte": filter.minLat, "$lte": filter.maxLat}
+    // the geohash ranges of the bbox read the geohash index, the coordinates trim the cells to the bbox
+    match["geohash"] = geohashCover(filter.minLat, filter.minLng, filter.maxLat, filter.maxLng)
+    vehicleIDs := filter.selected
+    if filter.vehicleIDs != nil {
+        vehicleIDs = filter.vehicleIDs
+        if filter.selected != nil {
+            vehicleIDs = slices.DeleteFunc(
+                slices.Clone(vehicleIDs), func(id primitive.ObjectID) bool {
+                    return !slices.Contains(filter.selected, id)
+                },
+            )
+        }
+    }
+    if vehicleIDs != nil {
+        match["vehicle_id"] = bson.M{"$in": vehicleIDs}
+    }
+    scopeTenant(ctx, match)
+    if !filter.from.IsZero() || !filter.to.IsZero() {
+        createdAt := bson.M{}
+        if !filter.from.IsZero() {
+            createdAt["$gte"] = filter.from
+        }
+        if !filter.to.IsZero() {
+            createdAt["$lt"] = filter.to
+        }
+        match["created_at"] = createdAt
+    }
+
+    // the aggregation counterparts of TileX and TileY
+    n := int64(1) << filter.zoom
+    clamp := func(index bson.M) bson.M {
+        return bson.M{"$max": bson.A{0, bson.M{"$min": bson.A{n - 1, bson.M{"$toLong": bson.M{"$floor": index}}}}}}
+    }
+    lat := bson.M{"$degreesToRadians": "$lat"}
+    secant := bson.M{"$divide": bson.A{1, bson.M{"$cos": lat}}}
+    mercator := bson.M{"$ln": bson.M{"$add": bson.A{bson.M{"$tan": lat}, secant}}}
+    x := bson.M{"$multiply": bson.A{bson.M{"$divide": bson.A{bson.M{"$add": bson.A{"$lng", 180}}, 360}}, n}}
+    y := bson.M{
+        "$multiply": bson.A{
+            bson.M{"$divide": bson.A{bson.M{"$subtract": bson.A{1, bson.M{"$divide": bson.A{mercator, math.Pi}}}}, 2}},
+            n,
+        },
+    }
+    pipeline := mongo.Pipeline{
+        {{Key: "$match", Value: match}},
+        {{
+            Key: "$group",
+            Value: bson.M{
+                "_id":      bson.M{"x": clamp(x), "y": clamp(y)},
+                "vehicles": bson.M{"$addToSet": "$vehicle_id"},
+                "readings": bson.M{"$sum": 1},
+            },
+        }},
+        {{
+            Key: "$project",
+            Value: bson.M{
+                "_id":      0,
+                "x":        "$_id.x",
+                "y":        "$_id.y",
+                "vehicles": bson.M{"$size": "$vehicles"},
+                "readings": 1,
+            },
+        }},
+        {{
+            Key:   "$sort",
+            Value: bson.D{{Key: "readings", Value: -1}, {Key: "y", Value: 1}, {Key: "x", Value: 1}},
+        }},
+    }
+
+    collections := []*mongo.Collection{repo.collection}
+    if repo.partitions != nil {
+        var err error
+        if collections, err = repo.partitions.Collections(ctx, filter.from, filter.to); err != nil {
+            return nil, err
+        }
+        if len(collections) == 0 {
+            return nil, nil
+        }
+    }
+    cursor, err := collections[0].Aggregate(
+        ctx,
+        unionPipeline(collections, pipeline),
+        options.Aggregate().SetAllowDiskUse(true),
+    )
+    if err != nil {
+        return nil, err
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
+        }
+    }(cursor, ctx)
+    var tiles []*HeatmapTile
+    for cursor.Next(ctx) {
+        var count heatmapTileCount
+        if err := cursor.Decode(&count); err != nil {
+            return nil, err
+        }
+        tiles = append(tiles, newHeatmapTile(filter.zoom, count.X, count.Y, count.Readings, count.Vehicles))
+    }
+    return tiles, cursor.Err()
+}
@@ -0,0 +1,62 @@
+package repositories
+
+import (
+    "errors"
+    "math"
+    "testing"
+)
+
+func TestTiles(t *testing.T) {
+    // Yangon on the tiles of the OpenStreetMap tile server
+    if x, y := TileX(96.1561, 12), TileY(16.8409, 12); x != 3142 || y != 1853 {
+        t.Errorf("expected the tile 12/3142/1853, got 12/%d/%d", x, y)
+    }
+    if x, y := TileX(0, 0), TileY(0, 0); x != 0 || y != 0 {
+        t.Errorf("expected the single tile at zoom 0, got 0/%d/%d", x, y)
+    }
+    // the edges of the map stay on the last tiles
+    if x, y := TileX(180, 3), TileY(-90, 3); x != 7 || y != 7 {
+        t.Errorf("expected the tile 3/7/7, got 3/%d/%d", x, y)
+    }
+    if y := TileY(90, 3); y != 0 {
+        t.Errorf("expected the first row, got %d", y)
+    }
+
+    tile := newHeatmapTile(12, 3142, 1853, 10, 2)
+    if tile.MinLng > 96.1561 || tile.MaxLng < 96.1561 || tile.MinLat > 16.8409 || tile.MaxLat < 16.8409 {
+        t.Errorf("expected the tile to contain Yangon, got %+v", tile)
+    }
+    if math.Abs(tile.MaxLng-tile.MinLng-360.0/4096) > 1e-9 {
+        t.Errorf("expected a tile of 1/4096 of the longitudes, got %+v", tile)
+    }
+}
+
+func TestTrackingHeatmapFilter_Build(t *testing.T) {
+    filters := map[*TrackingHeatmapFilter]error{
+        {Zoom: "10"}:                                      ErrInvalidBBox,
+        {BBox: "96,16,97", Zoom: "10"}:                    ErrInvalidBBox,
+        {BBox: "97,16,96,17", Zoom: "10"}:                 ErrInvalidBBox,
+        {BBox: "96,16,97,north", Zoom: "10"}:              ErrInvalidBBox,
+        {BBox: "-190,16,97,17", Zoom: "10"}:               ErrInvalidBBox,
+        {BBox: "96,86,97,89", Zoom: "10"}:                 ErrInvalidBBox,
+        {BBox: "96,16,97,17"}:                             ErrInvalidZoom,
+        {BBox: "96,16,97,17", Zoom: "23"}:                 ErrInvalidZoom,
+        {BBox: "96,16,97,17", Zoom: "street"}:             ErrInvalidZoom,
+        {BBox: "-180,-90,180,90", Zoom: "7"}:              ErrTooManyTiles,
+        {BBox: "96,16,97,17", Zoom: "10", VehicleID: "v"}: ErrInvalidID,
+    }
+    for filter, expected := range filters {
+        if err := filter.Build(); !errors.Is(err, expected) {
+            t.Errorf("Should reject %+v with %v, got %v", filter, expected, err)
+        }
+    }
+
+    // the whole world at zoom 6 is 4096 tiles, the poles are left out
+    filter := &TrackingHeatmapFilter{BBox: "-180,-90,180,90", Zoom: "6"}
+    if err := filter.Build(); err != nil {
+        t.Fatal(err)
+    }
+    if filter.minLat != -MaxTileLat || filter.maxLat != MaxTileLat || filter.zoom != 6 {
+        t.Errorf("expected the latitudes of the tiles at zoom 6, got %+v", filter)
+    }
+}
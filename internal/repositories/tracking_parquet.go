@@ -0,0 +1,93 @@
+package repositories
+
+import (
+    "io"
+    "strings"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/parquet"
+)
+
+// trackingParquetRowGroup is the number of tracking data of a row group of the Parquet files, the rows of a row
+// group are held in memory until it is written
+const trackingParquetRowGroup = 50_000
+
+// trackingParquetColumns are the columns of the tracking data exported as Parquet, the flags are comma separated and
+// the sensors a JSON object
+var trackingParquetColumns = []parquet.Column{
+    {Name: "id", Type: parquet.String},
+    {Name: "public_id", Type: parquet.String, Optional: true},
+    {Name: "tenant_id", Type: parquet.String, Optional: true},
+    {Name: "vehicle_id", Type: parquet.String},
+    {Name: "driver_id", Type: parquet.String, Optional: true},
+    {Name: "location", Type: parquet.String},
+    {Name: "mileage", Type: parquet.Double},
+    {Name: "status", Type: parquet.String},
+    {Name: "fuel_condition", Type: parquet.String},
+    {Name: "lat", Type: parquet.Double, Optional: true},
+    {Name: "lng", Type: parquet.Double, Optional: true},
+    {Name: "distance_meters", Type: parquet.Double, Optional: true},
+    {Name: "odometer_meters", Type: parquet.Double, Optional: true},
+    {Name: "speed_kmh", Type: parquet.Double, Optional: true},
+    {Name: "flags", Type: parquet.String, Optional: true},
+    {Name: "sensors", Type: parquet.String, Optional: true},
+    {Name: "created_at", Type: parquet.Timestamp},
+    {Name: "updated_at", Type: parquet.Timestamp},
+}
+
+// TrackingParquetWriter writes tracking data as a Parquet file, with a typed column per field so the file loads
+// into Spark or DuckDB without conversion
+type TrackingParquetWriter struct {
+    writer *parquet.Writer
+}
+
+func NewTrackingParquetWriter(w io.Writer) (*TrackingParquetWriter, error) {
+    writer, err := parquet.NewWriter(w, trackingParquetRowGroup, trackingParquetColumns...)
+    if err != nil {
+        return nil, err
+    }
+    return &TrackingParquetWriter{writer: writer}, nil
+}
+
+func (w *TrackingParquetWriter) Write(record *TrackingRecord) error {
+    var sensors []byte
+    if len(record.Sensors) > 0 {
+        var err error
+        if sensors, err = json.Marshal(record.Sensors); err != nil {
+            return err
+        }
+    }
+    return w.writer.Write(
+        record.ID.Hex(),
+        optionalString(record.PublicID),
+        optionalString(record.TenantID),
+        record.VehicleID.Hex(),
+        optionalString(record.DriverID),
+        record.Location,
+        record.Mileage,
+        string(record.Status),
+        string(record.FuelCondition),
+        record.Lat,
+        record.Lng,
+        record.DistanceMeters,
+        record.OdometerMeters,
+        record.SpeedKmh,
+        optionalString(strings.Join(record.Flags, ",")),
+        optionalString(string(sensors)),
+        record.CreatedAt,
+        record.UpdatedAt,
+    )
+}
+
+// Close writes the buffered tracking data and the metadata of the file, it doesn't close the underlying writer
+func (w *TrackingParquetWriter) Close() error {
+    return w.writer.Close()
+}
+
+// optionalString returns nil for an empty string, a missing value of an optional column
+func optionalString(value string) any {
+    if value == "" {
+        return nil
+    }
+    return value
+}
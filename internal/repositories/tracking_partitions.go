@@ -0,0 +1,254 @@
+package repositories
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "regexp"
+    "slices"
+    "strconv"
+    "sync"
+    "time"
+
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+    trackingCollection = "tracking"
+
+    // partitionRefreshInterval is how long the listing of the partitions is reused, partitions created by other
+    // instances are seen after it
+    partitionRefreshInterval = time.Minute
+)
+
+// partitionNamePattern matches the monthly partitions, tracking_2025_01 holds the tracking data created in
+// January 2025
+var partitionNamePattern = regexp.MustCompile(`^tracking_(\d{4})_(\d{2})$`)
+
+// partitionIndexes are created on every partition when it is created
+var partitionIndexes = []mongo.IndexModel{
+    {Keys: bson.D{{Key: "vehicle_id", Value: 1}, {Key: "created_at", Value: -1}}},
+    {Keys: bson.D{{Key: "created_at", Value: -1}}},
+}
+
+// PartitionName returns the name of the partition of the tracking data created at t
+func PartitionName(t time.Time) string {
+    t = t.UTC()
+    return fmt.Sprintf("%s_%04d_%02d", trackingCollection, t.Year(), int(t.Month()))
+}
+
+// partitionMonth returns the first instant of the month of a partition, false for the unpartitioned collection
+// and names that aren't partitions
+func partitionMonth(name string) (time.Time, bool) {
+    matches := partitionNamePattern.FindStringSubmatch(name)
+    if matches == nil {
+        return time.Time{}, false
+    }
+    year, _ := strconv.Atoi(matches[1])
+    month, _ := strconv.Atoi(matches[2])
+    if month < 1 || month > 12 {
+        return time.Time{}, false
+    }
+    return time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC), true
+}
+
+// partitionsInRange returns the partitions that can hold tracking data created in [from, to) newest first,
+// a zero from or to leaves that side open. The unpartitioned collection is always kept, it holds the tracking
+// data stored before partitioning was enabled.
+func partitionsInRange(names []string, from, to time.Time) []string {
+    var partitions []string
+    for _, name := range names {
+        month, ok := partitionMonth(name)
+        if !ok {
+            if name == trackingCollection {
+                partitions = append(partitions, name)
+            }
+            continue
+        }
+        if !from.IsZero() && !month.AddDate(0, 1, 0).After(from) {
+            continue
+        }
+        if !to.IsZero() && !month.Before(to) {
+            continue
+        }
+        partitions = append(partitions, name)
+    }
+    // the unpartitioned collection is a prefix of every partition, so it sorts last
+    slices.Sort(partitions)
+    slices.Reverse(partitions)
+    return slices.Compact(partitions)
+}
+
+// TrackingPartitions manages the monthly partitions of the tracking data. The partition of a month is created
+// with its indexes on its first write, so the rollover to a new month needs no setup, and old months are
+// dropped as whole collections instead of deleting their documents.
+type TrackingPartitions struct {
+    db *mongo.Database
+
+    mu       sync.Mutex
+    indexes  []mongo.IndexModel
+    ready    map[string]bool
+    names    []string
+    listedAt time.Time
+    now      func() time.Time
+}
+
+func NewTrackingPartitions(db *mongo.Database) *TrackingPartitions {
+    return &TrackingPartitions{
+        db:      db,
+        indexes: slices.Clone(partitionIndexes),
+        ready:   map[string]bool{},
+        now:     time.Now,
+    }
+}
+
+// AddIndexes creates the indexes on the existing partitions and on every partition created later
+func (p *TrackingPartitions) AddIndexes(ctx context.Context, indexes []mongo.IndexModel) error {
+    p.mu.Lock()
+    p.indexes = append(p.indexes, indexes...)
+    p.mu.Unlock()
+
+    names, err := p.list(ctx)
+    if err != nil {
+        return err
+    }
+    for _, name := range names {
+        if _, err := p.db.Collection(name).Indexes().CreateMany(ctx, indexes); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Partition returns the partition of the tracking data created at t, creating it with its indexes when it
+// doesn't exist yet
+func (p *TrackingPartitions) Partition(ctx context.Context, t time.Time) (*mongo.Collection, error) {
+    name := PartitionName(t)
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    if p.ready[name] {
+        return p.db.Collection(name), nil
+    }
+
+    // other instances may create the same partition, creating an existing collection or index is not an error
+    if err := p.db.CreateCollection(ctx, name); err != nil && !isNamespaceExists(err) {
+        return nil, err
+    }
+    collection := p.db.Collection(name)
+    if _, err := collection.Indexes().CreateMany(ctx, p.indexes); err != nil {
+        return nil, err
+    }
+    p.ready[name] = true
+    if !slices.Contains(p.names, name) {
+        p.names = append(p.names, name)
+    }
+    return collection, nil
+}
+
+// Collections returns the partitions that can hold tracking data created in [from, to) newest first, a zero
+// from or to leaves that side open
+func (p *TrackingPartitions) Collections(ctx context.Context, from, to time.Time) ([]*mongo.Collection, error) {
+    names, err := p.list(ctx)
+    if err != nil {
+        return nil, err
+    }
+    // the partitions of the current and next month are queried even before they are listed, they may have
+    // just been created by another instance. Querying a missing collection finds nothing.
+    now := p.now().UTC()
+    month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+    names = append(names, PartitionName(month), PartitionName(month.AddDate(0, 1, 0)))
+
+    partitions := partitionsInRange(names, from, to)
+    collections := make([]*mongo.Collection, 0, len(partitions))
+    for _, name := range partitions {
+        collections = append(collections, p.db.Collection(name))
+    }
+    return collections, nil
+}
+
+// DropBefore drops the partitions of the months that ended before before, the unpartitioned collection is kept.
+// It returns the names of the dropped partitions.
+func (p *TrackingPartitions) DropBefore(ctx context.Context, before time.Time) ([]string, error) {
+    names, err := p.list(ctx)
+    if err != nil {
+        return nil, err
+    }
+    var dropped []string
+    for _, name := range names {
+        month, ok := partitionMonth(name)
+        if !ok || month.AddDate(0, 1, 0).After(before) {
+            continue
+        }
+        if err := p.db.Collection(name).Drop(ctx); err != nil {
+            return dropped, err
+        }
+        dropped = append(dropped, name)
+    }
+
+    p.mu.Lock()
+    for _, name := range dropped {
+        delete(p.ready, name)
+    }
+    p.names = slices.DeleteFunc(p.names, func(name string) bool { return slices.Contains(dropped, name) })
+    p.mu.Unlock()
+    return dropped, nil
+}
+
+// list returns the names of the partitions and the unpartitioned collection, the listing is reused for
+// partitionRefreshInterval
+func (p *TrackingPartitions) list(ctx context.Context) ([]string, error) {
+    p.mu.Lock()
+    if p.names != nil && p.now().Sub(p.listedAt) < partitionRefreshInterval {
+        names := slices.Clone(p.names)
+        p.mu.Unlock()
+        return names, nil
+    }
+    p.mu.Unlock()
+
+    names, err := p.db.ListCollectionNames(
+        ctx,
+        bson.M{"name": bson.M{"$regex": "^" + trackingCollection + `(_\d{4}_\d{2})?$`}},
+    )
+    if err != nil {
+        return nil, err
+    }
+
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    // keeps the partitions created while listing
+    for _, name := range p.names {
+        if p.ready[name] && !slices.Contains(names, name) {
+            names = append(names, name)
+        }
+    }
+    if names == nil {
+        names = []string{}
+    }
+    p.names = names
+    p.listedAt = p.now()
+    return slices.Clone(names), nil
+}
+
+func isNamespaceExists(err error) bool {
+    var commandErr mongo.CommandError
+    return errors.As(err, &commandErr) && commandErr.Code == 48
+}
+
+// unionPipeline runs a pipeline starting with a $match over several collections, the other collections are
+// matched with $unionWith right after the first stage so the rest of the pipeline sees all their documents.
+// The pipeline is run on the first collection.
+func unionPipeline(collections []*mongo.Collection, pipeline mongo.Pipeline) mongo.Pipeline {
+    if len(collections) < 2 {
+        return pipeline
+    }
+    union := make(mongo.Pipeline, 0, len(pipeline)+len(collections)-1)
+    union = append(union, pipeline[0])
+    for _, collection := range collections[1:] {
+        union = append(
+            union,
+            bson.D{{Key: "$unionWith", Value: bson.M{"coll": collection.Name(), "pipeline": bson.A{pipeline[0]}}}},
+        )
+    }
+    return append(union, pipeline[1:]...)
+}
@@ -0,0 +1,76 @@
+package repositories
+
+import (
+    "context"
+    "slices"
+    "testing"
+    "time"
+
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestPartitionName(t *testing.T) {
+    at := time.Date(2025, time.January, 31, 23, 30, 0, 0, time.FixedZone("UTC-2", -2*60*60))
+    if name := PartitionName(at); name != "tracking_2025_02" {
+        t.Fatalf("Should partition by the UTC month, got %s", name)
+    }
+
+    month, ok := partitionMonth("tracking_2025_02")
+    if !ok || !month.Equal(time.Date(2025, time.February, 1, 0, 0, 0, 0, time.UTC)) {
+        t.Fatalf("Should parse the month of a partition, got %v", month)
+    }
+    for _, name := range []string{"tracking", "tracking_2025_13", "tracking_stats"} {
+        if _, ok := partitionMonth(name); ok {
+            t.Fatalf("%s should not be a partition", name)
+        }
+    }
+}
+
+func TestPartitionsInRange(t *testing.T) {
+    names := []string{"tracking_2025_01", "tracking", "tracking_2025_03", "tracking_2025_02", "tracking_2025_03"}
+
+    partitions := partitionsInRange(names, time.Time{}, time.Time{})
+    expected := []string{"tracking_2025_03", "tracking_2025_02", "tracking_2025_01", "tracking"}
+    if !slices.Equal(partitions, expected) {
+        t.Fatalf("Should list every partition newest first, got %v", partitions)
+    }
+
+    partitions = partitionsInRange(
+        names,
+        time.Date(2025, time.February, 10, 0, 0, 0, 0, time.UTC),
+        time.Date(2025, time.March, 1, 0, 0, 0, 0, time.UTC),
+    )
+    expected = []string{"tracking_2025_02", "tracking"}
+    if !slices.Equal(partitions, expected) {
+        t.Fatalf("Should only list the partitions overlapping the range, got %v", partitions)
+    }
+}
+
+func TestUnionPipeline(t *testing.T) {
+    // connecting is lazy, no server is needed to build pipelines
+    client, err := mongo.Connect(context.Background(), options.Client().ApplyURI("mongodb://localhost:27017"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    db := client.Database("tracking")
+    pipeline := mongo.Pipeline{
+        {{Key: "$match", Value: bson.M{"vehicle_id": primitive.NewObjectID()}}},
+        {{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+    }
+
+    if union := unionPipeline([]*mongo.Collection{db.Collection("tracking")}, pipeline); len(union) != 2 {
+        t.Fatalf("Should not change the pipeline of a single collection, got %d stages", len(union))
+    }
+
+    union := unionPipeline(
+        []*mongo.Collection{db.Collection("tracking_2025_02"), db.Collection("tracking_2025_01")},
+        pipeline,
+    )
+    // $match, $unionWith, $sort
+    if len(union) != 3 || union[1][0].Key != "$unionWith" || union[2][0].Key != "$sort" {
+        t.Fatalf("Should match the other partitions before the rest of the pipeline, got %v", union)
+    }
+}
@@ -0,0 +1,193 @@
+package repositories
+
+import (
+    "fmt"
+    "slices"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/geo"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+)
+
+// recordFields are the JSON values of the fields the tracking data can be projected to, by JSON name
+var recordFields = map[string]func(r *TrackingRecord) any{
+    "id":              func(r *TrackingRecord) any { return r.ID },
+    "public_id":       func(r *TrackingRecord) any { return r.PublicID },
+    "vehicle_id":      func(r *TrackingRecord) any { return r.VehicleID },
+    "driver_id":       func(r *TrackingRecord) any { return r.DriverID },
+    "location":        func(r *TrackingRecord) any { return r.Location },
+    "mileage":         func(r *TrackingRecord) any { return r.Mileage },
+    "status":          func(r *TrackingRecord) any { return r.Status },
+    "fuel_condition":  func(r *TrackingRecord) any { return r.FuelCondition },
+    "lat":             func(r *TrackingRecord) any { return r.Lat },
+    "lng":             func(r *TrackingRecord) any { return r.Lng },
+    "distance_meters": func(r *TrackingRecord) any { return r.DistanceMeters },
+    "odometer_meters": func(r *TrackingRecord) any { return r.OdometerMeters },
+    "flags":           func(r *TrackingRecord) any { return r.Flags },
+    "sensors":         func(r *TrackingRecord) any { return r.Sensors },
+    "device_time":     func(r *TrackingRecord) any { return r.DeviceTime },
+    "received_time":   func(r *TrackingRecord) any { return r.ReceivedTime },
+    "created_at":      func(r *TrackingRecord) any { return timestamp.New(r.CreatedAt) },
+    "updated_at":      func(r *TrackingRecord) any { return timestamp.New(r.UpdatedAt) },
+}
+
+// ValidateField fails with ErrInvalidField when the tracking data can't be projected to the field
+func ValidateField(field string) error {
+    if _, ok := recordFields[field]; !ok {
+        return fmt.Errorf("%w: %s", ErrInvalidField, field)
+    }
+    return nil
+}
+
+// fieldPath returns the path of a projectable field in the stored document
+func fieldPath(field string) string {
+    if field == "id" {
+        return "_id"
+    }
+    return field
+}
+
+// TrackingRecord is the tracking document stored by this service. It embeds the shared models.TrackingData
+// inline, so the shared fields keep their names in both JSON and BSON, and adds the fields only this service uses.
+type TrackingRecord struct {
+    models.TrackingData `bson:",inline"`
+
+    // TenantID is the fleet customer the record belongs to, set from the context it was stored with
+    TenantID string `json:"tenant_id,omitempty" bson:"tenant_id,omitempty"`
+
+    // PublicID is the ULID of the record, a storage independent id lookups accept alongside the ObjectID
+    PublicID string `json:"public_id,omitempty" bson:"public_id,omitempty"`
+
+    // DriverID is the driver of the vehicle when the reading was taken, sent with the reading or looked up from the
+    // driver assignments
+    DriverID string `json:"driver_id,omitempty" bson:"driver_id,omitempty"`
+
+    // Sensors are the values of the additional sensors of the vehicle, like the temperature of a refrigerated trailer
+    Sensors Sensors `json:"sensors,omitempty" bson:"sensors,omitempty"`
+
+    // DeviceTime is when the device took the reading by its own clock and ReceivedTime when the reading reached the
+    // broker or this service. CreatedAt is one of them, or the recorded_at of a backfilled reading.
+    DeviceTime   *timestamp.Time `json:"device_time,omitempty" bson:"device_time,omitempty"`
+    ReceivedTime *timestamp.Time `json:"received_time,omitempty" bson:"received_time,omitempty"`
+
+    // Flags mark tracking data that read queries can exclude, like FlagBackfill, FlagAnomaly and FlagSuspect
+    Flags []string `json:"flags,omitempty" bson:"flags,omitempty"`
+
+    Lat *float64 `json:"lat,omitempty" bson:"lat,omitempty"`
+    Lng *float64 `json:"lng,omitempty" bson:"lng,omitempty"`
+    // Geohash is the geohash of the coordinates, assigned when the record is stored in MongoDB
+    Geohash string `json:"geohash,omitempty" bson:"geohash,omitempty"`
+
+    // MatchedLat and MatchedLng are the coordinates snapped to the road network and RoadName the name of that road,
+    // set only when map matching at ingestion matched the coordinates
+    MatchedLat *float64 `json:"matched_lat,omitempty" bson:"matched_lat,omitempty"`
+    MatchedLng *float64 `json:"matched_lng,omitempty" bson:"matched_lng,omitempty"`
+    RoadName   string   `json:"road_name,omitempty" bson:"road_name,omitempty"`
+
+    // DistanceMeters is the haversine distance from the vehicle's previous position and OdometerMeters the
+    // total of those distances, both are computed from the coordinates and set only for records with them
+    DistanceMeters *float64 `json:"distance_meters,omitempty" bson:"distance_meters,omitempty"`
+    OdometerMeters *float64 `json:"odometer_meters,omitempty" bson:"odometer_meters,omitempty"`
+    // SpeedKmh is the average speed since the vehicle's previous position, set only when it was long enough before
+    SpeedKmh *float64 `json:"speed_kmh,omitempty" bson:"speed_kmh,omitempty"`
+}
+
+func NewTrackingRecord(trackingData *models.TrackingData) *TrackingRecord {
+    return &TrackingRecord{TrackingData: *trackingData}
+}
+
+// SetPosition sets the coordinates of the record
+func (r *TrackingRecord) SetPosition(lat, lng float64) *TrackingRecord {
+    r.Lat = &lat
+    r.Lng = &lng
+    return r
+}
+
+// SetMatchedPosition sets the coordinates snapped to the road network and the name of the road, empty when unknown
+func (r *TrackingRecord) SetMatchedPosition(lat, lng float64, roadName string) *TrackingRecord {
+    r.MatchedLat = &lat
+    r.MatchedLng = &lng
+    r.RoadName = roadName
+    return r
+}
+
+// SetDistance sets the distance from the previous position and the odometer after it
+func (r *TrackingRecord) SetDistance(distanceMeters, odometerMeters float64) *TrackingRecord {
+    r.DistanceMeters = &distanceMeters
+    r.OdometerMeters = &odometerMeters
+    return r
+}
+
+// SetSpeed sets the average speed since the previous position
+func (r *TrackingRecord) SetSpeed(speedKmh float64) *TrackingRecord {
+    r.SpeedKmh = &speedKmh
+    return r
+}
+
+// AddFlag flags the record, a flag is only added once
+func (r *TrackingRecord) AddFlag(flag string) *TrackingRecord {
+    if !slices.Contains(r.Flags, flag) {
+        r.Flags = append(r.Flags, flag)
+    }
+    return r
+}
+
+// Point returns the position of the record, false when the record has no coordinates
+func (r *TrackingRecord) Point() (geo.Point, bool) {
+    if r.Lat == nil || r.Lng == nil {
+        return geo.Point{}, false
+    }
+    return geo.Point{Lat: *r.Lat, Lng: *r.Lng, Time: r.CreatedAt}, true
+}
+
+// RoadPoint returns the position of the record on the road network when it was matched, its GPS position otherwise
+func (r *TrackingRecord) RoadPoint() (geo.Point, bool) {
+    if r.MatchedLat != nil && r.MatchedLng != nil {
+        return geo.Point{Lat: *r.MatchedLat, Lng: *r.MatchedLng, Time: r.CreatedAt}, true
+    }
+    return r.Point()
+}
+
+// MarshalJSON formats the timestamps of the shared model like every other timestamp of the API and events
+func (r TrackingRecord) MarshalJSON() ([]byte, error) {
+    // record has the fields of TrackingRecord without this method, the timestamps below shadow the embedded ones
+    type record TrackingRecord
+    var deletedAt *timestamp.Time
+    if r.DeletedAt != nil {
+        deletedAt = timestamp.Ptr(*r.DeletedAt)
+    }
+    return json.Marshal(
+        struct {
+            record
+            CreatedAt timestamp.Time  `json:"created_at"`
+            UpdatedAt timestamp.Time  `json:"updated_at"`
+            DeletedAt *timestamp.Time `json:"deleted_at,omitempty"`
+        }{
+            record:    record(r),
+            CreatedAt: timestamp.New(r.CreatedAt),
+            UpdatedAt: timestamp.New(r.UpdatedAt),
+            DeletedAt: deletedAt,
+        },
+    )
+}
+
+// Project returns the fields of the record by their JSON names, for the responses of projected queries
+func (r *TrackingRecord) Project(fields []string) map[string]any {
+    projected := make(map[string]any, len(fields))
+    for _, field := range fields {
+        if value, ok := recordFields[field]; ok {
+            projected[field] = value(r)
+        }
+    }
+    return projected
+}
+
+// ProjectRecords projects every record to the fields
+func ProjectRecords(records []*TrackingRecord, fields []string) []map[string]any {
+    projected := make([]map[string]any, 0, len(records))
+    for _, record := range records {
+        projected = append(projected, record.Project(fields))
+    }
+    return projected
+}
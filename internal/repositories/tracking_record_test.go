@@ -0,0 +1,122 @@
+package repositories
+
+import (
+    "bytes"
+    "errors"
+    "slices"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/goccy/go-json"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestTrackingRecord_MarshalJSON(t *testing.T) {
+    record := &TrackingRecord{}
+    record.Location = "Yangon"
+    record.CreatedAt = time.Date(2024, 1, 2, 9, 34, 5, 678_900_000, time.FixedZone("MMT", 6*3600+1800))
+
+    data, err := json.Marshal(record)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !strings.Contains(string(data), `"created_at":"2024-01-02T03:04:05.678Z"`) {
+        t.Fatalf("Should format created_at as RFC3339 UTC with milliseconds, got %s", data)
+    }
+    if !strings.Contains(string(data), `"location":"Yangon"`) {
+        t.Fatalf("Should keep the other fields, got %s", data)
+    }
+    if strings.Contains(string(data), `"deleted_at"`) {
+        t.Fatalf("Should leave out deleted_at of a record that isn't deleted, got %s", data)
+    }
+
+    deletedAt := record.CreatedAt.Add(time.Hour)
+    record.DeletedAt = &deletedAt
+    if data, err = json.Marshal(record); err != nil {
+        t.Fatal(err)
+    }
+    if !strings.Contains(string(data), `"deleted_at":"2024-01-02T04:04:05.678Z"`) {
+        t.Fatalf("Should format deleted_at like created_at, got %s", data)
+    }
+    record.DeletedAt = nil
+
+    record.AddFlag(FlagBackfill).AddFlag(FlagBackfill).SetPosition(16.8, 96.1)
+    assignPublicID(record)
+    if data, err = json.Marshal(record); err != nil {
+        t.Fatal(err)
+    }
+    if !strings.Contains(string(data), `"public_id":"`+record.PublicID+`"`) {
+        t.Fatalf("Should return the public id, got %s", data)
+    }
+    if !strings.Contains(string(data), `"flags":["backfill"]`) || !strings.Contains(string(data), `"lat":16.8`) {
+        t.Fatalf("Should keep the flags once and the position, got %s", data)
+    }
+}
+
+func TestAssignGeohash(t *testing.T) {
+    record := (&TrackingRecord{}).SetPosition(16.8409, 96.1735)
+    assignGeohash(record)
+    if len(record.Geohash) != 9 || !strings.HasPrefix(record.Geohash, "w4") {
+        t.Fatalf("Should assign the geohash of Yangon, got %q", record.Geohash)
+    }
+    cover := geohashCover(16.8, 96.1, 16.9, 96.2)["$in"].(bson.A)
+    if !slices.ContainsFunc(cover, func(prefix any) bool {
+        return strings.HasPrefix(record.Geohash, strings.TrimPrefix(prefix.(primitive.Regex).Pattern, "^"))
+    }) {
+        t.Errorf("Should match the geohash with a prefix of the cover, got %v", cover)
+    }
+
+    record.Lat, record.Lng = nil, nil
+    assignGeohash(record)
+    if record.Geohash != "" {
+        t.Errorf("Should clear the geohash of a record without coordinates, got %q", record.Geohash)
+    }
+}
+
+func TestTrackingRecord_Project(t *testing.T) {
+    record := (&TrackingRecord{}).SetPosition(16.8, 96.1)
+    record.Location = "Yangon"
+    record.CreatedAt = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+    data, err := json.Marshal(ProjectRecords([]*TrackingRecord{record}, []string{"lat", "lng", "created_at"}))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if string(data) != `[{"created_at":"2024-01-02T03:04:05.000Z","lat":16.8,"lng":96.1}]` {
+        t.Fatalf("Should only have the projected fields, got %s", data)
+    }
+    if err = ValidateField("location"); err != nil {
+        t.Fatal(err)
+    }
+    if err = ValidateField("deleted_at"); !errors.Is(err, ErrInvalidField) {
+        t.Fatalf("Should reject fields that can't be projected, got %v", err)
+    }
+}
+
+func TestTrackingParquetWriter(t *testing.T) {
+    var file bytes.Buffer
+    w, err := NewTrackingParquetWriter(&file)
+    if err != nil {
+        t.Fatal(err)
+    }
+    record := &TrackingRecord{}
+    record.Location = "Yangon"
+    record.CreatedAt = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+    // every field has the type of its column, the missing optional ones included
+    if err = w.Write(record); err != nil {
+        t.Fatalf("Should write a record without position, got %v", err)
+    }
+    record.AddFlag(FlagBackfill).SetPosition(16.8, 96.1).SetDistance(12.5, 1200).SetSpeed(30)
+    assignPublicID(record)
+    if err = w.Write(record); err != nil {
+        t.Fatalf("Should write a record with every field, got %v", err)
+    }
+    if err = w.Close(); err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.HasPrefix(file.Bytes(), []byte("PAR1")) || !bytes.HasSuffix(file.Bytes(), []byte("PAR1")) {
+        t.Fatalf("Should write a Parquet file, got %q", file.Bytes())
+    }
+}
@@ -1,143 +1,467 @@
 package repositories
 
 import (
+    "bytes"
     "context"
     "errors"
     "fmt"
     "log"
+    "slices"
+    "time"
 
-    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/ulid"
     "go.mongodb.org/mongo-driver/bson"
     "go.mongodb.org/mongo-driver/bson/primitive"
     "go.mongodb.org/mongo-driver/mongo"
     "go.mongodb.org/mongo-driver/mongo/options"
 )
 
+const (
+    streamBatchSize = 500
+)
+
 var (
-    ErrInvalidID = errors.New("invalid id")
+    ErrTrackingDataNotFound = fmt.Errorf("tracking data %w", ErrNotFound)
 )
 
-type TrackingFilter struct {
-    Page          int                  `json:"page"`
-    PageSize      int                  `json:"limit"`
-    SortField     string               `json:"sort_by"`
-    SortOrder     string               `json:"sort_order"`
-    VehicleID     string               `json:"vehicle_id"`
-    Location      string               `json:"location"`
-    Mileage       float64              `json:"mileage"`
-    Status        models.VehicleStatus `json:"status"`
-    FuelCondition models.FuelCondition `json:"fuel_condition"`
+type TrackingRepository interface {
+    CreateTrackingData(ctx context.Context, trackingData *TrackingRecord) error
+    CreateManyTrackingData(ctx context.Context, trackingData []*TrackingRecord) ([]error, error)
+    FindTrackingData(ctx context.Context, filter *TrackingFilter) ([]*TrackingRecord, error)
+    // FindTrackingDataByID returns the tracking data with the ObjectID or public id, ErrInvalidID when id is
+    // neither and ErrTrackingDataNotFound when there is no such tracking data or it was deleted
+    FindTrackingDataByID(ctx context.Context, id string) (*TrackingRecord, error)
+    StreamTrackingData(
+        ctx context.Context,
+        filter *TrackingFilter,
+        fn func(trackingData *TrackingRecord) error,
+    ) error
+    FindLatestTrackingData(ctx context.Context, limits []VehicleLimit) (map[primitive.ObjectID][]*TrackingRecord, error)
+    FindTrackingDataAfter(
+        ctx context.Context,
+        after primitive.ObjectID,
+        vehicleIDs []primitive.ObjectID,
+        limit int,
+    ) ([]*TrackingRecord, error)
+    // DeleteTrackingData soft deletes or purges the tracking data of the vehicle created before before, a zero
+    // before deletes all of it. It returns the number of tracking data deleted.
+    DeleteTrackingData(ctx context.Context, vehicleID primitive.ObjectID, before time.Time, purge bool) (int64, error)
+    // FindDeletionScope counts the tracking data DeleteTrackingData would delete without deleting it, with the
+    // public ids of up to samples of them, the oldest first
+    FindDeletionScope(
+        ctx context.Context,
+        vehicleID primitive.ObjectID,
+        before time.Time,
+        purge bool,
+        samples int,
+    ) (int64, []string, error)
+    // FlagTrackingData adds the flag to the stored tracking data, queries can exclude the flagged tracking data
+    FlagTrackingData(ctx context.Context, trackingData *TrackingRecord, flag string) error
+    // UpdateTrackingDistances stores the distance, odometer and speed of the stored tracking data again, after
+    // readings arriving late were measured between them
+    UpdateTrackingDistances(ctx context.Context, trackingData []*TrackingRecord) error
+    // FindActiveVehicles returns up to limit vehicles with tracking data created since since, the most recently
+    // seen first
+    FindActiveVehicles(ctx context.Context, since time.Time, limit int) ([]*ActiveVehicle, error)
+    // FindLatestVersion returns the version of the tracking data matching the filter, ignoring pagination, nil when
+    // none matches
+    FindLatestVersion(ctx context.Context, filter *TrackingFilter) (*TrackingDataVersion, error)
+}
 
-    vehicleID primitive.ObjectID
+// TrackingDataVersion is the last stored id and the number of the tracking data matching a filter, so clients
+// polling the same query can tell whether its results changed. The ids are assigned when the tracking data is
+// stored, a late or backfilled reading with an earlier created_at changes the version too, and deleting tracking
+// data changes the count.
+type TrackingDataVersion struct {
+    ID    primitive.ObjectID `bson:"_id"`
+    Count int64              `bson:"count"`
 }
 
-func (t *TrackingFilter) VehicleObjID() primitive.ObjectID {
-    return t.vehicleID
+// ModifiedAt returns when the last matching tracking data was stored
+func (v *TrackingDataVersion) ModifiedAt() time.Time {
+    return v.ID.Timestamp().UTC()
 }
 
-func (t *TrackingFilter) Build() error {
-    if t.Page == 0 {
-        t.Page = 1
-    }
-    if t.PageSize == 0 {
-        t.PageSize = 10
-    }
-    if t.PageSize > 100 {
-        t.PageSize = 100
-    }
-    if t.SortField == "" {
-        t.SortField = "created_at"
-    }
-    if t.SortOrder == "" {
-        t.SortOrder = "asc"
-    }
-    if t.VehicleID != "" {
-        id, err := primitive.ObjectIDFromHex(t.VehicleID)
-        if err != nil {
-            return ErrInvalidID
-        }
-        t.vehicleID = id
-    }
-    if t.Status != "" {
-        if err := t.Status.Valid(); err != nil {
-            return err
-        }
-    }
-    if t.FuelCondition != "" {
-        if err := t.FuelCondition.Valid(); err != nil {
-            return err
-        }
-    }
-    return nil
+// ActiveVehicle is a vehicle that reported recently, with the tenant its tracking data belongs to
+type ActiveVehicle struct {
+    TenantID  string             `bson:"tenant_id"`
+    VehicleID primitive.ObjectID `bson:"vehicle_id"`
+    LastSeen  time.Time          `bson:"last_seen"`
 }
 
-type TrackingRepository interface {
-    CreateTrackingData(ctx context.Context, trackingData *models.TrackingData) error
-    FindTrackingData(ctx context.Context, filter *TrackingFilter) ([]*models.TrackingData, error)
+// VehicleLimit is the number of latest tracking data to find for a vehicle
+type VehicleLimit struct {
+    VehicleID primitive.ObjectID
+    Limit     int
+}
+
+// tenantIndexes are the indexes of the tenant scoped queries, the tenant comes first so the data of each tenant
+// is a contiguous range of the indexes
+var tenantIndexes = []mongo.IndexModel{
+    {Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "vehicle_id", Value: 1}, {Key: "created_at", Value: -1}}},
+    {Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "created_at", Value: -1}}},
+}
+
+// driverIndexes are the indexes of the queries of the tracking data of drivers, only the readings with a driver
+// are indexed
+var driverIndexes = []mongo.IndexModel{
+    {
+        Keys: bson.D{{Key: "driver_id", Value: 1}, {Key: "created_at", Value: -1}},
+        Options: options.Index().
+            SetPartialFilterExpression(bson.M{"driver_id": bson.M{"$exists": true}}),
+    },
+}
+
+// sensorIndexes are the indexes of the sensor filters, a wildcard index covers every sensor of the readings with
+// sensors
+var sensorIndexes = []mongo.IndexModel{
+    {
+        Keys: bson.D{{Key: "sensors.$**", Value: 1}},
+        Options: options.Index().
+            SetPartialFilterExpression(bson.M{"sensors": bson.M{"$exists": true}}),
+    },
 }
 
 type MongoTackingRepository struct {
     collection *mongo.Collection
+    // partitions is nil when the tracking data is stored in a single collection
+    partitions *TrackingPartitions
 }
 
 func NewMongoTackingRepository(db *mongo.Database) *MongoTackingRepository {
-    trackingCollection := db.Collection("tracking")
+    trackingCollection := db.Collection(trackingCollection)
     return &MongoTackingRepository{
         collection: trackingCollection,
     }
 }
 
-func (repo *MongoTackingRepository) CreateTrackingData(ctx context.Context, trackingData *models.TrackingData) error {
+// NewPartitionedMongoTackingRepository stores the tracking data in monthly partitions by created_at, queries
+// only read the partitions overlapping their time range
+func NewPartitionedMongoTackingRepository(db *mongo.Database, partitions *TrackingPartitions) *MongoTackingRepository {
+    return &MongoTackingRepository{
+        collection: db.Collection(trackingCollection),
+        partitions: partitions,
+    }
+}
+
+// CreateDriverIndexes creates the index of the queries of the tracking data of drivers, on every partition when
+// partitioned
+func (repo *MongoTackingRepository) CreateDriverIndexes(ctx context.Context) error {
+    if repo.partitions != nil {
+        return repo.partitions.AddIndexes(ctx, driverIndexes)
+    }
+    _, err := repo.collection.Indexes().CreateMany(ctx, driverIndexes)
+    return err
+}
+
+// CreateSensorIndexes creates the index of the sensor filters, on every partition when partitioned
+func (repo *MongoTackingRepository) CreateSensorIndexes(ctx context.Context) error {
+    if repo.partitions != nil {
+        return repo.partitions.AddIndexes(ctx, sensorIndexes)
+    }
+    _, err := repo.collection.Indexes().CreateMany(ctx, sensorIndexes)
+    return err
+}
+
+// CreateTenantIndexes creates the indexes of the tenant scoped queries, on every partition when partitioned
+func (repo *MongoTackingRepository) CreateTenantIndexes(ctx context.Context) error {
+    if repo.partitions != nil {
+        return repo.partitions.AddIndexes(ctx, tenantIndexes)
+    }
+    _, err := repo.collection.Indexes().CreateMany(ctx, tenantIndexes)
+    return err
+}
+
+// writeCollection returns the collection storing the tracking data created at createdAt
+func (repo *MongoTackingRepository) writeCollection(ctx context.Context, createdAt time.Time) (*mongo.Collection, error) {
+    if repo.partitions == nil {
+        return repo.collection, nil
+    }
+    if createdAt.IsZero() {
+        createdAt = time.Now()
+    }
+    return repo.partitions.Partition(ctx, createdAt)
+}
+
+// readCollections returns the collections that can hold tracking data created in [from, to), zero from or to
+// leave that side open
+func (repo *MongoTackingRepository) readCollections(ctx context.Context, from, to time.Time) ([]*mongo.Collection, error) {
+    if repo.partitions == nil {
+        return []*mongo.Collection{repo.collection}, nil
+    }
+    return repo.partitions.Collections(ctx, from, to)
+}
+
+func (repo *MongoTackingRepository) CreateTrackingData(ctx context.Context, trackingData *TrackingRecord) error {
     if err := trackingData.Build(); err != nil {
-        return err
+        return classify(err)
+    }
+    trackingData.TenantID = tenantOf(ctx)
+    assignPublicID(trackingData)
+    assignGeohash(trackingData)
+    collection, err := repo.writeCollection(ctx, trackingData.CreatedAt)
+    if err != nil {
+        return classify(err)
     }
-    result, err := repo.collection.InsertOne(ctx, trackingData)
+    result, err := collection.InsertOne(ctx, trackingData)
     if err != nil {
-        return err
+        return classify(err)
     }
     trackingData.ID = result.InsertedID.(primitive.ObjectID)
     return nil
 }
 
+// CreateManyTrackingData inserts the tracking data unordered, so a failing document doesn't stop the rest.
+// The returned slice has an entry per tracking data, nil for the ones that were inserted.
+func (repo *MongoTackingRepository) CreateManyTrackingData(
+    ctx context.Context,
+    trackingData []*TrackingRecord,
+) ([]error, error) {
+    itemErrs := make([]error, len(trackingData))
+    // the tracking data is inserted per collection, a batch of buffered readings can span partitions
+    type insert struct {
+        collection *mongo.Collection
+        documents  []any
+        // indexes maps the position in documents back to the position in trackingData
+        indexes []int
+    }
+    var inserts []*insert
+    byCollection := map[string]*insert{}
+    for i, data := range trackingData {
+        if err := data.Build(); err != nil {
+            itemErrs[i] = err
+            continue
+        }
+        data.TenantID = tenantOf(ctx)
+        // IDs are assigned upfront, so we know them even when part of the batch fails
+        if data.ID.IsZero() {
+            data.ID = primitive.NewObjectID()
+        }
+        assignPublicID(data)
+        assignGeohash(data)
+        collection, err := repo.writeCollection(ctx, data.CreatedAt)
+        if err != nil {
+            return nil, classify(err)
+        }
+        batch, ok := byCollection[collection.Name()]
+        if !ok {
+            batch = &insert{collection: collection}
+            byCollection[collection.Name()] = batch
+            inserts = append(inserts, batch)
+        }
+        batch.documents = append(batch.documents, data)
+        batch.indexes = append(batch.indexes, i)
+    }
+
+    for _, batch := range inserts {
+        _, err := batch.collection.InsertMany(ctx, batch.documents, options.InsertMany().SetOrdered(false))
+        if err != nil {
+            var bulkErr mongo.BulkWriteException
+            if !errors.As(err, &bulkErr) || len(bulkErr.WriteErrors) == 0 {
+                return nil, classify(err)
+            }
+            for _, writeErr := range bulkErr.WriteErrors {
+                itemErrs[batch.indexes[writeErr.Index]] = classifyWriteError(writeErr)
+            }
+        }
+    }
+    return itemErrs, nil
+}
+
 func (repo *MongoTackingRepository) FindTrackingData(
     ctx context.Context,
     filter *TrackingFilter,
-) ([]*models.TrackingData, error) {
-    var trackingData []*models.TrackingData
-    bsonMFilter := bson.M{}
-    findOptions := options.Find()
+) ([]*TrackingRecord, error) {
+    var trackingData []*TrackingRecord
+    query, err := buildQuery(filter)
+    if err != nil {
+        return nil, classify(err)
+    }
+    scopeTenant(ctx, query.match)
+    var skip, limit int64
+    var from, to time.Time
     if filter != nil {
-        if err := filter.Build(); err != nil {
-            return nil, err
+        skip = int64((filter.Page - 1) * filter.PageSize)
+        limit = int64(filter.PageSize)
+        from, to = filter.createdRange()
+    }
+    collections, err := repo.readCollections(ctx, from, to)
+    if err != nil || len(collections) == 0 {
+        return nil, classify(err)
+    }
+    pipeline := query.pipeline(skip, limit)
+    if query.projection != nil {
+        pipeline = append(pipeline, bson.D{{Key: "$project", Value: query.projection}})
+    }
+    cursor, err := collections[0].Aggregate(
+        ctx,
+        unionPipeline(collections, pipeline),
+        options.Aggregate().SetAllowDiskUse(true),
+    )
+    if err != nil {
+        return nil, classify(err)
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
+        }
+    }(cursor, ctx)
+    for cursor.Next(ctx) {
+        var data TrackingRecord
+        if err := cursor.Decode(&data); err != nil {
+            return nil, classify(err)
+        }
+        trackingData = append(trackingData, &data)
+    }
+    return trackingData, nil
+}
+
+func (repo *MongoTackingRepository) FindTrackingDataByID(ctx context.Context, id string) (*TrackingRecord, error) {
+    objectID, publicID, err := parseID(id)
+    if err != nil {
+        return nil, classify(err)
+    }
+    match := bson.M{"_id": objectID}
+    // ObjectIDs are generated when the tracking data is stored, so only the ULIDs tell the partition of the
+    // tracking data, they have the time of its creation
+    var from, to time.Time
+    if publicID != "" {
+        match = bson.M{"public_id": publicID}
+        if from, err = ulid.Time(publicID); err != nil {
+            return nil, classify(err)
+        }
+        to = from.Add(time.Millisecond)
+    }
+    collections, err := repo.readCollections(ctx, from, to)
+    if err != nil {
+        return nil, classify(err)
+    }
+    if len(collections) == 0 {
+        return nil, ErrTrackingDataNotFound
+    }
+    cursor, err := collections[0].Aggregate(
+        ctx,
+        unionPipeline(
+            collections,
+            mongo.Pipeline{
+                {{Key: "$match", Value: notDeleted(scopeTenant(ctx, match))}},
+                {{Key: "$limit", Value: 1}},
+            },
+        ),
+    )
+    if err != nil {
+        return nil, classify(err)
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
         }
-        if filter.VehicleID != "" {
-            bsonMFilter["vehicle_id"] = filter.VehicleObjID()
+    }(cursor, ctx)
+    if !cursor.Next(ctx) {
+        if err = cursor.Err(); err != nil {
+            return nil, classify(err)
         }
-        if filter.Location != "" {
-            bsonMFilter["location"] = bson.M{"$regex": fmt.Sprintf("^%s", filter.Location), "$options": "i"}
+        return nil, ErrTrackingDataNotFound
+    }
+    var data TrackingRecord
+    if err = cursor.Decode(&data); err != nil {
+        return nil, classify(err)
+    }
+    return &data, nil
+}
+
+// StreamTrackingData calls fn for every tracking data matching the filter, ignoring pagination.
+// Documents are decoded one at a time from the cursor, so memory usage doesn't grow with the result size.
+func (repo *MongoTackingRepository) StreamTrackingData(
+    ctx context.Context,
+    filter *TrackingFilter,
+    fn func(trackingData *TrackingRecord) error,
+) error {
+    query, err := buildQuery(filter)
+    if err != nil {
+        return classify(err)
+    }
+    scopeTenant(ctx, query.match)
+    var from, to time.Time
+    if filter != nil {
+        from, to = filter.createdRange()
+    }
+    collections, err := repo.readCollections(ctx, from, to)
+    if err != nil || len(collections) == 0 {
+        return classify(err)
+    }
+    cursor, err := collections[0].Aggregate(
+        ctx,
+        unionPipeline(collections, query.pipeline(0, 0)),
+        options.Aggregate().SetAllowDiskUse(true).SetBatchSize(streamBatchSize),
+    )
+    if err != nil {
+        return classify(err)
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
         }
-        if filter.Mileage != 0 {
-            bsonMFilter["mileage"] = bson.M{"$gte": filter.Mileage}
+    }(cursor, ctx)
+    for cursor.Next(ctx) {
+        var data TrackingRecord
+        if err := cursor.Decode(&data); err != nil {
+            return classify(err)
         }
-        if filter.Status != "" {
-            bsonMFilter["status"] = filter.Status
+        if err := fn(&data); err != nil {
+            return err
         }
-        if filter.FuelCondition != "" {
-            bsonMFilter["fuel_condition"] = filter.FuelCondition
+    }
+    return classify(cursor.Err())
+}
+
+// FindLatestTrackingData finds the latest tracking data of several vehicles in one round trip, newest first.
+// Every vehicle gets its own sub-pipeline combined with $unionWith, so each one can use
+// an index on vehicle_id and created_at and stop after its limit. When partitioned every partition gets
+// a sub-pipeline per vehicle, the latest of them are picked afterwards.
+func (repo *MongoTackingRepository) FindLatestTrackingData(
+    ctx context.Context,
+    limits []VehicleLimit,
+) (map[primitive.ObjectID][]*TrackingRecord, error) {
+    trackingData := make(map[primitive.ObjectID][]*TrackingRecord, len(limits))
+    if len(limits) == 0 {
+        return trackingData, nil
+    }
+
+    latest := func(limit VehicleLimit) bson.A {
+        return bson.A{
+            bson.M{"$match": scopeTenant(ctx, notDeleted(bson.M{"vehicle_id": limit.VehicleID}))},
+            bson.M{"$sort": bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+            bson.M{"$limit": limit.Limit},
         }
-        if filter.SortField != "" {
-            order := 1
-            if filter.SortOrder == "desc" {
-                order = -1
+    }
+
+    collections, err := repo.readCollections(ctx, time.Time{}, time.Time{})
+    if err != nil || len(collections) == 0 {
+        return trackingData, classify(err)
+    }
+
+    var pipeline bson.A
+    for _, collection := range collections {
+        for _, limit := range limits {
+            if pipeline == nil {
+                pipeline = latest(limit)
+                continue
             }
-            findOptions.SetSort(bson.D{{Key: filter.SortField, Value: order}})
+            pipeline = append(
+                pipeline,
+                bson.M{"$unionWith": bson.M{"coll": collection.Name(), "pipeline": latest(limit)}},
+            )
         }
-        findOptions.SetSkip(int64((filter.Page - 1) * filter.PageSize))
-        findOptions.SetLimit(int64(filter.PageSize))
     }
-    cursor, err := repo.collection.Find(ctx, bsonMFilter, findOptions)
+
+    cursor, err := collections[0].Aggregate(ctx, pipeline)
     if err != nil {
-        return nil, err
+        return nil, classify(err)
     }
     defer func(cursor *mongo.Cursor, ctx context.Context) {
         err := cursor.Close(ctx)
@@ -146,11 +470,340 @@ func (repo *MongoTackingRepository) FindTrackingData(
         }
     }(cursor, ctx)
     for cursor.Next(ctx) {
-        var data models.TrackingData
+        var data TrackingRecord
         if err := cursor.Decode(&data); err != nil {
-            return nil, err
+            return nil, classify(err)
+        }
+        trackingData[data.VehicleID] = append(trackingData[data.VehicleID], &data)
+    }
+    if err := cursor.Err(); err != nil {
+        return nil, classify(err)
+    }
+
+    if len(collections) > 1 {
+        for _, limit := range limits {
+            trackingData[limit.VehicleID] = latestOf(trackingData[limit.VehicleID], limit.Limit)
         }
-        trackingData = append(trackingData, &data)
     }
     return trackingData, nil
 }
+
+// latestOf returns the limit latest of the tracking data, newest first
+func latestOf(trackingData []*TrackingRecord, limit int) []*TrackingRecord {
+    if trackingData == nil {
+        return nil
+    }
+    slices.SortFunc(
+        trackingData, func(a, b *TrackingRecord) int {
+            if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+                return c
+            }
+            return bytes.Compare(b.ID[:], a.ID[:])
+        },
+    )
+    return trackingData[:min(limit, len(trackingData))]
+}
+
+// FindTrackingDataAfter finds the tracking data of the vehicles stored after the tracking data with the id after,
+// ordered by id so the id of the last one can be used as the next after
+func (repo *MongoTackingRepository) FindTrackingDataAfter(
+    ctx context.Context,
+    after primitive.ObjectID,
+    vehicleIDs []primitive.ObjectID,
+    limit int,
+) ([]*TrackingRecord, error) {
+    var trackingData []*TrackingRecord
+    // the ids are assigned when stored, a reading stored now can be in the partition of any month
+    collections, err := repo.readCollections(ctx, time.Time{}, time.Time{})
+    if err != nil || len(collections) == 0 {
+        return nil, classify(err)
+    }
+    match := scopeTenant(ctx, notDeleted(bson.M{"_id": bson.M{"$gt": after}, "vehicle_id": bson.M{"$in": vehicleIDs}}))
+    cursor, err := collections[0].Aggregate(
+        ctx,
+        unionPipeline(
+            collections,
+            mongo.Pipeline{
+                {{Key: "$match", Value: match}},
+                {{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+                {{Key: "$limit", Value: limit}},
+            },
+        ),
+    )
+    if err != nil {
+        return nil, classify(err)
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
+        }
+    }(cursor, ctx)
+    for cursor.Next(ctx) {
+        var data TrackingRecord
+        if err := cursor.Decode(&data); err != nil {
+            return nil, classify(err)
+        }
+        trackingData = append(trackingData, &data)
+    }
+    return trackingData, classify(cursor.Err())
+}
+
+func (repo *MongoTackingRepository) DeleteTrackingData(
+    ctx context.Context,
+    vehicleID primitive.ObjectID,
+    before time.Time,
+    purge bool,
+) (int64, error) {
+    match := scopeTenant(ctx, bson.M{"vehicle_id": vehicleID})
+    if !before.IsZero() {
+        match["created_at"] = bson.M{"$lt": before}
+    }
+    collections, err := repo.readCollections(ctx, time.Time{}, before)
+    if err != nil {
+        return 0, classify(err)
+    }
+    deletedAt := timestamp.Now()
+    var deleted int64
+    for _, collection := range collections {
+        if purge {
+            result, err := collection.DeleteMany(ctx, match)
+            if err != nil {
+                return deleted, classify(err)
+            }
+            deleted += result.DeletedCount
+            continue
+        }
+        result, err := collection.UpdateMany(
+            ctx,
+            notDeleted(match),
+            bson.M{"$set": bson.M{"deleted_at": deletedAt}},
+        )
+        if err != nil {
+            return deleted, classify(err)
+        }
+        deleted += result.ModifiedCount
+    }
+    return deleted, nil
+}
+
+func (repo *MongoTackingRepository) FindDeletionScope(
+    ctx context.Context,
+    vehicleID primitive.ObjectID,
+    before time.Time,
+    purge bool,
+    samples int,
+) (int64, []string, error) {
+    match := scopeTenant(ctx, bson.M{"vehicle_id": vehicleID})
+    if !before.IsZero() {
+        match["created_at"] = bson.M{"$lt": before}
+    }
+    // a soft delete leaves the tracking data that is already deleted as it is
+    if !purge {
+        match = notDeleted(match)
+    }
+    collections, err := repo.readCollections(ctx, time.Time{}, before)
+    if err != nil {
+        return 0, nil, classify(err)
+    }
+    var count int64
+    ids := []string{}
+    // the partitions are newest first
+    for _, collection := range slices.Backward(collections) {
+        n, err := collection.CountDocuments(ctx, match)
+        if err != nil {
+            return 0, nil, classify(err)
+        }
+        count += n
+        if n == 0 || len(ids) >= samples {
+            continue
+        }
+        cursor, err := collection.Find(
+            ctx,
+            match,
+            options.Find().
+                SetProjection(bson.M{"_id": 1, "public_id": 1}).
+                SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}).
+                SetLimit(int64(samples-len(ids))),
+        )
+        if err != nil {
+            return 0, nil, classify(err)
+        }
+        var sampled []*TrackingRecord
+        if err = cursor.All(ctx, &sampled); err != nil {
+            return 0, nil, classify(err)
+        }
+        for _, data := range sampled {
+            id := data.PublicID
+            if id == "" {
+                id = data.ID.Hex()
+            }
+            ids = append(ids, id)
+        }
+    }
+    return count, ids, nil
+}
+
+func (repo *MongoTackingRepository) FlagTrackingData(
+    ctx context.Context,
+    trackingData *TrackingRecord,
+    flag string,
+) error {
+    collection, err := repo.writeCollection(ctx, trackingData.CreatedAt)
+    if err != nil {
+        return classify(err)
+    }
+    if _, err = collection.UpdateOne(
+        ctx,
+        scopeTenant(ctx, bson.M{"_id": trackingData.ID}),
+        bson.M{"$addToSet": bson.M{"flags": flag}},
+    ); err != nil {
+        return classify(err)
+    }
+    trackingData.AddFlag(flag)
+    return nil
+}
+
+func (repo *MongoTackingRepository) UpdateTrackingDistances(
+    ctx context.Context,
+    trackingData []*TrackingRecord,
+) error {
+    // the updates are written with a bulk write per partition
+    var collections []*mongo.Collection
+    writes := map[string][]mongo.WriteModel{}
+    for _, data := range trackingData {
+        collection, err := repo.writeCollection(ctx, data.CreatedAt)
+        if err != nil {
+            return classify(err)
+        }
+        if _, ok := writes[collection.Name()]; !ok {
+            collections = append(collections, collection)
+        }
+        writes[collection.Name()] = append(
+            writes[collection.Name()],
+            mongo.NewUpdateOneModel().
+                SetFilter(scopeTenant(ctx, bson.M{"_id": data.ID})).
+                SetUpdate(distanceUpdate(data)),
+        )
+    }
+    for _, collection := range collections {
+        _, err := collection.BulkWrite(ctx, writes[collection.Name()], options.BulkWrite().SetOrdered(false))
+        if err != nil {
+            return classify(err)
+        }
+    }
+    return nil
+}
+
+// distanceUpdate sets the distance fields of the stored tracking data to the ones of trackingData, the speed is
+// removed when it has none
+func distanceUpdate(trackingData *TrackingRecord) bson.M {
+    set := bson.M{"distance_meters": trackingData.DistanceMeters, "odometer_meters": trackingData.OdometerMeters}
+    if trackingData.SpeedKmh == nil {
+        return bson.M{"$set": set, "$unset": bson.M{"speed_kmh": ""}}
+    }
+    set["speed_kmh"] = trackingData.SpeedKmh
+    return bson.M{"$set": set}
+}
+
+func (repo *MongoTackingRepository) FindActiveVehicles(
+    ctx context.Context,
+    since time.Time,
+    limit int,
+) ([]*ActiveVehicle, error) {
+    collections, err := repo.readCollections(ctx, since, time.Time{})
+    if err != nil || len(collections) == 0 {
+        return nil, classify(err)
+    }
+    pipeline := mongo.Pipeline{
+        {{Key: "$match", Value: notDeleted(scopeTenant(ctx, bson.M{"created_at": bson.M{"$gte": since}}))}},
+        {{
+            Key: "$group",
+            Value: bson.M{
+                "_id":       bson.M{"tenant_id": "$tenant_id", "vehicle_id": "$vehicle_id"},
+                "last_seen": bson.M{"$max": "$created_at"},
+            },
+        }},
+        {{Key: "$sort", Value: bson.D{{Key: "last_seen", Value: -1}}}},
+        {{Key: "$limit", Value: limit}},
+        {{
+            Key: "$project",
+            Value: bson.M{
+                "_id":        0,
+                "tenant_id":  "$_id.tenant_id",
+                "vehicle_id": "$_id.vehicle_id",
+                "last_seen":  1,
+            },
+        }},
+    }
+    cursor, err := collections[0].Aggregate(
+        ctx,
+        unionPipeline(collections, pipeline),
+        options.Aggregate().SetAllowDiskUse(true),
+    )
+    if err != nil {
+        return nil, classify(err)
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
+        }
+    }(cursor, ctx)
+    var vehicles []*ActiveVehicle
+    for cursor.Next(ctx) {
+        var vehicle ActiveVehicle
+        if err := cursor.Decode(&vehicle); err != nil {
+            return nil, classify(err)
+        }
+        vehicles = append(vehicles, &vehicle)
+    }
+    return vehicles, classify(cursor.Err())
+}
+
+func (repo *MongoTackingRepository) FindLatestVersion(
+    ctx context.Context,
+    filter *TrackingFilter,
+) (*TrackingDataVersion, error) {
+    query, err := buildQuery(filter)
+    if err != nil {
+        return nil, classify(err)
+    }
+    scopeTenant(ctx, query.match)
+    var from, to time.Time
+    if filter != nil {
+        from, to = filter.createdRange()
+    }
+    collections, err := repo.readCollections(ctx, from, to)
+    if err != nil || len(collections) == 0 {
+        return nil, classify(err)
+    }
+    cursor, err := collections[0].Aggregate(
+        ctx,
+        unionPipeline(
+            collections,
+            mongo.Pipeline{
+                {{Key: "$match", Value: query.match}},
+                {{Key: "$group", Value: bson.M{"_id": nil, "id": bson.M{"$max": "$_id"}, "count": bson.M{"$sum": 1}}}},
+                {{Key: "$project", Value: bson.M{"_id": "$id", "count": 1}}},
+            },
+        ),
+    )
+    if err != nil {
+        return nil, classify(err)
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
+        }
+    }(cursor, ctx)
+    if !cursor.Next(ctx) {
+        return nil, classify(cursor.Err())
+    }
+    var version TrackingDataVersion
+    if err = cursor.Decode(&version); err != nil {
+        return nil, classify(err)
+    }
+    return &version, nil
+}
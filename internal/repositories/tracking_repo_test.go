@@ -44,7 +44,7 @@ var FuelConditions = []models.FuelCondition{
     models.FuelConditionFull,
 }
 
-func getRandomTrackingData() (*models.TrackingData, error) {
+func getRandomTrackingData() (*TrackingRecord, error) {
     trackingData, err := models.NewTrackingData().SetVehicleID(
         fmt.Sprintf("%d735cc0f1af72af5f7cdcdee", rand.Intn(9)),
     )
@@ -58,7 +58,7 @@ func getRandomTrackingData() (*models.TrackingData, error) {
     if err := trackingData.Build(); err != nil {
         return nil, err
     }
-    return trackingData, nil
+    return NewTrackingRecord(trackingData).SetPosition(16.8+rand.Float64()/10, 96.1+rand.Float64()/10), nil
 }
 
 func TestMongoTackingRepository_CreateTrackingData(t *testing.T) {
@@ -0,0 +1,354 @@
+package repositories
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MaxRollups is how many rollups a query returns at most
+const MaxRollups = 10000
+
+var (
+    ErrInvalidResolution = errors.New("invalid resolution, it must be 5m or 1h")
+    ErrTooManyRollups    = fmt.Errorf(
+        "%w: the query has more than %d rollups, narrow the time range or use the 1h resolution",
+        ErrTooManyValues,
+        MaxRollups,
+    )
+)
+
+// RollupResolution is the length of the periods the readings are summarized over
+type RollupResolution string
+
+const (
+    RollupFiveMinutes RollupResolution = "5m"
+    RollupHourly      RollupResolution = "1h"
+)
+
+// Duration returns the length of the periods of the resolution
+func (r RollupResolution) Duration() time.Duration {
+    if r == RollupFiveMinutes {
+        return 5 * time.Minute
+    }
+    return time.Hour
+}
+
+func (r RollupResolution) collection() string {
+    if r == RollupFiveMinutes {
+        return "tracking_rollups_5m"
+    }
+    return "tracking_rollups_1h"
+}
+
+// TrackingRollup summarizes the readings of a vehicle during the period starting at Start
+type TrackingRollup struct {
+    VehicleID primitive.ObjectID `json:"vehicle_id" bson:"vehicle_id"`
+    // TenantID is stored empty without multi-tenancy, the rollups are unique per tenant, vehicle and period
+    TenantID string         `json:"tenant_id,omitempty" bson:"tenant_id"`
+    Start    timestamp.Time `json:"start" bson:"start"`
+    Readings int64          `json:"readings" bson:"readings"`
+    // Points is the number of readings with coordinates
+    Points int64 `json:"points" bson:"points"`
+    // DistanceMeters is the length of the path through the positions of the period
+    DistanceMeters float64 `json:"distance_meters" bson:"distance_meters"`
+    // AvgSpeedKmh is DistanceMeters over the time between the first and the last reading, 0 with a single reading
+    AvgSpeedKmh float64        `json:"avg_speed_kmh" bson:"avg_speed_kmh"`
+    FirstSeen   timestamp.Time `json:"first_seen" bson:"first_seen"`
+    LastSeen    timestamp.Time `json:"last_seen" bson:"last_seen"`
+    // FirstLat and FirstLng are the first position of the period, Lat and Lng the last one. They are missing when
+    // no reading of the period has coordinates.
+    FirstLat   *float64       `json:"first_lat,omitempty" bson:"first_lat,omitempty"`
+    FirstLng   *float64       `json:"first_lng,omitempty" bson:"first_lng,omitempty"`
+    Lat        *float64       `json:"lat,omitempty" bson:"lat,omitempty"`
+    Lng        *float64       `json:"lng,omitempty" bson:"lng,omitempty"`
+    ComputedAt timestamp.Time `json:"computed_at" bson:"computed_at"`
+}
+
+type TrackingRollupFilter struct {
+    VehicleID  string `json:"vehicle_id"`
+    From       string `json:"from" doc:"RFC3339 start of the periods, inclusive"`
+    To         string `json:"to" doc:"RFC3339 end of the periods, exclusive"`
+    Resolution string `json:"resolution" doc:"5m or 1h (default)"`
+
+    vehicleID  primitive.ObjectID
+    vehicleIDs []primitive.ObjectID
+    from       time.Time
+    to         time.Time
+    resolution RollupResolution
+}
+
+// RestrictVehicles limits the rollups to the given vehicles, on top of the vehicle_id filter
+func (f *TrackingRollupFilter) RestrictVehicles(vehicleIDs []primitive.ObjectID) {
+    f.vehicleIDs = vehicleIDs
+}
+
+// VehicleObjID returns the vehicle of the filter once it was built, nil without a vehicle_id
+func (f *TrackingRollupFilter) VehicleObjID() primitive.ObjectID {
+    return f.vehicleID
+}
+
+// ResolutionValue returns the resolution of the rollups, 1h by default
+func (f *TrackingRollupFilter) ResolutionValue() RollupResolution {
+    return f.resolution
+}
+
+func (f *TrackingRollupFilter) Build() error {
+    f.vehicleID = primitive.NilObjectID
+    if f.VehicleID != "" {
+        id, err := primitive.ObjectIDFromHex(f.VehicleID)
+        if err != nil {
+            return ErrInvalidID
+        }
+        f.vehicleID = id
+    }
+    f.from, f.to = time.Time{}, time.Time{}
+    if f.From != "" {
+        from, err := time.Parse(time.RFC3339, f.From)
+        if err != nil {
+            return ErrInvalidTimeRange
+        }
+        f.from = from
+    }
+    if f.To != "" {
+        to, err := time.Parse(time.RFC3339, f.To)
+        if err != nil {
+            return ErrInvalidTimeRange
+        }
+        f.to = to
+    }
+    if !f.from.IsZero() && !f.to.IsZero() && !f.from.Before(f.to) {
+        return ErrInvalidTimeRange
+    }
+    f.resolution = RollupHourly
+    if f.Resolution != "" {
+        f.resolution = RollupResolution(f.Resolution)
+        if f.resolution != RollupFiveMinutes && f.resolution != RollupHourly {
+            return ErrInvalidResolution
+        }
+    }
+    return nil
+}
+
+// match selects the rollups of the vehicles and periods of the filter
+func (f *TrackingRollupFilter) match(ctx context.Context) bson.M {
+    match := scopeTenant(ctx, bson.M{})
+    switch {
+    case f.vehicleIDs != nil && !f.vehicleID.IsZero():
+        match["vehicle_id"] = bson.M{"$in": intersect(f.vehicleIDs, f.vehicleID)}
+    case f.vehicleIDs != nil:
+        match["vehicle_id"] = bson.M{"$in": f.vehicleIDs}
+    case !f.vehicleID.IsZero():
+        match["vehicle_id"] = f.vehicleID
+    }
+    start := bson.M{}
+    if !f.from.IsZero() {
+        start["$gte"] = f.from
+    }
+    if !f.to.IsZero() {
+        start["$lt"] = f.to
+    }
+    if len(start) > 0 {
+        match["start"] = start
+    }
+    return match
+}
+
+type TrackingRollupRepository interface {
+    // ReplaceRollups stores the rollups computed for the vehicles and periods of the filter, the rollups of these
+    // periods that weren't computed again, because their readings are gone, are deleted. The rollups of every
+    // tenant are stored with their own TenantID.
+    ReplaceRollups(ctx context.Context, filter *TrackingRollupFilter, rollups []*TrackingRollup) error
+    // FindRollups returns the rollups of the filter ordered by vehicle and period, ErrTooManyRollups when there
+    // are more than MaxRollups of them
+    FindRollups(ctx context.Context, filter *TrackingRollupFilter) ([]*TrackingRollup, error)
+    // StreamRollups calls fn for every rollup of the filter ordered by vehicle and period, without a limit
+    StreamRollups(ctx context.Context, filter *TrackingRollupFilter, fn func(rollup *TrackingRollup) error) error
+    // DeleteRollups deletes the rollups of the vehicle starting before before, a zero before deletes all of them
+    DeleteRollups(ctx context.Context, vehicleID primitive.ObjectID, before time.Time) error
+    // FindWatermark returns the time the readings are rolled up until, zero before the first rollup
+    FindWatermark(ctx context.Context) (time.Time, error)
+    // SaveWatermark advances the watermark to at, it never goes back
+    SaveWatermark(ctx context.Context, at time.Time) error
+}
+
+type MongoTrackingRollupRepository struct {
+    db    *mongo.Database
+    state *mongo.Collection
+}
+
+func NewMongoTrackingRollupRepository(db *mongo.Database) *MongoTrackingRollupRepository {
+    return &MongoTrackingRollupRepository{db: db, state: db.Collection("tracking_rollup_state")}
+}
+
+// CreateIndexes creates the unique index of the rollups of every resolution
+func (repo *MongoTrackingRollupRepository) CreateIndexes(ctx context.Context) error {
+    for _, resolution := range []RollupResolution{RollupFiveMinutes, RollupHourly} {
+        _, err := repo.db.Collection(resolution.collection()).Indexes().CreateOne(
+            ctx,
+            mongo.IndexModel{
+                Keys: bson.D{
+                    {Key: "tenant_id", Value: 1},
+                    {Key: "vehicle_id", Value: 1},
+                    {Key: "start", Value: 1},
+                },
+                Options: options.Index().SetUnique(true),
+            },
+        )
+        if err != nil {
+            return classify(err)
+        }
+    }
+    return nil
+}
+
+// ReplaceRollups upserts the rollups and then deletes the rollups of the periods computed before, so instances
+// rolling up the same periods at once don't delete each other's rollups
+func (repo *MongoTrackingRollupRepository) ReplaceRollups(
+    ctx context.Context,
+    filter *TrackingRollupFilter,
+    rollups []*TrackingRollup,
+) error {
+    if err := filter.Build(); err != nil {
+        return err
+    }
+    collection := repo.db.Collection(filter.resolution.collection())
+    computedAt := timestamp.New(time.Now().UTC().Truncate(time.Millisecond))
+    if len(rollups) > 0 {
+        models := make([]mongo.WriteModel, 0, len(rollups))
+        for _, rollup := range rollups {
+            rollup.ComputedAt = computedAt
+            models = append(
+                models, mongo.NewReplaceOneModel().
+                    SetFilter(
+                        bson.M{"tenant_id": rollup.TenantID, "vehicle_id": rollup.VehicleID, "start": rollup.Start},
+                    ).
+                    SetReplacement(rollup).
+                    SetUpsert(true),
+            )
+        }
+        if _, err := collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+            return classify(err)
+        }
+    }
+    match := filter.match(ctx)
+    match["computed_at"] = bson.M{"$lt": computedAt}
+    _, err := collection.DeleteMany(ctx, match)
+    return classify(err)
+}
+
+func (repo *MongoTrackingRollupRepository) FindRollups(
+    ctx context.Context,
+    filter *TrackingRollupFilter,
+) ([]*TrackingRollup, error) {
+    rollups := []*TrackingRollup{}
+    err := repo.streamRollups(
+        ctx, filter, MaxRollups+1, func(rollup *TrackingRollup) error {
+            rollups = append(rollups, rollup)
+            return nil
+        },
+    )
+    if err != nil {
+        return nil, err
+    }
+    if len(rollups) > MaxRollups {
+        return nil, ErrTooManyRollups
+    }
+    return rollups, nil
+}
+
+func (repo *MongoTrackingRollupRepository) StreamRollups(
+    ctx context.Context,
+    filter *TrackingRollupFilter,
+    fn func(rollup *TrackingRollup) error,
+) error {
+    return repo.streamRollups(ctx, filter, 0, fn)
+}
+
+// streamRollups calls fn for up to limit rollups of the filter, all of them with a zero limit
+func (repo *MongoTrackingRollupRepository) streamRollups(
+    ctx context.Context,
+    filter *TrackingRollupFilter,
+    limit int64,
+    fn func(rollup *TrackingRollup) error,
+) error {
+    if err := filter.Build(); err != nil {
+        return err
+    }
+    opts := options.Find().
+        SetSort(bson.D{{Key: "vehicle_id", Value: 1}, {Key: "start", Value: 1}}).
+        SetBatchSize(streamBatchSize)
+    if limit > 0 {
+        opts.SetLimit(limit)
+    }
+    cursor, err := repo.db.Collection(filter.resolution.collection()).Find(ctx, filter.match(ctx), opts)
+    if err != nil {
+        return classify(err)
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
+        }
+    }(cursor, ctx)
+
+    for cursor.Next(ctx) {
+        var rollup TrackingRollup
+        if err := cursor.Decode(&rollup); err != nil {
+            return err
+        }
+        if err := fn(&rollup); err != nil {
+            return err
+        }
+    }
+    return classify(cursor.Err())
+}
+
+func (repo *MongoTrackingRollupRepository) DeleteRollups(
+    ctx context.Context,
+    vehicleID primitive.ObjectID,
+    before time.Time,
+) error {
+    match := scopeTenant(ctx, bson.M{"vehicle_id": vehicleID})
+    if !before.IsZero() {
+        match["start"] = bson.M{"$lt": before}
+    }
+    for _, resolution := range []RollupResolution{RollupFiveMinutes, RollupHourly} {
+        if _, err := repo.db.Collection(resolution.collection()).DeleteMany(ctx, match); err != nil {
+            return classify(err)
+        }
+    }
+    return nil
+}
+
+func (repo *MongoTrackingRollupRepository) FindWatermark(ctx context.Context) (time.Time, error) {
+    var state struct {
+        At time.Time `bson:"at"`
+    }
+    err := repo.state.FindOne(ctx, bson.M{"_id": "watermark"}).Decode(&state)
+    if errors.Is(err, mongo.ErrNoDocuments) {
+        return time.Time{}, nil
+    }
+    if err != nil {
+        return time.Time{}, classify(err)
+    }
+    return state.At.UTC(), nil
+}
+
+func (repo *MongoTrackingRollupRepository) SaveWatermark(ctx context.Context, at time.Time) error {
+    _, err := repo.state.UpdateOne(
+        ctx,
+        bson.M{"_id": "watermark"},
+        bson.M{"$max": bson.M{"at": at}},
+        options.Update().SetUpsert(true),
+    )
+    return classify(err)
+}
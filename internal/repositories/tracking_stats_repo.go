@@ -0,0 +1,383 @@
+package repositories
+
+import (
+    "context"
+    "log"
+    "slices"
+    "sort"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// VehicleStats is the aggregated tracking data of a vehicle over a time range
+type VehicleStats struct {
+    VehicleID    primitive.ObjectID `json:"vehicle_id"`
+    Readings     int64              `json:"readings"`
+    FirstSeen    timestamp.Time     `json:"first_seen"`
+    LastSeen     timestamp.Time     `json:"last_seen"`
+    MileageStart float64            `json:"mileage_start"`
+    MileageEnd   float64            `json:"mileage_end"`
+    MileageDelta float64            `json:"mileage_delta"`
+    // DistanceMeters is the distance traveled according to the coordinates, to cross-validate MileageDelta
+    DistanceMeters float64 `json:"distance_meters"`
+    // ActiveDays is the number of distinct UTC days with at least one reading
+    ActiveDays int `json:"active_days"`
+    // FuelConditions is the share of readings per fuel condition, the shares add up to 1
+    FuelConditions map[string]float64 `json:"fuel_conditions"`
+    Statuses       map[string]int64   `json:"statuses"`
+}
+
+type TrackingStatsFilter struct {
+    VehicleID string `json:"vehicle_id"`
+    From      string `json:"from"`
+    To        string `json:"to"`
+    Exclude   string `json:"exclude"`
+
+    vehicleID  primitive.ObjectID
+    vehicleIDs []primitive.ObjectID
+    from       time.Time
+    to         time.Time
+    excluded   []string
+}
+
+// RestrictVehicles limits the stats to the given vehicles, on top of the vehicle_id filter
+func (f *TrackingStatsFilter) RestrictVehicles(vehicleIDs []primitive.ObjectID) {
+    f.vehicleIDs = vehicleIDs
+}
+
+func (f *TrackingStatsFilter) Build() error {
+    if f.VehicleID != "" {
+        id, err := primitive.ObjectIDFromHex(f.VehicleID)
+        if err != nil {
+            return ErrInvalidID
+        }
+        f.vehicleID = id
+    }
+    if f.From != "" {
+        from, err := time.Parse(time.RFC3339, f.From)
+        if err != nil {
+            return ErrInvalidTimeRange
+        }
+        f.from = from
+    }
+    if f.To != "" {
+        to, err := time.Parse(time.RFC3339, f.To)
+        if err != nil {
+            return ErrInvalidTimeRange
+        }
+        f.to = to
+    }
+    if !f.from.IsZero() && !f.to.IsZero() && !f.from.Before(f.to) {
+        return ErrInvalidTimeRange
+    }
+    excluded, err := excludedFlags(f.Exclude)
+    if err != nil {
+        return err
+    }
+    f.excluded = excluded
+    return nil
+}
+
+// ZoneCount is the number of distinct vehicles and readings in a zone of a grid during an hour. The zone is the
+// cell [LatIndex, LatIndex+1) x [LngIndex, LngIndex+1) in units of the grid size.
+type ZoneCount struct {
+    LatIndex int64     `bson:"lat_index"`
+    LngIndex int64     `bson:"lng_index"`
+    Hour     time.Time `bson:"hour"`
+    Vehicles int64     `bson:"vehicles"`
+    Readings int64     `bson:"readings"`
+}
+
+type TrackingStatsRepository interface {
+    FindVehicleStats(ctx context.Context, filter *TrackingStatsFilter) ([]*VehicleStats, error)
+    // FindZoneCounts counts the readings with coordinates created in [from, to) per zone of a grid of zoneDegrees
+    // and per hour, the readings excluded by default are left out
+    FindZoneCounts(ctx context.Context, from, to time.Time, zoneDegrees float64) ([]*ZoneCount, error)
+    // FindHeatmapTiles counts the readings and vehicles per web mercator tile of the bbox, the busiest tiles first
+    FindHeatmapTiles(ctx context.Context, filter *TrackingHeatmapFilter) ([]*HeatmapTile, error)
+}
+
+type MongoTrackingStatsRepository struct {
+    collection *mongo.Collection
+    // partitions is nil when the tracking data is stored in a single collection
+    partitions *TrackingPartitions
+}
+
+func NewMongoTrackingStatsRepository(db *mongo.Database) *MongoTrackingStatsRepository {
+    return &MongoTrackingStatsRepository{
+        collection: db.Collection(trackingCollection),
+    }
+}
+
+// NewPartitionedMongoTrackingStatsRepository aggregates the monthly partitions overlapping the time range
+func NewPartitionedMongoTrackingStatsRepository(
+    db *mongo.Database,
+    partitions *TrackingPartitions,
+) *MongoTrackingStatsRepository {
+    return &MongoTrackingStatsRepository{
+        collection: db.Collection(trackingCollection),
+        partitions: partitions,
+    }
+}
+
+type vehicleSummary struct {
+    VehicleID    primitive.ObjectID `bson:"_id"`
+    Readings     int64              `bson:"readings"`
+    FirstSeen    time.Time          `bson:"first_seen"`
+    LastSeen     time.Time          `bson:"last_seen"`
+    MileageStart float64            `bson:"mileage_start"`
+    MileageEnd   float64            `bson:"mileage_end"`
+    OdometerMin  *float64           `bson:"odometer_min"`
+    OdometerMax  *float64           `bson:"odometer_max"`
+    Days         []string           `bson:"days"`
+}
+
+type vehicleValueCount struct {
+    ID struct {
+        VehicleID primitive.ObjectID `bson:"vehicle_id"`
+        Value     string             `bson:"value"`
+    } `bson:"_id"`
+    Count int64 `bson:"count"`
+}
+
+// FindVehicleStats aggregates the tracking data per vehicle in a single pipeline, the facets compute the summary,
+// fuel condition and status counts over the same matched documents. Vehicles are ordered by id.
+func (repo *MongoTrackingStatsRepository) FindVehicleStats(
+    ctx context.Context,
+    filter *TrackingStatsFilter,
+) ([]*VehicleStats, error) {
+    if err := filter.Build(); err != nil {
+        return nil, err
+    }
+
+    match := excludeFlagged(notDeleted(bson.M{}), filter.excluded)
+    if !filter.vehicleID.IsZero() {
+        match["vehicle_id"] = filter.vehicleID
+    }
+    if filter.vehicleIDs != nil {
+        vehicleIDs := filter.vehicleIDs
+        if !filter.vehicleID.IsZero() {
+            vehicleIDs = slices.DeleteFunc(
+                slices.Clone(vehicleIDs), func(id primitive.ObjectID) bool {
+                    return id != filter.vehicleID
+                },
+            )
+        }
+        match["vehicle_id"] = bson.M{"$in": vehicleIDs}
+    }
+    scopeTenant(ctx, match)
+    if !filter.from.IsZero() || !filter.to.IsZero() {
+        createdAt := bson.M{}
+        if !filter.from.IsZero() {
+            createdAt["$gte"] = filter.from
+        }
+        if !filter.to.IsZero() {
+            createdAt["$lt"] = filter.to
+        }
+        match["created_at"] = createdAt
+    }
+
+    countBy := func(field string) bson.A {
+        return bson.A{
+            bson.M{
+                "$group": bson.M{
+                    "_id":   bson.M{"vehicle_id": "$vehicle_id", "value": "$" + field},
+                    "count": bson.M{"$sum": 1},
+                },
+            },
+        }
+    }
+
+    pipeline := mongo.Pipeline{
+        {{Key: "$match", Value: match}},
+        // sorting first, so $first and $last pick the earliest and latest mileage
+        {{Key: "$sort", Value: bson.D{{Key: "vehicle_id", Value: 1}, {Key: "created_at", Value: 1}}}},
+        {{
+            Key: "$facet", Value: bson.M{
+                "summary": bson.A{
+                    bson.M{
+                        "$group": bson.M{
+                            "_id":           "$vehicle_id",
+                            "readings":      bson.M{"$sum": 1},
+                            "first_seen":    bson.M{"$first": "$created_at"},
+                            "last_seen":     bson.M{"$last": "$created_at"},
+                            "mileage_start": bson.M{"$first": "$mileage"},
+                            "mileage_end":   bson.M{"$last": "$mileage"},
+                            "odometer_min":  bson.M{"$min": "$odometer_meters"},
+                            "odometer_max":  bson.M{"$max": "$odometer_meters"},
+                            "days": bson.M{
+                                "$addToSet": bson.M{
+                                    "$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$created_at"},
+                                },
+                            },
+                        },
+                    },
+                },
+                "fuel_conditions": countBy("fuel_condition"),
+                "statuses":        countBy("status"),
+            },
+        }},
+    }
+
+    collections := []*mongo.Collection{repo.collection}
+    if repo.partitions != nil {
+        var err error
+        if collections, err = repo.partitions.Collections(ctx, filter.from, filter.to); err != nil {
+            return nil, err
+        }
+    }
+    cursor, err := collections[0].Aggregate(
+        ctx,
+        unionPipeline(collections, pipeline),
+        options.Aggregate().SetAllowDiskUse(true),
+    )
+    if err != nil {
+        return nil, err
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
+        }
+    }(cursor, ctx)
+
+    var result struct {
+        Summary        []vehicleSummary    `bson:"summary"`
+        FuelConditions []vehicleValueCount `bson:"fuel_conditions"`
+        Statuses       []vehicleValueCount `bson:"statuses"`
+    }
+    if cursor.Next(ctx) {
+        if err := cursor.Decode(&result); err != nil {
+            return nil, err
+        }
+    }
+    if err := cursor.Err(); err != nil {
+        return nil, err
+    }
+
+    byVehicle := make(map[primitive.ObjectID]*VehicleStats, len(result.Summary))
+    stats := make([]*VehicleStats, 0, len(result.Summary))
+    for _, summary := range result.Summary {
+        vehicle := &VehicleStats{
+            VehicleID:      summary.VehicleID,
+            Readings:       summary.Readings,
+            FirstSeen:      timestamp.New(summary.FirstSeen),
+            LastSeen:       timestamp.New(summary.LastSeen),
+            MileageStart:   summary.MileageStart,
+            MileageEnd:     summary.MileageEnd,
+            MileageDelta:   summary.MileageEnd - summary.MileageStart,
+            ActiveDays:     len(summary.Days),
+            FuelConditions: map[string]float64{},
+            Statuses:       map[string]int64{},
+        }
+        if summary.OdometerMin != nil && summary.OdometerMax != nil {
+            vehicle.DistanceMeters = *summary.OdometerMax - *summary.OdometerMin
+        }
+        byVehicle[summary.VehicleID] = vehicle
+        stats = append(stats, vehicle)
+    }
+    for _, count := range result.FuelConditions {
+        if vehicle, ok := byVehicle[count.ID.VehicleID]; ok && vehicle.Readings > 0 {
+            vehicle.FuelConditions[count.ID.Value] = float64(count.Count) / float64(vehicle.Readings)
+        }
+    }
+    for _, count := range result.Statuses {
+        if vehicle, ok := byVehicle[count.ID.VehicleID]; ok {
+            vehicle.Statuses[count.ID.Value] = count.Count
+        }
+    }
+
+    sort.Slice(
+        stats, func(i, j int) bool {
+            return stats[i].VehicleID.Hex() < stats[j].VehicleID.Hex()
+        },
+    )
+    return stats, nil
+}
+
+func (repo *MongoTrackingStatsRepository) FindZoneCounts(
+    ctx context.Context,
+    from, to time.Time,
+    zoneDegrees float64,
+) ([]*ZoneCount, error) {
+    excluded, err := excludedFlags("")
+    if err != nil {
+        return nil, err
+    }
+    match := scopeTenant(ctx, excludeFlagged(notDeleted(bson.M{}), excluded))
+    match["created_at"] = bson.M{"$gte": from, "$lt": to}
+    match["lat"] = bson.M{"$ne": nil}
+    match["lng"] = bson.M{"$ne": nil}
+
+    index := func(field string) bson.M {
+        return bson.M{"$toLong": bson.M{"$floor": bson.M{"$divide": bson.A{"$" + field, zoneDegrees}}}}
+    }
+    pipeline := mongo.Pipeline{
+        {{Key: "$match", Value: match}},
+        {{
+            Key: "$group",
+            Value: bson.M{
+                "_id": bson.M{
+                    "lat_index": index("lat"),
+                    "lng_index": index("lng"),
+                    "hour":      bson.M{"$dateTrunc": bson.M{"date": "$created_at", "unit": "hour"}},
+                },
+                "vehicles": bson.M{"$addToSet": "$vehicle_id"},
+                "readings": bson.M{"$sum": 1},
+            },
+        }},
+        {{
+            Key: "$project",
+            Value: bson.M{
+                "_id":       0,
+                "lat_index": "$_id.lat_index",
+                "lng_index": "$_id.lng_index",
+                "hour":      "$_id.hour",
+                "vehicles":  bson.M{"$size": "$vehicles"},
+                "readings":  1,
+            },
+        }},
+        {{
+            Key:   "$sort",
+            Value: bson.D{{Key: "hour", Value: 1}, {Key: "lat_index", Value: 1}, {Key: "lng_index", Value: 1}},
+        }},
+    }
+
+    collections := []*mongo.Collection{repo.collection}
+    if repo.partitions != nil {
+        if collections, err = repo.partitions.Collections(ctx, from, to); err != nil {
+            return nil, err
+        }
+        if len(collections) == 0 {
+            return nil, nil
+        }
+    }
+    cursor, err := collections[0].Aggregate(
+        ctx,
+        unionPipeline(collections, pipeline),
+        options.Aggregate().SetAllowDiskUse(true),
+    )
+    if err != nil {
+        return nil, err
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
+        }
+    }(cursor, ctx)
+    var counts []*ZoneCount
+    for cursor.Next(ctx) {
+        var count ZoneCount
+        if err := cursor.Decode(&count); err != nil {
+            return nil, err
+        }
+        counts = append(counts, &count)
+    }
+    return counts, cursor.Err()
+}
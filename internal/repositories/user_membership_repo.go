@@ -0,0 +1,96 @@
+package repositories
+
+import (
+    "context"
+    "log"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// UserMembership is the tenant, organization and fleet groups a user of the auth service belongs to, the tenant and
+// the claims of the access control are built from it instead of anything the client sends
+type UserMembership struct {
+    UserID         string         `json:"user_id" bson:"_id"`
+    TenantID       string         `json:"tenant_id,omitempty" bson:"tenant_id,omitempty"`
+    OrganizationID string         `json:"organization_id" bson:"organization_id"`
+    FleetGroupIDs  []string       `json:"fleet_group_ids" bson:"fleet_group_ids"`
+    UpdatedAt      timestamp.Time `json:"updated_at" bson:"updated_at"`
+}
+
+type UserMembershipRepository interface {
+    // FindMembership returns the membership of the user in any tenant, ErrNotFound when the user doesn't belong to
+    // any. The tenant of a request is resolved with it, so it can't be limited to one.
+    FindMembership(ctx context.Context, userID string) (*UserMembership, error)
+    // FindMemberships returns the memberships of the tenant of the context
+    FindMemberships(ctx context.Context) ([]*UserMembership, error)
+    UpsertMembership(ctx context.Context, membership *UserMembership) error
+}
+
+type MongoUserMembershipRepository struct {
+    collection *mongo.Collection
+}
+
+func NewMongoUserMembershipRepository(db *mongo.Database) *MongoUserMembershipRepository {
+    return &MongoUserMembershipRepository{collection: db.Collection("user_memberships")}
+}
+
+func (repo *MongoUserMembershipRepository) FindMembership(
+    ctx context.Context,
+    userID string,
+) (*UserMembership, error) {
+    var membership UserMembership
+    if err := repo.collection.FindOne(ctx, bson.M{"_id": userID}).Decode(&membership); err != nil {
+        return nil, classify(err)
+    }
+    return &membership, nil
+}
+
+func (repo *MongoUserMembershipRepository) FindMemberships(ctx context.Context) ([]*UserMembership, error) {
+    var memberships []*UserMembership
+    cursor, err := repo.collection.Find(
+        ctx,
+        scopeTenant(ctx, bson.M{}),
+        options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}),
+    )
+    if err != nil {
+        return nil, classify(err)
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
+        }
+    }(cursor, ctx)
+    for cursor.Next(ctx) {
+        var membership UserMembership
+        if err := cursor.Decode(&membership); err != nil {
+            return nil, err
+        }
+        memberships = append(memberships, &membership)
+    }
+    return memberships, cursor.Err()
+}
+
+func (repo *MongoUserMembershipRepository) UpsertMembership(ctx context.Context, membership *UserMembership) error {
+    if membership.FleetGroupIDs == nil {
+        membership.FleetGroupIDs = []string{}
+    }
+    membership.UpdatedAt = timestamp.Now()
+    _, err := repo.collection.UpdateOne(
+        ctx,
+        bson.M{"_id": membership.UserID},
+        bson.M{
+            "$set": bson.M{
+                "tenant_id":       membership.TenantID,
+                "organization_id": membership.OrganizationID,
+                "fleet_group_ids": membership.FleetGroupIDs,
+                "updated_at":      membership.UpdatedAt,
+            },
+        },
+        options.Update().SetUpsert(true),
+    )
+    return classify(err)
+}
@@ -0,0 +1,309 @@
+package repositories
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/ulid"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+    // SuggestionPending suggestions wait for the vehicle service or an operator to act on them
+    SuggestionPending = "pending"
+    // SuggestionAccepted suggestions were applied to the fleet records
+    SuggestionAccepted = "accepted"
+    // SuggestionDismissed suggestions were rejected, e.g. for a vehicle parked on purpose
+    SuggestionDismissed = "dismissed"
+    // SuggestionMoved suggestions were closed because the vehicle moved again before anyone acted on them
+    SuggestionMoved = "moved"
+)
+
+var (
+    ErrSuggestionNotFound = fmt.Errorf("status suggestion %w", ErrNotFound)
+    ErrSuggestionResolved = errors.New("status suggestion is no longer pending")
+)
+
+// VehicleActivity is the latest reading of a vehicle and when it last moved, a reading moves the vehicle when its
+// mileage is higher or its position is another than the ones of the previous reading
+type VehicleActivity struct {
+    VehicleID primitive.ObjectID   `json:"vehicle_id" bson:"vehicle_id"`
+    TenantID  string               `json:"tenant_id,omitempty" bson:"tenant_id,omitempty"`
+    Status    models.VehicleStatus `json:"status" bson:"status"`
+    Mileage   float64              `json:"mileage" bson:"mileage"`
+    Lat       *float64             `json:"lat,omitempty" bson:"lat,omitempty"`
+    Lng       *float64             `json:"lng,omitempty" bson:"lng,omitempty"`
+    MovedAt   timestamp.Time       `json:"moved_at" bson:"moved_at"`
+    SeenAt    timestamp.Time       `json:"seen_at" bson:"seen_at"`
+}
+
+// StatusSuggestion suggests changing the status of a vehicle in the fleet records, a vehicle is suggested once
+// per time it stops moving
+type StatusSuggestion struct {
+    ID        primitive.ObjectID   `json:"id" bson:"_id,omitempty"`
+    PublicID  string               `json:"public_id,omitempty" bson:"public_id,omitempty"`
+    TenantID  string               `json:"tenant_id,omitempty" bson:"tenant_id,omitempty"`
+    VehicleID primitive.ObjectID   `json:"vehicle_id" bson:"vehicle_id"`
+    Status    models.VehicleStatus `json:"status" bson:"status"`
+    Reason    string               `json:"reason" bson:"reason"`
+    // CurrentStatus is the status of the latest reading of the vehicle
+    CurrentStatus models.VehicleStatus `json:"current_status" bson:"current_status"`
+    MovedAt       timestamp.Time       `json:"moved_at" bson:"moved_at"`
+    SeenAt        timestamp.Time       `json:"seen_at" bson:"seen_at"`
+    State         string               `json:"state" bson:"state"`
+    CreatedAt     timestamp.Time       `json:"created_at" bson:"created_at"`
+    ResolvedAt    *timestamp.Time      `json:"resolved_at,omitempty" bson:"resolved_at,omitempty"`
+}
+
+type StatusSuggestionFilter struct {
+    Page      int    `json:"page"`
+    PageSize  int    `json:"limit"`
+    VehicleID string `json:"vehicle_id"`
+    State     string `json:"state" doc:"pending (the default), accepted, dismissed or moved"`
+
+    vehicleID primitive.ObjectID
+}
+
+func (f *StatusSuggestionFilter) Build() error {
+    if f.Page == 0 {
+        f.Page = 1
+    }
+    f.PageSize = pageSize(f.PageSize)
+    if f.State == "" {
+        f.State = SuggestionPending
+    }
+    if f.VehicleID != "" {
+        id, err := primitive.ObjectIDFromHex(f.VehicleID)
+        if err != nil {
+            return ErrInvalidID
+        }
+        f.vehicleID = id
+    }
+    return nil
+}
+
+type VehicleActivityRepository interface {
+    FindActivity(ctx context.Context, vehicleID primitive.ObjectID) (*VehicleActivity, error)
+    SaveActivity(ctx context.Context, activity *VehicleActivity) error
+    // FindInactive returns the vehicles of every tenant that didn't move since before
+    FindInactive(ctx context.Context, before time.Time) ([]*VehicleActivity, error)
+    // RecordSuggestion stores the suggestion unless the vehicle already had one since it last moved, so a dismissed
+    // suggestion isn't repeated, created is false then
+    RecordSuggestion(ctx context.Context, suggestion *StatusSuggestion) (created bool, err error)
+    FindSuggestions(ctx context.Context, filter *StatusSuggestionFilter) ([]*StatusSuggestion, error)
+    // ResolveSuggestion moves a pending suggestion to the state, by its ObjectID or ULID
+    ResolveSuggestion(ctx context.Context, id string, state string) (*StatusSuggestion, error)
+    // ResolveVehicleSuggestions moves the pending suggestions of the vehicle to the state
+    ResolveVehicleSuggestions(ctx context.Context, vehicleID primitive.ObjectID, state string) error
+}
+
+type MongoVehicleActivityRepository struct {
+    activities  *mongo.Collection
+    suggestions *mongo.Collection
+}
+
+func NewMongoVehicleActivityRepository(db *mongo.Database) *MongoVehicleActivityRepository {
+    return &MongoVehicleActivityRepository{
+        activities:  db.Collection("vehicle_activities"),
+        suggestions: db.Collection("status_suggestions"),
+    }
+}
+
+// FindActivity returns the activity of the vehicle, nil before its first reading
+func (repo *MongoVehicleActivityRepository) FindActivity(
+    ctx context.Context,
+    vehicleID primitive.ObjectID,
+) (*VehicleActivity, error) {
+    var activity VehicleActivity
+    err := repo.activities.FindOne(ctx, scopeTenant(ctx, bson.M{"vehicle_id": vehicleID})).Decode(&activity)
+    if errors.Is(err, mongo.ErrNoDocuments) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    return &activity, nil
+}
+
+func (repo *MongoVehicleActivityRepository) SaveActivity(ctx context.Context, activity *VehicleActivity) error {
+    activity.TenantID = tenantOf(ctx)
+    _, err := repo.activities.ReplaceOne(
+        ctx,
+        scopeTenant(ctx, bson.M{"vehicle_id": activity.VehicleID}),
+        activity,
+        options.Replace().SetUpsert(true),
+    )
+    return err
+}
+
+func (repo *MongoVehicleActivityRepository) FindInactive(
+    ctx context.Context,
+    before time.Time,
+) ([]*VehicleActivity, error) {
+    var activities []*VehicleActivity
+    cursor, err := repo.activities.Find(
+        ctx,
+        bson.M{"moved_at": bson.M{"$lt": before}},
+        options.Find().SetSort(bson.D{{Key: "moved_at", Value: 1}}),
+    )
+    if err != nil {
+        return nil, err
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
+        }
+    }(cursor, ctx)
+    for cursor.Next(ctx) {
+        var activity VehicleActivity
+        if err := cursor.Decode(&activity); err != nil {
+            return nil, err
+        }
+        activities = append(activities, &activity)
+    }
+    return activities, cursor.Err()
+}
+
+func (repo *MongoVehicleActivityRepository) RecordSuggestion(
+    ctx context.Context,
+    suggestion *StatusSuggestion,
+) (bool, error) {
+    if suggestion.CreatedAt.IsZero() {
+        suggestion.CreatedAt = timestamp.Now()
+    }
+    if suggestion.PublicID == "" {
+        suggestion.PublicID = ulid.New(suggestion.CreatedAt.Time)
+    }
+    suggestion.TenantID = tenantOf(ctx)
+    suggestion.State = SuggestionPending
+    // the tenant, vehicle and time it last moved of the filter are set on the inserted suggestion
+    result, err := repo.suggestions.UpdateOne(
+        ctx,
+        scopeTenant(ctx, bson.M{"vehicle_id": suggestion.VehicleID, "moved_at": suggestion.MovedAt}),
+        bson.M{
+            "$setOnInsert": bson.M{
+                "public_id":      suggestion.PublicID,
+                "status":         suggestion.Status,
+                "reason":         suggestion.Reason,
+                "current_status": suggestion.CurrentStatus,
+                "state":          suggestion.State,
+                "seen_at":        suggestion.SeenAt,
+                "created_at":     suggestion.CreatedAt,
+            },
+        },
+        options.Update().SetUpsert(true),
+    )
+    if err != nil {
+        return false, err
+    }
+    if result.UpsertedID == nil {
+        return false, nil
+    }
+    suggestion.ID = result.UpsertedID.(primitive.ObjectID)
+    return true, nil
+}
+
+func (repo *MongoVehicleActivityRepository) FindSuggestions(
+    ctx context.Context,
+    filter *StatusSuggestionFilter,
+) ([]*StatusSuggestion, error) {
+    if filter == nil {
+        filter = &StatusSuggestionFilter{}
+    }
+    if err := filter.Build(); err != nil {
+        return nil, err
+    }
+    match := scopeTenant(ctx, bson.M{"state": filter.State})
+    if !filter.vehicleID.IsZero() {
+        match["vehicle_id"] = filter.vehicleID
+    }
+    findOptions := options.Find().
+        SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+        SetSkip(int64((filter.Page - 1) * filter.PageSize)).
+        SetLimit(int64(filter.PageSize))
+
+    var suggestions []*StatusSuggestion
+    cursor, err := repo.suggestions.Find(ctx, match, findOptions)
+    if err != nil {
+        return nil, err
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
+        }
+    }(cursor, ctx)
+    for cursor.Next(ctx) {
+        var suggestion StatusSuggestion
+        if err := cursor.Decode(&suggestion); err != nil {
+            return nil, err
+        }
+        suggestions = append(suggestions, &suggestion)
+    }
+    return suggestions, cursor.Err()
+}
+
+func (repo *MongoVehicleActivityRepository) ResolveSuggestion(
+    ctx context.Context,
+    id string,
+    state string,
+) (*StatusSuggestion, error) {
+    objectID, publicID, err := parseID(id)
+    if err != nil {
+        return nil, err
+    }
+    match := scopeTenant(ctx, bson.M{})
+    if publicID != "" {
+        match["public_id"] = publicID
+    } else {
+        match["_id"] = objectID
+    }
+
+    var suggestion StatusSuggestion
+    if err = repo.suggestions.FindOne(ctx, match).Decode(&suggestion); errors.Is(err, mongo.ErrNoDocuments) {
+        return nil, ErrSuggestionNotFound
+    } else if err != nil {
+        return nil, err
+    }
+    if suggestion.State != SuggestionPending {
+        return nil, ErrSuggestionResolved
+    }
+
+    resolvedAt := timestamp.Now()
+    result, err := repo.suggestions.UpdateOne(
+        ctx,
+        bson.M{"_id": suggestion.ID, "state": SuggestionPending},
+        bson.M{"$set": bson.M{"state": state, "resolved_at": resolvedAt}},
+    )
+    if err != nil {
+        return nil, err
+    }
+    // another resolution came first
+    if result.ModifiedCount == 0 {
+        return nil, ErrSuggestionResolved
+    }
+    suggestion.State, suggestion.ResolvedAt = state, &resolvedAt
+    return &suggestion, nil
+}
+
+func (repo *MongoVehicleActivityRepository) ResolveVehicleSuggestions(
+    ctx context.Context,
+    vehicleID primitive.ObjectID,
+    state string,
+) error {
+    _, err := repo.suggestions.UpdateMany(
+        ctx,
+        scopeTenant(ctx, bson.M{"vehicle_id": vehicleID, "state": SuggestionPending}),
+        bson.M{"$set": bson.M{"state": state, "resolved_at": timestamp.Now()}},
+    )
+    return err
+}
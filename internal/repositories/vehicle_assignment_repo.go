@@ -0,0 +1,122 @@
+package repositories
+
+import (
+    "context"
+    "log"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// VehicleAssignment is the organization and fleet groups a vehicle is assigned to,
+// users only see the tracking data of the vehicles assigned to their organization or fleet groups
+type VehicleAssignment struct {
+    VehicleID      primitive.ObjectID `json:"vehicle_id" bson:"_id"`
+    OrganizationID string             `json:"organization_id" bson:"organization_id"`
+    FleetGroupIDs  []string           `json:"fleet_group_ids" bson:"fleet_group_ids"`
+    UpdatedAt      timestamp.Time     `json:"updated_at" bson:"updated_at"`
+}
+
+type VehicleAssignmentRepository interface {
+    // FindAssignedVehicles returns the vehicles assigned to the organization or any of the fleet groups
+    FindAssignedVehicles(
+        ctx context.Context,
+        organizationID string,
+        fleetGroupIDs []string,
+    ) ([]primitive.ObjectID, error)
+    FindAssignments(ctx context.Context) ([]*VehicleAssignment, error)
+    UpsertAssignment(ctx context.Context, assignment *VehicleAssignment) error
+}
+
+type MongoVehicleAssignmentRepository struct {
+    collection *mongo.Collection
+}
+
+func NewMongoVehicleAssignmentRepository(db *mongo.Database) *MongoVehicleAssignmentRepository {
+    return &MongoVehicleAssignmentRepository{
+        collection: db.Collection("vehicle_assignments"),
+    }
+}
+
+func (repo *MongoVehicleAssignmentRepository) FindAssignedVehicles(
+    ctx context.Context,
+    organizationID string,
+    fleetGroupIDs []string,
+) ([]primitive.ObjectID, error) {
+    vehicleIDs := make([]primitive.ObjectID, 0)
+    var or bson.A
+    if organizationID != "" {
+        or = append(or, bson.M{"organization_id": organizationID})
+    }
+    if len(fleetGroupIDs) > 0 {
+        or = append(or, bson.M{"fleet_group_ids": bson.M{"$in": fleetGroupIDs}})
+    }
+    if len(or) == 0 {
+        return vehicleIDs, nil
+    }
+
+    assignments, err := repo.find(ctx, bson.M{"$or": or}, options.Find().SetProjection(bson.M{"_id": 1}))
+    if err != nil {
+        return nil, err
+    }
+    for _, assignment := range assignments {
+        vehicleIDs = append(vehicleIDs, assignment.VehicleID)
+    }
+    return vehicleIDs, nil
+}
+
+func (repo *MongoVehicleAssignmentRepository) FindAssignments(ctx context.Context) ([]*VehicleAssignment, error) {
+    return repo.find(ctx, bson.M{}, options.Find())
+}
+
+func (repo *MongoVehicleAssignmentRepository) UpsertAssignment(
+    ctx context.Context,
+    assignment *VehicleAssignment,
+) error {
+    if assignment.FleetGroupIDs == nil {
+        assignment.FleetGroupIDs = []string{}
+    }
+    assignment.UpdatedAt = timestamp.Now()
+    _, err := repo.collection.UpdateOne(
+        ctx,
+        bson.M{"_id": assignment.VehicleID},
+        bson.M{
+            "$set": bson.M{
+                "organization_id": assignment.OrganizationID,
+                "fleet_group_ids": assignment.FleetGroupIDs,
+                "updated_at":      assignment.UpdatedAt,
+            },
+        },
+        options.Update().SetUpsert(true),
+    )
+    return err
+}
+
+func (repo *MongoVehicleAssignmentRepository) find(
+    ctx context.Context,
+    filter bson.M,
+    opts *options.FindOptions,
+) ([]*VehicleAssignment, error) {
+    var assignments []*VehicleAssignment
+    cursor, err := repo.collection.Find(ctx, filter, opts.SetSort(bson.D{{Key: "_id", Value: 1}}))
+    if err != nil {
+        return nil, err
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
+        }
+    }(cursor, ctx)
+    for cursor.Next(ctx) {
+        var assignment VehicleAssignment
+        if err := cursor.Decode(&assignment); err != nil {
+            return nil, err
+        }
+        assignments = append(assignments, &assignment)
+    }
+    return assignments, nil
+}
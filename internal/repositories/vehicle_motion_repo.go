@@ -0,0 +1,79 @@
+package repositories
+
+import (
+    "context"
+    "errors"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// VehicleMotion is the latest position of a vehicle with what the geofence and speeding alerts of its next reading
+// are compared with: the geofences it is in and whether it was speeding
+type VehicleMotion struct {
+    VehicleID   primitive.ObjectID   `json:"vehicle_id" bson:"vehicle_id"`
+    TenantID    string               `json:"tenant_id,omitempty" bson:"tenant_id,omitempty"`
+    Lat         float64              `json:"lat" bson:"lat"`
+    Lng         float64              `json:"lng" bson:"lng"`
+    At          timestamp.Time       `json:"at" bson:"at"`
+    GeofenceIDs []primitive.ObjectID `json:"geofence_ids" bson:"geofence_ids"`
+    Speeding    bool                 `json:"speeding" bson:"speeding"`
+}
+
+type VehicleMotionRepository interface {
+    // FindMotion returns the motion of the vehicle, nil when it has none yet
+    FindMotion(ctx context.Context, vehicleID primitive.ObjectID) (*VehicleMotion, error)
+    SaveMotion(ctx context.Context, motion *VehicleMotion) error
+}
+
+type MongoVehicleMotionRepository struct {
+    collection *mongo.Collection
+}
+
+func NewMongoVehicleMotionRepository(db *mongo.Database) *MongoVehicleMotionRepository {
+    return &MongoVehicleMotionRepository{collection: db.Collection("vehicle_motion")}
+}
+
+// CreateIndexes creates the unique index of the motion of every vehicle
+func (repo *MongoVehicleMotionRepository) CreateIndexes(ctx context.Context) error {
+    _, err := repo.collection.Indexes().CreateOne(
+        ctx,
+        mongo.IndexModel{
+            Keys:    bson.D{{Key: "tenant_id", Value: 1}, {Key: "vehicle_id", Value: 1}},
+            Options: options.Index().SetUnique(true),
+        },
+    )
+    return classify(err)
+}
+
+func (repo *MongoVehicleMotionRepository) FindMotion(
+    ctx context.Context,
+    vehicleID primitive.ObjectID,
+) (*VehicleMotion, error) {
+    var motion VehicleMotion
+    err := repo.collection.FindOne(ctx, scopeTenant(ctx, bson.M{"vehicle_id": vehicleID})).Decode(&motion)
+    if errors.Is(err, mongo.ErrNoDocuments) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, classify(err)
+    }
+    return &motion, nil
+}
+
+func (repo *MongoVehicleMotionRepository) SaveMotion(ctx context.Context, motion *VehicleMotion) error {
+    motion.TenantID = tenantOf(ctx)
+    if motion.GeofenceIDs == nil {
+        motion.GeofenceIDs = []primitive.ObjectID{}
+    }
+    _, err := repo.collection.ReplaceOne(
+        ctx,
+        scopeTenant(ctx, bson.M{"vehicle_id": motion.VehicleID}),
+        motion,
+        options.Replace().SetUpsert(true),
+    )
+    return classify(err)
+}
@@ -0,0 +1,296 @@
+package repositories
+
+import (
+    "context"
+    "log"
+    "strings"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// VehicleState is the current state of a vehicle from its latest reading, the fleet snapshot is read from it
+// instead of the tracking data
+type VehicleState struct {
+    VehicleID primitive.ObjectID `json:"vehicle_id" bson:"vehicle_id"`
+    TenantID  string             `json:"tenant_id,omitempty" bson:"tenant_id,omitempty"`
+    // TrackingDataID is the latest reading of the vehicle
+    TrackingDataID primitive.ObjectID   `json:"tracking_data_id" bson:"tracking_data_id"`
+    Location       string               `json:"location" bson:"location"`
+    Mileage        float64              `json:"mileage" bson:"mileage"`
+    Status         models.VehicleStatus `json:"status" bson:"status"`
+    FuelCondition  models.FuelCondition `json:"fuel_condition" bson:"fuel_condition"`
+    OdometerMeters *float64             `json:"odometer_meters,omitempty" bson:"odometer_meters,omitempty"`
+    // Lat and Lng are the last known position, reported at PositionAt. It is older than LastSeenAt when the latest
+    // readings have no coordinates.
+    Lat        *float64        `json:"lat,omitempty" bson:"lat,omitempty"`
+    Lng        *float64        `json:"lng,omitempty" bson:"lng,omitempty"`
+    PositionAt *timestamp.Time `json:"position_at,omitempty" bson:"position_at,omitempty"`
+    LastSeenAt timestamp.Time  `json:"last_seen_at" bson:"last_seen_at"`
+    // OfflineAt is when the vehicle was found stale, it is cleared by its next reading
+    OfflineAt *timestamp.Time `json:"offline_at,omitempty" bson:"offline_at,omitempty"`
+}
+
+type VehicleStateFilter struct {
+    Page      int    `json:"page"`
+    PageSize  int    `json:"limit"`
+    VehicleID string `json:"vehicle_id" doc:"Comma separated vehicle ids"`
+    Status    string `json:"status"`
+
+    vehicleIDs []primitive.ObjectID
+    restricted []primitive.ObjectID
+    seenBefore time.Time
+}
+
+// RestrictVehicles limits the states to the given vehicles, on top of the vehicle_id filter
+func (f *VehicleStateFilter) RestrictVehicles(vehicleIDs []primitive.ObjectID) {
+    f.restricted = vehicleIDs
+}
+
+// SeenBefore limits the states to the vehicles without a reading since before, the stale ones
+func (f *VehicleStateFilter) SeenBefore(before time.Time) {
+    f.seenBefore = before
+}
+
+func (f *VehicleStateFilter) Build() error {
+    if f.Page == 0 {
+        f.Page = 1
+    }
+    f.PageSize = pageSize(f.PageSize)
+    f.vehicleIDs = nil
+    if f.VehicleID != "" {
+        for _, value := range strings.Split(f.VehicleID, ",") {
+            id, err := primitive.ObjectIDFromHex(strings.TrimSpace(value))
+            if err != nil {
+                return ErrInvalidID
+            }
+            f.vehicleIDs = append(f.vehicleIDs, id)
+        }
+    }
+    return nil
+}
+
+// match selects the states of the vehicles and status of the filter
+func (f *VehicleStateFilter) match(ctx context.Context) bson.M {
+    match := scopeTenant(ctx, bson.M{})
+    vehicleIDs := f.vehicleIDs
+    if f.restricted != nil {
+        vehicleIDs = []primitive.ObjectID{}
+        for _, id := range f.restricted {
+            if f.vehicleIDs == nil || len(intersect(f.vehicleIDs, id)) > 0 {
+                vehicleIDs = append(vehicleIDs, id)
+            }
+        }
+    }
+    if vehicleIDs != nil {
+        match["vehicle_id"] = bson.M{"$in": vehicleIDs}
+    }
+    if f.Status != "" {
+        match["status"] = f.Status
+    }
+    if !f.seenBefore.IsZero() {
+        match["last_seen_at"] = bson.M{"$lt": f.seenBefore}
+    }
+    return match
+}
+
+type VehicleStateRepository interface {
+    // SaveStates updates the states of the vehicles of the stored records, with the tenant of every record. A record
+    // older than the state of its vehicle leaves it as it is.
+    SaveStates(ctx context.Context, records []*TrackingRecord) error
+    // DeleteState deletes the state of the vehicle, before its state is computed again
+    DeleteState(ctx context.Context, vehicleID primitive.ObjectID) error
+    // FindStates returns a page of the states of the filter ordered by vehicle
+    FindStates(ctx context.Context, filter *VehicleStateFilter) ([]*VehicleState, error)
+    // CountStates returns the number of vehicles with a state, of every tenant
+    CountStates(ctx context.Context) (int64, error)
+    // MarkOffline marks the states of the vehicles without a reading since before offline at, of every tenant. It
+    // returns the states it marked, the ones already offline are left out.
+    MarkOffline(ctx context.Context, before, at time.Time) ([]*VehicleState, error)
+}
+
+type MongoVehicleStateRepository struct {
+    collection *mongo.Collection
+}
+
+func NewMongoVehicleStateRepository(db *mongo.Database) *MongoVehicleStateRepository {
+    return &MongoVehicleStateRepository{collection: db.Collection("vehicle_state")}
+}
+
+// CreateIndexes creates the unique index of the state of every vehicle
+func (repo *MongoVehicleStateRepository) CreateIndexes(ctx context.Context) error {
+    _, err := repo.collection.Indexes().CreateOne(
+        ctx,
+        mongo.IndexModel{
+            Keys:    bson.D{{Key: "tenant_id", Value: 1}, {Key: "vehicle_id", Value: 1}},
+            Options: options.Index().SetUnique(true),
+        },
+    )
+    return classify(err)
+}
+
+// SaveStates updates the states with an aggregation pipeline comparing the time of the reading with the stored
+// one, so readings stored concurrently or out of order leave the newest state. Concurrent upserts of a new vehicle
+// fail on the unique index, they are retried once and then find the state of the other write.
+func (repo *MongoVehicleStateRepository) SaveStates(ctx context.Context, records []*TrackingRecord) error {
+    if len(records) == 0 {
+        return nil
+    }
+    writes := make([]mongo.WriteModel, 0, len(records))
+    for _, record := range records {
+        writes = append(
+            writes,
+            mongo.NewUpdateOneModel().
+                SetFilter(stateFilter(record.VehicleID, record.TenantID)).
+                SetUpdate(stateUpdate(record)).
+                SetUpsert(true),
+        )
+    }
+    var err error
+    for range 2 {
+        _, err = repo.collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+        if !mongo.IsDuplicateKeyError(err) {
+            break
+        }
+    }
+    return classify(err)
+}
+
+// stateFilter selects the state of a vehicle of the tenant, of a vehicle without tenant when it is empty
+func stateFilter(vehicleID primitive.ObjectID, tenantID string) bson.M {
+    filter := bson.M{"vehicle_id": vehicleID}
+    if tenantID != "" {
+        filter["tenant_id"] = tenantID
+    }
+    return filter
+}
+
+// stateUpdate sets the fields of the reading when it is newer than the state or the state is of the reading itself,
+// and its position when it has one newer than the position of the state
+func stateUpdate(record *TrackingRecord) mongo.Pipeline {
+    at := record.CreatedAt
+    newer := func(field string) bson.M {
+        return bson.M{"$lt": bson.A{bson.M{"$ifNull": bson.A{"$" + field, time.Time{}}}, at}}
+    }
+    // a reading stored again, like after its odometer was measured again, updates its own state
+    current := bson.M{"$or": bson.A{newer("last_seen_at"), bson.M{"$eq": bson.A{"$tracking_data_id", record.ID}}}}
+    set := bson.M{}
+    // the values are literals, so strings starting with $ aren't read as field paths
+    setIf := func(condition bson.M, field string, value any) {
+        set[field] = bson.M{"$cond": bson.A{condition, bson.M{"$literal": value}, "$" + field}}
+    }
+    fields := bson.D{
+        {Key: "tracking_data_id", Value: record.ID},
+        {Key: "location", Value: record.Location},
+        {Key: "mileage", Value: record.Mileage},
+        {Key: "status", Value: record.Status},
+        {Key: "fuel_condition", Value: record.FuelCondition},
+        {Key: "odometer_meters", Value: record.OdometerMeters},
+        {Key: "last_seen_at", Value: at},
+    }
+    for _, field := range fields {
+        setIf(current, field.Key, field.Value)
+    }
+    // a vehicle reporting again is back online
+    set["offline_at"] = bson.M{"$cond": bson.A{newer("last_seen_at"), "$$REMOVE", "$offline_at"}}
+    if point, ok := record.Point(); ok {
+        setIf(newer("position_at"), "lat", point.Lat)
+        setIf(newer("position_at"), "lng", point.Lng)
+        setIf(newer("position_at"), "position_at", at)
+    }
+    return mongo.Pipeline{{{Key: "$set", Value: set}}}
+}
+
+func (repo *MongoVehicleStateRepository) DeleteState(ctx context.Context, vehicleID primitive.ObjectID) error {
+    _, err := repo.collection.DeleteMany(ctx, scopeTenant(ctx, bson.M{"vehicle_id": vehicleID}))
+    return classify(err)
+}
+
+func (repo *MongoVehicleStateRepository) FindStates(
+    ctx context.Context,
+    filter *VehicleStateFilter,
+) ([]*VehicleState, error) {
+    if filter == nil {
+        filter = &VehicleStateFilter{}
+    }
+    if err := filter.Build(); err != nil {
+        return nil, err
+    }
+    cursor, err := repo.collection.Find(
+        ctx,
+        filter.match(ctx),
+        options.Find().
+            SetSort(bson.D{{Key: "vehicle_id", Value: 1}, {Key: "tenant_id", Value: 1}}).
+            SetSkip(int64((filter.Page-1)*filter.PageSize)).
+            SetLimit(int64(filter.PageSize)),
+    )
+    if err != nil {
+        return nil, classify(err)
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
+        }
+    }(cursor, ctx)
+
+    states := []*VehicleState{}
+    for cursor.Next(ctx) {
+        var state VehicleState
+        if err := cursor.Decode(&state); err != nil {
+            return nil, err
+        }
+        states = append(states, &state)
+    }
+    return states, classify(cursor.Err())
+}
+
+func (repo *MongoVehicleStateRepository) CountStates(ctx context.Context) (int64, error) {
+    count, err := repo.collection.EstimatedDocumentCount(ctx)
+    return count, classify(err)
+}
+
+// MarkOffline marks the states one at a time, only when they are still stale and not offline, so a state updated by a
+// reading meanwhile or marked by another instance is left out
+func (repo *MongoVehicleStateRepository) MarkOffline(
+    ctx context.Context,
+    before, at time.Time,
+) ([]*VehicleState, error) {
+    stale := bson.M{"last_seen_at": bson.M{"$lt": before}, "offline_at": bson.M{"$exists": false}}
+    cursor, err := repo.collection.Find(ctx, stale)
+    if err != nil {
+        return nil, classify(err)
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
+        }
+    }(cursor, ctx)
+
+    var marked []*VehicleState
+    for cursor.Next(ctx) {
+        var state VehicleState
+        if err := cursor.Decode(&state); err != nil {
+            return marked, err
+        }
+        filter := stateFilter(state.VehicleID, state.TenantID)
+        for key, value := range stale {
+            filter[key] = value
+        }
+        result, err := repo.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"offline_at": at}})
+        if err != nil {
+            return marked, classify(err)
+        }
+        if result.ModifiedCount == 1 {
+            state.OfflineAt = timestamp.Ptr(at)
+            marked = append(marked, &state)
+        }
+    }
+    return marked, classify(cursor.Err())
+}
@@ -0,0 +1,107 @@
+package repositories
+
+import (
+    "context"
+    "log"
+    "slices"
+    "time"
+
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// VehicleStateTrackingRepository keeps the state of every vehicle in line with its stored readings, except the suspect
+// and skewed ones. A failure to update a state is logged without failing the write, the next reading of the vehicle
+// updates it again.
+type VehicleStateTrackingRepository struct {
+    TrackingRepository
+    stateRepo VehicleStateRepository
+}
+
+func NewVehicleStateTrackingRepository(
+    trackingRepo TrackingRepository,
+    stateRepo VehicleStateRepository,
+) *VehicleStateTrackingRepository {
+    return &VehicleStateTrackingRepository{TrackingRepository: trackingRepo, stateRepo: stateRepo}
+}
+
+func (repo *VehicleStateTrackingRepository) CreateTrackingData(
+    ctx context.Context,
+    trackingData *TrackingRecord,
+) error {
+    if err := repo.TrackingRepository.CreateTrackingData(ctx, trackingData); err != nil {
+        return err
+    }
+    repo.save(ctx, []*TrackingRecord{trackingData})
+    return nil
+}
+
+func (repo *VehicleStateTrackingRepository) CreateManyTrackingData(
+    ctx context.Context,
+    trackingData []*TrackingRecord,
+) ([]error, error) {
+    errs, err := repo.TrackingRepository.CreateManyTrackingData(ctx, trackingData)
+    if err != nil {
+        return errs, err
+    }
+    stored := make([]*TrackingRecord, 0, len(trackingData))
+    for i, data := range trackingData {
+        if errs == nil || errs[i] == nil {
+            stored = append(stored, data)
+        }
+    }
+    repo.save(ctx, stored)
+    return errs, nil
+}
+
+// DeleteTrackingData computes the state of the vehicle again from the latest reading left, the vehicle has no
+// state once all of its readings are deleted
+func (repo *VehicleStateTrackingRepository) DeleteTrackingData(
+    ctx context.Context,
+    vehicleID primitive.ObjectID,
+    before time.Time,
+    purge bool,
+) (int64, error) {
+    deleted, err := repo.TrackingRepository.DeleteTrackingData(ctx, vehicleID, before, purge)
+    if err != nil || deleted == 0 {
+        return deleted, err
+    }
+    if err := repo.stateRepo.DeleteState(ctx, vehicleID); err != nil {
+        log.Println("Failed to delete the vehicle state", err)
+        return deleted, nil
+    }
+    latest, err := repo.TrackingRepository.FindLatestTrackingData(ctx, []VehicleLimit{{VehicleID: vehicleID, Limit: 1}})
+    if err != nil {
+        log.Println("Failed to find the latest tracking data of the vehicle state", err)
+        return deleted, nil
+    }
+    repo.save(ctx, latest[vehicleID])
+    return deleted, nil
+}
+
+// UpdateTrackingDistances updates the odometer of the states of the updated records
+func (repo *VehicleStateTrackingRepository) UpdateTrackingDistances(
+    ctx context.Context,
+    trackingData []*TrackingRecord,
+) error {
+    if err := repo.TrackingRepository.UpdateTrackingDistances(ctx, trackingData); err != nil {
+        return err
+    }
+    repo.save(ctx, trackingData)
+    return nil
+}
+
+// save updates the states with the records, the suspect ones are left out so the next reading of a vehicle is
+// checked against its last good one and the skewed ones so a clock ahead doesn't hold the state
+func (repo *VehicleStateTrackingRepository) save(ctx context.Context, records []*TrackingRecord) {
+    records = slices.DeleteFunc(
+        slices.Clone(records), func(record *TrackingRecord) bool {
+            return slices.Contains(record.Flags, FlagSuspect) || slices.Contains(record.Flags, FlagSkewed)
+        },
+    )
+    if len(records) == 0 {
+        return
+    }
+    if err := repo.stateRepo.SaveStates(ctx, records); err != nil {
+        log.Println("Failed to update the vehicle state", err)
+    }
+}
@@ -0,0 +1,111 @@
+package repositories
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryStateRepo keeps the latest record of every vehicle like the pipeline of MongoVehicleStateRepository
+type memoryStateRepo struct {
+    latest map[primitive.ObjectID]*TrackingRecord
+}
+
+func (repo *memoryStateRepo) SaveStates(_ context.Context, records []*TrackingRecord) error {
+    for _, record := range records {
+        stored, ok := repo.latest[record.VehicleID]
+        if !ok || stored.CreatedAt.Before(record.CreatedAt) || stored.ID == record.ID {
+            repo.latest[record.VehicleID] = record
+        }
+    }
+    return nil
+}
+
+func (repo *memoryStateRepo) DeleteState(_ context.Context, vehicleID primitive.ObjectID) error {
+    delete(repo.latest, vehicleID)
+    return nil
+}
+
+func (repo *memoryStateRepo) FindStates(context.Context, *VehicleStateFilter) ([]*VehicleState, error) {
+    return nil, nil
+}
+
+func (repo *memoryStateRepo) CountStates(context.Context) (int64, error) {
+    return int64(len(repo.latest)), nil
+}
+
+func (repo *memoryStateRepo) MarkOffline(context.Context, time.Time, time.Time) ([]*VehicleState, error) {
+    return nil, nil
+}
+
+func TestVehicleStateTrackingRepository(t *testing.T) {
+    stateRepo := &memoryStateRepo{latest: map[primitive.ObjectID]*TrackingRecord{}}
+    repo := NewVehicleStateTrackingRepository(NewMemoryTrackingRepository(), stateRepo)
+    ctx := context.Background()
+    vehicleID := primitive.NewObjectID()
+    now := time.Now().Truncate(time.Second)
+
+    record := func(at time.Time, mileage float64) *TrackingRecord {
+        return NewTrackingRecord(
+            &models.TrackingData{
+                ID:            primitive.NewObjectID(),
+                VehicleID:     vehicleID,
+                Location:      "Yangon",
+                Mileage:       mileage,
+                Status:        models.VehicleStatusActive,
+                FuelCondition: models.FuelConditionFull,
+                CreatedAt:     at,
+            },
+        )
+    }
+    older, newer := record(now.Add(-2*time.Hour), 10), record(now.Add(-time.Hour), 20)
+    if err := repo.CreateTrackingData(ctx, newer); err != nil {
+        t.Fatal(err)
+    }
+    // a reading stored out of order leaves the newer state
+    if errs, err := repo.CreateManyTrackingData(ctx, []*TrackingRecord{older}); err != nil || errs[0] != nil {
+        t.Fatal(errs, err)
+    }
+    if state := stateRepo.latest[vehicleID]; state != newer {
+        t.Fatalf("state is %v, want the newer reading", state)
+    }
+
+    // measuring the newer reading again updates its odometer, the older one leaves the state
+    remeasured := *newer
+    remeasured.SetDistance(1000, 5000)
+    olderRemeasured := *older
+    olderRemeasured.SetDistance(1000, 4000)
+    if err := repo.UpdateTrackingDistances(ctx, []*TrackingRecord{&olderRemeasured, &remeasured}); err != nil {
+        t.Fatal(err)
+    }
+    if state := stateRepo.latest[vehicleID]; state.ID != newer.ID || *state.OdometerMeters != 5000 {
+        t.Fatalf("state is %v, want the newer reading measured again", state)
+    }
+
+    // deleting the older readings computes the state again from the latest one left
+    if _, err := repo.DeleteTrackingData(ctx, vehicleID, now.Add(-90*time.Minute), true); err != nil {
+        t.Fatal(err)
+    }
+    if state := stateRepo.latest[vehicleID]; state == nil || state.ID != newer.ID {
+        t.Fatalf("state is %v, want the newer reading", state)
+    }
+
+    // the vehicle has no state once all of its readings are deleted
+    if _, err := repo.DeleteTrackingData(ctx, vehicleID, time.Time{}, true); err != nil {
+        t.Fatal(err)
+    }
+    if state, ok := stateRepo.latest[vehicleID]; ok {
+        t.Fatalf("the state of a vehicle without readings is %v", state)
+    }
+
+    // a suspect reading doesn't update the state
+    if err := repo.CreateTrackingData(ctx, record(now, 5).AddFlag(FlagSuspect)); err != nil {
+        t.Fatal(err)
+    }
+    if state, ok := stateRepo.latest[vehicleID]; ok {
+        t.Fatalf("the state of a suspect reading is %v", state)
+    }
+}
@@ -0,0 +1,163 @@
+package repositories
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "slices"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+    ScopeIngestionErrorsRead = "ingestion_errors:read"
+    ScopeDeviceHealthRead    = "device_health:read"
+)
+
+var (
+    ErrVendorNotFound = fmt.Errorf("vendor %w", ErrNotFound)
+)
+
+// Vendor is a hardware vendor with API access to the devices registered to them.
+// Only the hash of the API key is stored, the key itself is shown once when the vendor is created.
+type Vendor struct {
+    ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+    Name      string             `json:"name" bson:"name"`
+    KeyHash   string             `json:"-" bson:"key_hash"`
+    KeyPrefix string             `json:"key_prefix" bson:"key_prefix"`
+    Scopes    []string           `json:"scopes" bson:"scopes"`
+    DeviceIDs []string           `json:"device_ids" bson:"device_ids"`
+    // RevokedAt is when the API key was revoked, the vendor can't authenticate with it anymore
+    RevokedAt *timestamp.Time `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
+    CreatedAt timestamp.Time  `json:"created_at" bson:"created_at"`
+    UpdatedAt timestamp.Time  `json:"updated_at" bson:"updated_at"`
+}
+
+func (v *Vendor) HasScope(scope string) bool {
+    return slices.Contains(v.Scopes, scope)
+}
+
+func (v *Vendor) OwnsDevice(deviceID string) bool {
+    return slices.Contains(v.DeviceIDs, deviceID)
+}
+
+type VendorRepository interface {
+    CreateVendor(ctx context.Context, vendor *Vendor) error
+    FindVendors(ctx context.Context) ([]*Vendor, error)
+    FindVendorByKeyHash(ctx context.Context, keyHash string) (*Vendor, error)
+    SetVendorDevices(ctx context.Context, id primitive.ObjectID, deviceIDs []string) (*Vendor, error)
+    // RevokeVendorKey revokes the API key of the vendor, the time of the first revocation is kept
+    RevokeVendorKey(ctx context.Context, id primitive.ObjectID) (*Vendor, error)
+}
+
+type MongoVendorRepository struct {
+    collection *mongo.Collection
+}
+
+func NewMongoVendorRepository(db *mongo.Database) *MongoVendorRepository {
+    return &MongoVendorRepository{
+        collection: db.Collection("vendors"),
+    }
+}
+
+func (repo *MongoVendorRepository) CreateVendor(ctx context.Context, vendor *Vendor) error {
+    now := timestamp.Now()
+    vendor.CreatedAt = now
+    vendor.UpdatedAt = now
+    if vendor.DeviceIDs == nil {
+        vendor.DeviceIDs = []string{}
+    }
+    result, err := repo.collection.InsertOne(ctx, vendor)
+    if err != nil {
+        return err
+    }
+    vendor.ID = result.InsertedID.(primitive.ObjectID)
+    return nil
+}
+
+func (repo *MongoVendorRepository) FindVendors(ctx context.Context) ([]*Vendor, error) {
+    var vendors []*Vendor
+    cursor, err := repo.collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+    if err != nil {
+        return nil, err
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
+        }
+    }(cursor, ctx)
+    for cursor.Next(ctx) {
+        var vendor Vendor
+        if err := cursor.Decode(&vendor); err != nil {
+            return nil, err
+        }
+        vendors = append(vendors, &vendor)
+    }
+    return vendors, nil
+}
+
+func (repo *MongoVendorRepository) FindVendorByKeyHash(ctx context.Context, keyHash string) (*Vendor, error) {
+    var vendor Vendor
+    err := repo.collection.FindOne(ctx, bson.M{"key_hash": keyHash}).Decode(&vendor)
+    if errors.Is(err, mongo.ErrNoDocuments) {
+        return nil, ErrVendorNotFound
+    }
+    if err != nil {
+        return nil, err
+    }
+    return &vendor, nil
+}
+
+// SetVendorDevices replaces the devices registered to the vendor
+func (repo *MongoVendorRepository) SetVendorDevices(
+    ctx context.Context,
+    id primitive.ObjectID,
+    deviceIDs []string,
+) (*Vendor, error) {
+    var vendor Vendor
+    err := repo.collection.FindOneAndUpdate(
+        ctx,
+        bson.M{"_id": id},
+        bson.M{"$set": bson.M{"device_ids": deviceIDs, "updated_at": time.Now()}},
+        options.FindOneAndUpdate().SetReturnDocument(options.After),
+    ).Decode(&vendor)
+    if errors.Is(err, mongo.ErrNoDocuments) {
+        return nil, ErrVendorNotFound
+    }
+    if err != nil {
+        return nil, err
+    }
+    return &vendor, nil
+}
+
+func (repo *MongoVendorRepository) RevokeVendorKey(ctx context.Context, id primitive.ObjectID) (*Vendor, error) {
+    now := timestamp.Now()
+    var vendor Vendor
+    err := repo.collection.FindOneAndUpdate(
+        ctx,
+        bson.M{"_id": id},
+        bson.A{
+            bson.M{
+                "$set": bson.M{
+                    "revoked_at": bson.M{"$ifNull": bson.A{"$revoked_at", now}},
+                    "updated_at": now,
+                },
+            },
+        },
+        options.FindOneAndUpdate().SetReturnDocument(options.After),
+    ).Decode(&vendor)
+    if errors.Is(err, mongo.ErrNoDocuments) {
+        return nil, ErrVendorNotFound
+    }
+    if err != nil {
+        return nil, err
+    }
+    return &vendor, nil
+}
@@ -0,0 +1,372 @@
+package repositories
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "time"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+    DeliveryPending   = "pending"
+    DeliverySucceeded = "succeeded"
+    DeliveryFailed    = "failed"
+
+    // webhookDeliveryRetention is how long the delivery log is kept
+    webhookDeliveryRetention = 30 * 24 * time.Hour
+)
+
+var (
+    ErrWebhookNotFound       = fmt.Errorf("webhook %w", ErrNotFound)
+    ErrInvalidDeliveryStatus = errors.New("status must be pending, succeeded or failed")
+)
+
+// Webhook is an endpoint of a consumer the events of its types are posted to. The secret signs the deliveries, it
+// is only shown when the webhook is created or the secret is rotated.
+type Webhook struct {
+    ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+    TenantID  string             `json:"tenant_id,omitempty" bson:"tenant_id,omitempty"`
+    URL       string             `json:"url" bson:"url"`
+    Events    []string           `json:"events" bson:"events"`
+    Active    bool               `json:"active" bson:"active"`
+    Secret    string             `json:"-" bson:"secret"`
+    CreatedAt timestamp.Time     `json:"created_at" bson:"created_at"`
+    UpdatedAt timestamp.Time     `json:"updated_at" bson:"updated_at"`
+}
+
+// WebhookDelivery is an event to post to a webhook and the outcome of its latest attempt. A pending delivery is
+// attempted at NextAttemptAt, which is pushed back while an instance attempts it.
+type WebhookDelivery struct {
+    ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+    WebhookID primitive.ObjectID `json:"webhook_id" bson:"webhook_id"`
+    TenantID  string             `json:"tenant_id,omitempty" bson:"tenant_id,omitempty"`
+    EventID   string             `json:"event_id" bson:"event_id"`
+    EventType string             `json:"event_type" bson:"event_type"`
+    Payload   json.RawMessage    `json:"payload" bson:"payload"`
+    Status    string             `json:"status" bson:"status"`
+    Attempts  int                `json:"attempts" bson:"attempts"`
+    // ResponseStatus is the HTTP status of the latest attempt, 0 when the webhook didn't respond
+    ResponseStatus int             `json:"response_status,omitempty" bson:"response_status,omitempty"`
+    Error          string          `json:"error,omitempty" bson:"error,omitempty"`
+    NextAttemptAt  *timestamp.Time `json:"next_attempt_at,omitempty" bson:"next_attempt_at,omitempty"`
+    DeliveredAt    *timestamp.Time `json:"delivered_at,omitempty" bson:"delivered_at,omitempty"`
+    CreatedAt      timestamp.Time  `json:"created_at" bson:"created_at"`
+}
+
+type WebhookDeliveryFilter struct {
+    Page      int    `json:"page"`
+    PageSize  int    `json:"limit"`
+    Status    string `json:"status"`
+    EventType string `json:"event_type"`
+
+    webhookID primitive.ObjectID
+}
+
+// ForWebhook limits the deliveries to the ones of the webhook
+func (f *WebhookDeliveryFilter) ForWebhook(webhookID primitive.ObjectID) {
+    f.webhookID = webhookID
+}
+
+func (f *WebhookDeliveryFilter) Build() error {
+    if f.Page == 0 {
+        f.Page = 1
+    }
+    f.PageSize = pageSize(f.PageSize)
+    switch f.Status {
+    case "", DeliveryPending, DeliverySucceeded, DeliveryFailed:
+        return nil
+    }
+    return ErrInvalidDeliveryStatus
+}
+
+type WebhookRepository interface {
+    CreateWebhook(ctx context.Context, webhook *Webhook) error
+    FindWebhooks(ctx context.Context) ([]*Webhook, error)
+    FindWebhook(ctx context.Context, id primitive.ObjectID) (*Webhook, error)
+    // UpdateWebhook replaces the url, events, state and secret of the webhook
+    UpdateWebhook(ctx context.Context, webhook *Webhook) error
+    // DeleteWebhook deletes the webhook with its deliveries
+    DeleteWebhook(ctx context.Context, id primitive.ObjectID) error
+    // FindSubscribedWebhooks returns the active webhooks of the tenant of the context subscribed to the event type
+    FindSubscribedWebhooks(ctx context.Context, eventType string) ([]*Webhook, error)
+
+    CreateDeliveries(ctx context.Context, deliveries []*WebhookDelivery) error
+    // ClaimDeliveries returns up to limit pending deliveries due by now, of every tenant, and pushes back their next
+    // attempt by lease so other instances don't attempt them meanwhile
+    ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*WebhookDelivery, error)
+    // SaveAttempt stores the outcome of an attempt of the delivery
+    SaveAttempt(ctx context.Context, delivery *WebhookDelivery) error
+    // FindDeliveries returns a page of the deliveries of the filter, the newest first
+    FindDeliveries(ctx context.Context, filter *WebhookDeliveryFilter) ([]*WebhookDelivery, error)
+}
+
+type MongoWebhookRepository struct {
+    webhooks   *mongo.Collection
+    deliveries *mongo.Collection
+}
+
+func NewMongoWebhookRepository(db *mongo.Database) *MongoWebhookRepository {
+    return &MongoWebhookRepository{
+        webhooks:   db.Collection("webhooks"),
+        deliveries: db.Collection("webhook_deliveries"),
+    }
+}
+
+// CreateIndexes creates the indexes of the subscriptions, the due deliveries and the delivery log, which expires
+// after webhookDeliveryRetention
+func (repo *MongoWebhookRepository) CreateIndexes(ctx context.Context) error {
+    if _, err := repo.webhooks.Indexes().CreateOne(
+        ctx,
+        mongo.IndexModel{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "events", Value: 1}}},
+    ); err != nil {
+        return classify(err)
+    }
+    _, err := repo.deliveries.Indexes().CreateMany(
+        ctx,
+        []mongo.IndexModel{
+            {Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+            {Keys: bson.D{{Key: "webhook_id", Value: 1}, {Key: "created_at", Value: -1}}},
+            {
+                Keys:    bson.D{{Key: "created_at", Value: 1}},
+                Options: options.Index().SetExpireAfterSeconds(int32(webhookDeliveryRetention.Seconds())),
+            },
+        },
+    )
+    return classify(err)
+}
+
+func (repo *MongoWebhookRepository) CreateWebhook(ctx context.Context, webhook *Webhook) error {
+    now := timestamp.Now()
+    webhook.CreatedAt = now
+    webhook.UpdatedAt = now
+    webhook.TenantID = tenantOf(ctx)
+    result, err := repo.webhooks.InsertOne(ctx, webhook)
+    if err != nil {
+        return classify(err)
+    }
+    webhook.ID = result.InsertedID.(primitive.ObjectID)
+    return nil
+}
+
+func (repo *MongoWebhookRepository) FindWebhooks(ctx context.Context) ([]*Webhook, error) {
+    return repo.findWebhooks(ctx, scopeTenant(ctx, bson.M{}))
+}
+
+// FindSubscribedWebhooks never returns the webhooks of another tenant, without a tenant in the context it returns
+// the webhooks without a tenant
+func (repo *MongoWebhookRepository) FindSubscribedWebhooks(ctx context.Context, eventType string) ([]*Webhook, error) {
+    match := bson.M{"events": eventType, "active": true, "tenant_id": bson.M{"$exists": false}}
+    if id, ok := tenant.FromContext(ctx); ok {
+        match["tenant_id"] = id
+    }
+    return repo.findWebhooks(ctx, match)
+}
+
+func (repo *MongoWebhookRepository) findWebhooks(ctx context.Context, match bson.M) ([]*Webhook, error) {
+    cursor, err := repo.webhooks.Find(ctx, match, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+    if err != nil {
+        return nil, classify(err)
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
+        }
+    }(cursor, ctx)
+
+    webhooks := []*Webhook{}
+    for cursor.Next(ctx) {
+        var webhook Webhook
+        if err := cursor.Decode(&webhook); err != nil {
+            return nil, err
+        }
+        webhooks = append(webhooks, &webhook)
+    }
+    return webhooks, classify(cursor.Err())
+}
+
+func (repo *MongoWebhookRepository) FindWebhook(ctx context.Context, id primitive.ObjectID) (*Webhook, error) {
+    var webhook Webhook
+    err := repo.webhooks.FindOne(ctx, scopeTenant(ctx, bson.M{"_id": id})).Decode(&webhook)
+    if errors.Is(err, mongo.ErrNoDocuments) {
+        return nil, ErrWebhookNotFound
+    }
+    if err != nil {
+        return nil, classify(err)
+    }
+    return &webhook, nil
+}
+
+func (repo *MongoWebhookRepository) UpdateWebhook(ctx context.Context, webhook *Webhook) error {
+    webhook.UpdatedAt = timestamp.Now()
+    result, err := repo.webhooks.UpdateOne(
+        ctx,
+        scopeTenant(ctx, bson.M{"_id": webhook.ID}),
+        bson.M{
+            "$set": bson.M{
+                "url":        webhook.URL,
+                "events":     webhook.Events,
+                "active":     webhook.Active,
+                "secret":     webhook.Secret,
+                "updated_at": webhook.UpdatedAt,
+            },
+        },
+    )
+    if err != nil {
+        return classify(err)
+    }
+    if result.MatchedCount == 0 {
+        return ErrWebhookNotFound
+    }
+    return nil
+}
+
+func (repo *MongoWebhookRepository) DeleteWebhook(ctx context.Context, id primitive.ObjectID) error {
+    result, err := repo.webhooks.DeleteOne(ctx, scopeTenant(ctx, bson.M{"_id": id}))
+    if err != nil {
+        return classify(err)
+    }
+    if result.DeletedCount == 0 {
+        return ErrWebhookNotFound
+    }
+    _, err = repo.deliveries.DeleteMany(ctx, bson.M{"webhook_id": id})
+    return classify(err)
+}
+
+func (repo *MongoWebhookRepository) CreateDeliveries(ctx context.Context, deliveries []*WebhookDelivery) error {
+    if len(deliveries) == 0 {
+        return nil
+    }
+    now := timestamp.Now()
+    documents := make([]any, 0, len(deliveries))
+    for _, delivery := range deliveries {
+        delivery.TenantID = tenantOf(ctx)
+        delivery.CreatedAt = now
+        if delivery.NextAttemptAt == nil {
+            delivery.NextAttemptAt = timestamp.Ptr(now.Time)
+        }
+        documents = append(documents, delivery)
+    }
+    result, err := repo.deliveries.InsertMany(ctx, documents)
+    if err != nil {
+        return classify(err)
+    }
+    for i, id := range result.InsertedIDs {
+        deliveries[i].ID = id.(primitive.ObjectID)
+    }
+    return nil
+}
+
+// ClaimDeliveries claims the deliveries one at a time with a conditional update, a delivery claimed by another
+// instance meanwhile no longer matches
+func (repo *MongoWebhookRepository) ClaimDeliveries(
+    ctx context.Context,
+    now time.Time,
+    lease time.Duration,
+    limit int,
+) ([]*WebhookDelivery, error) {
+    var claimed []*WebhookDelivery
+    for len(claimed) < limit {
+        var delivery WebhookDelivery
+        err := repo.deliveries.FindOneAndUpdate(
+            ctx,
+            bson.M{"status": DeliveryPending, "next_attempt_at": bson.M{"$lte": now}},
+            bson.M{"$set": bson.M{"next_attempt_at": now.Add(lease)}},
+            options.FindOneAndUpdate().
+                SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).
+                SetReturnDocument(options.After),
+        ).Decode(&delivery)
+        if errors.Is(err, mongo.ErrNoDocuments) {
+            break
+        }
+        if err != nil {
+            return claimed, classify(err)
+        }
+        claimed = append(claimed, &delivery)
+    }
+    return claimed, nil
+}
+
+func (repo *MongoWebhookRepository) SaveAttempt(ctx context.Context, delivery *WebhookDelivery) error {
+    set := bson.M{
+        "status":          delivery.Status,
+        "attempts":        delivery.Attempts,
+        "response_status": delivery.ResponseStatus,
+        "error":           delivery.Error,
+    }
+    unset := bson.M{}
+    if delivery.NextAttemptAt != nil {
+        set["next_attempt_at"] = delivery.NextAttemptAt
+    } else {
+        unset["next_attempt_at"] = ""
+    }
+    if delivery.DeliveredAt != nil {
+        set["delivered_at"] = delivery.DeliveredAt
+    } else {
+        unset["delivered_at"] = ""
+    }
+    update := bson.M{"$set": set}
+    if len(unset) > 0 {
+        update["$unset"] = unset
+    }
+    _, err := repo.deliveries.UpdateOne(ctx, bson.M{"_id": delivery.ID}, update)
+    return classify(err)
+}
+
+func (repo *MongoWebhookRepository) FindDeliveries(
+    ctx context.Context,
+    filter *WebhookDeliveryFilter,
+) ([]*WebhookDelivery, error) {
+    if filter == nil {
+        filter = &WebhookDeliveryFilter{}
+    }
+    if err := filter.Build(); err != nil {
+        return nil, err
+    }
+    match := scopeTenant(ctx, bson.M{})
+    if !filter.webhookID.IsZero() {
+        match["webhook_id"] = filter.webhookID
+    }
+    if filter.Status != "" {
+        match["status"] = filter.Status
+    }
+    if filter.EventType != "" {
+        match["event_type"] = filter.EventType
+    }
+    cursor, err := repo.deliveries.Find(
+        ctx,
+        match,
+        options.Find().
+            SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+            SetSkip(int64((filter.Page-1)*filter.PageSize)).
+            SetLimit(int64(filter.PageSize)),
+    )
+    if err != nil {
+        return nil, classify(err)
+    }
+    defer func(cursor *mongo.Cursor, ctx context.Context) {
+        err := cursor.Close(ctx)
+        if err != nil {
+            log.Println("Error closing cursor", err)
+        }
+    }(cursor, ctx)
+
+    deliveries := []*WebhookDelivery{}
+    for cursor.Next(ctx) {
+        var delivery WebhookDelivery
+        if err := cursor.Decode(&delivery); err != nil {
+            return nil, err
+        }
+        deliveries = append(deliveries, &delivery)
+    }
+    return deliveries, classify(cursor.Err())
+}
@@ -0,0 +1,59 @@
+package secrets
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "regexp"
+    "strings"
+)
+
+var (
+    ErrSecretNotFound = errors.New("secret not found")
+    ErrInvalidName    = errors.New("invalid secret name, it must be / separated letters, digits, ., - or _")
+)
+
+// segmentPattern matches a segment of a secret name, names can't escape the directory of a FileProvider
+var segmentPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,128}$`)
+
+// Provider returns secrets by name, e.g. the encryption keys of the tenants. Embedding services can read them
+// from a secrets manager instead of files.
+type Provider interface {
+    Secret(ctx context.Context, name string) ([]byte, error)
+}
+
+// ValidateName checks that name is a / separated path of letters, digits, ., - or _ without . or .. segments
+func ValidateName(name string) error {
+    for _, segment := range strings.Split(name, "/") {
+        if !segmentPattern.MatchString(segment) || segment == "." || segment == ".." {
+            return ErrInvalidName
+        }
+    }
+    return nil
+}
+
+// FileProvider reads the secrets from files in a directory, like the secrets mounted by Docker or Kubernetes
+type FileProvider struct {
+    dir string
+}
+
+func NewFileProvider(dir string) *FileProvider {
+    return &FileProvider{dir: dir}
+}
+
+// Secret reads the file of the name in the directory, trailing whitespace is trimmed
+func (p *FileProvider) Secret(_ context.Context, name string) ([]byte, error) {
+    if err := ValidateName(name); err != nil {
+        return nil, err
+    }
+    secret, err := os.ReadFile(filepath.Join(p.dir, filepath.FromSlash(name)))
+    if errors.Is(err, os.ErrNotExist) {
+        return nil, fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+    }
+    if err != nil {
+        return nil, err
+    }
+    return []byte(strings.TrimRight(string(secret), " \t\r\n")), nil
+}
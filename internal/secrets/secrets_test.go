@@ -0,0 +1,33 @@
+package secrets
+
+import (
+    "context"
+    "errors"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+func TestFileProvider(t *testing.T) {
+    dir := t.TempDir()
+    if err := os.MkdirAll(filepath.Join(dir, "acme"), 0o700); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.WriteFile(filepath.Join(dir, "acme", "default"), []byte("c2VjcmV0\n"), 0o600); err != nil {
+        t.Fatal(err)
+    }
+    p := NewFileProvider(dir)
+
+    secret, err := p.Secret(context.Background(), "acme/default")
+    if err != nil || string(secret) != "c2VjcmV0" {
+        t.Fatalf("expected the trimmed secret, got %q, %v", secret, err)
+    }
+    if _, err = p.Secret(context.Background(), "acme/rotated"); !errors.Is(err, ErrSecretNotFound) {
+        t.Errorf("expected ErrSecretNotFound, got %v", err)
+    }
+    for _, name := range []string{"../acme/default", "acme/../acme/default", "/etc/passwd", "acme//default", ""} {
+        if _, err = p.Secret(context.Background(), name); !errors.Is(err, ErrInvalidName) {
+            t.Errorf("expected %q to be rejected, got %v", name, err)
+        }
+    }
+}
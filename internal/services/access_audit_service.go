@@ -0,0 +1,54 @@
+package services
+
+import (
+    "context"
+    "net/url"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+)
+
+type accessAuditContextKey struct{}
+
+// WithAccessAudit returns a context carrying the audit of the request, the handlers report their result count
+// to it with RecordResultCount
+func WithAccessAudit(ctx context.Context, audit *repositories.AccessAudit) context.Context {
+    return context.WithValue(ctx, accessAuditContextKey{}, audit)
+}
+
+// RecordResultCount records the number of records a request returned or changed in its audit, if it is audited
+func RecordResultCount(ctx context.Context, count int) {
+    audit, ok := ctx.Value(accessAuditContextKey{}).(*repositories.AccessAudit)
+    if !ok || audit == nil {
+        return
+    }
+    resultCount := int64(count)
+    audit.ResultCount = &resultCount
+}
+
+type AccessAuditService interface {
+    RecordAccess(ctx context.Context, audit *repositories.AccessAudit) error
+    FindAccessAudits(ctx context.Context, query url.Values) ([]*repositories.AccessAudit, error)
+}
+
+type MongoAccessAuditService struct {
+    accessAuditRepo repositories.AccessAuditRepository
+}
+
+func NewMongoAccessAuditService(accessAuditRepo repositories.AccessAuditRepository) *MongoAccessAuditService {
+    return &MongoAccessAuditService{accessAuditRepo: accessAuditRepo}
+}
+
+func (s *MongoAccessAuditService) RecordAccess(ctx context.Context, audit *repositories.AccessAudit) error {
+    return s.accessAuditRepo.CreateAccessAudit(ctx, audit)
+}
+
+func (s *MongoAccessAuditService) FindAccessAudits(
+    ctx context.Context,
+    query url.Values,
+) ([]*repositories.AccessAudit, error) {
+    var filter repositories.AccessAuditFilter
+    if err := decodeQuery(query, &filter); err != nil {
+        return nil, err
+    }
+    return s.accessAuditRepo.FindAccessAudits(ctx, &filter)
+}
@@ -0,0 +1,27 @@
+package services
+
+import (
+    "context"
+    "testing"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+)
+
+func TestRecordResultCount(t *testing.T) {
+    // requests that aren't audited ignore the count
+    RecordResultCount(context.Background(), 3)
+
+    audit := &repositories.AccessAudit{}
+    ctx := WithAccessAudit(context.Background(), audit)
+    if audit.ResultCount != nil {
+        t.Fatalf("expected no result count before the handler reports it, got %d", *audit.ResultCount)
+    }
+    RecordResultCount(ctx, 0)
+    if audit.ResultCount == nil || *audit.ResultCount != 0 {
+        t.Fatalf("expected an empty result to be recorded as 0, got %v", audit.ResultCount)
+    }
+    RecordResultCount(ctx, 5)
+    if *audit.ResultCount != 5 {
+        t.Errorf("expected the result count 5, got %d", *audit.ResultCount)
+    }
+}
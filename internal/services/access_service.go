@@ -0,0 +1,216 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "slices"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+    RoleAdmin = "admin"
+)
+
+var (
+    ErrVehicleNotAllowed = errors.New("vehicle is not assigned to your organization or fleet groups")
+    ErrAdminRequired     = errors.New("admin role is required")
+    ErrUserMissing       = errors.New("authenticated user is missing")
+)
+
+// Claims are the claims of the authenticated user making the request
+type Claims struct {
+    UserID         string   `json:"user_id"`
+    Role           string   `json:"role"`
+    OrganizationID string   `json:"organization_id"`
+    FleetGroupIDs  []string `json:"fleet_group_ids"`
+}
+
+func (c *Claims) IsAdmin() bool {
+    return c.Role == RoleAdmin
+}
+
+type claimsContextKey struct{}
+
+// WithClaims returns a context carrying the claims, tracking queries made with it are limited to the
+// vehicles the user has access to
+func WithClaims(ctx context.Context, claims *Claims) context.Context {
+    return context.WithValue(ctx, claimsContextKey{}, claims)
+}
+
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+    claims, ok := ctx.Value(claimsContextKey{}).(*Claims)
+    return claims, ok && claims != nil
+}
+
+// VehicleScope is the set of vehicles a request has access to
+type VehicleScope struct {
+    All        bool
+    VehicleIDs []primitive.ObjectID
+}
+
+func (s *VehicleScope) Allows(vehicleID primitive.ObjectID) bool {
+    return s.All || slices.Contains(s.VehicleIDs, vehicleID)
+}
+
+// Restrict limits the filter to the vehicles of the scope
+func (s *VehicleScope) Restrict(filter interface {
+    RestrictVehicles(vehicleIDs []primitive.ObjectID)
+}) {
+    if s.All {
+        return
+    }
+    // a nil restriction means unrestricted, a scope without vehicles must match nothing
+    vehicleIDs := s.VehicleIDs
+    if vehicleIDs == nil {
+        vehicleIDs = []primitive.ObjectID{}
+    }
+    filter.RestrictVehicles(vehicleIDs)
+}
+
+type VehicleAssignmentRequest struct {
+    VehicleID      string   `json:"vehicle_id" validate:"required,mongodb"`
+    OrganizationID string   `json:"organization_id"`
+    FleetGroupIDs  []string `json:"fleet_group_ids"`
+}
+
+type UserMembershipRequest struct {
+    UserID string `json:"user_id" validate:"required"`
+    // TenantID is the tenant of the user when multi-tenancy is enabled, the tenant of the request when it has one
+    TenantID       string   `json:"tenant_id,omitempty"`
+    OrganizationID string   `json:"organization_id"`
+    FleetGroupIDs  []string `json:"fleet_group_ids"`
+}
+
+type AccessService interface {
+    // Claims returns the claims of the user authenticated by the auth service, the role is the one of the user
+    // and the organization and fleet groups are the ones of its membership. Users without one are only
+    // allowed what their role allows.
+    Claims(ctx context.Context, user *models.AuthUser) (*Claims, error)
+    // Tenant returns the tenant of the membership of the user authenticated by the auth service,
+    // tenant.ErrTenantMissing when the user doesn't belong to one
+    Tenant(ctx context.Context, user *models.AuthUser) (string, error)
+    // VehicleScope returns the vehicles the claims of the context have access to. Admins and requests without
+    // claims, like the ones made by the service itself, have access to all vehicles.
+    VehicleScope(ctx context.Context) (*VehicleScope, error)
+    FindAssignments(ctx context.Context) ([]*repositories.VehicleAssignment, error)
+    SetAssignment(ctx context.Context, req *VehicleAssignmentRequest) (*repositories.VehicleAssignment, error)
+    FindMemberships(ctx context.Context) ([]*repositories.UserMembership, error)
+    SetMembership(ctx context.Context, req *UserMembershipRequest) (*repositories.UserMembership, error)
+}
+
+type MongoAccessService struct {
+    assignmentRepo repositories.VehicleAssignmentRepository
+    membershipRepo repositories.UserMembershipRepository
+}
+
+func NewMongoAccessService(
+    assignmentRepo repositories.VehicleAssignmentRepository,
+    membershipRepo repositories.UserMembershipRepository,
+) *MongoAccessService {
+    return &MongoAccessService{assignmentRepo: assignmentRepo, membershipRepo: membershipRepo}
+}
+
+func (s *MongoAccessService) Claims(ctx context.Context, user *models.AuthUser) (*Claims, error) {
+    if user == nil || user.Data.Id == "" {
+        return nil, ErrUserMissing
+    }
+    claims := &Claims{UserID: user.Data.Id, Role: user.Data.Role}
+    membership, err := s.membershipRepo.FindMembership(ctx, user.Data.Id)
+    if errors.Is(err, repositories.ErrNotFound) {
+        return claims, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    claims.OrganizationID, claims.FleetGroupIDs = membership.OrganizationID, membership.FleetGroupIDs
+    return claims, nil
+}
+
+func (s *MongoAccessService) VehicleScope(ctx context.Context) (*VehicleScope, error) {
+    claims, ok := ClaimsFromContext(ctx)
+    if !ok || claims.IsAdmin() {
+        return &VehicleScope{All: true}, nil
+    }
+    vehicleIDs, err := s.assignmentRepo.FindAssignedVehicles(ctx, claims.OrganizationID, claims.FleetGroupIDs)
+    if err != nil {
+        return nil, err
+    }
+    return &VehicleScope{VehicleIDs: vehicleIDs}, nil
+}
+
+func (s *MongoAccessService) FindAssignments(ctx context.Context) ([]*repositories.VehicleAssignment, error) {
+    return s.assignmentRepo.FindAssignments(ctx)
+}
+
+func (s *MongoAccessService) SetAssignment(
+    ctx context.Context,
+    req *VehicleAssignmentRequest,
+) (*repositories.VehicleAssignment, error) {
+    id, err := primitive.ObjectIDFromHex(req.VehicleID)
+    if err != nil {
+        return nil, repositories.ErrInvalidID
+    }
+    assignment := &repositories.VehicleAssignment{
+        VehicleID:      id,
+        OrganizationID: req.OrganizationID,
+        FleetGroupIDs:  req.FleetGroupIDs,
+    }
+    if err := s.assignmentRepo.UpsertAssignment(ctx, assignment); err != nil {
+        return nil, err
+    }
+    return assignment, nil
+}
+
+func (s *MongoAccessService) Tenant(ctx context.Context, user *models.AuthUser) (string, error) {
+    if user == nil || user.Data.Id == "" {
+        return "", ErrUserMissing
+    }
+    membership, err := s.membershipRepo.FindMembership(ctx, user.Data.Id)
+    if errors.Is(err, repositories.ErrNotFound) {
+        return "", tenant.ErrTenantMissing
+    }
+    if err != nil {
+        return "", err
+    }
+    if err = tenant.Validate(membership.TenantID); err != nil {
+        return "", err
+    }
+    return membership.TenantID, nil
+}
+
+func (s *MongoAccessService) FindMemberships(ctx context.Context) ([]*repositories.UserMembership, error) {
+    return s.membershipRepo.FindMemberships(ctx)
+}
+
+func (s *MongoAccessService) SetMembership(
+    ctx context.Context,
+    req *UserMembershipRequest,
+) (*repositories.UserMembership, error) {
+    tenantID := req.TenantID
+    // the operators of a tenant only manage the users of their tenant
+    if id, ok := tenant.FromContext(ctx); ok {
+        if tenantID != "" && tenantID != id {
+            return nil, tenant.ErrTenantMismatch
+        }
+        tenantID = id
+    }
+    if tenantID != "" {
+        if err := tenant.Validate(tenantID); err != nil {
+            return nil, err
+        }
+    }
+    membership := &repositories.UserMembership{
+        UserID:         req.UserID,
+        TenantID:       tenantID,
+        OrganizationID: req.OrganizationID,
+        FleetGroupIDs:  req.FleetGroupIDs,
+    }
+    if err := s.membershipRepo.UpsertMembership(ctx, membership); err != nil {
+        return nil, err
+    }
+    return membership, nil
+}
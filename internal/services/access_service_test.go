@@ -0,0 +1,51 @@
+package services
+
+import (
+    "context"
+    "testing"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type fakeAssignmentRepo struct {
+    repositories.VehicleAssignmentRepository
+    vehicleIDs []primitive.ObjectID
+}
+
+func (r *fakeAssignmentRepo) FindAssignedVehicles(context.Context, string, []string) ([]primitive.ObjectID, error) {
+    return r.vehicleIDs, nil
+}
+
+func TestVehicleScope(t *testing.T) {
+    assigned := primitive.NewObjectID()
+    other := primitive.NewObjectID()
+    s := NewMongoAccessService(&fakeAssignmentRepo{vehicleIDs: []primitive.ObjectID{assigned}}, nil)
+
+    scope, err := s.VehicleScope(context.Background())
+    if err != nil || !scope.All {
+        t.Fatalf("expected requests without claims to access all vehicles, got %+v, %v", scope, err)
+    }
+
+    admin := WithClaims(context.Background(), &Claims{UserID: "1", Role: RoleAdmin})
+    if scope, _ = s.VehicleScope(admin); !scope.All {
+        t.Errorf("expected admins to access all vehicles, got %+v", scope)
+    }
+
+    user := WithClaims(context.Background(), &Claims{UserID: "2", Role: "user", OrganizationID: "org"})
+    scope, err = s.VehicleScope(user)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !scope.Allows(assigned) || scope.Allows(other) {
+        t.Errorf("expected only the assigned vehicle to be allowed, got %+v", scope)
+    }
+}
+
+func TestVehicleScopeRestrictWithoutVehicles(t *testing.T) {
+    filter := &repositories.TrackingFilter{}
+    (&VehicleScope{}).Restrict(filter)
+    if restricted := filter.RestrictedVehicles(); restricted == nil || len(restricted) != 0 {
+        t.Errorf("expected a scope without vehicles to match nothing, got %v", restricted)
+    }
+}
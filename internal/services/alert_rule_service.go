@@ -0,0 +1,463 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net/url"
+    "slices"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+    AlertTriggeredEvent = "alert.triggered"
+    AlertResolvedEvent  = "alert.resolved"
+
+    AlertMetricSpeed          = "speed_kmh"
+    AlertMetricMileage        = "mileage"
+    AlertMetricOdometerMeters = "odometer_meters"
+    // alertMetricSensorPrefix prefixes the name of a sensor, e.g. sensor.temperature
+    alertMetricSensorPrefix = "sensor."
+
+    // maxAlertRuleDuration is the longest a rule can require its metric to meet the threshold
+    maxAlertRuleDuration = 24 * time.Hour
+    // alertRuleCacheTTL is how long the active rules are reused, changes made on another instance apply after it
+    alertRuleCacheTTL = 10 * time.Second
+)
+
+var ErrInvalidAlertRule = errors.New("invalid alert rule")
+
+// alertComparators compare the value of a metric with the threshold of a rule
+var alertComparators = map[string]func(value, threshold float64) bool{
+    "gt":  func(value, threshold float64) bool { return value > threshold },
+    "gte": func(value, threshold float64) bool { return value >= threshold },
+    "lt":  func(value, threshold float64) bool { return value < threshold },
+    "lte": func(value, threshold float64) bool { return value <= threshold },
+    "eq":  func(value, threshold float64) bool { return value == threshold },
+    "ne":  func(value, threshold float64) bool { return value != threshold },
+}
+
+type AlertRuleRequest struct {
+    Name       string   `json:"name" validate:"required,max=100"`
+    Metric     string   `json:"metric" validate:"required"`
+    Comparator string   `json:"comparator" validate:"required,oneof=gt gte lt lte eq ne"`
+    Threshold  *float64 `json:"threshold" validate:"required"`
+    // DurationSeconds is how long the readings have to meet the rule before it alerts, 0 alerts right away
+    DurationSeconds int64    `json:"duration_seconds" validate:"gte=0"`
+    VehicleIDs      []string `json:"vehicle_ids" validate:"max=100,dive,mongodb"`
+    // Active is true when it is left out
+    Active *bool `json:"active"`
+    // Notifications are sent when the rule triggers and resolves an alert, they need NOTIFICATIONS
+    Notifications []*repositories.AlertNotification `json:"notifications" validate:"max=10,dive,required"`
+}
+
+// AlertRuleAlert is the event published to the alerts queue when an alert is triggered or resolved
+type AlertRuleAlert struct {
+    Event string              `json:"event"`
+    Alert *repositories.Alert `json:"alert"`
+}
+
+type AlertRuleService interface {
+    CreateAlertRule(ctx context.Context, req *AlertRuleRequest) (*repositories.AlertRule, error)
+    FindAlertRules(ctx context.Context) ([]*repositories.AlertRule, error)
+    UpdateAlertRule(ctx context.Context, id string, req *AlertRuleRequest) (*repositories.AlertRule, error)
+    DeleteAlertRule(ctx context.Context, id string) error
+    // FindAlerts returns a page of the alert history, the latest first
+    FindAlerts(ctx context.Context, query url.Values) ([]*repositories.Alert, error)
+    // Evaluate compares the reading with the active rules of its vehicle, triggering and resolving their alerts
+    Evaluate(ctx context.Context, record *repositories.TrackingRecord) error
+}
+
+type MongoAlertRuleService struct {
+    ruleRepo        repositories.AlertRuleRepository
+    accessService   AccessService
+    alertsPublisher Publisher
+    // notifications sends the alerts to the recipients of their rule, nil when the notifications are disabled
+    notifications *NotificationDispatcher
+    locks         *vehicleLocks
+
+    mu    sync.Mutex
+    rules map[string]*activeAlertRules
+}
+
+// activeAlertRules are the active rules of a tenant
+type activeAlertRules struct {
+    rules    []*repositories.AlertRule
+    loadedAt time.Time
+}
+
+func NewMongoAlertRuleService(
+    ruleRepo repositories.AlertRuleRepository,
+    accessService AccessService,
+    alertsPublisher Publisher,
+    notifications *NotificationDispatcher,
+) *MongoAlertRuleService {
+    return &MongoAlertRuleService{
+        ruleRepo:        ruleRepo,
+        accessService:   accessService,
+        alertsPublisher: alertsPublisher,
+        notifications:   notifications,
+        locks:           newVehicleLocks(),
+        rules:           map[string]*activeAlertRules{},
+    }
+}
+
+func (s *MongoAlertRuleService) CreateAlertRule(
+    ctx context.Context,
+    req *AlertRuleRequest,
+) (*repositories.AlertRule, error) {
+    if err := s.validateNotifications(req.Notifications); err != nil {
+        return nil, err
+    }
+    rule := &repositories.AlertRule{}
+    if err := applyAlertRuleRequest(rule, req); err != nil {
+        return nil, err
+    }
+    if err := s.ruleRepo.CreateAlertRule(ctx, rule); err != nil {
+        return nil, err
+    }
+    s.forgetRules()
+    return rule, nil
+}
+
+func (s *MongoAlertRuleService) FindAlertRules(ctx context.Context) ([]*repositories.AlertRule, error) {
+    return s.ruleRepo.FindAlertRules(ctx)
+}
+
+func (s *MongoAlertRuleService) UpdateAlertRule(
+    ctx context.Context,
+    id string,
+    req *AlertRuleRequest,
+) (*repositories.AlertRule, error) {
+    objectID, err := primitive.ObjectIDFromHex(id)
+    if err != nil {
+        return nil, repositories.ErrInvalidID
+    }
+    if err = s.validateNotifications(req.Notifications); err != nil {
+        return nil, err
+    }
+    rule, err := s.ruleRepo.FindAlertRule(ctx, objectID)
+    if err != nil {
+        return nil, err
+    }
+    if err = applyAlertRuleRequest(rule, req); err != nil {
+        return nil, err
+    }
+    if err = s.ruleRepo.UpdateAlertRule(ctx, rule); err != nil {
+        return nil, err
+    }
+    s.forgetRules()
+    return rule, nil
+}
+
+func (s *MongoAlertRuleService) DeleteAlertRule(ctx context.Context, id string) error {
+    objectID, err := primitive.ObjectIDFromHex(id)
+    if err != nil {
+        return repositories.ErrInvalidID
+    }
+    if err = s.ruleRepo.DeleteAlertRule(ctx, objectID); err != nil {
+        return err
+    }
+    s.forgetRules()
+    return nil
+}
+
+func (s *MongoAlertRuleService) FindAlerts(ctx context.Context, query url.Values) ([]*repositories.Alert, error) {
+    var filter repositories.AlertFilter
+    if err := decodeQuery(query, &filter); err != nil {
+        return nil, err
+    }
+    if err := filter.Build(); err != nil {
+        return nil, err
+    }
+    scope, err := s.accessService.VehicleScope(ctx)
+    if err != nil {
+        return nil, err
+    }
+    scope.Restrict(&filter)
+    return s.ruleRepo.FindAlerts(ctx, &filter)
+}
+
+// Evaluate keeps the state of every rule per vehicle. A rule is met from the first reading meeting it, and alerts
+// once its readings kept meeting it for its duration. The first reading no longer meeting it resets it and resolves
+// its alert. Readings without the metric of a rule leave it as it is, and readings older than the latest one
+// evaluated for the vehicle are skipped.
+func (s *MongoAlertRuleService) Evaluate(ctx context.Context, record *repositories.TrackingRecord) error {
+    rules, err := s.activeRules(ctx)
+    if err != nil {
+        return err
+    }
+    rules = slices.DeleteFunc(
+        slices.Clone(rules), func(rule *repositories.AlertRule) bool {
+            return len(rule.VehicleIDs) > 0 && !slices.Contains(rule.VehicleIDs, record.VehicleID)
+        },
+    )
+    if len(rules) == 0 {
+        return nil
+    }
+    // the states of the vehicle are compared with one reading at a time
+    unlock := s.locks.lock([]primitive.ObjectID{record.VehicleID})
+    defer unlock()
+
+    states, err := s.ruleRepo.FindAlertRuleStates(ctx, record.VehicleID)
+    if err != nil {
+        return err
+    }
+    at := readingTime(record)
+    for _, rule := range rules {
+        value, ok := AlertMetricValue(record, rule.Metric)
+        if !ok {
+            continue
+        }
+        i := slices.IndexFunc(
+            states, func(state *repositories.AlertRuleState) bool {
+                return state.RuleID == rule.ID
+            },
+        )
+        state := &repositories.AlertRuleState{RuleID: rule.ID, VehicleID: record.VehicleID}
+        if i >= 0 {
+            state = states[i]
+            if !at.After(state.At.Time) {
+                continue
+            }
+        }
+        if err := s.evaluateRule(ctx, rule, state, record, value, at); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// evaluateRule moves the state of the rule with the reading and saves it
+func (s *MongoAlertRuleService) evaluateRule(
+    ctx context.Context,
+    rule *repositories.AlertRule,
+    state *repositories.AlertRuleState,
+    record *repositories.TrackingRecord,
+    value float64,
+    at time.Time,
+) error {
+    state.At = timestamp.New(at)
+    if !alertComparators[rule.Comparator](value, rule.Threshold) {
+        alertID := state.AlertID
+        state.Since, state.AlertID = nil, nil
+        if err := s.ruleRepo.SaveAlertRuleState(ctx, state); err != nil || alertID == nil {
+            return err
+        }
+        alert, err := s.ruleRepo.ResolveAlert(ctx, *alertID, at)
+        if err != nil {
+            return err
+        }
+        return s.publish(ctx, rule, AlertResolvedEvent, alert)
+    }
+    if state.Since == nil {
+        state.Since = timestamp.Ptr(at)
+    }
+    if state.AlertID != nil || at.Sub(state.Since.Time) < time.Duration(rule.DurationSeconds)*time.Second {
+        return s.ruleRepo.SaveAlertRuleState(ctx, state)
+    }
+    alert := &repositories.Alert{
+        RuleID:         rule.ID,
+        RuleName:       rule.Name,
+        VehicleID:      record.VehicleID,
+        DriverID:       record.DriverID,
+        TrackingDataID: record.ID,
+        Metric:         rule.Metric,
+        Comparator:     rule.Comparator,
+        Threshold:      rule.Threshold,
+        Value:          value,
+        Since:          *state.Since,
+        TriggeredAt:    timestamp.New(at),
+    }
+    if err := s.ruleRepo.CreateAlert(ctx, alert); err != nil {
+        return err
+    }
+    state.AlertID = &alert.ID
+    if err := s.ruleRepo.SaveAlertRuleState(ctx, state); err != nil {
+        return err
+    }
+    return s.publish(ctx, rule, AlertTriggeredEvent, alert)
+}
+
+// publish publishes the alert to the alerts queue and notifies the recipients of the rule
+func (s *MongoAlertRuleService) publish(
+    ctx context.Context,
+    rule *repositories.AlertRule,
+    eventType string,
+    alert *repositories.Alert,
+) error {
+    body, err := json.Marshal(&AlertRuleAlert{Event: eventType, Alert: alert})
+    if err != nil {
+        return err
+    }
+    if err = s.alertsPublisher.Publish(ctx, body); err != nil {
+        return err
+    }
+    if s.notifications != nil {
+        s.notifications.Dispatch(rule.Notifications, eventType, body)
+    }
+    return nil
+}
+
+// validateNotifications fails with ErrInvalidAlertRule when a notification isn't valid or the notifications are
+// disabled
+func (s *MongoAlertRuleService) validateNotifications(notifications []*repositories.AlertNotification) error {
+    if len(notifications) == 0 {
+        return nil
+    }
+    if s.notifications == nil {
+        return fmt.Errorf("%w: the notifications are disabled", ErrInvalidAlertRule)
+    }
+    for _, notification := range notifications {
+        if err := s.notifications.Validate(notification); err != nil {
+            return fmt.Errorf("%w: %w", ErrInvalidAlertRule, err)
+        }
+    }
+    return nil
+}
+
+// activeRules returns the active rules of the tenant of the context, they are read at most alertRuleCacheTTL ago
+// so readings don't cost a query each
+func (s *MongoAlertRuleService) activeRules(ctx context.Context) ([]*repositories.AlertRule, error) {
+    tenantID, _ := tenant.FromContext(ctx)
+    s.mu.Lock()
+    cached, ok := s.rules[tenantID]
+    s.mu.Unlock()
+    if ok && time.Since(cached.loadedAt) < alertRuleCacheTTL {
+        return cached.rules, nil
+    }
+
+    rules, err := s.ruleRepo.FindActiveAlertRules(ctx)
+    if err != nil {
+        return nil, err
+    }
+    s.mu.Lock()
+    s.rules[tenantID] = &activeAlertRules{rules: rules, loadedAt: time.Now()}
+    s.mu.Unlock()
+    return rules, nil
+}
+
+// forgetRules makes the next readings read the active rules again after a change
+func (s *MongoAlertRuleService) forgetRules() {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    clear(s.rules)
+}
+
+// applyAlertRuleRequest validates the request and sets it on the rule
+func applyAlertRuleRequest(rule *repositories.AlertRule, req *AlertRuleRequest) error {
+    if err := validateAlertMetric(req.Metric); err != nil {
+        return err
+    }
+    if _, ok := alertComparators[req.Comparator]; !ok {
+        return fmt.Errorf("%w: comparator must be gt, gte, lt, lte, eq or ne", ErrInvalidAlertRule)
+    }
+    if req.Threshold == nil {
+        return fmt.Errorf("%w: threshold is required", ErrInvalidAlertRule)
+    }
+    if req.DurationSeconds < 0 || time.Duration(req.DurationSeconds)*time.Second > maxAlertRuleDuration {
+        return fmt.Errorf(
+            "%w: duration_seconds must be between 0 and %d",
+            ErrInvalidAlertRule,
+            int64(maxAlertRuleDuration.Seconds()),
+        )
+    }
+    vehicleIDs := make([]primitive.ObjectID, 0, len(req.VehicleIDs))
+    for _, value := range req.VehicleIDs {
+        id, err := primitive.ObjectIDFromHex(value)
+        if err != nil {
+            return repositories.ErrInvalidID
+        }
+        if !slices.Contains(vehicleIDs, id) {
+            vehicleIDs = append(vehicleIDs, id)
+        }
+    }
+    rule.Name = strings.TrimSpace(req.Name)
+    rule.Metric = req.Metric
+    rule.Comparator = req.Comparator
+    rule.Threshold = *req.Threshold
+    rule.DurationSeconds = req.DurationSeconds
+    rule.VehicleIDs = vehicleIDs
+    rule.Active = req.Active == nil || *req.Active
+    rule.Notifications = req.Notifications
+    return nil
+}
+
+func validateAlertMetric(metric string) error {
+    switch metric {
+    case AlertMetricSpeed, AlertMetricMileage, AlertMetricOdometerMeters:
+        return nil
+    }
+    if name, ok := strings.CutPrefix(metric, alertMetricSensorPrefix); ok {
+        if err := repositories.ValidateSensorName(name); err != nil {
+            return fmt.Errorf("%w: %w", ErrInvalidAlertRule, err)
+        }
+        return nil
+    }
+    return fmt.Errorf(
+        "%w: metric must be %s, %s, %s or sensor.<name>",
+        ErrInvalidAlertRule,
+        AlertMetricSpeed,
+        AlertMetricMileage,
+        AlertMetricOdometerMeters,
+    )
+}
+
+// AlertMetricValue returns the value of the metric of the reading, false when the reading doesn't have it. A boolean
+// sensor is 1 when true and 0 when false, and text sensors have no value.
+func AlertMetricValue(record *repositories.TrackingRecord, metric string) (float64, bool) {
+    switch metric {
+    case AlertMetricSpeed:
+        return derefFloat(record.SpeedKmh)
+    case AlertMetricMileage:
+        return record.Mileage, true
+    case AlertMetricOdometerMeters:
+        return derefFloat(record.OdometerMeters)
+    }
+    name, ok := strings.CutPrefix(metric, alertMetricSensorPrefix)
+    if !ok {
+        return 0, false
+    }
+    switch value := record.Sensors[name].(type) {
+    case float64:
+        return value, true
+    case bool:
+        if value {
+            return 1, true
+        }
+        return 0, true
+    }
+    return 0, false
+}
+
+func derefFloat(value *float64) (float64, bool) {
+    if value == nil {
+        return 0, false
+    }
+    return *value, true
+}
+
+// AlertRuleProcessor is an ingestion processor evaluating the alert rules with every stored reading
+type AlertRuleProcessor struct {
+    alertRuleService AlertRuleService
+}
+
+func NewAlertRuleProcessor(alertRuleService AlertRuleService) *AlertRuleProcessor {
+    return &AlertRuleProcessor{alertRuleService: alertRuleService}
+}
+
+func (p *AlertRuleProcessor) PreValidate(context.Context, *TrackingDataRequest) error {
+    return nil
+}
+
+func (p *AlertRuleProcessor) PostPersist(ctx context.Context, record *repositories.TrackingRecord) error {
+    return p.alertRuleService.Evaluate(ctx, record)
+}
@@ -0,0 +1,303 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/notifier"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeAlertRuleRepo keeps the rules, states and alerts in memory
+type fakeAlertRuleRepo struct {
+    rules  []*repositories.AlertRule
+    states []*repositories.AlertRuleState
+    alerts []*repositories.Alert
+}
+
+func (r *fakeAlertRuleRepo) CreateAlertRule(_ context.Context, rule *repositories.AlertRule) error {
+    rule.ID = primitive.NewObjectID()
+    r.rules = append(r.rules, rule)
+    return nil
+}
+
+func (r *fakeAlertRuleRepo) FindAlertRules(context.Context) ([]*repositories.AlertRule, error) {
+    return r.rules, nil
+}
+
+func (r *fakeAlertRuleRepo) FindAlertRule(_ context.Context, id primitive.ObjectID) (*repositories.AlertRule, error) {
+    for _, rule := range r.rules {
+        if rule.ID == id {
+            return rule, nil
+        }
+    }
+    return nil, repositories.ErrAlertRuleNotFound
+}
+
+func (r *fakeAlertRuleRepo) UpdateAlertRule(context.Context, *repositories.AlertRule) error {
+    return nil
+}
+
+func (r *fakeAlertRuleRepo) DeleteAlertRule(context.Context, primitive.ObjectID) error {
+    return nil
+}
+
+func (r *fakeAlertRuleRepo) FindActiveAlertRules(context.Context) ([]*repositories.AlertRule, error) {
+    var active []*repositories.AlertRule
+    for _, rule := range r.rules {
+        if rule.Active {
+            active = append(active, rule)
+        }
+    }
+    return active, nil
+}
+
+func (r *fakeAlertRuleRepo) FindAlertRuleStates(
+    _ context.Context,
+    vehicleID primitive.ObjectID,
+) ([]*repositories.AlertRuleState, error) {
+    var states []*repositories.AlertRuleState
+    for _, state := range r.states {
+        if state.VehicleID == vehicleID {
+            copied := *state
+            states = append(states, &copied)
+        }
+    }
+    return states, nil
+}
+
+func (r *fakeAlertRuleRepo) SaveAlertRuleState(_ context.Context, state *repositories.AlertRuleState) error {
+    copied := *state
+    for i, saved := range r.states {
+        if saved.RuleID == state.RuleID && saved.VehicleID == state.VehicleID {
+            r.states[i] = &copied
+            return nil
+        }
+    }
+    r.states = append(r.states, &copied)
+    return nil
+}
+
+func (r *fakeAlertRuleRepo) CreateAlert(_ context.Context, alert *repositories.Alert) error {
+    alert.ID = primitive.NewObjectID()
+    r.alerts = append(r.alerts, alert)
+    return nil
+}
+
+func (r *fakeAlertRuleRepo) ResolveAlert(
+    _ context.Context,
+    id primitive.ObjectID,
+    at time.Time,
+) (*repositories.Alert, error) {
+    for _, alert := range r.alerts {
+        if alert.ID == id {
+            alert.ResolvedAt = timestamp.Ptr(at)
+            return alert, nil
+        }
+    }
+    return nil, repositories.ErrAlertRuleNotFound
+}
+
+func (r *fakeAlertRuleRepo) FindAlerts(context.Context, *repositories.AlertFilter) ([]*repositories.Alert, error) {
+    return r.alerts, nil
+}
+
+func (r *fakeAlertRuleRepo) CountAlerts(context.Context, time.Time, time.Time) (map[primitive.ObjectID]int64, error) {
+    counts := map[primitive.ObjectID]int64{}
+    for _, alert := range r.alerts {
+        counts[alert.VehicleID]++
+    }
+    return counts, nil
+}
+
+func speedRecord(vehicleID primitive.ObjectID, at time.Time, speed float64) *repositories.TrackingRecord {
+    record := positionedRecord(vehicleID, at, 16.0, 96.0)
+    record.ID = primitive.NewObjectID()
+    record.SpeedKmh = &speed
+    return record
+}
+
+func TestMongoAlertRuleService_Evaluate(t *testing.T) {
+    ctx := context.Background()
+    repo := &fakeAlertRuleRepo{}
+    publisher := &recordingPublisher{}
+    s := NewMongoAlertRuleService(repo, NewMongoAccessService(nil, nil), publisher, nil)
+
+    threshold := 100.0
+    if _, err := s.CreateAlertRule(
+        ctx, &AlertRuleRequest{
+            Name:            "Speeding",
+            Metric:          AlertMetricSpeed,
+            Comparator:      "gt",
+            Threshold:       &threshold,
+            DurationSeconds: 60,
+        },
+    ); err != nil {
+        t.Fatal(err)
+    }
+
+    vehicleID := primitive.NewObjectID()
+    start := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+    readings := []*repositories.TrackingRecord{
+        speedRecord(vehicleID, start, 110),
+        // dropping below resets the rule before its duration
+        speedRecord(vehicleID, start.Add(30*time.Second), 90),
+        speedRecord(vehicleID, start.Add(1*time.Minute), 120),
+        speedRecord(vehicleID, start.Add(90*time.Second), 115),
+        // meeting the rule for its duration triggers it once
+        speedRecord(vehicleID, start.Add(2*time.Minute), 130),
+        speedRecord(vehicleID, start.Add(3*time.Minute), 125),
+        // an older reading is skipped
+        speedRecord(vehicleID, start.Add(150*time.Second), 50),
+    }
+    for _, reading := range readings {
+        if err := s.Evaluate(ctx, reading); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if len(repo.alerts) != 1 || publisher.count() != 1 {
+        t.Fatalf("Should trigger 1 alert, got %d alerts and %d events", len(repo.alerts), publisher.count())
+    }
+    alert := repo.alerts[0]
+    if !alert.Since.Equal(start.Add(1*time.Minute)) || !alert.TriggeredAt.Equal(start.Add(2*time.Minute)) {
+        t.Fatalf("Should trigger once the speed was above 100 for a minute, got %+v", alert)
+    }
+
+    if err := s.Evaluate(ctx, speedRecord(vehicleID, start.Add(4*time.Minute), 80)); err != nil {
+        t.Fatal(err)
+    }
+    if alert.ResolvedAt == nil || !alert.ResolvedAt.Equal(start.Add(4*time.Minute)) {
+        t.Fatalf("Should resolve the alert with the first reading below 100, got %+v", alert.ResolvedAt)
+    }
+    if publisher.count() != 2 {
+        t.Fatalf("Should publish the resolution, got %d events", publisher.count())
+    }
+    var event AlertRuleAlert
+    if err := json.Unmarshal(publisher.bodies[1], &event); err != nil || event.Event != AlertResolvedEvent {
+        t.Fatalf("Should publish %s, got %q", AlertResolvedEvent, event.Event)
+    }
+}
+
+func TestMongoAlertRuleService_EvaluateOtherVehicles(t *testing.T) {
+    ctx := context.Background()
+    repo := &fakeAlertRuleRepo{}
+    publisher := &recordingPublisher{}
+    s := NewMongoAlertRuleService(repo, NewMongoAccessService(nil, nil), publisher, nil)
+
+    threshold := 0.0
+    if _, err := s.CreateAlertRule(
+        ctx, &AlertRuleRequest{
+            Name:       "Door open",
+            Metric:     "sensor.door_open",
+            Comparator: "ne",
+            Threshold:  &threshold,
+            VehicleIDs: []string{primitive.NewObjectID().Hex()},
+        },
+    ); err != nil {
+        t.Fatal(err)
+    }
+
+    record := speedRecord(primitive.NewObjectID(), time.Now(), 0)
+    record.Sensors = repositories.Sensors{"door_open": true}
+    if err := s.Evaluate(ctx, record); err != nil {
+        t.Fatal(err)
+    }
+    if len(repo.alerts) != 0 {
+        t.Fatalf("Should only evaluate the rule with its vehicles, got %d alerts", len(repo.alerts))
+    }
+}
+
+func TestAlertMetricValue(t *testing.T) {
+    record := speedRecord(primitive.NewObjectID(), time.Now(), 42)
+    record.Sensors = repositories.Sensors{"temperature": -4.5, "door_open": true, "status": "closed"}
+    tests := []struct {
+        metric string
+        value  float64
+        ok     bool
+    }{
+        {AlertMetricSpeed, 42, true},
+        {AlertMetricOdometerMeters, 0, false},
+        {"sensor.temperature", -4.5, true},
+        {"sensor.door_open", 1, true},
+        {"sensor.status", 0, false},
+        {"sensor.missing", 0, false},
+    }
+    for _, tt := range tests {
+        value, ok := AlertMetricValue(record, tt.metric)
+        if value != tt.value || ok != tt.ok {
+            t.Errorf("%s: expected %v %v, got %v %v", tt.metric, tt.value, tt.ok, value, ok)
+        }
+    }
+}
+
+func TestApplyAlertRuleRequest(t *testing.T) {
+    threshold := 1.0
+    tests := []struct {
+        name string
+        req  AlertRuleRequest
+    }{
+        {"unknown metric", AlertRuleRequest{Metric: "fuel", Comparator: "gt", Threshold: &threshold}},
+        {"invalid sensor", AlertRuleRequest{Metric: "sensor.Temp", Comparator: "gt", Threshold: &threshold}},
+        {"unknown comparator", AlertRuleRequest{Metric: AlertMetricSpeed, Comparator: "in", Threshold: &threshold}},
+        {
+            "too long",
+            AlertRuleRequest{Metric: AlertMetricSpeed, Comparator: "gt", Threshold: &threshold, DurationSeconds: 86401},
+        },
+    }
+    for _, tt := range tests {
+        err := applyAlertRuleRequest(&repositories.AlertRule{}, &tt.req)
+        if !errors.Is(err, ErrInvalidAlertRule) {
+            t.Errorf("%s: expected ErrInvalidAlertRule, got %v", tt.name, err)
+        }
+    }
+}
+
+func TestMongoAlertRuleService_Notifications(t *testing.T) {
+    ctx := context.Background()
+    threshold := 100.0
+    req := &AlertRuleRequest{
+        Name:          "Speeding",
+        Metric:        AlertMetricSpeed,
+        Comparator:    "gt",
+        Threshold:     &threshold,
+        Notifications: []*repositories.AlertNotification{{Channel: notifier.ChannelSMS, To: "+15551234567"}},
+    }
+
+    disabled := NewMongoAlertRuleService(
+        &fakeAlertRuleRepo{},
+        NewMongoAccessService(nil, nil),
+        &recordingPublisher{},
+        nil,
+    )
+    if _, err := disabled.CreateAlertRule(ctx, req); !errors.Is(err, ErrInvalidAlertRule) {
+        t.Fatalf("Should reject notifications when they are disabled, got %v", err)
+    }
+
+    sender := &recordingSender{}
+    d := NewNotificationDispatcher(map[string]notifier.Sender{notifier.ChannelSMS: sender}, time.Second, 10)
+    s := NewMongoAlertRuleService(&fakeAlertRuleRepo{}, NewMongoAccessService(nil, nil), &recordingPublisher{}, d)
+    if _, err := s.CreateAlertRule(ctx, req); err != nil {
+        t.Fatal(err)
+    }
+    vehicleID := primitive.NewObjectID()
+    start := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+    for i, speed := range []float64{120, 80} {
+        if err := s.Evaluate(ctx, speedRecord(vehicleID, start.Add(time.Duration(i)*time.Minute), speed)); err != nil {
+            t.Fatal(err)
+        }
+    }
+    drain(d, start)
+
+    if len(sender.messages) != 2 || sender.messages[0].To != "+15551234567" {
+        t.Fatalf("Should text the trigger and the resolution, got %d messages", len(sender.messages))
+    }
+    if want := "Speeding: speed_kmh of vehicle " + vehicleID.Hex(); !strings.HasPrefix(sender.messages[0].Text, want) {
+        t.Errorf("Should render the alert, got %q", sender.messages[0].Text)
+    }
+}
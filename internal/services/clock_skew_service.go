@@ -0,0 +1,97 @@
+package services
+
+import (
+    "context"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+)
+
+type receivedAtKey struct{}
+
+// WithReceivedAt returns a context of readings received at t, like the time the broker received a message. The
+// readings tracked with other contexts are received when they are tracked.
+func WithReceivedAt(ctx context.Context, t time.Time) context.Context {
+    if t.IsZero() {
+        return ctx
+    }
+    return context.WithValue(ctx, receivedAtKey{}, t)
+}
+
+// receivedAt returns when the readings of the context were received, now when the context doesn't tell
+func receivedAt(ctx context.Context, now time.Time) time.Time {
+    if t, ok := ctx.Value(receivedAtKey{}).(time.Time); ok {
+        return t
+    }
+    return now
+}
+
+// receivedWith returns the request received at the time of the context, the request itself when it has a time or the
+// context doesn't tell
+func receivedWith(ctx context.Context, req *TrackingDataRequest) *TrackingDataRequest {
+    at, ok := ctx.Value(receivedAtKey{}).(time.Time)
+    if !ok || !req.receivedAt.IsZero() {
+        return req
+    }
+    received := *req
+    received.receivedAt = at
+    return &received
+}
+
+// ClockSkewRules configure how the device times of the live readings are trusted
+type ClockSkewRules struct {
+    // Correct stores the readings of a device clock off by more than Tolerance at the time they are received, they
+    // are stored at their device time flagged skewed otherwise
+    Correct bool
+    // Tolerance is how far a device clock can be off the time its readings are received
+    Tolerance time.Duration
+}
+
+// ClockSkewTrackingService compares the device_time of the live readings with the time they were received before
+// the wrapped service stores them. The readings are stored at their device time when the clock of the device is
+// within the tolerance, the others are corrected to the time they were received or flagged skewed. Backfilled
+// readings arrive late by design, their device time is stored without being compared.
+type ClockSkewTrackingService struct {
+    TrackingService
+    rules ClockSkewRules
+}
+
+func NewClockSkewTrackingService(trackingService TrackingService, rules ClockSkewRules) *ClockSkewTrackingService {
+    return &ClockSkewTrackingService{TrackingService: trackingService, rules: rules}
+}
+
+func (s *ClockSkewTrackingService) TrackVehicle(
+    ctx context.Context,
+    req *TrackingDataRequest,
+) (*repositories.TrackingRecord, error) {
+    return s.TrackingService.TrackVehicle(ctx, s.stamp(req, receivedAt(ctx, time.Now())))
+}
+
+func (s *ClockSkewTrackingService) TrackVehicles(
+    ctx context.Context,
+    reqs []*TrackingDataRequest,
+) ([]*repositories.TrackingRecord, []error) {
+    at := receivedAt(ctx, time.Now())
+    stamped := make([]*TrackingDataRequest, len(reqs))
+    for i, req := range reqs {
+        stamped[i] = s.stamp(req, at)
+    }
+    return s.TrackingService.TrackVehicles(ctx, stamped)
+}
+
+// stamp returns a copy of the request received at, with the time it is stored at
+func (s *ClockSkewTrackingService) stamp(req *TrackingDataRequest, at time.Time) *TrackingDataRequest {
+    stamped := *req
+    stamped.receivedAt = at
+    if req.DeviceTime == nil || req.Backfill {
+        return &stamped
+    }
+    if req.DeviceTime.Sub(at).Abs() <= s.rules.Tolerance {
+        stamped.atDeviceTime = true
+        return &stamped
+    }
+    if !s.rules.Correct {
+        stamped.atDeviceTime, stamped.skewed = true, true
+    }
+    return &stamped
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+    "context"
+    "slices"
+    "testing"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+)
+
+func TestClockSkewTrackingService(t *testing.T) {
+    received := time.Date(2025, time.May, 6, 7, 0, 0, 0, time.UTC)
+    ctx := WithReceivedAt(context.Background(), received)
+    reading := func(deviceTime time.Time, backfill bool) *TrackingDataRequest {
+        req := vehicleReading("6650c3e0f1a2b3c4d5e6f7a8")
+        req.DeviceTime, req.Backfill = &deviceTime, backfill
+        return req
+    }
+    rules := ClockSkewRules{Correct: true, Tolerance: 2 * time.Minute}
+
+    for _, tc := range []struct {
+        name      string
+        rules     ClockSkewRules
+        req       *TrackingDataRequest
+        createdAt time.Time
+        skewed    bool
+    }{
+        {
+            name:      "within the tolerance",
+            rules:     rules,
+            req:       reading(received.Add(-time.Minute), false),
+            createdAt: received.Add(-time.Minute),
+        },
+        {
+            name:      "corrected",
+            rules:     rules,
+            req:       reading(received.Add(time.Hour), false),
+            createdAt: received,
+        },
+        {
+            name:      "flagged",
+            rules:     ClockSkewRules{Tolerance: 2 * time.Minute},
+            req:       reading(received.Add(-time.Hour), false),
+            createdAt: received.Add(-time.Hour),
+            skewed:    true,
+        },
+        {
+            name:      "backfilled",
+            rules:     rules,
+            req:       reading(received.Add(-time.Hour), true),
+            createdAt: received,
+        },
+        {
+            name:      "without a device time",
+            rules:     rules,
+            req:       vehicleReading("6650c3e0f1a2b3c4d5e6f7a8"),
+            createdAt: received,
+        },
+    } {
+        t.Run(
+            tc.name, func(t *testing.T) {
+                storing := &storingTrackingService{}
+                if _, err := NewClockSkewTrackingService(storing, tc.rules).TrackVehicle(ctx, tc.req); err != nil {
+                    t.Fatal(err)
+                }
+                if len(storing.stored) != 1 {
+                    t.Fatalf("expected the reading to be stored, got %d", len(storing.stored))
+                }
+                record, err := storing.stored[0].ToTrackingRecord()
+                if err != nil {
+                    t.Fatal(err)
+                }
+                if !record.CreatedAt.Equal(tc.createdAt) || !record.ReceivedTime.Equal(received) {
+                    t.Errorf(
+                        "expected the reading at %v, got %v received at %v",
+                        tc.createdAt,
+                        record.CreatedAt,
+                        record.ReceivedTime,
+                    )
+                }
+                if skewed := slices.Contains(record.Flags, repositories.FlagSkewed); skewed != tc.skewed {
+                    t.Errorf("expected skewed to be %v, got flags %v", tc.skewed, record.Flags)
+                }
+                deviceTime := tc.req.DeviceTime
+                if deviceTime != nil && (record.DeviceTime == nil || !record.DeviceTime.Equal(*deviceTime)) {
+                    t.Errorf("expected the device time to be stored, got %v", record.DeviceTime)
+                }
+            },
+        )
+    }
+}
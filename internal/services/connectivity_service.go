@@ -0,0 +1,316 @@
+package services
+
+import (
+    "context"
+    "log"
+    "net/url"
+    "slices"
+    "strings"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+    // connectivityLookback is how long before a day the readings that still cover its start are looked for
+    connectivityLookback = time.Hour
+)
+
+// MonthlyConnectivity is the connectivity of a vehicle during a month, the days since the vehicle was first tracked
+// without a rollup count as not connected
+type MonthlyConnectivity struct {
+    Month string `json:"month"`
+    // Days is the number of complete days of the month since the vehicle was first tracked
+    Days             int     `json:"days"`
+    ReportedDays     int     `json:"reported_days"`
+    ConnectedSeconds float64 `json:"connected_seconds"`
+    ExpectedSeconds  float64 `json:"expected_seconds"`
+    // Connectivity is the percentage of the expected seconds the vehicle was connected
+    Connectivity float64 `json:"connectivity"`
+}
+
+// VehicleConnectivity is the SLA report of a vehicle, a breakdown per month
+type VehicleConnectivity struct {
+    VehicleID    string                 `json:"vehicle_id"`
+    TrackedSince timestamp.Time         `json:"tracked_since"`
+    Months       []*MonthlyConnectivity `json:"months"`
+}
+
+type ConnectivityService interface {
+    // RollUp computes the connectivity of every vehicle that reported during the last complete UTC day before at
+    RollUp(ctx context.Context, at time.Time) ([]*repositories.ConnectivityRollup, error)
+    // FindSLAReport returns the monthly connectivity of the vehicles from their rollups
+    FindSLAReport(ctx context.Context, query url.Values) ([]*VehicleConnectivity, error)
+}
+
+type MongoConnectivityService struct {
+    trackingRepo     repositories.TrackingRepository
+    connectivityRepo repositories.ConnectivityRepository
+    freshnessService FreshnessService
+    accessService    AccessService
+}
+
+func NewMongoConnectivityService(
+    trackingRepo repositories.TrackingRepository,
+    connectivityRepo repositories.ConnectivityRepository,
+    freshnessService FreshnessService,
+    accessService AccessService,
+) *MongoConnectivityService {
+    return &MongoConnectivityService{
+        trackingRepo:     trackingRepo,
+        connectivityRepo: connectivityRepo,
+        freshnessService: freshnessService,
+        accessService:    accessService,
+    }
+}
+
+// RollUp streams the readings of the day ordered by vehicle and time, so only the readings of one vehicle are held
+// in memory at a time. The readings of every tenant are rolled up, each rollup is stored with its tenant.
+func (s *MongoConnectivityService) RollUp(
+    ctx context.Context,
+    at time.Time,
+) ([]*repositories.ConnectivityRollup, error) {
+    from, to := ReportPeriodDaily.Bounds(at)
+    filter := &repositories.TrackingFilter{
+        SortField: "vehicle_id,created_at",
+        SortOrder: "asc",
+        From:      from.Add(-connectivityLookback).Format(time.RFC3339),
+        To:        to.Format(time.RFC3339),
+    }
+    if err := filter.Build(); err != nil {
+        return nil, err
+    }
+
+    var rollups []*repositories.ConnectivityRollup
+    var records []*repositories.TrackingRecord
+    flush := func() error {
+        defer func() {
+            records = records[:0]
+        }()
+        if len(records) == 0 {
+            return nil
+        }
+        rollup, err := s.rollUp(ctx, records, from, to)
+        if err != nil || rollup == nil {
+            return err
+        }
+        rollups = append(rollups, rollup)
+        return nil
+    }
+
+    err := s.trackingRepo.StreamTrackingData(
+        ctx, filter, func(record *repositories.TrackingRecord) error {
+            if len(records) > 0 && records[0].VehicleID != record.VehicleID {
+                if err := flush(); err != nil {
+                    return err
+                }
+            }
+            records = append(records, record)
+            return nil
+        },
+    )
+    if err != nil {
+        return rollups, err
+    }
+    return rollups, flush()
+}
+
+// rollUp stores the connectivity of the time ordered readings of a vehicle during [from, to), nil when the vehicle
+// didn't report during the day
+func (s *MongoConnectivityService) rollUp(
+    ctx context.Context,
+    records []*repositories.TrackingRecord,
+    from, to time.Time,
+) (*repositories.ConnectivityRollup, error) {
+    vehicleID := records[0].VehicleID
+    times := make([]time.Time, 0, len(records))
+    readings := 0
+    for _, record := range records {
+        times = append(times, record.CreatedAt)
+        if !record.CreatedAt.Before(from) {
+            readings++
+        }
+    }
+    if readings == 0 {
+        return nil, nil
+    }
+    intervals, err := s.freshnessService.ExpectedIntervals(ctx, []primitive.ObjectID{vehicleID})
+    if err != nil {
+        return nil, err
+    }
+    interval := intervals[vehicleID]
+    connected := ConnectedTime(times, from, to, interval)
+    rollup := &repositories.ConnectivityRollup{
+        VehicleID:               vehicleID,
+        Day:                     timestamp.New(from),
+        Readings:                readings,
+        ConnectedSeconds:        connected.Seconds(),
+        ExpectedIntervalSeconds: interval.Seconds(),
+        Connectivity:            100 * connected.Seconds() / to.Sub(from).Seconds(),
+    }
+    vehicleCtx := ctx
+    if tenantID := records[0].TenantID; tenantID != "" {
+        vehicleCtx = tenant.WithID(ctx, tenantID)
+    }
+    if err = s.connectivityRepo.SaveRollup(vehicleCtx, rollup); err != nil {
+        return nil, err
+    }
+    return rollup, nil
+}
+
+// ConnectedTime returns how much of [from, to) is covered by the readings taken at the ordered times, a reading
+// covers the interval after it or the time until the next reading when that comes sooner
+func ConnectedTime(times []time.Time, from, to time.Time, interval time.Duration) time.Duration {
+    var connected time.Duration
+    for i, at := range times {
+        end := at.Add(interval)
+        if i+1 < len(times) && times[i+1].Before(end) {
+            end = times[i+1]
+        }
+        if end.After(to) {
+            end = to
+        }
+        if at.Before(from) {
+            at = from
+        }
+        if end.After(at) {
+            connected += end.Sub(at)
+        }
+    }
+    return connected
+}
+
+func (s *MongoConnectivityService) FindSLAReport(
+    ctx context.Context,
+    query url.Values,
+) ([]*VehicleConnectivity, error) {
+    var filter repositories.ConnectivityFilter
+    if err := decodeQuery(query, &filter); err != nil {
+        return nil, err
+    }
+    now := time.Now()
+    if err := filter.Build(now); err != nil {
+        return nil, err
+    }
+    scope, err := s.accessService.VehicleScope(ctx)
+    if err != nil {
+        return nil, err
+    }
+    scope.Restrict(&filter)
+
+    trackedSince, err := s.connectivityRepo.FindTrackedSince(ctx, &filter)
+    if err != nil {
+        return nil, err
+    }
+    rollups, err := s.connectivityRepo.FindRollups(ctx, &filter)
+    if err != nil {
+        return nil, err
+    }
+    from, to := filter.Months()
+    // the days are complete once they were rolled up, the day before now at the latest
+    if _, today := ReportPeriodDaily.Bounds(now); today.Before(to) {
+        to = today
+    }
+    return SLAReport(trackedSince, rollups, from, to), nil
+}
+
+// SLAReport breaks the connectivity of every tracked vehicle down per month of [from, to), the rollups are ordered
+// by vehicle and day
+func SLAReport(
+    trackedSince map[primitive.ObjectID]time.Time,
+    rollups []*repositories.ConnectivityRollup,
+    from, to time.Time,
+) []*VehicleConnectivity {
+    byVehicle := map[primitive.ObjectID][]*repositories.ConnectivityRollup{}
+    for _, rollup := range rollups {
+        byVehicle[rollup.VehicleID] = append(byVehicle[rollup.VehicleID], rollup)
+    }
+
+    reports := make([]*VehicleConnectivity, 0, len(trackedSince))
+    for vehicleID, since := range trackedSince {
+        if !since.Before(to) {
+            continue
+        }
+        report := &VehicleConnectivity{
+            VehicleID:    vehicleID.Hex(),
+            TrackedSince: timestamp.New(since),
+            Months:       []*MonthlyConnectivity{},
+        }
+        vehicleRollups := byVehicle[vehicleID]
+        for month := from; month.Before(to); month = month.AddDate(0, 1, 0) {
+            start, end := month, month.AddDate(0, 1, 0)
+            if since.After(start) {
+                start = since
+            }
+            if end.After(to) {
+                end = to
+            }
+            if !start.Before(end) {
+                continue
+            }
+            monthly := &MonthlyConnectivity{
+                Month:           month.Format(repositories.MonthLayout),
+                Days:            int(end.Sub(start) / (24 * time.Hour)),
+                ExpectedSeconds: end.Sub(start).Seconds(),
+            }
+            for _, rollup := range vehicleRollups {
+                if !rollup.Day.Before(start) && rollup.Day.Before(end) {
+                    monthly.ReportedDays++
+                    monthly.ConnectedSeconds += rollup.ConnectedSeconds
+                }
+            }
+            monthly.Connectivity = 100 * monthly.ConnectedSeconds / monthly.ExpectedSeconds
+            report.Months = append(report.Months, monthly)
+        }
+        reports = append(reports, report)
+    }
+    slices.SortFunc(
+        reports, func(a, b *VehicleConnectivity) int {
+            return strings.Compare(a.VehicleID, b.VehicleID)
+        },
+    )
+    return reports
+}
+
+// ConnectivityScheduler rolls up the connectivity of the previous UTC day delay after midnight, and of the last
+// complete day right away so a restart doesn't miss one
+type ConnectivityScheduler struct {
+    connectivityService ConnectivityService
+    // delay gives late readings some time to arrive before a day is rolled up
+    delay time.Duration
+}
+
+func NewConnectivityScheduler(connectivityService ConnectivityService, delay time.Duration) *ConnectivityScheduler {
+    return &ConnectivityScheduler{connectivityService: connectivityService, delay: delay}
+}
+
+// Run blocks until ctx is done, rolling up every day once it is complete
+func (s *ConnectivityScheduler) Run(ctx context.Context) {
+    s.rollUp(ctx, time.Now().UTC().Add(-s.delay))
+    for {
+        now := time.Now().UTC()
+        _, next := ReportPeriodDaily.Bounds(now.Add(-s.delay))
+        next = next.AddDate(0, 0, 1).Add(s.delay)
+
+        timer := time.NewTimer(next.Sub(now))
+        select {
+        case <-ctx.Done():
+            timer.Stop()
+            return
+        case at := <-timer.C:
+            s.rollUp(ctx, at.UTC().Add(-s.delay))
+        }
+    }
+}
+
+func (s *ConnectivityScheduler) rollUp(ctx context.Context, at time.Time) {
+    rollups, err := s.connectivityService.RollUp(ctx, at)
+    if err != nil {
+        log.Println("Failed to roll up the connectivity of the vehicles: ", err)
+        return
+    }
+    log.Printf("Rolled up the connectivity of %d vehicles", len(rollups))
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestConnectedTime(t *testing.T) {
+    from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+    to := from.Add(time.Hour)
+    at := func(minutes ...int) []time.Time {
+        times := make([]time.Time, 0, len(minutes))
+        for _, minute := range minutes {
+            times = append(times, from.Add(time.Duration(minute)*time.Minute))
+        }
+        return times
+    }
+    for _, tt := range []struct {
+        name  string
+        times []time.Time
+        want  time.Duration
+    }{
+        {"no readings", nil, 0},
+        {"every interval", at(0, 10, 20, 30, 40, 50), time.Hour},
+        {"gap", at(0, 30), 20 * time.Minute},
+        {"reading before the range covers its start", at(-5, 10), 15 * time.Minute},
+        {"last reading is cut at the end", at(55), 5 * time.Minute},
+    } {
+        if got := ConnectedTime(tt.times, from, to, 10*time.Minute); got != tt.want {
+            t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+        }
+    }
+}
+
+func TestSLAReport(t *testing.T) {
+    vehicleID := primitive.NewObjectID()
+    jan, mar := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+    since := time.Date(2024, 1, 22, 0, 0, 0, 0, time.UTC)
+    rollups := []*repositories.ConnectivityRollup{
+        {VehicleID: vehicleID, Day: timestamp.New(since), ConnectedSeconds: 86400},
+        {VehicleID: vehicleID, Day: timestamp.New(since.AddDate(0, 0, 1)), ConnectedSeconds: 43200},
+        {VehicleID: vehicleID, Day: timestamp.New(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)), ConnectedSeconds: 86400},
+    }
+    reports := SLAReport(
+        map[primitive.ObjectID]time.Time{vehicleID: since, primitive.NewObjectID(): mar},
+        rollups,
+        jan,
+        mar,
+    )
+    if len(reports) != 1 || len(reports[0].Months) != 2 {
+        t.Fatalf("expected the two months of the tracked vehicle, got %+v", reports)
+    }
+    january, february := reports[0].Months[0], reports[0].Months[1]
+    // January counts from the first tracked day, the days without a rollup aren't connected
+    if january.Month != "2024-01" || january.Days != 10 || january.ReportedDays != 2 {
+        t.Errorf("unexpected January %+v", january)
+    }
+    if january.Connectivity != 15 {
+        t.Errorf("expected 1.5 of 10 days connected, got %v", january.Connectivity)
+    }
+    if february.Days != 29 || february.ReportedDays != 1 || february.ExpectedSeconds != 29*86400 {
+        t.Errorf("unexpected February %+v", february)
+    }
+}
+
+type fakeStreamingTrackingRepo struct {
+    repositories.TrackingRepository
+    records []*repositories.TrackingRecord
+}
+
+func (r *fakeStreamingTrackingRepo) StreamTrackingData(
+    _ context.Context,
+    _ *repositories.TrackingFilter,
+    fn func(trackingData *repositories.TrackingRecord) error,
+) error {
+    for _, record := range r.records {
+        if err := fn(record); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+type fakeConnectivityRepo struct {
+    repositories.ConnectivityRepository
+    saved   []*repositories.ConnectivityRollup
+    tenants []string
+}
+
+func (r *fakeConnectivityRepo) SaveRollup(ctx context.Context, rollup *repositories.ConnectivityRollup) error {
+    tenantID, _ := tenant.FromContext(ctx)
+    r.saved = append(r.saved, rollup)
+    r.tenants = append(r.tenants, tenantID)
+    return nil
+}
+
+type fixedIntervalFreshnessService struct {
+    FreshnessService
+    interval time.Duration
+}
+
+func (s *fixedIntervalFreshnessService) ExpectedIntervals(
+    _ context.Context,
+    vehicleIDs []primitive.ObjectID,
+) (map[primitive.ObjectID]time.Duration, error) {
+    intervals := map[primitive.ObjectID]time.Duration{}
+    for _, vehicleID := range vehicleIDs {
+        intervals[vehicleID] = s.interval
+    }
+    return intervals, nil
+}
+
+func TestConnectivityService_RollUp(t *testing.T) {
+    day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+    parked, late := primitive.NewObjectID(), primitive.NewObjectID()
+    var records []*repositories.TrackingRecord
+    for hour := 0; hour < 12; hour++ {
+        record := &repositories.TrackingRecord{TenantID: "acme"}
+        record.VehicleID, record.CreatedAt = parked, day.Add(time.Duration(hour)*time.Hour)
+        records = append(records, record)
+    }
+    // only the reading of the day before, it covers the start of the day but the vehicle didn't report during it
+    record := &repositories.TrackingRecord{}
+    record.VehicleID, record.CreatedAt = late, day.Add(-time.Minute)
+    records = append(records, record)
+
+    connectivityRepo := &fakeConnectivityRepo{}
+    s := NewMongoConnectivityService(
+        &fakeStreamingTrackingRepo{records: records},
+        connectivityRepo,
+        &fixedIntervalFreshnessService{interval: time.Hour},
+        nil,
+    )
+    rollups, err := s.RollUp(context.Background(), day.Add(36*time.Hour))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(rollups) != 1 || len(connectivityRepo.saved) != 1 || connectivityRepo.tenants[0] != "acme" {
+        t.Fatalf("expected the rollup of the reporting vehicle with its tenant, got %+v", connectivityRepo.saved)
+    }
+    rollup := rollups[0]
+    if rollup.VehicleID != parked || !rollup.Day.Equal(day) || rollup.Readings != 12 || rollup.Connectivity != 50 {
+        t.Errorf("expected half the day connected, got %+v", rollup)
+    }
+}
@@ -0,0 +1,159 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "sync"
+    "time"
+
+    amqp "github.com/rabbitmq/amqp091-go"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+)
+
+var (
+    ErrConsumerStopped = errors.New("consumer is stopped for the shutdown")
+)
+
+// ConsumerStatus is whether the consumer of the tracking queue receives messages
+type ConsumerStatus struct {
+    Queue    string          `json:"queue"`
+    Paused   bool            `json:"paused"`
+    PausedAt *timestamp.Time `json:"paused_at,omitempty"`
+}
+
+type ConsumerService interface {
+    // Pause stops the deliveries of the queue, the messages wait in the broker until Resume. It returns once the
+    // delivered messages were handed to the handler, the in-flight messages are still processed.
+    Pause(ctx context.Context) (*ConsumerStatus, error)
+    Resume(ctx context.Context) (*ConsumerStatus, error)
+    Status(ctx context.Context) *ConsumerStatus
+}
+
+// ConsumerChannel is the part of the AMQP channel a consumer uses
+type ConsumerChannel interface {
+    Consume(
+        queue string,
+        consumer string,
+        autoAck bool,
+        exclusive bool,
+        noLocal bool,
+        noWait bool,
+        args amqp.Table,
+    ) (<-chan amqp.Delivery, error)
+    Cancel(consumer string, noWait bool) error
+}
+
+// RabbitConsumer passes the deliveries of a queue to the handler, it can be paused and resumed at runtime
+type RabbitConsumer struct {
+    channel ConsumerChannel
+    queue   string
+    tag     string
+    handle  func(deliveries <-chan amqp.Delivery)
+
+    mu sync.Mutex
+    // done is closed once the handler returned after the consumer was cancelled, nil before the consumer started
+    done     chan struct{}
+    pausedAt *time.Time
+    stopped  bool
+}
+
+func NewRabbitConsumer(
+    channel ConsumerChannel,
+    queue string,
+    tag string,
+    handle func(deliveries <-chan amqp.Delivery),
+) *RabbitConsumer {
+    return &RabbitConsumer{channel: channel, queue: queue, tag: tag, handle: handle}
+}
+
+// Start starts consuming the queue
+func (c *RabbitConsumer) Start() error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.start()
+}
+
+func (c *RabbitConsumer) start() error {
+    deliveries, err := c.channel.Consume(c.queue, c.tag, false, false, false, false, nil)
+    if err != nil {
+        return err
+    }
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        c.handle(deliveries)
+    }()
+    c.done, c.pausedAt = done, nil
+    return nil
+}
+
+func (c *RabbitConsumer) Pause(ctx context.Context) (*ConsumerStatus, error) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if err := c.pause(ctx); err != nil {
+        return nil, err
+    }
+    return c.status(), nil
+}
+
+// pause cancels the consumer unless it is paused already and waits for the handler to return
+func (c *RabbitConsumer) pause(ctx context.Context) error {
+    if c.done == nil {
+        return nil
+    }
+    if c.pausedAt == nil {
+        if err := c.channel.Cancel(c.tag, false); err != nil {
+            return err
+        }
+        now := time.Now()
+        c.pausedAt = &now
+    }
+    select {
+    case <-c.done:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+func (c *RabbitConsumer) Resume(ctx context.Context) (*ConsumerStatus, error) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if c.stopped {
+        return nil, ErrConsumerStopped
+    }
+    if c.pausedAt != nil {
+        // the deliveries of the cancelled consumer are handled before the new one starts
+        select {
+        case <-c.done:
+        case <-ctx.Done():
+            return nil, ctx.Err()
+        }
+        if err := c.start(); err != nil {
+            return nil, err
+        }
+    }
+    return c.status(), nil
+}
+
+// Stop pauses the consumer for good, it isn't resumed anymore
+func (c *RabbitConsumer) Stop(ctx context.Context) error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.stopped = true
+    return c.pause(ctx)
+}
+
+func (c *RabbitConsumer) Status(context.Context) *ConsumerStatus {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.status()
+}
+
+func (c *RabbitConsumer) status() *ConsumerStatus {
+    status := &ConsumerStatus{Queue: c.queue, Paused: c.pausedAt != nil}
+    if c.pausedAt != nil {
+        status.PausedAt = timestamp.Ptr(*c.pausedAt)
+    }
+    return status
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "testing"
+
+    amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// fakeConsumerChannel delivers a message to every consumer and closes its deliveries once it is cancelled
+type fakeConsumerChannel struct {
+    deliveries chan amqp.Delivery
+    consumes   int
+}
+
+func (c *fakeConsumerChannel) Consume(
+    string,
+    string,
+    bool,
+    bool,
+    bool,
+    bool,
+    amqp.Table,
+) (<-chan amqp.Delivery, error) {
+    c.consumes++
+    c.deliveries = make(chan amqp.Delivery, 1)
+    c.deliveries <- amqp.Delivery{Body: []byte(`{}`)}
+    return c.deliveries, nil
+}
+
+func (c *fakeConsumerChannel) Cancel(string, bool) error {
+    close(c.deliveries)
+    return nil
+}
+
+func TestRabbitConsumer(t *testing.T) {
+    ctx := context.Background()
+    channel := &fakeConsumerChannel{}
+    handled := make(chan amqp.Delivery, 4)
+    c := NewRabbitConsumer(
+        channel, "tracking", "tracking-svc", func(deliveries <-chan amqp.Delivery) {
+            for delivery := range deliveries {
+                handled <- delivery
+            }
+        },
+    )
+    if err := c.Start(); err != nil {
+        t.Fatal(err)
+    }
+
+    // the delivered messages are handled before the pause returns
+    status, err := c.Pause(ctx)
+    if err != nil || !status.Paused || status.PausedAt == nil || status.Queue != "tracking" {
+        t.Fatalf("expected a paused consumer, got %+v, %v", status, err)
+    }
+    if len(handled) != 1 {
+        t.Errorf("expected the delivered message to be handled, got %d", len(handled))
+    }
+    // pausing again doesn't cancel the consumer twice
+    if _, err = c.Pause(ctx); err != nil {
+        t.Errorf("expected the consumer to stay paused, got %v", err)
+    }
+
+    status, err = c.Resume(ctx)
+    if err != nil || status.Paused || channel.consumes != 2 {
+        t.Fatalf("expected a resumed consumer, got %+v, %v after %d consumes", status, err, channel.consumes)
+    }
+    if _, err = c.Resume(ctx); err != nil || channel.consumes != 2 {
+        t.Errorf("expected the running consumer to be kept, got %v after %d consumes", err, channel.consumes)
+    }
+
+    // a stopped consumer isn't resumed anymore
+    if err = c.Stop(ctx); err != nil {
+        t.Fatal(err)
+    }
+    if _, err = c.Resume(ctx); !errors.Is(err, ErrConsumerStopped) {
+        t.Errorf("expected ErrConsumerStopped, got %v", err)
+    }
+    if !c.Status(ctx).Paused || len(handled) != 2 {
+        t.Errorf("expected a paused consumer after handling 2 messages, got %+v", c.Status(ctx))
+    }
+}
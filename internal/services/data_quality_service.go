@@ -0,0 +1,366 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "net/url"
+    "slices"
+    "strings"
+    "time"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/geo"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The data quality checks of the readings
+const (
+    // CheckSpeedJump fails a position the vehicle couldn't have driven to from its previous one in time
+    CheckSpeedJump = "speed_jump"
+    // CheckMileageDecrease fails a mileage lower than the previous one of the vehicle
+    CheckMileageDecrease = "mileage_decrease"
+    // CheckFutureTimestamp fails a recorded_at in the future
+    CheckFutureTimestamp = "future_timestamp"
+)
+
+// minJumpElapsed is the shortest time the speed between two positions is computed over, so the GPS noise of readings
+// sent right after one another isn't taken for a jump
+const minJumpElapsed = time.Second
+
+var (
+    ErrQuarantinedReading      = errors.New("reading failed the data quality checks and was quarantined")
+    ErrUnknownDataQualityCheck = errors.New("unknown data quality check")
+)
+
+// DataQualityChecks are the data quality checks a reading can fail
+var DataQualityChecks = []string{CheckSpeedJump, CheckMileageDecrease, CheckFutureTimestamp}
+
+// DataQualityRules configure the data quality checks, all of them are run when Checks is empty
+type DataQualityRules struct {
+    Checks []string
+    // MaxSpeedKmh is the speed a vehicle can't have driven between two positions
+    MaxSpeedKmh float64
+}
+
+// Validate fails with ErrUnknownDataQualityCheck for a check that doesn't exist
+func (r DataQualityRules) Validate() error {
+    for _, check := range r.Checks {
+        if !slices.Contains(DataQualityChecks, check) {
+            return fmt.Errorf(
+                "%w: %q, it must be one of %s",
+                ErrUnknownDataQualityCheck,
+                check,
+                strings.Join(DataQualityChecks, ", "),
+            )
+        }
+    }
+    return nil
+}
+
+func (r DataQualityRules) enabled(check string) bool {
+    return len(r.Checks) == 0 || slices.Contains(r.Checks, check)
+}
+
+// check returns the checks the reading fails against the state of its vehicle, nil when the vehicle has no state
+func (r DataQualityRules) check(req *TrackingDataRequest, state *repositories.VehicleState, now time.Time) []string {
+    at := takenAt(req, now)
+    var failed []string
+    if r.enabled(CheckFutureTimestamp) && at.After(now) {
+        failed = append(failed, CheckFutureTimestamp)
+    }
+    // readings older than the state of their vehicle arrived out of order, they aren't compared with it
+    if state == nil || !at.After(state.LastSeenAt.Time) {
+        return failed
+    }
+    if r.enabled(CheckMileageDecrease) && req.Mileage < state.Mileage {
+        failed = append(failed, CheckMileageDecrease)
+    }
+    if r.enabled(CheckSpeedJump) && req.Lat != nil && req.Lng != nil && state.Lat != nil && state.Lng != nil &&
+        state.PositionAt != nil {
+        elapsed := max(at.Sub(state.PositionAt.Time), minJumpElapsed)
+        meters := geo.Haversine(geo.NewPoint(*state.Lat, *state.Lng), geo.NewPoint(*req.Lat, *req.Lng))
+        if meters/elapsed.Seconds()*3.6 > r.MaxSpeedKmh {
+            failed = append(failed, CheckSpeedJump)
+        }
+    }
+    return failed
+}
+
+// takenAt is when the reading was taken, the time it is received unless it was recorded earlier or is stored at the
+// time of its device
+func takenAt(req *TrackingDataRequest, now time.Time) time.Time {
+    switch {
+    case req.RecordedAt != nil:
+        return *req.RecordedAt
+    case req.atDeviceTime && req.DeviceTime != nil:
+        return *req.DeviceTime
+    }
+    return now
+}
+
+type dataQualityReviewedKey struct{}
+
+// withDataQualityReviewed marks the context of readings reviewed already, they aren't checked again
+func withDataQualityReviewed(ctx context.Context) context.Context {
+    return context.WithValue(ctx, dataQualityReviewedKey{}, true)
+}
+
+// DataQualityTrackingService checks the readings against the state of their vehicle before the wrapped service
+// stores them. The readings failing a check are stored flagged suspect, or kept in the quarantine and rejected with
+// ErrQuarantinedReading when there is one. Readings are accepted when the state can't be read, so a failure of it
+// doesn't stop the ingestion.
+type DataQualityTrackingService struct {
+    TrackingService
+    stateRepo repositories.VehicleStateRepository
+    // quarantineRepo keeps the readings failing a check, they are stored flagged suspect when it is nil
+    quarantineRepo repositories.QuarantineRepository
+    rules          DataQualityRules
+}
+
+func NewDataQualityTrackingService(
+    trackingService TrackingService,
+    stateRepo repositories.VehicleStateRepository,
+    quarantineRepo repositories.QuarantineRepository,
+    rules DataQualityRules,
+) *DataQualityTrackingService {
+    return &DataQualityTrackingService{
+        TrackingService: trackingService,
+        stateRepo:       stateRepo,
+        quarantineRepo:  quarantineRepo,
+        rules:           rules,
+    }
+}
+
+func (s *DataQualityTrackingService) TrackVehicle(
+    ctx context.Context,
+    req *TrackingDataRequest,
+) (*repositories.TrackingRecord, error) {
+    req, err := s.check(ctx, req, map[primitive.ObjectID]*repositories.VehicleState{})
+    if err != nil {
+        return nil, err
+    }
+    return s.TrackingService.TrackVehicle(ctx, req)
+}
+
+// TrackVehicles checks every reading against the previous one of its vehicle in the batch, or its state for the
+// first one, and only passes the readings that aren't quarantined to the wrapped service
+func (s *DataQualityTrackingService) TrackVehicles(
+    ctx context.Context,
+    reqs []*TrackingDataRequest,
+) ([]*repositories.TrackingRecord, []error) {
+    results := make([]*repositories.TrackingRecord, len(reqs))
+    errs := make([]error, len(reqs))
+
+    states := map[primitive.ObjectID]*repositories.VehicleState{}
+    accepted := make([]*TrackingDataRequest, 0, len(reqs))
+    // indexes maps the position in accepted back to the position in reqs
+    indexes := make([]int, 0, len(reqs))
+    for i, req := range reqs {
+        req, err := s.check(ctx, req, states)
+        if err != nil {
+            errs[i] = err
+            continue
+        }
+        accepted = append(accepted, req)
+        indexes = append(indexes, i)
+    }
+    if len(accepted) == 0 {
+        return results, errs
+    }
+
+    trackingData, trackErrs := s.TrackingService.TrackVehicles(ctx, accepted)
+    for j, i := range indexes {
+        results[i], errs[i] = trackingData[j], trackErrs[j]
+    }
+    return results, errs
+}
+
+// check returns the reading to store, flagged suspect when it fails a check without a quarantine. states holds the
+// states of the vehicles the readings are compared with, the good readings move them forward. Invalid vehicle ids
+// are left to the validation of the wrapped service.
+func (s *DataQualityTrackingService) check(
+    ctx context.Context,
+    req *TrackingDataRequest,
+    states map[primitive.ObjectID]*repositories.VehicleState,
+) (*TrackingDataRequest, error) {
+    if ctx.Value(dataQualityReviewedKey{}) != nil {
+        return req, nil
+    }
+    vehicleID, err := primitive.ObjectIDFromHex(req.VehicleID)
+    if err != nil {
+        return req, nil
+    }
+    state, ok := states[vehicleID]
+    if !ok {
+        if state, err = s.findState(ctx, vehicleID); err != nil {
+            log.Println("Failed to find the vehicle state, accepting the reading unchecked: ", err)
+            return req, nil
+        }
+    }
+
+    now := time.Now()
+    failed := s.rules.check(req, state, now)
+    if len(failed) == 0 {
+        states[vehicleID] = advanceState(state, req, now)
+        return req, nil
+    }
+    states[vehicleID] = state
+    if s.quarantineRepo != nil {
+        return nil, s.quarantine(ctx, vehicleID, req, failed)
+    }
+
+    suspect := *req
+    suspect.suspect = true
+    // a reading from the future is stored at the time it is received
+    if slices.Contains(failed, CheckFutureTimestamp) {
+        suspect.RecordedAt, suspect.atDeviceTime = nil, false
+    }
+    return &suspect, nil
+}
+
+func (s *DataQualityTrackingService) findState(
+    ctx context.Context,
+    vehicleID primitive.ObjectID,
+) (*repositories.VehicleState, error) {
+    states, err := s.stateRepo.FindStates(
+        ctx,
+        &repositories.VehicleStateFilter{VehicleID: vehicleID.Hex(), PageSize: 1},
+    )
+    if err != nil || len(states) == 0 {
+        return nil, err
+    }
+    return states[0], nil
+}
+
+func (s *DataQualityTrackingService) quarantine(
+    ctx context.Context,
+    vehicleID primitive.ObjectID,
+    req *TrackingDataRequest,
+    failed []string,
+) error {
+    reading, err := json.Marshal(req)
+    if err != nil {
+        return err
+    }
+    if err = s.quarantineRepo.CreateQuarantinedReading(
+        ctx,
+        &repositories.QuarantinedReading{
+            VehicleID:  vehicleID,
+            Checks:     failed,
+            Reading:    reading,
+            ReceivedAt: timestamp.New(receivedWith(ctx, req).receivedAt),
+        },
+    ); err != nil {
+        return err
+    }
+    return fmt.Errorf("%w: %s", ErrQuarantinedReading, strings.Join(failed, ", "))
+}
+
+// advanceState returns the state of the vehicle once the good reading is stored, the next reading of the vehicle in
+// the same batch is compared with it
+func advanceState(
+    state *repositories.VehicleState,
+    req *TrackingDataRequest,
+    now time.Time,
+) *repositories.VehicleState {
+    at := takenAt(req, now)
+    if state != nil && !at.After(state.LastSeenAt.Time) {
+        return state
+    }
+    next := &repositories.VehicleState{}
+    if state != nil {
+        *next = *state
+    }
+    next.Mileage = req.Mileage
+    next.LastSeenAt = timestamp.New(at)
+    if req.Lat != nil && req.Lng != nil {
+        positionAt := timestamp.New(at)
+        next.Lat, next.Lng, next.PositionAt = req.Lat, req.Lng, &positionAt
+    }
+    return next
+}
+
+type QuarantineService interface {
+    // FindQuarantinedReadings returns the readings waiting for a review, the latest first
+    FindQuarantinedReadings(ctx context.Context, query url.Values) ([]*repositories.QuarantinedReading, error)
+    // ReleaseQuarantinedReading stores the reviewed reading without checking it again and publishes it like the
+    // other readings, it stays quarantined when it can't be stored
+    ReleaseQuarantinedReading(ctx context.Context, id string) (*repositories.TrackingRecord, error)
+    // DiscardQuarantinedReading deletes the reviewed reading
+    DiscardQuarantinedReading(ctx context.Context, id string) error
+}
+
+type MongoQuarantineService struct {
+    quarantineRepo  repositories.QuarantineRepository
+    trackingService TrackingService
+    publisher       Publisher
+}
+
+func NewMongoQuarantineService(
+    quarantineRepo repositories.QuarantineRepository,
+    trackingService TrackingService,
+    publisher Publisher,
+) *MongoQuarantineService {
+    return &MongoQuarantineService{
+        quarantineRepo:  quarantineRepo,
+        trackingService: trackingService,
+        publisher:       publisher,
+    }
+}
+
+func (s *MongoQuarantineService) FindQuarantinedReadings(
+    ctx context.Context,
+    query url.Values,
+) ([]*repositories.QuarantinedReading, error) {
+    var filter repositories.QuarantineFilter
+    if err := decodeQuery(query, &filter); err != nil {
+        return nil, err
+    }
+    return s.quarantineRepo.FindQuarantinedReadings(ctx, &filter)
+}
+
+func (s *MongoQuarantineService) ReleaseQuarantinedReading(
+    ctx context.Context,
+    id string,
+) (*repositories.TrackingRecord, error) {
+    quarantined, err := s.take(ctx, id)
+    if err != nil {
+        return nil, err
+    }
+    var req TrackingDataRequest
+    err = json.Unmarshal(quarantined.Reading, &req)
+    var record *repositories.TrackingRecord
+    if err == nil {
+        // the device time of the reading is compared with the time it was received, not with its release
+        reviewedCtx := WithReceivedAt(withDataQualityReviewed(ctx), quarantined.ReceivedAt.Time)
+        record, err = s.trackingService.TrackVehicle(reviewedCtx, &req)
+    }
+    if err != nil {
+        if restoreErr := s.quarantineRepo.CreateQuarantinedReading(ctx, quarantined); restoreErr != nil {
+            log.Println("Failed to keep the quarantined reading: ", restoreErr)
+        }
+        return nil, err
+    }
+
+    if err = s.publisher.Publish(ctx, TrackingDataMessage(record, quarantined.Reading)); err != nil {
+        log.Println("Failed to publish the released reading: ", err)
+    }
+    return record, nil
+}
+
+func (s *MongoQuarantineService) DiscardQuarantinedReading(ctx context.Context, id string) error {
+    _, err := s.take(ctx, id)
+    return err
+}
+
+func (s *MongoQuarantineService) take(ctx context.Context, id string) (*repositories.QuarantinedReading, error) {
+    objectID, err := primitive.ObjectIDFromHex(id)
+    if err != nil {
+        return nil, repositories.ErrInvalidID
+    }
+    return s.quarantineRepo.TakeQuarantinedReading(ctx, objectID)
+}
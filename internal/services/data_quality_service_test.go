@@ -0,0 +1,233 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "slices"
+    "testing"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// latestStateRepo returns the state of the vehicle it holds
+type latestStateRepo struct {
+    repositories.VehicleStateRepository
+    state *repositories.VehicleState
+}
+
+func (r *latestStateRepo) FindStates(
+    context.Context,
+    *repositories.VehicleStateFilter,
+) ([]*repositories.VehicleState, error) {
+    if r.state == nil {
+        return nil, nil
+    }
+    return []*repositories.VehicleState{r.state}, nil
+}
+
+type fakeQuarantineRepo struct {
+    repositories.QuarantineRepository
+    readings []*repositories.QuarantinedReading
+}
+
+func (r *fakeQuarantineRepo) CreateQuarantinedReading(
+    _ context.Context,
+    reading *repositories.QuarantinedReading,
+) error {
+    if reading.ID.IsZero() {
+        reading.ID = primitive.NewObjectID()
+    }
+    r.readings = append(r.readings, reading)
+    return nil
+}
+
+func (r *fakeQuarantineRepo) TakeQuarantinedReading(
+    _ context.Context,
+    id primitive.ObjectID,
+) (*repositories.QuarantinedReading, error) {
+    for i, reading := range r.readings {
+        if reading.ID == id {
+            r.readings = slices.Delete(r.readings, i, i+1)
+            return reading, nil
+        }
+    }
+    return nil, repositories.ErrQuarantinedReadingNotFound
+}
+
+func qualityReading(vehicleID primitive.ObjectID, lat, lng, mileage float64) *TrackingDataRequest {
+    return &TrackingDataRequest{
+        TrackingDataRequest: models.TrackingDataRequest{VehicleID: vehicleID.Hex(), Mileage: mileage},
+        Lat:                 &lat,
+        Lng:                 &lng,
+    }
+}
+
+func qualityState(vehicleID primitive.ObjectID, lat, lng, mileage float64, at time.Time) *repositories.VehicleState {
+    positionAt := timestamp.New(at)
+    return &repositories.VehicleState{
+        VehicleID:  vehicleID,
+        Mileage:    mileage,
+        Lat:        &lat,
+        Lng:        &lng,
+        PositionAt: &positionAt,
+        LastSeenAt: timestamp.New(at),
+    }
+}
+
+func TestDataQualityRules_Check(t *testing.T) {
+    vehicleID := primitive.NewObjectID()
+    now := time.Date(2025, time.March, 4, 10, 0, 0, 0, time.UTC)
+    // a degree of latitude is about 111 km, driven in a minute
+    state := qualityState(vehicleID, 0, 0, 1000, now.Add(-time.Minute))
+    future, past := now.Add(time.Hour), now.Add(-time.Hour)
+    rules := DataQualityRules{MaxSpeedKmh: 300}
+
+    for _, tc := range []struct {
+        name   string
+        rules  DataQualityRules
+        req    *TrackingDataRequest
+        state  *repositories.VehicleState
+        failed []string
+    }{
+        {name: "good reading", rules: rules, req: qualityReading(vehicleID, 0.01, 0, 1001), state: state},
+        {
+            name:   "impossible jump",
+            rules:  rules,
+            req:    qualityReading(vehicleID, 1, 0, 1001),
+            state:  state,
+            failed: []string{CheckSpeedJump},
+        },
+        {
+            name:   "mileage decreasing",
+            rules:  rules,
+            req:    qualityReading(vehicleID, 0.01, 0, 900),
+            state:  state,
+            failed: []string{CheckMileageDecrease},
+        },
+        {
+            name:   "future timestamp",
+            rules:  rules,
+            req:    &TrackingDataRequest{Backfill: true, RecordedAt: &future},
+            failed: []string{CheckFutureTimestamp},
+        },
+        {
+            name:  "older than the state",
+            rules: rules,
+            req: &TrackingDataRequest{
+                TrackingDataRequest: models.TrackingDataRequest{Mileage: 900},
+                Backfill:            true,
+                RecordedAt:          &past,
+            },
+            state: state,
+        },
+        {
+            name:   "only the configured checks",
+            rules:  DataQualityRules{Checks: []string{CheckMileageDecrease}, MaxSpeedKmh: 300},
+            req:    qualityReading(vehicleID, 1, 0, 900),
+            state:  state,
+            failed: []string{CheckMileageDecrease},
+        },
+        {name: "first reading", rules: rules, req: qualityReading(vehicleID, 1, 0, 0)},
+    } {
+        t.Run(
+            tc.name, func(t *testing.T) {
+                if failed := tc.rules.check(tc.req, tc.state, now); !slices.Equal(failed, tc.failed) {
+                    t.Errorf("expected %v to fail, got %v", tc.failed, failed)
+                }
+            },
+        )
+    }
+
+    if err := (DataQualityRules{Checks: []string{"teleport"}}).Validate(); !errors.Is(
+        err,
+        ErrUnknownDataQualityCheck,
+    ) {
+        t.Errorf("expected an unknown check, got %v", err)
+    }
+}
+
+func TestDataQualityTrackingService_Tag(t *testing.T) {
+    ctx := context.Background()
+    vehicleID := primitive.NewObjectID()
+    stateRepo := &latestStateRepo{state: qualityState(vehicleID, 0, 0, 1000, time.Now().Add(-time.Minute))}
+    storing := &storingTrackingService{}
+    s := NewDataQualityTrackingService(storing, stateRepo, nil, DataQualityRules{MaxSpeedKmh: 300})
+
+    if _, err := s.TrackVehicle(ctx, qualityReading(vehicleID, 1, 0, 1001)); err != nil {
+        t.Fatal(err)
+    }
+    if len(storing.stored) != 1 || !storing.stored[0].suspect {
+        t.Fatalf("expected the jump to be stored suspect, got %+v", storing.stored)
+    }
+
+    // the second reading of the batch is compared with the first one
+    _, errs := s.TrackVehicles(
+        ctx,
+        []*TrackingDataRequest{qualityReading(vehicleID, 0.01, 0, 1001), qualityReading(vehicleID, 0.01, 0, 990)},
+    )
+    if errs[0] != nil || errs[1] != nil {
+        t.Fatalf("expected the readings to be stored, got %v", errs)
+    }
+    if storing.stored[1].suspect || !storing.stored[2].suspect {
+        t.Errorf("expected only the decreasing mileage to be suspect, got %v, %v", storing.stored[1], storing.stored[2])
+    }
+
+    // a reading from the future is stored at the time it is received
+    future := time.Now().Add(time.Hour)
+    req := &TrackingDataRequest{
+        TrackingDataRequest: models.TrackingDataRequest{VehicleID: vehicleID.Hex(), Mileage: 1001},
+        Backfill:            true,
+        RecordedAt:          &future,
+    }
+    if _, err := s.TrackVehicle(ctx, req); err != nil {
+        t.Fatal(err)
+    }
+    if stored := storing.stored[3]; !stored.suspect || stored.RecordedAt != nil || req.RecordedAt == nil {
+        t.Errorf("expected a suspect copy without recorded_at, got %+v", stored)
+    }
+}
+
+func TestDataQualityTrackingService_Quarantine(t *testing.T) {
+    ctx := context.Background()
+    vehicleID := primitive.NewObjectID()
+    stateRepo := &latestStateRepo{state: qualityState(vehicleID, 0, 0, 1000, time.Now().Add(-time.Minute))}
+    storing := &storingTrackingService{}
+    quarantineRepo := &fakeQuarantineRepo{}
+    s := NewDataQualityTrackingService(storing, stateRepo, quarantineRepo, DataQualityRules{MaxSpeedKmh: 300})
+
+    _, err := s.TrackVehicle(ctx, qualityReading(vehicleID, 1, 0, 900))
+    if !errors.Is(err, ErrQuarantinedReading) || IngestionErrorReason(err) != repositories.IngestionReasonQuarantined {
+        t.Fatalf("expected the reading to be quarantined, got %v", err)
+    }
+    if len(storing.stored) != 0 || len(quarantineRepo.readings) != 1 {
+        t.Fatalf("expected the reading in the quarantine only, got %d stored", len(storing.stored))
+    }
+    quarantined := quarantineRepo.readings[0]
+    if !slices.Equal(quarantined.Checks, []string{CheckMileageDecrease, CheckSpeedJump}) ||
+        quarantined.VehicleID != vehicleID {
+        t.Errorf("unexpected quarantined reading %+v", quarantined)
+    }
+
+    // a released reading isn't checked again, it is stored and published
+    publisher := &queuePublisher{}
+    quarantineService := NewMongoQuarantineService(quarantineRepo, s, publisher)
+    if _, err = quarantineService.ReleaseQuarantinedReading(ctx, quarantined.ID.Hex()); err != nil {
+        t.Fatal(err)
+    }
+    if len(storing.stored) != 1 || storing.stored[0].Mileage != 900 || len(publisher.bodies) != 1 {
+        t.Errorf("expected the released reading to be stored and published, got %+v", storing.stored)
+    }
+    if len(quarantineRepo.readings) != 0 {
+        t.Errorf("expected the released reading to leave the quarantine, got %d", len(quarantineRepo.readings))
+    }
+    if err = quarantineService.DiscardQuarantinedReading(ctx, quarantined.ID.Hex()); !errors.Is(
+        err,
+        repositories.ErrNotFound,
+    ) {
+        t.Errorf("expected the reading to be released once, got %v", err)
+    }
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/metrics"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+)
+
+const (
+    DeploymentProceed          = "proceed"
+    DeploymentRollback         = "rollback"
+    DeploymentInsufficientData = "insufficient_data"
+)
+
+// DeploymentBaseline is the expected behaviour of a healthy deployment and how far the current one may deviate
+type DeploymentBaseline struct {
+    // ErrorRate is the baseline share of failed ingests, MaxErrorRateDelta is the allowed absolute increase
+    ErrorRate         float64
+    MaxErrorRateDelta float64
+    // LatencyP95 is the baseline 95th percentile ingest latency, MaxLatencyDelta is the allowed relative increase
+    LatencyP95      time.Duration
+    MaxLatencyDelta float64
+    // MinSamples is the number of ingests needed before a decision is made
+    MinSamples int64
+}
+
+// MetricComparison is a current metric compared to its baseline
+type MetricComparison struct {
+    Current   float64 `json:"current"`
+    Baseline  float64 `json:"baseline"`
+    Delta     float64 `json:"delta"`
+    Threshold float64 `json:"threshold"`
+    Exceeded  bool    `json:"exceeded"`
+}
+
+// DeploymentHealth is the rollback signal for the CD system
+type DeploymentHealth struct {
+    Recommendation string         `json:"recommendation"`
+    Since          timestamp.Time `json:"since"`
+    UptimeSeconds  float64        `json:"uptime_seconds"`
+    Samples        int64          `json:"samples"`
+    // ErrorRate deltas are absolute, a delta of 0.01 is one percentage point
+    ErrorRate MetricComparison `json:"error_rate"`
+    // LatencyP95Ms deltas are relative to the baseline, a delta of 0.5 is 50% slower
+    LatencyP95Ms     MetricComparison `json:"latency_p95_ms"`
+    AvgLatencyMs     float64          `json:"avg_latency_ms"`
+    IngestErrorCount int64            `json:"ingest_error_count"`
+}
+
+type DeploymentHealthService interface {
+    DeploymentHealth() *DeploymentHealth
+}
+
+type MetricsDeploymentHealthService struct {
+    recorder *metrics.IngestRecorder
+    baseline DeploymentBaseline
+}
+
+func NewMetricsDeploymentHealthService(
+    recorder *metrics.IngestRecorder,
+    baseline DeploymentBaseline,
+) *MetricsDeploymentHealthService {
+    return &MetricsDeploymentHealthService{recorder: recorder, baseline: baseline}
+}
+
+// DeploymentHealth compares the ingest metrics since the process started with the baseline,
+// rollback is recommended when either the error rate or the latency exceeds its threshold
+func (s *MetricsDeploymentHealthService) DeploymentHealth() *DeploymentHealth {
+    return CompareDeployment(s.recorder.Snapshot(), s.baseline, time.Now())
+}
+
+func CompareDeployment(snapshot metrics.IngestSnapshot, baseline DeploymentBaseline, now time.Time) *DeploymentHealth {
+    health := &DeploymentHealth{
+        Since:            timestamp.New(snapshot.Since),
+        UptimeSeconds:    now.Sub(snapshot.Since).Seconds(),
+        Samples:          snapshot.Total,
+        AvgLatencyMs:     milliseconds(snapshot.AvgLatency),
+        IngestErrorCount: snapshot.Errors,
+    }
+
+    errorRate := snapshot.ErrorRate()
+    health.ErrorRate = MetricComparison{
+        Current:   errorRate,
+        Baseline:  baseline.ErrorRate,
+        Delta:     errorRate - baseline.ErrorRate,
+        Threshold: baseline.MaxErrorRateDelta,
+    }
+    health.ErrorRate.Exceeded = health.ErrorRate.Delta > baseline.MaxErrorRateDelta
+
+    health.LatencyP95Ms = MetricComparison{
+        Current:   milliseconds(snapshot.P95Latency),
+        Baseline:  milliseconds(baseline.LatencyP95),
+        Threshold: baseline.MaxLatencyDelta,
+    }
+    if baseline.LatencyP95 > 0 {
+        health.LatencyP95Ms.Delta = float64(snapshot.P95Latency-baseline.LatencyP95) / float64(baseline.LatencyP95)
+        health.LatencyP95Ms.Exceeded = health.LatencyP95Ms.Delta > baseline.MaxLatencyDelta
+    }
+
+    switch {
+    case snapshot.Total < baseline.MinSamples:
+        health.Recommendation = DeploymentInsufficientData
+    case health.ErrorRate.Exceeded || health.LatencyP95Ms.Exceeded:
+        health.Recommendation = DeploymentRollback
+    default:
+        health.Recommendation = DeploymentProceed
+    }
+    return health
+}
+
+func milliseconds(d time.Duration) float64 {
+    return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+    "errors"
+    "net/url"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/metrics"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+)
+
+var (
+    ErrFeatureSunset = errors.New("this feature was removed after its sunset date")
+)
+
+// Deprecation marks an endpoint, or a parameter of it when Param is set, as deprecated. Requests using it get
+// the Deprecation and Sunset headers and are counted per caller until it is removed.
+type Deprecation struct {
+    // Feature names the deprecation in the usage metrics
+    Feature string
+    // Method is empty for every method
+    Method string
+    Path   string
+    Param  string
+    // DeprecatedAt is when the feature was deprecated, Sunset when it is removed, zero when not planned yet
+    DeprecatedAt time.Time
+    Sunset       time.Time
+    // Link documents the deprecation and what to use instead
+    Link string
+}
+
+// Applies reports whether a request uses the deprecated feature
+func (d *Deprecation) Applies(method, path string, query url.Values) bool {
+    if d.Method != "" && d.Method != method {
+        return false
+    }
+    if d.Path != path {
+        return false
+    }
+    return d.Param == "" || query.Has(d.Param)
+}
+
+// SunsetPassed reports whether the feature is past its sunset date at now
+func (d *Deprecation) SunsetPassed(now time.Time) bool {
+    return !d.Sunset.IsZero() && !now.Before(d.Sunset)
+}
+
+type DeprecationUsage struct {
+    Caller   string         `json:"caller"`
+    Count    int64          `json:"count"`
+    LastSeen timestamp.Time `json:"last_seen"`
+}
+
+// DeprecationReport is a deprecation with who still uses it since the process started
+type DeprecationReport struct {
+    Feature      string              `json:"feature"`
+    Method       string              `json:"method,omitempty"`
+    Path         string              `json:"path"`
+    Param        string              `json:"param,omitempty"`
+    DeprecatedAt timestamp.Time      `json:"deprecated_at"`
+    Sunset       *timestamp.Time     `json:"sunset,omitempty"`
+    Link         string              `json:"link,omitempty"`
+    Enforced     bool                `json:"enforced"`
+    Usage        []*DeprecationUsage `json:"usage"`
+}
+
+type DeprecationService interface {
+    // Use returns the deprecations the request uses and records their use by the caller, it fails with
+    // ErrFeatureSunset when one of them is past its sunset date and the sunset policy is enforced
+    Use(method, path string, query url.Values, caller string) ([]*Deprecation, error)
+    Deprecations() []*DeprecationReport
+}
+
+type MetricsDeprecationService struct {
+    deprecations []*Deprecation
+    recorder     *metrics.DeprecationRecorder
+    enforce      bool
+}
+
+// NewMetricsDeprecationService creates the deprecation service, with enforce the features past their sunset
+// date are rejected instead of only announced
+func NewMetricsDeprecationService(
+    deprecations []*Deprecation,
+    recorder *metrics.DeprecationRecorder,
+    enforce bool,
+) *MetricsDeprecationService {
+    return &MetricsDeprecationService{deprecations: deprecations, recorder: recorder, enforce: enforce}
+}
+
+func (s *MetricsDeprecationService) Use(
+    method, path string,
+    query url.Values,
+    caller string,
+) ([]*Deprecation, error) {
+    now := time.Now()
+    var used []*Deprecation
+    for _, deprecation := range s.deprecations {
+        if !deprecation.Applies(method, path, query) {
+            continue
+        }
+        s.recorder.Observe(deprecation.Feature, caller, now)
+        if s.enforce && deprecation.SunsetPassed(now) {
+            return nil, ErrFeatureSunset
+        }
+        used = append(used, deprecation)
+    }
+    return used, nil
+}
+
+func (s *MetricsDeprecationService) Deprecations() []*DeprecationReport {
+    now := time.Now()
+    reports := make([]*DeprecationReport, 0, len(s.deprecations))
+    for _, deprecation := range s.deprecations {
+        report := &DeprecationReport{
+            Feature:      deprecation.Feature,
+            Method:       deprecation.Method,
+            Path:         deprecation.Path,
+            Param:        deprecation.Param,
+            DeprecatedAt: timestamp.New(deprecation.DeprecatedAt),
+            Link:         deprecation.Link,
+            Enforced:     s.enforce && deprecation.SunsetPassed(now),
+            Usage:        make([]*DeprecationUsage, 0),
+        }
+        if !deprecation.Sunset.IsZero() {
+            report.Sunset = timestamp.Ptr(deprecation.Sunset)
+        }
+        for _, usage := range s.recorder.Usage(deprecation.Feature) {
+            report.Usage = append(
+                report.Usage, &DeprecationUsage{
+                    Caller:   usage.Caller,
+                    Count:    usage.Count,
+                    LastSeen: timestamp.New(usage.LastSeen),
+                },
+            )
+        }
+        reports = append(reports, report)
+    }
+    return reports
+}
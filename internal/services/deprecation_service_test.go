@@ -0,0 +1,60 @@
+package services
+
+import (
+    "errors"
+    "net/http"
+    "net/url"
+    "testing"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/metrics"
+)
+
+func TestDeprecationService_Use(t *testing.T) {
+    deprecations := []*Deprecation{
+        {
+            Feature:      "sort_order",
+            Method:       http.MethodGet,
+            Path:         "/api/v1/tracking-data",
+            Param:        "sort_order",
+            DeprecatedAt: time.Now().Add(-time.Hour),
+            Sunset:       time.Now().Add(time.Hour),
+        },
+        {
+            Feature:      "old-endpoint",
+            Path:         "/api/v1/old",
+            DeprecatedAt: time.Now().Add(-2 * time.Hour),
+            Sunset:       time.Now().Add(-time.Hour),
+        },
+    }
+    s := NewMetricsDeprecationService(deprecations, metrics.NewDeprecationRecorder(), false)
+
+    used, err := s.Use(http.MethodGet, "/api/v1/tracking-data", url.Values{"sort_order": {"desc"}}, "alice")
+    if err != nil || len(used) != 1 || used[0].Feature != "sort_order" {
+        t.Fatalf("expected sort_order to be used, got %v, %v", used, err)
+    }
+    for _, query := range []url.Values{{}, {"sort_by": {"-created_at"}}} {
+        if used, _ = s.Use(http.MethodGet, "/api/v1/tracking-data", query, "alice"); len(used) != 0 {
+            t.Errorf("expected %v not to use a deprecated feature, got %v", query, used)
+        }
+    }
+    if used, _ = s.Use(http.MethodPost, "/api/v1/tracking-data", url.Values{"sort_order": {"desc"}}, "alice"); len(used) != 0 {
+        t.Errorf("expected other methods not to use sort_order, got %v", used)
+    }
+
+    if used, err = s.Use(http.MethodGet, "/api/v1/old", url.Values{}, "bob"); err != nil || len(used) != 1 {
+        t.Errorf("expected the sunset to only be announced without enforcement, got %v, %v", used, err)
+    }
+    enforced := NewMetricsDeprecationService(deprecations, metrics.NewDeprecationRecorder(), true)
+    if _, err = enforced.Use(http.MethodGet, "/api/v1/old", url.Values{}, "bob"); !errors.Is(err, ErrFeatureSunset) {
+        t.Errorf("expected ErrFeatureSunset past the sunset, got %v", err)
+    }
+
+    reports := s.Deprecations()
+    if len(reports) != 2 || len(reports[0].Usage) != 1 || reports[0].Usage[0].Caller != "alice" {
+        t.Fatalf("expected the use of sort_order by alice, got %+v", reports)
+    }
+    if reports[1].Enforced || !enforced.Deprecations()[1].Enforced {
+        t.Errorf("expected the old endpoint to only be enforced with enforcement")
+    }
+}
@@ -0,0 +1,192 @@
+package services
+
+import (
+    "context"
+    "regexp"
+    "runtime"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/metrics"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+)
+
+const (
+    HealthStatusOK    = "ok"
+    HealthStatusError = "error"
+
+    // diagnosticsErrorSamples is the number of most recent ingestion errors in a diagnostics bundle
+    diagnosticsErrorSamples = 20
+    // healthCheckTimeout bounds each health check, so an unreachable dependency can't stall the bundle
+    healthCheckTimeout = 5 * time.Second
+)
+
+// objectIDPattern matches object ids in error messages, like the ids of vehicles
+var objectIDPattern = regexp.MustCompile(`\b[0-9a-fA-F]{24}\b`)
+
+// HealthCheck checks a dependency of the service, Run may return details like queue depths
+type HealthCheck struct {
+    Name string
+    Run  func(ctx context.Context) (map[string]any, error)
+}
+
+type HealthCheckResult struct {
+    Name      string         `json:"name"`
+    Status    string         `json:"status"`
+    LatencyMs float64        `json:"latency_ms"`
+    Error     string         `json:"error,omitempty"`
+    Details   map[string]any `json:"details,omitempty"`
+}
+
+type RuntimeDiagnostics struct {
+    GoVersion      string `json:"go_version"`
+    OS             string `json:"os"`
+    Arch           string `json:"arch"`
+    CPUs           int    `json:"cpus"`
+    Goroutines     int    `json:"goroutines"`
+    HeapAllocBytes uint64 `json:"heap_alloc_bytes"`
+    SysBytes       uint64 `json:"sys_bytes"`
+    GCRuns         uint32 `json:"gc_runs"`
+}
+
+// IngestDiagnostics is a snapshot of the ingest metrics since the process started
+type IngestDiagnostics struct {
+    Since         timestamp.Time `json:"since"`
+    UptimeSeconds float64        `json:"uptime_seconds"`
+    Total         int64          `json:"total"`
+    Errors        int64          `json:"errors"`
+    ErrorRate     float64        `json:"error_rate"`
+    AvgLatencyMs  float64        `json:"avg_latency_ms"`
+    P95LatencyMs  float64        `json:"p95_latency_ms"`
+}
+
+// ErrorSample is a recent ingestion error without its payload and vehicle, ids in the error are redacted
+type ErrorSample struct {
+    Source    string         `json:"source"`
+    Reason    string         `json:"reason"`
+    Error     string         `json:"error"`
+    CreatedAt timestamp.Time `json:"created_at"`
+}
+
+// Diagnostics is a bundle for support tickets, it doesn't contain secrets or personal data like vehicles
+// and positions
+type Diagnostics struct {
+    GeneratedAt timestamp.Time       `json:"generated_at"`
+    Runtime     *RuntimeDiagnostics  `json:"runtime"`
+    Config      map[string]string    `json:"config"`
+    Checks      []*HealthCheckResult `json:"checks"`
+    // Ingest is only known by the running service, bundles generated by the diagnostics command don't have it
+    Ingest       *IngestDiagnostics `json:"ingest,omitempty"`
+    RecentErrors []*ErrorSample     `json:"recent_errors"`
+    // RecentErrorsError is why the recent errors couldn't be loaded, the rest of the bundle is still useful
+    RecentErrorsError string `json:"recent_errors_error,omitempty"`
+}
+
+type DiagnosticsService interface {
+    // Diagnostics collects the bundle, failing checks are reported in the bundle instead of failing it
+    Diagnostics(ctx context.Context) *Diagnostics
+}
+
+type MongoDiagnosticsService struct {
+    config             map[string]string
+    ingestionErrorRepo repositories.IngestionErrorRepository
+    recorder           *metrics.IngestRecorder
+    checks             []HealthCheck
+}
+
+// NewMongoDiagnosticsService creates the diagnostics service, config must already be redacted and
+// recorder is nil outside of the running service
+func NewMongoDiagnosticsService(
+    config map[string]string,
+    ingestionErrorRepo repositories.IngestionErrorRepository,
+    recorder *metrics.IngestRecorder,
+    checks []HealthCheck,
+) *MongoDiagnosticsService {
+    return &MongoDiagnosticsService{
+        config:             config,
+        ingestionErrorRepo: ingestionErrorRepo,
+        recorder:           recorder,
+        checks:             checks,
+    }
+}
+
+func (s *MongoDiagnosticsService) Diagnostics(ctx context.Context) *Diagnostics {
+    diagnostics := &Diagnostics{
+        GeneratedAt:  timestamp.Now(),
+        Runtime:      runtimeDiagnostics(),
+        Config:       s.config,
+        Checks:       make([]*HealthCheckResult, 0, len(s.checks)),
+        RecentErrors: make([]*ErrorSample, 0),
+    }
+
+    for _, check := range s.checks {
+        diagnostics.Checks = append(diagnostics.Checks, runHealthCheck(ctx, check))
+    }
+
+    if s.recorder != nil {
+        snapshot := s.recorder.Snapshot()
+        diagnostics.Ingest = &IngestDiagnostics{
+            Since:         timestamp.New(snapshot.Since),
+            UptimeSeconds: time.Since(snapshot.Since).Seconds(),
+            Total:         snapshot.Total,
+            Errors:        snapshot.Errors,
+            ErrorRate:     snapshot.ErrorRate(),
+            AvgLatencyMs:  float64(snapshot.AvgLatency) / float64(time.Millisecond),
+            P95LatencyMs:  float64(snapshot.P95Latency) / float64(time.Millisecond),
+        }
+    }
+
+    ingestionErrors, err := s.ingestionErrorRepo.FindIngestionErrors(
+        ctx,
+        &repositories.IngestionErrorFilter{PageSize: diagnosticsErrorSamples},
+    )
+    if err != nil {
+        diagnostics.RecentErrorsError = err.Error()
+        return diagnostics
+    }
+    for _, ingestionError := range ingestionErrors {
+        diagnostics.RecentErrors = append(
+            diagnostics.RecentErrors, &ErrorSample{
+                Source:    ingestionError.Source,
+                Reason:    ingestionError.Reason,
+                Error:     objectIDPattern.ReplaceAllString(ingestionError.Error, "<id>"),
+                CreatedAt: ingestionError.CreatedAt,
+            },
+        )
+    }
+    return diagnostics
+}
+
+func runHealthCheck(ctx context.Context, check HealthCheck) *HealthCheckResult {
+    ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+    defer cancel()
+
+    started := time.Now()
+    details, err := check.Run(ctx)
+    result := &HealthCheckResult{
+        Name:      check.Name,
+        Status:    HealthStatusOK,
+        LatencyMs: float64(time.Since(started)) / float64(time.Millisecond),
+        Details:   details,
+    }
+    if err != nil {
+        result.Status = HealthStatusError
+        result.Error = err.Error()
+    }
+    return result
+}
+
+func runtimeDiagnostics() *RuntimeDiagnostics {
+    var memStats runtime.MemStats
+    runtime.ReadMemStats(&memStats)
+    return &RuntimeDiagnostics{
+        GoVersion:      runtime.Version(),
+        OS:             runtime.GOOS,
+        Arch:           runtime.GOARCH,
+        CPUs:           runtime.NumCPU(),
+        Goroutines:     runtime.NumGoroutine(),
+        HeapAllocBytes: memStats.HeapAlloc,
+        SysBytes:       memStats.Sys,
+        GCRuns:         memStats.NumGC,
+    }
+}
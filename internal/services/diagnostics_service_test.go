@@ -0,0 +1,72 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "strings"
+    "testing"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+)
+
+type fakeIngestionErrorRepo struct {
+    repositories.IngestionErrorRepository
+    ingestionErrors []*repositories.IngestionError
+}
+
+func (r *fakeIngestionErrorRepo) FindIngestionErrors(
+    context.Context,
+    *repositories.IngestionErrorFilter,
+) ([]*repositories.IngestionError, error) {
+    return r.ingestionErrors, nil
+}
+
+func TestDiagnostics(t *testing.T) {
+    repo := &fakeIngestionErrorRepo{
+        ingestionErrors: []*repositories.IngestionError{
+            {
+                Source:    repositories.IngestionSourceHTTP,
+                Reason:    repositories.IngestionReasonInvalid,
+                Error:     "vehicle 6745a1b2c3d4e5f6a7b8c9d0 was not found",
+                VehicleID: "6745a1b2c3d4e5f6a7b8c9d0",
+                Payload:   `{"vehicle_id":"6745a1b2c3d4e5f6a7b8c9d0","lat":1.3,"lng":103.8}`,
+            },
+        },
+    }
+    s := NewMongoDiagnosticsService(
+        map[string]string{"HOST": "0.0.0.0"},
+        repo,
+        nil,
+        []HealthCheck{
+            {
+                Name: "healthy",
+                Run: func(context.Context) (map[string]any, error) {
+                    return map[string]any{"messages": 3}, nil
+                },
+            },
+            {
+                Name: "unreachable",
+                Run: func(context.Context) (map[string]any, error) {
+                    return nil, errors.New("connection refused")
+                },
+            },
+        },
+    )
+
+    diagnostics := s.Diagnostics(context.Background())
+    if len(diagnostics.Checks) != 2 ||
+        diagnostics.Checks[0].Status != HealthStatusOK ||
+        diagnostics.Checks[1].Status != HealthStatusError ||
+        diagnostics.Checks[1].Error != "connection refused" {
+        t.Errorf("expected a healthy and an unreachable check, got %+v, %+v", diagnostics.Checks[0], diagnostics.Checks[1])
+    }
+    if diagnostics.Ingest != nil {
+        t.Errorf("expected no ingest metrics without a recorder, got %+v", diagnostics.Ingest)
+    }
+    if len(diagnostics.RecentErrors) != 1 {
+        t.Fatalf("expected 1 recent error, got %d", len(diagnostics.RecentErrors))
+    }
+    if sample := diagnostics.RecentErrors[0]; strings.Contains(sample.Error, "6745a1b2c3d4e5f6a7b8c9d0") {
+        t.Errorf("expected the vehicle id to be redacted, got %q", sample.Error)
+    }
+}
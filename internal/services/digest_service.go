@@ -0,0 +1,302 @@
+package services
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "slices"
+    "strings"
+    "text/tabwriter"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/notifier"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+)
+
+type DigestRequest struct {
+    Periods    []string `json:"periods" validate:"required,min=1,max=2,unique,dive,oneof=daily weekly"`
+    Recipients []string `json:"recipients" validate:"required,min=1,max=20,unique,dive,email"`
+    // Active is true when it is left out
+    Active *bool `json:"active"`
+}
+
+// VehicleDigest is the summary of a vehicle over the period of a digest. Utilization is the share of its readings
+// reporting it ACTIVE and Alerts the alerts of the alert rules triggered for it.
+type VehicleDigest struct {
+    VehicleID      string  `json:"vehicle_id"`
+    Readings       int64   `json:"readings"`
+    DistanceMeters float64 `json:"distance_meters"`
+    MileageDelta   float64 `json:"mileage_delta"`
+    Utilization    float64 `json:"utilization"`
+    Alerts         int64   `json:"alerts"`
+}
+
+// Digest summarizes the fleet of a tenant over a period, the vehicles that drove the most first
+type Digest struct {
+    Period   ReportPeriod     `json:"period"`
+    From     timestamp.Time   `json:"from"`
+    To       timestamp.Time   `json:"to"`
+    Vehicles []*VehicleDigest `json:"vehicles"`
+}
+
+type DigestService interface {
+    // FindDigestSubscription returns the digest subscription of the tenant
+    FindDigestSubscription(ctx context.Context) (*repositories.DigestSubscription, error)
+    SaveDigestSubscription(ctx context.Context, req *DigestRequest) (*repositories.DigestSubscription, error)
+    // BuildDigest summarizes the fleet of the tenant over the last complete period before at
+    BuildDigest(ctx context.Context, period ReportPeriod, at time.Time) (*Digest, error)
+    // SendDigests emails the digests of the last complete period before at to the subscribed tenants, every digest
+    // once across the instances. It returns the number of digests sent.
+    SendDigests(ctx context.Context, period ReportPeriod, at time.Time) (int, error)
+}
+
+type MongoDigestService struct {
+    digestRepo repositories.DigestRepository
+    statsRepo  repositories.TrackingStatsRepository
+    // alertRepo counts the alerts of the vehicles, nil when the alert rules are disabled
+    alertRepo repositories.AlertRuleRepository
+    sender    notifier.Sender
+    // timeout bounds the sending of an email
+    timeout time.Duration
+}
+
+func NewMongoDigestService(
+    digestRepo repositories.DigestRepository,
+    statsRepo repositories.TrackingStatsRepository,
+    alertRepo repositories.AlertRuleRepository,
+    sender notifier.Sender,
+    timeout time.Duration,
+) *MongoDigestService {
+    return &MongoDigestService{
+        digestRepo: digestRepo,
+        statsRepo:  statsRepo,
+        alertRepo:  alertRepo,
+        sender:     sender,
+        timeout:    timeout,
+    }
+}
+
+func (s *MongoDigestService) FindDigestSubscription(ctx context.Context) (*repositories.DigestSubscription, error) {
+    return s.digestRepo.FindDigestSubscription(ctx)
+}
+
+func (s *MongoDigestService) SaveDigestSubscription(
+    ctx context.Context,
+    req *DigestRequest,
+) (*repositories.DigestSubscription, error) {
+    subscription := &repositories.DigestSubscription{
+        Periods:    req.Periods,
+        Recipients: req.Recipients,
+        Active:     req.Active == nil || *req.Active,
+    }
+    if err := s.digestRepo.SaveDigestSubscription(ctx, subscription); err != nil {
+        return nil, err
+    }
+    return subscription, nil
+}
+
+func (s *MongoDigestService) BuildDigest(ctx context.Context, period ReportPeriod, at time.Time) (*Digest, error) {
+    if err := period.Valid(); err != nil {
+        return nil, err
+    }
+    from, to := period.Bounds(at)
+    filter := &repositories.TrackingStatsFilter{From: from.Format(time.RFC3339), To: to.Format(time.RFC3339)}
+    if err := filter.Build(); err != nil {
+        return nil, err
+    }
+    stats, err := s.statsRepo.FindVehicleStats(ctx, filter)
+    if err != nil {
+        return nil, err
+    }
+    alerts := map[string]int64{}
+    if s.alertRepo != nil {
+        counts, err := s.alertRepo.CountAlerts(ctx, from, to)
+        if err != nil {
+            return nil, err
+        }
+        for vehicleID, count := range counts {
+            alerts[vehicleID.Hex()] = count
+        }
+    }
+
+    digest := &Digest{
+        Period:   period,
+        From:     timestamp.New(from),
+        To:       timestamp.New(to),
+        Vehicles: make([]*VehicleDigest, 0, len(stats)),
+    }
+    for _, vehicle := range stats {
+        vehicleDigest := &VehicleDigest{
+            VehicleID:      vehicle.VehicleID.Hex(),
+            Readings:       vehicle.Readings,
+            DistanceMeters: vehicle.DistanceMeters,
+            MileageDelta:   vehicle.MileageDelta,
+            Alerts:         alerts[vehicle.VehicleID.Hex()],
+        }
+        if vehicle.Readings > 0 {
+            active := vehicle.Statuses[string(models.VehicleStatusActive)]
+            vehicleDigest.Utilization = float64(active) / float64(vehicle.Readings)
+        }
+        digest.Vehicles = append(digest.Vehicles, vehicleDigest)
+    }
+    slices.SortFunc(
+        digest.Vehicles, func(a, b *VehicleDigest) int {
+            if a.DistanceMeters != b.DistanceMeters {
+                if a.DistanceMeters > b.DistanceMeters {
+                    return -1
+                }
+                return 1
+            }
+            return strings.Compare(a.VehicleID, b.VehicleID)
+        },
+    )
+    return digest, nil
+}
+
+func (s *MongoDigestService) SendDigests(ctx context.Context, period ReportPeriod, at time.Time) (int, error) {
+    subscriptions, err := s.digestRepo.FindActiveDigestSubscriptions(ctx)
+    if err != nil {
+        return 0, err
+    }
+    from, _ := period.Bounds(at)
+    sent := 0
+    for _, subscription := range subscriptions {
+        if !slices.Contains(subscription.Periods, string(period)) {
+            continue
+        }
+        claimed, err := s.digestRepo.ClaimDigest(ctx, subscription.TenantID, string(period), from)
+        if err != nil {
+            return sent, err
+        }
+        // another instance sends it
+        if !claimed {
+            continue
+        }
+        if err = s.send(ctx, subscription, period, at); err != nil {
+            log.Printf("Failed to send the %s digest of tenant %q: %v", period, subscription.TenantID, err)
+            continue
+        }
+        sent++
+    }
+    return sent, nil
+}
+
+// send builds the digest of the tenant of the subscription and emails it to every recipient
+func (s *MongoDigestService) send(
+    ctx context.Context,
+    subscription *repositories.DigestSubscription,
+    period ReportPeriod,
+    at time.Time,
+) error {
+    tenantCtx := ctx
+    if subscription.TenantID != "" {
+        tenantCtx = tenant.WithID(ctx, subscription.TenantID)
+    }
+    digest, err := s.BuildDigest(tenantCtx, period, at)
+    if err != nil {
+        return err
+    }
+    subject, text := FormatDigest(digest)
+    var errs []error
+    for _, recipient := range subscription.Recipients {
+        sendCtx, cancel := context.WithTimeout(ctx, s.timeout)
+        err := s.sender.Send(sendCtx, &notifier.Message{To: recipient, Subject: subject, Text: text})
+        cancel()
+        if err != nil {
+            errs = append(errs, fmt.Errorf("%s: %w", recipient, err))
+        }
+    }
+    if len(errs) > 0 {
+        return fmt.Errorf("failed to email %d of %d recipients: %w", len(errs), len(subscription.Recipients), errs[0])
+    }
+    return nil
+}
+
+// FormatDigest returns the subject and the plain text of the digest email, a table of the vehicles and their total
+func FormatDigest(digest *Digest) (string, string) {
+    from, to := digest.From.Format(time.DateOnly), digest.To.AddDate(0, 0, -1).Format(time.DateOnly)
+    days := from
+    if from != to {
+        days = from + " to " + to
+    }
+    subject := fmt.Sprintf("Fleet %s digest of %s", digest.Period, days)
+
+    var b strings.Builder
+    fmt.Fprintf(&b, "%s (UTC), %d vehicles reported.\n\n", subject, len(digest.Vehicles))
+    w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', tabwriter.AlignRight)
+    fmt.Fprintln(w, "Vehicle\tReadings\tDistance (km)\tMileage\tUtilization\tAlerts\t")
+    var total VehicleDigest
+    for _, vehicle := range digest.Vehicles {
+        fmt.Fprintf(
+            w,
+            "%s\t%d\t%.1f\t%.1f\t%.0f%%\t%d\t\n",
+            vehicle.VehicleID,
+            vehicle.Readings,
+            vehicle.DistanceMeters/1000,
+            vehicle.MileageDelta,
+            vehicle.Utilization*100,
+            vehicle.Alerts,
+        )
+        total.Readings += vehicle.Readings
+        total.DistanceMeters += vehicle.DistanceMeters
+        total.MileageDelta += vehicle.MileageDelta
+        total.Alerts += vehicle.Alerts
+    }
+    fmt.Fprintf(
+        w,
+        "Total\t%d\t%.1f\t%.1f\t\t%d\t\n",
+        total.Readings,
+        total.DistanceMeters/1000,
+        total.MileageDelta,
+        total.Alerts,
+    )
+    _ = w.Flush()
+    return subject, b.String()
+}
+
+// DigestScheduler emails the digests delay after midnight UTC, the weekly ones on Monday
+type DigestScheduler struct {
+    digestService DigestService
+    // delay gives late readings some time to arrive before a period is summarized
+    delay time.Duration
+}
+
+func NewDigestScheduler(digestService DigestService, delay time.Duration) *DigestScheduler {
+    return &DigestScheduler{digestService: digestService, delay: delay}
+}
+
+// Run blocks until ctx is done, sending the digests of every period once it is complete
+func (s *DigestScheduler) Run(ctx context.Context) {
+    for {
+        now := time.Now().UTC()
+        _, next := ReportPeriodDaily.Bounds(now.Add(-s.delay))
+        next = next.AddDate(0, 0, 1).Add(s.delay)
+
+        timer := time.NewTimer(next.Sub(now))
+        select {
+        case <-ctx.Done():
+            timer.Stop()
+            return
+        case at := <-timer.C:
+            s.send(ctx, at.UTC().Add(-s.delay))
+        }
+    }
+}
+
+func (s *DigestScheduler) send(ctx context.Context, at time.Time) {
+    for _, period := range []ReportPeriod{ReportPeriodDaily, ReportPeriodWeekly} {
+        // weekly digests are only due on the first day of the week
+        if period == ReportPeriodWeekly && at.Weekday() != time.Monday {
+            continue
+        }
+        sent, err := s.digestService.SendDigests(ctx, period, at)
+        if err != nil {
+            log.Printf("Failed to send the %s digests: %v", period, err)
+            continue
+        }
+        log.Printf("Sent %d %s digests", sent, period)
+    }
+}
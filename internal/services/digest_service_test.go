@@ -0,0 +1,187 @@
+package services
+
+import (
+    "context"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type fakeDigestRepo struct {
+    repositories.DigestRepository
+    subscriptions []*repositories.DigestSubscription
+}
+
+func (repo *fakeDigestRepo) FindActiveDigestSubscriptions(context.Context) ([]*repositories.DigestSubscription, error) {
+    var active []*repositories.DigestSubscription
+    for _, subscription := range repo.subscriptions {
+        if subscription.Active {
+            active = append(active, subscription)
+        }
+    }
+    return active, nil
+}
+
+func (repo *fakeDigestRepo) ClaimDigest(
+    _ context.Context,
+    tenantID string,
+    period string,
+    from time.Time,
+) (bool, error) {
+    for _, subscription := range repo.subscriptions {
+        if subscription.TenantID != tenantID {
+            continue
+        }
+        if sent, ok := subscription.Sent[period]; ok && !sent.Before(from) {
+            return false, nil
+        }
+        if subscription.Sent == nil {
+            subscription.Sent = map[string]timestamp.Time{}
+        }
+        subscription.Sent[period] = timestamp.New(from)
+        return true, nil
+    }
+    return false, nil
+}
+
+type fakeVehicleStatsRepo struct {
+    repositories.TrackingStatsRepository
+    stats   []*repositories.VehicleStats
+    filters []*repositories.TrackingStatsFilter
+}
+
+func (repo *fakeVehicleStatsRepo) FindVehicleStats(
+    _ context.Context,
+    filter *repositories.TrackingStatsFilter,
+) ([]*repositories.VehicleStats, error) {
+    repo.filters = append(repo.filters, filter)
+    return repo.stats, nil
+}
+
+type fakeAlertCountRepo struct {
+    repositories.AlertRuleRepository
+    counts map[primitive.ObjectID]int64
+}
+
+func (repo *fakeAlertCountRepo) CountAlerts(
+    context.Context,
+    time.Time,
+    time.Time,
+) (map[primitive.ObjectID]int64, error) {
+    return repo.counts, nil
+}
+
+func TestMongoDigestService_BuildDigest(t *testing.T) {
+    short, long := primitive.NewObjectID(), primitive.NewObjectID()
+    active := string(models.VehicleStatusActive)
+    statsRepo := &fakeVehicleStatsRepo{
+        stats: []*repositories.VehicleStats{
+            {VehicleID: short, Readings: 4, DistanceMeters: 1500, Statuses: map[string]int64{active: 1}},
+            {VehicleID: long, Readings: 10, DistanceMeters: 42000, Statuses: map[string]int64{active: 8}},
+        },
+    }
+    alertRepo := &fakeAlertCountRepo{counts: map[primitive.ObjectID]int64{short: 3}}
+    svc := NewMongoDigestService(&fakeDigestRepo{}, statsRepo, alertRepo, &recordingSender{}, time.Second)
+
+    at := time.Date(2025, time.March, 4, 0, 30, 0, 0, time.UTC)
+    digest, err := svc.BuildDigest(context.Background(), ReportPeriodDaily, at)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !digest.From.Equal(time.Date(2025, time.March, 3, 0, 0, 0, 0, time.UTC)) || !digest.To.Equal(
+        time.Date(2025, time.March, 4, 0, 0, 0, 0, time.UTC),
+    ) {
+        t.Fatalf("expected the digest of March 3, got %v to %v", digest.From, digest.To)
+    }
+    if len(digest.Vehicles) != 2 || digest.Vehicles[0].VehicleID != long.Hex() {
+        t.Fatalf("expected the vehicle that drove the most first, got %+v", digest.Vehicles)
+    }
+    if digest.Vehicles[0].Utilization != 0.8 || digest.Vehicles[1].Utilization != 0.25 {
+        t.Fatalf(
+            "expected utilizations of 0.8 and 0.25, got %v and %v",
+            digest.Vehicles[0].Utilization,
+            digest.Vehicles[1].Utilization,
+        )
+    }
+    if digest.Vehicles[0].Alerts != 0 || digest.Vehicles[1].Alerts != 3 {
+        t.Fatalf("expected the alerts of the second vehicle, got %+v", digest.Vehicles)
+    }
+
+    if _, err = svc.BuildDigest(context.Background(), "monthly", at); err == nil {
+        t.Fatal("expected an unknown period to fail")
+    }
+}
+
+func TestMongoDigestService_SendDigests(t *testing.T) {
+    digestRepo := &fakeDigestRepo{
+        subscriptions: []*repositories.DigestSubscription{
+            {
+                TenantID:   "acme",
+                Periods:    []string{"daily"},
+                Recipients: []string{"a@example.com", "b@example.com"},
+                Active:     true,
+            },
+            {TenantID: "globex", Periods: []string{"weekly"}, Recipients: []string{"c@example.com"}, Active: true},
+            {TenantID: "initech", Periods: []string{"daily"}, Recipients: []string{"d@example.com"}},
+        },
+    }
+    statsRepo := &fakeVehicleStatsRepo{}
+    sender := &recordingSender{}
+    svc := NewMongoDigestService(digestRepo, statsRepo, nil, sender, time.Second)
+
+    at := time.Date(2025, time.March, 4, 0, 30, 0, 0, time.UTC)
+    sent, err := svc.SendDigests(context.Background(), ReportPeriodDaily, at)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if sent != 1 || len(sender.messages) != 2 {
+        t.Fatalf(
+            "expected the daily digest of acme to its 2 recipients, got %d digests and %d messages",
+            sent,
+            len(sender.messages),
+        )
+    }
+    if sender.messages[0].Subject != "Fleet daily digest of 2025-03-03" {
+        t.Fatalf("unexpected subject %q", sender.messages[0].Subject)
+    }
+
+    // the digest was claimed, another instance doesn't send it again
+    sent, err = svc.SendDigests(context.Background(), ReportPeriodDaily, at.Add(time.Minute))
+    if err != nil || sent != 0 {
+        t.Fatalf("expected no digest to be sent twice, got %d, %v", sent, err)
+    }
+    sent, err = svc.SendDigests(context.Background(), ReportPeriodDaily, at.AddDate(0, 0, 1))
+    if err != nil || sent != 1 {
+        t.Fatalf("expected the digest of the next day, got %d, %v", sent, err)
+    }
+}
+
+func TestFormatDigest(t *testing.T) {
+    digest := &Digest{
+        Period: ReportPeriodWeekly,
+        From:   timestamp.New(time.Date(2025, time.March, 3, 0, 0, 0, 0, time.UTC)),
+        To:     timestamp.New(time.Date(2025, time.March, 10, 0, 0, 0, 0, time.UTC)),
+        Vehicles: []*VehicleDigest{
+            {VehicleID: "v1", Readings: 10, DistanceMeters: 42000, MileageDelta: 41.5, Utilization: 0.8, Alerts: 1},
+            {VehicleID: "v2", Readings: 4, DistanceMeters: 1500, MileageDelta: 1.5, Utilization: 0.25, Alerts: 2},
+        },
+    }
+    subject, text := FormatDigest(digest)
+    if subject != "Fleet weekly digest of 2025-03-03 to 2025-03-09" {
+        t.Fatalf("unexpected subject %q", subject)
+    }
+    for _, want := range []string{"2 vehicles reported", "42.0", "80%", "25%"} {
+        if !strings.Contains(text, want) {
+            t.Fatalf("expected %q in the digest, got\n%s", want, text)
+        }
+    }
+    lines := strings.Split(strings.TrimSpace(text), "\n")
+    if total := strings.Fields(lines[len(lines)-1]); strings.Join(total, " ") != "Total 14 43.5 43.0 3" {
+        t.Fatalf("unexpected total %q", lines[len(lines)-1])
+    }
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "log"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type DriverAssignmentRequest struct {
+    VehicleID string `json:"vehicle_id" validate:"required,mongodb"`
+    DriverID  string `json:"driver_id" validate:"required,max=64"`
+}
+
+type DriverService interface {
+    FindAssignments(ctx context.Context) ([]*repositories.DriverAssignment, error)
+    // SetAssignment assigns the driver to the vehicle, replacing the driver assigned to it before
+    SetAssignment(ctx context.Context, req *DriverAssignmentRequest) (*repositories.DriverAssignment, error)
+    // DeleteAssignment unassigns the driver of the vehicle, the vehicle isn't driven by anyone known anymore
+    DeleteAssignment(ctx context.Context, vehicleID string) error
+}
+
+type MongoDriverService struct {
+    assignmentRepo repositories.DriverAssignmentRepository
+}
+
+func NewMongoDriverService(assignmentRepo repositories.DriverAssignmentRepository) *MongoDriverService {
+    return &MongoDriverService{assignmentRepo: assignmentRepo}
+}
+
+func (s *MongoDriverService) FindAssignments(ctx context.Context) ([]*repositories.DriverAssignment, error) {
+    return s.assignmentRepo.FindDriverAssignments(ctx)
+}
+
+func (s *MongoDriverService) SetAssignment(
+    ctx context.Context,
+    req *DriverAssignmentRequest,
+) (*repositories.DriverAssignment, error) {
+    id, err := primitive.ObjectIDFromHex(req.VehicleID)
+    if err != nil {
+        return nil, repositories.ErrInvalidID
+    }
+    assignment := &repositories.DriverAssignment{VehicleID: id, DriverID: req.DriverID}
+    if err := s.assignmentRepo.UpsertDriverAssignment(ctx, assignment); err != nil {
+        return nil, err
+    }
+    return assignment, nil
+}
+
+func (s *MongoDriverService) DeleteAssignment(ctx context.Context, vehicleID string) error {
+    id, err := primitive.ObjectIDFromHex(vehicleID)
+    if err != nil {
+        return repositories.ErrInvalidID
+    }
+    return s.assignmentRepo.DeleteDriverAssignment(ctx, id)
+}
+
+// DriverAssignmentProcessor is an ingestion processor attributing the readings sent without a driver to the driver
+// assigned to their vehicle. Backfilled readings were taken before the current assignment, they are left without one.
+type DriverAssignmentProcessor struct {
+    assignmentRepo repositories.DriverAssignmentRepository
+}
+
+func NewDriverAssignmentProcessor(assignmentRepo repositories.DriverAssignmentRepository) *DriverAssignmentProcessor {
+    return &DriverAssignmentProcessor{assignmentRepo: assignmentRepo}
+}
+
+// PreValidate never rejects a reading, a failed lookup is logged and the reading is stored without a driver
+func (p *DriverAssignmentProcessor) PreValidate(ctx context.Context, req *TrackingDataRequest) error {
+    if req.DriverID != "" || req.Backfill {
+        return nil
+    }
+    vehicleID, err := primitive.ObjectIDFromHex(req.VehicleID)
+    if err != nil {
+        // the invalid vehicle id is rejected by the validation
+        return nil
+    }
+    assignment, err := p.assignmentRepo.FindDriverAssignment(ctx, vehicleID)
+    switch {
+    case errors.Is(err, repositories.ErrNotFound):
+    case err != nil:
+        log.Printf("Error finding the driver of vehicle %s: %v", req.VehicleID, err)
+    default:
+        req.DriverID = assignment.DriverID
+    }
+    return nil
+}
+
+func (p *DriverAssignmentProcessor) PostPersist(context.Context, *repositories.TrackingRecord) error {
+    return nil
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "testing"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeDriverAssignmentRepo keeps the drivers by vehicle, lookups fail with err when it is set
+type fakeDriverAssignmentRepo struct {
+    drivers map[primitive.ObjectID]string
+    err     error
+}
+
+func (r *fakeDriverAssignmentRepo) FindDriverAssignment(
+    _ context.Context,
+    vehicleID primitive.ObjectID,
+) (*repositories.DriverAssignment, error) {
+    if r.err != nil {
+        return nil, r.err
+    }
+    driverID, ok := r.drivers[vehicleID]
+    if !ok {
+        return nil, repositories.ErrNotFound
+    }
+    return &repositories.DriverAssignment{VehicleID: vehicleID, DriverID: driverID}, nil
+}
+
+func (r *fakeDriverAssignmentRepo) FindDriverAssignments(context.Context) ([]*repositories.DriverAssignment, error) {
+    return nil, nil
+}
+
+func (r *fakeDriverAssignmentRepo) UpsertDriverAssignment(
+    _ context.Context,
+    assignment *repositories.DriverAssignment,
+) error {
+    r.drivers[assignment.VehicleID] = assignment.DriverID
+    return nil
+}
+
+func (r *fakeDriverAssignmentRepo) DeleteDriverAssignment(_ context.Context, vehicleID primitive.ObjectID) error {
+    if _, ok := r.drivers[vehicleID]; !ok {
+        return repositories.ErrNotFound
+    }
+    delete(r.drivers, vehicleID)
+    return nil
+}
+
+func TestDriverAssignmentProcessor_PreValidate(t *testing.T) {
+    assigned, unassigned := primitive.NewObjectID(), primitive.NewObjectID()
+    repo := &fakeDriverAssignmentRepo{drivers: map[primitive.ObjectID]string{assigned: "driver-1"}}
+    p := NewDriverAssignmentProcessor(repo)
+    ctx := context.Background()
+
+    req := vehicleReading(assigned.Hex())
+    if err := p.PreValidate(ctx, req); err != nil {
+        t.Fatal(err)
+    }
+    if req.DriverID != "driver-1" {
+        t.Errorf("expected the assigned driver, got %q", req.DriverID)
+    }
+    // the driver sent with the reading is kept
+    req = vehicleReading(assigned.Hex())
+    req.DriverID = "driver-2"
+    if err := p.PreValidate(ctx, req); err != nil {
+        t.Fatal(err)
+    }
+    if req.DriverID != "driver-2" {
+        t.Errorf("expected the driver of the reading, got %q", req.DriverID)
+    }
+    // backfilled readings were taken before the current assignment
+    req = vehicleReading(assigned.Hex())
+    req.Backfill = true
+    if err := p.PreValidate(ctx, req); err != nil || req.DriverID != "" {
+        t.Errorf("expected a backfilled reading without a driver, got %q, %v", req.DriverID, err)
+    }
+    req = vehicleReading(unassigned.Hex())
+    if err := p.PreValidate(ctx, req); err != nil || req.DriverID != "" {
+        t.Errorf("expected no driver for an unassigned vehicle, got %q, %v", req.DriverID, err)
+    }
+
+    // a failed lookup doesn't reject the reading
+    repo.err = repositories.ErrTransient
+    req = vehicleReading(assigned.Hex())
+    if err := p.PreValidate(ctx, req); err != nil || req.DriverID != "" {
+        t.Errorf("expected the reading without a driver, got %q, %v", req.DriverID, err)
+    }
+}
+
+func TestMongoDriverService_Assignments(t *testing.T) {
+    repo := &fakeDriverAssignmentRepo{drivers: map[primitive.ObjectID]string{}}
+    s := NewMongoDriverService(repo)
+    ctx := context.Background()
+    vehicleID := primitive.NewObjectID()
+
+    assignment, err := s.SetAssignment(ctx, &DriverAssignmentRequest{VehicleID: vehicleID.Hex(), DriverID: "driver-1"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if assignment.VehicleID != vehicleID || repo.drivers[vehicleID] != "driver-1" {
+        t.Errorf("expected the driver to be assigned, got %+v", assignment)
+    }
+    if err = s.DeleteAssignment(ctx, vehicleID.Hex()); err != nil {
+        t.Fatal(err)
+    }
+    if err = s.DeleteAssignment(ctx, vehicleID.Hex()); !errors.Is(err, repositories.ErrNotFound) {
+        t.Errorf("expected no assignment left, got %v", err)
+    }
+    if err = s.DeleteAssignment(ctx, "42"); !errors.Is(err, repositories.ErrInvalidID) {
+        t.Errorf("expected an invalid vehicle id, got %v", err)
+    }
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+    "context"
+    "sync"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Freshness tells how old the latest reading of a vehicle is, a vehicle is stale when it didn't report
+// within its expected interval
+type Freshness struct {
+    AgeSeconds              float64 `json:"age_seconds"`
+    IsStale                 bool    `json:"is_stale"`
+    ExpectedIntervalSeconds float64 `json:"expected_interval_seconds"`
+}
+
+type ExpectedIntervalRequest struct {
+    VehicleID       string  `json:"vehicle_id" validate:"required,mongodb"`
+    IntervalSeconds float64 `json:"interval_seconds" validate:"required,gt=0"`
+}
+
+type FreshnessService interface {
+    // Freshness computes the freshness of every vehicle from the time of its latest reading
+    Freshness(
+        ctx context.Context,
+        lastSeen map[primitive.ObjectID]time.Time,
+        now time.Time,
+    ) (map[primitive.ObjectID]*Freshness, error)
+    // ExpectedIntervals returns the expected interval of every vehicle, the default one for vehicles without their own
+    ExpectedIntervals(
+        ctx context.Context,
+        vehicleIDs []primitive.ObjectID,
+    ) (map[primitive.ObjectID]time.Duration, error)
+    FindExpectedIntervals(ctx context.Context) ([]*repositories.ExpectedInterval, error)
+    SetExpectedInterval(ctx context.Context, req *ExpectedIntervalRequest) (*repositories.ExpectedInterval, error)
+}
+
+type MongoFreshnessService struct {
+    intervalRepo repositories.ExpectedIntervalRepository
+    // defaultInterval applies to vehicles without their own expected interval, it is reloadable
+    mu              sync.RWMutex
+    defaultInterval time.Duration
+}
+
+func NewMongoFreshnessService(
+    intervalRepo repositories.ExpectedIntervalRepository,
+    defaultInterval time.Duration,
+) *MongoFreshnessService {
+    return &MongoFreshnessService{intervalRepo: intervalRepo, defaultInterval: defaultInterval}
+}
+
+// SetDefaultInterval changes the expected interval of the vehicles without their own
+func (s *MongoFreshnessService) SetDefaultInterval(defaultInterval time.Duration) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.defaultInterval = defaultInterval
+}
+
+func (s *MongoFreshnessService) Freshness(
+    ctx context.Context,
+    lastSeen map[primitive.ObjectID]time.Time,
+    now time.Time,
+) (map[primitive.ObjectID]*Freshness, error) {
+    vehicleIDs := make([]primitive.ObjectID, 0, len(lastSeen))
+    for vehicleID := range lastSeen {
+        vehicleIDs = append(vehicleIDs, vehicleID)
+    }
+    intervals, err := s.ExpectedIntervals(ctx, vehicleIDs)
+    if err != nil {
+        return nil, err
+    }
+
+    freshness := make(map[primitive.ObjectID]*Freshness, len(lastSeen))
+    for vehicleID, seen := range lastSeen {
+        freshness[vehicleID] = ComputeFreshness(seen, now, intervals[vehicleID])
+    }
+    return freshness, nil
+}
+
+func (s *MongoFreshnessService) ExpectedIntervals(
+    ctx context.Context,
+    vehicleIDs []primitive.ObjectID,
+) (map[primitive.ObjectID]time.Duration, error) {
+    expected, err := s.intervalRepo.FindExpectedIntervals(ctx, vehicleIDs)
+    if err != nil {
+        return nil, err
+    }
+
+    s.mu.RLock()
+    defaultInterval := s.defaultInterval
+    s.mu.RUnlock()
+    intervals := make(map[primitive.ObjectID]time.Duration, len(vehicleIDs))
+    for _, vehicleID := range vehicleIDs {
+        intervals[vehicleID] = defaultInterval
+        if interval, ok := expected[vehicleID]; ok {
+            intervals[vehicleID] = time.Duration(interval.IntervalSeconds * float64(time.Second))
+        }
+    }
+    return intervals, nil
+}
+
+func (s *MongoFreshnessService) FindExpectedIntervals(ctx context.Context) ([]*repositories.ExpectedInterval, error) {
+    return s.intervalRepo.FindAllExpectedIntervals(ctx)
+}
+
+func (s *MongoFreshnessService) SetExpectedInterval(
+    ctx context.Context,
+    req *ExpectedIntervalRequest,
+) (*repositories.ExpectedInterval, error) {
+    id, err := primitive.ObjectIDFromHex(req.VehicleID)
+    if err != nil {
+        return nil, repositories.ErrInvalidID
+    }
+    interval := &repositories.ExpectedInterval{VehicleID: id, IntervalSeconds: req.IntervalSeconds}
+    if err := s.intervalRepo.UpsertExpectedInterval(ctx, interval); err != nil {
+        return nil, err
+    }
+    return interval, nil
+}
+
+// ComputeFreshness returns the freshness of a reading taken at seen, readings from the future
+// (device clock ahead of ours) have an age of 0
+func ComputeFreshness(seen, now time.Time, interval time.Duration) *Freshness {
+    age := max(now.Sub(seen), 0)
+    return &Freshness{
+        AgeSeconds:              age.Seconds(),
+        IsStale:                 age > interval,
+        ExpectedIntervalSeconds: interval.Seconds(),
+    }
+}
@@ -0,0 +1,25 @@
+package services
+
+import (
+    "testing"
+    "time"
+)
+
+func TestComputeFreshness(t *testing.T) {
+    now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+    fresh := ComputeFreshness(now.Add(-time.Minute), now, 5*time.Minute)
+    if fresh.AgeSeconds != 60 || fresh.IsStale || fresh.ExpectedIntervalSeconds != 300 {
+        t.Errorf("expected a fresh reading of 60 seconds, got %+v", fresh)
+    }
+
+    stale := ComputeFreshness(now.Add(-10*time.Minute), now, 5*time.Minute)
+    if !stale.IsStale {
+        t.Errorf("expected a stale reading, got %+v", stale)
+    }
+
+    future := ComputeFreshness(now.Add(time.Minute), now, 5*time.Minute)
+    if future.AgeSeconds != 0 || future.IsStale {
+        t.Errorf("expected a reading from the future to be fresh, got %+v", future)
+    }
+}
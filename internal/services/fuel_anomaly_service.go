@@ -0,0 +1,204 @@
+package services
+
+import (
+    "context"
+    "log"
+    "net/url"
+    "sync"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+)
+
+const (
+    FuelAnomalyEvent = "fuel.anomaly"
+
+    // minFuelLevelsDropped is the smallest drop considered, e.g. Full→Low or Half→Empty
+    minFuelLevelsDropped = 2
+)
+
+// fuelLevels orders the fuel conditions, each level is roughly a third of the tank
+var fuelLevels = map[models.FuelCondition]int{
+    models.FuelConditionEmpty: 0,
+    models.FuelConditionLow:   1,
+    models.FuelConditionHalf:  2,
+    models.FuelConditionFull:  3,
+}
+
+// FuelAnomalyAlert is the event published to the alerts queue for every detected anomaly
+type FuelAnomalyAlert struct {
+    Event   string                    `json:"event"`
+    Anomaly *repositories.FuelAnomaly `json:"anomaly"`
+}
+
+type FuelAnomalyService interface {
+    // Inspect compares a stored reading with the previous reading of the vehicle, returning the anomaly if any
+    Inspect(ctx context.Context, trackingData *repositories.TrackingRecord) (*repositories.FuelAnomaly, error)
+    FindFuelAnomalies(ctx context.Context, query url.Values) ([]*repositories.FuelAnomaly, error)
+}
+
+type MongoFuelAnomalyService struct {
+    trackingRepo    repositories.TrackingRepository
+    anomalyRepo     repositories.FuelAnomalyRepository
+    alertsPublisher Publisher
+    // mileagePerLevel is the mileage a vehicle is expected to drive on one fuel level, it is reloadable
+    mu              sync.RWMutex
+    mileagePerLevel float64
+}
+
+func NewMongoFuelAnomalyService(
+    trackingRepo repositories.TrackingRepository,
+    anomalyRepo repositories.FuelAnomalyRepository,
+    alertsPublisher Publisher,
+    mileagePerLevel float64,
+) *MongoFuelAnomalyService {
+    return &MongoFuelAnomalyService{
+        trackingRepo:    trackingRepo,
+        anomalyRepo:     anomalyRepo,
+        alertsPublisher: alertsPublisher,
+        mileagePerLevel: mileagePerLevel,
+    }
+}
+
+// SetMileagePerLevel changes the mileage expected per fuel level of the next inspected readings
+func (s *MongoFuelAnomalyService) SetMileagePerLevel(mileagePerLevel float64) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.mileagePerLevel = mileagePerLevel
+}
+
+func (s *MongoFuelAnomalyService) Inspect(
+    ctx context.Context,
+    trackingData *repositories.TrackingRecord,
+) (*repositories.FuelAnomaly, error) {
+    previous, err := findPreviousTrackingData(ctx, s.trackingRepo, trackingData)
+    if err != nil || previous == nil {
+        return nil, err
+    }
+
+    s.mu.RLock()
+    mileagePerLevel := s.mileagePerLevel
+    s.mu.RUnlock()
+    anomaly := DetectFuelAnomaly(previous, trackingData, mileagePerLevel)
+    if anomaly == nil {
+        return nil, nil
+    }
+    anomaly.ReadingAt = timestamp.New(readingTime(trackingData))
+    if err := s.anomalyRepo.CreateFuelAnomaly(ctx, anomaly); err != nil {
+        return nil, err
+    }
+    // the reading is flagged so analytical queries can leave it out with exclude=anomalies
+    if err := s.trackingRepo.FlagTrackingData(ctx, trackingData, repositories.FlagAnomaly); err != nil {
+        return nil, err
+    }
+
+    alert, err := json.Marshal(&FuelAnomalyAlert{Event: FuelAnomalyEvent, Anomaly: anomaly})
+    if err != nil {
+        return anomaly, err
+    }
+    return anomaly, s.alertsPublisher.Publish(ctx, alert)
+}
+
+func (s *MongoFuelAnomalyService) FindFuelAnomalies(
+    ctx context.Context,
+    query url.Values,
+) ([]*repositories.FuelAnomaly, error) {
+    var filter repositories.FuelAnomalyFilter
+    if err := decodeQuery(query, &filter); err != nil {
+        return nil, err
+    }
+    return s.anomalyRepo.FindFuelAnomalies(ctx, &filter)
+}
+
+// DetectFuelAnomaly flags a drop of at least two fuel levels between consecutive readings when the vehicle
+// drove less than mileagePerLevel for each level lost, refuelling and normal consumption aren't flagged
+func DetectFuelAnomaly(
+    previous, current *repositories.TrackingRecord,
+    mileagePerLevel float64,
+) *repositories.FuelAnomaly {
+    from, ok := fuelLevels[previous.FuelCondition]
+    if !ok {
+        return nil
+    }
+    to, ok := fuelLevels[current.FuelCondition]
+    if !ok {
+        return nil
+    }
+    dropped := from - to
+    if dropped < minFuelLevelsDropped {
+        return nil
+    }
+
+    mileageDelta := current.Mileage - previous.Mileage
+    expected := float64(dropped) * mileagePerLevel
+    if mileageDelta >= expected {
+        return nil
+    }
+    return &repositories.FuelAnomaly{
+        VehicleID:              current.VehicleID,
+        TrackingDataID:         current.ID,
+        TrackingDataPublicID:   current.PublicID,
+        PreviousTrackingDataID: previous.ID,
+        FromCondition:          previous.FuelCondition,
+        ToCondition:            current.FuelCondition,
+        LevelsDropped:          dropped,
+        MileageDelta:           mileageDelta,
+        ExpectedMileage:        expected,
+    }
+}
+
+// FuelMonitoringTrackingService inspects every stored reading of the wrapped service for fuel anomalies.
+// Detection failures are logged and never fail the ingest.
+type FuelMonitoringTrackingService struct {
+    TrackingService
+    fuelAnomalyService FuelAnomalyService
+}
+
+func NewFuelMonitoringTrackingService(
+    trackingService TrackingService,
+    fuelAnomalyService FuelAnomalyService,
+) *FuelMonitoringTrackingService {
+    return &FuelMonitoringTrackingService{TrackingService: trackingService, fuelAnomalyService: fuelAnomalyService}
+}
+
+func (s *FuelMonitoringTrackingService) TrackVehicle(
+    ctx context.Context,
+    req *TrackingDataRequest,
+) (*repositories.TrackingRecord, error) {
+    trackingData, err := s.TrackingService.TrackVehicle(ctx, req)
+    if err == nil {
+        s.inspect(ctx, trackingData)
+    }
+    return trackingData, err
+}
+
+func (s *FuelMonitoringTrackingService) TrackVehicles(
+    ctx context.Context,
+    reqs []*TrackingDataRequest,
+) ([]*repositories.TrackingRecord, []error) {
+    trackingData, errs := s.TrackingService.TrackVehicles(ctx, reqs)
+    for i, data := range trackingData {
+        if errs[i] == nil && data != nil {
+            s.inspect(ctx, data)
+        }
+    }
+    return trackingData, errs
+}
+
+func (s *FuelMonitoringTrackingService) inspect(ctx context.Context, trackingData *repositories.TrackingRecord) {
+    anomaly, err := s.fuelAnomalyService.Inspect(ctx, trackingData)
+    if err != nil {
+        log.Println("Failed to inspect fuel condition: ", err)
+        return
+    }
+    if anomaly != nil {
+        log.Printf(
+            "Fuel anomaly detected for vehicle %s: %s to %s",
+            anomaly.VehicleID.Hex(),
+            anomaly.FromCondition,
+            anomaly.ToCondition,
+        )
+    }
+}
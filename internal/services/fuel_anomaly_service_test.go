@@ -0,0 +1,42 @@
+package services
+
+import (
+    "testing"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+)
+
+func TestDetectFuelAnomaly(t *testing.T) {
+    reading := func(fuel models.FuelCondition, mileage float64) *repositories.TrackingRecord {
+        return &repositories.TrackingRecord{
+            TrackingData: models.TrackingData{FuelCondition: fuel, Mileage: mileage},
+        }
+    }
+
+    tests := []struct {
+        name     string
+        previous *repositories.TrackingRecord
+        current  *repositories.TrackingRecord
+        anomaly  bool
+    }{
+        {"full to empty while parked", reading(models.FuelConditionFull, 1000), reading(models.FuelConditionEmpty, 1000), true},
+        {"full to low after a short trip", reading(models.FuelConditionFull, 1000), reading(models.FuelConditionLow, 1050), true},
+        {"full to empty after a long trip", reading(models.FuelConditionFull, 1000), reading(models.FuelConditionEmpty, 1400), false},
+        {"single level drop", reading(models.FuelConditionFull, 1000), reading(models.FuelConditionHalf, 1000), false},
+        {"refuelled", reading(models.FuelConditionEmpty, 1000), reading(models.FuelConditionFull, 1000), false},
+    }
+    for _, test := range tests {
+        t.Run(
+            test.name, func(t *testing.T) {
+                anomaly := DetectFuelAnomaly(test.previous, test.current, 100)
+                if (anomaly != nil) != test.anomaly {
+                    t.Fatalf("expected anomaly %v, got %+v", test.anomaly, anomaly)
+                }
+                if anomaly != nil && anomaly.LevelsDropped < minFuelLevelsDropped {
+                    t.Errorf("expected at least %d levels dropped, got %d", minFuelLevelsDropped, anomaly.LevelsDropped)
+                }
+            },
+        )
+    }
+}
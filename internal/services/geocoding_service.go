@@ -0,0 +1,116 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "sync"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/geo"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+)
+
+// addressCacheMaxEntries bounds the addresses cached by the geocoding processor, the cache is cleared when it is full
+const addressCacheMaxEntries = 10000
+
+// GeocodingProcessor is an ingestion processor filling in the location of the readings sent with coordinates and
+// without a location, from the address of the coordinates. The addresses are cached per about 10m of coordinates and
+// the geocoder is asked at most rate times per second, the readings over the rate or failing to geocode get their
+// coordinates as their location instead of waiting for the geocoder.
+type GeocodingProcessor struct {
+    geocoder geo.ReverseGeocoder
+    ttl      time.Duration
+    // interval is the time between two requests to the geocoder
+    interval time.Duration
+
+    mu        sync.Mutex
+    addresses map[string]cachedAddress
+    // next is when the geocoder may be asked again
+    next time.Time
+}
+
+type cachedAddress struct {
+    address string
+    expires time.Time
+}
+
+func NewGeocodingProcessor(geocoder geo.ReverseGeocoder, ttl time.Duration, rate float64) *GeocodingProcessor {
+    var interval time.Duration
+    if rate > 0 {
+        interval = time.Duration(float64(time.Second) / rate)
+    }
+    return &GeocodingProcessor{
+        geocoder:  geocoder,
+        ttl:       ttl,
+        interval:  interval,
+        addresses: map[string]cachedAddress{},
+    }
+}
+
+func (p *GeocodingProcessor) PreValidate(ctx context.Context, req *TrackingDataRequest) error {
+    if req.Location != "" || req.Lat == nil || req.Lng == nil {
+        return nil
+    }
+    // the coordinates rounded to 4 decimals, positions about 10m apart share an address
+    key := fmt.Sprintf("%.4f,%.4f", *req.Lat, *req.Lng)
+    if address, ok := p.cached(key); ok {
+        req.Location = address
+        return nil
+    }
+
+    req.Location = coordinatesLocation(*req.Lat, *req.Lng)
+    if !p.allow(time.Now()) {
+        return nil
+    }
+    address, err := p.geocoder.ReverseGeocode(ctx, geo.Point{Lat: *req.Lat, Lng: *req.Lng})
+    switch {
+    case errors.Is(err, geo.ErrAddressNotFound):
+        // positions without an address keep their coordinates, they aren't asked for again until the cache expires
+        p.cache(key, req.Location)
+    case err != nil:
+        log.Printf("Error reverse geocoding %s: %v", req.Location, err)
+    default:
+        req.Location = address
+        p.cache(key, address)
+    }
+    return nil
+}
+
+func (p *GeocodingProcessor) PostPersist(context.Context, *repositories.TrackingRecord) error {
+    return nil
+}
+
+func (p *GeocodingProcessor) cached(key string) (string, bool) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    address, ok := p.addresses[key]
+    if !ok || time.Now().After(address.expires) {
+        return "", false
+    }
+    return address.address, true
+}
+
+func (p *GeocodingProcessor) cache(key string, address string) {
+    if p.ttl <= 0 {
+        return
+    }
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    if len(p.addresses) >= addressCacheMaxEntries {
+        clear(p.addresses)
+    }
+    p.addresses[key] = cachedAddress{address: address, expires: time.Now().Add(p.ttl)}
+}
+
+// allow reports whether the geocoder may be asked now, it doesn't wait so the ingestion isn't slowed down by the rate
+func (p *GeocodingProcessor) allow(now time.Time) bool {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    if now.Before(p.next) {
+        return false
+    }
+    p.next = now.Add(p.interval)
+    return true
+}
@@ -0,0 +1,96 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/geo"
+)
+
+// fakeGeocoder finds Yangon everywhere north of the equator and fails south of it, and counts its calls
+type fakeGeocoder struct {
+    calls int
+}
+
+func (g *fakeGeocoder) ReverseGeocode(_ context.Context, point geo.Point) (string, error) {
+    g.calls++
+    switch {
+    case point.Lat == 0:
+        return "", geo.ErrAddressNotFound
+    case point.Lat < 0:
+        return "", errors.New("connection refused")
+    }
+    return "Yangon, Myanmar", nil
+}
+
+func geocodingRequest(lat, lng float64, location string) *TrackingDataRequest {
+    req := &TrackingDataRequest{Lat: &lat, Lng: &lng}
+    req.Location = location
+    return req
+}
+
+func TestGeocodingProcessor_PreValidate(t *testing.T) {
+    geocoder := &fakeGeocoder{}
+    p := NewGeocodingProcessor(geocoder, time.Hour, 1)
+    ctx := context.Background()
+
+    req := geocodingRequest(16.84091, 96.15612, "")
+    if err := p.PreValidate(ctx, req); err != nil {
+        t.Fatal(err)
+    }
+    if req.Location != "Yangon, Myanmar" {
+        t.Errorf("expected the address, got %q", req.Location)
+    }
+    // a position a few meters away is read from the cache, even over the rate
+    req = geocodingRequest(16.84093, 96.15614, "")
+    if err := p.PreValidate(ctx, req); err != nil {
+        t.Fatal(err)
+    }
+    if req.Location != "Yangon, Myanmar" || geocoder.calls != 1 {
+        t.Errorf("expected the cached address, got %q after %d calls", req.Location, geocoder.calls)
+    }
+    // another position over the rate keeps its coordinates
+    req = geocodingRequest(16.9, 96.2, "")
+    if err := p.PreValidate(ctx, req); err != nil {
+        t.Fatal(err)
+    }
+    if req.Location != "16.90000,96.20000" || geocoder.calls != 1 {
+        t.Errorf("expected the coordinates, got %q after %d calls", req.Location, geocoder.calls)
+    }
+    // the location sent by the device is kept
+    req = geocodingRequest(16.9, 96.2, "Depot")
+    if err := p.PreValidate(ctx, req); err != nil {
+        t.Fatal(err)
+    }
+    if req.Location != "Depot" {
+        t.Errorf("expected the location of the device, got %q", req.Location)
+    }
+}
+
+func TestGeocodingProcessor_PreValidateFailure(t *testing.T) {
+    geocoder := &fakeGeocoder{}
+    p := NewGeocodingProcessor(geocoder, time.Hour, 0)
+    ctx := context.Background()
+
+    req := geocodingRequest(-16.5, 96.1, "")
+    if err := p.PreValidate(ctx, req); err != nil {
+        t.Fatal(err)
+    }
+    if req.Location != "-16.50000,96.10000" {
+        t.Errorf("expected the coordinates, got %q", req.Location)
+    }
+    // failures are asked again, positions without an address aren't
+    for range 2 {
+        if err := p.PreValidate(ctx, geocodingRequest(-16.5, 96.1, "")); err != nil {
+            t.Fatal(err)
+        }
+        if err := p.PreValidate(ctx, geocodingRequest(0, 96.1, "")); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if geocoder.calls != 4 {
+        t.Errorf("expected 4 calls, got %d", geocoder.calls)
+    }
+}
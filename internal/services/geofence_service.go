@@ -0,0 +1,166 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "strings"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/geojson"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+)
+
+var (
+    ErrInvalidFeatureCollection = errors.New("invalid feature collection")
+    ErrInvalidGeofences         = errors.New("one or more geofences are invalid")
+)
+
+type GeofenceImportError struct {
+    Index int    `json:"index"`
+    Name  string `json:"name,omitempty"`
+    Error string `json:"error"`
+}
+
+type GeofenceImportResult struct {
+    DryRun  bool                   `json:"dry_run"`
+    Total   int                    `json:"total"`
+    Created int                    `json:"created"`
+    Updated int                    `json:"updated"`
+    Errors  []*GeofenceImportError `json:"errors,omitempty"`
+}
+
+type GeofenceService interface {
+    ExportGeofences(ctx context.Context) (*geojson.FeatureCollection, error)
+    ImportGeofences(
+        ctx context.Context,
+        collection *geojson.FeatureCollection,
+        dryRun bool,
+    ) (*GeofenceImportResult, error)
+}
+
+type MongoGeofenceService struct {
+    geofenceRepo repositories.GeofenceRepository
+}
+
+func NewMongoGeofenceService(geofenceRepo repositories.GeofenceRepository) *MongoGeofenceService {
+    return &MongoGeofenceService{
+        geofenceRepo: geofenceRepo,
+    }
+}
+
+func (s *MongoGeofenceService) ExportGeofences(ctx context.Context) (*geojson.FeatureCollection, error) {
+    geofences, err := s.geofenceRepo.FindGeofences(ctx)
+    if err != nil {
+        return nil, err
+    }
+    features := make([]*geojson.Feature, 0, len(geofences))
+    for _, geofence := range geofences {
+        properties := map[string]any{}
+        for key, value := range geofence.Properties {
+            properties[key] = value
+        }
+        properties["name"] = geofence.Name
+        features = append(features, geojson.NewFeature(geofence.ID.Hex(), geofence.Geometry, properties))
+    }
+    return geojson.NewFeatureCollection(features), nil
+}
+
+// ImportGeofences validates every feature before writing anything, so an import either applies fully or not at all.
+// In dry-run mode nothing is written, the result reports what would be created or updated alongside any
+// validation errors.
+func (s *MongoGeofenceService) ImportGeofences(
+    ctx context.Context,
+    collection *geojson.FeatureCollection,
+    dryRun bool,
+) (*GeofenceImportResult, error) {
+    if collection == nil || collection.Type != geojson.TypeFeatureCollection {
+        return nil, ErrInvalidFeatureCollection
+    }
+
+    result := &GeofenceImportResult{DryRun: dryRun, Total: len(collection.Features)}
+    geofences := make([]*repositories.Geofence, 0, len(collection.Features))
+    seen := map[string]bool{}
+
+    for i, feature := range collection.Features {
+        geofence, err := featureToGeofence(feature)
+        if err == nil && seen[geofence.Name] {
+            err = fmt.Errorf("duplicate name %q", geofence.Name)
+        }
+        if err != nil {
+            importErr := &GeofenceImportError{Index: i, Error: err.Error()}
+            if geofence != nil {
+                importErr.Name = geofence.Name
+            }
+            result.Errors = append(result.Errors, importErr)
+            continue
+        }
+        seen[geofence.Name] = true
+        geofences = append(geofences, geofence)
+    }
+
+    var validationErr error
+    if len(result.Errors) > 0 {
+        messages := make([]string, 0, len(result.Errors))
+        for _, importErr := range result.Errors {
+            messages = append(messages, fmt.Sprintf("feature %d: %s", importErr.Index, importErr.Error))
+        }
+        validationErr = fmt.Errorf("%w: %s", ErrInvalidGeofences, strings.Join(messages, "; "))
+    }
+
+    if validationErr != nil && !dryRun {
+        return result, validationErr
+    }
+
+    if dryRun {
+        existing, err := s.geofenceRepo.FindGeofences(ctx)
+        if err != nil {
+            return nil, err
+        }
+        names := make(map[string]bool, len(existing))
+        for _, geofence := range existing {
+            names[geofence.Name] = true
+        }
+        for _, geofence := range geofences {
+            if names[geofence.Name] {
+                result.Updated++
+                continue
+            }
+            result.Created++
+        }
+        return result, validationErr
+    }
+
+    created, updated, err := s.geofenceRepo.UpsertGeofences(ctx, geofences)
+    if err != nil {
+        return nil, err
+    }
+    result.Created = created
+    result.Updated = updated
+    return result, nil
+}
+
+func featureToGeofence(feature *geojson.Feature) (*repositories.Geofence, error) {
+    if feature == nil || feature.Type != geojson.TypeFeature {
+        return nil, errors.New("not a feature")
+    }
+    geofence := &repositories.Geofence{Properties: map[string]any{}}
+    for key, value := range feature.Properties {
+        if key == "name" {
+            name, _ := value.(string)
+            geofence.Name = strings.TrimSpace(name)
+            continue
+        }
+        geofence.Properties[key] = value
+    }
+    if geofence.Name == "" {
+        return geofence, errors.New("properties.name is required")
+    }
+    if feature.Geometry == nil {
+        return geofence, errors.New("geometry is required")
+    }
+    if err := feature.Geometry.ValidatePolygons(); err != nil {
+        return geofence, err
+    }
+    geofence.Geometry = feature.Geometry
+    return geofence, nil
+}
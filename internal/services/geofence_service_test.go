@@ -0,0 +1,194 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "reflect"
+    "testing"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/geojson"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// UpsertGeofences replaces the geofences with the same name and adds the others, like the upsert by name in MongoDB
+func (r *fakeGeofenceRepo) UpsertGeofences(
+    _ context.Context,
+    geofences []*repositories.Geofence,
+) (created int, updated int, err error) {
+    for _, geofence := range geofences {
+        i := 0
+        for i < len(r.geofences) && r.geofences[i].Name != geofence.Name {
+            i++
+        }
+        if i < len(r.geofences) {
+            geofence.ID = r.geofences[i].ID
+            r.geofences[i] = geofence
+            updated++
+            continue
+        }
+        geofence.ID = primitive.NewObjectID()
+        r.geofences = append(r.geofences, geofence)
+        created++
+    }
+    return created, updated, nil
+}
+
+// decodeFeatures decodes a feature collection like the import handler does
+func decodeFeatures(t *testing.T, body string) *geojson.FeatureCollection {
+    var collection geojson.FeatureCollection
+    if err := json.Unmarshal([]byte(body), &collection); err != nil {
+        t.Fatal(err)
+    }
+    return &collection
+}
+
+func TestMongoGeofenceService_ImportGeofences(t *testing.T) {
+    const square = `{"type":"Polygon","coordinates":[[[96,16],[97,16],[97,17],[96,17],[96,16]]]}`
+    for _, test := range []struct {
+        name     string
+        features string
+        expected error
+        errors   int
+    }{
+        {"valid", `{"type":"Feature","properties":{"name":"depot"},"geometry":` + square + `}`, nil, 0},
+        {
+            "self-intersecting",
+            `{"type":"Feature","properties":{"name":"depot"},"geometry":` +
+                `{"type":"Polygon","coordinates":[[[96,16],[97,17],[97,16],[96,17],[96,16]]]}}`,
+            ErrInvalidGeofences,
+            1,
+        },
+        {
+            "unclosed",
+            `{"type":"Feature","properties":{"name":"depot"},"geometry":` +
+                `{"type":"Polygon","coordinates":[[[96,16],[97,16],[97,17],[96,17]]]}}`,
+            ErrInvalidGeofences,
+            1,
+        },
+        {
+            "wrong geometry type",
+            `{"type":"Feature","properties":{"name":"depot"},"geometry":{"type":"Point","coordinates":[96,16]}}`,
+            ErrInvalidGeofences,
+            1,
+        },
+        {"wrong feature type", `{"type":"Point","properties":{"name":"depot"}}`, ErrInvalidGeofences, 1},
+        {"without a name", `{"type":"Feature","properties":{},"geometry":` + square + `}`, ErrInvalidGeofences, 1},
+        {
+            "duplicate name",
+            `{"type":"Feature","properties":{"name":"depot"},"geometry":` + square + `},` +
+                `{"type":"Feature","properties":{"name":"depot"},"geometry":` + square + `}`,
+            ErrInvalidGeofences,
+            1,
+        },
+    } {
+        t.Run(
+            test.name, func(t *testing.T) {
+                repo := &fakeGeofenceRepo{}
+                s := NewMongoGeofenceService(repo)
+                collection := decodeFeatures(t, `{"type":"FeatureCollection","features":[`+test.features+`]}`)
+
+                result, err := s.ImportGeofences(context.Background(), collection, false)
+                if test.expected == nil && err != nil || test.expected != nil && !errors.Is(err, test.expected) {
+                    t.Fatalf("expected %v, got %v", test.expected, err)
+                }
+                if len(result.Errors) != test.errors {
+                    t.Errorf("expected %d errors, got %+v", test.errors, result.Errors)
+                }
+                // an import with an invalid feature doesn't write any of them
+                if test.expected != nil && len(repo.geofences) != 0 {
+                    t.Errorf("expected nothing to be imported, got %d geofences", len(repo.geofences))
+                }
+                if test.expected == nil && (result.Created != 1 || len(repo.geofences) != 1) {
+                    t.Errorf("expected the geofence to be created, got %+v", result)
+                }
+            },
+        )
+    }
+
+    // the type of the collection is checked before its features
+    _, err := NewMongoGeofenceService(&fakeGeofenceRepo{}).ImportGeofences(
+        context.Background(),
+        decodeFeatures(t, `{"type":"Feature","features":[]}`),
+        false,
+    )
+    if !errors.Is(err, ErrInvalidFeatureCollection) {
+        t.Errorf("expected the feature collection to be invalid, got %v", err)
+    }
+}
+
+func TestMongoGeofenceService_ImportGeofences_DryRun(t *testing.T) {
+    repo := &fakeGeofenceRepo{geofences: []*repositories.Geofence{{ID: primitive.NewObjectID(), Name: "depot"}}}
+    s := NewMongoGeofenceService(repo)
+    collection := decodeFeatures(
+        t,
+        `{"type":"FeatureCollection","features":[`+
+            `{"type":"Feature","properties":{"name":"depot"},"geometry":`+
+            `{"type":"Polygon","coordinates":[[[96,16],[97,16],[97,17],[96,16]]]}},`+
+            `{"type":"Feature","properties":{"name":"yard"},"geometry":{"type":"Point","coordinates":[96,16]}}]}`,
+    )
+
+    result, err := s.ImportGeofences(context.Background(), collection, true)
+    if !errors.Is(err, ErrInvalidGeofences) || !result.DryRun || result.Updated != 1 || len(result.Errors) != 1 {
+        t.Fatalf("expected the dry run to report the update and the invalid feature, got %+v, %v", result, err)
+    }
+    if result.Errors[0].Index != 1 || result.Errors[0].Name != "yard" {
+        t.Errorf("expected the error of the second feature, got %+v", result.Errors[0])
+    }
+    if len(repo.geofences) != 1 || repo.geofences[0].Geometry != nil {
+        t.Errorf("expected the dry run not to write anything, got %+v", repo.geofences)
+    }
+}
+
+func TestMongoGeofenceService_ExportGeofences_RoundTrip(t *testing.T) {
+    repo := &fakeGeofenceRepo{}
+    s := NewMongoGeofenceService(repo)
+    collection := decodeFeatures(
+        t,
+        `{"type":"FeatureCollection","features":[`+
+            `{"type":"Feature","properties":{"name":"depot","speed_limit_kmh":30},"geometry":`+
+            `{"type":"Polygon","coordinates":[[[96,16],[97,16],[97,17],[96,17],[96,16]]]}},`+
+            `{"type":"Feature","properties":{"name":"yards"},"geometry":{"type":"MultiPolygon","coordinates":`+
+            `[[[[98,16],[99,16],[99,17],[98,16]]],[[[100,16],[101,16],[101,17],[100,16]]]]}}]}`,
+    )
+    if _, err := s.ImportGeofences(context.Background(), collection, false); err != nil {
+        t.Fatal(err)
+    }
+
+    exported, err := s.ExportGeofences(context.Background())
+    if err != nil {
+        t.Fatal(err)
+    }
+    body, err := json.Marshal(exported)
+    if err != nil {
+        t.Fatal(err)
+    }
+    // the exported file is imported again as it is, updating the same geofences
+    result, err := s.ImportGeofences(context.Background(), decodeFeatures(t, string(body)), false)
+    if err != nil || result.Updated != 2 || result.Created != 0 {
+        t.Fatalf("expected the export to import again, got %+v, %v", result, err)
+    }
+
+    reexported, err := s.ExportGeofences(context.Background())
+    if err != nil {
+        t.Fatal(err)
+    }
+    for i, feature := range reexported.Features {
+        original := exported.Features[i]
+        if feature.ID != original.ID || !reflect.DeepEqual(feature.Properties, original.Properties) {
+            t.Errorf("expected the feature %d to be kept, got %+v", i, feature)
+        }
+        polygons, err := feature.Geometry.Polygons()
+        if err != nil {
+            t.Fatal(err)
+        }
+        originalPolygons, _ := original.Geometry.Polygons()
+        if feature.Geometry.Type != original.Geometry.Type || !reflect.DeepEqual(polygons, originalPolygons) {
+            t.Errorf("expected the geometry %d to be kept, got %+v", i, feature.Geometry)
+        }
+    }
+    if len(reexported.Features) != 2 || reexported.Features[0].Properties["speed_limit_kmh"] != float64(30) {
+        t.Errorf("expected both geofences with their properties, got %+v", reexported.Features)
+    }
+}
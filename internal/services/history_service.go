@@ -0,0 +1,93 @@
+package services
+
+import (
+    "context"
+    "math"
+    "net/url"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+)
+
+// HistoryService answers the analytical queries over the long-term history of the tracking data
+type HistoryService interface {
+    FindHeatmap(ctx context.Context, query url.Values) ([]*repositories.HeatmapCell, error)
+    FindUtilization(ctx context.Context, query url.Values) ([]*repositories.VehicleUtilization, error)
+}
+
+type ClickHouseHistoryService struct {
+    historyRepo   repositories.HistoryRepository
+    accessService AccessService
+    now           func() time.Time
+}
+
+func NewClickHouseHistoryService(
+    historyRepo repositories.HistoryRepository,
+    accessService AccessService,
+) *ClickHouseHistoryService {
+    return &ClickHouseHistoryService{
+        historyRepo:   historyRepo,
+        accessService: accessService,
+        now:           time.Now,
+    }
+}
+
+func (s *ClickHouseHistoryService) filter(ctx context.Context, query url.Values) (*repositories.HistoryFilter, error) {
+    var filter repositories.HistoryFilter
+    if err := decodeQuery(query, &filter, "cell_degrees"); err != nil {
+        return nil, err
+    }
+    scope, err := s.accessService.VehicleScope(ctx)
+    if err != nil {
+        return nil, err
+    }
+    scope.Restrict(&filter)
+    return &filter, nil
+}
+
+func (s *ClickHouseHistoryService) FindHeatmap(
+    ctx context.Context,
+    query url.Values,
+) ([]*repositories.HeatmapCell, error) {
+    filter, err := s.filter(ctx, query)
+    if err != nil {
+        return nil, err
+    }
+    return s.historyRepo.FindHeatmap(ctx, filter)
+}
+
+// FindUtilization completes the utilization of the periods with their number of days, the first and last
+// periods only count the days within the time range of the query, which ends now without a to
+func (s *ClickHouseHistoryService) FindUtilization(
+    ctx context.Context,
+    query url.Values,
+) ([]*repositories.VehicleUtilization, error) {
+    filter, err := s.filter(ctx, query)
+    if err != nil {
+        return nil, err
+    }
+    utilization, err := s.historyRepo.FindUtilization(ctx, filter)
+    if err != nil {
+        return nil, err
+    }
+    from, to := filter.TimeRange()
+    if to.IsZero() {
+        to = s.now().UTC()
+    }
+    for _, period := range utilization {
+        start, err := time.Parse(time.DateOnly, period.Period)
+        if err != nil {
+            return nil, err
+        }
+        end := filter.IntervalValue().End(start)
+        if start.Before(from) {
+            start = from.Truncate(24 * time.Hour)
+        }
+        if end.After(to) {
+            end = to
+        }
+        period.Days = max(int(math.Ceil(end.Sub(start).Hours()/24)), 1)
+        period.Utilization = math.Round(min(float64(period.ActiveDays)/float64(period.Days), 1)*10000) / 100
+    }
+    return utilization, nil
+}
@@ -0,0 +1,57 @@
+package services
+
+import (
+    "context"
+    "net/url"
+    "testing"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type fakeHistoryRepo struct {
+    repositories.HistoryRepository
+    utilization []*repositories.VehicleUtilization
+}
+
+func (repo *fakeHistoryRepo) FindUtilization(
+    _ context.Context,
+    filter *repositories.HistoryFilter,
+) ([]*repositories.VehicleUtilization, error) {
+    return repo.utilization, filter.Build()
+}
+
+func TestClickHouseHistoryService_FindUtilization(t *testing.T) {
+    vehicleID := primitive.NewObjectID().Hex()
+    repo := &fakeHistoryRepo{
+        utilization: []*repositories.VehicleUtilization{
+            {VehicleID: vehicleID, Period: "2025-01-01", ActiveDays: 10},
+            {VehicleID: vehicleID, Period: "2025-02-01", ActiveDays: 28},
+            {VehicleID: vehicleID, Period: "2025-03-01", ActiveDays: 3},
+        },
+    }
+    service := NewClickHouseHistoryService(repo, NewMongoAccessService(&fakeAssignmentRepo{}, nil))
+    service.now = func() time.Time {
+        return time.Date(2025, time.March, 5, 12, 0, 0, 0, time.UTC)
+    }
+
+    utilization, err := service.FindUtilization(context.Background(), url.Values{"from": {"2025-01-22T08:00:00Z"}})
+    if err != nil {
+        t.Fatal(err)
+    }
+    expected := []struct {
+        days        int
+        utilization float64
+    }{{10, 100}, {28, 100}, {5, 60}}
+    for i, period := range utilization {
+        if period.Days != expected[i].days || period.Utilization != expected[i].utilization {
+            t.Errorf(
+                "Should count the days of %s within the time range, got %d days and %v%%",
+                period.Period,
+                period.Days,
+                period.Utilization,
+            )
+        }
+    }
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+)
+
+const (
+    // MaxIdempotencyKeyLength is the longest Idempotency-Key accepted, long enough for a UUID or a ULID with a prefix
+    MaxIdempotencyKeyLength = 255
+
+    // idempotencyLease is how long a request is in progress at most, a request not completed by then was
+    // interrupted and its retries are processed again
+    idempotencyLease = 2 * time.Minute
+)
+
+var (
+    ErrIdempotencyKeyTooLong    = errors.New("Idempotency-Key must be at most 255 characters")
+    ErrIdempotencyKeyInProgress = errors.New("a request with the Idempotency-Key is still in progress")
+    ErrIdempotencyKeyReused     = errors.New("the Idempotency-Key was already used for a different request")
+)
+
+// IdempotentResponse is the response of a request sent with an Idempotency-Key
+type IdempotentResponse struct {
+    Status      int
+    ContentType string
+    Body        []byte
+}
+
+type IdempotencyService interface {
+    // Begin reserves the key for the request, it returns the response of the request when it was completed before.
+    // It fails with ErrIdempotencyKeyInProgress while the request is processed and with ErrIdempotencyKeyReused when
+    // the key was used for another request.
+    Begin(ctx context.Context, key string, request []byte) (*IdempotentResponse, error)
+    // Complete keeps the response of the request of the key for the retries
+    Complete(ctx context.Context, key string, response *IdempotentResponse) error
+    // Release forgets the request of the key, so a retry is processed again
+    Release(ctx context.Context, key string) error
+}
+
+// MongoIdempotencyService keeps the responses of the requests with an Idempotency-Key for ttl, the keys are unique
+// per tenant
+type MongoIdempotencyService struct {
+    idempotencyRepo repositories.IdempotencyRepository
+    ttl             time.Duration
+}
+
+func NewMongoIdempotencyService(
+    idempotencyRepo repositories.IdempotencyRepository,
+    ttl time.Duration,
+) *MongoIdempotencyService {
+    return &MongoIdempotencyService{idempotencyRepo: idempotencyRepo, ttl: ttl}
+}
+
+func (s *MongoIdempotencyService) Begin(ctx context.Context, key string, request []byte) (*IdempotentResponse, error) {
+    if len(key) > MaxIdempotencyKeyLength {
+        return nil, ErrIdempotencyKeyTooLong
+    }
+    fingerprint := sha256.Sum256(request)
+    now := time.Now()
+    reserved := &repositories.IdempotentRequest{
+        Key:         key,
+        Fingerprint: hex.EncodeToString(fingerprint[:]),
+        CreatedAt:   timestamp.New(now),
+        ExpiresAt:   timestamp.New(now.Add(idempotencyLease)),
+    }
+    stored, err := s.idempotencyRepo.ReserveIdempotencyKey(ctx, reserved)
+    if err != nil || stored == nil {
+        return nil, err
+    }
+    switch {
+    case stored.Fingerprint != reserved.Fingerprint:
+        return nil, ErrIdempotencyKeyReused
+    case stored.Status == 0:
+        return nil, ErrIdempotencyKeyInProgress
+    }
+    return &IdempotentResponse{Status: stored.Status, ContentType: stored.ContentType, Body: stored.Body}, nil
+}
+
+func (s *MongoIdempotencyService) Complete(ctx context.Context, key string, response *IdempotentResponse) error {
+    return s.idempotencyRepo.CompleteIdempotencyKey(
+        ctx, &repositories.IdempotentRequest{
+            Key:         key,
+            Status:      response.Status,
+            ContentType: response.ContentType,
+            Body:        response.Body,
+            ExpiresAt:   timestamp.New(time.Now().Add(s.ttl)),
+        },
+    )
+}
+
+func (s *MongoIdempotencyService) Release(ctx context.Context, key string) error {
+    return s.idempotencyRepo.ReleaseIdempotencyKey(ctx, key)
+}
@@ -0,0 +1,86 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+)
+
+// fakeIdempotencyRepo keeps the requests by key like MongoIdempotencyRepository
+type fakeIdempotencyRepo map[string]*repositories.IdempotentRequest
+
+func (r fakeIdempotencyRepo) ReserveIdempotencyKey(
+    _ context.Context,
+    request *repositories.IdempotentRequest,
+) (*repositories.IdempotentRequest, error) {
+    if stored, ok := r[request.Key]; ok && stored.ExpiresAt.After(time.Now()) {
+        return stored, nil
+    }
+    reserved := *request
+    r[request.Key] = &reserved
+    return nil, nil
+}
+
+func (r fakeIdempotencyRepo) CompleteIdempotencyKey(_ context.Context, request *repositories.IdempotentRequest) error {
+    stored := r[request.Key]
+    stored.Status, stored.ContentType, stored.Body = request.Status, request.ContentType, request.Body
+    stored.ExpiresAt = request.ExpiresAt
+    return nil
+}
+
+func (r fakeIdempotencyRepo) ReleaseIdempotencyKey(_ context.Context, key string) error {
+    delete(r, key)
+    return nil
+}
+
+func TestMongoIdempotencyService(t *testing.T) {
+    ctx := context.Background()
+    repo := fakeIdempotencyRepo{}
+    s := NewMongoIdempotencyService(repo, time.Hour)
+    request := []byte(`POST /api/v1/tracking-data {"vehicle_id":"6650c3e0f1a2b3c4d5e6f7a8"}`)
+
+    if response, err := s.Begin(ctx, "key-1", request); err != nil || response != nil {
+        t.Fatalf("expected the key to be reserved, got %v, %v", response, err)
+    }
+    if _, err := s.Begin(ctx, "key-1", request); !errors.Is(err, ErrIdempotencyKeyInProgress) {
+        t.Fatalf("expected the retry of a request in progress to fail, got %v", err)
+    }
+
+    completed := &IdempotentResponse{Status: http.StatusCreated, ContentType: "application/json", Body: []byte(`{}`)}
+    if err := s.Complete(ctx, "key-1", completed); err != nil {
+        t.Fatal(err)
+    }
+    response, err := s.Begin(ctx, "key-1", request)
+    if err != nil || response == nil || response.Status != http.StatusCreated || string(response.Body) != `{}` {
+        t.Fatalf("expected the retry to get the first response, got %+v, %v", response, err)
+    }
+    if _, err := s.Begin(ctx, "key-1", []byte("POST /api/v1/tracking-data/batch []")); !errors.Is(
+        err,
+        ErrIdempotencyKeyReused,
+    ) {
+        t.Fatalf("expected the key of another request to be refused, got %v", err)
+    }
+
+    // a released key is processed again, like after a failure of the first request
+    if _, err := s.Begin(ctx, "key-2", request); err != nil {
+        t.Fatal(err)
+    }
+    if err := s.Release(ctx, "key-2"); err != nil {
+        t.Fatal(err)
+    }
+    if response, err := s.Begin(ctx, "key-2", request); err != nil || response != nil {
+        t.Fatalf("expected the released key to be reserved again, got %v, %v", response, err)
+    }
+
+    if _, err := s.Begin(ctx, strings.Repeat("k", MaxIdempotencyKeyLength+1), request); !errors.Is(
+        err,
+        ErrIdempotencyKeyTooLong,
+    ) {
+        t.Fatalf("expected a long key to be refused, got %v", err)
+    }
+}
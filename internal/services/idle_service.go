@@ -0,0 +1,194 @@
+package services
+
+import (
+    "context"
+    "math"
+    "net/url"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/geo"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// IdleReportDay is the idling of a vehicle during a UTC day with the fuel it wasted
+type IdleReportDay struct {
+    *repositories.IdleDay
+    FuelLiters float64 `json:"fuel_liters"`
+}
+
+type IdleService interface {
+    // FindSegments returns a page of the idle segments, the latest first
+    FindSegments(ctx context.Context, query url.Values) ([]*repositories.IdleSegment, error)
+    // FindReport returns a page of the idling per vehicle and day, the latest day first
+    FindReport(ctx context.Context, query url.Values) ([]*IdleReportDay, error)
+}
+
+type MongoIdleService struct {
+    segmentRepo   repositories.IdleSegmentRepository
+    accessService AccessService
+    // fuelLitersPerHour is the fuel an idling vehicle burns
+    fuelLitersPerHour float64
+}
+
+func NewMongoIdleService(
+    segmentRepo repositories.IdleSegmentRepository,
+    accessService AccessService,
+    fuelLitersPerHour float64,
+) *MongoIdleService {
+    return &MongoIdleService{
+        segmentRepo:       segmentRepo,
+        accessService:     accessService,
+        fuelLitersPerHour: fuelLitersPerHour,
+    }
+}
+
+func (s *MongoIdleService) FindSegments(
+    ctx context.Context,
+    query url.Values,
+) ([]*repositories.IdleSegment, error) {
+    filter, err := s.filter(ctx, query)
+    if err != nil {
+        return nil, err
+    }
+    return s.segmentRepo.FindIdleSegments(ctx, filter)
+}
+
+func (s *MongoIdleService) FindReport(ctx context.Context, query url.Values) ([]*IdleReportDay, error) {
+    filter, err := s.filter(ctx, query)
+    if err != nil {
+        return nil, err
+    }
+    days, err := s.segmentRepo.SumIdleDays(ctx, filter)
+    if err != nil {
+        return nil, err
+    }
+    report := make([]*IdleReportDay, 0, len(days))
+    for _, day := range days {
+        fuel := day.IdleSeconds / time.Hour.Seconds() * s.fuelLitersPerHour
+        report = append(report, &IdleReportDay{IdleDay: day, FuelLiters: math.Round(fuel*100) / 100})
+    }
+    return report, nil
+}
+
+// filter decodes the query limited to the vehicles the user has access to
+func (s *MongoIdleService) filter(ctx context.Context, query url.Values) (*repositories.IdleFilter, error) {
+    var filter repositories.IdleFilter
+    if err := decodeQuery(query, &filter); err != nil {
+        return nil, err
+    }
+    if err := filter.Build(); err != nil {
+        return nil, err
+    }
+    scope, err := s.accessService.VehicleScope(ctx)
+    if err != nil {
+        return nil, err
+    }
+    scope.Restrict(&filter)
+    return &filter, nil
+}
+
+// IdleDetector is an ingestion processor recording the periods active vehicles stand still. A vehicle idles from a
+// reading on while its next active readings stay within idleRadiusMeters of it, the segment ends with the last of
+// them. Segments shorter than the minimum duration, like at traffic lights, aren't recorded, and late readings and
+// readings without coordinates are skipped.
+type IdleDetector struct {
+    segmentRepo repositories.IdleSegmentRepository
+    locks       *vehicleLocks
+    minDuration time.Duration
+}
+
+func NewIdleDetector(segmentRepo repositories.IdleSegmentRepository, minDuration time.Duration) *IdleDetector {
+    return &IdleDetector{segmentRepo: segmentRepo, locks: newVehicleLocks(), minDuration: minDuration}
+}
+
+func (d *IdleDetector) PreValidate(context.Context, *TrackingDataRequest) error {
+    return nil
+}
+
+func (d *IdleDetector) PostPersist(ctx context.Context, record *repositories.TrackingRecord) error {
+    point, ok := record.Point()
+    if !ok {
+        return nil
+    }
+    // the previous state of the vehicle is compared with one reading at a time
+    unlock := d.locks.lock([]primitive.ObjectID{record.VehicleID})
+    defer unlock()
+
+    previous, err := d.segmentRepo.FindIdleState(ctx, record.VehicleID)
+    if err != nil {
+        return err
+    }
+    at := readingTime(record)
+    if previous != nil && !at.After(previous.At.Time) {
+        return nil
+    }
+    state := &repositories.IdleState{
+        VehicleID: record.VehicleID,
+        DriverID:  record.DriverID,
+        Lat:       point.Lat,
+        Lng:       point.Lng,
+        At:        timestamp.New(at),
+        Active:    record.Status == models.VehicleStatusActive,
+    }
+    var segments []*repositories.IdleSegment
+    if previous != nil {
+        if d.stillIdle(previous, state) {
+            state.IdleSince, state.IdleLat, state.IdleLng = previous.IdleSince, previous.IdleLat, previous.IdleLng
+            if state.IdleSince == nil {
+                state.IdleSince, state.IdleLat, state.IdleLng = &previous.At, previous.Lat, previous.Lng
+            }
+        } else if previous.IdleSince != nil {
+            segments = d.segments(previous)
+        }
+    }
+    if err = d.segmentRepo.SaveIdleState(ctx, state); err != nil {
+        return err
+    }
+    return d.segmentRepo.CreateIdleSegments(ctx, segments)
+}
+
+// stillIdle reports whether the vehicle stood still and active since the previous reading, around where it started
+// idling when it already was
+func (d *IdleDetector) stillIdle(previous, state *repositories.IdleState) bool {
+    if !previous.Active || !state.Active || state.At.Sub(previous.At.Time) > maxScoredGap {
+        return false
+    }
+    from := geo.NewPoint(previous.Lat, previous.Lng)
+    if previous.IdleSince != nil {
+        from = geo.NewPoint(previous.IdleLat, previous.IdleLng)
+    }
+    return geo.Haversine(from, geo.NewPoint(state.Lat, state.Lng)) <= idleRadiusMeters
+}
+
+// segments ends the idling of the state with its reading, split per UTC day. It returns none when the idling was
+// shorter than the minimum duration.
+func (d *IdleDetector) segments(state *repositories.IdleState) []*repositories.IdleSegment {
+    start, end := state.IdleSince.Time, state.At.Time
+    if end.Sub(start) < d.minDuration {
+        return nil
+    }
+    var segments []*repositories.IdleSegment
+    for start.Before(end) {
+        next := start.UTC().Truncate(24*time.Hour).AddDate(0, 0, 1)
+        if next.After(end) {
+            next = end
+        }
+        segments = append(
+            segments,
+            &repositories.IdleSegment{
+                VehicleID:       state.VehicleID,
+                DriverID:        state.DriverID,
+                Lat:             state.IdleLat,
+                Lng:             state.IdleLng,
+                Start:           timestamp.New(start),
+                End:             timestamp.New(next),
+                DurationSeconds: next.Sub(start).Seconds(),
+            },
+        )
+        start = next
+    }
+    return segments
+}
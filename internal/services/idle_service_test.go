@@ -0,0 +1,116 @@
+package services
+
+import (
+    "context"
+    "net/url"
+    "testing"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeIdleSegmentRepo keeps the states and segments in memory, the report is answered with days
+type fakeIdleSegmentRepo struct {
+    states   map[primitive.ObjectID]*repositories.IdleState
+    segments []*repositories.IdleSegment
+    days     []*repositories.IdleDay
+}
+
+func (r *fakeIdleSegmentRepo) CreateIdleSegments(_ context.Context, segments []*repositories.IdleSegment) error {
+    r.segments = append(r.segments, segments...)
+    return nil
+}
+
+func (r *fakeIdleSegmentRepo) FindIdleSegments(
+    context.Context,
+    *repositories.IdleFilter,
+) ([]*repositories.IdleSegment, error) {
+    return r.segments, nil
+}
+
+func (r *fakeIdleSegmentRepo) SumIdleDays(context.Context, *repositories.IdleFilter) ([]*repositories.IdleDay, error) {
+    return r.days, nil
+}
+
+func (r *fakeIdleSegmentRepo) FindIdleState(
+    _ context.Context,
+    vehicleID primitive.ObjectID,
+) (*repositories.IdleState, error) {
+    return r.states[vehicleID], nil
+}
+
+func (r *fakeIdleSegmentRepo) SaveIdleState(_ context.Context, state *repositories.IdleState) error {
+    r.states[state.VehicleID] = state
+    return nil
+}
+
+func TestIdleDetector_PostPersist(t *testing.T) {
+    ctx := context.Background()
+    repo := &fakeIdleSegmentRepo{states: map[primitive.ObjectID]*repositories.IdleState{}}
+    d := NewIdleDetector(repo, 2*time.Minute)
+
+    vehicleID := primitive.NewObjectID()
+    start := time.Date(2024, 1, 1, 23, 55, 0, 0, time.UTC)
+    inactive := positionedRecord(vehicleID, start.Add(20*time.Minute), 16.0, 96.0)
+    inactive.Status = models.VehicleStatusInactive
+    readings := []*repositories.TrackingRecord{
+        positionedRecord(vehicleID, start, 16.0, 96.0),
+        // a few meters of GPS jitter
+        positionedRecord(vehicleID, start.Add(5*time.Minute), 16.0001, 96.0),
+        positionedRecord(vehicleID, start.Add(8*time.Minute), 16.0, 96.0001),
+        // driving away ends the idling with the previous reading, split at midnight
+        positionedRecord(vehicleID, start.Add(9*time.Minute), 16.01, 96.0),
+        // a stop at a traffic light is too short
+        positionedRecord(vehicleID, start.Add(10*time.Minute), 16.01, 96.0),
+        positionedRecord(vehicleID, start.Add(11*time.Minute), 16.02, 96.0),
+        // an inactive vehicle doesn't idle
+        positionedRecord(vehicleID, start.Add(15*time.Minute), 16.0, 96.0),
+        inactive,
+        positionedRecord(vehicleID, start.Add(25*time.Minute), 16.03, 96.0),
+    }
+    for _, reading := range readings {
+        if err := d.PostPersist(ctx, reading); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    if len(repo.segments) != 2 {
+        t.Fatalf("Should record the idling split in 2 segments, got %d", len(repo.segments))
+    }
+    midnight := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+    first, second := repo.segments[0], repo.segments[1]
+    if !first.Start.Equal(start) || !first.End.Equal(midnight) || first.DurationSeconds != 300 {
+        t.Fatalf("Should end the first segment at midnight, got %+v", first)
+    }
+    if !second.Start.Equal(midnight) || !second.End.Equal(start.Add(8*time.Minute)) || second.DurationSeconds != 180 {
+        t.Fatalf("Should end the second segment with the last idle reading, got %+v", second)
+    }
+    if first.Lat != 16.0 || first.Lng != 96.0 {
+        t.Fatalf("Should locate the segment where the idling started, got %v,%v", first.Lat, first.Lng)
+    }
+}
+
+func TestMongoIdleService_FindReport(t *testing.T) {
+    repo := &fakeIdleSegmentRepo{
+        days: []*repositories.IdleDay{
+            {
+                VehicleID:   primitive.NewObjectID(),
+                Day:         timestamp.New(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)),
+                Segments:    3,
+                IdleSeconds: 5400,
+            },
+        },
+    }
+    s := NewMongoIdleService(repo, NewMongoAccessService(nil, nil), 0.8)
+
+    report, err := s.FindReport(context.Background(), url.Values{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(report) != 1 || report[0].FuelLiters != 1.2 || report[0].Segments != 3 {
+        t.Fatalf("Should estimate 1.2l wasted in 1.5h idling, got %+v", report[0])
+    }
+}
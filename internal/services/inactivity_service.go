@@ -0,0 +1,273 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "math"
+    "net/url"
+    "time"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/geo"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+    StatusSuggestedEvent = "vehicle.status_suggested"
+
+    // stationaryMeters is how far apart two positions can be while the vehicle is still considered parked,
+    // so GPS drift isn't movement
+    stationaryMeters = 50
+)
+
+var (
+    ErrInvalidSuggestionState = errors.New("state must be accepted or dismissed")
+)
+
+// StatusSuggested is the event published to the status suggestion queue for the vehicle service
+type StatusSuggested struct {
+    Event      string                         `json:"event"`
+    Suggestion *repositories.StatusSuggestion `json:"suggestion"`
+}
+
+type ResolveSuggestionRequest struct {
+    State string `json:"state" validate:"required,oneof=accepted dismissed"`
+}
+
+type InactivityService interface {
+    // Observe records whether the stored reading moved its vehicle, the pending suggestions of a vehicle that
+    // moves are closed
+    Observe(ctx context.Context, trackingData *repositories.TrackingRecord) error
+    // Suggest suggests the inactive status for every vehicle that didn't move for the inactivity period by now
+    Suggest(ctx context.Context, now time.Time) ([]*repositories.StatusSuggestion, error)
+    FindSuggestions(ctx context.Context, query url.Values) ([]*repositories.StatusSuggestion, error)
+    ResolveSuggestion(
+        ctx context.Context,
+        id string,
+        req *ResolveSuggestionRequest,
+    ) (*repositories.StatusSuggestion, error)
+}
+
+type MongoInactivityService struct {
+    activityRepo repositories.VehicleActivityRepository
+    publisher    Publisher
+    locks        *vehicleLocks
+    // period is how long a vehicle doesn't move before it is suggested inactive
+    period time.Duration
+}
+
+func NewMongoInactivityService(
+    activityRepo repositories.VehicleActivityRepository,
+    publisher Publisher,
+    period time.Duration,
+) *MongoInactivityService {
+    return &MongoInactivityService{
+        activityRepo: activityRepo,
+        publisher:    publisher,
+        locks:        newVehicleLocks(),
+        period:       period,
+    }
+}
+
+func (s *MongoInactivityService) Observe(ctx context.Context, trackingData *repositories.TrackingRecord) error {
+    // the previous reading of the vehicle is compared with one reading at a time
+    unlock := s.locks.lock([]primitive.ObjectID{trackingData.VehicleID})
+    defer unlock()
+
+    previous, err := s.activityRepo.FindActivity(ctx, trackingData.VehicleID)
+    if err != nil {
+        return err
+    }
+    seenAt := readingTime(trackingData)
+    // late readings don't tell where the vehicle is now
+    if previous != nil && seenAt.Before(previous.SeenAt.Time) {
+        return nil
+    }
+    activity := &repositories.VehicleActivity{
+        VehicleID: trackingData.VehicleID,
+        Status:    trackingData.Status,
+        Mileage:   trackingData.Mileage,
+        Lat:       trackingData.Lat,
+        Lng:       trackingData.Lng,
+        MovedAt:   timestamp.New(seenAt),
+        SeenAt:    timestamp.New(seenAt),
+    }
+    moved := previous == nil || Moved(previous, activity)
+    if !moved {
+        activity.MovedAt = previous.MovedAt
+        // positions are kept from the last reading with coordinates
+        if activity.Lat == nil {
+            activity.Lat, activity.Lng = previous.Lat, previous.Lng
+        }
+    }
+    if err = s.activityRepo.SaveActivity(ctx, activity); err != nil {
+        return err
+    }
+    if moved && previous != nil {
+        return s.activityRepo.ResolveVehicleSuggestions(ctx, trackingData.VehicleID, repositories.SuggestionMoved)
+    }
+    return nil
+}
+
+// Moved reports whether the vehicle moved from the previous to the current activity, by a higher mileage or a
+// position further away than GPS drift
+func Moved(previous, current *repositories.VehicleActivity) bool {
+    if current.Mileage > previous.Mileage {
+        return true
+    }
+    if previous.Lat == nil || previous.Lng == nil || current.Lat == nil || current.Lng == nil {
+        return false
+    }
+    distance := geo.Haversine(geo.NewPoint(*previous.Lat, *previous.Lng), geo.NewPoint(*current.Lat, *current.Lng))
+    return distance > stationaryMeters
+}
+
+func (s *MongoInactivityService) Suggest(ctx context.Context, now time.Time) ([]*repositories.StatusSuggestion, error) {
+    inactive, err := s.activityRepo.FindInactive(ctx, now.Add(-s.period))
+    if err != nil {
+        return nil, err
+    }
+    var suggestions []*repositories.StatusSuggestion
+    for _, activity := range inactive {
+        // vehicles already out of service don't need the suggestion
+        if activity.Status == models.VehicleStatusInactive || activity.Status == models.VehicleStatusSold {
+            continue
+        }
+        vehicleCtx := ctx
+        if activity.TenantID != "" {
+            vehicleCtx = tenant.WithID(ctx, activity.TenantID)
+        }
+        days := int(math.Floor(now.Sub(activity.MovedAt.Time).Hours() / 24))
+        suggestion := &repositories.StatusSuggestion{
+            VehicleID:     activity.VehicleID,
+            Status:        models.VehicleStatusInactive,
+            Reason:        fmt.Sprintf("no movement for %d days", days),
+            CurrentStatus: activity.Status,
+            MovedAt:       activity.MovedAt,
+            SeenAt:        activity.SeenAt,
+        }
+        created, err := s.activityRepo.RecordSuggestion(vehicleCtx, suggestion)
+        if err != nil {
+            return suggestions, err
+        }
+        if !created {
+            continue
+        }
+        suggestions = append(suggestions, suggestion)
+
+        body, err := json.Marshal(&StatusSuggested{Event: StatusSuggestedEvent, Suggestion: suggestion})
+        if err != nil {
+            return suggestions, err
+        }
+        if err = s.publisher.Publish(vehicleCtx, body); err != nil {
+            return suggestions, err
+        }
+    }
+    return suggestions, nil
+}
+
+func (s *MongoInactivityService) FindSuggestions(
+    ctx context.Context,
+    query url.Values,
+) ([]*repositories.StatusSuggestion, error) {
+    var filter repositories.StatusSuggestionFilter
+    if err := decodeQuery(query, &filter); err != nil {
+        return nil, err
+    }
+    return s.activityRepo.FindSuggestions(ctx, &filter)
+}
+
+func (s *MongoInactivityService) ResolveSuggestion(
+    ctx context.Context,
+    id string,
+    req *ResolveSuggestionRequest,
+) (*repositories.StatusSuggestion, error) {
+    if req.State != repositories.SuggestionAccepted && req.State != repositories.SuggestionDismissed {
+        return nil, ErrInvalidSuggestionState
+    }
+    return s.activityRepo.ResolveSuggestion(ctx, id, req.State)
+}
+
+// InactivityScheduler suggests the inactive status every interval
+type InactivityScheduler struct {
+    inactivityService InactivityService
+    interval          time.Duration
+}
+
+func NewInactivityScheduler(inactivityService InactivityService, interval time.Duration) *InactivityScheduler {
+    return &InactivityScheduler{inactivityService: inactivityService, interval: interval}
+}
+
+// Run blocks until ctx is done, suggesting once right away and then every interval
+func (s *InactivityScheduler) Run(ctx context.Context) {
+    ticker := time.NewTicker(s.interval)
+    defer ticker.Stop()
+    now := time.Now()
+    for {
+        suggestions, err := s.inactivityService.Suggest(ctx, now)
+        if err != nil {
+            log.Println("Failed to suggest inactive vehicles: ", err)
+        }
+        if len(suggestions) > 0 {
+            log.Printf("Suggested the inactive status for %d vehicles", len(suggestions))
+        }
+        select {
+        case <-ctx.Done():
+            return
+        case now = <-ticker.C:
+        }
+    }
+}
+
+// InactivityMonitoringTrackingService observes the movement of every stored reading of the wrapped service.
+// Failures are logged and never fail the ingest.
+type InactivityMonitoringTrackingService struct {
+    TrackingService
+    inactivityService InactivityService
+}
+
+func NewInactivityMonitoringTrackingService(
+    trackingService TrackingService,
+    inactivityService InactivityService,
+) *InactivityMonitoringTrackingService {
+    return &InactivityMonitoringTrackingService{
+        TrackingService:   trackingService,
+        inactivityService: inactivityService,
+    }
+}
+
+func (s *InactivityMonitoringTrackingService) TrackVehicle(
+    ctx context.Context,
+    req *TrackingDataRequest,
+) (*repositories.TrackingRecord, error) {
+    trackingData, err := s.TrackingService.TrackVehicle(ctx, req)
+    if err == nil {
+        s.observe(ctx, trackingData)
+    }
+    return trackingData, err
+}
+
+func (s *InactivityMonitoringTrackingService) TrackVehicles(
+    ctx context.Context,
+    reqs []*TrackingDataRequest,
+) ([]*repositories.TrackingRecord, []error) {
+    trackingData, errs := s.TrackingService.TrackVehicles(ctx, reqs)
+    for i, data := range trackingData {
+        if errs[i] == nil && data != nil {
+            s.observe(ctx, data)
+        }
+    }
+    return trackingData, errs
+}
+
+func (s *InactivityMonitoringTrackingService) observe(ctx context.Context, trackingData *repositories.TrackingRecord) {
+    if err := s.inactivityService.Observe(ctx, trackingData); err != nil {
+        log.Println("Failed to observe vehicle activity: ", err)
+    }
+}
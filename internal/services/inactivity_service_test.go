@@ -0,0 +1,130 @@
+package services
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryActivityRepo keeps the activities and suggestions of a single tenant
+type memoryActivityRepo struct {
+    repositories.VehicleActivityRepository
+    activities  map[primitive.ObjectID]*repositories.VehicleActivity
+    suggestions []*repositories.StatusSuggestion
+}
+
+func (r *memoryActivityRepo) FindActivity(
+    _ context.Context,
+    vehicleID primitive.ObjectID,
+) (*repositories.VehicleActivity, error) {
+    return r.activities[vehicleID], nil
+}
+
+func (r *memoryActivityRepo) SaveActivity(_ context.Context, activity *repositories.VehicleActivity) error {
+    r.activities[activity.VehicleID] = activity
+    return nil
+}
+
+func (r *memoryActivityRepo) FindInactive(
+    _ context.Context,
+    before time.Time,
+) ([]*repositories.VehicleActivity, error) {
+    var inactive []*repositories.VehicleActivity
+    for _, activity := range r.activities {
+        if activity.MovedAt.Before(before) {
+            inactive = append(inactive, activity)
+        }
+    }
+    return inactive, nil
+}
+
+func (r *memoryActivityRepo) RecordSuggestion(
+    _ context.Context,
+    suggestion *repositories.StatusSuggestion,
+) (bool, error) {
+    for _, recorded := range r.suggestions {
+        if recorded.VehicleID == suggestion.VehicleID && recorded.MovedAt.Equal(suggestion.MovedAt.Time) {
+            return false, nil
+        }
+    }
+    suggestion.State = repositories.SuggestionPending
+    r.suggestions = append(r.suggestions, suggestion)
+    return true, nil
+}
+
+func (r *memoryActivityRepo) ResolveVehicleSuggestions(
+    _ context.Context,
+    vehicleID primitive.ObjectID,
+    state string,
+) error {
+    for _, suggestion := range r.suggestions {
+        if suggestion.VehicleID == vehicleID && suggestion.State == repositories.SuggestionPending {
+            suggestion.State = state
+        }
+    }
+    return nil
+}
+
+func TestInactivitySuggestions(t *testing.T) {
+    repo := &memoryActivityRepo{activities: map[primitive.ObjectID]*repositories.VehicleActivity{}}
+    publisher := &queuePublisher{}
+    s := NewMongoInactivityService(repo, publisher, 14*24*time.Hour)
+    parked, sold := primitive.NewObjectID(), primitive.NewObjectID()
+    start := time.Date(2026, 9, 1, 8, 0, 0, 0, time.UTC)
+    reading := func(vehicleID primitive.ObjectID, at time.Time, mileage float64, lat float64) {
+        trackingData := repositories.NewTrackingRecord(
+            &models.TrackingData{
+                VehicleID: vehicleID,
+                Mileage:   mileage,
+                Status:    models.VehicleStatusActive,
+                CreatedAt: at,
+            },
+        ).SetPosition(lat, 96.15)
+        if vehicleID == sold {
+            trackingData.Status = models.VehicleStatusSold
+        }
+        if err := s.Observe(context.Background(), trackingData); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    reading(parked, start, 1000, 16.8)
+    // GPS drift of a few meters and the same mileage aren't movement
+    reading(parked, start.AddDate(0, 0, 10), 1000, 16.80001)
+    reading(sold, start, 500, 16.8)
+
+    suggestions, err := s.Suggest(context.Background(), start.AddDate(0, 0, 13))
+    if err != nil || len(suggestions) != 0 {
+        t.Fatalf("expected no suggestion before the period, got %v, %v", suggestions, err)
+    }
+    suggestions, err = s.Suggest(context.Background(), start.AddDate(0, 0, 15))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(suggestions) != 1 || suggestions[0].VehicleID != parked {
+        t.Fatalf("expected the parked vehicle to be suggested, got %+v", suggestions)
+    }
+    if suggestions[0].Status != models.VehicleStatusInactive || suggestions[0].Reason != "no movement for 15 days" {
+        t.Errorf("unexpected suggestion %+v", suggestions[0])
+    }
+    var event StatusSuggested
+    if len(publisher.bodies) != 1 || json.Unmarshal(publisher.bodies[0], &event) != nil ||
+        event.Event != StatusSuggestedEvent {
+        t.Errorf("expected the suggestion to be published, got %d events", len(publisher.bodies))
+    }
+
+    // a vehicle is suggested once per time it stops moving
+    if suggestions, _ = s.Suggest(context.Background(), start.AddDate(0, 0, 16)); len(suggestions) != 0 {
+        t.Errorf("expected the suggestion not to be repeated, got %+v", suggestions)
+    }
+
+    reading(parked, start.AddDate(0, 0, 17), 1012, 16.9)
+    if repo.suggestions[0].State != repositories.SuggestionMoved {
+        t.Errorf("expected the suggestion to be closed once the vehicle moved, got %s", repo.suggestions[0].State)
+    }
+}
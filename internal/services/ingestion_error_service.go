@@ -0,0 +1,88 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "net/url"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+)
+
+const (
+    // maxRecordedPayloadSize is how much of a rejected payload is kept, enough to debug without storing huge bodies
+    maxRecordedPayloadSize = 2048
+)
+
+type IngestionErrorService interface {
+    RecordIngestionError(ctx context.Context, source string, payload []byte, err error) error
+    FindIngestionErrors(ctx context.Context, query url.Values) ([]*repositories.IngestionError, error)
+}
+
+type MongoIngestionErrorService struct {
+    ingestionErrorRepo repositories.IngestionErrorRepository
+}
+
+func NewMongoIngestionErrorService(
+    ingestionErrorRepo repositories.IngestionErrorRepository,
+) *MongoIngestionErrorService {
+    return &MongoIngestionErrorService{
+        ingestionErrorRepo: ingestionErrorRepo,
+    }
+}
+
+// RecordIngestionError stores a summary of a rejected message, the vehicle is extracted from the payload when possible
+func (s *MongoIngestionErrorService) RecordIngestionError(
+    ctx context.Context,
+    source string,
+    payload []byte,
+    err error,
+) error {
+    ingestionError := &repositories.IngestionError{
+        Source: source,
+        Reason: IngestionErrorReason(err),
+        Error:  err.Error(),
+    }
+
+    if len(payload) > maxRecordedPayloadSize {
+        ingestionError.Payload = string(payload[:maxRecordedPayloadSize])
+    } else {
+        ingestionError.Payload = string(payload)
+    }
+
+    var fields struct {
+        VehicleID string `json:"vehicle_id"`
+    }
+    if json.Unmarshal(payload, &fields) == nil {
+        ingestionError.VehicleID = fields.VehicleID
+    }
+
+    return s.ingestionErrorRepo.CreateIngestionError(ctx, ingestionError)
+}
+
+func (s *MongoIngestionErrorService) FindIngestionErrors(
+    ctx context.Context,
+    query url.Values,
+) ([]*repositories.IngestionError, error) {
+    var filter repositories.IngestionErrorFilter
+    if err := decodeQuery(query, &filter); err != nil {
+        return nil, err
+    }
+    return s.ingestionErrorRepo.FindIngestionErrors(ctx, &filter)
+}
+
+// IngestionErrorReason classifies why a message was rejected
+func IngestionErrorReason(err error) string {
+    switch {
+    case errors.Is(err, ErrMalformedPayload):
+        return repositories.IngestionReasonMalformed
+    case errors.Is(err, ErrInvalidTrackingData):
+        return repositories.IngestionReasonInvalid
+    case errors.Is(err, ErrUnknownVehicle):
+        return repositories.IngestionReasonUnknownVehicle
+    case errors.Is(err, ErrQuarantinedReading):
+        return repositories.IngestionReasonQuarantined
+    default:
+        return repositories.IngestionReasonStorageFailed
+    }
+}
@@ -0,0 +1,52 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/metrics"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+)
+
+// InstrumentedTrackingService records the latency and outcome of every ingest of the wrapped service.
+// Invalid tracking data is rejected by the client's fault, so it isn't counted as an error.
+type InstrumentedTrackingService struct {
+    TrackingService
+    recorder *metrics.IngestRecorder
+}
+
+func NewInstrumentedTrackingService(
+    trackingService TrackingService,
+    recorder *metrics.IngestRecorder,
+) *InstrumentedTrackingService {
+    return &InstrumentedTrackingService{TrackingService: trackingService, recorder: recorder}
+}
+
+func (s *InstrumentedTrackingService) TrackVehicle(
+    ctx context.Context,
+    req *TrackingDataRequest,
+) (*repositories.TrackingRecord, error) {
+    start := time.Now()
+    trackingData, err := s.TrackingService.TrackVehicle(ctx, req)
+    s.recorder.Observe(time.Since(start), isIngestFailure(err))
+    return trackingData, err
+}
+
+// TrackVehicles records a single observation per reading, each with the latency of the whole batch
+func (s *InstrumentedTrackingService) TrackVehicles(
+    ctx context.Context,
+    reqs []*TrackingDataRequest,
+) ([]*repositories.TrackingRecord, []error) {
+    start := time.Now()
+    trackingData, errs := s.TrackingService.TrackVehicles(ctx, reqs)
+    latency := time.Since(start)
+    for _, err := range errs {
+        s.recorder.Observe(latency, isIngestFailure(err))
+    }
+    return trackingData, errs
+}
+
+func isIngestFailure(err error) bool {
+    return err != nil && !errors.Is(err, ErrInvalidTrackingData)
+}
@@ -0,0 +1,219 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "log"
+    "math"
+    "net/url"
+    "sync"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+    MaintenanceDueEvent = "maintenance.due"
+)
+
+var (
+    ErrInvalidMaintenanceInterval = errors.New("maintenance interval must be positive")
+)
+
+type MaintenanceThresholdRequest struct {
+    VehicleID string  `json:"vehicle_id" validate:"required,mongodb"`
+    Interval  float64 `json:"interval" validate:"required,gt=0"`
+}
+
+// MaintenanceDue is the event published to the maintenance queue when a vehicle crosses a threshold
+type MaintenanceDue struct {
+    Event            string                         `json:"event"`
+    MaintenanceEvent *repositories.MaintenanceEvent `json:"maintenance"`
+}
+
+type MaintenanceService interface {
+    // Inspect records a maintenance event when the reading crossed a mileage threshold since the previous one
+    Inspect(ctx context.Context, trackingData *repositories.TrackingRecord) (*repositories.MaintenanceEvent, error)
+    FindThresholds(ctx context.Context) ([]*repositories.MaintenanceThreshold, error)
+    SetThreshold(ctx context.Context, req *MaintenanceThresholdRequest) (*repositories.MaintenanceThreshold, error)
+    FindEvents(ctx context.Context, query url.Values) ([]*repositories.MaintenanceEvent, error)
+}
+
+type MongoMaintenanceService struct {
+    trackingRepo    repositories.TrackingRepository
+    maintenanceRepo repositories.MaintenanceRepository
+    publisher       Publisher
+    // globalInterval applies to vehicles without their own threshold, 0 disables it. It is reloadable.
+    mu             sync.RWMutex
+    globalInterval float64
+}
+
+func NewMongoMaintenanceService(
+    trackingRepo repositories.TrackingRepository,
+    maintenanceRepo repositories.MaintenanceRepository,
+    publisher Publisher,
+    globalInterval float64,
+) *MongoMaintenanceService {
+    return &MongoMaintenanceService{
+        trackingRepo:    trackingRepo,
+        maintenanceRepo: maintenanceRepo,
+        publisher:       publisher,
+        globalInterval:  globalInterval,
+    }
+}
+
+// SetGlobalInterval changes the interval of the vehicles without their own threshold
+func (s *MongoMaintenanceService) SetGlobalInterval(globalInterval float64) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.globalInterval = globalInterval
+}
+
+func (s *MongoMaintenanceService) Inspect(
+    ctx context.Context,
+    trackingData *repositories.TrackingRecord,
+) (*repositories.MaintenanceEvent, error) {
+    s.mu.RLock()
+    interval := s.globalInterval
+    s.mu.RUnlock()
+    threshold, err := s.maintenanceRepo.FindThreshold(ctx, trackingData.VehicleID)
+    if err != nil {
+        return nil, err
+    }
+    if threshold != nil {
+        interval = threshold.Interval
+    }
+    if interval <= 0 {
+        return nil, nil
+    }
+
+    previous, err := findPreviousTrackingData(ctx, s.trackingRepo, trackingData)
+    if err != nil || previous == nil {
+        return nil, err
+    }
+    crossed, ok := CrossedMileageThreshold(previous.Mileage, trackingData.Mileage, interval)
+    if !ok {
+        return nil, nil
+    }
+
+    event := &repositories.MaintenanceEvent{
+        VehicleID:            trackingData.VehicleID,
+        TrackingDataID:       trackingData.ID,
+        TrackingDataPublicID: trackingData.PublicID,
+        Threshold:            crossed,
+        Interval:             interval,
+        Mileage:              trackingData.Mileage,
+    }
+    created, err := s.maintenanceRepo.RecordEvent(ctx, event)
+    if err != nil || !created {
+        return nil, err
+    }
+
+    body, err := json.Marshal(&MaintenanceDue{Event: MaintenanceDueEvent, MaintenanceEvent: event})
+    if err != nil {
+        return event, err
+    }
+    return event, s.publisher.Publish(ctx, body)
+}
+
+func (s *MongoMaintenanceService) FindThresholds(ctx context.Context) ([]*repositories.MaintenanceThreshold, error) {
+    return s.maintenanceRepo.FindThresholds(ctx)
+}
+
+func (s *MongoMaintenanceService) SetThreshold(
+    ctx context.Context,
+    req *MaintenanceThresholdRequest,
+) (*repositories.MaintenanceThreshold, error) {
+    id, err := primitive.ObjectIDFromHex(req.VehicleID)
+    if err != nil {
+        return nil, repositories.ErrInvalidID
+    }
+    if req.Interval <= 0 {
+        return nil, ErrInvalidMaintenanceInterval
+    }
+    threshold := &repositories.MaintenanceThreshold{VehicleID: id, Interval: req.Interval}
+    if err := s.maintenanceRepo.UpsertThreshold(ctx, threshold); err != nil {
+        return nil, err
+    }
+    return threshold, nil
+}
+
+func (s *MongoMaintenanceService) FindEvents(
+    ctx context.Context,
+    query url.Values,
+) ([]*repositories.MaintenanceEvent, error) {
+    var filter repositories.MaintenanceEventFilter
+    if err := decodeQuery(query, &filter); err != nil {
+        return nil, err
+    }
+    return s.maintenanceRepo.FindEvents(ctx, &filter)
+}
+
+// CrossedMileageThreshold returns the highest multiple of interval in (previous, current],
+// false when the mileage didn't reach a new multiple
+func CrossedMileageThreshold(previous, current, interval float64) (float64, bool) {
+    if interval <= 0 || current <= previous {
+        return 0, false
+    }
+    crossed := math.Floor(current/interval) * interval
+    if crossed <= previous {
+        return 0, false
+    }
+    return crossed, true
+}
+
+// MaintenanceMonitoringTrackingService inspects every stored reading of the wrapped service for crossed
+// maintenance thresholds. Inspection failures are logged and never fail the ingest.
+type MaintenanceMonitoringTrackingService struct {
+    TrackingService
+    maintenanceService MaintenanceService
+}
+
+func NewMaintenanceMonitoringTrackingService(
+    trackingService TrackingService,
+    maintenanceService MaintenanceService,
+) *MaintenanceMonitoringTrackingService {
+    return &MaintenanceMonitoringTrackingService{
+        TrackingService:    trackingService,
+        maintenanceService: maintenanceService,
+    }
+}
+
+func (s *MaintenanceMonitoringTrackingService) TrackVehicle(
+    ctx context.Context,
+    req *TrackingDataRequest,
+) (*repositories.TrackingRecord, error) {
+    trackingData, err := s.TrackingService.TrackVehicle(ctx, req)
+    if err == nil {
+        s.inspect(ctx, trackingData)
+    }
+    return trackingData, err
+}
+
+func (s *MaintenanceMonitoringTrackingService) TrackVehicles(
+    ctx context.Context,
+    reqs []*TrackingDataRequest,
+) ([]*repositories.TrackingRecord, []error) {
+    trackingData, errs := s.TrackingService.TrackVehicles(ctx, reqs)
+    for i, data := range trackingData {
+        if errs[i] == nil && data != nil {
+            s.inspect(ctx, data)
+        }
+    }
+    return trackingData, errs
+}
+
+func (s *MaintenanceMonitoringTrackingService) inspect(
+    ctx context.Context,
+    trackingData *repositories.TrackingRecord,
+) {
+    event, err := s.maintenanceService.Inspect(ctx, trackingData)
+    if err != nil {
+        log.Println("Failed to inspect maintenance thresholds: ", err)
+        return
+    }
+    if event != nil {
+        log.Printf("Maintenance due for vehicle %s at mileage %v", event.VehicleID.Hex(), event.Threshold)
+    }
+}
@@ -0,0 +1,30 @@
+package services
+
+import "testing"
+
+func TestCrossedMileageThreshold(t *testing.T) {
+    tests := []struct {
+        name     string
+        previous float64
+        current  float64
+        crossed  float64
+        ok       bool
+    }{
+        {"below the first threshold", 100, 9000, 0, false},
+        {"crosses a threshold", 9900, 10100, 10000, true},
+        {"reaches a threshold exactly", 9900, 10000, 10000, true},
+        {"already past the threshold", 10000, 10100, 0, false},
+        {"crosses several thresholds", 9900, 30100, 30000, true},
+        {"mileage went back", 10100, 9900, 0, false},
+    }
+    for _, test := range tests {
+        t.Run(
+            test.name, func(t *testing.T) {
+                crossed, ok := CrossedMileageThreshold(test.previous, test.current, 10000)
+                if ok != test.ok || crossed != test.crossed {
+                    t.Errorf("expected %v, %v, got %v, %v", test.crossed, test.ok, crossed, ok)
+                }
+            },
+        )
+    }
+}
@@ -0,0 +1,235 @@
+package services
+
+import (
+    "context"
+    "slices"
+    "sync"
+    "time"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/geo"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+    GeofenceEnterEvent = "geofence.enter"
+    GeofenceExitEvent  = "geofence.exit"
+    SpeedingEvent      = "vehicle.speeding"
+
+    // geofenceCacheTTL is how long the geofences are reused before they are read again, imported geofences are
+    // monitored once it passed
+    geofenceCacheTTL = time.Minute
+    // minSpeedInterval is the shortest time between two readings their speed is computed from, GPS jitter makes the
+    // speed between closer readings meaningless
+    minSpeedInterval = 5 * time.Second
+)
+
+// GeofenceAlert is the event published to the alerts queue when a vehicle enters or exits a geofence
+type GeofenceAlert struct {
+    Event          string             `json:"event"`
+    VehicleID      primitive.ObjectID `json:"vehicle_id"`
+    TrackingDataID primitive.ObjectID `json:"tracking_data_id"`
+    GeofenceID     primitive.ObjectID `json:"geofence_id"`
+    GeofenceName   string             `json:"geofence_name"`
+    Lat            float64            `json:"lat"`
+    Lng            float64            `json:"lng"`
+    At             timestamp.Time     `json:"at"`
+}
+
+// SpeedingAlert is the event published to the alerts queue when a vehicle goes faster than the speed limit, the
+// speed is the average since the previous reading
+type SpeedingAlert struct {
+    Event          string             `json:"event"`
+    VehicleID      primitive.ObjectID `json:"vehicle_id"`
+    TrackingDataID primitive.ObjectID `json:"tracking_data_id"`
+    SpeedKmh       float64            `json:"speed_kmh"`
+    LimitKmh       float64            `json:"limit_kmh"`
+    Lat            float64            `json:"lat"`
+    Lng            float64            `json:"lng"`
+    At             timestamp.Time     `json:"at"`
+}
+
+// MotionMonitor is an ingestion processor comparing the position of every stored reading with the previous one of
+// its vehicle. It alerts when the vehicle enters or exits a geofence and when it starts speeding, readings without
+// coordinates and late readings are skipped.
+type MotionMonitor struct {
+    geofenceRepo    repositories.GeofenceRepository
+    motionRepo      repositories.VehicleMotionRepository
+    alertsPublisher Publisher
+    locks           *vehicleLocks
+    geofencing      bool
+    // speedLimitKmh is the speed above which a vehicle is speeding, 0 disables the speeding alerts
+    speedLimitKmh float64
+
+    mu        sync.Mutex
+    geofences []*monitoredGeofence
+    loadedAt  time.Time
+}
+
+type monitoredGeofence struct {
+    geofence *repositories.Geofence
+    polygons [][][][]float64
+}
+
+func NewMotionMonitor(
+    geofenceRepo repositories.GeofenceRepository,
+    motionRepo repositories.VehicleMotionRepository,
+    alertsPublisher Publisher,
+    geofencing bool,
+    speedLimitKmh float64,
+) *MotionMonitor {
+    return &MotionMonitor{
+        geofenceRepo:    geofenceRepo,
+        motionRepo:      motionRepo,
+        alertsPublisher: alertsPublisher,
+        locks:           newVehicleLocks(),
+        geofencing:      geofencing,
+        speedLimitKmh:   speedLimitKmh,
+    }
+}
+
+func (m *MotionMonitor) PreValidate(context.Context, *TrackingDataRequest) error {
+    return nil
+}
+
+func (m *MotionMonitor) PostPersist(ctx context.Context, record *repositories.TrackingRecord) error {
+    point, ok := record.Point()
+    if !ok {
+        return nil
+    }
+    // the previous motion of the vehicle is compared with one reading at a time
+    unlock := m.locks.lock([]primitive.ObjectID{record.VehicleID})
+    defer unlock()
+
+    previous, err := m.motionRepo.FindMotion(ctx, record.VehicleID)
+    if err != nil {
+        return err
+    }
+    at := readingTime(record)
+    if previous != nil && !at.After(previous.At.Time) {
+        return nil
+    }
+    motion := &repositories.VehicleMotion{
+        VehicleID: record.VehicleID,
+        Lat:       point.Lat,
+        Lng:       point.Lng,
+        At:        timestamp.New(at),
+    }
+    var alerts []any
+
+    if m.geofencing {
+        geofences, err := m.loadGeofences(ctx)
+        if err != nil {
+            return err
+        }
+        for _, monitored := range geofences {
+            inside := monitored.contains(point)
+            if inside {
+                motion.GeofenceIDs = append(motion.GeofenceIDs, monitored.geofence.ID)
+            }
+            // the first position of a vehicle only tells where it is, not what it entered
+            if previous == nil {
+                continue
+            }
+            event := ""
+            wasInside := slices.Contains(previous.GeofenceIDs, monitored.geofence.ID)
+            switch {
+            case inside && !wasInside:
+                event = GeofenceEnterEvent
+            case !inside && wasInside:
+                event = GeofenceExitEvent
+            default:
+                continue
+            }
+            alerts = append(
+                alerts,
+                &GeofenceAlert{
+                    Event:          event,
+                    VehicleID:      record.VehicleID,
+                    TrackingDataID: record.ID,
+                    GeofenceID:     monitored.geofence.ID,
+                    GeofenceName:   monitored.geofence.Name,
+                    Lat:            point.Lat,
+                    Lng:            point.Lng,
+                    At:             motion.At,
+                },
+            )
+        }
+    }
+
+    if m.speedLimitKmh > 0 && previous != nil {
+        motion.Speeding = previous.Speeding
+        if elapsed := at.Sub(previous.At.Time); elapsed >= minSpeedInterval {
+            speedKmh := geo.Haversine(geo.NewPoint(previous.Lat, previous.Lng), point) / elapsed.Hours() / 1000
+            motion.Speeding = speedKmh > m.speedLimitKmh
+            // a vehicle is alerted about once per time it starts speeding
+            if motion.Speeding && !previous.Speeding {
+                alerts = append(
+                    alerts,
+                    &SpeedingAlert{
+                        Event:          SpeedingEvent,
+                        VehicleID:      record.VehicleID,
+                        TrackingDataID: record.ID,
+                        SpeedKmh:       speedKmh,
+                        LimitKmh:       m.speedLimitKmh,
+                        Lat:            point.Lat,
+                        Lng:            point.Lng,
+                        At:             motion.At,
+                    },
+                )
+            }
+        }
+    }
+
+    if err = m.motionRepo.SaveMotion(ctx, motion); err != nil {
+        return err
+    }
+    for _, alert := range alerts {
+        body, err := json.Marshal(alert)
+        if err != nil {
+            return err
+        }
+        if err = m.alertsPublisher.Publish(ctx, body); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// loadGeofences returns the geofences read at most geofenceCacheTTL ago, the polygons are decoded once per read
+func (m *MotionMonitor) loadGeofences(ctx context.Context) ([]*monitoredGeofence, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if m.geofences != nil && time.Since(m.loadedAt) < geofenceCacheTTL {
+        return m.geofences, nil
+    }
+    geofences, err := m.geofenceRepo.FindGeofences(ctx)
+    if err != nil {
+        return nil, err
+    }
+    monitored := make([]*monitoredGeofence, 0, len(geofences))
+    for _, geofence := range geofences {
+        if geofence.Geometry == nil {
+            continue
+        }
+        // the geofences are validated on import, ones that can't be decoded are skipped
+        polygons, err := geofence.Geometry.Polygons()
+        if err != nil {
+            continue
+        }
+        monitored = append(monitored, &monitoredGeofence{geofence: geofence, polygons: polygons})
+    }
+    m.geofences, m.loadedAt = monitored, time.Now()
+    return monitored, nil
+}
+
+func (g *monitoredGeofence) contains(point geo.Point) bool {
+    for _, polygon := range g.polygons {
+        if geo.InPolygon(point, polygon) {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,106 @@
+package services
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/geojson"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type fakeGeofenceRepo struct {
+    repositories.GeofenceRepository
+    geofences []*repositories.Geofence
+}
+
+func (r *fakeGeofenceRepo) FindGeofences(context.Context) ([]*repositories.Geofence, error) {
+    return r.geofences, nil
+}
+
+// fakeMotionRepo keeps the motion of every vehicle in memory, without tenants
+type fakeMotionRepo map[primitive.ObjectID]*repositories.VehicleMotion
+
+func (r fakeMotionRepo) FindMotion(
+    _ context.Context,
+    vehicleID primitive.ObjectID,
+) (*repositories.VehicleMotion, error) {
+    return r[vehicleID], nil
+}
+
+func (r fakeMotionRepo) SaveMotion(_ context.Context, motion *repositories.VehicleMotion) error {
+    r[motion.VehicleID] = motion
+    return nil
+}
+
+// publishedEvents returns the event of every published alert
+func publishedEvents(t *testing.T, publisher *recordingPublisher) []string {
+    var events []string
+    for _, body := range publisher.bodies {
+        var alert struct {
+            Event string `json:"event"`
+        }
+        if err := json.Unmarshal(body, &alert); err != nil {
+            t.Fatal(err)
+        }
+        events = append(events, alert.Event)
+    }
+    return events
+}
+
+func TestMotionMonitor_PostPersist(t *testing.T) {
+    ctx := context.Background()
+    depot := &repositories.Geofence{
+        ID:   primitive.NewObjectID(),
+        Name: "depot",
+        Geometry: &geojson.Geometry{
+            Type:        geojson.TypePolygon,
+            Coordinates: []any{[]any{[]any{96.0, 16.0}, []any{96.1, 16.0}, []any{96.1, 16.1}, []any{96.0, 16.0}}},
+        },
+    }
+    publisher := &recordingPublisher{}
+    m := NewMotionMonitor(
+        &fakeGeofenceRepo{geofences: []*repositories.Geofence{depot}},
+        fakeMotionRepo{},
+        publisher,
+        true,
+        80,
+    )
+
+    vehicleID := primitive.NewObjectID()
+    start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+    readings := []*repositories.TrackingRecord{
+        // the first position is inside without entering
+        positionedRecord(vehicleID, start, 16.01, 96.09),
+        // about 11km in a minute is speeding, outside the depot
+        positionedRecord(vehicleID, start.Add(time.Minute), 16.11, 96.09),
+        // still speeding, alerted once
+        positionedRecord(vehicleID, start.Add(2*time.Minute), 16.21, 96.09),
+        // a late reading is skipped
+        positionedRecord(vehicleID, start.Add(90*time.Second), 16.01, 96.09),
+        // back slowly into the depot
+        positionedRecord(vehicleID, start.Add(3*time.Hour), 16.01, 96.09),
+    }
+    for _, reading := range readings {
+        if err := m.PostPersist(ctx, reading); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    events := publishedEvents(t, publisher)
+    want := []string{GeofenceExitEvent, SpeedingEvent, GeofenceEnterEvent}
+    if len(events) != len(want) {
+        t.Fatalf("expected the events %v, got %v", want, events)
+    }
+    for i := range want {
+        if events[i] != want[i] {
+            t.Errorf("expected the events %v, got %v", want, events)
+        }
+    }
+    var alert GeofenceAlert
+    if err := json.Unmarshal(publisher.bodies[2], &alert); err != nil || alert.GeofenceID != depot.ID {
+        t.Errorf("expected an alert of the depot, got %+v, %v", alert, err)
+    }
+}
@@ -0,0 +1,289 @@
+package services
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "net/mail"
+    "net/url"
+    "regexp"
+    "strings"
+    "sync"
+    "text/template"
+    "time"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/notifier"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+)
+
+const (
+    // notificationQueueSize is the number of notifications waiting to be sent at most, the next ones are dropped
+    notificationQueueSize = 1000
+    // notificationConcurrency is the number of notifications sent at once, so a slow channel doesn't hold up the others
+    notificationConcurrency = 4
+    // notificationRateWindow is the window of the rate limit of a recipient
+    notificationRateWindow = time.Hour
+    // maxNotificationTemplateLength is the longest template of a notification
+    maxNotificationTemplateLength = 2000
+)
+
+var ErrInvalidNotification = errors.New("invalid notification")
+
+// phoneNumber is a phone number in E.164 format, like +15551234567
+var phoneNumber = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
+// defaultNotificationTemplates are the messages of the events sent without a template of their own. The data of a
+// template is the published alert, e.g. {{.vehicle_id}} or {{.alert.value}}.
+var defaultNotificationTemplates = map[string]string{
+    GeofenceEnterEvent: `Vehicle {{.vehicle_id}} entered {{.geofence_name}} at {{.at}}`,
+    GeofenceExitEvent:  `Vehicle {{.vehicle_id}} exited {{.geofence_name}} at {{.at}}`,
+    SpeedingEvent: `Vehicle {{.vehicle_id}} drove {{.speed_kmh}} km/h, above the limit of {{.limit_kmh}} km/h, ` +
+        `at {{.at}}`,
+    DeviceOfflineEvent: `Vehicle {{.state.vehicle_id}} didn't report for {{.silent_seconds}} seconds`,
+    FuelAnomalyEvent: `Vehicle {{.anomaly.vehicle_id}} lost fuel from {{.anomaly.from_condition}} to ` +
+        `{{.anomaly.to_condition}} over {{.anomaly.mileage_delta}} of mileage`,
+    SafetyScoreChangedEvent: `Safety score of {{with .vehicle_id}}vehicle {{.}}{{end}}{{with .driver_id}}driver ` +
+        `{{.}}{{end}} changed from {{.previous_score}} to {{.score}}`,
+    AlertTriggeredEvent: `{{.alert.rule_name}}: {{.alert.metric}} of vehicle {{.alert.vehicle_id}} is ` +
+        `{{.alert.value}} ({{.alert.comparator}} {{.alert.threshold}}) since {{.alert.since}}`,
+    AlertResolvedEvent: `{{.alert.rule_name}}: resolved for vehicle {{.alert.vehicle_id}} at {{.alert.resolved_at}}`,
+}
+
+// NotificationDispatcher sends the alerts to their recipients over the configured channels. The messages are queued
+// and sent in the background so publishing an alert doesn't wait for them, a recipient gets at most limit messages
+// an hour on this instance and the messages over the limit or a full queue are dropped.
+type NotificationDispatcher struct {
+    senders map[string]notifier.Sender
+    timeout time.Duration
+    limit   int
+    queue   chan *queuedNotification
+
+    mu        sync.Mutex
+    windows   map[string]*notificationWindow
+    templates map[string]*template.Template
+}
+
+type queuedNotification struct {
+    channel string
+    message *notifier.Message
+}
+
+// notificationWindow counts the messages sent to a recipient since start
+type notificationWindow struct {
+    start   time.Time
+    count   int
+    dropped int
+}
+
+func NewNotificationDispatcher(
+    senders map[string]notifier.Sender,
+    timeout time.Duration,
+    limit int,
+) *NotificationDispatcher {
+    return &NotificationDispatcher{
+        senders:   senders,
+        timeout:   timeout,
+        limit:     limit,
+        queue:     make(chan *queuedNotification, notificationQueueSize),
+        windows:   map[string]*notificationWindow{},
+        templates: map[string]*template.Template{},
+    }
+}
+
+// ParseAlertNotification parses a channel:recipient pair, like email:ops@example.com
+func ParseAlertNotification(value string) (*repositories.AlertNotification, error) {
+    channel, to, ok := strings.Cut(strings.TrimSpace(value), ":")
+    if !ok {
+        return nil, fmt.Errorf("%w: %q must be channel:recipient", ErrInvalidNotification, value)
+    }
+    return &repositories.AlertNotification{Channel: channel, To: to}, nil
+}
+
+// Validate fails with ErrInvalidNotification when the channel isn't configured, the recipient isn't one of the
+// channel or the template doesn't parse
+func (d *NotificationDispatcher) Validate(notification *repositories.AlertNotification) error {
+    if _, ok := d.senders[notification.Channel]; !ok {
+        return fmt.Errorf("%w: channel %q isn't configured", ErrInvalidNotification, notification.Channel)
+    }
+    switch notification.Channel {
+    case notifier.ChannelEmail:
+        if address, err := mail.ParseAddress(notification.To); err != nil || address.Address != notification.To {
+            return fmt.Errorf("%w: %q isn't an email address", ErrInvalidNotification, notification.To)
+        }
+    case notifier.ChannelSMS:
+        if !phoneNumber.MatchString(notification.To) {
+            return fmt.Errorf(
+                "%w: %q isn't a phone number like +15551234567",
+                ErrInvalidNotification,
+                notification.To,
+            )
+        }
+    case notifier.ChannelSlack:
+        if u, err := url.Parse(notification.To); err != nil || u.Scheme != "https" || u.Host == "" {
+            return fmt.Errorf(
+                "%w: the slack recipient must be the https url of an incoming webhook",
+                ErrInvalidNotification,
+            )
+        }
+    }
+    if len(notification.Template) > maxNotificationTemplateLength {
+        return fmt.Errorf(
+            "%w: template must be at most %d characters",
+            ErrInvalidNotification,
+            maxNotificationTemplateLength,
+        )
+    }
+    if _, err := template.New("notification").Parse(notification.Template); err != nil {
+        return fmt.Errorf("%w: %w", ErrInvalidNotification, err)
+    }
+    return nil
+}
+
+// Dispatch renders the alert for every notification and queues the messages, it doesn't wait for them to be sent
+func (d *NotificationDispatcher) Dispatch(
+    notifications []*repositories.AlertNotification,
+    eventType string,
+    alert []byte,
+) {
+    if len(notifications) == 0 {
+        return
+    }
+    var data map[string]any
+    decoder := json.NewDecoder(bytes.NewReader(alert))
+    // numbers are printed as they were published, not in exponent notation
+    decoder.UseNumber()
+    if err := decoder.Decode(&data); err != nil {
+        log.Println("Failed to decode the alert to notify: ", err)
+        return
+    }
+    for _, notification := range notifications {
+        text, err := d.render(notification.Template, eventType, data)
+        if err != nil {
+            log.Println("Failed to render the notification of ", eventType, ": ", err)
+            continue
+        }
+        message := &notifier.Message{To: notification.To, Subject: "Fleet alert: " + eventType, Text: text}
+        select {
+        case d.queue <- &queuedNotification{channel: notification.Channel, message: message}:
+        default:
+            log.Println("The notification queue is full, dropped the notification of ", eventType)
+        }
+    }
+}
+
+// render executes the template of the notification, the default one of the event when it is empty
+func (d *NotificationDispatcher) render(text string, eventType string, data map[string]any) (string, error) {
+    if text == "" {
+        text = defaultNotificationTemplates[eventType]
+    }
+    if text == "" {
+        text = `{{.event}}`
+    }
+    d.mu.Lock()
+    parsed, ok := d.templates[text]
+    d.mu.Unlock()
+    if !ok {
+        var err error
+        if parsed, err = template.New("notification").Parse(text); err != nil {
+            return "", err
+        }
+        d.mu.Lock()
+        d.templates[text] = parsed
+        d.mu.Unlock()
+    }
+    var b strings.Builder
+    if err := parsed.Execute(&b, data); err != nil {
+        return "", err
+    }
+    return b.String(), nil
+}
+
+// Run sends the queued messages until ctx is done
+func (d *NotificationDispatcher) Run(ctx context.Context) {
+    var wg sync.WaitGroup
+    for range notificationConcurrency {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for {
+                select {
+                case <-ctx.Done():
+                    return
+                case queued := <-d.queue:
+                    d.send(ctx, queued, time.Now())
+                }
+            }
+        }()
+    }
+    wg.Wait()
+}
+
+// send sends the message unless its recipient reached the rate limit, failures are logged without retrying
+func (d *NotificationDispatcher) send(ctx context.Context, queued *queuedNotification, now time.Time) {
+    if !d.allow(queued.channel+":"+queued.message.To, now) {
+        return
+    }
+    sendCtx, cancel := context.WithTimeout(ctx, d.timeout)
+    defer cancel()
+    if err := d.senders[queued.channel].Send(sendCtx, queued.message); err != nil {
+        log.Println("Failed to send the ", queued.channel, " notification: ", err)
+    }
+}
+
+// allow reports whether the recipient may get another message now, the first message dropped in a window is logged
+func (d *NotificationDispatcher) allow(recipient string, now time.Time) bool {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    window, ok := d.windows[recipient]
+    if !ok || now.Sub(window.start) >= notificationRateWindow {
+        window = &notificationWindow{start: now}
+        d.windows[recipient] = window
+    }
+    if window.count < d.limit {
+        window.count++
+        return true
+    }
+    if window.dropped++; window.dropped == 1 {
+        log.Println(
+            "Notifications to a ", strings.SplitN(recipient, ":", 2)[0], " recipient are rate limited until ",
+            window.start.Add(notificationRateWindow).Format(time.RFC3339),
+        )
+    }
+    return false
+}
+
+// NotificationPublisher publishes the alerts to the wrapped publisher and notifies the recipients of every alert,
+// except the alerts of the alert rules which notify the recipients of their rule
+type NotificationPublisher struct {
+    Publisher
+    dispatcher *NotificationDispatcher
+    recipients []*repositories.AlertNotification
+}
+
+func NewNotificationPublisher(
+    publisher Publisher,
+    dispatcher *NotificationDispatcher,
+    recipients []*repositories.AlertNotification,
+) *NotificationPublisher {
+    return &NotificationPublisher{Publisher: publisher, dispatcher: dispatcher, recipients: recipients}
+}
+
+func (p *NotificationPublisher) Publish(ctx context.Context, body []byte) error {
+    if err := p.Publisher.Publish(ctx, body); err != nil {
+        return err
+    }
+    var alert struct {
+        Event string `json:"event"`
+    }
+    if err := json.Unmarshal(body, &alert); err != nil || alert.Event == "" {
+        return nil
+    }
+    if alert.Event == AlertTriggeredEvent || alert.Event == AlertResolvedEvent {
+        return nil
+    }
+    p.dispatcher.Dispatch(p.recipients, alert.Event, body)
+    return nil
+}
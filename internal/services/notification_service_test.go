@@ -0,0 +1,169 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/notifier"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// recordingSender keeps the messages sent
+type recordingSender struct {
+    mu       sync.Mutex
+    messages []*notifier.Message
+}
+
+func (s *recordingSender) Send(_ context.Context, message *notifier.Message) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.messages = append(s.messages, message)
+    return nil
+}
+
+// drain sends the queued messages
+func drain(d *NotificationDispatcher, now time.Time) {
+    for {
+        select {
+        case queued := <-d.queue:
+            d.send(context.Background(), queued, now)
+        default:
+            return
+        }
+    }
+}
+
+func TestNotificationDispatcher_Validate(t *testing.T) {
+    d := NewNotificationDispatcher(
+        map[string]notifier.Sender{
+            notifier.ChannelEmail: &recordingSender{},
+            notifier.ChannelSlack: &recordingSender{},
+        },
+        time.Second,
+        10,
+    )
+    valid := []*repositories.AlertNotification{
+        {Channel: notifier.ChannelEmail, To: "ops@example.com"},
+        {Channel: notifier.ChannelSlack, To: "https://hooks.slack.com/services/T0/B0/x", Template: "{{.event}}"},
+    }
+    for _, notification := range valid {
+        if err := d.Validate(notification); err != nil {
+            t.Errorf("Should accept %+v, got %v", notification, err)
+        }
+    }
+    invalid := []*repositories.AlertNotification{
+        // text messages aren't configured
+        {Channel: notifier.ChannelSMS, To: "+15551234567"},
+        {Channel: notifier.ChannelEmail, To: "Ops <ops@example.com>"},
+        {Channel: notifier.ChannelSlack, To: "http://hooks.slack.com/services/T0/B0/x"},
+        {Channel: notifier.ChannelEmail, To: "ops@example.com", Template: "{{.event"},
+    }
+    for _, notification := range invalid {
+        if err := d.Validate(notification); !errors.Is(err, ErrInvalidNotification) {
+            t.Errorf("Should reject %+v, got %v", notification, err)
+        }
+    }
+}
+
+func TestParseAlertNotification(t *testing.T) {
+    notification, err := ParseAlertNotification(" slack:https://hooks.slack.com/services/T0/B0/x")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if notification.Channel != notifier.ChannelSlack || notification.To != "https://hooks.slack.com/services/T0/B0/x" {
+        t.Errorf("Should split the channel from the recipient, got %+v", notification)
+    }
+    if _, err = ParseAlertNotification("ops@example.com"); !errors.Is(err, ErrInvalidNotification) {
+        t.Errorf("Should reject a recipient without a channel, got %v", err)
+    }
+}
+
+func TestNotificationDispatcher_Dispatch(t *testing.T) {
+    sender := &recordingSender{}
+    d := NewNotificationDispatcher(map[string]notifier.Sender{notifier.ChannelEmail: sender}, time.Second, 2)
+
+    vehicleID := primitive.NewObjectID()
+    body, _ := json.Marshal(
+        &GeofenceAlert{
+            Event:        GeofenceEnterEvent,
+            VehicleID:    vehicleID,
+            GeofenceName: "Depot",
+            At:           timestamp.New(time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)),
+        },
+    )
+    notifications := []*repositories.AlertNotification{
+        {Channel: notifier.ChannelEmail, To: "ops@example.com"},
+        {Channel: notifier.ChannelEmail, To: "dispatch@example.com", Template: "{{.geofence_name}}: {{.event}}"},
+    }
+    d.Dispatch(notifications, GeofenceEnterEvent, body)
+    drain(d, time.Now())
+
+    if len(sender.messages) != 2 {
+        t.Fatalf("Should send a message to every recipient, got %d", len(sender.messages))
+    }
+    if sender.messages[0].Subject != "Fleet alert: geofence.enter" {
+        t.Errorf("Should name the event in the subject, got %q", sender.messages[0].Subject)
+    }
+    want := "Vehicle " + vehicleID.Hex() + " entered Depot at 2024-01-01T08:00:00.000Z"
+    if sender.messages[0].Text != want {
+        t.Errorf("Should render the default template, got %q", sender.messages[0].Text)
+    }
+    if sender.messages[1].Text != "Depot: geofence.enter" {
+        t.Errorf("Should render the template of the notification, got %q", sender.messages[1].Text)
+    }
+}
+
+func TestNotificationDispatcher_RateLimit(t *testing.T) {
+    sender := &recordingSender{}
+    d := NewNotificationDispatcher(map[string]notifier.Sender{notifier.ChannelEmail: sender}, time.Second, 2)
+    notifications := []*repositories.AlertNotification{{Channel: notifier.ChannelEmail, To: "ops@example.com"}}
+    body, _ := json.Marshal(&SpeedingAlert{Event: SpeedingEvent, SpeedKmh: 131.5, LimitKmh: 100})
+
+    now := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+    for range 3 {
+        d.Dispatch(notifications, SpeedingEvent, body)
+    }
+    drain(d, now)
+    if len(sender.messages) != 2 {
+        t.Fatalf("Should send 2 messages an hour, got %d", len(sender.messages))
+    }
+
+    d.Dispatch(notifications, SpeedingEvent, body)
+    drain(d, now.Add(time.Hour))
+    if len(sender.messages) != 3 {
+        t.Fatalf("Should send again the next hour, got %d", len(sender.messages))
+    }
+}
+
+func TestNotificationPublisher_Publish(t *testing.T) {
+    sender := &recordingSender{}
+    d := NewNotificationDispatcher(map[string]notifier.Sender{notifier.ChannelEmail: sender}, time.Second, 10)
+    publisher := &recordingPublisher{}
+    p := NewNotificationPublisher(
+        publisher,
+        d,
+        []*repositories.AlertNotification{{Channel: notifier.ChannelEmail, To: "ops@example.com"}},
+    )
+
+    offline, _ := json.Marshal(&DeviceOfflineAlert{Event: DeviceOfflineEvent, State: &repositories.VehicleState{}})
+    triggered, _ := json.Marshal(&AlertRuleAlert{Event: AlertTriggeredEvent, Alert: &repositories.Alert{}})
+    for _, body := range [][]byte{offline, triggered} {
+        if err := p.Publish(context.Background(), body); err != nil {
+            t.Fatal(err)
+        }
+    }
+    drain(d, time.Now())
+
+    if publisher.count() != 2 {
+        t.Fatalf("Should publish every alert, got %d", publisher.count())
+    }
+    if len(sender.messages) != 1 {
+        t.Fatalf("Should leave the alerts of the rules to their recipients, got %d messages", len(sender.messages))
+    }
+}
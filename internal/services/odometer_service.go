@@ -0,0 +1,402 @@
+package services
+
+import (
+    "context"
+    "log"
+    "math"
+    "slices"
+    "sync"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/geo"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// OdometerService accumulates the distance traveled by each vehicle from the coordinates of its readings,
+// so it can be cross-validated with the mileage reported by the device
+type OdometerService interface {
+    // Measure sets the distance fields of the records with coordinates and stores the records with store,
+    // which returns an error per record like TrackingRepository.CreateManyTrackingData.
+    // The odometers are only advanced by the records that were stored.
+    Measure(
+        ctx context.Context,
+        records []*repositories.TrackingRecord,
+        store func() ([]error, error),
+    ) ([]error, error)
+}
+
+// MongoOdometerService measures the readings of a batch in the order they were taken. Readings older than the odometer
+// of their vehicle arrived late, like the buffer of a device uploaded after it was offline, the readings stored after
+// them are measured again from the position before them.
+type MongoOdometerService struct {
+    odometerRepo repositories.OdometerRepository
+    // trackingRepo is nil when late readings aren't reconciled with the readings stored after them
+    trackingRepo repositories.TrackingRepository
+    // matcher is nil when the readings aren't matched to the road network
+    matcher geo.MapMatcher
+    locks   *vehicleLocks
+}
+
+func NewMongoOdometerService(
+    odometerRepo repositories.OdometerRepository,
+    trackingRepo repositories.TrackingRepository,
+) *MongoOdometerService {
+    return &MongoOdometerService{odometerRepo: odometerRepo, trackingRepo: trackingRepo, locks: newVehicleLocks()}
+}
+
+// NewMapMatchingOdometerService snaps the coordinates of the readings to the road network with the matcher before
+// measuring them, the odometers advance along the matched positions
+func NewMapMatchingOdometerService(
+    odometerRepo repositories.OdometerRepository,
+    trackingRepo repositories.TrackingRepository,
+    matcher geo.MapMatcher,
+) *MongoOdometerService {
+    return &MongoOdometerService{
+        odometerRepo: odometerRepo,
+        trackingRepo: trackingRepo,
+        matcher:      matcher,
+        locks:        newVehicleLocks(),
+    }
+}
+
+func (s *MongoOdometerService) Measure(
+    ctx context.Context,
+    records []*repositories.TrackingRecord,
+    store func() ([]error, error),
+) ([]error, error) {
+    var vehicleIDs []primitive.ObjectID
+    for _, record := range records {
+        if _, ok := record.Point(); ok && !slices.Contains(vehicleIDs, record.VehicleID) {
+            vehicleIDs = append(vehicleIDs, record.VehicleID)
+        }
+    }
+    if len(vehicleIDs) == 0 {
+        return store()
+    }
+
+    // readings of the same vehicle are measured one batch at a time, so concurrent readings can't both
+    // advance the odometer from the same position
+    unlock := s.locks.lock(vehicleIDs)
+    defer unlock()
+
+    odometers, err := s.odometerRepo.FindOdometers(ctx, vehicleIDs)
+    if err != nil {
+        return nil, err
+    }
+    if s.matcher != nil {
+        s.matchRoads(ctx, records, odometers)
+    }
+
+    // the records are measured in the order they were taken, a batch uploaded after an offline period doesn't
+    // arrive in that order
+    order := make([]int, len(records))
+    for i := range order {
+        order[i] = i
+    }
+    slices.SortStableFunc(
+        order, func(a, b int) int {
+            return readingTime(records[a]).Compare(readingTime(records[b]))
+        },
+    )
+
+    // advanced keeps the odometer after each record, applied only once the record is stored
+    advanced := make([]*repositories.VehicleOdometer, len(records))
+    // late marks the records older than the odometer of their vehicle
+    late := make([]bool, len(records))
+    for _, i := range order {
+        record := records[i]
+        point, ok := record.RoadPoint()
+        if !ok {
+            continue
+        }
+        point.Time = readingTime(record)
+        odometer := AdvanceOdometer(odometers[record.VehicleID], record.VehicleID, point)
+        distance := 0.0
+        if previous := odometers[record.VehicleID]; previous != nil {
+            late[i] = point.Time.Before(previous.ReadingAt.Time)
+            distance = odometer.Meters - previous.Meters
+            // readings out of order don't move the vehicle, GPS jitter makes the speed between close readings
+            // meaningless
+            if elapsed := point.Time.Sub(previous.ReadingAt.Time); elapsed >= minSpeedInterval {
+                record.SetSpeed(distance / elapsed.Hours() / 1000)
+            }
+        }
+        record.SetDistance(distance, odometer.Meters)
+        odometers[record.VehicleID] = odometer
+        advanced[i] = odometer
+    }
+
+    itemErrs, err := store()
+    if err != nil {
+        return itemErrs, err
+    }
+
+    latest := make(map[primitive.ObjectID]*repositories.VehicleOdometer, len(vehicleIDs))
+    lateSince := map[primitive.ObjectID]time.Time{}
+    for _, i := range order {
+        if advanced[i] == nil || (itemErrs != nil && itemErrs[i] != nil) {
+            continue
+        }
+        latest[advanced[i].VehicleID] = advanced[i]
+        if _, ok := lateSince[records[i].VehicleID]; late[i] && !ok {
+            lateSince[records[i].VehicleID] = readingTime(records[i])
+        }
+    }
+    for _, odometer := range latest {
+        if err := s.odometerRepo.SaveOdometer(ctx, odometer); err != nil {
+            return itemErrs, err
+        }
+    }
+
+    // the records are stored either way, a failed reconciliation leaves the distances of the late readings as they
+    // were measured
+    if s.trackingRepo != nil {
+        for vehicleID, since := range lateSince {
+            if err := s.reconcile(ctx, vehicleID, since, records); err != nil {
+                log.Printf("Failed to reconcile the late readings of vehicle %s: %v", vehicleID.Hex(), err)
+            }
+        }
+    }
+    return itemErrs, nil
+}
+
+// reconcile measures the stored readings of the vehicle taken since since again, from the last position before them.
+// The readings whose distance, odometer or speed changed are updated, in records too, and the odometer ends at the
+// latest of them.
+func (s *MongoOdometerService) reconcile(
+    ctx context.Context,
+    vehicleID primitive.ObjectID,
+    since time.Time,
+    records []*repositories.TrackingRecord,
+) error {
+    odometer, err := s.findOdometerBefore(ctx, vehicleID, since)
+    if err != nil {
+        return err
+    }
+
+    var changed []*repositories.TrackingRecord
+    err = s.trackingRepo.StreamTrackingData(
+        ctx, &repositories.TrackingFilter{
+            SortField: "created_at",
+            SortOrder: "asc",
+            VehicleID: vehicleID.Hex(),
+            From:      since.UTC().Format(time.RFC3339Nano),
+            // every reading was measured when it was stored, whatever its flags
+            Exclude: repositories.ExcludeNone,
+        }, func(record *repositories.TrackingRecord) error {
+            point, ok := record.RoadPoint()
+            if !ok {
+                return nil
+            }
+            next := AdvanceOdometer(odometer, vehicleID, point)
+            distance, speed := 0.0, (*float64)(nil)
+            if odometer != nil {
+                distance = next.Meters - odometer.Meters
+                if elapsed := point.Time.Sub(odometer.ReadingAt.Time); elapsed >= minSpeedInterval {
+                    speedKmh := distance / elapsed.Hours() / 1000
+                    speed = &speedKmh
+                }
+            }
+            odometer = next
+            if sameMeasurement(record.DistanceMeters, &distance) &&
+                sameMeasurement(record.OdometerMeters, &next.Meters) &&
+                sameMeasurement(record.SpeedKmh, speed) {
+                return nil
+            }
+            record.SetDistance(distance, next.Meters)
+            record.SpeedKmh = speed
+            changed = append(changed, record)
+            return nil
+        },
+    )
+    if err != nil || odometer == nil {
+        return err
+    }
+    if len(changed) > 0 {
+        if err := s.trackingRepo.UpdateTrackingDistances(ctx, changed); err != nil {
+            return err
+        }
+    }
+    updated := make(map[primitive.ObjectID]*repositories.TrackingRecord, len(changed))
+    for _, record := range changed {
+        updated[record.ID] = record
+    }
+    for _, record := range records {
+        if update, ok := updated[record.ID]; ok {
+            record.DistanceMeters, record.OdometerMeters = update.DistanceMeters, update.OdometerMeters
+            record.SpeedKmh = update.SpeedKmh
+        }
+    }
+    return s.odometerRepo.SaveOdometer(ctx, odometer)
+}
+
+// findOdometerBefore returns the odometer of the vehicle at its last measured reading before before, nil when the
+// vehicle has none
+func (s *MongoOdometerService) findOdometerBefore(
+    ctx context.Context,
+    vehicleID primitive.ObjectID,
+    before time.Time,
+) (*repositories.VehicleOdometer, error) {
+    for page := 1; ; page++ {
+        previous, err := s.trackingRepo.FindTrackingData(
+            ctx, &repositories.TrackingFilter{
+                Page:      page,
+                PageSize:  100,
+                SortField: "-created_at",
+                VehicleID: vehicleID.Hex(),
+                To:        before.UTC().Format(time.RFC3339Nano),
+                Exclude:   repositories.ExcludeNone,
+            },
+        )
+        if err != nil || len(previous) == 0 {
+            return nil, err
+        }
+        for _, record := range previous {
+            if point, ok := record.RoadPoint(); ok && record.OdometerMeters != nil {
+                return &repositories.VehicleOdometer{
+                    VehicleID: vehicleID,
+                    Lat:       point.Lat,
+                    Lng:       point.Lng,
+                    Meters:    *record.OdometerMeters,
+                    ReadingAt: timestamp.New(point.Time),
+                }, nil
+            }
+        }
+    }
+}
+
+// sameMeasurement reports whether two optional measurements are equal, within the rounding of the stored values
+func sameMeasurement(a, b *float64) bool {
+    if a == nil || b == nil {
+        return a == b
+    }
+    return math.Abs(*a-*b) < 1e-6
+}
+
+// matchRoads snaps the coordinates of the records to the road network, the trace of a vehicle starts at the position
+// of its odometer so the provider knows where the vehicle came from. A failed match is only logged, the records keep
+// their GPS coordinates.
+func (s *MongoOdometerService) matchRoads(
+    ctx context.Context,
+    records []*repositories.TrackingRecord,
+    odometers map[primitive.ObjectID]*repositories.VehicleOdometer,
+) {
+    var vehicleIDs []primitive.ObjectID
+    traces := map[primitive.ObjectID][]*repositories.TrackingRecord{}
+    for _, record := range records {
+        if _, ok := record.Point(); !ok {
+            continue
+        }
+        if _, ok := traces[record.VehicleID]; !ok {
+            vehicleIDs = append(vehicleIDs, record.VehicleID)
+        }
+        traces[record.VehicleID] = append(traces[record.VehicleID], record)
+    }
+
+    for _, vehicleID := range vehicleIDs {
+        trace := traces[vehicleID]
+        slices.SortStableFunc(
+            trace, func(a, b *repositories.TrackingRecord) int {
+                return readingTime(a).Compare(readingTime(b))
+            },
+        )
+        points := make([]geo.Point, 0, len(trace)+1)
+        // readings out of order would send the vehicle back to where it was
+        if previous := odometers[vehicleID]; previous != nil && !readingTime(trace[0]).Before(previous.ReadingAt.Time) {
+            points = append(points, geo.Point{Lat: previous.Lat, Lng: previous.Lng, Time: previous.ReadingAt.Time})
+        }
+        offset := len(points)
+        for _, record := range trace {
+            point, _ := record.Point()
+            point.Time = readingTime(record)
+            points = append(points, point)
+        }
+
+        // a single position has no direction to match it with
+        if len(points) < 2 {
+            continue
+        }
+        result, err := s.matcher.Match(ctx, points)
+        if err != nil {
+            log.Printf("Failed to match the positions of vehicle %s to the roads: %v", vehicleID.Hex(), err)
+            continue
+        }
+        for i, record := range trace {
+            if j := offset + i; j < len(result.Matched) && result.Matched[j] {
+                record.SetMatchedPosition(result.Points[j].Lat, result.Points[j].Lng, result.Roads[j])
+            }
+        }
+    }
+}
+
+// AdvanceOdometer returns the odometer after the vehicle moved to point. Readings older than the last
+// position arrived out of order, they don't advance the odometer to avoid counting the same road twice.
+func AdvanceOdometer(
+    odometer *repositories.VehicleOdometer,
+    vehicleID primitive.ObjectID,
+    point geo.Point,
+) *repositories.VehicleOdometer {
+    next := &repositories.VehicleOdometer{VehicleID: vehicleID, Lat: point.Lat, Lng: point.Lng, ReadingAt: timestamp.New(point.Time)}
+    if odometer == nil {
+        return next
+    }
+    if point.Time.Before(odometer.ReadingAt.Time) {
+        return odometer
+    }
+    next.Meters = odometer.Meters + geo.Haversine(geo.Point{Lat: odometer.Lat, Lng: odometer.Lng}, point)
+    return next
+}
+
+// vehicleLocks is a mutex per vehicle
+type vehicleLocks struct {
+    mu    sync.Mutex
+    locks map[primitive.ObjectID]*vehicleLock
+}
+
+type vehicleLock struct {
+    sync.Mutex
+    users int
+}
+
+func newVehicleLocks() *vehicleLocks {
+    return &vehicleLocks{locks: map[primitive.ObjectID]*vehicleLock{}}
+}
+
+// lock locks all the vehicles, always in the same order so concurrent batches can't deadlock
+func (l *vehicleLocks) lock(vehicleIDs []primitive.ObjectID) func() {
+    ids := slices.Clone(vehicleIDs)
+    slices.SortFunc(
+        ids, func(a, b primitive.ObjectID) int {
+            return slices.Compare(a[:], b[:])
+        },
+    )
+
+    held := make([]*vehicleLock, 0, len(ids))
+    for _, id := range ids {
+        l.mu.Lock()
+        lock, ok := l.locks[id]
+        if !ok {
+            lock = &vehicleLock{}
+            l.locks[id] = lock
+        }
+        lock.users++
+        l.mu.Unlock()
+
+        lock.Lock()
+        held = append(held, lock)
+    }
+
+    return func() {
+        l.mu.Lock()
+        defer l.mu.Unlock()
+        for i, lock := range held {
+            lock.Unlock()
+            lock.users--
+            if lock.users == 0 {
+                delete(l.locks, ids[i])
+            }
+        }
+    }
+}
@@ -0,0 +1,222 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "math"
+    "testing"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/geo"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeOdometerRepo keeps the odometers in memory
+type fakeOdometerRepo map[primitive.ObjectID]*repositories.VehicleOdometer
+
+func (r fakeOdometerRepo) FindOdometers(
+    _ context.Context,
+    vehicleIDs []primitive.ObjectID,
+) (map[primitive.ObjectID]*repositories.VehicleOdometer, error) {
+    odometers := map[primitive.ObjectID]*repositories.VehicleOdometer{}
+    for _, id := range vehicleIDs {
+        if odometer, ok := r[id]; ok {
+            odometers[id] = odometer
+        }
+    }
+    return odometers, nil
+}
+
+func (r fakeOdometerRepo) SaveOdometer(_ context.Context, odometer *repositories.VehicleOdometer) error {
+    r[odometer.VehicleID] = odometer
+    return nil
+}
+
+// fakeMapMatcher moves every point 0.001 degrees north onto a road, and records the traces it matched
+type fakeMapMatcher struct {
+    traces [][]geo.Point
+}
+
+func (m *fakeMapMatcher) Match(_ context.Context, points []geo.Point) (*geo.MatchResult, error) {
+    m.traces = append(m.traces, points)
+    result := &geo.MatchResult{}
+    for _, point := range points {
+        point.Lat += 0.001
+        result.Points = append(result.Points, point)
+        result.Matched = append(result.Matched, len(points) > 1)
+        result.Roads = append(result.Roads, "Pyay Road")
+    }
+    return result, nil
+}
+
+func TestAdvanceOdometer(t *testing.T) {
+    vehicleID := primitive.NewObjectID()
+    start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+    odometer := AdvanceOdometer(nil, vehicleID, geo.Point{Lat: 0, Lng: 0, Time: start})
+    if odometer.Meters != 0 {
+        t.Fatalf("expected the first position to start at 0, got %v", odometer.Meters)
+    }
+
+    // one degree of longitude on the equator is about 111.2km
+    odometer = AdvanceOdometer(odometer, vehicleID, geo.Point{Lat: 0, Lng: 1, Time: start.Add(time.Hour)})
+    if math.Abs(odometer.Meters-111195) > 1 {
+        t.Errorf("expected about 111195 meters, got %v", odometer.Meters)
+    }
+
+    // a reading older than the last position doesn't move the odometer
+    late := AdvanceOdometer(odometer, vehicleID, geo.Point{Lat: 0, Lng: 5, Time: start.Add(time.Minute)})
+    if late != odometer {
+        t.Errorf("expected an out of order reading to keep the odometer, got %+v", late)
+    }
+}
+
+func TestMongoOdometerService_Measure(t *testing.T) {
+    s := NewMongoOdometerService(fakeOdometerRepo{}, nil)
+    vehicleID := primitive.NewObjectID()
+    start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+    records := []*repositories.TrackingRecord{
+        positionedRecord(vehicleID, start, 0, 0),
+        positionedRecord(vehicleID, start.Add(time.Hour), 0, 1),
+        // too close to the previous reading for a speed
+        positionedRecord(vehicleID, start.Add(time.Hour+time.Second), 0, 1.0001),
+    }
+    for _, record := range records {
+        _, err := s.Measure(
+            context.Background(), []*repositories.TrackingRecord{record}, func() ([]error, error) {
+                return nil, nil
+            },
+        )
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    if records[0].SpeedKmh != nil || *records[0].DistanceMeters != 0 {
+        t.Errorf("expected the first position without a speed, got %+v", records[0])
+    }
+    if math.Abs(*records[1].SpeedKmh-111.195) > 0.01 || math.Abs(*records[1].DistanceMeters-111195) > 1 {
+        t.Errorf("expected about 111.2km in an hour, got %v km/h", *records[1].SpeedKmh)
+    }
+    if records[2].SpeedKmh != nil || *records[2].DistanceMeters == 0 {
+        t.Errorf("expected a distance without a speed, got %+v", records[2])
+    }
+}
+
+func TestMongoOdometerService_MeasureMatched(t *testing.T) {
+    matcher := &fakeMapMatcher{}
+    s := NewMapMatchingOdometerService(fakeOdometerRepo{}, nil, matcher)
+    vehicleID := primitive.NewObjectID()
+    start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+    records := []*repositories.TrackingRecord{
+        positionedRecord(vehicleID, start, 0, 0),
+        positionedRecord(vehicleID, start.Add(time.Hour), 0, 1),
+    }
+    for _, record := range records {
+        _, err := s.Measure(
+            context.Background(), []*repositories.TrackingRecord{record}, func() ([]error, error) {
+                return nil, nil
+            },
+        )
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    // the first reading isn't matched on its own, the second after the position of the odometer
+    if len(matcher.traces) != 1 || len(matcher.traces[0]) != 2 || matcher.traces[0][0].Lng != 0 {
+        t.Fatalf("expected a trace starting at the first position, got %v", matcher.traces)
+    }
+    if records[0].MatchedLat != nil {
+        t.Errorf("expected a single position to stay unmatched, got %+v", records[0])
+    }
+    if *records[1].MatchedLat != 0.001 || *records[1].Lat != 0 || records[1].RoadName != "Pyay Road" {
+        t.Errorf("expected the matched position next to the GPS one, got %+v", records[1])
+    }
+    // the odometer moved from the GPS position to the matched one
+    if distance := geo.Haversine(geo.NewPoint(0, 0), geo.NewPoint(0.001, 1)); *records[1].DistanceMeters != distance {
+        t.Errorf("expected %v meters between the positions, got %v", distance, *records[1].DistanceMeters)
+    }
+}
+
+func TestMongoOdometerService_MeasureOutOfOrder(t *testing.T) {
+    odometerRepo := fakeOdometerRepo{}
+    s := NewMongoOdometerService(odometerRepo, nil)
+    vehicleID := primitive.NewObjectID()
+    start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+    // a batch uploaded after an offline period, newest first
+    records := []*repositories.TrackingRecord{
+        positionedRecord(vehicleID, start.Add(2*time.Hour), 0, 2),
+        positionedRecord(vehicleID, start, 0, 0),
+        positionedRecord(vehicleID, start.Add(time.Hour), 0, 1),
+    }
+    _, err := s.Measure(
+        context.Background(), records, func() ([]error, error) {
+            return nil, nil
+        },
+    )
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if *records[1].DistanceMeters != 0 || records[1].SpeedKmh != nil {
+        t.Errorf("expected the earliest reading to start the odometer, got %+v", records[1])
+    }
+    for _, record := range []*repositories.TrackingRecord{records[2], records[0]} {
+        if math.Abs(*record.DistanceMeters-111195) > 1 || math.Abs(*record.SpeedKmh-111.195) > 0.01 {
+            t.Errorf("expected about 111.2km in an hour, got %v meters", *record.DistanceMeters)
+        }
+    }
+    if odometer := odometerRepo[vehicleID]; math.Abs(odometer.Meters-222390) > 2 || odometer.Lng != 2 {
+        t.Errorf("expected the odometer at the latest reading, got %+v", odometer)
+    }
+}
+
+func TestMongoOdometerService_MeasureLate(t *testing.T) {
+    ctx := context.Background()
+    trackingRepo := repositories.NewMemoryTrackingRepository()
+    odometerRepo := fakeOdometerRepo{}
+    s := NewMongoOdometerService(odometerRepo, trackingRepo)
+    vehicleID := primitive.NewObjectID()
+    start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+    measure := func(records ...*repositories.TrackingRecord) {
+        t.Helper()
+        itemErrs, err := s.Measure(
+            ctx, records, func() ([]error, error) {
+                return trackingRepo.CreateManyTrackingData(ctx, records)
+            },
+        )
+        if err = errors.Join(append(itemErrs, err)...); err != nil {
+            t.Fatal(err)
+        }
+    }
+    measure(positionedRecord(vehicleID, start, 0, 0), positionedRecord(vehicleID, start.Add(2*time.Hour), 0, 2))
+
+    // the reading between them arrives after the device was offline
+    late := positionedRecord(vehicleID, start.Add(time.Hour), 0, 1)
+    measure(late)
+
+    if math.Abs(*late.DistanceMeters-111195) > 1 || math.Abs(*late.OdometerMeters-111195) > 1 {
+        t.Errorf("expected the late reading measured from the one before it, got %v meters", *late.DistanceMeters)
+    }
+    stored, err := trackingRepo.FindTrackingData(
+        ctx, &repositories.TrackingFilter{VehicleID: vehicleID.Hex(), SortField: "created_at", SortOrder: "asc"},
+    )
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(stored) != 3 {
+        t.Fatalf("expected the 3 readings to be stored, got %d", len(stored))
+    }
+    last := stored[len(stored)-1]
+    if math.Abs(*last.DistanceMeters-111195) > 1 || math.Abs(*last.SpeedKmh-111.195) > 0.01 {
+        t.Errorf("expected the reading after the late one measured from it, got %v meters", *last.DistanceMeters)
+    }
+    if math.Abs(*last.OdometerMeters-222390) > 2 {
+        t.Errorf("expected the odometer of the latest reading to stay the same, got %v", *last.OdometerMeters)
+    }
+    if odometer := odometerRepo[vehicleID]; math.Abs(odometer.Meters-222390) > 2 || odometer.Lng != 2 {
+        t.Errorf("expected the odometer at the latest reading, got %+v", odometer)
+    }
+}
@@ -0,0 +1,230 @@
+package services
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "fmt"
+    "net/http"
+    "sync"
+    "time"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-common"
+)
+
+// The actions authorized by the policy, named resource:verb
+const (
+    ActionReadAccessAudits       = "access_audit:read"
+    ActionReadAlertRules         = "alert_rule:read"
+    ActionWriteAlertRules        = "alert_rule:write"
+    ActionReadAssignments        = "assignment:read"
+    ActionWriteConsumer          = "consumer:write"
+    ActionWriteAssignments       = "assignment:write"
+    ActionReadDriverAssignments  = "driver_assignment:read"
+    ActionWriteDriverAssignments = "driver_assignment:write"
+    ActionReadDeprecations       = "deprecation:read"
+    ActionReadDigests            = "digest:read"
+    ActionWriteDigests           = "digest:write"
+    ActionReadDiagnostics        = "diagnostics:read"
+    ActionReadQuarantine         = "quarantine:read"
+    ActionWriteQuarantine        = "quarantine:write"
+    ActionReadSimulation         = "simulation:read"
+    ActionWriteSimulation        = "simulation:write"
+    ActionDeleteTrackingData     = "tracking:delete"
+    ActionReadDeletionAudits     = "deletion_audit:read"
+    ActionExportTrackingData     = "tracking:export"
+    ActionReadDisclosureAudits   = "disclosure_audit:read"
+    ActionReadVehicleEvents      = "vehicle_event:read"
+    ActionWriteStatusSuggestions = "status_suggestion:write"
+    ActionReadWebhooks           = "webhook:read"
+    ActionWriteWebhooks          = "webhook:write"
+)
+
+// policyCacheMaxEntries bounds the decisions cached by the HTTP policy, the cache is cleared when it is full
+const policyCacheMaxEntries = 10000
+
+var (
+    ErrPolicyDenied      = errors.New("denied by the authorization policy")
+    ErrPolicyUnavailable = errors.New("authorization policy is unavailable")
+)
+
+// adminActions are the actions the role policy only allows admins
+var adminActions = map[string]bool{
+    ActionReadAccessAudits:       true,
+    ActionReadAlertRules:         true,
+    ActionWriteAlertRules:        true,
+    ActionReadAssignments:        true,
+    ActionWriteConsumer:          true,
+    ActionWriteAssignments:       true,
+    ActionReadDriverAssignments:  true,
+    ActionWriteDriverAssignments: true,
+    ActionReadDeprecations:       true,
+    ActionReadDigests:            true,
+    ActionWriteDigests:           true,
+    ActionReadDiagnostics:        true,
+    ActionReadQuarantine:         true,
+    ActionWriteQuarantine:        true,
+    ActionReadSimulation:         true,
+    ActionWriteSimulation:        true,
+    ActionDeleteTrackingData:     true,
+    ActionReadDeletionAudits:     true,
+    ActionReadDisclosureAudits:   true,
+    ActionReadVehicleEvents:      true,
+    ActionWriteStatusSuggestions: true,
+    ActionReadWebhooks:           true,
+    ActionWriteWebhooks:          true,
+}
+
+// PolicyInput is what a decision is made on: who does what on which resource
+type PolicyInput struct {
+    Action string `json:"action"`
+    // User is nil when access control is disabled
+    User     *Claims `json:"user"`
+    TenantID string  `json:"tenant_id,omitempty"`
+    // Resource are the attributes of the data the action is on, e.g. the vehicle and time range of an export
+    Resource map[string]any `json:"resource,omitempty"`
+}
+
+type PolicyService interface {
+    // Authorize returns nil when the action is allowed, ErrPolicyUnavailable when no decision could be made
+    Authorize(ctx context.Context, input *PolicyInput) error
+}
+
+type policyContextKey struct{}
+
+// WithPolicy returns a context carrying the policy the handlers authorize their actions with
+func WithPolicy(ctx context.Context, policy PolicyService) context.Context {
+    return context.WithValue(ctx, policyContextKey{}, policy)
+}
+
+// PolicyFromContext returns the policy of the context, the role policy when there is none
+func PolicyFromContext(ctx context.Context) PolicyService {
+    if policy, ok := ctx.Value(policyContextKey{}).(PolicyService); ok && policy != nil {
+        return policy
+    }
+    return RolePolicyService{}
+}
+
+// RolePolicyService is the built-in policy, the admin actions need the admin role and the other actions are
+// allowed. Requests without a user are trusted because access control is disabled for them.
+type RolePolicyService struct{}
+
+func (RolePolicyService) Authorize(_ context.Context, input *PolicyInput) error {
+    if input.User != nil && adminActions[input.Action] && !input.User.IsAdmin() {
+        return ErrAdminRequired
+    }
+    return nil
+}
+
+// HTTPPolicyService asks an external policy decision point, like the data API of Open Policy Agent.
+// The input is posted as {"input": ...} and the decision is read from {"result": true} or
+// {"result": {"allow": true}}, an undefined result is a denial. Decisions are cached for the TTL.
+type HTTPPolicyService struct {
+    client *http.Client
+    url    string
+    // token is sent as a bearer token when it is set
+    token string
+    ttl   time.Duration
+
+    mu        sync.Mutex
+    decisions map[string]policyDecision
+}
+
+type policyDecision struct {
+    allowed bool
+    expires time.Time
+}
+
+func NewHTTPPolicyService(client *http.Client, url string, token string, ttl time.Duration) *HTTPPolicyService {
+    return &HTTPPolicyService{
+        client:    client,
+        url:       url,
+        token:     token,
+        ttl:       ttl,
+        decisions: map[string]policyDecision{},
+    }
+}
+
+func (s *HTTPPolicyService) Authorize(ctx context.Context, input *PolicyInput) error {
+    body, err := json.Marshal(map[string]*PolicyInput{"input": input})
+    if err != nil {
+        return err
+    }
+
+    // the request body identifies the decision, the same user doing the same action is answered from the cache
+    key := string(body)
+    allowed, ok := s.cached(key)
+    if !ok {
+        if allowed, err = s.decide(ctx, body); err != nil {
+            return fmt.Errorf("%w: %w", ErrPolicyUnavailable, err)
+        }
+        s.cache(key, allowed)
+    }
+    if !allowed {
+        return fmt.Errorf("%w: %s", ErrPolicyDenied, input.Action)
+    }
+    return nil
+}
+
+func (s *HTTPPolicyService) cached(key string) (bool, bool) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    decision, ok := s.decisions[key]
+    if !ok || time.Now().After(decision.expires) {
+        return false, false
+    }
+    return decision.allowed, true
+}
+
+func (s *HTTPPolicyService) cache(key string, allowed bool) {
+    if s.ttl <= 0 {
+        return
+    }
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if len(s.decisions) >= policyCacheMaxEntries {
+        clear(s.decisions)
+    }
+    s.decisions[key] = policyDecision{allowed: allowed, expires: time.Now().Add(s.ttl)}
+}
+
+func (s *HTTPPolicyService) decide(ctx context.Context, body []byte) (bool, error) {
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+    if err != nil {
+        return false, err
+    }
+    req.Header.Set("Content-Type", common.ApplicationJSON)
+    if s.token != "" {
+        req.Header.Set("Authorization", "Bearer "+s.token)
+    }
+    res, err := s.client.Do(req)
+    if err != nil {
+        return false, err
+    }
+    defer res.Body.Close()
+    if res.StatusCode != http.StatusOK {
+        return false, fmt.Errorf("policy decision point responded with %s", res.Status)
+    }
+
+    var decision struct {
+        Result json.RawMessage `json:"result"`
+    }
+    if err = json.NewDecoder(res.Body).Decode(&decision); err != nil {
+        return false, err
+    }
+    if len(decision.Result) == 0 {
+        return false, nil
+    }
+    var allowed bool
+    if err = json.Unmarshal(decision.Result, &allowed); err == nil {
+        return allowed, nil
+    }
+    var result struct {
+        Allow bool `json:"allow"`
+    }
+    if err = json.Unmarshal(decision.Result, &result); err != nil {
+        return false, fmt.Errorf("unexpected policy result: %s", decision.Result)
+    }
+    return result.Allow, nil
+}
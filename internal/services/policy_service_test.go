@@ -0,0 +1,87 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/goccy/go-json"
+)
+
+func TestRolePolicyService_Authorize(t *testing.T) {
+    ctx := context.Background()
+    policy := PolicyFromContext(ctx)
+
+    driver := &Claims{UserID: "driver-1", Role: "driver"}
+    if err := policy.Authorize(ctx, &PolicyInput{Action: ActionDeleteTrackingData, User: driver}); !errors.Is(err, ErrAdminRequired) {
+        t.Errorf("expected ErrAdminRequired for a driver, got %v", err)
+    }
+    if err := policy.Authorize(ctx, &PolicyInput{Action: ActionExportTrackingData, User: driver}); err != nil {
+        t.Errorf("expected exports to be allowed, got %v", err)
+    }
+    admin := &Claims{UserID: "admin-1", Role: RoleAdmin}
+    if err := policy.Authorize(ctx, &PolicyInput{Action: ActionDeleteTrackingData, User: admin}); err != nil {
+        t.Errorf("expected admins to be allowed, got %v", err)
+    }
+    if err := policy.Authorize(ctx, &PolicyInput{Action: ActionDeleteTrackingData}); err != nil {
+        t.Errorf("expected requests without a user to be trusted, got %v", err)
+    }
+}
+
+func TestHTTPPolicyService_Authorize(t *testing.T) {
+    var calls atomic.Int32
+    server := httptest.NewServer(
+        http.HandlerFunc(
+            func(w http.ResponseWriter, r *http.Request) {
+                calls.Add(1)
+                var body struct {
+                    Input PolicyInput `json:"input"`
+                }
+                if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+                    t.Error(err)
+                }
+                switch body.Input.User.UserID {
+                case "allowed":
+                    _, _ = w.Write([]byte(`{"result": {"allow": true}}`))
+                case "denied":
+                    _, _ = w.Write([]byte(`{"result": false}`))
+                case "undefined":
+                    _, _ = w.Write([]byte(`{}`))
+                default:
+                    w.WriteHeader(http.StatusInternalServerError)
+                }
+            },
+        ),
+    )
+    defer server.Close()
+
+    ctx := context.Background()
+    policy := NewHTTPPolicyService(server.Client(), server.URL, "", time.Minute)
+    input := func(userID string) *PolicyInput {
+        return &PolicyInput{
+            Action:   ActionExportTrackingData,
+            User:     &Claims{UserID: userID},
+            Resource: map[string]any{"age_days": 90},
+        }
+    }
+
+    if err := policy.Authorize(ctx, input("allowed")); err != nil {
+        t.Errorf("expected the export to be allowed, got %v", err)
+    }
+    if err := policy.Authorize(ctx, input("allowed")); err != nil || calls.Load() != 1 {
+        t.Errorf("expected the cached decision, got %v after %d calls", err, calls.Load())
+    }
+    if err := policy.Authorize(ctx, input("denied")); !errors.Is(err, ErrPolicyDenied) {
+        t.Errorf("expected ErrPolicyDenied, got %v", err)
+    }
+    if err := policy.Authorize(ctx, input("undefined")); !errors.Is(err, ErrPolicyDenied) {
+        t.Errorf("expected an undefined result to deny, got %v", err)
+    }
+    if err := policy.Authorize(ctx, input("failing")); !errors.Is(err, ErrPolicyUnavailable) {
+        t.Errorf("expected ErrPolicyUnavailable, got %v", err)
+    }
+}
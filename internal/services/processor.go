@@ -0,0 +1,186 @@
+package services
+
+import (
+    "context"
+    "fmt"
+    "log"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+)
+
+// Processor is an extension point of the ingestion, deployment specific logic like custom enrichment or extra
+// events is added by registering a processor instead of changing the tracking service or the consumer loop
+type Processor interface {
+    // PreValidate runs before the reading is validated and stored, it may modify the request.
+    // An error rejects the reading as invalid tracking data.
+    PreValidate(ctx context.Context, req *TrackingDataRequest) error
+    // PostPersist runs after the reading was stored, an error is logged without failing the ingestion
+    PostPersist(ctx context.Context, record *repositories.TrackingRecord) error
+}
+
+// PublishProcessor is a processor that also runs before the stored reading is published to the vehicle queue, like
+// redacting fields downstream services must not see
+type PublishProcessor interface {
+    Processor
+    // PrePublish returns the message of the stored reading to publish, it may modify it. An error drops the message
+    // for every format and is logged.
+    PrePublish(ctx context.Context, message []byte) ([]byte, error)
+}
+
+// ProcessorFuncs is a publish processor of hook functions, for processors that don't need a type of their own. The
+// hooks left nil do nothing.
+type ProcessorFuncs struct {
+    PreValidateFunc func(ctx context.Context, req *TrackingDataRequest) error
+    PostPersistFunc func(ctx context.Context, record *repositories.TrackingRecord) error
+    PrePublishFunc  func(ctx context.Context, message []byte) ([]byte, error)
+}
+
+func (f *ProcessorFuncs) PreValidate(ctx context.Context, req *TrackingDataRequest) error {
+    if f.PreValidateFunc == nil {
+        return nil
+    }
+    return f.PreValidateFunc(ctx, req)
+}
+
+func (f *ProcessorFuncs) PostPersist(ctx context.Context, record *repositories.TrackingRecord) error {
+    if f.PostPersistFunc == nil {
+        return nil
+    }
+    return f.PostPersistFunc(ctx, record)
+}
+
+func (f *ProcessorFuncs) PrePublish(ctx context.Context, message []byte) ([]byte, error) {
+    if f.PrePublishFunc == nil {
+        return message, nil
+    }
+    return f.PrePublishFunc(ctx, message)
+}
+
+// ProcessorRegistry runs the registered processors in registration order
+type ProcessorRegistry struct {
+    processors []Processor
+}
+
+func NewProcessorRegistry() *ProcessorRegistry {
+    return &ProcessorRegistry{}
+}
+
+func (r *ProcessorRegistry) Register(processor Processor) {
+    r.processors = append(r.processors, processor)
+}
+
+// PreValidate stops at the first processor rejecting the reading
+func (r *ProcessorRegistry) PreValidate(ctx context.Context, req *TrackingDataRequest) error {
+    for _, processor := range r.processors {
+        if err := processor.PreValidate(ctx, req); err != nil {
+            return fmt.Errorf("%w: %w", ErrInvalidTrackingData, err)
+        }
+    }
+    return nil
+}
+
+// PostPersist runs every processor, even when a previous one failed
+func (r *ProcessorRegistry) PostPersist(ctx context.Context, record *repositories.TrackingRecord) {
+    for _, processor := range r.processors {
+        if err := processor.PostPersist(ctx, record); err != nil {
+            log.Printf("Processor %T failed after persisting tracking data: %v", processor, err)
+        }
+    }
+}
+
+// PrePublish passes the message through every publish processor, each one gets the message of the previous one. It
+// stops at the first processor failing.
+func (r *ProcessorRegistry) PrePublish(ctx context.Context, message []byte) ([]byte, error) {
+    for _, processor := range r.processors {
+        publishProcessor, ok := processor.(PublishProcessor)
+        if !ok {
+            continue
+        }
+        var err error
+        if message, err = publishProcessor.PrePublish(ctx, message); err != nil {
+            return nil, fmt.Errorf("processor %T dropped the message: %w", processor, err)
+        }
+    }
+    return message, nil
+}
+
+// ProcessingPublisher runs the publish processors on every message before the wrapped publisher publishes it
+type ProcessingPublisher struct {
+    Publisher
+    registry *ProcessorRegistry
+}
+
+func NewProcessingPublisher(publisher Publisher, registry *ProcessorRegistry) *ProcessingPublisher {
+    return &ProcessingPublisher{Publisher: publisher, registry: registry}
+}
+
+func (p *ProcessingPublisher) Publish(ctx context.Context, body []byte) error {
+    body, err := p.registry.PrePublish(ctx, body)
+    if err != nil {
+        return err
+    }
+    return p.Publisher.Publish(ctx, body)
+}
+
+// ProcessingTrackingService runs the registered processors around every ingest of the wrapped service
+type ProcessingTrackingService struct {
+    TrackingService
+    registry *ProcessorRegistry
+}
+
+func NewProcessingTrackingService(
+    trackingService TrackingService,
+    registry *ProcessorRegistry,
+) *ProcessingTrackingService {
+    return &ProcessingTrackingService{TrackingService: trackingService, registry: registry}
+}
+
+func (s *ProcessingTrackingService) TrackVehicle(
+    ctx context.Context,
+    req *TrackingDataRequest,
+) (*repositories.TrackingRecord, error) {
+    if err := s.registry.PreValidate(ctx, req); err != nil {
+        return nil, err
+    }
+    trackingData, err := s.TrackingService.TrackVehicle(ctx, req)
+    if err != nil {
+        return nil, err
+    }
+    s.registry.PostPersist(ctx, trackingData)
+    return trackingData, nil
+}
+
+// TrackVehicles only passes the readings accepted by every processor to the wrapped service
+func (s *ProcessingTrackingService) TrackVehicles(
+    ctx context.Context,
+    reqs []*TrackingDataRequest,
+) ([]*repositories.TrackingRecord, []error) {
+    results := make([]*repositories.TrackingRecord, len(reqs))
+    errs := make([]error, len(reqs))
+
+    accepted := make([]*TrackingDataRequest, 0, len(reqs))
+    // indexes maps the position in accepted back to the position in reqs
+    indexes := make([]int, 0, len(reqs))
+    for i, req := range reqs {
+        if err := s.registry.PreValidate(ctx, req); err != nil {
+            errs[i] = err
+            continue
+        }
+        accepted = append(accepted, req)
+        indexes = append(indexes, i)
+    }
+    if len(accepted) == 0 {
+        return results, errs
+    }
+
+    trackingData, trackErrs := s.TrackingService.TrackVehicles(ctx, accepted)
+    for j, i := range indexes {
+        if trackErrs[j] != nil {
+            errs[i] = trackErrs[j]
+            continue
+        }
+        results[i] = trackingData[j]
+        s.registry.PostPersist(ctx, trackingData[j])
+    }
+    return results, errs
+}
@@ -0,0 +1,107 @@
+package services
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "testing"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+)
+
+type recordingProcessor struct {
+    name   string
+    calls  *[]string
+    reject bool
+}
+
+func (p *recordingProcessor) PreValidate(context.Context, *TrackingDataRequest) error {
+    *p.calls = append(*p.calls, "pre:"+p.name)
+    if p.reject {
+        return errors.New("rejected by " + p.name)
+    }
+    return nil
+}
+
+func (p *recordingProcessor) PostPersist(context.Context, *repositories.TrackingRecord) error {
+    *p.calls = append(*p.calls, "post:"+p.name)
+    return errors.New("failures are only logged")
+}
+
+func TestProcessorRegistry(t *testing.T) {
+    var calls []string
+    registry := NewProcessorRegistry()
+    registry.Register(&recordingProcessor{name: "first", calls: &calls})
+    registry.Register(&recordingProcessor{name: "second", calls: &calls})
+
+    if err := registry.PreValidate(context.Background(), &TrackingDataRequest{}); err != nil {
+        t.Fatalf("expected the reading to be accepted, got %v", err)
+    }
+    registry.PostPersist(context.Background(), &repositories.TrackingRecord{})
+    expected := []string{"pre:first", "pre:second", "post:first", "post:second"}
+    if len(calls) != len(expected) {
+        t.Fatalf("expected calls %v, got %v", expected, calls)
+    }
+    for i := range expected {
+        if calls[i] != expected[i] {
+            t.Fatalf("expected calls %v, got %v", expected, calls)
+        }
+    }
+
+    calls = nil
+    registry = NewProcessorRegistry()
+    registry.Register(&recordingProcessor{name: "first", calls: &calls, reject: true})
+    registry.Register(&recordingProcessor{name: "second", calls: &calls})
+    err := registry.PreValidate(context.Background(), &TrackingDataRequest{})
+    if !errors.Is(err, ErrInvalidTrackingData) {
+        t.Errorf("expected invalid tracking data, got %v", err)
+    }
+    if len(calls) != 1 {
+        t.Errorf("expected the processors after a rejection to be skipped, got %v", calls)
+    }
+}
+
+func TestProcessingPublisher(t *testing.T) {
+    var calls []string
+    registry := NewProcessorRegistry()
+    // processors without PrePublish are skipped
+    registry.Register(&recordingProcessor{name: "first", calls: &calls})
+    registry.Register(
+        &ProcessorFuncs{
+            PrePublishFunc: func(_ context.Context, message []byte) ([]byte, error) {
+                return bytes.ReplaceAll(message, []byte(`"location":"home"`), []byte(`"location":""`)), nil
+            },
+        },
+    )
+    registry.Register(
+        &ProcessorFuncs{
+            PrePublishFunc: func(_ context.Context, message []byte) ([]byte, error) {
+                if bytes.Contains(message, []byte("drop")) {
+                    return nil, errors.New("not for downstream")
+                }
+                return message, nil
+            },
+        },
+    )
+    queue := &queuePublisher{}
+    publisher := NewProcessingPublisher(queue, registry)
+
+    if err := publisher.Publish(context.Background(), []byte(`{"location":"home","mileage":1}`)); err != nil {
+        t.Fatal(err)
+    }
+    if len(queue.bodies) != 1 || string(queue.bodies[0]) != `{"location":"","mileage":1}` {
+        t.Errorf("expected the redacted message, got %q", queue.bodies)
+    }
+    if err := publisher.Publish(context.Background(), []byte(`{"location":"drop"}`)); err == nil {
+        t.Error("expected the message to be dropped")
+    }
+    if len(queue.bodies) != 1 || len(calls) != 0 {
+        t.Errorf("expected only the first message to be published, got %q and calls %v", queue.bodies, calls)
+    }
+
+    // the hooks left nil do nothing
+    funcs := &ProcessorFuncs{}
+    if err := funcs.PreValidate(context.Background(), &TrackingDataRequest{}); err != nil {
+        t.Errorf("expected no error of a nil hook, got %v", err)
+    }
+}
@@ -0,0 +1,227 @@
+package services
+
+import (
+    "context"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "crypto/subtle"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "math"
+    "net/url"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+)
+
+const (
+    // publicStatsMaxRange bounds the time range of a public statistics request
+    publicStatsMaxRange = 31 * 24 * time.Hour
+)
+
+var (
+    ErrInvalidPartnerKey  = errors.New("invalid partner api key")
+    ErrInvalidStatsPeriod = errors.New("invalid period, from and to are required RFC3339 timestamps at most 31 days apart")
+)
+
+// PublicStatsPrivacy are the privacy parameters of the public statistics
+type PublicStatsPrivacy struct {
+    // Epsilon is the privacy budget of each zone hour, the counts get Laplace noise with a scale of 1/Epsilon
+    // since a vehicle changes the count of a zone hour by at most 1
+    Epsilon float64
+    // MinVehicles is the k of k-anonymity, zone hours with fewer distinct vehicles are suppressed
+    MinVehicles int
+    // ZoneDegrees is the size of the zones of the grid in degrees of latitude and longitude
+    ZoneDegrees float64
+    // NoiseKey derives the noise, a zone hour always gets the same noise so repeating a request can't average
+    // it out. A random key is used when it is empty, the noise is then the same until the service restarts.
+    NoiseKey []byte
+}
+
+type PublicStatsRequest struct {
+    From string `json:"from" doc:"RFC3339 start of the period, truncated to the hour"`
+    To   string `json:"to" doc:"RFC3339 end of the period, exclusive and at most 31 days after from"`
+}
+
+// ZoneStats is the number of distinct vehicles in a zone during an hour, with noise
+type ZoneStats struct {
+    MinLat   float64        `json:"min_lat"`
+    MinLng   float64        `json:"min_lng"`
+    MaxLat   float64        `json:"max_lat"`
+    MaxLng   float64        `json:"max_lng"`
+    Hour     timestamp.Time `json:"hour"`
+    Vehicles int64          `json:"vehicles"`
+}
+
+// PublicStats are the zone statistics disclosed to a partner with the privacy parameters they were computed with
+type PublicStats struct {
+    From        timestamp.Time `json:"from"`
+    To          timestamp.Time `json:"to"`
+    ZoneDegrees float64        `json:"zone_degrees"`
+    Epsilon     float64        `json:"epsilon"`
+    MinVehicles int            `json:"min_vehicles"`
+    // Suppressed is the number of zone hours withheld for having fewer than MinVehicles vehicles
+    Suppressed int          `json:"suppressed"`
+    Zones      []*ZoneStats `json:"zones"`
+}
+
+type PublicStatsService interface {
+    // Authenticate returns the partner an API key was issued to
+    Authenticate(apiKey string) (string, error)
+    // ZoneStats computes the statistics of the period of the query for the partner, the disclosure is audited
+    // before the statistics are returned
+    ZoneStats(ctx context.Context, partner string, query url.Values) (*PublicStats, error)
+    FindDisclosureAudits(ctx context.Context, query url.Values) ([]*repositories.DisclosureAudit, error)
+}
+
+// NoisyPublicStatsService discloses city level movement statistics to municipal partners, coarse zone counts
+// protected by k-anonymity and differential privacy noise
+type NoisyPublicStatsService struct {
+    trackingStatsRepo   repositories.TrackingStatsRepository
+    disclosureAuditRepo repositories.DisclosureAuditRepository
+    privacy             PublicStatsPrivacy
+    // partners maps the API keys to the partners they were issued to
+    partners map[string]string
+}
+
+func NewNoisyPublicStatsService(
+    trackingStatsRepo repositories.TrackingStatsRepository,
+    disclosureAuditRepo repositories.DisclosureAuditRepository,
+    privacy PublicStatsPrivacy,
+    partners map[string]string,
+) *NoisyPublicStatsService {
+    if len(privacy.NoiseKey) == 0 {
+        privacy.NoiseKey = make([]byte, 32)
+        if _, err := rand.Read(privacy.NoiseKey); err != nil {
+            // crypto/rand doesn't fail on supported platforms
+            panic(err)
+        }
+    }
+    return &NoisyPublicStatsService{
+        trackingStatsRepo:   trackingStatsRepo,
+        disclosureAuditRepo: disclosureAuditRepo,
+        privacy:             privacy,
+        partners:            partners,
+    }
+}
+
+func (s *NoisyPublicStatsService) Authenticate(apiKey string) (string, error) {
+    // every key is compared, so the time taken doesn't tell which keys exist
+    partner := ""
+    for key, name := range s.partners {
+        if subtle.ConstantTimeCompare([]byte(key), []byte(apiKey)) == 1 {
+            partner = name
+        }
+    }
+    if partner == "" {
+        return "", ErrInvalidPartnerKey
+    }
+    return partner, nil
+}
+
+func (s *NoisyPublicStatsService) ZoneStats(
+    ctx context.Context,
+    partner string,
+    query url.Values,
+) (*PublicStats, error) {
+    var req PublicStatsRequest
+    if err := decodeQuery(query, &req); err != nil {
+        return nil, err
+    }
+    from, err := time.Parse(time.RFC3339, req.From)
+    if err != nil {
+        return nil, ErrInvalidStatsPeriod
+    }
+    to, err := time.Parse(time.RFC3339, req.To)
+    if err != nil {
+        return nil, ErrInvalidStatsPeriod
+    }
+    from, to = from.UTC().Truncate(time.Hour), to.UTC().Truncate(time.Hour)
+    if !from.Before(to) || to.Sub(from) > publicStatsMaxRange {
+        return nil, ErrInvalidStatsPeriod
+    }
+
+    counts, err := s.trackingStatsRepo.FindZoneCounts(ctx, from, to, s.privacy.ZoneDegrees)
+    if err != nil {
+        return nil, err
+    }
+    stats := &PublicStats{
+        From:        timestamp.New(from),
+        To:          timestamp.New(to),
+        ZoneDegrees: s.privacy.ZoneDegrees,
+        Epsilon:     s.privacy.Epsilon,
+        MinVehicles: s.privacy.MinVehicles,
+        Zones:       make([]*ZoneStats, 0, len(counts)),
+    }
+    for _, count := range counts {
+        if count.Vehicles < int64(s.privacy.MinVehicles) {
+            stats.Suppressed++
+            continue
+        }
+        size := s.privacy.ZoneDegrees
+        stats.Zones = append(
+            stats.Zones, &ZoneStats{
+                MinLat:   float64(count.LatIndex) * size,
+                MinLng:   float64(count.LngIndex) * size,
+                MaxLat:   float64(count.LatIndex+1) * size,
+                MaxLng:   float64(count.LngIndex+1) * size,
+                Hour:     timestamp.New(count.Hour),
+                Vehicles: max(int64(math.Round(float64(count.Vehicles)+s.noise(count))), 0),
+            },
+        )
+    }
+
+    // nothing is disclosed without an audit of it
+    if err = s.disclosureAuditRepo.CreateDisclosureAudit(
+        ctx, &repositories.DisclosureAudit{
+            Partner:     partner,
+            From:        stats.From,
+            To:          stats.To,
+            ZoneDegrees: stats.ZoneDegrees,
+            Epsilon:     stats.Epsilon,
+            MinVehicles: stats.MinVehicles,
+            Disclosed:   len(stats.Zones),
+            Suppressed:  stats.Suppressed,
+        },
+    ); err != nil {
+        return nil, err
+    }
+    return stats, nil
+}
+
+func (s *NoisyPublicStatsService) FindDisclosureAudits(
+    ctx context.Context,
+    query url.Values,
+) ([]*repositories.DisclosureAudit, error) {
+    var filter repositories.DisclosureAuditFilter
+    if err := decodeQuery(query, &filter); err != nil {
+        return nil, err
+    }
+    return s.disclosureAuditRepo.FindDisclosureAudits(ctx, &filter)
+}
+
+// noise returns the Laplace noise of a zone hour, derived from the noise key and the zone hour
+func (s *NoisyPublicStatsService) noise(count *repositories.ZoneCount) float64 {
+    mac := hmac.New(sha256.New, s.privacy.NoiseKey)
+    _, _ = fmt.Fprintf(mac, "%g:%d:%d:%d", s.privacy.ZoneDegrees, count.LatIndex, count.LngIndex, count.Hour.Unix())
+    // a uniform value in [-0.5, 0.5) from 53 bits of the MAC
+    u := float64(binary.BigEndian.Uint64(mac.Sum(nil))>>11)/(1<<53) - 0.5
+    return laplace(u, 1/s.privacy.Epsilon)
+}
+
+// laplace maps a uniform value in [-0.5, 0.5) to the Laplace distribution centered on 0 with the scale, with
+// its inverse cumulative distribution
+func laplace(u, scale float64) float64 {
+    if u == 0 {
+        return 0
+    }
+    // -0.5 would be infinite noise, it is bounded like the values next to it
+    magnitude := -scale * math.Log(max(1-2*math.Abs(u), math.SmallestNonzeroFloat64))
+    if u < 0 {
+        return -magnitude
+    }
+    return magnitude
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "math"
+    "net/url"
+    "testing"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+)
+
+type fakeZoneCountsRepo struct {
+    repositories.TrackingStatsRepository
+    counts []*repositories.ZoneCount
+}
+
+func (repo *fakeZoneCountsRepo) FindZoneCounts(
+    context.Context,
+    time.Time,
+    time.Time,
+    float64,
+) ([]*repositories.ZoneCount, error) {
+    return repo.counts, nil
+}
+
+type fakeDisclosureAuditRepo struct {
+    repositories.DisclosureAuditRepository
+    audits []*repositories.DisclosureAudit
+}
+
+func (repo *fakeDisclosureAuditRepo) CreateDisclosureAudit(_ context.Context, audit *repositories.DisclosureAudit) error {
+    repo.audits = append(repo.audits, audit)
+    return nil
+}
+
+func TestNoisyPublicStatsService_ZoneStats(t *testing.T) {
+    hour := time.Date(2025, time.March, 4, 10, 0, 0, 0, time.UTC)
+    statsRepo := &fakeZoneCountsRepo{
+        counts: []*repositories.ZoneCount{
+            {LatIndex: 1680, LngIndex: 9615, Hour: hour, Vehicles: 40, Readings: 900},
+            {LatIndex: 1681, LngIndex: 9615, Hour: hour, Vehicles: 2, Readings: 30},
+        },
+    }
+    auditRepo := &fakeDisclosureAuditRepo{}
+    privacy := PublicStatsPrivacy{Epsilon: 1, MinVehicles: 5, ZoneDegrees: 0.01, NoiseKey: []byte("key")}
+    service := NewNoisyPublicStatsService(statsRepo, auditRepo, privacy, map[string]string{"secret": "yangon"})
+
+    query := url.Values{"from": {"2025-03-04T10:30:00Z"}, "to": {"2025-03-04T12:00:00Z"}}
+    stats, err := service.ZoneStats(context.Background(), "yangon", query)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !stats.From.Equal(hour) {
+        t.Fatalf("Should truncate the period to the hour, got %v", stats.From)
+    }
+    if len(stats.Zones) != 1 || stats.Suppressed != 1 {
+        t.Fatalf("Should suppress the zone with too few vehicles, got %d zones and %d suppressed", len(stats.Zones), stats.Suppressed)
+    }
+    zone := stats.Zones[0]
+    if math.Abs(zone.MinLat-16.8) > 1e-9 || math.Abs(zone.MaxLng-96.16) > 1e-9 {
+        t.Fatalf("Should return the bounds of the zone, got %+v", zone)
+    }
+    if zone.Vehicles < 0 || math.Abs(float64(zone.Vehicles-40)) > 30 {
+        t.Fatalf("Should add noise of scale 1, got %d vehicles", zone.Vehicles)
+    }
+
+    // the same zone hour always gets the same noise
+    again, err := service.ZoneStats(context.Background(), "yangon", query)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if again.Zones[0].Vehicles != zone.Vehicles {
+        t.Fatalf("Should add the same noise to the same zone hour, got %d and %d", zone.Vehicles, again.Zones[0].Vehicles)
+    }
+
+    if len(auditRepo.audits) != 2 {
+        t.Fatalf("Should audit every disclosure, got %d", len(auditRepo.audits))
+    }
+    if audit := auditRepo.audits[0]; audit.Partner != "yangon" || audit.Disclosed != 1 || audit.Suppressed != 1 {
+        t.Fatalf("Should audit what was disclosed to whom, got %+v", audit)
+    }
+
+    query.Set("to", "2025-05-04T12:00:00Z")
+    if _, err = service.ZoneStats(context.Background(), "yangon", query); !errors.Is(err, ErrInvalidStatsPeriod) {
+        t.Fatalf("Should reject periods longer than 31 days, got %v", err)
+    }
+}
+
+func TestNoisyPublicStatsService_Authenticate(t *testing.T) {
+    service := NewNoisyPublicStatsService(nil, nil, PublicStatsPrivacy{Epsilon: 1}, map[string]string{"secret": "yangon"})
+    if partner, err := service.Authenticate("secret"); err != nil || partner != "yangon" {
+        t.Fatalf("Should authenticate the partner of the key, got %q, %v", partner, err)
+    }
+    if _, err := service.Authenticate("guess"); !errors.Is(err, ErrInvalidPartnerKey) {
+        t.Fatalf("Should reject unknown keys, got %v", err)
+    }
+}
+
+func TestLaplace(t *testing.T) {
+    if laplace(0, 1) != 0 {
+        t.Fatal("Should be centered on 0")
+    }
+    if noise := laplace(0.25, 2); math.Abs(noise-2*math.Ln2) > 1e-9 {
+        t.Fatalf("Should follow the inverse distribution, got %v", noise)
+    }
+    if laplace(-0.25, 2) != -laplace(0.25, 2) {
+        t.Fatal("Should be symmetric")
+    }
+    if noise := laplace(-0.5, 1); math.IsInf(noise, 0) {
+        t.Fatal("Should bound the noise")
+    }
+}
@@ -0,0 +1,138 @@
+package services
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "fmt"
+    "net/http"
+
+    amqp "github.com/rabbitmq/amqp091-go"
+    "github.com/yemyoaung/managing-vehicle-tracking-common"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/envelope"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+)
+
+var (
+    ErrPublishRejected = errors.New("publish was rejected")
+)
+
+type Publisher interface {
+    Publish(ctx context.Context, body []byte) error
+}
+
+// RoutingKey returns the routing key of a message published to an exchange from its body
+type RoutingKey func(body []byte) string
+
+// RabbitPublisher publishes messages to a RabbitMQ queue through the default exchange, or to an exchange with the
+// routing key of every message
+type RabbitPublisher struct {
+    channel    *amqp.Channel
+    queue      string
+    exchange   string
+    routingKey RoutingKey
+    // cipher encrypts the bodies when it is set, with the key of the context or keyID
+    cipher *envelope.Cipher
+    keyID  string
+}
+
+func NewRabbitPublisher(channel *amqp.Channel, queue string) *RabbitPublisher {
+    return &RabbitPublisher{channel: channel, queue: queue}
+}
+
+// NewEncryptingRabbitPublisher creates a publisher encrypting the bodies with the key of the consumed message
+// they are published for, or keyID when there is none
+func NewEncryptingRabbitPublisher(
+    channel *amqp.Channel,
+    queue string,
+    cipher *envelope.Cipher,
+    keyID string,
+) *RabbitPublisher {
+    return &RabbitPublisher{channel: channel, queue: queue, cipher: cipher, keyID: keyID}
+}
+
+// WithExchange publishes the messages to the exchange instead of the queue, with the routing key of their bodies
+func (p *RabbitPublisher) WithExchange(exchange string, routingKey RoutingKey) *RabbitPublisher {
+    p.exchange, p.routingKey = exchange, routingKey
+    return p
+}
+
+// Publish publishes the body, the tenant of the context is forwarded in the message headers
+func (p *RabbitPublisher) Publish(ctx context.Context, body []byte) error {
+    // the routing key is read from the body before it is encrypted
+    key := p.queue
+    if p.routingKey != nil {
+        key = p.routingKey(body)
+    }
+    headers := amqp.Table{}
+    id, hasTenant := tenant.FromContext(ctx)
+    if hasTenant {
+        headers[tenant.MessageHeader] = id
+    }
+    contentType := common.ApplicationJSON
+    if p.cipher != nil {
+        keyID, ok := envelope.KeyIDFromContext(ctx)
+        if !ok {
+            keyID = p.keyID
+        }
+        sealed, err := p.cipher.Seal(ctx, id, keyID, body)
+        if err != nil {
+            return err
+        }
+        for key, value := range sealed.Headers() {
+            headers[key] = value
+        }
+        contentType, body = "application/octet-stream", sealed.Body
+    }
+    if len(headers) == 0 {
+        headers = nil
+    }
+    return p.channel.PublishWithContext(
+        ctx,
+        p.exchange,
+        key,
+        false,
+        false,
+        amqp.Publishing{
+            ContentType: contentType,
+            Headers:     headers,
+            Body:        body,
+        },
+    )
+}
+
+// HTTPPublisher posts messages to an HTTP endpoint, like the tracking data endpoint of a deployment
+type HTTPPublisher struct {
+    client *http.Client
+    url    string
+    // token is sent as a bearer token when it is set
+    token string
+}
+
+func NewHTTPPublisher(client *http.Client, url string, token string) *HTTPPublisher {
+    return &HTTPPublisher{client: client, url: url, token: token}
+}
+
+// Publish posts the body as JSON with the tenant of ctx, responses other than 2xx fail with ErrPublishRejected
+func (p *HTTPPublisher) Publish(ctx context.Context, body []byte) error {
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", common.ApplicationJSON)
+    if id, ok := tenant.FromContext(ctx); ok {
+        req.Header.Set(tenant.Header, id)
+    }
+    if p.token != "" {
+        req.Header.Set("Authorization", "Bearer "+p.token)
+    }
+    res, err := p.client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer res.Body.Close()
+    if res.StatusCode < 200 || res.StatusCode > 299 {
+        return fmt.Errorf("%w: %s", ErrPublishRejected, res.Status)
+    }
+    return nil
+}
@@ -0,0 +1,130 @@
+package services
+
+import (
+    "context"
+    "sync"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+)
+
+const (
+    ReadinessStarting = "starting"
+    ReadinessReady    = "ready"
+    ReadinessStopping = "stopping"
+
+    // primeBatchSize is the number of vehicles primed at once, the size of the largest batch query
+    primeBatchSize = 100
+)
+
+// Readiness tells whether the service should receive traffic, it isn't ready until the cache is primed and
+// while it shuts down
+type Readiness struct {
+    Status         string `json:"status"`
+    PrimedVehicles int    `json:"primed_vehicles"`
+    Error          string `json:"error,omitempty"`
+}
+
+type ReadinessService interface {
+    Readiness() *Readiness
+    // Prime loads the latest tracking data of the recently active vehicles into the cache, the service is ready
+    // once it returns. A failed priming only makes the first queries slower, so the service is ready either way.
+    Prime(ctx context.Context) error
+    // Stop marks the service as not ready while it shuts down
+    Stop()
+}
+
+// CachePrimingReadinessService primes the latest tracking data of the vehicles that reported within window, the
+// ones the dashboards reconnecting after a deploy ask for. At most maxVehicles are primed, a zero window or
+// maxVehicles disables priming.
+type CachePrimingReadinessService struct {
+    trackingRepo repositories.TrackingRepository
+    window       time.Duration
+    maxVehicles  int
+
+    mu        sync.RWMutex
+    readiness Readiness
+}
+
+func NewCachePrimingReadinessService(
+    trackingRepo repositories.TrackingRepository,
+    window time.Duration,
+    maxVehicles int,
+) *CachePrimingReadinessService {
+    return &CachePrimingReadinessService{
+        trackingRepo: trackingRepo,
+        window:       window,
+        maxVehicles:  maxVehicles,
+        readiness:    Readiness{Status: ReadinessStarting},
+    }
+}
+
+func (s *CachePrimingReadinessService) Readiness() *Readiness {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+    readiness := s.readiness
+    return &readiness
+}
+
+func (s *CachePrimingReadinessService) Prime(ctx context.Context) error {
+    primed, err := s.prime(ctx)
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.readiness.Status == ReadinessStarting {
+        s.readiness.Status = ReadinessReady
+    }
+    s.readiness.PrimedVehicles = primed
+    if err != nil {
+        s.readiness.Error = err.Error()
+    }
+    return err
+}
+
+func (s *CachePrimingReadinessService) Stop() {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.readiness.Status = ReadinessStopping
+}
+
+// prime asks for the latest reading of the active vehicles, what the batch query asks for by default, so the
+// cached repository stores it. The vehicles are primed per tenant since the cached results are.
+func (s *CachePrimingReadinessService) prime(ctx context.Context) (int, error) {
+    if s.window <= 0 || s.maxVehicles <= 0 {
+        return 0, nil
+    }
+    vehicles, err := s.trackingRepo.FindActiveVehicles(ctx, time.Now().Add(-s.window), s.maxVehicles)
+    if err != nil {
+        return 0, err
+    }
+
+    var tenants []string
+    byTenant := map[string][]repositories.VehicleLimit{}
+    for _, vehicle := range vehicles {
+        if _, ok := byTenant[vehicle.TenantID]; !ok {
+            tenants = append(tenants, vehicle.TenantID)
+        }
+        byTenant[vehicle.TenantID] = append(
+            byTenant[vehicle.TenantID],
+            repositories.VehicleLimit{VehicleID: vehicle.VehicleID, Limit: 1},
+        )
+    }
+
+    primed := 0
+    for _, id := range tenants {
+        tenantCtx := ctx
+        if id != "" {
+            tenantCtx = tenant.WithID(ctx, id)
+        }
+        limits := byTenant[id]
+        for start := 0; start < len(limits); start += primeBatchSize {
+            batch := limits[start:min(start+primeBatchSize, len(limits))]
+            if _, err := s.trackingRepo.FindLatestTrackingData(tenantCtx, batch); err != nil {
+                return primed, err
+            }
+            primed += len(batch)
+        }
+    }
+    return primed, nil
+}
@@ -0,0 +1,85 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type fakePrimingTrackingRepo struct {
+    repositories.TrackingRepository
+    vehicles []*repositories.ActiveVehicle
+    err      error
+    // batches has the size of every batch per tenant
+    batches map[string][]int
+}
+
+func (repo *fakePrimingTrackingRepo) FindActiveVehicles(
+    context.Context,
+    time.Time,
+    int,
+) ([]*repositories.ActiveVehicle, error) {
+    return repo.vehicles, nil
+}
+
+func (repo *fakePrimingTrackingRepo) FindLatestTrackingData(
+    ctx context.Context,
+    limits []repositories.VehicleLimit,
+) (map[primitive.ObjectID][]*repositories.TrackingRecord, error) {
+    if repo.err != nil {
+        return nil, repo.err
+    }
+    id, _ := tenant.FromContext(ctx)
+    repo.batches[id] = append(repo.batches[id], len(limits))
+    return nil, nil
+}
+
+func TestCachePrimingReadinessService_Prime(t *testing.T) {
+    repo := &fakePrimingTrackingRepo{batches: map[string][]int{}}
+    for i := 0; i < 150; i++ {
+        repo.vehicles = append(repo.vehicles, &repositories.ActiveVehicle{VehicleID: primitive.NewObjectID()})
+    }
+    repo.vehicles = append(
+        repo.vehicles,
+        &repositories.ActiveVehicle{TenantID: "acme", VehicleID: primitive.NewObjectID()},
+    )
+    service := NewCachePrimingReadinessService(repo, time.Hour, 1000)
+    if status := service.Readiness().Status; status != ReadinessStarting {
+        t.Fatalf("Should not be ready before priming, got %s", status)
+    }
+
+    if err := service.Prime(context.Background()); err != nil {
+        t.Fatal(err)
+    }
+    readiness := service.Readiness()
+    if readiness.Status != ReadinessReady || readiness.PrimedVehicles != 151 {
+        t.Fatalf("Should be ready with every vehicle primed, got %+v", readiness)
+    }
+    if len(repo.batches[""]) != 2 || repo.batches[""][0] != 100 || len(repo.batches["acme"]) != 1 {
+        t.Fatalf("Should prime in batches per tenant, got %v", repo.batches)
+    }
+
+    service.Stop()
+    if status := service.Readiness().Status; status != ReadinessStopping {
+        t.Fatalf("Should not be ready while stopping, got %s", status)
+    }
+}
+
+func TestCachePrimingReadinessService_PrimeFails(t *testing.T) {
+    repo := &fakePrimingTrackingRepo{
+        vehicles: []*repositories.ActiveVehicle{{VehicleID: primitive.NewObjectID()}},
+        err:      errors.New("timeout"),
+    }
+    service := NewCachePrimingReadinessService(repo, time.Hour, 1000)
+    if err := service.Prime(context.Background()); err == nil {
+        t.Fatal("Should return the priming error")
+    }
+    if readiness := service.Readiness(); readiness.Status != ReadinessReady || readiness.Error != "timeout" {
+        t.Fatalf("Should be ready without a primed cache, got %+v", readiness)
+    }
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+    "context"
+    "log"
+    "time"
+)
+
+// ReportScheduler generates the configured reports once their period is complete,
+// daily reports after midnight UTC and weekly reports after midnight UTC on Monday
+type ReportScheduler struct {
+    reportService ReportService
+    periods       []ReportPeriod
+    // delay gives late readings some time to arrive before a period is reported
+    delay time.Duration
+}
+
+func NewReportScheduler(reportService ReportService, periods []ReportPeriod, delay time.Duration) *ReportScheduler {
+    return &ReportScheduler{reportService: reportService, periods: periods, delay: delay}
+}
+
+// Run blocks until ctx is done, generating reports as their periods complete
+func (s *ReportScheduler) Run(ctx context.Context) {
+    for {
+        now := time.Now().UTC()
+        _, next := ReportPeriodDaily.Bounds(now.Add(-s.delay))
+        next = next.AddDate(0, 0, 1).Add(s.delay)
+
+        timer := time.NewTimer(next.Sub(now))
+        select {
+        case <-ctx.Done():
+            timer.Stop()
+            return
+        case at := <-timer.C:
+            s.generate(ctx, at.UTC().Add(-s.delay))
+        }
+    }
+}
+
+func (s *ReportScheduler) generate(ctx context.Context, at time.Time) {
+    for _, period := range s.periods {
+        // weekly reports are only due on the first day of the week
+        if period == ReportPeriodWeekly && at.Weekday() != time.Monday {
+            continue
+        }
+        ready, err := s.reportService.GenerateReport(ctx, period, at)
+        if err != nil {
+            log.Printf("Failed to generate %s report: %v", period, err)
+            continue
+        }
+        log.Printf("Generated %s report for %d vehicles: %s", period, ready.Vehicles, ready.Key)
+    }
+}
@@ -0,0 +1,266 @@
+package services
+
+import (
+    "bytes"
+    "context"
+    "encoding/csv"
+    "errors"
+    "fmt"
+    "strconv"
+    "time"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/geo"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/storage"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+)
+
+const (
+    ReportPeriodDaily  ReportPeriod = "daily"
+    ReportPeriodWeekly ReportPeriod = "weekly"
+
+    ReportReadyEvent = "report.ready"
+
+    // maxActiveGap is the longest gap between two readings that still counts as driving time,
+    // longer gaps are treated as the vehicle being off
+    maxActiveGap = 15 * time.Minute
+)
+
+var (
+    ErrUnknownReportPeriod = errors.New("unknown report period")
+)
+
+var reportHeader = []string{
+    "vehicle_id",
+    "period_start",
+    "period_end",
+    "readings",
+    "mileage",
+    "distance_meters",
+    "distance_method",
+    "active_seconds",
+    "utilization",
+}
+
+// ReportPeriod is the time span covered by a report
+type ReportPeriod string
+
+func (p ReportPeriod) Valid() error {
+    switch p {
+    case ReportPeriodDaily, ReportPeriodWeekly:
+        return nil
+    default:
+        return fmt.Errorf("%w: %s", ErrUnknownReportPeriod, p)
+    }
+}
+
+// Bounds returns the last complete period before at, in UTC. Weeks start on Monday.
+func (p ReportPeriod) Bounds(at time.Time) (time.Time, time.Time) {
+    at = at.UTC()
+    end := time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, time.UTC)
+    if p == ReportPeriodWeekly {
+        end = end.AddDate(0, 0, -(int(end.Weekday())+6)%7)
+        return end.AddDate(0, 0, -7), end
+    }
+    return end.AddDate(0, 0, -1), end
+}
+
+// VehicleReport is the mileage and utilization of a single vehicle over a period
+type VehicleReport struct {
+    VehicleID     string       `json:"vehicle_id"`
+    Readings      int          `json:"readings"`
+    Mileage       float64      `json:"mileage"`
+    Distance      geo.Distance `json:"distance"`
+    ActiveSeconds float64      `json:"active_seconds"`
+    Utilization   float64      `json:"utilization"`
+}
+
+// ReportReady is the event published once a report was uploaded
+type ReportReady struct {
+    Event       string         `json:"event"`
+    Period      ReportPeriod   `json:"period"`
+    From        timestamp.Time `json:"from"`
+    To          timestamp.Time `json:"to"`
+    Bucket      string         `json:"bucket"`
+    Key         string         `json:"key"`
+    Vehicles    int            `json:"vehicles"`
+    GeneratedAt timestamp.Time `json:"generated_at"`
+}
+
+type ReportService interface {
+    GenerateReport(ctx context.Context, period ReportPeriod, at time.Time) (*ReportReady, error)
+}
+
+type MongoReportService struct {
+    trackingRepo repositories.TrackingRepository
+    distance     *geo.DistanceCalculator
+    storage      storage.BlobStorage
+    bucket       string
+    publisher    Publisher
+}
+
+func NewMongoReportService(
+    trackingRepo repositories.TrackingRepository,
+    distance *geo.DistanceCalculator,
+    storage storage.BlobStorage,
+    bucket string,
+    publisher Publisher,
+) *MongoReportService {
+    return &MongoReportService{
+        trackingRepo: trackingRepo,
+        distance:     distance,
+        storage:      storage,
+        bucket:       bucket,
+        publisher:    publisher,
+    }
+}
+
+// GenerateReport builds the report of the last complete period before at, uploads it as CSV
+// and publishes a report ready event
+func (s *MongoReportService) GenerateReport(
+    ctx context.Context,
+    period ReportPeriod,
+    at time.Time,
+) (*ReportReady, error) {
+    if err := period.Valid(); err != nil {
+        return nil, err
+    }
+    from, to := period.Bounds(at)
+
+    reports, err := s.vehicleReports(ctx, from, to)
+    if err != nil {
+        return nil, err
+    }
+
+    body, err := encodeReport(reports, from, to)
+    if err != nil {
+        return nil, err
+    }
+
+    key := fmt.Sprintf("reports/%s/%s.csv", period, from.Format(time.DateOnly))
+    if err := s.storage.Put(ctx, key, "text/csv; charset=utf-8", body); err != nil {
+        return nil, err
+    }
+
+    ready := &ReportReady{
+        Event:       ReportReadyEvent,
+        Period:      period,
+        From:        timestamp.New(from),
+        To:          timestamp.New(to),
+        Bucket:      s.bucket,
+        Key:         key,
+        Vehicles:    len(reports),
+        GeneratedAt: timestamp.Now(),
+    }
+    event, err := json.Marshal(ready)
+    if err != nil {
+        return nil, err
+    }
+    if err := s.publisher.Publish(ctx, event); err != nil {
+        return nil, err
+    }
+    return ready, nil
+}
+
+// vehicleReports streams the readings of the period ordered by vehicle and time,
+// so only the readings of one vehicle are held in memory at a time
+func (s *MongoReportService) vehicleReports(ctx context.Context, from, to time.Time) ([]*VehicleReport, error) {
+    filter := &repositories.TrackingFilter{
+        SortField: "vehicle_id,created_at",
+        SortOrder: "asc",
+        From:      from.Format(time.RFC3339),
+        To:        to.Format(time.RFC3339),
+    }
+    if err := filter.Build(); err != nil {
+        return nil, err
+    }
+
+    var reports []*VehicleReport
+    var records []*repositories.TrackingRecord
+    flush := func() {
+        if len(records) > 0 {
+            reports = append(reports, s.vehicleReport(ctx, records, to.Sub(from)))
+        }
+        records = records[:0]
+    }
+
+    err := s.trackingRepo.StreamTrackingData(
+        ctx, filter, func(record *repositories.TrackingRecord) error {
+            if len(records) > 0 && records[0].VehicleID != record.VehicleID {
+                flush()
+            }
+            records = append(records, record)
+            return nil
+        },
+    )
+    if err != nil {
+        return nil, err
+    }
+    flush()
+    return reports, nil
+}
+
+// vehicleReport summarizes the time ordered readings of a vehicle. The vehicle counts as active
+// between two readings when its mileage increased and the readings are at most maxActiveGap apart.
+func (s *MongoReportService) vehicleReport(
+    ctx context.Context,
+    records []*repositories.TrackingRecord,
+    span time.Duration,
+) *VehicleReport {
+    report := &VehicleReport{
+        VehicleID: records[0].VehicleID.Hex(),
+        Readings:  len(records),
+    }
+
+    var points []geo.Point
+    for i, record := range records {
+        if point, ok := record.Point(); ok {
+            points = append(points, point)
+        }
+        if i == 0 {
+            continue
+        }
+        previous := records[i-1]
+        if record.Mileage > previous.Mileage {
+            report.Mileage += record.Mileage - previous.Mileage
+            if gap := record.CreatedAt.Sub(previous.CreatedAt); gap <= maxActiveGap {
+                report.ActiveSeconds += gap.Seconds()
+            }
+        }
+    }
+
+    report.Distance = s.distance.Distance(ctx, points)
+    if span > 0 {
+        report.Utilization = report.ActiveSeconds / span.Seconds()
+    }
+    return report
+}
+
+func encodeReport(reports []*VehicleReport, from, to time.Time) ([]byte, error) {
+    var buf bytes.Buffer
+    writer := csv.NewWriter(&buf)
+    if err := writer.Write(reportHeader); err != nil {
+        return nil, err
+    }
+    for _, report := range reports {
+        err := writer.Write(
+            []string{
+                report.VehicleID,
+                timestamp.Format(from),
+                timestamp.Format(to),
+                strconv.Itoa(report.Readings),
+                strconv.FormatFloat(report.Mileage, 'f', -1, 64),
+                strconv.FormatFloat(report.Distance.Meters, 'f', 1, 64),
+                string(report.Distance.Method),
+                strconv.FormatFloat(report.ActiveSeconds, 'f', 0, 64),
+                strconv.FormatFloat(report.Utilization, 'f', 4, 64),
+            },
+        )
+        if err != nil {
+            return nil, err
+        }
+    }
+    writer.Flush()
+    return buf.Bytes(), writer.Error()
+}
@@ -0,0 +1,428 @@
+package services
+
+import (
+    "context"
+    "log"
+    "math"
+    "net/url"
+    "time"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/geo"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+    SafetyScoreChangedEvent = "safety_score.changed"
+
+    // the penalties of a safety score, per 100km driven: points per speeding, per harsh acceleration or braking and
+    // per hour idling
+    speedingPenalty    = 5.0
+    harshPenalty       = 3.0
+    idlePenaltyPerHour = 6.0
+    // scoredDistanceKm is the distance the penalties are counted per, shorter distances count as this distance so a
+    // single event on a short trip doesn't ruin the score
+    scoredDistanceKm = 100.0
+
+    // harshAccelerationMps2 is the change of speed above which an acceleration or braking is harsh, in m/s²
+    harshAccelerationMps2 = 3.0
+    // idleRadiusMeters is how far an active vehicle moves at most between two readings while it is idling, GPS
+    // jitter moves parked vehicles a few meters
+    idleRadiusMeters = 25.0
+    // maxScoredGap is the longest time between two readings that is scored, a vehicle not reporting for longer was
+    // probably switched off
+    maxScoredGap = 10 * time.Minute
+)
+
+// SafetyScore is the safety score of a vehicle or a driver over a window, from 100 without any penalty down to 0
+type SafetyScore struct {
+    VehicleID string          `json:"vehicle_id,omitempty"`
+    DriverID  string          `json:"driver_id,omitempty"`
+    Day       *timestamp.Time `json:"day,omitempty"`
+    Score     float64         `json:"score"`
+    repositories.SafetyCounts
+}
+
+// SafetyScoreChange is the event published to the alerts queue when the rolling score of a vehicle or a driver
+// changed enough during a day
+type SafetyScoreChange struct {
+    Event         string         `json:"event"`
+    VehicleID     string         `json:"vehicle_id,omitempty"`
+    DriverID      string         `json:"driver_id,omitempty"`
+    Score         float64        `json:"score"`
+    PreviousScore float64        `json:"previous_score"`
+    Days          int            `json:"days"`
+    At            timestamp.Time `json:"at"`
+}
+
+// ComputeSafetyScore scores the counts from 100 down, removing the penalties per 100km driven
+func ComputeSafetyScore(counts repositories.SafetyCounts) float64 {
+    penalty := speedingPenalty*float64(counts.SpeedingEvents) +
+        harshPenalty*float64(counts.HarshEvents) +
+        idlePenaltyPerHour*counts.IdleSeconds/time.Hour.Seconds()
+    km := max(counts.DistanceMeters/1000, scoredDistanceKm)
+    score := max(100-penalty*scoredDistanceKm/km, 0)
+    return math.Round(score*10) / 10
+}
+
+type SafetyScoreService interface {
+    // FindScores returns a page of the rolling scores of the vehicles or the drivers
+    FindScores(ctx context.Context, query url.Values) ([]*SafetyScore, error)
+    // FindDailyScores returns a page of the scores of every day of the vehicles or the drivers, the newest day first
+    FindDailyScores(ctx context.Context, query url.Values) ([]*SafetyScore, error)
+    // PublishChanges publishes the vehicles and drivers whose rolling score changed by at least the threshold
+    // during the UTC day of day, of every tenant. It returns the number of changes published.
+    PublishChanges(ctx context.Context, day time.Time) (int, error)
+}
+
+type MongoSafetyScoreService struct {
+    scoreRepo       repositories.SafetyScoreRepository
+    accessService   AccessService
+    alertsPublisher Publisher
+    // days is the window of the rolling scores without days
+    days int
+    // threshold is the smallest change of score published
+    threshold float64
+}
+
+func NewMongoSafetyScoreService(
+    scoreRepo repositories.SafetyScoreRepository,
+    accessService AccessService,
+    alertsPublisher Publisher,
+    days int,
+    threshold float64,
+) *MongoSafetyScoreService {
+    return &MongoSafetyScoreService{
+        scoreRepo:       scoreRepo,
+        accessService:   accessService,
+        alertsPublisher: alertsPublisher,
+        days:            days,
+        threshold:       threshold,
+    }
+}
+
+func (s *MongoSafetyScoreService) FindScores(ctx context.Context, query url.Values) ([]*SafetyScore, error) {
+    filter, err := s.filter(ctx, query)
+    if err != nil {
+        return nil, err
+    }
+    return s.scores(ctx, filter)
+}
+
+func (s *MongoSafetyScoreService) FindDailyScores(ctx context.Context, query url.Values) ([]*SafetyScore, error) {
+    filter, err := s.filter(ctx, query)
+    if err != nil {
+        return nil, err
+    }
+    filter.Daily()
+    return s.scores(ctx, filter)
+}
+
+// filter decodes the query limited to the vehicles the user has access to
+func (s *MongoSafetyScoreService) filter(
+    ctx context.Context,
+    query url.Values,
+) (*repositories.SafetyScoreFilter, error) {
+    filter := repositories.SafetyScoreFilter{Days: s.days}
+    if err := decodeQuery(query, &filter, "days"); err != nil {
+        return nil, err
+    }
+    if err := filter.Build(time.Now()); err != nil {
+        return nil, err
+    }
+    scope, err := s.accessService.VehicleScope(ctx)
+    if err != nil {
+        return nil, err
+    }
+    scope.Restrict(&filter)
+    return &filter, nil
+}
+
+func (s *MongoSafetyScoreService) scores(
+    ctx context.Context,
+    filter *repositories.SafetyScoreFilter,
+) ([]*SafetyScore, error) {
+    totals, err := s.scoreRepo.SumSafetyCounts(ctx, filter)
+    if err != nil {
+        return nil, err
+    }
+    scores := make([]*SafetyScore, 0, len(totals))
+    for _, total := range totals {
+        score := newSafetyScore(filter.By, total)
+        if !total.Day.IsZero() {
+            day := timestamp.New(total.Day)
+            score.Day = &day
+        }
+        scores = append(scores, score)
+    }
+    return scores, nil
+}
+
+func newSafetyScore(by string, total *repositories.SafetyTotals) *SafetyScore {
+    score := &SafetyScore{Score: ComputeSafetyScore(total.SafetyCounts), SafetyCounts: total.SafetyCounts}
+    if by == repositories.SafetyScoreByDriver {
+        score.DriverID = total.DriverID
+    } else {
+        score.VehicleID = total.VehicleID.Hex()
+    }
+    return score
+}
+
+// PublishChanges compares the window ending with the day with the window ending the day before, the vehicles and
+// drivers without driving in a window have a score of 100 in it
+func (s *MongoSafetyScoreService) PublishChanges(ctx context.Context, day time.Time) (int, error) {
+    end := day.UTC().Truncate(24*time.Hour).AddDate(0, 0, 1)
+    published := 0
+    for _, by := range []string{repositories.SafetyScoreByVehicle, repositories.SafetyScoreByDriver} {
+        current, err := s.windowScores(ctx, by, end)
+        if err != nil {
+            return published, err
+        }
+        previous, err := s.windowScores(ctx, by, end.AddDate(0, 0, -1))
+        if err != nil {
+            return published, err
+        }
+        for key, score := range current {
+            before := 100.0
+            if previousScore, ok := previous[key]; ok {
+                before = previousScore.score.Score
+            }
+            delete(previous, key)
+            changed, err := s.publishChange(ctx, score, before, end)
+            if err != nil {
+                return published, err
+            }
+            if changed {
+                published++
+            }
+        }
+        // the ones that didn't drive during the window anymore are back to 100
+        for _, before := range previous {
+            after := &tenantSafetyScore{
+                tenantID: before.tenantID,
+                score: &SafetyScore{
+                    VehicleID: before.score.VehicleID,
+                    DriverID:  before.score.DriverID,
+                    Score:     100,
+                },
+            }
+            changed, err := s.publishChange(ctx, after, before.score.Score, end)
+            if err != nil {
+                return published, err
+            }
+            if changed {
+                published++
+            }
+        }
+    }
+    return published, nil
+}
+
+type tenantSafetyScore struct {
+    tenantID string
+    score    *SafetyScore
+}
+
+// windowScores returns the rolling scores of the window ending at end, of every tenant, by tenant and subject
+func (s *MongoSafetyScoreService) windowScores(
+    ctx context.Context,
+    by string,
+    end time.Time,
+) (map[string]*tenantSafetyScore, error) {
+    scores := map[string]*tenantSafetyScore{}
+    for page := 1; ; page++ {
+        filter := &repositories.SafetyScoreFilter{
+            Page:     page,
+            PageSize: repositories.MaxPageSize(),
+            By:       by,
+            Days:     s.days,
+        }
+        filter.Window(end.AddDate(0, 0, -s.days), end)
+        if err := filter.Build(end); err != nil {
+            return nil, err
+        }
+        totals, err := s.scoreRepo.SumSafetyCounts(ctx, filter)
+        if err != nil {
+            return nil, err
+        }
+        for _, total := range totals {
+            score := newSafetyScore(by, total)
+            scores[total.TenantID+"/"+score.VehicleID+score.DriverID] = &tenantSafetyScore{
+                tenantID: total.TenantID,
+                score:    score,
+            }
+        }
+        if len(totals) < filter.PageSize {
+            return scores, nil
+        }
+    }
+}
+
+// publishChange publishes the change of score when it reaches the threshold, with the tenant of the score. It
+// reports whether the change was published.
+func (s *MongoSafetyScoreService) publishChange(
+    ctx context.Context,
+    current *tenantSafetyScore,
+    previous float64,
+    end time.Time,
+) (bool, error) {
+    if math.Abs(current.score.Score-previous) < s.threshold {
+        return false, nil
+    }
+    if current.tenantID != "" {
+        ctx = tenant.WithID(ctx, current.tenantID)
+    }
+    body, err := json.Marshal(
+        &SafetyScoreChange{
+            Event:         SafetyScoreChangedEvent,
+            VehicleID:     current.score.VehicleID,
+            DriverID:      current.score.DriverID,
+            Score:         current.score.Score,
+            PreviousScore: previous,
+            Days:          s.days,
+            At:            timestamp.New(end),
+        },
+    )
+    if err != nil {
+        return false, err
+    }
+    return true, s.alertsPublisher.Publish(ctx, body)
+}
+
+// SafetyScoringProcessor is an ingestion processor counting the driving behaviors of the safety scores, comparing
+// every stored reading with the previous one of its vehicle. The counts go to the day of the reading and to the
+// driver of the reading, late readings and readings without coordinates are skipped.
+type SafetyScoringProcessor struct {
+    scoreRepo repositories.SafetyScoreRepository
+    locks     *vehicleLocks
+    // speedLimitKmh is the speed above which a vehicle is speeding, 0 doesn't count speeding
+    speedLimitKmh float64
+}
+
+func NewSafetyScoringProcessor(
+    scoreRepo repositories.SafetyScoreRepository,
+    speedLimitKmh float64,
+) *SafetyScoringProcessor {
+    return &SafetyScoringProcessor{scoreRepo: scoreRepo, locks: newVehicleLocks(), speedLimitKmh: speedLimitKmh}
+}
+
+func (p *SafetyScoringProcessor) PreValidate(context.Context, *TrackingDataRequest) error {
+    return nil
+}
+
+func (p *SafetyScoringProcessor) PostPersist(ctx context.Context, record *repositories.TrackingRecord) error {
+    point, ok := record.Point()
+    if !ok {
+        return nil
+    }
+    // the previous state of the vehicle is compared with one reading at a time
+    unlock := p.locks.lock([]primitive.ObjectID{record.VehicleID})
+    defer unlock()
+
+    previous, err := p.scoreRepo.FindSafetyState(ctx, record.VehicleID)
+    if err != nil {
+        return err
+    }
+    at := readingTime(record)
+    elapsed := time.Duration(0)
+    if previous != nil {
+        elapsed = at.Sub(previous.At.Time)
+        // the speed between readings closer than minSpeedInterval is meaningless, the next reading is compared with
+        // the previous one instead
+        if elapsed < minSpeedInterval {
+            return nil
+        }
+    }
+    state := &repositories.SafetyState{
+        VehicleID: record.VehicleID,
+        Lat:       point.Lat,
+        Lng:       point.Lng,
+        At:        timestamp.New(at),
+    }
+    var counts repositories.SafetyCounts
+    if previous != nil && elapsed <= maxScoredGap {
+        counts = p.count(previous, state, record.Status, elapsed)
+    }
+    if err = p.scoreRepo.SaveSafetyState(ctx, state); err != nil {
+        return err
+    }
+    if counts.IsZero() {
+        return nil
+    }
+    return p.scoreRepo.AddSafetyCounts(
+        ctx,
+        &repositories.SafetyScoreDay{
+            VehicleID:    record.VehicleID,
+            DriverID:     record.DriverID,
+            Day:          timestamp.New(at.UTC().Truncate(24 * time.Hour)),
+            SafetyCounts: counts,
+        },
+    )
+}
+
+// count compares the state of a reading with the previous one, it sets the speed of the state
+func (p *SafetyScoringProcessor) count(
+    previous, state *repositories.SafetyState,
+    status models.VehicleStatus,
+    elapsed time.Duration,
+) repositories.SafetyCounts {
+    distance := geo.Haversine(geo.NewPoint(previous.Lat, previous.Lng), geo.NewPoint(state.Lat, state.Lng))
+    speedKmh := distance / elapsed.Hours() / 1000
+    state.SpeedKmh = &speedKmh
+    state.Speeding = p.speedLimitKmh > 0 && speedKmh > p.speedLimitKmh
+
+    counts := repositories.SafetyCounts{DistanceMeters: distance}
+    // a vehicle is counted once per time it starts speeding
+    if state.Speeding && !previous.Speeding {
+        counts.SpeedingEvents = 1
+    }
+    if previous.SpeedKmh != nil {
+        acceleration := math.Abs(speedKmh-*previous.SpeedKmh) / 3.6 / elapsed.Seconds()
+        if acceleration >= harshAccelerationMps2 {
+            counts.HarshEvents = 1
+        }
+    }
+    if status == models.VehicleStatusActive && distance <= idleRadiusMeters {
+        counts.IdleSeconds = elapsed.Seconds()
+    }
+    return counts
+}
+
+// SafetyScoreScheduler publishes the changes of the safety scores of the previous UTC day delay after midnight
+type SafetyScoreScheduler struct {
+    safetyScoreService SafetyScoreService
+    // delay gives late readings some time to arrive before a day is scored
+    delay time.Duration
+}
+
+func NewSafetyScoreScheduler(safetyScoreService SafetyScoreService, delay time.Duration) *SafetyScoreScheduler {
+    return &SafetyScoreScheduler{safetyScoreService: safetyScoreService, delay: delay}
+}
+
+// Run blocks until ctx is done, publishing the changes of every day once it is complete
+func (s *SafetyScoreScheduler) Run(ctx context.Context) {
+    for {
+        now := time.Now().UTC()
+        _, next := ReportPeriodDaily.Bounds(now.Add(-s.delay))
+        next = next.AddDate(0, 0, 1).Add(s.delay)
+
+        timer := time.NewTimer(next.Sub(now))
+        select {
+        case <-ctx.Done():
+            timer.Stop()
+            return
+        case at := <-timer.C:
+            day := at.UTC().Add(-s.delay).AddDate(0, 0, -1)
+            published, err := s.safetyScoreService.PublishChanges(ctx, day)
+            if err != nil {
+                log.Printf("Failed to publish the safety score changes of %s: %v", day.Format(time.DateOnly), err)
+                continue
+            }
+            log.Printf("Published %d safety score changes of %s", published, day.Format(time.DateOnly))
+        }
+    }
+}
@@ -0,0 +1,178 @@
+package services
+
+import (
+    "context"
+    "math"
+    "testing"
+    "time"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeSafetyScoreRepo keeps the states and added days in memory, the sums are answered in the order they are asked
+type fakeSafetyScoreRepo struct {
+    states map[primitive.ObjectID]*repositories.SafetyState
+    days   []*repositories.SafetyScoreDay
+    sums   [][]*repositories.SafetyTotals
+}
+
+func (r *fakeSafetyScoreRepo) AddSafetyCounts(_ context.Context, day *repositories.SafetyScoreDay) error {
+    r.days = append(r.days, day)
+    return nil
+}
+
+func (r *fakeSafetyScoreRepo) SumSafetyCounts(
+    context.Context,
+    *repositories.SafetyScoreFilter,
+) ([]*repositories.SafetyTotals, error) {
+    if len(r.sums) == 0 {
+        return nil, nil
+    }
+    totals := r.sums[0]
+    r.sums = r.sums[1:]
+    return totals, nil
+}
+
+func (r *fakeSafetyScoreRepo) FindSafetyState(
+    _ context.Context,
+    vehicleID primitive.ObjectID,
+) (*repositories.SafetyState, error) {
+    return r.states[vehicleID], nil
+}
+
+func (r *fakeSafetyScoreRepo) SaveSafetyState(_ context.Context, state *repositories.SafetyState) error {
+    r.states[state.VehicleID] = state
+    return nil
+}
+
+func TestComputeSafetyScore(t *testing.T) {
+    tests := []struct {
+        name   string
+        counts repositories.SafetyCounts
+        want   float64
+    }{
+        {name: "no penalty", counts: repositories.SafetyCounts{DistanceMeters: 50000}, want: 100},
+        {
+            name:   "short distances count as 100km",
+            counts: repositories.SafetyCounts{DistanceMeters: 10000, SpeedingEvents: 1, HarshEvents: 1},
+            want:   92,
+        },
+        {
+            name:   "penalties per 100km",
+            counts: repositories.SafetyCounts{DistanceMeters: 400000, SpeedingEvents: 4, IdleSeconds: 7200},
+            want:   92,
+        },
+        {name: "never below 0", counts: repositories.SafetyCounts{SpeedingEvents: 30}, want: 0},
+    }
+    for _, tt := range tests {
+        t.Run(
+            tt.name, func(t *testing.T) {
+                if got := ComputeSafetyScore(tt.counts); got != tt.want {
+                    t.Fatalf("Should score %v, got %v", tt.want, got)
+                }
+            },
+        )
+    }
+}
+
+func TestSafetyScoringProcessor_PostPersist(t *testing.T) {
+    ctx := context.Background()
+    repo := &fakeSafetyScoreRepo{states: map[primitive.ObjectID]*repositories.SafetyState{}}
+    p := NewSafetyScoringProcessor(repo, 80)
+
+    vehicleID := primitive.NewObjectID()
+    start := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+    readings := []*repositories.TrackingRecord{
+        positionedRecord(vehicleID, start, 16.0, 96.0),
+        // too close to the previous reading to be compared
+        positionedRecord(vehicleID, start.Add(2*time.Second), 16.0001, 96.0),
+        // about 67km/h
+        positionedRecord(vehicleID, start.Add(time.Minute), 16.01, 96.0),
+        // about 133km/h, speeding
+        positionedRecord(vehicleID, start.Add(2*time.Minute), 16.03, 96.0),
+        // stopped within 10 seconds, a harsh braking and idling
+        positionedRecord(vehicleID, start.Add(2*time.Minute+10*time.Second), 16.03, 96.0),
+        // after a gap nothing is counted
+        positionedRecord(vehicleID, start.Add(30*time.Minute), 16.03, 96.0),
+    }
+    for _, reading := range readings {
+        reading.DriverID = "driver-1"
+        if err := p.PostPersist(ctx, reading); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    var total repositories.SafetyCounts
+    for _, day := range repo.days {
+        if day.DriverID != "driver-1" || !day.Day.Equal(start.Truncate(24*time.Hour)) {
+            t.Fatalf("Should count to the day and driver of the reading, got %+v", day)
+        }
+        total.DistanceMeters += day.DistanceMeters
+        total.SpeedingEvents += day.SpeedingEvents
+        total.HarshEvents += day.HarshEvents
+        total.IdleSeconds += day.IdleSeconds
+    }
+    if total.SpeedingEvents != 1 || total.HarshEvents != 1 || total.IdleSeconds != 10 {
+        t.Fatalf("Should count one speeding, one harsh event and 10s idling, got %+v", total)
+    }
+    if math.Abs(total.DistanceMeters-3336) > 10 {
+        t.Fatalf("Should count about 3336m driven, got %v", total.DistanceMeters)
+    }
+    if !repo.states[vehicleID].At.Equal(start.Add(30 * time.Minute)) {
+        t.Fatalf("Should keep the latest reading as the state, got %v", repo.states[vehicleID].At)
+    }
+}
+
+func TestMongoSafetyScoreService_PublishChanges(t *testing.T) {
+    ctx := context.Background()
+    worse, steady, idle := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+    repo := &fakeSafetyScoreRepo{
+        sums: [][]*repositories.SafetyTotals{
+            // the vehicles in the window ending with the day
+            {
+                {VehicleID: worse, SafetyCounts: repositories.SafetyCounts{SpeedingEvents: 3}},
+                {VehicleID: steady, SafetyCounts: repositories.SafetyCounts{SpeedingEvents: 1}},
+            },
+            // the vehicles in the window ending the day before
+            {
+                {VehicleID: worse, SafetyCounts: repositories.SafetyCounts{SpeedingEvents: 1}},
+                {VehicleID: steady, SafetyCounts: repositories.SafetyCounts{SpeedingEvents: 1}},
+                {TenantID: "acme", VehicleID: idle, SafetyCounts: repositories.SafetyCounts{HarshEvents: 4}},
+            },
+            // the drivers in the window ending with the day
+            {{DriverID: "driver-1", SafetyCounts: repositories.SafetyCounts{HarshEvents: 2}}},
+        },
+    }
+    publisher := &recordingPublisher{}
+    s := NewMongoSafetyScoreService(repo, nil, publisher, 30, 5)
+
+    published, err := s.PublishChanges(ctx, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if published != 3 || publisher.count() != 3 {
+        t.Fatalf("Should publish 3 changes, got %d and %d bodies", published, publisher.count())
+    }
+    changes := map[string]*SafetyScoreChange{}
+    for _, body := range publisher.bodies {
+        var change SafetyScoreChange
+        if err := json.Unmarshal(body, &change); err != nil {
+            t.Fatal(err)
+        }
+        changes[change.VehicleID+change.DriverID] = &change
+    }
+    if change := changes[worse.Hex()]; change == nil || change.Score != 85 || change.PreviousScore != 95 {
+        t.Fatalf("Should publish the worse score, got %+v", change)
+    }
+    if change := changes[idle.Hex()]; change == nil || change.Score != 100 || change.PreviousScore != 88 {
+        t.Fatalf("Should publish the vehicle that stopped driving back to 100, got %+v", change)
+    }
+    if change := changes["driver-1"]; change == nil || change.Score != 94 || change.PreviousScore != 100 {
+        t.Fatalf("Should publish the new driver, got %+v", change)
+    }
+    if change := changes["driver-1"]; !change.At.Equal(time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)) {
+        t.Fatalf("Should publish the end of the window, got %v", change.At)
+    }
+}
@@ -0,0 +1,414 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "log"
+    "math"
+    "math/rand"
+    "sync"
+    "time"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/geo"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+    defaultSimulationSpeedKmh = 40
+    defaultSimulationInterval = 5 * time.Second
+    minSimulationInterval     = 100 * time.Millisecond
+
+    // simulatedFuelRange is the mileage in km between refuels of the simulated vehicles
+    simulatedFuelRange = 400
+)
+
+var (
+    ErrSimulationRunning    = errors.New("a simulation is already running")
+    ErrSimulationNotRunning = errors.New("no simulation is running")
+    ErrInvalidRoute         = errors.New("a route needs at least two valid points")
+    ErrInvalidInterval      = errors.New("invalid interval, it must be a duration of at least 100ms")
+)
+
+// defaultSimulationRoute is driven when the simulation is started without routes, a loop through downtown Yangon
+var defaultSimulationRoute = SimulationRoute{
+    Name: "Yangon Downtown",
+    Points: []geo.Point{
+        geo.NewPoint(16.7784, 96.1490),
+        geo.NewPoint(16.7806, 96.1581),
+        geo.NewPoint(16.7900, 96.1601),
+        geo.NewPoint(16.7985, 96.1496),
+        geo.NewPoint(16.8060, 96.1560),
+        geo.NewPoint(16.8160, 96.1290),
+        geo.NewPoint(16.7950, 96.1370),
+        geo.NewPoint(16.7784, 96.1490),
+    },
+}
+
+type SimulationRoute struct {
+    // Name is sent as the location of the readings
+    Name   string      `json:"name" validate:"required"`
+    Points []geo.Point `json:"points" validate:"required,min=2"`
+}
+
+type SimulationRequest struct {
+    Vehicles int               `json:"vehicles" validate:"required,min=1,max=1000"`
+    Routes   []SimulationRoute `json:"routes" validate:"omitempty,dive"`
+    // SpeedKmh is the average driving speed, every vehicle drives up to 10% faster or slower
+    SpeedKmh float64 `json:"speed_kmh" validate:"omitempty,gt=0,lte=200"`
+    // Interval is how often every vehicle reports in real time, 5s by default
+    Interval string `json:"interval"`
+    // Speed multiplies how fast the simulated time passes, 1 is real time
+    Speed float64 `json:"speed" validate:"omitempty,gt=0,lte=100"`
+}
+
+type SimulationSpeedRequest struct {
+    Speed float64 `json:"speed" validate:"required,gt=0,lte=100"`
+}
+
+type SimulatedVehicle struct {
+    VehicleID string               `json:"vehicle_id"`
+    Route     string               `json:"route"`
+    Lat       float64              `json:"lat"`
+    Lng       float64              `json:"lng"`
+    Mileage   float64              `json:"mileage"`
+    Fuel      models.FuelCondition `json:"fuel_condition"`
+}
+
+type SimulationStatus struct {
+    Running   bool                `json:"running"`
+    StartedAt *timestamp.Time     `json:"started_at,omitempty"`
+    Interval  string              `json:"interval,omitempty"`
+    Speed     float64             `json:"speed,omitempty"`
+    Published int64               `json:"published"`
+    Failed    int64               `json:"failed"`
+    Vehicles  []*SimulatedVehicle `json:"vehicles"`
+}
+
+type SimulationService interface {
+    // Start drives the virtual vehicles in the background until Stop, the readings are published with the
+    // tenant of ctx
+    Start(ctx context.Context, req *SimulationRequest) (*SimulationStatus, error)
+    Stop() (*SimulationStatus, error)
+    // SetSpeed changes how fast the simulated time passes while running
+    SetSpeed(speed float64) (*SimulationStatus, error)
+    Status() *SimulationStatus
+}
+
+// simulatedVehicle drives back and forth along its route
+type simulatedVehicle struct {
+    id       primitive.ObjectID
+    route    *simulationPath
+    speedKmh float64
+    // traveled is the distance driven along the route in meters, it goes back and forth over twice the length
+    traveled float64
+    mileage  float64
+}
+
+// simulationPath is a route with the cumulative distance of every point in meters
+type simulationPath struct {
+    name      string
+    points    []geo.Point
+    distances []float64
+}
+
+func newSimulationPath(route SimulationRoute) (*simulationPath, error) {
+    if len(route.Points) < 2 {
+        return nil, ErrInvalidRoute
+    }
+    path := &simulationPath{name: route.Name, points: route.Points, distances: make([]float64, len(route.Points))}
+    for i, point := range route.Points {
+        if !point.Valid() {
+            return nil, ErrInvalidRoute
+        }
+        if i > 0 {
+            path.distances[i] = path.distances[i-1] + geo.Haversine(route.Points[i-1], point)
+        }
+    }
+    if path.length() == 0 {
+        return nil, ErrInvalidRoute
+    }
+    return path, nil
+}
+
+func (p *simulationPath) length() float64 {
+    return p.distances[len(p.distances)-1]
+}
+
+// at returns the position after driving traveled meters, vehicles turn around at the ends of the route
+func (p *simulationPath) at(traveled float64) geo.Point {
+    length := p.length()
+    offset := math.Mod(traveled, 2*length)
+    if offset > length {
+        offset = 2*length - offset
+    }
+    for i := 1; i < len(p.points); i++ {
+        if offset > p.distances[i] {
+            continue
+        }
+        segment := p.distances[i] - p.distances[i-1]
+        if segment == 0 {
+            return p.points[i]
+        }
+        ratio := (offset - p.distances[i-1]) / segment
+        from, to := p.points[i-1], p.points[i]
+        return geo.NewPoint(from.Lat+(to.Lat-from.Lat)*ratio, from.Lng+(to.Lng-from.Lng)*ratio)
+    }
+    return p.points[len(p.points)-1]
+}
+
+// simulatedFuel returns the fuel condition of a vehicle that refuels every simulatedFuelRange km
+func simulatedFuel(mileage float64) models.FuelCondition {
+    switch left := 1 - math.Mod(mileage, simulatedFuelRange)/simulatedFuelRange; {
+    case left > 0.75:
+        return models.FuelConditionFull
+    case left > 0.4:
+        return models.FuelConditionHalf
+    case left > 0.1:
+        return models.FuelConditionLow
+    default:
+        return models.FuelConditionEmpty
+    }
+}
+
+type simulation struct {
+    vehicles  []*simulatedVehicle
+    interval  time.Duration
+    speed     float64
+    startedAt time.Time
+    published int64
+    failed    int64
+    cancel    context.CancelFunc
+    done      chan struct{}
+}
+
+// RealTimeSimulationService drives virtual vehicles in real time and publishes their readings like devices would,
+// for staging and demos without hardware. One simulation runs at a time.
+type RealTimeSimulationService struct {
+    publisher Publisher
+
+    mu         sync.Mutex
+    simulation *simulation
+}
+
+func NewRealTimeSimulationService(publisher Publisher) *RealTimeSimulationService {
+    return &RealTimeSimulationService{publisher: publisher}
+}
+
+func (s *RealTimeSimulationService) Start(ctx context.Context, req *SimulationRequest) (*SimulationStatus, error) {
+    sim, err := newSimulation(req)
+    if err != nil {
+        return nil, err
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.simulation != nil {
+        return nil, ErrSimulationRunning
+    }
+    // the simulation outlives the request, it keeps its tenant
+    runCtx, cancel := context.WithCancel(tenant.Detach(ctx))
+    sim.cancel = cancel
+    s.simulation = sim
+    go s.run(runCtx, sim)
+    return s.statusLocked(), nil
+}
+
+func newSimulation(req *SimulationRequest) (*simulation, error) {
+    routes := req.Routes
+    if len(routes) == 0 {
+        routes = []SimulationRoute{defaultSimulationRoute}
+    }
+    paths := make([]*simulationPath, 0, len(routes))
+    for _, route := range routes {
+        path, err := newSimulationPath(route)
+        if err != nil {
+            return nil, err
+        }
+        paths = append(paths, path)
+    }
+
+    interval := defaultSimulationInterval
+    if req.Interval != "" {
+        parsed, err := time.ParseDuration(req.Interval)
+        if err != nil || parsed < minSimulationInterval {
+            return nil, ErrInvalidInterval
+        }
+        interval = parsed
+    }
+    speedKmh := req.SpeedKmh
+    if speedKmh == 0 {
+        speedKmh = defaultSimulationSpeedKmh
+    }
+    speed := req.Speed
+    if speed == 0 {
+        speed = 1
+    }
+
+    sim := &simulation{
+        vehicles:  make([]*simulatedVehicle, req.Vehicles),
+        interval:  interval,
+        speed:     speed,
+        startedAt: time.Now(),
+        done:      make(chan struct{}),
+    }
+    random := rand.New(rand.NewSource(time.Now().UnixNano()))
+    for i := range sim.vehicles {
+        // the vehicles are spread over the routes and along them, so they don't drive in a convoy
+        path := paths[i%len(paths)]
+        sim.vehicles[i] = &simulatedVehicle{
+            id:       primitive.NewObjectID(),
+            route:    path,
+            speedKmh: speedKmh * (0.9 + 0.2*random.Float64()),
+            traveled: 2 * path.length() * random.Float64(),
+            mileage:  math.Round(random.Float64() * simulatedFuelRange),
+        }
+    }
+    return sim, nil
+}
+
+func (s *RealTimeSimulationService) run(ctx context.Context, sim *simulation) {
+    defer close(sim.done)
+    ticker := time.NewTicker(sim.interval)
+    defer ticker.Stop()
+    for {
+        s.publish(ctx, sim)
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            s.mu.Lock()
+            sim.advance(time.Duration(float64(sim.interval) * sim.speed))
+            s.mu.Unlock()
+        }
+    }
+}
+
+// advance drives every vehicle for the simulated duration
+func (sim *simulation) advance(elapsed time.Duration) {
+    for _, vehicle := range sim.vehicles {
+        meters := vehicle.speedKmh * 1000 * elapsed.Hours()
+        vehicle.traveled += meters
+        vehicle.mileage += meters / 1000
+    }
+}
+
+// readings returns the current reading of every vehicle as tracking data messages
+func (sim *simulation) readings() ([][]byte, error) {
+    readings := make([][]byte, 0, len(sim.vehicles))
+    for _, vehicle := range sim.vehicles {
+        position := vehicle.route.at(vehicle.traveled)
+        req := &TrackingDataRequest{
+            TrackingDataRequest: models.TrackingDataRequest{
+                VehicleID:     vehicle.id.Hex(),
+                Location:      vehicle.route.name,
+                Mileage:       math.Round(vehicle.mileage*10) / 10,
+                Status:        models.VehicleStatusActive,
+                FuelCondition: simulatedFuel(vehicle.mileage),
+            },
+            Lat: &position.Lat,
+            Lng: &position.Lng,
+        }
+        body, err := json.Marshal(req)
+        if err != nil {
+            return nil, err
+        }
+        readings = append(readings, body)
+    }
+    return readings, nil
+}
+
+func (s *RealTimeSimulationService) publish(ctx context.Context, sim *simulation) {
+    s.mu.Lock()
+    readings, err := sim.readings()
+    s.mu.Unlock()
+    if err != nil {
+        log.Println("Failed to build simulated readings: ", err)
+        return
+    }
+
+    var published, failed int64
+    for _, body := range readings {
+        if ctx.Err() != nil {
+            return
+        }
+        if err := s.publisher.Publish(ctx, body); err != nil {
+            failed++
+            log.Println("Failed to publish simulated reading: ", err)
+            continue
+        }
+        published++
+    }
+
+    s.mu.Lock()
+    sim.published += published
+    sim.failed += failed
+    s.mu.Unlock()
+}
+
+// Stop stops the simulation and waits until it stopped publishing
+func (s *RealTimeSimulationService) Stop() (*SimulationStatus, error) {
+    s.mu.Lock()
+    sim := s.simulation
+    if sim == nil {
+        s.mu.Unlock()
+        return nil, ErrSimulationNotRunning
+    }
+    status := s.statusLocked()
+    s.simulation = nil
+    s.mu.Unlock()
+
+    sim.cancel()
+    <-sim.done
+    status.Running = false
+    return status, nil
+}
+
+func (s *RealTimeSimulationService) SetSpeed(speed float64) (*SimulationStatus, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.simulation == nil {
+        return nil, ErrSimulationNotRunning
+    }
+    s.simulation.speed = speed
+    return s.statusLocked(), nil
+}
+
+func (s *RealTimeSimulationService) Status() *SimulationStatus {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.statusLocked()
+}
+
+func (s *RealTimeSimulationService) statusLocked() *SimulationStatus {
+    sim := s.simulation
+    if sim == nil {
+        return &SimulationStatus{Vehicles: []*SimulatedVehicle{}}
+    }
+    status := &SimulationStatus{
+        Running:   true,
+        StartedAt: timestamp.Ptr(sim.startedAt),
+        Interval:  sim.interval.String(),
+        Speed:     sim.speed,
+        Published: sim.published,
+        Failed:    sim.failed,
+        Vehicles:  make([]*SimulatedVehicle, 0, len(sim.vehicles)),
+    }
+    for _, vehicle := range sim.vehicles {
+        position := vehicle.route.at(vehicle.traveled)
+        status.Vehicles = append(
+            status.Vehicles, &SimulatedVehicle{
+                VehicleID: vehicle.id.Hex(),
+                Route:     vehicle.route.name,
+                Lat:       position.Lat,
+                Lng:       position.Lng,
+                Mileage:   math.Round(vehicle.mileage*10) / 10,
+                Fuel:      simulatedFuel(vehicle.mileage),
+            },
+        )
+    }
+    return status
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "math"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/geo"
+)
+
+type recordingPublisher struct {
+    mu     sync.Mutex
+    bodies [][]byte
+}
+
+func (p *recordingPublisher) Publish(_ context.Context, body []byte) error {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    p.bodies = append(p.bodies, body)
+    return nil
+}
+
+func (p *recordingPublisher) count() int {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    return len(p.bodies)
+}
+
+func TestSimulationPath_At(t *testing.T) {
+    path, err := newSimulationPath(
+        SimulationRoute{Name: "line", Points: []geo.Point{geo.NewPoint(0, 0), geo.NewPoint(0, 1)}},
+    )
+    if err != nil {
+        t.Fatal(err)
+    }
+    length := path.length()
+
+    if middle := path.at(length / 2); math.Abs(middle.Lng-0.5) > 1e-9 {
+        t.Errorf("expected the middle of the route, got %v", middle)
+    }
+    // past the end the vehicle drives back
+    if back := path.at(length * 1.25); math.Abs(back.Lng-0.75) > 1e-9 {
+        t.Errorf("expected the vehicle to turn around at the end, got %v", back)
+    }
+    if start := path.at(length * 2); math.Abs(start.Lng) > 1e-9 {
+        t.Errorf("expected the vehicle back at the start, got %v", start)
+    }
+
+    if _, err = newSimulationPath(SimulationRoute{Name: "point", Points: []geo.Point{geo.NewPoint(0, 0)}}); !errors.Is(err, ErrInvalidRoute) {
+        t.Errorf("expected a route with one point to be invalid, got %v", err)
+    }
+}
+
+func TestSimulation_Advance(t *testing.T) {
+    sim, err := newSimulation(&SimulationRequest{Vehicles: 3, SpeedKmh: 60})
+    if err != nil {
+        t.Fatal(err)
+    }
+    mileage := sim.vehicles[0].mileage
+    speedKmh := sim.vehicles[0].speedKmh
+    if speedKmh < 54 || speedKmh > 66 {
+        t.Errorf("expected the speed to vary by at most 10%%, got %f", speedKmh)
+    }
+
+    sim.advance(30 * time.Minute)
+    if driven := sim.vehicles[0].mileage - mileage; math.Abs(driven-speedKmh/2) > 1e-9 {
+        t.Errorf("expected %f km to be driven in half an hour, got %f", speedKmh/2, driven)
+    }
+
+    readings, err := sim.readings()
+    if err != nil || len(readings) != 3 {
+        t.Fatalf("expected a reading per vehicle, got %d, %v", len(readings), err)
+    }
+    var req TrackingDataRequest
+    if err = json.Unmarshal(readings[0], &req); err != nil {
+        t.Fatal(err)
+    }
+    if req.Location != defaultSimulationRoute.Name || req.Lat == nil || req.Lng == nil {
+        t.Errorf("expected a reading on the default route with a position, got %+v", req)
+    }
+
+    if _, err = newSimulation(&SimulationRequest{Vehicles: 1, Interval: "10ms"}); !errors.Is(err, ErrInvalidInterval) {
+        t.Errorf("expected a too short interval to be invalid, got %v", err)
+    }
+}
+
+func TestSimulationService_StartStop(t *testing.T) {
+    publisher := &recordingPublisher{}
+    s := NewRealTimeSimulationService(publisher)
+
+    if _, err := s.Stop(); !errors.Is(err, ErrSimulationNotRunning) {
+        t.Fatalf("expected ErrSimulationNotRunning, got %v", err)
+    }
+
+    status, err := s.Start(context.Background(), &SimulationRequest{Vehicles: 2, Interval: "100ms"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !status.Running || len(status.Vehicles) != 2 {
+        t.Fatalf("expected 2 running vehicles, got %+v", status)
+    }
+    if _, err = s.Start(context.Background(), &SimulationRequest{Vehicles: 1}); !errors.Is(err, ErrSimulationRunning) {
+        t.Errorf("expected ErrSimulationRunning, got %v", err)
+    }
+    if status, err = s.SetSpeed(10); err != nil || status.Speed != 10 {
+        t.Errorf("expected the speed to change, got %+v, %v", status, err)
+    }
+
+    deadline := time.Now().Add(2 * time.Second)
+    for publisher.count() < 4 && time.Now().Before(deadline) {
+        time.Sleep(10 * time.Millisecond)
+    }
+    if publisher.count() < 4 {
+        t.Fatalf("expected readings to be published every interval, got %d", publisher.count())
+    }
+
+    if status, err = s.Stop(); err != nil || status.Running || status.Published < 4 {
+        t.Fatalf("expected the stopped simulation with its published readings, got %+v, %v", status, err)
+    }
+    published := publisher.count()
+    time.Sleep(150 * time.Millisecond)
+    if publisher.count() != published {
+        t.Errorf("expected no readings after stopping")
+    }
+    if s.Status().Running {
+        t.Errorf("expected no running simulation")
+    }
+}
@@ -0,0 +1,260 @@
+package services
+
+import (
+    "bufio"
+    "bytes"
+    "compress/gzip"
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "time"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/parquet"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/storage"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+)
+
+const (
+    SnapshotContentType = "application/gzip"
+
+    // restoreBatchSize is the number of readings of a tenant inserted at once by a restore
+    restoreBatchSize = 500
+    // maxSnapshotLine is the longest reading of a snapshot
+    maxSnapshotLine = 1 << 20
+)
+
+var (
+    ErrInvalidSnapshot = errors.New("invalid snapshot")
+)
+
+// SnapshotKey returns the key of the snapshot of the UTC day of day
+func SnapshotKey(day time.Time) string {
+    return "snapshots/" + day.UTC().Format("2006/01/02") + ".jsonl.gz"
+}
+
+// SnapshotParquetKey returns the key of the Parquet copy of the snapshot of the UTC day of day
+func SnapshotParquetKey(day time.Time) string {
+    return "snapshots/" + day.UTC().Format("2006/01/02") + ".parquet"
+}
+
+// Snapshot is the export of the tracking data of a UTC day, ParquetKey is the key of its Parquet copy when there is
+// one
+type Snapshot struct {
+    Day          string `json:"day"`
+    Key          string `json:"key"`
+    ParquetKey   string `json:"parquet_key,omitempty"`
+    Readings     int    `json:"readings"`
+    Bytes        int    `json:"bytes"`
+    ParquetBytes int    `json:"parquet_bytes,omitempty"`
+}
+
+// SnapshotRestore is the outcome of the restore of a snapshot. Existing are the readings that were still stored,
+// they are kept as they are.
+type SnapshotRestore struct {
+    Day      string `json:"day"`
+    Key      string `json:"key"`
+    Restored int    `json:"restored"`
+    Existing int    `json:"existing"`
+    Failed   int    `json:"failed"`
+}
+
+type SnapshotService interface {
+    // ExportSnapshot uploads the tracking data created on the UTC day of day as gzip compressed JSONL, flagged and
+    // every tenant's readings included, replacing the previous snapshot of the day. A Parquet copy for analytics is
+    // uploaded next to it when enabled, only the JSONL can be restored.
+    ExportSnapshot(ctx context.Context, day time.Time) (*Snapshot, error)
+    // RestoreSnapshot inserts the readings of the snapshot of the UTC day of day with their ids and tenants, only the
+    // readings of the tenant of the context when it has one. The readings still stored are left untouched, so a
+    // snapshot can be restored again after a failure.
+    RestoreSnapshot(ctx context.Context, day time.Time) (*SnapshotRestore, error)
+}
+
+type TrackingSnapshotService struct {
+    trackingRepo repositories.TrackingRepository
+    storage      storage.ObjectStorage
+    // parquet uploads a Parquet copy of every snapshot
+    parquet bool
+}
+
+func NewTrackingSnapshotService(
+    trackingRepo repositories.TrackingRepository,
+    storage storage.ObjectStorage,
+    parquet bool,
+) *TrackingSnapshotService {
+    return &TrackingSnapshotService{trackingRepo: trackingRepo, storage: storage, parquet: parquet}
+}
+
+func (s *TrackingSnapshotService) ExportSnapshot(ctx context.Context, day time.Time) (*Snapshot, error) {
+    from, to := ReportPeriodDaily.Bounds(day.UTC().AddDate(0, 0, 1))
+    filter := &repositories.TrackingFilter{
+        SortField: "created_at",
+        SortOrder: "asc",
+        From:      from.Format(time.RFC3339),
+        To:        to.Format(time.RFC3339),
+        Exclude:   repositories.ExcludeNone,
+    }
+    if err := filter.Build(); err != nil {
+        return nil, err
+    }
+
+    var body, parquetBody bytes.Buffer
+    compressed := gzip.NewWriter(&body)
+    encoder := json.NewEncoder(compressed)
+    var parquetWriter *repositories.TrackingParquetWriter
+    if s.parquet {
+        var err error
+        if parquetWriter, err = repositories.NewTrackingParquetWriter(&parquetBody); err != nil {
+            return nil, err
+        }
+    }
+    snapshot := &Snapshot{Day: from.Format(time.DateOnly), Key: SnapshotKey(from)}
+    // the readings are streamed once, into the JSONL and the Parquet copy
+    err := s.trackingRepo.StreamTrackingData(
+        ctx, filter, func(record *repositories.TrackingRecord) error {
+            snapshot.Readings++
+            if parquetWriter != nil {
+                if err := parquetWriter.Write(record); err != nil {
+                    return err
+                }
+            }
+            return encoder.Encode(record)
+        },
+    )
+    if err != nil {
+        return nil, err
+    }
+    if err = compressed.Close(); err != nil {
+        return nil, err
+    }
+
+    snapshot.Bytes = body.Len()
+    if err = s.storage.Put(ctx, snapshot.Key, SnapshotContentType, body.Bytes()); err != nil {
+        return nil, err
+    }
+    if parquetWriter != nil {
+        if err = parquetWriter.Close(); err != nil {
+            return nil, err
+        }
+        snapshot.ParquetKey, snapshot.ParquetBytes = SnapshotParquetKey(from), parquetBody.Len()
+        if err = s.storage.Put(ctx, snapshot.ParquetKey, parquet.ContentType, parquetBody.Bytes()); err != nil {
+            return nil, err
+        }
+    }
+    return snapshot, nil
+}
+
+func (s *TrackingSnapshotService) RestoreSnapshot(ctx context.Context, day time.Time) (*SnapshotRestore, error) {
+    restore := &SnapshotRestore{Day: day.UTC().Format(time.DateOnly), Key: SnapshotKey(day)}
+    body, err := s.storage.Get(ctx, restore.Key)
+    if err != nil {
+        return nil, err
+    }
+    decompressed, err := gzip.NewReader(bytes.NewReader(body))
+    if err != nil {
+        return nil, fmt.Errorf("%w: %w", ErrInvalidSnapshot, err)
+    }
+    defer decompressed.Close()
+
+    only, scoped := tenant.FromContext(ctx)
+    // the readings are inserted per tenant, the repository stores them with the tenant of the context
+    batches := map[string][]*repositories.TrackingRecord{}
+    insert := func(id string) error {
+        batch := batches[id]
+        if len(batch) == 0 {
+            return nil
+        }
+        batches[id] = nil
+        insertCtx := ctx
+        if id != "" && !scoped {
+            insertCtx = tenant.WithID(ctx, id)
+        }
+        itemErrs, err := s.trackingRepo.CreateManyTrackingData(insertCtx, batch)
+        if err != nil {
+            return err
+        }
+        for i, itemErr := range itemErrs {
+            switch {
+            case itemErr == nil:
+                restore.Restored++
+            case errors.Is(itemErr, repositories.ErrDuplicate):
+                restore.Existing++
+            default:
+                restore.Failed++
+                log.Printf("Failed to restore tracking data %s: %v", batch[i].ID.Hex(), itemErr)
+            }
+        }
+        return nil
+    }
+
+    scanner := bufio.NewScanner(decompressed)
+    scanner.Buffer(make([]byte, 0, 64*1024), maxSnapshotLine)
+    for line := 1; scanner.Scan(); line++ {
+        if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+            continue
+        }
+        record := &repositories.TrackingRecord{}
+        if err = json.Unmarshal(scanner.Bytes(), record); err != nil {
+            return nil, fmt.Errorf("%w: line %d: %w", ErrInvalidSnapshot, line, err)
+        }
+        if scoped && record.TenantID != only {
+            continue
+        }
+        batches[record.TenantID] = append(batches[record.TenantID], record)
+        if len(batches[record.TenantID]) >= restoreBatchSize {
+            if err = insert(record.TenantID); err != nil {
+                return nil, err
+            }
+        }
+    }
+    // the snapshot is read from memory, the errors are the ones of a corrupt or truncated snapshot
+    if err = scanner.Err(); err != nil {
+        return nil, fmt.Errorf("%w: %w", ErrInvalidSnapshot, err)
+    }
+    for id := range batches {
+        if err = insert(id); err != nil {
+            return nil, err
+        }
+    }
+    return restore, nil
+}
+
+// SnapshotScheduler exports the tracking data of the previous UTC day delay after midnight
+type SnapshotScheduler struct {
+    snapshotService SnapshotService
+    // delay gives late readings some time to arrive before a day is exported
+    delay time.Duration
+}
+
+func NewSnapshotScheduler(snapshotService SnapshotService, delay time.Duration) *SnapshotScheduler {
+    return &SnapshotScheduler{snapshotService: snapshotService, delay: delay}
+}
+
+// Run blocks until ctx is done, exporting every day once it is complete
+func (s *SnapshotScheduler) Run(ctx context.Context) {
+    for {
+        now := time.Now().UTC()
+        _, next := ReportPeriodDaily.Bounds(now.Add(-s.delay))
+        next = next.AddDate(0, 0, 1).Add(s.delay)
+
+        timer := time.NewTimer(next.Sub(now))
+        select {
+        case <-ctx.Done():
+            timer.Stop()
+            return
+        case at := <-timer.C:
+            s.export(ctx, at.UTC().Add(-s.delay).AddDate(0, 0, -1))
+        }
+    }
+}
+
+func (s *SnapshotScheduler) export(ctx context.Context, day time.Time) {
+    snapshot, err := s.snapshotService.ExportSnapshot(ctx, day)
+    if err != nil {
+        log.Printf("Failed to export the snapshot of %s: %v", day.Format(time.DateOnly), err)
+        return
+    }
+    log.Printf("Exported %d readings to the snapshot %s", snapshot.Readings, snapshot.Key)
+}
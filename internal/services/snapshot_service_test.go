@@ -0,0 +1,103 @@
+package services
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/storage"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeObjectStorage keeps the objects in memory
+type fakeObjectStorage map[string][]byte
+
+func (s fakeObjectStorage) Put(_ context.Context, key, _ string, body []byte) error {
+    s[key] = body
+    return nil
+}
+
+func (s fakeObjectStorage) Get(_ context.Context, key string) ([]byte, error) {
+    body, ok := s[key]
+    if !ok {
+        return nil, storage.ErrObjectNotFound
+    }
+    return body, nil
+}
+
+func TestTrackingSnapshotService(t *testing.T) {
+    ctx := context.Background()
+    acme, globex := tenant.WithID(ctx, "acme"), tenant.WithID(ctx, "globex")
+    day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+    trackingRepo := repositories.NewMemoryTrackingRepository()
+    for _, reading := range []struct {
+        ctx context.Context
+        at  time.Time
+    }{
+        {ctx: acme, at: day.Add(10 * time.Hour)},
+        {ctx: globex, at: day.Add(11 * time.Hour)},
+        {ctx: acme, at: day.Add(26 * time.Hour)},
+    } {
+        record := positionedRecord(primitive.NewObjectID(), reading.at, 16.8, 96.15)
+        // the flagged readings are part of the snapshot
+        record.AddFlag(repositories.FlagBackfill)
+        if err := trackingRepo.CreateTrackingData(reading.ctx, record); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    objects := fakeObjectStorage{}
+    exporter := NewTrackingSnapshotService(trackingRepo, objects, true)
+    snapshot, err := exporter.ExportSnapshot(ctx, day.Add(15*time.Hour))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if snapshot.Readings != 2 || snapshot.Key != "snapshots/2024/05/01.jsonl.gz" || snapshot.Day != "2024-05-01" {
+        t.Fatalf("expected the 2 readings of the day, got %+v", snapshot)
+    }
+    parquetCopy := objects["snapshots/2024/05/01.parquet"]
+    if snapshot.ParquetBytes != len(parquetCopy) || !bytes.HasPrefix(parquetCopy, []byte("PAR1")) {
+        t.Errorf("expected a Parquet copy of the snapshot, got %+v", snapshot)
+    }
+
+    restoredRepo := repositories.NewMemoryTrackingRepository()
+    s := NewTrackingSnapshotService(restoredRepo, objects, false)
+    restore, err := s.RestoreSnapshot(ctx, day)
+    if err != nil || restore.Restored != 2 {
+        t.Fatalf("expected 2 restored readings, got %+v, %v", restore, err)
+    }
+    all := &repositories.TrackingFilter{Exclude: repositories.ExcludeNone}
+    restored, err := restoredRepo.FindTrackingData(globex, all)
+    if err != nil || len(restored) != 1 || !restored[0].CreatedAt.Equal(day.Add(11*time.Hour)) {
+        t.Fatalf("expected the reading of the tenant at its time, got %v, %v", restored, err)
+    }
+    if len(restored[0].Flags) != 1 || restored[0].PublicID == "" {
+        t.Errorf("expected the flags and the public id to be kept, got %+v", restored[0])
+    }
+    if err = restored[0].Validate(); err != nil || restored[0].Mileage != 1200 || restored[0].Lat == nil ||
+        *restored[0].Lat != 16.8 {
+        t.Errorf("expected a valid reading with its mileage and position, got %+v", restored[0])
+    }
+
+    // restoring again keeps the stored readings
+    if restore, err = s.RestoreSnapshot(ctx, day); err != nil || restore.Restored != 0 || restore.Existing != 2 {
+        t.Errorf("expected the readings to exist already, got %+v, %v", restore, err)
+    }
+    // a tenant only restores its own readings
+    scoped := NewTrackingSnapshotService(repositories.NewMemoryTrackingRepository(), objects, false)
+    if restore, err = scoped.RestoreSnapshot(acme, day); err != nil || restore.Restored != 1 {
+        t.Errorf("expected the reading of the tenant to be restored, got %+v, %v", restore, err)
+    }
+
+    if _, err = s.RestoreSnapshot(ctx, day.AddDate(0, 0, 1)); !errors.Is(err, storage.ErrObjectNotFound) {
+        t.Errorf("expected ErrObjectNotFound, got %v", err)
+    }
+    objects[SnapshotKey(day)] = objects[SnapshotKey(day)][:20]
+    if _, err = s.RestoreSnapshot(ctx, day); !errors.Is(err, ErrInvalidSnapshot) {
+        t.Errorf("expected ErrInvalidSnapshot for a truncated snapshot, got %v", err)
+    }
+}
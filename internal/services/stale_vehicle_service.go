@@ -0,0 +1,135 @@
+package services
+
+import (
+    "context"
+    "log"
+    "net/url"
+    "time"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+)
+
+const (
+    DeviceOfflineEvent = "device.offline"
+
+    // offlineAlertHorizon is how long after going stale a vehicle is still alerted about, vehicles silent for longer,
+    // like the ones retired before the watchdog ran, are marked offline without an alert
+    offlineAlertHorizon = 24 * time.Hour
+)
+
+// DeviceOfflineAlert is the event published to the alerts queue for every vehicle found stale
+type DeviceOfflineAlert struct {
+    Event         string                     `json:"event"`
+    State         *repositories.VehicleState `json:"state"`
+    SilentSeconds float64                    `json:"silent_seconds"`
+}
+
+type StaleVehicleService interface {
+    // FindStale returns a page of the states of the vehicles that didn't report for the stale period
+    FindStale(ctx context.Context, query url.Values) ([]*repositories.VehicleState, error)
+    // Detect marks the vehicles that didn't report for the stale period by now offline and alerts about them, it
+    // returns the vehicles marked
+    Detect(ctx context.Context, now time.Time) ([]*repositories.VehicleState, error)
+}
+
+type MongoStaleVehicleService struct {
+    stateRepo       repositories.VehicleStateRepository
+    alertsPublisher Publisher
+    accessService   AccessService
+    // staleAfter is how long a vehicle doesn't report before it is stale
+    staleAfter time.Duration
+}
+
+func NewMongoStaleVehicleService(
+    stateRepo repositories.VehicleStateRepository,
+    alertsPublisher Publisher,
+    accessService AccessService,
+    staleAfter time.Duration,
+) *MongoStaleVehicleService {
+    return &MongoStaleVehicleService{
+        stateRepo:       stateRepo,
+        alertsPublisher: alertsPublisher,
+        accessService:   accessService,
+        staleAfter:      staleAfter,
+    }
+}
+
+func (s *MongoStaleVehicleService) FindStale(
+    ctx context.Context,
+    query url.Values,
+) ([]*repositories.VehicleState, error) {
+    var filter repositories.VehicleStateFilter
+    if err := decodeQuery(query, &filter); err != nil {
+        return nil, err
+    }
+    if err := filter.Build(); err != nil {
+        return nil, err
+    }
+    scope, err := s.accessService.VehicleScope(ctx)
+    if err != nil {
+        return nil, err
+    }
+    scope.Restrict(&filter)
+    filter.SeenBefore(time.Now().Add(-s.staleAfter))
+    return s.stateRepo.FindStates(ctx, &filter)
+}
+
+func (s *MongoStaleVehicleService) Detect(ctx context.Context, now time.Time) ([]*repositories.VehicleState, error) {
+    marked, err := s.stateRepo.MarkOffline(ctx, now.Add(-s.staleAfter), now)
+    if err != nil {
+        return marked, err
+    }
+    for _, state := range marked {
+        silent := now.Sub(state.LastSeenAt.Time)
+        if silent > s.staleAfter+offlineAlertHorizon {
+            continue
+        }
+        vehicleCtx := ctx
+        if state.TenantID != "" {
+            vehicleCtx = tenant.WithID(ctx, state.TenantID)
+        }
+        alert, err := json.Marshal(
+            &DeviceOfflineAlert{Event: DeviceOfflineEvent, State: state, SilentSeconds: silent.Seconds()},
+        )
+        if err != nil {
+            return marked, err
+        }
+        if err = s.alertsPublisher.Publish(vehicleCtx, alert); err != nil {
+            return marked, err
+        }
+    }
+    return marked, nil
+}
+
+// StaleVehicleScheduler looks for stale vehicles every interval
+type StaleVehicleScheduler struct {
+    staleVehicleService StaleVehicleService
+    interval            time.Duration
+}
+
+func NewStaleVehicleScheduler(staleVehicleService StaleVehicleService, interval time.Duration) *StaleVehicleScheduler {
+    return &StaleVehicleScheduler{staleVehicleService: staleVehicleService, interval: interval}
+}
+
+// Run blocks until ctx is done, looking for stale vehicles once right away and then every interval
+func (s *StaleVehicleScheduler) Run(ctx context.Context) {
+    ticker := time.NewTicker(s.interval)
+    defer ticker.Stop()
+    now := time.Now()
+    for {
+        marked, err := s.staleVehicleService.Detect(ctx, now)
+        if err != nil {
+            log.Println("Failed to detect stale vehicles: ", err)
+        }
+        if len(marked) > 0 {
+            log.Printf("Marked %d stale vehicles offline", len(marked))
+        }
+        select {
+        case <-ctx.Done():
+            return
+        case now = <-ticker.C:
+        }
+    }
+}
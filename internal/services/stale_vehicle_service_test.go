@@ -0,0 +1,72 @@
+package services
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// offlineStateRepo marks its states offline like MongoVehicleStateRepository
+type offlineStateRepo struct {
+    repositories.VehicleStateRepository
+    states []*repositories.VehicleState
+}
+
+func (r *offlineStateRepo) MarkOffline(_ context.Context, before, at time.Time) ([]*repositories.VehicleState, error) {
+    var marked []*repositories.VehicleState
+    for _, state := range r.states {
+        if state.OfflineAt == nil && state.LastSeenAt.Before(before) {
+            state.OfflineAt = timestamp.Ptr(at)
+            marked = append(marked, state)
+        }
+    }
+    return marked, nil
+}
+
+func TestStaleVehicleService_Detect(t *testing.T) {
+    now := time.Now().Truncate(time.Second)
+    state := func(silent time.Duration) *repositories.VehicleState {
+        return &repositories.VehicleState{
+            VehicleID:  primitive.NewObjectID(),
+            LastSeenAt: timestamp.New(now.Add(-silent)),
+        }
+    }
+    reporting, stale, retired := state(time.Minute), state(20*time.Minute), state(30*24*time.Hour)
+    stateRepo := &offlineStateRepo{states: []*repositories.VehicleState{reporting, stale, retired}}
+    publisher := &queuePublisher{}
+    s := NewMongoStaleVehicleService(
+        stateRepo,
+        publisher,
+        NewMongoAccessService(&fakeAssignmentRepo{}, nil),
+        15*time.Minute,
+    )
+
+    marked, err := s.Detect(context.Background(), now)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(marked) != 2 || reporting.OfflineAt != nil {
+        t.Fatalf("marked %d vehicles offline, want the stale and the retired one", len(marked))
+    }
+    // the vehicle retired long ago is marked without an alert
+    if len(publisher.bodies) != 1 {
+        t.Fatalf("published %d alerts, want 1", len(publisher.bodies))
+    }
+    var alert DeviceOfflineAlert
+    if err = json.Unmarshal(publisher.bodies[0], &alert); err != nil {
+        t.Fatal(err)
+    }
+    if alert.Event != DeviceOfflineEvent || alert.State.VehicleID != stale.VehicleID || alert.SilentSeconds != 1200 {
+        t.Fatalf("alert is %+v, want the stale vehicle silent for 20 minutes", alert)
+    }
+
+    // vehicles already offline aren't alerted about again
+    if marked, err = s.Detect(context.Background(), now.Add(time.Minute)); err != nil || len(marked) != 0 {
+        t.Fatalf("second pass marked %d vehicles, %v", len(marked), err)
+    }
+}
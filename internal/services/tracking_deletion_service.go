@@ -0,0 +1,147 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "net/url"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/plan"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// deletionRate is the number of tracking data deleted per second the plans of deletions assume
+const deletionRate = 5000
+
+var (
+    ErrDeletionVehicleMissing = errors.New("vehicle_id is required to delete tracking data")
+    ErrInvalidDeletionBefore  = errors.New("before must be an RFC3339 timestamp")
+    ErrInvalidDeletionMode    = errors.New("mode must be soft or purge")
+)
+
+// TrackingDeletionRequest selects the tracking data of a vehicle to delete, an empty Before selects all of it
+type TrackingDeletionRequest struct {
+    VehicleID string `json:"vehicle_id"`
+    Before    string `json:"before"`
+    Mode      string `json:"mode"`
+    DryRun    bool   `json:"dry_run" doc:"returns the plan of the deletion instead of deleting"`
+}
+
+// TrackingDeletionService deletes tracking data for retention and right to erasure requests,
+// every deletion is audited
+type TrackingDeletionService interface {
+    // DeleteTrackingData soft deletes or purges the tracking data selected by the query,
+    // requestedBy is recorded in the returned audit
+    DeleteTrackingData(ctx context.Context, query url.Values, requestedBy string) (*repositories.DeletionAudit, error)
+    // PlanDeletion returns what DeleteTrackingData would delete with the query, without deleting anything
+    PlanDeletion(ctx context.Context, query url.Values) (*plan.Plan, error)
+    FindDeletionAudits(ctx context.Context, query url.Values) ([]*repositories.DeletionAudit, error)
+}
+
+type MongoTrackingDeletionService struct {
+    trackingRepo repositories.TrackingRepository
+    auditRepo    repositories.DeletionAuditRepository
+}
+
+func NewMongoTrackingDeletionService(
+    trackingRepo repositories.TrackingRepository,
+    auditRepo repositories.DeletionAuditRepository,
+) *MongoTrackingDeletionService {
+    return &MongoTrackingDeletionService{trackingRepo: trackingRepo, auditRepo: auditRepo}
+}
+
+func (s *MongoTrackingDeletionService) DeleteTrackingData(
+    ctx context.Context,
+    query url.Values,
+    requestedBy string,
+) (*repositories.DeletionAudit, error) {
+    audit, before, err := parseDeletion(query)
+    if err != nil {
+        return nil, err
+    }
+    audit.RequestedBy = requestedBy
+
+    audit.Deleted, err = s.trackingRepo.DeleteTrackingData(
+        ctx,
+        audit.VehicleID,
+        before,
+        audit.Mode == repositories.DeletionModePurge,
+    )
+    if err != nil {
+        return nil, err
+    }
+    if err = s.auditRepo.CreateDeletionAudit(ctx, audit); err != nil {
+        return nil, err
+    }
+    return audit, nil
+}
+
+func (s *MongoTrackingDeletionService) PlanDeletion(ctx context.Context, query url.Values) (*plan.Plan, error) {
+    audit, before, err := parseDeletion(query)
+    if err != nil {
+        return nil, err
+    }
+    count, ids, err := s.trackingRepo.FindDeletionScope(
+        ctx,
+        audit.VehicleID,
+        before,
+        audit.Mode == repositories.DeletionModePurge,
+        plan.SampleSize,
+    )
+    if err != nil {
+        return nil, err
+    }
+    p := plan.New(ActionDeleteTrackingData, count, deletionRate)
+    p.SampleIDs = ids
+    p.Scope = map[string]string{"vehicle_id": audit.VehicleID.Hex(), "mode": audit.Mode}
+    if audit.Before != nil {
+        p.Scope["before"] = before.UTC().Format(time.RFC3339)
+    }
+    if audit.Mode == repositories.DeletionModePurge {
+        p.Warnings = append(p.Warnings, "purged tracking data can't be restored")
+    }
+    return p, nil
+}
+
+// parseDeletion validates the query of a deletion, the returned audit has the vehicle, mode and before of it
+func parseDeletion(query url.Values) (*repositories.DeletionAudit, time.Time, error) {
+    var req TrackingDeletionRequest
+    if err := decodeQuery(query, &req); err != nil {
+        return nil, time.Time{}, err
+    }
+    if req.VehicleID == "" {
+        return nil, time.Time{}, ErrDeletionVehicleMissing
+    }
+    vehicleID, err := primitive.ObjectIDFromHex(req.VehicleID)
+    if err != nil {
+        return nil, time.Time{}, repositories.ErrInvalidID
+    }
+    audit := &repositories.DeletionAudit{VehicleID: vehicleID, Mode: req.Mode}
+    if audit.Mode == "" {
+        audit.Mode = repositories.DeletionModeSoft
+    }
+    if audit.Mode != repositories.DeletionModeSoft && audit.Mode != repositories.DeletionModePurge {
+        return nil, time.Time{}, ErrInvalidDeletionMode
+    }
+    var before time.Time
+    if req.Before != "" {
+        if before, err = time.Parse(time.RFC3339, req.Before); err != nil {
+            return nil, time.Time{}, ErrInvalidDeletionBefore
+        }
+        audit.Before = timestamp.Ptr(before)
+    }
+    return audit, before, nil
+}
+
+func (s *MongoTrackingDeletionService) FindDeletionAudits(
+    ctx context.Context,
+    query url.Values,
+) ([]*repositories.DeletionAudit, error) {
+    var filter repositories.DeletionAuditFilter
+    if err := decodeQuery(query, &filter); err != nil {
+        return nil, err
+    }
+    return s.auditRepo.FindDeletionAudits(ctx, &filter)
+}
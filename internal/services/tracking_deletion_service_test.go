@@ -0,0 +1,126 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "net/url"
+    "testing"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type fakeDeletingTrackingRepo struct {
+    repositories.TrackingRepository
+    before time.Time
+    purge  bool
+}
+
+func (r *fakeDeletingTrackingRepo) DeleteTrackingData(
+    _ context.Context,
+    _ primitive.ObjectID,
+    before time.Time,
+    purge bool,
+) (int64, error) {
+    r.before, r.purge = before, purge
+    return 3, nil
+}
+
+func (r *fakeDeletingTrackingRepo) FindDeletionScope(
+    _ context.Context,
+    _ primitive.ObjectID,
+    before time.Time,
+    purge bool,
+    samples int,
+) (int64, []string, error) {
+    r.before, r.purge = before, purge
+    return 12000, []string{"01HZ"}[:min(samples, 1)], nil
+}
+
+type fakeDeletionAuditRepo struct {
+    repositories.DeletionAuditRepository
+    audits []*repositories.DeletionAudit
+}
+
+func (r *fakeDeletionAuditRepo) CreateDeletionAudit(_ context.Context, audit *repositories.DeletionAudit) error {
+    r.audits = append(r.audits, audit)
+    return nil
+}
+
+func TestTrackingDeletionService_DeleteTrackingData(t *testing.T) {
+    trackingRepo := &fakeDeletingTrackingRepo{}
+    auditRepo := &fakeDeletionAuditRepo{}
+    s := NewMongoTrackingDeletionService(trackingRepo, auditRepo)
+
+    vehicleID := primitive.NewObjectID().Hex()
+    audit, err := s.DeleteTrackingData(
+        context.Background(),
+        url.Values{"vehicle_id": {vehicleID}, "before": {"2024-01-01T00:00:00Z"}},
+        "admin",
+    )
+    if err != nil {
+        t.Fatal(err)
+    }
+    if audit.Mode != repositories.DeletionModeSoft || trackingRepo.purge {
+        t.Errorf("expected a soft delete by default, got %s", audit.Mode)
+    }
+    if audit.Deleted != 3 || audit.RequestedBy != "admin" || len(auditRepo.audits) != 1 {
+        t.Errorf("expected the deletion to be audited, got %+v", audit)
+    }
+    if !trackingRepo.before.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) {
+        t.Errorf("expected the before of the query, got %v", trackingRepo.before)
+    }
+
+    if _, err = s.DeleteTrackingData(
+        context.Background(),
+        url.Values{"vehicle_id": {vehicleID}, "mode": {repositories.DeletionModePurge}},
+        "admin",
+    ); err != nil || !trackingRepo.purge {
+        t.Errorf("expected a purge, got %v", err)
+    }
+
+    for _, query := range []url.Values{
+        {},
+        {"vehicle_id": {vehicleID}, "before": {"yesterday"}},
+        {"vehicle_id": {vehicleID}, "mode": {"hard"}},
+    } {
+        if _, err = s.DeleteTrackingData(context.Background(), query, "admin"); err == nil {
+            t.Errorf("expected %v to be rejected", query)
+        }
+    }
+    if len(auditRepo.audits) != 2 {
+        t.Errorf("expected rejected deletions not to be audited, got %d audits", len(auditRepo.audits))
+    }
+    if _, err = s.DeleteTrackingData(context.Background(), url.Values{}, "admin"); !errors.Is(err, ErrDeletionVehicleMissing) {
+        t.Errorf("expected ErrDeletionVehicleMissing, got %v", err)
+    }
+}
+
+func TestTrackingDeletionService_PlanDeletion(t *testing.T) {
+    trackingRepo := &fakeDeletingTrackingRepo{}
+    auditRepo := &fakeDeletionAuditRepo{}
+    s := NewMongoTrackingDeletionService(trackingRepo, auditRepo)
+
+    vehicleID := primitive.NewObjectID().Hex()
+    p, err := s.PlanDeletion(
+        context.Background(),
+        url.Values{"vehicle_id": {vehicleID}, "mode": {"purge"}, "before": {"2024-01-01T00:00:00Z"}},
+    )
+    if err != nil {
+        t.Fatal(err)
+    }
+    if p.Affected != 12000 || len(p.SampleIDs) != 1 || p.EstimatedSeconds != 3 {
+        t.Errorf("expected the scope of the repository, got %+v", p)
+    }
+    if p.Scope["vehicle_id"] != vehicleID || p.Scope["before"] != "2024-01-01T00:00:00Z" || !trackingRepo.purge {
+        t.Errorf("expected the parameters of the purge, got %+v", p.Scope)
+    }
+    if len(p.Warnings) != 1 || len(auditRepo.audits) != 0 {
+        t.Errorf("expected a warning and no audit, got %v and %d audits", p.Warnings, len(auditRepo.audits))
+    }
+
+    if _, err = s.PlanDeletion(context.Background(), url.Values{"vehicle_id": {vehicleID}, "mode": {"hard"}}); err == nil {
+        t.Error("expected the plan to validate the query like the deletion")
+    }
+}
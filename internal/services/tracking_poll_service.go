@@ -0,0 +1,186 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net/url"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+    defaultPollWait = 30 * time.Second
+    maxPollWait     = 60 * time.Second
+    // pollRecheckInterval is how often a waiting poll checks the database, readings stored by other
+    // instances of the service aren't notified
+    pollRecheckInterval = 5 * time.Second
+    pollLimit           = 100
+    maxPollVehicles     = 100
+)
+
+var (
+    ErrPollVehiclesMissing = errors.New("vehicle_id is required, pass up to 100 comma separated vehicle ids")
+    ErrInvalidPollCursor   = errors.New("invalid cursor")
+    ErrInvalidPollWait     = errors.New("invalid wait, expected a duration of at most 60s like 30s")
+)
+
+// TrackingPoll is the tracking data stored after the cursor, pass Cursor as since to get the next tracking data
+type TrackingPoll struct {
+    TrackingData []*repositories.TrackingRecord `json:"tracking_data"`
+    Cursor       string                         `json:"cursor"`
+}
+
+type TrackingPollService interface {
+    // Poll waits until tracking data of the vehicles is stored after the cursor or the wait expires
+    Poll(ctx context.Context, query url.Values) (*TrackingPoll, error)
+}
+
+type MongoTrackingPollService struct {
+    trackingRepo  repositories.TrackingRepository
+    notifier      *TrackingNotifier
+    accessService AccessService
+}
+
+func NewMongoTrackingPollService(
+    trackingRepo repositories.TrackingRepository,
+    notifier *TrackingNotifier,
+    accessService AccessService,
+) *MongoTrackingPollService {
+    return &MongoTrackingPollService{trackingRepo: trackingRepo, notifier: notifier, accessService: accessService}
+}
+
+// Poll returns right away with a cursor at the current time when since is missing, so clients only receive
+// tracking data stored after they started polling
+func (s *MongoTrackingPollService) Poll(ctx context.Context, query url.Values) (*TrackingPoll, error) {
+    var vehicleIDs []primitive.ObjectID
+    for _, value := range strings.Split(query.Get("vehicle_id"), ",") {
+        if value = strings.TrimSpace(value); value == "" {
+            continue
+        }
+        id, err := primitive.ObjectIDFromHex(value)
+        if err != nil {
+            return nil, repositories.ErrInvalidID
+        }
+        vehicleIDs = append(vehicleIDs, id)
+    }
+    if len(vehicleIDs) == 0 || len(vehicleIDs) > maxPollVehicles {
+        return nil, ErrPollVehiclesMissing
+    }
+    scope, err := s.accessService.VehicleScope(ctx)
+    if err != nil {
+        return nil, err
+    }
+    for _, vehicleID := range vehicleIDs {
+        if !scope.Allows(vehicleID) {
+            return nil, fmt.Errorf("%w: %s", ErrVehicleNotAllowed, vehicleID.Hex())
+        }
+    }
+
+    wait := defaultPollWait
+    if value := query.Get("wait"); value != "" {
+        parsed, err := time.ParseDuration(value)
+        if err != nil || parsed < 0 || parsed > maxPollWait {
+            return nil, ErrInvalidPollWait
+        }
+        wait = parsed
+    }
+
+    since := query.Get("since")
+    if since == "" {
+        cursor := primitive.NewObjectIDFromTimestamp(time.Now())
+        return &TrackingPoll{TrackingData: []*repositories.TrackingRecord{}, Cursor: cursor.Hex()}, nil
+    }
+    after, err := primitive.ObjectIDFromHex(since)
+    if err != nil {
+        return nil, ErrInvalidPollCursor
+    }
+
+    // subscribing before the first query, so tracking data stored in between isn't missed
+    notified, unsubscribe := s.notifier.Subscribe(vehicleIDs)
+    defer unsubscribe()
+
+    timeout := time.NewTimer(wait)
+    defer timeout.Stop()
+    recheck := time.NewTicker(pollRecheckInterval)
+    defer recheck.Stop()
+
+    for {
+        trackingData, err := s.trackingRepo.FindTrackingDataAfter(ctx, after, vehicleIDs, pollLimit)
+        if err != nil {
+            return nil, err
+        }
+        if len(trackingData) > 0 {
+            return &TrackingPoll{TrackingData: trackingData, Cursor: trackingData[len(trackingData)-1].ID.Hex()}, nil
+        }
+
+        select {
+        case <-notified:
+        case <-recheck.C:
+        case <-timeout.C:
+            return &TrackingPoll{TrackingData: []*repositories.TrackingRecord{}, Cursor: since}, nil
+        case <-ctx.Done():
+            return nil, ctx.Err()
+        }
+    }
+}
+
+// TrackingNotifier wakes up the polls waiting for a vehicle when its tracking data is stored.
+// It is registered as an ingestion processor.
+type TrackingNotifier struct {
+    mu          sync.Mutex
+    subscribers map[primitive.ObjectID]map[chan struct{}]bool
+}
+
+func NewTrackingNotifier() *TrackingNotifier {
+    return &TrackingNotifier{subscribers: map[primitive.ObjectID]map[chan struct{}]bool{}}
+}
+
+// Subscribe returns a channel receiving a value when tracking data of any of the vehicles is stored,
+// notifications are coalesced while the previous one wasn't received
+func (n *TrackingNotifier) Subscribe(vehicleIDs []primitive.ObjectID) (<-chan struct{}, func()) {
+    notified := make(chan struct{}, 1)
+    n.mu.Lock()
+    defer n.mu.Unlock()
+    for _, vehicleID := range vehicleIDs {
+        if n.subscribers[vehicleID] == nil {
+            n.subscribers[vehicleID] = map[chan struct{}]bool{}
+        }
+        n.subscribers[vehicleID][notified] = true
+    }
+
+    return notified, func() {
+        n.mu.Lock()
+        defer n.mu.Unlock()
+        for _, vehicleID := range vehicleIDs {
+            delete(n.subscribers[vehicleID], notified)
+            if len(n.subscribers[vehicleID]) == 0 {
+                delete(n.subscribers, vehicleID)
+            }
+        }
+    }
+}
+
+func (n *TrackingNotifier) Notify(vehicleID primitive.ObjectID) {
+    n.mu.Lock()
+    defer n.mu.Unlock()
+    for notified := range n.subscribers[vehicleID] {
+        select {
+        case notified <- struct{}{}:
+        default:
+        }
+    }
+}
+
+func (n *TrackingNotifier) PreValidate(context.Context, *TrackingDataRequest) error {
+    return nil
+}
+
+func (n *TrackingNotifier) PostPersist(_ context.Context, record *repositories.TrackingRecord) error {
+    n.Notify(record.VehicleID)
+    return nil
+}
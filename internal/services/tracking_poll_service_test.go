@@ -0,0 +1,35 @@
+package services
+
+import (
+    "testing"
+    "time"
+
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestTrackingNotifier(t *testing.T) {
+    notifier := NewTrackingNotifier()
+    vehicleID, otherID := primitive.NewObjectID(), primitive.NewObjectID()
+
+    notified, unsubscribe := notifier.Subscribe([]primitive.ObjectID{vehicleID})
+    notifier.Notify(otherID)
+    select {
+    case <-notified:
+        t.Fatal("Should not be notified of other vehicles")
+    default:
+    }
+
+    // notifications are coalesced, notifying twice doesn't block
+    notifier.Notify(vehicleID)
+    notifier.Notify(vehicleID)
+    select {
+    case <-notified:
+    case <-time.After(time.Second):
+        t.Fatal("Should be notified of the subscribed vehicle")
+    }
+
+    unsubscribe()
+    if len(notifier.subscribers) != 0 {
+        t.Fatalf("Should remove the subscription, got %d vehicles", len(notifier.subscribers))
+    }
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+    "errors"
+    "fmt"
+    "net/url"
+    "strings"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+)
+
+var (
+    ErrRecordedAtLive   = errors.New("recorded_at is only accepted for backfilled readings")
+    ErrRecordedAtFuture = errors.New("recorded_at must not be in the future")
+    ErrDriverIDTooLong  = errors.New("driver_id must be at most 64 characters")
+)
+
+// csvNumberColumns are the columns of a CSV reading holding numbers, the others hold strings
+var csvNumberColumns = []string{"mileage", "lat", "lng"}
+
+// TrackingDataRequest is an incoming tracking data reading. It embeds the shared models.TrackingDataRequest,
+// so existing payloads keep working, and accepts the optional fields only this service uses.
+type TrackingDataRequest struct {
+    models.TrackingDataRequest
+
+    Lat *float64 `json:"lat" validate:"required_with=Lng,omitempty,latitude"`
+    Lng *float64 `json:"lng" validate:"required_with=Lat,omitempty,longitude"`
+
+    // DriverID is the driver of the vehicle, readings without one get the driver assigned to the vehicle
+    DriverID string `json:"driver_id,omitempty" validate:"omitempty,max=64"`
+
+    // Sensors are the values of the additional sensors of the vehicle by name, numbers, booleans or short texts
+    Sensors repositories.Sensors `json:"sensors,omitempty"`
+
+    // Backfill marks a reading sent late from the buffer of a device or imported from another system,
+    // analytical queries can exclude it
+    Backfill bool `json:"backfill,omitempty"`
+    // RecordedAt is when a backfilled reading was taken, it is stored with that time instead of the time it is
+    // received
+    RecordedAt *time.Time `json:"recorded_at,omitempty"`
+
+    // DeviceTime is when the device took the reading by its own clock, it is stored with the reading and the live
+    // readings are stored at it when the clock skew correction trusts the clock
+    DeviceTime *time.Time `json:"device_time,omitempty"`
+
+    // suspect flags the reading for failing the data quality checks
+    suspect bool
+    // receivedAt is when the reading reached the broker or this service, the time it is converted when zero
+    receivedAt time.Time
+    // atDeviceTime stores the reading at its device time instead of the time it is received
+    atDeviceTime bool
+    // skewed flags the reading for the clock of its device being off by more than the tolerance
+    skewed bool
+}
+
+// DecodeCSVReading decodes a reading from the columns of a CSV row by the names of the header, the columns are named
+// like the fields of the JSON readings. Empty columns are left out and backfill is ignored, the importer flags the
+// readings itself.
+func DecodeCSVReading(columns map[string]string) (*TrackingDataRequest, error) {
+    query := url.Values{}
+    for column, value := range columns {
+        if value = strings.TrimSpace(value); value != "" && column != "backfill" {
+            query.Set(column, value)
+        }
+    }
+    var req TrackingDataRequest
+    if err := decodeQuery(query, &req, csvNumberColumns...); err != nil {
+        return nil, fmt.Errorf("%w: %w", ErrMalformedPayload, err)
+    }
+    return &req, nil
+}
+
+// coordinatesLocation is the location of a reading named by its coordinates instead of an address
+func coordinatesLocation(lat, lng float64) string {
+    return fmt.Sprintf("%.5f,%.5f", lat, lng)
+}
+
+// ToTrackingRecord validates the request and converts it to the stored record
+func (r *TrackingDataRequest) ToTrackingRecord() (*repositories.TrackingRecord, error) {
+    if r.Location == "" && r.Lat != nil && r.Lng != nil {
+        // the readings located by their coordinates only, like the ones of API v2, are named by the coordinates
+        r.Location = coordinatesLocation(*r.Lat, *r.Lng)
+    }
+    if err := r.Validate(); err != nil {
+        return nil, err
+    }
+    if r.RecordedAt != nil && !r.Backfill {
+        return nil, ErrRecordedAtLive
+    }
+    if r.RecordedAt != nil && r.RecordedAt.After(time.Now()) {
+        return nil, ErrRecordedAtFuture
+    }
+    if len(r.DriverID) > repositories.MaxDriverIDLength {
+        return nil, ErrDriverIDTooLong
+    }
+    if err := r.Sensors.Validate(); err != nil {
+        return nil, err
+    }
+    trackingData, err := r.ToTrackingData()
+    if err != nil {
+        return nil, err
+    }
+    record := repositories.NewTrackingRecord(trackingData)
+    if r.Lat != nil && r.Lng != nil {
+        record.SetPosition(*r.Lat, *r.Lng)
+    }
+    record.DriverID = r.DriverID
+    if len(r.Sensors) > 0 {
+        record.Sensors = r.Sensors
+    }
+    if r.Backfill {
+        record.AddFlag(repositories.FlagBackfill)
+    }
+    if r.suspect {
+        record.AddFlag(repositories.FlagSuspect)
+    }
+    if r.skewed {
+        record.AddFlag(repositories.FlagSkewed)
+    }
+    receivedAt := r.receivedAt
+    if receivedAt.IsZero() {
+        receivedAt = time.Now()
+    }
+    record.ReceivedTime = timestamp.Ptr(receivedAt.UTC())
+    if r.DeviceTime != nil {
+        record.DeviceTime = timestamp.Ptr(r.DeviceTime.UTC())
+    }
+    switch {
+    case r.RecordedAt != nil:
+        record.CreatedAt = r.RecordedAt.UTC()
+    case r.atDeviceTime && r.DeviceTime != nil:
+        record.CreatedAt = r.DeviceTime.UTC()
+    default:
+        record.CreatedAt = receivedAt.UTC()
+    }
+    record.UpdatedAt = record.CreatedAt
+    return record, nil
+}
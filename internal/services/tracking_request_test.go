@@ -0,0 +1,126 @@
+package services
+
+import (
+    "errors"
+    "slices"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+)
+
+func TestDecodeCSVReading(t *testing.T) {
+    req, err := DecodeCSVReading(
+        map[string]string{
+            "vehicle_id":  "6650c3e0f1a2b3c4d5e6f7a8",
+            "mileage":     " 1200.5",
+            "lat":         "16.8",
+            "lng":         "96.1",
+            "recorded_at": "2024-01-02T03:04:05Z",
+            "backfill":    "false",
+            "status":      "",
+        },
+    )
+    if err != nil {
+        t.Fatal(err)
+    }
+    if req.VehicleID != "6650c3e0f1a2b3c4d5e6f7a8" || req.Mileage != 1200.5 || req.Lat == nil || *req.Lat != 16.8 ||
+        req.RecordedAt == nil || req.RecordedAt.Year() != 2024 {
+        t.Errorf("expected the columns to be decoded, got %+v", req)
+    }
+    if _, err = DecodeCSVReading(map[string]string{"mileage": "far"}); !errors.Is(err, ErrMalformedPayload) {
+        t.Errorf("expected a malformed payload, got %v", err)
+    }
+}
+
+func TestTrackingDataRequest_RecordedAt(t *testing.T) {
+    recordedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("MMT", 6*3600+1800))
+    req := vehicleReading("6650c3e0f1a2b3c4d5e6f7a8")
+    req.RecordedAt = &recordedAt
+    if _, err := req.ToTrackingRecord(); !errors.Is(err, ErrRecordedAtLive) {
+        t.Errorf("expected recorded_at to be rejected for a live reading, got %v", err)
+    }
+
+    req.Backfill = true
+    record, err := req.ToTrackingRecord()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !record.CreatedAt.Equal(recordedAt) || record.CreatedAt.Location() != time.UTC ||
+        !slices.Contains(record.Flags, repositories.FlagBackfill) {
+        t.Errorf("expected the backfilled reading at its recorded time, got %v, %v", record.CreatedAt, record.Flags)
+    }
+
+    future := time.Now().Add(time.Hour)
+    req.RecordedAt = &future
+    if _, err = req.ToTrackingRecord(); !errors.Is(err, ErrRecordedAtFuture) {
+        t.Errorf("expected a future recorded_at to be rejected, got %v", err)
+    }
+}
+
+func TestTrackingDataRequest_DriverID(t *testing.T) {
+    req := vehicleReading("6650c3e0f1a2b3c4d5e6f7a8")
+    req.DriverID = "driver-1"
+    record, err := req.ToTrackingRecord()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if record.DriverID != "driver-1" {
+        t.Errorf("expected the driver of the reading, got %q", record.DriverID)
+    }
+
+    req.DriverID = strings.Repeat("d", repositories.MaxDriverIDLength)
+    if _, err = req.ToTrackingRecord(); err != nil {
+        t.Errorf("expected a driver id of the maximum length to be accepted, got %v", err)
+    }
+    req.DriverID += "d"
+    if _, err = req.ToTrackingRecord(); !errors.Is(err, ErrDriverIDTooLong) {
+        t.Errorf("expected a too long driver id to be rejected, got %v", err)
+    }
+}
+
+func TestTrackingDataRequest_Sensors(t *testing.T) {
+    req := vehicleReading("6650c3e0f1a2b3c4d5e6f7a8")
+    req.Sensors = repositories.Sensors{"temperature": -18.5, "door_open": false, "door_status": "closed"}
+    record, err := req.ToTrackingRecord()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if record.Sensors["temperature"] != -18.5 || record.Sensors["door_open"] != false ||
+        record.Sensors["door_status"] != "closed" {
+        t.Errorf("expected the sensors of the reading, got %v", record.Sensors)
+    }
+
+    for _, sensors := range []repositories.Sensors{
+        {"Temperature": 1.0},
+        {"temperature": []any{1.0}},
+        {"door_status": strings.Repeat("x", repositories.MaxSensorTextLength+1)},
+    } {
+        req.Sensors = sensors
+        if _, err = req.ToTrackingRecord(); !errors.Is(err, repositories.ErrInvalidSensors) {
+            t.Errorf("expected the sensors %v to be rejected, got %v", sensors, err)
+        }
+    }
+}
+
+func TestTrackingDataRequest_CoordinatesLocation(t *testing.T) {
+    lat, lng := 16.8, 96.15
+    req := vehicleReading("6650c3e0f1a2b3c4d5e6f7a8")
+    req.Location = ""
+    req.Lat, req.Lng = &lat, &lng
+    if _, err := req.ToTrackingRecord(); err != nil {
+        t.Fatal(err)
+    }
+    if req.Location != "16.80000,96.15000" {
+        t.Errorf("expected the reading without a location to be named by its coordinates, got %q", req.Location)
+    }
+
+    req.Location = "Depot"
+    if _, err := req.ToTrackingRecord(); err != nil {
+        t.Fatal(err)
+    }
+    if req.Location != "Depot" {
+        t.Errorf("expected the location of the reading to be kept, got %q", req.Location)
+    }
+}
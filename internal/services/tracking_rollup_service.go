@@ -0,0 +1,589 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "log"
+    "maps"
+    "net/url"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/geo"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+    // rollupLookback is how long before the watermark every pass rolls up again, for the readings arriving late
+    rollupLookback = 10 * time.Minute
+    // rollupChunk is the longest period a pass rolls up at once while catching up
+    rollupChunk = 24 * time.Hour
+    // rollupStep is the length of the shortest rollups, the periods are rolled up in steps of it
+    rollupStep = 5 * time.Minute
+)
+
+var (
+    ErrInvalidRouteResolution = errors.New("invalid resolution, it must be raw, 5m, 1h or auto")
+)
+
+type TrackingRollupService interface {
+    // RollUp rolls up the readings from the watermark until now minus the delay, and the periods before the
+    // watermark that were invalidated since the last pass
+    RollUp(ctx context.Context, now time.Time) error
+    // Invalidate marks the periods of the vehicle between from and to, inclusive, to be rolled up again
+    Invalidate(ctx context.Context, vehicleID primitive.ObjectID, from, to time.Time)
+    // Watermark returns the time the readings are rolled up until, zero before the first pass
+    Watermark() time.Time
+    // FindRollups returns the rollups of the vehicles, ErrVehicleNotAllowed for a vehicle_id out of scope
+    FindRollups(ctx context.Context, query url.Values) ([]*repositories.TrackingRollup, error)
+}
+
+// rollupScope is a vehicle of a tenant, the periods invalidated before the watermark are tracked per vehicle
+type rollupScope struct {
+    tenantID  string
+    scoped    bool
+    vehicleID primitive.ObjectID
+}
+
+// rollupRange is a time range to roll up again, both ends inclusive
+type rollupRange struct {
+    from, to time.Time
+}
+
+// MongoTrackingRollupService computes the rollups from the raw readings of any storage backend. The 5 minute
+// rollups are computed from the readings, the hourly ones from the 5 minute rollups of their hour.
+type MongoTrackingRollupService struct {
+    trackingRepo  repositories.TrackingRepository
+    rollupRepo    repositories.TrackingRollupRepository
+    accessService AccessService
+    // delay gives late readings some time to arrive before a period is rolled up
+    delay time.Duration
+
+    mu        sync.Mutex
+    watermark time.Time
+    dirty     map[rollupScope]rollupRange
+}
+
+func NewMongoTrackingRollupService(
+    trackingRepo repositories.TrackingRepository,
+    rollupRepo repositories.TrackingRollupRepository,
+    accessService AccessService,
+    delay time.Duration,
+) *MongoTrackingRollupService {
+    return &MongoTrackingRollupService{
+        trackingRepo:  trackingRepo,
+        rollupRepo:    rollupRepo,
+        accessService: accessService,
+        delay:         delay,
+        dirty:         map[rollupScope]rollupRange{},
+    }
+}
+
+// RollUp starts from the oldest reading on the first pass and rolls up a day at a time until it caught up, the
+// watermark is saved after every day so a restart carries on from there. The rollups of every tenant are computed.
+func (s *MongoTrackingRollupService) RollUp(ctx context.Context, now time.Time) error {
+    watermark, err := s.rollupRepo.FindWatermark(ctx)
+    if err != nil {
+        return err
+    }
+    cutoff := now.UTC().Add(-s.delay).Truncate(rollupStep)
+    if watermark.IsZero() {
+        if watermark, err = s.oldestReading(ctx, cutoff); err != nil {
+            return err
+        }
+    }
+    s.setWatermark(watermark)
+
+    if err := s.rollUpDirty(ctx); err != nil {
+        return err
+    }
+    for watermark.Before(cutoff) {
+        from := watermark.Add(-rollupLookback).Truncate(rollupStep)
+        to := watermark.Add(rollupChunk)
+        if to.After(cutoff) {
+            to = cutoff
+        }
+        if err := s.rollUpRange(ctx, primitive.NilObjectID, from, to); err != nil {
+            return err
+        }
+        if err := s.rollupRepo.SaveWatermark(ctx, to); err != nil {
+            return err
+        }
+        watermark = to
+        s.setWatermark(watermark)
+    }
+    return nil
+}
+
+// oldestReading returns the start of the hour of the oldest reading, cutoff when there are no readings yet
+func (s *MongoTrackingRollupService) oldestReading(ctx context.Context, cutoff time.Time) (time.Time, error) {
+    filter := &repositories.TrackingFilter{
+        SortField: "created_at",
+        SortOrder: "asc",
+        PageSize:  1,
+        Exclude:   repositories.ExcludeNone,
+    }
+    if err := filter.Build(); err != nil {
+        return time.Time{}, err
+    }
+    records, err := s.trackingRepo.FindTrackingData(ctx, filter)
+    if err != nil {
+        return time.Time{}, err
+    }
+    if len(records) == 0 {
+        return cutoff, nil
+    }
+    return records[0].CreatedAt.UTC().Truncate(time.Hour), nil
+}
+
+// rollUpDirty rolls up again the invalidated periods of every vehicle, with the tenant of the vehicle. The periods
+// that fail are kept for the next pass.
+func (s *MongoTrackingRollupService) rollUpDirty(ctx context.Context) error {
+    s.mu.Lock()
+    dirty := s.dirty
+    s.dirty = map[rollupScope]rollupRange{}
+    s.mu.Unlock()
+
+    var failed error
+    for scope, period := range dirty {
+        vehicleCtx := ctx
+        if scope.scoped {
+            vehicleCtx = tenant.WithID(ctx, scope.tenantID)
+        }
+        from := period.from.Truncate(rollupStep)
+        to := period.to.Truncate(rollupStep).Add(rollupStep)
+        if err := s.rollUpRange(vehicleCtx, scope.vehicleID, from, to); err != nil {
+            s.invalidate(scope, period)
+            failed = err
+        }
+    }
+    return failed
+}
+
+// rollUpRange computes the 5 minute rollups of [from, to) from the readings and then the hourly rollups of the
+// hours they belong to. Without a vehicle, the rollups of every vehicle are computed.
+func (s *MongoTrackingRollupService) rollUpRange(
+    ctx context.Context,
+    vehicleID primitive.ObjectID,
+    from, to time.Time,
+) error {
+    vehicle := ""
+    if !vehicleID.IsZero() {
+        vehicle = vehicleID.Hex()
+    }
+    filter := &repositories.TrackingFilter{
+        VehicleID: vehicle,
+        SortField: "vehicle_id,created_at",
+        SortOrder: "asc",
+        From:      from.Format(time.RFC3339),
+        To:        to.Format(time.RFC3339),
+    }
+    if err := filter.Build(); err != nil {
+        return err
+    }
+    fiveMinutes := newRollupBuilder(repositories.RollupFiveMinutes)
+    err := s.trackingRepo.StreamTrackingData(
+        ctx, filter, func(record *repositories.TrackingRecord) error {
+            fiveMinutes.addReading(record)
+            return nil
+        },
+    )
+    if err != nil {
+        return err
+    }
+    err = s.rollupRepo.ReplaceRollups(
+        ctx,
+        rollupFilter(vehicle, from, to, repositories.RollupFiveMinutes),
+        fiveMinutes.result(),
+    )
+    if err != nil {
+        return err
+    }
+
+    hourFrom, hourTo := from.Truncate(time.Hour), to.Truncate(time.Hour)
+    if hourTo.Before(to) {
+        hourTo = hourTo.Add(time.Hour)
+    }
+    hourly := newRollupBuilder(repositories.RollupHourly)
+    err = s.rollupRepo.StreamRollups(
+        ctx,
+        rollupFilter(vehicle, hourFrom, hourTo, repositories.RollupFiveMinutes),
+        func(rollup *repositories.TrackingRollup) error {
+            hourly.addRollup(rollup)
+            return nil
+        },
+    )
+    if err != nil {
+        return err
+    }
+    return s.rollupRepo.ReplaceRollups(
+        ctx,
+        rollupFilter(vehicle, hourFrom, hourTo, repositories.RollupHourly),
+        hourly.result(),
+    )
+}
+
+func rollupFilter(
+    vehicleID string,
+    from, to time.Time,
+    resolution repositories.RollupResolution,
+) *repositories.TrackingRollupFilter {
+    return &repositories.TrackingRollupFilter{
+        VehicleID:  vehicleID,
+        From:       from.Format(time.RFC3339),
+        To:         to.Format(time.RFC3339),
+        Resolution: string(resolution),
+    }
+}
+
+// Invalidate only tracks the periods before the watermark minus the lookback, the next pass rolls up the later
+// ones anyway
+func (s *MongoTrackingRollupService) Invalidate(
+    ctx context.Context,
+    vehicleID primitive.ObjectID,
+    from, to time.Time,
+) {
+    id, scoped := tenant.FromContext(ctx)
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.watermark.IsZero() || !from.Before(s.watermark.Add(-rollupLookback).Truncate(rollupStep)) {
+        return
+    }
+    s.invalidateLocked(rollupScope{tenantID: id, scoped: scoped, vehicleID: vehicleID}, rollupRange{from, to})
+}
+
+func (s *MongoTrackingRollupService) invalidate(scope rollupScope, period rollupRange) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.invalidateLocked(scope, period)
+}
+
+// invalidateLocked widens the invalidated range of the vehicle to cover period, s.mu must be held
+func (s *MongoTrackingRollupService) invalidateLocked(scope rollupScope, period rollupRange) {
+    if current, ok := s.dirty[scope]; ok {
+        if current.from.Before(period.from) {
+            period.from = current.from
+        }
+        if current.to.After(period.to) {
+            period.to = current.to
+        }
+    }
+    s.dirty[scope] = period
+}
+
+func (s *MongoTrackingRollupService) Watermark() time.Time {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.watermark
+}
+
+func (s *MongoTrackingRollupService) setWatermark(watermark time.Time) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.watermark = watermark
+}
+
+func (s *MongoTrackingRollupService) FindRollups(
+    ctx context.Context,
+    query url.Values,
+) ([]*repositories.TrackingRollup, error) {
+    var filter repositories.TrackingRollupFilter
+    if err := decodeQuery(query, &filter); err != nil {
+        return nil, err
+    }
+    if err := filter.Build(); err != nil {
+        return nil, err
+    }
+    scope, err := s.accessService.VehicleScope(ctx)
+    if err != nil {
+        return nil, err
+    }
+    if filter.VehicleID != "" && !scope.Allows(filter.VehicleObjID()) {
+        return nil, ErrVehicleNotAllowed
+    }
+    scope.Restrict(&filter)
+    return s.rollupRepo.FindRollups(ctx, &filter)
+}
+
+// rollupBuilder summarizes readings, or the rollups of a shorter resolution, ordered by vehicle and time into the
+// periods of its resolution
+type rollupBuilder struct {
+    resolution repositories.RollupResolution
+    rollups    []*repositories.TrackingRollup
+}
+
+func newRollupBuilder(resolution repositories.RollupResolution) *rollupBuilder {
+    return &rollupBuilder{resolution: resolution, rollups: []*repositories.TrackingRollup{}}
+}
+
+// period returns the rollup of the vehicle and period starting at start, the readings of a period come one after
+// the other so only the last rollup can be it
+func (b *rollupBuilder) period(
+    tenantID string,
+    vehicleID primitive.ObjectID,
+    start time.Time,
+) *repositories.TrackingRollup {
+    if n := len(b.rollups); n > 0 {
+        last := b.rollups[n-1]
+        if last.TenantID == tenantID && last.VehicleID == vehicleID && last.Start.Equal(start) {
+            return last
+        }
+    }
+    rollup := &repositories.TrackingRollup{
+        VehicleID: vehicleID,
+        TenantID:  tenantID,
+        Start:     timestamp.New(start),
+    }
+    b.rollups = append(b.rollups, rollup)
+    return rollup
+}
+
+func (b *rollupBuilder) addReading(record *repositories.TrackingRecord) {
+    at := record.CreatedAt.UTC()
+    rollup := b.period(record.TenantID, record.VehicleID, at.Truncate(b.resolution.Duration()))
+    if rollup.Readings == 0 {
+        rollup.FirstSeen = timestamp.New(at)
+    }
+    rollup.Readings++
+    rollup.LastSeen = timestamp.New(at)
+    if point, ok := record.Point(); ok {
+        addPosition(rollup, point, point, 0, 1)
+    }
+    rollup.AvgSpeedKmh = averageSpeed(rollup)
+}
+
+func (b *rollupBuilder) addRollup(source *repositories.TrackingRollup) {
+    rollup := b.period(source.TenantID, source.VehicleID, source.Start.Truncate(b.resolution.Duration()))
+    if rollup.Readings == 0 {
+        rollup.FirstSeen = source.FirstSeen
+    }
+    rollup.Readings += source.Readings
+    rollup.LastSeen = source.LastSeen
+    if first, last, ok := rollupPositions(source); ok {
+        addPosition(rollup, first, last, source.DistanceMeters, source.Points)
+    }
+    rollup.AvgSpeedKmh = averageSpeed(rollup)
+}
+
+// addPosition extends the path of the rollup with a path from first to last of length distance
+func addPosition(rollup *repositories.TrackingRollup, first, last geo.Point, distance float64, points int64) {
+    if rollup.Points == 0 {
+        rollup.FirstLat, rollup.FirstLng = &first.Lat, &first.Lng
+    } else {
+        previous := geo.Point{Lat: *rollup.Lat, Lng: *rollup.Lng}
+        rollup.DistanceMeters += geo.Haversine(previous, first)
+    }
+    rollup.DistanceMeters += distance
+    rollup.Points += points
+    rollup.Lat, rollup.Lng = &last.Lat, &last.Lng
+}
+
+// rollupPositions returns the first and the last position of the rollup, false without positions
+func rollupPositions(rollup *repositories.TrackingRollup) (geo.Point, geo.Point, bool) {
+    if rollup.FirstLat == nil || rollup.FirstLng == nil || rollup.Lat == nil || rollup.Lng == nil {
+        return geo.Point{}, geo.Point{}, false
+    }
+    first := geo.Point{Lat: *rollup.FirstLat, Lng: *rollup.FirstLng, Time: rollup.FirstSeen.Time}
+    last := geo.Point{Lat: *rollup.Lat, Lng: *rollup.Lng, Time: rollup.LastSeen.Time}
+    return first, last, true
+}
+
+func averageSpeed(rollup *repositories.TrackingRollup) float64 {
+    elapsed := rollup.LastSeen.Sub(rollup.FirstSeen.Time)
+    if elapsed <= 0 {
+        return 0
+    }
+    return rollup.DistanceMeters / elapsed.Seconds() * 3.6
+}
+
+func (b *rollupBuilder) result() []*repositories.TrackingRollup {
+    return b.rollups
+}
+
+// TrackingRollupScheduler rolls up the readings every interval
+type TrackingRollupScheduler struct {
+    rollupService TrackingRollupService
+    interval      time.Duration
+}
+
+func NewTrackingRollupScheduler(rollupService TrackingRollupService, interval time.Duration) *TrackingRollupScheduler {
+    return &TrackingRollupScheduler{rollupService: rollupService, interval: interval}
+}
+
+// Run blocks until ctx is done, rolling up right away and then every interval
+func (s *TrackingRollupScheduler) Run(ctx context.Context) {
+    ticker := time.NewTicker(s.interval)
+    defer ticker.Stop()
+    for {
+        if err := s.rollupService.RollUp(ctx, time.Now()); err != nil && ctx.Err() == nil {
+            log.Println("Failed to roll up the tracking data: ", err)
+        }
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// RollupRoutingTrackingService serves the routes over wide time ranges from the rollups, so their cost depends on
+// the number of periods rather than of readings. The path has the last position of every period until the
+// watermark and the raw readings after it. The rollups are picked with the resolution query parameter: raw, 5m,
+// 1h or auto, which picks them from the length of the time range. Auto reads the raw readings when the query
+// excludes readings explicitly, the rollups only leave out the readings excluded by default.
+type RollupRoutingTrackingService struct {
+    TrackingService
+    rollupService TrackingRollupService
+    // fiveMinutesAfter and hourlyAfter are the shortest time ranges auto reads the 5m and 1h rollups for
+    fiveMinutesAfter time.Duration
+    hourlyAfter      time.Duration
+    now              func() time.Time
+}
+
+func NewRollupRoutingTrackingService(
+    trackingService TrackingService,
+    rollupService TrackingRollupService,
+    fiveMinutesAfter time.Duration,
+    hourlyAfter time.Duration,
+) *RollupRoutingTrackingService {
+    return &RollupRoutingTrackingService{
+        TrackingService:  trackingService,
+        rollupService:    rollupService,
+        fiveMinutesAfter: fiveMinutesAfter,
+        hourlyAfter:      hourlyAfter,
+        now:              time.Now,
+    }
+}
+
+func (s *RollupRoutingTrackingService) FindRoute(ctx context.Context, query url.Values) (*Route, error) {
+    from, to, err := routeTimeRange(query)
+    if err != nil {
+        return nil, err
+    }
+    resolution, err := s.routeResolution(query, from, to)
+    if err != nil {
+        return nil, err
+    }
+    watermark := s.rollupService.Watermark()
+    if resolution == "" || watermark.IsZero() || (!from.IsZero() && !from.Before(watermark)) {
+        return s.TrackingService.FindRoute(ctx, query)
+    }
+    vehicleID := query.Get("vehicle_id")
+    if vehicleID == "" || strings.Contains(vehicleID, ",") {
+        return nil, ErrRouteVehicleMissing
+    }
+    maxPoints, err := routeMaxPoints(query)
+    if err != nil {
+        return nil, err
+    }
+
+    rollupTo := watermark
+    if !to.IsZero() && to.Before(rollupTo) {
+        rollupTo = to
+    }
+    rollupQuery := url.Values{
+        "vehicle_id": {vehicleID},
+        "to":         {rollupTo.Format(time.RFC3339)},
+        "resolution": {string(resolution)},
+    }
+    if !from.IsZero() {
+        rollupQuery.Set("from", from.Truncate(resolution.Duration()).Format(time.RFC3339))
+    }
+    rollups, err := s.rollupService.FindRollups(ctx, rollupQuery)
+    if err != nil {
+        return nil, err
+    }
+
+    route := &Route{VehicleID: vehicleID, Resolution: string(resolution), Path: []geo.Point{}}
+    if !from.IsZero() {
+        route.From = timestamp.Ptr(from)
+    }
+    if !to.IsZero() {
+        route.To = timestamp.Ptr(to)
+    }
+    for _, rollup := range rollups {
+        first, last, ok := rollupPositions(rollup)
+        if !ok {
+            continue
+        }
+        route.join(first, []geo.Point{last}, rollup.DistanceMeters, int(rollup.Points))
+    }
+    if to.IsZero() || to.After(watermark) {
+        tailQuery := maps.Clone(query)
+        tailQuery.Set("from", watermark.Format(time.RFC3339))
+        tailQuery.Del("max_points")
+        tail, err := s.TrackingService.FindRoute(ctx, tailQuery)
+        if err != nil {
+            return nil, err
+        }
+        if len(tail.Path) > 0 {
+            route.join(tail.Path[0], tail.Path, tail.DistanceMeters, tail.TotalPoints)
+        }
+    }
+    route.simplify(maxPoints)
+    return route, nil
+}
+
+// join appends a part of the route starting at first, the distance from the end of the route so far to first is
+// added to the distance of the part
+func (r *Route) join(first geo.Point, path []geo.Point, distance float64, points int) {
+    if len(r.Path) > 0 {
+        r.DistanceMeters += geo.Haversine(r.Path[len(r.Path)-1], first)
+    }
+    r.DistanceMeters += distance
+    r.TotalPoints += points
+    r.Path = append(r.Path, path...)
+}
+
+// routeResolution returns the resolution of the rollups the route is read from, empty for the raw readings
+func (s *RollupRoutingTrackingService) routeResolution(
+    query url.Values,
+    from, to time.Time,
+) (repositories.RollupResolution, error) {
+    switch resolution := query.Get("resolution"); resolution {
+    case "raw":
+        return "", nil
+    case string(repositories.RollupFiveMinutes), string(repositories.RollupHourly):
+        return repositories.RollupResolution(resolution), nil
+    case "", "auto":
+    default:
+        return "", ErrInvalidRouteResolution
+    }
+    if query.Get("exclude") != "" {
+        return "", nil
+    }
+    if to.IsZero() {
+        to = s.now()
+    }
+    switch {
+    case from.IsZero() || to.Sub(from) >= s.hourlyAfter:
+        return repositories.RollupHourly, nil
+    case to.Sub(from) >= s.fiveMinutesAfter:
+        return repositories.RollupFiveMinutes, nil
+    }
+    return "", nil
+}
+
+// routeTimeRange returns the from and to of the route query, zero when they are missing
+func routeTimeRange(query url.Values) (time.Time, time.Time, error) {
+    var from, to time.Time
+    var err error
+    if value := query.Get("from"); value != "" {
+        if from, err = time.Parse(time.RFC3339, value); err != nil {
+            return from, to, repositories.ErrInvalidTimeRange
+        }
+    }
+    if value := query.Get("to"); value != "" {
+        if to, err = time.Parse(time.RFC3339, value); err != nil {
+            return from, to, repositories.ErrInvalidTimeRange
+        }
+    }
+    if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+        return from, to, repositories.ErrInvalidTimeRange
+    }
+    return from, to, nil
+}
@@ -0,0 +1,359 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "math"
+    "net/url"
+    "slices"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/geo"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeRollupRepo keeps the rollups per resolution in memory, ordered by vehicle and period
+type fakeRollupRepo struct {
+    rollups   map[repositories.RollupResolution][]*repositories.TrackingRollup
+    watermark time.Time
+}
+
+func newFakeRollupRepo() *fakeRollupRepo {
+    return &fakeRollupRepo{rollups: map[repositories.RollupResolution][]*repositories.TrackingRollup{}}
+}
+
+// within reports whether the rollup is one of the vehicle and periods of the built filter
+func within(filter *repositories.TrackingRollupFilter, rollup *repositories.TrackingRollup) bool {
+    from, _ := time.Parse(time.RFC3339, filter.From)
+    to, _ := time.Parse(time.RFC3339, filter.To)
+    return (filter.VehicleID == "" || rollup.VehicleID == filter.VehicleObjID()) &&
+        (from.IsZero() || !rollup.Start.Before(from)) &&
+        (to.IsZero() || rollup.Start.Before(to))
+}
+
+func (r *fakeRollupRepo) ReplaceRollups(
+    _ context.Context,
+    filter *repositories.TrackingRollupFilter,
+    rollups []*repositories.TrackingRollup,
+) error {
+    if err := filter.Build(); err != nil {
+        return err
+    }
+    resolution := filter.ResolutionValue()
+    kept := slices.DeleteFunc(
+        r.rollups[resolution], func(rollup *repositories.TrackingRollup) bool {
+            return within(filter, rollup)
+        },
+    )
+    kept = append(kept, rollups...)
+    slices.SortFunc(
+        kept, func(a, b *repositories.TrackingRollup) int {
+            if c := strings.Compare(a.VehicleID.Hex(), b.VehicleID.Hex()); c != 0 {
+                return c
+            }
+            return a.Start.Compare(b.Start.Time)
+        },
+    )
+    r.rollups[resolution] = kept
+    return nil
+}
+
+func (r *fakeRollupRepo) FindRollups(
+    ctx context.Context,
+    filter *repositories.TrackingRollupFilter,
+) ([]*repositories.TrackingRollup, error) {
+    rollups := []*repositories.TrackingRollup{}
+    err := r.StreamRollups(
+        ctx, filter, func(rollup *repositories.TrackingRollup) error {
+            rollups = append(rollups, rollup)
+            return nil
+        },
+    )
+    return rollups, err
+}
+
+func (r *fakeRollupRepo) StreamRollups(
+    _ context.Context,
+    filter *repositories.TrackingRollupFilter,
+    fn func(rollup *repositories.TrackingRollup) error,
+) error {
+    if err := filter.Build(); err != nil {
+        return err
+    }
+    for _, rollup := range r.rollups[filter.ResolutionValue()] {
+        if within(filter, rollup) {
+            if err := fn(rollup); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+func (r *fakeRollupRepo) DeleteRollups(_ context.Context, vehicleID primitive.ObjectID, before time.Time) error {
+    for resolution, rollups := range r.rollups {
+        r.rollups[resolution] = slices.DeleteFunc(
+            rollups, func(rollup *repositories.TrackingRollup) bool {
+                return rollup.VehicleID == vehicleID && (before.IsZero() || rollup.Start.Before(before))
+            },
+        )
+    }
+    return nil
+}
+
+func (r *fakeRollupRepo) FindWatermark(context.Context) (time.Time, error) {
+    return r.watermark, nil
+}
+
+func (r *fakeRollupRepo) SaveWatermark(_ context.Context, at time.Time) error {
+    if at.After(r.watermark) {
+        r.watermark = at
+    }
+    return nil
+}
+
+func positionedRecord(
+    vehicleID primitive.ObjectID,
+    createdAt time.Time,
+    lat, lng float64,
+) *repositories.TrackingRecord {
+    record := repositories.NewTrackingRecord(
+        &models.TrackingData{
+            VehicleID:     vehicleID,
+            Location:      "Yangon",
+            Mileage:       1200,
+            Status:        models.VehicleStatusActive,
+            FuelCondition: models.FuelConditionFull,
+            CreatedAt:     createdAt,
+        },
+    )
+    record.Lat, record.Lng = &lat, &lng
+    return record
+}
+
+func TestRollupBuilder(t *testing.T) {
+    vehicleID := primitive.NewObjectID()
+    start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+    records := []*repositories.TrackingRecord{
+        positionedRecord(vehicleID, start, 16.80, 96.15),
+        positionedRecord(vehicleID, start.Add(time.Minute), 16.81, 96.15),
+        positionedRecord(vehicleID, start.Add(6*time.Minute), 16.82, 96.15),
+        positionedRecord(vehicleID, start.Add(7*time.Minute), 16.83, 96.15),
+    }
+    // a reading without coordinates counts without moving the vehicle
+    records = append(
+        records,
+        repositories.NewTrackingRecord(
+            &models.TrackingData{VehicleID: vehicleID, CreatedAt: start.Add(8 * time.Minute)},
+        ),
+    )
+
+    fiveMinutes := newRollupBuilder(repositories.RollupFiveMinutes)
+    for _, record := range records {
+        fiveMinutes.addReading(record)
+    }
+    rollups := fiveMinutes.result()
+    if len(rollups) != 2 {
+        t.Fatalf("expected 2 rollups, got %d", len(rollups))
+    }
+    first, second := rollups[0], rollups[1]
+    segment := geo.Haversine(geo.NewPoint(16.80, 96.15), geo.NewPoint(16.81, 96.15))
+    if first.Readings != 2 || first.Points != 2 || math.Abs(first.DistanceMeters-segment) > 1e-6 {
+        t.Errorf("unexpected first rollup %+v", first)
+    }
+    if want := segment / 60 * 3.6; math.Abs(first.AvgSpeedKmh-want) > 1e-6 {
+        t.Errorf("expected an average speed of %f, got %f", want, first.AvgSpeedKmh)
+    }
+    if second.Readings != 3 || second.Points != 2 || *second.Lat != 16.83 || *second.FirstLat != 16.82 {
+        t.Errorf("unexpected second rollup %+v", second)
+    }
+    if !second.Start.Equal(start.Add(5*time.Minute)) || !second.LastSeen.Equal(start.Add(8*time.Minute)) {
+        t.Errorf("unexpected period of the second rollup %+v", second)
+    }
+
+    // the hourly rollup is the path through every reading of the hour
+    hourly := newRollupBuilder(repositories.RollupHourly)
+    for _, rollup := range rollups {
+        hourly.addRollup(rollup)
+    }
+    hour := hourly.result()
+    path := []geo.Point{}
+    for _, record := range records[:4] {
+        point, _ := record.Point()
+        path = append(path, point)
+    }
+    if len(hour) != 1 || hour[0].Readings != 5 || hour[0].Points != 4 {
+        t.Fatalf("unexpected hourly rollups %+v", hour)
+    }
+    if math.Abs(hour[0].DistanceMeters-geo.PathLength(path)) > 1e-6 {
+        t.Errorf("expected a distance of %f, got %f", geo.PathLength(path), hour[0].DistanceMeters)
+    }
+    if *hour[0].FirstLat != 16.80 || *hour[0].Lat != 16.83 || !hour[0].FirstSeen.Equal(start) {
+        t.Errorf("unexpected positions of the hourly rollup %+v", hour[0])
+    }
+}
+
+func TestMongoTrackingRollupService_RollUp(t *testing.T) {
+    ctx := context.Background()
+    trackingRepo := repositories.NewMemoryTrackingRepository()
+    rollupRepo := newFakeRollupRepo()
+    accessService := NewMongoAccessService(&fakeAssignmentRepo{}, nil)
+    s := NewMongoTrackingRollupService(trackingRepo, rollupRepo, accessService, time.Minute)
+
+    vehicleID := primitive.NewObjectID()
+    start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+    for i := range 24 {
+        at := start.Add(time.Duration(i) * 5 * time.Minute)
+        record := positionedRecord(vehicleID, at, 16.8+float64(i)/100, 96.15)
+        if err := trackingRepo.CreateTrackingData(ctx, record); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    now := start.Add(2*time.Hour + 3*time.Minute)
+    if err := s.RollUp(ctx, now); err != nil {
+        t.Fatal(err)
+    }
+    if want := start.Add(2 * time.Hour); !rollupRepo.watermark.Equal(want) || !s.Watermark().Equal(want) {
+        t.Fatalf("expected the watermark %v, got %v", want, rollupRepo.watermark)
+    }
+    if n := len(rollupRepo.rollups[repositories.RollupFiveMinutes]); n != 24 {
+        t.Errorf("expected 24 rollups of 5 minutes, got %d", n)
+    }
+    hourly := rollupRepo.rollups[repositories.RollupHourly]
+    if len(hourly) != 2 || hourly[0].Readings != 12 || hourly[1].Readings != 12 {
+        t.Fatalf("expected 2 hourly rollups of 12 readings, got %+v", hourly)
+    }
+
+    // a reading stored late invalidates its period, the next pass rolls it up again
+    late := positionedRecord(vehicleID, start.Add(time.Minute), 16.9, 96.2)
+    invalidating := repositories.NewRollupInvalidatingTrackingRepository(trackingRepo, rollupRepo, s)
+    if err := invalidating.CreateTrackingData(ctx, late); err != nil {
+        t.Fatal(err)
+    }
+    if err := s.RollUp(ctx, now); err != nil {
+        t.Fatal(err)
+    }
+    if first := rollupRepo.rollups[repositories.RollupFiveMinutes][0]; first.Readings != 2 {
+        t.Errorf("expected the late reading in its period, got %+v", first)
+    }
+    if first := rollupRepo.rollups[repositories.RollupHourly][0]; first.Readings != 13 {
+        t.Errorf("expected the late reading in its hour, got %+v", first)
+    }
+
+    // the rollups of deleted readings are deleted with them
+    if _, err := invalidating.DeleteTrackingData(ctx, vehicleID, start.Add(time.Hour), true); err != nil {
+        t.Fatal(err)
+    }
+    if err := s.RollUp(ctx, now); err != nil {
+        t.Fatal(err)
+    }
+    hourly = rollupRepo.rollups[repositories.RollupHourly]
+    if len(hourly) != 1 || !hourly[0].Start.Equal(start.Add(time.Hour)) || hourly[0].Readings != 12 {
+        t.Errorf("expected only the second hour to be left, got %+v", hourly)
+    }
+}
+
+// fakeRollupService returns its rollups for every query, remembering the last one
+type fakeRollupService struct {
+    TrackingRollupService
+    watermark time.Time
+    rollups   []*repositories.TrackingRollup
+    query     url.Values
+}
+
+func (s *fakeRollupService) Watermark() time.Time {
+    return s.watermark
+}
+
+func (s *fakeRollupService) FindRollups(_ context.Context, query url.Values) ([]*repositories.TrackingRollup, error) {
+    s.query = query
+    return s.rollups, nil
+}
+
+// routeTrackingService returns its route for every query, remembering the last one
+type routeTrackingService struct {
+    TrackingService
+    route *Route
+    query url.Values
+}
+
+func (s *routeTrackingService) FindRoute(_ context.Context, query url.Values) (*Route, error) {
+    s.query = query
+    return s.route, nil
+}
+
+func TestRollupRoutingTrackingService_FindRoute(t *testing.T) {
+    vehicleID := primitive.NewObjectID()
+    watermark := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+    lat, lng := 16.80, 96.15
+    firstLat, lastLat := 16.81, 16.82
+    rollupService := &fakeRollupService{
+        watermark: watermark,
+        rollups: []*repositories.TrackingRollup{
+            {
+                VehicleID: vehicleID, Start: timestamp.New(watermark.Add(-2 * time.Hour)), Points: 10,
+                DistanceMeters: 500, FirstLat: &lat, FirstLng: &lng, Lat: &lat, Lng: &lng,
+            },
+            {
+                VehicleID: vehicleID, Start: timestamp.New(watermark.Add(-time.Hour)), Points: 5,
+                DistanceMeters: 300, FirstLat: &firstLat, FirstLng: &lng, Lat: &lastLat, Lng: &lng,
+            },
+        },
+    }
+    tail := &Route{
+        VehicleID:      vehicleID.Hex(),
+        TotalPoints:    2,
+        DistanceMeters: 100,
+        Path:           []geo.Point{geo.NewPoint(16.83, 96.15), geo.NewPoint(16.84, 96.15)},
+    }
+    trackingService := &routeTrackingService{route: tail}
+    s := NewRollupRoutingTrackingService(trackingService, rollupService, 24*time.Hour, 7*24*time.Hour)
+    s.now = func() time.Time {
+        return watermark.Add(time.Hour)
+    }
+
+    query := url.Values{"vehicle_id": {vehicleID.Hex()}, "from": {"2024-01-01T00:30:00Z"}}
+    route, err := s.FindRoute(context.Background(), query)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if route.Resolution != "1h" || rollupService.query.Get("from") != "2024-01-01T00:00:00Z" {
+        t.Errorf(
+            "expected the hourly rollups from the start of the hour, got %q, %v",
+            route.Resolution,
+            rollupService.query,
+        )
+    }
+    if trackingService.query.Get("from") != watermark.Format(time.RFC3339) {
+        t.Errorf("expected the readings after the watermark, got %v", trackingService.query)
+    }
+    if len(route.Path) != 4 || route.TotalPoints != 17 {
+        t.Fatalf("expected the last position of every period and the readings, got %+v", route)
+    }
+    joins := geo.Haversine(geo.NewPoint(lat, lng), geo.NewPoint(firstLat, lng)) +
+        geo.Haversine(geo.NewPoint(lastLat, lng), tail.Path[0])
+    if want := 900 + joins; math.Abs(route.DistanceMeters-want) > 1e-6 {
+        t.Errorf("expected a distance of %f, got %f", want, route.DistanceMeters)
+    }
+
+    // a short time range is read from the readings only
+    rollupService.query, trackingService.query = nil, nil
+    query.Set("from", watermark.Add(-time.Hour).Format(time.RFC3339))
+    if route, err = s.FindRoute(context.Background(), query); err != nil || route.Resolution != "" {
+        t.Fatalf("expected the raw route, got %+v, %v", route, err)
+    }
+    if rollupService.query != nil || trackingService.query.Get("from") != query.Get("from") {
+        t.Errorf("expected the query to be passed on, got %v", trackingService.query)
+    }
+
+    query.Set("resolution", "10m")
+    if _, err = s.FindRoute(context.Background(), query); !errors.Is(err, ErrInvalidRouteResolution) {
+        t.Errorf("expected an invalid resolution, got %v", err)
+    }
+}
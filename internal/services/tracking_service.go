@@ -1,64 +1,532 @@
 package services
 
 import (
+    "cmp"
     "context"
+    "errors"
+    "fmt"
+    "math"
     "net/url"
+    "slices"
     "strconv"
+    "strings"
+    "time"
 
     "github.com/goccy/go-json"
     "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/geo"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/params"
     "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+    ErrMalformedPayload    = errors.New("malformed payload")
+    ErrInvalidTrackingData = errors.New("invalid tracking data")
+    ErrRouteVehicleMissing = errors.New("a single vehicle_id is required for a route")
+    ErrInvalidMaxPoints    = errors.New("max_points must be a positive integer")
 )
 
 type TrackingService interface {
-    TrackVehicle(ctx context.Context, req *models.TrackingDataRequest) error
-    FindTrackingData(ctx context.Context, query url.Values) ([]*models.TrackingData, error)
+    TrackVehicle(ctx context.Context, req *TrackingDataRequest) (*repositories.TrackingRecord, error)
+    TrackVehicles(ctx context.Context, reqs []*TrackingDataRequest) ([]*repositories.TrackingRecord, []error)
+    FindTrackingData(ctx context.Context, query url.Values) ([]*repositories.TrackingRecord, error)
+    // FindTrackingDataVersion returns the version of the tracking data FindTrackingData would find for the query,
+    // nil when there is none
+    FindTrackingDataVersion(ctx context.Context, query url.Values) (*repositories.TrackingDataVersion, error)
+    FindTrackingDataByID(ctx context.Context, id string) (*repositories.TrackingRecord, error)
+    ExportTrackingData(
+        ctx context.Context,
+        query url.Values,
+        fn func(trackingData *repositories.TrackingRecord) error,
+    ) error
+    BatchQueryTrackingData(ctx context.Context, req *BatchQueryRequest) ([]*VehicleTrackingData, error)
+    FindRoute(ctx context.Context, query url.Values) (*Route, error)
+}
+
+// Route is the path driven by a vehicle, ordered by time. DistanceMeters and TotalPoints are computed
+// before simplification, so they don't depend on max_points.
+type Route struct {
+    VehicleID      string          `json:"vehicle_id"`
+    From           *timestamp.Time `json:"from,omitempty"`
+    To             *timestamp.Time `json:"to,omitempty"`
+    TotalPoints    int             `json:"total_points"`
+    Simplified     bool            `json:"simplified"`
+    DistanceMeters float64         `json:"distance_meters"`
+    // Resolution is the resolution of the rollups the route was read from before the watermark, empty when it was
+    // read from the raw readings only
+    Resolution string      `json:"resolution,omitempty"`
+    Path       []geo.Point `json:"path"`
+}
+
+// BatchQueryRequest asks for the latest tracking data of several vehicles, Limit is the default
+// for vehicles without their own limit
+type BatchQueryRequest struct {
+    Limit    int                  `json:"limit" validate:"omitempty,min=1,max=100"`
+    Vehicles []*BatchQueryVehicle `json:"vehicles" validate:"required,min=1,max=100,dive,required"`
+}
+
+type BatchQueryVehicle struct {
+    VehicleID string `json:"vehicle_id" validate:"required,mongodb"`
+    Limit     int    `json:"limit" validate:"omitempty,min=1,max=100"`
+}
+
+// VehicleTrackingData is the latest tracking data of a vehicle, newest first. The freshness of the newest
+// reading is inlined, it is missing for vehicles without tracking data.
+type VehicleTrackingData struct {
+    VehicleID    string                         `json:"vehicle_id"`
+    TrackingData []*repositories.TrackingRecord `json:"tracking_data"`
+    *Freshness
 }
 
 type MongoTrackingService struct {
-    trackingRepo repositories.TrackingRepository
+    trackingRepo     repositories.TrackingRepository
+    odometerService  OdometerService
+    freshnessService FreshnessService
+    accessService    AccessService
 }
 
-func NewMongoTrackingService(trackingRepo repositories.TrackingRepository) *MongoTrackingService {
+func NewMongoTrackingService(
+    trackingRepo repositories.TrackingRepository,
+    odometerService OdometerService,
+    freshnessService FreshnessService,
+    accessService AccessService,
+) *MongoTrackingService {
     return &MongoTrackingService{
-        trackingRepo: trackingRepo,
+        trackingRepo:     trackingRepo,
+        odometerService:  odometerService,
+        freshnessService: freshnessService,
+        accessService:    accessService,
     }
 }
 
-func (s *MongoTrackingService) TrackVehicle(ctx context.Context, req *models.TrackingDataRequest) error {
-    err := req.Validate()
+func (s *MongoTrackingService) TrackVehicle(
+    ctx context.Context,
+    req *TrackingDataRequest,
+) (*repositories.TrackingRecord, error) {
+    trackingData, err := receivedWith(ctx, req).ToTrackingRecord()
     if err != nil {
-        return err
+        return nil, fmt.Errorf("%w: %w", ErrInvalidTrackingData, err)
     }
-    trackingData, err := req.ToTrackingData()
+    _, err = s.odometerService.Measure(
+        ctx, []*repositories.TrackingRecord{trackingData}, func() ([]error, error) {
+            return nil, s.trackingRepo.CreateTrackingData(ctx, trackingData)
+        },
+    )
     if err != nil {
-        return err
+        return nil, err
+    }
+
+    return trackingData, nil
+}
+
+// TrackVehicles stores a batch of readings, the returned slices have an entry per request
+// with either the stored tracking data or the error that prevented storing it.
+func (s *MongoTrackingService) TrackVehicles(
+    ctx context.Context,
+    reqs []*TrackingDataRequest,
+) ([]*repositories.TrackingRecord, []error) {
+    results := make([]*repositories.TrackingRecord, len(reqs))
+    errs := make([]error, len(reqs))
+
+    batch := make([]*repositories.TrackingRecord, 0, len(reqs))
+    // indexes maps the position in batch back to the position in reqs
+    indexes := make([]int, 0, len(reqs))
+    for i, req := range reqs {
+        trackingData, err := receivedWith(ctx, req).ToTrackingRecord()
+        if err != nil {
+            errs[i] = fmt.Errorf("%w: %w", ErrInvalidTrackingData, err)
+            continue
+        }
+        batch = append(batch, trackingData)
+        indexes = append(indexes, i)
+    }
+    if len(batch) == 0 {
+        return results, errs
+    }
+
+    itemErrs, err := s.odometerService.Measure(
+        ctx, batch, func() ([]error, error) {
+            return s.trackingRepo.CreateManyTrackingData(ctx, batch)
+        },
+    )
+    for j, trackingData := range batch {
+        i := indexes[j]
+        if err != nil {
+            errs[i] = err
+            continue
+        }
+        if itemErrs[j] != nil {
+            errs[i] = itemErrs[j]
+            continue
+        }
+        results[i] = trackingData
     }
-    err = s.trackingRepo.CreateTrackingData(ctx, trackingData)
+    return results, errs
+}
+
+func (s *MongoTrackingService) FindTrackingData(
+    ctx context.Context,
+    query url.Values,
+) ([]*repositories.TrackingRecord, error) {
+    filter, err := s.scopedTrackingFilter(ctx, query)
+    if err != nil {
+        return nil, err
+    }
+
+    return s.trackingRepo.FindTrackingData(ctx, filter)
+}
+
+func (s *MongoTrackingService) FindTrackingDataVersion(
+    ctx context.Context,
+    query url.Values,
+) (*repositories.TrackingDataVersion, error) {
+    filter, err := s.scopedTrackingFilter(ctx, query)
+    if err != nil {
+        return nil, err
+    }
+
+    return s.trackingRepo.FindLatestVersion(ctx, filter)
+}
+
+// FindTrackingDataByID returns a single tracking data, the tracking data of vehicles the user may not see is
+// reported as not found like unknown ids
+func (s *MongoTrackingService) FindTrackingDataByID(
+    ctx context.Context,
+    id string,
+) (*repositories.TrackingRecord, error) {
+    trackingData, err := s.trackingRepo.FindTrackingDataByID(ctx, id)
+    if err != nil {
+        return nil, err
+    }
+    scope, err := s.accessService.VehicleScope(ctx)
+    if err != nil {
+        return nil, err
+    }
+    if !scope.Allows(trackingData.VehicleID) {
+        return nil, repositories.ErrTrackingDataNotFound
+    }
+    return trackingData, nil
+}
+
+// ExportTrackingData streams every tracking data matching the query to fn, pagination parameters are ignored
+func (s *MongoTrackingService) ExportTrackingData(
+    ctx context.Context,
+    query url.Values,
+    fn func(trackingData *repositories.TrackingRecord) error,
+) error {
+    filter, err := s.scopedTrackingFilter(ctx, query)
     if err != nil {
         return err
     }
+    // building the filter upfront, so an invalid filter is reported before anything is streamed
+    if err := filter.Build(); err != nil {
+        return err
+    }
+
+    return s.trackingRepo.StreamTrackingData(ctx, filter, fn)
+}
+
+// BatchQueryTrackingData returns the latest tracking data of every requested vehicle in the requested order,
+// vehicles without tracking data have an empty list
+func (s *MongoTrackingService) BatchQueryTrackingData(
+    ctx context.Context,
+    req *BatchQueryRequest,
+) ([]*VehicleTrackingData, error) {
+    defaultLimit := req.Limit
+    if defaultLimit == 0 {
+        defaultLimit = 1
+    }
+
+    scope, err := s.accessService.VehicleScope(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    limits := make([]repositories.VehicleLimit, 0, len(req.Vehicles))
+    seen := make(map[primitive.ObjectID]bool, len(req.Vehicles))
+    for _, vehicle := range req.Vehicles {
+        id, err := primitive.ObjectIDFromHex(vehicle.VehicleID)
+        if err != nil {
+            return nil, repositories.ErrInvalidID
+        }
+        if !scope.Allows(id) {
+            return nil, fmt.Errorf("%w: %s", ErrVehicleNotAllowed, vehicle.VehicleID)
+        }
+        if seen[id] {
+            continue
+        }
+        seen[id] = true
+        limit := vehicle.Limit
+        if limit == 0 {
+            limit = defaultLimit
+        }
+        limits = append(limits, repositories.VehicleLimit{VehicleID: id, Limit: limit})
+    }
+
+    trackingData, err := s.trackingRepo.FindLatestTrackingData(ctx, limits)
+    if err != nil {
+        return nil, err
+    }
+
+    lastSeen := make(map[primitive.ObjectID]time.Time, len(trackingData))
+    for vehicleID, data := range trackingData {
+        if len(data) > 0 {
+            lastSeen[vehicleID] = readingTime(data[0])
+        }
+    }
+    freshness, err := s.freshnessService.Freshness(ctx, lastSeen, time.Now())
+    if err != nil {
+        return nil, err
+    }
+
+    results := make([]*VehicleTrackingData, 0, len(limits))
+    for _, limit := range limits {
+        data := trackingData[limit.VehicleID]
+        if data == nil {
+            data = []*repositories.TrackingRecord{}
+        }
+        results = append(
+            results, &VehicleTrackingData{
+                VehicleID:    limit.VehicleID.Hex(),
+                TrackingData: data,
+                Freshness:    freshness[limit.VehicleID],
+            },
+        )
+    }
+    return results, nil
+}
+
+// findPreviousTrackingData returns the reading of the same vehicle right before trackingData, nil for the first one
+func findPreviousTrackingData(
+    ctx context.Context,
+    trackingRepo repositories.TrackingRepository,
+    trackingData *repositories.TrackingRecord,
+) (*repositories.TrackingRecord, error) {
+    previous, err := trackingRepo.FindTrackingData(
+        ctx, &repositories.TrackingFilter{
+            PageSize:  1,
+            SortField: "-created_at",
+            VehicleID: trackingData.VehicleID.Hex(),
+            To:        readingTime(trackingData).UTC().Format(time.RFC3339Nano),
+            // readings are compared with the previous one whatever its flags
+            Exclude: repositories.ExcludeNone,
+        },
+    )
+    if err != nil || len(previous) == 0 {
+        return nil, err
+    }
+    return previous[0], nil
+}
+
+// readingTime returns when the reading was stored, now for readings that weren't stored yet
+func readingTime(trackingData *repositories.TrackingRecord) time.Time {
+    if trackingData.CreatedAt.IsZero() {
+        return time.Now()
+    }
+    return trackingData.CreatedAt
+}
+
+// FindRoute returns the positions of a vehicle between from and to, readings without coordinates are skipped and
+// the ones matched to the road network are on the road. With max_points, the path is simplified with Douglas-Peucker
+// to at most that many points for map replay.
+func (s *MongoTrackingService) FindRoute(ctx context.Context, query url.Values) (*Route, error) {
+    vehicleID := query.Get("vehicle_id")
+    if vehicleID == "" || strings.Contains(vehicleID, ",") {
+        return nil, ErrRouteVehicleMissing
+    }
+    maxPoints, err := routeMaxPoints(query)
+    if err != nil {
+        return nil, err
+    }
+
+    filter := &repositories.TrackingFilter{
+        VehicleID: vehicleID,
+        From:      query.Get("from"),
+        To:        query.Get("to"),
+        Exclude:   query.Get("exclude"),
+        // routes only make sense in the order they were driven
+        SortField: "created_at",
+        SortOrder: "asc",
+    }
+    if err := filter.Build(); err != nil {
+        return nil, err
+    }
+    scope, err := s.accessService.VehicleScope(ctx)
+    if err != nil {
+        return nil, err
+    }
+    if !scope.Allows(filter.VehicleObjID()) {
+        return nil, ErrVehicleNotAllowed
+    }
 
+    route := &Route{VehicleID: vehicleID, Path: []geo.Point{}}
+    from, to := filter.TimeRange()
+    if !from.IsZero() {
+        route.From = timestamp.Ptr(from)
+    }
+    if !to.IsZero() {
+        route.To = timestamp.Ptr(to)
+    }
+    err = s.trackingRepo.StreamTrackingData(
+        ctx, filter, func(trackingData *repositories.TrackingRecord) error {
+            if point, ok := trackingData.RoadPoint(); ok {
+                route.Path = append(route.Path, point)
+            }
+            return nil
+        },
+    )
+    if err != nil {
+        return nil, err
+    }
+
+    route.TotalPoints = len(route.Path)
+    route.DistanceMeters = geo.PathLength(route.Path)
+    route.simplify(maxPoints)
+    return route, nil
+}
+
+// routeMaxPoints returns the max_points of the route query, 0 without it
+func routeMaxPoints(query url.Values) (int, error) {
+    value := query.Get("max_points")
+    if value == "" {
+        return 0, nil
+    }
+    maxPoints, err := strconv.Atoi(value)
+    if err != nil || maxPoints < 2 {
+        return 0, ErrInvalidMaxPoints
+    }
+    return maxPoints, nil
+}
+
+// simplify reduces the path to at most maxPoints points, a maxPoints of 0 keeps all of them
+func (r *Route) simplify(maxPoints int) {
+    if maxPoints > 0 && len(r.Path) > maxPoints {
+        r.Path = geo.SimplifyToCount(r.Path, maxPoints)
+        r.Simplified = true
+    }
+}
+
+// scopedTrackingFilter parses the query and limits it to the vehicles the request has access to
+func (s *MongoTrackingService) scopedTrackingFilter(
+    ctx context.Context,
+    query url.Values,
+) (*repositories.TrackingFilter, error) {
+    filter, err := parseTrackingFilter(query)
+    if err != nil {
+        return nil, err
+    }
+    scope, err := s.accessService.VehicleScope(ctx)
+    if err != nil {
+        return nil, err
+    }
+    scope.Restrict(filter)
+    return filter, nil
+}
+
+// parseTrackingFilter validates the query parameters of the tracking data filter, a *params.Error lists every
+// invalid one. Unsupported parameters are ignored.
+func parseTrackingFilter(query url.Values) (*repositories.TrackingFilter, error) {
+    p := params.NewParser(query)
+    vehicleIDs := p.List("vehicle_id", repositories.MaxFilterValues, validObjectID)
+    driverIDs := p.List("driver_id", repositories.MaxFilterValues, validDriverID)
+    statuses := p.List(
+        "status", repositories.MaxFilterValues, func(value string) error {
+            return models.VehicleStatus(value).Valid()
+        },
+    )
+    fuelConditions := p.List(
+        "fuel_condition", repositories.MaxFilterValues, func(value string) error {
+            return models.FuelCondition(value).Valid()
+        },
+    )
+    sensors := p.List("sensor", repositories.MaxSensors, validSensorCondition)
+    fields := p.List("fields", repositories.MaxFilterValues, validField)
+    // mileage is the former name of mileage_min
+    mileageMin := cmp.Or(p.OptionalFloat("mileage_min", 0), p.OptionalFloat("mileage", 0))
+    mileageMax := p.OptionalFloat("mileage_max", 0)
+    if mileageMin != nil && mileageMax != nil && *mileageMin > *mileageMax {
+        p.Invalid("mileage_max", "must not be below mileage_min")
+    }
+    filter := &repositories.TrackingFilter{
+        ID:            p.String("id"),
+        Page:          p.Int("page", 1, math.MaxInt32),
+        PageSize:      p.Int("limit", 1, repositories.MaxPageSize()),
+        SortField:     p.String("sort_by"),
+        SortOrder:     p.Enum("sort_order", "asc", "desc"),
+        VehicleID:     strings.Join(vehicleIDs, ","),
+        DriverID:      strings.Join(driverIDs, ","),
+        Location:      p.String("location"),
+        MileageMin:    mileageMin,
+        MileageMax:    mileageMax,
+        Status:        models.VehicleStatus(strings.Join(statuses, ",")),
+        FuelCondition: models.FuelCondition(strings.Join(fuelConditions, ",")),
+        From:          query.Get("from"),
+        To:            query.Get("to"),
+        TimeAxis:      p.Enum("time_axis", "created_at", "device_time", "received_time"),
+        Exclude:       p.String("exclude"),
+        Sensor:        strings.Join(sensors, ","),
+        Fields:        strings.Join(fields, ","),
+        After:         p.Check("after", validObjectID),
+    }
+    from, to := p.Time("from"), p.Time("to")
+    if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+        p.Invalid("to", "must be after from")
+    }
+    if err := p.Err(); err != nil {
+        return nil, err
+    }
+    return filter, nil
+}
+
+func validField(value string) error {
+    if repositories.ValidateField(value) != nil {
+        return errors.New("must be a field of the tracking data")
+    }
+    return nil
+}
+
+func validSensorCondition(value string) error {
+    if _, err := repositories.ParseSensorCondition(value); err != nil {
+        return errors.New("must be name:min..max or name:value, e.g. temperature:-20..-15")
+    }
+    return nil
+}
+
+func validObjectID(value string) error {
+    if !primitive.IsValidObjectID(value) {
+        return errors.New("must be a 24 character hex ObjectID")
+    }
     return nil
 }
 
-func (s *MongoTrackingService) FindTrackingData(ctx context.Context, query url.Values) ([]*models.TrackingData, error) {
-    // by converting url.Values to map[string]any and unmarshalling it to TrackingFilter,
+func validDriverID(value string) error {
+    if len(value) > repositories.MaxDriverIDLength {
+        return fmt.Errorf("must be at most %d characters", repositories.MaxDriverIDLength)
+    }
+    return nil
+}
+
+// decodeQuery decodes the query parameters into filter, page and limit are always converted to integers
+// and floatKeys lists the other numeric parameters.
+func decodeQuery(query url.Values, filter any, floatKeys ...string) error {
+    // by converting url.Values to map[string]any and unmarshalling it to the filter,
     // we can ignore unsupported query parameters
     data := map[string]any{}
     for key, value := range query {
         if key == "page" || key == "limit" {
             converted, err := strconv.Atoi(value[0])
             if err != nil {
-                return nil, err
+                return err
             }
             data[key] = converted
             continue
         }
-        if key == "mileage" {
+        if slices.Contains(floatKeys, key) {
             converted, err := strconv.ParseFloat(value[0], 64)
             if err != nil {
-                return nil, err
+                return err
             }
             data[key] = converted
             continue
@@ -68,13 +536,8 @@ func (s *MongoTrackingService) FindTrackingData(ctx context.Context, query url.V
 
     buf, err := json.Marshal(data)
     if err != nil {
-        return nil, err
-    }
-
-    var filter repositories.TrackingFilter
-    if err := json.Unmarshal(buf, &filter); err != nil {
-        return nil, err
+        return err
     }
 
-    return s.trackingRepo.FindTrackingData(ctx, &filter)
+    return json.Unmarshal(buf, filter)
 }
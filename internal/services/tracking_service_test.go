@@ -0,0 +1,165 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "net/url"
+    "testing"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/params"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// idTrackingRepo finds its single tracking data by ObjectID
+type idTrackingRepo struct {
+    repositories.TrackingRepository
+    trackingData *repositories.TrackingRecord
+}
+
+func (r *idTrackingRepo) FindTrackingDataByID(_ context.Context, id string) (*repositories.TrackingRecord, error) {
+    if id != r.trackingData.ID.Hex() {
+        return nil, repositories.ErrTrackingDataNotFound
+    }
+    return r.trackingData, nil
+}
+
+func TestFindTrackingDataByID(t *testing.T) {
+    assigned := primitive.NewObjectID()
+    trackingData := repositories.NewTrackingRecord(
+        &models.TrackingData{ID: primitive.NewObjectID(), VehicleID: primitive.NewObjectID()},
+    )
+    s := NewMongoTrackingService(
+        &idTrackingRepo{trackingData: trackingData},
+        nil,
+        nil,
+        NewMongoAccessService(&fakeAssignmentRepo{vehicleIDs: []primitive.ObjectID{assigned}}, nil),
+    )
+
+    found, err := s.FindTrackingDataByID(context.Background(), trackingData.ID.Hex())
+    if err != nil || found != trackingData {
+        t.Fatalf("expected the tracking data, got %v, %v", found, err)
+    }
+
+    if _, err = s.FindTrackingDataByID(context.Background(), primitive.NewObjectID().Hex()); !errors.Is(
+        err,
+        repositories.ErrTrackingDataNotFound,
+    ) {
+        t.Errorf("expected an unknown id to be not found, got %v", err)
+    }
+
+    // the tracking data of a vehicle the user isn't assigned to looks like an unknown id
+    user := WithClaims(context.Background(), &Claims{UserID: "2", Role: "user", OrganizationID: "org"})
+    if _, err = s.FindTrackingDataByID(user, trackingData.ID.Hex()); !errors.Is(
+        err,
+        repositories.ErrTrackingDataNotFound,
+    ) {
+        t.Errorf("expected the tracking data of another vehicle to be not found, got %v", err)
+    }
+}
+
+// versionTrackingRepo returns its version for every filter, remembering the last one
+type versionTrackingRepo struct {
+    repositories.TrackingRepository
+    version *repositories.TrackingDataVersion
+    filter  *repositories.TrackingFilter
+}
+
+func (r *versionTrackingRepo) FindLatestVersion(
+    _ context.Context,
+    filter *repositories.TrackingFilter,
+) (*repositories.TrackingDataVersion, error) {
+    r.filter = filter
+    return r.version, nil
+}
+
+func TestFindTrackingDataVersion(t *testing.T) {
+    repo := &versionTrackingRepo{version: &repositories.TrackingDataVersion{ID: primitive.NewObjectID()}}
+    s := NewMongoTrackingService(repo, nil, nil, NewMongoAccessService(&fakeAssignmentRepo{}, nil))
+
+    vehicleID := primitive.NewObjectID().Hex()
+    version, err := s.FindTrackingDataVersion(context.Background(), url.Values{"vehicle_id": {vehicleID}})
+    if err != nil || version != repo.version {
+        t.Fatalf("expected the version, got %v, %v", version, err)
+    }
+    if repo.filter.VehicleID != vehicleID {
+        t.Errorf("expected the version of the vehicle, got the filter %+v", repo.filter)
+    }
+
+    // the query is validated like the one of the results
+    repo.filter = nil
+    var invalid *params.Error
+    if _, err = s.FindTrackingDataVersion(context.Background(), url.Values{"limit": {"x"}}); !errors.As(
+        err,
+        &invalid,
+    ) || repo.filter != nil {
+        t.Errorf("expected an invalid query to fail before finding the version, got %v", err)
+    }
+}
+
+func TestParseTrackingFilter(t *testing.T) {
+    filter, err := parseTrackingFilter(
+        url.Values{
+            "page":       {"2"},
+            "limit":      {"20"},
+            "mileage":    {"1500.5"},
+            "sort_order": {"desc"},
+            "from":       {"2024-01-01T00:00:00Z"},
+            "to":         {"2024-02-01T00:00:00Z"},
+            "unknown":    {"ignored"},
+        },
+    )
+    if err != nil {
+        t.Fatal(err)
+    }
+    if filter.Page != 2 || filter.PageSize != 20 || *filter.MileageMin != 1500.5 || filter.SortOrder != "desc" {
+        t.Errorf("unexpected filter %+v", filter)
+    }
+
+    // 0 is a bound of its own, not an unset one
+    filter, err = parseTrackingFilter(url.Values{"mileage_min": {"0"}, "mileage_max": {"20000"}})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if filter.MileageMin == nil || *filter.MileageMin != 0 || *filter.MileageMax != 20000 {
+        t.Errorf("expected the mileage band, got %v and %v", filter.MileageMin, filter.MileageMax)
+    }
+
+    first, second := primitive.NewObjectID().Hex(), primitive.NewObjectID().Hex()
+    filter, err = parseTrackingFilter(
+        url.Values{"vehicle_id": {first + ", " + second + "," + first}, "status": {"active,repair"}},
+    )
+    if err != nil {
+        t.Fatal(err)
+    }
+    if filter.VehicleID != first+","+second || filter.Status != models.VehicleStatusActive+","+models.VehicleStatusRepair {
+        t.Errorf("expected the distinct values of the lists, got %+v", filter)
+    }
+
+    _, err = parseTrackingFilter(
+        url.Values{
+            "page":        {"abc"},
+            "vehicle_id":  {primitive.NewObjectID().Hex() + ",42"},
+            "sort_order":  {"sideways"},
+            "from":        {"2024-02-01T00:00:00Z"},
+            "to":          {"2024-01-01T00:00:00Z"},
+            "fields":      {"vehicle_id,secret"},
+            "mileage_min": {"20000"},
+            "mileage_max": {"10000"},
+        },
+    )
+    var invalid *params.Error
+    if !errors.As(err, &invalid) {
+        t.Fatalf("expected the invalid parameters, got %v", err)
+    }
+    fields := map[string]bool{}
+    for _, field := range invalid.Fields {
+        fields[field.Field] = true
+    }
+    for _, name := range []string{"page", "vehicle_id", "sort_order", "to", "mileage_max", "fields"} {
+        if !fields[name] {
+            t.Errorf("expected %s to be invalid, got %+v", name, invalid.Fields)
+        }
+    }
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+    "context"
+    "net/url"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+)
+
+type TrackingStatsService interface {
+    FindVehicleStats(ctx context.Context, query url.Values) ([]*repositories.VehicleStats, error)
+    FindHeatmapTiles(ctx context.Context, query url.Values) ([]*repositories.HeatmapTile, error)
+}
+
+type MongoTrackingStatsService struct {
+    statsRepo     repositories.TrackingStatsRepository
+    accessService AccessService
+}
+
+func NewMongoTrackingStatsService(
+    statsRepo repositories.TrackingStatsRepository,
+    accessService AccessService,
+) *MongoTrackingStatsService {
+    return &MongoTrackingStatsService{
+        statsRepo:     statsRepo,
+        accessService: accessService,
+    }
+}
+
+func (s *MongoTrackingStatsService) FindVehicleStats(
+    ctx context.Context,
+    query url.Values,
+) ([]*repositories.VehicleStats, error) {
+    var filter repositories.TrackingStatsFilter
+    if err := decodeQuery(query, &filter); err != nil {
+        return nil, err
+    }
+    scope, err := s.accessService.VehicleScope(ctx)
+    if err != nil {
+        return nil, err
+    }
+    scope.Restrict(&filter)
+    return s.statsRepo.FindVehicleStats(ctx, &filter)
+}
+
+func (s *MongoTrackingStatsService) FindHeatmapTiles(
+    ctx context.Context,
+    query url.Values,
+) ([]*repositories.HeatmapTile, error) {
+    var filter repositories.TrackingHeatmapFilter
+    if err := decodeQuery(query, &filter); err != nil {
+        return nil, err
+    }
+    scope, err := s.accessService.VehicleScope(ctx)
+    if err != nil {
+        return nil, err
+    }
+    scope.Restrict(&filter)
+    return s.statsRepo.FindHeatmapTiles(ctx, &filter)
+}
@@ -0,0 +1,251 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "log"
+    "strings"
+    "sync/atomic"
+    "time"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/event"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+)
+
+const (
+    // VehicleEventFormatLegacy publishes the raw tracking data, the format of the consumers that didn't migrate
+    VehicleEventFormatLegacy = "legacy"
+    // VehicleEventFormatEnvelope publishes VehicleUpdateEvent only
+    VehicleEventFormatEnvelope = "envelope"
+    // VehicleEventFormatBoth publishes both formats while the consumers migrate
+    VehicleEventFormatBoth = "both"
+
+    // unknownRoutingVehicle and unknownRoutingEvent are the vehicle and event type of the routing key of a body
+    // without them, like the reports of the whole fleet
+    unknownRoutingVehicle = "unknown"
+    unknownRoutingEvent   = "unknown"
+)
+
+// VehicleUpdateEvent is the new schema of the vehicle updates, the tracking data in an event envelope
+type VehicleUpdateEvent = event.Envelope
+
+// QueueInspector returns the number of messages and consumers of a queue
+type QueueInspector func(ctx context.Context, queue string) (messages int, consumers int, err error)
+
+// VehicleEventFormatStats are the metrics of one format, Published and Failed count since the process started
+type VehicleEventFormatStats struct {
+    Format    string `json:"format"`
+    Queue     string `json:"queue"`
+    Published int64  `json:"published"`
+    Failed    int64  `json:"failed"`
+    Messages  int    `json:"messages"`
+    Consumers int    `json:"consumers"`
+    // Error is why the queue couldn't be inspected, the messages and consumers are unknown then
+    Error string `json:"error,omitempty"`
+}
+
+type VehicleEventStats struct {
+    // Format is what is published now
+    Format  string                     `json:"format"`
+    Formats []*VehicleEventFormatStats `json:"formats"`
+}
+
+type VehicleEventService interface {
+    // Stats shows which formats are published and how many consumers are left on each, the legacy format can
+    // be turned off once its queue has no consumers
+    Stats(ctx context.Context) *VehicleEventStats
+}
+
+// vehicleEventFormat is one of the formats, with its own publisher and queue
+type vehicleEventFormat struct {
+    name      string
+    queue     string
+    publisher Publisher
+    published atomic.Int64
+    failed    atomic.Int64
+}
+
+func (f *vehicleEventFormat) publish(ctx context.Context, body []byte) error {
+    if err := f.publisher.Publish(ctx, body); err != nil {
+        f.failed.Add(1)
+        return err
+    }
+    f.published.Add(1)
+    return nil
+}
+
+// TrackingDataMessage returns the message of the stored tracking data published to the vehicle queue: the record with
+// its id, tenant and the distance and speed computed from the previous reading. The received body is published
+// when there is no record, like with a tracking service that doesn't return it.
+func TrackingDataMessage(record *repositories.TrackingRecord, body []byte) []byte {
+    if record == nil {
+        return body
+    }
+    message, err := json.Marshal(record)
+    if err != nil {
+        log.Println("Failed to encode the tracking data message: ", err)
+        return body
+    }
+    return message
+}
+
+// TrackingRoutingKey returns the routing key of the tracking data published as the event type to a topic exchange,
+// tracking.{vehicle_id}.{event_type}, so consumers bind to the vehicles and events they need
+func TrackingRoutingKey(eventType string) RoutingKey {
+    return func(body []byte) string {
+        var data struct {
+            VehicleID string `json:"vehicle_id"`
+        }
+        _ = json.Unmarshal(body, &data)
+        return routingKey(data.VehicleID, eventType)
+    }
+}
+
+// EventRoutingKey returns the routing key of an event published with its type in the event field, like the alerts,
+// tracking.{vehicle_id}.{event_type}. The vehicle is the one of the event or of the alert, anomaly, maintenance,
+// state or suggestion it carries.
+func EventRoutingKey(body []byte) string {
+    type vehicle struct {
+        VehicleID string `json:"vehicle_id"`
+    }
+    var data struct {
+        Event       string  `json:"event"`
+        VehicleID   string  `json:"vehicle_id"`
+        Alert       vehicle `json:"alert"`
+        Anomaly     vehicle `json:"anomaly"`
+        Maintenance vehicle `json:"maintenance"`
+        State       vehicle `json:"state"`
+        Suggestion  vehicle `json:"suggestion"`
+    }
+    _ = json.Unmarshal(body, &data)
+    vehicleID := data.VehicleID
+    for _, nested := range []vehicle{data.Alert, data.Anomaly, data.Maintenance, data.State, data.Suggestion} {
+        if vehicleID == "" {
+            vehicleID = nested.VehicleID
+        }
+    }
+    return routingKey(vehicleID, data.Event)
+}
+
+// TrackingBindingKey returns the binding key of the event type of every vehicle, tracking.*.{event_type}
+func TrackingBindingKey(eventType string) string {
+    return "tracking.*." + routingEventType(eventType)
+}
+
+// routingKey returns tracking.{vehicle_id}.{event_type} with three segments
+func routingKey(vehicleID, eventType string) string {
+    // dots and wildcards would change the segments of the key
+    if vehicleID == "" || strings.ContainsAny(vehicleID, ".*#") {
+        vehicleID = unknownRoutingVehicle
+    }
+    return "tracking." + vehicleID + "." + routingEventType(eventType)
+}
+
+// routingEventType returns the event type as a single segment of a routing key, vehicle.updated is vehicle_updated
+func routingEventType(eventType string) string {
+    if eventType == "" {
+        return unknownRoutingEvent
+    }
+    return strings.NewReplacer(".", "_", "*", "_", "#", "_").Replace(eventType)
+}
+
+// DualFormatVehicleEventService publishes the vehicle updates in the legacy format, as VehicleUpdateEvent or both,
+// every format to a queue of its own. It lets the consumers migrate one by one, the format can be changed while
+// publishing.
+type DualFormatVehicleEventService struct {
+    legacy   *vehicleEventFormat
+    envelope *vehicleEventFormat
+    format   atomic.Value
+    inspect  QueueInspector
+}
+
+func NewDualFormatVehicleEventService(
+    legacy Publisher,
+    legacyQueue string,
+    envelope Publisher,
+    envelopeQueue string,
+    format string,
+    inspect QueueInspector,
+) *DualFormatVehicleEventService {
+    s := &DualFormatVehicleEventService{
+        legacy:   &vehicleEventFormat{name: VehicleEventFormatLegacy, queue: legacyQueue, publisher: legacy},
+        envelope: &vehicleEventFormat{name: VehicleEventFormatEnvelope, queue: envelopeQueue, publisher: envelope},
+        inspect:  inspect,
+    }
+    s.SetFormat(format)
+    return s
+}
+
+// SetFormat changes what is published, unknown formats publish the legacy format
+func (s *DualFormatVehicleEventService) SetFormat(format string) {
+    if format != VehicleEventFormatEnvelope && format != VehicleEventFormatBoth {
+        format = VehicleEventFormatLegacy
+    }
+    s.format.Store(format)
+}
+
+func (s *DualFormatVehicleEventService) Format() string {
+    return s.format.Load().(string)
+}
+
+// Publish publishes the tracking data in the current format, with both formats a failure of one doesn't keep
+// the other from being published
+func (s *DualFormatVehicleEventService) Publish(ctx context.Context, body []byte) error {
+    format := s.Format()
+    var errs []error
+    if format != VehicleEventFormatEnvelope {
+        errs = append(errs, s.legacy.publish(ctx, body))
+    }
+    if format != VehicleEventFormatLegacy {
+        errs = append(errs, s.publishEnvelope(ctx, body))
+    }
+    return errors.Join(errs...)
+}
+
+func (s *DualFormatVehicleEventService) publishEnvelope(ctx context.Context, body []byte) error {
+    tenantID, _ := tenant.FromContext(ctx)
+    // the time of the event is the time of the reading, the tracking data without one happened now
+    var data struct {
+        CreatedAt time.Time `json:"created_at"`
+    }
+    at := time.Now()
+    if err := json.Unmarshal(body, &data); err == nil && !data.CreatedAt.IsZero() {
+        at = data.CreatedAt
+    }
+    wrapped, err := event.Wrap(event.TypeVehicleUpdated, body, at, tenantID)
+    if err != nil {
+        s.envelope.failed.Add(1)
+        return err
+    }
+    wrapped.SchemaVersion = event.TrackingDataSchemaVersion
+    body, err = json.Marshal(wrapped)
+    if err != nil {
+        s.envelope.failed.Add(1)
+        return err
+    }
+    return s.envelope.publish(ctx, body)
+}
+
+func (s *DualFormatVehicleEventService) Stats(ctx context.Context) *VehicleEventStats {
+    stats := &VehicleEventStats{Format: s.Format()}
+    for _, format := range []*vehicleEventFormat{s.legacy, s.envelope} {
+        formatStats := &VehicleEventFormatStats{
+            Format:    format.name,
+            Queue:     format.queue,
+            Published: format.published.Load(),
+            Failed:    format.failed.Load(),
+        }
+        if s.inspect != nil {
+            messages, consumers, err := s.inspect(ctx, format.queue)
+            if err != nil {
+                // the stats of the other format are still useful
+                formatStats.Error = err.Error()
+            }
+            formatStats.Messages, formatStats.Consumers = messages, consumers
+        }
+        stats.Formats = append(stats.Formats, formatStats)
+    }
+    return stats
+}
@@ -0,0 +1,171 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/event"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// queuePublisher keeps the bodies published to its queue, or fails with err
+type queuePublisher struct {
+    bodies [][]byte
+    err    error
+}
+
+func (p *queuePublisher) Publish(_ context.Context, body []byte) error {
+    if p.err != nil {
+        return p.err
+    }
+    p.bodies = append(p.bodies, body)
+    return nil
+}
+
+func TestDualFormatVehicleEventService(t *testing.T) {
+    legacy, envelope := &queuePublisher{}, &queuePublisher{}
+    consumers := map[string]int{"vehicle": 2, "vehicle.events": 1}
+    s := NewDualFormatVehicleEventService(
+        legacy,
+        "vehicle",
+        envelope,
+        "vehicle.events",
+        "",
+        func(_ context.Context, queue string) (int, int, error) {
+            return 0, consumers[queue], nil
+        },
+    )
+    ctx := tenant.WithID(context.Background(), "acme")
+    body := []byte(`{"vehicle_id":"1"}`)
+
+    if err := s.Publish(ctx, body); err != nil {
+        t.Fatal(err)
+    }
+    if len(legacy.bodies) != 1 || len(envelope.bodies) != 0 {
+        t.Fatalf("expected only the legacy format by default, got %d and %d", len(legacy.bodies), len(envelope.bodies))
+    }
+
+    s.SetFormat(VehicleEventFormatBoth)
+    if err := s.Publish(ctx, body); err != nil {
+        t.Fatal(err)
+    }
+    if len(legacy.bodies) != 2 || string(legacy.bodies[1]) != string(body) || len(envelope.bodies) != 1 {
+        t.Fatalf("expected both formats, got %d and %d", len(legacy.bodies), len(envelope.bodies))
+    }
+    var published VehicleUpdateEvent
+    if err := json.Unmarshal(envelope.bodies[0], &published); err != nil {
+        t.Fatal(err)
+    }
+    if published.Type != event.TypeVehicleUpdated || published.TenantID != "acme" || string(published.Data) != string(body) {
+        t.Errorf("expected the tracking data in an envelope of the tenant, got %+v", published)
+    }
+
+    // a failing format doesn't keep the other from being published
+    legacy.err = errors.New("legacy queue is gone")
+    if err := s.Publish(ctx, body); !errors.Is(err, legacy.err) {
+        t.Errorf("expected the legacy error, got %v", err)
+    }
+    if len(envelope.bodies) != 2 {
+        t.Errorf("expected the envelope to be published, got %d", len(envelope.bodies))
+    }
+
+    s.SetFormat(VehicleEventFormatEnvelope)
+    if err := s.Publish(ctx, body); err != nil {
+        t.Fatal(err)
+    }
+
+    stats := s.Stats(context.Background())
+    if stats.Format != VehicleEventFormatEnvelope || len(stats.Formats) != 2 {
+        t.Fatalf("unexpected stats %+v", stats)
+    }
+    legacyStats, envelopeStats := stats.Formats[0], stats.Formats[1]
+    if legacyStats.Published != 2 || legacyStats.Failed != 1 || legacyStats.Consumers != 2 {
+        t.Errorf("unexpected legacy stats %+v", legacyStats)
+    }
+    if envelopeStats.Published != 3 || envelopeStats.Failed != 0 || envelopeStats.Consumers != 1 {
+        t.Errorf("unexpected envelope stats %+v", envelopeStats)
+    }
+}
+
+func TestTrackingRoutingKey(t *testing.T) {
+    routingKey := TrackingRoutingKey(event.TypeVehicleUpdated)
+    for body, want := range map[string]string{
+        `{"vehicle_id":"65a1","mileage":1}`: "tracking.65a1.vehicle_updated",
+        `{"vehicle_id":"a.#"}`:              "tracking.unknown.vehicle_updated",
+        `{"mileage":1}`:                     "tracking.unknown.vehicle_updated",
+        `not json`:                          "tracking.unknown.vehicle_updated",
+    } {
+        got := routingKey([]byte(body))
+        if got != want {
+            t.Errorf("expected the routing key %s of %s, got %s", want, body, got)
+        }
+        if len(strings.Split(got, ".")) != 3 {
+            t.Errorf("expected the routing key %s to have three segments", got)
+        }
+    }
+    if key := TrackingBindingKey(event.TypeVehicleUpdated); key != "tracking.*.vehicle_updated" {
+        t.Errorf("expected the binding key of every vehicle, got %s", key)
+    }
+}
+
+func TestEventRoutingKey(t *testing.T) {
+    for body, want := range map[string]string{
+        `{"event":"geofence.enter","vehicle_id":"v1"}`:                          "tracking.v1.geofence_enter",
+        `{"event":"vehicle.speeding","vehicle_id":"v1"}`:                        "tracking.v1.vehicle_speeding",
+        `{"event":"device.offline","state":{"vehicle_id":"v1"}}`:                "tracking.v1.device_offline",
+        `{"event":"fuel.anomaly","anomaly":{"vehicle_id":"v1"}}`:                "tracking.v1.fuel_anomaly",
+        `{"event":"alert.triggered","alert":{"vehicle_id":"v1"}}`:               "tracking.v1.alert_triggered",
+        `{"event":"maintenance.due","maintenance":{"vehicle_id":"v1"}}`:         "tracking.v1.maintenance_due",
+        `{"event":"vehicle.status_suggested","suggestion":{"vehicle_id":"v1"}}`: "tracking.v1.vehicle_status_suggested",
+        `{"event":"report.ready","period":"daily"}`:                             "tracking.unknown.report_ready",
+        `{"vehicle_id":"v1"}`: "tracking.v1.unknown",
+        `not json`:            "tracking.unknown.unknown",
+    } {
+        got := EventRoutingKey([]byte(body))
+        if got != want {
+            t.Errorf("expected the routing key %s of %s, got %s", want, body, got)
+        }
+        if len(strings.Split(got, ".")) != 3 {
+            t.Errorf("expected the routing key %s to have three segments", got)
+        }
+    }
+}
+
+func TestDualFormatVehicleEventService_TrackingDataMessage(t *testing.T) {
+    envelope := &queuePublisher{}
+    s := NewDualFormatVehicleEventService(&queuePublisher{}, "vehicle", envelope, "vehicle.events", "envelope", nil)
+    created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+    record := positionedRecord(primitive.NewObjectID(), created, 16.8, 96.15)
+    record.ID = primitive.NewObjectID()
+    record.SetDistance(120, 4500).SetSpeed(43.2)
+
+    if got := TrackingDataMessage(nil, []byte(`{"raw":true}`)); string(got) != `{"raw":true}` {
+        t.Errorf("expected the received body without a record, got %s", got)
+    }
+    if err := s.Publish(context.Background(), TrackingDataMessage(record, nil)); err != nil {
+        t.Fatal(err)
+    }
+    var published VehicleUpdateEvent
+    if err := json.Unmarshal(envelope.bodies[0], &published); err != nil {
+        t.Fatal(err)
+    }
+    if published.SchemaVersion != event.TrackingDataSchemaVersion || len(published.ID) != 26 {
+        t.Errorf("expected a versioned event with an id, got %+v", published)
+    }
+    if !published.Time.Equal(created) || published.ProducedAt.IsZero() {
+        t.Errorf("expected the time of the reading and when it was produced, got %+v", published)
+    }
+    var data repositories.TrackingRecord
+    if err := json.Unmarshal(published.Data, &data); err != nil {
+        t.Fatal(err)
+    }
+    if data.ID != record.ID || data.SpeedKmh == nil || *data.SpeedKmh != 43.2 || *data.DistanceMeters != 120 {
+        t.Errorf("expected the stored tracking data, got %+v", data)
+    }
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+    "context"
+    "net/url"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+)
+
+const (
+    // vehicleStateBackfillVehicles is the number of vehicles the backfill computes the state of at most
+    vehicleStateBackfillVehicles = 100000
+    // vehicleStateBackfillBatch is the number of vehicles the latest reading is looked up for at once
+    vehicleStateBackfillBatch = 100
+)
+
+type VehicleStateService interface {
+    // FindStates returns a page of the current states of the vehicles
+    FindStates(ctx context.Context, query url.Values) ([]*repositories.VehicleState, error)
+    // Backfill computes the state of every vehicle from its latest reading when no vehicle has a state yet, it
+    // returns the number of states computed
+    Backfill(ctx context.Context) (int, error)
+}
+
+type MongoVehicleStateService struct {
+    stateRepo     repositories.VehicleStateRepository
+    trackingRepo  repositories.TrackingRepository
+    accessService AccessService
+}
+
+func NewMongoVehicleStateService(
+    stateRepo repositories.VehicleStateRepository,
+    trackingRepo repositories.TrackingRepository,
+    accessService AccessService,
+) *MongoVehicleStateService {
+    return &MongoVehicleStateService{stateRepo: stateRepo, trackingRepo: trackingRepo, accessService: accessService}
+}
+
+func (s *MongoVehicleStateService) FindStates(
+    ctx context.Context,
+    query url.Values,
+) ([]*repositories.VehicleState, error) {
+    var filter repositories.VehicleStateFilter
+    if err := decodeQuery(query, &filter); err != nil {
+        return nil, err
+    }
+    if err := filter.Build(); err != nil {
+        return nil, err
+    }
+    scope, err := s.accessService.VehicleScope(ctx)
+    if err != nil {
+        return nil, err
+    }
+    scope.Restrict(&filter)
+    return s.stateRepo.FindStates(ctx, &filter)
+}
+
+// Backfill fills the states of the vehicles tracked before the states were maintained, of every tenant. The
+// readings stored meanwhile update the states themselves, the backfill never overwrites a newer state.
+func (s *MongoVehicleStateService) Backfill(ctx context.Context) (int, error) {
+    count, err := s.stateRepo.CountStates(ctx)
+    if err != nil || count > 0 {
+        return 0, err
+    }
+    vehicles, err := s.trackingRepo.FindActiveVehicles(ctx, time.Time{}, vehicleStateBackfillVehicles)
+    if err != nil {
+        return 0, err
+    }
+    backfilled := 0
+    for start := 0; start < len(vehicles); start += vehicleStateBackfillBatch {
+        batch := vehicles[start:min(start+vehicleStateBackfillBatch, len(vehicles))]
+        limits := make([]repositories.VehicleLimit, 0, len(batch))
+        for _, vehicle := range batch {
+            limits = append(limits, repositories.VehicleLimit{VehicleID: vehicle.VehicleID, Limit: 1})
+        }
+        latest, err := s.trackingRepo.FindLatestTrackingData(ctx, limits)
+        if err != nil {
+            return backfilled, err
+        }
+        records := make([]*repositories.TrackingRecord, 0, len(latest))
+        for _, trackingData := range latest {
+            records = append(records, trackingData...)
+        }
+        if err := s.stateRepo.SaveStates(ctx, records); err != nil {
+            return backfilled, err
+        }
+        backfilled += len(records)
+    }
+    return backfilled, nil
+}
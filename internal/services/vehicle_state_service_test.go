@@ -0,0 +1,76 @@
+package services
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeStateRepo keeps the saved records of every vehicle
+type fakeStateRepo struct {
+    repositories.VehicleStateRepository
+    saved map[primitive.ObjectID]*repositories.TrackingRecord
+}
+
+func (r *fakeStateRepo) SaveStates(_ context.Context, records []*repositories.TrackingRecord) error {
+    for _, record := range records {
+        r.saved[record.VehicleID] = record
+    }
+    return nil
+}
+
+func (r *fakeStateRepo) CountStates(context.Context) (int64, error) {
+    return int64(len(r.saved)), nil
+}
+
+func TestVehicleStateService_Backfill(t *testing.T) {
+    trackingRepo := repositories.NewMemoryTrackingRepository()
+    stateRepo := &fakeStateRepo{saved: map[primitive.ObjectID]*repositories.TrackingRecord{}}
+    s := NewMongoVehicleStateService(stateRepo, trackingRepo, NewMongoAccessService(&fakeAssignmentRepo{}, nil))
+    ctx := context.Background()
+    now := time.Now().Truncate(time.Second)
+
+    latest := map[primitive.ObjectID]primitive.ObjectID{}
+    for range 3 {
+        vehicleID := primitive.NewObjectID()
+        for i := range 2 {
+            record := repositories.NewTrackingRecord(
+                &models.TrackingData{
+                    ID:            primitive.NewObjectID(),
+                    VehicleID:     vehicleID,
+                    Location:      "Yangon",
+                    Mileage:       float64(1200 + i),
+                    Status:        models.VehicleStatusActive,
+                    FuelCondition: models.FuelConditionFull,
+                    CreatedAt:     now.Add(time.Duration(i) * time.Minute),
+                },
+            )
+            if err := trackingRepo.CreateTrackingData(ctx, record); err != nil {
+                t.Fatal(err)
+            }
+            latest[vehicleID] = record.ID
+        }
+    }
+
+    backfilled, err := s.Backfill(ctx)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if backfilled != len(latest) {
+        t.Fatalf("backfilled %d states, want %d", backfilled, len(latest))
+    }
+    for vehicleID, id := range latest {
+        if state := stateRepo.saved[vehicleID]; state == nil || state.ID != id {
+            t.Fatalf("state of %s is %v, want the latest reading %s", vehicleID.Hex(), state, id.Hex())
+        }
+    }
+
+    // the states are only backfilled once
+    if backfilled, err = s.Backfill(ctx); err != nil || backfilled != 0 {
+        t.Fatalf("second backfill computed %d states, %v", backfilled, err)
+    }
+}
@@ -0,0 +1,249 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "net/url"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+    // vehicleCacheMaxEntries bounds the vehicles cached by the HTTP directory, the cache is cleared when it is full
+    vehicleCacheMaxEntries = 10000
+    // vehicleBreakerFailures is how many lookups in a row fail before the vehicle service isn't asked for
+    // vehicleBreakerCooldown, after it a single lookup tries it again
+    vehicleBreakerFailures = 5
+    vehicleBreakerCooldown = 30 * time.Second
+)
+
+var (
+    ErrUnknownVehicle            = errors.New("vehicle doesn't exist")
+    ErrVehicleServiceUnavailable = errors.New("vehicle service is unavailable")
+)
+
+type VehicleDirectory interface {
+    // VehicleExists reports whether the vehicle is registered, ErrVehicleServiceUnavailable when it can't be told
+    VehicleExists(ctx context.Context, vehicleID string) (bool, error)
+}
+
+// HTTPVehicleDirectory asks the vehicle service for GET {url}/{vehicle_id}, 200 is a registered vehicle and 404 an
+// unknown one. The answers are cached for the TTL and the vehicle service isn't asked while it keeps failing.
+type HTTPVehicleDirectory struct {
+    client *http.Client
+    url    string
+    // token is sent as a bearer token when it is set
+    token string
+    ttl   time.Duration
+
+    mu       sync.Mutex
+    vehicles map[string]vehicleLookup
+    failures int
+    // openUntil is when the vehicle service is asked again after failing vehicleBreakerFailures times in a row
+    openUntil time.Time
+}
+
+type vehicleLookup struct {
+    exists  bool
+    expires time.Time
+}
+
+func NewHTTPVehicleDirectory(client *http.Client, url string, token string, ttl time.Duration) *HTTPVehicleDirectory {
+    return &HTTPVehicleDirectory{
+        client:   client,
+        url:      strings.TrimSuffix(url, "/"),
+        token:    token,
+        ttl:      ttl,
+        vehicles: map[string]vehicleLookup{},
+    }
+}
+
+func (d *HTTPVehicleDirectory) VehicleExists(ctx context.Context, vehicleID string) (bool, error) {
+    // vehicles are registered per tenant, the same id of another tenant is another vehicle
+    tenantID, _ := tenant.FromContext(ctx)
+    key := tenantID + "/" + vehicleID
+    if exists, ok := d.cached(key); ok {
+        return exists, nil
+    }
+    if !d.allow(time.Now()) {
+        return false, ErrVehicleServiceUnavailable
+    }
+    exists, err := d.lookup(ctx, tenantID, vehicleID)
+    d.record(err, time.Now())
+    if err != nil {
+        return false, fmt.Errorf("%w: %w", ErrVehicleServiceUnavailable, err)
+    }
+    d.cache(key, exists)
+    return exists, nil
+}
+
+func (d *HTTPVehicleDirectory) cached(key string) (bool, bool) {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    lookup, ok := d.vehicles[key]
+    if !ok || time.Now().After(lookup.expires) {
+        return false, false
+    }
+    return lookup.exists, true
+}
+
+func (d *HTTPVehicleDirectory) cache(key string, exists bool) {
+    if d.ttl <= 0 {
+        return
+    }
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    if len(d.vehicles) >= vehicleCacheMaxEntries {
+        clear(d.vehicles)
+    }
+    d.vehicles[key] = vehicleLookup{exists: exists, expires: time.Now().Add(d.ttl)}
+}
+
+// allow reports whether the vehicle service is asked, the first lookup after the cooldown moves the reopening so
+// the other lookups keep waiting for its answer
+func (d *HTTPVehicleDirectory) allow(now time.Time) bool {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    if d.failures < vehicleBreakerFailures {
+        return true
+    }
+    if now.Before(d.openUntil) {
+        return false
+    }
+    d.openUntil = now.Add(vehicleBreakerCooldown)
+    return true
+}
+
+// record counts the failed lookups in a row, the breaker opens at vehicleBreakerFailures and a success closes it
+func (d *HTTPVehicleDirectory) record(err error, now time.Time) {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    if err == nil {
+        d.failures = 0
+        return
+    }
+    d.failures++
+    if d.failures >= vehicleBreakerFailures {
+        d.openUntil = now.Add(vehicleBreakerCooldown)
+    }
+}
+
+func (d *HTTPVehicleDirectory) lookup(ctx context.Context, tenantID string, vehicleID string) (bool, error) {
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.url+"/"+url.PathEscape(vehicleID), nil)
+    if err != nil {
+        return false, err
+    }
+    if d.token != "" {
+        req.Header.Set("Authorization", "Bearer "+d.token)
+    }
+    if tenantID != "" {
+        req.Header.Set(tenant.Header, tenantID)
+    }
+    res, err := d.client.Do(req)
+    if err != nil {
+        return false, err
+    }
+    defer res.Body.Close()
+    switch res.StatusCode {
+    case http.StatusOK:
+        return true, nil
+    case http.StatusNotFound:
+        return false, nil
+    }
+    return false, fmt.Errorf("vehicle service responded with %s", res.Status)
+}
+
+// VehicleValidatingTrackingService only passes the readings of registered vehicles to the wrapped service, the
+// readings of unknown vehicles are published to the quarantine queue and rejected with ErrUnknownVehicle. Readings
+// are accepted when the vehicle service is unavailable, so an outage of it doesn't stop the ingestion.
+type VehicleValidatingTrackingService struct {
+    TrackingService
+    directory  VehicleDirectory
+    quarantine Publisher
+}
+
+func NewVehicleValidatingTrackingService(
+    trackingService TrackingService,
+    directory VehicleDirectory,
+    quarantine Publisher,
+) *VehicleValidatingTrackingService {
+    return &VehicleValidatingTrackingService{
+        TrackingService: trackingService,
+        directory:       directory,
+        quarantine:      quarantine,
+    }
+}
+
+func (s *VehicleValidatingTrackingService) TrackVehicle(
+    ctx context.Context,
+    req *TrackingDataRequest,
+) (*repositories.TrackingRecord, error) {
+    if err := s.validate(ctx, req); err != nil {
+        return nil, err
+    }
+    return s.TrackingService.TrackVehicle(ctx, req)
+}
+
+// TrackVehicles only passes the readings of registered vehicles to the wrapped service
+func (s *VehicleValidatingTrackingService) TrackVehicles(
+    ctx context.Context,
+    reqs []*TrackingDataRequest,
+) ([]*repositories.TrackingRecord, []error) {
+    results := make([]*repositories.TrackingRecord, len(reqs))
+    errs := make([]error, len(reqs))
+
+    accepted := make([]*TrackingDataRequest, 0, len(reqs))
+    // indexes maps the position in accepted back to the position in reqs
+    indexes := make([]int, 0, len(reqs))
+    for i, req := range reqs {
+        if err := s.validate(ctx, req); err != nil {
+            errs[i] = err
+            continue
+        }
+        accepted = append(accepted, req)
+        indexes = append(indexes, i)
+    }
+    if len(accepted) == 0 {
+        return results, errs
+    }
+
+    trackingData, trackErrs := s.TrackingService.TrackVehicles(ctx, accepted)
+    for j, i := range indexes {
+        results[i], errs[i] = trackingData[j], trackErrs[j]
+    }
+    return results, errs
+}
+
+// validate rejects the readings of unknown vehicles, invalid vehicle ids are left to the validation of the wrapped
+// service
+func (s *VehicleValidatingTrackingService) validate(ctx context.Context, req *TrackingDataRequest) error {
+    if !primitive.IsValidObjectID(req.VehicleID) {
+        return nil
+    }
+    exists, err := s.directory.VehicleExists(ctx, req.VehicleID)
+    if err != nil {
+        log.Println("Failed to check vehicle, accepting the reading: ", err)
+        return nil
+    }
+    if exists {
+        return nil
+    }
+
+    body, err := json.Marshal(req)
+    if err == nil {
+        err = s.quarantine.Publish(ctx, body)
+    }
+    if err != nil {
+        log.Println("Failed to quarantine reading: ", err)
+    }
+    return fmt.Errorf("%w: %s", ErrUnknownVehicle, req.VehicleID)
+}
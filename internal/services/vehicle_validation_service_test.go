@@ -0,0 +1,151 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-models"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// storingTrackingService accepts every reading it is passed
+type storingTrackingService struct {
+    TrackingService
+    stored []*TrackingDataRequest
+}
+
+func (s *storingTrackingService) TrackVehicle(
+    _ context.Context,
+    req *TrackingDataRequest,
+) (*repositories.TrackingRecord, error) {
+    s.stored = append(s.stored, req)
+    return &repositories.TrackingRecord{}, nil
+}
+
+func (s *storingTrackingService) TrackVehicles(
+    _ context.Context,
+    reqs []*TrackingDataRequest,
+) ([]*repositories.TrackingRecord, []error) {
+    s.stored = append(s.stored, reqs...)
+    records := make([]*repositories.TrackingRecord, len(reqs))
+    for i := range records {
+        records[i] = &repositories.TrackingRecord{}
+    }
+    return records, make([]error, len(reqs))
+}
+
+// fakeVehicleDirectory knows the vehicles of the map, it is unavailable when err is set
+type fakeVehicleDirectory struct {
+    vehicles map[string]bool
+    err      error
+}
+
+func (d *fakeVehicleDirectory) VehicleExists(_ context.Context, vehicleID string) (bool, error) {
+    return d.vehicles[vehicleID], d.err
+}
+
+// vehicleReading returns a valid live reading of the vehicle
+func vehicleReading(vehicleID string) *TrackingDataRequest {
+    return &TrackingDataRequest{
+        TrackingDataRequest: models.TrackingDataRequest{
+            VehicleID:     vehicleID,
+            Location:      "Yangon",
+            Mileage:       1200,
+            Status:        models.VehicleStatusActive,
+            FuelCondition: models.FuelConditionFull,
+        },
+    }
+}
+
+func TestHTTPVehicleDirectory_VehicleExists(t *testing.T) {
+    known := primitive.NewObjectID().Hex()
+    var calls atomic.Int32
+    var failing atomic.Bool
+    server := httptest.NewServer(
+        http.HandlerFunc(
+            func(w http.ResponseWriter, r *http.Request) {
+                calls.Add(1)
+                switch {
+                case failing.Load():
+                    w.WriteHeader(http.StatusBadGateway)
+                case strings.HasSuffix(r.URL.Path, "/"+known):
+                    w.WriteHeader(http.StatusOK)
+                default:
+                    w.WriteHeader(http.StatusNotFound)
+                }
+            },
+        ),
+    )
+    defer server.Close()
+
+    ctx := context.Background()
+    directory := NewHTTPVehicleDirectory(server.Client(), server.URL+"/api/v1/vehicles/", "", time.Minute)
+    if exists, err := directory.VehicleExists(ctx, known); err != nil || !exists {
+        t.Errorf("expected the vehicle to exist, got %v, %v", exists, err)
+    }
+    if exists, err := directory.VehicleExists(ctx, known); err != nil || !exists || calls.Load() != 1 {
+        t.Errorf("expected the cached vehicle, got %v, %v after %d calls", exists, err, calls.Load())
+    }
+    if exists, err := directory.VehicleExists(ctx, primitive.NewObjectID().Hex()); err != nil || exists {
+        t.Errorf("expected an unknown vehicle, got %v, %v", exists, err)
+    }
+
+    // the vehicle service isn't asked anymore once it failed enough times in a row
+    failing.Store(true)
+    calls.Store(0)
+    for range vehicleBreakerFailures + 2 {
+        if _, err := directory.VehicleExists(ctx, primitive.NewObjectID().Hex()); !errors.Is(
+            err,
+            ErrVehicleServiceUnavailable,
+        ) {
+            t.Errorf("expected the vehicle service to be unavailable, got %v", err)
+        }
+    }
+    if calls.Load() != vehicleBreakerFailures {
+        t.Errorf("expected %d calls before the breaker opened, got %d", vehicleBreakerFailures, calls.Load())
+    }
+    if !directory.allow(time.Now().Add(vehicleBreakerCooldown)) {
+        t.Error("expected a lookup to be allowed after the cooldown")
+    }
+}
+
+func TestVehicleValidatingTrackingService(t *testing.T) {
+    ctx := context.Background()
+    known, unknown := primitive.NewObjectID().Hex(), primitive.NewObjectID().Hex()
+    directory := &fakeVehicleDirectory{vehicles: map[string]bool{known: true}}
+    storing := &storingTrackingService{}
+    quarantine := &queuePublisher{}
+    s := NewVehicleValidatingTrackingService(storing, directory, quarantine)
+
+    if _, err := s.TrackVehicle(ctx, vehicleReading(known)); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := s.TrackVehicle(ctx, vehicleReading(unknown)); !errors.Is(err, ErrUnknownVehicle) {
+        t.Errorf("expected an unknown vehicle, got %v", err)
+    }
+    if len(quarantine.bodies) != 1 || !strings.Contains(string(quarantine.bodies[0]), unknown) {
+        t.Errorf("expected the reading to be quarantined, got %q", quarantine.bodies)
+    }
+
+    // the readings of unknown vehicles are left out of batches
+    _, errs := s.TrackVehicles(ctx, []*TrackingDataRequest{vehicleReading(unknown), vehicleReading(known)})
+    if !errors.Is(errs[0], ErrUnknownVehicle) || errs[1] != nil {
+        t.Errorf("expected only the unknown vehicle to be rejected, got %v", errs)
+    }
+    if len(storing.stored) != 2 || len(quarantine.bodies) != 2 {
+        t.Errorf("expected 2 stored and quarantined readings, got %d, %d", len(storing.stored), len(quarantine.bodies))
+    }
+
+    // readings are accepted while the vehicle service is unavailable
+    directory.err = ErrVehicleServiceUnavailable
+    if _, err := s.TrackVehicle(ctx, vehicleReading(unknown)); err != nil {
+        t.Errorf("expected the reading to be accepted, got %v", err)
+    }
+}
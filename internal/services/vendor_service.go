@@ -0,0 +1,281 @@
+package services
+
+import (
+    "context"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "net/url"
+    "slices"
+    "time"
+
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+    vendorKeyPrefix = "vk_"
+
+    // defaultHealthWindow is the period device health is computed over when no time range is given
+    defaultHealthWindow = 24 * time.Hour
+    // degradedErrorRate is the share of rejected messages above which a device is reported as degraded
+    degradedErrorRate = 0.05
+
+    DeviceHealthy  = "healthy"
+    DeviceDegraded = "degraded"
+    DeviceOffline  = "offline"
+)
+
+var (
+    ErrInvalidAPIKey     = errors.New("invalid api key")
+    ErrDeviceNotOwned    = errors.New("device is not registered to the vendor")
+    ErrUnknownScope      = errors.New("unknown scope")
+    ErrVendorNameMissing = errors.New("vendor name is required")
+)
+
+var vendorScopes = []string{repositories.ScopeIngestionErrorsRead, repositories.ScopeDeviceHealthRead}
+
+type VendorRequest struct {
+    Name      string   `json:"name" validate:"required"`
+    Scopes    []string `json:"scopes"`
+    DeviceIDs []string `json:"device_ids" validate:"dive,mongodb"`
+}
+
+type VendorDevicesRequest struct {
+    VendorID  string   `json:"vendor_id" validate:"required,mongodb"`
+    DeviceIDs []string `json:"device_ids" validate:"dive,mongodb"`
+}
+
+// DeviceHealth is the ingestion health of a single device over a time range
+type DeviceHealth struct {
+    DeviceID        string          `json:"device_id"`
+    Status          string          `json:"status"`
+    Readings        int64           `json:"readings"`
+    IngestionErrors int64           `json:"ingestion_errors"`
+    LastSeen        *timestamp.Time `json:"last_seen,omitempty"`
+    From            timestamp.Time  `json:"from"`
+    To              timestamp.Time  `json:"to"`
+}
+
+type VendorService interface {
+    // CreateVendor returns the created vendor and its API key, the key can't be retrieved later
+    CreateVendor(ctx context.Context, req *VendorRequest) (*repositories.Vendor, string, error)
+    FindVendors(ctx context.Context) ([]*repositories.Vendor, error)
+    SetVendorDevices(ctx context.Context, req *VendorDevicesRequest) (*repositories.Vendor, error)
+    // RevokeVendorKey revokes the API key of the vendor, a new one is only issued by registering the vendor again
+    RevokeVendorKey(ctx context.Context, vendorID string) (*repositories.Vendor, error)
+    Authenticate(ctx context.Context, apiKey string) (*repositories.Vendor, error)
+    FindIngestionErrors(
+        ctx context.Context,
+        vendor *repositories.Vendor,
+        query url.Values,
+    ) ([]*repositories.IngestionError, error)
+    FindDeviceHealth(ctx context.Context, vendor *repositories.Vendor, query url.Values) ([]*DeviceHealth, error)
+}
+
+type MongoVendorService struct {
+    vendorRepo         repositories.VendorRepository
+    ingestionErrorRepo repositories.IngestionErrorRepository
+    statsRepo          repositories.TrackingStatsRepository
+}
+
+func NewMongoVendorService(
+    vendorRepo repositories.VendorRepository,
+    ingestionErrorRepo repositories.IngestionErrorRepository,
+    statsRepo repositories.TrackingStatsRepository,
+) *MongoVendorService {
+    return &MongoVendorService{
+        vendorRepo:         vendorRepo,
+        ingestionErrorRepo: ingestionErrorRepo,
+        statsRepo:          statsRepo,
+    }
+}
+
+func (s *MongoVendorService) CreateVendor(
+    ctx context.Context,
+    req *VendorRequest,
+) (*repositories.Vendor, string, error) {
+    if req.Name == "" {
+        return nil, "", ErrVendorNameMissing
+    }
+    scopes := req.Scopes
+    if len(scopes) == 0 {
+        scopes = vendorScopes
+    }
+    for _, scope := range scopes {
+        if !slices.Contains(vendorScopes, scope) {
+            return nil, "", fmt.Errorf("%w: %s", ErrUnknownScope, scope)
+        }
+    }
+
+    secret := make([]byte, 32)
+    if _, err := rand.Read(secret); err != nil {
+        return nil, "", err
+    }
+    apiKey := vendorKeyPrefix + hex.EncodeToString(secret)
+
+    vendor := &repositories.Vendor{
+        Name:      req.Name,
+        KeyHash:   hashAPIKey(apiKey),
+        KeyPrefix: apiKey[:len(vendorKeyPrefix)+6],
+        Scopes:    scopes,
+        DeviceIDs: req.DeviceIDs,
+    }
+    if err := s.vendorRepo.CreateVendor(ctx, vendor); err != nil {
+        return nil, "", err
+    }
+    return vendor, apiKey, nil
+}
+
+func (s *MongoVendorService) FindVendors(ctx context.Context) ([]*repositories.Vendor, error) {
+    return s.vendorRepo.FindVendors(ctx)
+}
+
+func (s *MongoVendorService) SetVendorDevices(
+    ctx context.Context,
+    req *VendorDevicesRequest,
+) (*repositories.Vendor, error) {
+    id, err := primitive.ObjectIDFromHex(req.VendorID)
+    if err != nil {
+        return nil, repositories.ErrInvalidID
+    }
+    deviceIDs := req.DeviceIDs
+    if deviceIDs == nil {
+        deviceIDs = []string{}
+    }
+    return s.vendorRepo.SetVendorDevices(ctx, id, deviceIDs)
+}
+
+func (s *MongoVendorService) RevokeVendorKey(ctx context.Context, vendorID string) (*repositories.Vendor, error) {
+    id, err := primitive.ObjectIDFromHex(vendorID)
+    if err != nil {
+        return nil, repositories.ErrInvalidID
+    }
+    return s.vendorRepo.RevokeVendorKey(ctx, id)
+}
+
+// Authenticate returns the vendor owning the API key, a revoked key is invalid
+func (s *MongoVendorService) Authenticate(ctx context.Context, apiKey string) (*repositories.Vendor, error) {
+    if len(apiKey) <= len(vendorKeyPrefix) || apiKey[:len(vendorKeyPrefix)] != vendorKeyPrefix {
+        return nil, ErrInvalidAPIKey
+    }
+    vendor, err := s.vendorRepo.FindVendorByKeyHash(ctx, hashAPIKey(apiKey))
+    if errors.Is(err, repositories.ErrVendorNotFound) || err == nil && vendor.RevokedAt != nil {
+        return nil, ErrInvalidAPIKey
+    }
+    return vendor, err
+}
+
+// FindIngestionErrors finds the ingestion errors of the devices registered to the vendor
+func (s *MongoVendorService) FindIngestionErrors(
+    ctx context.Context,
+    vendor *repositories.Vendor,
+    query url.Values,
+) ([]*repositories.IngestionError, error) {
+    var filter repositories.IngestionErrorFilter
+    if err := decodeQuery(query, &filter); err != nil {
+        return nil, err
+    }
+    if filter.VehicleID != "" && !vendor.OwnsDevice(filter.VehicleID) {
+        return nil, ErrDeviceNotOwned
+    }
+    filter.RestrictVehicles(vendorDeviceIDs(vendor))
+    return s.ingestionErrorRepo.FindIngestionErrors(ctx, &filter)
+}
+
+// FindDeviceHealth reports the health of the devices registered to the vendor over from and to,
+// the last 24 hours by default. A device without readings is offline, and one with more than
+// 5% of its messages rejected is degraded.
+func (s *MongoVendorService) FindDeviceHealth(
+    ctx context.Context,
+    vendor *repositories.Vendor,
+    query url.Values,
+) ([]*DeviceHealth, error) {
+    var filter repositories.TrackingStatsFilter
+    if err := decodeQuery(query, &filter); err != nil {
+        return nil, err
+    }
+    if filter.VehicleID != "" && !vendor.OwnsDevice(filter.VehicleID) {
+        return nil, ErrDeviceNotOwned
+    }
+
+    now := time.Now().UTC()
+    if filter.To == "" {
+        filter.To = now.Format(time.RFC3339)
+    }
+    if filter.From == "" {
+        to, err := time.Parse(time.RFC3339, filter.To)
+        if err != nil {
+            return nil, repositories.ErrInvalidTimeRange
+        }
+        filter.From = to.Add(-defaultHealthWindow).Format(time.RFC3339)
+    }
+
+    deviceIDs := vendorDeviceIDs(vendor)
+    if filter.VehicleID != "" {
+        deviceIDs = []string{filter.VehicleID}
+    }
+    objectIDs := make([]primitive.ObjectID, 0, len(deviceIDs))
+    for _, deviceID := range deviceIDs {
+        id, err := primitive.ObjectIDFromHex(deviceID)
+        if err != nil {
+            continue
+        }
+        objectIDs = append(objectIDs, id)
+    }
+    filter.RestrictVehicles(objectIDs)
+
+    stats, err := s.statsRepo.FindVehicleStats(ctx, &filter)
+    if err != nil {
+        return nil, err
+    }
+    // the filter was built by the stats repository, so the time range is valid
+    from, _ := time.Parse(time.RFC3339, filter.From)
+    to, _ := time.Parse(time.RFC3339, filter.To)
+    errorCounts, err := s.ingestionErrorRepo.CountIngestionErrors(ctx, deviceIDs, from, to)
+    if err != nil {
+        return nil, err
+    }
+
+    byDevice := make(map[string]*repositories.VehicleStats, len(stats))
+    for _, stat := range stats {
+        byDevice[stat.VehicleID.Hex()] = stat
+    }
+
+    health := make([]*DeviceHealth, 0, len(deviceIDs))
+    for _, deviceID := range deviceIDs {
+        device := &DeviceHealth{
+            DeviceID:        deviceID,
+            Status:          DeviceOffline,
+            IngestionErrors: errorCounts[deviceID],
+            From:            timestamp.New(from),
+            To:              timestamp.New(to),
+        }
+        if stat, ok := byDevice[deviceID]; ok {
+            device.Readings = stat.Readings
+            device.LastSeen = &stat.LastSeen
+            device.Status = DeviceHealthy
+            total := float64(device.Readings + device.IngestionErrors)
+            if float64(device.IngestionErrors)/total > degradedErrorRate {
+                device.Status = DeviceDegraded
+            }
+        }
+        health = append(health, device)
+    }
+    return health, nil
+}
+
+func vendorDeviceIDs(vendor *repositories.Vendor) []string {
+    if vendor.DeviceIDs == nil {
+        return []string{}
+    }
+    return vendor.DeviceIDs
+}
+
+func hashAPIKey(apiKey string) string {
+    sum := sha256.Sum256([]byte(apiKey))
+    return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,500 @@
+package services
+
+import (
+    "bytes"
+    "context"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "net/url"
+    "slices"
+    "strconv"
+    "sync"
+    "time"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/event"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+    WebhookEventHeader     = "X-Webhook-Event"
+    WebhookDeliveryHeader  = "X-Webhook-Delivery"
+    WebhookTimestampHeader = "X-Webhook-Timestamp"
+    WebhookSignatureHeader = "X-Webhook-Signature"
+
+    webhookSecretPrefix = "whsec_"
+    // webhookBatch is the number of due deliveries claimed at once
+    webhookBatch = 50
+    // webhookConcurrency is the number of deliveries attempted at once, so a slow endpoint doesn't hold up the others
+    webhookConcurrency = 10
+    // webhookCacheTTL is how long the subscribed webhooks are reused, changes made on another instance apply after it
+    webhookCacheTTL = 10 * time.Second
+    // maxWebhookBackoff caps the time between two attempts of a delivery
+    maxWebhookBackoff = 6 * time.Hour
+    // webhookPollInterval is how often the due deliveries are looked for when there were none
+    webhookPollInterval = 2 * time.Second
+)
+
+var (
+    ErrUnknownWebhookEvent = errors.New("unknown webhook event")
+    ErrWebhookRejected     = errors.New("webhook rejected the delivery")
+    ErrWebhookInactive     = errors.New("webhook is inactive")
+)
+
+// AlertEvents are the event types published to the alerts queue
+var AlertEvents = []string{
+    GeofenceEnterEvent,
+    GeofenceExitEvent,
+    SpeedingEvent,
+    DeviceOfflineEvent,
+    FuelAnomalyEvent,
+    SafetyScoreChangedEvent,
+    AlertTriggeredEvent,
+    AlertResolvedEvent,
+}
+
+// WebhookEvents are the event types webhooks can subscribe to, the vehicle updates and the alerts
+var WebhookEvents = append([]string{event.TypeVehicleUpdated}, AlertEvents...)
+
+type WebhookRequest struct {
+    URL    string   `json:"url" validate:"required,http_url"`
+    Events []string `json:"events" validate:"required,min=1"`
+    // Active is true when it is left out
+    Active *bool `json:"active"`
+    // RotateSecret replaces the secret of an updated webhook, the new one is part of the response
+    RotateSecret bool `json:"rotate_secret"`
+}
+
+type WebhookService interface {
+    // CreateWebhook returns the created webhook and its secret, the secret can't be retrieved later
+    CreateWebhook(ctx context.Context, req *WebhookRequest) (*repositories.Webhook, string, error)
+    FindWebhooks(ctx context.Context) ([]*repositories.Webhook, error)
+    // UpdateWebhook returns the updated webhook and its new secret when it was rotated, empty otherwise
+    UpdateWebhook(ctx context.Context, id string, req *WebhookRequest) (*repositories.Webhook, string, error)
+    DeleteWebhook(ctx context.Context, id string) error
+    FindDeliveries(ctx context.Context, id string, query url.Values) ([]*repositories.WebhookDelivery, error)
+    // Notify queues the event for the active webhooks of its tenant subscribed to its type
+    Notify(ctx context.Context, envelope *event.Envelope) error
+    // Deliver attempts the deliveries due by now and schedules the retries of the failed ones, it returns the number
+    // of deliveries attempted
+    Deliver(ctx context.Context, now time.Time) (int, error)
+}
+
+type MongoWebhookService struct {
+    webhookRepo repositories.WebhookRepository
+    client      *http.Client
+    // maxAttempts is the number of attempts of a delivery before it failed, the first retry is after backoff and
+    // every next one waits twice as long
+    maxAttempts int
+    backoff     time.Duration
+
+    mu            sync.Mutex
+    subscriptions map[webhookSubscription]*subscribedWebhooks
+}
+
+type webhookSubscription struct {
+    tenantID  string
+    eventType string
+}
+
+type subscribedWebhooks struct {
+    webhooks []*repositories.Webhook
+    loadedAt time.Time
+}
+
+func NewMongoWebhookService(
+    webhookRepo repositories.WebhookRepository,
+    client *http.Client,
+    maxAttempts int,
+    backoff time.Duration,
+) *MongoWebhookService {
+    return &MongoWebhookService{
+        webhookRepo:   webhookRepo,
+        client:        client,
+        maxAttempts:   maxAttempts,
+        backoff:       backoff,
+        subscriptions: map[webhookSubscription]*subscribedWebhooks{},
+    }
+}
+
+func (s *MongoWebhookService) CreateWebhook(
+    ctx context.Context,
+    req *WebhookRequest,
+) (*repositories.Webhook, string, error) {
+    if err := validateWebhookEvents(req.Events); err != nil {
+        return nil, "", err
+    }
+    secret, err := newWebhookSecret()
+    if err != nil {
+        return nil, "", err
+    }
+    webhook := &repositories.Webhook{
+        URL:    req.URL,
+        Events: slices.Compact(slices.Sorted(slices.Values(req.Events))),
+        Active: req.Active == nil || *req.Active,
+        Secret: secret,
+    }
+    if err = s.webhookRepo.CreateWebhook(ctx, webhook); err != nil {
+        return nil, "", err
+    }
+    s.forgetSubscriptions()
+    return webhook, secret, nil
+}
+
+func (s *MongoWebhookService) FindWebhooks(ctx context.Context) ([]*repositories.Webhook, error) {
+    return s.webhookRepo.FindWebhooks(ctx)
+}
+
+func (s *MongoWebhookService) UpdateWebhook(
+    ctx context.Context,
+    id string,
+    req *WebhookRequest,
+) (*repositories.Webhook, string, error) {
+    if err := validateWebhookEvents(req.Events); err != nil {
+        return nil, "", err
+    }
+    webhook, err := s.findWebhook(ctx, id)
+    if err != nil {
+        return nil, "", err
+    }
+    webhook.URL = req.URL
+    webhook.Events = slices.Compact(slices.Sorted(slices.Values(req.Events)))
+    webhook.Active = req.Active == nil || *req.Active
+    secret := ""
+    if req.RotateSecret {
+        if secret, err = newWebhookSecret(); err != nil {
+            return nil, "", err
+        }
+        webhook.Secret = secret
+    }
+    if err = s.webhookRepo.UpdateWebhook(ctx, webhook); err != nil {
+        return nil, "", err
+    }
+    s.forgetSubscriptions()
+    return webhook, secret, nil
+}
+
+func (s *MongoWebhookService) DeleteWebhook(ctx context.Context, id string) error {
+    objectID, err := primitive.ObjectIDFromHex(id)
+    if err != nil {
+        return repositories.ErrInvalidID
+    }
+    if err = s.webhookRepo.DeleteWebhook(ctx, objectID); err != nil {
+        return err
+    }
+    s.forgetSubscriptions()
+    return nil
+}
+
+func (s *MongoWebhookService) FindDeliveries(
+    ctx context.Context,
+    id string,
+    query url.Values,
+) ([]*repositories.WebhookDelivery, error) {
+    webhook, err := s.findWebhook(ctx, id)
+    if err != nil {
+        return nil, err
+    }
+    var filter repositories.WebhookDeliveryFilter
+    if err := decodeQuery(query, &filter); err != nil {
+        return nil, err
+    }
+    filter.ForWebhook(webhook.ID)
+    return s.webhookRepo.FindDeliveries(ctx, &filter)
+}
+
+func (s *MongoWebhookService) findWebhook(ctx context.Context, id string) (*repositories.Webhook, error) {
+    objectID, err := primitive.ObjectIDFromHex(id)
+    if err != nil {
+        return nil, repositories.ErrInvalidID
+    }
+    return s.webhookRepo.FindWebhook(ctx, objectID)
+}
+
+func (s *MongoWebhookService) Notify(ctx context.Context, envelope *event.Envelope) error {
+    webhooks, err := s.subscribedWebhooks(ctx, envelope.Type)
+    if err != nil || len(webhooks) == 0 {
+        return err
+    }
+    payload, err := json.Marshal(envelope)
+    if err != nil {
+        return err
+    }
+    deliveries := make([]*repositories.WebhookDelivery, 0, len(webhooks))
+    for _, webhook := range webhooks {
+        deliveries = append(
+            deliveries,
+            &repositories.WebhookDelivery{
+                WebhookID: webhook.ID,
+                EventID:   envelope.ID,
+                EventType: envelope.Type,
+                Payload:   payload,
+                Status:    repositories.DeliveryPending,
+            },
+        )
+    }
+    return s.webhookRepo.CreateDeliveries(ctx, deliveries)
+}
+
+// subscribedWebhooks returns the subscribed webhooks of the tenant of the context, they are read at most
+// webhookCacheTTL ago so events don't cost a query each
+func (s *MongoWebhookService) subscribedWebhooks(
+    ctx context.Context,
+    eventType string,
+) ([]*repositories.Webhook, error) {
+    tenantID, _ := tenant.FromContext(ctx)
+    key := webhookSubscription{tenantID: tenantID, eventType: eventType}
+    s.mu.Lock()
+    cached, ok := s.subscriptions[key]
+    s.mu.Unlock()
+    if ok && time.Since(cached.loadedAt) < webhookCacheTTL {
+        return cached.webhooks, nil
+    }
+
+    webhooks, err := s.webhookRepo.FindSubscribedWebhooks(ctx, eventType)
+    if err != nil {
+        return nil, err
+    }
+    s.mu.Lock()
+    s.subscriptions[key] = &subscribedWebhooks{webhooks: webhooks, loadedAt: time.Now()}
+    s.mu.Unlock()
+    return webhooks, nil
+}
+
+// forgetSubscriptions makes the next events read the subscribed webhooks again after a change
+func (s *MongoWebhookService) forgetSubscriptions() {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    clear(s.subscriptions)
+}
+
+func (s *MongoWebhookService) Deliver(ctx context.Context, now time.Time) (int, error) {
+    // a claimed delivery is attempted again by any instance once the lease passed, e.g. after a crash
+    lease := s.client.Timeout + time.Minute
+    deliveries, err := s.webhookRepo.ClaimDeliveries(ctx, now, lease, webhookBatch)
+    if len(deliveries) == 0 {
+        return 0, err
+    }
+
+    var wg sync.WaitGroup
+    slots := make(chan struct{}, webhookConcurrency)
+    for _, delivery := range deliveries {
+        wg.Add(1)
+        slots <- struct{}{}
+        go func() {
+            defer func() {
+                <-slots
+                wg.Done()
+            }()
+            s.attempt(ctx, delivery, now)
+        }()
+    }
+    wg.Wait()
+    return len(deliveries), err
+}
+
+// attempt posts the delivery to its webhook and saves the outcome, failures are kept in the delivery log
+func (s *MongoWebhookService) attempt(ctx context.Context, delivery *repositories.WebhookDelivery, now time.Time) {
+    deliveryCtx := ctx
+    if delivery.TenantID != "" {
+        deliveryCtx = tenant.WithID(ctx, delivery.TenantID)
+    }
+    delivery.Attempts++
+    delivery.ResponseStatus = 0
+    delivery.Error = ""
+
+    webhook, err := s.webhookRepo.FindWebhook(deliveryCtx, delivery.WebhookID)
+    if err == nil && !webhook.Active {
+        err = ErrWebhookInactive
+    }
+    if err == nil {
+        delivery.ResponseStatus, err = s.post(deliveryCtx, webhook, delivery, now)
+    }
+
+    switch {
+    case err == nil:
+        delivery.Status = repositories.DeliverySucceeded
+        delivery.NextAttemptAt = nil
+        delivery.DeliveredAt = timestamp.Ptr(now)
+    // deliveries of deleted or deactivated webhooks aren't retried
+    case delivery.Attempts >= s.maxAttempts,
+        errors.Is(err, repositories.ErrNotFound),
+        errors.Is(err, ErrWebhookInactive):
+        delivery.Status = repositories.DeliveryFailed
+        delivery.NextAttemptAt = nil
+        delivery.Error = err.Error()
+    default:
+        delivery.NextAttemptAt = timestamp.Ptr(now.Add(s.retryAfter(delivery.Attempts)))
+        delivery.Error = err.Error()
+    }
+    if err := s.webhookRepo.SaveAttempt(deliveryCtx, delivery); err != nil {
+        log.Println("Failed to save the webhook delivery attempt: ", err)
+    }
+}
+
+// post sends the payload signed with the secret of the webhook, it returns the status of the response, 0 without one
+func (s *MongoWebhookService) post(
+    ctx context.Context,
+    webhook *repositories.Webhook,
+    delivery *repositories.WebhookDelivery,
+    now time.Time,
+) (int, error) {
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+    if err != nil {
+        return 0, err
+    }
+    sentAt := strconv.FormatInt(now.Unix(), 10)
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set(WebhookEventHeader, delivery.EventType)
+    req.Header.Set(WebhookDeliveryHeader, delivery.ID.Hex())
+    req.Header.Set(WebhookTimestampHeader, sentAt)
+    req.Header.Set(WebhookSignatureHeader, SignWebhook(webhook.Secret, sentAt, delivery.Payload))
+
+    resp, err := s.client.Do(req)
+    if err != nil {
+        return 0, err
+    }
+    defer func() {
+        _ = resp.Body.Close()
+    }()
+    // the body is drained so the connection is reused, the response itself isn't used
+    _, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        return resp.StatusCode, fmt.Errorf("%w with status %d", ErrWebhookRejected, resp.StatusCode)
+    }
+    return resp.StatusCode, nil
+}
+
+// retryAfter is the backoff after the attempt, doubled after every attempt up to maxWebhookBackoff
+func (s *MongoWebhookService) retryAfter(attempts int) time.Duration {
+    backoff := s.backoff
+    for range attempts - 1 {
+        if backoff *= 2; backoff >= maxWebhookBackoff {
+            return maxWebhookBackoff
+        }
+    }
+    return backoff
+}
+
+// SignWebhook returns the signature of a delivery, sha256= and the hex HMAC-SHA256 of the timestamp, a dot and the
+// payload with the secret of the webhook. Consumers compute it the same way and compare it with the
+// X-Webhook-Signature header, rejecting old timestamps to prevent replays.
+func SignWebhook(secret, sentAt string, payload []byte) string {
+    mac := hmac.New(sha256.New, []byte(secret))
+    mac.Write([]byte(sentAt))
+    mac.Write([]byte("."))
+    mac.Write(payload)
+    return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func newWebhookSecret() (string, error) {
+    secret := make([]byte, 32)
+    if _, err := rand.Read(secret); err != nil {
+        return "", err
+    }
+    return webhookSecretPrefix + hex.EncodeToString(secret), nil
+}
+
+func validateWebhookEvents(events []string) error {
+    for _, eventType := range events {
+        if !slices.Contains(WebhookEvents, eventType) {
+            return fmt.Errorf("%w: %s", ErrUnknownWebhookEvent, eventType)
+        }
+    }
+    return nil
+}
+
+// WebhookDispatcher delivers the queued webhook events until ctx is done
+type WebhookDispatcher struct {
+    webhookService WebhookService
+}
+
+func NewWebhookDispatcher(webhookService WebhookService) *WebhookDispatcher {
+    return &WebhookDispatcher{webhookService: webhookService}
+}
+
+// Run blocks until ctx is done, a full batch of deliveries is followed by the next one right away
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+    for {
+        attempted, err := d.webhookService.Deliver(ctx, time.Now())
+        if err != nil {
+            log.Println("Failed to deliver the webhook events: ", err)
+        }
+        if attempted == webhookBatch && err == nil {
+            continue
+        }
+        select {
+        case <-ctx.Done():
+            return
+        case <-time.After(webhookPollInterval):
+        }
+    }
+}
+
+// WebhookPublisher publishes the alerts to the wrapped publisher and notifies the webhooks subscribed to them, the
+// alerts are the data of the events. A failure to notify the webhooks is logged without failing the publish.
+type WebhookPublisher struct {
+    Publisher
+    webhookService WebhookService
+}
+
+func NewWebhookPublisher(publisher Publisher, webhookService WebhookService) *WebhookPublisher {
+    return &WebhookPublisher{Publisher: publisher, webhookService: webhookService}
+}
+
+func (p *WebhookPublisher) Publish(ctx context.Context, body []byte) error {
+    if err := p.Publisher.Publish(ctx, body); err != nil {
+        return err
+    }
+    var alert struct {
+        Event string `json:"event"`
+    }
+    if err := json.Unmarshal(body, &alert); err != nil || !slices.Contains(WebhookEvents, alert.Event) {
+        return nil
+    }
+    if err := notify(ctx, p.webhookService, alert.Event, body); err != nil {
+        log.Println("Failed to notify the webhooks: ", err)
+    }
+    return nil
+}
+
+// WebhookProcessor is an ingestion processor notifying the webhooks of every stored reading
+type WebhookProcessor struct {
+    webhookService WebhookService
+}
+
+func NewWebhookProcessor(webhookService WebhookService) *WebhookProcessor {
+    return &WebhookProcessor{webhookService: webhookService}
+}
+
+func (p *WebhookProcessor) PreValidate(context.Context, *TrackingDataRequest) error {
+    return nil
+}
+
+func (p *WebhookProcessor) PostPersist(ctx context.Context, record *repositories.TrackingRecord) error {
+    data, err := json.Marshal(record)
+    if err != nil {
+        return err
+    }
+    return notify(ctx, p.webhookService, event.TypeVehicleUpdated, data)
+}
+
+// notify wraps the data in an event envelope of the tenant of the context for the webhooks
+func notify(ctx context.Context, webhookService WebhookService, eventType string, data []byte) error {
+    tenantID, _ := tenant.FromContext(ctx)
+    envelope, err := event.Wrap(eventType, data, time.Now(), tenantID)
+    if err != nil {
+        return err
+    }
+    return webhookService.Notify(ctx, envelope)
+}
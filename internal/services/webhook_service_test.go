@@ -0,0 +1,287 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "slices"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/goccy/go-json"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/event"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/timestamp"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeWebhookRepo keeps the webhooks and deliveries in memory, without tenants
+type fakeWebhookRepo struct {
+    mu         sync.Mutex
+    webhooks   []*repositories.Webhook
+    deliveries []*repositories.WebhookDelivery
+}
+
+func (r *fakeWebhookRepo) CreateWebhook(_ context.Context, webhook *repositories.Webhook) error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    webhook.ID = primitive.NewObjectID()
+    r.webhooks = append(r.webhooks, webhook)
+    return nil
+}
+
+func (r *fakeWebhookRepo) FindWebhooks(context.Context) ([]*repositories.Webhook, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    return slices.Clone(r.webhooks), nil
+}
+
+func (r *fakeWebhookRepo) FindWebhook(_ context.Context, id primitive.ObjectID) (*repositories.Webhook, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    for _, webhook := range r.webhooks {
+        if webhook.ID == id {
+            return webhook, nil
+        }
+    }
+    return nil, repositories.ErrWebhookNotFound
+}
+
+func (r *fakeWebhookRepo) UpdateWebhook(context.Context, *repositories.Webhook) error {
+    return nil
+}
+
+func (r *fakeWebhookRepo) DeleteWebhook(_ context.Context, id primitive.ObjectID) error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.webhooks = slices.DeleteFunc(
+        r.webhooks, func(webhook *repositories.Webhook) bool {
+            return webhook.ID == id
+        },
+    )
+    return nil
+}
+
+func (r *fakeWebhookRepo) FindSubscribedWebhooks(
+    _ context.Context,
+    eventType string,
+) ([]*repositories.Webhook, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    var webhooks []*repositories.Webhook
+    for _, webhook := range r.webhooks {
+        if webhook.Active && slices.Contains(webhook.Events, eventType) {
+            webhooks = append(webhooks, webhook)
+        }
+    }
+    return webhooks, nil
+}
+
+func (r *fakeWebhookRepo) CreateDeliveries(_ context.Context, deliveries []*repositories.WebhookDelivery) error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    for _, delivery := range deliveries {
+        delivery.ID = primitive.NewObjectID()
+        r.deliveries = append(r.deliveries, delivery)
+    }
+    return nil
+}
+
+func (r *fakeWebhookRepo) ClaimDeliveries(
+    _ context.Context,
+    now time.Time,
+    lease time.Duration,
+    limit int,
+) ([]*repositories.WebhookDelivery, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    var claimed []*repositories.WebhookDelivery
+    for _, delivery := range r.deliveries {
+        due := delivery.NextAttemptAt == nil || !delivery.NextAttemptAt.After(now)
+        if delivery.Status == repositories.DeliveryPending && due && len(claimed) < limit {
+            delivery.NextAttemptAt = timestamp.Ptr(now.Add(lease))
+            claimed = append(claimed, delivery)
+        }
+    }
+    return claimed, nil
+}
+
+func (r *fakeWebhookRepo) SaveAttempt(context.Context, *repositories.WebhookDelivery) error {
+    return nil
+}
+
+func (r *fakeWebhookRepo) FindDeliveries(
+    context.Context,
+    *repositories.WebhookDeliveryFilter,
+) ([]*repositories.WebhookDelivery, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    return slices.Clone(r.deliveries), nil
+}
+
+func TestMongoWebhookService_Deliver(t *testing.T) {
+    ctx := context.Background()
+    var (
+        mu       sync.Mutex
+        requests []*http.Request
+        bodies   [][]byte
+        status   = http.StatusInternalServerError
+    )
+    server := httptest.NewServer(
+        http.HandlerFunc(
+            func(w http.ResponseWriter, r *http.Request) {
+                body, _ := io.ReadAll(r.Body)
+                mu.Lock()
+                defer mu.Unlock()
+                requests, bodies = append(requests, r), append(bodies, body)
+                w.WriteHeader(status)
+            },
+        ),
+    )
+    defer server.Close()
+
+    repo := &fakeWebhookRepo{}
+    s := NewMongoWebhookService(repo, &http.Client{Timeout: time.Second}, 3, time.Minute)
+    webhook, secret, err := s.CreateWebhook(
+        ctx,
+        &WebhookRequest{URL: server.URL, Events: []string{GeofenceEnterEvent, SpeedingEvent}},
+    )
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !webhook.Active || len(secret) == 0 {
+        t.Fatalf("expected an active webhook with a secret, got %+v", webhook)
+    }
+    if _, _, err = s.CreateWebhook(
+        ctx,
+        &WebhookRequest{URL: server.URL, Events: []string{"vehicle.unknown"}},
+    ); !errors.Is(err, ErrUnknownWebhookEvent) {
+        t.Errorf("expected an unknown event, got %v", err)
+    }
+
+    // only the subscribed events are queued
+    publisher := NewWebhookPublisher(&recordingPublisher{}, s)
+    for _, alert := range []string{GeofenceEnterEvent, DeviceOfflineEvent} {
+        body, _ := json.Marshal(map[string]string{"event": alert})
+        if err = publisher.Publish(ctx, body); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if len(repo.deliveries) != 1 || repo.deliveries[0].EventType != GeofenceEnterEvent {
+        t.Fatalf("expected a delivery of the subscribed event, got %+v", repo.deliveries)
+    }
+    delivery := repo.deliveries[0]
+
+    // a rejected delivery is retried after the backoff, twice as late every time
+    now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+    if attempted, err := s.Deliver(ctx, now); err != nil || attempted != 1 {
+        t.Fatalf("expected an attempt, got %d, %v", attempted, err)
+    }
+    if delivery.Status != repositories.DeliveryPending || delivery.ResponseStatus != http.StatusInternalServerError {
+        t.Fatalf("expected a pending delivery after a rejection, got %+v", delivery)
+    }
+    if !delivery.NextAttemptAt.Equal(now.Add(time.Minute)) {
+        t.Errorf("expected the retry after the backoff, got %v", delivery.NextAttemptAt)
+    }
+    if attempted, _ := s.Deliver(ctx, now.Add(30*time.Second)); attempted != 0 {
+        t.Errorf("expected no attempt before the retry, got %d", attempted)
+    }
+    now = now.Add(time.Minute)
+    if _, err = s.Deliver(ctx, now); err != nil {
+        t.Fatal(err)
+    }
+    if delivery.Attempts != 2 || !delivery.NextAttemptAt.Equal(now.Add(2*time.Minute)) {
+        t.Errorf("expected the backoff to double, got %+v", delivery)
+    }
+
+    mu.Lock()
+    status = http.StatusNoContent
+    mu.Unlock()
+    now = now.Add(2 * time.Minute)
+    if _, err = s.Deliver(ctx, now); err != nil {
+        t.Fatal(err)
+    }
+    if delivery.Status != repositories.DeliverySucceeded || !delivery.DeliveredAt.Equal(now) {
+        t.Fatalf("expected a delivered delivery, got %+v", delivery)
+    }
+
+    // the consumer verifies the signature of the timestamp and payload with the secret
+    last := requests[len(requests)-1]
+    signature := SignWebhook(secret, last.Header.Get(WebhookTimestampHeader), bodies[len(bodies)-1])
+    if last.Header.Get(WebhookSignatureHeader) != signature {
+        t.Errorf("expected the signature %s, got %s", signature, last.Header.Get(WebhookSignatureHeader))
+    }
+    if last.Header.Get(WebhookEventHeader) != GeofenceEnterEvent {
+        t.Errorf("expected the event header, got %v", last.Header)
+    }
+    var envelope event.Envelope
+    if err = json.Unmarshal(bodies[len(bodies)-1], &envelope); err != nil || envelope.Type != GeofenceEnterEvent {
+        t.Errorf("expected the event envelope, got %s, %v", bodies[len(bodies)-1], err)
+    }
+}
+
+func TestMongoWebhookService_DeliverFails(t *testing.T) {
+    ctx := context.Background()
+    server := httptest.NewServer(
+        http.HandlerFunc(
+            func(w http.ResponseWriter, _ *http.Request) {
+                w.WriteHeader(http.StatusBadGateway)
+            },
+        ),
+    )
+    defer server.Close()
+
+    repo := &fakeWebhookRepo{}
+    s := NewMongoWebhookService(repo, &http.Client{Timeout: time.Second}, 2, time.Minute)
+    webhook, _, err := s.CreateWebhook(ctx, &WebhookRequest{URL: server.URL, Events: []string{SpeedingEvent}})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err = notify(ctx, s, SpeedingEvent, []byte(`{}`)); err != nil {
+        t.Fatal(err)
+    }
+    exhausted := repo.deliveries[0]
+
+    // the attempts are exhausted
+    now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+    for range 2 {
+        if _, err = s.Deliver(ctx, now); err != nil {
+            t.Fatal(err)
+        }
+        now = now.Add(time.Hour)
+    }
+    if exhausted.Status != repositories.DeliveryFailed || exhausted.Attempts != 2 || exhausted.NextAttemptAt != nil {
+        t.Errorf("expected a failed delivery after 2 attempts, got %+v", exhausted)
+    }
+
+    // deliveries of deleted webhooks fail without a retry
+    if err = notify(ctx, s, SpeedingEvent, []byte(`{}`)); err != nil {
+        t.Fatal(err)
+    }
+    deleted := repo.deliveries[1]
+    if err = s.DeleteWebhook(ctx, webhook.ID.Hex()); err != nil {
+        t.Fatal(err)
+    }
+    if _, err = s.Deliver(ctx, now); err != nil {
+        t.Fatal(err)
+    }
+    if deleted.Status != repositories.DeliveryFailed || deleted.Attempts != 1 {
+        t.Errorf("expected a failed delivery of the deleted webhook, got %+v", deleted)
+    }
+}
+
+func TestMongoWebhookService_retryAfter(t *testing.T) {
+    s := NewMongoWebhookService(&fakeWebhookRepo{}, http.DefaultClient, 20, time.Minute)
+    for attempts, want := range map[int]time.Duration{
+        1:  time.Minute,
+        3:  4 * time.Minute,
+        20: maxWebhookBackoff,
+    } {
+        if got := s.retryAfter(attempts); got != want {
+            t.Errorf("expected a backoff of %v after %d attempts, got %v", want, attempts, got)
+        }
+    }
+}
@@ -0,0 +1,106 @@
+package storage
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "strings"
+    "time"
+
+    "github.com/aws/aws-sdk-go-v2/aws"
+    awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+    "github.com/aws/aws-sdk-go-v2/credentials"
+    "github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+var (
+    ErrBucketRequired = errors.New("bucket is required")
+    ErrUploadFailed   = errors.New("upload failed")
+    ErrDownloadFailed = errors.New("download failed")
+    ErrObjectNotFound = errors.New("object not found")
+)
+
+// BlobStorage stores objects by key
+type BlobStorage interface {
+    Put(ctx context.Context, key, contentType string, body []byte) error
+}
+
+// ObjectStorage stores objects by key and reads them back
+type ObjectStorage interface {
+    BlobStorage
+    // Get returns the body of the object, ErrObjectNotFound when there is none with the key
+    Get(ctx context.Context, key string) ([]byte, error)
+}
+
+// S3Storage stores objects in S3 or any S3-compatible storage (MinIO, Ceph, R2...) with the AWS SDK, using
+// path-style URLs
+type S3Storage struct {
+    client *s3.Client
+    bucket string
+}
+
+// NewS3Storage creates an S3 client, endpoint defaults to AWS for the region when empty
+func NewS3Storage(endpoint, region, bucket, accessKey, secretKey string) (*S3Storage, error) {
+    if bucket == "" {
+        return nil, ErrBucketRequired
+    }
+    if region == "" {
+        region = "us-east-1"
+    }
+    options := s3.Options{
+        Region:       region,
+        Credentials:  credentials.NewStaticCredentialsProvider(accessKey, secretKey, ""),
+        UsePathStyle: true,
+        HTTPClient:   &http.Client{Timeout: 30 * time.Second},
+        // the checksums are sent when S3 requires them only, not every S3-compatible storage supports the others
+        RequestChecksumCalculation: aws.RequestChecksumCalculationWhenRequired,
+        ResponseChecksumValidation: aws.ResponseChecksumValidationWhenRequired,
+    }
+    if endpoint != "" {
+        if _, err := url.Parse(endpoint); err != nil {
+            return nil, err
+        }
+        options.BaseEndpoint = aws.String(strings.TrimRight(endpoint, "/"))
+    }
+    return &S3Storage{client: s3.New(options), bucket: bucket}, nil
+}
+
+// Bucket returns the bucket objects are uploaded to
+func (s *S3Storage) Bucket() string {
+    return s.bucket
+}
+
+func (s *S3Storage) Put(ctx context.Context, key, contentType string, body []byte) error {
+    _, err := s.client.PutObject(
+        ctx,
+        &s3.PutObjectInput{
+            Bucket:        aws.String(s.bucket),
+            Key:           aws.String(key),
+            ContentType:   aws.String(contentType),
+            ContentLength: aws.Int64(int64(len(body))),
+            Body:          bytes.NewReader(body),
+        },
+    )
+    if err != nil {
+        return fmt.Errorf("%w: %w", ErrUploadFailed, err)
+    }
+    return nil
+}
+
+func (s *S3Storage) Get(ctx context.Context, key string) ([]byte, error) {
+    object, err := s.client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(key)})
+    if err != nil {
+        // a missing key is a NoSuchKey error with a 404, some S3-compatible storages send the 404 only
+        var responseError *awshttp.ResponseError
+        if errors.As(err, &responseError) && responseError.HTTPStatusCode() == http.StatusNotFound {
+            return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, key)
+        }
+        return nil, fmt.Errorf("%w: %w", ErrDownloadFailed, err)
+    }
+    defer object.Body.Close()
+    return io.ReadAll(object.Body)
+}
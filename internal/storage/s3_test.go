@@ -0,0 +1,113 @@
+package storage
+
+import (
+    "context"
+    "errors"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+// s3Error is the body of an error response of S3
+func s3Error(w http.ResponseWriter, status int, code, message string) {
+    w.Header().Set("Content-Type", "application/xml")
+    w.WriteHeader(status)
+    _, _ = io.WriteString(
+        w,
+        `<?xml version="1.0" encoding="UTF-8"?><Error><Code>`+code+`</Code><Message>`+message+`</Message>`+
+            `<RequestId>4442587FB7D0A2F9</RequestId></Error>`,
+    )
+}
+
+// newFakeS3 serves the objects of the bucket backups, the other buckets are denied
+func newFakeS3(t *testing.T) (*httptest.Server, map[string][]byte, map[string]string) {
+    objects, contentTypes := map[string][]byte{}, map[string]string{}
+    server := httptest.NewServer(
+        http.HandlerFunc(
+            func(w http.ResponseWriter, r *http.Request) {
+                authorization := r.Header.Get("Authorization")
+                if !strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 Credential=access/") ||
+                    !strings.Contains(authorization, "/us-east-1/s3/aws4_request") ||
+                    !strings.HasPrefix(r.URL.Path, "/backups/") {
+                    s3Error(w, http.StatusForbidden, "AccessDenied", "Access Denied")
+                    return
+                }
+                switch r.Method {
+                case http.MethodPut:
+                    objects[r.URL.Path], _ = io.ReadAll(r.Body)
+                    contentTypes[r.URL.Path] = r.Header.Get("Content-Type")
+                case http.MethodGet:
+                    body, ok := objects[r.URL.Path]
+                    if strings.HasSuffix(r.URL.Path, ".missing") {
+                        // some S3-compatible storages send the status only
+                        w.WriteHeader(http.StatusNotFound)
+                        return
+                    }
+                    if !ok {
+                        s3Error(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+                        return
+                    }
+                    _, _ = w.Write(body)
+                }
+            },
+        ),
+    )
+    t.Cleanup(server.Close)
+    return server, objects, contentTypes
+}
+
+func TestS3Storage(t *testing.T) {
+    server, objects, contentTypes := newFakeS3(t)
+    s, err := NewS3Storage(server.URL+"/", "", "backups", "access", "secret")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if s.Bucket() != "backups" {
+        t.Errorf("expected the bucket backups, got %s", s.Bucket())
+    }
+    ctx := context.Background()
+    if err = s.Put(ctx, "snapshots/2024/05/01.jsonl.gz", "application/gzip", []byte("snapshot")); err != nil {
+        t.Fatal(err)
+    }
+    if string(objects["/backups/snapshots/2024/05/01.jsonl.gz"]) != "snapshot" {
+        t.Errorf("expected a path-style key, got %v", objects)
+    }
+    if contentType := contentTypes["/backups/snapshots/2024/05/01.jsonl.gz"]; contentType != "application/gzip" {
+        t.Errorf("expected the content type of the object, got %q", contentType)
+    }
+    body, err := s.Get(ctx, "snapshots/2024/05/01.jsonl.gz")
+    if err != nil || string(body) != "snapshot" {
+        t.Errorf("expected the uploaded object, got %q, %v", body, err)
+    }
+
+    for _, key := range []string{"snapshots/2024/05/02.jsonl.gz", "snapshots/2024/05/02.missing"} {
+        if _, err = s.Get(ctx, key); !errors.Is(err, ErrObjectNotFound) {
+            t.Errorf("expected ErrObjectNotFound for %s, got %v", key, err)
+        }
+    }
+}
+
+func TestS3Storage_Denied(t *testing.T) {
+    server, objects, _ := newFakeS3(t)
+    s, err := NewS3Storage(server.URL, "", "reports", "access", "secret")
+    if err != nil {
+        t.Fatal(err)
+    }
+    ctx := context.Background()
+    err = s.Put(ctx, "reports/daily/2024-05-01.csv", "text/csv", []byte("vehicle_id"))
+    if !errors.Is(err, ErrUploadFailed) || !strings.Contains(err.Error(), "AccessDenied") {
+        t.Errorf("expected ErrUploadFailed with the code of the error, got %v", err)
+    }
+    if len(objects) != 0 {
+        t.Errorf("expected nothing to be uploaded, got %v", objects)
+    }
+    if _, err = s.Get(ctx, "reports/daily/2024-05-01.csv"); !errors.Is(err, ErrDownloadFailed) {
+        t.Errorf("expected ErrDownloadFailed, got %v", err)
+    }
+
+    if _, err = NewS3Storage(server.URL, "", "", "access", "secret"); !errors.Is(err, ErrBucketRequired) {
+        t.Errorf("expected ErrBucketRequired, got %v", err)
+    }
+}
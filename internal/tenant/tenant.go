@@ -0,0 +1,55 @@
+package tenant
+
+import (
+    "context"
+    "errors"
+    "regexp"
+)
+
+const (
+    // Header is the header of the tenant of API requests, it must be the tenant of the authenticated user when sent
+    Header = "X-Tenant-ID"
+    // MessageHeader is the AMQP header of the tenant of tracking data messages
+    MessageHeader = "tenant_id"
+)
+
+var (
+    ErrTenantMissing  = errors.New("tenant is missing")
+    ErrInvalidTenant  = errors.New("invalid tenant, it must be 1 to 64 letters, digits, - or _")
+    ErrTenantMismatch = errors.New("tenant is not the tenant of the authenticated user")
+)
+
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+type contextKey struct{}
+
+// Validate checks that id can be used as a tenant
+func Validate(id string) error {
+    if id == "" {
+        return ErrTenantMissing
+    }
+    if !idPattern.MatchString(id) {
+        return ErrInvalidTenant
+    }
+    return nil
+}
+
+// WithID returns a context of the tenant, the data read and written with it is limited to the tenant
+func WithID(ctx context.Context, id string) context.Context {
+    return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the tenant of the context, false for contexts without one like the ones of background jobs
+func FromContext(ctx context.Context) (string, bool) {
+    id, ok := ctx.Value(contextKey{}).(string)
+    return id, ok && id != ""
+}
+
+// Detach returns a background context with the tenant of ctx, for work that outlives the request
+func Detach(ctx context.Context) context.Context {
+    detached := context.Background()
+    if id, ok := FromContext(ctx); ok {
+        detached = WithID(detached, id)
+    }
+    return detached
+}
@@ -0,0 +1,41 @@
+package tenant
+
+import (
+    "context"
+    "errors"
+    "testing"
+)
+
+func TestValidate(t *testing.T) {
+    tests := []struct {
+        id  string
+        err error
+    }{
+        {"acme", nil},
+        {"fleet_42-eu", nil},
+        {"", ErrTenantMissing},
+        {"acme corp", ErrInvalidTenant},
+        {`{"$ne":""}`, ErrInvalidTenant},
+    }
+    for _, test := range tests {
+        if err := Validate(test.id); !errors.Is(err, test.err) {
+            t.Errorf("expected %q to return %v, got %v", test.id, test.err, err)
+        }
+    }
+}
+
+func TestDetach(t *testing.T) {
+    ctx, cancel := context.WithCancel(WithID(context.Background(), "acme"))
+    cancel()
+
+    detached := Detach(ctx)
+    if detached.Err() != nil {
+        t.Error("expected the detached context not to be canceled")
+    }
+    if id, ok := FromContext(detached); !ok || id != "acme" {
+        t.Errorf("expected the detached context to keep the tenant, got %q", id)
+    }
+    if _, ok := FromContext(Detach(context.Background())); ok {
+        t.Error("expected no tenant when the context has none")
+    }
+}
@@ -0,0 +1,124 @@
+package timestamp
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// Layout is RFC3339 with millisecond precision, timestamps are always formatted in UTC
+const Layout = "2006-01-02T15:04:05.000Z07:00"
+
+var ErrInvalidTimestamp = errors.New("invalid timestamp")
+
+// legacyLayouts are the formats accepted besides Layout, tried in order
+var legacyLayouts = []string{
+    time.RFC3339Nano,
+    "2006-01-02T15:04:05.999999999",
+    "2006-01-02 15:04:05.999999999Z07:00",
+    "2006-01-02 15:04:05.999999999",
+    time.RFC1123Z,
+    time.RFC1123,
+    "2006-01-02",
+}
+
+// Time is the timestamp of every API response and event. It is formatted as RFC3339 in UTC with milliseconds,
+// e.g. 2024-01-02T03:04:05.678Z, and the zero time as null. Parsing is tolerant of the legacy formats.
+// In MongoDB it is stored as a date, like time.Time.
+type Time struct {
+    time.Time
+}
+
+func New(t time.Time) Time {
+    return Time{Time: t}
+}
+
+func Now() Time {
+    return New(time.Now())
+}
+
+// Ptr returns a pointer to the timestamp of t, for optional fields
+func Ptr(t time.Time) *Time {
+    timestamp := New(t)
+    return &timestamp
+}
+
+// Format formats t with Layout
+func Format(t time.Time) string {
+    return t.UTC().Truncate(time.Millisecond).Format(Layout)
+}
+
+// Parse parses RFC3339 with any precision and the legacy formats, timestamps without a time zone are UTC.
+// Numbers are unix timestamps, in milliseconds when they are too large to be seconds.
+func Parse(value string) (Time, error) {
+    value = strings.TrimSpace(value)
+    for _, layout := range append([]string{Layout}, legacyLayouts...) {
+        if parsed, err := time.Parse(layout, value); err == nil {
+            return New(parsed), nil
+        }
+    }
+    if unix, err := strconv.ParseInt(value, 10, 64); err == nil {
+        // unix seconds can't be that large until the year 5138
+        if unix > 1e11 || unix < -1e11 {
+            return New(time.UnixMilli(unix)), nil
+        }
+        return New(time.Unix(unix, 0)), nil
+    }
+    return Time{}, fmt.Errorf("%w: %q", ErrInvalidTimestamp, value)
+}
+
+func (t Time) String() string {
+    return Format(t.Time)
+}
+
+func (t Time) MarshalJSON() ([]byte, error) {
+    if t.IsZero() {
+        return []byte("null"), nil
+    }
+    return []byte(`"` + Format(t.Time) + `"`), nil
+}
+
+func (t *Time) UnmarshalJSON(data []byte) error {
+    if bytes.Equal(data, []byte("null")) {
+        *t = Time{}
+        return nil
+    }
+    parsed, err := Parse(string(bytes.Trim(data, `"`)))
+    if err != nil {
+        return err
+    }
+    *t = parsed
+    return nil
+}
+
+func (t Time) MarshalBSONValue() (bsontype.Type, []byte, error) {
+    return bson.MarshalValue(t.Time)
+}
+
+// UnmarshalBSONValue decodes dates, and strings written by older versions
+func (t *Time) UnmarshalBSONValue(typ bsontype.Type, data []byte) error {
+    switch typ {
+    case bson.TypeNull, bson.TypeUndefined:
+        *t = Time{}
+        return nil
+    case bson.TypeString:
+        var value string
+        if err := bson.UnmarshalValue(typ, data, &value); err != nil {
+            return err
+        }
+        parsed, err := Parse(value)
+        if err != nil {
+            return err
+        }
+        *t = parsed
+        return nil
+    default:
+        return bson.UnmarshalValue(typ, data, &t.Time)
+    }
+}
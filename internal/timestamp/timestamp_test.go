@@ -0,0 +1,75 @@
+package timestamp
+
+import (
+    "testing"
+    "time"
+
+    "github.com/goccy/go-json"
+    "go.mongodb.org/mongo-driver/bson"
+)
+
+func TestTime_MarshalJSON(t *testing.T) {
+    local := time.FixedZone("MMT", 6*3600+1800)
+    value := struct {
+        At    Time  `json:"at"`
+        Empty Time  `json:"empty"`
+        Ptr   *Time `json:"ptr,omitempty"`
+    }{At: New(time.Date(2024, 1, 2, 9, 34, 5, 678_900_000, local))}
+
+    data, err := json.Marshal(value)
+    if err != nil {
+        t.Fatal(err)
+    }
+    expected := `{"at":"2024-01-02T03:04:05.678Z","empty":null}`
+    if string(data) != expected {
+        t.Fatalf("Should marshal %s, got %s", expected, data)
+    }
+}
+
+func TestParse(t *testing.T) {
+    expected := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+    for _, value := range []string{
+        "2024-01-02T03:04:05Z",
+        "2024-01-02T03:04:05.000Z",
+        "2024-01-02T09:34:05+06:30",
+        "2024-01-02T03:04:05",
+        "2024-01-02 03:04:05",
+        "Tue, 02 Jan 2024 03:04:05 +0000",
+        "1704164645",
+        "1704164645000",
+    } {
+        parsed, err := Parse(value)
+        if err != nil {
+            t.Fatalf("Should parse %q: %v", value, err)
+        }
+        if !parsed.Equal(expected) {
+            t.Fatalf("Should parse %q as %s, got %s", value, expected, parsed)
+        }
+    }
+
+    if _, err := Parse("yesterday"); err == nil {
+        t.Fatal("Should reject unknown formats")
+    }
+}
+
+func TestTime_BSON(t *testing.T) {
+    at := New(time.Date(2024, 1, 2, 3, 4, 5, 678_000_000, time.UTC))
+    data, err := bson.Marshal(bson.M{"at": at, "legacy": "2024-01-02T03:04:05.678Z"})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var decoded struct {
+        At     Time `bson:"at"`
+        Legacy Time `bson:"legacy"`
+    }
+    if err := bson.Unmarshal(data, &decoded); err != nil {
+        t.Fatal(err)
+    }
+    if !decoded.At.Equal(at.Time) || !decoded.Legacy.Equal(at.Time) {
+        t.Fatalf("Should decode dates and legacy strings, got %s and %s", decoded.At, decoded.Legacy)
+    }
+    if bson.Raw(data).Lookup("at").Type != bson.TypeDateTime {
+        t.Fatal("Should be stored as a date")
+    }
+}
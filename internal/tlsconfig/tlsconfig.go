@@ -0,0 +1,50 @@
+package tlsconfig
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "errors"
+    "fmt"
+    "os"
+)
+
+var (
+    ErrInvalidCABundle = errors.New("CA bundle has no PEM certificates")
+)
+
+// Files are the PEM files of a TLS connection, they are all optional. CAFile is the bundle the server certificate
+// is verified with instead of the system roots, CertFile and KeyFile the client certificate for mutual TLS.
+type Files struct {
+    CAFile   string
+    CertFile string
+    KeyFile  string
+}
+
+// Configured reports whether any file is set
+func (f Files) Configured() bool {
+    return f.CAFile != "" || f.CertFile != "" || f.KeyFile != ""
+}
+
+// New creates the client TLS config of the files, the server name is left to the clients since they set it
+// from the host they connect to
+func New(files Files) (*tls.Config, error) {
+    cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+    if files.CAFile != "" {
+        bundle, err := os.ReadFile(files.CAFile)
+        if err != nil {
+            return nil, err
+        }
+        cfg.RootCAs = x509.NewCertPool()
+        if !cfg.RootCAs.AppendCertsFromPEM(bundle) {
+            return nil, fmt.Errorf("%w: %s", ErrInvalidCABundle, files.CAFile)
+        }
+    }
+    if files.CertFile != "" || files.KeyFile != "" {
+        cert, err := tls.LoadX509KeyPair(files.CertFile, files.KeyFile)
+        if err != nil {
+            return nil, err
+        }
+        cfg.Certificates = []tls.Certificate{cert}
+    }
+    return cfg, nil
+}
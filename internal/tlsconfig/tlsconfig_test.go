@@ -0,0 +1,78 @@
+package tlsconfig
+
+import (
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/pem"
+    "errors"
+    "math/big"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+// writeCertificate writes a self-signed certificate and its key, the certificate doubles as the CA bundle
+func writeCertificate(t *testing.T) (string, string) {
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        t.Fatal(err)
+    }
+    template := &x509.Certificate{
+        SerialNumber:          big.NewInt(1),
+        Subject:               pkix.Name{CommonName: "tracking-svc"},
+        NotBefore:             time.Now().Add(-time.Hour),
+        NotAfter:              time.Now().Add(time.Hour),
+        IsCA:                  true,
+        BasicConstraintsValid: true,
+    }
+    der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    keyDER, err := x509.MarshalECPrivateKey(key)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    dir := t.TempDir()
+    certFile, keyFile := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+    if err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+        t.Fatal(err)
+    }
+    if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+        t.Fatal(err)
+    }
+    return certFile, keyFile
+}
+
+func TestNew(t *testing.T) {
+    certFile, keyFile := writeCertificate(t)
+
+    cfg, err := New(Files{CAFile: certFile, CertFile: certFile, KeyFile: keyFile})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if cfg.RootCAs == nil || len(cfg.Certificates) != 1 {
+        t.Errorf("expected the CA bundle and the client certificate, got %+v", cfg)
+    }
+
+    cfg, err = New(Files{CAFile: certFile})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(cfg.Certificates) != 0 {
+        t.Errorf("expected no client certificate without mutual TLS")
+    }
+
+    // the key isn't a certificate
+    if _, err = New(Files{CAFile: keyFile}); !errors.Is(err, ErrInvalidCABundle) {
+        t.Errorf("expected ErrInvalidCABundle, got %v", err)
+    }
+    if _, err = New(Files{CertFile: certFile, KeyFile: certFile}); err == nil {
+        t.Errorf("expected an error for a certificate without its key")
+    }
+}
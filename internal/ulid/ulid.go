@@ -0,0 +1,99 @@
+package ulid
+
+import (
+    "crypto/rand"
+    "encoding/binary"
+    "errors"
+    "strings"
+    "time"
+)
+
+// ULIDs are 26 characters of Crockford's base32: 10 for the milliseconds since the Unix epoch and 16 for 80
+// random bits, so they sort by time as text. See https://github.com/ulid/spec.
+const (
+    Length = 26
+
+    alphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+    // maxTime is the largest time in milliseconds that fits the 48 bits of the time
+    maxTime = 1<<48 - 1
+)
+
+var (
+    ErrInvalid = errors.New("invalid ULID")
+)
+
+// decoding maps the characters of the alphabet to their values, -1 for the other characters.
+// Lower case is accepted like upper case.
+var decoding = func() [256]int8 {
+    var table [256]int8
+    for i := range table {
+        table[i] = -1
+    }
+    for i, c := range alphabet {
+        table[c] = int8(i)
+        table[strings.ToLower(string(c))[0]] = int8(i)
+    }
+    return table
+}()
+
+// New returns a ULID of the time with random bits from crypto/rand
+func New(t time.Time) string {
+    var entropy [10]byte
+    if _, err := rand.Read(entropy[:]); err != nil {
+        // crypto/rand doesn't fail on supported platforms
+        panic(err)
+    }
+    return encode(uint64(t.UnixMilli()), entropy)
+}
+
+func encode(ms uint64, entropy [10]byte) string {
+    var b [Length]byte
+    for i := 9; i >= 0; i-- {
+        b[i] = alphabet[ms&31]
+        ms >>= 5
+    }
+    // the 80 random bits are 16 groups of 5 bits, read from two 40 bit halves
+    for half := 0; half < 2; half++ {
+        var buf [8]byte
+        copy(buf[3:], entropy[half*5:half*5+5])
+        bits := binary.BigEndian.Uint64(buf[:])
+        for i := 7; i >= 0; i-- {
+            b[10+half*8+i] = alphabet[bits&31]
+            bits >>= 5
+        }
+    }
+    return string(b[:])
+}
+
+// Parse validates a ULID and returns it in its canonical upper case form
+func Parse(s string) (string, error) {
+    if len(s) != Length {
+        return "", ErrInvalid
+    }
+    for i := 0; i < len(s); i++ {
+        if decoding[s[i]] < 0 {
+            return "", ErrInvalid
+        }
+    }
+    // the first character only has 3 bits of the time
+    if decoding[s[0]] > 7 {
+        return "", ErrInvalid
+    }
+    return strings.ToUpper(s), nil
+}
+
+// Time returns the time of a valid ULID
+func Time(s string) (time.Time, error) {
+    id, err := Parse(s)
+    if err != nil {
+        return time.Time{}, err
+    }
+    var ms uint64
+    for i := 0; i < 10; i++ {
+        ms = ms<<5 | uint64(decoding[id[i]])
+    }
+    if ms > maxTime {
+        return time.Time{}, ErrInvalid
+    }
+    return time.UnixMilli(int64(ms)), nil
+}
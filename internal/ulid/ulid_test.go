@@ -0,0 +1,55 @@
+package ulid
+
+import (
+    "errors"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestNew(t *testing.T) {
+    at := time.Date(2025, time.March, 4, 10, 20, 30, 123_000_000, time.UTC)
+    id := New(at)
+    if len(id) != Length {
+        t.Fatalf("expected %d characters, got %q", Length, id)
+    }
+    parsed, err := Time(id)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !parsed.Equal(at) {
+        t.Errorf("expected the time %v, got %v", at, parsed)
+    }
+    if New(at) == id {
+        t.Errorf("expected random bits to differ")
+    }
+    if later := New(at.Add(time.Millisecond)); later[:10] <= id[:10] {
+        t.Errorf("expected later ULIDs to sort after, got %s before %s", later, id)
+    }
+}
+
+func TestEncode(t *testing.T) {
+    // the maximum ULID of the spec
+    var entropy [10]byte
+    for i := range entropy {
+        entropy[i] = 0xff
+    }
+    if id := encode(maxTime, entropy); id != "7ZZZZZZZZZZZZZZZZZZZZZZZZZ" {
+        t.Errorf("expected the maximum ULID, got %s", id)
+    }
+    if id := encode(0, [10]byte{}); id != strings.Repeat("0", Length) {
+        t.Errorf("expected the zero ULID, got %s", id)
+    }
+}
+
+func TestParse(t *testing.T) {
+    id, err := Parse("01arz3ndektsv4rrffq69g5fav")
+    if err != nil || id != "01ARZ3NDEKTSV4RRFFQ69G5FAV" {
+        t.Errorf("expected the upper case ULID, got %q, %v", id, err)
+    }
+    for _, invalid := range []string{"", "01ARZ3NDEKTSV4RRFFQ69G5FA", "01ARZ3NDEKTSV4RRFFQ69G5FAU", "8ZZZZZZZZZZZZZZZZZZZZZZZZZ"} {
+        if _, err = Parse(invalid); !errors.Is(err, ErrInvalid) {
+            t.Errorf("expected %q to be invalid, got %v", invalid, err)
+        }
+    }
+}
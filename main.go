@@ -2,34 +2,532 @@ package main
 
 import (
     "context"
+    "flag"
+    "fmt"
+    "io"
     "log"
+    "net/url"
+    "os"
+    "os/signal"
+    "path/filepath"
+    "strings"
+    "syscall"
+    "time"
 
     "github.com/go-playground/validator/v10"
+    "github.com/goccy/go-json"
     "github.com/yemyoaung/managing-vehicle-tracking-common"
-    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/app"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/app"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/cli"
     "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/config"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/handler"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/migration"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/repositories"
+    "github.com/yemyoaung/managing-vehicle-tracking-tracking-svc/internal/tenant"
 )
 
 func main() {
+    commands := cli.New(
+        filepath.Base(os.Args[0]),
+        "serve",
+        os.Stderr,
+        &cli.Command{Name: "serve", Summary: "Run the service", Run: serve},
+        &cli.Command{
+            Name:    "migrate-indexes",
+            Summary: "Create the indexes of the tracking data and of the enabled features",
+            Run:     migrateIndexes,
+        },
+        &cli.Command{
+            Name:    "backfill-geohashes",
+            Summary: "Assign geohashes to the tracking data stored before they were added",
+            Run:     backfillGeohashes,
+        },
+        &cli.Command{
+            Name:    "replay",
+            Aliases: []string{"replay-dlq"},
+            Summary: "Republish the messages of a dead-letter queue to the tracking queue",
+            Run:     replay,
+        },
+        &cli.Command{
+            Name:    "backfill",
+            Summary: "Publish the readings of a file to the tracking queue, flagged backfill",
+            Run:     backfill,
+        },
+        &cli.Command{Name: "purge", Summary: "Soft delete or purge the tracking data of a vehicle", Run: purge},
+        &cli.Command{Name: "snapshot", Summary: "Export the tracking data of a day to S3_BUCKET", Run: snapshot},
+        &cli.Command{
+            Name:    "restore-snapshot",
+            Summary: "Insert the readings of the snapshot of a day that aren't stored anymore",
+            Run:     restoreSnapshot,
+        },
+        &cli.Command{
+            Name:    "migrate-queue",
+            Summary: "Republish the messages of a queue to an exchange",
+            Run:     migrateQueue,
+        },
+        &cli.Command{
+            Name:    "diagnostics",
+            Summary: "Write the diagnostics bundle for support tickets",
+            Run:     diagnostics,
+        },
+    )
+    if err := commands.Run(context.Background(), os.Args[1:]); err != nil {
+        log.Fatal(err)
+    }
+}
+
+// newApp loads the config and creates the app the commands run on, after they parsed their flags so the flags of a
+// command can be listed without a config
+func newApp() (*app.App, error) {
     validate := validator.New(
         validator.WithRequiredStructEnabled(),
     )
+    cfg, err := loadConfig(validate)
+    if err != nil {
+        return nil, fmt.Errorf("failed to load config: %w", err)
+    }
+    return app.NewApp(
+        app.WithValidator(validate),
+        app.WithConfig(cfg),
+        app.WithConfigFile(os.Getenv("CONFIG_FILE")),
+    ), nil
+}
+
+// serve runs the service until it is shut down
+func serve(ctx context.Context, args []string) error {
+    flags := flag.NewFlagSet("serve", flag.ExitOnError)
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+    instance, err := newApp()
+    if err != nil {
+        return err
+    }
+
+    instance.Run(ctx)
+
+    if err = instance.Shutdown(ctx); err != nil {
+        return fmt.Errorf("shutdown failed: %w", err)
+    }
+    log.Println("App shutdown successfully")
+    return nil
+}
+
+// migrateIndexes creates the indexes before a deployment, the service creates the missing ones on startup too
+func migrateIndexes(ctx context.Context, args []string) error {
+    flags := flag.NewFlagSet("migrate-indexes", flag.ExitOnError)
+    timeout := flags.Duration("timeout", 30*time.Minute, "time the index builds may take at most")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+    instance, err := newApp()
+    if err != nil {
+        return err
+    }
+
+    ctx, cancel := context.WithTimeout(ctx, *timeout)
+    defer cancel()
+    return instance.MigrateIndexes(ctx)
+}
+
+// backfillGeohashes assigns the geohashes of the tracking data stored before the upgrade, once after it
+func backfillGeohashes(ctx context.Context, args []string) error {
+    flags := flag.NewFlagSet("backfill-geohashes", flag.ExitOnError)
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+    instance, err := newApp()
+    if err != nil {
+        return err
+    }
+
+    updated, err := instance.BackfillGeohashes(ctx)
+    if err != nil {
+        return err
+    }
+    log.Printf("Assigned the geohash of %d tracking data", updated)
+    return nil
+}
+
+// loadConfig loads the config from the YAML or TOML file of CONFIG_FILE when it is set, with the environment
+// variables overriding it, and from the .env file otherwise
+func loadConfig(validate *validator.Validate) (*config.EnvConfig, error) {
+    if path := os.Getenv("CONFIG_FILE"); path != "" {
+        return config.Load(path)
+    }
     load, err := common.NewConfigLoaderFromEnvFile[config.EnvConfig](".env", validate)
     if err != nil {
-        log.Fatal("Failed to load config")
+        return nil, err
     }
+    return load.Config, nil
+}
 
-    ctx := context.Background()
+// diagnostics writes the diagnostics bundle for support tickets, to a file in the working directory by default
+func diagnostics(ctx context.Context, args []string) error {
+    flags := flag.NewFlagSet("diagnostics", flag.ExitOnError)
+    output := flags.String("o", "", "file to write the bundle to, - for stdout")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+    instance, err := newApp()
+    if err != nil {
+        return err
+    }
 
-    instance := app.NewApp().SetValidator(validate).SetConfig(load.Config)
+    ctx, cancel := context.WithTimeout(ctx, time.Minute)
+    defer cancel()
+    bundle, err := instance.Diagnose(ctx)
+    if err != nil {
+        return err
+    }
 
-    instance.Run(ctx)
+    var w io.Writer = os.Stdout
+    if *output != "-" {
+        if *output == "" {
+            *output = handler.DiagnosticsFileName(bundle.GeneratedAt.Time)
+        }
+        file, err := os.Create(*output)
+        if err != nil {
+            return err
+        }
+        defer func(file *os.File) {
+            if err := file.Close(); err != nil {
+                log.Println("Failed to close diagnostics bundle", err)
+            }
+        }(file)
+        w = file
+        log.Println("Writing diagnostics bundle to: ", *output)
+    }
 
-    err = instance.Shutdown(ctx)
+    encoder := json.NewEncoder(w)
+    encoder.SetIndent("", "  ")
+    return encoder.Encode(bundle)
+}
 
+// migrateQueue republishes the messages of a queue to an exchange, e.g. to move the consumers of a queue to a new
+// exchange. Interrupting it stops after the current message, the remaining messages stay in the queue.
+func migrateQueue(ctx context.Context, args []string) error {
+    flags := flag.NewFlagSet("migrate-queue", flag.ExitOnError)
+    from := flags.String("from", "", "queue to drain")
+    to := flags.String("to", "", "exchange to publish to, empty for the default exchange")
+    routingKey := flags.String("routing-key", "", "routing key of the published messages, the original one by default")
+    transforms := flags.String("transform", "", "comma separated transforms applied in order: envelope")
+    rate := flags.Float64("rate", 0, "messages per second at most, 0 doesn't limit it")
+    limit := flags.Int("limit", 0, "messages migrated at most, 0 drains the queue")
+    progress := flags.Duration("progress", 10*time.Second, "interval of the progress logs")
+    dryRun := flags.Bool("dry-run", false, "print the plan of the migration without migrating anything")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+    instance, err := newApp()
     if err != nil {
-        log.Fatal("Shutdown failed:", err)
-        return
+        return err
     }
-    log.Println("App shutdown successfully")
+
+    opts := app.MigrateOptions{
+        From:             *from,
+        To:               *to,
+        RoutingKey:       *routingKey,
+        Rate:             *rate,
+        Limit:            *limit,
+        ProgressInterval: *progress,
+        OnProgress: func(progress app.MigrateProgress) {
+            log.Printf(
+                "Migrated %d messages in %s, %d remaining",
+                progress.Migrated,
+                progress.Elapsed.Round(time.Second),
+                progress.Remaining,
+            )
+        },
+    }
+    for _, name := range strings.Split(*transforms, ",") {
+        if name = strings.TrimSpace(name); name == "" {
+            continue
+        }
+        transform, ok := migration.Transforms[name]
+        if !ok {
+            return fmt.Errorf("%w: %s", migration.ErrUnknownTransform, name)
+        }
+        opts.Transforms = append(opts.Transforms, transform)
+    }
+
+    ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+    defer stop()
+    if *dryRun {
+        p, err := instance.PlanQueueMigration(ctx, opts)
+        if err != nil {
+            return err
+        }
+        return printJSON(p)
+    }
+    _, err = instance.MigrateQueue(ctx, opts)
+    return err
+}
+
+// replay republishes the messages of a dead-letter queue to the tracking queue, e.g. after a schema bug was fixed.
+// Interrupting it stops after the current message, the remaining messages stay in the queue.
+func replay(ctx context.Context, args []string) error {
+    flags := flag.NewFlagSet("replay", flag.ExitOnError)
+    from := flags.String("from", "", "dead-letter queue to replay")
+    fields := map[string]any{}
+    usage := "field=value set on every message, the value is JSON or a string, can be repeated"
+    flags.Func(
+        "set", usage, func(value string) error {
+            field, raw, ok := strings.Cut(value, "=")
+            if !ok || field == "" {
+                return fmt.Errorf("expected field=value, got %q", value)
+            }
+            var decoded any
+            if err := json.Unmarshal([]byte(raw), &decoded); err != nil {
+                decoded = raw
+            }
+            fields[field] = decoded
+            return nil
+        },
+    )
+    rate := flags.Float64("rate", 0, "messages per second at most, 0 doesn't limit it")
+    limit := flags.Int("limit", 0, "messages replayed at most, 0 drains the queue")
+    progress := flags.Duration("progress", 10*time.Second, "interval of the progress logs")
+    dryRun := flags.Bool("dry-run", false, "print the plan of the replay without replaying anything")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+    instance, err := newApp()
+    if err != nil {
+        return err
+    }
+
+    opts := app.MigrateOptions{
+        From:             *from,
+        Rate:             *rate,
+        Limit:            *limit,
+        ProgressInterval: *progress,
+        OnProgress: func(progress app.MigrateProgress) {
+            log.Printf(
+                "Replayed %d messages in %s, %d remaining",
+                progress.Migrated,
+                progress.Elapsed.Round(time.Second),
+                progress.Remaining,
+            )
+        },
+    }
+    if len(fields) > 0 {
+        opts.Transforms = append(opts.Transforms, migration.SetFieldsTransform(fields))
+    }
+
+    ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+    defer stop()
+    if *dryRun {
+        p, err := instance.PlanDeadLetterReplay(ctx, opts)
+        if err != nil {
+            return err
+        }
+        return printJSON(p)
+    }
+    _, err = instance.ReplayDeadLetters(ctx, opts)
+    return err
+}
+
+// backfill imports the readings exported from another system to the tracking queue, from a JSONL or CSV file or
+// stdin. The line of the last confirmed reading is saved to the checkpoint, so an interrupted backfill continues
+// after it when it is run again.
+func backfill(ctx context.Context, args []string) error {
+    flags := flag.NewFlagSet("backfill", flag.ExitOnError)
+    file := flags.String("file", "-", "file of the readings, - for stdin")
+    format := flags.String("format", "", "jsonl or csv, by the extension of the file by default")
+    checkpoint := flags.String("checkpoint", "", "file of the checkpoint, <file>.checkpoint by default, none for stdin")
+    restart := flags.Bool("restart", false, "start at the first line instead of after the checkpoint")
+    tenantID := flags.String("tenant", "", "tenant the readings are published for, required with multi-tenancy")
+    rate := flags.Float64("rate", 0, "readings per second at most, 0 doesn't limit it")
+    progress := flags.Duration("progress", 10*time.Second, "interval of the progress logs")
+    dryRun := flags.Bool("dry-run", false, "print the plan of the backfill without publishing anything")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+    instance, err := newApp()
+    if err != nil {
+        return err
+    }
+
+    opts := app.BackfillOptions{
+        Format:           migration.BackfillFormat(*format),
+        Rate:             *rate,
+        ProgressInterval: *progress,
+        OnSkip: func(line int, err error) {
+            log.Printf("Skipped line %d: %v", line, err)
+        },
+    }
+    var r io.Reader = os.Stdin
+    var size int64
+    if *file != "-" {
+        f, err := os.Open(*file)
+        if err != nil {
+            return err
+        }
+        defer func(f *os.File) {
+            if err := f.Close(); err != nil {
+                log.Println("Failed to close backfill file", err)
+            }
+        }(f)
+        if info, err := f.Stat(); err == nil {
+            size = info.Size()
+        }
+        r = f
+        if opts.Format == "" {
+            opts.Format = migration.BackfillFormatOf(*file)
+        }
+        if *checkpoint == "" {
+            *checkpoint = *file + ".checkpoint"
+        }
+    }
+    opts.OnProgress = func(progress app.BackfillProgress) {
+        done := ""
+        if size > 0 {
+            done = fmt.Sprintf(" (%.1f%%)", float64(progress.Offset)/float64(size)*100)
+        }
+        log.Printf(
+            "Backfilled %d readings up to line %d%s in %s, %d skipped",
+            progress.Published,
+            progress.Line,
+            done,
+            progress.Elapsed.Round(time.Second),
+            progress.Skipped,
+        )
+    }
+
+    if *checkpoint != "" {
+        // the checkpoint belongs to the file wherever the backfill is run from
+        source, err := filepath.Abs(*file)
+        if err != nil {
+            return err
+        }
+        if !*restart {
+            if opts.After, err = migration.LoadBackfillCheckpoint(*checkpoint, source); err != nil {
+                return err
+            }
+        }
+        if opts.After > 0 {
+            log.Printf("Resuming after line %d of the checkpoint %s", opts.After, *checkpoint)
+        }
+        opts.OnCheckpoint = func(line int) error {
+            return migration.SaveBackfillCheckpoint(*checkpoint, source, line)
+        }
+    }
+
+    ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+    defer stop()
+    if *tenantID != "" {
+        ctx = tenant.WithID(ctx, *tenantID)
+    }
+    if *dryRun {
+        p, err := instance.PlanBackfill(ctx, r, opts)
+        if err != nil {
+            return err
+        }
+        return printJSON(p)
+    }
+    _, err = instance.Backfill(ctx, r, opts)
+    return err
+}
+
+// purge deletes the tracking data of a vehicle for retention and right to erasure requests, the deletion is audited
+// like the ones of the API
+func purge(ctx context.Context, args []string) error {
+    flags := flag.NewFlagSet("purge", flag.ExitOnError)
+    vehicleID := flags.String("vehicle", "", "id of the vehicle whose tracking data is deleted")
+    before := flags.String("before", "", "only delete the tracking data before this RFC 3339 time, all by default")
+    mode := flags.String("mode", repositories.DeletionModePurge, "purge removes the data for good, soft flags it")
+    tenantID := flags.String("tenant", "", "tenant of the vehicle, required with multi-tenancy")
+    requestedBy := flags.String("requested-by", "cli", "who asked for the deletion, recorded in the audit")
+    dryRun := flags.Bool("dry-run", false, "print the plan of the deletion without deleting anything")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+    instance, err := newApp()
+    if err != nil {
+        return err
+    }
+
+    query := url.Values{"vehicle_id": {*vehicleID}, "mode": {*mode}}
+    if *before != "" {
+        query.Set("before", *before)
+    }
+    if *tenantID != "" {
+        ctx = tenant.WithID(ctx, *tenantID)
+    }
+    if *dryRun {
+        p, err := instance.PlanTrackingDataDeletion(ctx, query)
+        if err != nil {
+            return err
+        }
+        return printJSON(p)
+    }
+    audit, err := instance.DeleteTrackingData(ctx, query, *requestedBy)
+    if err != nil {
+        return err
+    }
+    return printJSON(audit)
+}
+
+// snapshot exports the tracking data of a day, e.g. one the scheduler failed to export
+func snapshot(ctx context.Context, args []string) error {
+    flags := flag.NewFlagSet("snapshot", flag.ExitOnError)
+    date := flags.String("date", "", "UTC day to export as YYYY-MM-DD, yesterday by default")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+    day, err := snapshotDay(*date)
+    if err != nil {
+        return err
+    }
+    instance, err := newApp()
+    if err != nil {
+        return err
+    }
+    exported, err := instance.ExportSnapshot(ctx, day)
+    if err != nil {
+        return err
+    }
+    return printJSON(exported)
+}
+
+// restoreSnapshot restores the readings of a day from its snapshot, the readings still stored are kept
+func restoreSnapshot(ctx context.Context, args []string) error {
+    flags := flag.NewFlagSet("restore-snapshot", flag.ExitOnError)
+    date := flags.String("date", "", "UTC day to restore as YYYY-MM-DD, yesterday by default")
+    tenantID := flags.String("tenant", "", "only restore the readings of this tenant, all of them by default")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+    day, err := snapshotDay(*date)
+    if err != nil {
+        return err
+    }
+    instance, err := newApp()
+    if err != nil {
+        return err
+    }
+    if *tenantID != "" {
+        ctx = tenant.WithID(ctx, *tenantID)
+    }
+    restore, err := instance.RestoreSnapshot(ctx, day)
+    if err != nil {
+        return err
+    }
+    return printJSON(restore)
+}
+
+// snapshotDay parses the day of a snapshot, yesterday in UTC when it is empty
+func snapshotDay(date string) (time.Time, error) {
+    if date == "" {
+        return time.Now().UTC().AddDate(0, 0, -1), nil
+    }
+    return time.Parse(time.DateOnly, date)
+}
+
+// printJSON writes the indented JSON of v to stdout
+func printJSON(v any) error {
+    encoder := json.NewEncoder(os.Stdout)
+    encoder.SetIndent("", "  ")
+    return encoder.Encode(v)
 }